@@ -0,0 +1,268 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/service"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	backupPassphraseEnvVar = "SFTPGO_BACKUP_PASSPHRASE"
+)
+
+var (
+	backupFile         string
+	backupScopes       []string
+	restoreUsers       string
+	restoreMode        int
+	restoreDryRun      bool
+	backupScopesUsage  = fmt.Sprintf("Comma separated scopes to include, empty means\nall. Supported scopes: %s", strings.Join(dataprovider.DumpScopes, ", "))
+	backupProviderHelp = `This command reads the data provider connection details from the specified
+configuration file. The memory provider is not supported.
+For embedded providers like bolt and SQLite you should stop the running SFTPGo
+instance to avoid database corruption.
+
+The passphrase is read from the SFTPGO_BACKUP_PASSPHRASE env var or requested
+interactively.`
+
+	backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Create an encrypted backup of the data provider",
+		Long: `This command creates a backup, encrypted using the provided passphrase, of
+the selected data provider objects. The backup archive can be restored using
+the "restore" command.
+
+` + backupProviderHelp + `
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			initializeBackupProvider()
+			filter := dataprovider.BackupFilter{Scopes: backupScopes}
+			if err := filter.Validate(); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			passphrase, err := getBackupPassphrase(true)
+			if err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			data, err := dataprovider.DumpData(backupScopes)
+			if err != nil {
+				logger.ErrorToConsole("Unable to dump data: %v", err)
+				os.Exit(1)
+			}
+			archive, err := dataprovider.EncryptBackup(&data, passphrase)
+			if err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			if err := os.MkdirAll(filepath.Dir(backupFile), 0700); err != nil {
+				logger.ErrorToConsole("Unable to create the output directory: %v", err)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(backupFile, archive, 0600); err != nil {
+				logger.ErrorToConsole("Unable to write the backup: %v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Backup saved to %q, version: %d", backupFile, data.Version)
+		},
+	}
+
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a backup to the data provider",
+		Long: `This command restores a backup created using the "backup" command. Plain JSON
+backups, for example created using the "dumpdata" REST API, are supported too.
+You can restore only some scopes and only the users, and their shares,
+matching a shell pattern. Use the dry-run flag to show the changes without
+applying them.
+
+` + backupProviderHelp + `
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			filter := dataprovider.BackupFilter{
+				Scopes:       backupScopes,
+				UsersPattern: restoreUsers,
+			}
+			if err := filter.Validate(); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			if restoreMode < 0 || restoreMode > 1 {
+				logger.ErrorToConsole("Invalid restore mode %d", restoreMode)
+				os.Exit(1)
+			}
+			initializeBackupProvider()
+			data, err := readBackup()
+			if err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			if err := dataprovider.CheckBackupVersion(&data); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			filter.Apply(&data)
+			if restoreDryRun {
+				printBackupChanges(&data)
+				return
+			}
+			if err := service.RestoreData(&data, backupFile, restoreMode, 0); err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Backup %q restored, version: %d, mode: %d", backupFile, data.Version, restoreMode)
+		},
+	}
+)
+
+func initializeBackupProvider() {
+	logger.DisableLogger()
+	logger.EnableConsoleLogger(zerolog.DebugLevel)
+	configDir = util.CleanDirInput(configDir)
+	err := config.LoadConfig(configDir, configFile)
+	if err != nil {
+		logger.WarnToConsole("Unable to load configuration: %v", err)
+		os.Exit(1)
+	}
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("unable to initialize KMS: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver == dataprovider.MemoryDataProviderName {
+		logger.ErrorToConsole("memory provider is not supported")
+		os.Exit(1)
+	}
+	logger.InfoToConsole("Initializing provider: %q config file: %q", providerConf.Driver, viper.ConfigFileUsed())
+	err = dataprovider.Initialize(providerConf, configDir, false)
+	if err != nil {
+		logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+		os.Exit(1)
+	}
+}
+
+func getBackupPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(backupPassphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+	fmt.Printf("Enter Passphrase: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println("")
+	if err != nil {
+		return "", fmt.Errorf("unable to read the passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("the passphrase cannot be empty")
+	}
+	if confirm {
+		fmt.Printf("Confirm Passphrase: ")
+		confirmPassphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println("")
+		if err != nil {
+			return "", fmt.Errorf("unable to read the passphrase: %w", err)
+		}
+		if !bytes.Equal(passphrase, confirmPassphrase) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+func readBackup() (dataprovider.BackupData, error) {
+	content, err := os.ReadFile(backupFile)
+	if err != nil {
+		return dataprovider.BackupData{}, fmt.Errorf("unable to read the backup: %w", err)
+	}
+	if !dataprovider.IsEncryptedBackup(content) {
+		data, err := dataprovider.ParseDumpData(content)
+		if err != nil {
+			return data, fmt.Errorf("unable to parse the backup %q: %w", backupFile, err)
+		}
+		return data, nil
+	}
+	passphrase, err := getBackupPassphrase(false)
+	if err != nil {
+		return dataprovider.BackupData{}, err
+	}
+	return dataprovider.DecryptBackup(content, passphrase)
+}
+
+func printBackupChanges(data *dataprovider.BackupData) {
+	changes, err := dataprovider.GetBackupChanges(data, restoreMode)
+	if err != nil {
+		logger.ErrorToConsole("Unable to compare the backup with the data provider: %v", err)
+		os.Exit(1)
+	}
+	counters := make(map[string]int)
+	for _, change := range changes {
+		counters[change.Action]++
+		switch change.Action {
+		case dataprovider.BackupChangeAdd:
+			fmt.Printf("+ %s %q\n", change.Scope, change.Name)
+		case dataprovider.BackupChangeUpdate:
+			fmt.Printf("~ %s %q, changed fields: %s\n", change.Scope, change.Name, strings.Join(change.Fields, ", "))
+		case dataprovider.BackupChangeSkip:
+			fmt.Printf("= %s %q, already exists and will not be modified\n", change.Scope, change.Name)
+		}
+	}
+	fmt.Printf("\nBackup version: %d, to add: %d, to update: %d, existing not modified: %d, unchanged: %d\n",
+		data.Version, counters[dataprovider.BackupChangeAdd], counters[dataprovider.BackupChangeUpdate],
+		counters[dataprovider.BackupChangeSkip], counters[dataprovider.BackupChangeUnchanged])
+}
+
+func init() {
+	addConfigFlags(backupCmd)
+	backupCmd.Flags().StringVarP(&backupFile, "output", "o", "", `Path for the backup archive`)
+	backupCmd.Flags().StringSliceVar(&backupScopes, "scopes", nil, backupScopesUsage)
+	backupCmd.MarkFlagRequired("output") //nolint:errcheck
+
+	addConfigFlags(restoreCmd)
+	restoreCmd.Flags().StringVarP(&backupFile, "input", "i", "", `Path for the backup to restore`)
+	restoreCmd.Flags().StringSliceVar(&backupScopes, "scopes", nil, backupScopesUsage)
+	restoreCmd.Flags().StringVar(&restoreUsers, "users", "", `Restore only the users, and their shares,
+matching this shell pattern, for example
+"team-*"`)
+	restoreCmd.Flags().IntVar(&restoreMode, "mode", 1, `Restore mode:
+  0 - new objects are added, existing objects
+      are updated
+  1 - new objects are added, existing objects
+      are not modified
+`)
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, `Show the changes without applying them`)
+	restoreCmd.MarkFlagRequired("input") //nolint:errcheck
+
+	rootCmd.AddCommand(backupCmd, restoreCmd)
+}
@@ -44,6 +44,13 @@ For SQLite/bolt providers the database file will be auto-created if missing.
 For PostgreSQL and MySQL providers you need to create the configured database,
 this command will create/update the required tables as needed.
 
+For MongoDB provider this command will create the required collections and
+indexes. MongoDB must be configured as a replica set, a single node replica
+set is enough, since transactions are required.
+
+For etcd provider the configured database name is used as key prefix, so
+multiple SFTPGo installations can share the same etcd cluster.
+
 To initialize/update the data provider from the configuration directory simply use:
 
 $ sftpgo initprovider
@@ -0,0 +1,539 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+const (
+	remoteProfileFlag      = "profile"
+	remoteProfileKey       = "remote_profile"
+	defaultRemoteProfile   = "default"
+	remoteAPIBasePath      = "/api/v2"
+	remoteRequestTimeout   = 60 * time.Second
+	remoteMaxResponseSize  = 100 * 1048576
+	remoteProfilesEnvVar   = "SFTPGO_REMOTE_PROFILES"
+	remotePasswordEnvVar   = "SFTPGO_REMOTE_PASSWORD"
+	remoteAPIKeyHeader     = "X-SFTPGO-API-KEY"
+	remoteProfilesFileName = "profiles.json"
+)
+
+var (
+	remoteProfile         string
+	remoteProfileURL      string
+	remoteProfileAPIKey   string
+	remoteProfileUsername string
+	remoteInputFile       string
+	remoteListLimit       int
+	remoteListOffset      int
+
+	remoteCmd = &cobra.Command{
+		Use:   "remote",
+		Short: "Administer a running SFTPGo instance using the REST API",
+		Long: `The remote subcommands allow to script the administration of a running
+SFTPGo instance using the REST API.
+
+The connection details are stored in named profiles, added using the
+"remote profile set" command. A profile can authenticate using an API key
+or an admin username. For username based profiles, the password is read
+from the SFTPGO_REMOTE_PASSWORD env var or requested interactively and
+it is never stored.
+
+The profiles are stored in the "sftpgo/profiles.json" file inside the
+user configuration directory, you can use a different file setting the
+SFTPGO_REMOTE_PROFILES env var.
+
+Users, folders and groups must be provided as JSON, using the same
+format as the REST API, and the responses are printed as JSON.`,
+	}
+
+	remoteProfileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "Manage the profiles to connect to SFTPGo instances",
+	}
+
+	remoteProfileSetCmd = &cobra.Command{
+		Use:   "set <name>",
+		Short: "Add or update a profile",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			if err := setRemoteProfile(args[0]); err != nil {
+				exitWithRemoteError(err)
+			}
+			fmt.Printf("Profile %q saved\n", args[0])
+		},
+	}
+
+	remoteProfileListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the stored profiles",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			profiles, err := loadRemoteProfiles()
+			if err != nil {
+				exitWithRemoteError(err)
+			}
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				p := profiles[name]
+				auth := "api key"
+				if p.APIKey == "" {
+					auth = fmt.Sprintf("admin %q", p.Username)
+				}
+				fmt.Printf("%s\t%s\t%s\n", name, p.URL, auth)
+			}
+		},
+	}
+
+	remoteProfileRemoveCmd = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a profile",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			profiles, err := loadRemoteProfiles()
+			if err != nil {
+				exitWithRemoteError(err)
+			}
+			if _, ok := profiles[args[0]]; !ok {
+				exitWithRemoteError(fmt.Errorf("profile %q not found", args[0]))
+			}
+			delete(profiles, args[0])
+			if err := saveRemoteProfiles(profiles); err != nil {
+				exitWithRemoteError(err)
+			}
+			fmt.Printf("Profile %q removed\n", args[0])
+		},
+	}
+
+	remoteQuotaScanCmd = &cobra.Command{
+		Use:   "quota-scan",
+		Short: "Start a quota scan for a user or a virtual folder",
+	}
+
+	remoteBanCmd = &cobra.Command{
+		Use:   "ban",
+		Short: "Manage the hosts banned by the defender",
+	}
+)
+
+// remoteProfileConfig defines the details to connect to an SFTPGo instance
+type remoteProfileConfig struct {
+	URL      string `json:"url"`
+	APIKey   string `json:"api_key,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (p *remoteProfileConfig) validate() error {
+	if p.URL == "" {
+		return errors.New("the URL is mandatory")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", p.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL %q: only http and https are supported", p.URL)
+	}
+	p.URL = strings.TrimSuffix(p.URL, "/")
+	if p.APIKey == "" && p.Username == "" {
+		return errors.New("an API key or an admin username is required")
+	}
+	if p.APIKey != "" && p.Username != "" {
+		return errors.New("an API key and an admin username cannot be used together")
+	}
+	return nil
+}
+
+func getRemoteProfilesPath() (string, error) {
+	if p := os.Getenv(remoteProfilesEnvVar); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get the user configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "sftpgo", remoteProfilesFileName), nil
+}
+
+func loadRemoteProfiles() (map[string]remoteProfileConfig, error) {
+	profiles := make(map[string]remoteProfileConfig)
+	profilesPath, err := getRemoteProfilesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(profilesPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return profiles, nil
+		}
+		return nil, fmt.Errorf("unable to read the profiles: %w", err)
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("unable to decode the profiles from %q: %w", profilesPath, err)
+	}
+	return profiles, nil
+}
+
+func saveRemoteProfiles(profiles map[string]remoteProfileConfig) error {
+	profilesPath, err := getRemoteProfilesPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(profilesPath), 0700); err != nil {
+		return fmt.Errorf("unable to create the profiles directory: %w", err)
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the profiles can contain API keys
+	return os.WriteFile(profilesPath, data, 0600)
+}
+
+func setRemoteProfile(name string) error {
+	profile := remoteProfileConfig{
+		URL:      remoteProfileURL,
+		APIKey:   remoteProfileAPIKey,
+		Username: remoteProfileUsername,
+	}
+	if err := profile.validate(); err != nil {
+		return err
+	}
+	profiles, err := loadRemoteProfiles()
+	if err != nil {
+		return err
+	}
+	profiles[name] = profile
+	return saveRemoteProfiles(profiles)
+}
+
+type remoteClient struct {
+	profile remoteProfileConfig
+	client  *http.Client
+	mu      sync.Mutex
+	token   string
+	// the password is kept in memory to renew the token for long running
+	// commands, it is never stored
+	password string
+}
+
+func newRemoteClient() (*remoteClient, error) {
+	profiles, err := loadRemoteProfiles()
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := profiles[remoteProfile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found, add it using the \"remote profile set\" command", remoteProfile)
+	}
+	c := &remoteClient{
+		profile: profile,
+		client: &http.Client{
+			Timeout: remoteRequestTimeout,
+		},
+	}
+	if profile.APIKey == "" {
+		if err := c.login(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *remoteClient) getPassword() (string, error) {
+	if c.password != "" {
+		return c.password, nil
+	}
+	if password := os.Getenv(remotePasswordEnvVar); password != "" {
+		return password, nil
+	}
+	fmt.Fprintf(os.Stderr, "Enter password for admin %q: ", c.profile.Username)
+	pwd, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		return "", fmt.Errorf("unable to read the password: %w", err)
+	}
+	return string(pwd), nil
+}
+
+// login gets a short lived JWT token for the profile admin
+func (c *remoteClient) login() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	password, err := c.getPassword()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, c.profile.URL+remoteAPIBasePath+"/token", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.profile.Username, password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get an access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get an access token, status code: %d", resp.StatusCode)
+	}
+	c.password = password
+	var token map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1048576)).Decode(&token); err != nil {
+		return fmt.Errorf("unable to decode the access token: %w", err)
+	}
+	accessToken, ok := token["access_token"].(string)
+	if !ok || accessToken == "" {
+		return errors.New("no access token received")
+	}
+	c.token = accessToken
+	return nil
+}
+
+func (c *remoteClient) do(method, relativeURL string, body []byte) ([]byte, error) {
+	respBody, statusCode, err := c.doRequest(method, relativeURL, body)
+	if err == nil && statusCode == http.StatusUnauthorized && c.profile.APIKey == "" {
+		// the token is expired, renew it and retry
+		if err := c.login(); err != nil {
+			return nil, err
+		}
+		respBody, statusCode, err = c.doRequest(method, relativeURL, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if statusCode < http.StatusOK || statusCode > http.StatusNoContent {
+		return nil, fmt.Errorf("unexpected status code %d: %s", statusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func (c *remoteClient) doRequest(method, relativeURL string, body []byte) ([]byte, int, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.profile.URL+remoteAPIBasePath+relativeURL, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.profile.APIKey != "" {
+		req.Header.Set(remoteAPIKeyHeader, c.profile.APIKey)
+	} else {
+		c.mu.Lock()
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		c.mu.Unlock()
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to send the request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxResponseSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to read the response: %w", err)
+	}
+	return respBody, resp.StatusCode, nil
+}
+
+func exitWithRemoteError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
+
+func readRemoteInput() []byte {
+	var data []byte
+	var err error
+	if remoteInputFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(remoteInputFile)
+	}
+	if err != nil {
+		exitWithRemoteError(fmt.Errorf("unable to read the input: %w", err))
+	}
+	if !json.Valid(data) {
+		exitWithRemoteError(errors.New("the input is not valid JSON"))
+	}
+	return data
+}
+
+// runRemoteRequest sends the request using the selected profile and
+// prints the response
+func runRemoteRequest(method, relativeURL string, body []byte) {
+	client, err := newRemoteClient()
+	if err != nil {
+		exitWithRemoteError(err)
+	}
+	resp, err := client.do(method, relativeURL, body)
+	if err != nil {
+		exitWithRemoteError(err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, resp, "", "  "); err != nil {
+		fmt.Println(string(resp))
+		return
+	}
+	fmt.Println(out.String())
+}
+
+// newRemoteResourceCmd returns a command to list, get, add, update and
+// delete the resources available at the specified REST API path
+func newRemoteResourceCmd(use, resource, basePath string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: fmt.Sprintf("Manage %ss", resource),
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: fmt.Sprintf("List %ss", resource),
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			runRemoteRequest(http.MethodGet, fmt.Sprintf("%s?limit=%d&offset=%d", basePath, remoteListLimit,
+				remoteListOffset), nil)
+		},
+	}
+	listCmd.Flags().IntVar(&remoteListLimit, "limit", 100, `Maximum number of items to return, max 500`)
+	listCmd.Flags().IntVar(&remoteListOffset, "offset", 0, `Number of items to skip`)
+
+	getCmd := &cobra.Command{
+		Use:   "get <name>",
+		Short: fmt.Sprintf("Get a %s by name", resource),
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			runRemoteRequest(http.MethodGet, basePath+"/"+url.PathEscape(args[0]), nil)
+		},
+	}
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: fmt.Sprintf("Add a %s", resource),
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			runRemoteRequest(http.MethodPost, basePath, readRemoteInput())
+		},
+	}
+	updateCmd := &cobra.Command{
+		Use:   "update <name>",
+		Short: fmt.Sprintf("Update a %s, the whole object must be provided", resource),
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			runRemoteRequest(http.MethodPut, basePath+"/"+url.PathEscape(args[0]), readRemoteInput())
+		},
+	}
+	for _, c := range []*cobra.Command{addCmd, updateCmd} {
+		c.Flags().StringVarP(&remoteInputFile, "file", "f", "", fmt.Sprintf(`JSON file with the %s to save, use "-" to
+read from the standard input`, resource))
+		c.MarkFlagRequired("file") //nolint:errcheck
+	}
+	deleteCmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: fmt.Sprintf("Delete a %s", resource),
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			runRemoteRequest(http.MethodDelete, basePath+"/"+url.PathEscape(args[0]), nil)
+		},
+	}
+	cmd.AddCommand(listCmd, getCmd, addCmd, updateCmd, deleteCmd)
+	return cmd
+}
+
+func init() {
+	viper.SetDefault(remoteProfileKey, defaultRemoteProfile)
+	viper.BindEnv(remoteProfileKey, "SFTPGO_REMOTE_PROFILE") //nolint:errcheck
+	remoteCmd.PersistentFlags().StringVarP(&remoteProfile, remoteProfileFlag, "p", viper.GetString(remoteProfileKey),
+		`Profile to use. This flag can be set using
+SFTPGO_REMOTE_PROFILE env var too.`)
+
+	remoteProfileSetCmd.Flags().StringVar(&remoteProfileURL, "url", "", `Base URL for the SFTPGo instance, for
+example https://sftpgo.example.com:8080`)
+	remoteProfileSetCmd.Flags().StringVar(&remoteProfileAPIKey, "api-key", "", `API key to use`)
+	remoteProfileSetCmd.Flags().StringVar(&remoteProfileUsername, "username", "", `Admin username to use. The password is
+requested for each command`)
+	remoteProfileSetCmd.MarkFlagRequired("url") //nolint:errcheck
+	remoteProfileCmd.AddCommand(remoteProfileSetCmd, remoteProfileListCmd, remoteProfileRemoveCmd)
+
+	remoteQuotaScanCmd.AddCommand(&cobra.Command{
+		Use:   "user <username>",
+		Short: "Start a quota scan for a user",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			runRemoteRequest(http.MethodPost, fmt.Sprintf("/quotas/users/%s/scan", url.PathEscape(args[0])), nil)
+		},
+	}, &cobra.Command{
+		Use:   "folder <name>",
+		Short: "Start a quota scan for a virtual folder",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			runRemoteRequest(http.MethodPost, fmt.Sprintf("/quotas/folders/%s/scan", url.PathEscape(args[0])), nil)
+		},
+	})
+
+	remoteBanCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the hosts banned or with a score",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			runRemoteRequest(http.MethodGet, "/defender/hosts", nil)
+		},
+	}, &cobra.Command{
+		Use:   "remove <ip>",
+		Short: "Remove a host from the defender, the ban and the score are cleared",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			ip := net.ParseIP(args[0])
+			if ip == nil {
+				exitWithRemoteError(fmt.Errorf("invalid IP address %q", args[0]))
+			}
+			runRemoteRequest(http.MethodDelete, "/defender/hosts/"+hex.EncodeToString([]byte(ip.String())), nil)
+		},
+	})
+
+	remoteCmd.AddCommand(remoteProfileCmd,
+		newRemoteResourceCmd("user", "user", "/users"),
+		newRemoteResourceCmd("folder", "virtual folder", "/folders"),
+		newRemoteResourceCmd("group", "group", "/groups"),
+		remoteQuotaScanCmd, remoteBanCmd)
+	rootCmd.AddCommand(remoteCmd)
+}
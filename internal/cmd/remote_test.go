@@ -0,0 +1,332 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type remoteTestRequest struct {
+	method string
+	path   string
+	query  string
+	body   string
+	apiKey string
+	auth   string
+}
+
+type remoteTestServer struct {
+	sync.Mutex
+	*httptest.Server
+	requests []remoteTestRequest
+	tokens   []string
+	// status codes to return for the API requests, the following requests
+	// will get http.StatusOK
+	statuses []int
+}
+
+func newRemoteTestServer(t *testing.T) *remoteTestServer {
+	s := &remoteTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		if r.URL.Path == remoteAPIBasePath+"/token" {
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "admin" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			token := "token" + string(rune('0'+len(s.tokens)))
+			s.tokens = append(s.tokens, token)
+			json.NewEncoder(w).Encode(map[string]any{"access_token": token}) //nolint:errcheck
+			return
+		}
+		s.requests = append(s.requests, remoteTestRequest{
+			method: r.Method,
+			path:   r.URL.EscapedPath(),
+			query:  r.URL.RawQuery,
+			body:   string(body),
+			apiKey: r.Header.Get(remoteAPIKeyHeader),
+			auth:   r.Header.Get("Authorization"),
+		})
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				w.Write([]byte(`{"error":"test error"}`)) //nolint:errcheck
+				return
+			}
+		}
+		w.Write([]byte(`{"message":"ok"}`)) //nolint:errcheck
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *remoteTestServer) getRequests() []remoteTestRequest {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]remoteTestRequest(nil), s.requests...)
+}
+
+func setRemoteTestProfiles(t *testing.T) string {
+	profilesPath := filepath.Join(t.TempDir(), "sftpgo", remoteProfilesFileName)
+	t.Setenv(remoteProfilesEnvVar, profilesPath)
+	return profilesPath
+}
+
+// resetTestFlags restores the default flag values, cobra keeps the values
+// set in the previous executions
+func resetTestFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if f.Changed {
+			f.Value.Set(f.DefValue) //nolint:errcheck
+			f.Changed = false
+		}
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetTestFlags(c)
+	}
+}
+
+func executeRemoteTestCmd(args ...string) error {
+	// the commands print the responses to the standard output
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err == nil {
+		os.Stdout = devNull
+		defer func() {
+			os.Stdout = stdout
+			devNull.Close()
+		}()
+	}
+	resetTestFlags(rootCmd)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	rootCmd.SetArgs(args)
+	defer rootCmd.SetArgs(nil)
+
+	_, err = rootCmd.ExecuteC()
+	return err
+}
+
+func TestRemoteProfileValidation(t *testing.T) {
+	tests := []struct {
+		profile remoteProfileConfig
+		url     string
+		valid   bool
+	}{
+		{profile: remoteProfileConfig{APIKey: "key"}},
+		{profile: remoteProfileConfig{URL: "ftp://localhost", APIKey: "key"}},
+		{profile: remoteProfileConfig{URL: "http://localhost:8080"}},
+		{profile: remoteProfileConfig{URL: "http://localhost:8080", APIKey: "key", Username: "admin"}},
+		{profile: remoteProfileConfig{URL: "http://localhost:8080/", APIKey: "key"}, url: "http://localhost:8080", valid: true},
+		{profile: remoteProfileConfig{URL: "https://localhost", Username: "admin"}, url: "https://localhost", valid: true},
+	}
+	for _, test := range tests {
+		p := test.profile
+		err := p.validate()
+		if test.valid {
+			assert.NoError(t, err)
+			assert.Equal(t, test.url, p.URL)
+		} else {
+			assert.Error(t, err, "profile %+v", test.profile)
+		}
+	}
+}
+
+func TestRemoteProfileCommands(t *testing.T) {
+	profilesPath := setRemoteTestProfiles(t)
+
+	profiles, err := loadRemoteProfiles()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 0)
+	// missing arguments and flags
+	err = executeRemoteTestCmd("remote", "profile", "set", "--url", "http://localhost", "--api-key", "key")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("remote", "profile", "set", "p1", "--api-key", "key")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("remote", "profile", "set", "p1", "p2", "--url", "http://localhost", "--api-key", "key")
+	assert.Error(t, err)
+	assert.NoFileExists(t, profilesPath)
+
+	err = executeRemoteTestCmd("remote", "profile", "set", "p1", "--url", "http://localhost:8080/", "--api-key", "key")
+	assert.NoError(t, err)
+	err = executeRemoteTestCmd("remote", "profile", "set", "p2", "--url", "https://localhost", "--username", "admin")
+	assert.NoError(t, err)
+	profiles, err = loadRemoteProfiles()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]remoteProfileConfig{
+		"p1": {URL: "http://localhost:8080", APIKey: "key"},
+		"p2": {URL: "https://localhost", Username: "admin"},
+	}, profiles)
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(profilesPath)
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	}
+	err = executeRemoteTestCmd("remote", "profile", "remove", "p1")
+	assert.NoError(t, err)
+	profiles, err = loadRemoteProfiles()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Contains(t, profiles, "p2")
+
+	err = os.WriteFile(profilesPath, []byte("invalid json"), 0600)
+	assert.NoError(t, err)
+	_, err = loadRemoteProfiles()
+	assert.Error(t, err)
+}
+
+func TestRemoteResourceCommands(t *testing.T) {
+	setRemoteTestProfiles(t)
+	server := newRemoteTestServer(t)
+
+	err := executeRemoteTestCmd("remote", "profile", "set", "test", "--url", server.URL, "--api-key", "secret key")
+	require.NoError(t, err)
+	inputFile := filepath.Join(t.TempDir(), "user.json")
+	err = os.WriteFile(inputFile, []byte(`{"username":"user 1"}`), 0600)
+	require.NoError(t, err)
+	// invalid arguments must not reach the server
+	err = executeRemoteTestCmd("remote", "-p", "test", "user", "get")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("remote", "-p", "test", "user", "delete", "a", "b")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("remote", "-p", "test", "folder", "list", "name")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("remote", "-p", "test", "quota-scan", "user")
+	assert.Error(t, err)
+	assert.Len(t, server.getRequests(), 0)
+
+	commands := [][]string{
+		{"remote", "-p", "test", "user", "list", "--limit", "10", "--offset", "5"},
+		{"remote", "-p", "test", "user", "get", "user 1"},
+		{"remote", "-p", "test", "user", "add", "-f", inputFile},
+		{"remote", "-p", "test", "user", "update", "user 1", "--file", inputFile},
+		{"remote", "-p", "test", "user", "delete", "user 1"},
+		{"remote", "-p", "test", "folder", "get", "f/1"},
+		{"remote", "-p", "test", "group", "delete", "group1"},
+		{"remote", "-p", "test", "quota-scan", "user", "user 1"},
+		{"remote", "-p", "test", "quota-scan", "folder", "folder1"},
+		{"remote", "-p", "test", "ban", "list"},
+		{"remote", "-p", "test", "ban", "remove", "192.168.1.10"},
+	}
+	for _, args := range commands {
+		err = executeRemoteTestCmd(args...)
+		assert.NoError(t, err, "args: %v", args)
+	}
+	userJSON := `{"username":"user 1"}`
+	expected := []remoteTestRequest{
+		{method: http.MethodGet, path: "/api/v2/users", query: "limit=10&offset=5"},
+		{method: http.MethodGet, path: "/api/v2/users/user%201"},
+		{method: http.MethodPost, path: "/api/v2/users", body: userJSON},
+		{method: http.MethodPut, path: "/api/v2/users/user%201", body: userJSON},
+		{method: http.MethodDelete, path: "/api/v2/users/user%201"},
+		{method: http.MethodGet, path: "/api/v2/folders/f%2F1"},
+		{method: http.MethodDelete, path: "/api/v2/groups/group1"},
+		{method: http.MethodPost, path: "/api/v2/quotas/users/user%201/scan"},
+		{method: http.MethodPost, path: "/api/v2/quotas/folders/folder1/scan"},
+		{method: http.MethodGet, path: "/api/v2/defender/hosts"},
+		{method: http.MethodDelete, path: "/api/v2/defender/hosts/" + hex.EncodeToString([]byte("192.168.1.10"))},
+	}
+	requests := server.getRequests()
+	if assert.Len(t, requests, len(expected)) {
+		for idx, req := range requests {
+			assert.Equal(t, "secret key", req.apiKey)
+			assert.Empty(t, req.auth)
+			req.apiKey = ""
+			assert.Equal(t, expected[idx], req)
+		}
+	}
+}
+
+func TestRemoteClient(t *testing.T) {
+	setRemoteTestProfiles(t)
+	server := newRemoteTestServer(t)
+
+	remoteProfile = "missing"
+	_, err := newRemoteClient()
+	assert.ErrorContains(t, err, "not found")
+
+	err = executeRemoteTestCmd("remote", "profile", "set", "admin", "--url", server.URL, "--username", "admin")
+	require.NoError(t, err)
+	remoteProfile = "admin"
+	t.Setenv(remotePasswordEnvVar, "wrong")
+	_, err = newRemoteClient()
+	assert.ErrorContains(t, err, "status code: 401")
+
+	t.Setenv(remotePasswordEnvVar, "password")
+	client, err := newRemoteClient()
+	require.NoError(t, err)
+	assert.Equal(t, "token0", client.token)
+	// the password is kept in memory to renew the token
+	t.Setenv(remotePasswordEnvVar, "")
+	server.Lock()
+	server.statuses = []int{http.StatusUnauthorized}
+	server.Unlock()
+	resp, err := client.do(http.MethodGet, "/users", nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message":"ok"}`, string(resp))
+	assert.Equal(t, "token1", client.token)
+	requests := server.getRequests()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, "Bearer token0", requests[0].auth)
+		assert.Equal(t, "Bearer token1", requests[1].auth)
+		assert.Empty(t, requests[1].apiKey)
+	}
+	// API errors are returned with the response body
+	server.Lock()
+	server.statuses = []int{http.StatusNotFound}
+	server.Unlock()
+	_, err = client.do(http.MethodDelete, "/users/missing", nil)
+	assert.ErrorContains(t, err, "unexpected status code 404")
+	assert.ErrorContains(t, err, "test error")
+	// API keys are not renewed
+	client.profile = remoteProfileConfig{URL: server.URL, APIKey: "key"}
+	server.Lock()
+	server.statuses = []int{http.StatusUnauthorized}
+	server.Unlock()
+	_, err = client.do(http.MethodGet, "/users", nil)
+	assert.ErrorContains(t, err, "unexpected status code 401")
+	server.Lock()
+	assert.Len(t, server.tokens, 2)
+	server.Unlock()
+
+	server.Close()
+	_, err = client.do(http.MethodGet, "/users", nil)
+	assert.ErrorContains(t, err, "unable to send the request")
+}
@@ -0,0 +1,609 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	topMaxErrors   = 5
+	topMaxEvents   = 5
+	topMaxBans     = 5
+	topMinInterval = 1
+)
+
+const (
+	topModeNormal = iota
+	topModeConfirmKill
+	topModeThrottle
+)
+
+var (
+	topRefreshInterval int
+
+	topCmd = &cobra.Command{
+		Use:   "top",
+		Short: "Show live activity for a running SFTPGo instance",
+		Long: `The top command connects to the REST API of a running SFTPGo instance and
+shows, in the terminal, the active connections with their transfer speeds,
+the hosts banned by the defender and the recent errors.
+
+It is useful for operators working over SSH without access to the web
+admin. The connection details are read from the profiles added using the
+"remote profile set" command.
+
+The recent errors are read from the events search API, if the eventsearcher
+plugin is not configured only the errors returned by the REST API are shown.
+
+Keys:
+
+  up/down, k/j  select a connection
+  x             disconnect the selected connection
+  t             set the bandwidth limit, in KB/s, for the selected user,
+                0 means no limit. The limit applies to new transfers
+  r             refresh now
+  q             quit`,
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			if topRefreshInterval < topMinInterval {
+				topRefreshInterval = topMinInterval
+			}
+			client, err := newRemoteClient()
+			if err != nil {
+				exitWithRemoteError(err)
+			}
+			if err := runTop(client, time.Duration(topRefreshInterval)*time.Second); err != nil {
+				exitWithRemoteError(err)
+			}
+		},
+	}
+)
+
+type topTransfer struct {
+	OperationType string `json:"operation_type"`
+	StartTime     int64  `json:"start_time"`
+	Size          int64  `json:"size"`
+	Path          string `json:"path"`
+}
+
+type topConnection struct {
+	Username       string        `json:"username"`
+	ConnectionID   string        `json:"connection_id"`
+	ClientVersion  string        `json:"client_version"`
+	RemoteAddress  string        `json:"remote_address"`
+	ConnectionTime int64         `json:"connection_time"`
+	LastActivity   int64         `json:"last_activity"`
+	Protocol       string        `json:"protocol"`
+	Transfers      []topTransfer `json:"active_transfers"`
+	Command        string        `json:"command"`
+	Node           string        `json:"node"`
+}
+
+type topDefenderHost struct {
+	ID      string `json:"id"`
+	IP      string `json:"ip"`
+	Score   int    `json:"score"`
+	BanTime string `json:"ban_time"`
+}
+
+type topEvent struct {
+	Timestamp   int64  `json:"timestamp"`
+	Action      string `json:"action"`
+	Username    string `json:"username"`
+	VirtualPath string `json:"virtual_path"`
+	Status      int    `json:"status"`
+	Protocol    string `json:"protocol"`
+	IP          string `json:"ip"`
+}
+
+type topSample struct {
+	size      int64
+	timestamp time.Time
+}
+
+type topSnapshot struct {
+	connections     []topConnection
+	hosts           []topDefenderHost
+	events          []topEvent
+	eventsAvailable bool
+	errors          []error
+	timestamp       time.Time
+}
+
+type topUI struct {
+	client    *remoteClient
+	snapshot  topSnapshot
+	speeds    map[string]float64
+	samples   map[string]topSample
+	apiErrors []string
+	selected  int
+	mode      int
+	input     string
+	status    string
+}
+
+func newTopUI(client *remoteClient) *topUI {
+	return &topUI{
+		client:  client,
+		speeds:  make(map[string]float64),
+		samples: make(map[string]topSample),
+	}
+}
+
+func (t *topUI) get(relativeURL string, result any) error {
+	resp, err := t.client.do(http.MethodGet, relativeURL, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp, result)
+}
+
+// fetch reads the data to show from the REST API, it is safe to call it
+// from a different goroutine
+func (t *topUI) fetch() topSnapshot {
+	s := topSnapshot{
+		eventsAvailable: true,
+		timestamp:       time.Now(),
+	}
+	if err := t.get("/connections", &s.connections); err != nil {
+		s.errors = append(s.errors, fmt.Errorf("unable to get connections: %w", err))
+	}
+	if err := t.get("/defender/hosts", &s.hosts); err != nil {
+		s.errors = append(s.errors, fmt.Errorf("unable to get defender hosts: %w", err))
+	}
+	query := url.Values{}
+	query.Set("statuses", "2,3")
+	query.Set("limit", strconv.Itoa(topMaxEvents))
+	query.Set("order", "DESC")
+	if err := t.get("/events/fs?"+query.Encode(), &s.events); err != nil {
+		// the eventsearcher plugin is optional
+		s.eventsAvailable = false
+	}
+	return s
+}
+
+func (t *topUI) update(s topSnapshot) {
+	sort.Slice(s.connections, func(i, j int) bool {
+		if s.connections[i].Username == s.connections[j].Username {
+			return s.connections[i].ConnectionID < s.connections[j].ConnectionID
+		}
+		return s.connections[i].Username < s.connections[j].Username
+	})
+	sort.Slice(s.hosts, func(i, j int) bool {
+		return s.hosts[i].BanTime > s.hosts[j].BanTime
+	})
+	var selectedID string
+	if t.selected < len(t.snapshot.connections) {
+		selectedID = t.snapshot.connections[t.selected].ConnectionID
+	}
+	t.snapshot = s
+	t.selected = 0
+	for idx, c := range s.connections {
+		if c.ConnectionID == selectedID {
+			t.selected = idx
+			break
+		}
+	}
+	for _, err := range s.errors {
+		t.addError(err)
+	}
+	t.updateSpeeds()
+}
+
+// updateSpeeds computes the transfer speeds comparing the transferred size
+// with the one from the previous refresh
+func (t *topUI) updateSpeeds() {
+	samples := make(map[string]topSample)
+	speeds := make(map[string]float64)
+	for _, c := range t.snapshot.connections {
+		for _, tr := range c.Transfers {
+			key := getTopTransferKey(c.ConnectionID, tr)
+			sample := topSample{
+				size:      tr.Size,
+				timestamp: t.snapshot.timestamp,
+			}
+			if prev, ok := t.samples[key]; ok {
+				elapsed := sample.timestamp.Sub(prev.timestamp).Seconds()
+				if elapsed > 0 && sample.size >= prev.size {
+					speeds[key] = float64(sample.size-prev.size) / elapsed
+				}
+			} else {
+				elapsed := sample.timestamp.Sub(util.GetTimeFromMsecSinceEpoch(tr.StartTime)).Seconds()
+				if elapsed > 0 {
+					speeds[key] = float64(tr.Size) / elapsed
+				}
+			}
+			samples[key] = sample
+		}
+	}
+	t.samples = samples
+	t.speeds = speeds
+}
+
+func (t *topUI) addError(err error) {
+	t.apiErrors = append(t.apiErrors, fmt.Sprintf("%s %v", time.Now().Format("15:04:05"), err))
+	if len(t.apiErrors) > topMaxErrors {
+		t.apiErrors = t.apiErrors[len(t.apiErrors)-topMaxErrors:]
+	}
+}
+
+func (t *topUI) getSelectedConnection() (topConnection, bool) {
+	if t.selected >= 0 && t.selected < len(t.snapshot.connections) {
+		return t.snapshot.connections[t.selected], true
+	}
+	return topConnection{}, false
+}
+
+func (t *topUI) killSelected() {
+	c, ok := t.getSelectedConnection()
+	if !ok {
+		return
+	}
+	_, err := t.client.do(http.MethodDelete, "/connections/"+url.PathEscape(c.ConnectionID), nil)
+	if err != nil {
+		t.addError(fmt.Errorf("unable to disconnect %q: %w", c.ConnectionID, err))
+		t.status = ""
+		return
+	}
+	t.status = fmt.Sprintf("connection %q closed", c.ConnectionID)
+}
+
+// throttleSelected sets the upload and download bandwidth limits for the
+// user of the selected connection
+func (t *topUI) throttleSelected() {
+	c, ok := t.getSelectedConnection()
+	if !ok {
+		return
+	}
+	bandwidth, err := strconv.ParseInt(t.input, 10, 64)
+	if err != nil || bandwidth < 0 {
+		t.status = fmt.Sprintf("invalid bandwidth %q", t.input)
+		return
+	}
+	relativeURL := "/users/" + url.PathEscape(c.Username)
+	var user map[string]any
+	if err := t.get(relativeURL, &user); err != nil {
+		t.addError(fmt.Errorf("unable to get user %q: %w", c.Username, err))
+		t.status = ""
+		return
+	}
+	user["upload_bandwidth"] = bandwidth
+	user["download_bandwidth"] = bandwidth
+	body, err := json.Marshal(user)
+	if err != nil {
+		t.addError(err)
+		return
+	}
+	if _, err := t.client.do(http.MethodPut, relativeURL, body); err != nil {
+		t.addError(fmt.Errorf("unable to update user %q: %w", c.Username, err))
+		t.status = ""
+		return
+	}
+	if bandwidth == 0 {
+		t.status = fmt.Sprintf("bandwidth limit removed for user %q", c.Username)
+	} else {
+		t.status = fmt.Sprintf("bandwidth limit for user %q set to %d KB/s", c.Username, bandwidth)
+	}
+}
+
+// handleKey processes a key press and returns true if the UI must exit
+// and a boolean that is true if a refresh is requested
+func (t *topUI) handleKey(key string) (bool, bool) {
+	switch t.mode {
+	case topModeConfirmKill:
+		t.mode = topModeNormal
+		if key == "y" || key == "Y" {
+			t.killSelected()
+			return false, true
+		}
+		t.status = ""
+		return false, false
+	case topModeThrottle:
+		switch key {
+		case "\r", "\n":
+			t.mode = topModeNormal
+			t.throttleSelected()
+		case "\x1b":
+			t.mode = topModeNormal
+			t.status = ""
+		case "\x7f", "\b":
+			if t.input != "" {
+				t.input = t.input[:len(t.input)-1]
+			}
+		default:
+			if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+				t.input += key
+			}
+		}
+		return false, false
+	}
+	t.status = ""
+	switch key {
+	case "q", "Q", "\x03":
+		return true, false
+	case "r", "R":
+		return false, true
+	case "\x1b[A", "k":
+		if t.selected > 0 {
+			t.selected--
+		}
+	case "\x1b[B", "j":
+		if t.selected < len(t.snapshot.connections)-1 {
+			t.selected++
+		}
+	case "x", "X":
+		if c, ok := t.getSelectedConnection(); ok {
+			t.mode = topModeConfirmKill
+			t.status = fmt.Sprintf("disconnect %q, user %q? (y/n)", c.ConnectionID, c.Username)
+		}
+	case "t", "T":
+		if _, ok := t.getSelectedConnection(); ok {
+			t.mode = topModeThrottle
+			t.input = ""
+		}
+	}
+	return false, false
+}
+
+func (t *topUI) render() string {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width = 80
+		height = 24
+	}
+	var lines []string
+	addStyled := func(style, format string, a ...any) {
+		line := truncateTopField(fmt.Sprintf(format, a...), width)
+		if style != "" {
+			line = style + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	add := func(format string, a ...any) {
+		addStyled("", format, a...)
+	}
+	s := t.snapshot
+	add("SFTPGo %s - %s, refreshed at %s", t.client.profile.URL, time.Now().Format("15:04:05"),
+		s.timestamp.Format("15:04:05"))
+	add("Connections: %d, transfers: %d, defender hosts: %d", len(s.connections), countTopTransfers(s.connections),
+		len(s.hosts))
+	add("")
+	addStyled("\x1b[7m", "%-2s%-20s %-8s %-22s %-10s %-10s %s", "", "USER", "PROTO", "REMOTE ADDRESS", "DURATION", "SPEED",
+		"INFO")
+
+	// reserve space for the bans, errors and status sections
+	maxRows := height - len(lines) - topMaxBans - topMaxErrors - 8
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	first := 0
+	if t.selected >= maxRows {
+		first = t.selected - maxRows + 1
+	}
+	for idx := first; idx < len(s.connections) && idx < first+maxRows; idx++ {
+		c := s.connections[idx]
+		marker := " "
+		if idx == t.selected {
+			marker = ">"
+		}
+		var speed float64
+		info := c.Command
+		if info == "" {
+			info = c.ClientVersion
+		}
+		for _, tr := range c.Transfers {
+			speed += t.speeds[getTopTransferKey(c.ConnectionID, tr)]
+		}
+		if len(c.Transfers) > 0 {
+			tr := c.Transfers[0]
+			info = fmt.Sprintf("%s %s (%s)", tr.OperationType, tr.Path, util.ByteCountIEC(tr.Size))
+			if len(c.Transfers) > 1 {
+				info += fmt.Sprintf(" +%d", len(c.Transfers)-1)
+			}
+		}
+		duration := time.Since(util.GetTimeFromMsecSinceEpoch(c.ConnectionTime))
+		add("%-2s%-20s %-8s %-22s %-10s %-10s %s", marker, truncateTopField(c.Username, 20), c.Protocol,
+			truncateTopField(c.RemoteAddress, 22), util.GetDurationAsString(duration), getTopSpeedAsString(speed), info)
+	}
+	if len(s.connections) == 0 {
+		add("  no active connections")
+	}
+	add("")
+	addStyled("\x1b[1m", "Defender")
+	for idx, h := range s.hosts {
+		if idx >= topMaxBans {
+			add("  ... %d more", len(s.hosts)-topMaxBans)
+			break
+		}
+		if h.BanTime != "" {
+			add("  %-40s banned until %s", h.IP, h.BanTime)
+		} else {
+			add("  %-40s score %d", h.IP, h.Score)
+		}
+	}
+	if len(s.hosts) == 0 {
+		add("  no banned hosts")
+	}
+	add("")
+	addStyled("\x1b[1m", "Recent errors")
+	if s.eventsAvailable {
+		for _, ev := range s.events {
+			ts := time.Unix(0, ev.Timestamp).Format("15:04:05")
+			status := "error"
+			if ev.Status == 3 {
+				status = "quota exceeded"
+			}
+			add("  %s %s %s %q, user %q, %s %s", ts, ev.Protocol, ev.Action, ev.VirtualPath, ev.Username, ev.IP, status)
+		}
+	}
+	for _, e := range t.apiErrors {
+		add("  %s", e)
+	}
+	if len(t.apiErrors) == 0 && (!s.eventsAvailable || len(s.events) == 0) {
+		add("  no recent errors")
+	}
+	for len(lines) < height-1 {
+		add("")
+	}
+	switch t.mode {
+	case topModeThrottle:
+		c, _ := t.getSelectedConnection()
+		add("bandwidth limit for user %q in KB/s, 0 means no limit: %s", c.Username, t.input)
+	default:
+		if t.status != "" {
+			add("%s", t.status)
+		} else {
+			add("q quit, r refresh, x disconnect, t throttle, up/down select")
+		}
+	}
+	// overwrite the previous content instead of clearing the screen to avoid flickering
+	return "\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K\x1b[J"
+}
+
+func runTop(client *remoteClient, interval time.Duration) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("the top command requires a terminal")
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("unable to set the terminal in raw mode: %w", err)
+	}
+	// alternate screen and hidden cursor
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, oldState) //nolint:errcheck
+	}()
+
+	ui := newTopUI(client)
+	keys := make(chan string)
+	go readTopKeys(keys)
+
+	snapshots := make(chan topSnapshot, 1)
+	refreshing := false
+	refresh := func() {
+		if refreshing {
+			return
+		}
+		refreshing = true
+		go func() {
+			snapshots <- ui.fetch()
+		}()
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Fprint(os.Stdout, ui.render())
+	for {
+		select {
+		case s := <-snapshots:
+			refreshing = false
+			ui.update(s)
+		case <-ticker.C:
+			refresh()
+			continue
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			exit, needRefresh := ui.handleKey(key)
+			if exit {
+				return nil
+			}
+			if needRefresh {
+				refresh()
+			}
+		}
+		fmt.Fprint(os.Stdout, ui.render())
+	}
+}
+
+// readTopKeys reads the key presses from stdin, escape sequences, such as
+// the ones for the arrow keys, are sent as a single key
+func readTopKeys(keys chan<- string) {
+	defer close(keys)
+
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		data := string(buf[:n])
+		for data != "" {
+			if strings.HasPrefix(data, "\x1b[") && len(data) >= 3 {
+				keys <- data[:3]
+				data = data[3:]
+				continue
+			}
+			keys <- data[:1]
+			data = data[1:]
+		}
+	}
+}
+
+func getTopTransferKey(connectionID string, tr topTransfer) string {
+	return fmt.Sprintf("%s|%s|%s|%d", connectionID, tr.OperationType, tr.Path, tr.StartTime)
+}
+
+func getTopSpeedAsString(speed float64) string {
+	if speed <= 0 {
+		return "-"
+	}
+	return util.ByteCountIEC(int64(speed)) + "/s"
+}
+
+func countTopTransfers(connections []topConnection) int {
+	var result int
+	for _, c := range connections {
+		result += len(c.Transfers)
+	}
+	return result
+}
+
+func truncateTopField(s string, size int) string {
+	runes := []rune(s)
+	if len(runes) > size {
+		return string(runes[:size-1]) + "~"
+	}
+	return s
+}
+
+func init() {
+	topCmd.Flags().StringVarP(&remoteProfile, remoteProfileFlag, "p", viper.GetString(remoteProfileKey),
+		`Profile to use. This flag can be set using
+SFTPGO_REMOTE_PROFILE env var too.`)
+	topCmd.Flags().IntVar(&topRefreshInterval, "interval", 2, `Refresh interval in seconds`)
+
+	rootCmd.AddCommand(topCmd)
+}
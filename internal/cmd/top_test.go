@@ -0,0 +1,301 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type topTestServer struct {
+	sync.Mutex
+	*httptest.Server
+	connections []topConnection
+	hosts       []topDefenderHost
+	events      []topEvent
+	user        map[string]any
+	deleted     []string
+	updated     []map[string]any
+	failUpdate  bool
+}
+
+func newTopTestServer(t *testing.T) *topTestServer {
+	s := &topTestServer{
+		user: map[string]any{
+			"username":           "user1",
+			"upload_bandwidth":   0,
+			"download_bandwidth": 0,
+			"status":             1,
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+
+		assert.Equal(t, "key", r.Header.Get(remoteAPIKeyHeader))
+		path := strings.TrimPrefix(r.URL.EscapedPath(), remoteAPIBasePath)
+		switch {
+		case r.Method == http.MethodGet && path == "/connections":
+			json.NewEncoder(w).Encode(s.connections) //nolint:errcheck
+		case r.Method == http.MethodGet && path == "/defender/hosts":
+			json.NewEncoder(w).Encode(s.hosts) //nolint:errcheck
+		case r.Method == http.MethodGet && path == "/events/fs":
+			if s.events == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			assert.Equal(t, "2,3", r.URL.Query().Get("statuses"))
+			assert.Equal(t, "DESC", r.URL.Query().Get("order"))
+			json.NewEncoder(w).Encode(s.events) //nolint:errcheck
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/connections/"):
+			s.deleted = append(s.deleted, strings.TrimPrefix(path, "/connections/"))
+			w.Write([]byte(`{"message":"Connection closed"}`)) //nolint:errcheck
+		case r.Method == http.MethodGet && path == "/users/user1":
+			json.NewEncoder(w).Encode(s.user) //nolint:errcheck
+		case r.Method == http.MethodPut && path == "/users/user1":
+			if s.failUpdate {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"validation error"}`)) //nolint:errcheck
+				return
+			}
+			var user map[string]any
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.NoError(t, json.Unmarshal(body, &user))
+			s.updated = append(s.updated, user)
+			w.Write([]byte(`{"message":"User updated"}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`)) //nolint:errcheck
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTopTestUI(server *topTestServer) *topUI {
+	return newTopUI(&remoteClient{
+		profile: remoteProfileConfig{URL: server.URL, APIKey: "key"},
+		client:  &http.Client{Timeout: 5 * time.Second},
+	})
+}
+
+func TestTopArgs(t *testing.T) {
+	err := executeRemoteTestCmd("top", "extra")
+	assert.Error(t, err)
+	err = executeRemoteTestCmd("top", "--interval", "invalid")
+	assert.Error(t, err)
+}
+
+func TestTopFetchAndUpdate(t *testing.T) {
+	server := newTopTestServer(t)
+	now := time.Now()
+	server.connections = []topConnection{
+		{
+			Username:       "user2",
+			ConnectionID:   "SFTP_2",
+			Protocol:       "SFTP",
+			ConnectionTime: util.GetTimeAsMsSinceEpoch(now.Add(-time.Minute)),
+			Transfers: []topTransfer{
+				{
+					OperationType: "upload",
+					StartTime:     util.GetTimeAsMsSinceEpoch(now.Add(-10 * time.Second)),
+					Size:          10240,
+					Path:          "/file.bin",
+				},
+			},
+		},
+		{
+			Username:     "user1",
+			ConnectionID: "FTP_1",
+			Protocol:     "FTP",
+		},
+	}
+	server.hosts = []topDefenderHost{
+		{IP: "10.0.0.1", Score: 2},
+		{IP: "10.0.0.2", BanTime: now.Add(time.Hour).Format(time.RFC3339)},
+	}
+	ui := newTopTestUI(server)
+	s := ui.fetch()
+	assert.Len(t, s.errors, 0)
+	// the eventsearcher plugin is not available
+	assert.False(t, s.eventsAvailable)
+	ui.update(s)
+	require.Len(t, ui.snapshot.connections, 2)
+	assert.Equal(t, "FTP_1", ui.snapshot.connections[0].ConnectionID)
+	assert.Equal(t, "SFTP_2", ui.snapshot.connections[1].ConnectionID)
+	// banned hosts first
+	assert.Equal(t, "10.0.0.2", ui.snapshot.hosts[0].IP)
+	// the first speed is computed from the transfer start time
+	key := getTopTransferKey("SFTP_2", server.connections[0].Transfers[0])
+	assert.InDelta(t, 1024, ui.speeds[key], 100)
+	// the selection follows the connection
+	ui.selected = 1
+	server.Lock()
+	server.connections[0].Transfers[0].Size = 30720
+	server.connections = append(server.connections, topConnection{
+		Username:     "a_user",
+		ConnectionID: "DAV_3",
+		Protocol:     "DAV",
+	})
+	server.events = []topEvent{
+		{
+			Timestamp:   now.UnixNano(),
+			Action:      "upload",
+			Username:    "user1",
+			VirtualPath: "/quota.bin",
+			Status:      3,
+			Protocol:    "SFTP",
+			IP:          "10.0.0.3",
+		},
+	}
+	server.Unlock()
+	s = ui.fetch()
+	assert.True(t, s.eventsAvailable)
+	s.timestamp = ui.snapshot.timestamp.Add(2 * time.Second)
+	ui.update(s)
+	require.Len(t, ui.snapshot.connections, 3)
+	assert.Equal(t, "SFTP_2", ui.snapshot.connections[ui.selected].ConnectionID)
+	// the next speeds are computed from the previous sample
+	assert.InDelta(t, 10240, ui.speeds[key], 1)
+	assert.Len(t, ui.samples, 1)
+
+	out := ui.render()
+	assert.Contains(t, out, "Connections: 3, transfers: 1, defender hosts: 2")
+	// the selected connection is marked
+	assert.Contains(t, out, "> user2")
+	assert.Contains(t, out, "10.0 KiB/s")
+	assert.Contains(t, out, "banned until")
+	assert.Contains(t, out, "score 2")
+	assert.Contains(t, out, `"/quota.bin", user "user1", 10.0.0.3 quota exceeded`)
+	// API errors are shown in the recent errors section
+	server.Close()
+	s = ui.fetch()
+	assert.Len(t, s.errors, 2)
+	ui.update(s)
+	assert.Len(t, ui.snapshot.connections, 0)
+	assert.Len(t, ui.apiErrors, 2)
+	out = ui.render()
+	assert.Contains(t, out, "no active connections")
+	assert.Contains(t, out, "unable to get connections")
+	for i := 0; i < topMaxErrors; i++ {
+		ui.update(ui.fetch())
+	}
+	assert.Len(t, ui.apiErrors, topMaxErrors)
+}
+
+func TestTopKeys(t *testing.T) {
+	server := newTopTestServer(t)
+	server.connections = []topConnection{
+		{Username: "user1", ConnectionID: "SFTP_1", Protocol: "SFTP"},
+		{Username: "user1", ConnectionID: "SFTP_2", Protocol: "SFTP"},
+	}
+	ui := newTopTestUI(server)
+	ui.update(ui.fetch())
+
+	exit, refresh := ui.handleKey("j")
+	assert.False(t, exit)
+	assert.False(t, refresh)
+	assert.Equal(t, 1, ui.selected)
+	ui.handleKey("\x1b[B")
+	assert.Equal(t, 1, ui.selected)
+	ui.handleKey("\x1b[A")
+	assert.Equal(t, 0, ui.selected)
+	ui.handleKey("k")
+	assert.Equal(t, 0, ui.selected)
+	_, refresh = ui.handleKey("r")
+	assert.True(t, refresh)
+	// disconnect requires a confirmation
+	ui.handleKey("x")
+	assert.Equal(t, topModeConfirmKill, ui.mode)
+	assert.Contains(t, ui.render(), `disconnect "SFTP_1"`)
+	_, refresh = ui.handleKey("n")
+	assert.False(t, refresh)
+	assert.Equal(t, topModeNormal, ui.mode)
+	ui.handleKey("j")
+	ui.handleKey("x")
+	_, refresh = ui.handleKey("y")
+	assert.True(t, refresh)
+	assert.Equal(t, `connection "SFTP_2" closed`, ui.status)
+	server.Lock()
+	assert.Equal(t, []string{"SFTP_2"}, server.deleted)
+	server.Unlock()
+	// throttle the selected user
+	ui.handleKey("t")
+	assert.Equal(t, topModeThrottle, ui.mode)
+	for _, key := range []string{"1", "a", "2", "8", "\x7f"} {
+		ui.handleKey(key)
+	}
+	assert.Equal(t, "12", ui.input)
+	assert.Contains(t, ui.render(), `bandwidth limit for user "user1" in KB/s, 0 means no limit: 12`)
+	ui.handleKey("\r")
+	assert.Equal(t, topModeNormal, ui.mode)
+	assert.Equal(t, `bandwidth limit for user "user1" set to 12 KB/s`, ui.status)
+	ui.handleKey("t")
+	ui.handleKey("0")
+	ui.handleKey("\n")
+	assert.Equal(t, `bandwidth limit removed for user "user1"`, ui.status)
+	server.Lock()
+	if assert.Len(t, server.updated, 2) {
+		assert.Equal(t, float64(12), server.updated[0]["upload_bandwidth"])
+		assert.Equal(t, float64(12), server.updated[0]["download_bandwidth"])
+		// the other user fields are preserved
+		assert.Equal(t, float64(1), server.updated[0]["status"])
+		assert.Equal(t, float64(0), server.updated[1]["upload_bandwidth"])
+	}
+	server.failUpdate = true
+	server.Unlock()
+	ui.handleKey("t")
+	ui.handleKey("\r")
+	assert.Equal(t, `invalid bandwidth ""`, ui.status)
+	ui.handleKey("t")
+	ui.handleKey("5")
+	ui.handleKey("\r")
+	assert.Empty(t, ui.status)
+	if assert.NotEmpty(t, ui.apiErrors) {
+		assert.Contains(t, ui.apiErrors[len(ui.apiErrors)-1], "validation error")
+	}
+	// escape cancels the input
+	ui.handleKey("t")
+	ui.handleKey("\x1b")
+	assert.Equal(t, topModeNormal, ui.mode)
+
+	exit, _ = ui.handleKey("q")
+	assert.True(t, exit)
+}
+
+func TestTopHelpers(t *testing.T) {
+	assert.Equal(t, "-", getTopSpeedAsString(0))
+	assert.Equal(t, "1.0 KiB/s", getTopSpeedAsString(1024))
+	assert.Equal(t, "abc", truncateTopField("abc", 3))
+	assert.Equal(t, "ab~", truncateTopField("abcd", 3))
+	assert.Equal(t, "àè~", truncateTopField("àèìò", 3))
+	assert.Equal(t, 3, countTopTransfers([]topConnection{
+		{Transfers: make([]topTransfer, 2)},
+		{},
+		{Transfers: make([]topTransfer, 1)},
+	}))
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	verifyAuditLogCmd = &cobra.Command{
+		Use:   "verifyauditlog",
+		Short: "Verify the integrity of the audit log",
+		Long: `This command reads the data provider connection details from the specified
+configuration file and checks the hash chains of the audit log entries.
+If an HMAC secret is configured for the audit log, the same secret used to
+record the entries is required.
+The command exits with a non-zero status if the verification fails.
+This command is not supported for the memory provider.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("memory provider is not supported")
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Initializing provider: %q config file: %q", providerConf.Driver, viper.ConfigFileUsed())
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+				os.Exit(1)
+			}
+			result, err := dataprovider.VerifyAuditLog()
+			if err != nil {
+				logger.ErrorToConsole("Unable to verify the audit log: %v", err)
+				os.Exit(1)
+			}
+			if !result.Valid {
+				logger.ErrorToConsole("Audit log verification failed at entry %d: %s, verified entries: %d",
+					result.InvalidID, result.Error, result.Entries)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Audit log verified, entries: %d, nodes: %q", result.Entries,
+				strings.Join(result.Nodes, ", "))
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(verifyAuditLogCmd)
+
+	rootCmd.AddCommand(verifyAuditLogCmd)
+}
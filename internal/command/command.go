@@ -42,12 +42,14 @@ const (
 	HookPostLogin           = "post_login"
 	HookExternalAuth        = "external_auth"
 	HookKeyboardInteractive = "keyboard_interactive"
+	HookContentExtractor    = "content_extractor"
 )
 
 var (
 	config         Config
 	supportedHooks = []string{HookFsActions, HookProviderActions, HookStartup, HookPostConnect, HookPostDisconnect,
-		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive}
+		HookDataRetention, HookCheckPassword, HookPreLogin, HookPostLogin, HookExternalAuth, HookKeyboardInteractive,
+		HookContentExtractor}
 )
 
 // Command define the configuration for a specific commands
@@ -38,6 +38,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 var (
@@ -112,6 +113,7 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 			Protocol:          event.Protocol,
 			IP:                event.IP,
 			Role:              event.Role,
+			Tenant:            conn.User.Tenant,
 			Timestamp:         event.Timestamp,
 			Email:             conn.User.Email,
 			Object:            nil,
@@ -131,6 +133,11 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtualPath, target, virtualTarget, sshCmd string,
 	fileSize int64, err error, elapsed int64, metadata map[string]string,
 ) error {
+	if operation == operationUpload || operation == operationDownload {
+		publishTransferLiveEvent(conn, operation, virtualPath, fileSize, elapsed, conn.getNotificationStatus(err))
+	}
+	updateSearchIndex(conn, operation, virtualPath, virtualTarget, fileSize, err)
+	recordQuotaScanChange(conn, operation, virtualPath, virtualTarget)
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
 	hasHook := util.Contains(Config.Actions.ExecuteOn, operation)
 	hasRules := eventManager.hasFsRules()
@@ -159,6 +166,7 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 			Protocol:          notification.Protocol,
 			IP:                notification.IP,
 			Role:              notification.Role,
+			Tenant:            conn.User.Tenant,
 			Timestamp:         notification.Timestamp,
 			Email:             conn.User.Email,
 			Object:            nil,
@@ -218,6 +226,8 @@ func newActionNotification(
 		endpoint = fsConfig.SFTPConfig.Endpoint
 	case sdk.HTTPFilesystemProvider:
 		endpoint = fsConfig.HTTPConfig.Endpoint
+	case vfs.B2FilesystemProvider:
+		bucket = fsConfig.B2Config.Bucket
 	}
 
 	return &notifier.FsEvent{
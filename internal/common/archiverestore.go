@@ -0,0 +1,198 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// the status of the pending restores is checked using this interval
+	archiveRestoreCheckInterval = 5 * time.Minute
+	// restores not completed within this time are reported as failed.
+	// Restoring from S3 Deep Archive can take up to 48 hours
+	archiveRestoreTimeout = 72 * time.Hour
+	archiveRestores       = archiveRestoreJobs{
+		jobs: make(map[string]string),
+	}
+	errArchiveRestoreNotInProgress = errors.New("the restore is no longer in progress")
+	errArchiveRestoreTimeout       = errors.New("the restore did not complete in time")
+)
+
+// ArchiveRestoreResult is the result of an archive restore job
+type ArchiveRestoreResult struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// archiveRestoreJobs maps users and paths to the running restore jobs, so
+// requesting a restore for a file already being restored does not start
+// another job
+type archiveRestoreJobs struct {
+	sync.Mutex
+	jobs map[string]string
+}
+
+func (r *archiveRestoreJobs) getKey(username, virtualPath string) string {
+	return username + ":" + virtualPath
+}
+
+// start returns the running job for the given key, if any, or starts a new
+// one using the provided function
+func (r *archiveRestoreJobs) start(key string, startFn func() Job) Job {
+	r.Lock()
+	defer r.Unlock()
+
+	if id, ok := r.jobs[key]; ok {
+		if job, err := Jobs.Get(id, ""); err == nil && job.Status == JobStatusRunning {
+			return job
+		}
+	}
+	job := startFn()
+	r.jobs[key] = job.ID
+	return job
+}
+
+func (r *archiveRestoreJobs) remove(key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.jobs, key)
+}
+
+// RestoreArchivedFile starts restoring the specified file from an archive
+// storage tier. Restoring is asynchronous, a background job tracks its status
+// and an "archive-restore" event is triggered when the file can be downloaded
+// or the restore fails. If a restore is already in progress its job is returned
+func (c *BaseConnection) RestoreArchivedFile(virtualPath string) (Job, error) {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return Job{}, util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "restoring file %q is not allowed", virtualPath)
+		return Job{}, util.NewI18nError(c.GetErrorForDeniedFile(policy), util.I18nError403Message)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return Job{}, err
+	}
+	restorer, ok := fs.(vfs.FsArchiveRestorer)
+	if !ok {
+		return Job{}, util.NewI18nError(fmt.Errorf("%w: archive restore is not supported for %q",
+			c.GetOpUnsupportedError(), virtualPath), util.I18nErrorArchiveRestoreUnsupported)
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return Job{}, c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return Job{}, util.NewI18nError(fmt.Errorf("%w: %q is not a regular file", c.GetOpUnsupportedError(),
+			virtualPath), util.I18nErrorArchiveRestoreNotFile)
+	}
+	status, err := restorer.GetArchiveStatus(fsPath)
+	if err != nil {
+		return Job{}, c.GetFsError(fs, err)
+	}
+	if status == vfs.ArchiveStatusNone {
+		return Job{}, util.NewI18nError(fmt.Errorf("%w: %q is not archived", c.GetOpUnsupportedError(), virtualPath),
+			util.I18nErrorArchiveRestoreNotArchived)
+	}
+
+	if status == vfs.ArchiveStatusArchived {
+		// requesting a restore already in progress is not an error
+		if err := restorer.RestoreArchived(fsPath); err != nil {
+			c.Log(logger.LevelError, "unable to restore archived file %q: %v", virtualPath, err)
+			return Job{}, c.GetFsError(fs, err)
+		}
+	}
+
+	key := archiveRestores.getKey(c.User.Username, virtualPath)
+	job := archiveRestores.start(key, func() Job {
+		c.Log(logger.LevelInfo, "restore started for archived file %q", virtualPath)
+		// the job outlives the connection that started it
+		conn := NewBaseConnection(util.GenerateUniqueID(), c.protocol, c.localAddr, c.remoteAddr, c.User)
+		return Jobs.StartForUser(JobTypeArchiveRestore, c.User.Username, c.User.Role, true,
+			func(ctx context.Context, _ func(int)) (any, error) {
+				defer archiveRestores.remove(key)
+
+				return conn.waitArchiveRestore(ctx, restorer, fsPath, virtualPath, info.Size())
+			})
+	})
+	return job, nil
+}
+
+// waitArchiveRestore periodically checks the archive status for the specified
+// file and triggers an event when the restore completes or fails
+func (c *BaseConnection) waitArchiveRestore(ctx context.Context, restorer vfs.FsArchiveRestorer,
+	fsPath, virtualPath string, size int64,
+) (any, error) {
+	startTime := time.Now()
+	result := ArchiveRestoreResult{
+		Path: virtualPath,
+		Size: size,
+	}
+	ticker := time.NewTicker(archiveRestoreCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.Log(logger.LevelInfo, "restore monitoring canceled for archived file %q", virtualPath)
+			return result, ctx.Err()
+		case <-ticker.C:
+			status, err := restorer.GetArchiveStatus(fsPath)
+			if err != nil {
+				c.Log(logger.LevelError, "unable to get the archive status for file %q: %v", virtualPath, err)
+				if !restorer.IsNotExist(err) && time.Since(startTime) < archiveRestoreTimeout {
+					continue
+				}
+			} else {
+				switch status {
+				case vfs.ArchiveStatusNone:
+					c.Log(logger.LevelInfo, "archived file %q restored, elapsed: %s", virtualPath,
+						time.Since(startTime))
+					c.notifyArchiveRestore(fsPath, virtualPath, size, nil, startTime)
+					return result, nil
+				case vfs.ArchiveStatusArchived:
+					err = errArchiveRestoreNotInProgress
+				default:
+					if time.Since(startTime) < archiveRestoreTimeout {
+						continue
+					}
+					err = errArchiveRestoreTimeout
+				}
+			}
+			c.Log(logger.LevelError, "restore failed for archived file %q: %v", virtualPath, err)
+			c.notifyArchiveRestore(fsPath, virtualPath, size, err, startTime)
+			return result, err
+		}
+	}
+}
+
+func (c *BaseConnection) notifyArchiveRestore(fsPath, virtualPath string, size int64, err error, startTime time.Time) {
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	ExecuteActionNotification(c, operationArchiveRestore, fsPath, virtualPath, "", "", "", size, err, //nolint:errcheck
+		elapsed, nil)
+}
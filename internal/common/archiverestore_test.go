@@ -0,0 +1,150 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// mockArchiveFs returns the configured archive statuses, in order. The last
+// status is repeated
+type mockArchiveFs struct {
+	vfs.Fs
+	sync.Mutex
+	statuses  []int
+	statusErr error
+}
+
+func (fs *mockArchiveFs) RestoreArchived(_ string) error {
+	return nil
+}
+
+func (fs *mockArchiveFs) GetArchiveStatus(_ string) (int, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.statusErr != nil {
+		return vfs.ArchiveStatusNone, fs.statusErr
+	}
+	status := fs.statuses[0]
+	if len(fs.statuses) > 1 {
+		fs.statuses = fs.statuses[1:]
+	}
+	return status, nil
+}
+
+func TestWaitArchiveRestore(t *testing.T) {
+	checkInterval := archiveRestoreCheckInterval
+	timeout := archiveRestoreTimeout
+	archiveRestoreCheckInterval = 10 * time.Millisecond
+	defer func() {
+		archiveRestoreCheckInterval = checkInterval
+		archiveRestoreTimeout = timeout
+	}()
+
+	conn := NewBaseConnection("", ProtocolHTTP, "", "", dataprovider.User{BaseUser: sdk.BaseUser{HomeDir: os.TempDir()}})
+	fs := &mockArchiveFs{
+		Fs:       vfs.NewOsFs("", os.TempDir(), "", nil),
+		statuses: []int{vfs.ArchiveStatusRestoring, vfs.ArchiveStatusRestoring, vfs.ArchiveStatusNone},
+	}
+	result, err := conn.waitArchiveRestore(context.Background(), fs, "/file", "/file", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, ArchiveRestoreResult{Path: "/file", Size: 100}, result)
+
+	fs.statuses = []int{vfs.ArchiveStatusRestoring, vfs.ArchiveStatusArchived}
+	_, err = conn.waitArchiveRestore(context.Background(), fs, "/file", "/file", 100)
+	assert.ErrorIs(t, err, errArchiveRestoreNotInProgress)
+
+	fs.statuses = []int{vfs.ArchiveStatusRestoring}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = conn.waitArchiveRestore(ctx, fs, "/file", "/file", 100)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	archiveRestoreTimeout = 50 * time.Millisecond
+	_, err = conn.waitArchiveRestore(context.Background(), fs, "/file", "/file", 100)
+	assert.ErrorIs(t, err, errArchiveRestoreTimeout)
+
+	archiveRestoreTimeout = timeout
+	fs.statusErr = os.ErrNotExist
+	_, err = conn.waitArchiveRestore(context.Background(), fs, "/file", "/file", 100)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestArchiveRestoreJobs(t *testing.T) {
+	key := archiveRestores.getKey("user", "/file")
+	done := make(chan struct{})
+	startFn := func() Job {
+		return Jobs.StartForUser(JobTypeArchiveRestore, "user", "", true, func(_ context.Context, _ func(int)) (any, error) {
+			<-done
+			archiveRestores.remove(key)
+			return nil, nil
+		})
+	}
+	job1 := archiveRestores.start(key, startFn)
+	job2 := archiveRestores.start(key, startFn)
+	assert.Equal(t, job1.ID, job2.ID)
+	close(done)
+	assert.Eventually(t, func() bool {
+		info, err := Jobs.GetForUser(job1.ID, "user")
+		return err == nil && info.Status == JobStatusCompleted
+	}, 1*time.Second, 50*time.Millisecond)
+	done = make(chan struct{})
+	job3 := archiveRestores.start(key, startFn)
+	assert.NotEqual(t, job1.ID, job3.ID)
+	close(done)
+}
+
+func TestRestoreArchivedFileErrors(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "archive_restore_test")
+	err := os.MkdirAll(filepath.Join(homeDir, "dir"), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(homeDir)
+
+	err = os.WriteFile(filepath.Join(homeDir, "file"), []byte("data"), 0666)
+	require.NoError(t, err)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "archive_user",
+			HomeDir:  homeDir,
+			Permissions: map[string][]string{
+				"/":    {dataprovider.PermAny},
+				"/dir": {dataprovider.PermListItems},
+			},
+		},
+	}
+	conn := NewBaseConnection("", ProtocolHTTP, "", "", user)
+	_, err = conn.RestoreArchivedFile("/dir/file")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	// the local filesystem has no archive tiers
+	_, err = conn.RestoreArchivedFile("/file")
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+}
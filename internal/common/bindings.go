@@ -0,0 +1,206 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// ErrBindingRemoved defines the error returned serving a binding removed
+// reloading the configuration
+var ErrBindingRemoved = errors.New("binding removed reloading the configuration")
+
+// BindingListener is the listener started for a service binding
+type BindingListener struct {
+	mu      sync.Mutex
+	stopFn  func() error
+	removed bool
+}
+
+// SetStopFunc sets the function to call to stop accepting new connections.
+// The active connections must not be affected. If the binding was removed in
+// the meantime the function is called immediately and ErrBindingRemoved is returned
+func (l *BindingListener) SetStopFunc(fn func() error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.removed {
+		fn() //nolint:errcheck
+		return ErrBindingRemoved
+	}
+	l.stopFn = fn
+	return nil
+}
+
+// IsRemoved returns true if the binding was removed reloading the configuration
+func (l *BindingListener) IsRemoved() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.removed
+}
+
+func (l *BindingListener) stop(address, logSender string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removed = true
+	if l.stopFn == nil {
+		return
+	}
+	stopFn := l.stopFn
+	go func() {
+		err := stopFn()
+		logger.Info(logSender, "", "listener for binding %q stopped, err: %v", address, err)
+	}()
+}
+
+type runningBinding[T any] struct {
+	binding  T
+	listener *BindingListener
+}
+
+// BindingsRegistry tracks the listeners started for the bindings of a service,
+// so that bindings can be added and removed, reloading the configuration,
+// without affecting the active connections
+type BindingsRegistry[T any] struct {
+	mu         sync.Mutex
+	logSender  string
+	getAddress func(T) string
+	serve      func(T, *BindingListener) error
+	bindings   map[string]*runningBinding[T]
+}
+
+// NewBindingsRegistry returns a registry that uses the specified function to
+// serve the bindings
+func NewBindingsRegistry[T any](logSender string, getAddress func(T) string,
+	serve func(T, *BindingListener) error,
+) *BindingsRegistry[T] {
+	return &BindingsRegistry[T]{
+		logSender:  logSender,
+		getAddress: getAddress,
+		serve:      serve,
+		bindings:   make(map[string]*runningBinding[T]),
+	}
+}
+
+// Serve registers and serves the specified binding. It blocks until the
+// listener is stopped and returns ErrBindingRemoved if the binding was removed
+// reloading the configuration
+func (r *BindingsRegistry[T]) Serve(binding T) error {
+	r.mu.Lock()
+	rb := r.addLocked(binding)
+	r.mu.Unlock()
+
+	return r.serveBinding(rb)
+}
+
+func (r *BindingsRegistry[T]) addLocked(binding T) *runningBinding[T] {
+	rb := &runningBinding[T]{
+		binding:  binding,
+		listener: &BindingListener{},
+	}
+	r.bindings[r.getAddress(binding)] = rb
+	return rb
+}
+
+func (r *BindingsRegistry[T]) serveBinding(rb *runningBinding[T]) error {
+	err := r.serve(rb.binding, rb.listener)
+	if rb.listener.IsRemoved() {
+		return ErrBindingRemoved
+	}
+	address := r.getAddress(rb.binding)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.bindings[address] == rb {
+		delete(r.bindings, address)
+	}
+	return err
+}
+
+// Reload applies the specified bindings. New bindings are started and removed
+// bindings stop accepting new connections, the active connections are not
+// affected. Changing the settings of an existing binding requires a restart.
+// canStart, if not nil, is called before starting a new binding and the binding
+// is skipped if it returns an error. The bindings served after the reload are
+// returned
+func (r *BindingsRegistry[T]) Reload(bindings []T, canStart func(T) error) []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	newBindings := make(map[string]T)
+	for _, binding := range bindings {
+		newBindings[r.getAddress(binding)] = binding
+	}
+	for address, rb := range r.bindings {
+		if _, ok := newBindings[address]; !ok {
+			logger.Info(r.logSender, "", "binding %q removed, stopping the listener", address)
+			delete(r.bindings, address)
+			rb.listener.stop(address, r.logSender)
+		}
+	}
+	var result []T
+	for _, binding := range bindings {
+		address := r.getAddress(binding)
+		if rb, ok := r.bindings[address]; ok {
+			if !isSameBinding(rb.binding, binding) {
+				logger.Warn(r.logSender, "", "the settings for binding %q changed, a restart is required to apply them",
+					address)
+			}
+			result = append(result, rb.binding)
+			continue
+		}
+		if canStart != nil {
+			if err := canStart(binding); err != nil {
+				logger.Warn(r.logSender, "", "unable to start binding %q, a restart is required: %v", address, err)
+				continue
+			}
+		}
+		logger.Info(r.logSender, "", "binding %q added, starting the listener", address)
+		rb := r.addLocked(binding)
+		go func() {
+			if err := r.serveBinding(rb); err != nil && !errors.Is(err, ErrBindingRemoved) {
+				logger.Error(r.logSender, "", "listener for binding %q stopped, err: %v", address, err)
+			}
+		}()
+		result = append(result, binding)
+	}
+	return result
+}
+
+// isSameBinding compares the configurable, exported, binding settings
+func isSameBinding(b1, b2 any) bool {
+	data1, err1 := json.Marshal(b1)
+	data2, err2 := json.Marshal(b2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return bytes.Equal(data1, data2)
+}
+
+// Len returns the number of bindings served
+func (r *BindingsRegistry[T]) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.bindings)
+}
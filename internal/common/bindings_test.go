@@ -0,0 +1,183 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBinding struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Banner  string `json:"banner"`
+}
+
+func (b *testBinding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+func serveTestBinding(b testBinding, l *BindingListener) error {
+	listener, err := net.Listen("tcp", b.GetAddress())
+	if err != nil {
+		return err
+	}
+	if err := l.SetStopFunc(listener.Close); err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+
+			scanner := bufio.NewScanner(c)
+			for scanner.Scan() {
+				if _, err := fmt.Fprintf(c, "%s %s\n", b.Banner, scanner.Text()); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func sendTestBindingLine(conn net.Conn, line string) (string, error) {
+	if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+		return "", err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func isTestBindingListening(address string) bool {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestBindingsRegistry(t *testing.T) {
+	b1 := testBinding{
+		Address: "127.0.0.1",
+		Port:    9881,
+		Banner:  "b1",
+	}
+	b2 := testBinding{
+		Address: "127.0.0.1",
+		Port:    9882,
+		Banner:  "b2",
+	}
+	registry := NewBindingsRegistry(logSender, func(b testBinding) string {
+		return b.GetAddress()
+	}, serveTestBinding)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- registry.Serve(b1)
+	}()
+	assert.Eventually(t, func() bool {
+		return isTestBindingListening(b1.GetAddress())
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 1, registry.Len())
+	// add a binding
+	bindings := registry.Reload([]testBinding{b1, b2}, nil)
+	assert.Equal(t, []testBinding{b1, b2}, bindings)
+	assert.Eventually(t, func() bool {
+		return isTestBindingListening(b2.GetAddress())
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 2, registry.Len())
+	conn, err := net.Dial("tcp", b1.GetAddress())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	line, err := sendTestBindingLine(conn, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "b1 hello\n", line)
+	// changing the settings of an existing binding requires a restart
+	changed := b2
+	changed.Banner = "changed"
+	bindings = registry.Reload([]testBinding{b1, changed}, nil)
+	assert.Equal(t, []testBinding{b1, b2}, bindings)
+	// new bindings are skipped if they cannot be started
+	b3 := testBinding{
+		Address: "127.0.0.1",
+		Port:    9883,
+	}
+	bindings = registry.Reload([]testBinding{b1, b2, b3}, func(_ testBinding) error {
+		return errors.New("no certificate")
+	})
+	assert.Equal(t, []testBinding{b1, b2}, bindings)
+	assert.Equal(t, 2, registry.Len())
+	// remove the first binding, the active connections are not affected
+	bindings = registry.Reload([]testBinding{b2}, nil)
+	assert.Equal(t, []testBinding{b2}, bindings)
+	assert.Equal(t, 1, registry.Len())
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, ErrBindingRemoved)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "the removed binding is still served")
+	}
+	assert.False(t, isTestBindingListening(b1.GetAddress()))
+	line, err = sendTestBindingLine(conn, "still connected")
+	assert.NoError(t, err)
+	assert.Equal(t, "b1 still connected\n", line)
+	// a binding that cannot be served is removed from the registry
+	b4 := testBinding{
+		Address: "127.0.0.1",
+		Port:    9884,
+	}
+	listener, err := net.Listen("tcp", b4.GetAddress())
+	require.NoError(t, err)
+	defer listener.Close()
+
+	bindings = registry.Reload([]testBinding{b2, b4}, nil)
+	assert.Equal(t, []testBinding{b2, b4}, bindings)
+	assert.Eventually(t, func() bool {
+		return registry.Len() == 1
+	}, 2*time.Second, 50*time.Millisecond)
+
+	bindings = registry.Reload(nil, nil)
+	assert.Len(t, bindings, 0)
+	assert.Eventually(t, func() bool {
+		return !isTestBindingListening(b2.GetAddress())
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestBindingListenerRemoved(t *testing.T) {
+	l := &BindingListener{}
+	assert.False(t, l.IsRemoved())
+	l.stop("address", logSender)
+	assert.True(t, l.IsRemoved())
+	stopped := false
+	err := l.SetStopFunc(func() error {
+		stopped = true
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrBindingRemoved)
+	assert.True(t, stopped)
+}
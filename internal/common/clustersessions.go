@@ -0,0 +1,219 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	clusterConnectionsPath   = "/api/v2/connections"
+	clusterDefenderHostsPath = "/api/v2/defender/hosts"
+	// the node auth token requires an admin username, the connections are
+	// requested without a role so all of them are returned
+	clusterSessionsRequester = "__sftpgo_cluster"
+)
+
+var (
+	clusterSessions = newRemoteSessions()
+)
+
+// remoteSessions holds the sessions active on the other cluster nodes, they
+// are periodically loaded and used to enforce cluster-wide limits
+type remoteSessions struct {
+	sync.RWMutex
+	enabled bool
+	total   int
+	perUser map[string]int
+	// last update for each node, sessions from nodes that cannot be
+	// contacted for too long are discarded
+	nodes map[string]time.Time
+	users map[string]map[string]int
+}
+
+func newRemoteSessions() *remoteSessions {
+	return &remoteSessions{
+		perUser: make(map[string]int),
+		nodes:   make(map[string]time.Time),
+		users:   make(map[string]map[string]int),
+	}
+}
+
+func (s *remoteSessions) setEnabled(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.enabled = enabled
+	if !enabled {
+		s.total = 0
+		s.perUser = make(map[string]int)
+		s.nodes = make(map[string]time.Time)
+		s.users = make(map[string]map[string]int)
+	}
+}
+
+func (s *remoteSessions) isEnabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.enabled
+}
+
+func (s *remoteSessions) getTotal() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.total
+}
+
+func (s *remoteSessions) getUserSessions(username string) int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.perUser[username]
+}
+
+// setNodeSessions updates the sessions for the specified node
+func (s *remoteSessions) setNodeSessions(node string, stats []ConnectionStatus) {
+	users := make(map[string]int)
+	for _, stat := range stats {
+		if stat.Username != "" {
+			users[stat.Username]++
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.enabled {
+		return
+	}
+	s.nodes[node] = time.Now()
+	s.users[node] = users
+	s.updateTotalsLocked()
+}
+
+// removeStaleNodes removes the sessions for the nodes not updated
+// after the specified time
+func (s *remoteSessions) removeStaleNodes(updatedBefore time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for node, lastUpdate := range s.nodes {
+		if lastUpdate.Before(updatedBefore) {
+			logger.Debug(logSender, "", "removing stale sessions for node %q, last update: %s", node, lastUpdate)
+			delete(s.nodes, node)
+			delete(s.users, node)
+		}
+	}
+	s.updateTotalsLocked()
+}
+
+func (s *remoteSessions) updateTotalsLocked() {
+	s.total = 0
+	s.perUser = make(map[string]int)
+	for _, users := range s.users {
+		for username, sessions := range users {
+			s.perUser[username] += sessions
+			s.total += sessions
+		}
+	}
+}
+
+func (s *remoteSessions) update(interval time.Duration) {
+	nodes, err := dataprovider.GetNodes()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get cluster nodes, the remote sessions will not be updated: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+
+	for _, n := range nodes {
+		wg.Add(1)
+
+		go func(node dataprovider.Node) {
+			defer wg.Done()
+
+			var stats []ConnectionStatus
+			if err := node.SendGetRequest(clusterSessionsRequester, "", clusterConnectionsPath, &stats); err != nil {
+				logger.Warn(logSender, "", "unable to get sessions from node %q: %v", node.Name, err)
+				return
+			}
+			s.setNodeSessions(node.Name, stats)
+			replicateDefenderBans(node)
+		}(n)
+	}
+	wg.Wait()
+
+	s.removeStaleNodes(time.Now().Add(-3 * interval))
+	logger.Debug(logSender, "", "cluster sessions updated, nodes: %d, remote sessions: %d", len(nodes), s.getTotal())
+}
+
+// remoteDefenderHost is a host returned by the defender API of another node
+type remoteDefenderHost struct {
+	IP      string `json:"ip"`
+	BanTime string `json:"ban_time"`
+}
+
+// getReplicatedDefender returns the in-memory defender, if in use. Its bans
+// are replicated between the cluster nodes, the "provider" driver already
+// shares the defender state using the data provider
+func getReplicatedDefender() (*memoryDefender, bool) {
+	if !clusterSessions.isEnabled() {
+		return nil, false
+	}
+	defender, ok := getDefender().(*memoryDefender)
+	return defender, ok
+}
+
+// IsDefenderReplicated returns true if the defender bans are replicated
+// between the cluster nodes, the hosts removed on a node must be removed
+// on the other nodes too
+func IsDefenderReplicated() bool {
+	_, ok := getReplicatedDefender()
+	return ok
+}
+
+// replicateDefenderBans adds the hosts banned on the specified node
+func replicateDefenderBans(node dataprovider.Node) {
+	defender, ok := getReplicatedDefender()
+	if !ok {
+		return
+	}
+	var hosts []remoteDefenderHost
+	if err := node.SendGetRequest(clusterSessionsRequester, "", clusterDefenderHostsPath, &hosts); err != nil {
+		logger.Warn(logSender, "", "unable to get defender hosts from node %q: %v", node.Name, err)
+		return
+	}
+	bans := make(map[string]time.Time)
+	for _, host := range hosts {
+		if host.BanTime == "" {
+			continue
+		}
+		banTime, err := time.Parse(time.RFC3339, host.BanTime)
+		if err != nil {
+			logger.Debug(logSender, "", "invalid ban time %q for host %q from node %q", host.BanTime, host.IP, node.Name)
+			continue
+		}
+		bans[host.IP] = banTime
+	}
+	if added := defender.addRemoteBans(bans); added > 0 {
+		logger.Debug(logSender, "", "bans added from node %q: %d", node.Name, added)
+	}
+}
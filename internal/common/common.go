@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -48,25 +49,27 @@ import (
 
 // constants
 const (
-	logSender              = "common"
-	uploadLogSender        = "Upload"
-	downloadLogSender      = "Download"
-	renameLogSender        = "Rename"
-	rmdirLogSender         = "Rmdir"
-	mkdirLogSender         = "Mkdir"
-	symlinkLogSender       = "Symlink"
-	removeLogSender        = "Remove"
-	chownLogSender         = "Chown"
-	chmodLogSender         = "Chmod"
-	chtimesLogSender       = "Chtimes"
-	copyLogSender          = "Copy"
-	truncateLogSender      = "Truncate"
-	operationDownload      = "download"
-	operationUpload        = "upload"
-	operationFirstDownload = "first-download"
-	operationFirstUpload   = "first-upload"
-	operationDelete        = "delete"
-	operationCopy          = "copy"
+	logSender               = "common"
+	uploadLogSender         = "Upload"
+	downloadLogSender       = "Download"
+	renameLogSender         = "Rename"
+	rmdirLogSender          = "Rmdir"
+	mkdirLogSender          = "Mkdir"
+	symlinkLogSender        = "Symlink"
+	removeLogSender         = "Remove"
+	chownLogSender          = "Chown"
+	chmodLogSender          = "Chmod"
+	chtimesLogSender        = "Chtimes"
+	copyLogSender           = "Copy"
+	truncateLogSender       = "Truncate"
+	operationDownload       = "download"
+	operationUpload         = "upload"
+	operationFirstDownload  = "first-download"
+	operationFirstUpload    = "first-upload"
+	operationDelete         = "delete"
+	operationCopy           = "copy"
+	operationQuotaThreshold = "quota-threshold"
+	operationArchiveRestore = "archive-restore"
 	// Pre-download action name
 	OperationPreDownload = "pre-download"
 	// Pre-upload action name
@@ -96,6 +99,14 @@ const (
 	TransferDownload
 )
 
+// Filesystem operations tracked in the latency metrics
+const (
+	FsOperationOpen  = "open"
+	FsOperationRead  = "read"
+	FsOperationWrite = "write"
+	FsOperationList  = "list"
+)
+
 // Supported protocols
 const (
 	ProtocolSFTP          = "SFTP"
@@ -105,8 +116,10 @@ const (
 	ProtocolWebDAV        = "DAV"
 	ProtocolHTTP          = "HTTP"
 	ProtocolHTTPShare     = "HTTPShare"
+	ProtocolS3            = "S3"
 	ProtocolDataRetention = "DataRetention"
 	ProtocolOIDC          = "OIDC"
+	ProtocolSAML          = "SAML"
 	protocolEventAction   = "EventAction"
 )
 
@@ -157,18 +170,24 @@ var (
 	QuotaScans         ActiveScans
 	transfersChecker   TransfersChecker
 	supportedProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
-		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC}
+		ProtocolHTTP, ProtocolHTTPShare, ProtocolS3, ProtocolOIDC, ProtocolSAML}
 	disconnHookProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP}
 	// the map key is the protocol, for each protocol we can have multiple rate limiters
-	rateLimiters     map[string][]*rateLimiter
+	rateLimiters map[string][]*rateLimiter
+	// reloadMutex protects the rate limiters and the defender, they can be replaced
+	// reloading the configuration
+	reloadMutex      sync.RWMutex
 	isShuttingDown   atomic.Bool
+	isDraining       atomic.Bool
 	ftpLoginCommands = []string{"PASS", "USER"}
 )
 
 // Initialize sets the common configuration
 func Initialize(c Configuration, isShared int) error {
 	isShuttingDown.Store(false)
+	isDraining.Store(false)
 	util.SetUmask(c.Umask)
+	util.SetReusePort(c.ReusePort)
 	version.SetConfig(c.ServerVersion)
 	dataprovider.SetTZ(c.TZ)
 	Config = c
@@ -181,43 +200,17 @@ func Initialize(c Configuration, isShared int) error {
 	Config.defender = nil
 	Config.allowList = nil
 	Config.rateLimitersList = nil
-	rateLimiters = make(map[string][]*rateLimiter)
-	for _, rlCfg := range c.RateLimitersConfig {
-		if rlCfg.isEnabled() {
-			if err := rlCfg.validate(); err != nil {
-				return fmt.Errorf("rate limiters initialization error: %w", err)
-			}
-			rateLimiter := rlCfg.getLimiter()
-			for _, protocol := range rlCfg.Protocols {
-				rateLimiters[protocol] = append(rateLimiters[protocol], rateLimiter)
-			}
-		}
-	}
-	if len(rateLimiters) > 0 {
-		rateLimitersList, err := dataprovider.NewIPList(dataprovider.IPListTypeRateLimiterSafeList)
-		if err != nil {
-			return fmt.Errorf("unable to initialize ratelimiters list: %w", err)
-		}
-		Config.rateLimitersList = rateLimitersList
+	limiters, limitersList, err := newRateLimiters(c.RateLimitersConfig)
+	if err != nil {
+		return err
 	}
-	if c.DefenderConfig.Enabled {
-		if !util.Contains(supportedDefenderDrivers, c.DefenderConfig.Driver) {
-			return fmt.Errorf("unsupported defender driver %q", c.DefenderConfig.Driver)
-		}
-		var defender Defender
-		var err error
-		switch c.DefenderConfig.Driver {
-		case DefenderDriverProvider:
-			defender, err = newDBDefender(&c.DefenderConfig)
-		default:
-			defender, err = newInMemoryDefender(&c.DefenderConfig)
-		}
-		if err != nil {
-			return fmt.Errorf("defender initialization error: %v", err)
-		}
-		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
-		Config.defender = defender
+	rateLimiters = limiters
+	Config.rateLimitersList = limitersList
+	defender, err := newDefender(c.DefenderConfig)
+	if err != nil {
+		return err
 	}
+	Config.defender = defender
 	if c.AllowListStatus > 0 {
 		allowList, err := dataprovider.NewIPList(dataprovider.IPListTypeAllowList)
 		if err != nil {
@@ -234,13 +227,116 @@ func Initialize(c Configuration, isShared int) error {
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	vfs.SetRenameMode(c.RenameMode)
 	vfs.SetReadMetadataMode(c.Metadata.Read)
+	vfs.SetMetadataCache(c.Metadata.CacheTTL, c.Metadata.CacheMaxEntries)
 	vfs.SetResumeMaxSize(c.ResumeMaxSize)
+	vfs.SetQuotaScanWorkers(c.QuotaScanWorkers)
 	vfs.SetUploadMode(c.UploadMode)
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	transfersChecker = getTransfersChecker(isShared)
+	return searchIndex.initialize(c.SearchIndex)
+}
+
+func newRateLimiters(configs []RateLimiterConfig) (map[string][]*rateLimiter, *dataprovider.IPList, error) {
+	limiters := make(map[string][]*rateLimiter)
+	for _, rlCfg := range configs {
+		if rlCfg.isEnabled() {
+			if err := rlCfg.validate(); err != nil {
+				return nil, nil, fmt.Errorf("rate limiters initialization error: %w", err)
+			}
+			rateLimiter := rlCfg.getLimiter()
+			for _, protocol := range rlCfg.Protocols {
+				limiters[protocol] = append(limiters[protocol], rateLimiter)
+			}
+		}
+	}
+	if len(limiters) == 0 {
+		return limiters, nil, nil
+	}
+	limitersList, err := dataprovider.NewIPList(dataprovider.IPListTypeRateLimiterSafeList)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to initialize ratelimiters list: %w", err)
+	}
+	return limiters, limitersList, nil
+}
+
+func newDefender(config DefenderConfig) (Defender, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if !util.Contains(supportedDefenderDrivers, config.Driver) {
+		return nil, fmt.Errorf("unsupported defender driver %q", config.Driver)
+	}
+	var defender Defender
+	var err error
+	switch config.Driver {
+	case DefenderDriverProvider:
+		defender, err = newDBDefender(&config)
+	default:
+		defender, err = newInMemoryDefender(&config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("defender initialization error: %v", err)
+	}
+	logger.Info(logSender, "", "defender initialized with config %+v", config)
+	return defender, nil
+}
+
+// ReloadConfig applies the rate limiters and defender settings from the given
+// configuration without affecting the active connections and transfers.
+// The defender is replaced only if its configuration changed, the hosts
+// scores and bans are preserved if the in-memory driver is used before and
+// after the change
+func ReloadConfig(c Configuration) error {
+	limiters, limitersList, err := newRateLimiters(c.RateLimitersConfig)
+	if err != nil {
+		return err
+	}
+	reloadMutex.RLock()
+	isDefenderChanged := !reflect.DeepEqual(Config.DefenderConfig, c.DefenderConfig)
+	currentDefender := Config.defender
+	reloadMutex.RUnlock()
+
+	var defender Defender
+	if isDefenderChanged {
+		defender, err = newDefender(c.DefenderConfig)
+		if err != nil {
+			return err
+		}
+		// keep the hosts scores and bans if the in-memory defender is still in use
+		if newMemDefender, ok := defender.(*memoryDefender); ok {
+			if memDefender, ok := currentDefender.(*memoryDefender); ok {
+				newMemDefender.copyState(memDefender)
+			}
+		}
+	}
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	rateLimiters = limiters
+	Config.rateLimitersList = limitersList
+	Config.RateLimitersConfig = c.RateLimitersConfig
+	if isDefenderChanged {
+		Config.defender = defender
+		Config.DefenderConfig = c.DefenderConfig
+	}
+	logger.Info(logSender, "", "configuration reloaded, rate limiters: %d, defender changed: %t",
+		len(c.RateLimitersConfig), isDefenderChanged)
 	return nil
 }
 
+func getDefender() Defender {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+
+	return Config.defender
+}
+
+// IsDefenderEnabled returns true if the defender is enabled
+func IsDefenderEnabled() bool {
+	return getDefender() != nil
+}
+
 // CheckClosing returns an error if the service is closing
 func CheckClosing() error {
 	if isShuttingDown.Load() {
@@ -249,6 +345,12 @@ func CheckClosing() error {
 	return nil
 }
 
+// IsDraining returns true if the listeners were handed over to a new process
+// and we are only waiting for the ongoing transfers to complete
+func IsDraining() bool {
+	return isDraining.Load()
+}
+
 // WaitForTransfers waits, for the specified grace time, for currently ongoing
 // client-initiated transfer sessions to completes.
 // A zero graceTime means no wait
@@ -259,6 +361,13 @@ func WaitForTransfers(graceTime int) {
 	if isShuttingDown.Swap(true) {
 		return
 	}
+	if util.IsReusePortEnabled() || util.HasActivatedListeners() {
+		// stop accepting new connections, the new process bound to the
+		// same addresses will handle them
+		isDraining.Store(true)
+		closed := util.CloseListeners()
+		logger.Info(logSender, "", "listeners closed for handover: %d, waiting for ongoing transfers", closed)
+	}
 
 	if activeHooks.Load() == 0 && getActiveConnections() == 0 {
 		return
@@ -307,13 +416,21 @@ func getActiveConnections() int {
 // It returns an error if the time to wait exceeds the max
 // allowed delay
 func LimitRate(protocol, ip string) (time.Duration, error) {
-	if Config.rateLimitersList != nil {
-		isListed, _, err := Config.rateLimitersList.IsListed(ip, protocol)
+	reloadMutex.RLock()
+	limitersList := Config.rateLimitersList
+	limiters := rateLimiters[protocol]
+	reloadMutex.RUnlock()
+
+	if limitersList != nil {
+		isListed, _, err := limitersList.IsListed(ip, protocol)
 		if err == nil && isListed {
 			return 0, nil
 		}
 	}
-	for _, limiter := range rateLimiters[protocol] {
+	for _, limiter := range limiters {
+		if limiter.isPerIdentity() {
+			continue
+		}
 		if delay, err := limiter.Wait(ip, protocol); err != nil {
 			logger.Debug(logSender, "", "protocol %s ip %s: %v", protocol, ip, err)
 			return delay, err
@@ -322,6 +439,33 @@ func LimitRate(protocol, ip string) (time.Duration, error) {
 	return 0, nil
 }
 
+// LimitRateByIdentity is like LimitRate but it uses the per-identity rate
+// limiters. The identity is an opaque key, for example an API key ID or an
+// admin username. Rate limiters safe listed IP addresses are not limited
+func LimitRateByIdentity(protocol, ip, identity string) (time.Duration, error) {
+	reloadMutex.RLock()
+	limitersList := Config.rateLimitersList
+	limiters := rateLimiters[protocol]
+	reloadMutex.RUnlock()
+
+	if limitersList != nil {
+		isListed, _, err := limitersList.IsListed(ip, protocol)
+		if err == nil && isListed {
+			return 0, nil
+		}
+	}
+	for _, limiter := range limiters {
+		if !limiter.isPerIdentity() {
+			continue
+		}
+		if delay, err := limiter.Wait(identity, protocol); err != nil {
+			logger.Debug(logSender, "", "protocol %s ip %s identity %q: %v", protocol, ip, identity, err)
+			return delay, err
+		}
+	}
+	return 0, nil
+}
+
 // Reload reloads the whitelist, the IP filter plugin and the defender's block and safe lists
 func Reload() error {
 	plugin.Handler.ReloadFilter()
@@ -330,8 +474,8 @@ func Reload() error {
 
 // DelayLogin applies the configured login delay
 func DelayLogin(err error) {
-	if Config.defender != nil {
-		Config.defender.DelayLogin(err)
+	if defender := getDefender(); defender != nil {
+		defender.DelayLogin(err)
 	}
 }
 
@@ -340,67 +484,76 @@ func IsBanned(ip, protocol string) bool {
 	if plugin.Handler.IsIPBanned(ip, protocol) {
 		return true
 	}
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return false
 	}
 
-	return Config.defender.IsBanned(ip, protocol)
+	return defender.IsBanned(ip, protocol)
 }
 
 // GetDefenderBanTime returns the ban time for the given IP
 // or nil if the IP is not banned or the defender is disabled
 func GetDefenderBanTime(ip string) (*time.Time, error) {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return nil, nil
 	}
 
-	return Config.defender.GetBanTime(ip)
+	return defender.GetBanTime(ip)
 }
 
 // GetDefenderHosts returns hosts that are banned or for which some violations have been detected
 func GetDefenderHosts() ([]dataprovider.DefenderEntry, error) {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return nil, nil
 	}
 
-	return Config.defender.GetHosts()
+	return defender.GetHosts()
 }
 
 // GetDefenderHost returns a defender host by ip, if any
 func GetDefenderHost(ip string) (dataprovider.DefenderEntry, error) {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return dataprovider.DefenderEntry{}, errors.New("defender is disabled")
 	}
 
-	return Config.defender.GetHost(ip)
+	return defender.GetHost(ip)
 }
 
 // DeleteDefenderHost removes the specified IP address from the defender lists
 func DeleteDefenderHost(ip string) bool {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return false
 	}
 
-	return Config.defender.DeleteHost(ip)
+	return defender.DeleteHost(ip)
 }
 
 // GetDefenderScore returns the score for the given IP
 func GetDefenderScore(ip string) (int, error) {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return 0, nil
 	}
 
-	return Config.defender.GetScore(ip)
+	return defender.GetScore(ip)
 }
 
 // AddDefenderEvent adds the specified defender event for the given IP.
 // Returns true if the IP is in the defender's safe list.
 func AddDefenderEvent(ip, protocol string, event HostEvent) bool {
-	if Config.defender == nil {
+	defender := getDefender()
+	if defender == nil {
 		return false
 	}
 
-	return Config.defender.AddEvent(ip, protocol, event)
+	isBanned := defender.AddEvent(ip, protocol, event)
+	publishDefenderLiveEvent(ip, protocol, event, isBanned)
+	return isBanned
 }
 
 func startPeriodicChecks(duration time.Duration, isShared int) {
@@ -421,6 +574,34 @@ func startPeriodicChecks(duration time.Duration, isShared int) {
 		util.PanicOnError(err)
 		logger.Info(logSender, "", "scheduled idle connections check, schedule %q", spec)
 	}
+	spec = fmt.Sprintf("@every %s", trashPurgeInterval)
+	_, err = eventScheduler.AddFunc(spec, purgeUsersTrashAndVersions)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled trash and file versions purge, schedule %q", spec)
+	spec = fmt.Sprintf("@every %s", quotaScanCleanupInterval)
+	_, err = eventScheduler.AddFunc(spec, vfs.CleanupQuotaScans)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled incremental quota scans cleanup, schedule %q", spec)
+	spec = fmt.Sprintf("@every %s", dirQuotaCleanupInterval)
+	_, err = eventScheduler.AddFunc(spec, cleanupDirQuotaUsages)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled directory quotas usage cleanup, schedule %q", spec)
+	if Config.SearchIndex.Enabled {
+		spec = fmt.Sprintf("@every %s", searchIndexCleanupInterval)
+		_, err = eventScheduler.AddFunc(spec, searchIndex.cleanup)
+		util.PanicOnError(err)
+		logger.Info(logSender, "", "scheduled search index cleanup, schedule %q", spec)
+	}
+	clusterSessions.setEnabled(isShared == 1 && Config.ClusterSessionsCheckInterval > 0)
+	if isShared == 1 && Config.ClusterSessionsCheckInterval > 0 {
+		interval := time.Duration(Config.ClusterSessionsCheckInterval) * time.Second
+		spec = fmt.Sprintf("@every %s", interval)
+		_, err = eventScheduler.AddFunc(spec, func() {
+			clusterSessions.update(interval)
+		})
+		util.PanicOnError(err)
+		logger.Info(logSender, "", "scheduled cluster sessions check, schedule %q", spec)
+	}
 }
 
 // ActiveTransfer defines the interface for the current active transfers
@@ -490,6 +671,12 @@ type MetadataConfig struct {
 	// If not zero the metadata will be read before downloads and will be
 	// available in notifications
 	Read int `json:"read" mapstructure:"read"`
+	// Time to live, in seconds, for the cached stat results of cloud storage backends.
+	// The cache is populated from stat calls and directory listings and it is invalidated
+	// on writes. 0 means disabled
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+	// Maximum number of cached entries. 0 means unlimited
+	CacheMaxEntries int `json:"cache_max_entries" mapstructure:"cache_max_entries"`
 }
 
 // Configuration defines configuration parameters common to all supported protocols
@@ -529,6 +716,10 @@ type Configuration struct {
 	// Set to a value greater than 0 to allow resuming uploads of files smaller than or equal to the
 	// defined size.
 	ResumeMaxSize int64 `json:"resume_max_size" mapstructure:"resume_max_size"`
+	// QuotaScanWorkers defines the number of directories listed in parallel during quota scans.
+	// Increasing this value speeds up the scans for cloud storage providers where listing a
+	// directory requires a network round trip
+	QuotaScanWorkers int `json:"quota_scan_workers" mapstructure:"quota_scan_workers"`
 	// TempPath defines the path for temporary files such as those used for atomic uploads or file pipes.
 	// If you set this option you must make sure that the defined path exists, is accessible for writing
 	// by the user running SFTPGo, and is on the same filesystem as the users home directories otherwise
@@ -573,6 +764,16 @@ type Configuration struct {
 	MaxTotalConnections int `json:"max_total_connections" mapstructure:"max_total_connections"`
 	// Maximum number of concurrent client connections from the same host (IP). 0 means unlimited
 	MaxPerHostConnections int `json:"max_per_host_connections" mapstructure:"max_per_host_connections"`
+	// Interval, in seconds, to load the sessions active on the other cluster nodes. If set, the maximum
+	// sessions per user and the maximum total connections are enforced cluster-wide and the hosts banned
+	// by the "memory" defender are replicated to the other nodes.
+	// It requires a shared data provider and the node configuration. 0 means disabled
+	ClusterSessionsCheckInterval int `json:"cluster_sessions_check_interval" mapstructure:"cluster_sessions_check_interval"`
+	// If enabled, SO_REUSEPORT is set on the TCP listeners so a new SFTPGo process can bind the same
+	// addresses. On graceful shutdown the listeners are closed, new connections are handled by the new
+	// process and the ongoing transfers are completed within the grace time. Listeners passed using
+	// systemd socket activation are handed over the same way. Not supported on Windows
+	ReusePort bool `json:"reuse_port" mapstructure:"reuse_port"`
 	// Defines the status of the global allow list. 0 means disabled, 1 enabled.
 	// If enabled, only the listed IPs/networks can access the configured services, all other
 	// client connections will be dropped before they even try to authenticate.
@@ -594,7 +795,11 @@ type Configuration struct {
 	// server's local time, otherwise UTC will be used.
 	TZ string `json:"tz" mapstructure:"tz"`
 	// Metadata configuration
-	Metadata              MetadataConfig `json:"metadata" mapstructure:"metadata"`
+	Metadata MetadataConfig `json:"metadata" mapstructure:"metadata"`
+	// Response compression for WebDAV and HTTP downloads
+	HTTPCompression HTTPCompressionConfig `json:"http_compression" mapstructure:"http_compression"`
+	// Embedded index to search files and directories by name and attributes
+	SearchIndex           SearchIndexConfig `json:"search_index" mapstructure:"search_index"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
@@ -645,6 +850,9 @@ func (c *Configuration) GetProxyListener(listener net.Listener) (*proxyproto.Lis
 
 // GetRateLimitersStatus returns the rate limiters status
 func (c *Configuration) GetRateLimitersStatus() (bool, []string) {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+
 	enabled := false
 	var protocols []string
 	for _, rlCfg := range c.RateLimitersConfig {
@@ -842,12 +1050,39 @@ func getProxyPolicy(allowed, skipped []func(net.IP) bool, def proxyproto.Policy)
 	}
 }
 
+// SSH channel types
+const (
+	SSHChannelTypeSession    = "session"
+	SSHChannelTypeSFTP       = "sftp"
+	SSHChannelTypeSCP        = "scp"
+	SSHChannelTypeCommand    = "ssh_command"
+	SSHChannelTypeForwarding = "direct-tcpip"
+)
+
+// SSHChannelStatus describes a channel opened within an SSH connection
+type SSHChannelStatus struct {
+	// Channel identifier, unique within the SSH connection
+	ID int64 `json:"id"`
+	// Channel type: session, sftp, scp, ssh_command, direct-tcpip.
+	// Session channels become sftp, scp or ssh_command based on the
+	// request received from the client
+	Type string `json:"type"`
+	// SSH command or forwarding destination
+	Details string `json:"details,omitempty"`
+	// Open time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+}
+
 // SSHConnection defines an ssh connection.
 // Each SSH connection can open several channels for SFTP or SSH commands
 type SSHConnection struct {
 	id           string
 	conn         net.Conn
 	lastActivity atomic.Int64
+	mu           sync.RWMutex
+	maxChannels  int
+	lastChannel  int64
+	channels     []SSHChannelStatus
 }
 
 // NewSSHConnection returns a new SSHConnection
@@ -880,6 +1115,70 @@ func (c *SSHConnection) Close() error {
 	return c.conn.Close()
 }
 
+// SetMaxChannels sets the maximum number of concurrent channels, 0 means no limit
+func (c *SSHConnection) SetMaxChannels(value int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxChannels = value
+}
+
+// AddChannel registers a new channel and returns its identifier.
+// An error is returned if the maximum number of channels is reached
+func (c *SSHConnection) AddChannel(channelType, details string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxChannels > 0 && len(c.channels) >= c.maxChannels {
+		return 0, fmt.Errorf("too many open channels, max allowed: %d", c.maxChannels)
+	}
+	c.lastChannel++
+	c.channels = append(c.channels, SSHChannelStatus{
+		ID:        c.lastChannel,
+		Type:      channelType,
+		Details:   details,
+		StartTime: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	return c.lastChannel, nil
+}
+
+// UpdateChannel updates the type and details for the specified channel
+func (c *SSHConnection) UpdateChannel(id int64, channelType, details string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for idx := range c.channels {
+		if c.channels[idx].ID == id {
+			c.channels[idx].Type = channelType
+			c.channels[idx].Details = details
+			return
+		}
+	}
+}
+
+// RemoveChannel removes the channel with the specified identifier
+func (c *SSHConnection) RemoveChannel(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for idx := range c.channels {
+		if c.channels[idx].ID == id {
+			c.channels = append(c.channels[:idx], c.channels[idx+1:]...)
+			return
+		}
+	}
+}
+
+// GetChannels returns the open channels
+func (c *SSHConnection) GetChannels() []SSHChannelStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	channels := make([]SSHChannelStatus, len(c.channels))
+	copy(channels, c.channels)
+	return channels
+}
+
 // ActiveConnections holds the currect active connections with the associated transfers
 type ActiveConnections struct {
 	// clients contains both authenticated and estabilished connections and the ones waiting
@@ -917,12 +1216,13 @@ func (conns *ActiveConnections) removeUserConnection(username string) {
 }
 
 // GetActiveSessions returns the number of active sessions for the given username.
-// We return the open sessions for any protocol
+// We return the open sessions for any protocol, including the ones active on the
+// other cluster nodes if the cluster sessions check is enabled
 func (conns *ActiveConnections) GetActiveSessions(username string) int {
 	conns.RLock()
 	defer conns.RUnlock()
 
-	return conns.perUserConns[username]
+	return conns.perUserConns[username] + clusterSessions.getUserSessions(username)
 }
 
 // Add adds a new connection to the active ones
@@ -932,7 +1232,7 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 
 	if username := c.GetUsername(); username != "" {
 		if maxSessions := c.GetMaxSessions(); maxSessions > 0 {
-			if val := conns.perUserConns[username]; val >= maxSessions {
+			if val := conns.perUserConns[username] + clusterSessions.getUserSessions(username); val >= maxSessions {
 				return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 			}
 		}
@@ -941,6 +1241,7 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 	conns.mapping[c.GetID()] = len(conns.connections)
 	conns.connections = append(conns.connections, c)
 	metric.UpdateActiveConnectionsSize(len(conns.connections))
+	publishConnectionLiveEvent(c, LiveEventActionConnect)
 	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, local address %q, remote address %q, num open connections: %d",
 		c.GetLocalAddress(), c.GetRemoteAddress(), len(conns.connections))
 	return nil
@@ -959,7 +1260,7 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 		conns.removeUserConnection(conn.GetUsername())
 		if username := c.GetUsername(); username != "" {
 			if maxSessions := c.GetMaxSessions(); maxSessions > 0 {
-				if val, ok := conns.perUserConns[username]; ok && val >= maxSessions {
+				if val := conns.perUserConns[username] + clusterSessions.getUserSessions(username); val >= maxSessions {
 					conns.addUserConnection(conn.GetUsername())
 					return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 				}
@@ -969,6 +1270,9 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 		err := conn.CloseFS()
 		conns.connections[idx] = c
 		logger.Debug(logSender, c.GetID(), "connection swapped, close fs error: %v", err)
+		if conn.GetUsername() == "" {
+			publishConnectionLiveEvent(c, LiveEventActionConnect)
+		}
 		conn = nil
 		return nil
 	}
@@ -994,6 +1298,7 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 		}
 		conns.removeUserConnection(conn.GetUsername())
 		metric.UpdateActiveConnectionsSize(lastIdx)
+		publishConnectionLiveEvent(conn, LiveEventActionDisconnect)
 		logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, local address %q, remote address %q close fs error: %v, num open connections: %d",
 			conn.GetLocalAddress(), conn.GetRemoteAddress(), err, lastIdx)
 		if conn.GetProtocol() == ProtocolFTP && conn.GetUsername() == "" && !util.Contains(ftpLoginCommands, conn.GetCommand()) {
@@ -1240,7 +1545,7 @@ func (conns *ActiveConnections) IsNewConnectionAllowed(ipAddr, protocol string)
 		conns.RLock()
 		defer conns.RUnlock()
 
-		if sess := len(conns.connections); sess >= Config.MaxTotalConnections {
+		if sess := len(conns.connections) + clusterSessions.getTotal(); sess >= Config.MaxTotalConnections {
 			logger.Info(logSender, "", "active client sessions %d/%d", sess, Config.MaxTotalConnections)
 			return ErrConnectionDenied
 		}
@@ -1270,6 +1575,7 @@ func (conns *ActiveConnections) GetStats(role string) []ConnectionStatus {
 				Command:        c.GetCommand(),
 				Transfers:      c.GetTransfers(),
 				Node:           node,
+				SSHChannels:    conns.getSSHChannels(c),
 			}
 			stats = append(stats, stat)
 		}
@@ -1277,6 +1583,20 @@ func (conns *ActiveConnections) GetStats(role string) []ConnectionStatus {
 	return stats
 }
 
+// getSSHChannels returns the channels for the SSH connection the specified
+// connection belongs to. The caller must hold the lock
+func (conns *ActiveConnections) getSSHChannels(c ActiveConnection) []SSHChannelStatus {
+	switch c.GetProtocol() {
+	case ProtocolSFTP, ProtocolSCP, ProtocolSSH:
+		for _, sshConn := range conns.sshConnections {
+			if strings.Contains(c.GetID(), fmt.Sprintf("_%s_", sshConn.GetID())) {
+				return sshConn.GetChannels()
+			}
+		}
+	}
+	return nil
+}
+
 // ConnectionStatus returns the status for an active connection
 type ConnectionStatus struct {
 	// Logged in username
@@ -1301,6 +1621,8 @@ type ConnectionStatus struct {
 	Command string `json:"command,omitempty"`
 	// Node identifier, omitted for single node installations
 	Node string `json:"node,omitempty"`
+	// Channels opened within the same SSH connection, for SFTP, SCP and SSH connections
+	SSHChannels []SSHChannelStatus `json:"ssh_channels,omitempty"`
 }
 
 // ActiveQuotaScan defines an active quota scan for a user
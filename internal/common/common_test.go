@@ -366,6 +366,55 @@ func TestSSHConnections(t *testing.T) {
 	assert.NoError(t, sshConn3.Close())
 }
 
+func TestSSHConnectionChannels(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	sshConn := NewSSHConnection("sshid", conn1)
+	sshConn.SetMaxChannels(2)
+	id1, err := sshConn.AddChannel(SSHChannelTypeSession, "")
+	assert.NoError(t, err)
+	id2, err := sshConn.AddChannel(SSHChannelTypeForwarding, "127.0.0.1:22")
+	assert.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+	_, err = sshConn.AddChannel(SSHChannelTypeSession, "")
+	assert.Error(t, err)
+	sshConn.UpdateChannel(id1, SSHChannelTypeSFTP, "")
+	channels := sshConn.GetChannels()
+	if assert.Len(t, channels, 2) {
+		assert.Equal(t, id1, channels[0].ID)
+		assert.Equal(t, SSHChannelTypeSFTP, channels[0].Type)
+		assert.Greater(t, channels[0].StartTime, int64(0))
+		assert.Equal(t, SSHChannelTypeForwarding, channels[1].Type)
+		assert.Equal(t, "127.0.0.1:22", channels[1].Details)
+	}
+	sshConn.RemoveChannel(id2)
+	assert.Len(t, sshConn.GetChannels(), 1)
+	id3, err := sshConn.AddChannel(SSHChannelTypeSession, "")
+	assert.NoError(t, err)
+	assert.Greater(t, id3, id2)
+	sshConn.UpdateChannel(id3, SSHChannelTypeSCP, "scp -t /")
+
+	Connections.AddSSHConnection(sshConn)
+	c := NewBaseConnection(fmt.Sprintf("%s_%d", sshConn.GetID(), id1), ProtocolSFTP, "", "", dataprovider.User{})
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	err = Connections.Add(fakeConn)
+	assert.NoError(t, err)
+	stats := Connections.GetStats("")
+	if assert.Len(t, stats, 1) {
+		assert.Len(t, stats[0].SSHChannels, 2)
+	}
+	Connections.Remove(fakeConn.GetID())
+	Connections.RemoveSSHConnection(sshConn.GetID())
+	sshConn.SetMaxChannels(0)
+	for i := 0; i < 10; i++ {
+		_, err = sshConn.AddChannel(SSHChannelTypeSession, "")
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, sshConn.Close())
+	assert.NoError(t, conn2.Close())
+}
+
 func TestDefenderIntegration(t *testing.T) {
 	// by default defender is nil
 	configCopy := Config
@@ -546,19 +595,21 @@ func TestRateLimitersIntegration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, Config.rateLimitersList)
 
-	assert.Len(t, rateLimiters, 4)
+	assert.Len(t, rateLimiters, 5)
 	assert.Len(t, rateLimiters[ProtocolSSH], 1)
 	assert.Len(t, rateLimiters[ProtocolFTP], 2)
 	assert.Len(t, rateLimiters[ProtocolWebDAV], 2)
 	assert.Len(t, rateLimiters[ProtocolHTTP], 1)
+	assert.Len(t, rateLimiters[ProtocolS3], 1)
 
 	enabled, protocols = Config.GetRateLimitersStatus()
 	assert.True(t, enabled)
-	assert.Len(t, protocols, 4)
+	assert.Len(t, protocols, 5)
 	assert.Contains(t, protocols, ProtocolFTP)
 	assert.Contains(t, protocols, ProtocolSSH)
 	assert.Contains(t, protocols, ProtocolHTTP)
 	assert.Contains(t, protocols, ProtocolWebDAV)
+	assert.Contains(t, protocols, ProtocolS3)
 
 	source1 := "127.1.1.1"
 	source2 := "127.1.1.2"
@@ -594,6 +645,82 @@ func TestRateLimitersIntegration(t *testing.T) {
 	Config = configCopy
 }
 
+func TestReloadConfig(t *testing.T) {
+	configCopy := Config
+	limitersCopy := rateLimiters
+
+	c := Config
+	c.RateLimitersConfig = []RateLimiterConfig{
+		{
+			Average:   100,
+			Period:    10,
+			Burst:     5,
+			Type:      int(rateLimiterTypeGlobal),
+			Protocols: []string{ProtocolSSH},
+		},
+	}
+	err := ReloadConfig(c)
+	assert.Error(t, err)
+	assert.Equal(t, configCopy.RateLimitersConfig, Config.RateLimitersConfig)
+	c.RateLimitersConfig[0].Period = 1000
+	c.DefenderConfig = DefenderConfig{
+		Enabled:          true,
+		Driver:           DefenderDriverMemory,
+		BanTime:          10,
+		BanTimeIncrement: 50,
+		Threshold:        10,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ScoreNoAuth:      2,
+		ObservationTime:  15,
+		EntriesSoftLimit: 100,
+		EntriesHardLimit: 150,
+	}
+	err = ReloadConfig(c)
+	assert.NoError(t, err)
+	assert.Len(t, rateLimiters, 1)
+	assert.Len(t, rateLimiters[ProtocolSSH], 1)
+	enabled, protocols := Config.GetRateLimitersStatus()
+	assert.True(t, enabled)
+	assert.Equal(t, []string{ProtocolSSH}, protocols)
+	assert.True(t, IsDefenderEnabled())
+	defender := getDefender()
+	// unchanged defender configuration, the defender must be preserved
+	c.RateLimitersConfig = nil
+	err = ReloadConfig(c)
+	assert.NoError(t, err)
+	assert.Len(t, rateLimiters, 0)
+	assert.Equal(t, defender, getDefender())
+	// the in-memory hosts scores and bans must survive a defender configuration change
+	bannedIP := "172.16.1.10"
+	scoredIP := "172.16.1.11"
+	for i := 0; i < 5; i++ {
+		defender.AddEvent(bannedIP, ProtocolSSH, HostEventUserNotFound)
+	}
+	assert.True(t, defender.IsBanned(bannedIP, ProtocolSSH))
+	defender.AddEvent(scoredIP, ProtocolSSH, HostEventLoginFailed)
+	c.DefenderConfig.Threshold = 20
+	err = ReloadConfig(c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, defender, getDefender())
+	assert.Equal(t, 20, Config.DefenderConfig.Threshold)
+	assert.True(t, getDefender().IsBanned(bannedIP, ProtocolSSH))
+	score, err := getDefender().GetScore(scoredIP)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, score)
+	c.DefenderConfig.Driver = "unsupported"
+	err = ReloadConfig(c)
+	assert.Error(t, err)
+	assert.Equal(t, DefenderDriverMemory, Config.DefenderConfig.Driver)
+	c.DefenderConfig.Enabled = false
+	err = ReloadConfig(c)
+	assert.NoError(t, err)
+	assert.False(t, IsDefenderEnabled())
+
+	Config = configCopy
+	rateLimiters = limitersCopy
+}
+
 func TestUserMaxSessions(t *testing.T) {
 	c := NewBaseConnection("id", ProtocolSFTP, "", "", dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -654,6 +781,102 @@ func TestMaxConnections(t *testing.T) {
 	Config.MaxTotalConnections = oldValue
 }
 
+func TestListenersHandover(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	l1, err := util.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	// without SO_REUSEPORT the address cannot be bound twice
+	_, err = util.Listen("tcp", l1.Addr().String())
+	assert.Error(t, err)
+	err = l1.Close()
+	assert.NoError(t, err)
+
+	util.SetReusePort(true)
+	defer util.SetReusePort(false)
+
+	l1, err = util.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l2, err := util.Listen("tcp", l1.Addr().String())
+	require.NoError(t, err)
+	// the new listener handles the connections after closing the old one
+	err = l1.Close()
+	assert.NoError(t, err)
+	err = l1.Close()
+	assert.NoError(t, err)
+	go func() {
+		conn, err := l2.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l2.Addr().String())
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+	err = l2.Close()
+	assert.NoError(t, err)
+	assert.False(t, IsDraining())
+}
+
+func TestClusterSessions(t *testing.T) {
+	oldValue := Config.MaxTotalConnections
+	perHost := Config.MaxPerHostConnections
+	Config.MaxPerHostConnections = 0
+	Config.MaxTotalConnections = 3
+
+	stats := []ConnectionStatus{
+		{
+			Username:     userTestUsername,
+			ConnectionID: "id1",
+		},
+		{
+			Username:     userTestUsername,
+			ConnectionID: "id2",
+		},
+	}
+	// remote sessions are ignored if the check is disabled
+	clusterSessions.setNodeSessions("node1", stats)
+	assert.Equal(t, 0, Connections.GetActiveSessions(userTestUsername))
+	assert.False(t, IsDefenderReplicated())
+	clusterSessions.setEnabled(true)
+	clusterSessions.setNodeSessions("node1", stats)
+	clusterSessions.setNodeSessions("node2", stats[:1])
+	assert.Equal(t, 3, Connections.GetActiveSessions(userTestUsername))
+	assert.Equal(t, 3, clusterSessions.getTotal())
+
+	ipAddr := "192.168.7.9"
+	assert.Error(t, Connections.IsNewConnectionAllowed(ipAddr, ProtocolSSH))
+	c := NewBaseConnection("id", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    userTestUsername,
+			MaxSessions: 3,
+		},
+	})
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	err := Connections.Add(fakeConn)
+	assert.Error(t, err)
+	// stale nodes are removed
+	clusterSessions.removeStaleNodes(time.Now().Add(1 * time.Second))
+	assert.Equal(t, 0, clusterSessions.getTotal())
+	assert.NoError(t, Connections.IsNewConnectionAllowed(ipAddr, ProtocolSSH))
+	err = Connections.Add(fakeConn)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, Connections.GetActiveSessions(userTestUsername))
+	Connections.Remove(fakeConn.GetID())
+	assert.Len(t, Connections.GetStats(""), 0)
+	// no cluster nodes are defined
+	clusterSessions.update(time.Second)
+	assert.Equal(t, 0, clusterSessions.getTotal())
+
+	clusterSessions.setEnabled(false)
+	Config.MaxTotalConnections = oldValue
+	Config.MaxPerHostConnections = perHost
+}
+
 func TestConnectionRoles(t *testing.T) {
 	username := "testUsername"
 	role1 := "testRole1"
@@ -32,6 +32,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -66,6 +67,9 @@ func NewBaseConnection(id, protocol, localAddr, remoteAddr string, user dataprov
 		connID = fmt.Sprintf("%s_%s", protocol, id)
 	}
 	user.UploadBandwidth, user.DownloadBandwidth = user.GetBandwidthForIP(util.GetIPFromRemoteAddress(remoteAddr), connID)
+	if up, down, ok := user.GetBandwidthForProtocol(protocol); ok {
+		user.UploadBandwidth, user.DownloadBandwidth = up, down
+	}
 	c := &BaseConnection{
 		ID:         connID,
 		User:       user,
@@ -85,6 +89,12 @@ func (c *BaseConnection) Log(level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, c.protocol, c.ID, format, v...)
 }
 
+// UpdateFsOperationMetrics records the latency of the specified operation, started
+// at startTime, for the given filesystem
+func (c *BaseConnection) UpdateFsOperationMetrics(fs vfs.Fs, operation string, startTime time.Time) {
+	metric.FsOperationCompleted(c.protocol, vfs.GetBackendName(fs), operation, time.Since(startTime))
+}
+
 // GetTransferID returns an unique transfer ID for this connection
 func (c *BaseConnection) GetTransferID() int64 {
 	return c.transferID.Add(1)
@@ -134,6 +144,10 @@ func (c *BaseConnection) SetProtocol(protocol string) {
 	if util.Contains(supportedProtocols, c.protocol) {
 		c.ID = fmt.Sprintf("%v_%v", c.protocol, c.ID)
 	}
+	// SSH commands and SCP are served by connections created before knowing the protocol
+	if up, down, ok := c.User.GetBandwidthForProtocol(protocol); ok {
+		c.User.UploadBandwidth, c.User.DownloadBandwidth = up, down
+	}
 }
 
 // GetConnectionTime returns the initial connection time
@@ -314,7 +328,9 @@ func (c *BaseConnection) ListDir(virtualPath string) (*DirListerAt, error) {
 	if err != nil {
 		return nil, err
 	}
+	startTime := time.Now()
 	lister, err := fs.ReadDir(fsPath)
+	c.UpdateFsOperationMetrics(fs, FsOperationList, startTime)
 	if err != nil {
 		c.Log(logger.LevelDebug, "error listing directory: %+v", err)
 		return nil, c.GetFsError(fs, err)
@@ -431,8 +447,27 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 		return c.GetPermissionDeniedError()
 	}
 	updateQuota := true
+	useTrash := c.isTrashEnabledForPath(virtualPath)
+	if useTrash && !c.User.Filters.Trash.ExcludeFromQuota {
+		// the file is still included in the user quota
+		updateQuota = false
+	}
+	versioningRule, useVersioning := c.getVersioningRule(virtualPath)
+	useVersioning = useVersioning && !useTrash && info.Mode().IsRegular()
+	if useVersioning {
+		// the file is kept as a version and it is still included in the user quota
+		updateQuota = false
+	}
 	startTime := time.Now()
-	if err := fs.Remove(fsPath, false); err != nil {
+	switch {
+	case useTrash:
+		err = c.moveToTrash(fs, fsPath, virtualPath)
+	case useVersioning:
+		err = c.moveToVersions(fs, fsPath, virtualPath)
+	default:
+		err = fs.Remove(fsPath, false)
+	}
+	if err != nil {
 		if status > 0 && fs.IsNotExist(err) {
 			// file removed in the pre-action, if the file was deleted from the EventManager the quota is already updated
 			c.Log(logger.LevelDebug, "file deleted from the hook, status: %d", status)
@@ -446,6 +481,13 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr, elapsed)
+	if useVersioning {
+		c.pruneFileVersions(fs, virtualPath, versioningRule)
+	}
+	if info.Mode()&os.ModeSymlink == 0 && (err == nil || status == 1) {
+		// the file is no longer inside its directory even if moved to the trash or versions
+		c.UpdateDirQuotaUsage(virtualPath, -1, -size)
+	}
 	if updateQuota && info.Mode()&os.ModeSymlink == 0 {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
@@ -664,11 +706,30 @@ func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, s
 	defer wCancelFn()
 
 	startTime := time.Now()
-	_, err = io.Copy(writer, reader)
+	err = c.copyFileData(writer, reader)
 	return closeWriterAndUpdateQuota(writer, c, virtualSourcePath, virtualTargetPath, numFiles, truncatedSize,
 		err, operationCopy, startTime)
 }
 
+// copyFileData copies the data from the reader to the writer. Local files are
+// cloned if the filesystem supports reflinks, otherwise the data are copied,
+// io.Copy uses copy_file_range between local files, if supported
+func (c *BaseConnection) copyFileData(w io.Writer, r io.Reader) error {
+	if dst, ok := w.(*os.File); ok {
+		if src, ok := r.(*os.File); ok {
+			size, err := vfs.CloneFile(dst, src)
+			if err == nil {
+				c.Log(logger.LevelDebug, "file %q cloned to %q, size: %d", src.Name(), dst.Name(), size)
+				return nil
+			}
+			c.Log(logger.LevelDebug, "unable to clone file %q to %q, the data will be copied: %v",
+				src.Name(), dst.Name(), err)
+		}
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
 func (c *BaseConnection) doRecursiveCopy(virtualSourcePath, virtualTargetPath string, srcInfo os.FileInfo,
 	createTargetDir bool, recursion int,
 ) error {
@@ -854,6 +915,7 @@ func (c *BaseConnection) renameInternal(virtualSourcePath, virtualTargetPath str
 	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize, files, size) //nolint:errcheck
+	c.updateDirQuotasAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize, files, size)
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 		"", "", "", -1, c.localAddr, c.remoteAddr, elapsed)
 	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, //nolint:errcheck
@@ -1101,6 +1163,94 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 	return nil
 }
 
+// GetXattr returns the value of the specified extended attribute
+func (c *BaseConnection) GetXattr(virtualPath, attr string) ([]byte, error) {
+	fs, fsPath, err := c.getXattrFsAndPath(virtualPath, false)
+	if err != nil {
+		return nil, err
+	}
+	value, err := fs.GetXattr(fsPath, attr)
+	if err != nil {
+		return nil, c.getXattrError(fs, fsPath, attr, err)
+	}
+	return value, nil
+}
+
+// ListXattr returns the names of the extended attributes for the specified path
+func (c *BaseConnection) ListXattr(virtualPath string) ([]string, error) {
+	fs, fsPath, err := c.getXattrFsAndPath(virtualPath, false)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fs.ListXattr(fsPath)
+	if err != nil {
+		return nil, c.getXattrError(fs, fsPath, "", err)
+	}
+	return names, nil
+}
+
+// SetXattr sets the value of the specified extended attribute
+func (c *BaseConnection) SetXattr(virtualPath, attr string, value []byte) error {
+	fs, fsPath, err := c.getXattrFsAndPath(virtualPath, true)
+	if err != nil {
+		return err
+	}
+	if err := fs.SetXattr(fsPath, attr, value); err != nil {
+		return c.getXattrError(fs, fsPath, attr, err)
+	}
+	c.Log(logger.LevelDebug, "extended attribute %q set for path %q, value length: %d", attr, fsPath, len(value))
+	return nil
+}
+
+// RemoveXattr removes the specified extended attribute
+func (c *BaseConnection) RemoveXattr(virtualPath, attr string) error {
+	fs, fsPath, err := c.getXattrFsAndPath(virtualPath, true)
+	if err != nil {
+		return err
+	}
+	if err := fs.RemoveXattr(fsPath, attr); err != nil {
+		return c.getXattrError(fs, fsPath, attr, err)
+	}
+	c.Log(logger.LevelDebug, "extended attribute %q removed for path %q", attr, fsPath)
+	return nil
+}
+
+// getXattrFsAndPath checks the permissions for the extended attributes.
+// Reading requires the permission to list the parent directory, modifying
+// requires the same permission as chmod
+func (c *BaseConnection) getXattrFsAndPath(virtualPath string, modify bool) (vfs.FsXattrer, string, error) {
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		return nil, "", c.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if modify {
+		if !c.User.HasPerm(dataprovider.PermChmod, c.getPathForSetStatPerms(fs, fsPath, virtualPath)) {
+			return nil, "", c.GetPermissionDeniedError()
+		}
+	} else if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+		return nil, "", c.GetPermissionDeniedError()
+	}
+	xattrer, ok := fs.(vfs.FsXattrer)
+	if !ok {
+		return nil, "", c.GetOpUnsupportedError()
+	}
+	return xattrer, c.getRealFsPath(fsPath), nil
+}
+
+func (c *BaseConnection) getXattrError(fs vfs.Fs, fsPath, attr string, err error) error {
+	c.Log(logger.LevelDebug, "extended attribute %q operation failed for path %q: %v", attr, fsPath, err)
+	if errors.Is(err, vfs.ErrXattrNotFound) {
+		if c.protocol == ProtocolSFTP {
+			return fmt.Errorf("%w: %w", sftp.ErrSSHFxFailure, err)
+		}
+		return err
+	}
+	return c.GetFsError(fs, err)
+}
+
 func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, size int64) error {
 	// check first if we have an open transfer for the given path and try to truncate the file already opened
 	// if we found no transfer we truncate by path.
@@ -1117,8 +1267,12 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 		initialSize = info.Size()
 		err = fs.Truncate(fsPath, size)
 	}
+	if err == nil {
+		c.addQuotaScanChange(path.Dir(virtualPath), false)
+	}
 	if err == nil && vfs.HasTruncateSupport(fs) {
 		sizeDiff := initialSize - size
+		c.UpdateDirQuotaUsage(virtualPath, 0, -sizeDiff)
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
 			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -sizeDiff, false) //nolint:errcheck
@@ -1267,6 +1421,9 @@ func (c *BaseConnection) hasSpaceForRename(fs vfs.Fs, virtualSourcePath, virtual
 	if dataprovider.GetQuotaTracking() == 0 {
 		return true
 	}
+	if !c.hasSpaceForDirQuotaRename(fs, virtualSourcePath, virtualTargetPath, initialSize, fsSourcePath) {
+		return false
+	}
 	sourceFolder, errSrc := c.User.GetVirtualFolderForPath(path.Dir(virtualSourcePath))
 	dstFolder, errDst := c.User.GetVirtualFolderForPath(path.Dir(virtualTargetPath))
 	if errSrc != nil && errDst != nil {
@@ -1422,9 +1579,23 @@ func (c *BaseConnection) checkUserQuota() (dataprovider.TransferQuota, int, int6
 	return result, usedFiles, usedSize
 }
 
-// HasSpace checks user's quota usage
+// HasSpace checks user's quota usage. The directory quota applying to the
+// request path, if any, is checked too and the most restrictive limits are returned
 func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string) (vfs.QuotaCheckResult,
 	dataprovider.TransferQuota,
+) {
+	result, transferQuota := c.checkQuota(checkFiles, getUsage, requestPath)
+	if !result.HasSpace || dataprovider.GetQuotaTracking() == 0 {
+		return result, transferQuota
+	}
+	if dirResult, ok := c.getDirQuotaResult(checkFiles, getUsage, requestPath); ok {
+		result = mergeQuotaCheckResults(result, dirResult)
+	}
+	return result, transferQuota
+}
+
+func (c *BaseConnection) checkQuota(checkFiles, getUsage bool, requestPath string) (vfs.QuotaCheckResult,
+	dataprovider.TransferQuota,
 ) {
 	result := vfs.QuotaCheckResult{
 		HasSpace:     true,
@@ -1600,20 +1771,10 @@ func (c *BaseConnection) updateQuotaAfterRename(fs vfs.Fs, virtualSourcePath, vi
 
 	if filesSize == -1 {
 		// fs.Rename didn't return the affected files/sizes, we need to calculate them
-		numFiles = 1
-		if fi, err := fs.Stat(targetPath); err == nil {
-			if fi.Mode().IsDir() {
-				numFiles, filesSize, err = fs.GetDirSize(targetPath)
-				if err != nil {
-					c.Log(logger.LevelError, "failed to update quota after rename, error scanning moved folder %q: %+v",
-						targetPath, err)
-					return err
-				}
-			} else {
-				filesSize = fi.Size()
-			}
-		} else {
-			c.Log(logger.LevelError, "failed to update quota after renaming, file %q stat error: %+v", targetPath, err)
+		var err error
+		numFiles, filesSize, err = c.getRenamedFilesSize(fs, targetPath)
+		if err != nil {
+			c.Log(logger.LevelError, "failed to update quota after renaming %q: %+v", targetPath, err)
 			return err
 		}
 		c.Log(logger.LevelDebug, "calculated renamed files: %d, size: %d bytes", numFiles, filesSize)
@@ -1637,7 +1798,7 @@ func (c *BaseConnection) IsNotExistError(err error) bool {
 	switch c.protocol {
 	case ProtocolSFTP:
 		return errors.Is(err, sftp.ErrSSHFxNoSuchFile)
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolS3, ProtocolOIDC, ProtocolSAML, ProtocolHTTPShare, ProtocolDataRetention:
 		return errors.Is(err, os.ErrNotExist)
 	default:
 		return errors.Is(err, ErrNotExist)
@@ -1664,7 +1825,7 @@ func (c *BaseConnection) GetNotExistError() error {
 	switch c.protocol {
 	case ProtocolSFTP:
 		return sftp.ErrSSHFxNoSuchFile
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolS3, ProtocolOIDC, ProtocolSAML, ProtocolHTTPShare, ProtocolDataRetention:
 		return os.ErrNotExist
 	default:
 		return ErrNotExist
@@ -1732,7 +1893,8 @@ func (c *BaseConnection) IsQuotaExceededError(err error) bool {
 func isSFTPGoError(err error) bool {
 	return errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrNotExist) || errors.Is(err, ErrOpUnsupported) ||
 		errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrReadQuotaExceeded) ||
-		errors.Is(err, vfs.ErrStorageSizeUnavailable) || errors.Is(err, ErrShuttingDown)
+		errors.Is(err, vfs.ErrStorageSizeUnavailable) || errors.Is(err, ErrShuttingDown) ||
+		errors.Is(err, vfs.ErrObjectArchived)
 }
 
 // GetGenericError returns an appropriate generic error for the connection protocol
@@ -1802,6 +1964,10 @@ func (c *BaseConnection) GetFsAndResolvedPath(virtualPath string) (vfs.Fs, strin
 	if isShuttingDown.Load() {
 		return nil, "", c.GetFsError(fs, ErrShuttingDown)
 	}
+	if c.User.IsTrashPath(virtualPath) || c.User.IsVersionsPath(virtualPath) {
+		// the trash and the file versions can only be managed using the WebClient or the REST API
+		return nil, "", c.GetNotExistError()
+	}
 
 	fsPath, err := fs.ResolvePath(virtualPath)
 	if err != nil {
@@ -1895,7 +2061,7 @@ func getPermissionDeniedError(protocol string) error {
 	switch protocol {
 	case ProtocolSFTP:
 		return sftp.ErrSSHFxPermissionDenied
-	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolOIDC, ProtocolHTTPShare, ProtocolDataRetention:
+	case ProtocolWebDAV, ProtocolFTP, ProtocolHTTP, ProtocolS3, ProtocolOIDC, ProtocolSAML, ProtocolHTTPShare, ProtocolDataRetention:
 		return os.ErrPermission
 	default:
 		return ErrPermissionDenied
@@ -15,6 +15,7 @@
 package common
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -425,6 +426,13 @@ func TestErrorsMapping(t *testing.T) {
 		} else {
 			assert.EqualError(t, err, vfs.ErrStorageSizeUnavailable.Error())
 		}
+		err = conn.GetFsError(fs, vfs.ErrObjectArchived)
+		if protocol == ProtocolSFTP {
+			assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+			assert.Contains(t, err.Error(), vfs.ErrObjectArchived.Error())
+		} else {
+			assert.ErrorIs(t, err, vfs.ErrObjectArchived)
+		}
 		err = conn.GetQuotaExceededError()
 		assert.True(t, conn.IsQuotaExceededError(err))
 		err = conn.GetReadQuotaExceededError()
@@ -654,6 +662,60 @@ func TestFsFileCopier(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestCopyFileData(t *testing.T) {
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{})
+	content := []byte("copy file data")
+	srcPath := filepath.Join(os.TempDir(), "copy_src")
+	dstPath := filepath.Join(os.TempDir(), "copy_dst")
+	err := os.WriteFile(srcPath, content, 0666)
+	assert.NoError(t, err)
+	src, err := os.Open(srcPath)
+	assert.NoError(t, err)
+	dst, err := os.Create(dstPath)
+	assert.NoError(t, err)
+	// the data are cloned or copied based on the filesystem support
+	err = conn.copyFileData(dst, src)
+	assert.NoError(t, err)
+	err = dst.Close()
+	assert.NoError(t, err)
+	err = src.Close()
+	assert.NoError(t, err)
+	data, err := os.ReadFile(dstPath)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	// cloning a closed file must fail
+	_, err = vfs.CloneFile(dst, src)
+	assert.Error(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = conn.copyFileData(buf, bytes.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf.Bytes())
+
+	err = os.Remove(srcPath)
+	assert.NoError(t, err)
+	err = os.Remove(dstPath)
+	assert.NoError(t, err)
+}
+
+func TestFsBackendName(t *testing.T) {
+	fs := vfs.NewOsFs("", os.TempDir(), "", nil)
+	assert.Equal(t, "local", vfs.GetBackendName(fs))
+	fs, err := vfs.NewCryptFs("", os.TempDir(), "", vfs.CryptFsConfig{
+		Passphrase: kms.NewPlainSecret("secret"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "crypt", vfs.GetBackendName(fs))
+
+	conn := NewBaseConnection(xid.New().String(), ProtocolSFTP, "", "", dataprovider.User{})
+	conn.UpdateFsOperationMetrics(fs, FsOperationList, time.Now())
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "/file", TransferDownload, 0, 0, 0, 0, false, fs,
+		dataprovider.TransferQuota{})
+	transfer.UpdateIOMetrics(time.Now())
+	err = transfer.Close()
+	assert.NoError(t, err)
+}
+
 func TestFilePatterns(t *testing.T) {
 	filters := dataprovider.UserFilters{
 		BaseUserFilters: sdk.BaseUserFilters{
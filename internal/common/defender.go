@@ -20,6 +20,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 )
 
 // HostEvent is the enumerable for the supported host events
@@ -151,6 +152,7 @@ func (d *baseDefender) logEvent(ip, protocol string, event HostEvent, totalScore
 	if eventScore == 0 {
 		return
 	}
+	metric.AddDefenderEvent(protocol, string(event))
 
 	logger.GetLogger().Debug().
 		Timestamp().
@@ -165,6 +167,7 @@ func (d *baseDefender) logEvent(ip, protocol string, event HostEvent, totalScore
 
 // logBan logs a host's ban due to a too high host score
 func (d *baseDefender) logBan(ip, protocol string) {
+	metric.AddDefenderBan(protocol)
 	logger.GetLogger().Info().
 		Timestamp().
 		Str("sender", "defender").
@@ -347,6 +347,53 @@ func TestExpiredHostBans(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestDefenderAddRemoteBans(t *testing.T) {
+	d := memoryDefender{
+		baseDefender: baseDefender{
+			config: &DefenderConfig{
+				ObservationTime:  1,
+				EntriesSoftLimit: 10,
+				EntriesHardLimit: 20,
+			},
+		},
+		banned: make(map[string]time.Time),
+		hosts:  make(map[string]hostScore),
+	}
+	banTime := time.Now().Add(10 * time.Minute)
+	d.banned["1.1.1.1"] = banTime
+	d.hosts["1.1.1.2"] = hostScore{
+		TotalScore: 1,
+		Events: []hostEvent{
+			{
+				dateTime: time.Now(),
+				score:    1,
+			},
+		},
+	}
+	added := d.addRemoteBans(map[string]time.Time{
+		"1.1.1.1": banTime.Add(-5 * time.Minute),
+		"1.1.1.2": time.Now().Add(5 * time.Minute),
+		"1.1.1.3": time.Now().Add(-1 * time.Minute),
+	})
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 2, d.countBanned())
+	assert.Equal(t, 0, d.countHosts())
+	assert.Equal(t, banTime, d.banned["1.1.1.1"])
+	banTime2, err := d.GetBanTime("1.1.1.2")
+	assert.NoError(t, err)
+	assert.NotNil(t, banTime2)
+	banTime2, err = d.GetBanTime("1.1.1.3")
+	assert.NoError(t, err)
+	assert.Nil(t, banTime2)
+	// a longer ban extends the local one
+	added = d.addRemoteBans(map[string]time.Time{
+		"1.1.1.1": banTime.Add(5 * time.Minute),
+	})
+	assert.Equal(t, 1, added)
+	assert.Equal(t, banTime.Add(5*time.Minute), d.banned["1.1.1.1"])
+	assert.Equal(t, 0, d.addRemoteBans(nil))
+}
+
 func TestDefenderCleanup(t *testing.T) {
 	d := memoryDefender{
 		baseDefender: baseDefender{
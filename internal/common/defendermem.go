@@ -55,6 +55,27 @@ func newInMemoryDefender(config *DefenderConfig) (Defender, error) {
 	return defender, nil
 }
 
+// copyState copies the hosts scores and the bans from the specified defender
+func (d *memoryDefender) copyState(from *memoryDefender) {
+	from.RLock()
+	defer from.RUnlock()
+
+	d.Lock()
+	defer d.Unlock()
+
+	for k, v := range from.hosts {
+		events := make([]hostEvent, len(v.Events))
+		copy(events, v.Events)
+		d.hosts[k] = hostScore{
+			TotalScore: v.TotalScore,
+			Events:     events,
+		}
+	}
+	for k, v := range from.banned {
+		d.banned[k] = v
+	}
+}
+
 // GetHosts returns hosts that are banned or for which some violations have been detected
 func (d *memoryDefender) GetHosts() ([]dataprovider.DefenderEntry, error) {
 	d.RLock()
@@ -235,6 +256,31 @@ func (d *memoryDefender) AddEvent(ip, protocol string, event HostEvent) bool {
 	return false
 }
 
+// addRemoteBans adds the bans detected by other cluster nodes. An existing ban
+// is extended if the remote one expires later. Returns the number of bans
+// added or extended
+func (d *memoryDefender) addRemoteBans(bans map[string]time.Time) int {
+	d.Lock()
+	defer d.Unlock()
+
+	added := 0
+	for ip, banTime := range bans {
+		if !banTime.After(time.Now()) {
+			continue
+		}
+		if current, ok := d.banned[ip]; ok && !banTime.After(current) {
+			continue
+		}
+		d.banned[ip] = banTime
+		delete(d.hosts, ip)
+		added++
+	}
+	if added > 0 {
+		d.cleanupBanned()
+	}
+	return added
+}
+
 func (d *memoryDefender) countBanned() int {
 	d.RLock()
 	defer d.RUnlock()
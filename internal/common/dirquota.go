@@ -0,0 +1,352 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	dirQuotaCleanupInterval = 10 * time.Minute
+	// the usage not checked within this time is removed and computed again
+	// by scanning the directory the next time it is needed
+	dirQuotaUsageMaxAge = 1 * time.Hour
+)
+
+var dirQuotaUsages = dirQuotaUsageTracker{
+	usages: make(map[dirQuotaKey]*dirQuotaUsage),
+}
+
+// dirQuotaKey identifies a directory by its filesystem location, so a
+// directory inside a virtual folder shared by multiple users is tracked once
+type dirQuotaKey struct {
+	fsName string
+	fsPath string
+}
+
+type dirQuotaUsage struct {
+	files    int
+	size     int64
+	lastUsed time.Time
+}
+
+// dirQuotaUsageTracker stores the usage of the directories with a quota.
+// The usage is computed by scanning the directory the first time it is
+// needed, then it is updated for the changes made through this instance.
+// It is not shared between cluster nodes, so the usage is approximate:
+// it drifts if the directory is modified by other nodes or outside SFTPGo
+// until it is rescanned after a quota scan or an expiration
+type dirQuotaUsageTracker struct {
+	sync.Mutex
+	usages map[dirQuotaKey]*dirQuotaUsage
+}
+
+func (t *dirQuotaUsageTracker) get(key dirQuotaKey) (int, int64, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	usage, ok := t.usages[key]
+	if !ok {
+		return 0, 0, false
+	}
+	usage.lastUsed = time.Now()
+	return usage.files, usage.size, true
+}
+
+// add stores the scanned usage, if not already stored by a concurrent scan,
+// and returns the usage to use
+func (t *dirQuotaUsageTracker) add(key dirQuotaKey, files int, size int64) (int, int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	usage, ok := t.usages[key]
+	if !ok {
+		usage = &dirQuotaUsage{
+			files: files,
+			size:  size,
+		}
+		t.usages[key] = usage
+	}
+	usage.lastUsed = time.Now()
+	return usage.files, usage.size
+}
+
+func (t *dirQuotaUsageTracker) update(key dirQuotaKey, files int, size int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	usage, ok := t.usages[key]
+	if !ok {
+		// the usage will be computed when needed
+		return
+	}
+	usage.files = max(usage.files+files, 0)
+	usage.size = max(usage.size+size, 0)
+}
+
+func (t *dirQuotaUsageTracker) remove(key dirQuotaKey) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.usages, key)
+}
+
+func (t *dirQuotaUsageTracker) cleanup(usedBefore time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	for key, usage := range t.usages {
+		if usage.lastUsed.Before(usedBefore) {
+			delete(t.usages, key)
+		}
+	}
+}
+
+func cleanupDirQuotaUsages() {
+	dirQuotaUsages.cleanup(time.Now().Add(-dirQuotaUsageMaxAge))
+}
+
+// ResetDirQuotaUsages removes the tracked usage for the directory quotas of the
+// specified user, so it is computed again by scanning the directories. It is
+// called after a quota scan to include the changes made outside this instance
+func ResetDirQuotaUsages(user *dataprovider.User) {
+	for _, q := range user.Filters.DirQuotas {
+		fs, err := user.GetFilesystemForPath(q.Path, "")
+		if err != nil {
+			continue
+		}
+		fsPath, err := fs.ResolvePath(q.Path)
+		if err != nil {
+			continue
+		}
+		dirQuotaUsages.remove(dirQuotaKey{fsName: fs.Name(), fsPath: fsPath})
+	}
+}
+
+// getDirQuotaResult returns the quota check result for the directory quota
+// applying to the specified path. The returned bool is false if no directory
+// quota applies
+func (c *BaseConnection) getDirQuotaResult(checkFiles, getUsage bool, requestPath string) (vfs.QuotaCheckResult, bool) {
+	result := vfs.QuotaCheckResult{
+		HasSpace: true,
+	}
+	dirQuota, ok := c.User.GetDirQuota(requestPath)
+	if !ok || (dirQuota.HasNoQuotaRestrictions(checkFiles) && !getUsage) {
+		return result, false
+	}
+	result.QuotaSize = dirQuota.QuotaSize
+	result.QuotaFiles = dirQuota.QuotaFiles
+	fs, fsPath, err := c.GetFsAndResolvedPath(dirQuota.Path)
+	if err != nil {
+		c.Log(logger.LevelError, "unable to resolve directory quota path %q: %v", dirQuota.Path, err)
+		result.HasSpace = false
+		return result, true
+	}
+	result.UsedFiles, result.UsedSize, err = c.getDirQuotaUsage(fs, fsPath)
+	if err != nil {
+		c.Log(logger.LevelError, "error getting used quota for directory %q: %v", dirQuota.Path, err)
+		result.HasSpace = false
+		return result, true
+	}
+	result.AllowedFiles = result.QuotaFiles - result.UsedFiles
+	result.AllowedSize = result.QuotaSize - result.UsedSize
+	if (checkFiles && result.QuotaFiles > 0 && result.UsedFiles >= result.QuotaFiles) ||
+		(result.QuotaSize > 0 && result.UsedSize >= result.QuotaSize) {
+		c.Log(logger.LevelDebug, "quota exceed for directory %q, request path %q, num files: %d/%d, size: %d/%d check files: %t",
+			dirQuota.Path, requestPath, result.UsedFiles, result.QuotaFiles, result.UsedSize, result.QuotaSize, checkFiles)
+		result.HasSpace = false
+	}
+	return result, true
+}
+
+// hasSpaceForDirQuotaRename checks the directory quota applying to the rename
+// target, if any. Renames inside the same quota directory don't change its usage
+func (c *BaseConnection) hasSpaceForDirQuotaRename(fs vfs.Fs, virtualSourcePath, virtualTargetPath string,
+	initialSize int64, fsSourcePath string,
+) bool {
+	dirQuota, ok := c.User.GetDirQuota(virtualTargetPath)
+	if !ok || dirQuota.IsPathIncluded(path.Dir(virtualSourcePath)) {
+		return true
+	}
+	quotaResult, ok := c.getDirQuotaResult(true, false, virtualTargetPath)
+	if !ok {
+		return true
+	}
+	return c.hasSpaceForCrossRename(fs, quotaResult, initialSize, fsSourcePath)
+}
+
+// mergeQuotaCheckResults returns a quota check result with the most restrictive
+// limits between the user or virtual folder result and the directory result
+func mergeQuotaCheckResults(result, dirResult vfs.QuotaCheckResult) vfs.QuotaCheckResult {
+	if dirResult.QuotaSize > 0 && (result.QuotaSize == 0 || dirResult.AllowedSize < result.AllowedSize) {
+		result.QuotaSize = dirResult.QuotaSize
+		result.UsedSize = dirResult.UsedSize
+		result.AllowedSize = dirResult.AllowedSize
+	}
+	if dirResult.QuotaFiles > 0 && (result.QuotaFiles == 0 || dirResult.AllowedFiles < result.AllowedFiles) {
+		result.QuotaFiles = dirResult.QuotaFiles
+		result.UsedFiles = dirResult.UsedFiles
+		result.AllowedFiles = dirResult.AllowedFiles
+	}
+	result.HasSpace = result.HasSpace && dirResult.HasSpace
+	return result
+}
+
+// getDirQuotaUsage returns the tracked usage for the specified quota directory.
+// The directory is scanned if its usage is not tracked yet
+func (c *BaseConnection) getDirQuotaUsage(fs vfs.Fs, fsPath string) (int, int64, error) {
+	key := dirQuotaKey{fsName: fs.Name(), fsPath: fsPath}
+	if files, size, ok := dirQuotaUsages.get(key); ok {
+		return files, size, nil
+	}
+	files, size, err := fs.GetDirSize(fsPath)
+	if err != nil {
+		if !fs.IsNotExist(err) {
+			return 0, 0, err
+		}
+		files = 0
+		size = 0
+	}
+	c.Log(logger.LevelDebug, "directory quota usage computed for %q, files: %d, size: %d", fsPath, files, size)
+	files, size = dirQuotaUsages.add(key, files, size)
+	return files, size, nil
+}
+
+func (c *BaseConnection) getDirQuotaKey(dirQuota *dataprovider.DirQuota) (dirQuotaKey, bool) {
+	fs, fsPath, err := c.GetFsAndResolvedPath(dirQuota.Path)
+	if err != nil {
+		return dirQuotaKey{}, false
+	}
+	return dirQuotaKey{fsName: fs.Name(), fsPath: fsPath}, true
+}
+
+// UpdateDirQuotaUsage updates the tracked usage of the directory quotas, including
+// nested ones, containing the specified file
+func (c *BaseConnection) UpdateDirQuotaUsage(virtualPath string, numFiles int, size int64) {
+	if len(c.User.Filters.DirQuotas) == 0 || (numFiles == 0 && size == 0) {
+		return
+	}
+	dirPath := path.Dir(virtualPath)
+	for idx := range c.User.Filters.DirQuotas {
+		dirQuota := &c.User.Filters.DirQuotas[idx]
+		if !dirQuota.IsPathIncluded(dirPath) {
+			continue
+		}
+		if key, ok := c.getDirQuotaKey(dirQuota); ok {
+			dirQuotaUsages.update(key, numFiles, size)
+		}
+	}
+}
+
+// InvalidateDirQuotaUsage removes the tracked usage of the directory quotas
+// affected by changes of unknown size to the specified directory tree
+func (c *BaseConnection) InvalidateDirQuotaUsage(virtualDirPath string) {
+	for idx := range c.User.Filters.DirQuotas {
+		dirQuota := &c.User.Filters.DirQuotas[idx]
+		if !dirQuota.IsPathIncluded(virtualDirPath) && !isDirQuotaInTree(dirQuota, virtualDirPath) {
+			continue
+		}
+		if key, ok := c.getDirQuotaKey(dirQuota); ok {
+			dirQuotaUsages.remove(key)
+		}
+	}
+}
+
+// updateDirQuotasAfterRename moves the renamed files and their size between the
+// directory quotas containing the source and the target
+func (c *BaseConnection) updateDirQuotasAfterRename(fs vfs.Fs, virtualSourcePath, virtualTargetPath, targetPath string,
+	initialSize int64, numFiles int, filesSize int64,
+) {
+	if len(c.User.Filters.DirQuotas) == 0 {
+		return
+	}
+	sourceDir := path.Dir(virtualSourcePath)
+	targetDir := path.Dir(virtualTargetPath)
+	var removedFrom, addedTo []dirQuotaKey
+	for idx := range c.User.Filters.DirQuotas {
+		dirQuota := &c.User.Filters.DirQuotas[idx]
+		key, ok := c.getDirQuotaKey(dirQuota)
+		if !ok {
+			continue
+		}
+		if isDirQuotaInTree(dirQuota, virtualSourcePath) || isDirQuotaInTree(dirQuota, virtualTargetPath) {
+			// the quota directory itself was moved
+			dirQuotaUsages.remove(key)
+			continue
+		}
+		inSource := dirQuota.IsPathIncluded(sourceDir)
+		inTarget := dirQuota.IsPathIncluded(targetDir)
+		switch {
+		case inSource && inTarget:
+			if initialSize != -1 {
+				dirQuotaUsages.update(key, -1, -initialSize)
+			}
+		case inSource:
+			removedFrom = append(removedFrom, key)
+		case inTarget:
+			addedTo = append(addedTo, key)
+		}
+	}
+	if len(removedFrom) == 0 && len(addedTo) == 0 {
+		return
+	}
+	if filesSize == -1 {
+		var err error
+		numFiles, filesSize, err = c.getRenamedFilesSize(fs, targetPath)
+		if err != nil {
+			c.Log(logger.LevelError, "unable to update directory quotas after renaming %q: %v", targetPath, err)
+			for _, key := range append(removedFrom, addedTo...) {
+				dirQuotaUsages.remove(key)
+			}
+			return
+		}
+	}
+	for _, key := range removedFrom {
+		dirQuotaUsages.update(key, -numFiles, -filesSize)
+	}
+	for _, key := range addedTo {
+		if initialSize != -1 {
+			dirQuotaUsages.update(key, numFiles-1, filesSize-initialSize)
+		} else {
+			dirQuotaUsages.update(key, numFiles, filesSize)
+		}
+	}
+}
+
+// getRenamedFilesSize returns the number of files, and their size, for the
+// specified renamed path
+func (c *BaseConnection) getRenamedFilesSize(fs vfs.Fs, targetPath string) (int, int64, error) {
+	fi, err := fs.Stat(targetPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.Mode().IsDir() {
+		return fs.GetDirSize(targetPath)
+	}
+	return 1, fi.Size(), nil
+}
+
+// isDirQuotaInTree returns true if the quota directory is the specified
+// directory or one of its sub directories
+func isDirQuotaInTree(dirQuota *dataprovider.DirQuota, virtualDirPath string) bool {
+	return virtualDirPath == "/" || dirQuota.Path == virtualDirPath || strings.HasPrefix(dirQuota.Path, virtualDirPath+"/")
+}
@@ -46,6 +46,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -57,6 +58,8 @@ const (
 	maxAttachmentsSize       = int64(10 * 1024 * 1024)
 	objDataPlaceholder       = "{{ObjectData}}"
 	objDataPlaceholderString = "{{ObjectDataString}}"
+	objDiffPlaceholder       = "{{ObjectDiff}}"
+	objDiffPlaceholderString = "{{ObjectDiffString}}"
 )
 
 // Supported IDP login events
@@ -79,17 +82,20 @@ func init() {
 		concurrencyGuard: make(chan struct{}, 200),
 	}
 	dataprovider.SetEventRulesCallbacks(eventManager.loadRules, eventManager.RemoveRule,
-		func(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer) {
+		func(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer, snapshot []byte) {
+			publishProviderLiveEvent(operation, executor, ip, objectType, objectName, role)
 			p := EventParams{
-				Name:       executor,
-				ObjectName: objectName,
-				Event:      operation,
-				Status:     1,
-				ObjectType: objectType,
-				IP:         ip,
-				Role:       role,
-				Timestamp:  time.Now().UnixNano(),
-				Object:     object,
+				Name:           executor,
+				ObjectName:     objectName,
+				Event:          operation,
+				Status:         1,
+				ObjectType:     objectType,
+				IP:             ip,
+				Role:           role,
+				Tenant:         dataprovider.GetObjectTenant(object),
+				Timestamp:      time.Now().UnixNano(),
+				Object:         object,
+				objectSnapshot: snapshot,
 			}
 			if u, ok := object.(*dataprovider.User); ok {
 				p.Email = u.Email
@@ -221,7 +227,7 @@ func (r *eventRulesContainer) addUpdateRuleInternal(rule dataprovider.EventRule)
 	r.removeRuleInternal(rule.Name)
 	if rule.DeletedAt > 0 {
 		deletedAt := util.GetTimeFromMsecSinceEpoch(rule.DeletedAt)
-		if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
+		if deletedAt.Add(30*time.Minute).Before(time.Now()) && dataprovider.IsClusterLeader() {
 			eventManagerLog(logger.LevelDebug, "removing rule %q deleted at %s", rule.Name, deletedAt)
 			go dataprovider.RemoveEventRule(rule) //nolint:errcheck
 		}
@@ -374,6 +380,9 @@ func (r *eventRulesContainer) handleFsEvent(params EventParams) (bool, error) {
 
 	var rulesWithSyncActions, rulesAsync []dataprovider.EventRule
 	for _, rule := range r.FsEvents {
+		if !rule.MatchesTenant(params.Tenant) {
+			continue
+		}
 		if r.checkFsEventMatch(&rule.Conditions, &params) {
 			if err := rule.CheckActionsConsistency(""); err != nil {
 				eventManagerLog(logger.LevelWarn, "rule %q skipped: %v, event %q",
@@ -470,6 +479,9 @@ func (r *eventRulesContainer) handleProviderEvent(params EventParams) {
 
 	var rules []dataprovider.EventRule
 	for _, rule := range r.ProviderEvents {
+		if !rule.MatchesTenant(params.Tenant) {
+			continue
+		}
 		if r.checkProviderEventMatch(&rule.Conditions, &params) {
 			if err := rule.CheckActionsConsistency(params.ObjectType); err == nil {
 				rules = append(rules, rule)
@@ -554,6 +566,7 @@ type EventParams struct {
 	Protocol              string
 	IP                    string
 	Role                  string
+	Tenant                string
 	Email                 string
 	Timestamp             int64
 	UID                   string
@@ -561,6 +574,7 @@ type EventParams struct {
 	Object                plugin.Renderer
 	Metadata              map[string]string
 	sender                string
+	objectSnapshot        []byte
 	updateStatusFromError bool
 	errors                []string
 	retentionChecks       []executedRetentionCheck
@@ -788,6 +802,7 @@ func (p *EventParams) getStringReplacements(addObjectData, jsonEscaped bool) []s
 		"{{Protocol}}", p.Protocol,
 		"{{IP}}", p.IP,
 		"{{Role}}", p.getStringReplacement(p.Role, jsonEscaped),
+		"{{Tenant}}", p.getStringReplacement(p.Tenant, jsonEscaped),
 		"{{Email}}", p.getStringReplacement(p.Email, jsonEscaped),
 		"{{Timestamp}}", strconv.FormatInt(p.Timestamp, 10),
 		"{{StatusString}}", p.getStatusString(),
@@ -808,12 +823,19 @@ func (p *EventParams) getStringReplacements(addObjectData, jsonEscaped bool) []s
 	}
 	replacements = append(replacements, objDataPlaceholder, "{}")
 	replacements = append(replacements, objDataPlaceholderString, "")
+	replacements = append(replacements, objDiffPlaceholder, "[]")
+	replacements = append(replacements, objDiffPlaceholderString, "")
 	if addObjectData {
 		data, err := p.Object.RenderAsJSON(p.Event != operationDelete)
 		if err == nil {
 			dataString := util.BytesToString(data)
-			replacements[len(replacements)-3] = p.getStringReplacement(dataString, false)
-			replacements[len(replacements)-1] = p.getStringReplacement(dataString, true)
+			replacements[len(replacements)-7] = p.getStringReplacement(dataString, false)
+			replacements[len(replacements)-5] = p.getStringReplacement(dataString, true)
+			if diff, err := dataprovider.GetObjectDiff(p.Event, p.objectSnapshot, data); err == nil {
+				diffString := util.BytesToString(diff)
+				replacements[len(replacements)-3] = p.getStringReplacement(diffString, false)
+				replacements[len(replacements)-1] = p.getStringReplacement(diffString, true)
+			}
 		}
 	}
 	if p.IDPCustomFields != nil {
@@ -903,6 +925,7 @@ func closeWriterAndUpdateQuota(w io.WriteCloser, conn *BaseConnection, virtualSo
 }
 
 func updateUserQuotaAfterFileWrite(conn *BaseConnection, virtualPath string, numFiles int, fileSize int64) {
+	conn.UpdateDirQuotaUsage(virtualPath, numFiles, fileSize)
 	vfolder, err := conn.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 	if err != nil {
 		dataprovider.UpdateUserQuota(&conn.User, numFiles, fileSize, false) //nolint:errcheck
@@ -1475,11 +1498,16 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 	return nil
 }
 
+func hasObjectDataPlaceholder(val string) bool {
+	return strings.Contains(val, objDataPlaceholder) || strings.Contains(val, objDataPlaceholderString) ||
+		strings.Contains(val, objDiffPlaceholder) || strings.Contains(val, objDiffPlaceholderString)
+}
+
 func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *EventParams) error {
 	addObjectData := false
 	if params.Object != nil {
 		for _, k := range c.EnvVars {
-			if strings.Contains(k.Value, objDataPlaceholder) || strings.Contains(k.Value, objDataPlaceholderString) {
+			if hasObjectDataPlaceholder(k.Value) {
 				addObjectData = true
 				break
 			}
@@ -1536,7 +1564,7 @@ func getEmailAddressesWithReplacer(addrs []string, replacer *strings.Replacer) [
 func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *EventParams) error {
 	addObjectData := false
 	if params.Object != nil {
-		if strings.Contains(c.Body, objDataPlaceholder) || strings.Contains(c.Body, objDataPlaceholderString) {
+		if hasObjectDataPlaceholder(c.Body) {
 			addObjectData = true
 		}
 	}
@@ -1603,6 +1631,8 @@ func getUserForEventAction(user dataprovider.User) (dataprovider.User, error) {
 	user.Filters.DisableFsChecks = false
 	user.Filters.FilePatterns = nil
 	user.Filters.BandwidthLimits = nil
+	user.Filters.ProtocolBandwidthLimits = nil
+	user.Filters.AppendOnly = false
 	for k := range user.Permissions {
 		user.Permissions[k] = []string{dataprovider.PermAny}
 	}
@@ -2128,11 +2158,12 @@ func executeQuotaResetForUser(user *dataprovider.User) error {
 	}
 	defer QuotaScans.RemoveUserQuotaScan(user.Username)
 
-	numFiles, size, err := user.ScanQuota()
+	numFiles, size, err := user.ScanQuota(false)
 	if err != nil {
 		eventManagerLog(logger.LevelError, "error scanning quota for user %q: %v", user.Username, err)
 		return fmt.Errorf("error scanning quota for user %q: %w", user.Username, err)
 	}
+	ResetDirQuotaUsages(user)
 	err = dataprovider.UpdateUserQuota(user, numFiles, size, true)
 	if err != nil {
 		eventManagerLog(logger.LevelError, "error updating quota for user %q: %v", user.Username, err)
@@ -2198,7 +2229,7 @@ func executeFoldersQuotaResetRuleAction(conditions dataprovider.ConditionOptions
 			BaseVirtualFolder: folder,
 			VirtualPath:       "/",
 		}
-		numFiles, size, err := f.ScanQuota()
+		numFiles, size, err := f.ScanQuota(false)
 		QuotaScans.RemoveVFolderQuotaScan(folder.Name)
 		if err != nil {
 			eventManagerLog(logger.LevelError, "error scanning quota for folder %q: %v", folder.Name, err)
@@ -2591,6 +2622,8 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = fmt.Errorf("unsupported action type: %d", action.Type)
 	}
 
+	// the type is an i18n key, for example "actions.types.http", the last part is enough as label
+	metric.EventActionCompleted(strings.TrimPrefix(action.GetTypeAsString(), "actions.types."), err)
 	if err != nil {
 		err = fmt.Errorf("action %q failed: %w", action.Name, err)
 	}
@@ -2258,6 +2258,46 @@ func TestMetadataReplacement(t *testing.T) {
 	assert.Equal(t, `{"key":"value"} {\"key\":\"value\"}`, string(data))
 }
 
+type testRenderer struct {
+	data []byte
+}
+
+func (r *testRenderer) RenderAsJSON(_ bool) ([]byte, error) {
+	return r.data, nil
+}
+
+func TestObjectDiffReplacement(t *testing.T) {
+	params := &EventParams{
+		Event:          "update",
+		Object:         &testRenderer{data: []byte(`{"name":"n","status":0,"groups":["g2"],"filters":{"a/b":1}}`)},
+		objectSnapshot: []byte(`{"name":"n","status":1,"groups":["g1"],"filters":{"a/b":1,"c":2},"description":"d"}`),
+	}
+	replacements := params.getStringReplacements(true, false)
+	replacer := strings.NewReplacer(replacements...)
+	assert.True(t, hasObjectDataPlaceholder("{{ObjectDiff}}"))
+	assert.JSONEq(t, `[{"op":"remove","path":"/description"},{"op":"remove","path":"/filters/c"},`+
+		`{"op":"replace","path":"/groups","value":["g2"]},{"op":"replace","path":"/status","value":0}]`,
+		replacer.Replace("{{ObjectDiff}}"))
+	assert.NotEmpty(t, replacer.Replace("{{ObjectDiffString}}"))
+	assert.JSONEq(t, `{"name":"n","status":0,"groups":["g2"],"filters":{"a/b":1}}`, replacer.Replace("{{ObjectData}}"))
+
+	params.Event = "add"
+	params.Object = &testRenderer{data: []byte(`{"a/b":"c"}`)}
+	replacements = params.getStringReplacements(true, false)
+	replacer = strings.NewReplacer(replacements...)
+	assert.JSONEq(t, `[{"op":"add","path":"/a~1b","value":"c"}]`, replacer.Replace("{{ObjectDiff}}"))
+	// without snapshot no diff is available for updates
+	params.Event = "update"
+	params.objectSnapshot = nil
+	replacements = params.getStringReplacements(true, false)
+	replacer = strings.NewReplacer(replacements...)
+	assert.Equal(t, "[]", replacer.Replace("{{ObjectDiff}}"))
+	replacements = params.getStringReplacements(false, false)
+	replacer = strings.NewReplacer(replacements...)
+	assert.Equal(t, "[]", replacer.Replace("{{ObjectDiff}}"))
+	assert.Equal(t, "{}", replacer.Replace("{{ObjectData}}"))
+}
+
 func TestUserInactivityCheck(t *testing.T) {
 	username1 := "user1"
 	username2 := "user2"
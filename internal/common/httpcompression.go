@@ -0,0 +1,220 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingZstd   = "zstd"
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+	// browsers limit the zstd window size to 8MB
+	zstdWindowSize = 8 << 20
+	// the default brotli level is too slow for on the fly compression, this
+	// one compresses better than gzip at a similar speed
+	brotliLevel = 5
+)
+
+// supported content encodings in order of preference
+var supportedContentEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// HTTPCompressionConfig defines the response compression for WebDAV and
+// HTTP downloads
+type HTTPCompressionConfig struct {
+	// Set to true to compress the responses if the client supports it
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Minimum size, in bytes, for the content to compress. Smaller contents
+	// are sent uncompressed
+	MinSize int64 `json:"min_size" mapstructure:"min_size"`
+	// Compressible MIME types. Wildcards such as "text/*" are supported
+	MimeTypes []string `json:"mime_types" mapstructure:"mime_types"`
+}
+
+func (c *HTTPCompressionConfig) isMimeTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.MimeTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isCompressible returns true if a response with the specified content type
+// and length should be compressed. A negative length means unknown
+func (c *HTTPCompressionConfig) isCompressible(contentType string, length int64) bool {
+	if length >= 0 && length < c.MinSize {
+		return false
+	}
+	return c.isMimeTypeAllowed(contentType)
+}
+
+// negotiateContentEncoding returns the preferred encoding, among the supported
+// ones, accepted by the client. An empty string means no compression
+func negotiateContentEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if val, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		weights[coding] = q
+	}
+	var result string
+	var best float64
+	for _, encoding := range supportedContentEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > best {
+			result = encoding
+			best = q
+		}
+	}
+	return result
+}
+
+// WrapCompressedResponse returns a response writer that transparently
+// compresses the response body if the request accepts a supported content
+// encoding and the response content type and length, as set in the headers
+// before writing the status code, are compressible. Range and HEAD requests
+// are never compressed. The returned function must be called after writing
+// the body to flush the compressed data. Transfers keep accounting the
+// uncompressed bytes read from the storage backend
+func WrapCompressedResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error) {
+	c := &Config.HTTPCompression
+	if !c.Enabled || r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return w, func() error { return nil }
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateContentEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return w, func() error { return nil }
+	}
+	cw := &compressResponseWriter{
+		ResponseWriter: w,
+		config:         c,
+		encoding:       encoding,
+	}
+	return cw, cw.close
+}
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	config      *HTTPCompressionConfig
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) shouldCompress(code int) bool {
+	h := w.Header()
+	if code != http.StatusOK || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	length := int64(-1)
+	if val := h.Get("Content-Length"); val != "" {
+		size, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return false
+		}
+		length = size
+	}
+	return w.config.isCompressible(h.Get("Content-Type"), length)
+}
+
+func (w *compressResponseWriter) newEncoder() (io.WriteCloser, error) {
+	switch w.encoding {
+	case encodingZstd:
+		return zstd.NewWriter(w.ResponseWriter, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+	case encodingBrotli:
+		return brotli.NewWriterLevel(w.ResponseWriter, brotliLevel), nil
+	default:
+		return gzip.NewWriterLevel(w.ResponseWriter, gzip.DefaultCompression)
+	}
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldCompress(code) {
+		encoder, err := w.newEncoder()
+		if err == nil {
+			h := w.Header()
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			h.Set("Content-Encoding", w.encoding)
+			// the compressed representation is not byte-identical
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			w.writer = encoder
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.writer != nil {
+		return w.writer.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) close() error {
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
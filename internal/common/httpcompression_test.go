@@ -0,0 +1,52 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	assert.Empty(t, negotiateContentEncoding(""))
+	assert.Empty(t, negotiateContentEncoding("deflate"))
+	assert.Equal(t, encodingBrotli, negotiateContentEncoding("br, deflate"))
+	assert.Equal(t, encodingBrotli, negotiateContentEncoding("gzip, br"))
+	assert.Equal(t, encodingZstd, negotiateContentEncoding("gzip, br, zstd"))
+	assert.Equal(t, encodingBrotli, negotiateContentEncoding("zstd;q=0.5, br;q=0.8, gzip;q=0.8"))
+	assert.Empty(t, negotiateContentEncoding("gzip;q=0"))
+	assert.Empty(t, negotiateContentEncoding("*;q=0"))
+	assert.Equal(t, encodingGzip, negotiateContentEncoding("gzip, deflate"))
+	assert.Equal(t, encodingZstd, negotiateContentEncoding("gzip, zstd"))
+	assert.Equal(t, encodingGzip, negotiateContentEncoding("zstd;q=0.5, gzip;q=0.8"))
+	assert.Equal(t, encodingZstd, negotiateContentEncoding("*"))
+	assert.Equal(t, encodingBrotli, negotiateContentEncoding("zstd;q=0, *"))
+	assert.Equal(t, encodingGzip, negotiateContentEncoding("zstd;q=invalid, GZIP"))
+}
+
+func TestHTTPCompressionIsCompressible(t *testing.T) {
+	c := HTTPCompressionConfig{
+		Enabled:   true,
+		MinSize:   100,
+		MimeTypes: []string{"text/*", "Application/JSON"},
+	}
+	assert.True(t, c.isCompressible("text/plain; charset=utf-8", 100))
+	assert.True(t, c.isCompressible("application/json", -1))
+	assert.False(t, c.isCompressible("text/html", 99))
+	assert.False(t, c.isCompressible("application/octet-stream", 1000))
+	assert.False(t, c.isCompressible("textplain", 1000))
+	assert.False(t, c.isCompressible("", 1000))
+}
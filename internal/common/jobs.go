@@ -0,0 +1,273 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported job types
+const (
+	JobTypeUserQuotaScan   = "user_quota_scan"
+	JobTypeFolderQuotaScan = "folder_quota_scan"
+	JobTypeRetentionCheck  = "retention_check"
+	JobTypeBackup          = "backup"
+	JobTypeUsersDelete     = "users_delete"
+	JobTypeArchiveRestore  = "archive_restore"
+)
+
+// Supported job statuses
+const (
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCanceled  = "canceled"
+)
+
+const (
+	// finished jobs are kept in memory for this time so their results can be queried
+	jobsRetention = time.Hour
+)
+
+var (
+	// Jobs is the list of the asynchronous jobs started using the REST API
+	// or the WebClient
+	Jobs = ActiveJobs{
+		jobs: make(map[string]*activeJob),
+	}
+	errJobNotCancelable = errors.New("the job cannot be canceled")
+	errJobFinished      = errors.New("the job is already finished")
+)
+
+// JobFunc defines the function executed by an asynchronous job.
+// ctx is canceled if the job is canceled, setProgress allows to report
+// the job progress as percentage. The returned result is stored within the job
+type JobFunc func(ctx context.Context, setProgress func(int)) (any, error)
+
+// Job defines an asynchronous job
+type Job struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Progress   int    `json:"progress"`
+	Cancelable bool   `json:"cancelable"`
+	Admin      string `json:"admin"`
+	Username   string `json:"username,omitempty"`
+	Role       string `json:"role,omitempty"`
+	StartTime  int64  `json:"start_time"`
+	EndTime    int64  `json:"end_time,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Result     any    `json:"result,omitempty"`
+}
+
+type activeJob struct {
+	sync.RWMutex
+	job    Job
+	cancel context.CancelFunc
+}
+
+func (j *activeJob) getJob() Job {
+	j.RLock()
+	defer j.RUnlock()
+
+	return j.job
+}
+
+func (j *activeJob) setProgress(progress int) {
+	j.Lock()
+	defer j.Unlock()
+
+	if progress > 99 {
+		// 100 is reserved for finished jobs
+		progress = 99
+	}
+	if progress > j.job.Progress {
+		j.job.Progress = progress
+	}
+}
+
+func (j *activeJob) finish(result any, err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.job.Status == JobStatusRunning {
+		switch {
+		case err == nil:
+			j.job.Status = JobStatusCompleted
+		case errors.Is(err, context.Canceled):
+			j.job.Status = JobStatusCanceled
+		default:
+			j.job.Status = JobStatusFailed
+		}
+	}
+	if err != nil {
+		j.job.Error = err.Error()
+	}
+	j.job.Progress = 100
+	j.job.Result = result
+	j.job.EndTime = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// ActiveJobs holds the asynchronous jobs
+type ActiveJobs struct {
+	sync.RWMutex
+	jobs map[string]*activeJob
+}
+
+// Start starts a new job executing the provided function in a new goroutine
+func (j *ActiveJobs) Start(jobType, admin, role string, cancelable bool, fn JobFunc) Job {
+	return j.start(Job{
+		Type:       jobType,
+		Cancelable: cancelable,
+		Admin:      admin,
+		Role:       role,
+	}, fn)
+}
+
+// StartForUser starts a new job, on behalf of the specified user, executing
+// the provided function in a new goroutine
+func (j *ActiveJobs) StartForUser(jobType, username, role string, cancelable bool, fn JobFunc) Job {
+	return j.start(Job{
+		Type:       jobType,
+		Cancelable: cancelable,
+		Username:   username,
+		Role:       role,
+	}, fn)
+}
+
+func (j *ActiveJobs) start(info Job, fn JobFunc) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	info.ID = util.GenerateUniqueID()
+	info.Status = JobStatusRunning
+	info.StartTime = util.GetTimeAsMsSinceEpoch(time.Now())
+	job := &activeJob{
+		job:    info,
+		cancel: cancel,
+	}
+
+	j.Lock()
+	j.cleanup()
+	j.jobs[job.job.ID] = job
+	j.Unlock()
+
+	logger.Debug(logSender, "", "job %q, type %q started by admin %q, user %q", info.ID, info.Type, info.Admin,
+		info.Username)
+
+	go func() {
+		defer cancel()
+
+		result, err := fn(ctx, job.setProgress)
+		job.finish(result, err)
+		logger.Debug(logSender, "", "job %q, type %q finished, error: %v", info.ID, info.Type, err)
+	}()
+
+	return job.getJob()
+}
+
+// Get returns the job with the specified ID
+func (j *ActiveJobs) Get(id, role string) (Job, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	if job, ok := j.jobs[id]; ok {
+		info := job.getJob()
+		if role == "" || role == info.Role {
+			return info, nil
+		}
+	}
+	return Job{}, util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", id))
+}
+
+// GetForUser returns the job with the specified ID if it was started by the
+// given user
+func (j *ActiveJobs) GetForUser(id, username string) (Job, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	if job, ok := j.jobs[id]; ok {
+		info := job.getJob()
+		if info.Username != "" && info.Username == username {
+			return info, nil
+		}
+	}
+	return Job{}, util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", id))
+}
+
+// List returns the jobs visible for the specified role, the most recent first
+func (j *ActiveJobs) List(role string) []Job {
+	j.RLock()
+	defer j.RUnlock()
+
+	jobs := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		info := job.getJob()
+		if role == "" || role == info.Role {
+			jobs = append(jobs, info)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if jobs[i].StartTime == jobs[k].StartTime {
+			return jobs[i].ID > jobs[k].ID
+		}
+		return jobs[i].StartTime > jobs[k].StartTime
+	})
+	return jobs
+}
+
+// Cancel requests the cancellation of a running job
+func (j *ActiveJobs) Cancel(id, role string) error {
+	j.RLock()
+	job, ok := j.jobs[id]
+	j.RUnlock()
+
+	if !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", id))
+	}
+	job.Lock()
+	defer job.Unlock()
+
+	if role != "" && role != job.job.Role {
+		return util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", id))
+	}
+	if job.job.Status != JobStatusRunning {
+		return util.NewValidationError(errJobFinished.Error())
+	}
+	if !job.job.Cancelable {
+		return util.NewValidationError(errJobNotCancelable.Error())
+	}
+	job.job.Status = JobStatusCanceled
+	job.cancel()
+	return nil
+}
+
+// cleanup removes the jobs finished since more than jobsRetention.
+// It must be called with the lock held
+func (j *ActiveJobs) cleanup() {
+	limit := util.GetTimeAsMsSinceEpoch(time.Now().Add(-jobsRetention))
+	for id, job := range j.jobs {
+		info := job.getJob()
+		if info.EndTime > 0 && info.EndTime < limit {
+			delete(j.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestJobs(t *testing.T) {
+	jobs := ActiveJobs{
+		jobs: make(map[string]*activeJob),
+	}
+	started := make(chan struct{})
+	job := jobs.Start(JobTypeUsersDelete, "admin", "role1", true, func(ctx context.Context, setProgress func(int)) (any, error) {
+		setProgress(50)
+		setProgress(20)
+		close(started)
+		<-ctx.Done()
+		return "partial", ctx.Err()
+	})
+	<-started
+	assert.Equal(t, JobStatusRunning, job.Status)
+	_, err := jobs.Get(job.ID, "role2")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	info, err := jobs.Get(job.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, 50, info.Progress)
+	assert.Len(t, jobs.List("role1"), 1)
+	assert.Len(t, jobs.List("role2"), 0)
+	err = jobs.Cancel(job.ID, "role2")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	err = jobs.Cancel("missing", "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	err = jobs.Cancel(job.ID, "role1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		info, err := jobs.Get(job.ID, "role1")
+		return err == nil && info.EndTime > 0
+	}, 1*time.Second, 50*time.Millisecond)
+	info, err = jobs.Get(job.ID, "role1")
+	require.NoError(t, err)
+	assert.Equal(t, JobStatusCanceled, info.Status)
+	assert.Equal(t, 100, info.Progress)
+	assert.Equal(t, "partial", info.Result)
+	err = jobs.Cancel(job.ID, "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+
+	job = jobs.Start(JobTypeBackup, "admin", "", false, func(_ context.Context, _ func(int)) (any, error) {
+		return nil, errors.New("backup error")
+	})
+	assert.Eventually(t, func() bool {
+		info, err := jobs.Get(job.ID, "")
+		return err == nil && info.Status == JobStatusFailed
+	}, 1*time.Second, 50*time.Millisecond)
+	info, err = jobs.Get(job.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, "backup error", info.Error)
+	err = jobs.Cancel(job.ID, "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	assert.Len(t, jobs.List(""), 2)
+	// finished jobs are removed after the retention time
+	jobs.jobs[job.ID].job.EndTime = util.GetTimeAsMsSinceEpoch(time.Now().Add(-2 * jobsRetention))
+
+	done := make(chan struct{})
+	job = jobs.Start(JobTypeUserQuotaScan, "admin", "", false, func(ctx context.Context, _ func(int)) (any, error) {
+		<-done
+		return nil, nil
+	})
+	err = jobs.Cancel(job.ID, "")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	close(done)
+	assert.Len(t, jobs.List(""), 2)
+}
+
+func TestUserJobs(t *testing.T) {
+	jobs := ActiveJobs{
+		jobs: make(map[string]*activeJob),
+	}
+	job := jobs.StartForUser(JobTypeArchiveRestore, "user1", "role1", false, func(_ context.Context, _ func(int)) (any, error) {
+		return "done", nil
+	})
+	assert.Empty(t, job.Admin)
+	assert.Equal(t, "user1", job.Username)
+	assert.Eventually(t, func() bool {
+		info, err := jobs.GetForUser(job.ID, "user1")
+		return err == nil && info.Status == JobStatusCompleted
+	}, 1*time.Second, 50*time.Millisecond)
+	_, err := jobs.GetForUser(job.ID, "user2")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	_, err = jobs.GetForUser("missing", "user1")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	// jobs started by admins are not visible to users
+	adminJob := jobs.Start(JobTypeBackup, "admin", "", false, func(_ context.Context, _ func(int)) (any, error) {
+		return nil, nil
+	})
+	_, err = jobs.GetForUser(adminJob.ID, "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	assert.Len(t, jobs.List("role1"), 1)
+}
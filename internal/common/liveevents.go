@@ -0,0 +1,241 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported live event types
+const (
+	LiveEventTypeConnection = "connection"
+	LiveEventTypeTransfer   = "transfer"
+	LiveEventTypeDefender   = "defender"
+	LiveEventTypeProvider   = "provider"
+)
+
+// Supported actions for connection events
+const (
+	LiveEventActionConnect    = "connect"
+	LiveEventActionDisconnect = "disconnect"
+)
+
+const (
+	// events are dropped for subscribers slower than this buffer
+	liveEventsBufferSize = 256
+)
+
+var (
+	// LiveEventTypes defines the supported live event types
+	LiveEventTypes = []string{LiveEventTypeConnection, LiveEventTypeTransfer, LiveEventTypeDefender,
+		LiveEventTypeProvider}
+	liveEvents = liveEventsBroadcaster{
+		subscribers: make(map[*LiveEventsSubscription]bool),
+	}
+)
+
+// LiveEvent defines an event streamed in real time to the subscribers
+type LiveEvent struct {
+	Type         string `json:"type"`
+	Action       string `json:"action"`
+	Timestamp    int64  `json:"timestamp"`
+	Username     string `json:"username,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	Role         string `json:"role,omitempty"`
+	ConnectionID string `json:"connection_id,omitempty"`
+	VirtualPath  string `json:"virtual_path,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+	Elapsed      int64  `json:"elapsed,omitempty"`
+	Status       int    `json:"status,omitempty"`
+	ObjectType   string `json:"object_type,omitempty"`
+	ObjectName   string `json:"object_name,omitempty"`
+}
+
+// LiveEventsFilter defines the server side filters for live events.
+// Empty fields match any value
+type LiveEventsFilter struct {
+	Types     []string
+	Usernames []string
+	Protocols []string
+	// Role is the role of the subscribed admin, admins with a role only receive
+	// the events related to their role
+	Role string
+}
+
+func (f *LiveEventsFilter) match(ev *LiveEvent) bool {
+	if len(f.Types) > 0 && !util.Contains(f.Types, ev.Type) {
+		return false
+	}
+	if len(f.Usernames) > 0 && !util.Contains(f.Usernames, ev.Username) {
+		return false
+	}
+	if len(f.Protocols) > 0 && !util.Contains(f.Protocols, ev.Protocol) {
+		return false
+	}
+	return f.Role == "" || f.Role == ev.Role
+}
+
+// LiveEventsSubscription is a subscription to the live events
+type LiveEventsSubscription struct {
+	filter  LiveEventsFilter
+	events  chan LiveEvent
+	dropped atomic.Int64
+}
+
+// Events returns the channel to receive the events from
+func (s *LiveEventsSubscription) Events() <-chan LiveEvent {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the subscriber was too slow
+func (s *LiveEventsSubscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+type liveEventsBroadcaster struct {
+	sync.RWMutex
+	subscribers map[*LiveEventsSubscription]bool
+	count       atomic.Int32
+}
+
+func (b *liveEventsBroadcaster) subscribe(filter LiveEventsFilter) *LiveEventsSubscription {
+	b.Lock()
+	defer b.Unlock()
+
+	s := &LiveEventsSubscription{
+		filter: filter,
+		events: make(chan LiveEvent, liveEventsBufferSize),
+	}
+	b.subscribers[s] = true
+	b.count.Store(int32(len(b.subscribers)))
+	return s
+}
+
+func (b *liveEventsBroadcaster) unsubscribe(s *LiveEventsSubscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.subscribers, s)
+	b.count.Store(int32(len(b.subscribers)))
+}
+
+func (b *liveEventsBroadcaster) hasSubscribers() bool {
+	return b.count.Load() > 0
+}
+
+func (b *liveEventsBroadcaster) publish(ev LiveEvent) {
+	if !b.hasSubscribers() {
+		return
+	}
+	if ev.Timestamp == 0 {
+		ev.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+	}
+
+	b.RLock()
+	defer b.RUnlock()
+
+	for s := range b.subscribers {
+		if !s.filter.match(&ev) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// SubscribeLiveEvents returns a new subscription to the live events matching
+// the specified filter. The subscription must be removed using UnsubscribeLiveEvents
+func SubscribeLiveEvents(filter LiveEventsFilter) *LiveEventsSubscription {
+	return liveEvents.subscribe(filter)
+}
+
+// UnsubscribeLiveEvents removes the specified subscription
+func UnsubscribeLiveEvents(s *LiveEventsSubscription) {
+	liveEvents.unsubscribe(s)
+}
+
+func publishConnectionLiveEvent(c ActiveConnection, action string) {
+	if !liveEvents.hasSubscribers() || c.GetUsername() == "" {
+		return
+	}
+	liveEvents.publish(LiveEvent{
+		Type:         LiveEventTypeConnection,
+		Action:       action,
+		Username:     c.GetUsername(),
+		IP:           util.GetIPFromRemoteAddress(c.GetRemoteAddress()),
+		Protocol:     c.GetProtocol(),
+		Role:         c.GetRole(),
+		ConnectionID: c.GetID(),
+	})
+}
+
+func publishTransferLiveEvent(conn *BaseConnection, operation, virtualPath string, fileSize, elapsed int64, status int) {
+	if !liveEvents.hasSubscribers() {
+		return
+	}
+	liveEvents.publish(LiveEvent{
+		Type:         LiveEventTypeTransfer,
+		Action:       operation,
+		Username:     conn.GetUsername(),
+		IP:           conn.GetRemoteIP(),
+		Protocol:     conn.GetProtocol(),
+		Role:         conn.GetRole(),
+		ConnectionID: conn.GetID(),
+		VirtualPath:  virtualPath,
+		FileSize:     fileSize,
+		Elapsed:      elapsed,
+		Status:       status,
+	})
+}
+
+func publishDefenderLiveEvent(ip, protocol string, event HostEvent, isBanned bool) {
+	if !liveEvents.hasSubscribers() {
+		return
+	}
+	ev := LiveEvent{
+		Type:     LiveEventTypeDefender,
+		Action:   string(event),
+		IP:       ip,
+		Protocol: protocol,
+		Status:   1,
+	}
+	if isBanned {
+		ev.Status = 2
+	}
+	liveEvents.publish(ev)
+}
+
+func publishProviderLiveEvent(operation, executor, ip, objectType, objectName, role string) {
+	if !liveEvents.hasSubscribers() {
+		return
+	}
+	liveEvents.publish(LiveEvent{
+		Type:       LiveEventTypeProvider,
+		Action:     operation,
+		Username:   executor,
+		IP:         ip,
+		Role:       role,
+		ObjectType: objectType,
+		ObjectName: objectName,
+	})
+}
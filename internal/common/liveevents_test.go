@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestLiveEvents(t *testing.T) {
+	b := liveEventsBroadcaster{
+		subscribers: make(map[*LiveEventsSubscription]bool),
+	}
+	assert.False(t, b.hasSubscribers())
+	// no subscribers, the event is discarded
+	b.publish(LiveEvent{Type: LiveEventTypeDefender})
+
+	all := b.subscribe(LiveEventsFilter{})
+	transfers := b.subscribe(LiveEventsFilter{
+		Types:     []string{LiveEventTypeTransfer},
+		Usernames: []string{"user1"},
+		Protocols: []string{ProtocolSFTP},
+	})
+	role := b.subscribe(LiveEventsFilter{Role: "role1"})
+	assert.True(t, b.hasSubscribers())
+
+	b.publish(LiveEvent{Type: LiveEventTypeTransfer, Username: "user1", Protocol: ProtocolSFTP, Role: "role1"})
+	b.publish(LiveEvent{Type: LiveEventTypeTransfer, Username: "user2", Protocol: ProtocolSFTP})
+	b.publish(LiveEvent{Type: LiveEventTypeTransfer, Username: "user1", Protocol: ProtocolFTP})
+	b.publish(LiveEvent{Type: LiveEventTypeDefender, IP: "127.0.0.1"})
+	assert.Len(t, all.Events(), 4)
+	assert.Len(t, transfers.Events(), 1)
+	assert.Len(t, role.Events(), 1)
+	ev := <-transfers.Events()
+	assert.Equal(t, "user1", ev.Username)
+	assert.Greater(t, ev.Timestamp, int64(0))
+	ev = <-role.Events()
+	assert.Equal(t, "role1", ev.Role)
+
+	for i := 0; i < liveEventsBufferSize; i++ {
+		b.publish(LiveEvent{Type: LiveEventTypeProvider})
+	}
+	assert.Len(t, all.Events(), liveEventsBufferSize)
+	assert.Equal(t, int64(4), all.Dropped())
+	assert.Equal(t, int64(0), transfers.Dropped())
+
+	b.unsubscribe(all)
+	b.unsubscribe(transfers)
+	b.unsubscribe(role)
+	assert.False(t, b.hasSubscribers())
+}
+
+func TestLiveEventsPublish(t *testing.T) {
+	s := SubscribeLiveEvents(LiveEventsFilter{})
+	defer UnsubscribeLiveEvents(s)
+
+	conn := NewBaseConnection("id", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user",
+			Role:     "role",
+		},
+	})
+	publishTransferLiveEvent(conn, operationUpload, "/file", 100, 10, 1)
+	publishDefenderLiveEvent("127.0.0.1", ProtocolFTP, HostEventLoginFailed, true)
+	publishProviderLiveEvent("add", "admin", "::1", "user", "user", "")
+	if assert.Len(t, s.Events(), 3) {
+		ev := <-s.Events()
+		assert.Equal(t, LiveEventTypeTransfer, ev.Type)
+		assert.Equal(t, operationUpload, ev.Action)
+		assert.Equal(t, "user", ev.Username)
+		assert.Equal(t, "role", ev.Role)
+		assert.Equal(t, "/file", ev.VirtualPath)
+		assert.Equal(t, int64(100), ev.FileSize)
+		ev = <-s.Events()
+		assert.Equal(t, LiveEventTypeDefender, ev.Type)
+		assert.Equal(t, string(HostEventLoginFailed), ev.Action)
+		assert.Equal(t, 2, ev.Status)
+		ev = <-s.Events()
+		assert.Equal(t, LiveEventTypeProvider, ev.Type)
+		assert.Equal(t, "user", ev.ObjectType)
+	}
+}
@@ -1712,6 +1712,128 @@ func TestQuotaRenameOverwrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDirQuota(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.Filters.DirQuotas = []dataprovider.DirQuota{
+		{
+			Path:       "/",
+			QuotaFiles: 2,
+		},
+	}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.DirQuotas = []dataprovider.DirQuota{
+		{
+			Path: "/incoming",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.DirQuotas = []dataprovider.DirQuota{
+		{
+			Path:       "/incoming",
+			QuotaFiles: 2,
+		},
+		{
+			Path:      "incoming/sub/",
+			QuotaSize: 65535,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	if assert.Len(t, user.Filters.DirQuotas, 2) {
+		assert.Equal(t, "/incoming/sub", user.Filters.DirQuotas[1].Path)
+	}
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFileSize := int64(65536)
+		err = client.Mkdir("/incoming")
+		assert.NoError(t, err)
+		err = client.Mkdir("/incoming/sub")
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/incoming", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/incoming", testFileName+"1"), testFileSize, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/incoming", testFileName+"2"), testFileSize, client)
+		assert.Error(t, err)
+		// overwriting an existing file is allowed
+		err = writeSFTPFile(path.Join("/incoming", testFileName+"1"), testFileSize, client)
+		assert.NoError(t, err)
+		// the user quota still applies outside the quota directory
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		stat, err := client.StatVFS("/incoming")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(2), stat.Files)
+			assert.Equal(t, uint64(0), stat.Ffree)
+		}
+		stat, err = client.StatVFS("/")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(100), stat.Files)
+			assert.Equal(t, uint64(97), stat.Ffree)
+		}
+		// renaming a file inside the quota directory is allowed
+		err = client.Rename(path.Join("/incoming", testFileName+"1"), path.Join("/incoming", testFileName+"2"))
+		assert.NoError(t, err)
+		// renaming a file into the quota directory is denied
+		err = client.Rename(testFileName, path.Join("/incoming", testFileName+"3"))
+		assert.Error(t, err)
+		err = client.Remove(path.Join("/incoming", testFileName+"2"))
+		assert.NoError(t, err)
+		err = client.Rename(testFileName, path.Join("/incoming", testFileName+"3"))
+		assert.NoError(t, err)
+		// the nested quota limits the size
+		err = writeSFTPFile(path.Join("/incoming", "sub", testFileName), testFileSize, client)
+		assert.Error(t, err)
+		err = writeSFTPFile(testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName, path.Join("/incoming", "sub", testFileName))
+		assert.Error(t, err)
+
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, user.UsedQuotaFiles)
+		// the used quota is tracked, files added outside SFTPGo are detected after a quota scan
+		err = client.Remove(path.Join("/incoming", testFileName+"3"))
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), "incoming", "external.dat"), []byte("data"), 0666)
+		assert.NoError(t, err)
+		stat, err = client.StatVFS("/incoming")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(1), stat.Ffree)
+		}
+		common.ResetDirQuotaUsages(&user)
+		stat, err = client.StatVFS("/incoming")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(0), stat.Ffree)
+		}
+		err = writeSFTPFile(path.Join("/incoming", testFileName+"4"), testFileSize, client)
+		assert.Error(t, err)
+		err = os.Remove(filepath.Join(user.GetHomeDir(), "incoming", "external.dat"))
+		assert.NoError(t, err)
+		common.ResetDirQuotaUsages(&user)
+		// renaming a file out of the quota directory frees the quota
+		err = writeSFTPFile(path.Join("/incoming", testFileName+"4"), testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(path.Join("/incoming", testFileName+"4"), testFileName+"4")
+		assert.NoError(t, err)
+		stat, err = client.StatVFS("/incoming")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(1), stat.Ffree)
+		}
+	}
+	common.ResetDirQuotaUsages(&user)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestVirtualFoldersQuotaValues(t *testing.T) {
 	u := getTestUser()
 	u.QuotaFiles = 100
@@ -6447,6 +6569,106 @@ func TestEventRuleFirstUploadDownloadActions(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEventRuleQuotaThreshold(t *testing.T) {
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          2525,
+		From:          "notify@example.com",
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeEmail,
+		Options: dataprovider.BaseEventActionOptions{
+			EmailConfig: dataprovider.EventActionEmailConfig{
+				Recipients: []string{"test@example.com"},
+				Subject:    `"{{Event}}" from "{{Name}}"`,
+				Body:       "Virtual path {{VirtualPath}}, metadata: {{Metadata}}",
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test quota threshold rule",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"quota-threshold"},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	u := getTestUser()
+	u.QuotaSize = 100000
+	u.Filters.QuotaWarnThresholds = []int{90, 50, 50}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{50, 90}, user.Filters.QuotaWarnThresholds)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		lastReceivedEmail.reset()
+		err = writeSFTPFileNoCheck(testFileName, 40000, client)
+		assert.NoError(t, err)
+		assert.Never(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1000*time.Millisecond, 100*time.Millisecond)
+		err = writeSFTPFileNoCheck(testFileName+"_1", 20000, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		email := lastReceivedEmail.get()
+		assert.Len(t, email.To, 1)
+		assert.Contains(t, email.Data, fmt.Sprintf(`Subject: "quota-threshold" from "%s"`, user.Username))
+		assert.Contains(t, email.Data, `"quota_threshold":"50"`)
+		assert.Contains(t, email.Data, `"quota_type":"size"`)
+		// the threshold is already crossed, a new upload below the next one will not produce a new notification
+		lastReceivedEmail.reset()
+		err = writeSFTPFileNoCheck(testFileName+"_2", 20000, client)
+		assert.NoError(t, err)
+		assert.Never(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1000*time.Millisecond, 100*time.Millisecond)
+		err = writeSFTPFileNoCheck(testFileName+"_3", 15000, client)
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return lastReceivedEmail.get().From != ""
+		}, 1500*time.Millisecond, 100*time.Millisecond)
+		assert.Contains(t, lastReceivedEmail.get().Data, `"quota_threshold":"90"`)
+	}
+	u.Filters.QuotaWarnThresholds = []int{100}
+	_, _, err = httpdtest.UpdateUser(u, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize(configDir, true)
+	require.NoError(t, err)
+}
+
 func TestEventRuleRenameEvent(t *testing.T) {
 	smtpCfg := smtp.Config{
 		Host:          "127.0.0.1",
@@ -7695,6 +7917,108 @@ func TestSyncUploadAction(t *testing.T) {
 	common.Config.Actions.Hook = uploadScriptPath
 }
 
+func TestIncrementalQuotaScan(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "mapped")
+	folderName := filepath.Base(mappedPath)
+	vdirPath := "/vdir"
+	f := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: mappedPath,
+	}
+	folder, _, err := httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name: folder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaSize:   -1,
+		QuotaFiles:  -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	checkScan := func(expectedFiles int, expectedSize int64) {
+		numFiles, size, err := user.ScanQuota(true)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedFiles, numFiles)
+		assert.Equal(t, expectedSize, size)
+		numFiles, size, err = user.ScanQuota(false)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedFiles, numFiles)
+		assert.Equal(t, expectedSize, size)
+	}
+
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFileSize := int64(1024)
+		err = client.MkdirAll("/dir1/sub1/sub2")
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/dir1", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/dir1/sub1/sub2", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join(vdirPath, testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		// the first incremental scan is a full scan
+		checkScan(3, 3*testFileSize)
+
+		err = writeSFTPFile(path.Join("/dir1/sub1", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("/dir2")
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join("/dir2", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = client.MkdirAll(path.Join(vdirPath, "sub"))
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join(vdirPath, "sub", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		checkScan(6, 6*testFileSize)
+
+		err = client.Rename("/dir1/sub1", "/dir2/sub1")
+		assert.NoError(t, err)
+		err = client.Remove(path.Join("/dir1", testFileName))
+		assert.NoError(t, err)
+		err = client.Rename(path.Join(vdirPath, "sub", testFileName), path.Join(vdirPath, testFileName+".1"))
+		assert.NoError(t, err)
+		checkScan(5, 5*testFileSize)
+
+		err = client.Remove(path.Join("/dir2/sub1/sub2", testFileName))
+		assert.NoError(t, err)
+		err = client.RemoveDirectory("/dir2/sub1/sub2")
+		assert.NoError(t, err)
+		err = client.Truncate(path.Join("/dir2", testFileName), 100)
+		assert.NoError(t, err)
+		checkScan(4, 3*testFileSize+100)
+		// changes made outside SFTPGo are only detected by full scans
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), "dir2", "external.dat"), []byte("data"), 0666)
+		assert.NoError(t, err)
+		numFiles, size, err := user.ScanQuota(true)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, numFiles)
+		assert.Equal(t, 3*testFileSize+100, size)
+		numFiles, size, err = user.ScanQuota(false)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, numFiles)
+		assert.Equal(t, 3*testFileSize+104, size)
+		// the full scan updated the saved results
+		checkScan(5, 3*testFileSize+104)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestQuotaTrackDisabled(t *testing.T) {
 	err := dataprovider.Close()
 	assert.NoError(t, err)
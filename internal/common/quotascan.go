@@ -0,0 +1,66 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const quotaScanCleanupInterval = 1 * time.Hour
+
+// recordQuotaScanChange records the paths changed by a filesystem operation so
+// the next incremental quota scan includes them. Failed operations are recorded
+// too, they could have partially modified the filesystem
+func recordQuotaScanChange(conn *BaseConnection, operation, virtualPath, virtualTarget string) {
+	if !vfs.HasIncrementalQuotaScans() {
+		return
+	}
+	switch operation {
+	case operationUpload, operationDelete:
+		conn.addQuotaScanChange(path.Dir(virtualPath), false)
+	case operationCopy:
+		conn.addQuotaScanChange(virtualTarget, true)
+		conn.addQuotaScanChange(path.Dir(virtualTarget), false)
+	case operationMkdir, operationRmdir:
+		conn.addQuotaScanChange(virtualPath, true)
+	case operationRename:
+		conn.addQuotaScanChange(virtualPath, true)
+		conn.addQuotaScanChange(path.Dir(virtualPath), false)
+		conn.addQuotaScanChange(virtualTarget, true)
+		conn.addQuotaScanChange(path.Dir(virtualTarget), false)
+	case OperationSSHCmd:
+		// commands such as rsync can modify any file
+		vfs.InvalidateQuotaScan(vfs.UserQuotaScanKey(conn.User.Username))
+		for idx := range conn.User.VirtualFolders {
+			vfs.InvalidateQuotaScan(vfs.FolderQuotaScanKey(conn.User.VirtualFolders[idx].Name))
+		}
+	}
+}
+
+// addQuotaScanChange records a change for the filesystem, the user's root one
+// or a virtual folder, containing the specified virtual path
+func (c *BaseConnection) addQuotaScanChange(virtualPath string, isTree bool) {
+	if folder, err := c.User.GetVirtualFolderForPath(virtualPath); err == nil {
+		relPath := util.CleanPath(strings.TrimPrefix(virtualPath, folder.VirtualPath))
+		vfs.RecordQuotaScanChange(vfs.FolderQuotaScanKey(folder.Name), relPath, isTree)
+		return
+	}
+	vfs.RecordQuotaScanChange(vfs.UserQuotaScanKey(c.User.Username), virtualPath, isTree)
+}
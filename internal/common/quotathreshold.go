@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"strconv"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	quotaThresholdScopeUser   = "user"
+	quotaThresholdScopeFolder = "folder"
+)
+
+// checkUserQuotaThresholds triggers a quota threshold event if the user quota
+// update, already applied, crossed one of the configured warning thresholds
+func (t *BaseTransfer) checkUserQuotaThresholds(numFiles int, sizeDiff, fileSize int64) {
+	user := &t.Connection.User
+	if len(user.Filters.QuotaWarnThresholds) == 0 || !user.HasQuotaRestrictions() {
+		return
+	}
+	usedFiles, usedSize, _, _, err := dataprovider.GetUsedQuota(user.Username)
+	if err != nil {
+		t.Connection.Log(logger.LevelError, "unable to get used quota to check warning thresholds: %v", err)
+		return
+	}
+	t.notifyCrossedQuotaThresholds(map[string]string{"quota_scope": quotaThresholdScopeUser},
+		int64(user.QuotaFiles), int64(usedFiles), int64(numFiles),
+		user.QuotaSize, usedSize, sizeDiff, fileSize)
+}
+
+// checkFolderQuotaThresholds triggers a quota threshold event if the quota
+// update, already applied, for a virtual folder with its own quota crossed one
+// of the configured warning thresholds
+func (t *BaseTransfer) checkFolderQuotaThresholds(folder *vfs.VirtualFolder, numFiles int, sizeDiff, fileSize int64) {
+	if len(t.Connection.User.Filters.QuotaWarnThresholds) == 0 || folder.HasNoQuotaRestrictions(true) {
+		return
+	}
+	usedFiles, usedSize, err := dataprovider.GetUsedVirtualFolderQuota(folder.Name)
+	if err != nil {
+		t.Connection.Log(logger.LevelError, "unable to get used quota for folder %q to check warning thresholds: %v",
+			folder.Name, err)
+		return
+	}
+	t.notifyCrossedQuotaThresholds(map[string]string{
+		"quota_scope":  quotaThresholdScopeFolder,
+		"quota_folder": folder.Name,
+	}, int64(folder.QuotaFiles), int64(usedFiles), int64(numFiles), folder.QuotaSize, usedSize, sizeDiff, fileSize)
+}
+
+// notifyCrossedQuotaThresholds checks the size and the files thresholds.
+// The usage before the update is computed from the current one so no state is
+// required, concurrent uploads could rarely cause a missing or duplicate event
+func (t *BaseTransfer) notifyCrossedQuotaThresholds(metadata map[string]string, quotaFiles, usedFiles, numFiles,
+	quotaSize, usedSize, sizeDiff, fileSize int64,
+) {
+	user := &t.Connection.User
+	if threshold := user.GetCrossedQuotaThreshold(quotaSize, usedSize-sizeDiff, usedSize); threshold > 0 {
+		t.notifyQuotaThreshold(metadata, "size", threshold, quotaSize, usedSize, fileSize)
+	}
+	if threshold := user.GetCrossedQuotaThreshold(quotaFiles, usedFiles-numFiles, usedFiles); threshold > 0 {
+		t.notifyQuotaThreshold(metadata, "files", threshold, quotaFiles, usedFiles, fileSize)
+	}
+}
+
+func (t *BaseTransfer) notifyQuotaThreshold(baseMetadata map[string]string, quotaType string, threshold int,
+	quota, used, fileSize int64,
+) {
+	metadata := make(map[string]string, len(baseMetadata)+4)
+	for k, v := range baseMetadata {
+		metadata[k] = v
+	}
+	metadata["quota_type"] = quotaType
+	metadata["quota_threshold"] = strconv.Itoa(threshold)
+	metadata["quota_limit"] = strconv.FormatInt(quota, 10)
+	metadata["quota_used"] = strconv.FormatInt(used, 10)
+
+	t.Connection.Log(logger.LevelInfo, "quota warning threshold %d%% crossed, type: %s, scope: %s, used: %d/%d",
+		threshold, quotaType, metadata["quota_scope"], used, quota)
+	ExecuteActionNotification(t.Connection, operationQuotaThreshold, t.fsPath, t.requestPath, "", //nolint:errcheck
+		"", "", fileSize, nil, 0, metadata)
+}
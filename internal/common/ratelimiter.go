@@ -30,7 +30,7 @@ import (
 var (
 	errNoBucket               = errors.New("no bucket found")
 	errReserve                = errors.New("unable to reserve token")
-	rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP}
+	rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP, ProtocolS3}
 )
 
 // RateLimiterType defines the supported rate limiters types
@@ -40,6 +40,7 @@ type RateLimiterType int
 const (
 	rateLimiterTypeGlobal RateLimiterType = iota + 1
 	rateLimiterTypeSource
+	rateLimiterTypeIdentity
 )
 
 // RateLimiterConfig defines the configuration for a rate limiter
@@ -56,6 +57,9 @@ type RateLimiterConfig struct {
 	// Type defines the rate limiter type:
 	// - rateLimiterTypeGlobal is a global rate limiter independent from the source
 	// - rateLimiterTypeSource is a per-source rate limiter
+	// - rateLimiterTypeIdentity is a per-identity rate limiter, the identity is the
+	//   API key, the admin or the user authenticated for a REST API request.
+	//   This type is supported for the HTTP protocol only
 	Type int `json:"type" mapstructure:"type"`
 	// Protocols defines the protocols for this rate limiter.
 	// Available protocols are: "SFTP", "FTP", "DAV".
@@ -81,7 +85,7 @@ func (r *RateLimiterConfig) validate() error {
 	if r.Period < 100 {
 		return fmt.Errorf("invalid period %v. It must be >= 100", r.Period)
 	}
-	if r.Type != int(rateLimiterTypeGlobal) && r.Type != int(rateLimiterTypeSource) {
+	if r.Type < int(rateLimiterTypeGlobal) || r.Type > int(rateLimiterTypeIdentity) {
 		return fmt.Errorf("invalid type %v", r.Type)
 	}
 	if r.Type != int(rateLimiterTypeGlobal) {
@@ -97,12 +101,16 @@ func (r *RateLimiterConfig) validate() error {
 		if !util.Contains(rateLimiterProtocolValues, protocol) {
 			return fmt.Errorf("invalid protocol %q", protocol)
 		}
+		if r.Type == int(rateLimiterTypeIdentity) && protocol != ProtocolHTTP {
+			return fmt.Errorf("protocol %q is not supported for per-identity rate limiters", protocol)
+		}
 	}
 	return nil
 }
 
 func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 	limiter := &rateLimiter{
+		limiterType:            RateLimiterType(r.Type),
 		burst:                  r.Burst,
 		globalBucket:           nil,
 		generateDefenderEvents: r.GenerateDefenderEvents,
@@ -125,7 +133,7 @@ func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 		hardLimit: r.EntriesHardLimit,
 		softLimit: r.EntriesSoftLimit,
 	}
-	if r.Type != int(rateLimiterTypeSource) {
+	if r.Type == int(rateLimiterTypeGlobal) {
 		limiter.globalBucket = rate.NewLimiter(limiter.rate, limiter.burst)
 	}
 	return limiter
@@ -133,6 +141,7 @@ func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 
 // RateLimiter defines a rate limiter
 type rateLimiter struct {
+	limiterType            RateLimiterType
 	rate                   rate.Limit
 	burst                  int
 	maxDelay               time.Duration
@@ -141,9 +150,13 @@ type rateLimiter struct {
 	generateDefenderEvents bool
 }
 
+func (rl *rateLimiter) isPerIdentity() bool {
+	return rl.limiterType == rateLimiterTypeIdentity
+}
+
 // Wait blocks until the limit allows one event to happen
 // or returns an error if the time to wait exceeds the max
-// allowed delay
+// allowed delay. For per-identity limiters source is the identity
 func (rl *rateLimiter) Wait(source, protocol string) (time.Duration, error) {
 	var res *rate.Reservation
 	if rl.globalBucket != nil {
@@ -162,7 +175,7 @@ func (rl *rateLimiter) Wait(source, protocol string) (time.Duration, error) {
 	delay := res.Delay()
 	if delay > rl.maxDelay {
 		res.Cancel()
-		if rl.generateDefenderEvents && rl.globalBucket == nil {
+		if rl.generateDefenderEvents && rl.limiterType == rateLimiterTypeSource {
 			AddDefenderEvent(source, protocol, HostEventLimitExceeded)
 		}
 		return delay, fmt.Errorf("rate limit exceed, wait time to respect rate %v, max wait time allowed %v", delay, rl.maxDelay)
@@ -49,8 +49,19 @@ func TestRateLimiterConfig(t *testing.T) {
 	config.Protocols = rateLimiterProtocolValues
 	err = config.validate()
 	require.NoError(t, err)
-
+	config.Type = int(rateLimiterTypeIdentity)
+	err = config.validate()
+	require.Error(t, err)
+	config.Protocols = []string{ProtocolHTTP}
+	err = config.validate()
+	require.NoError(t, err)
 	limiter := config.getLimiter()
+	require.True(t, limiter.isPerIdentity())
+	require.Nil(t, limiter.globalBucket)
+	config.Type = int(rateLimiterTypeSource)
+	config.Protocols = rateLimiterProtocolValues
+
+	limiter = config.getLimiter()
 	require.Equal(t, 500*time.Millisecond, limiter.maxDelay)
 	require.Nil(t, limiter.globalBucket)
 	config.Type = int(rateLimiterTypeGlobal)
@@ -0,0 +1,537 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/xid"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/drakkan/sftpgo/v2/internal/command"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	searchContentQueueSize = 4096
+	searchContentWorkers   = 2
+	searchSnippetLength    = 160
+	// limit the uncompressed size read from office documents
+	maxOfficeXMLSize = 64 * 1048576
+)
+
+var (
+	errSearchContentDisabled = errors.New("content search is not enabled")
+	officeExtensions         = []string{".docx", ".pptx", ".xlsx", ".odt", ".odp", ".ods"}
+	// XML elements, inside office documents, that end a block of text
+	officeBlockElements = []string{"p", "br", "tab", "si", "h", "tr"}
+)
+
+// SearchContentConfig defines the configuration to extract and index the text
+// contents of the uploaded documents so they can be searched
+type SearchContentConfig struct {
+	// Set to true to index the documents contents
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Files bigger than this size, in bytes, are not indexed
+	MaxFileSize int64 `json:"max_file_size" mapstructure:"max_file_size"`
+	// Maximum size, in bytes, of the text extracted for each file.
+	// Any additional text is not indexed
+	MaxTextSize int `json:"max_text_size" mapstructure:"max_text_size"`
+	// Extensions for the files to index as plain text
+	TextExtensions []string `json:"text_extensions" mapstructure:"text_extensions"`
+	// Set to true to use the built-in extractor for Office Open XML and
+	// OpenDocument files: docx, pptx, xlsx, odt, odp, ods
+	OfficeDocuments bool `json:"office_documents" mapstructure:"office_documents"`
+	// External programs to extract the text from other formats, for example PDF.
+	// They take precedence over the built-in extractors
+	Extractors []ContentExtractorConfig `json:"extractors" mapstructure:"extractors"`
+}
+
+// ContentExtractorConfig defines an external program to extract the text from
+// documents. The file contents are written to the program standard input and
+// the text is read from its standard output. Timeout, environment variables
+// and arguments can be defined in the commands configuration using the
+// "content_extractor" hook name
+type ContentExtractorConfig struct {
+	// Absolute path to the program
+	Hook string `json:"hook" mapstructure:"hook"`
+	// File extensions handled by this program, for example ".pdf"
+	Extensions []string `json:"extensions" mapstructure:"extensions"`
+}
+
+func normalizeSearchExtensions(extensions []string) []string {
+	var result []string
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		result = append(result, ext)
+	}
+	return util.RemoveDuplicates(result, false)
+}
+
+func (c *SearchContentConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxFileSize <= 0 {
+		return fmt.Errorf("search index: invalid max file size %d", c.MaxFileSize)
+	}
+	if c.MaxTextSize <= 0 {
+		return fmt.Errorf("search index: invalid max text size %d", c.MaxTextSize)
+	}
+	c.TextExtensions = normalizeSearchExtensions(c.TextExtensions)
+	for idx := range c.Extractors {
+		extractor := &c.Extractors[idx]
+		if !filepath.IsAbs(extractor.Hook) {
+			return fmt.Errorf("search index: invalid content extractor %q, it must be an absolute path", extractor.Hook)
+		}
+		extractor.Extensions = normalizeSearchExtensions(extractor.Extensions)
+		if len(extractor.Extensions) == 0 {
+			return fmt.Errorf("search index: no extensions defined for the content extractor %q", extractor.Hook)
+		}
+	}
+	return nil
+}
+
+func (c *SearchContentConfig) getExtractor(virtualPath string) contentExtractor {
+	if !c.Enabled {
+		return nil
+	}
+	ext := strings.ToLower(path.Ext(virtualPath))
+	if ext == "" {
+		return nil
+	}
+	for idx := range c.Extractors {
+		if util.Contains(c.Extractors[idx].Extensions, ext) {
+			return &commandContentExtractor{hook: c.Extractors[idx].Hook}
+		}
+	}
+	if c.OfficeDocuments && util.Contains(officeExtensions, ext) {
+		return &officeContentExtractor{}
+	}
+	if util.Contains(c.TextExtensions, ext) {
+		return &textContentExtractor{}
+	}
+	return nil
+}
+
+type contentExtractor interface {
+	extract(r io.Reader, username, virtualPath string, maxTextSize int) (string, error)
+}
+
+type textContentExtractor struct{}
+
+func (e *textContentExtractor) extract(r io.Reader, _, _ string, maxTextSize int) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxTextSize)))
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", errors.New("binary content")
+	}
+	return strings.ToValidUTF8(string(data), ""), nil
+}
+
+type officeContentExtractor struct{}
+
+func (e *officeContentExtractor) getXMLFiles(zr *zip.Reader, ext string) []*zip.File {
+	var result []*zip.File
+	for _, f := range zr.File {
+		switch ext {
+		case ".docx":
+			if f.Name == "word/document.xml" {
+				result = append(result, f)
+			}
+		case ".pptx":
+			if strings.HasPrefix(f.Name, "ppt/slides/slide") && strings.HasSuffix(f.Name, ".xml") {
+				result = append(result, f)
+			}
+		case ".xlsx":
+			if f.Name == "xl/sharedStrings.xml" {
+				result = append(result, f)
+			}
+		default:
+			if f.Name == "content.xml" {
+				result = append(result, f)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].Name) == len(result[j].Name) {
+			return result[i].Name < result[j].Name
+		}
+		return len(result[i].Name) < len(result[j].Name)
+	})
+	return result
+}
+
+func (e *officeContentExtractor) extract(r io.Reader, _, virtualPath string, maxTextSize int) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, f := range e.getXMLFiles(zr, strings.ToLower(path.Ext(virtualPath))) {
+		if err := e.extractXMLText(f, &sb, maxTextSize); err != nil {
+			return "", err
+		}
+		if sb.Len() >= maxTextSize {
+			break
+		}
+	}
+	return truncateText(sb.String(), maxTextSize), nil
+}
+
+func (e *officeContentExtractor) extractXMLText(f *zip.File, sb *strings.Builder, maxTextSize int) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxOfficeXMLSize))
+	for sb.Len() < maxTextSize {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.EndElement:
+			if util.Contains(officeBlockElements, t.Name.Local) {
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return nil
+}
+
+type commandContentExtractor struct {
+	hook string
+}
+
+func (e *commandContentExtractor) extract(r io.Reader, username, virtualPath string, maxTextSize int) (string, error) {
+	timeout, env, args := command.GetConfig(e.hook, command.HookContentExtractor)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.hook, args...)
+	cmd.Env = append(env,
+		fmt.Sprintf("SFTPGO_SEARCH_USERNAME=%s", username),
+		fmt.Sprintf("SFTPGO_SEARCH_VIRTUAL_PATH=%s", virtualPath))
+	cmd.Stdin = r
+	out := &limitedBuffer{limit: maxTextSize}
+	cmd.Stdout = out
+
+	startTime := time.Now()
+	err := cmd.Run()
+	logger.Debug(logSender, "", "executed content extractor %q for path %q, elapsed: %s, error: %v",
+		e.hook, virtualPath, time.Since(startTime), err)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(out.String(), ""), nil
+}
+
+// limitedBuffer stores up to limit bytes and discards the remaining ones.
+// The buffer is not embedded, io.Copy would bypass Write using ReadFrom
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.buf.Write(p[:remaining])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// truncateText truncates the text to the specified size, in bytes,
+// without breaking multibyte characters
+func truncateText(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
+
+// searchIndexContent defines the text extracted from a file. Size and
+// modification time are used to detect if the file changed
+type searchIndexContent struct {
+	Size    int64  `json:"s,omitempty"`
+	ModTime int64  `json:"m,omitempty"`
+	Text    string `json:"t,omitempty"`
+}
+
+func (c *searchIndexContent) isStale(entry *searchIndexEntry) bool {
+	return c.Size != entry.Size || c.ModTime != entry.ModTime
+}
+
+type searchContentJob struct {
+	username    string
+	virtualPath string
+}
+
+func (m *searchIndexManager) getContentConfig() SearchContentConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.content
+}
+
+func (m *searchIndexManager) isContentEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.contentQueue != nil
+}
+
+func (m *searchIndexManager) addContentJob(username, virtualPath string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.contentQueue == nil || m.content.getExtractor(virtualPath) == nil {
+		return
+	}
+	select {
+	case m.contentQueue <- searchContentJob{username: username, virtualPath: virtualPath}:
+	default:
+		logger.Warn(logSender, "", "search content queue is full, unable to index %q for user %q", virtualPath, username)
+	}
+}
+
+func (m *searchIndexManager) processContentJobs(queue chan searchContentJob) {
+	defer m.contentWg.Done()
+
+	for job := range queue {
+		user, err := dataprovider.GetUserWithGroupSettings(job.username, "")
+		if err != nil {
+			logger.Warn(logSender, "", "search index: unable to get user %q: %v", job.username, err)
+			continue
+		}
+		if err := m.indexContent(&user, job.virtualPath); err != nil {
+			logger.Warn(logSender, "", "search index: unable to index the contents of %q for user %q: %v",
+				job.virtualPath, job.username, err)
+		}
+		user.CloseFs() //nolint:errcheck
+	}
+}
+
+// indexContent extracts and stores the text for the specified file. Files that
+// cannot be parsed are stored without text so they are not processed again
+// until they change
+func (m *searchIndexManager) indexContent(user *dataprovider.User, virtualPath string) error {
+	db := m.getDB()
+	if db == nil {
+		return errSearchIndexDisabled
+	}
+	config := m.getContentConfig()
+	extractor := config.getExtractor(virtualPath)
+	if extractor == nil {
+		return nil
+	}
+	fs, err := user.GetFilesystemForPath(virtualPath, xid.New().String())
+	if err != nil {
+		return err
+	}
+	fsPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	entry := newSearchIndexEntry(info)
+	content := searchIndexContent{
+		Size:    entry.Size,
+		ModTime: entry.ModTime,
+	}
+	if info.Size() <= config.MaxFileSize {
+		text, err := extractContent(extractor, fs, fsPath, user.Username, virtualPath, config.MaxTextSize)
+		if err != nil {
+			logger.Debug(logSender, "", "search index: unable to extract the text from %q for user %q: %v",
+				virtualPath, user.Username, err)
+		}
+		content.Text = text
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		indexUser, err := getSearchIndexUser(tx.Bucket(searchIndexUsersBucket), user.Username)
+		if err != nil || indexUser == nil || indexUser.ContentBucket == "" {
+			return err
+		}
+		bucket := tx.Bucket([]byte(indexUser.ContentBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Put([]byte(virtualPath), data)
+	})
+}
+
+func extractContent(extractor contentExtractor, fs vfs.Fs, fsPath, username, virtualPath string,
+	maxTextSize int,
+) (string, error) {
+	f, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return "", err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.ReadCloser = r
+	if f != nil {
+		reader = f
+	}
+	defer reader.Close()
+
+	text, err := extractor.extract(reader, username, virtualPath, maxTextSize)
+	if err != nil {
+		return "", err
+	}
+	return truncateText(text, maxTextSize), nil
+}
+
+// pruneContentBucket removes the contents for the files no longer included in
+// the index or modified and returns the paths of the files to index
+func pruneContentBucket(bucket, contentBucket *bolt.Bucket, config *SearchContentConfig) ([]string, error) {
+	var toDelete [][]byte
+	err := contentBucket.ForEach(func(k, v []byte) error {
+		var content searchIndexContent
+		var entry searchIndexEntry
+		data := bucket.Get(k)
+		if data == nil || json.Unmarshal(v, &content) != nil || json.Unmarshal(data, &entry) != nil ||
+			content.isStale(&entry) {
+			toDelete = append(toDelete, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range toDelete {
+		if err := contentBucket.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	var toIndex []string
+	err = bucket.ForEach(func(k, v []byte) error {
+		var entry searchIndexEntry
+		if err := json.Unmarshal(v, &entry); err != nil || entry.IsDir {
+			return nil
+		}
+		if contentBucket.Get(k) == nil && config.getExtractor(string(k)) != nil {
+			toIndex = append(toIndex, string(k))
+		}
+		return nil
+	})
+	return toIndex, err
+}
+
+// matchesContent returns true if the text contains all the specified terms.
+// The returned snippet shows the text around the first term
+func matchesContent(text string, terms []string) (bool, string) {
+	if len(terms) == 0 {
+		return true, ""
+	}
+	lowerText := strings.ToLower(text)
+	for _, term := range terms {
+		if !strings.Contains(lowerText, term) {
+			return false, ""
+		}
+	}
+	// the lowercase text could have a different length for some characters,
+	// in this case the snippet is extracted from the lowercase text
+	if len(lowerText) != len(text) {
+		text = lowerText
+	}
+	return true, getContentSnippet(text, strings.Index(lowerText, terms[0]))
+}
+
+func getContentSnippet(text string, idx int) string {
+	start := idx - searchSnippetLength/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + searchSnippetLength
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	snippet := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSearchContentConfig(t *testing.T) {
+	c := SearchContentConfig{
+		Enabled:         true,
+		MaxFileSize:     100,
+		MaxTextSize:     100,
+		TextExtensions:  []string{"TXT", ".md"},
+		OfficeDocuments: true,
+		Extractors: []ContentExtractorConfig{
+			{
+				Hook:       filepath.Join(t.TempDir(), "extractor"),
+				Extensions: []string{"pdf", ".TXT"},
+			},
+		},
+	}
+	require.NoError(t, c.validate())
+	assert.Equal(t, []string{".txt", ".md"}, c.TextExtensions)
+	assert.Equal(t, []string{".pdf", ".txt"}, c.Extractors[0].Extensions)
+	// external extractors take precedence
+	assert.IsType(t, &commandContentExtractor{}, c.getExtractor("/a/file.TXT"))
+	assert.IsType(t, &commandContentExtractor{}, c.getExtractor("/file.pdf"))
+	assert.IsType(t, &officeContentExtractor{}, c.getExtractor("/file.docx"))
+	assert.IsType(t, &textContentExtractor{}, c.getExtractor("/file.md"))
+	assert.Nil(t, c.getExtractor("/file.png"))
+	assert.Nil(t, c.getExtractor("/file"))
+	c.OfficeDocuments = false
+	assert.Nil(t, c.getExtractor("/file.docx"))
+	c.Enabled = false
+	assert.Nil(t, c.getExtractor("/file.md"))
+
+	for _, c := range []SearchContentConfig{
+		{Enabled: true, MaxTextSize: 10},
+		{Enabled: true, MaxFileSize: 10},
+		{Enabled: true, MaxFileSize: 10, MaxTextSize: 10, Extractors: []ContentExtractorConfig{{Hook: "relative"}}},
+		{Enabled: true, MaxFileSize: 10, MaxTextSize: 10, Extractors: []ContentExtractorConfig{
+			{Hook: filepath.Join(t.TempDir(), "extractor")},
+		}},
+	} {
+		assert.Error(t, c.validate(), "config %+v", c)
+	}
+}
+
+func TestContentExtractors(t *testing.T) {
+	text, err := (&textContentExtractor{}).extract(strings.NewReader("hello world"), "", "", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", text)
+	_, err = (&textContentExtractor{}).extract(bytes.NewReader([]byte{'a', 0, 'b'}), "", "", 5)
+	assert.Error(t, err)
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	f, err := zw.Create("word/document.xml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`<w:document><w:body><w:p><w:r><w:t>First paragraph</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>Second</w:t></w:r></w:p></w:body></w:document>`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	text, err = (&officeContentExtractor{}).extract(bytes.NewReader(buf.Bytes()), "", "/doc.docx", 1000)
+	assert.NoError(t, err)
+	assert.Contains(t, text, "First paragraph")
+	assert.Contains(t, text, "Second")
+	assert.NotContains(t, text, "paragraphSecond")
+	_, err = (&officeContentExtractor{}).extract(strings.NewReader("not a zip file"), "", "/doc.docx", 1000)
+	assert.Error(t, err)
+
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	text, err = (&commandContentExtractor{hook: "/bin/cat"}).extract(strings.NewReader("external text"), "user",
+		"/file.pdf", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, "external", text)
+}
+
+func TestContentMatches(t *testing.T) {
+	assert.Equal(t, "abc", truncateText("abc", 10))
+	assert.Equal(t, "ab", truncateText("abc", 2))
+	assert.Equal(t, "a", truncateText("aè", 2))
+
+	text := strings.Repeat("x ", 100) + "The Quick brown fox" + strings.Repeat(" y", 100)
+	ok, snippet := matchesContent(text, []string{"quick", "fox"})
+	assert.True(t, ok)
+	assert.Contains(t, snippet, "The Quick brown fox")
+	assert.True(t, strings.HasPrefix(snippet, "…"))
+	assert.True(t, strings.HasSuffix(snippet, "…"))
+	ok, _ = matchesContent(text, []string{"quick", "dog"})
+	assert.False(t, ok)
+	ok, snippet = matchesContent("short text", []string{"text"})
+	assert.True(t, ok)
+	assert.Equal(t, "short text", snippet)
+}
+
+func TestSearchContentEvents(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "index.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	username := "user"
+	err = db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket(searchIndexUsersBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket([]byte("idx")); err != nil {
+			return err
+		}
+		contentBucket, err := tx.CreateBucket([]byte("cnt"))
+		if err != nil {
+			return err
+		}
+		for _, p := range []string{"/a/file1.txt", "/a/b/file2.txt", "/c.txt"} {
+			if err := contentBucket.Put([]byte(p), []byte(`{"t":"text"}`)); err != nil {
+				return err
+			}
+		}
+		return users.Put([]byte(username), []byte(`{"bucket":"idx","fs_key":"key","content_bucket":"cnt"}`))
+	})
+	require.NoError(t, err)
+
+	apply := func(ev searchIndexEvent) {
+		ev.username = username
+		err := db.Update(func(tx *bolt.Tx) error {
+			return applySearchIndexEvent(tx, &ev)
+		})
+		assert.NoError(t, err)
+	}
+	getKeys := func() []string {
+		var keys []string
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("cnt")).ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	// renames move the contents without adding the parent directories
+	apply(searchIndexEvent{action: searchIndexActionRename, path: "/a", target: "/d/e"})
+	assert.Equal(t, []string{"/c.txt", "/d/e/b/file2.txt", "/d/e/file1.txt"}, getKeys())
+	apply(searchIndexEvent{action: searchIndexActionRemove, path: "/d/e/b"})
+	assert.Equal(t, []string{"/c.txt", "/d/e/file1.txt"}, getKeys())
+}
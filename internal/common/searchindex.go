@@ -0,0 +1,1100 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported search types
+const (
+	SearchTypeFile = "file"
+	SearchTypeDir  = "dir"
+)
+
+const (
+	searchIndexCleanupInterval = 1 * time.Hour
+	searchIndexQueueSize       = 8192
+	searchIndexEventsBatchSize = 512
+	searchIndexBuildBatchSize  = 2000
+	defaultSearchResults       = 100
+	maxSearchResults           = 1000
+)
+
+const (
+	searchIndexActionAdd = iota
+	searchIndexActionRemove
+	searchIndexActionRename
+	searchIndexActionInvalidate
+)
+
+var (
+	// ErrSearchIndexNotReady is returned if the search index for a user
+	// is not available yet, the search can be retried later
+	ErrSearchIndexNotReady = errors.New("the search index is being built, please retry later")
+	errSearchIndexDisabled = errors.New("the search index is not enabled")
+	searchIndexUsersBucket = []byte("users")
+	searchIndex            = &searchIndexManager{}
+)
+
+// SearchIndexConfig defines the configuration for the embedded index used
+// to search files and directories by name and attributes
+type SearchIndexConfig struct {
+	// Set to true to enable the search index
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to the index database file. A relative path is resolved against
+	// the configuration directory
+	Path string `json:"path" mapstructure:"path"`
+	// Interval, in hours, to rebuild the index for a user from scratch. The
+	// index is updated from the filesystem events generated by SFTPGo, a
+	// periodic rebuild includes the changes made outside SFTPGo or from other
+	// cluster nodes. 0 means no periodic rebuild
+	RebuildInterval int `json:"rebuild_interval" mapstructure:"rebuild_interval"`
+	// Documents content indexing
+	Content SearchContentConfig `json:"content" mapstructure:"content"`
+}
+
+func (c *SearchIndexConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("search index: invalid path %q, it must be an absolute path", c.Path)
+	}
+	if c.RebuildInterval < 0 {
+		return fmt.Errorf("search index: invalid rebuild interval %d", c.RebuildInterval)
+	}
+	return c.Content.validate()
+}
+
+// SearchQuery defines the criteria to search files and directories.
+// Empty criteria are ignored
+type SearchQuery struct {
+	// Case insensitive name to search, a substring match is performed unless
+	// the name contains shell wildcards
+	Name string
+	// Limit the search to this directory and its sub directories
+	Path string
+	// Limit the search to files with these extensions
+	Extensions []string
+	// Limit the search to files or directories
+	Type string
+	// Size limits, in bytes
+	MinSize int64
+	MaxSize int64
+	// Modification time limits as unix timestamp in milliseconds
+	ModifiedAfter  int64
+	ModifiedBefore int64
+	// Case insensitive text to search inside the indexed documents. The
+	// documents must contain all the specified words
+	Content string
+	// Maximum number of results
+	Limit int
+}
+
+func (q *SearchQuery) validate() error {
+	q.Name = strings.ToLower(strings.TrimSpace(q.Name))
+	q.Content = strings.Join(strings.Fields(strings.ToLower(q.Content)), " ")
+	if q.isPattern() {
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid name pattern %q", q.Name))
+		}
+	}
+	q.Path = util.CleanPath(q.Path)
+	q.Extensions = normalizeSearchExtensions(q.Extensions)
+	if q.Type != "" && q.Type != SearchTypeFile && q.Type != SearchTypeDir {
+		return util.NewValidationError(fmt.Sprintf("invalid search type %q", q.Type))
+	}
+	if q.MinSize < 0 || q.MaxSize < 0 || (q.MaxSize > 0 && q.MinSize > q.MaxSize) {
+		return util.NewValidationError("invalid size range")
+	}
+	if q.ModifiedAfter < 0 || q.ModifiedBefore < 0 || (q.ModifiedBefore > 0 && q.ModifiedAfter > q.ModifiedBefore) {
+		return util.NewValidationError("invalid modification time range")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchResults
+	}
+	if q.Limit > maxSearchResults {
+		q.Limit = maxSearchResults
+	}
+	return nil
+}
+
+func (q *SearchQuery) isPattern() bool {
+	return strings.ContainsAny(q.Name, "*?[")
+}
+
+func (q *SearchQuery) matches(name string, entry *searchIndexEntry) bool {
+	if q.Type == SearchTypeFile && entry.IsDir {
+		return false
+	}
+	if q.Type == SearchTypeDir && !entry.IsDir {
+		return false
+	}
+	name = strings.ToLower(name)
+	if q.Name != "" {
+		if q.isPattern() {
+			if ok, _ := path.Match(q.Name, name); !ok {
+				return false
+			}
+		} else if !strings.Contains(name, q.Name) {
+			return false
+		}
+	}
+	if len(q.Extensions) > 0 && (entry.IsDir || !util.Contains(q.Extensions, path.Ext(name))) {
+		return false
+	}
+	if q.MinSize > 0 && entry.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && entry.Size > q.MaxSize {
+		return false
+	}
+	if q.ModifiedAfter > 0 && entry.ModTime < q.ModifiedAfter {
+		return false
+	}
+	if q.ModifiedBefore > 0 && entry.ModTime > q.ModifiedBefore {
+		return false
+	}
+	return true
+}
+
+// SearchResult defines a file or directory matching a search query
+type SearchResult struct {
+	// Virtual path
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Last modification time as unix timestamp in milliseconds
+	LastModified int64 `json:"last_modified"`
+	IsDir        bool  `json:"is_dir"`
+	// Text around the first match for content searches
+	Snippet string `json:"snippet,omitempty"`
+}
+
+type searchIndexEntry struct {
+	Size    int64 `json:"s,omitempty"`
+	ModTime int64 `json:"m,omitempty"`
+	IsDir   bool  `json:"d,omitempty"`
+}
+
+// searchIndexUser is stored, for each user, inside the users bucket.
+// The user entries are stored inside a dedicated bucket so the index
+// can be rebuilt while the previous one is still used
+type searchIndexUser struct {
+	Bucket string `json:"bucket"`
+	// The bucket with the extracted texts is preserved when the index is
+	// rebuilt, only the new and modified files are processed again
+	ContentBucket string `json:"content_bucket,omitempty"`
+	FsKey         string `json:"fs_key"`
+	BuiltAt       int64  `json:"built_at"`
+}
+
+type searchIndexEvent struct {
+	username string
+	action   int
+	path     string
+	target   string
+	entry    searchIndexEntry
+}
+
+type searchIndexManager struct {
+	mu              sync.RWMutex
+	db              *bolt.DB
+	queue           chan searchIndexEvent
+	done            chan struct{}
+	rebuildInterval time.Duration
+	content         SearchContentConfig
+	contentQueue    chan searchContentJob
+	contentWg       sync.WaitGroup
+	buildMu         sync.Mutex
+	building        map[string]bool
+	stale           map[string]bool
+}
+
+func (m *searchIndexManager) initialize(c SearchIndexConfig) error {
+	m.close()
+	if !c.Enabled {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{
+		NoGrowSync:   false,
+		FreelistType: bolt.FreelistArrayType,
+		Timeout:      5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("search index: unable to open %q: %w", c.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(searchIndexUsersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("search index: unable to initialize %q: %w", c.Path, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.db = db
+	m.queue = make(chan searchIndexEvent, searchIndexQueueSize)
+	m.done = make(chan struct{})
+	m.rebuildInterval = time.Duration(c.RebuildInterval) * time.Hour
+	m.content = c.Content
+	go m.processEvents(db, m.queue, m.done)
+	if c.Content.Enabled {
+		m.contentQueue = make(chan searchContentJob, searchContentQueueSize)
+		for i := 0; i < searchContentWorkers; i++ {
+			m.contentWg.Add(1)
+			go m.processContentJobs(m.contentQueue)
+		}
+	}
+	logger.Info(logSender, "", "search index initialized, path %q", c.Path)
+	return nil
+}
+
+func (m *searchIndexManager) close() {
+	m.mu.Lock()
+	db := m.db
+	done := m.done
+	if m.queue != nil {
+		close(m.queue)
+	}
+	if m.contentQueue != nil {
+		close(m.contentQueue)
+	}
+	m.db = nil
+	m.queue = nil
+	m.done = nil
+	m.contentQueue = nil
+	m.content = SearchContentConfig{}
+	m.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	m.contentWg.Wait()
+	if db != nil {
+		if err := db.Close(); err != nil {
+			logger.Warn(logSender, "", "unable to close the search index: %v", err)
+		}
+	}
+}
+
+func (m *searchIndexManager) getDB() *bolt.DB {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.db
+}
+
+func (m *searchIndexManager) isEnabled() bool {
+	return m.getDB() != nil
+}
+
+// IsSearchIndexEnabled returns true if the search index is enabled
+func IsSearchIndexEnabled() bool {
+	return searchIndex.isEnabled()
+}
+
+// IsSearchContentEnabled returns true if the documents content indexing is enabled
+func IsSearchContentEnabled() bool {
+	return searchIndex.isContentEnabled()
+}
+
+func (m *searchIndexManager) addEvent(ev searchIndexEvent) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.queue == nil {
+		return
+	}
+	select {
+	case m.queue <- ev:
+	default:
+		logger.Warn(logSender, "", "search index queue is full, the index for user %q will be rebuilt", ev.username)
+		m.setStale(ev.username, true)
+	}
+}
+
+// setStale marks the index for the specified user as outdated, it will
+// be rebuilt on the next search
+func (m *searchIndexManager) setStale(username string, stale bool) bool {
+	m.buildMu.Lock()
+	defer m.buildMu.Unlock()
+
+	if m.stale == nil {
+		m.stale = make(map[string]bool)
+	}
+	wasStale := m.stale[username]
+	if stale {
+		m.stale[username] = true
+	} else {
+		delete(m.stale, username)
+	}
+	return wasStale
+}
+
+func (m *searchIndexManager) processEvents(db *bolt.DB, queue chan searchIndexEvent, done chan struct{}) {
+	defer close(done)
+
+	for ev := range queue {
+		batch := []searchIndexEvent{ev}
+	readBatch:
+		for len(batch) < searchIndexEventsBatchSize {
+			select {
+			case ev, ok := <-queue:
+				if !ok {
+					break readBatch
+				}
+				batch = append(batch, ev)
+			default:
+				break readBatch
+			}
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			for idx := range batch {
+				if err := applySearchIndexEvent(tx, &batch[idx]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error(logSender, "", "unable to update the search index: %v", err)
+		}
+	}
+}
+
+// startBuild starts building the index for the specified user in background,
+// if it is not already in progress
+func (m *searchIndexManager) startBuild(username string) {
+	m.buildMu.Lock()
+	defer m.buildMu.Unlock()
+
+	if m.building == nil {
+		m.building = make(map[string]bool)
+	}
+	if m.building[username] {
+		return
+	}
+	m.building[username] = true
+
+	go func() {
+		defer func() {
+			m.buildMu.Lock()
+			delete(m.building, username)
+			m.buildMu.Unlock()
+		}()
+
+		if err := m.buildUserIndex(username); err != nil {
+			logger.Error(logSender, "", "unable to build the search index for user %q: %v", username, err)
+		}
+	}()
+}
+
+func (m *searchIndexManager) buildUserIndex(username string) error {
+	db := m.getDB()
+	if db == nil {
+		return errSearchIndexDisabled
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		return err
+	}
+	defer user.CloseFs() //nolint:errcheck
+
+	startTime := time.Now()
+	bucketName := []byte("idx_" + xid.New().String())
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(bucketName)
+		return err
+	}); err != nil {
+		return err
+	}
+	builder := &searchIndexBuilder{
+		db:     db,
+		bucket: bucketName,
+		user:   &user,
+	}
+	numEntries, err := builder.build()
+	if err != nil {
+		db.Update(func(tx *bolt.Tx) error { //nolint:errcheck
+			return tx.DeleteBucket(bucketName)
+		})
+		return err
+	}
+	contentConfig := m.getContentConfig()
+	var toIndex []string
+	err = db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(searchIndexUsersBucket)
+		indexUser := searchIndexUser{
+			Bucket:  string(bucketName),
+			FsKey:   getSearchIndexFsKey(&user),
+			BuiltAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+		}
+		old, err := getSearchIndexUser(users, username)
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := deleteBucketIfExists(tx, old.Bucket); err != nil {
+				return err
+			}
+			indexUser.ContentBucket = old.ContentBucket
+		}
+		if contentConfig.Enabled {
+			if indexUser.ContentBucket == "" {
+				indexUser.ContentBucket = "cnt_" + xid.New().String()
+			}
+			contentBucket, err := tx.CreateBucketIfNotExists([]byte(indexUser.ContentBucket))
+			if err != nil {
+				return err
+			}
+			toIndex, err = pruneContentBucket(tx.Bucket(bucketName), contentBucket, &contentConfig)
+			if err != nil {
+				return err
+			}
+		} else if indexUser.ContentBucket != "" {
+			if err := deleteBucketIfExists(tx, indexUser.ContentBucket); err != nil {
+				return err
+			}
+			indexUser.ContentBucket = ""
+		}
+		data, err := json.Marshal(indexUser)
+		if err != nil {
+			return err
+		}
+		return users.Put([]byte(username), data)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug(logSender, "", "search index built for user %q, entries: %d, elapsed: %s", username, numEntries,
+		time.Since(startTime))
+	for _, virtualPath := range toIndex {
+		if err := m.indexContent(&user, virtualPath); err != nil {
+			logger.Warn(logSender, "", "search index: unable to index the contents of %q for user %q: %v",
+				virtualPath, username, err)
+		}
+	}
+	if len(toIndex) > 0 {
+		logger.Debug(logSender, "", "search index contents updated for user %q, files: %d, elapsed: %s", username,
+			len(toIndex), time.Since(startTime))
+	}
+	return nil
+}
+
+func deleteBucketIfExists(tx *bolt.Tx, name string) error {
+	if name == "" {
+		return nil
+	}
+	if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	return nil
+}
+
+// cleanup removes the index for the users that no longer exist
+func (m *searchIndexManager) cleanup() {
+	db := m.getDB()
+	if db == nil {
+		return
+	}
+	var usernames []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(searchIndexUsersBucket).ForEach(func(k, _ []byte) error {
+			usernames = append(usernames, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error(logSender, "", "unable to get the users from the search index: %v", err)
+		return
+	}
+	for _, username := range usernames {
+		if _, err := dataprovider.UserExists(username, ""); err != nil {
+			var notFoundErr *util.RecordNotFoundError
+			if !errors.As(err, &notFoundErr) {
+				continue
+			}
+			if err := m.removeUserIndex(username); err != nil {
+				logger.Error(logSender, "", "unable to remove the search index for user %q: %v", username, err)
+				continue
+			}
+			logger.Debug(logSender, "", "search index removed for deleted user %q", username)
+		}
+	}
+}
+
+func (m *searchIndexManager) removeUserIndex(username string) error {
+	db := m.getDB()
+	if db == nil {
+		return nil
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(searchIndexUsersBucket)
+		indexUser, err := getSearchIndexUser(users, username)
+		if err != nil || indexUser == nil {
+			return err
+		}
+		if err := deleteBucketIfExists(tx, indexUser.Bucket); err != nil {
+			return err
+		}
+		if err := deleteBucketIfExists(tx, indexUser.ContentBucket); err != nil {
+			return err
+		}
+		return users.Delete([]byte(username))
+	})
+}
+
+func (m *searchIndexManager) search(user *dataprovider.User, query *SearchQuery,
+	isVisible func(string) bool,
+) ([]SearchResult, error) {
+	db := m.getDB()
+	if db == nil {
+		return nil, errSearchIndexDisabled
+	}
+	var indexUser *searchIndexUser
+	results := make([]SearchResult, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		indexUser, err = getSearchIndexUser(tx.Bucket(searchIndexUsersBucket), user.Username)
+		if err != nil || indexUser == nil || indexUser.FsKey != getSearchIndexFsKey(user) {
+			return err
+		}
+		bucket := tx.Bucket([]byte(indexUser.Bucket))
+		if bucket == nil {
+			return nil
+		}
+		prefix := []byte(query.Path)
+		if query.Path != "/" {
+			prefix = append(prefix, '/')
+		}
+		if query.Content != "" {
+			contentBucket := tx.Bucket([]byte(indexUser.ContentBucket))
+			if indexUser.ContentBucket == "" || contentBucket == nil {
+				return nil
+			}
+			results = searchIndexContents(bucket, contentBucket, prefix, query, isVisible)
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var entry searchIndexEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			virtualPath := string(k)
+			name := path.Base(virtualPath)
+			if !query.matches(name, &entry) || !isVisible(virtualPath) {
+				continue
+			}
+			results = append(results, newSearchResult(virtualPath, &entry, ""))
+			if len(results) >= query.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if indexUser == nil || indexUser.FsKey != getSearchIndexFsKey(user) {
+		m.startBuild(user.Username)
+		return nil, ErrSearchIndexNotReady
+	}
+	isExpired := m.rebuildInterval > 0 &&
+		time.Since(util.GetTimeFromMsecSinceEpoch(indexUser.BuiltAt)) > m.rebuildInterval
+	if m.setStale(user.Username, false) || isExpired {
+		m.startBuild(user.Username)
+	}
+	return results, nil
+}
+
+func searchIndexContents(bucket, contentBucket *bolt.Bucket, prefix []byte, query *SearchQuery,
+	isVisible func(string) bool,
+) []SearchResult {
+	results := make([]SearchResult, 0)
+	terms := strings.Fields(query.Content)
+	cursor := contentBucket.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		var entry searchIndexEntry
+		data := bucket.Get(k)
+		if data == nil || json.Unmarshal(data, &entry) != nil {
+			continue
+		}
+		virtualPath := string(k)
+		if !query.matches(path.Base(virtualPath), &entry) {
+			continue
+		}
+		var content searchIndexContent
+		if err := json.Unmarshal(v, &content); err != nil || content.Text == "" {
+			continue
+		}
+		ok, snippet := matchesContent(content.Text, terms)
+		if !ok || !isVisible(virtualPath) {
+			continue
+		}
+		results = append(results, newSearchResult(virtualPath, &entry, snippet))
+		if len(results) >= query.Limit {
+			break
+		}
+	}
+	return results
+}
+
+func newSearchResult(virtualPath string, entry *searchIndexEntry, snippet string) SearchResult {
+	return SearchResult{
+		Path:         virtualPath,
+		Name:         path.Base(virtualPath),
+		Size:         entry.Size,
+		LastModified: entry.ModTime,
+		IsDir:        entry.IsDir,
+		Snippet:      snippet,
+	}
+}
+
+type searchIndexBuilder struct {
+	db      *bolt.DB
+	bucket  []byte
+	user    *dataprovider.User
+	pending map[string][]byte
+	total   int
+}
+
+func (b *searchIndexBuilder) build() (int, error) {
+	b.pending = make(map[string][]byte)
+	mountPaths := []string{"/"}
+	for idx := range b.user.VirtualFolders {
+		mountPaths = append(mountPaths, b.user.VirtualFolders[idx].VirtualPath)
+	}
+	for _, mountPath := range mountPaths {
+		if err := b.walk(mountPath); err != nil {
+			return b.total, err
+		}
+	}
+	return b.total, b.flush()
+}
+
+func (b *searchIndexBuilder) getMountPath(virtualPath string) string {
+	folder, err := b.user.GetVirtualFolderForPath(virtualPath)
+	if err != nil {
+		return "/"
+	}
+	return folder.VirtualPath
+}
+
+func (b *searchIndexBuilder) walk(mountPath string) error {
+	fs, err := b.user.GetFilesystemForPath(mountPath, xid.New().String())
+	if err != nil {
+		return err
+	}
+	fsPath, err := fs.ResolvePath(mountPath)
+	if err != nil {
+		return err
+	}
+	err = fs.Walk(fsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if walkedPath != fsPath || !fs.IsNotExist(err) {
+				logger.Warn(logSender, "", "search index: unable to walk %q for user %q: %v", walkedPath,
+					b.user.Username, err)
+			}
+			return nil
+		}
+		virtualPath := fs.GetRelativePath(walkedPath)
+		if virtualPath == "/" {
+			return nil
+		}
+		if b.getMountPath(virtualPath) != mountPath || b.user.IsTrashPath(virtualPath) ||
+			b.user.IsVersionsPath(virtualPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return b.add(virtualPath, newSearchIndexEntry(info))
+	})
+	if err != nil && !fs.IsNotExist(err) {
+		return fmt.Errorf("unable to walk %q: %w", mountPath, err)
+	}
+	return nil
+}
+
+func (b *searchIndexBuilder) add(virtualPath string, entry searchIndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b.pending[virtualPath] = data
+	b.total++
+	if len(b.pending) >= searchIndexBuildBatchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *searchIndexBuilder) flush() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		if bucket == nil {
+			return bolt.ErrBucketNotFound
+		}
+		for k, v := range b.pending {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	b.pending = make(map[string][]byte)
+	return err
+}
+
+func newSearchIndexEntry(info os.FileInfo) searchIndexEntry {
+	entry := searchIndexEntry{
+		ModTime: util.GetTimeAsMsSinceEpoch(info.ModTime()),
+		IsDir:   info.IsDir(),
+	}
+	if !entry.IsDir {
+		entry.Size = info.Size()
+	}
+	return entry
+}
+
+func getSearchIndexUser(bucket *bolt.Bucket, username string) (*searchIndexUser, error) {
+	data := bucket.Get([]byte(username))
+	if data == nil {
+		return nil, nil
+	}
+	var indexUser searchIndexUser
+	if err := json.Unmarshal(data, &indexUser); err != nil {
+		return nil, err
+	}
+	return &indexUser, nil
+}
+
+// getSearchIndexFsKey returns a key that changes if the storage configuration
+// for the user changes, the index must be rebuilt in this case
+func getSearchIndexFsKey(user *dataprovider.User) string {
+	type mount struct {
+		VirtualPath string
+		MappedPath  string
+		FsConfig    vfs.Filesystem
+	}
+	mounts := []mount{
+		{
+			VirtualPath: "/",
+			MappedPath:  user.GetHomeDir(),
+			FsConfig:    user.FsConfig.GetACopy(),
+		},
+	}
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		mounts = append(mounts, mount{
+			VirtualPath: folder.VirtualPath,
+			MappedPath:  folder.MappedPath,
+			FsConfig:    folder.FsConfig.GetACopy(),
+		})
+	}
+	for idx := range mounts {
+		mounts[idx].FsConfig.HideConfidentialData()
+	}
+	data, err := json.Marshal(mounts)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func applySearchIndexEvent(tx *bolt.Tx, ev *searchIndexEvent) error {
+	users := tx.Bucket(searchIndexUsersBucket)
+	indexUser, err := getSearchIndexUser(users, ev.username)
+	if err != nil || indexUser == nil {
+		return err
+	}
+	if ev.action == searchIndexActionInvalidate {
+		indexUser.FsKey = ""
+		data, err := json.Marshal(indexUser)
+		if err != nil {
+			return err
+		}
+		return users.Put([]byte(ev.username), data)
+	}
+	bucket := tx.Bucket([]byte(indexUser.Bucket))
+	if bucket == nil {
+		return nil
+	}
+	var contentBucket *bolt.Bucket
+	if indexUser.ContentBucket != "" {
+		contentBucket = tx.Bucket([]byte(indexUser.ContentBucket))
+	}
+	switch ev.action {
+	case searchIndexActionAdd:
+		return putSearchIndexEntry(bucket, ev.path, ev.entry)
+	case searchIndexActionRemove:
+		if contentBucket != nil {
+			if err := deleteSearchIndexEntries(contentBucket, ev.path); err != nil {
+				return err
+			}
+		}
+		return deleteSearchIndexEntries(bucket, ev.path)
+	case searchIndexActionRename:
+		if contentBucket != nil {
+			if err := renameSearchIndexContents(contentBucket, ev.path, ev.target); err != nil {
+				return err
+			}
+		}
+		return renameSearchIndexEntries(bucket, ev.path, ev.target)
+	}
+	return nil
+}
+
+func putSearchIndexEntry(bucket *bolt.Bucket, virtualPath string, entry searchIndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// parent directories could be implicitly created, for example by cloud
+	// storage backends
+	for _, dirPath := range util.GetDirsForVirtualPath(path.Dir(virtualPath)) {
+		if dirPath == "/" || bucket.Get([]byte(dirPath)) != nil {
+			continue
+		}
+		dirData, err := json.Marshal(searchIndexEntry{ModTime: entry.ModTime, IsDir: true})
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(dirPath), dirData); err != nil {
+			return err
+		}
+	}
+	return bucket.Put([]byte(virtualPath), data)
+}
+
+// getSearchIndexKeys returns the key for the specified path and the keys
+// for its contents, if it is a directory
+func getSearchIndexKeys(bucket *bolt.Bucket, virtualPath string) [][]byte {
+	var keys [][]byte
+	if bucket.Get([]byte(virtualPath)) != nil {
+		keys = append(keys, []byte(virtualPath))
+	}
+	prefix := []byte(virtualPath + "/")
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	return keys
+}
+
+func deleteSearchIndexEntries(bucket *bolt.Bucket, virtualPath string) error {
+	for _, k := range getSearchIndexKeys(bucket, virtualPath) {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameSearchIndexEntries(bucket *bolt.Bucket, source, target string) error {
+	keys := getSearchIndexKeys(bucket, source)
+	if len(keys) == 0 {
+		return nil
+	}
+	entries := make(map[string][]byte)
+	for _, k := range keys {
+		entries[target+strings.TrimPrefix(string(k), source)] = bytes.Clone(bucket.Get(k))
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	if err := deleteSearchIndexEntries(bucket, target); err != nil {
+		return err
+	}
+	for k, v := range entries {
+		var entry searchIndexEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			continue
+		}
+		if err := putSearchIndexEntry(bucket, k, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameSearchIndexContents(bucket *bolt.Bucket, source, target string) error {
+	contents := make(map[string][]byte)
+	for _, k := range getSearchIndexKeys(bucket, source) {
+		contents[target+strings.TrimPrefix(string(k), source)] = bytes.Clone(bucket.Get(k))
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	if err := deleteSearchIndexEntries(bucket, target); err != nil {
+		return err
+	}
+	for k, v := range contents {
+		if err := bucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSearchIndex updates the search index, if enabled, after a successful
+// filesystem operation
+func updateSearchIndex(conn *BaseConnection, operation, virtualPath, virtualTarget string, fileSize int64, err error) {
+	if err != nil || !searchIndex.isEnabled() {
+		return
+	}
+	ev := searchIndexEvent{
+		username: conn.User.Username,
+		path:     virtualPath,
+	}
+	modTime := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	switch operation {
+	case operationUpload, operationMkdir:
+		ev.action = searchIndexActionAdd
+		ev.entry = searchIndexEntry{
+			Size:    fileSize,
+			ModTime: modTime,
+			IsDir:   operation == operationMkdir,
+		}
+	case operationCopy:
+		ev.action = searchIndexActionAdd
+		ev.path = virtualTarget
+		ev.entry = searchIndexEntry{
+			Size:    fileSize,
+			ModTime: modTime,
+		}
+	case operationDelete, operationRmdir:
+		ev.action = searchIndexActionRemove
+	case operationRename:
+		ev.action = searchIndexActionRename
+		ev.target = virtualTarget
+	case OperationSSHCmd:
+		// commands such as rsync can modify many files
+		ev.action = searchIndexActionInvalidate
+	default:
+		return
+	}
+	searchIndex.addEvent(ev)
+	if ev.action == searchIndexActionAdd && !ev.entry.IsDir {
+		searchIndex.addContentJob(ev.username, ev.path)
+	}
+}
+
+// SearchFiles searches the files and directories matching the specified query
+// inside the user's index
+func (c *BaseConnection) SearchFiles(query SearchQuery) ([]SearchResult, error) {
+	if !searchIndex.isEnabled() {
+		return nil, util.NewI18nError(fmt.Errorf("%w: %w", c.GetOpUnsupportedError(), errSearchIndexDisabled),
+			util.I18nErrorSearchDisabled)
+	}
+	if err := query.validate(); err != nil {
+		return nil, util.NewI18nError(err, util.I18nErrorSearchInvalidQuery)
+	}
+	if query.Content != "" && !searchIndex.isContentEnabled() {
+		return nil, util.NewI18nError(fmt.Errorf("%w: %w", c.GetOpUnsupportedError(), errSearchContentDisabled),
+			util.I18nErrorSearchContentDisabled)
+	}
+	// the results are visible if the parent directory can be listed and
+	// the entry and its parent directories are not hidden. Content matches
+	// include text snippets, so the files must be downloadable too
+	listableDirs := make(map[string]bool)
+	downloadableDirs := make(map[string]bool)
+	allowedDirs := make(map[string]bool)
+
+	isDirListable := func(dirPath string) bool {
+		if listable, ok := listableDirs[dirPath]; ok {
+			return listable
+		}
+		listable := c.User.HasPerm(dataprovider.PermListItems, dirPath)
+		listableDirs[dirPath] = listable
+		return listable
+	}
+	isDirDownloadable := func(dirPath string) bool {
+		if downloadable, ok := downloadableDirs[dirPath]; ok {
+			return downloadable
+		}
+		downloadable := c.User.HasPerm(dataprovider.PermDownload, dirPath)
+		downloadableDirs[dirPath] = downloadable
+		return downloadable
+	}
+	isDirAllowed := func(dirPath string) bool {
+		if dirPath == "/" {
+			return true
+		}
+		if allowed, ok := allowedDirs[dirPath]; ok {
+			return allowed
+		}
+		allowed, _ := c.User.IsFileAllowed(dirPath)
+		allowedDirs[dirPath] = allowed
+		return allowed
+	}
+
+	results, err := searchIndex.search(&c.User, &query, func(virtualPath string) bool {
+		if !isDirListable(path.Dir(virtualPath)) {
+			return false
+		}
+		if query.Content != "" && !isDirDownloadable(path.Dir(virtualPath)) {
+			return false
+		}
+		for _, dirPath := range util.GetDirsForVirtualPath(path.Dir(virtualPath)) {
+			if !isDirAllowed(dirPath) {
+				return false
+			}
+		}
+		ok, _ := c.User.IsFileAllowed(virtualPath)
+		return ok
+	})
+	if err != nil {
+		if errors.Is(err, ErrSearchIndexNotReady) {
+			return nil, util.NewI18nError(err, util.I18nErrorSearchNotReady)
+		}
+		c.Log(logger.LevelError, "unable to search files: %v", err)
+		return nil, util.NewI18nError(err, util.I18nErrorSearchGeneric)
+	}
+	return results, nil
+}
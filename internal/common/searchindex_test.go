@@ -0,0 +1,125 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSearchQuery(t *testing.T) {
+	q := SearchQuery{
+		Name:       " Report ",
+		Extensions: []string{"PDF", ".pdf", " ", "txt"},
+		Limit:      maxSearchResults + 1,
+	}
+	require.NoError(t, q.validate())
+	assert.Equal(t, "report", q.Name)
+	assert.Equal(t, "/", q.Path)
+	assert.Equal(t, []string{".pdf", ".txt"}, q.Extensions)
+	assert.Equal(t, maxSearchResults, q.Limit)
+	assert.True(t, q.matches("my_REPORT.pdf", &searchIndexEntry{Size: 10}))
+	assert.False(t, q.matches("report.doc", &searchIndexEntry{Size: 10}))
+	assert.False(t, q.matches("report.pdf", &searchIndexEntry{IsDir: true}))
+
+	q = SearchQuery{Name: "*.JPG", Type: SearchTypeFile, MinSize: 10, MaxSize: 20, ModifiedAfter: 100}
+	require.NoError(t, q.validate())
+	assert.Equal(t, defaultSearchResults, q.Limit)
+	assert.True(t, q.matches("photo.jpg", &searchIndexEntry{Size: 15, ModTime: 200}))
+	assert.False(t, q.matches("photo.jpg.bak", &searchIndexEntry{Size: 15, ModTime: 200}))
+	assert.False(t, q.matches("photo.jpg", &searchIndexEntry{Size: 25, ModTime: 200}))
+	assert.False(t, q.matches("photo.jpg", &searchIndexEntry{Size: 15, ModTime: 50}))
+	assert.False(t, q.matches("dir.jpg", &searchIndexEntry{IsDir: true, ModTime: 200}))
+
+	for _, q := range []SearchQuery{
+		{Name: "["},
+		{Type: "link"},
+		{MinSize: -1},
+		{MinSize: 10, MaxSize: 5},
+		{ModifiedAfter: 10, ModifiedBefore: 5},
+	} {
+		assert.Error(t, q.validate(), "query %+v", q)
+	}
+}
+
+func TestSearchIndexEvents(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "index.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	username := "user"
+	bucketName := []byte("idx")
+	err = db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket(searchIndexUsersBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(bucketName); err != nil {
+			return err
+		}
+		return users.Put([]byte(username), []byte(`{"bucket":"idx","fs_key":"key"}`))
+	})
+	require.NoError(t, err)
+
+	apply := func(ev searchIndexEvent) {
+		ev.username = username
+		err := db.Update(func(tx *bolt.Tx) error {
+			return applySearchIndexEvent(tx, &ev)
+		})
+		assert.NoError(t, err)
+	}
+	getKeys := func() []string {
+		var keys []string
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketName).ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	// the parent directories are added if missing
+	apply(searchIndexEvent{action: searchIndexActionAdd, path: "/a/b/file1", entry: searchIndexEntry{Size: 1}})
+	apply(searchIndexEvent{action: searchIndexActionAdd, path: "/a/file2", entry: searchIndexEntry{Size: 2}})
+	apply(searchIndexEvent{action: searchIndexActionAdd, path: "/ab", entry: searchIndexEntry{IsDir: true}})
+	assert.Equal(t, []string{"/a", "/a/b", "/a/b/file1", "/a/file2", "/ab"}, getKeys())
+	// rename a directory, the contents are moved too
+	apply(searchIndexEvent{action: searchIndexActionRename, path: "/a", target: "/c/d"})
+	assert.Equal(t, []string{"/ab", "/c", "/c/d", "/c/d/b", "/c/d/b/file1", "/c/d/file2"}, getKeys())
+	apply(searchIndexEvent{action: searchIndexActionRemove, path: "/c/d/b"})
+	assert.Equal(t, []string{"/ab", "/c", "/c/d", "/c/d/file2"}, getKeys())
+	// events for users without an index are ignored
+	ev := searchIndexEvent{username: "missing", action: searchIndexActionAdd, path: "/file"}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return applySearchIndexEvent(tx, &ev)
+	})
+	assert.NoError(t, err)
+	// invalidate the index, it will be rebuilt on the next search
+	apply(searchIndexEvent{action: searchIndexActionInvalidate})
+	err = db.View(func(tx *bolt.Tx) error {
+		indexUser, err := getSearchIndexUser(tx.Bucket(searchIndexUsersBucket), username)
+		if assert.NotNil(t, indexUser) {
+			assert.Empty(t, indexUser.FsKey)
+			assert.Equal(t, string(bucketName), indexUser.Bucket)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+}
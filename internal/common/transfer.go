@@ -214,6 +214,22 @@ func (t *BaseTransfer) SetMetadata(val map[string]string) {
 	t.metadata = val
 }
 
+// AddMetadata merges the specified metadata with the existing ones.
+// Existing keys are overwritten
+func (t *BaseTransfer) AddMetadata(val map[string]string) {
+	if len(val) == 0 {
+		return
+	}
+	metadata := make(map[string]string, len(t.metadata)+len(val))
+	for k, v := range t.metadata {
+		metadata[k] = v
+	}
+	for k, v := range val {
+		metadata[k] = v
+	}
+	t.metadata = metadata
+}
+
 // SetCancelFn sets the cancel function for the transfer
 func (t *BaseTransfer) SetCancelFn(cancelFn func()) {
 	t.cancelFn = cancelFn
@@ -267,6 +283,16 @@ func (t *BaseTransfer) CheckWrite() error {
 	return nil
 }
 
+// UpdateIOMetrics records the latency of a read, for downloads, or a write,
+// for uploads, started at startTime
+func (t *BaseTransfer) UpdateIOMetrics(startTime time.Time) {
+	operation := FsOperationRead
+	if t.transferType == TransferUpload {
+		operation = FsOperationWrite
+	}
+	t.Connection.UpdateFsOperationMetrics(t.Fs, operation, startTime)
+}
+
 // Truncate changes the size of the opened file.
 // Supported for local fs only
 func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
@@ -282,6 +308,9 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 					t.MaxWriteSize += sizeDiff
 					metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 						t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
+					metric.ProtocolTransferCompleted(t.Connection.protocol, vfs.GetBackendName(t.Fs),
+						t.Connection.User.Username, t.BytesSent.Load(), t.BytesReceived.Load(), t.getMetricTransferKind(), 0,
+						t.ErrTransfer)
 					if t.transferQuota.HasSizeLimits() {
 						go func(ulSize, dlSize int64, user dataprovider.User) {
 							dataprovider.UpdateUserTransferQuota(&user, ulSize, dlSize, false) //nolint:errcheck
@@ -379,6 +408,8 @@ func (t *BaseTransfer) Close() error {
 	numFiles := t.getUploadedFiles()
 	metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 		t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
+	metric.ProtocolTransferCompleted(t.Connection.protocol, vfs.GetBackendName(t.Fs), t.Connection.User.Username,
+		t.BytesSent.Load(), t.BytesReceived.Load(), t.getMetricTransferKind(), time.Since(t.start), t.ErrTransfer)
 	if t.transferQuota.HasSizeLimits() {
 		dataprovider.UpdateUserTransferQuota(&t.Connection.User, t.BytesReceived.Load(), //nolint:errcheck
 			t.BytesSent.Load(), false)
@@ -452,6 +483,13 @@ func (t *BaseTransfer) isAtomicUpload() bool {
 	return t.transferType == TransferUpload && t.effectiveFsPath != t.fsPath
 }
 
+func (t *BaseTransfer) getMetricTransferKind() metric.TransferKind {
+	if t.transferType == TransferUpload {
+		return metric.TransferKindUpload
+	}
+	return metric.TransferKindDownload
+}
+
 func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize, elapsed int64) {
 	if t.ErrTransfer != nil {
 		return
@@ -485,6 +523,8 @@ func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize, elapsed int64)
 		// try to remove the uploaded file
 		err = t.Fs.Remove(t.fsPath, false)
 		if err == nil {
+			updateSearchIndex(t.Connection, operationDelete, t.requestPath, "", 0, nil)
+			recordQuotaScanChange(t.Connection, operationDelete, t.requestPath, "")
 			numFiles--
 			fileSize = 0
 			t.BytesReceived.Store(0)
@@ -519,16 +559,21 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	}
 	sizeDiff := fileSize - t.InitialSize
 	if t.transferType == TransferUpload && (numFiles != 0 || sizeDiff != 0) {
+		t.Connection.UpdateDirQuotaUsage(t.requestPath, numFiles, sizeDiff)
 		vfolder, err := t.Connection.User.GetVirtualFolderForPath(path.Dir(t.requestPath))
 		if err == nil {
 			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, //nolint:errcheck
 				sizeDiff, false)
 			if vfolder.IsIncludedInUserQuota() {
 				dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
+			} else {
+				t.checkFolderQuotaThresholds(&vfolder, numFiles, sizeDiff, fileSize)
+				return true
 			}
 		} else {
 			dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
 		}
+		t.checkUserQuotaThresholds(numFiles, sizeDiff, fileSize)
 		return true
 	}
 	return false
@@ -687,6 +687,7 @@ func TestDBTransferChecker(t *testing.T) {
 	providerConf.IsShared = 1
 	err = dataprovider.Initialize(providerConf, configDir, true)
 	assert.NoError(t, err)
+	assert.True(t, dataprovider.IsClusterLeader())
 	c := getTransfersChecker(1)
 	checker, ok := c.(*transfersCheckerDB)
 	assert.True(t, ok)
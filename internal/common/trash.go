@@ -0,0 +1,403 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const trashPurgeInterval = 1 * time.Hour
+
+var (
+	// deletes and overwrites from these protocols can be recovered using the
+	// trash and the file versioning, if enabled. Deletes and overwrites from
+	// event actions and data retention checks are permanent
+	recoveryProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
+		ProtocolHTTP, ProtocolHTTPShare, ProtocolS3}
+	errTrashDisabled = errors.New("trash is not enabled")
+)
+
+// TrashEntry defines a file moved to the trash.
+// Each deleted file is stored as "/.trash/<id>/<original path>", the id
+// encodes the deletion time
+type TrashEntry struct {
+	ID string `json:"id"`
+	// Original virtual path
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Deletion time as unix timestamp in milliseconds
+	DeletedAt int64 `json:"deleted_at"`
+}
+
+func (e *TrashEntry) getTrashPath() string {
+	return path.Join(dataprovider.GetTrashPath(), e.ID, e.Path)
+}
+
+func newTrashEntry(relativePath string, info os.FileInfo) (TrashEntry, error) {
+	trashPath := dataprovider.GetTrashPath() + "/"
+	if !strings.HasPrefix(relativePath, trashPath) {
+		return TrashEntry{}, fmt.Errorf("path %q is outside the trash", relativePath)
+	}
+	id, originalPath, ok := strings.Cut(strings.TrimPrefix(relativePath, trashPath), "/")
+	if !ok || originalPath == "" {
+		return TrashEntry{}, fmt.Errorf("invalid trash path %q", relativePath)
+	}
+	guid, err := xid.FromString(id)
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("invalid trash id %q: %w", id, err)
+	}
+	return TrashEntry{
+		ID:        id,
+		Path:      "/" + originalPath,
+		Size:      info.Size(),
+		DeletedAt: util.GetTimeAsMsSinceEpoch(guid.Time()),
+	}, nil
+}
+
+// isTrashEnabledForPath returns true if deleting the specified virtual
+// path must move the file to the trash
+func (c *BaseConnection) isTrashEnabledForPath(virtualPath string) bool {
+	if !c.User.Filters.Trash.IsEnabled() || !util.Contains(recoveryProtocols, c.protocol) {
+		return false
+	}
+	// the trash is inside the root filesystem, files inside virtual folders
+	// cannot be moved there
+	_, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	return err != nil
+}
+
+func (c *BaseConnection) createInternalDirs(fs vfs.Fs, virtualPath string) error {
+	if fs.HasVirtualFolders() {
+		return nil
+	}
+	dirs := util.GetDirsForVirtualPath(virtualPath)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if dirs[idx] == "/" {
+			continue
+		}
+		fsPath, err := fs.ResolvePath(dirs[idx])
+		if err != nil {
+			return err
+		}
+		if _, err = fs.Stat(fsPath); err == nil {
+			continue
+		}
+		if !fs.IsNotExist(err) {
+			return err
+		}
+		if err = fs.Mkdir(fsPath); err != nil {
+			return err
+		}
+		vfs.SetPathPermissions(fs, fsPath, c.User.GetUID(), c.User.GetGID())
+	}
+	return nil
+}
+
+// moveToTrash moves the file at the specified fsPath to the trash
+func (c *BaseConnection) moveToTrash(fs vfs.Fs, fsPath, virtualPath string) error {
+	trashPath := path.Join(dataprovider.GetTrashPath(), xid.New().String(), virtualPath)
+	if err := c.createInternalDirs(fs, path.Dir(trashPath)); err != nil {
+		c.Log(logger.LevelError, "unable to create trash dirs for %q: %v", trashPath, err)
+		return err
+	}
+	fsTrashPath, err := fs.ResolvePath(trashPath)
+	if err != nil {
+		return err
+	}
+	if _, _, err := fs.Rename(fsPath, fsTrashPath); err != nil {
+		return err
+	}
+	c.Log(logger.LevelDebug, "file %q moved to the trash, trash path: %q", virtualPath, trashPath)
+	return nil
+}
+
+// removeTrashEntryDirs removes the, now empty, directories for the specified entry
+func (c *BaseConnection) removeTrashEntryDirs(fs vfs.Fs, entry *TrashEntry) {
+	if fs.HasVirtualFolders() {
+		return
+	}
+	entryPath := path.Join(dataprovider.GetTrashPath(), entry.ID)
+	for dir := path.Dir(entry.getTrashPath()); strings.HasPrefix(dir, entryPath); dir = path.Dir(dir) {
+		fsPath, err := fs.ResolvePath(dir)
+		if err == nil {
+			err = fs.Remove(fsPath, true)
+		}
+		if err != nil {
+			c.Log(logger.LevelDebug, "unable to remove trash dir %q: %v", dir, err)
+			return
+		}
+	}
+}
+
+func (c *BaseConnection) getTrashFs() (vfs.Fs, error) {
+	if !c.User.Filters.Trash.IsEnabled() {
+		return nil, util.NewI18nError(fmt.Errorf("%w: %w", c.GetOpUnsupportedError(), errTrashDisabled),
+			util.I18nErrorTrashDisabled)
+	}
+	fs, err := c.User.GetFilesystemForPath("/", c.ID)
+	if err != nil {
+		return nil, c.GetGenericError(err)
+	}
+	return fs, nil
+}
+
+func (c *BaseConnection) walkTrash(fs vfs.Fs, virtualPath string, fn func(entry TrashEntry) error) error {
+	fsTrashPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	err = fs.Walk(fsTrashPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		entry, err := newTrashEntry(fs.GetRelativePath(walkedPath), info)
+		if err != nil {
+			c.Log(logger.LevelWarn, "skipping unexpected trash item %q: %v", walkedPath, err)
+			return nil
+		}
+		return fn(entry)
+	})
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	return nil
+}
+
+// GetTrashEntries returns the files in the trash, most recently deleted first
+func (c *BaseConnection) GetTrashEntries() ([]TrashEntry, error) {
+	fs, err := c.getTrashFs()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TrashEntry, 0)
+	err = c.walkTrash(fs, dataprovider.GetTrashPath(), func(entry TrashEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt == entries[j].DeletedAt {
+			return entries[i].ID > entries[j].ID
+		}
+		return entries[i].DeletedAt > entries[j].DeletedAt
+	})
+	return entries, nil
+}
+
+func (c *BaseConnection) getTrashEntry(id string) (vfs.Fs, TrashEntry, error) {
+	fs, err := c.getTrashFs()
+	if err != nil {
+		return nil, TrashEntry{}, err
+	}
+	if _, err := xid.FromString(id); err != nil {
+		return nil, TrashEntry{}, util.NewI18nError(c.GetNotExistError(), util.I18nErrorTrashNotFound)
+	}
+	var result *TrashEntry
+	err = c.walkTrash(fs, path.Join(dataprovider.GetTrashPath(), id), func(entry TrashEntry) error {
+		if result == nil {
+			result = &entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, TrashEntry{}, err
+	}
+	if result == nil {
+		return nil, TrashEntry{}, util.NewI18nError(c.GetNotExistError(), util.I18nErrorTrashNotFound)
+	}
+	return fs, *result, nil
+}
+
+// RestoreTrashEntry moves the trash entry with the specified id back to its original path
+func (c *BaseConnection) RestoreTrashEntry(id string) (TrashEntry, error) {
+	fs, entry, err := c.getTrashEntry(id)
+	if err != nil {
+		return entry, err
+	}
+	virtualPath := entry.Path
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+		return entry, util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		return entry, util.NewI18nError(c.GetErrorForDeniedFile(policy), util.I18nError403Message)
+	}
+	if _, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath)); err == nil {
+		return entry, util.NewI18nError(fmt.Errorf("%w: the path %q is now inside a virtual folder",
+			c.GetOpUnsupportedError(), virtualPath), util.I18nErrorTrashRestoreVirtualFolder)
+	}
+	if _, err := c.DoStat(virtualPath, 1, false); err == nil {
+		return entry, util.NewI18nError(fmt.Errorf("%w: the path %q already exists", c.GetOpUnsupportedError(),
+			virtualPath), util.I18nErrorTrashRestoreExists)
+	} else if !c.IsNotExistError(err) {
+		return entry, err
+	}
+	if c.User.Filters.Trash.ExcludeFromQuota {
+		quotaResult, _ := c.HasSpace(true, false, virtualPath)
+		if !quotaResult.HasSpace || (quotaResult.QuotaSize > 0 && quotaResult.GetRemainingSize() < entry.Size) {
+			return entry, util.NewI18nError(c.GetGenericError(ErrQuotaExceeded), util.I18nErrorTrashRestoreQuota)
+		}
+	}
+	if err := c.CheckParentDirs(path.Dir(virtualPath)); err != nil {
+		return entry, err
+	}
+	fsTrashPath, err := fs.ResolvePath(entry.getTrashPath())
+	if err != nil {
+		return entry, c.GetFsError(fs, err)
+	}
+	fsPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return entry, c.GetFsError(fs, err)
+	}
+	if _, _, err := fs.Rename(fsTrashPath, fsPath); err != nil {
+		c.Log(logger.LevelError, "unable to restore %q from the trash: %v", virtualPath, err)
+		return entry, c.GetFsError(fs, err)
+	}
+	c.removeTrashEntryDirs(fs, &entry)
+	c.UpdateDirQuotaUsage(virtualPath, 1, entry.Size)
+	if c.User.Filters.Trash.ExcludeFromQuota {
+		dataprovider.UpdateUserQuota(&c.User, 1, entry.Size, false) //nolint:errcheck
+	}
+	updateSearchIndex(c, operationUpload, virtualPath, "", entry.Size, nil)
+	recordQuotaScanChange(c, operationUpload, virtualPath, "")
+	c.Log(logger.LevelInfo, "file %q restored from the trash, id %q", virtualPath, id)
+	return entry, nil
+}
+
+// DeleteTrashEntry permanently removes the trash entry with the specified id
+func (c *BaseConnection) DeleteTrashEntry(id string) error {
+	fs, entry, err := c.getTrashEntry(id)
+	if err != nil {
+		return err
+	}
+	return c.deleteTrashEntry(fs, &entry)
+}
+
+func (c *BaseConnection) deleteTrashEntry(fs vfs.Fs, entry *TrashEntry) error {
+	fsTrashPath, err := fs.ResolvePath(entry.getTrashPath())
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if err := fs.Remove(fsTrashPath, false); err != nil {
+		c.Log(logger.LevelError, "unable to remove trash entry %q: %v", entry.getTrashPath(), err)
+		return c.GetFsError(fs, err)
+	}
+	c.removeTrashEntryDirs(fs, entry)
+	if !c.User.Filters.Trash.ExcludeFromQuota {
+		dataprovider.UpdateUserQuota(&c.User, -1, -entry.Size, false) //nolint:errcheck
+	}
+	c.Log(logger.LevelDebug, "trash entry %q removed, original path %q", entry.ID, entry.Path)
+	return nil
+}
+
+// PurgeTrash permanently removes the files moved to the trash before the specified time.
+// The number of removed files is returned
+func (c *BaseConnection) PurgeTrash(before time.Time) (int, error) {
+	entries, err := c.GetTrashEntries()
+	if err != nil {
+		return 0, err
+	}
+	fs, err := c.getTrashFs()
+	if err != nil {
+		return 0, err
+	}
+	limit := util.GetTimeAsMsSinceEpoch(before)
+	removed := 0
+	for idx := range entries {
+		if entries[idx].DeletedAt >= limit {
+			continue
+		}
+		if err := c.deleteTrashEntry(fs, &entries[idx]); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func purgeUserTrash(username string) {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get user %q for trash purge: %v", username, err)
+		return
+	}
+	connectionID := fmt.Sprintf("%s_%s", ProtocolDataRetention, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		logger.Warn(logSender, "", "unable to check root fs for user %q, trash purge skipped: %v", username, err)
+		return
+	}
+	conn := NewBaseConnection(connectionID, ProtocolDataRetention, "", "", user)
+	before := time.Now().Add(-time.Duration(user.Filters.Trash.RetentionDays) * 24 * time.Hour)
+	removed, err := conn.PurgeTrash(before)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to purge trash for user %q: %v", username, err)
+	}
+	if removed > 0 {
+		logger.Info(logSender, "", "trash purged for user %q, removed files: %d", username, removed)
+	}
+}
+
+// purgeUsersTrashAndVersions removes the trash entries and the file versions
+// exceeding the configured retention
+func purgeUsersTrashAndVersions() {
+	const limit = 100
+
+	// the storage is shared between the cluster nodes, the leader purges it
+	if !dataprovider.IsClusterLeader() {
+		return
+	}
+
+	for offset := 0; ; offset += limit {
+		users, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, "", "")
+		if err != nil {
+			logger.Warn(logSender, "", "unable to get users for trash purge: %v", err)
+			return
+		}
+		for idx := range users {
+			policy := &users[idx].Filters.Trash
+			if policy.IsEnabled() && policy.RetentionDays > 0 {
+				purgeUserTrash(users[idx].Username)
+			}
+			if users[idx].IsVersioningEnabled() {
+				pruneUserFileVersions(users[idx].Username)
+			}
+		}
+		if len(users) < limit {
+			return
+		}
+	}
+}
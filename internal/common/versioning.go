@@ -0,0 +1,458 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var errVersioningDisabled = errors.New("file versioning is not enabled")
+
+// FileVersion defines a previous version of a file.
+// Each version is stored as "/.versions/<file path>/<id>", the id
+// encodes the version creation time
+type FileVersion struct {
+	ID string `json:"id"`
+	// Virtual path of the versioned file
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+}
+
+func (v *FileVersion) getVersionPath() string {
+	return path.Join(dataprovider.GetFileVersionsPath(v.Path), v.ID)
+}
+
+// getVersioningRule returns the versioning rule to apply to the specified path
+func (c *BaseConnection) getVersioningRule(virtualPath string) (dataprovider.VersioningRule, bool) {
+	if !c.User.IsVersioningEnabled() || !util.Contains(recoveryProtocols, c.protocol) {
+		return dataprovider.VersioningRule{}, false
+	}
+	// versions are stored inside the root filesystem, files inside
+	// virtual folders are not versioned
+	if _, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath)); err == nil {
+		return dataprovider.VersioningRule{}, false
+	}
+	return c.User.GetVersioningRule(virtualPath)
+}
+
+func (c *BaseConnection) getVersioningFs(virtualPath string) (vfs.Fs, error) {
+	if !c.User.IsVersioningEnabled() {
+		return nil, util.NewI18nError(fmt.Errorf("%w: %w", c.GetOpUnsupportedError(), errVersioningDisabled),
+			util.I18nErrorVersioningDisabled)
+	}
+	if _, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath)); err == nil {
+		return nil, util.NewI18nError(fmt.Errorf("%w: files inside virtual folders are not versioned",
+			c.GetOpUnsupportedError()), util.I18nErrorVersioningDisabled)
+	}
+	fs, err := c.User.GetFilesystemForPath("/", c.ID)
+	if err != nil {
+		return nil, c.GetGenericError(err)
+	}
+	return fs, nil
+}
+
+// CreateFileVersion stores a copy of the specified file, before it is
+// overwritten, if a versioning rule applies to its path
+func (c *BaseConnection) CreateFileVersion(fs vfs.Fs, fsPath, virtualPath string, size int64) error {
+	rule, ok := c.getVersioningRule(virtualPath)
+	if !ok {
+		return nil
+	}
+	version := FileVersion{
+		ID:   xid.New().String(),
+		Path: virtualPath,
+		Size: size,
+	}
+	if err := c.createInternalDirs(fs, path.Dir(version.getVersionPath())); err != nil {
+		c.Log(logger.LevelError, "unable to create versions dirs for %q: %v", virtualPath, err)
+		return c.GetFsError(fs, err)
+	}
+	fsVersionPath, err := fs.ResolvePath(version.getVersionPath())
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	startTime := time.Now()
+	if copier, ok := fs.(vfs.FsFileCopier); ok {
+		numFiles, sizeDiff, err := copier.CopyFile(fsPath, fsVersionPath, size)
+		if err != nil {
+			c.Log(logger.LevelError, "unable to create a version for file %q: %v", virtualPath, err)
+			return c.GetFsError(fs, err)
+		}
+		dataprovider.UpdateUserQuota(&c.User, numFiles, sizeDiff, false) //nolint:errcheck
+	} else {
+		if err := c.copyFileToVersion(fs, fsPath, fsVersionPath); err != nil {
+			c.Log(logger.LevelError, "unable to create a version for file %q: %v", virtualPath, err)
+			return c.GetFsError(fs, err)
+		}
+		dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+	}
+	c.Log(logger.LevelDebug, "version %q created for file %q, elapsed: %s", version.ID, virtualPath,
+		time.Since(startTime))
+	c.pruneFileVersions(fs, virtualPath, rule)
+	return nil
+}
+
+func (c *BaseConnection) copyFileToVersion(fs vfs.Fs, fsSourcePath, fsTargetPath string) error {
+	f, r, rCancelFn, err := fs.Open(fsSourcePath, 0)
+	if err != nil {
+		return err
+	}
+	if rCancelFn != nil {
+		defer rCancelFn()
+	}
+	var reader io.ReadCloser = r
+	if f != nil {
+		reader = f
+	}
+	defer reader.Close()
+
+	fw, w, wCancelFn, err := fs.Create(fsTargetPath, 0, 0)
+	if err != nil {
+		return err
+	}
+	if wCancelFn != nil {
+		defer wCancelFn()
+	}
+	var writer io.WriteCloser = w
+	if fw != nil {
+		writer = fw
+	}
+	err = c.copyFileData(writer, reader)
+	errClose := writer.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		// try to remove the partial version, if this fails we can't do anything
+		fs.Remove(fsTargetPath, false) //nolint:errcheck
+		return err
+	}
+	vfs.SetPathPermissions(fs, fsTargetPath, c.User.GetUID(), c.User.GetGID())
+	return nil
+}
+
+// moveToVersions moves the specified file, before it is deleted, to the versions directory
+func (c *BaseConnection) moveToVersions(fs vfs.Fs, fsPath, virtualPath string) error {
+	version := FileVersion{
+		ID:   xid.New().String(),
+		Path: virtualPath,
+	}
+	if err := c.createInternalDirs(fs, path.Dir(version.getVersionPath())); err != nil {
+		c.Log(logger.LevelError, "unable to create versions dirs for %q: %v", virtualPath, err)
+		return err
+	}
+	fsVersionPath, err := fs.ResolvePath(version.getVersionPath())
+	if err != nil {
+		return err
+	}
+	if _, _, err := fs.Rename(fsPath, fsVersionPath); err != nil {
+		return err
+	}
+	c.Log(logger.LevelDebug, "file %q moved to the versions, id: %q", virtualPath, version.ID)
+	return nil
+}
+
+func (c *BaseConnection) listFileVersions(fs vfs.Fs, virtualPath string) ([]FileVersion, error) {
+	versions := make([]FileVersion, 0)
+	fsVersionsPath, err := fs.ResolvePath(dataprovider.GetFileVersionsPath(virtualPath))
+	if err != nil {
+		return nil, c.GetFsError(fs, err)
+	}
+	lister, err := fs.ReadDir(fsVersionsPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return versions, nil
+		}
+		return nil, c.GetFsError(fs, err)
+	}
+	defer lister.Close()
+
+	for {
+		entries, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return nil, c.GetFsError(fs, err)
+		}
+		for _, fi := range entries {
+			if fi.IsDir() {
+				continue
+			}
+			guid, err := xid.FromString(fi.Name())
+			if err != nil {
+				continue
+			}
+			versions = append(versions, FileVersion{
+				ID:        fi.Name(),
+				Path:      virtualPath,
+				Size:      fi.Size(),
+				CreatedAt: util.GetTimeAsMsSinceEpoch(guid.Time()),
+			})
+		}
+		if finished {
+			break
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].CreatedAt == versions[j].CreatedAt {
+			return versions[i].ID > versions[j].ID
+		}
+		return versions[i].CreatedAt > versions[j].CreatedAt
+	})
+	return versions, nil
+}
+
+// GetFileVersions returns the versions for the specified file, most recent first
+func (c *BaseConnection) GetFileVersions(virtualPath string) ([]FileVersion, error) {
+	virtualPath = util.CleanPath(virtualPath)
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+		return nil, util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		return nil, util.NewI18nError(c.GetErrorForDeniedFile(policy), util.I18nError403Message)
+	}
+	fs, err := c.getVersioningFs(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.listFileVersions(fs, virtualPath)
+}
+
+func (c *BaseConnection) getFileVersion(virtualPath, id string) (vfs.Fs, FileVersion, error) {
+	versions, err := c.GetFileVersions(virtualPath)
+	if err != nil {
+		return nil, FileVersion{}, err
+	}
+	for _, version := range versions {
+		if version.ID == id {
+			fs, err := c.getVersioningFs(version.Path)
+			return fs, version, err
+		}
+	}
+	return nil, FileVersion{}, util.NewI18nError(c.GetNotExistError(), util.I18nErrorVersionNotFound)
+}
+
+// RestoreFileVersion replaces the specified file with the version with the given id.
+// The current file, if any, is stored as a new version so the restore can be reverted
+func (c *BaseConnection) RestoreFileVersion(virtualPath, id string) (FileVersion, error) {
+	fs, version, err := c.getFileVersion(virtualPath, id)
+	if err != nil {
+		return version, err
+	}
+	virtualPath = version.Path
+	fsPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return version, c.GetFsError(fs, err)
+	}
+	info, err := fs.Lstat(fsPath)
+	if err != nil && !fs.IsNotExist(err) {
+		return version, c.GetFsError(fs, err)
+	}
+	fileExists := err == nil
+	if fileExists && !info.Mode().IsRegular() {
+		return version, util.NewI18nError(fmt.Errorf("%w: %q is not a regular file", c.GetOpUnsupportedError(),
+			virtualPath), util.I18nErrorVersionRestoreNotFile)
+	}
+	perm := dataprovider.PermUpload
+	if fileExists {
+		perm = dataprovider.PermOverwrite
+	}
+	if !c.User.HasPerm(perm, path.Dir(virtualPath)) {
+		return version, util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+	}
+	if fileExists {
+		if err := c.moveToVersions(fs, fsPath, virtualPath); err != nil {
+			c.Log(logger.LevelError, "unable to store the current version of %q: %v", virtualPath, err)
+			return version, c.GetFsError(fs, err)
+		}
+	} else if err := c.CheckParentDirs(path.Dir(virtualPath)); err != nil {
+		return version, err
+	}
+	fsVersionPath, err := fs.ResolvePath(version.getVersionPath())
+	if err != nil {
+		return version, c.GetFsError(fs, err)
+	}
+	if _, _, err := fs.Rename(fsVersionPath, fsPath); err != nil {
+		c.Log(logger.LevelError, "unable to restore version %q for file %q: %v", id, virtualPath, err)
+		if fileExists {
+			c.UpdateDirQuotaUsage(virtualPath, -1, -info.Size())
+		}
+		return version, c.GetFsError(fs, err)
+	}
+	if fileExists {
+		c.UpdateDirQuotaUsage(virtualPath, 0, version.Size-info.Size())
+	} else {
+		c.UpdateDirQuotaUsage(virtualPath, 1, version.Size)
+	}
+	c.removeEmptyVersionsDirs(fs, virtualPath)
+	updateSearchIndex(c, operationUpload, virtualPath, "", version.Size, nil)
+	recordQuotaScanChange(c, operationUpload, virtualPath, "")
+	c.Log(logger.LevelInfo, "version %q restored for file %q", id, virtualPath)
+	return version, nil
+}
+
+// DeleteFileVersion permanently removes the version with the given id for the specified file
+func (c *BaseConnection) DeleteFileVersion(virtualPath, id string) error {
+	fs, version, err := c.getFileVersion(virtualPath, id)
+	if err != nil {
+		return err
+	}
+	if !c.User.HasAnyPerm([]string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}, path.Dir(version.Path)) {
+		return util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+	}
+	if err := c.deleteFileVersion(fs, &version); err != nil {
+		return err
+	}
+	c.removeEmptyVersionsDirs(fs, version.Path)
+	return nil
+}
+
+func (c *BaseConnection) deleteFileVersion(fs vfs.Fs, version *FileVersion) error {
+	fsVersionPath, err := fs.ResolvePath(version.getVersionPath())
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if err := fs.Remove(fsVersionPath, false); err != nil {
+		c.Log(logger.LevelError, "unable to remove version %q for file %q: %v", version.ID, version.Path, err)
+		return c.GetFsError(fs, err)
+	}
+	dataprovider.UpdateUserQuota(&c.User, -1, -version.Size, false) //nolint:errcheck
+	c.Log(logger.LevelDebug, "version %q removed for file %q", version.ID, version.Path)
+	return nil
+}
+
+// removeEmptyVersionsDirs removes the versions directory for the specified
+// file and its parents, if empty
+func (c *BaseConnection) removeEmptyVersionsDirs(fs vfs.Fs, virtualPath string) {
+	if fs.HasVirtualFolders() {
+		return
+	}
+	versionsPath := dataprovider.GetVersionsPath()
+	for dir := dataprovider.GetFileVersionsPath(virtualPath); strings.HasPrefix(dir, versionsPath+"/"); dir = path.Dir(dir) {
+		fsPath, err := fs.ResolvePath(dir)
+		if err == nil {
+			err = fs.Remove(fsPath, true)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pruneFileVersions removes the versions exceeding the limits defined in the
+// given rule. The number of removed versions is returned
+func (c *BaseConnection) pruneFileVersions(fs vfs.Fs, virtualPath string, rule dataprovider.VersioningRule) int {
+	versions, err := c.listFileVersions(fs, virtualPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to list versions for file %q: %v", virtualPath, err)
+		return 0
+	}
+	var limit int64
+	if rule.RetentionDays > 0 {
+		limit = util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(rule.RetentionDays) * 24 * time.Hour))
+	}
+	removed := 0
+	for idx := range versions {
+		if (rule.MaxVersions == 0 || idx < rule.MaxVersions) && versions[idx].CreatedAt >= limit {
+			continue
+		}
+		if err := c.deleteFileVersion(fs, &versions[idx]); err != nil {
+			return removed
+		}
+		removed++
+	}
+	if removed == len(versions) {
+		c.removeEmptyVersionsDirs(fs, virtualPath)
+	}
+	return removed
+}
+
+// PruneFileVersions removes, for all the versioned files, the versions
+// exceeding the limits defined in the applicable versioning rules.
+// The number of removed versions is returned
+func (c *BaseConnection) PruneFileVersions() (int, error) {
+	fs, err := c.getVersioningFs("/")
+	if err != nil {
+		return 0, err
+	}
+	fsVersionsPath, err := fs.ResolvePath(dataprovider.GetVersionsPath())
+	if err != nil {
+		return 0, c.GetFsError(fs, err)
+	}
+	files := make(map[string]bool)
+	err = fs.Walk(fsVersionsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		virtualPath := path.Dir(fs.GetRelativePath(walkedPath))
+		files[strings.TrimPrefix(virtualPath, dataprovider.GetVersionsPath())] = true
+		return nil
+	})
+	if err != nil {
+		return 0, c.GetFsError(fs, err)
+	}
+	removed := 0
+	for virtualPath := range files {
+		if rule, ok := c.User.GetVersioningRule(virtualPath); ok {
+			removed += c.pruneFileVersions(fs, virtualPath, rule)
+		}
+	}
+	return removed, nil
+}
+
+func pruneUserFileVersions(username string) {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get user %q for file versions pruning: %v", username, err)
+		return
+	}
+	connectionID := fmt.Sprintf("%s_%s", ProtocolDataRetention, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		logger.Warn(logSender, "", "unable to check root fs for user %q, file versions pruning skipped: %v",
+			username, err)
+		return
+	}
+	conn := NewBaseConnection(connectionID, ProtocolDataRetention, "", "", user)
+	removed, err := conn.PruneFileVersions()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to prune file versions for user %q: %v", username, err)
+	}
+	if removed > 0 {
+		logger.Info(logSender, "", "file versions pruned for user %q, removed versions: %d", username, removed)
+	}
+}
@@ -18,6 +18,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -31,12 +32,14 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/s3gateway"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/telemetry"
@@ -75,10 +78,12 @@ var (
 		PassiveIPOverrides:         nil,
 		PassiveHost:                "",
 		ClientAuthType:             0,
+		TLSUsernameMappings:        nil,
 		TLSCipherSuites:            nil,
 		PassiveConnectionsSecurity: 0,
 		ActiveConnectionsSecurity:  0,
 		Debug:                      false,
+		Tenant:                     "",
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
 		Address:              "",
@@ -95,6 +100,28 @@ var (
 		ClientIPProxyHeader:  "",
 		ClientIPHeaderDepth:  0,
 		DisableWWWAuthHeader: false,
+		Tenant:               "",
+	}
+	defaultS3GatewayBinding = s3gateway.Binding{
+		Address:             "",
+		Port:                0,
+		EnableHTTPS:         false,
+		CertificateFile:     "",
+		CertificateKeyFile:  "",
+		MinTLSVersion:       12,
+		TLSCipherSuites:     nil,
+		ProxyAllowed:        nil,
+		ClientIPProxyHeader: "",
+		ClientIPHeaderDepth: 0,
+	}
+	defaultGRPCDBinding = grpcd.Binding{
+		Address:            "",
+		Port:               0,
+		EnableTLS:          false,
+		CertificateFile:    "",
+		CertificateKeyFile: "",
+		MinTLSVersion:      12,
+		TLSCipherSuites:    nil,
 	}
 	defaultHTTPDBinding = httpd.Binding{
 		Address:             "",
@@ -115,7 +142,10 @@ var (
 		ClientIPHeaderDepth: 0,
 		HideLoginURL:        0,
 		RenderOpenAPI:       true,
+		Tenant:              "",
 		OIDC: httpd.OIDC{
+			Name:                       "",
+			Domains:                    []string{},
 			ClientID:                   "",
 			ClientSecret:               "",
 			ClientSecretFile:           "",
@@ -127,7 +157,35 @@ var (
 			Scopes:                     []string{"openid", "profile", "email"},
 			CustomFields:               []string{},
 			InsecureSkipSignatureCheck: false,
-			Debug:                      false,
+			GroupsField:                "",
+			GroupMappings:              []httpd.OIDCGroupMapping{},
+			Provisioning: httpd.OIDCProvisioning{
+				Enabled:         false,
+				HomeDir:         "",
+				HomeDirField:    "",
+				Permissions:     []string{},
+				Groups:          []httpd.OIDCGroupMapping{},
+				QuotaSize:       0,
+				QuotaFiles:      0,
+				QuotaSizeField:  "",
+				QuotaFilesField: "",
+			},
+			Debug: false,
+		},
+		OIDCProviders: []httpd.OIDC{},
+		SAML: httpd.SAML{
+			IDPMetadata:       "",
+			EntityID:          "",
+			BaseURL:           "",
+			CertificateFile:   "",
+			KeyFile:           "",
+			SignRequests:      false,
+			UsernameAttribute: "",
+			RoleAttribute:     "",
+			ImplicitRoles:     false,
+			CustomFields:      []string{},
+			AllowIDPInitiated: false,
+			Debug:             false,
 		},
 		Security: httpd.SecurityConf{
 			Enabled:                 false,
@@ -152,33 +210,36 @@ var (
 		Period:                 1000,
 		Burst:                  1,
 		Type:                   2,
-		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP},
+		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP, common.ProtocolS3},
 		GenerateDefenderEvents: false,
 		EntriesSoftLimit:       100,
 		EntriesHardLimit:       150,
 	}
 	defaultTOTP = mfa.TOTPConfig{
-		Name:   "Default",
-		Issuer: "SFTPGo",
-		Algo:   mfa.TOTPAlgoSHA1,
+		Name:                "Default",
+		Issuer:              "SFTPGo",
+		Algo:                mfa.TOTPAlgoSHA1,
+		TrustedDeviceMaxAge: 0,
 	}
 )
 
 type globalConfig struct {
-	Common          common.Configuration  `json:"common" mapstructure:"common"`
-	ACME            acme.Configuration    `json:"acme" mapstructure:"acme"`
-	SFTPD           sftpd.Configuration   `json:"sftpd" mapstructure:"sftpd"`
-	FTPD            ftpd.Configuration    `json:"ftpd" mapstructure:"ftpd"`
-	WebDAVD         webdavd.Configuration `json:"webdavd" mapstructure:"webdavd"`
-	ProviderConf    dataprovider.Config   `json:"data_provider" mapstructure:"data_provider"`
-	HTTPDConfig     httpd.Conf            `json:"httpd" mapstructure:"httpd"`
-	HTTPConfig      httpclient.Config     `json:"http" mapstructure:"http"`
-	CommandConfig   command.Config        `json:"command" mapstructure:"command"`
-	KMSConfig       kms.Configuration     `json:"kms" mapstructure:"kms"`
-	MFAConfig       mfa.Config            `json:"mfa" mapstructure:"mfa"`
-	TelemetryConfig telemetry.Conf        `json:"telemetry" mapstructure:"telemetry"`
-	PluginsConfig   []plugin.Config       `json:"plugins" mapstructure:"plugins"`
-	SMTPConfig      smtp.Config           `json:"smtp" mapstructure:"smtp"`
+	Common          common.Configuration    `json:"common" mapstructure:"common"`
+	ACME            acme.Configuration      `json:"acme" mapstructure:"acme"`
+	SFTPD           sftpd.Configuration     `json:"sftpd" mapstructure:"sftpd"`
+	FTPD            ftpd.Configuration      `json:"ftpd" mapstructure:"ftpd"`
+	WebDAVD         webdavd.Configuration   `json:"webdavd" mapstructure:"webdavd"`
+	S3Gateway       s3gateway.Configuration `json:"s3gateway" mapstructure:"s3gateway"`
+	GRPCD           grpcd.Configuration     `json:"grpcd" mapstructure:"grpcd"`
+	ProviderConf    dataprovider.Config     `json:"data_provider" mapstructure:"data_provider"`
+	HTTPDConfig     httpd.Conf              `json:"httpd" mapstructure:"httpd"`
+	HTTPConfig      httpclient.Config       `json:"http" mapstructure:"http"`
+	CommandConfig   command.Config          `json:"command" mapstructure:"command"`
+	KMSConfig       kms.Configuration       `json:"kms" mapstructure:"kms"`
+	MFAConfig       mfa.Config              `json:"mfa" mapstructure:"mfa"`
+	TelemetryConfig telemetry.Conf          `json:"telemetry" mapstructure:"telemetry"`
+	PluginsConfig   []plugin.Config         `json:"plugins" mapstructure:"plugins"`
+	SMTPConfig      smtp.Config             `json:"smtp" mapstructure:"smtp"`
 }
 
 func init() {
@@ -199,20 +260,23 @@ func Init() {
 				ExecuteSync: []string{},
 				Hook:        "",
 			},
-			SetstatMode:           0,
-			RenameMode:            0,
-			ResumeMaxSize:         0,
-			TempPath:              "",
-			ProxyProtocol:         0,
-			ProxyAllowed:          []string{},
-			ProxySkipped:          []string{},
-			PostConnectHook:       "",
-			PostDisconnectHook:    "",
-			DataRetentionHook:     "",
-			MaxTotalConnections:   0,
-			MaxPerHostConnections: 20,
-			AllowListStatus:       0,
-			AllowSelfConnections:  0,
+			SetstatMode:                  0,
+			RenameMode:                   0,
+			ResumeMaxSize:                0,
+			QuotaScanWorkers:             4,
+			TempPath:                     "",
+			ProxyProtocol:                0,
+			ProxyAllowed:                 []string{},
+			ProxySkipped:                 []string{},
+			PostConnectHook:              "",
+			PostDisconnectHook:           "",
+			DataRetentionHook:            "",
+			MaxTotalConnections:          0,
+			MaxPerHostConnections:        20,
+			ClusterSessionsCheckInterval: 0,
+			ReusePort:                    false,
+			AllowListStatus:              0,
+			AllowSelfConnections:         0,
 			DefenderConfig: common.DefenderConfig{
 				Enabled:            false,
 				Driver:             common.DefenderDriverMemory,
@@ -236,7 +300,28 @@ func Init() {
 			ServerVersion:      "",
 			TZ:                 "",
 			Metadata: common.MetadataConfig{
-				Read: 0,
+				Read:            0,
+				CacheTTL:        0,
+				CacheMaxEntries: 10000,
+			},
+			HTTPCompression: common.HTTPCompressionConfig{
+				Enabled:   false,
+				MinSize:   1024,
+				MimeTypes: []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"},
+			},
+			SearchIndex: common.SearchIndexConfig{
+				Enabled:         false,
+				Path:            "search_index.db",
+				RebuildInterval: 24,
+				Content: common.SearchContentConfig{
+					Enabled:     false,
+					MaxFileSize: 10485760,
+					MaxTextSize: 262144,
+					TextExtensions: []string{".txt", ".md", ".csv", ".json", ".xml", ".html", ".htm", ".log",
+						".yaml", ".yml"},
+					OfficeDocuments: true,
+					Extractors:      nil,
+				},
 			},
 		},
 		ACME: acme.Configuration{
@@ -258,6 +343,7 @@ func Init() {
 		SFTPD: sftpd.Configuration{
 			Bindings:                          []sftpd.Binding{defaultSFTPDBinding},
 			MaxAuthTries:                      0,
+			MaxChannelsPerConnection:          0,
 			HostKeys:                          []string{},
 			HostCertificates:                  []string{},
 			HostKeyAlgorithms:                 []string{},
@@ -268,11 +354,20 @@ func Init() {
 			PublicKeyAlgorithms:               []string{},
 			TrustedUserCAKeys:                 []string{},
 			RevokedUserCertsFile:              "",
+			UserCAKey:                         "",
+			UserCertValidity:                  60,
 			LoginBannerFile:                   "",
 			EnabledSSHCommands:                []string{},
 			KeyboardInteractiveAuthentication: true,
 			KeyboardInteractiveHook:           "",
 			PasswordAuthentication:            true,
+			GSSAPI: sftpd.GSSAPIConfig{
+				Enabled:           false,
+				Keytab:            "",
+				ServicePrincipal:  "",
+				Realm:             "",
+				PrincipalMappings: []sftpd.GSSAPIPrincipalMapping{},
+			},
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -290,6 +385,10 @@ func Init() {
 			CertificateKeyFile: "",
 			CACertificates:     []string{},
 			CARevocationLists:  []string{},
+			Tarpit: ftpd.TarpitConfig{
+				Delay:    0,
+				MaxDelay: 0,
+			},
 		},
 		WebDAVD: webdavd.Configuration{
 			Bindings:           []webdavd.Binding{defaultWebDAVDBinding},
@@ -321,6 +420,22 @@ func Init() {
 				},
 			},
 		},
+		S3Gateway: s3gateway.Configuration{
+			Bindings:            []s3gateway.Binding{defaultS3GatewayBinding},
+			CertificateFile:     "",
+			CertificateKeyFile:  "",
+			Region:              "us-east-1",
+			MultipartExpiration: 24,
+			UsersCache: s3gateway.UsersCacheConfig{
+				ExpirationTime: 0,
+				MaxSize:        50,
+			},
+		},
+		GRPCD: grpcd.Configuration{
+			Bindings:           []grpcd.Binding{defaultGRPCDBinding},
+			CertificateFile:    "",
+			CertificateKeyFile: "",
+		},
 		ProviderConf: dataprovider.Config{
 			Driver:             "sqlite",
 			Name:               "sftpgo.db",
@@ -338,14 +453,59 @@ func Init() {
 			ClientKey:          "",
 			TrackQuota:         2,
 			PoolSize:           0,
-			UsersBaseDir:       "",
+			ReadReplicas: dataprovider.ReadReplicasConfig{
+				ConnectionStrings: nil,
+				MaxLag:            10,
+			},
+			RedisCache: dataprovider.RedisCacheConfig{
+				URL:       "",
+				KeyPrefix: "sftpgo",
+				TTL:       300,
+			},
+			AuditLog: dataprovider.AuditLogConfig{
+				Enabled:    false,
+				HMACSecret: "",
+			},
+			ScheduledBackups: dataprovider.ScheduledBackupsConfig{
+				Schedule:       "",
+				Folder:         "",
+				Path:           "/",
+				AgeRecipients:  nil,
+				PassphraseFile: "",
+				Retention:      7,
+			},
+			UsersBaseDir: "",
 			Actions: dataprovider.ObjectsActions{
-				ExecuteOn:  []string{},
-				ExecuteFor: []string{},
-				Hook:       "",
+				ExecuteOn:   []string{},
+				ExecuteFor:  []string{},
+				Hook:        "",
+				IncludeDiff: false,
+			},
+			ExternalAuthHook:  "",
+			ExternalAuthScope: 0,
+			LDAPAuth: dataprovider.LDAPAuthConfig{
+				URL:                  "",
+				StartTLS:             false,
+				SkipTLSVerify:        false,
+				CACertificates:       nil,
+				BindDN:               "",
+				BindPassword:         "",
+				BaseDN:               "",
+				SearchFilter:         "(&(objectClass=person)(uid=%username%))",
+				RequiredGroups:       nil,
+				UsernameAttribute:    "",
+				GroupsAttribute:      "memberOf",
+				EmailAttribute:       "mail",
+				DescriptionAttribute: "",
+				HomeDirAttribute:     "",
+				QuotaSizeAttribute:   "",
+				QuotaFilesAttribute:  "",
+				PublicKeysAttribute:  "",
+				GroupMappings:        nil,
+				Timeout:              0,
+				ReadThrough:          false,
+				CacheTTL:             300,
 			},
-			ExternalAuthHook:   "",
-			ExternalAuthScope:  0,
 			PreLoginHook:       "",
 			PostLoginHook:      "",
 			PostLoginScope:     0,
@@ -414,6 +574,20 @@ func Init() {
 				InstallationCodeHint: defaultInstallCodeHint,
 			},
 			HideSupportLink: false,
+			Thumbnails: httpd.ThumbnailsConfig{
+				Enabled:      false,
+				CachePath:    "",
+				CacheSize:    100,
+				MaxFileSize:  20,
+				PDFCommand:   "",
+				VideoCommand: "",
+			},
+			WOPI: httpd.WOPIConfig{
+				Enabled:       false,
+				ServerURL:     "",
+				HostURL:       "",
+				TokenValidity: 600,
+			},
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
@@ -444,6 +618,7 @@ func Init() {
 			BindPort:           0,
 			BindAddress:        "127.0.0.1",
 			EnableProfiler:     false,
+			EnableUserMetrics:  false,
 			AuthUserFile:       "",
 			CertificateFile:    "",
 			CertificateKeyFile: "",
@@ -514,6 +689,26 @@ func SetWebDAVDConfig(config webdavd.Configuration) {
 	globalConf.WebDAVD = config
 }
 
+// GetS3GatewayConfig returns the configuration for the S3 compatible API
+func GetS3GatewayConfig() s3gateway.Configuration {
+	return globalConf.S3Gateway
+}
+
+// SetS3GatewayConfig sets the configuration for the S3 compatible API
+func SetS3GatewayConfig(config s3gateway.Configuration) {
+	globalConf.S3Gateway = config
+}
+
+// GetGRPCDConfig returns the configuration for the gRPC management API
+func GetGRPCDConfig() grpcd.Configuration {
+	return globalConf.GRPCD
+}
+
+// SetGRPCDConfig sets the configuration for the gRPC management API
+func SetGRPCDConfig(config grpcd.Configuration) {
+	globalConf.GRPCD = config
+}
+
 // GetHTTPDConfig returns the configuration for the HTTP server
 func GetHTTPDConfig() httpd.Conf {
 	return globalConf.HTTPDConfig
@@ -590,7 +785,7 @@ func GetACMEConfig() acme.Configuration {
 }
 
 // HasServicesToStart returns true if the config defines at least a service to start.
-// Supported services are SFTP, FTP and WebDAV
+// Supported services are SFTP, FTP, WebDAV, HTTP, gRPC and the S3 compatible API
 func HasServicesToStart() bool {
 	if globalConf.SFTPD.ShouldBind() {
 		return true
@@ -601,6 +796,12 @@ func HasServicesToStart() bool {
 	if globalConf.WebDAVD.ShouldBind() {
 		return true
 	}
+	if globalConf.S3Gateway.ShouldBind() {
+		return true
+	}
+	if globalConf.GRPCD.ShouldBind() {
+		return true
+	}
 	if globalConf.HTTPDConfig.ShouldBind() {
 		return true
 	}
@@ -627,6 +828,11 @@ func getRedactedGlobalConf() globalConfig {
 	conf.ProviderConf.Password = getRedactedPassword(conf.ProviderConf.Password)
 	conf.ProviderConf.Actions.Hook = util.GetRedactedURL(conf.ProviderConf.Actions.Hook)
 	conf.ProviderConf.ExternalAuthHook = util.GetRedactedURL(conf.ProviderConf.ExternalAuthHook)
+	conf.ProviderConf.LDAPAuth.BindPassword = getRedactedPassword(conf.ProviderConf.LDAPAuth.BindPassword)
+	if u, err := url.Parse(conf.ProviderConf.RedisCache.URL); err == nil {
+		conf.ProviderConf.RedisCache.URL = u.Redacted()
+	}
+	conf.ProviderConf.AuditLog.HMACSecret = getRedactedPassword(conf.ProviderConf.AuditLog.HMACSecret)
 	conf.ProviderConf.PreLoginHook = util.GetRedactedURL(conf.ProviderConf.PreLoginHook)
 	conf.ProviderConf.PostLoginHook = util.GetRedactedURL(conf.ProviderConf.PostLoginHook)
 	conf.ProviderConf.CheckPasswordHook = util.GetRedactedURL(conf.ProviderConf.CheckPasswordHook)
@@ -635,6 +841,13 @@ func getRedactedGlobalConf() globalConfig {
 	for _, binding := range globalConf.HTTPDConfig.Bindings {
 		binding.OIDC.ClientID = getRedactedPassword(binding.OIDC.ClientID)
 		binding.OIDC.ClientSecret = getRedactedPassword(binding.OIDC.ClientSecret)
+		providers := make([]httpd.OIDC, 0, len(binding.OIDCProviders))
+		for _, provider := range binding.OIDCProviders {
+			provider.ClientID = getRedactedPassword(provider.ClientID)
+			provider.ClientSecret = getRedactedPassword(provider.ClientSecret)
+			providers = append(providers, provider)
+		}
+		binding.OIDCProviders = providers
 		conf.HTTPDConfig.Bindings = append(conf.HTTPDConfig.Bindings, binding)
 	}
 	conf.PluginsConfig = nil
@@ -760,6 +973,19 @@ func LoadConfig(configDir, configFile string) error {
 	return nil
 }
 
+// ReloadConfig loads the configuration again starting from the default values,
+// so the settings removed from the configuration file are reset too.
+// The current configuration is preserved if the new one cannot be loaded
+func ReloadConfig(configDir, configFile string) error {
+	current := globalConf
+	Init()
+	if err := LoadConfig(configDir, configFile); err != nil {
+		globalConf = current
+		return err
+	}
+	return nil
+}
+
 func isProxyProtocolValid() bool {
 	return globalConf.Common.ProxyProtocol >= 0 && globalConf.Common.ProxyProtocol <= 2
 }
@@ -812,10 +1038,80 @@ func loadBindingsFromEnv() {
 		getSFTPDBindindFromEnv(idx)
 		getFTPDBindingFromEnv(idx)
 		getWebDAVDBindingFromEnv(idx)
+		getS3GatewayBindingFromEnv(idx)
+		getGRPCDBindingFromEnv(idx)
 		getHTTPDBindingFromEnv(idx)
 		getHTTPClientCertificatesFromEnv(idx)
 		getHTTPClientHeadersFromEnv(idx)
 		getCommandConfigsFromEnv(idx)
+		getSearchContentExtractorsFromEnv(idx)
+		getLDAPGroupMappingFromEnv(idx)
+		getLDAPRequiredGroupFromEnv(idx)
+		getReadReplicaFromEnv(idx)
+	}
+}
+
+// getReadReplicaFromEnv loads the read replicas connection strings using indexed env vars,
+// connection strings may contain commas so they cannot be defined as a comma separated list
+func getReadReplicaFromEnv(idx int) {
+	connString, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS__%v", idx))
+	if !ok {
+		return
+	}
+	if len(globalConf.ProviderConf.ReadReplicas.ConnectionStrings) > idx {
+		globalConf.ProviderConf.ReadReplicas.ConnectionStrings[idx] = connString
+	} else {
+		globalConf.ProviderConf.ReadReplicas.ConnectionStrings = append(globalConf.ProviderConf.ReadReplicas.ConnectionStrings,
+			connString)
+	}
+}
+
+// getLDAPRequiredGroupFromEnv loads the required groups using indexed env vars,
+// DNs contain commas so they cannot be defined as a comma separated list
+func getLDAPRequiredGroupFromEnv(idx int) {
+	group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_AUTH__REQUIRED_GROUPS__%v", idx))
+	if !ok {
+		return
+	}
+	if len(globalConf.ProviderConf.LDAPAuth.RequiredGroups) > idx {
+		globalConf.ProviderConf.LDAPAuth.RequiredGroups[idx] = group
+	} else {
+		globalConf.ProviderConf.LDAPAuth.RequiredGroups = append(globalConf.ProviderConf.LDAPAuth.RequiredGroups, group)
+	}
+}
+
+func getLDAPGroupMappingFromEnv(idx int) {
+	var mapping dataprovider.LDAPGroupMapping
+	if len(globalConf.ProviderConf.LDAPAuth.GroupMappings) > idx {
+		mapping = globalConf.ProviderConf.LDAPAuth.GroupMappings[idx]
+	}
+
+	isSet := false
+
+	ldapGroup, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__%v__LDAP_GROUP", idx))
+	if ok {
+		mapping.LDAPGroup = ldapGroup
+		isSet = true
+	}
+
+	group, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__%v__GROUP", idx))
+	if ok {
+		mapping.Group = group
+		isSet = true
+	}
+
+	groupType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__%v__TYPE", idx), 0)
+	if ok {
+		mapping.Type = int(groupType)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.ProviderConf.LDAPAuth.GroupMappings) > idx {
+			globalConf.ProviderConf.LDAPAuth.GroupMappings[idx] = mapping
+		} else {
+			globalConf.ProviderConf.LDAPAuth.GroupMappings = append(globalConf.ProviderConf.LDAPAuth.GroupMappings, mapping)
+		}
 	}
 }
 
@@ -845,6 +1141,12 @@ func getTOTPFromEnv(idx int) {
 		isSet = true
 	}
 
+	maxAge, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_MFA__TOTP__%v__TRUSTED_DEVICE_MAX_AGE", idx), 32)
+	if ok {
+		totpConfig.TrustedDeviceMaxAge = int(maxAge)
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.MFAConfig.TOTP) > idx {
 			globalConf.MFAConfig.TOTP[idx] = totpConfig
@@ -1143,6 +1445,48 @@ func getFTPDPassiveIPOverridesFromEnv(idx int) []ftpd.PassiveIPOverride {
 	return overrides
 }
 
+func getFTPDTLSUsernameMappingsFromEnv(idx int) []ftpd.TLSUsernameMapping {
+	var mappings []ftpd.TLSUsernameMapping
+	if len(globalConf.FTPD.Bindings) > idx {
+		mappings = globalConf.FTPD.Bindings[idx].TLSUsernameMappings
+	}
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		var mapping ftpd.TLSUsernameMapping
+		var replace bool
+		if len(globalConf.FTPD.Bindings) > idx && len(globalConf.FTPD.Bindings[idx].TLSUsernameMappings) > subIdx {
+			mapping = globalConf.FTPD.Bindings[idx].TLSUsernameMappings[subIdx]
+			replace = true
+		}
+
+		field, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_USERNAME_MAPPINGS__%v__FIELD", idx, subIdx))
+		if ok {
+			mapping.Field = field
+		}
+
+		re, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_USERNAME_MAPPINGS__%v__REGEXP", idx, subIdx))
+		if ok {
+			mapping.Regexp = re
+		}
+
+		replacement, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_USERNAME_MAPPINGS__%v__REPLACEMENT",
+			idx, subIdx))
+		if ok {
+			mapping.Replacement = replacement
+		}
+
+		if mapping.Field != "" && mapping.Regexp != "" {
+			if replace {
+				mappings[subIdx] = mapping
+			} else {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	return mappings
+}
+
 func getDefaultFTPDBinding(idx int) ftpd.Binding {
 	binding := defaultFTPDBinding
 	if len(globalConf.FTPD.Bindings) > idx {
@@ -1251,6 +1595,12 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tlsUsernameMappings := getFTPDTLSUsernameMappingsFromEnv(idx)
+	if len(tlsUsernameMappings) > 0 {
+		binding.TLSUsernameMappings = tlsUsernameMappings
+		isSet = true
+	}
+
 	passiveHost, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PASSIVE_HOST", idx))
 	if ok {
 		binding.PassiveHost = passiveHost
@@ -1263,6 +1613,12 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tenant, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TENANT", idx))
+	if ok {
+		binding.Tenant = tenant
+		isSet = true
+	}
+
 	if getFTPDBindingSecurityFromEnv(idx, &binding) {
 		isSet = true
 	}
@@ -1413,6 +1769,12 @@ func getWebDAVDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tenant, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__TENANT", idx))
+	if ok {
+		binding.Tenant = tenant
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.WebDAVD.Bindings) > idx {
 			globalConf.WebDAVD.Bindings[idx] = binding
@@ -1422,6 +1784,172 @@ func getWebDAVDBindingFromEnv(idx int) {
 	}
 }
 
+func getS3GatewayBindingHTTPSConfigsFromEnv(idx int, binding *s3gateway.Binding) bool {
+	isSet := false
+
+	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__ENABLE_HTTPS", idx))
+	if ok {
+		binding.EnableHTTPS = enableHTTPS
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	if ok {
+		binding.MinTLSVersion = int(tlsVer)
+		isSet = true
+	}
+
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getS3GatewayBindingProxyConfigsFromEnv(idx int, binding *s3gateway.Binding) bool {
+	isSet := false
+
+	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__PROXY_ALLOWED", idx))
+	if ok {
+		binding.ProxyAllowed = proxyAllowed
+		isSet = true
+	}
+
+	clientIPProxyHeader, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__CLIENT_IP_PROXY_HEADER", idx))
+	if ok {
+		binding.ClientIPProxyHeader = clientIPProxyHeader
+		isSet = true
+	}
+
+	clientIPHeaderDepth, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__CLIENT_IP_HEADER_DEPTH", idx), 0)
+	if ok {
+		binding.ClientIPHeaderDepth = int(clientIPHeaderDepth)
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getS3GatewayBindingFromEnv(idx int) {
+	binding := defaultS3GatewayBinding
+	if len(globalConf.S3Gateway.Bindings) > idx {
+		binding = globalConf.S3Gateway.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__PORT", idx), 0)
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_S3GATEWAY__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	if getS3GatewayBindingHTTPSConfigsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if getS3GatewayBindingProxyConfigsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.S3Gateway.Bindings) > idx {
+			globalConf.S3Gateway.Bindings[idx] = binding
+		} else {
+			globalConf.S3Gateway.Bindings = append(globalConf.S3Gateway.Bindings, binding)
+		}
+	}
+}
+
+func getGRPCDBindingTLSConfigsFromEnv(idx int, binding *grpcd.Binding) bool {
+	isSet := false
+
+	enableTLS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__ENABLE_TLS", idx))
+	if ok {
+		binding.EnableTLS = enableTLS
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__CERTIFICATE_FILE", idx))
+	if ok {
+		binding.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	certificateKeyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__CERTIFICATE_KEY_FILE", idx))
+	if ok {
+		binding.CertificateKeyFile = certificateKeyFile
+		isSet = true
+	}
+
+	tlsVer, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__MIN_TLS_VERSION", idx), 0)
+	if ok {
+		binding.MinTLSVersion = int(tlsVer)
+		isSet = true
+	}
+
+	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
+	if ok {
+		binding.TLSCipherSuites = tlsCiphers
+		isSet = true
+	}
+
+	return isSet
+}
+
+func getGRPCDBindingFromEnv(idx int) {
+	binding := defaultGRPCDBinding
+	if len(globalConf.GRPCD.Bindings) > idx {
+		binding = globalConf.GRPCD.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__PORT", idx), 0)
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_GRPCD__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	if getGRPCDBindingTLSConfigsFromEnv(idx, &binding) {
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.GRPCD.Bindings) > idx {
+			globalConf.GRPCD.Bindings[idx] = binding
+		} else {
+			globalConf.GRPCD.Bindings = append(globalConf.GRPCD.Bindings, binding)
+		}
+	}
+}
+
 func getHTTPDSecurityProxyHeadersFromEnv(idx int) []httpd.HTTPSProxyHeader {
 	var httpsProxyHeaders []httpd.HTTPSProxyHeader
 	if len(globalConf.HTTPDConfig.Bindings) > idx {
@@ -1556,75 +2084,315 @@ func getHTTPDOIDCFromEnv(idx int) (httpd.OIDC, bool) {
 	if len(globalConf.HTTPDConfig.Bindings) > idx {
 		result = globalConf.HTTPDConfig.Bindings[idx].OIDC
 	}
+
+	return getHTTPDOIDCConfFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC", idx), result)
+}
+
+func getHTTPDOIDCProvidersFromEnv(idx int) ([]httpd.OIDC, bool) {
+	var result []httpd.OIDC
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].OIDCProviders
+	}
+	isSet := false
+
+	for subIdx := 0; subIdx < 10; subIdx++ {
+		provider := defaultHTTPDBinding.OIDC
+		replace := false
+		if len(result) > subIdx {
+			provider = result[subIdx]
+			replace = true
+		}
+		provider, ok := getHTTPDOIDCConfFromEnv(
+			fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC_PROVIDERS__%v", idx, subIdx), provider)
+		if !ok {
+			continue
+		}
+		isSet = true
+		if replace {
+			result[subIdx] = provider
+		} else {
+			result = append(result, provider)
+		}
+	}
+
+	return result, isSet
+}
+
+func getHTTPDOIDCGroupMappingsFromEnv(prefix string, mappings []httpd.OIDCGroupMapping) ([]httpd.OIDCGroupMapping, bool) {
+	isSet := false
+
+	for idx := 0; idx < 10; idx++ {
+		var mapping httpd.OIDCGroupMapping
+		replace := false
+		if len(mappings) > idx {
+			mapping = mappings[idx]
+			replace = true
+		}
+		isMappingSet := false
+
+		claim, ok := os.LookupEnv(fmt.Sprintf("%s__%v__CLAIM", prefix, idx))
+		if ok {
+			mapping.Claim = claim
+			isMappingSet = true
+		}
+
+		group, ok := os.LookupEnv(fmt.Sprintf("%s__%v__GROUP", prefix, idx))
+		if ok {
+			mapping.Group = group
+			isMappingSet = true
+		}
+
+		groupType, ok := lookupIntFromEnv(fmt.Sprintf("%s__%v__TYPE", prefix, idx), 0)
+		if ok {
+			mapping.Type = int(groupType)
+			isMappingSet = true
+		}
+
+		if isMappingSet {
+			isSet = true
+			if replace {
+				mappings[idx] = mapping
+			} else {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	return mappings, isSet
+}
+
+func getHTTPDOIDCConfFromEnv(prefix string, result httpd.OIDC) (httpd.OIDC, bool) { //nolint:gocyclo
 	isSet := false
 
-	clientID, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CLIENT_ID", idx))
+	name, ok := os.LookupEnv(fmt.Sprintf("%s__NAME", prefix))
+	if ok {
+		result.Name = name
+		isSet = true
+	}
+
+	domains, ok := lookupStringListFromEnv(fmt.Sprintf("%s__DOMAINS", prefix))
+	if ok {
+		result.Domains = domains
+		isSet = true
+	}
+
+	clientID, ok := os.LookupEnv(fmt.Sprintf("%s__CLIENT_ID", prefix))
 	if ok {
 		result.ClientID = clientID
 		isSet = true
 	}
 
-	clientSecret, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CLIENT_SECRET", idx))
+	clientSecret, ok := os.LookupEnv(fmt.Sprintf("%s__CLIENT_SECRET", prefix))
 	if ok {
 		result.ClientSecret = clientSecret
 		isSet = true
 	}
 
-	clientSecretFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CLIENT_SECRET_FILE", idx))
+	clientSecretFile, ok := os.LookupEnv(fmt.Sprintf("%s__CLIENT_SECRET_FILE", prefix))
 	if ok {
 		result.ClientSecretFile = clientSecretFile
 		isSet = true
 	}
 
-	configURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CONFIG_URL", idx))
+	configURL, ok := os.LookupEnv(fmt.Sprintf("%s__CONFIG_URL", prefix))
 	if ok {
 		result.ConfigURL = configURL
 		isSet = true
 	}
 
-	redirectBaseURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__REDIRECT_BASE_URL", idx))
+	redirectBaseURL, ok := os.LookupEnv(fmt.Sprintf("%s__REDIRECT_BASE_URL", prefix))
 	if ok {
 		result.RedirectBaseURL = redirectBaseURL
 		isSet = true
 	}
 
-	usernameField, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__USERNAME_FIELD", idx))
+	usernameField, ok := os.LookupEnv(fmt.Sprintf("%s__USERNAME_FIELD", prefix))
 	if ok {
 		result.UsernameField = usernameField
 		isSet = true
 	}
 
-	scopes, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__SCOPES", idx))
+	scopes, ok := lookupStringListFromEnv(fmt.Sprintf("%s__SCOPES", prefix))
 	if ok {
 		result.Scopes = scopes
 		isSet = true
 	}
 
-	roleField, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__ROLE_FIELD", idx))
+	roleField, ok := os.LookupEnv(fmt.Sprintf("%s__ROLE_FIELD", prefix))
 	if ok {
 		result.RoleField = roleField
 		isSet = true
 	}
 
-	implicitRoles, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__IMPLICIT_ROLES", idx))
+	implicitRoles, ok := lookupBoolFromEnv(fmt.Sprintf("%s__IMPLICIT_ROLES", prefix))
 	if ok {
 		result.ImplicitRoles = implicitRoles
 		isSet = true
 	}
 
-	customFields, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__CUSTOM_FIELDS", idx))
+	customFields, ok := lookupStringListFromEnv(fmt.Sprintf("%s__CUSTOM_FIELDS", prefix))
 	if ok {
 		result.CustomFields = customFields
 		isSet = true
 	}
 
-	skipSignatureCheck, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", idx))
+	skipSignatureCheck, ok := lookupBoolFromEnv(fmt.Sprintf("%s__INSECURE_SKIP_SIGNATURE_CHECK", prefix))
 	if ok {
 		result.InsecureSkipSignatureCheck = skipSignatureCheck
 		isSet = true
 	}
 
-	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__DEBUG", idx))
+	groupsField, ok := os.LookupEnv(fmt.Sprintf("%s__GROUPS_FIELD", prefix))
+	if ok {
+		result.GroupsField = groupsField
+		isSet = true
+	}
+
+	groupMappings, ok := getHTTPDOIDCGroupMappingsFromEnv(fmt.Sprintf("%s__GROUP_MAPPINGS", prefix),
+		result.GroupMappings)
+	if ok {
+		result.GroupMappings = groupMappings
+		isSet = true
+	}
+
+	provisioningEnabled, ok := lookupBoolFromEnv(fmt.Sprintf("%s__PROVISIONING__ENABLED", prefix))
+	if ok {
+		result.Provisioning.Enabled = provisioningEnabled
+		isSet = true
+	}
+
+	provisioningHomeDir, ok := os.LookupEnv(fmt.Sprintf("%s__PROVISIONING__HOME_DIR", prefix))
+	if ok {
+		result.Provisioning.HomeDir = provisioningHomeDir
+		isSet = true
+	}
+
+	provisioningHomeDirField, ok := os.LookupEnv(fmt.Sprintf("%s__PROVISIONING__HOME_DIR_FIELD", prefix))
+	if ok {
+		result.Provisioning.HomeDirField = provisioningHomeDirField
+		isSet = true
+	}
+
+	provisioningPermissions, ok := lookupStringListFromEnv(fmt.Sprintf("%s__PROVISIONING__PERMISSIONS", prefix))
+	if ok {
+		result.Provisioning.Permissions = provisioningPermissions
+		isSet = true
+	}
+
+	provisioningGroups, ok := getHTTPDOIDCGroupMappingsFromEnv(fmt.Sprintf("%s__PROVISIONING__GROUPS", prefix),
+		result.Provisioning.Groups)
+	if ok {
+		result.Provisioning.Groups = provisioningGroups
+		isSet = true
+	}
+
+	provisioningQuotaSize, ok := lookupIntFromEnv(fmt.Sprintf("%s__PROVISIONING__QUOTA_SIZE", prefix), 64)
+	if ok {
+		result.Provisioning.QuotaSize = provisioningQuotaSize
+		isSet = true
+	}
+
+	provisioningQuotaFiles, ok := lookupIntFromEnv(fmt.Sprintf("%s__PROVISIONING__QUOTA_FILES", prefix), 32)
+	if ok {
+		result.Provisioning.QuotaFiles = int(provisioningQuotaFiles)
+		isSet = true
+	}
+
+	provisioningQuotaSizeField, ok := os.LookupEnv(fmt.Sprintf("%s__PROVISIONING__QUOTA_SIZE_FIELD", prefix))
+	if ok {
+		result.Provisioning.QuotaSizeField = provisioningQuotaSizeField
+		isSet = true
+	}
+
+	provisioningQuotaFilesField, ok := os.LookupEnv(fmt.Sprintf("%s__PROVISIONING__QUOTA_FILES_FIELD", prefix))
+	if ok {
+		result.Provisioning.QuotaFilesField = provisioningQuotaFilesField
+		isSet = true
+	}
+
+	debug, ok := lookupBoolFromEnv(fmt.Sprintf("%s__DEBUG", prefix))
+	if ok {
+		result.Debug = debug
+		isSet = true
+	}
+
+	return result, isSet
+}
+
+func getHTTPDSAMLFromEnv(idx int) (httpd.SAML, bool) {
+	result := defaultHTTPDBinding.SAML
+	if len(globalConf.HTTPDConfig.Bindings) > idx {
+		result = globalConf.HTTPDConfig.Bindings[idx].SAML
+	}
+	isSet := false
+
+	idpMetadata, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__IDP_METADATA", idx))
+	if ok {
+		result.IDPMetadata = idpMetadata
+		isSet = true
+	}
+
+	entityID, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__ENTITY_ID", idx))
+	if ok {
+		result.EntityID = entityID
+		isSet = true
+	}
+
+	baseURL, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__BASE_URL", idx))
+	if ok {
+		result.BaseURL = baseURL
+		isSet = true
+	}
+
+	certificateFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__CERTIFICATE_FILE", idx))
+	if ok {
+		result.CertificateFile = certificateFile
+		isSet = true
+	}
+
+	keyFile, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__KEY_FILE", idx))
+	if ok {
+		result.KeyFile = keyFile
+		isSet = true
+	}
+
+	signRequests, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__SIGN_REQUESTS", idx))
+	if ok {
+		result.SignRequests = signRequests
+		isSet = true
+	}
+
+	usernameAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__USERNAME_ATTRIBUTE", idx))
+	if ok {
+		result.UsernameAttribute = usernameAttribute
+		isSet = true
+	}
+
+	roleAttribute, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__ROLE_ATTRIBUTE", idx))
+	if ok {
+		result.RoleAttribute = roleAttribute
+		isSet = true
+	}
+
+	implicitRoles, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__IMPLICIT_ROLES", idx))
+	if ok {
+		result.ImplicitRoles = implicitRoles
+		isSet = true
+	}
+
+	customFields, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__CUSTOM_FIELDS", idx))
+	if ok {
+		result.CustomFields = customFields
+		isSet = true
+	}
+
+	allowIDPInitiated, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__ALLOW_IDP_INITIATED", idx))
+	if ok {
+		result.AllowIDPInitiated = allowIDPInitiated
+		isSet = true
+	}
+
+	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__SAML__DEBUG", idx))
 	if ok {
 		result.Debug = debug
 		isSet = true
@@ -1728,6 +2496,18 @@ func getHTTPDNestedObjectsFromEnv(idx int, binding *httpd.Binding) bool {
 		isSet = true
 	}
 
+	oidcProviders, ok := getHTTPDOIDCProvidersFromEnv(idx)
+	if ok {
+		binding.OIDCProviders = oidcProviders
+		isSet = true
+	}
+
+	saml, ok := getHTTPDSAMLFromEnv(idx)
+	if ok {
+		binding.SAML = saml
+		isSet = true
+	}
+
 	securityConf, ok := getHTTPDSecurityConfFromEnv(idx)
 	if ok {
 		binding.Security = securityConf
@@ -1825,6 +2605,12 @@ func getHTTPDBindingFromEnv(idx int) { //nolint:gocyclo
 		isSet = true
 	}
 
+	tenant, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__TENANT", idx))
+	if ok {
+		binding.Tenant = tenant
+		isSet = true
+	}
+
 	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ENABLE_HTTPS", idx))
 	if ok {
 		binding.EnableHTTPS = enableHTTPS
@@ -1972,6 +2758,31 @@ func getCommandConfigsFromEnv(idx int) {
 	}
 }
 
+func getSearchContentExtractorsFromEnv(idx int) {
+	cfg := common.ContentExtractorConfig{}
+	if len(globalConf.Common.SearchIndex.Content.Extractors) > idx {
+		cfg = globalConf.Common.SearchIndex.Content.Extractors[idx]
+	}
+
+	hook, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SEARCH_INDEX__CONTENT__EXTRACTORS__%v__HOOK", idx))
+	if ok {
+		cfg.Hook = hook
+	}
+
+	extensions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__SEARCH_INDEX__CONTENT__EXTRACTORS__%v__EXTENSIONS", idx))
+	if ok {
+		cfg.Extensions = extensions
+	}
+
+	if cfg.Hook != "" {
+		if len(globalConf.Common.SearchIndex.Content.Extractors) > idx {
+			globalConf.Common.SearchIndex.Content.Extractors[idx] = cfg
+		} else {
+			globalConf.Common.SearchIndex.Content.Extractors = append(globalConf.Common.SearchIndex.Content.Extractors, cfg)
+		}
+	}
+}
+
 func setViperDefaults() {
 	viper.SetDefault("common.idle_timeout", globalConf.Common.IdleTimeout)
 	viper.SetDefault("common.upload_mode", globalConf.Common.UploadMode)
@@ -1981,6 +2792,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
 	viper.SetDefault("common.rename_mode", globalConf.Common.RenameMode)
 	viper.SetDefault("common.resume_max_size", globalConf.Common.ResumeMaxSize)
+	viper.SetDefault("common.quota_scan_workers", globalConf.Common.QuotaScanWorkers)
 	viper.SetDefault("common.temp_path", globalConf.Common.TempPath)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
@@ -1990,6 +2802,8 @@ func setViperDefaults() {
 	viper.SetDefault("common.data_retention_hook", globalConf.Common.DataRetentionHook)
 	viper.SetDefault("common.max_total_connections", globalConf.Common.MaxTotalConnections)
 	viper.SetDefault("common.max_per_host_connections", globalConf.Common.MaxPerHostConnections)
+	viper.SetDefault("common.cluster_sessions_check_interval", globalConf.Common.ClusterSessionsCheckInterval)
+	viper.SetDefault("common.reuse_port", globalConf.Common.ReusePort)
 	viper.SetDefault("common.allowlist_status", globalConf.Common.AllowListStatus)
 	viper.SetDefault("common.allow_self_connections", globalConf.Common.AllowSelfConnections)
 	viper.SetDefault("common.defender.enabled", globalConf.Common.DefenderConfig.Enabled)
@@ -2010,6 +2824,19 @@ func setViperDefaults() {
 	viper.SetDefault("common.server_version", globalConf.Common.ServerVersion)
 	viper.SetDefault("common.tz", globalConf.Common.TZ)
 	viper.SetDefault("common.metadata.read", globalConf.Common.Metadata.Read)
+	viper.SetDefault("common.metadata.cache_ttl", globalConf.Common.Metadata.CacheTTL)
+	viper.SetDefault("common.metadata.cache_max_entries", globalConf.Common.Metadata.CacheMaxEntries)
+	viper.SetDefault("common.http_compression.enabled", globalConf.Common.HTTPCompression.Enabled)
+	viper.SetDefault("common.http_compression.min_size", globalConf.Common.HTTPCompression.MinSize)
+	viper.SetDefault("common.http_compression.mime_types", globalConf.Common.HTTPCompression.MimeTypes)
+	viper.SetDefault("common.search_index.enabled", globalConf.Common.SearchIndex.Enabled)
+	viper.SetDefault("common.search_index.path", globalConf.Common.SearchIndex.Path)
+	viper.SetDefault("common.search_index.rebuild_interval", globalConf.Common.SearchIndex.RebuildInterval)
+	viper.SetDefault("common.search_index.content.enabled", globalConf.Common.SearchIndex.Content.Enabled)
+	viper.SetDefault("common.search_index.content.max_file_size", globalConf.Common.SearchIndex.Content.MaxFileSize)
+	viper.SetDefault("common.search_index.content.max_text_size", globalConf.Common.SearchIndex.Content.MaxTextSize)
+	viper.SetDefault("common.search_index.content.text_extensions", globalConf.Common.SearchIndex.Content.TextExtensions)
+	viper.SetDefault("common.search_index.content.office_documents", globalConf.Common.SearchIndex.Content.OfficeDocuments)
 	viper.SetDefault("acme.email", globalConf.ACME.Email)
 	viper.SetDefault("acme.key_type", globalConf.ACME.KeyType)
 	viper.SetDefault("acme.certs_path", globalConf.ACME.CertsPath)
@@ -2021,6 +2848,7 @@ func setViperDefaults() {
 	viper.SetDefault("acme.http01_challenge.proxy_header", globalConf.ACME.HTTP01Challenge.ProxyHeader)
 	viper.SetDefault("acme.tls_alpn01_challenge.port", globalConf.ACME.TLSALPN01Challenge.Port)
 	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
+	viper.SetDefault("sftpd.max_channels_per_connection", globalConf.SFTPD.MaxChannelsPerConnection)
 	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
 	viper.SetDefault("sftpd.host_certificates", globalConf.SFTPD.HostCertificates)
 	viper.SetDefault("sftpd.host_key_algorithms", globalConf.SFTPD.HostKeyAlgorithms)
@@ -2031,11 +2859,17 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.public_key_algorithms", globalConf.SFTPD.PublicKeyAlgorithms)
 	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
 	viper.SetDefault("sftpd.revoked_user_certs_file", globalConf.SFTPD.RevokedUserCertsFile)
+	viper.SetDefault("sftpd.user_ca_key", globalConf.SFTPD.UserCAKey)
+	viper.SetDefault("sftpd.user_cert_validity", globalConf.SFTPD.UserCertValidity)
 	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
 	viper.SetDefault("sftpd.enabled_ssh_commands", sftpd.GetDefaultSSHCommands())
 	viper.SetDefault("sftpd.keyboard_interactive_authentication", globalConf.SFTPD.KeyboardInteractiveAuthentication)
 	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
 	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
+	viper.SetDefault("sftpd.gssapi.enabled", globalConf.SFTPD.GSSAPI.Enabled)
+	viper.SetDefault("sftpd.gssapi.keytab", globalConf.SFTPD.GSSAPI.Keytab)
+	viper.SetDefault("sftpd.gssapi.service_principal", globalConf.SFTPD.GSSAPI.ServicePrincipal)
+	viper.SetDefault("sftpd.gssapi.realm", globalConf.SFTPD.GSSAPI.Realm)
 	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
 	viper.SetDefault("ftpd.active_transfers_port_non_20", globalConf.FTPD.ActiveTransfersPortNon20)
 	viper.SetDefault("ftpd.passive_port_range.start", globalConf.FTPD.PassivePortRange.Start)
@@ -2044,6 +2878,8 @@ func setViperDefaults() {
 	viper.SetDefault("ftpd.enable_site", globalConf.FTPD.EnableSite)
 	viper.SetDefault("ftpd.hash_support", globalConf.FTPD.HASHSupport)
 	viper.SetDefault("ftpd.combine_support", globalConf.FTPD.CombineSupport)
+	viper.SetDefault("ftpd.tarpit.delay", globalConf.FTPD.Tarpit.Delay)
+	viper.SetDefault("ftpd.tarpit.max_delay", globalConf.FTPD.Tarpit.MaxDelay)
 	viper.SetDefault("ftpd.certificate_file", globalConf.FTPD.CertificateFile)
 	viper.SetDefault("ftpd.certificate_key_file", globalConf.FTPD.CertificateKeyFile)
 	viper.SetDefault("ftpd.ca_certificates", globalConf.FTPD.CACertificates)
@@ -2067,6 +2903,14 @@ func setViperDefaults() {
 	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
 	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
 	viper.SetDefault("webdavd.cache.mime_types.custom_mappings", globalConf.WebDAVD.Cache.MimeTypes.CustomMappings)
+	viper.SetDefault("s3gateway.certificate_file", globalConf.S3Gateway.CertificateFile)
+	viper.SetDefault("s3gateway.certificate_key_file", globalConf.S3Gateway.CertificateKeyFile)
+	viper.SetDefault("s3gateway.region", globalConf.S3Gateway.Region)
+	viper.SetDefault("s3gateway.multipart_expiration", globalConf.S3Gateway.MultipartExpiration)
+	viper.SetDefault("s3gateway.users_cache.expiration_time", globalConf.S3Gateway.UsersCache.ExpirationTime)
+	viper.SetDefault("s3gateway.users_cache.max_size", globalConf.S3Gateway.UsersCache.MaxSize)
+	viper.SetDefault("grpcd.certificate_file", globalConf.GRPCD.CertificateFile)
+	viper.SetDefault("grpcd.certificate_key_file", globalConf.GRPCD.CertificateKeyFile)
 	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
 	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
 	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
@@ -2083,12 +2927,44 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.sql_tables_prefix", globalConf.ProviderConf.SQLTablesPrefix)
 	viper.SetDefault("data_provider.track_quota", globalConf.ProviderConf.TrackQuota)
 	viper.SetDefault("data_provider.pool_size", globalConf.ProviderConf.PoolSize)
+	viper.SetDefault("data_provider.read_replicas.max_lag", globalConf.ProviderConf.ReadReplicas.MaxLag)
+	viper.SetDefault("data_provider.redis_cache.url", globalConf.ProviderConf.RedisCache.URL)
+	viper.SetDefault("data_provider.redis_cache.key_prefix", globalConf.ProviderConf.RedisCache.KeyPrefix)
+	viper.SetDefault("data_provider.redis_cache.ttl", globalConf.ProviderConf.RedisCache.TTL)
+	viper.SetDefault("data_provider.audit_log.enabled", globalConf.ProviderConf.AuditLog.Enabled)
+	viper.SetDefault("data_provider.audit_log.hmac_secret", globalConf.ProviderConf.AuditLog.HMACSecret)
+	viper.SetDefault("data_provider.scheduled_backups.schedule", globalConf.ProviderConf.ScheduledBackups.Schedule)
+	viper.SetDefault("data_provider.scheduled_backups.folder", globalConf.ProviderConf.ScheduledBackups.Folder)
+	viper.SetDefault("data_provider.scheduled_backups.path", globalConf.ProviderConf.ScheduledBackups.Path)
+	viper.SetDefault("data_provider.scheduled_backups.age_recipients", globalConf.ProviderConf.ScheduledBackups.AgeRecipients)
+	viper.SetDefault("data_provider.scheduled_backups.passphrase_file", globalConf.ProviderConf.ScheduledBackups.PassphraseFile)
+	viper.SetDefault("data_provider.scheduled_backups.retention", globalConf.ProviderConf.ScheduledBackups.Retention)
 	viper.SetDefault("data_provider.users_base_dir", globalConf.ProviderConf.UsersBaseDir)
 	viper.SetDefault("data_provider.actions.execute_on", globalConf.ProviderConf.Actions.ExecuteOn)
 	viper.SetDefault("data_provider.actions.execute_for", globalConf.ProviderConf.Actions.ExecuteFor)
 	viper.SetDefault("data_provider.actions.hook", globalConf.ProviderConf.Actions.Hook)
+	viper.SetDefault("data_provider.actions.include_diff", globalConf.ProviderConf.Actions.IncludeDiff)
 	viper.SetDefault("data_provider.external_auth_hook", globalConf.ProviderConf.ExternalAuthHook)
 	viper.SetDefault("data_provider.external_auth_scope", globalConf.ProviderConf.ExternalAuthScope)
+	viper.SetDefault("data_provider.ldap_auth.url", globalConf.ProviderConf.LDAPAuth.URL)
+	viper.SetDefault("data_provider.ldap_auth.start_tls", globalConf.ProviderConf.LDAPAuth.StartTLS)
+	viper.SetDefault("data_provider.ldap_auth.skip_tls_verify", globalConf.ProviderConf.LDAPAuth.SkipTLSVerify)
+	viper.SetDefault("data_provider.ldap_auth.ca_certificates", globalConf.ProviderConf.LDAPAuth.CACertificates)
+	viper.SetDefault("data_provider.ldap_auth.bind_dn", globalConf.ProviderConf.LDAPAuth.BindDN)
+	viper.SetDefault("data_provider.ldap_auth.bind_password", globalConf.ProviderConf.LDAPAuth.BindPassword)
+	viper.SetDefault("data_provider.ldap_auth.base_dn", globalConf.ProviderConf.LDAPAuth.BaseDN)
+	viper.SetDefault("data_provider.ldap_auth.search_filter", globalConf.ProviderConf.LDAPAuth.SearchFilter)
+	viper.SetDefault("data_provider.ldap_auth.username_attribute", globalConf.ProviderConf.LDAPAuth.UsernameAttribute)
+	viper.SetDefault("data_provider.ldap_auth.groups_attribute", globalConf.ProviderConf.LDAPAuth.GroupsAttribute)
+	viper.SetDefault("data_provider.ldap_auth.email_attribute", globalConf.ProviderConf.LDAPAuth.EmailAttribute)
+	viper.SetDefault("data_provider.ldap_auth.description_attribute", globalConf.ProviderConf.LDAPAuth.DescriptionAttribute)
+	viper.SetDefault("data_provider.ldap_auth.home_dir_attribute", globalConf.ProviderConf.LDAPAuth.HomeDirAttribute)
+	viper.SetDefault("data_provider.ldap_auth.quota_size_attribute", globalConf.ProviderConf.LDAPAuth.QuotaSizeAttribute)
+	viper.SetDefault("data_provider.ldap_auth.quota_files_attribute", globalConf.ProviderConf.LDAPAuth.QuotaFilesAttribute)
+	viper.SetDefault("data_provider.ldap_auth.public_keys_attribute", globalConf.ProviderConf.LDAPAuth.PublicKeysAttribute)
+	viper.SetDefault("data_provider.ldap_auth.timeout", globalConf.ProviderConf.LDAPAuth.Timeout)
+	viper.SetDefault("data_provider.ldap_auth.read_through", globalConf.ProviderConf.LDAPAuth.ReadThrough)
+	viper.SetDefault("data_provider.ldap_auth.cache_ttl", globalConf.ProviderConf.LDAPAuth.CacheTTL)
 	viper.SetDefault("data_provider.pre_login_hook", globalConf.ProviderConf.PreLoginHook)
 	viper.SetDefault("data_provider.post_login_hook", globalConf.ProviderConf.PostLoginHook)
 	viper.SetDefault("data_provider.post_login_scope", globalConf.ProviderConf.PostLoginScope)
@@ -2136,6 +3012,16 @@ func setViperDefaults() {
 	viper.SetDefault("httpd.setup.installation_code", globalConf.HTTPDConfig.Setup.InstallationCode)
 	viper.SetDefault("httpd.setup.installation_code_hint", globalConf.HTTPDConfig.Setup.InstallationCodeHint)
 	viper.SetDefault("httpd.hide_support_link", globalConf.HTTPDConfig.HideSupportLink)
+	viper.SetDefault("httpd.thumbnails.enabled", globalConf.HTTPDConfig.Thumbnails.Enabled)
+	viper.SetDefault("httpd.thumbnails.cache_path", globalConf.HTTPDConfig.Thumbnails.CachePath)
+	viper.SetDefault("httpd.thumbnails.cache_size", globalConf.HTTPDConfig.Thumbnails.CacheSize)
+	viper.SetDefault("httpd.thumbnails.max_file_size", globalConf.HTTPDConfig.Thumbnails.MaxFileSize)
+	viper.SetDefault("httpd.thumbnails.pdf_command", globalConf.HTTPDConfig.Thumbnails.PDFCommand)
+	viper.SetDefault("httpd.thumbnails.video_command", globalConf.HTTPDConfig.Thumbnails.VideoCommand)
+	viper.SetDefault("httpd.wopi.enabled", globalConf.HTTPDConfig.WOPI.Enabled)
+	viper.SetDefault("httpd.wopi.server_url", globalConf.HTTPDConfig.WOPI.ServerURL)
+	viper.SetDefault("httpd.wopi.host_url", globalConf.HTTPDConfig.WOPI.HostURL)
+	viper.SetDefault("httpd.wopi.token_validity", globalConf.HTTPDConfig.WOPI.TokenValidity)
 	viper.SetDefault("http.timeout", globalConf.HTTPConfig.Timeout)
 	viper.SetDefault("http.retry_wait_min", globalConf.HTTPConfig.RetryWaitMin)
 	viper.SetDefault("http.retry_wait_max", globalConf.HTTPConfig.RetryWaitMax)
@@ -2150,6 +3036,7 @@ func setViperDefaults() {
 	viper.SetDefault("telemetry.bind_port", globalConf.TelemetryConfig.BindPort)
 	viper.SetDefault("telemetry.bind_address", globalConf.TelemetryConfig.BindAddress)
 	viper.SetDefault("telemetry.enable_profiler", globalConf.TelemetryConfig.EnableProfiler)
+	viper.SetDefault("telemetry.enable_user_metrics", globalConf.TelemetryConfig.EnableUserMetrics)
 	viper.SetDefault("telemetry.auth_user_file", globalConf.TelemetryConfig.AuthUserFile)
 	viper.SetDefault("telemetry.certificate_file", globalConf.TelemetryConfig.CertificateFile)
 	viper.SetDefault("telemetry.certificate_key_file", globalConf.TelemetryConfig.CertificateKeyFile)
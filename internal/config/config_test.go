@@ -88,7 +88,7 @@ func TestLoadConfigFileNotFound(t *testing.T) {
 	mfaConf := config.GetMFAConfig()
 	require.Len(t, mfaConf.TOTP, 1)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
-	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 4)
+	require.Len(t, config.GetCommonConfig().RateLimitersConfig[0].Protocols, 5)
 	require.Len(t, config.GetHTTPDConfig().Bindings, 1)
 	require.Len(t, config.GetHTTPDConfig().Bindings[0].OIDC.Scopes, 3)
 }
@@ -172,6 +172,38 @@ func TestInvalidExternalAuthScope(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestReloadConfig(t *testing.T) {
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	commonConf := config.GetCommonConfig()
+	commonConf.IdleTimeout = 5
+	c := make(map[string]common.Configuration)
+	c["common"] = commonConf
+	jsonConf, err := json.Marshal(c)
+	assert.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	assert.NoError(t, err)
+	err = config.ReloadConfig(configDir, confName)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, config.GetCommonConfig().IdleTimeout)
+	// an invalid configuration file must not change the current configuration
+	err = os.WriteFile(configFilePath, []byte("{invalid json"), os.ModePerm)
+	assert.NoError(t, err)
+	err = config.ReloadConfig(configDir, confName)
+	assert.Error(t, err)
+	assert.Equal(t, 5, config.GetCommonConfig().IdleTimeout)
+	// the removed settings are reset to the default values
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+	err = config.ReloadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 15, config.GetCommonConfig().IdleTimeout)
+}
+
 func TestInvalidProxyProtocol(t *testing.T) {
 	reset()
 
@@ -399,11 +431,13 @@ func TestMFAFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_MFA__TOTP__1__NAME", "additional_name")
 	os.Setenv("SFTPGO_MFA__TOTP__1__ISSUER", "additional_issuer")
 	os.Setenv("SFTPGO_MFA__TOTP__1__ALGO", "sha256")
+	os.Setenv("SFTPGO_MFA__TOTP__1__TRUSTED_DEVICE_MAX_AGE", "30")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_MFA__TOTP__0__NAME")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__NAME")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__ISSUER")
 		os.Unsetenv("SFTPGO_MFA__TOTP__1__ALGO")
+		os.Unsetenv("SFTPGO_MFA__TOTP__1__TRUSTED_DEVICE_MAX_AGE")
 	})
 
 	err := config.LoadConfig(configDir, "")
@@ -416,6 +450,135 @@ func TestMFAFromEnv(t *testing.T) {
 	require.Equal(t, "additional_name", mfaConf.TOTP[1].Name)
 	require.Equal(t, "additional_issuer", mfaConf.TOTP[1].Issuer)
 	require.Equal(t, "sha256", mfaConf.TOTP[1].Algo)
+	require.Equal(t, 0, mfaConf.TOTP[0].TrustedDeviceMaxAge)
+	require.Equal(t, 30, mfaConf.TOTP[1].TrustedDeviceMaxAge)
+}
+
+func TestLDAPAuthFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__URL", "ldaps://ldap.example.com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__BASE_DN", "dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__REQUIRED_GROUPS__0", "cn=sftp,dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__REQUIRED_GROUPS__1", "cn=ftp,dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__LDAP_GROUP", "cn=admins,dc=example,dc=com")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__GROUP", "admins")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__TYPE", "2")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__HOME_DIR_ATTRIBUTE", "homeDirectory")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__READ_THROUGH", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__CACHE_TTL", "60")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__BASE_DN")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__REQUIRED_GROUPS__0")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__REQUIRED_GROUPS__1")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__LDAP_GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__GROUP_MAPPINGS__0__TYPE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__HOME_DIR_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__READ_THROUGH")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__LDAP_AUTH__CACHE_TTL")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	ldapConf := config.GetProviderConf().LDAPAuth
+	assert.Equal(t, "ldaps://ldap.example.com", ldapConf.URL)
+	assert.Equal(t, "dc=example,dc=com", ldapConf.BaseDN)
+	assert.Equal(t, "(&(objectClass=person)(uid=%username%))", ldapConf.SearchFilter)
+	assert.Equal(t, "memberOf", ldapConf.GroupsAttribute)
+	assert.Equal(t, []string{"cn=sftp,dc=example,dc=com", "cn=ftp,dc=example,dc=com"}, ldapConf.RequiredGroups)
+	require.Len(t, ldapConf.GroupMappings, 1)
+	assert.Equal(t, "cn=admins,dc=example,dc=com", ldapConf.GroupMappings[0].LDAPGroup)
+	assert.Equal(t, "admins", ldapConf.GroupMappings[0].Group)
+	assert.Equal(t, 2, ldapConf.GroupMappings[0].Type)
+	assert.Equal(t, "homeDirectory", ldapConf.HomeDirAttribute)
+	assert.Empty(t, ldapConf.PublicKeysAttribute)
+	assert.True(t, ldapConf.ReadThrough)
+	assert.Equal(t, 60, ldapConf.CacheTTL)
+}
+
+func TestReadReplicasFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS__0", "host=replica1 port=5432")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS__1", "host=replica2,replica3")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG", "30")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS__0")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS__1")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	replicasConf := config.GetProviderConf().ReadReplicas
+	assert.Equal(t, []string{"host=replica1 port=5432", "host=replica2,replica3"}, replicasConf.ConnectionStrings)
+	assert.Equal(t, 30, replicasConf.MaxLag)
+}
+
+func TestRedisCacheFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__REDIS_CACHE__URL", "redis://:pwd@localhost:6379/1")
+	os.Setenv("SFTPGO_DATA_PROVIDER__REDIS_CACHE__TTL", "60")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__REDIS_CACHE__URL")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__REDIS_CACHE__TTL")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	cacheConf := config.GetProviderConf().RedisCache
+	assert.Equal(t, "redis://:pwd@localhost:6379/1", cacheConf.URL)
+	assert.Equal(t, "sftpgo", cacheConf.KeyPrefix)
+	assert.Equal(t, 60, cacheConf.TTL)
+}
+
+func TestAuditLogFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__AUDIT_LOG__ENABLED", "true")
+	os.Setenv("SFTPGO_DATA_PROVIDER__AUDIT_LOG__HMAC_SECRET", "secret")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__AUDIT_LOG__ENABLED")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__AUDIT_LOG__HMAC_SECRET")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	auditConf := config.GetProviderConf().AuditLog
+	assert.True(t, auditConf.Enabled)
+	assert.Equal(t, "secret", auditConf.HMACSecret)
+}
+
+func TestScheduledBackupsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__SCHEDULE", "0 2 * * *")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__FOLDER", "backups")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__PATH", "/sftpgo")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__AGE_RECIPIENTS", "age1a,age1b")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__PASSPHRASE_FILE", "passphrase")
+	os.Setenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__RETENTION", "30")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__SCHEDULE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__FOLDER")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__PATH")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__AGE_RECIPIENTS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__PASSPHRASE_FILE")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__SCHEDULED_BACKUPS__RETENTION")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	backupsConf := config.GetProviderConf().ScheduledBackups
+	assert.Equal(t, "0 2 * * *", backupsConf.Schedule)
+	assert.Equal(t, "backups", backupsConf.Folder)
+	assert.Equal(t, "/sftpgo", backupsConf.Path)
+	assert.Equal(t, []string{"age1a", "age1b"}, backupsConf.AgeRecipients)
+	assert.Equal(t, "passphrase", backupsConf.PassphraseFile)
+	assert.Equal(t, 30, backupsConf.Retention)
 }
 
 func TestDisabledMFAConfig(t *testing.T) {
@@ -480,7 +643,7 @@ func TestOverrideSliceValues(t *testing.T) {
 	assert.NoError(t, err)
 	require.Len(t, config.GetCommonConfig().RateLimitersConfig, 1)
 	rl := config.GetCommonConfig().RateLimitersConfig[0]
-	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP"}, rl.Protocols)
+	require.Equal(t, []string{"SSH", "FTP", "DAV", "HTTP", "S3"}, rl.Protocols)
 	require.Equal(t, int64(1000), rl.Period)
 
 	reset()
@@ -570,6 +733,32 @@ func TestFTPDOverridesFromEnv(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestFTPDTLSUsernameMappingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__FIELD", "CN")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__REGEXP", "^(.+)\\.example\\.com$")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__REPLACEMENT", "$1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__1__FIELD", "email")
+	cleanup := func() {
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__FIELD")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__REGEXP")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__0__REPLACEMENT")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__0__TLS_USERNAME_MAPPINGS__1__FIELD")
+	}
+	t.Cleanup(cleanup)
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	ftpdConf := config.GetFTPDConfig()
+	require.Len(t, ftpdConf.Bindings, 1)
+	// the second mapping has no regexp and so it is ignored
+	require.Len(t, ftpdConf.Bindings[0].TLSUsernameMappings, 1)
+	require.Equal(t, "CN", ftpdConf.Bindings[0].TLSUsernameMappings[0].Field)
+	require.Equal(t, "^(.+)\\.example\\.com$", ftpdConf.Bindings[0].TLSUsernameMappings[0].Regexp)
+	require.Equal(t, "$1", ftpdConf.Bindings[0].TLSUsernameMappings[0].Replacement)
+}
+
 func TestHTTPDSubObjectsFromEnv(t *testing.T) {
 	reset()
 
@@ -798,11 +987,12 @@ func TestRateLimitersFromEnv(t *testing.T) {
 	require.Equal(t, 1, limiters[1].Burst)
 	require.Equal(t, 2, limiters[1].Type)
 	protocols = limiters[1].Protocols
-	require.Len(t, protocols, 4)
+	require.Len(t, protocols, 5)
 	require.True(t, util.Contains(protocols, common.ProtocolFTP))
 	require.True(t, util.Contains(protocols, common.ProtocolSSH))
 	require.True(t, util.Contains(protocols, common.ProtocolWebDAV))
 	require.True(t, util.Contains(protocols, common.ProtocolHTTP))
+	require.True(t, util.Contains(protocols, common.ProtocolS3))
 	require.False(t, limiters[1].GenerateDefenderEvents)
 	require.Equal(t, 100, limiters[1].EntriesSoftLimit)
 	require.Equal(t, 150, limiters[1].EntriesHardLimit)
@@ -1147,6 +1337,96 @@ func TestWebDAVBindingsFromEnv(t *testing.T) {
 	require.True(t, bindings[2].DisableWWWAuthHeader)
 }
 
+func TestS3GatewayBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__PORT", "9000")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__ENABLE_HTTPS", "1")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_RSA_WITH_AES_128_CBC_SHA ")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__CERTIFICATE_FILE", "s3.crt")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__CERTIFICATE_KEY_FILE", "s3.key")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__PROXY_ALLOWED", "192.168.10.1")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__CLIENT_IP_PROXY_HEADER", "X-Forwarded-For")
+	os.Setenv("SFTPGO_S3GATEWAY__BINDINGS__1__CLIENT_IP_HEADER_DEPTH", "2")
+	os.Setenv("SFTPGO_S3GATEWAY__REGION", "eu-west-1")
+	os.Setenv("SFTPGO_S3GATEWAY__MULTIPART_EXPIRATION", "12")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__ENABLE_HTTPS")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__CERTIFICATE_KEY_FILE")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__PROXY_ALLOWED")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__CLIENT_IP_PROXY_HEADER")
+		os.Unsetenv("SFTPGO_S3GATEWAY__BINDINGS__1__CLIENT_IP_HEADER_DEPTH")
+		os.Unsetenv("SFTPGO_S3GATEWAY__REGION")
+		os.Unsetenv("SFTPGO_S3GATEWAY__MULTIPART_EXPIRATION")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	s3Config := config.GetS3GatewayConfig()
+	assert.Equal(t, "eu-west-1", s3Config.Region)
+	assert.Equal(t, 12, s3Config.MultipartExpiration)
+	bindings := s3Config.Bindings
+	require.Len(t, bindings, 2)
+	require.Equal(t, 0, bindings[0].Port)
+	require.False(t, bindings[0].EnableHTTPS)
+	require.Equal(t, 9000, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.True(t, bindings[1].EnableHTTPS)
+	require.Equal(t, 13, bindings[1].MinTLSVersion)
+	require.Len(t, bindings[1].TLSCipherSuites, 1)
+	require.Equal(t, "TLS_RSA_WITH_AES_128_CBC_SHA", bindings[1].TLSCipherSuites[0])
+	require.Equal(t, "s3.crt", bindings[1].CertificateFile)
+	require.Equal(t, "s3.key", bindings[1].CertificateKeyFile)
+	require.Equal(t, "192.168.10.1", bindings[1].ProxyAllowed[0])
+	require.Equal(t, "X-Forwarded-For", bindings[1].ClientIPProxyHeader)
+	require.Equal(t, 2, bindings[1].ClientIPHeaderDepth)
+}
+
+func TestGRPCDBindingsFromEnv(t *testing.T) {
+	reset()
+
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__ADDRESS", "127.0.0.1")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__PORT", "9090")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__ENABLE_TLS", "1")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__MIN_TLS_VERSION", "13")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__TLS_CIPHER_SUITES", "TLS_RSA_WITH_AES_128_CBC_SHA ")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_FILE", "grpc.crt")
+	os.Setenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_KEY_FILE", "grpc.key")
+
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__ADDRESS")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__PORT")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__ENABLE_TLS")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__MIN_TLS_VERSION")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__TLS_CIPHER_SUITES")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_GRPCD__BINDINGS__1__CERTIFICATE_KEY_FILE")
+	})
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	bindings := config.GetGRPCDConfig().Bindings
+	require.Len(t, bindings, 2)
+	require.Equal(t, 0, bindings[0].Port)
+	require.False(t, bindings[0].EnableTLS)
+	require.Equal(t, 9090, bindings[1].Port)
+	require.Equal(t, "127.0.0.1", bindings[1].Address)
+	require.True(t, bindings[1].EnableTLS)
+	require.Equal(t, 13, bindings[1].MinTLSVersion)
+	require.Len(t, bindings[1].TLSCipherSuites, 1)
+	require.Equal(t, "TLS_RSA_WITH_AES_128_CBC_SHA", bindings[1].TLSCipherSuites[0])
+	require.Equal(t, "grpc.crt", bindings[1].CertificateFile)
+	require.Equal(t, "grpc.key", bindings[1].CertificateKeyFile)
+}
+
 func TestHTTPDBindingsFromEnv(t *testing.T) {
 	reset()
 
@@ -1188,6 +1468,37 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS", "field1,field2")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DOMAINS", "example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUPS_FIELD", "groups")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__CLAIM", "sales")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__GROUP", "sftpgo-sales")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__TYPE", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__ENABLED", "true")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__HOME_DIR", "/srv/%username%")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__PERMISSIONS", "list,download")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__GROUPS__0__GROUP", "default")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__HOME_DIR_FIELD", "home")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_SIZE", "1048576")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_FILES", "100")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_SIZE_FIELD", "quota.size")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_FILES_FIELD", "quota.files")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__NAME", "Partner")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__DOMAINS", "partner.com, partner.net")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__CLIENT_ID", "partner client id")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__CONFIG_URL", "partner config url")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__USERNAME_FIELD", "email")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IDP_METADATA", "https://idp.example.com/metadata")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ENTITY_ID", "sftpgo")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__BASE_URL", "https://sftpgo.example.com")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CERTIFICATE_FILE", "saml.crt")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__KEY_FILE", "saml.key")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__SIGN_REQUESTS", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__USERNAME_ATTRIBUTE", "uid")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ROLE_ATTRIBUTE", "sftpgo_role")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IMPLICIT_ROLES", "1")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CUSTOM_FIELDS", "mail,department")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ALLOW_IDP_INITIATED", "true")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SAML__DEBUG", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED", "true")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS", "*.example.com,*.example.net")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS_ARE_REGEX", "1")
@@ -1252,6 +1563,37 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DOMAINS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUPS_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__CLAIM")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__GROUP")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__GROUP_MAPPINGS__0__TYPE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__HOME_DIR")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__PERMISSIONS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__GROUPS__0__GROUP")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__HOME_DIR_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_SIZE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_FILES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_SIZE_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__PROVISIONING__QUOTA_FILES_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__NAME")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__DOMAINS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__CLIENT_ID")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__CONFIG_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC_PROVIDERS__0__USERNAME_FIELD")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IDP_METADATA")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ENTITY_ID")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__BASE_URL")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CERTIFICATE_FILE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__KEY_FILE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__SIGN_REQUESTS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__USERNAME_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ROLE_ATTRIBUTE")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__IMPLICIT_ROLES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__CUSTOM_FIELDS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__ALLOW_IDP_INITIATED")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SAML__DEBUG")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ALLOWED_HOSTS_ARE_REGEX")
@@ -1302,6 +1644,9 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Len(t, bindings[0].OIDC.Scopes, 3)
 	require.False(t, bindings[0].OIDC.InsecureSkipSignatureCheck)
 	require.False(t, bindings[0].OIDC.Debug)
+	require.Empty(t, bindings[0].SAML.IDPMetadata)
+	require.Len(t, bindings[0].OIDCProviders, 0)
+	require.False(t, bindings[0].SAML.SignRequests)
 	require.Equal(t, 8000, bindings[1].Port)
 	require.Equal(t, "127.0.0.1", bindings[1].Address)
 	require.False(t, bindings[1].EnableHTTPS)
@@ -1357,6 +1702,42 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Equal(t, "field2", bindings[2].OIDC.CustomFields[1])
 	require.True(t, bindings[2].OIDC.InsecureSkipSignatureCheck)
 	require.True(t, bindings[2].OIDC.Debug)
+	require.Equal(t, []string{"example.com"}, bindings[2].OIDC.Domains)
+	require.Equal(t, "groups", bindings[2].OIDC.GroupsField)
+	require.Len(t, bindings[2].OIDC.GroupMappings, 1)
+	require.Equal(t, "sales", bindings[2].OIDC.GroupMappings[0].Claim)
+	require.Equal(t, "sftpgo-sales", bindings[2].OIDC.GroupMappings[0].Group)
+	require.Equal(t, 1, bindings[2].OIDC.GroupMappings[0].Type)
+	require.True(t, bindings[2].OIDC.Provisioning.Enabled)
+	require.Equal(t, "/srv/%username%", bindings[2].OIDC.Provisioning.HomeDir)
+	require.Equal(t, []string{"list", "download"}, bindings[2].OIDC.Provisioning.Permissions)
+	require.Len(t, bindings[2].OIDC.Provisioning.Groups, 1)
+	require.Equal(t, "default", bindings[2].OIDC.Provisioning.Groups[0].Group)
+	require.Equal(t, "home", bindings[2].OIDC.Provisioning.HomeDirField)
+	require.Equal(t, int64(1048576), bindings[2].OIDC.Provisioning.QuotaSize)
+	require.Equal(t, 100, bindings[2].OIDC.Provisioning.QuotaFiles)
+	require.Equal(t, "quota.size", bindings[2].OIDC.Provisioning.QuotaSizeField)
+	require.Equal(t, "quota.files", bindings[2].OIDC.Provisioning.QuotaFilesField)
+	require.Len(t, bindings[2].OIDCProviders[0].GroupMappings, 0)
+	require.Len(t, bindings[2].OIDCProviders, 1)
+	require.Equal(t, "Partner", bindings[2].OIDCProviders[0].Name)
+	require.Equal(t, []string{"partner.com", "partner.net"}, bindings[2].OIDCProviders[0].Domains)
+	require.Equal(t, "partner client id", bindings[2].OIDCProviders[0].ClientID)
+	require.Equal(t, "partner config url", bindings[2].OIDCProviders[0].ConfigURL)
+	require.Equal(t, "email", bindings[2].OIDCProviders[0].UsernameField)
+	require.Equal(t, []string{"openid", "profile", "email"}, bindings[2].OIDCProviders[0].Scopes)
+	require.Equal(t, "https://idp.example.com/metadata", bindings[2].SAML.IDPMetadata)
+	require.Equal(t, "sftpgo", bindings[2].SAML.EntityID)
+	require.Equal(t, "https://sftpgo.example.com", bindings[2].SAML.BaseURL)
+	require.Equal(t, "saml.crt", bindings[2].SAML.CertificateFile)
+	require.Equal(t, "saml.key", bindings[2].SAML.KeyFile)
+	require.True(t, bindings[2].SAML.SignRequests)
+	require.Equal(t, "uid", bindings[2].SAML.UsernameAttribute)
+	require.Equal(t, "sftpgo_role", bindings[2].SAML.RoleAttribute)
+	require.True(t, bindings[2].SAML.ImplicitRoles)
+	require.Equal(t, []string{"mail", "department"}, bindings[2].SAML.CustomFields)
+	require.True(t, bindings[2].SAML.AllowIDPInitiated)
+	require.True(t, bindings[2].SAML.Debug)
 	require.True(t, bindings[2].Security.Enabled)
 	require.Len(t, bindings[2].Security.AllowedHosts, 2)
 	require.Equal(t, "*.example.com", bindings[2].Security.AllowedHosts[0])
@@ -1532,6 +1913,10 @@ func TestConfigFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA")
 	os.Setenv("SFTPGO_TELEMETRY__TLS_PROTOCOLS", "h2")
 	os.Setenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE", "123")
+	os.Setenv("SFTPGO_HTTPD__THUMBNAILS__ENABLED", "true")
+	os.Setenv("SFTPGO_HTTPD__THUMBNAILS__VIDEO_COMMAND", "/usr/bin/ffmpeg")
+	os.Setenv("SFTPGO_HTTPD__WOPI__ENABLED", "true")
+	os.Setenv("SFTPGO_HTTPD__WOPI__SERVER_URL", "https://collabora.example.com")
 	os.Setenv("SFTPGO_ACME__HTTP01_CHALLENGE__PORT", "5002")
 	t.Cleanup(func() {
 		os.Unsetenv("SFTPGO_SFTPD__BINDINGS__0__ADDRESS")
@@ -1545,6 +1930,10 @@ func TestConfigFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES")
 		os.Unsetenv("SFTPGO_TELEMETRY__TLS_PROTOCOLS")
 		os.Unsetenv("SFTPGO_HTTPD__SETUP__INSTALLATION_CODE")
+		os.Unsetenv("SFTPGO_HTTPD__THUMBNAILS__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__THUMBNAILS__VIDEO_COMMAND")
+		os.Unsetenv("SFTPGO_HTTPD__WOPI__ENABLED")
+		os.Unsetenv("SFTPGO_HTTPD__WOPI__SERVER_URL")
 		os.Unsetenv("SFTPGO_ACME__HTTP01_CHALLENGE_PORT")
 	})
 	err := config.LoadConfig(configDir, "")
@@ -1568,6 +1957,12 @@ func TestConfigFromEnv(t *testing.T) {
 	require.Len(t, telemetryConfig.Protocols, 1)
 	assert.Equal(t, "h2", telemetryConfig.Protocols[0])
 	assert.Equal(t, "123", config.GetHTTPDConfig().Setup.InstallationCode)
+	assert.True(t, config.GetHTTPDConfig().Thumbnails.Enabled)
+	assert.Equal(t, "/usr/bin/ffmpeg", config.GetHTTPDConfig().Thumbnails.VideoCommand)
+	assert.Equal(t, 100, config.GetHTTPDConfig().Thumbnails.CacheSize)
+	assert.True(t, config.GetHTTPDConfig().WOPI.Enabled)
+	assert.Equal(t, "https://collabora.example.com", config.GetHTTPDConfig().WOPI.ServerURL)
+	assert.Equal(t, 600, config.GetHTTPDConfig().WOPI.TokenValidity)
 	acmeConfig := config.GetACMEConfig()
 	assert.Equal(t, 5002, acmeConfig.HTTP01Challenge.Port)
 }
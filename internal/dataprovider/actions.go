@@ -17,6 +17,7 @@ package dataprovider
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os/exec"
@@ -53,6 +54,8 @@ const (
 	actionObjectRole        = "role"
 	actionObjectIPListEntry = "ip_list_entry"
 	actionObjectConfigs     = "configs"
+	actionObjectSSHUserCert = "ssh_user_certificate"
+	actionObjectTenant      = "tenant"
 )
 
 var (
@@ -60,7 +63,46 @@ var (
 	reservedUsers           = []string{ActionExecutorSelf, ActionExecutorSystem}
 )
 
+// getObjectSnapshot returns the stored state of the specified object serialized as JSON,
+// it must be called before updating the object
+func getObjectSnapshot(object plugin.Renderer) []byte {
+	data, err := object.RenderAsJSON(true)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// GetObjectDiff returns the RFC 6902 JSON patch describing the change applied to a
+// provider object. data is the object serialized as JSON and snapshot its state
+// before an update. Added objects are compared against an empty object and deleted
+// objects are transformed to an empty object
+func GetObjectDiff(operation string, snapshot, data []byte) ([]byte, error) {
+	switch operation {
+	case operationAdd:
+		return util.GetJSONPatch([]byte("{}"), data)
+	case operationDelete:
+		return util.GetJSONPatch(data, []byte("{}"))
+	default:
+		if len(snapshot) == 0 {
+			return []byte("[]"), nil
+		}
+		return util.GetJSONPatch(snapshot, data)
+	}
+}
+
 func executeAction(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer) {
+	executeActionWithSnapshot(operation, executor, ip, objectType, objectName, role, object, nil)
+}
+
+func executeUpdateAction(executor, ip, objectType, objectName, role string, object plugin.Renderer, snapshot []byte) {
+	executeActionWithSnapshot(operationUpdate, executor, ip, objectType, objectName, role, object, snapshot)
+}
+
+func executeActionWithSnapshot(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer,
+	snapshot []byte,
+) {
+	addProviderAuditLogEntry(operation, executor, ip, objectType, objectName, role)
 	if plugin.Handler.HasNotifiers() {
 		plugin.Handler.NotifyProviderEvent(&notifier.ProviderEvent{
 			Action:     operation,
@@ -73,7 +115,7 @@ func executeAction(operation, executor, ip, objectType, objectName, role string,
 		}, object)
 	}
 	if fnHandleRuleForProviderEvent != nil {
-		fnHandleRuleForProviderEvent(operation, executor, ip, objectType, objectName, role, object)
+		fnHandleRuleForProviderEvent(operation, executor, ip, objectType, objectName, role, object, snapshot)
 	}
 	if config.Actions.Hook == "" {
 		return
@@ -94,6 +136,14 @@ func executeAction(operation, executor, ip, objectType, objectName, role string,
 			providerLog(logger.LevelError, "unable to serialize user as JSON for operation %q: %v", operation, err)
 			return
 		}
+		var diff []byte
+		if config.Actions.IncludeDiff {
+			diff, err = GetObjectDiff(operation, snapshot, dataAsJSON)
+			if err != nil {
+				providerLog(logger.LevelError, "unable to compute the diff for operation %q: %v", operation, err)
+				return
+			}
+		}
 		if strings.HasPrefix(config.Actions.Hook, "http") {
 			var url *url.URL
 			url, err := url.Parse(config.Actions.Hook)
@@ -113,8 +163,19 @@ func executeAction(operation, executor, ip, objectType, objectName, role string,
 			}
 			q.Add("timestamp", fmt.Sprintf("%d", time.Now().UnixNano()))
 			url.RawQuery = q.Encode()
+			body := dataAsJSON
+			if diff != nil {
+				body, err = json.Marshal(map[string]json.RawMessage{
+					"object": dataAsJSON,
+					"diff":   diff,
+				})
+				if err != nil {
+					providerLog(logger.LevelError, "unable to serialize the body for operation %q: %v", operation, err)
+					return
+				}
+			}
 			startTime := time.Now()
-			resp, err := httpclient.RetryablePost(url.String(), "application/json", bytes.NewBuffer(dataAsJSON))
+			resp, err := httpclient.RetryablePost(url.String(), "application/json", bytes.NewBuffer(body))
 			respCode := 0
 			if err == nil {
 				respCode = resp.StatusCode
@@ -124,11 +185,11 @@ func executeAction(operation, executor, ip, objectType, objectName, role string,
 				operation, url.Redacted(), respCode, time.Since(startTime), err)
 			return
 		}
-		executeNotificationCommand(operation, executor, ip, objectType, objectName, role, dataAsJSON) //nolint:errcheck // the error is used in test cases only
+		executeNotificationCommand(operation, executor, ip, objectType, objectName, role, dataAsJSON, diff) //nolint:errcheck // the error is used in test cases only
 	}()
 }
 
-func executeNotificationCommand(operation, executor, ip, objectType, objectName, role string, objectAsJSON []byte) error {
+func executeNotificationCommand(operation, executor, ip, objectType, objectName, role string, objectAsJSON, diff []byte) error {
 	if !filepath.IsAbs(config.Actions.Hook) {
 		err := fmt.Errorf("invalid notification command %q", config.Actions.Hook)
 		logger.Warn(logSender, "", "unable to execute notification command: %v", err)
@@ -149,6 +210,9 @@ func executeNotificationCommand(operation, executor, ip, objectType, objectName,
 		fmt.Sprintf("SFTPGO_PROVIDER_ROLE=%s", role),
 		fmt.Sprintf("SFTPGO_PROVIDER_TIMESTAMP=%d", util.GetTimeAsMsSinceEpoch(time.Now())),
 		fmt.Sprintf("SFTPGO_PROVIDER_OBJECT=%s", util.BytesToString(objectAsJSON)))
+	if diff != nil {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SFTPGO_PROVIDER_OBJECT_DIFF=%s", util.BytesToString(diff)))
+	}
 
 	startTime := time.Now()
 	err := cmd.Run()
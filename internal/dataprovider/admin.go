@@ -58,6 +58,37 @@ const (
 	PermAdminManageRoles      = "manage_roles"
 	PermAdminManageIPLists    = "manage_ip_lists"
 	PermAdminDisableMFA       = "disable_mfa"
+	PermAdminManageTenants    = "manage_tenants"
+	PermAdminViewAdmins       = "view_admins"
+	PermAdminAddAdmins        = "add_admins"
+	PermAdminChangeAdmins     = "edit_admins"
+	PermAdminDeleteAdmins     = "del_admins"
+	PermAdminViewGroups       = "view_groups"
+	PermAdminAddGroups        = "add_groups"
+	PermAdminChangeGroups     = "edit_groups"
+	PermAdminDeleteGroups     = "del_groups"
+	PermAdminViewFolders      = "view_folders"
+	PermAdminAddFolders       = "add_folders"
+	PermAdminChangeFolders    = "edit_folders"
+	PermAdminDeleteFolders    = "del_folders"
+	PermAdminViewEventRules   = "view_event_rules"
+	PermAdminAddEventRules    = "add_event_rules"
+	PermAdminChangeEventRules = "edit_event_rules"
+	PermAdminDeleteEventRules = "del_event_rules"
+	PermAdminViewRoles        = "view_roles"
+	PermAdminAddRoles         = "add_roles"
+	PermAdminChangeRoles      = "edit_roles"
+	PermAdminDeleteRoles      = "del_roles"
+	PermAdminViewIPLists      = "view_ip_lists"
+	PermAdminAddIPLists       = "add_ip_lists"
+	PermAdminChangeIPLists    = "edit_ip_lists"
+	PermAdminDeleteIPLists    = "del_ip_lists"
+	PermAdminViewTenants      = "view_tenants"
+	PermAdminAddTenants       = "add_tenants"
+	PermAdminChangeTenants    = "edit_tenants"
+	PermAdminDeleteTenants    = "del_tenants"
+	PermAdminImpersonateUsers = "impersonate_users"
+	PermAdminViewAuditLog     = "view_audit_log"
 )
 
 const (
@@ -75,11 +106,61 @@ var (
 		PermAdminCloseConnections, PermAdminViewServerStatus, PermAdminManageAdmins, PermAdminManageRoles,
 		PermAdminManageEventRules, PermAdminManageAPIKeys, PermAdminQuotaScans, PermAdminManageSystem,
 		PermAdminManageDefender, PermAdminViewDefender, PermAdminManageIPLists, PermAdminRetentionChecks,
-		PermAdminViewEvents, PermAdminDisableMFA}
-	forbiddenPermsForRoleAdmins = []string{PermAdminAny, PermAdminManageAdmins, PermAdminManageSystem,
-		PermAdminManageEventRules, PermAdminManageIPLists, PermAdminManageRoles}
+		PermAdminViewEvents, PermAdminDisableMFA, PermAdminManageTenants, PermAdminViewAdmins, PermAdminAddAdmins,
+		PermAdminChangeAdmins, PermAdminDeleteAdmins, PermAdminViewGroups, PermAdminAddGroups, PermAdminChangeGroups,
+		PermAdminDeleteGroups, PermAdminViewFolders, PermAdminAddFolders, PermAdminChangeFolders,
+		PermAdminDeleteFolders, PermAdminViewEventRules, PermAdminAddEventRules, PermAdminChangeEventRules,
+		PermAdminDeleteEventRules, PermAdminViewRoles, PermAdminAddRoles, PermAdminChangeRoles, PermAdminDeleteRoles,
+		PermAdminViewIPLists, PermAdminAddIPLists, PermAdminChangeIPLists, PermAdminDeleteIPLists,
+		PermAdminViewTenants, PermAdminAddTenants, PermAdminChangeTenants, PermAdminDeleteTenants,
+		PermAdminImpersonateUsers, PermAdminViewAuditLog}
+	// the "manage_*" permissions grant all the granular permissions for the
+	// same resource
+	impliedAdminPerms = map[string][]string{
+		PermAdminManageAdmins: {PermAdminViewAdmins, PermAdminAddAdmins, PermAdminChangeAdmins,
+			PermAdminDeleteAdmins},
+		PermAdminManageGroups: {PermAdminViewGroups, PermAdminAddGroups, PermAdminChangeGroups,
+			PermAdminDeleteGroups},
+		PermAdminManageFolders: {PermAdminViewFolders, PermAdminAddFolders, PermAdminChangeFolders,
+			PermAdminDeleteFolders},
+		PermAdminManageEventRules: {PermAdminViewEventRules, PermAdminAddEventRules, PermAdminChangeEventRules,
+			PermAdminDeleteEventRules},
+		PermAdminManageRoles: {PermAdminViewRoles, PermAdminAddRoles, PermAdminChangeRoles, PermAdminDeleteRoles},
+		PermAdminManageIPLists: {PermAdminViewIPLists, PermAdminAddIPLists, PermAdminChangeIPLists,
+			PermAdminDeleteIPLists},
+		PermAdminManageTenants: {PermAdminViewTenants, PermAdminAddTenants, PermAdminChangeTenants,
+			PermAdminDeleteTenants},
+	}
+	forbiddenPermsForRoleAdmins = append([]string{PermAdminAny, PermAdminManageAdmins, PermAdminManageSystem,
+		PermAdminManageEventRules, PermAdminManageIPLists, PermAdminManageRoles, PermAdminManageTenants,
+		PermAdminViewAuditLog},
+		getImpliedAdminPerms(PermAdminManageAdmins, PermAdminManageEventRules, PermAdminManageIPLists,
+			PermAdminManageRoles, PermAdminManageTenants)...)
 )
 
+func getImpliedAdminPerms(perms ...string) []string {
+	var result []string
+	for _, perm := range perms {
+		result = append(result, impliedAdminPerms[perm]...)
+	}
+	return result
+}
+
+// HasAdminPermission returns true if the given admin permissions grant the
+// specified permission. The "manage_*" permissions grant all the granular
+// permissions for the same resource
+func HasAdminPermission(permissions []string, perm string) bool {
+	if util.Contains(permissions, PermAdminAny) || util.Contains(permissions, perm) {
+		return true
+	}
+	for _, p := range permissions {
+		if util.Contains(impliedAdminPerms[p], perm) {
+			return true
+		}
+	}
+	return false
+}
+
 // AdminTOTPConfig defines the time-based one time password configuration
 type AdminTOTPConfig struct {
 	Enabled    bool        `json:"enabled,omitempty"`
@@ -271,7 +352,11 @@ type Admin struct {
 	// - manage_system
 	// - manage_event_rules
 	// - manage_roles
+	// - manage_tenants
 	Role string `json:"role,omitempty"`
+	// Tenant name. If set the admin can only view and manage the objects
+	// belonging to the same tenant
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // CountUnusedRecoveryCodes returns the number of unused recovery codes
@@ -356,6 +441,18 @@ func (a *Admin) validatePermissions() error {
 				)
 			}
 		}
+		if a.Tenant != "" {
+			if util.Contains(forbiddenPermsForTenantAdmins, perm) {
+				deniedPerms := strings.Join(forbiddenPermsForTenantAdmins, ",")
+				return util.NewI18nError(
+					util.NewValidationError(fmt.Sprintf("a tenant admin cannot have the following permissions: %q", deniedPerms)),
+					util.I18nErrorTenantAdminPerms,
+					util.I18nErrorArgs(map[string]any{
+						"val": deniedPerms,
+					}),
+				)
+			}
+		}
 	}
 	return nil
 }
@@ -509,6 +606,9 @@ func (a *Admin) checkUserAndPass(password, ip string) error {
 	if !match {
 		return ErrInvalidCredentials
 	}
+	if isPasswordHashUpgradeNeeded(a.Password) {
+		convertAdminPassword(a.Username, password)
+	}
 	return nil
 }
 
@@ -559,10 +659,7 @@ func (a *Admin) SetNilSecretsIfEmpty() {
 
 // HasPermission returns true if the admin has the specified permission
 func (a *Admin) HasPermission(perm string) bool {
-	if util.Contains(a.Permissions, PermAdminAny) {
-		return true
-	}
-	return util.Contains(a.Permissions, perm)
+	return HasAdminPermission(a.Permissions, perm)
 }
 
 // GetAllowedIPAsString returns the allowed IP as comma separated string
@@ -638,6 +735,7 @@ func (a *Admin) getACopy() Admin {
 		CreatedAt:      a.CreatedAt,
 		UpdatedAt:      a.UpdatedAt,
 		Role:           a.Role,
+		Tenant:         a.Tenant,
 	}
 }
 
@@ -17,6 +17,7 @@ package dataprovider
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -38,6 +39,33 @@ const (
 	APIKeyScopeUser
 )
 
+// Supported API key permission levels
+const (
+	APIKeyPermissionRead  = "read"
+	APIKeyPermissionWrite = "write"
+)
+
+var (
+	// ValidAdminAPIKeyResources defines the resources that can be granted to admin API keys
+	ValidAdminAPIKeyResources = []string{"users", "groups", "folders", "admins", "roles", "tenants", "quotas", "retention",
+		"connections", "events", "eventmanager", "iplists", "defender", "maintenance", "status", "jobs"}
+	// ValidUserAPIKeyResources defines the resources that can be granted to user API keys
+	ValidUserAPIKeyResources = []string{"files", "shares"}
+)
+
+// APIKeyFilters defines additional restrictions for an API key
+type APIKeyFilters struct {
+	// Permission scopes in the format "resource:read" or "resource:write".
+	// Empty means that the key inherits all the permissions of the associated
+	// admin or user
+	Permissions []string `json:"permissions,omitempty"`
+	// only clients connecting from these IP/Mask are allowed.
+	// IP/Mask must be in CIDR notation as defined in RFC 4632 and RFC 4291
+	// for example "192.0.2.0/24" or "2001:db8::/32".
+	// Empty means allow from any IP
+	AllowList []string `json:"allow_list,omitempty"`
+}
+
 // APIKey defines a SFTPGo API key.
 // API keys can be used as authentication alternative to short lived tokens
 // for REST API
@@ -66,6 +94,8 @@ type APIKey struct {
 	// Admin username associated with this API key.
 	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
 	Admin string `json:"admin,omitempty"`
+	// Additional restrictions
+	Filters APIKeyFilters `json:"filters"`
 	// these fields are for internal use
 	userID   int64
 	adminID  int64
@@ -73,6 +103,11 @@ type APIKey struct {
 }
 
 func (k *APIKey) getACopy() APIKey {
+	permissions := make([]string, len(k.Filters.Permissions))
+	copy(permissions, k.Filters.Permissions)
+	allowList := make([]string, len(k.Filters.AllowList))
+	copy(allowList, k.Filters.AllowList)
+
 	return APIKey{
 		ID:          k.ID,
 		KeyID:       k.KeyID,
@@ -86,8 +121,12 @@ func (k *APIKey) getACopy() APIKey {
 		Description: k.Description,
 		User:        k.User,
 		Admin:       k.Admin,
-		userID:      k.userID,
-		adminID:     k.adminID,
+		Filters: APIKeyFilters{
+			Permissions: permissions,
+			AllowList:   allowList,
+		},
+		userID:  k.userID,
+		adminID: k.adminID,
 	}
 }
 
@@ -164,6 +203,9 @@ func (k *APIKey) validate() error {
 	if k.Scope == APIKeyScopeUser {
 		k.Admin = ""
 	}
+	if err := k.validateFilters(); err != nil {
+		return err
+	}
 	if k.User != "" {
 		_, err := provider.userExists(k.User, "")
 		if err != nil {
@@ -179,6 +221,58 @@ func (k *APIKey) validate() error {
 	return nil
 }
 
+func (k *APIKey) validateFilters() error {
+	validResources := ValidAdminAPIKeyResources
+	if k.Scope == APIKeyScopeUser {
+		validResources = ValidUserAPIKeyResources
+	}
+	k.Filters.Permissions = util.RemoveDuplicates(k.Filters.Permissions, true)
+	for _, perm := range k.Filters.Permissions {
+		resource, level, ok := strings.Cut(perm, ":")
+		if !ok || !util.Contains(validResources, resource) ||
+			(level != APIKeyPermissionRead && level != APIKeyPermissionWrite) {
+			return util.NewValidationError(fmt.Sprintf("invalid permission %q", perm))
+		}
+	}
+	k.Filters.AllowList = util.RemoveDuplicates(k.Filters.AllowList, true)
+	for _, ipMask := range k.Filters.AllowList {
+		if _, _, err := net.ParseCIDR(ipMask); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not parse allow list entry %q : %v", ipMask, err))
+		}
+	}
+	return nil
+}
+
+// HasPermission returns true if the API key can access the specified resource
+// with the specified permission level
+func (k *APIKey) HasPermission(resource, level string) bool {
+	if len(k.Filters.Permissions) == 0 {
+		return true
+	}
+	return util.Contains(k.Filters.Permissions, resource+":"+level)
+}
+
+// IsAllowedFromIP returns true if the API key can be used from the specified IP
+func (k *APIKey) IsAllowedFromIP(ip string) bool {
+	if len(k.Filters.AllowList) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, ipMask := range k.Filters.AllowList {
+		_, network, err := net.ParseCIDR(ipMask)
+		if err != nil {
+			continue
+		}
+		if network.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
 // Authenticate tries to authenticate the provided plain key
 func (k *APIKey) Authenticate(plainKey string) error {
 	if k.ExpiresAt > 0 && k.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
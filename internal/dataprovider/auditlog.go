@@ -0,0 +1,343 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported audit log categories
+const (
+	AuditLogCategoryProvider = "provider"
+	AuditLogCategoryAuth     = "auth"
+)
+
+const (
+	auditLogVerifyPageSize = 500
+	auditLogMaxInfoLength  = 1024
+	auditLogMaxNameLength  = 255
+)
+
+var (
+	auditLog auditLogger
+)
+
+// AuditLogConfig defines the configuration for the tamper-evident audit log.
+// Each entry stores the hash of the previous entry recorded by the same node,
+// so removing or changing an entry breaks the chain
+type AuditLogConfig struct {
+	// Set to true to record administrative changes and authentication decisions
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Optional secret used to compute the entries hashes as HMAC-SHA256 instead
+	// of plain SHA256. Without a secret anyone able to write to the data provider
+	// can recompute the whole chain after changing an entry
+	HMACSecret string `json:"hmac_secret" mapstructure:"hmac_secret"`
+}
+
+// AuditLogEntry defines an audit log entry
+type AuditLogEntry struct {
+	ID int64 `json:"id"`
+	// Entry creation time as unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// Node that recorded the entry, each node has its own chain
+	Node     string `json:"node"`
+	Category string `json:"category"`
+	// Provider action (add, update, delete) or login method
+	Action     string `json:"action"`
+	Username   string `json:"username"`
+	IP         string `json:"ip,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	ObjectType string `json:"object_type,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+	Role       string `json:"role,omitempty"`
+	// 1 means success, 0 failure
+	Status int `json:"status"`
+	// Error details for failed authentications
+	Info     string `json:"info,omitempty"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// auditLogHashData defines the entry fields covered by the hash. The ID is
+// assigned by the data provider and so it is excluded
+type auditLogHashData struct {
+	Timestamp  int64  `json:"timestamp"`
+	Node       string `json:"node"`
+	Category   string `json:"category"`
+	Action     string `json:"action"`
+	Username   string `json:"username"`
+	IP         string `json:"ip"`
+	Protocol   string `json:"protocol"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	Role       string `json:"role"`
+	Status     int    `json:"status"`
+	Info       string `json:"info"`
+	PrevHash   string `json:"prev_hash"`
+}
+
+func (e *AuditLogEntry) computeHash(secret []byte) (string, error) {
+	data, err := json.Marshal(&auditLogHashData{
+		Timestamp:  e.Timestamp,
+		Node:       e.Node,
+		Category:   e.Category,
+		Action:     e.Action,
+		Username:   e.Username,
+		IP:         e.IP,
+		Protocol:   e.Protocol,
+		ObjectType: e.ObjectType,
+		ObjectName: e.ObjectName,
+		Role:       e.Role,
+		Status:     e.Status,
+		Info:       e.Info,
+		PrevHash:   e.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(secret) > 0 {
+		h := hmac.New(sha256.New, secret)
+		h.Write(data)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+func (e *AuditLogEntry) validate() error {
+	if e.Node == "" {
+		return util.NewValidationError("audit log entry: node is mandatory")
+	}
+	if e.Hash == "" {
+		return util.NewValidationError("audit log entry: hash is mandatory")
+	}
+	return nil
+}
+
+// AuditLogVerifyResult defines the result of an audit log integrity check
+type AuditLogVerifyResult struct {
+	Valid bool `json:"valid"`
+	// Number of verified entries
+	Entries int64 `json:"entries"`
+	// Names of the nodes that recorded the verified entries
+	Nodes []string `json:"nodes"`
+	// ID of the first entry that does not match the chain, if any
+	InvalidID int64  `json:"invalid_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type auditLogger struct {
+	sync.Mutex
+	isEnabled bool
+	secret    []byte
+	node      string
+	lastHash  string
+	isLoaded  bool
+}
+
+func (l *auditLogger) initialize() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.isEnabled = config.AuditLog.Enabled
+	l.secret = []byte(config.AuditLog.HMACSecret)
+	l.isLoaded = false
+	l.lastHash = ""
+	if !l.isEnabled {
+		return
+	}
+	l.node = getAuditLogNodeName()
+	providerLog(logger.LevelInfo, "audit log enabled, node %q, hmac: %t", l.node, len(l.secret) > 0)
+}
+
+func (l *auditLogger) loadLastHash() error {
+	if l.isLoaded {
+		return nil
+	}
+	entry, err := provider.getLastAuditLogEntry(l.node)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		entry.Hash = ""
+	}
+	l.lastHash = entry.Hash
+	l.isLoaded = true
+	return nil
+}
+
+func (l *auditLogger) add(entry AuditLogEntry) {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.isEnabled {
+		return
+	}
+	if err := l.loadLastHash(); err != nil {
+		providerLog(logger.LevelError, "unable to load the last audit log entry for node %q: %v", l.node, err)
+		return
+	}
+	entry.Timestamp = util.GetTimeAsMsSinceEpoch(time.Now())
+	entry.Node = l.node
+	entry.Username = truncateAuditLogField(entry.Username, auditLogMaxNameLength)
+	entry.ObjectName = truncateAuditLogField(entry.ObjectName, auditLogMaxNameLength)
+	entry.Info = truncateAuditLogField(entry.Info, auditLogMaxInfoLength)
+	entry.PrevHash = l.lastHash
+	hash, err := entry.computeHash(l.secret)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to compute audit log hash: %v", err)
+		return
+	}
+	entry.Hash = hash
+	if err := provider.addAuditLogEntry(&entry); err != nil {
+		// the entry could be saved anyway, for example on timeouts,
+		// the last hash will be reloaded from the provider
+		l.isLoaded = false
+		providerLog(logger.LevelError, "unable to save audit log entry, category %q action %q username %q: %v",
+			entry.Category, entry.Action, entry.Username, err)
+		return
+	}
+	l.lastHash = entry.Hash
+}
+
+func (l *auditLogger) verify() (AuditLogVerifyResult, error) {
+	l.Lock()
+	secret := l.secret
+	l.Unlock()
+
+	result := AuditLogVerifyResult{
+		Valid: true,
+		Nodes: []string{},
+	}
+	lastHashes := make(map[string]string)
+	var from int64
+	for {
+		entries, err := provider.getAuditLogEntries(from, auditLogVerifyPageSize)
+		if err != nil {
+			return result, err
+		}
+		for idx := range entries {
+			entry := &entries[idx]
+			prevHash, ok := lastHashes[entry.Node]
+			if !ok {
+				result.Nodes = append(result.Nodes, entry.Node)
+			}
+			if entry.PrevHash != prevHash {
+				result.setInvalid(entry.ID, fmt.Sprintf("the previous hash does not match the last entry for node %q",
+					entry.Node))
+				return result, nil
+			}
+			hash, err := entry.computeHash(secret)
+			if err != nil {
+				return result, err
+			}
+			if !hmac.Equal([]byte(hash), []byte(entry.Hash)) {
+				result.setInvalid(entry.ID, "the entry hash does not match its content")
+				return result, nil
+			}
+			lastHashes[entry.Node] = entry.Hash
+			result.Entries++
+			from = entry.ID
+		}
+		if len(entries) < auditLogVerifyPageSize {
+			return result, nil
+		}
+	}
+}
+
+func (r *AuditLogVerifyResult) setInvalid(id int64, reason string) {
+	r.Valid = false
+	r.InvalidID = id
+	r.Error = reason
+}
+
+func truncateAuditLogField(value string, maxLength int) string {
+	if len(value) > maxLength {
+		// drop any rune split by the truncation
+		return strings.ToValidUTF8(value[:maxLength], "")
+	}
+	return value
+}
+
+func getAuditLogNodeName() string {
+	if currentNode != nil && currentNode.Name != "" {
+		return currentNode.Name
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "sftpgo"
+	}
+	return hostname
+}
+
+func addProviderAuditLogEntry(operation, executor, ip, objectType, objectName, role string) {
+	auditLog.add(AuditLogEntry{
+		Category:   AuditLogCategoryProvider,
+		Action:     operation,
+		Username:   executor,
+		IP:         ip,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Role:       role,
+		Status:     1,
+	})
+}
+
+func addAuthAuditLogEntry(objectType, username, loginMethod, ip, protocol, role string, err error) {
+	entry := AuditLogEntry{
+		Category:   AuditLogCategoryAuth,
+		Action:     loginMethod,
+		Username:   username,
+		IP:         ip,
+		Protocol:   protocol,
+		ObjectType: objectType,
+		ObjectName: username,
+		Role:       role,
+		Status:     1,
+	}
+	if err != nil {
+		entry.Status = 0
+		entry.Info = err.Error()
+	}
+	auditLog.add(entry)
+}
+
+// IsAuditLogEnabled returns true if the audit log is enabled
+func IsAuditLogEnabled() bool {
+	return config.AuditLog.Enabled
+}
+
+// GetAuditLogEntries returns at most limit audit log entries with an ID greater than from,
+// ordered by ID
+func GetAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	return provider.getAuditLogEntries(from, limit)
+}
+
+// VerifyAuditLog checks the integrity of the audit log hash chains
+func VerifyAuditLog() (AuditLogVerifyResult, error) {
+	return auditLog.verify()
+}
@@ -0,0 +1,402 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+
+	"github.com/minio/sio"
+	"golang.org/x/crypto/argon2"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Encrypted backup archive format: magic, format version, salt and the gzip
+// compressed JSON dump encrypted using DARE with a key derived from the
+// passphrase using Argon2id
+const (
+	backupArchiveMagic   = "SFTPGOBAK"
+	backupArchiveVersion = 1
+	backupSaltLen        = 32
+	backupMaxSize        = 1073741824
+)
+
+// Backup changes
+const (
+	BackupChangeAdd       = "add"
+	BackupChangeUpdate    = "update"
+	BackupChangeSkip      = "skip"
+	BackupChangeUnchanged = "unchanged"
+)
+
+var (
+	errInvalidBackupArchive = errors.New("invalid backup archive")
+	// volatile fields ignored comparing backup objects with the existing ones
+	backupIgnoredFields = []string{"id", "created_at", "updated_at", "last_login", "used_quota_size",
+		"used_quota_files", "last_quota_update", "used_upload_data_transfer", "used_download_data_transfer",
+		"first_download", "first_upload", "last_password_change", "last_use_at", "used_tokens"}
+	// relations populated by the data provider and not restored
+	backupIgnoredRelations = map[string][]string{
+		DumpScopeFolders: {"users", "groups"},
+		DumpScopeGroups:  {"users"},
+		DumpScopeRoles:   {"users", "admins"},
+		DumpScopeActions: {"rules"},
+	}
+	// DumpScopes defines all the supported dump scopes
+	DumpScopes = []string{DumpScopeUsers, DumpScopeFolders, DumpScopeGroups, DumpScopeAdmins, DumpScopeAPIKeys,
+		DumpScopeShares, DumpScopeActions, DumpScopeRules, DumpScopeRoles, DumpScopeIPLists, DumpScopeConfigs,
+		DumpScopeTenants}
+)
+
+func getBackupSIOConfig(passphrase string, salt []byte) sio.Config {
+	key := argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, 32)
+	return sio.Config{
+		MinVersion:     sio.Version20,
+		MaxVersion:     sio.Version20,
+		Key:            key,
+		CipherSuites:   []byte{sio.AES_256_GCM, sio.CHACHA20_POLY1305},
+		SequenceNumber: 0,
+	}
+}
+
+// IsEncryptedBackup returns true if the content is an encrypted backup archive
+func IsEncryptedBackup(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(backupArchiveMagic))
+}
+
+// EncryptBackup returns the backup data as an archive encrypted using the
+// given passphrase
+func EncryptBackup(data *BackupData, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("a passphrase is required to encrypt the backup")
+	}
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	if err := json.NewEncoder(gz).Encode(data); err != nil {
+		return nil, fmt.Errorf("unable to encode the backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress the backup: %w", err)
+	}
+	salt := util.GenerateRandomBytes(backupSaltLen)
+	var archive bytes.Buffer
+	archive.WriteString(backupArchiveMagic)
+	archive.WriteByte(backupArchiveVersion)
+	archive.Write(salt)
+	if _, err := sio.Encrypt(&archive, &payload, getBackupSIOConfig(passphrase, salt)); err != nil {
+		return nil, fmt.Errorf("unable to encrypt the backup: %w", err)
+	}
+	return archive.Bytes(), nil
+}
+
+// DecryptBackup returns the backup data from an archive created using EncryptBackup
+func DecryptBackup(content []byte, passphrase string) (BackupData, error) {
+	headerLen := len(backupArchiveMagic) + 1 + backupSaltLen
+	if len(content) < headerLen || !IsEncryptedBackup(content) {
+		return BackupData{}, errInvalidBackupArchive
+	}
+	if version := content[len(backupArchiveMagic)]; version != backupArchiveVersion {
+		return BackupData{}, fmt.Errorf("unsupported backup archive version %d", version)
+	}
+	salt := content[len(backupArchiveMagic)+1 : headerLen]
+	var payload bytes.Buffer
+	_, err := sio.Decrypt(&payload, bytes.NewReader(content[headerLen:]), getBackupSIOConfig(passphrase, salt))
+	if err != nil {
+		return BackupData{}, errors.New("unable to decrypt the backup archive, please check the passphrase")
+	}
+	gz, err := gzip.NewReader(&payload)
+	if err != nil {
+		return BackupData{}, errInvalidBackupArchive
+	}
+	defer gz.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(gz, backupMaxSize))
+	if err != nil {
+		return BackupData{}, errInvalidBackupArchive
+	}
+	return ParseDumpData(decompressed)
+}
+
+// CheckBackupVersion returns an error if the backup was created using a
+// newer, unsupported, dump version
+func CheckBackupVersion(data *BackupData) error {
+	if data.Version > DumpVersion {
+		return fmt.Errorf("the backup version %d is newer than the supported one %d, please upgrade SFTPGo",
+			data.Version, DumpVersion)
+	}
+	return nil
+}
+
+// BackupFilter defines the data to restore from a backup
+type BackupFilter struct {
+	// Scopes to restore, empty means all
+	Scopes []string
+	// Restore only the users, and their shares, matching this shell pattern.
+	// Empty means all
+	UsersPattern string
+}
+
+// Validate returns an error if the filter is not valid
+func (f *BackupFilter) Validate() error {
+	for _, scope := range f.Scopes {
+		if !util.Contains(DumpScopes, scope) {
+			return util.NewValidationError(fmt.Sprintf("invalid scope %q", scope))
+		}
+	}
+	if f.UsersPattern != "" {
+		if _, err := path.Match(f.UsersPattern, ""); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid users pattern %q: %v", f.UsersPattern, err))
+		}
+	}
+	return nil
+}
+
+func (f *BackupFilter) hasScope(scope string) bool {
+	return len(f.Scopes) == 0 || util.Contains(f.Scopes, scope)
+}
+
+func (f *BackupFilter) matchUser(username string) bool {
+	if f.UsersPattern == "" {
+		return true
+	}
+	matched, err := path.Match(f.UsersPattern, username)
+	return err == nil && matched
+}
+
+// Apply removes from the backup data the objects not matching the filter
+func (f *BackupFilter) Apply(data *BackupData) {
+	if !f.hasScope(DumpScopeUsers) {
+		data.Users = nil
+	}
+	if !f.hasScope(DumpScopeFolders) {
+		data.Folders = nil
+	}
+	if !f.hasScope(DumpScopeGroups) {
+		data.Groups = nil
+	}
+	if !f.hasScope(DumpScopeAdmins) {
+		data.Admins = nil
+	}
+	if !f.hasScope(DumpScopeAPIKeys) {
+		data.APIKeys = nil
+	}
+	if !f.hasScope(DumpScopeShares) {
+		data.Shares = nil
+	}
+	if !f.hasScope(DumpScopeActions) {
+		data.EventActions = nil
+	}
+	if !f.hasScope(DumpScopeRules) {
+		data.EventRules = nil
+	}
+	if !f.hasScope(DumpScopeRoles) {
+		data.Roles = nil
+	}
+	if !f.hasScope(DumpScopeIPLists) {
+		data.IPLists = nil
+	}
+	if !f.hasScope(DumpScopeConfigs) {
+		data.Configs = nil
+	}
+	if !f.hasScope(DumpScopeTenants) {
+		data.Tenants = nil
+	}
+	if f.UsersPattern == "" {
+		return
+	}
+	users := make([]User, 0, len(data.Users))
+	for _, user := range data.Users {
+		if f.matchUser(user.Username) {
+			users = append(users, user)
+		}
+	}
+	data.Users = users
+	shares := make([]Share, 0, len(data.Shares))
+	for _, share := range data.Shares {
+		if f.matchUser(share.Username) {
+			shares = append(shares, share)
+		}
+	}
+	data.Shares = shares
+}
+
+// BackupChange defines a change that restoring a backup would apply
+type BackupChange struct {
+	Scope  string
+	Name   string
+	Action string
+	// Changed fields for updates
+	Fields []string
+}
+
+func getBackupFields(scope string, obj any) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, f := range backupIgnoredFields {
+		delete(fields, f)
+	}
+	for _, f := range backupIgnoredRelations[scope] {
+		delete(fields, f)
+	}
+	return fields, nil
+}
+
+func getBackupChange(scope, name string, backup, current any, err error, mode int) (BackupChange, error) {
+	change := BackupChange{
+		Scope: scope,
+		Name:  name,
+	}
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			change.Action = BackupChangeAdd
+			return change, nil
+		}
+		return change, err
+	}
+	if mode == 1 {
+		change.Action = BackupChangeSkip
+		return change, nil
+	}
+	backupFields, err := getBackupFields(scope, backup)
+	if err != nil {
+		return change, err
+	}
+	currentFields, err := getBackupFields(scope, current)
+	if err != nil {
+		return change, err
+	}
+	for k, v := range backupFields {
+		if !reflect.DeepEqual(v, currentFields[k]) {
+			change.Fields = append(change.Fields, k)
+		}
+	}
+	for k := range currentFields {
+		if _, ok := backupFields[k]; !ok {
+			change.Fields = append(change.Fields, k)
+		}
+	}
+	if len(change.Fields) == 0 {
+		change.Action = BackupChangeUnchanged
+	} else {
+		sort.Strings(change.Fields)
+		change.Action = BackupChangeUpdate
+	}
+	return change, nil
+}
+
+// GetBackupChanges returns the changes that restoring the backup data, using the
+// specified mode, would apply. Mode 0 means new objects are added and existing
+// ones are updated, 1 means existing objects are not modified
+func GetBackupChanges(data *BackupData, mode int) ([]BackupChange, error) {
+	var changes []BackupChange
+	addChange := func(scope, name string, backup, current any, err error) error {
+		change, err := getBackupChange(scope, name, backup, current, err, mode)
+		if err != nil {
+			return fmt.Errorf("unable to check %s %q: %w", scope, name, err)
+		}
+		changes = append(changes, change)
+		return nil
+	}
+	if data.Configs != nil {
+		configs, err := GetConfigs()
+		if err := addChange(DumpScopeConfigs, "configs", data.Configs, &configs, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.IPLists {
+		entry := &data.IPLists[idx]
+		current, err := IPListEntryExists(entry.IPOrNet, entry.Type)
+		if err := addChange(DumpScopeIPLists, fmt.Sprintf("%s (%s)", entry.IPOrNet, entry.Type.AsString()),
+			entry, &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Tenants {
+		current, err := TenantExists(data.Tenants[idx].Name)
+		if err := addChange(DumpScopeTenants, data.Tenants[idx].Name, &data.Tenants[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Roles {
+		current, err := RoleExists(data.Roles[idx].Name)
+		if err := addChange(DumpScopeRoles, data.Roles[idx].Name, &data.Roles[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Folders {
+		current, err := GetFolderByName(data.Folders[idx].Name)
+		if err := addChange(DumpScopeFolders, data.Folders[idx].Name, &data.Folders[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Groups {
+		current, err := GroupExists(data.Groups[idx].Name)
+		if err := addChange(DumpScopeGroups, data.Groups[idx].Name, &data.Groups[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Users {
+		current, err := UserExists(data.Users[idx].Username, "")
+		if err := addChange(DumpScopeUsers, data.Users[idx].Username, &data.Users[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Admins {
+		current, err := AdminExists(data.Admins[idx].Username)
+		if err := addChange(DumpScopeAdmins, data.Admins[idx].Username, &data.Admins[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.APIKeys {
+		current, err := APIKeyExists(data.APIKeys[idx].KeyID)
+		if err := addChange(DumpScopeAPIKeys, data.APIKeys[idx].KeyID, &data.APIKeys[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.Shares {
+		current, err := ShareExists(data.Shares[idx].ShareID, "")
+		if err := addChange(DumpScopeShares, data.Shares[idx].ShareID, &data.Shares[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.EventActions {
+		current, err := EventActionExists(data.EventActions[idx].Name)
+		if err := addChange(DumpScopeActions, data.EventActions[idx].Name, &data.EventActions[idx], &current,
+			err); err != nil {
+			return nil, err
+		}
+	}
+	for idx := range data.EventRules {
+		current, err := EventRuleExists(data.EventRules[idx].Name)
+		if err := addChange(DumpScopeRules, data.EventRules[idx].Name, &data.EventRules[idx], &current, err); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
@@ -20,6 +20,7 @@ package dataprovider
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -37,7 +38,7 @@ import (
 )
 
 const (
-	boltDatabaseVersion = 29
+	boltDatabaseVersion = 34
 )
 
 var (
@@ -50,15 +51,26 @@ var (
 	actionsBucket   = []byte("events_actions")
 	rulesBucket     = []byte("events_rules")
 	rolesBucket     = []byte("roles")
+	tenantsBucket   = []byte("tenants")
+	auditLogsBucket = []byte("audit_logs")
 	ipListsBucket   = []byte("ip_lists")
 	configsBucket   = []byte("configs")
+	sessionsBucket  = []byte("shared_sessions")
 	dbVersionBucket = []byte("db_version")
 	dbVersionKey    = []byte("version")
 	configsKey      = []byte("configs")
 	boltBuckets     = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, dbVersionBucket}
+		sharesBucket, actionsBucket, rulesBucket, rolesBucket, tenantsBucket, auditLogsBucket, ipListsBucket,
+		configsBucket, sessionsBucket, dbVersionBucket}
 )
 
+// boltSession is the stored representation of a shared session
+type boltSession struct {
+	Data      []byte      `json:"data"`
+	Type      SessionType `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+}
+
 // BoltProvider defines the auth provider for bolt key/value store
 type BoltProvider struct {
 	dbHandle *bolt.DB
@@ -138,6 +150,31 @@ func (p *BoltProvider) validateAdminAndPass(username, password, ip string) (Admi
 	return admin, err
 }
 
+func (p *BoltProvider) updateAdminPassword(username, password string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getAdminsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var a []byte
+		if a = bucket.Get([]byte(username)); a == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist", username))
+		}
+		var admin Admin
+		err = json.Unmarshal(a, &admin)
+		if err != nil {
+			return err
+		}
+		admin.Password = password
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(username), buf)
+	})
+}
+
 func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
 	var user User
 	if len(pubKey) == 0 {
@@ -528,7 +565,7 @@ func (p *BoltProvider) deleteAdmin(admin Admin) error {
 	})
 }
 
-func (p *BoltProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+func (p *BoltProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
 	admins := make([]Admin, 0, limit)
 
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -540,6 +577,9 @@ func (p *BoltProvider) getAdmins(limit int, offset int, order string) ([]Admin,
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -557,6 +597,9 @@ func (p *BoltProvider) getAdmins(limit int, offset int, order string) ([]Admin,
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -968,7 +1011,7 @@ func (p *BoltProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, e
 	return users, err
 }
 
-func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *BoltProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -987,6 +1030,9 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1006,6 +1052,9 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1050,7 +1099,7 @@ func (p *BoltProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return folders, err
 }
 
-func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vfs.BaseVirtualFolder, error) {
+func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -1065,6 +1114,9 @@ func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vf
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1082,6 +1134,9 @@ func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vf
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1301,7 +1356,7 @@ func (p *BoltProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
 }
 
-func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Group, error) {
+func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool, tenant string) ([]Group, error) {
 	groups := make([]Group, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -1320,6 +1375,9 @@ func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Gro
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1337,6 +1395,9 @@ func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Gro
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1832,6 +1893,7 @@ func (p *BoltProvider) addShare(share *Share) error {
 			share.UpdatedAt = share.CreatedAt
 			share.LastUseAt = 0
 			share.UsedTokens = 0
+			share.Stats = ShareStats{}
 		}
 		if share.CreatedAt == 0 {
 			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
@@ -1879,6 +1941,7 @@ func (p *BoltProvider) updateShare(share *Share) error {
 			share.UsedTokens = oldObject.UsedTokens
 			share.CreatedAt = oldObject.CreatedAt
 			share.LastUseAt = oldObject.LastUseAt
+			share.Stats = oldObject.Stats
 			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		}
 		if share.CreatedAt == 0 {
@@ -2032,6 +2095,36 @@ func (p *BoltProvider) updateShareLastUse(shareID string, numTokens int) error {
 	})
 }
 
+func (p *BoltProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(shareID)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update stats", shareID))
+		}
+		var share Share
+		err = json.Unmarshal(u, &share)
+		if err != nil {
+			return err
+		}
+		share.Stats.update(ipAddress, filePaths)
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(shareID), buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating stats for share %q: %v", shareID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "stats updated for share %q", shareID)
+		return nil
+	})
+}
+
 func (p *BoltProvider) getDefenderHosts(_ int64, _ int) ([]DefenderEntry, error) {
 	return nil, ErrNotImplemented
 }
@@ -2084,20 +2177,125 @@ func (p *BoltProvider) getActiveTransfers(_ time.Time) ([]ActiveTransfer, error)
 	return nil, ErrNotImplemented
 }
 
-func (p *BoltProvider) addSharedSession(_ Session) error {
-	return ErrNotImplemented
+func (p *BoltProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(boltSession{
+		Data:      data,
+		Type:      session.Type,
+		Timestamp: session.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getSessionsBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(session.Key), buf)
+	})
 }
 
-func (p *BoltProvider) deleteSharedSession(_ string) error {
-	return ErrNotImplemented
+func (p *BoltProvider) deleteSharedSession(key string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getSessionsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(key)) == nil {
+			return util.NewRecordNotFoundError("no session deleted")
+		}
+		return bucket.Delete([]byte(key))
+	})
 }
 
-func (p *BoltProvider) getSharedSession(_ string) (Session, error) {
-	return Session{}, ErrNotImplemented
+func (p *BoltProvider) getSharedSession(key string) (Session, error) {
+	var session Session
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getSessionsBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+		}
+		var s boltSession
+		if err := json.Unmarshal(v, &s); err != nil {
+			return err
+		}
+		session = Session{
+			Key:       key,
+			Data:      s.Data,
+			Type:      s.Type,
+			Timestamp: s.Timestamp,
+		}
+		return nil
+	})
+	return session, err
 }
 
-func (p *BoltProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
-	return ErrNotImplemented
+func (p *BoltProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	var sessions []Session
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getSessionsBucket(tx)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(keyPrefix)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var s boltSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Type == sessionType {
+				sessions = append(sessions, Session{
+					Key:       string(k),
+					Data:      s.Data,
+					Type:      s.Type,
+					Timestamp: s.Timestamp,
+				})
+			}
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func (p *BoltProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getSessionsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var s boltSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Type == sessionType && s.Timestamp < before {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (p *BoltProvider) getEventActions(limit, offset int, order string, _ bool) ([]BaseEventAction, error) {
@@ -2301,7 +2499,7 @@ func (p *BoltProvider) deleteEventAction(action BaseEventAction) error {
 	})
 }
 
-func (p *BoltProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
+func (p *BoltProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
 	if limit <= 0 {
 		return nil, nil
 	}
@@ -2319,6 +2517,9 @@ func (p *BoltProvider) getEventRules(limit, offset int, order string) ([]EventRu
 		cursor := bucket.Cursor()
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -2336,6 +2537,9 @@ func (p *BoltProvider) getEventRules(limit, offset int, order string) ([]EventRu
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -2723,7 +2927,7 @@ func (p *BoltProvider) deleteRole(role Role) error {
 	})
 }
 
-func (p *BoltProvider) getRoles(limit int, offset int, order string, _ bool) ([]Role, error) {
+func (p *BoltProvider) getRoles(limit int, offset int, order string, _ bool, tenant string) ([]Role, error) {
 	roles := make([]Role, 0, limit)
 	if limit <= 0 {
 		return roles, nil
@@ -2737,6 +2941,9 @@ func (p *BoltProvider) getRoles(limit int, offset int, order string, _ bool) ([]
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -2753,6 +2960,9 @@ func (p *BoltProvider) getRoles(limit int, offset int, order string, _ bool) ([]
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !boltMatchTenant(v, tenant) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -2794,6 +3004,243 @@ func (p *BoltProvider) dumpRoles() ([]Role, error) {
 	return roles, err
 }
 
+func (p *BoltProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		t := bucket.Get([]byte(name))
+		if t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return json.Unmarshal(t, &tenant)
+	})
+	return tenant, err
+}
+
+func (p *BoltProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(tenant.Name)); t != nil {
+			return util.NewI18nError(
+				fmt.Errorf("%w: tenant %q already exists", ErrDuplicatedKey, tenant.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		tenant.ID = int64(id)
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(tenant.Name), buf)
+	})
+}
+
+func (p *BoltProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var t []byte
+		if t = bucket.Get([]byte(tenant.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		var oldTenant Tenant
+		err = json.Unmarshal(t, &oldTenant)
+		if err != nil {
+			return err
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(tenant)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(tenant.Name), buf)
+	})
+}
+
+func (p *BoltProvider) deleteTenant(tenant Tenant) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if t := bucket.Get([]byte(tenant.Name)); t == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		for _, name := range [][]byte{usersBucket, adminsBucket, groupsBucket, foldersBucket, rolesBucket, rulesBucket} {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+			cursor := b.Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if boltMatchTenant(v, tenant.Name) {
+					return util.NewValidationError(fmt.Sprintf("the tenant %q is referenced, it cannot be removed",
+						tenant.Name))
+				}
+			}
+		}
+		return bucket.Delete([]byte(tenant.Name))
+	})
+}
+
+func (p *BoltProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err = json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var tenant Tenant
+				err = json.Unmarshal(v, &tenant)
+				if err != nil {
+					return err
+				}
+				tenants = append(tenants, tenant)
+				if len(tenants) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return tenants, err
+}
+
+func (p *BoltProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getTenantsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var tenant Tenant
+			err = json.Unmarshal(v, &tenant)
+			if err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+		}
+		return err
+	})
+	return tenants, err
+}
+
+func (p *BoltProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getAuditLogsBucket(tx)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = int64(id)
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltAuditLogKey(entry.ID), buf)
+	})
+}
+
+func (p *BoltProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getAuditLogsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(boltAuditLogKey(from + 1)); k != nil && len(entries) < limit; k, v = cursor.Next() {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *BoltProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	var entry AuditLogEntry
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getAuditLogsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var e AuditLogEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Node == node {
+				entry = e
+				return nil
+			}
+		}
+		return util.NewRecordNotFoundError(fmt.Sprintf("no audit log entry for node %q", node))
+	})
+	return entry, err
+}
+
 func (p *BoltProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	entry := IPListEntry{
 		IPOrNet: ipOrNet,
@@ -3139,6 +3586,8 @@ func (p *BoltProvider) migrateDatabase() error {
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 29:
+		return updateBoltDatabaseFrom29To34(p.dbHandle)
 	default:
 		if version > boltDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -3160,6 +3609,8 @@ func (p *BoltProvider) revertDatabase(targetVersion int) error { //nolint:gocycl
 		return errors.New("current version match target version, nothing to do")
 	}
 	switch dbVersion.Version {
+	case 34:
+		return downgradeBoltDatabaseFrom34To29(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %v", dbVersion.Version)
 	}
@@ -3836,6 +4287,24 @@ func (p *BoltProvider) getGroupsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getTenantsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(tenantsBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find tenants bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func (p *BoltProvider) getAuditLogsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(auditLogsBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find audit logs bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getRolesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 	bucket := tx.Bucket(rolesBucket)
@@ -3845,6 +4314,15 @@ func (p *BoltProvider) getRolesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getSessionsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(sessionsBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find shared sessions bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getIPListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 	bucket := tx.Bucket(rolesBucket)
@@ -3900,7 +4378,34 @@ func getBoltDatabaseVersion(dbHandle *bolt.DB) (schemaVersion, error) {
 	return dbVersion, err
 }
 
-/*func updateBoltDatabaseVersion(dbHandle *bolt.DB, version int) error {
+// updateBoltDatabaseFrom29To34 only sets the new version: the buckets added in
+// the SQL versions from 30 to 34 are created when the database is opened and
+// the new fields are stored inside the serialized objects
+func updateBoltDatabaseFrom29To34(dbHandle *bolt.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 34")
+	return updateBoltDatabaseVersion(dbHandle, 34)
+}
+
+func downgradeBoltDatabaseFrom34To29(dbHandle *bolt.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 29")
+	err := dbHandle.Update(func(tx *bolt.Tx) error {
+		for _, bucketName := range [][]byte{tenantsBucket, auditLogsBucket} {
+			err := tx.DeleteBucket(bucketName)
+			if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return fmt.Errorf("unable to remove bucket %v: %w", bucketName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return updateBoltDatabaseVersion(dbHandle, 29)
+}
+
+func updateBoltDatabaseVersion(dbHandle *bolt.DB, version int) error {
 	err := dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(dbVersionBucket)
 		if bucket == nil {
@@ -3916,4 +4421,27 @@ func getBoltDatabaseVersion(dbHandle *bolt.DB) (schemaVersion, error) {
 		return bucket.Put(dbVersionKey, buf)
 	})
 	return err
-}*/
+}
+
+// boltAuditLogKey returns the key for the audit log entry with the given ID,
+// big endian encoding preserves the ordering
+func boltAuditLogKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// boltMatchTenant returns true if the serialized object belongs to the
+// specified tenant or if no tenant is specified
+func boltMatchTenant(v []byte, tenant string) bool {
+	if tenant == "" {
+		return true
+	}
+	var obj struct {
+		Tenant string `json:"tenant"`
+	}
+	if err := json.Unmarshal(v, &obj); err != nil {
+		return false
+	}
+	return obj.Tenant == tenant
+}
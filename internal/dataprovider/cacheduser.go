@@ -26,12 +26,16 @@ import (
 
 var (
 	webDAVUsersCache *usersCache
+	s3UsersCache     *usersCache
 )
 
 func init() {
 	webDAVUsersCache = &usersCache{
 		users: map[string]CachedUser{},
 	}
+	s3UsersCache = &usersCache{
+		users: map[string]CachedUser{},
+	}
 }
 
 // InitializeWebDAVUserCache initializes the cache for webdav users
@@ -42,6 +46,15 @@ func InitializeWebDAVUserCache(maxSize int) {
 	}
 }
 
+// InitializeS3UserCache initializes the cache for the users authenticated
+// by the S3 compatible API
+func InitializeS3UserCache(maxSize int) {
+	s3UsersCache = &usersCache{
+		users:   map[string]CachedUser{},
+		maxSize: maxSize,
+	}
+}
+
 // CachedUser adds fields useful for caching to a SFTPGo user
 type CachedUser struct {
 	User       User
@@ -178,3 +191,38 @@ func GetCachedWebDAVUser(username string) (*CachedUser, bool) {
 func RemoveCachedWebDAVUser(username string) {
 	webDAVUsersCache.remove(username)
 }
+
+// CacheS3User adds a copy of the specified user to the S3 cache. The access
+// key is the username, the cached user is removed each time the user is updated
+func CacheS3User(cachedUser *CachedUser) {
+	cu := *cachedUser
+	cu.User = cachedUser.User.getACopy()
+	s3UsersCache.add(&cu)
+}
+
+// GetCachedS3User returns a copy of a previously cached S3 user, the copy
+// does not share the secrets with the cached user
+func GetCachedS3User(accessKey string) (*CachedUser, bool) {
+	cachedUser, ok := s3UsersCache.get(accessKey)
+	if ok {
+		cachedUser.User = cachedUser.User.getACopy()
+	}
+	return cachedUser, ok
+}
+
+// RemoveCachedS3User removes a cached S3 user
+func RemoveCachedS3User(accessKey string) {
+	s3UsersCache.remove(accessKey)
+}
+
+// swapCachedUser updates the cached users after a user change. The cached
+// S3 user is removed, the next request will authenticate it again
+func swapCachedUser(user *User, plainPassword string) {
+	webDAVUsersCache.swap(user, plainPassword)
+	s3UsersCache.remove(user.Username)
+}
+
+func removeCachedUser(username string) {
+	webDAVUsersCache.remove(username)
+	s3UsersCache.remove(username)
+}
@@ -390,10 +390,11 @@ func (c *ACMEConfigs) getACopy() *ACMEConfigs {
 // Configs allows to set configuration keys disabled by default without
 // modifying the config file or setting env vars
 type Configs struct {
-	SFTPD     *SFTPDConfigs `json:"sftpd,omitempty"`
-	SMTP      *SMTPConfigs  `json:"smtp,omitempty"`
-	ACME      *ACMEConfigs  `json:"acme,omitempty"`
-	UpdatedAt int64         `json:"updated_at,omitempty"`
+	SFTPD      *SFTPDConfigs `json:"sftpd,omitempty"`
+	SMTP       *SMTPConfigs  `json:"smtp,omitempty"`
+	ACME       *ACMEConfigs  `json:"acme,omitempty"`
+	OAuth2Apps []OAuth2App   `json:"oauth2_apps,omitempty"`
+	UpdatedAt  int64         `json:"updated_at,omitempty"`
 }
 
 func (c *Configs) validate() error {
@@ -412,6 +413,16 @@ func (c *Configs) validate() error {
 			return err
 		}
 	}
+	clientIDs := make(map[string]bool)
+	for idx := range c.OAuth2Apps {
+		if err := c.OAuth2Apps[idx].validate(); err != nil {
+			return err
+		}
+		if clientIDs[c.OAuth2Apps[idx].ClientID] {
+			return util.NewValidationError(fmt.Sprintf("duplicated OAuth2 app %q", c.OAuth2Apps[idx].ClientID))
+		}
+		clientIDs[c.OAuth2Apps[idx].ClientID] = true
+	}
 	return nil
 }
 
@@ -428,6 +439,9 @@ func (c *Configs) PrepareForRendering() {
 	if c.ACME != nil && c.ACME.isEmpty() {
 		c.ACME = nil
 	}
+	for idx := range c.OAuth2Apps {
+		c.OAuth2Apps[idx].HideConfidentialData()
+	}
 	if c.SMTP != nil {
 		if c.SMTP.Password != nil {
 			c.SMTP.Password.Hide()
@@ -498,6 +512,9 @@ func (c *Configs) getACopy() Configs {
 	if c.ACME != nil {
 		result.ACME = c.ACME.getACopy()
 	}
+	for idx := range c.OAuth2Apps {
+		result.OAuth2Apps = append(result.OAuth2Apps, c.OAuth2Apps[idx].getACopy())
+	}
 	result.UpdatedAt = c.UpdatedAt
 	return result
 }
@@ -68,6 +68,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -87,6 +88,10 @@ const (
 	MemoryDataProviderName = "memory"
 	// CockroachDataProviderName defines the for CockroachDB provider
 	CockroachDataProviderName = "cockroachdb"
+	// MongoDBDataProviderName defines the name for MongoDB database provider
+	MongoDBDataProviderName = "mongodb"
+	// EtcdDataProviderName defines the name for etcd key/value store provider
+	EtcdDataProviderName = "etcd"
 	// DumpVersion defines the version for the dump.
 	// For restore/load we support the current version and the previous one
 	DumpVersion = 16
@@ -109,6 +114,7 @@ const (
 	operationAdd              = "add"
 	operationUpdate           = "update"
 	operationDelete           = "delete"
+	operationImpersonate      = "impersonate"
 	sqlPrefixValidChars       = "abcdefghijklmnopqrstuvwxyz_0123456789"
 	maxHookResponseSize       = 1048576 // 1MB
 	iso8601UTCFormat          = "2006-01-02T15:04:05Z"
@@ -132,6 +138,7 @@ const (
 	protocolFTP    = "FTP"
 	protocolWebDAV = "DAV"
 	protocolHTTP   = "HTTP"
+	protocolS3     = "S3"
 )
 
 // Dump scopes
@@ -147,6 +154,7 @@ const (
 	DumpScopeRoles   = "roles"
 	DumpScopeIPLists = "ip_lists"
 	DumpScopeConfigs = "configs"
+	DumpScopeTenants = "tenants"
 )
 
 const (
@@ -158,15 +166,18 @@ const (
 var (
 	// SupportedProviders defines the supported data providers
 	SupportedProviders = []string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
-		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName}
+		BoltDataProviderName, MemoryDataProviderName, CockroachDataProviderName, MongoDBDataProviderName,
+		EtcdDataProviderName}
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename,
 		PermRenameFiles, PermRenameDirs, PermDelete, PermDeleteFiles, PermDeleteDirs, PermCopy, PermCreateSymlinks,
 		PermChmod, PermChown, PermChtimes}
+	// permissions removed for users in append-only mode
+	appendOnlyDeniedPerms = []string{PermAny, PermOverwrite, PermDelete, PermDeleteFiles, PermDeleteDirs}
 	// ValidLoginMethods defines all the valid login methods
 	ValidLoginMethods = []string{SSHLoginMethodPublicKey, LoginMethodPassword, SSHLoginMethodPassword,
 		SSHLoginMethodKeyboardInteractive, SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt,
-		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd}
+		LoginMethodTLSCertificate, LoginMethodTLSCertificateAndPwd, SSHLoginMethodGSSAPI}
 	// SSHMultiStepsLoginMethods defines the supported Multi-Step Authentications
 	SSHMultiStepsLoginMethods = []string{SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
 	// ErrNoAuthTried defines the error for connection closed before authentication
@@ -174,9 +185,11 @@ var (
 	// ErrNotImplemented defines the error for features not supported for a particular data provider
 	ErrNotImplemented = errors.New("feature not supported with the configured data provider")
 	// ValidProtocols defines all the valid protcols
-	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP}
+	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP, protocolS3}
 	// MFAProtocols defines the supported protocols for multi-factor authentication
 	MFAProtocols = []string{protocolHTTP, protocolSSH, protocolFTP}
+	// BandwidthLimitProtocols defines the protocols for which a bandwidth limit can be set
+	BandwidthLimitProtocols = []string{"SFTP", "SCP", protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP, "HTTPShare", protocolS3}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
 	ErrNoInitRequired = errors.New("the data provider is up to date")
 	// ErrInvalidCredentials defines the error to return if the supplied credentials are invalid
@@ -201,8 +214,9 @@ var (
 	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
 	unixPwdPrefixes         = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha256cryptPwdPrefix, sha512cryptPwdPrefix,
 		yescryptPwdPrefix}
-	digestPwdPrefixes            = []string{md5DigestPwdPrefix, sha256DigestPwdPrefix, sha512DigestPwdPrefix}
-	sharedProviders              = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
+	digestPwdPrefixes = []string{md5DigestPwdPrefix, sha256DigestPwdPrefix, sha512DigestPwdPrefix}
+	sharedProviders   = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName,
+		MongoDBDataProviderName, EtcdDataProviderName}
 	logSender                    = "dataprovider"
 	sqlTableUsers                string
 	sqlTableFolders              string
@@ -224,6 +238,8 @@ var (
 	sqlTableTasks                string
 	sqlTableNodes                string
 	sqlTableRoles                string
+	sqlTableTenants              string
+	sqlTableAuditLogs            string
 	sqlTableIPLists              string
 	sqlTableConfigs              string
 	sqlTableSchemaVersion        string
@@ -258,6 +274,8 @@ func initSQLTables() {
 	sqlTableTasks = "tasks"
 	sqlTableNodes = "nodes"
 	sqlTableRoles = "roles"
+	sqlTableTenants = "tenants"
+	sqlTableAuditLogs = "audit_logs"
 	sqlTableIPLists = "ip_lists"
 	sqlTableConfigs = "configurations"
 	sqlTableSchemaVersion = "schema_version"
@@ -270,7 +288,9 @@ type FnReloadRules func()
 type FnRemoveRule func(name string)
 
 // FnHandleRuleForProviderEvent define the callback to handle event rules for provider events
-type FnHandleRuleForProviderEvent func(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer)
+// snapshot is the object serialized as JSON before an update, it is empty for other operations
+type FnHandleRuleForProviderEvent func(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer,
+	snapshot []byte)
 
 // SetEventRulesCallbacks sets the event rules callbacks
 func SetEventRulesCallbacks(reload FnReloadRules, remove FnRemoveRule, handle FnHandleRuleForProviderEvent) {
@@ -295,7 +315,9 @@ type Argon2Options struct {
 	Parallelism uint8  `json:"parallelism" mapstructure:"parallelism"`
 }
 
-// PasswordHashing defines the configuration for password hashing
+// PasswordHashing defines the configuration for password hashing.
+// Existing hashes using a legacy scheme, a different algorithm or weaker
+// parameters are upgraded on the next successful login
 type PasswordHashing struct {
 	BcryptOptions BcryptOptions `json:"bcrypt_options" mapstructure:"bcrypt_options"`
 	Argon2Options Argon2Options `json:"argon2_options" mapstructure:"argon2_options"`
@@ -346,6 +368,10 @@ type ObjectsActions struct {
 	ExecuteFor []string `json:"execute_for" mapstructure:"execute_for"`
 	// Absolute path to an external program or an HTTP URL
 	Hook string `json:"hook" mapstructure:"hook"`
+	// If enabled, an RFC 6902 JSON patch describing the change is sent to the hook.
+	// HTTP hooks receive a JSON body with the "object" and "diff" keys instead of
+	// the plain object, for commands the SFTPGO_PROVIDER_OBJECT_DIFF env var is set
+	IncludeDiff bool `json:"include_diff" mapstructure:"include_diff"`
 }
 
 // ProviderStatus defines the provider status
@@ -360,9 +386,10 @@ type Config struct {
 	// Driver name, must be one of the SupportedProviders
 	Driver string `json:"driver" mapstructure:"driver"`
 	// Database name. For driver sqlite this can be the database name relative to the config dir
-	// or the absolute path to the SQLite database.
+	// or the absolute path to the SQLite database. For driver etcd it is used as key prefix.
 	Name string `json:"name" mapstructure:"name"`
-	// Database host. For postgresql and cockroachdb driver you can specify multiple hosts separated by commas
+	// Database host. For postgresql, cockroachdb, mongodb and etcd driver you can specify multiple hosts
+	// separated by commas
 	Host string `json:"host" mapstructure:"host"`
 	// Database port
 	Port int `json:"port" mapstructure:"port"`
@@ -370,10 +397,10 @@ type Config struct {
 	Username string `json:"username" mapstructure:"username"`
 	// Database password
 	Password string `json:"password" mapstructure:"password"`
-	// Used for drivers mysql and postgresql.
+	// Used for drivers mysql, postgresql, mongodb and etcd.
 	// 0 disable SSL/TLS connections.
 	// 1 require ssl.
-	// 2 set ssl mode to verify-ca for driver postgresql and skip-verify for driver mysql.
+	// 2 set ssl mode to verify-ca for driver postgresql and skip-verify for drivers mysql, mongodb and etcd.
 	// 3 set ssl mode to verify-full for driver postgresql and preferred for driver mysql.
 	SSLMode int `json:"sslmode" mapstructure:"sslmode"`
 	// Used for drivers mysql, postgresql and cockroachdb. Set to true to disable SNI
@@ -390,9 +417,11 @@ type Config struct {
 	// Path to the client key for two-way TLS authentication
 	ClientKey string `json:"client_key" mapstructure:"client_key"`
 	// Custom database connection string.
-	// If not empty this connection string will be used instead of build one using the previous parameters
+	// If not empty this connection string will be used instead of build one using the previous parameters.
+	// For driver etcd you can specify a comma separated list of endpoints
 	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
-	// prefix for SQL tables
+	// prefix for SQL tables, for driver mongodb it is used as prefix for the collections
+	// and for driver etcd it is appended to the key prefix
 	SQLTablesPrefix string `json:"sql_tables_prefix" mapstructure:"sql_tables_prefix"`
 	// Set the preferred way to track users quota between the following choices:
 	// 0, disable quota tracking. REST API to scan user dir and update quota will do nothing
@@ -402,9 +431,17 @@ type Config struct {
 	//    With this configuration the "quota scan" REST API can still be used to periodically update space usage
 	//    for users without quota restrictions
 	TrackQuota int `json:"track_quota" mapstructure:"track_quota"`
-	// Sets the maximum number of open connections for mysql and postgresql driver.
+	// Sets the maximum number of open connections for mysql, postgresql and mongodb driver.
 	// Default 0 (unlimited)
 	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
+	// Read replicas for mysql and postgresql drivers
+	ReadReplicas ReadReplicasConfig `json:"read_replicas" mapstructure:"read_replicas"`
+	// Redis backed cache for users and groups, shared between the cluster nodes
+	RedisCache RedisCacheConfig `json:"redis_cache" mapstructure:"redis_cache"`
+	// Hash chained audit log for administrative changes and authentication decisions
+	AuditLog AuditLogConfig `json:"audit_log" mapstructure:"audit_log"`
+	// Encrypted backups executed on a schedule and uploaded to a virtual folder
+	ScheduledBackups ScheduledBackupsConfig `json:"scheduled_backups" mapstructure:"scheduled_backups"`
 	// Users default base directory.
 	// If no home dir is defined while adding a new user, and this value is
 	// a valid absolute path, then the user home dir will be automatically
@@ -431,6 +468,10 @@ type Config struct {
 	// you can combine the scopes, for example 3 means password and public key, 5 password and keyboard
 	// interactive and so on
 	ExternalAuthScope int `json:"external_auth_scope" mapstructure:"external_auth_scope"`
+	// LDAPAuth defines the built-in LDAP/Active Directory password authentication.
+	// In read-through mode users are read from LDAP for any login method.
+	// LDAP authentication and ExternalAuthHook are mutually exclusive.
+	LDAPAuth LDAPAuthConfig `json:"ldap_auth" mapstructure:"ldap_auth"`
 	// Absolute path to an external program or an HTTP URL to invoke just before the user login.
 	// This program/URL allows to modify or create the user trying to login.
 	// It is useful if you have users with dynamic fields to update just before the login.
@@ -542,7 +583,7 @@ func (c *Config) convertName(name string) string {
 // IsDefenderSupported returns true if the configured provider supports the defender
 func (c *Config) IsDefenderSupported() bool {
 	switch c.Driver {
-	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName:
+	case MySQLDataProviderName, PGSQLDataProviderName, CockroachDataProviderName, MongoDBDataProviderName:
 		return true
 	default:
 		return false
@@ -710,6 +751,7 @@ type BackupData struct {
 	EventActions []BaseEventAction       `json:"event_actions"`
 	EventRules   []EventRule             `json:"event_rules"`
 	Roles        []Role                  `json:"roles"`
+	Tenants      []Tenant                `json:"tenants"`
 	IPLists      []IPListEntry           `json:"ip_lists"`
 	Configs      *Configs                `json:"configs"`
 	Version      int                     `json:"version"`
@@ -763,14 +805,15 @@ type Provider interface {
 	updateUser(user *User) error
 	deleteUser(user User, softDelete bool) error
 	updateUserPassword(username, password string) error // used internally when converting passwords from other hash
-	getUsers(limit int, offset int, order, role string) ([]User, error)
+	getUsers(limit int, offset int, order, role, tenant string) ([]User, error)
 	dumpUsers() ([]User, error)
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
 	getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error)
 	updateLastLogin(username string) error
 	updateAdminLastLogin(username string) error
+	updateAdminPassword(username, password string) error // used internally when upgrading password hashes
 	setUpdatedAt(username string)
-	getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error)
+	getFolders(limit, offset int, order string, minimal bool, tenant string) ([]vfs.BaseVirtualFolder, error)
 	getFolderByName(name string) (vfs.BaseVirtualFolder, error)
 	addFolder(folder *vfs.BaseVirtualFolder) error
 	updateFolder(folder *vfs.BaseVirtualFolder) error
@@ -778,7 +821,7 @@ type Provider interface {
 	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedFolderQuota(name string) (int, int64, error)
 	dumpFolders() ([]vfs.BaseVirtualFolder, error)
-	getGroups(limit, offset int, order string, minimal bool) ([]Group, error)
+	getGroups(limit, offset int, order string, minimal bool, tenant string) ([]Group, error)
 	getGroupsWithNames(names []string) ([]Group, error)
 	getUsersInGroups(names []string) ([]string, error)
 	groupExists(name string) (Group, error)
@@ -790,7 +833,7 @@ type Provider interface {
 	addAdmin(admin *Admin) error
 	updateAdmin(admin *Admin) error
 	deleteAdmin(admin Admin) error
-	getAdmins(limit int, offset int, order string) ([]Admin, error)
+	getAdmins(limit int, offset int, order, tenant string) ([]Admin, error)
 	dumpAdmins() ([]Admin, error)
 	validateAdminAndPass(username, password, ip string) (Admin, error)
 	apiKeyExists(keyID string) (APIKey, error)
@@ -807,6 +850,7 @@ type Provider interface {
 	getShares(limit int, offset int, order, username string) ([]Share, error)
 	dumpShares() ([]Share, error)
 	updateShareLastUse(shareID string, numTokens int) error
+	updateShareStats(shareID, ipAddress string, filePaths []string) error
 	getDefenderHosts(from int64, limit int) ([]DefenderEntry, error)
 	getDefenderHostByIP(ip string, from int64) (DefenderEntry, error)
 	isDefenderHostBanned(ip string) (DefenderEntry, error)
@@ -823,6 +867,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string) error
 	getSharedSession(key string) (Session, error)
+	getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -830,7 +875,7 @@ type Provider interface {
 	addEventAction(action *BaseEventAction) error
 	updateEventAction(action *BaseEventAction) error
 	deleteEventAction(action BaseEventAction) error
-	getEventRules(limit, offset int, order string) ([]EventRule, error)
+	getEventRules(limit, offset int, order, tenant string) ([]EventRule, error)
 	dumpEventRules() ([]EventRule, error)
 	getRecentlyUpdatedRules(after int64) ([]EventRule, error)
 	eventRuleExists(name string) (EventRule, error)
@@ -852,8 +897,17 @@ type Provider interface {
 	addRole(role *Role) error
 	updateRole(role *Role) error
 	deleteRole(role Role) error
-	getRoles(limit int, offset int, order string, minimal bool) ([]Role, error)
+	getRoles(limit int, offset int, order string, minimal bool, tenant string) ([]Role, error)
 	dumpRoles() ([]Role, error)
+	tenantExists(name string) (Tenant, error)
+	addTenant(tenant *Tenant) error
+	updateTenant(tenant *Tenant) error
+	deleteTenant(tenant Tenant) error
+	getTenants(limit int, offset int, order string) ([]Tenant, error)
+	dumpTenants() ([]Tenant, error)
+	addAuditLogEntry(entry *AuditLogEntry) error
+	getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error)
+	getLastAuditLogEntry(node string) (AuditLogEntry, error)
 	ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error)
 	addIPListEntry(entry *IPListEntry) error
 	updateIPListEntry(entry *IPListEntry) error
@@ -914,13 +968,32 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := validateHooks(); err != nil {
 		return err
 	}
+	if err := config.LDAPAuth.validate(basePath); err != nil {
+		return err
+	}
+	if err := config.ReadReplicas.validate(); err != nil {
+		return err
+	}
+	if err := config.RedisCache.validate(); err != nil {
+		return err
+	}
+	if err := config.ScheduledBackups.validate(basePath); err != nil {
+		return err
+	}
 	if err := createProvider(basePath); err != nil {
 		return err
 	}
+	if config.RedisCache.isEnabled() {
+		cachedProvider, err := newRedisCachedProvider(provider)
+		if err != nil {
+			return err
+		}
+		provider = cachedProvider
+	}
 	if err := checkDatabase(checkAdmins); err != nil {
 		return err
 	}
-	admins, err := provider.getAdmins(1, 0, OrderASC)
+	admins, err := provider.getAdmins(1, 0, OrderASC, "")
 	if err != nil {
 		return err
 	}
@@ -928,6 +1001,7 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := config.Node.validate(); err != nil {
 		return err
 	}
+	auditLog.initialize()
 	delayedQuotaUpdater.start()
 	if currentNode != nil {
 		config.BackupsPath = filepath.Join(config.BackupsPath, currentNode.Name)
@@ -1008,7 +1082,7 @@ func GetProviderFromValue(value string) sdk.FilesystemProvider {
 		return sdk.LocalFilesystemProvider
 	}
 	result := sdk.FilesystemProvider(val)
-	if sdk.IsProviderSupported(result) {
+	if vfs.IsProviderSupported(result) {
 		return result
 	}
 	return sdk.LocalFilesystemProvider
@@ -1066,6 +1140,8 @@ func validateSQLTablesPrefix() error {
 		sqlTableTasks = config.SQLTablesPrefix + sqlTableTasks
 		sqlTableNodes = config.SQLTablesPrefix + sqlTableNodes
 		sqlTableRoles = config.SQLTablesPrefix + sqlTableRoles
+		sqlTableTenants = config.SQLTablesPrefix + sqlTableTenants
+		sqlTableAuditLogs = config.SQLTablesPrefix + sqlTableAuditLogs
 		sqlTableIPLists = config.SQLTablesPrefix + sqlTableIPLists
 		sqlTableConfigs = config.SQLTablesPrefix + sqlTableConfigs
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
@@ -1073,18 +1149,19 @@ func validateSQLTablesPrefix() error {
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
 			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q"+
-			"ip lists %q configs %q",
+			"tenants %q audit logs %q ip lists %q configs %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs)
+			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableTenants, sqlTableAuditLogs, sqlTableIPLists,
+			sqlTableConfigs)
 	}
 	return nil
 }
 
 func checkDefaultAdmin() error {
-	admins, err := provider.getAdmins(1, 0, OrderASC)
+	admins, err := provider.getAdmins(1, 0, OrderASC, "")
 	if err != nil {
 		return err
 	}
@@ -1147,7 +1224,11 @@ func ResetDatabase(cnf Config, basePath string) error {
 // CheckAdminAndPass validates the given admin and password connecting from ip
 func CheckAdminAndPass(username, password, ip string) (Admin, error) {
 	username = config.convertName(username)
-	return provider.validateAdminAndPass(username, password, ip)
+	startTime := time.Now()
+	admin, err := provider.validateAdminAndPass(username, password, ip)
+	updateQueryMetrics("check_admin_password", startTime, err)
+	addAuthAuditLogEntry(actionObjectAdmin, username, LoginMethodPassword, ip, protocolHTTP, admin.Role, err)
+	return admin, err
 }
 
 // CheckCachedUserCredentials checks the credentials for a cached user
@@ -1222,6 +1303,8 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 	if loginMethod == LoginMethodTLSCertificateAndPwd {
 		if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
 			user, err = doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
+		} else if config.LDAPAuth.isEnabled() {
+			user, err = doLDAPAuth(username, password, ip, protocol)
 		} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 			user, err = doExternalAuth(username, password, nil, "", ip, protocol, nil)
 		} else if config.PreLoginHook != "" {
@@ -1246,6 +1329,14 @@ func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certifi
 		err = user.LoadAndApplyGroupSettings()
 		return user, err
 	}
+	if config.LDAPAuth.isReadThroughEnabled() {
+		user, err := doLDAPLookup(username, ip, protocol)
+		if err != nil {
+			return user, err
+		}
+		err = user.LoadAndApplyGroupSettings()
+		return user, err
+	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
 		user, err := doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
 		if err != nil {
@@ -1281,6 +1372,13 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
+	if config.LDAPAuth.isReadThroughEnabled() {
+		user, err := doLDAPLookup(username, ip, protocol)
+		if err != nil {
+			return user, err
+		}
+		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
 		user, err := doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
 		if err != nil {
@@ -1298,6 +1396,33 @@ func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificat
 	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
 }
 
+// CheckUserAndGSSAPIAuth returns the SFTPGo user with the given username after
+// a successful GSSAPI authentication. The Kerberos principal is already verified
+// and mapped to the username so only the login conditions are checked here.
+// Plugins and external auth hooks require credentials so they are not invoked,
+// the user is read from LDAP in read-through mode, otherwise the pre-login hook,
+// if any, is executed so the user can be provisioned
+func CheckUserAndGSSAPIAuth(username, ip, protocol string) (User, error) {
+	var user User
+	var err error
+	username = config.convertName(username)
+	if config.LDAPAuth.isReadThroughEnabled() {
+		user, err = doLDAPLookup(username, ip, protocol)
+	} else if config.PreLoginHook != "" {
+		user, err = executePreLoginHook(username, SSHLoginMethodGSSAPI, ip, protocol, nil)
+	} else {
+		user, err = UserExists(username, "")
+	}
+	if err != nil {
+		return user, err
+	}
+	if err = user.LoadAndApplyGroupSettings(); err != nil {
+		return user, err
+	}
+	err = user.CheckLoginConditions()
+	return user, err
+}
+
 // CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
 func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 	username = config.convertName(username)
@@ -1308,6 +1433,13 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
+	if config.LDAPAuth.isEnabled() {
+		user, err := doLDAPAuth(username, password, ip, protocol)
+		if err != nil {
+			return user, err
+		}
+		return checkUserAndPass(&user, password, ip, protocol)
+	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 		user, err := doExternalAuth(username, password, nil, "", ip, protocol, nil)
 		if err != nil {
@@ -1322,7 +1454,10 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
-	return provider.validateUserAndPass(username, password, ip, protocol)
+	startTime := time.Now()
+	user, err := provider.validateUserAndPass(username, password, ip, protocol)
+	updateQueryMetrics("check_user_password", startTime, err)
+	return user, err
 }
 
 // CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
@@ -1335,6 +1470,13 @@ func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isS
 		}
 		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
+	if config.LDAPAuth.isReadThroughEnabled() {
+		user, err := doLDAPLookup(username, ip, protocol)
+		if err != nil {
+			return user, "", err
+		}
+		return checkUserAndPubKey(&user, pubKey, isSSHCert)
+	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
 		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol, nil)
 		if err != nil {
@@ -1349,7 +1491,10 @@ func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isS
 		}
 		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
-	return provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	startTime := time.Now()
+	user, keyID, err := provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	updateQueryMetrics("check_user_public_key", startTime, err)
+	return user, keyID, err
 }
 
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
@@ -1362,6 +1507,8 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	username = config.convertName(username)
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
 		user, err = doPluginAuth(username, "", nil, ip, protocol, nil, plugin.AuthScopeKeyboardInteractive)
+	} else if config.LDAPAuth.isReadThroughEnabled() {
+		user, err = doLDAPLookup(username, ip, protocol)
 	} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
 		user, err = doExternalAuth(username, "", nil, "1", ip, protocol, nil)
 	} else if config.PreLoginHook != "" {
@@ -1463,6 +1610,12 @@ func UpdateShareLastUse(share *Share, numTokens int) error {
 	return provider.updateShareLastUse(share.ShareID, numTokens)
 }
 
+// UpdateShareStats updates the download statistics for the given share.
+// filePaths are the downloaded files, an empty list only updates the last access
+func UpdateShareStats(share *Share, ipAddress string, filePaths []string) error {
+	return provider.updateShareStats(share.ShareID, ipAddress, filePaths)
+}
+
 // UpdateAPIKeyLastUse updates the LastUseAt field for the given API key
 func UpdateAPIKeyLastUse(apiKey *APIKey) error {
 	lastUse := util.GetTimeFromMsecSinceEpoch(apiKey.LastUseAt)
@@ -1473,6 +1626,12 @@ func UpdateAPIKeyLastUse(apiKey *APIKey) error {
 	return nil
 }
 
+// NotifyUserImpersonation notifies that the specified user is impersonated
+// by the admin identified by executor
+func NotifyUserImpersonation(user *User, executor, ipAddress, role string) {
+	executeAction(operationImpersonate, executor, ipAddress, actionObjectUser, user.Username, role, user)
+}
+
 // UpdateLastLogin updates the last login field for the given SFTPGo user
 func UpdateLastLogin(user *User) {
 	delay := lastLoginMinDelay
@@ -1509,7 +1668,10 @@ func UpdateUserQuota(user *User, filesAdd int, sizeAdd int64, reset bool) error
 		if reset {
 			delayedQuotaUpdater.resetUserQuota(user.Username)
 		}
-		return provider.updateQuota(user.Username, filesAdd, sizeAdd, reset)
+		startTime := time.Now()
+		err := provider.updateQuota(user.Username, filesAdd, sizeAdd, reset)
+		updateQueryMetrics("update_user_quota", startTime, err)
+		return err
 	}
 	delayedQuotaUpdater.updateUserQuota(user.Username, filesAdd, sizeAdd)
 	return nil
@@ -1612,9 +1774,10 @@ func UpdateConfigs(configs *Configs, executor, ipAddress, role string) error {
 	} else {
 		configs.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	}
+	snapshot := getObjectSnapshot(configs)
 	err := provider.setConfigs(configs)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectConfigs, "configs", role, configs)
+		executeUpdateAction(executor, ipAddress, actionObjectConfigs, "configs", role, configs, snapshot)
 	}
 	return err
 }
@@ -1711,9 +1874,58 @@ func GetIPListEntries(listType IPListType, filter, from, order string, limit int
 	return provider.getIPListEntries(listType, filter, from, order, limit)
 }
 
+// AddTenant adds a new tenant
+func AddTenant(tenant *Tenant, executor, ipAddress string) error {
+	tenant.Name = config.convertName(tenant.Name)
+	err := provider.addTenant(tenant)
+	if err == nil {
+		executeAction(operationAdd, executor, ipAddress, actionObjectTenant, tenant.Name, "", tenant)
+	}
+	return err
+}
+
+// UpdateTenant updates an existing tenant
+func UpdateTenant(tenant *Tenant, executor, ipAddress string) error {
+	snapshot := getObjectSnapshot(tenant)
+	err := provider.updateTenant(tenant)
+	if err == nil {
+		executeUpdateAction(executor, ipAddress, actionObjectTenant, tenant.Name, "", tenant, snapshot)
+	}
+	return err
+}
+
+// DeleteTenant deletes an existing tenant, a tenant cannot be removed while
+// it is referenced by any object
+func DeleteTenant(name string, executor, ipAddress string) error {
+	name = config.convertName(name)
+	tenant, err := provider.tenantExists(name)
+	if err != nil {
+		return err
+	}
+	err = provider.deleteTenant(tenant)
+	if err == nil {
+		executeAction(operationDelete, executor, ipAddress, actionObjectTenant, tenant.Name, "", &tenant)
+	}
+	return err
+}
+
+// TenantExists returns the tenant with the given name if it exists
+func TenantExists(name string) (Tenant, error) {
+	name = config.convertName(name)
+	return provider.tenantExists(name)
+}
+
+// GetTenants returns an array of tenants respecting limit and offset
+func GetTenants(limit, offset int, order string) ([]Tenant, error) {
+	return provider.getTenants(limit, offset, order)
+}
+
 // AddRole adds a new role
 func AddRole(role *Role, executor, ipAddress, executorRole string) error {
 	role.Name = config.convertName(role.Name)
+	if err := checkTenantExists(role.Tenant); err != nil {
+		return err
+	}
 	err := provider.addRole(role)
 	if err == nil {
 		executeAction(operationAdd, executor, ipAddress, actionObjectRole, role.Name, executorRole, role)
@@ -1723,6 +1935,9 @@ func AddRole(role *Role, executor, ipAddress, executorRole string) error {
 
 // UpdateRole updates an existing Role
 func UpdateRole(role *Role, executor, ipAddress, executorRole string) error {
+	if oldRole, err := provider.roleExists(role.Name); err == nil {
+		role.Tenant = oldRole.Tenant
+	}
 	err := provider.updateRole(role)
 	if err == nil {
 		executeAction(operationUpdate, executor, ipAddress, actionObjectRole, role.Name, executorRole, role)
@@ -1748,7 +1963,7 @@ func DeleteRole(name string, executor, ipAddress, executorRole string) error {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
-				webDAVUsersCache.swap(&u, "")
+				swapCachedUser(&u, "")
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			}
 		}
@@ -1765,6 +1980,9 @@ func RoleExists(name string) (Role, error) {
 // AddGroup adds a new group
 func AddGroup(group *Group, executor, ipAddress, role string) error {
 	group.Name = config.convertName(group.Name)
+	if err := checkGroupTenant(group); err != nil {
+		return err
+	}
 	err := provider.addGroup(group)
 	if err == nil {
 		executeAction(operationAdd, executor, ipAddress, actionObjectGroup, group.Name, role, group)
@@ -1774,18 +1992,25 @@ func AddGroup(group *Group, executor, ipAddress, role string) error {
 
 // UpdateGroup updates an existing Group
 func UpdateGroup(group *Group, users []string, executor, ipAddress, role string) error {
+	if oldGroup, err := provider.groupExists(group.Name); err == nil {
+		group.Tenant = oldGroup.Tenant
+	}
+	if err := checkGroupTenant(group); err != nil {
+		return err
+	}
+	snapshot := getObjectSnapshot(group)
 	err := provider.updateGroup(group)
 	if err == nil {
 		for _, user := range users {
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
-				webDAVUsersCache.swap(&u, "")
+				swapCachedUser(&u, "")
 			} else {
-				RemoveCachedWebDAVUser(user)
+				removeCachedUser(user)
 			}
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, role, group)
+		executeUpdateAction(executor, ipAddress, actionObjectGroup, group.Name, role, group, snapshot)
 	}
 	return err
 }
@@ -1809,7 +2034,7 @@ func DeleteGroup(name string, executor, ipAddress, role string) error {
 			if err == nil {
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			}
-			RemoveCachedWebDAVUser(user)
+			removeCachedUser(user)
 		}
 		executeAction(operationDelete, executor, ipAddress, actionObjectGroup, group.Name, role, &group)
 	}
@@ -1885,12 +2110,13 @@ func AddEventAction(action *BaseEventAction, executor, ipAddress, role string) e
 
 // UpdateEventAction updates an existing event action
 func UpdateEventAction(action *BaseEventAction, executor, ipAddress, role string) error {
+	snapshot := getObjectSnapshot(action)
 	err := provider.updateEventAction(action)
 	if err == nil {
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectEventAction, action.Name, role, action)
+		executeUpdateAction(executor, ipAddress, actionObjectEventAction, action.Name, role, action, snapshot)
 	}
 	return err
 }
@@ -1913,9 +2139,10 @@ func DeleteEventAction(name string, executor, ipAddress, role string) error {
 	return err
 }
 
-// GetEventRules returns an array of event rules respecting limit and offset
-func GetEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return provider.getEventRules(limit, offset, order)
+// GetEventRules returns an array of event rules respecting limit and offset.
+// If tenant is not empty only the event rules within the tenant are returned
+func GetEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	return provider.getEventRules(limit, offset, order, tenant)
 }
 
 // GetRecentlyUpdatedRules returns the event rules updated after the specified time
@@ -1932,6 +2159,9 @@ func EventRuleExists(name string) (EventRule, error) {
 // AddEventRule adds a new event rule
 func AddEventRule(rule *EventRule, executor, ipAddress, role string) error {
 	rule.Name = config.convertName(rule.Name)
+	if err := checkEventRuleTenant(rule); err != nil {
+		return err
+	}
 	err := provider.addEventRule(rule)
 	if err == nil {
 		if fnReloadRules != nil {
@@ -1944,12 +2174,19 @@ func AddEventRule(rule *EventRule, executor, ipAddress, role string) error {
 
 // UpdateEventRule updates an existing event rule
 func UpdateEventRule(rule *EventRule, executor, ipAddress, role string) error {
+	if oldRule, err := provider.eventRuleExists(rule.Name); err == nil {
+		rule.Tenant = oldRule.Tenant
+	}
+	if err := checkEventRuleTenant(rule); err != nil {
+		return err
+	}
+	snapshot := getObjectSnapshot(rule)
 	err := provider.updateEventRule(rule)
 	if err == nil {
 		if fnReloadRules != nil {
 			fnReloadRules()
 		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectEventRule, rule.Name, role, rule)
+		executeUpdateAction(executor, ipAddress, actionObjectEventRule, rule.Name, role, rule, snapshot)
 	}
 	return err
 }
@@ -2032,6 +2269,9 @@ func AddAdmin(admin *Admin, executor, ipAddress, role string) error {
 		Enabled: false,
 	}
 	admin.Username = config.convertName(admin.Username)
+	if err := checkAdminTenant(admin); err != nil {
+		return err
+	}
 	err := provider.addAdmin(admin)
 	if err == nil {
 		isAdminCreated.Store(true)
@@ -2042,9 +2282,16 @@ func AddAdmin(admin *Admin, executor, ipAddress, role string) error {
 
 // UpdateAdmin updates an existing SFTPGo admin
 func UpdateAdmin(admin *Admin, executor, ipAddress, role string) error {
+	if oldAdmin, err := provider.adminExists(admin.Username); err == nil {
+		admin.Tenant = oldAdmin.Tenant
+	}
+	if err := checkAdminTenant(admin); err != nil {
+		return err
+	}
+	snapshot := getObjectSnapshot(admin)
 	err := provider.updateAdmin(admin)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectAdmin, admin.Username, role, admin)
+		executeUpdateAction(executor, ipAddress, actionObjectAdmin, admin.Username, role, admin, snapshot)
 	}
 	return err
 }
@@ -2073,7 +2320,10 @@ func AdminExists(username string) (Admin, error) {
 // UserExists checks if the given SFTPGo username exists, returns an error if no match is found
 func UserExists(username, role string) (User, error) {
 	username = config.convertName(username)
-	return provider.userExists(username, role)
+	startTime := time.Now()
+	user, err := provider.userExists(username, role)
+	updateQueryMetrics("user_exists", startTime, err)
+	return user, err
 }
 
 // GetUserWithGroupSettings tries to return the user with the specified username
@@ -2104,7 +2354,12 @@ func GetUserVariants(username, role string) (User, User, error) {
 // AddUser adds a new SFTPGo user.
 func AddUser(user *User, executor, ipAddress, role string) error {
 	user.Username = config.convertName(user.Username)
+	if err := checkUserTenant(user); err != nil {
+		return err
+	}
+	startTime := time.Now()
 	err := provider.addUser(user)
+	updateQueryMetrics("add_user", startTime, err)
 	if err == nil {
 		executeAction(operationAdd, executor, ipAddress, actionObjectUser, user.Username, role, user)
 	}
@@ -2132,7 +2387,7 @@ func UpdateUserPassword(username, plainPwd, executor, ipAddress, role string) er
 	if err := provider.updateUser(&user); err != nil {
 		return err
 	}
-	webDAVUsersCache.swap(&user, plainPwd)
+	swapCachedUser(&user, plainPwd)
 	executeAction(operationUpdate, executor, ipAddress, actionObjectUser, username, role, &user)
 	return nil
 }
@@ -2142,10 +2397,19 @@ func UpdateUser(user *User, executor, ipAddress, role string) error {
 	if user.groupSettingsApplied {
 		return errors.New("cannot save a user with group settings applied")
 	}
+	if oldUser, err := provider.userExists(user.Username, ""); err == nil {
+		user.Tenant = oldUser.Tenant
+	}
+	if err := checkUserTenant(user); err != nil {
+		return err
+	}
+	snapshot := getObjectSnapshot(user)
+	startTime := time.Now()
 	err := provider.updateUser(user)
+	updateQueryMetrics("update_user", startTime, err)
 	if err == nil {
-		webDAVUsersCache.swap(user, "")
-		executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, user)
+		swapCachedUser(user, "")
+		executeUpdateAction(executor, ipAddress, actionObjectUser, user.Username, role, user, snapshot)
 	}
 	return err
 }
@@ -2157,11 +2421,15 @@ func DeleteUser(username, executor, ipAddress, role string) error {
 	if err != nil {
 		return err
 	}
+	startTime := time.Now()
 	err = provider.deleteUser(user, config.IsShared == 1)
+	updateQueryMetrics("delete_user", startTime, err)
 	if err == nil {
-		RemoveCachedWebDAVUser(user.Username)
+		removeCachedUser(user.Username)
 		delayedQuotaUpdater.resetUserQuota(user.Username)
+		vfs.RemoveQuotaScan(vfs.UserQuotaScanKey(user.Username))
 		cachedUserPasswords.Remove(username)
+		ldapEntries.remove(username)
 		executeAction(operationDelete, executor, ipAddress, actionObjectUser, user.Username, role, &user)
 	}
 	return err
@@ -2231,6 +2499,12 @@ func GetSharedSession(key string) (Session, error) {
 	return provider.getSharedSession(key)
 }
 
+// GetSharedSessions returns the sessions with the specified type and whose
+// key starts with the specified prefix. An empty prefix matches any key
+func GetSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	return provider.getSharedSessions(sessionType, keyPrefix)
+}
+
 // CleanupSharedSessions removes the shared session with the specified type and
 // before the specified time
 func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
@@ -2260,24 +2534,31 @@ func GetAPIKeys(limit, offset int, order string) ([]APIKey, error) {
 	return provider.getAPIKeys(limit, offset, order)
 }
 
-// GetAdmins returns an array of admins respecting limit and offset
-func GetAdmins(limit, offset int, order string) ([]Admin, error) {
-	return provider.getAdmins(limit, offset, order)
+// GetAdmins returns an array of admins respecting limit and offset.
+// If tenant is not empty only the admins within the tenant are returned
+func GetAdmins(limit, offset int, order, tenant string) ([]Admin, error) {
+	return provider.getAdmins(limit, offset, order, tenant)
 }
 
-// GetRoles returns an array of roles respecting limit and offset
-func GetRoles(limit, offset int, order string, minimal bool) ([]Role, error) {
-	return provider.getRoles(limit, offset, order, minimal)
+// GetRoles returns an array of roles respecting limit and offset.
+// If tenant is not empty only the roles within the tenant are returned
+func GetRoles(limit, offset int, order string, minimal bool, tenant string) ([]Role, error) {
+	return provider.getRoles(limit, offset, order, minimal, tenant)
 }
 
-// GetGroups returns an array of groups respecting limit and offset
-func GetGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return provider.getGroups(limit, offset, order, minimal)
+// GetGroups returns an array of groups respecting limit and offset.
+// If tenant is not empty only the groups within the tenant are returned
+func GetGroups(limit, offset int, order string, minimal bool, tenant string) ([]Group, error) {
+	return provider.getGroups(limit, offset, order, minimal, tenant)
 }
 
-// GetUsers returns an array of users respecting limit and offset
-func GetUsers(limit, offset int, order, role string) ([]User, error) {
-	return provider.getUsers(limit, offset, order, role)
+// GetUsers returns an array of users respecting limit and offset.
+// If tenant is not empty only the users within the tenant are returned
+func GetUsers(limit, offset int, order, role, tenant string) ([]User, error) {
+	startTime := time.Now()
+	users, err := provider.getUsers(limit, offset, order, role, tenant)
+	updateQueryMetrics("get_users", startTime, err)
+	return users, err
 }
 
 // GetUsersForQuotaCheck returns the users with the fields required for a quota check
@@ -2288,6 +2569,9 @@ func GetUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
 // AddFolder adds a new virtual folder.
 func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string) error {
 	folder.Name = config.convertName(folder.Name)
+	if err := checkFolderTenant(folder); err != nil {
+		return err
+	}
 	err := provider.addFolder(folder)
 	if err == nil {
 		executeAction(operationAdd, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
@@ -2297,9 +2581,16 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string)
 
 // UpdateFolder updates the specified virtual folder
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress, role string) error {
+	if oldFolder, err := provider.getFolderByName(folder.Name); err == nil {
+		folder.Tenant = oldFolder.Tenant
+	}
+	if err := checkFolderTenant(folder); err != nil {
+		return err
+	}
+	snapshot := getObjectSnapshot(&wrappedFolder{Folder: *folder})
 	err := provider.updateFolder(folder)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
+		executeUpdateAction(executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder}, snapshot)
 		usersInGroups, errGrp := provider.getUsersInGroups(groups)
 		if errGrp == nil {
 			users = append(users, usersInGroups...)
@@ -2311,10 +2602,10 @@ func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string
 			provider.setUpdatedAt(user)
 			u, err := provider.userExists(user, "")
 			if err == nil {
-				webDAVUsersCache.swap(&u, "")
+				swapCachedUser(&u, "")
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			} else {
-				RemoveCachedWebDAVUser(user)
+				removeCachedUser(user)
 			}
 		}
 	}
@@ -2345,9 +2636,10 @@ func DeleteFolder(folderName, executor, ipAddress, role string) error {
 			if err == nil {
 				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 			}
-			RemoveCachedWebDAVUser(user)
+			removeCachedUser(user)
 		}
 		delayedQuotaUpdater.resetFolderQuota(folderName)
+		vfs.RemoveQuotaScan(vfs.FolderQuotaScanKey(folderName))
 	}
 	return err
 }
@@ -2358,9 +2650,10 @@ func GetFolderByName(name string) (vfs.BaseVirtualFolder, error) {
 	return provider.getFolderByName(name)
 }
 
-// GetFolders returns an array of folders respecting limit and offset
-func GetFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return provider.getFolders(limit, offset, order, minimal)
+// GetFolders returns an array of folders respecting limit and offset.
+// If tenant is not empty only the folders within the tenant are returned
+func GetFolders(limit, offset int, order string, minimal bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	return provider.getFolders(limit, offset, order, minimal, tenant)
 }
 
 func dumpUsers(data *BackupData, scopes []string) error {
@@ -2462,6 +2755,17 @@ func dumpRoles(data *BackupData, scopes []string) error {
 	return nil
 }
 
+func dumpTenants(data *BackupData, scopes []string) error {
+	if len(scopes) == 0 || util.Contains(scopes, DumpScopeTenants) {
+		tenants, err := provider.dumpTenants()
+		if err != nil {
+			return err
+		}
+		data.Tenants = tenants
+	}
+	return nil
+}
+
 func dumpIPLists(data *BackupData, scopes []string) error {
 	if len(scopes) == 0 || util.Contains(scopes, DumpScopeIPLists) {
 		ipLists, err := provider.dumpIPListEntries()
@@ -2517,6 +2821,9 @@ func DumpData(scopes []string) (BackupData, error) {
 	if err := dumpRoles(&data, scopes); err != nil {
 		return data, err
 	}
+	if err := dumpTenants(&data, scopes); err != nil {
+		return data, err
+	}
 	if err := dumpIPLists(&data, scopes); err != nil {
 		return data, err
 	}
@@ -2559,6 +2866,9 @@ func GetProviderStatus() ProviderStatus {
 // Closing an uninitialized provider is not supported
 func Close() error {
 	stopScheduler()
+	clusterLeader.mu.Lock()
+	clusterLeader.resign("the data provider is closed")
+	clusterLeader.mu.Unlock()
 	return provider.close()
 }
 
@@ -2578,6 +2888,10 @@ func createProvider(basePath string) error {
 		return initializeMySQLProvider()
 	case BoltDataProviderName:
 		return initializeBoltProvider(basePath)
+	case MongoDBDataProviderName:
+		return initializeMongoDBProvider()
+	case EtcdDataProviderName:
+		return initializeEtcdProvider()
 	case MemoryDataProviderName:
 		if err := initializeMemoryProvider(basePath); err != nil {
 			msg := fmt.Sprintf("provider initialized but data loading failed: %v", err)
@@ -2653,7 +2967,10 @@ func buildUserHomeDir(user *User) {
 			return
 		}
 		switch user.FsConfig.Provider {
-		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider, sdk.HTTPFilesystemProvider:
+		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider,
+			sdk.HTTPFilesystemProvider, vfs.B2FilesystemProvider, vfs.DropboxFilesystemProvider,
+			vfs.GDriveFilesystemProvider, vfs.OneDriveFilesystemProvider, vfs.WebDAVFilesystemProvider, vfs.SMBFilesystemProvider,
+			vfs.HDFSFilesystemProvider, vfs.SwiftFilesystemProvider:
 			if tempPath != "" {
 				user.HomeDir = filepath.Join(tempPath, user.Username)
 			} else {
@@ -2817,6 +3134,20 @@ func validateUserRecoveryCodes(user *User) error {
 	return nil
 }
 
+func validateUserS3Secret(user *User) error {
+	if user.Filters.S3Secret == nil || user.Filters.S3Secret.IsEmpty() {
+		user.Filters.S3Secret = nil
+		return nil
+	}
+	if user.Filters.S3Secret.IsPlain() {
+		user.Filters.S3Secret.SetAdditionalData(user.Username)
+		if err := user.Filters.S3Secret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("s3: unable to encrypt secret access key: %v", err))
+		}
+	}
+	return nil
+}
+
 func validateUserPermissions(permsToCheck map[string][]string) (map[string][]string, error) {
 	permissions := make(map[string][]string)
 	for dir, perms := range permsToCheck {
@@ -3345,6 +3676,9 @@ func ValidateUser(user *User) error {
 	if err := validateUserRecoveryCodes(user); err != nil {
 		return util.NewI18nError(err, util.I18nErrorRecoveryCodesInvalid)
 	}
+	if err := validateUserS3Secret(user); err != nil {
+		return err
+	}
 	vfolders, err := validateAssociatedVirtualFolders(user.VirtualFolders)
 	if err != nil {
 		return err
@@ -3362,6 +3696,39 @@ func ValidateUser(user *User) error {
 	if err := validateBaseFilters(&user.Filters.BaseUserFilters); err != nil {
 		return err
 	}
+	if err := validateTwoFactorAuthExemptions(user.Filters.TwoFactorAuthExemptions); err != nil {
+		return err
+	}
+	if err := user.Filters.PortForwarding.validate(); err != nil {
+		return err
+	}
+	if err := validateProtocolBandwidthLimits(user.Filters.ProtocolBandwidthLimits); err != nil {
+		return err
+	}
+	if err := user.Filters.SSHAlgorithms.validate(); err != nil {
+		return err
+	}
+	if err := validateFTPPassiveIP(user.Filters.FTPPassiveIP); err != nil {
+		return err
+	}
+	if err := user.Filters.Trash.validate(); err != nil {
+		return err
+	}
+	rules, err := validateVersioningRules(user.Filters.FileVersioning)
+	if err != nil {
+		return err
+	}
+	user.Filters.FileVersioning = rules
+	dirQuotas, err := validateDirQuotas(user.Filters.DirQuotas)
+	if err != nil {
+		return err
+	}
+	user.Filters.DirQuotas = dirQuotas
+	thresholds, err := validateQuotaWarnThresholds(user.Filters.QuotaWarnThresholds)
+	if err != nil {
+		return err
+	}
+	user.Filters.QuotaWarnThresholds = thresholds
 	if !user.HasExternalAuth() {
 		user.Filters.ExternalAuthCacheTime = 0
 	}
@@ -3386,14 +3753,14 @@ func isPasswordOK(user *User, password string) (bool, error) {
 			return match, ErrInvalidCredentials
 		}
 		match = true
-		updatePwd = config.PasswordHashing.Algo != HashingAlgoBcrypt
+		updatePwd = isPasswordHashUpgradeNeeded(user.Password)
 	case strings.HasPrefix(user.Password, argonPwdPrefix):
 		match, err = argon2id.ComparePasswordAndHash(password, user.Password)
 		if err != nil {
 			providerLog(logger.LevelError, "error comparing password with argon hash: %v", err)
 			return match, err
 		}
-		updatePwd = config.PasswordHashing.Algo != HashingAlgoArgon2ID
+		updatePwd = isPasswordHashUpgradeNeeded(user.Password)
 	case util.IsStringPrefixInSlice(user.Password, unixPwdPrefixes):
 		match, err = compareUnixPasswordAndHash(user, password)
 		if err != nil {
@@ -3429,6 +3796,18 @@ func convertUserPassword(username, plainPwd string) {
 	}
 }
 
+func convertAdminPassword(username, plainPwd string) {
+	hashedPwd, err := hashPlainPassword(plainPwd)
+	if err == nil {
+		err = provider.updateAdminPassword(username, hashedPwd)
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to convert password for admin %s: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "password converted for admin %s", username)
+	}
+}
+
 func checkUserAndTLSCertificate(user *User, protocol string, tlsCert *x509.Certificate) (User, error) {
 	err := user.LoadAndApplyGroupSettings()
 	if err != nil {
@@ -3474,7 +3853,7 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 		user.setAnonymousSettings()
 		return *user, nil
 	}
-	password, err = checkUserPasscode(user, password, protocol)
+	password, err = checkUserPasscode(user, password, ip, protocol)
 	if err != nil {
 		return *user, ErrInvalidCredentials
 	}
@@ -3513,11 +3892,11 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 	return *user, err
 }
 
-func checkUserPasscode(user *User, password, protocol string) (string, error) {
+func checkUserPasscode(user *User, password, ip, protocol string) (string, error) {
 	if user.Filters.TOTPConfig.Enabled {
 		switch protocol {
 		case protocolFTP:
-			if util.Contains(user.Filters.TOTPConfig.Protocols, protocol) {
+			if user.IsSecondFactorRequired(protocol, ip) {
 				// the TOTP passcode has six digits
 				pwdLen := len(password)
 				if pwdLen < 7 {
@@ -3723,7 +4102,7 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		return 0, err
 	}
-	hasSecondFactor := user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH)
+	hasSecondFactor := user.IsSecondFactorRequired(protocolSSH, ip)
 	if !isPartialAuth || !hasSecondFactor {
 		answers, err := client("", "", []string{"Password: "}, []bool{false})
 		if err != nil {
@@ -3737,11 +4116,11 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 			return 0, err
 		}
 	}
-	return checkKeyboardInteractiveSecondFactor(user, client, protocol)
+	return checkKeyboardInteractiveSecondFactor(user, client, ip, protocol)
 }
 
-func checkKeyboardInteractiveSecondFactor(user *User, client ssh.KeyboardInteractiveChallenge, protocol string) (int, error) {
-	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH) {
+func checkKeyboardInteractiveSecondFactor(user *User, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+	if !user.IsSecondFactorRequired(protocolSSH, ip) {
 		return 1, nil
 	}
 	err := user.Filters.TOTPConfig.Secret.TryDecrypt()
@@ -3865,7 +4244,13 @@ func getKeyboardInteractiveAnswers(client ssh.KeyboardInteractiveChallenge, resp
 	}
 	if len(answers) == 1 && response.CheckPwd > 0 {
 		if response.CheckPwd == 2 {
-			if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH) {
+			if !user.IsSecondFactorRequired(protocolSSH, ip) {
+				if user.IsSecondFactorExempted(protocolSSH, ip) {
+					providerLog(logger.LevelDebug, "keyboard interactive auth: TOTP passcode check skipped for user %q, IP %q is exempted",
+						user.Username, ip)
+					answers[0] = "OK"
+					return answers, nil
+				}
 				providerLog(logger.LevelInfo, "keyboard interactive auth error: unable to check TOTP passcode, TOTP is not enabled for user %q",
 					user.Username)
 				return answers, errors.New("TOTP not enabled for SSH protocol")
@@ -3990,7 +4375,7 @@ func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardI
 		if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
 			authResult, err = executeKeyboardInteractivePlugin(user, client, ip, protocol)
 			if authResult == 1 && err == nil {
-				authResult, err = checkKeyboardInteractiveSecondFactor(user, client, protocol)
+				authResult, err = checkKeyboardInteractiveSecondFactor(user, client, ip, protocol)
 			}
 		} else if authHook != "" {
 			if strings.HasPrefix(authHook, "http") {
@@ -4172,6 +4557,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 	userCreatedAt := u.CreatedAt
 	totpConfig := u.Filters.TOTPConfig
 	recoveryCodes := u.Filters.RecoveryCodes
+	s3Secret := u.Filters.S3Secret
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("invalid pre-login hook response %q, error: %v", util.BytesToString(out), err)
@@ -4191,12 +4577,13 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 		err = provider.addUser(&u)
 	} else {
 		u.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and S3 credentials
 		u.Filters.TOTPConfig = totpConfig
 		u.Filters.RecoveryCodes = recoveryCodes
+		u.Filters.S3Secret = s3Secret
 		err = provider.updateUser(&u)
 		if err == nil {
-			webDAVUsersCache.swap(&u, "")
+			swapCachedUser(&u, "")
 		}
 	}
 	if err != nil {
@@ -4211,6 +4598,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	addAuthAuditLogEntry(actionObjectUser, user.Username, loginMethod, ip, protocol, user.Role, err)
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -4382,7 +4770,7 @@ func checkPasswordAfterEmptyExtAuthResponse(user *User, plainPwd, protocol strin
 	user.Password = hashedPwd
 	cachedUserPasswords.Add(user.Username, plainPwd, user.Password)
 	if protocol != protocolWebDAV {
-		webDAVUsersCache.swap(user, plainPwd)
+		swapCachedUser(user, plainPwd)
 	}
 	providerLog(logger.LevelDebug, "updated password for user %q after empty external auth response", user.Username)
 	return nil
@@ -4451,13 +4839,14 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.FirstUpload = u.FirstUpload
 		user.CreatedAt = u.CreatedAt
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and S3 credentials
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.S3Secret = u.Filters.S3Secret
 		user, err = updateUserAfterExternalAuth(&user)
 		if err == nil {
 			if protocol != protocolWebDAV {
-				webDAVUsersCache.swap(&user, password)
+				swapCachedUser(&user, password)
 			}
 			cachedUserPasswords.Add(user.Username, password, user.Password)
 		}
@@ -4523,13 +4912,14 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		user.LastPasswordChange = u.LastPasswordChange
 		user.FirstDownload = u.FirstDownload
 		user.FirstUpload = u.FirstUpload
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes and S3 credentials
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.S3Secret = u.Filters.S3Secret
 		user, err = updateUserAfterExternalAuth(&user)
 		if err == nil {
 			if protocol != protocolWebDAV {
-				webDAVUsersCache.swap(&user, password)
+				swapCachedUser(&user, password)
 			}
 			cachedUserPasswords.Add(user.Username, password, user.Password)
 		}
@@ -4594,6 +4984,14 @@ func isLastActivityRecent(lastActivity int64, minDelay time.Duration) bool {
 }
 
 func isExternalAuthConfigured(loginMethod string) bool {
+	if config.LDAPAuth.isReadThroughEnabled() {
+		return true
+	}
+	if config.LDAPAuth.isEnabled() {
+		if loginMethod == LoginMethodPassword || loginMethod == LoginMethodTLSCertificateAndPwd {
+			return true
+		}
+	}
 	if config.ExternalAuthHook != "" {
 		if config.ExternalAuthScope == 0 {
 			return true
@@ -4640,3 +5038,12 @@ func checkReservedUsernames(username string) error {
 func providerLog(level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, logSender, "", format, v...)
 }
+
+// updateQueryMetrics records the duration of a data provider query started at startTime.
+// Not found errors are expected results and so they are not reported as failures
+func updateQueryMetrics(operation string, startTime time.Time, err error) {
+	if errors.Is(err, util.ErrNotFound) {
+		err = nil
+	}
+	metric.ProviderQueryCompleted(operation, time.Since(startTime), err)
+}
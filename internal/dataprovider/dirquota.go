@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxDirQuotasPerUser = 100
+)
+
+// DirQuota defines the quota limits for a directory inside the user's
+// filesystem. The used quota is not stored in the data provider: each
+// SFTPGo instance computes it by scanning the directory the first time it
+// is needed and then updates it after each upload, rename and delete.
+// The limits are therefore enforced per node and they are approximate,
+// changes made by other nodes or outside SFTPGo are only detected after
+// a quota scan or when the cached usage expires
+type DirQuota struct {
+	// Virtual path, the quota applies to this path and its sub directories
+	Path string `json:"path"`
+	// Maximum size allowed as bytes. 0 means unlimited
+	QuotaSize int64 `json:"quota_size,omitempty"`
+	// Maximum number of files allowed. 0 means unlimited
+	QuotaFiles int `json:"quota_files,omitempty"`
+}
+
+// HasNoQuotaRestrictions returns true if no quota restrictions need to be applied
+func (q *DirQuota) HasNoQuotaRestrictions(checkFiles bool) bool {
+	if q.QuotaSize == 0 && (!checkFiles || q.QuotaFiles == 0) {
+		return true
+	}
+	return false
+}
+
+// IsPathIncluded returns true if the specified directory is the quota path
+// or one of its sub directories
+func (q *DirQuota) IsPathIncluded(dirPath string) bool {
+	return dirPath == q.Path || strings.HasPrefix(dirPath, q.Path+"/")
+}
+
+func (q *DirQuota) validate() error {
+	if q.Path == "" {
+		return util.NewValidationError("directory quota: path is mandatory")
+	}
+	q.Path = util.CleanPath(q.Path)
+	if q.Path == "/" {
+		return util.NewValidationError("directory quota: the root directory is not allowed, use the user quota")
+	}
+	if q.QuotaSize < 0 || q.QuotaFiles < 0 {
+		return util.NewValidationError(fmt.Sprintf("directory quota %q: negative limits are not allowed", q.Path))
+	}
+	if q.QuotaSize == 0 && q.QuotaFiles == 0 {
+		return util.NewValidationError(fmt.Sprintf("directory quota %q: quota size or quota files is required", q.Path))
+	}
+	if q.Path == GetVersionsPath() || strings.HasPrefix(q.Path, GetVersionsPath()+"/") ||
+		q.Path == GetTrashPath() || strings.HasPrefix(q.Path, GetTrashPath()+"/") {
+		return util.NewValidationError(fmt.Sprintf("directory quota %q: path not allowed", q.Path))
+	}
+	return nil
+}
+
+func validateDirQuotas(quotas []DirQuota) ([]DirQuota, error) {
+	if len(quotas) > maxDirQuotasPerUser {
+		return nil, util.NewValidationError(fmt.Sprintf("too many directory quotas, max allowed: %d",
+			maxDirQuotasPerUser))
+	}
+	var result []DirQuota
+	paths := make(map[string]bool)
+	for _, q := range quotas {
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+		if paths[q.Path] {
+			return nil, util.NewValidationError(fmt.Sprintf("duplicate directory quota for path %q", q.Path))
+		}
+		paths[q.Path] = true
+		result = append(result, q)
+	}
+	return result, nil
+}
+
+// GetDirQuota returns the directory quota that applies to the specified file.
+// The quota with the longest path matching the file directory is returned
+func (u *User) GetDirQuota(virtualPath string) (DirQuota, bool) {
+	var result DirQuota
+	found := false
+	dirPath := path.Dir(virtualPath)
+	for _, q := range u.Filters.DirQuotas {
+		if !q.IsPathIncluded(dirPath) {
+			continue
+		}
+		if !found || len(q.Path) > len(result.Path) {
+			result = q
+			found = true
+		}
+	}
+	return result, found
+}
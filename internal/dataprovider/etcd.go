@@ -0,0 +1,3784 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noetcd
+// +build !noetcd
+
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	etcdDatabaseVersion = 34
+	etcdDefaultPort     = 2379
+)
+
+const (
+	etcdCollUsers           = "users"
+	etcdCollGroups          = "groups"
+	etcdCollFolders         = "folders"
+	etcdCollAdmins          = "admins"
+	etcdCollAPIKeys         = "api_keys"
+	etcdCollShares          = "shares"
+	etcdCollActions         = "events_actions"
+	etcdCollRules           = "events_rules"
+	etcdCollRoles           = "roles"
+	etcdCollTenants         = "tenants"
+	etcdCollAuditLogs       = "audit_logs"
+	etcdCollIPLists         = "ip_lists"
+	etcdCollConfigs         = "configs"
+	etcdCollSchemaVersion   = "schema_version"
+	etcdCollSequences       = "sequences"
+	etcdCollActiveTransfers = "active_transfers"
+	etcdCollSharedSessions  = "shared_sessions"
+	etcdCollTasks           = "tasks"
+	etcdCollNodes           = "nodes"
+)
+
+var (
+	etcdTenantCollections = []string{etcdCollUsers, etcdCollAdmins, etcdCollGroups, etcdCollFolders,
+		etcdCollRoles, etcdCollRules}
+	errEtcdStopIteration = errors.New("stop iteration")
+)
+
+// EtcdProvider defines the auth provider for etcd key/value store.
+// Objects are serialized as JSON and stored below a common key prefix,
+// multi-key updates are executed using software transactional memory.
+// The provider watches its key prefix and invalidates the cached users,
+// IP list entries and event rules as soon as another node changes them,
+// for this reason deletes are never deferred using soft deletes
+type EtcdProvider struct {
+	client      *clientv3.Client
+	keyPrefix   string
+	cancelWatch context.CancelFunc
+}
+
+// etcdTx allows to read objects from a consistent snapshot or, if stm is
+// not nil, to read and write objects inside a serializable transaction
+type etcdTx struct {
+	ctx    context.Context
+	client *clientv3.Client
+	stm    concurrency.STM
+	prefix string
+	rev    int64
+}
+
+// etcdBucket exposes the same primitives used by the bolt provider so the
+// relations between objects are handled the same way
+type etcdBucket struct {
+	tx     *etcdTx
+	name   string
+	prefix string
+}
+
+type etcdActiveTransfer struct {
+	ID            int64  `json:"transfer_id"`
+	Type          int    `json:"transfer_type"`
+	ConnID        string `json:"connection_id"`
+	Username      string `json:"username"`
+	FolderName    string `json:"folder_name"`
+	IP            string `json:"ip"`
+	TruncatedSize int64  `json:"truncated_size"`
+	CurrentULSize int64  `json:"current_ul_size"`
+	CurrentDLSize int64  `json:"current_dl_size"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
+}
+
+type etcdSession struct {
+	Data      []byte      `json:"data"`
+	Type      SessionType `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+type etcdNode struct {
+	Data      NodeData `json:"data"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+type etcdTask struct {
+	UpdatedAt int64 `json:"updated_at"`
+	Version   int64 `json:"version"`
+}
+
+func init() {
+	version.AddFeature("+etcd")
+}
+
+func initializeEtcdProvider() error {
+	clientConfig, err := getEtcdClientConfig()
+	if err != nil {
+		providerLog(logger.LevelError, "error creating etcd client config: %v", err)
+		return err
+	}
+	keyPrefix := strings.Trim(config.Name, "/")
+	if keyPrefix == "" {
+		return errors.New("etcd: the database name, used as key prefix, is mandatory")
+	}
+	keyPrefix = "/" + keyPrefix + "/" + config.SQLTablesPrefix
+
+	c, err := clientv3.New(clientConfig)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+		_, err = c.Get(ctx, keyPrefix+etcdCollSchemaVersion)
+		cancel()
+		if err != nil {
+			c.Close()
+		}
+	}
+	if err != nil {
+		providerLog(logger.LevelError, "error creating etcd database handler, endpoints: %+v, error: %v",
+			clientConfig.Endpoints, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "etcd database handle created, endpoints: %+v, key prefix: %q",
+		clientConfig.Endpoints, keyPrefix)
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	p := &EtcdProvider{
+		client:      c,
+		keyPrefix:   keyPrefix,
+		cancelWatch: cancelWatch,
+	}
+	go p.watch(watchCtx)
+	provider = p
+	return nil
+}
+
+func getEtcdClientConfig() (clientv3.Config, error) {
+	clientConfig := clientv3.Config{
+		DialTimeout:          10 * time.Second,
+		DialKeepAliveTime:    30 * time.Second,
+		DialKeepAliveTimeout: 10 * time.Second,
+		Username:             config.Username,
+		Password:             config.Password,
+	}
+	scheme := "http"
+	if config.SSLMode > 0 {
+		tlsConfig, err := getEtcdTLSConfig()
+		if err != nil {
+			return clientConfig, err
+		}
+		clientConfig.TLS = tlsConfig
+		scheme = "https"
+	}
+	if config.ConnectionString != "" {
+		for _, endpoint := range strings.Split(config.ConnectionString, ",") {
+			endpoint = strings.TrimSpace(endpoint)
+			if endpoint != "" {
+				clientConfig.Endpoints = append(clientConfig.Endpoints, endpoint)
+			}
+		}
+	} else {
+		port := config.Port
+		if port <= 0 {
+			port = etcdDefaultPort
+		}
+		for _, host := range strings.Split(config.Host, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			clientConfig.Endpoints = append(clientConfig.Endpoints, fmt.Sprintf("%s://%s", scheme, host))
+		}
+	}
+	if len(clientConfig.Endpoints) == 0 {
+		return clientConfig, errors.New("etcd: no endpoint configured")
+	}
+	return clientConfig, nil
+}
+
+func getEtcdTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	providerLog(logger.LevelInfo, "using custom TLS config, root cert %q, client cert %q, client key %q, skip verify? %v",
+		config.RootCert, config.ClientCert, config.ClientKey, tlsConfig.InsecureSkipVerify)
+	return tlsConfig, nil
+}
+
+func (p *EtcdProvider) checkAvailability() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	_, err := p.client.Get(ctx, p.keyPrefix+etcdCollSchemaVersion)
+	return err
+}
+
+func (p *EtcdProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
+	var user User
+	if tlsCert == nil {
+		return user, errors.New("TLS certificate cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+}
+
+func (p *EtcdProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (p *EtcdProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
+	admin, err := p.adminExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating admin %q: %v", username, err)
+		return admin, err
+	}
+	err = admin.checkUserAndPass(password, ip)
+	return admin, err
+}
+
+func (p *EtcdProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+}
+
+func (p *EtcdProvider) updateAPIKeyLastUse(keyID string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		var apiKey APIKey
+		found, err := bucket.getObject(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to update last use", keyID))
+		}
+		apiKey.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err = bucket.put(keyID, &apiKey); err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for key %q: %v", keyID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "last use updated for key %q", keyID)
+		return nil
+	})
+}
+
+func (p *EtcdProvider) setUpdatedAt(username string) {
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update updated at", username))
+		}
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "updated at set for user %q", username)
+		setLastUserUpdate()
+	} else {
+		providerLog(logger.LevelWarn, "error setting updated_at for user %q: %v", username, err)
+	}
+}
+
+func (p *EtcdProvider) updateLastLogin(username string) error {
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update last login", username))
+		}
+		user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last login for user %q: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "last login updated for user %q", username)
+	}
+	return err
+}
+
+func (p *EtcdProvider) updateAdminPassword(username, password string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		var admin Admin
+		found, err := bucket.getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist", username))
+		}
+		admin.Password = password
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &admin)
+	})
+}
+
+func (p *EtcdProvider) updateAdminLastLogin(username string) error {
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		var admin Admin
+		found, err := bucket.getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist, unable to update last login", username))
+		}
+		admin.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &admin)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last login for admin %q: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "last login updated for admin %q", username)
+	}
+	return err
+}
+
+func (p *EtcdProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update transfer quota",
+				username))
+		}
+		if !reset {
+			user.UsedUploadDataTransfer += uploadSize
+			user.UsedDownloadDataTransfer += downloadSize
+		} else {
+			user.UsedUploadDataTransfer = uploadSize
+			user.UsedDownloadDataTransfer = downloadSize
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		err = bucket.put(username, &user)
+		providerLog(logger.LevelDebug, "transfer quota updated for user %q, ul increment: %v dl increment: %v is reset? %v",
+			username, uploadSize, downloadSize, reset)
+		return err
+	})
+}
+
+func (p *EtcdProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update quota", username))
+		}
+		if reset {
+			user.UsedQuotaSize = sizeAdd
+			user.UsedQuotaFiles = filesAdd
+		} else {
+			user.UsedQuotaSize += sizeAdd
+			user.UsedQuotaFiles += filesAdd
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		err = bucket.put(username, &user)
+		providerLog(logger.LevelDebug, "quota updated for user %q, files increment: %v size increment: %v is reset? %v",
+			username, filesAdd, sizeAdd, reset)
+		return err
+	})
+}
+
+func (p *EtcdProvider) getUsedQuota(username string) (int, int64, int64, int64, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, err
+}
+
+func (p *EtcdProvider) adminExists(username string) (Admin, error) {
+	var admin Admin
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollAdmins).getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", username))
+		}
+		return nil
+	})
+	return admin, err
+}
+
+func (p *EtcdProvider) addAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		exists, err := bucket.exists(admin.Username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: admin %q already exists", ErrDuplicatedKey, admin.Username),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		admin.ID = id
+		admin.LastLogin = 0
+		admin.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		sort.Slice(admin.Groups, func(i, j int) bool {
+			return admin.Groups[i].Name < admin.Groups[j].Name
+		})
+		groupsBucket := tx.bucket(etcdCollGroups)
+		for idx := range admin.Groups {
+			if err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, tx.bucket(etcdCollRoles)); err != nil {
+			return err
+		}
+		return bucket.put(admin.Username, admin)
+	})
+}
+
+func (p *EtcdProvider) updateAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		groupsBucket := tx.bucket(etcdCollGroups)
+		rolesBucket := tx.bucket(etcdCollRoles)
+		var oldAdmin Admin
+		found, err := bucket.getObject(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, rolesBucket); err != nil {
+			return err
+		}
+		sort.Slice(admin.Groups, func(i, j int) bool {
+			return admin.Groups[i].Name < admin.Groups[j].Name
+		})
+		for idx := range admin.Groups {
+			if err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		admin.ID = oldAdmin.ID
+		admin.CreatedAt = oldAdmin.CreatedAt
+		admin.LastLogin = oldAdmin.LastLogin
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(admin.Username, admin)
+	})
+}
+
+func (p *EtcdProvider) deleteAdmin(admin Admin) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		var oldAdmin Admin
+		found, err := bucket.getObject(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		groupsBucket := tx.bucket(etcdCollGroups)
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, tx.bucket(etcdCollRoles)); err != nil {
+			return err
+		}
+		if err = tx.bucket(etcdCollAPIKeys).deleteMatching("admin", admin.Username); err != nil {
+			return err
+		}
+		return bucket.delete(admin.Username)
+	})
+}
+
+func (p *EtcdProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
+	admins := make([]Admin, 0, limit)
+	if limit <= 0 {
+		return admins, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		return bucket.list(order, offset, limit, tenant, func(data []byte) error {
+			var admin Admin
+			if err := json.Unmarshal(data, &admin); err != nil {
+				return err
+			}
+			admin.HideConfidentialData()
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+	return admins, err
+}
+
+func (p *EtcdProvider) dumpAdmins() ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAdmins)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var admin Admin
+			if err := json.Unmarshal(data, &admin); err != nil {
+				return err
+			}
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+	return admins, err
+}
+
+func (p *EtcdProvider) userExists(username, role string) (User, error) {
+	var user User
+	err := p.view(func(tx *etcdTx) error {
+		data, err := tx.bucket(etcdCollUsers).get(username)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		user, err = p.joinUserAndFolders(data, tx.bucket(etcdCollFolders))
+		if err != nil {
+			return err
+		}
+		if !user.hasRole(role) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (p *EtcdProvider) addUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		exists, err := bucket.exists(user.Username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: username %v already exists", ErrDuplicatedKey, user.Username),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		user.ID = id
+		user.LastQuotaUpdate = 0
+		user.UsedQuotaSize = 0
+		user.UsedQuotaFiles = 0
+		user.UsedUploadDataTransfer = 0
+		user.UsedDownloadDataTransfer = 0
+		user.LastLogin = 0
+		user.FirstDownload = 0
+		user.FirstUpload = 0
+		user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err := p.addUserToRole(user.Username, user.Role, tx.bucket(etcdCollRoles)); err != nil {
+			return err
+		}
+		foldersBucket := tx.bucket(etcdCollFolders)
+		sort.Slice(user.VirtualFolders, func(i, j int) bool {
+			return user.VirtualFolders[i].Name < user.VirtualFolders[j].Name
+		})
+		for idx := range user.VirtualFolders {
+			err = p.addRelationToFolderMapping(user.VirtualFolders[idx].Name, user, nil, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		groupsBucket := tx.bucket(etcdCollGroups)
+		sort.Slice(user.Groups, func(i, j int) bool {
+			return user.Groups[i].Name < user.Groups[j].Name
+		})
+		for idx := range user.Groups {
+			if err = p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.put(user.Username, user)
+	})
+}
+
+func (p *EtcdProvider) updateUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	err = p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var oldUser User
+		found, err := bucket.getObject(user.Username, &oldUser)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		if err = p.updateUserRelations(tx, user, oldUser); err != nil {
+			return err
+		}
+		user.ID = oldUser.ID
+		user.LastQuotaUpdate = oldUser.LastQuotaUpdate
+		user.UsedQuotaSize = oldUser.UsedQuotaSize
+		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
+		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+		user.LastLogin = oldUser.LastLogin
+		user.FirstDownload = oldUser.FirstDownload
+		user.FirstUpload = oldUser.FirstUpload
+		user.CreatedAt = oldUser.CreatedAt
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(user.Username, user)
+	})
+	if err == nil {
+		setLastUserUpdate()
+	}
+	return err
+}
+
+// deleteUser always removes the user, the other nodes are notified using watches
+func (p *EtcdProvider) deleteUser(user User, _ bool) error {
+	return p.update(func(tx *etcdTx) error {
+		var oldUser User
+		found, err := tx.bucket(etcdCollUsers).getObject(user.Username, &oldUser)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		return p.deleteUserInternal(tx, oldUser)
+	})
+}
+
+func (p *EtcdProvider) updateUserPassword(username, password string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		user.Password = password
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *EtcdProvider) dumpUsers() ([]User, error) {
+	users := make([]User, 0, 100)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			user, err := p.joinUserAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
+	users := make([]User, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		groupsBucket := tx.bucket(etcdCollGroups)
+		return bucket.listFiltered(OrderASC, 0, 0, etcdMatchUpdatedAfter(after), func(data []byte) error {
+			user, err := p.joinUserAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			if len(user.Groups) > 0 {
+				groupMapping := make(map[string]Group)
+				for idx := range user.Groups {
+					group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+					if err != nil {
+						continue
+					}
+					groupMapping[group.Name] = group
+				}
+				user.applyGroupSettings(groupMapping)
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
+	users := make([]User, 0, 10)
+	if len(toFetch) == 0 {
+		return users, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		groupsBucket := tx.bucket(etcdCollGroups)
+		for username, fetchFolders := range toFetch {
+			var user User
+			found, err := bucket.getObject(username, &user)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			if fetchFolders && len(user.VirtualFolders) > 0 {
+				user.VirtualFolders = p.getUserOrGroupFolders(user.VirtualFolders, foldersBucket)
+			}
+			if len(user.Groups) > 0 {
+				groupMapping := make(map[string]Group)
+				for idx := range user.Groups {
+					group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+					if err != nil {
+						continue
+					}
+					groupMapping[group.Name] = group
+				}
+				user.applyGroupSettings(groupMapping)
+			}
+			user.SetEmptySecretsIfNil()
+			user.PrepareForRendering()
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		filter := etcdFilter{Tenant: tenant, Role: role}
+		return bucket.listFiltered(order, offset, limit, filter.match, func(data []byte) error {
+			user, err := p.joinUserAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			user.PrepareForRendering()
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *EtcdProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var folder vfs.BaseVirtualFolder
+			if err := json.Unmarshal(data, &folder); err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *EtcdProvider) getFolders(limit, offset int, order string, _ bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	if limit <= 0 {
+		return folders, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		return bucket.list(order, offset, limit, tenant, func(data []byte) error {
+			var folder vfs.BaseVirtualFolder
+			if err := json.Unmarshal(data, &folder); err != nil {
+				return err
+			}
+			folder.PrepareForRendering()
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *EtcdProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	err := p.view(func(tx *etcdTx) error {
+		var err error
+		folder, err = p.folderExistsInternal(name, tx.bucket(etcdCollFolders))
+		return err
+	})
+	return folder, err
+}
+
+func (p *EtcdProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		exists, err := bucket.exists(folder.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: folder %q already exists", ErrDuplicatedKey, folder.Name),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		folder.ID = id
+		folder.Users = nil
+		folder.Groups = nil
+		return bucket.put(folder.Name, folder)
+	})
+}
+
+func (p *EtcdProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		var oldFolder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(folder.Name, &oldFolder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+		}
+		folder.ID = oldFolder.ID
+		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+		folder.Users = oldFolder.Users
+		folder.Groups = oldFolder.Groups
+		return bucket.put(folder.Name, folder)
+	})
+}
+
+func (p *EtcdProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		var folder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(baseFolder.Name, &folder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", baseFolder.Name))
+		}
+		usersBucket := tx.bucket(etcdCollUsers)
+		for _, username := range folder.Users {
+			var user User
+			found, err := usersBucket.getObject(username, &user)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			user.VirtualFolders = etcdRemoveVirtualFolder(user.VirtualFolders, folder.Name)
+			if err = usersBucket.put(user.Username, &user); err != nil {
+				return err
+			}
+		}
+		groupsBucket := tx.bucket(etcdCollGroups)
+		for _, groupname := range folder.Groups {
+			var group Group
+			found, err := groupsBucket.getObject(groupname, &group)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			group.VirtualFolders = etcdRemoveVirtualFolder(group.VirtualFolders, folder.Name)
+			if err = groupsBucket.put(group.Name, &group); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(folder.Name)
+	})
+}
+
+func (p *EtcdProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollFolders)
+		var folder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(name, &folder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", name))
+		}
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(folder.Name, &folder)
+	})
+}
+
+func (p *EtcdProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.getFolderByName(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for folder %q error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p *EtcdProvider) getGroups(limit, offset int, order string, _ bool, tenant string) ([]Group, error) {
+	groups := make([]Group, 0, limit)
+	if limit <= 0 {
+		return groups, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		return bucket.list(order, offset, limit, tenant, func(data []byte) error {
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			group.PrepareForRendering()
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) getGroupsWithNames(names []string) ([]Group, error) {
+	var groups []Group
+	if len(names) == 0 {
+		return groups, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		for _, name := range names {
+			data, err := bucket.get(name)
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				continue
+			}
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+		return nil
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) getUsersInGroups(names []string) ([]string, error) {
+	var usernames []string
+	if len(names) == 0 {
+		return usernames, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		for _, name := range names {
+			var group Group
+			found, err := bucket.getObject(name, &group)
+			if err != nil {
+				return err
+			}
+			if found {
+				usernames = append(usernames, group.Users...)
+			}
+		}
+		return nil
+	})
+	return usernames, err
+}
+
+func (p *EtcdProvider) groupExists(name string) (Group, error) {
+	var group Group
+	err := p.view(func(tx *etcdTx) error {
+		data, err := tx.bucket(etcdCollGroups).get(name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+		}
+		group, err = p.joinGroupAndFolders(data, tx.bucket(etcdCollFolders))
+		return err
+	})
+	return group, err
+}
+
+func (p *EtcdProvider) addGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		exists, err := bucket.exists(group.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: group %q already exists", ErrDuplicatedKey, group.Name),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		group.ID = id
+		group.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.Users = nil
+		group.Admins = nil
+		foldersBucket := tx.bucket(etcdCollFolders)
+		sort.Slice(group.VirtualFolders, func(i, j int) bool {
+			return group.VirtualFolders[i].Name < group.VirtualFolders[j].Name
+		})
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(group.VirtualFolders[idx].Name, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.put(group.Name, group)
+	})
+}
+
+func (p *EtcdProvider) updateGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		var oldGroup Group
+		found, err := bucket.getObject(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		sort.Slice(group.VirtualFolders, func(i, j int) bool {
+			return group.VirtualFolders[i].Name < group.VirtualFolders[j].Name
+		})
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(group.VirtualFolders[idx].Name, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		group.ID = oldGroup.ID
+		group.CreatedAt = oldGroup.CreatedAt
+		group.Users = oldGroup.Users
+		group.Admins = oldGroup.Admins
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(group.Name, group)
+	})
+}
+
+func (p *EtcdProvider) deleteGroup(group Group) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		var oldGroup Group
+		found, err := bucket.getObject(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		if len(oldGroup.Users) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", oldGroup.Name))
+		}
+		foldersBucket := tx.bucket(etcdCollFolders)
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		adminsBucket := tx.bucket(etcdCollAdmins)
+		for idx := range oldGroup.Admins {
+			if err = p.removeGroupFromAdminMapping(oldGroup.Name, oldGroup.Admins[idx], adminsBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(group.Name)
+	})
+}
+
+func (p *EtcdProvider) dumpGroups() ([]Group, error) {
+	groups := make([]Group, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollGroups)
+		foldersBucket := tx.bucket(etcdCollFolders)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *EtcdProvider) apiKeyExists(keyID string) (APIKey, error) {
+	var apiKey APIKey
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollAPIKeys).getObject(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", keyID))
+		}
+		return nil
+	})
+	return apiKey, err
+}
+
+func (p *EtcdProvider) addAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		exists, err := bucket.exists(apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("API key %v already exists", apiKey.KeyID)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		apiKey.ID = id
+		apiKey.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.LastUseAt = 0
+		if err = p.checkAPIKeyRelations(tx, apiKey); err != nil {
+			return err
+		}
+		return bucket.put(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *EtcdProvider) updateAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		var oldAPIKey APIKey
+		found, err := bucket.getObject(apiKey.KeyID, &oldAPIKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		apiKey.ID = oldAPIKey.ID
+		apiKey.KeyID = oldAPIKey.KeyID
+		apiKey.Key = oldAPIKey.Key
+		apiKey.CreatedAt = oldAPIKey.CreatedAt
+		apiKey.LastUseAt = oldAPIKey.LastUseAt
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err = p.checkAPIKeyRelations(tx, apiKey); err != nil {
+			return err
+		}
+		return bucket.put(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *EtcdProvider) deleteAPIKey(apiKey APIKey) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		exists, err := bucket.exists(apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		return bucket.delete(apiKey.KeyID)
+	})
+}
+
+func (p *EtcdProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+	if limit <= 0 {
+		return apiKeys, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		return bucket.list(order, offset, limit, "", func(data []byte) error {
+			var apiKey APIKey
+			if err := json.Unmarshal(data, &apiKey); err != nil {
+				return err
+			}
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+	return apiKeys, err
+}
+
+func (p *EtcdProvider) dumpAPIKeys() ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAPIKeys)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var apiKey APIKey
+			if err := json.Unmarshal(data, &apiKey); err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+	return apiKeys, err
+}
+
+func (p *EtcdProvider) shareExists(shareID, username string) (Share, error) {
+	var share Share
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollShares).getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found || (username != "" && share.Username != username) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", shareID))
+		}
+		return nil
+	})
+	return share, err
+}
+
+func (p *EtcdProvider) addShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		exists, err := bucket.exists(share.ShareID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("share %q already exists", share.ShareID)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		share.ID = id
+		if !share.IsRestore {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			share.UpdatedAt = share.CreatedAt
+			share.LastUseAt = 0
+			share.UsedTokens = 0
+			share.Stats = ShareStats{}
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		exists, err = tx.bucket(etcdCollUsers).exists(share.Username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		return bucket.put(share.ShareID, share)
+	})
+}
+
+func (p *EtcdProvider) updateShare(share *Share) error {
+	if err := share.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		var oldObject Share
+		found, err := bucket.getObject(share.ShareID, &oldObject)
+		if err != nil {
+			return err
+		}
+		if !found || oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		share.ID = oldObject.ID
+		share.ShareID = oldObject.ShareID
+		if !share.IsRestore {
+			share.UsedTokens = oldObject.UsedTokens
+			share.CreatedAt = oldObject.CreatedAt
+			share.LastUseAt = oldObject.LastUseAt
+			share.Stats = oldObject.Stats
+			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		exists, err := tx.bucket(etcdCollUsers).exists(share.Username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		return bucket.put(share.ShareID, share)
+	})
+}
+
+func (p *EtcdProvider) deleteShare(share Share) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		var oldObject Share
+		found, err := bucket.getObject(share.ShareID, &oldObject)
+		if err != nil {
+			return err
+		}
+		if !found || oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		return bucket.delete(share.ShareID)
+	})
+}
+
+func (p *EtcdProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+	if limit <= 0 {
+		return shares, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		filter := etcdFilter{Username: username}
+		return bucket.listFiltered(order, offset, limit, filter.match, func(data []byte) error {
+			var share Share
+			if err := json.Unmarshal(data, &share); err != nil {
+				return err
+			}
+			share.HideConfidentialData()
+			shares = append(shares, share)
+			return nil
+		})
+	})
+	return shares, err
+}
+
+func (p *EtcdProvider) dumpShares() ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var share Share
+			if err := json.Unmarshal(data, &share); err != nil {
+				return err
+			}
+			shares = append(shares, share)
+			return nil
+		})
+	})
+	return shares, err
+}
+
+func (p *EtcdProvider) updateShareLastUse(shareID string, numTokens int) error {
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		var share Share
+		found, err := bucket.getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update last use", shareID))
+		}
+		share.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		share.UsedTokens += numTokens
+		return bucket.put(shareID, &share)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last use for share %q: %v", shareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "last use updated for share %q", shareID)
+	return nil
+}
+
+func (p *EtcdProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollShares)
+		var share Share
+		found, err := bucket.getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update stats", shareID))
+		}
+		share.Stats.update(ipAddress, filePaths)
+		return bucket.put(shareID, &share)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating stats for share %q: %v", shareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "stats updated for share %q", shareID)
+	return nil
+}
+
+func (p *EtcdProvider) getDefenderHosts(_ int64, _ int) ([]DefenderEntry, error) {
+	return nil, ErrNotImplemented
+}
+
+func (p *EtcdProvider) getDefenderHostByIP(_ string, _ int64) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) isDefenderHostBanned(_ string) (DefenderEntry, error) {
+	return DefenderEntry{}, ErrNotImplemented
+}
+
+func (p *EtcdProvider) updateDefenderBanTime(_ string, _ int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) deleteDefenderHost(_ string) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addDefenderEvent(_ string, _ int) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) setDefenderBanTime(_ string, _ int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) cleanupDefender(_ int64) error {
+	return ErrNotImplemented
+}
+
+func (p *EtcdProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollActiveTransfers).put(etcdTransferKey(transfer.ID, transfer.ConnID), &etcdActiveTransfer{
+			ID:            transfer.ID,
+			Type:          transfer.Type,
+			ConnID:        transfer.ConnID,
+			Username:      transfer.Username,
+			FolderName:    transfer.FolderName,
+			IP:            transfer.IP,
+			TruncatedSize: transfer.TruncatedSize,
+			CurrentULSize: transfer.CurrentULSize,
+			CurrentDLSize: transfer.CurrentDLSize,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	})
+}
+
+func (p *EtcdProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActiveTransfers)
+		key := etcdTransferKey(transferID, connectionID)
+		var transfer etcdActiveTransfer
+		found, err := bucket.getObject(key, &transfer)
+		if err != nil || !found {
+			return err
+		}
+		transfer.CurrentULSize = ulSize
+		transfer.CurrentDLSize = dlSize
+		transfer.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(key, &transfer)
+	})
+}
+
+func (p *EtcdProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollActiveTransfers).delete(etcdTransferKey(transferID, connectionID))
+	})
+}
+
+func (p *EtcdProvider) cleanupActiveTransfers(before time.Time) error {
+	ts := util.GetTimeAsMsSinceEpoch(before)
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActiveTransfers)
+		return bucket.deleteFiltered(func(v []byte) bool {
+			var transfer etcdActiveTransfer
+			if err := json.Unmarshal(v, &transfer); err != nil {
+				return false
+			}
+			return transfer.UpdatedAt < ts
+		})
+	})
+}
+
+func (p *EtcdProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	transfers := make([]ActiveTransfer, 0, 30)
+	ts := util.GetTimeAsMsSinceEpoch(from)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActiveTransfers)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var transfer etcdActiveTransfer
+			if err := json.Unmarshal(data, &transfer); err != nil {
+				return err
+			}
+			if transfer.UpdatedAt <= ts {
+				return nil
+			}
+			transfers = append(transfers, ActiveTransfer{
+				ID:            transfer.ID,
+				Type:          transfer.Type,
+				ConnID:        transfer.ConnID,
+				Username:      transfer.Username,
+				FolderName:    transfer.FolderName,
+				IP:            transfer.IP,
+				TruncatedSize: transfer.TruncatedSize,
+				CurrentULSize: transfer.CurrentULSize,
+				CurrentDLSize: transfer.CurrentDLSize,
+				CreatedAt:     transfer.CreatedAt,
+				UpdatedAt:     transfer.UpdatedAt,
+			})
+			return nil
+		})
+	})
+	return transfers, err
+}
+
+func (p *EtcdProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollSharedSessions).put(session.Key, &etcdSession{
+			Data:      data,
+			Type:      session.Type,
+			Timestamp: session.Timestamp,
+		})
+	})
+}
+
+func (p *EtcdProvider) deleteSharedSession(key string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollSharedSessions)
+		exists, err := bucket.exists(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError("no session deleted")
+		}
+		return bucket.delete(key)
+	})
+}
+
+func (p *EtcdProvider) getSharedSession(key string) (Session, error) {
+	var session Session
+	err := p.view(func(tx *etcdTx) error {
+		var s etcdSession
+		found, err := tx.bucket(etcdCollSharedSessions).getObject(key, &s)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+		}
+		session = Session{
+			Key:       key,
+			Data:      s.Data,
+			Type:      s.Type,
+			Timestamp: s.Timestamp,
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (p *EtcdProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	var sessions []Session
+	err := p.view(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollSharedSessions).iteratePrefix(keyPrefix, OrderASC, func(key string, v []byte) error {
+			var s etcdSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Type == sessionType {
+				sessions = append(sessions, Session{
+					Key:       key,
+					Data:      s.Data,
+					Type:      s.Type,
+					Timestamp: s.Timestamp,
+				})
+			}
+			return nil
+		})
+	})
+	return sessions, err
+}
+
+func (p *EtcdProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollSharedSessions).deleteFiltered(func(v []byte) bool {
+			var s etcdSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return false
+			}
+			return s.Type == sessionType && s.Timestamp < before
+		})
+	})
+}
+
+func (p *EtcdProvider) getEventActions(limit, offset int, order string, _ bool) ([]BaseEventAction, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	actions := make([]BaseEventAction, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActions)
+		return bucket.list(order, offset, limit, "", func(data []byte) error {
+			var action BaseEventAction
+			if err := json.Unmarshal(data, &action); err != nil {
+				return err
+			}
+			action.PrepareForRendering()
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *EtcdProvider) dumpEventActions() ([]BaseEventAction, error) {
+	actions := make([]BaseEventAction, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActions)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var action BaseEventAction
+			if err := json.Unmarshal(data, &action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *EtcdProvider) eventActionExists(name string) (BaseEventAction, error) {
+	var action BaseEventAction
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollActions).getObject(name, &action)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %q does not exist", name))
+		}
+		return nil
+	})
+	return action, err
+}
+
+func (p *EtcdProvider) addEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActions)
+		exists, err := bucket.exists(action.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: event action %q already exists", ErrDuplicatedKey, action.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		action.ID = id
+		action.Rules = nil
+		return bucket.put(action.Name, action)
+	})
+}
+
+func (p *EtcdProvider) updateEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	err = p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActions)
+		var oldAction BaseEventAction
+		found, err := bucket.getObject(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event action %s does not exist", action.Name))
+		}
+		action.ID = oldAction.ID
+		action.Name = oldAction.Name
+		action.Rules = nil
+		rulesBucket := tx.bucket(etcdCollRules)
+		var relatedRules []string
+		for _, ruleName := range oldAction.Rules {
+			var rule EventRule
+			found, err := rulesBucket.getObject(ruleName, &rule)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			relatedRules = append(relatedRules, ruleName)
+			rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			if err = rulesBucket.put(rule.Name, &rule); err != nil {
+				return err
+			}
+		}
+		action.Rules = relatedRules
+		return bucket.put(action.Name, action)
+	})
+	if err == nil && len(action.Rules) > 0 {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *EtcdProvider) deleteEventAction(action BaseEventAction) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollActions)
+		var oldAction BaseEventAction
+		found, err := bucket.getObject(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %s does not exist", action.Name))
+		}
+		if len(oldAction.Rules) > 0 {
+			return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
+		}
+		return bucket.delete(action.Name)
+	})
+}
+
+func (p *EtcdProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rules := make([]EventRule, 0, limit)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		actionsBucket := tx.bucket(etcdCollActions)
+		return bucket.list(order, offset, limit, tenant, func(data []byte) error {
+			rule, err := p.joinRuleAndActions(data, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rule.PrepareForRendering()
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) dumpEventRules() ([]EventRule, error) {
+	rules := make([]EventRule, 0, 50)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		actionsBucket := tx.bucket(etcdCollActions)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			rule, err := p.joinRuleAndActions(data, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
+	rules := make([]EventRule, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		actionsBucket := tx.bucket(etcdCollActions)
+		return bucket.listFiltered(OrderASC, 0, 0, etcdMatchUpdatedAfter(after), func(data []byte) error {
+			rule, err := p.joinRuleAndActions(data, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *EtcdProvider) eventRuleExists(name string) (EventRule, error) {
+	var rule EventRule
+	err := p.view(func(tx *etcdTx) error {
+		data, err := tx.bucket(etcdCollRules).get(name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", name))
+		}
+		rule, err = p.joinRuleAndActions(data, tx.bucket(etcdCollActions))
+		return err
+	})
+	return rule, err
+}
+
+func (p *EtcdProvider) addEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		actionsBucket := tx.bucket(etcdCollActions)
+		exists, err := bucket.exists(rule.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: event rule %q already exists", ErrDuplicatedKey, rule.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		rule.ID = id
+		rule.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		rule.UpdatedAt = rule.CreatedAt
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.put(rule.Name, rule)
+	})
+	if err == nil {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *EtcdProvider) updateEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	err := p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		actionsBucket := tx.bucket(etcdCollActions)
+		var oldRule EventRule
+		found, err := bucket.getObject(rule.Name, &oldRule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		rule.ID = oldRule.ID
+		rule.CreatedAt = oldRule.CreatedAt
+		rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.put(rule.Name, rule)
+	})
+	if err == nil {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *EtcdProvider) deleteEventRule(rule EventRule, _ bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRules)
+		var oldRule EventRule
+		found, err := bucket.getObject(rule.Name, &oldRule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		actionsBucket := tx.bucket(etcdCollActions)
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		if err = bucket.delete(rule.Name); err != nil {
+			return err
+		}
+		return tx.bucket(etcdCollTasks).delete(rule.Name)
+	})
+}
+
+func (p *EtcdProvider) getTaskByName(name string) (Task, error) {
+	task := Task{
+		Name: name,
+	}
+	err := p.view(func(tx *etcdTx) error {
+		var t etcdTask
+		found, err := tx.bucket(etcdCollTasks).getObject(name, &t)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("task %q does not exist", name))
+		}
+		task.UpdateAt = t.UpdatedAt
+		task.Version = t.Version
+		return nil
+	})
+	return task, err
+}
+
+func (p *EtcdProvider) addTask(name string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTasks)
+		exists, err := bucket.exists(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: task %q already exists", ErrDuplicatedKey, name)
+		}
+		return bucket.put(name, &etcdTask{
+			UpdatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+			Version:   0,
+		})
+	})
+}
+
+func (p *EtcdProvider) updateTask(name string, version int64) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTasks)
+		var t etcdTask
+		found, err := bucket.getObject(name, &t)
+		if err != nil {
+			return err
+		}
+		if !found || t.Version != version {
+			return util.NewGenericError(fmt.Sprintf("task %q with version %d not found", name, version))
+		}
+		t.Version++
+		t.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(name, &t)
+	})
+}
+
+func (p *EtcdProvider) updateTaskTimestamp(name string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTasks)
+		var t etcdTask
+		found, err := bucket.getObject(name, &t)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewGenericError(fmt.Sprintf("task %q not found", name))
+		}
+		t.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(name, &t)
+	})
+}
+
+func (p *EtcdProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	err := p.update(func(tx *etcdTx) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		return tx.bucket(etcdCollNodes).put(currentNode.Name, &etcdNode{
+			Data:      currentNode.Data,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+	return nil
+}
+
+func (p *EtcdProvider) getNodeByName(name string) (Node, error) {
+	var node Node
+	err := p.view(func(tx *etcdTx) error {
+		var n etcdNode
+		found, err := tx.bucket(etcdCollNodes).getObject(name, &n)
+		if err != nil {
+			return err
+		}
+		if !found || n.UpdatedAt <= util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff)) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("node %q does not exist", name))
+		}
+		node = Node{
+			Name:      name,
+			Data:      n.Data,
+			CreatedAt: n.CreatedAt,
+			UpdatedAt: n.UpdatedAt,
+		}
+		return nil
+	})
+	return node, err
+}
+
+func (p *EtcdProvider) getNodes() ([]Node, error) {
+	var nodes []Node
+	activeAfter := util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))
+	err := p.view(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollNodes).iterate(OrderASC, func(key string, v []byte) error {
+			if key == currentNode.Name {
+				return nil
+			}
+			var n etcdNode
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			if n.UpdatedAt > activeAfter {
+				nodes = append(nodes, Node{
+					Name:      key,
+					Data:      n.Data,
+					CreatedAt: n.CreatedAt,
+					UpdatedAt: n.UpdatedAt,
+				})
+			}
+			return nil
+		})
+	})
+	return nodes, err
+}
+
+func (p *EtcdProvider) updateNodeTimestamp() error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollNodes)
+		var n etcdNode
+		found, err := bucket.getObject(currentNode.Name, &n)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewGenericError(fmt.Sprintf("node %q not found", currentNode.Name))
+		}
+		n.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(currentNode.Name, &n)
+	})
+}
+
+func (p *EtcdProvider) cleanupNodes() error {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(10 * activeNodeTimeDiff))
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollNodes).deleteFiltered(func(v []byte) bool {
+			var n etcdNode
+			if err := json.Unmarshal(v, &n); err != nil {
+				return false
+			}
+			return n.UpdatedAt < before
+		})
+	})
+}
+
+func (p *EtcdProvider) roleExists(name string) (Role, error) {
+	var role Role
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollRoles).getObject(name, &role)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", name))
+		}
+		return nil
+	})
+	return role, err
+}
+
+func (p *EtcdProvider) addRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRoles)
+		exists, err := bucket.exists(role.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: role %q already exists", ErrDuplicatedKey, role.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		role.ID = id
+		role.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = nil
+		role.Admins = nil
+		return bucket.put(role.Name, role)
+	})
+}
+
+func (p *EtcdProvider) updateRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRoles)
+		var oldRole Role
+		found, err := bucket.getObject(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		role.ID = oldRole.ID
+		role.CreatedAt = oldRole.CreatedAt
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = oldRole.Users
+		role.Admins = oldRole.Admins
+		return bucket.put(role.Name, role)
+	})
+}
+
+func (p *EtcdProvider) deleteRole(role Role) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRoles)
+		var oldRole Role
+		found, err := bucket.getObject(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		if len(oldRole.Admins) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
+		}
+		usersBucket := tx.bucket(etcdCollUsers)
+		for _, username := range oldRole.Users {
+			if err = p.removeRoleFromUser(username, oldRole.Name, usersBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(role.Name)
+	})
+}
+
+func (p *EtcdProvider) getRoles(limit int, offset int, order string, _ bool, tenant string) ([]Role, error) {
+	roles := make([]Role, 0, limit)
+	if limit <= 0 {
+		return roles, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRoles)
+		return bucket.list(order, offset, limit, tenant, func(data []byte) error {
+			var role Role
+			if err := json.Unmarshal(data, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *EtcdProvider) dumpRoles() ([]Role, error) {
+	roles := make([]Role, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollRoles)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var role Role
+			if err := json.Unmarshal(data, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *EtcdProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollTenants).getObject(name, &tenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return nil
+	})
+	return tenant, err
+}
+
+func (p *EtcdProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTenants)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: tenant %q already exists", ErrDuplicatedKey, tenant.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		tenant.ID = id
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(tenant.Name, tenant)
+	})
+}
+
+func (p *EtcdProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTenants)
+		var oldTenant Tenant
+		found, err := bucket.getObject(tenant.Name, &oldTenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(tenant.Name, tenant)
+	})
+}
+
+func (p *EtcdProvider) deleteTenant(tenant Tenant) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTenants)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		for _, name := range etcdTenantCollections {
+			count := 0
+			err := tx.bucket(name).listFiltered(OrderASC, 0, 1, etcdFilter{Tenant: tenant.Name}.match, func(_ []byte) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return util.NewValidationError(fmt.Sprintf("the tenant %q is referenced, it cannot be removed",
+					tenant.Name))
+			}
+		}
+		return bucket.delete(tenant.Name)
+	})
+}
+
+func (p *EtcdProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTenants)
+		return bucket.list(order, offset, limit, "", func(data []byte) error {
+			var tenant Tenant
+			if err := json.Unmarshal(data, &tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *EtcdProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollTenants)
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var tenant Tenant
+			if err := json.Unmarshal(data, &tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *EtcdProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollAuditLogs)
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		return bucket.put(etcdAuditLogKey(entry.ID), entry)
+	})
+}
+
+func (p *EtcdProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, limit)
+	fromKey := etcdAuditLogKey(from)
+	err := p.view(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollAuditLogs).iterate(OrderASC, func(key string, v []byte) error {
+			if key <= fromKey {
+				return nil
+			}
+			if len(entries) >= limit {
+				return errEtcdStopIteration
+			}
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	var entry AuditLogEntry
+	found := false
+	err := p.view(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollAuditLogs).iterate(OrderDESC, func(_ string, v []byte) error {
+			var e AuditLogEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Node != node {
+				return nil
+			}
+			entry = e
+			found = true
+			return errEtcdStopIteration
+		})
+	})
+	if err == nil && !found {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("no audit log entry for node %q", node))
+	}
+	return entry, err
+}
+
+func (p *EtcdProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
+	entry := IPListEntry{
+		IPOrNet: ipOrNet,
+		Type:    listType,
+	}
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollIPLists).getObject(entry.getKey(), &entry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.PrepareForRendering()
+		return nil
+	})
+	return entry, err
+}
+
+func (p *EtcdProvider) addIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		exists, err := bucket.exists(entry.getKey())
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: entry %q already exists", ErrDuplicatedKey, entry.IPOrNet),
+				util.I18nErrorDuplicatedIPNet,
+			)
+		}
+		entry.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(entry.getKey(), entry)
+	})
+}
+
+func (p *EtcdProvider) updateIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		var oldEntry IPListEntry
+		found, err := bucket.getObject(entry.getKey(), &oldEntry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.CreatedAt = oldEntry.CreatedAt
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(entry.getKey(), entry)
+	})
+}
+
+func (p *EtcdProvider) deleteIPListEntry(entry IPListEntry, _ bool) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		exists, err := bucket.exists(entry.getKey())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		return bucket.delete(entry.getKey())
+	})
+}
+
+func (p *EtcdProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 15)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		prefix := fmt.Sprintf("%d_", listType)
+		return bucket.iteratePrefix(prefix, order, func(_ string, v []byte) error {
+			if limit > 0 && len(entries) >= limit {
+				return errEtcdStopIteration
+			}
+			var entry IPListEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.satisfySearchConstraints(filter, from, order) {
+				entry.PrepareForRendering()
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		return bucket.listFiltered(OrderASC, 0, 0, etcdMatchUpdatedAfter(after), func(data []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) dumpIPListEntries() ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 10)
+	err := p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		count, err := bucket.count("")
+		if err != nil {
+			return err
+		}
+		if count > ipListMemoryLimit {
+			providerLog(logger.LevelInfo, "IP lists excluded from dump, too many entries: %d", count)
+			return nil
+		}
+		return bucket.list(OrderASC, 0, 0, "", func(data []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) countIPListEntries(listType IPListType) (int64, error) {
+	var count int64
+	err := p.view(func(tx *etcdTx) error {
+		var prefix string
+		if listType != 0 {
+			prefix = fmt.Sprintf("%d_", listType)
+		}
+		var err error
+		count, err = tx.bucket(etcdCollIPLists).count(prefix)
+		return err
+	})
+	return count, err
+}
+
+func (p *EtcdProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 3)
+	ipAddr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return entries, fmt.Errorf("invalid ip address %s", ip)
+	}
+	var netType int
+	var ipBytes []byte
+	if ipAddr.Is4() || ipAddr.Is4In6() {
+		netType = ipTypeV4
+		as4 := ipAddr.As4()
+		ipBytes = as4[:]
+	} else {
+		netType = ipTypeV6
+		as16 := ipAddr.As16()
+		ipBytes = as16[:]
+	}
+	err = p.view(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollIPLists)
+		prefix := fmt.Sprintf("%d_", listType)
+		return bucket.iteratePrefix(prefix, OrderASC, func(_ string, v []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.IPType == netType && bytes.Compare(ipBytes, entry.First) >= 0 && bytes.Compare(ipBytes, entry.Last) <= 0 {
+				entry.PrepareForRendering()
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *EtcdProvider) getConfigs() (Configs, error) {
+	var configs Configs
+	err := p.view(func(tx *etcdTx) error {
+		_, err := tx.bucket(etcdCollConfigs).getObject(etcdCollConfigs, &configs)
+		return err
+	})
+	return configs, err
+}
+
+func (p *EtcdProvider) setConfigs(configs *Configs) error {
+	if err := configs.validate(); err != nil {
+		return err
+	}
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollConfigs).put(etcdCollConfigs, configs)
+	})
+}
+
+func (p *EtcdProvider) setFirstDownloadTimestamp(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set download timestamp",
+				username))
+		}
+		if user.FirstDownload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first download already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstDownload)))
+		}
+		user.FirstDownload = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *EtcdProvider) setFirstUploadTimestamp(username string) error {
+	return p.update(func(tx *etcdTx) error {
+		bucket := tx.bucket(etcdCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set upload timestamp",
+				username))
+		}
+		if user.FirstUpload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first upload already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstUpload)))
+		}
+		user.FirstUpload = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *EtcdProvider) close() error {
+	p.cancelWatch()
+	return p.client.Close()
+}
+
+func (p *EtcdProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase sets the initial schema version, no schema is required
+func (p *EtcdProvider) initializeDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err == nil && dbVersion.Version > 0 {
+		return ErrNoInitRequired
+	}
+	if err != nil && !errors.Is(err, util.ErrNotFound) {
+		return err
+	}
+	logger.InfoToConsole("creating initial database schema, version %d", etcdDatabaseVersion)
+	providerLog(logger.LevelInfo, "creating initial database schema, version %d", etcdDatabaseVersion)
+	return p.setDatabaseVersion(etcdDatabaseVersion)
+}
+
+func (p *EtcdProvider) migrateDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == etcdDatabaseVersion:
+		providerLog(logger.LevelDebug, "etcd database is up to date, current version: %d", version)
+		return ErrNoInitRequired
+	case version == 33:
+		// the audit logs are stored below a new key prefix, only the version must be updated
+		logger.InfoToConsole("updating database schema version: 33 -> 34")
+		providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+		return p.setDatabaseVersion(34)
+	default:
+		if version > etcdDatabaseVersion {
+			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
+				etcdDatabaseVersion)
+			logger.WarnToConsole("database schema version %d is newer than the supported one: %d", version,
+				etcdDatabaseVersion)
+			return nil
+		}
+		return fmt.Errorf("database schema version not handled: %d", version)
+	}
+}
+
+func (p *EtcdProvider) revertDatabase(targetVersion int) error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
+}
+
+func (p *EtcdProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	_, err := p.client.Delete(ctx, p.keyPrefix, clientv3.WithPrefix())
+	return err
+}
+
+func (p *EtcdProvider) getDatabaseVersion() (schemaVersion, error) {
+	var dbVersion schemaVersion
+	err := p.view(func(tx *etcdTx) error {
+		found, err := tx.bucket(etcdCollSchemaVersion).getObject("version", &dbVersion)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError("unable to find database schema version")
+		}
+		return nil
+	})
+	return dbVersion, err
+}
+
+func (p *EtcdProvider) setDatabaseVersion(version int) error {
+	return p.update(func(tx *etcdTx) error {
+		return tx.bucket(etcdCollSchemaVersion).put("version", &schemaVersion{Version: version})
+	})
+}
+
+// view executes fn reading from a consistent snapshot
+func (p *EtcdProvider) view(fn func(tx *etcdTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return fn(&etcdTx{
+		ctx:    ctx,
+		client: p.client,
+		prefix: p.keyPrefix,
+	})
+}
+
+// update executes fn inside a serializable transaction, fn is retried on conflicts
+func (p *EtcdProvider) update(fn func(tx *etcdTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	var txErr error
+	_, err := concurrency.NewSTM(p.client, func(stm concurrency.STM) error {
+		txErr = fn(&etcdTx{
+			ctx:    ctx,
+			client: p.client,
+			stm:    stm,
+			prefix: p.keyPrefix,
+		})
+		// returning an error from the apply function aborts the transaction
+		return txErr
+	}, concurrency.WithAbortContext(ctx), concurrency.WithIsolation(concurrency.SerializableSnapshot))
+	if txErr != nil {
+		return txErr
+	}
+	return err
+}
+
+// watch reacts to the changes made by any node and invalidates the related
+// caches. The watch is restarted until the provider is closed
+func (p *EtcdProvider) watch(ctx context.Context) {
+	for {
+		watchChan := p.client.Watch(clientv3.WithRequireLeader(ctx), p.keyPrefix, clientv3.WithPrefix(),
+			clientv3.WithPrevKV())
+		for resp := range watchChan {
+			if err := resp.Err(); err != nil {
+				providerLog(logger.LevelError, "etcd watch error: %v", err)
+				break
+			}
+			for _, ev := range resp.Events {
+				p.handleWatchEvent(ev)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			providerLog(logger.LevelDebug, "etcd watch stopped")
+			return
+		case <-time.After(5 * time.Second):
+			// some events could be lost while the watch was not active
+			providerLog(logger.LevelDebug, "restarting etcd watch")
+			if fnReloadRules != nil {
+				fnReloadRules()
+			}
+		}
+	}
+}
+
+func (p *EtcdProvider) handleWatchEvent(ev *clientv3.Event) {
+	bucketName, key, ok := strings.Cut(strings.TrimPrefix(string(ev.Kv.Key), p.keyPrefix), "/")
+	if !ok {
+		return
+	}
+	if ev.Type == clientv3.EventTypePut && ev.PrevKv != nil && !etcdHasNewTimestamp(ev.PrevKv.Value, ev.Kv.Value) {
+		// internal updates such as the last login or the quota fields
+		return
+	}
+	switch bucketName {
+	case etcdCollUsers:
+		providerLog(logger.LevelDebug, "invalidate caches for user %q", key)
+		if ev.Type == clientv3.EventTypeDelete {
+			removeCachedUser(key)
+			cachedUserPasswords.Remove(key)
+			delayedQuotaUpdater.resetUserQuota(key)
+			return
+		}
+		user, err := p.userExists(key, "")
+		if err != nil {
+			removeCachedUser(key)
+			return
+		}
+		swapCachedUser(&user, "")
+	case etcdCollIPLists:
+		var entry IPListEntry
+		value := ev.Kv.Value
+		if ev.Type == clientv3.EventTypeDelete {
+			if ev.PrevKv == nil {
+				return
+			}
+			value = ev.PrevKv.Value
+		}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return
+		}
+		providerLog(logger.LevelDebug, "update cache for IP list entry %q", entry.getName())
+		for _, l := range inMemoryLists {
+			if ev.Type == clientv3.EventTypeDelete {
+				l.removeEntry(&entry)
+			} else {
+				l.updateEntry(&entry)
+			}
+		}
+	case etcdCollRules:
+		if ev.Type == clientv3.EventTypeDelete {
+			if fnRemoveRule != nil {
+				fnRemoveRule(key)
+			}
+			return
+		}
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	}
+}
+
+func (p *EtcdProvider) checkAPIKeyRelations(tx *etcdTx, apiKey *APIKey) error {
+	if apiKey.User != "" {
+		exists, err := tx.bucket(etcdCollUsers).exists(apiKey.User)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: related user %q does not exists", ErrForeignKeyViolated, apiKey.User)
+		}
+	}
+	if apiKey.Admin != "" {
+		exists, err := tx.bucket(etcdCollAdmins).exists(apiKey.Admin)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: related admin %q does not exists", ErrForeignKeyViolated, apiKey.Admin)
+		}
+	}
+	return nil
+}
+
+func (p *EtcdProvider) deleteUserInternal(tx *etcdTx, user User) error {
+	if err := p.removeUserFromRole(user.Username, user.Role, tx.bucket(etcdCollRoles)); err != nil {
+		return err
+	}
+	if err := p.removeUserFromFoldersAndGroups(tx, user); err != nil {
+		return err
+	}
+	if err := tx.bucket(etcdCollAPIKeys).deleteMatching("user", user.Username); err != nil {
+		return err
+	}
+	if err := tx.bucket(etcdCollShares).deleteMatching("username", user.Username); err != nil {
+		return err
+	}
+	return tx.bucket(etcdCollUsers).delete(user.Username)
+}
+
+func (p *EtcdProvider) removeUserFromFoldersAndGroups(tx *etcdTx, user User) error {
+	foldersBucket := tx.bucket(etcdCollFolders)
+	for idx := range user.VirtualFolders {
+		err := p.removeRelationFromFolderMapping(user.VirtualFolders[idx], user.Username, "", foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	groupsBucket := tx.bucket(etcdCollGroups)
+	for idx := range user.Groups {
+		if err := p.removeUserFromGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EtcdProvider) updateUserRelations(tx *etcdTx, user *User, oldUser User) error {
+	if err := p.removeUserFromFoldersAndGroups(tx, oldUser); err != nil {
+		return err
+	}
+	rolesBucket := tx.bucket(etcdCollRoles)
+	if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
+		return err
+	}
+	foldersBucket := tx.bucket(etcdCollFolders)
+	sort.Slice(user.VirtualFolders, func(i, j int) bool {
+		return user.VirtualFolders[i].Name < user.VirtualFolders[j].Name
+	})
+	for idx := range user.VirtualFolders {
+		if err := p.addRelationToFolderMapping(user.VirtualFolders[idx].Name, user, nil, foldersBucket); err != nil {
+			return err
+		}
+	}
+	groupsBucket := tx.bucket(etcdCollGroups)
+	sort.Slice(user.Groups, func(i, j int) bool {
+		return user.Groups[i].Name < user.Groups[j].Name
+	})
+	for idx := range user.Groups {
+		if err := p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+			return err
+		}
+	}
+	return p.addUserToRole(user.Username, user.Role, rolesBucket)
+}
+
+func (p *EtcdProvider) joinRuleAndActions(r []byte, actionsBucket *etcdBucket) (EventRule, error) {
+	var rule EventRule
+	err := json.Unmarshal(r, &rule)
+	if err != nil {
+		return rule, err
+	}
+	var actions []EventAction
+	for idx := range rule.Actions {
+		action := &rule.Actions[idx]
+		var baseAction BaseEventAction
+		found, err := actionsBucket.getObject(action.Name, &baseAction)
+		if err != nil {
+			return rule, err
+		}
+		if !found {
+			continue
+		}
+		baseAction.Options.SetEmptySecretsIfNil()
+		action.BaseEventAction = baseAction
+		actions = append(actions, *action)
+	}
+	rule.Actions = actions
+	return rule, nil
+}
+
+func (p *EtcdProvider) joinGroupAndFolders(g []byte, foldersBucket *etcdBucket) (Group, error) {
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return group, err
+	}
+	if len(group.VirtualFolders) > 0 {
+		group.VirtualFolders = p.getUserOrGroupFolders(group.VirtualFolders, foldersBucket)
+	}
+	group.SetEmptySecretsIfNil()
+	return group, err
+}
+
+func (p *EtcdProvider) joinUserAndFolders(u []byte, foldersBucket *etcdBucket) (User, error) {
+	var user User
+	err := json.Unmarshal(u, &user)
+	if err != nil {
+		return user, err
+	}
+	if len(user.VirtualFolders) > 0 {
+		user.VirtualFolders = p.getUserOrGroupFolders(user.VirtualFolders, foldersBucket)
+	}
+	user.SetEmptySecretsIfNil()
+	return user, err
+}
+
+func (p *EtcdProvider) getUserOrGroupFolders(virtualFolders []vfs.VirtualFolder, foldersBucket *etcdBucket,
+) []vfs.VirtualFolder {
+	var folders []vfs.VirtualFolder
+	for idx := range virtualFolders {
+		folder := &virtualFolders[idx]
+		baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+		if err != nil {
+			continue
+		}
+		folder.BaseVirtualFolder = baseFolder
+		folders = append(folders, *folder)
+	}
+	return folders
+}
+
+func (p *EtcdProvider) groupExistsInternal(name string, bucket *etcdBucket) (Group, error) {
+	var group Group
+	found, err := bucket.getObject(name, &group)
+	if err == nil && !found {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+	}
+	return group, err
+}
+
+func (p *EtcdProvider) folderExistsInternal(name string, bucket *etcdBucket) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(name, &folder)
+	if err == nil && !found {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
+	}
+	return folder, err
+}
+
+func (p *EtcdProvider) removeRoleFromUser(username, role string, bucket *etcdBucket) error {
+	var user User
+	found, err := bucket.getObject(username, &user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "user %q does not exist, cannot remove role %q", username, role)
+		return nil
+	}
+	if user.Role == role {
+		user.Role = ""
+		return bucket.put(user.Username, &user)
+	}
+	providerLog(logger.LevelError, "user %q does not have the expected role %q, actual %q", username, role, user.Role)
+	return nil
+}
+
+func (p *EtcdProvider) addAdminToRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: role %q does not exist", ErrForeignKeyViolated, roleName)
+	}
+	if !util.Contains(role.Admins, username) {
+		role.Admins = append(role.Admins, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeAdminFromRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove admin %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Admins, username) {
+		role.Admins = etcdRemoveString(role.Admins, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addUserToRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: role %q does not exist", ErrForeignKeyViolated, roleName)
+	}
+	if !util.Contains(role.Users, username) {
+		role.Users = append(role.Users, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeUserFromRole(username, roleName string, bucket *etcdBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove user %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Users, username) {
+		role.Users = etcdRemoveString(role.Users, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addRuleToActionMapping(ruleName, actionName string, bucket *etcdBucket) error {
+	var action BaseEventAction
+	found, err := bucket.getObject(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("action %q does not exist", actionName))
+	}
+	if !util.Contains(action.Rules, ruleName) {
+		action.Rules = append(action.Rules, ruleName)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeRuleFromActionMapping(ruleName, actionName string, bucket *etcdBucket) error {
+	var action BaseEventAction
+	found, err := bucket.getObject(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "action %q does not exist, cannot remove from mapping", actionName)
+		return nil
+	}
+	if util.Contains(action.Rules, ruleName) {
+		action.Rules = etcdRemoveString(action.Rules, ruleName)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) addUserToGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	if !util.Contains(group.Users, username) {
+		group.Users = append(group.Users, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeUserFromGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	group.Users = etcdRemoveString(group.Users, username)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *EtcdProvider) addAdminToGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	if !util.Contains(group.Admins, username) {
+		group.Admins = append(group.Admins, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *EtcdProvider) removeAdminFromGroupMapping(username, groupname string, bucket *etcdBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	group.Admins = etcdRemoveString(group.Admins, username)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *EtcdProvider) removeGroupFromAdminMapping(groupName, adminName string, bucket *etcdBucket) error {
+	var admin Admin
+	found, err := bucket.getObject(adminName, &admin)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the admin does not exist so there is no associated group
+		return nil
+	}
+	var newGroups []AdminGroupMapping
+	for _, g := range admin.Groups {
+		if g.Name != groupName {
+			newGroups = append(newGroups, g)
+		}
+	}
+	admin.Groups = newGroups
+	return bucket.put(adminName, &admin)
+}
+
+func (p *EtcdProvider) addRelationToFolderMapping(folderName string, user *User, group *Group, bucket *etcdBucket) error {
+	var folder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(folderName, &folder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("folder %q does not exist", folderName))
+	}
+	updated := false
+	if user != nil && !util.Contains(folder.Users, user.Username) {
+		folder.Users = append(folder.Users, user.Username)
+		updated = true
+	}
+	if group != nil && !util.Contains(folder.Groups, group.Name) {
+		folder.Groups = append(folder.Groups, group.Name)
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	return bucket.put(folder.Name, &folder)
+}
+
+func (p *EtcdProvider) removeRelationFromFolderMapping(folder vfs.VirtualFolder, username, groupname string,
+	bucket *etcdBucket,
+) error {
+	var baseFolder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(folder.Name, &baseFolder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the folder does not exist so there is no associated user/group
+		return nil
+	}
+	if username == "" && groupname == "" {
+		return nil
+	}
+	if username != "" {
+		baseFolder.Users = etcdRemoveString(baseFolder.Users, username)
+	}
+	if groupname != "" {
+		baseFolder.Groups = etcdRemoveString(baseFolder.Groups, groupname)
+	}
+	return bucket.put(folder.Name, &baseFolder)
+}
+
+func (tx *etcdTx) bucket(name string) *etcdBucket {
+	return &etcdBucket{
+		tx:     tx,
+		name:   name,
+		prefix: tx.prefix + name + "/",
+	}
+}
+
+// rangeOptions returns the options to read from the snapshot used by
+// the first read in this transaction
+func (tx *etcdTx) rangeOptions(opts ...clientv3.OpOption) []clientv3.OpOption {
+	if tx.rev > 0 {
+		opts = append(opts, clientv3.WithRev(tx.rev))
+	}
+	return opts
+}
+
+func (tx *etcdTx) setRevision(resp *clientv3.GetResponse) {
+	if tx.rev == 0 && resp.Header != nil {
+		tx.rev = resp.Header.Revision
+	}
+}
+
+// getRange returns the key/value pairs with the given prefix. Inside a
+// transaction the keys read are included in the conflict detection
+func (tx *etcdTx) getRange(prefix string, order string) ([]*mvccpb.KeyValue, error) {
+	sortOrder := clientv3.SortAscend
+	if order == OrderDESC {
+		sortOrder = clientv3.SortDescend
+	}
+	resp, err := tx.client.Get(tx.ctx, prefix, tx.rangeOptions(clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, sortOrder))...)
+	if err != nil {
+		return nil, err
+	}
+	tx.setRevision(resp)
+	if tx.stm == nil {
+		return resp.Kvs, nil
+	}
+	kvs := make([]*mvccpb.KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		// read the key using the STM to detect concurrent modifications
+		val := tx.stm.Get(string(kv.Key))
+		if val == "" {
+			continue
+		}
+		kvs = append(kvs, &mvccpb.KeyValue{Key: kv.Key, Value: []byte(val)})
+	}
+	return kvs, nil
+}
+
+func (b *etcdBucket) key(key string) string {
+	return b.prefix + key
+}
+
+// get returns the serialized object with the given key or nil if it does not exist
+func (b *etcdBucket) get(key string) ([]byte, error) {
+	if b.tx.stm != nil {
+		val := b.tx.stm.Get(b.key(key))
+		if val == "" {
+			return nil, nil
+		}
+		return []byte(val), nil
+	}
+	resp, err := b.tx.client.Get(b.tx.ctx, b.key(key), b.tx.rangeOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	b.tx.setRevision(resp)
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (b *etcdBucket) getObject(key string, obj any) (bool, error) {
+	data, err := b.get(key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, obj)
+}
+
+func (b *etcdBucket) exists(key string) (bool, error) {
+	data, err := b.get(key)
+	return data != nil, err
+}
+
+func (b *etcdBucket) put(key string, obj any) error {
+	if b.tx.stm == nil {
+		return errors.New("etcd: write attempted in a read only transaction")
+	}
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	b.tx.stm.Put(b.key(key), string(buf))
+	return nil
+}
+
+func (b *etcdBucket) delete(key string) error {
+	if b.tx.stm == nil {
+		return errors.New("etcd: delete attempted in a read only transaction")
+	}
+	b.tx.stm.Del(b.key(key))
+	return nil
+}
+
+// deleteFiltered removes the objects for which match returns true
+func (b *etcdBucket) deleteFiltered(match func(v []byte) bool) error {
+	return b.iterate(OrderASC, func(key string, v []byte) error {
+		if match(v) {
+			return b.delete(key)
+		}
+		return nil
+	})
+}
+
+// deleteMatching removes the objects having the specified field equal to value
+func (b *etcdBucket) deleteMatching(field, value string) error {
+	return b.deleteFiltered(func(v []byte) bool {
+		var fields map[string]any
+		if err := json.Unmarshal(v, &fields); err != nil {
+			return false
+		}
+		fieldValue, ok := fields[field].(string)
+		return ok && fieldValue == value
+	})
+}
+
+// nextSequence returns the next numeric ID for the objects stored in this bucket
+func (b *etcdBucket) nextSequence() (int64, error) {
+	if b.tx.stm == nil {
+		return 0, errors.New("etcd: sequence requested in a read only transaction")
+	}
+	key := b.tx.prefix + etcdCollSequences + "/" + b.name
+	var current int64
+	if val := b.tx.stm.Get(key); val != "" {
+		var err error
+		current, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sequence value for %q: %w", b.name, err)
+		}
+	}
+	current++
+	b.tx.stm.Put(key, strconv.FormatInt(current, 10))
+	return current, nil
+}
+
+// iterate calls fn for each object in the bucket, sorted by key
+func (b *etcdBucket) iterate(order string, fn func(key string, v []byte) error) error {
+	return b.iteratePrefix("", order, fn)
+}
+
+// iteratePrefix calls fn for each object whose key starts with the given prefix,
+// return errEtcdStopIteration from fn to stop the iteration
+func (b *etcdBucket) iteratePrefix(prefix, order string, fn func(key string, v []byte) error) error {
+	kvs, err := b.tx.getRange(b.prefix+prefix, order)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if err := fn(strings.TrimPrefix(string(kv.Key), b.prefix), kv.Value); err != nil {
+			if errors.Is(err, errEtcdStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// count returns the number of objects whose key starts with the given prefix
+func (b *etcdBucket) count(prefix string) (int64, error) {
+	resp, err := b.tx.client.Get(b.tx.ctx, b.prefix+prefix, b.tx.rangeOptions(clientv3.WithPrefix(),
+		clientv3.WithCountOnly())...)
+	if err != nil {
+		return 0, err
+	}
+	b.tx.setRevision(resp)
+	return resp.Count, nil
+}
+
+// list calls fn for the objects belonging to the specified tenant, if any
+func (b *etcdBucket) list(order string, offset, limit int, tenant string, fn func(data []byte) error) error {
+	return b.listFiltered(order, offset, limit, etcdFilter{Tenant: tenant}.match, fn)
+}
+
+// listFiltered calls fn for the objects for which match returns true
+// honoring the requested pagination
+func (b *etcdBucket) listFiltered(order string, offset, limit int, match func(data []byte) bool,
+	fn func(data []byte) error,
+) error {
+	itNum := 0
+	return b.iterate(order, func(_ string, v []byte) error {
+		if !match(v) {
+			return nil
+		}
+		itNum++
+		if itNum <= offset {
+			return nil
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+		if limit > 0 && itNum-offset >= limit {
+			return errEtcdStopIteration
+		}
+		return nil
+	})
+}
+
+// etcdFilter matches the serialized objects against the non empty fields
+type etcdFilter struct {
+	Tenant   string `json:"tenant"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+	User     string `json:"user"`
+	Admin    string `json:"admin"`
+}
+
+func (f etcdFilter) match(data []byte) bool {
+	var obj etcdFilter
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return false
+	}
+	if f.Tenant != "" && obj.Tenant != f.Tenant {
+		return false
+	}
+	if f.Role != "" && obj.Role != f.Role {
+		return false
+	}
+	if f.Username != "" && obj.Username != f.Username {
+		return false
+	}
+	if f.User != "" && obj.User != f.User {
+		return false
+	}
+	return f.Admin == "" || obj.Admin == f.Admin
+}
+
+type etcdTimestamp struct {
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+func etcdMatchUpdatedAfter(after int64) func(data []byte) bool {
+	return func(data []byte) bool {
+		var obj etcdTimestamp
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return false
+		}
+		return obj.UpdatedAt >= after
+	}
+}
+
+// etcdHasNewTimestamp returns true if the update timestamp changed,
+// quota and last login updates do not change it
+func etcdHasNewTimestamp(prev, current []byte) bool {
+	var prevObj, currentObj etcdTimestamp
+	if err := json.Unmarshal(prev, &prevObj); err != nil {
+		return true
+	}
+	if err := json.Unmarshal(current, &currentObj); err != nil {
+		return true
+	}
+	return prevObj.UpdatedAt != currentObj.UpdatedAt
+}
+
+// etcdAuditLogKey returns the key for the audit log entry with the given ID,
+// the ID is zero padded so the lexicographic order matches the numeric one
+func etcdAuditLogKey(id int64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+func etcdTransferKey(transferID int64, connectionID string) string {
+	return fmt.Sprintf("%s_%d", connectionID, transferID)
+}
+
+func etcdRemoveString(values []string, toRemove string) []string {
+	var result []string
+	for _, v := range values {
+		if v != toRemove {
+			result = append(result, v)
+		}
+	}
+	return util.RemoveDuplicates(result, false)
+}
+
+func etcdRemoveVirtualFolder(folders []vfs.VirtualFolder, name string) []vfs.VirtualFolder {
+	var result []vfs.VirtualFolder
+	for _, folder := range folders {
+		if folder.Name != name {
+			result = append(result, folder)
+		}
+	}
+	return result
+}
@@ -193,15 +193,17 @@ func getFsActionTypeAsString(value int) string {
 var (
 	// SupportedFsEvents defines the supported filesystem events
 	SupportedFsEvents = []string{"upload", "pre-upload", "first-upload", "download", "pre-download",
-		"first-download", "delete", "pre-delete", "rename", "mkdir", "rmdir", "copy", "ssh_cmd"}
+		"first-download", "delete", "pre-delete", "rename", "mkdir", "rmdir", "copy", "ssh_cmd", "quota-threshold",
+		"archive-restore"}
 	// SupportedProviderEvents defines the supported provider events
-	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete}
+	SupportedProviderEvents = []string{operationAdd, operationUpdate, operationDelete, operationImpersonate}
 	// SupportedRuleConditionProtocols defines the supported protcols for rule conditions
 	SupportedRuleConditionProtocols = []string{"SFTP", "SCP", "SSH", "FTP", "DAV", "HTTP", "HTTPShare",
-		"OIDC"}
+		"S3", "OIDC"}
 	// SupporteRuleConditionProviderObjects defines the supported provider objects for rule conditions
 	SupporteRuleConditionProviderObjects = []string{actionObjectUser, actionObjectFolder, actionObjectGroup,
-		actionObjectAdmin, actionObjectAPIKey, actionObjectShare, actionObjectEventRule, actionObjectEventAction}
+		actionObjectAdmin, actionObjectAPIKey, actionObjectShare, actionObjectEventRule, actionObjectEventAction,
+		actionObjectSSHUserCert}
 	// SupportedHTTPActionMethods defines the supported methods for HTTP actions
 	SupportedHTTPActionMethods = []string{http.MethodPost, http.MethodGet, http.MethodPut, http.MethodDelete}
 	allowedSyncFsEvents        = []string{"upload", "pre-upload", "pre-download", "pre-delete"}
@@ -399,13 +401,13 @@ func (c *EventActionHTTPConfig) GetContext() (context.Context, context.CancelFun
 	return context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
 }
 
-// HasObjectData returns true if the {{ObjectData}} placeholder is defined
+// HasObjectData returns true if the {{ObjectData}} or {{ObjectDiff}} placeholders are defined
 func (c *EventActionHTTPConfig) HasObjectData() bool {
-	if strings.Contains(c.Body, "{{ObjectData}}") || strings.Contains(c.Body, "{{ObjectDataString}}") {
+	if strings.Contains(c.Body, "{{ObjectData") || strings.Contains(c.Body, "{{ObjectDiff") {
 		return true
 	}
 	for _, part := range c.Parts {
-		if strings.Contains(part.Body, "{{ObjectData}}") || strings.Contains(part.Body, "{{ObjectDataString}}") {
+		if strings.Contains(part.Body, "{{ObjectData") || strings.Contains(part.Body, "{{ObjectDiff") {
 			return true
 		}
 	}
@@ -1576,6 +1578,9 @@ type EventRule struct {
 	Conditions EventConditions `json:"conditions"`
 	// actions to execute
 	Actions []EventAction `json:"actions"`
+	// Tenant the rule belongs to, tenant rules are only triggered by
+	// events generated by users and objects within the same tenant
+	Tenant string `json:"tenant,omitempty"`
 	// in multi node setups we mark the rule as deleted to be able to update the cache
 	DeletedAt int64 `json:"-"`
 }
@@ -1597,6 +1602,7 @@ func (r *EventRule) getACopy() EventRule {
 		Conditions:  r.Conditions.getACopy(),
 		Actions:     actions,
 		DeletedAt:   r.DeletedAt,
+		Tenant:      r.Tenant,
 	}
 }
 
@@ -1609,6 +1615,12 @@ func (r *EventRule) GuardFromConcurrentExecution() bool {
 	return !r.Conditions.Options.ConcurrentExecution
 }
 
+// MatchesTenant returns true if the rule must be evaluated for events
+// generated within the specified tenant. Global rules match any tenant
+func (r *EventRule) MatchesTenant(tenant string) bool {
+	return r.Tenant == "" || r.Tenant == tenant
+}
+
 // GetTriggerAsString returns the rule trigger as string
 func (r *EventRule) GetTriggerAsString() string {
 	return getTriggerTypeAsString(r.Trigger)
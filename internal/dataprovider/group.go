@@ -33,6 +33,22 @@ type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// Source networks from which the second factor is not required for the
+	// specified protocols
+	TwoFactorAuthExemptions []TwoFactorAuthExemption `json:"two_factor_exemptions,omitempty"`
+	// SSH port forwarding policy, applied to users without a port forwarding
+	// policy if this is their primary group
+	PortForwarding PortForwardingPolicy `json:"port_forwarding,omitempty"`
+	// Per-protocol bandwidth limits, they are added to the user ones
+	ProtocolBandwidthLimits []ProtocolBandwidthLimit `json:"protocol_bandwidth_limits,omitempty"`
+	// Append-only mode, applied to users if this is their primary group
+	AppendOnly bool `json:"append_only,omitempty"`
+	// SSH algorithms policy, the restrictions not defined for a user are
+	// taken from its primary group
+	SSHAlgorithms SSHAlgorithmsPolicy `json:"ssh_algorithms,omitempty"`
+	// IPv4 address to advertise in FTP passive mode responses, applied to
+	// users without a passive IP if this is their primary group
+	FTPPassiveIP string `json:"ftp_passive_ip,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -43,6 +59,8 @@ type Group struct {
 	UserSettings GroupUserSettings `json:"user_settings,omitempty"`
 	// Mapping between virtual paths and virtual folders
 	VirtualFolders []vfs.VirtualFolder `json:"virtual_folders,omitempty"`
+	// Tenant the group belongs to, empty means no tenant
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // GetPermissions returns the permissions as list
@@ -183,6 +201,21 @@ func (g *Group) validateUserSettings() error {
 	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
 		return err
 	}
+	if err := validateTwoFactorAuthExemptions(g.UserSettings.TwoFactorAuthExemptions); err != nil {
+		return err
+	}
+	if err := g.UserSettings.PortForwarding.validate(); err != nil {
+		return err
+	}
+	if err := validateProtocolBandwidthLimits(g.UserSettings.ProtocolBandwidthLimits); err != nil {
+		return err
+	}
+	if err := g.UserSettings.SSHAlgorithms.validate(); err != nil {
+		return err
+	}
+	if err := validateFTPPassiveIP(g.UserSettings.FTPPassiveIP); err != nil {
+		return err
+	}
 	if !g.HasExternalAuth() {
 		g.UserSettings.Filters.ExternalAuthCacheTime = 0
 	}
@@ -232,8 +265,15 @@ func (g *Group) getACopy() Group {
 				ExpiresIn:            g.UserSettings.ExpiresIn,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:                g.UserSettings.FsConfig.GetACopy(),
+			TwoFactorAuthExemptions: copyTwoFactorAuthExemptions(g.UserSettings.TwoFactorAuthExemptions),
+			PortForwarding:          g.UserSettings.PortForwarding.getACopy(),
+			ProtocolBandwidthLimits: copyProtocolBandwidthLimits(g.UserSettings.ProtocolBandwidthLimits),
+			AppendOnly:              g.UserSettings.AppendOnly,
+			SSHAlgorithms:           g.UserSettings.SSHAlgorithms.getACopy(),
+			FTPPassiveIP:            g.UserSettings.FTPPassiveIP,
 		},
 		VirtualFolders: virtualFolders,
+		Tenant:         g.Tenant,
 	}
 }
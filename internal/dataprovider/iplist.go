@@ -103,6 +103,7 @@ type IPListEntry struct {
 	// - 2 FTP
 	// - 4 WebDAV
 	// - 8 HTTP
+	// - 16 S3
 	// Protocols can be combined
 	Protocols int    `json:"protocols"`
 	First     []byte `json:"first,omitempty"`
@@ -135,6 +136,8 @@ func (e *IPListEntry) HasProtocol(proto string) bool {
 		return e.Protocols&4 != 0
 	case protocolHTTP:
 		return e.Protocols&8 != 0
+	case protocolS3:
+		return e.Protocols&16 != 0
 	default:
 		return false
 	}
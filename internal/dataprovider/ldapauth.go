@@ -0,0 +1,586 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	ldapUsernamePlaceholder = "%username%"
+	ldapDefaultTimeout      = 10 * time.Second
+)
+
+var (
+	ldapEntries = ldapEntriesCache{
+		entries: make(map[string]ldapCachedEntry),
+	}
+)
+
+// LDAPGroupMapping maps an LDAP group to an SFTPGo group
+type LDAPGroupMapping struct {
+	// Distinguished name of the LDAP group, for example "cn=sftp,ou=groups,dc=example,dc=com"
+	LDAPGroup string `json:"ldap_group" mapstructure:"ldap_group"`
+	// SFTPGo group name
+	Group string `json:"group" mapstructure:"group"`
+	// Group type: 1 primary, 2 secondary, 3 membership only
+	Type int `json:"type" mapstructure:"type"`
+}
+
+// LDAPAuthConfig defines the configuration for the built-in LDAP/Active Directory
+// password authentication. The user is searched using a service account, if configured,
+// and then the password is checked by binding as the found entry.
+// If the authentication succeed the user will be automatically added/updated inside
+// the defined data provider, as for the external authentication hook.
+// In read-through mode LDAP is the source of truth for the users: they are read from
+// the directory for any login method and the data provider is used as overlay store
+// for the SFTPGo specific settings, for example permissions and filters
+type LDAPAuthConfig struct {
+	// LDAP server URL, for example "ldap://ldap.example.com" or "ldaps://ldap.example.com".
+	// Leave empty to disable LDAP authentication
+	URL string `json:"url" mapstructure:"url"`
+	// Upgrade the plain "ldap" connection using the StartTLS extended operation
+	StartTLS bool `json:"start_tls" mapstructure:"start_tls"`
+	// Don't verify the server certificate, for testing only
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// Paths to additional CA certificates to trust. Relative paths are resolved
+	// against the configuration directory
+	CACertificates []string `json:"ca_certificates" mapstructure:"ca_certificates"`
+	// DN and password of the service account used to search users.
+	// Leave empty to search using an anonymous bind
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// Base DN for user searches
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// Filter used to search the user, the "%username%" placeholder will be replaced
+	// with the escaped login username, for example "(&(objectClass=user)(sAMAccountName=%username%))"
+	SearchFilter string `json:"search_filter" mapstructure:"search_filter"`
+	// If not empty the user must be a member of at least one of these groups (DN)
+	RequiredGroups []string `json:"required_groups" mapstructure:"required_groups"`
+	// Attribute to use as SFTPGo username. Leave empty to use the login username
+	UsernameAttribute string `json:"username_attribute" mapstructure:"username_attribute"`
+	// Attribute containing the group DNs the user is a member of
+	GroupsAttribute string `json:"groups_attribute" mapstructure:"groups_attribute"`
+	// Attribute to map to the SFTPGo user email, leave empty to disable
+	EmailAttribute string `json:"email_attribute" mapstructure:"email_attribute"`
+	// Attribute to map to the SFTPGo user description, leave empty to disable
+	DescriptionAttribute string `json:"description_attribute" mapstructure:"description_attribute"`
+	// Attribute to map to the SFTPGo user home directory, leave empty to disable
+	HomeDirAttribute string `json:"home_dir_attribute" mapstructure:"home_dir_attribute"`
+	// Attribute to map to the SFTPGo user quota size, in bytes, leave empty to disable
+	QuotaSizeAttribute string `json:"quota_size_attribute" mapstructure:"quota_size_attribute"`
+	// Attribute to map to the SFTPGo user quota files, leave empty to disable
+	QuotaFilesAttribute string `json:"quota_files_attribute" mapstructure:"quota_files_attribute"`
+	// Attribute containing the SSH public keys for the user, for example "sshPublicKey".
+	// If set, the public keys stored in LDAP replace the ones defined in SFTPGo
+	PublicKeysAttribute string `json:"public_keys_attribute" mapstructure:"public_keys_attribute"`
+	// Map LDAP groups to SFTPGo groups. The mapped SFTPGo groups are managed
+	// by LDAP: they are added or removed at each login
+	GroupMappings []LDAPGroupMapping `json:"group_mappings" mapstructure:"group_mappings"`
+	// Network timeout in seconds, 0 means the default (10 seconds)
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+	// If enabled, users are read from LDAP for every login method and not only for password
+	// authentication. For public key, keyboard interactive and TLS certificate logins the user
+	// is searched using the service account, users not found in LDAP cannot login
+	ReadThrough bool `json:"read_through" mapstructure:"read_through"`
+	// Time, in seconds, to cache the LDAP entries read in read-through mode. 0 disables the cache
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+
+	tlsConfig *tls.Config
+}
+
+func (c *LDAPAuthConfig) isEnabled() bool {
+	return c.URL != ""
+}
+
+func (c *LDAPAuthConfig) isReadThroughEnabled() bool {
+	return c.isEnabled() && c.ReadThrough
+}
+
+func (c *LDAPAuthConfig) validate(basePath string) error {
+	c.tlsConfig = nil
+	if !c.isEnabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid LDAP URL %q: %w", c.URL, err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return fmt.Errorf("invalid LDAP URL %q: unsupported scheme %q", c.URL, u.Scheme)
+	}
+	if c.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("LDAP StartTLS cannot be used with a ldaps URL")
+	}
+	if c.BaseDN == "" {
+		return errors.New("LDAP base DN is required")
+	}
+	if !strings.Contains(c.SearchFilter, ldapUsernamePlaceholder) {
+		return fmt.Errorf("LDAP search filter %q must contain the %q placeholder", c.SearchFilter, ldapUsernamePlaceholder)
+	}
+	if c.GroupsAttribute == "" && (len(c.RequiredGroups) > 0 || len(c.GroupMappings) > 0) {
+		return errors.New("LDAP groups attribute is required to check group memberships")
+	}
+	if config.ExternalAuthHook != "" {
+		return errors.New("LDAP authentication and external auth hook are mutually exclusive")
+	}
+	for _, m := range c.GroupMappings {
+		if m.LDAPGroup == "" || m.Group == "" {
+			return errors.New("invalid LDAP group mapping: LDAP group and group are required")
+		}
+		if m.Type < sdk.GroupTypePrimary || m.Type > sdk.GroupTypeMembership {
+			return fmt.Errorf("invalid LDAP group mapping type %d for group %q", m.Type, m.Group)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid LDAP timeout %d", c.Timeout)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("invalid LDAP cache TTL %d", c.CacheTTL)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: c.SkipTLSVerify, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+	if len(c.CACertificates) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		for _, ca := range c.CACertificates {
+			caPath := getConfigPath(ca, basePath)
+			certs, err := os.ReadFile(caPath)
+			if err != nil {
+				return fmt.Errorf("unable to load LDAP CA certificate %q: %w", caPath, err)
+			}
+			if !rootCAs.AppendCertsFromPEM(certs) {
+				return fmt.Errorf("unable to add LDAP CA certificate %q", caPath)
+			}
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	c.tlsConfig = tlsConfig
+	return nil
+}
+
+func (c *LDAPAuthConfig) getTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return ldapDefaultTimeout
+}
+
+func (c *LDAPAuthConfig) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(c.URL, ldap.DialWithDialer(&net.Dialer{Timeout: c.getTimeout()}),
+		ldap.DialWithTLSConfig(c.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(c.getTimeout())
+	if c.StartTLS {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// authenticate checks the given credentials and returns the matching LDAP entry
+func (c *LDAPAuthConfig) authenticate(username, password string) (*ldap.Entry, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	if err := c.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	entry, err := c.searchUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("unable to bind as %q: %w", entry.DN, err)
+	}
+	if !c.isMemberOfRequiredGroups(entry) {
+		return nil, fmt.Errorf("LDAP user %q is not a member of the required groups", entry.DN)
+	}
+	ldapEntries.add(username, entry)
+	return entry, nil
+}
+
+// lookup returns the LDAP entry for the given user without checking any credential.
+// The entry is searched using the service account
+func (c *LDAPAuthConfig) lookup(username string) (*ldap.Entry, error) {
+	if entry, ok := ldapEntries.get(username); ok {
+		return entry, nil
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	if err := c.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	entry, err := c.searchUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if !c.isMemberOfRequiredGroups(entry) {
+		return nil, fmt.Errorf("LDAP user %q is not a member of the required groups", entry.DN)
+	}
+	ldapEntries.add(username, entry)
+	return entry, nil
+}
+
+func (c *LDAPAuthConfig) bindServiceAccount(conn *ldap.Conn) error {
+	var err error
+	if c.BindDN != "" {
+		err = conn.Bind(c.BindDN, c.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to bind the LDAP service account: %w", err)
+	}
+	return nil
+}
+
+func (c *LDAPAuthConfig) searchUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(c.SearchFilter, ldapUsernamePlaceholder, ldap.EscapeFilter(username))
+	var attributes []string
+	for _, attr := range []string{c.UsernameAttribute, c.GroupsAttribute, c.EmailAttribute, c.DescriptionAttribute,
+		c.HomeDirAttribute, c.QuotaSizeAttribute, c.QuotaFilesAttribute, c.PublicKeysAttribute} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	req := ldap.NewSearchRequest(c.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(c.getTimeout()/time.Second), false, filter, attributes, nil)
+	result, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("unable to search LDAP user %q: %w", username, err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("LDAP user %q does not exist", username))
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("multiple LDAP entries found for user %q", username)
+	}
+	return result.Entries[0], nil
+}
+
+func (c *LDAPAuthConfig) isMemberOfRequiredGroups(entry *ldap.Entry) bool {
+	if len(c.RequiredGroups) == 0 {
+		return true
+	}
+	memberOf := entry.GetEqualFoldAttributeValues(c.GroupsAttribute)
+	for _, group := range c.RequiredGroups {
+		if containsDN(memberOf, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *LDAPAuthConfig) getUsername(entry *ldap.Entry, username string) string {
+	if c.UsernameAttribute != "" {
+		if val := entry.GetEqualFoldAttributeValue(c.UsernameAttribute); val != "" {
+			return config.convertName(val)
+		}
+	}
+	return username
+}
+
+// applyToUser updates the given user using the attributes of the LDAP entry
+func (c *LDAPAuthConfig) applyToUser(user *User, entry *ldap.Entry) {
+	if c.EmailAttribute != "" {
+		if email := entry.GetEqualFoldAttributeValue(c.EmailAttribute); email != "" {
+			user.Email = email
+		}
+	}
+	if c.DescriptionAttribute != "" {
+		if description := entry.GetEqualFoldAttributeValue(c.DescriptionAttribute); description != "" {
+			user.Description = description
+		}
+	}
+	if c.HomeDirAttribute != "" {
+		if homeDir := entry.GetEqualFoldAttributeValue(c.HomeDirAttribute); homeDir != "" {
+			user.HomeDir = homeDir
+		}
+	}
+	if quotaSize, ok := c.getIntAttribute(entry, c.QuotaSizeAttribute); ok {
+		user.QuotaSize = quotaSize
+	}
+	if quotaFiles, ok := c.getIntAttribute(entry, c.QuotaFilesAttribute); ok {
+		user.QuotaFiles = int(quotaFiles)
+	}
+	if c.PublicKeysAttribute != "" {
+		user.PublicKeys = entry.GetEqualFoldAttributeValues(c.PublicKeysAttribute)
+	}
+	if len(c.GroupMappings) == 0 {
+		return
+	}
+	memberOf := entry.GetEqualFoldAttributeValues(c.GroupsAttribute)
+	groups := make([]sdk.GroupMapping, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		if !c.isMappedGroup(g.Name) {
+			groups = append(groups, g)
+		}
+	}
+	hasPrimaryGroup := false
+	for _, g := range groups {
+		if g.Type == sdk.GroupTypePrimary {
+			hasPrimaryGroup = true
+		}
+	}
+	for _, m := range c.GroupMappings {
+		if !containsDN(memberOf, m.LDAPGroup) {
+			continue
+		}
+		if m.Type == sdk.GroupTypePrimary {
+			if hasPrimaryGroup {
+				continue
+			}
+			hasPrimaryGroup = true
+		}
+		groups = append(groups, sdk.GroupMapping{
+			Name: m.Group,
+			Type: m.Type,
+		})
+	}
+	user.Groups = groups
+}
+
+func (c *LDAPAuthConfig) getIntAttribute(entry *ldap.Entry, attr string) (int64, bool) {
+	if attr == "" {
+		return 0, false
+	}
+	val := entry.GetEqualFoldAttributeValue(attr)
+	if val == "" {
+		return 0, false
+	}
+	res, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || res < 0 {
+		providerLog(logger.LevelWarn, "invalid value %q for LDAP attribute %q, dn %q", val, attr, entry.DN)
+		return 0, false
+	}
+	return res, true
+}
+
+func (c *LDAPAuthConfig) isMappedGroup(name string) bool {
+	for _, m := range c.GroupMappings {
+		if m.Group == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDN(dns []string, dn string) bool {
+	for _, val := range dns {
+		if strings.EqualFold(val, dn) {
+			return true
+		}
+	}
+	return false
+}
+
+func doLDAPAuth(username, password, ip, protocol string) (User, error) {
+	u, mergedUser, err := getUserForHook(username, nil)
+	if err != nil {
+		return u, err
+	}
+	if mergedUser.skipExternalAuth() {
+		return u, nil
+	}
+
+	startTime := time.Now()
+	entry, err := config.LDAPAuth.authenticate(username, password)
+	if err != nil {
+		return u, fmt.Errorf("LDAP auth error for user %q, ip %q, protocol %q, elapsed: %s: %w",
+			username, ip, protocol, time.Since(startTime), err)
+	}
+	providerLog(logger.LevelDebug, "LDAP auth completed for user %q, dn %q, elapsed: %s",
+		username, entry.DN, time.Since(startTime))
+
+	return saveLDAPUser(u, entry, username, password, protocol)
+}
+
+// doLDAPLookup reads the user from LDAP, without checking any credential, and updates
+// the user stored inside the data provider. It is used in read-through mode for the
+// login methods not handled by LDAP
+func doLDAPLookup(username, ip, protocol string) (User, error) {
+	u, mergedUser, err := getUserForHook(username, nil)
+	if err != nil {
+		return u, err
+	}
+	if mergedUser.skipExternalAuth() {
+		return u, nil
+	}
+
+	startTime := time.Now()
+	entry, err := config.LDAPAuth.lookup(username)
+	if err != nil {
+		return u, fmt.Errorf("LDAP lookup error for user %q, ip %q, protocol %q, elapsed: %s: %w",
+			username, ip, protocol, time.Since(startTime), err)
+	}
+	providerLog(logger.LevelDebug, "LDAP lookup completed for user %q, dn %q, elapsed: %s",
+		username, entry.DN, time.Since(startTime))
+
+	return saveLDAPUser(u, entry, username, "", protocol)
+}
+
+// saveLDAPUser adds or updates the user mapped to the given LDAP entry
+func saveLDAPUser(u User, entry *ldap.Entry, username, password, protocol string) (User, error) {
+	var err error
+	// as for the external auth hook, multiple login usernames can be mapped to a single SFTPGo account
+	if mappedUsername := config.LDAPAuth.getUsername(entry, username); mappedUsername != username {
+		u, err = provider.userExists(mappedUsername, "")
+		if err != nil {
+			if !errors.Is(err, util.ErrNotFound) {
+				return u, err
+			}
+			u = User{}
+		}
+		u.Username = mappedUsername
+	}
+	user := u.getACopy()
+	if user.ID == 0 {
+		user.Status = 1
+		user.Permissions = map[string][]string{
+			"/": {PermAny},
+		}
+	}
+	config.LDAPAuth.applyToUser(&user, entry)
+	if user.ID > 0 && password == "" && !isLDAPUserChanged(&u, &user) {
+		// nothing to update, avoid a write for each lookup
+		return u, nil
+	}
+	updateUserFromExtAuthResponse(&user, password, "")
+	if user.ID > 0 {
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		user, err = updateUserAfterExternalAuth(&user)
+		if err == nil {
+			if protocol != protocolWebDAV {
+				swapCachedUser(&user, password)
+			}
+			cachedUserPasswords.Add(user.Username, password, user.Password)
+		}
+		return user, err
+	}
+	err = provider.addUser(&user)
+	if err != nil {
+		return user, err
+	}
+	return provider.userExists(user.Username, "")
+}
+
+func isLDAPUserChanged(oldUser, newUser *User) bool {
+	if oldUser.Email != newUser.Email || oldUser.Description != newUser.Description {
+		return true
+	}
+	if oldUser.HomeDir != newUser.HomeDir {
+		return true
+	}
+	if oldUser.QuotaSize != newUser.QuotaSize || oldUser.QuotaFiles != newUser.QuotaFiles {
+		return true
+	}
+	return !slices.Equal(oldUser.PublicKeys, newUser.PublicKeys) || !slices.Equal(oldUser.Groups, newUser.Groups)
+}
+
+type ldapCachedEntry struct {
+	entry     *ldap.Entry
+	expiresAt time.Time
+}
+
+// ldapEntriesCache caches the LDAP entries read in read-through mode,
+// so the directory is not queried for each login
+type ldapEntriesCache struct {
+	sync.RWMutex
+	entries map[string]ldapCachedEntry
+}
+
+func (c *ldapEntriesCache) add(username string, entry *ldap.Entry) {
+	if !config.LDAPAuth.isReadThroughEnabled() || config.LDAPAuth.CacheTTL <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries[username] = ldapCachedEntry{
+		entry:     entry,
+		expiresAt: time.Now().Add(time.Duration(config.LDAPAuth.CacheTTL) * time.Second),
+	}
+}
+
+func (c *ldapEntriesCache) get(username string) (*ldap.Entry, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.entries[username]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return nil, false
+	}
+	return cached.entry, true
+}
+
+func (c *ldapEntriesCache) remove(username string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.entries, username)
+}
+
+func (c *ldapEntriesCache) cleanup() {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, v := range c.entries {
+		if now.After(v.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+}
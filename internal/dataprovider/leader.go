@@ -0,0 +1,125 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	clusterLeaderTaskName = "@cluster_leader"
+	// the leader renews its lease at each check, another node can take over
+	// if the lease is not renewed within clusterLeaderLeaseDuration
+	clusterLeaderCheckInterval = 30 * time.Second
+	clusterLeaderLeaseDuration = 90 * time.Second
+	// the leader stops acting as such if it cannot renew its lease within
+	// this time, so it stops before another node can take over
+	clusterLeaderValidity = 60 * time.Second
+)
+
+var (
+	clusterLeader = &leaderElection{}
+)
+
+// leaderElection elects a single leader among the nodes sharing the data
+// provider. The leader holds a lease stored as a task, the task version
+// is used to detect if another node took over
+type leaderElection struct {
+	mu        sync.RWMutex
+	isLeader  bool
+	version   int64
+	expiresAt time.Time
+}
+
+func (l *leaderElection) isValid() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.isLeader && l.expiresAt.After(time.Now())
+}
+
+func (l *leaderElection) setLeader(version int64) {
+	if !l.isLeader {
+		providerLog(logger.LevelInfo, "this node is now the cluster leader")
+	}
+	l.isLeader = true
+	l.version = version
+	l.expiresAt = time.Now().Add(clusterLeaderValidity)
+}
+
+func (l *leaderElection) resign(reason string) {
+	if l.isLeader {
+		providerLog(logger.LevelInfo, "this node is no longer the cluster leader: %s", reason)
+	}
+	l.isLeader = false
+	l.version = 0
+	l.expiresAt = time.Time{}
+}
+
+// check renews the lease if this node is the leader, otherwise it tries to
+// acquire the lease if it is expired
+func (l *leaderElection) check() {
+	task, err := provider.getTaskByName(clusterLeaderTaskName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			providerLog(logger.LevelWarn, "unable to get the cluster leader task: %v", err)
+			return
+		}
+		if err := provider.addTask(clusterLeaderTaskName); err != nil {
+			providerLog(logger.LevelDebug, "unable to add the cluster leader task: %v", err)
+			return
+		}
+		task = Task{Name: clusterLeaderTaskName}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isLeader && task.Version == l.version {
+		if err := provider.updateTask(clusterLeaderTaskName, l.version); err != nil {
+			l.resign("unable to renew the lease")
+			return
+		}
+		l.setLeader(l.version + 1)
+		return
+	}
+	if l.isLeader {
+		l.resign("the lease was acquired by another node")
+	}
+	// a newly added task has a fresh timestamp but nobody holds it
+	if task.Version > 0 && util.GetTimeFromMsecSinceEpoch(task.UpdateAt).Add(clusterLeaderLeaseDuration).After(time.Now()) {
+		return
+	}
+	if err := provider.updateTask(clusterLeaderTaskName, task.Version); err != nil {
+		providerLog(logger.LevelDebug, "unable to acquire the cluster leader lease: %v", err)
+		return
+	}
+	l.setLeader(task.Version + 1)
+}
+
+// IsClusterLeader returns true if this node must run the tasks that have to
+// be executed once in a cluster. Without a shared data provider each node is
+// the leader
+func IsClusterLeader() bool {
+	if config.IsShared != 1 {
+		return true
+	}
+	return clusterLeader.isValid()
+}
@@ -17,12 +17,14 @@ package dataprovider
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -76,12 +78,20 @@ type memoryProviderHandle struct {
 	roles map[string]Role
 	// slice with ordered roles
 	roleNames []string
+	// map for tenants, name is the key
+	tenants map[string]Tenant
+	// slice with ordered tenants
+	tenantNames []string
+	// audit log entries ordered by ID
+	auditLogs []AuditLogEntry
 	// map for IP List entry
 	ipListEntries map[string]IPListEntry
 	// slice with ordered IP list entries
 	ipListEntriesKeys []string
 	// configurations
 	configs Configs
+	// map for shared sessions, the session key is the key
+	sessions map[string]Session
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -118,9 +128,13 @@ func initializeMemoryProvider(basePath string) error {
 			rulesNames:        []string{},
 			roles:             map[string]Role{},
 			roleNames:         []string{},
+			tenants:           map[string]Tenant{},
+			tenantNames:       []string{},
+			auditLogs:         []AuditLogEntry{},
 			ipListEntries:     map[string]IPListEntry{},
 			ipListEntriesKeys: []string{},
 			configs:           Configs{},
+			sessions:          make(map[string]Session),
 			configFile:        configFile,
 		},
 	}
@@ -236,6 +250,22 @@ func (p *MemoryProvider) updateLastLogin(username string) error {
 	return nil
 }
 
+func (p *MemoryProvider) updateAdminPassword(username, password string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	admin, err := p.adminExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	admin.Password = password
+	admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.admins[admin.Username] = admin
+	return nil
+}
+
 func (p *MemoryProvider) updateAdminLastLogin(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -606,7 +636,7 @@ func (p *MemoryProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User,
 	return users, nil
 }
 
-func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *MemoryProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -620,6 +650,9 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 	itNum := 0
 	if order == OrderASC {
 		for _, username := range p.dbHandle.usernames {
+			if tenant != "" && p.dbHandle.users[username].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -638,11 +671,14 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 		}
 	} else {
 		for i := len(p.dbHandle.usernames) - 1; i >= 0; i-- {
+			username := p.dbHandle.usernames[i]
+			if tenant != "" && p.dbHandle.users[username].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			username := p.dbHandle.usernames[i]
 			u := p.dbHandle.users[username]
 			user := u.getACopy()
 			if !user.hasRole(role) {
@@ -867,7 +903,7 @@ func (p *MemoryProvider) dumpAdmins() ([]Admin, error) {
 	return admins, nil
 }
 
-func (p *MemoryProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+func (p *MemoryProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
 	admins := make([]Admin, 0, limit)
 
 	p.dbHandle.Lock()
@@ -882,6 +918,9 @@ func (p *MemoryProvider) getAdmins(limit int, offset int, order string) ([]Admin
 	itNum := 0
 	if order == OrderASC {
 		for _, username := range p.dbHandle.adminsUsernames {
+			if tenant != "" && p.dbHandle.admins[username].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -896,11 +935,14 @@ func (p *MemoryProvider) getAdmins(limit int, offset int, order string) ([]Admin
 		}
 	} else {
 		for i := len(p.dbHandle.adminsUsernames) - 1; i >= 0; i-- {
+			username := p.dbHandle.adminsUsernames[i]
+			if tenant != "" && p.dbHandle.admins[username].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			username := p.dbHandle.adminsUsernames[i]
 			a := p.dbHandle.admins[username]
 			admin := a.getACopy()
 			admin.HideConfidentialData()
@@ -937,7 +979,7 @@ func (p *MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd in
 	return nil
 }
 
-func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]Group, error) {
+func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool, tenant string) ([]Group, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -950,6 +992,9 @@ func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]G
 	itNum := 0
 	if order == OrderASC {
 		for _, name := range p.dbHandle.groupnames {
+			if tenant != "" && p.dbHandle.groups[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -965,11 +1010,14 @@ func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]G
 		}
 	} else {
 		for i := len(p.dbHandle.groupnames) - 1; i >= 0; i-- {
+			name := p.dbHandle.groupnames[i]
+			if tenant != "" && p.dbHandle.groups[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.groupnames[i]
 			g := p.dbHandle.groups[name]
 			group := g.getACopy()
 			p.addVirtualFoldersToGroup(&group)
@@ -1446,7 +1494,7 @@ func (p *MemoryProvider) folderExistsInternal(name string) (vfs.BaseVirtualFolde
 	return vfs.BaseVirtualFolder{}, util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
 }
 
-func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]vfs.BaseVirtualFolder, error) {
+func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -1460,6 +1508,9 @@ func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]
 	itNum := 0
 	if order == OrderASC {
 		for _, name := range p.dbHandle.vfoldersNames {
+			if tenant != "" && p.dbHandle.vfolders[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -1474,11 +1525,14 @@ func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]
 		}
 	} else {
 		for i := len(p.dbHandle.vfoldersNames) - 1; i >= 0; i-- {
+			name := p.dbHandle.vfoldersNames[i]
+			if tenant != "" && p.dbHandle.vfolders[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.vfoldersNames[i]
 			f := p.dbHandle.vfolders[name]
 			folder := f.GetACopy()
 			folder.PrepareForRendering()
@@ -1885,6 +1939,7 @@ func (p *MemoryProvider) addShare(share *Share) error {
 		share.UpdatedAt = share.CreatedAt
 		share.LastUseAt = 0
 		share.UsedTokens = 0
+		share.Stats = ShareStats{}
 	}
 	if share.CreatedAt == 0 {
 		share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
@@ -1922,6 +1977,7 @@ func (p *MemoryProvider) updateShare(share *Share) error {
 		share.UsedTokens = s.UsedTokens
 		share.CreatedAt = s.CreatedAt
 		share.LastUseAt = s.LastUseAt
+		share.Stats = s.Stats.getACopy()
 		share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	}
 	if share.CreatedAt == 0 {
@@ -2033,6 +2089,21 @@ func (p *MemoryProvider) updateShareLastUse(shareID string, numTokens int) error
 	return nil
 }
 
+func (p *MemoryProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	share, err := p.shareExistsInternal(shareID, "")
+	if err != nil {
+		return err
+	}
+	share.Stats.update(ipAddress, filePaths)
+	p.dbHandle.shares[share.ShareID] = share
+	return nil
+}
+
 func (p *MemoryProvider) getDefenderHosts(_ int64, _ int) ([]DefenderEntry, error) {
 	return nil, ErrNotImplemented
 }
@@ -2085,20 +2156,77 @@ func (p *MemoryProvider) getActiveTransfers(_ time.Time) ([]ActiveTransfer, erro
 	return nil, ErrNotImplemented
 }
 
-func (p *MemoryProvider) addSharedSession(_ Session) error {
-	return ErrNotImplemented
+func (p *MemoryProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	session.Data = data
+	p.dbHandle.sessions[session.Key] = session
+	return nil
 }
 
-func (p *MemoryProvider) deleteSharedSession(_ string) error {
-	return ErrNotImplemented
+func (p *MemoryProvider) deleteSharedSession(key string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.sessions[key]; !ok {
+		return util.NewRecordNotFoundError("no session deleted")
+	}
+	delete(p.dbHandle.sessions, key)
+	return nil
 }
 
-func (p *MemoryProvider) getSharedSession(_ string) (Session, error) {
-	return Session{}, ErrNotImplemented
+func (p *MemoryProvider) getSharedSession(key string) (Session, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return Session{}, errMemoryProviderClosed
+	}
+	session, ok := p.dbHandle.sessions[key]
+	if !ok {
+		return Session{}, util.NewRecordNotFoundError(fmt.Sprintf("session %q does not exist", key))
+	}
+	return session, nil
 }
 
-func (p *MemoryProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
-	return ErrNotImplemented
+func (p *MemoryProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	var sessions []Session
+	for key, session := range p.dbHandle.sessions {
+		if session.Type == sessionType && strings.HasPrefix(key, keyPrefix) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (p *MemoryProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for key, session := range p.dbHandle.sessions {
+		if session.Type == sessionType && session.Timestamp < before {
+			delete(p.dbHandle.sessions, key)
+		}
+	}
+	return nil
 }
 
 func (p *MemoryProvider) getEventActions(limit, offset int, order string, _ bool) ([]BaseEventAction, error) {
@@ -2251,7 +2379,7 @@ func (p *MemoryProvider) deleteEventAction(action BaseEventAction) error {
 	return nil
 }
 
-func (p *MemoryProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
+func (p *MemoryProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -2264,6 +2392,9 @@ func (p *MemoryProvider) getEventRules(limit, offset int, order string) ([]Event
 	rules := make([]EventRule, 0, limit)
 	if order == OrderASC {
 		for _, name := range p.dbHandle.rulesNames {
+			if tenant != "" && p.dbHandle.rules[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -2279,11 +2410,14 @@ func (p *MemoryProvider) getEventRules(limit, offset int, order string) ([]Event
 		}
 	} else {
 		for i := len(p.dbHandle.rulesNames) - 1; i >= 0; i-- {
+			name := p.dbHandle.rulesNames[i]
+			if tenant != "" && p.dbHandle.rules[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.rulesNames[i]
 			r := p.dbHandle.rules[name]
 			rule := r.getACopy()
 			p.addActionsToRule(&rule)
@@ -2586,7 +2720,7 @@ func (p *MemoryProvider) deleteRole(role Role) error {
 	return nil
 }
 
-func (p *MemoryProvider) getRoles(limit int, offset int, order string, _ bool) ([]Role, error) {
+func (p *MemoryProvider) getRoles(limit int, offset int, order string, _ bool, tenant string) ([]Role, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 
@@ -2600,6 +2734,9 @@ func (p *MemoryProvider) getRoles(limit int, offset int, order string, _ bool) (
 	itNum := 0
 	if order == OrderASC {
 		for _, name := range p.dbHandle.roleNames {
+			if tenant != "" && p.dbHandle.roles[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -2613,11 +2750,14 @@ func (p *MemoryProvider) getRoles(limit int, offset int, order string, _ bool) (
 		}
 	} else {
 		for i := len(p.dbHandle.roleNames) - 1; i >= 0; i-- {
+			name := p.dbHandle.roleNames[i]
+			if tenant != "" && p.dbHandle.roles[name].Tenant != tenant {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.roleNames[i]
 			r := p.dbHandle.roles[name]
 			role := r.getACopy()
 			roles = append(roles, role)
@@ -2644,6 +2784,228 @@ func (p *MemoryProvider) dumpRoles() ([]Role, error) {
 	return roles, nil
 }
 
+func (p *MemoryProvider) tenantExistsInternal(name string) (Tenant, error) {
+	if val, ok := p.dbHandle.tenants[name]; ok {
+		return val.getACopy(), nil
+	}
+	return Tenant{}, util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+}
+
+func (p *MemoryProvider) tenantExists(name string) (Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return Tenant{}, errMemoryProviderClosed
+	}
+	return p.tenantExistsInternal(name)
+}
+
+func (p *MemoryProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	_, err := p.tenantExistsInternal(tenant.Name)
+	if err == nil {
+		return util.NewI18nError(
+			fmt.Errorf("%w: tenant %q already exists", ErrDuplicatedKey, tenant.Name),
+			util.I18nErrorDuplicatedName,
+		)
+	}
+	tenant.ID = p.getNextTenantID()
+	tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.tenants[tenant.Name] = tenant.getACopy()
+	p.dbHandle.tenantNames = append(p.dbHandle.tenantNames, tenant.Name)
+	sort.Strings(p.dbHandle.tenantNames)
+	return nil
+}
+
+func (p *MemoryProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	oldTenant, err := p.tenantExistsInternal(tenant.Name)
+	if err != nil {
+		return err
+	}
+	tenant.ID = oldTenant.ID
+	tenant.CreatedAt = oldTenant.CreatedAt
+	tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.tenants[tenant.Name] = tenant.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) isTenantReferenced(name string) bool {
+	for _, u := range p.dbHandle.users {
+		if u.Tenant == name {
+			return true
+		}
+	}
+	for _, a := range p.dbHandle.admins {
+		if a.Tenant == name {
+			return true
+		}
+	}
+	for _, g := range p.dbHandle.groups {
+		if g.Tenant == name {
+			return true
+		}
+	}
+	for _, f := range p.dbHandle.vfolders {
+		if f.Tenant == name {
+			return true
+		}
+	}
+	for _, r := range p.dbHandle.roles {
+		if r.Tenant == name {
+			return true
+		}
+	}
+	for _, r := range p.dbHandle.rules {
+		if r.Tenant == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MemoryProvider) deleteTenant(tenant Tenant) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	oldTenant, err := p.tenantExistsInternal(tenant.Name)
+	if err != nil {
+		return err
+	}
+	if p.isTenantReferenced(oldTenant.Name) {
+		return util.NewValidationError(fmt.Sprintf("the tenant %q is referenced, it cannot be removed", oldTenant.Name))
+	}
+	delete(p.dbHandle.tenants, oldTenant.Name)
+	p.dbHandle.tenantNames = make([]string, 0, len(p.dbHandle.tenants))
+	for name := range p.dbHandle.tenants {
+		p.dbHandle.tenantNames = append(p.dbHandle.tenantNames, name)
+	}
+	sort.Strings(p.dbHandle.tenantNames)
+	return nil
+}
+
+func (p *MemoryProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	tenants := make([]Tenant, 0, 10)
+	itNum := 0
+	if order == OrderASC {
+		for _, name := range p.dbHandle.tenantNames {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			t := p.dbHandle.tenants[name]
+			tenants = append(tenants, t.getACopy())
+			if len(tenants) >= limit {
+				break
+			}
+		}
+	} else {
+		for i := len(p.dbHandle.tenantNames) - 1; i >= 0; i-- {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			name := p.dbHandle.tenantNames[i]
+			t := p.dbHandle.tenants[name]
+			tenants = append(tenants, t.getACopy())
+			if len(tenants) >= limit {
+				break
+			}
+		}
+	}
+	return tenants, nil
+}
+
+func (p *MemoryProvider) dumpTenants() ([]Tenant, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+
+	tenants := make([]Tenant, 0, len(p.dbHandle.tenants))
+	for _, name := range p.dbHandle.tenantNames {
+		t := p.dbHandle.tenants[name]
+		tenants = append(tenants, t.getACopy())
+	}
+	return tenants, nil
+}
+
+func (p *MemoryProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	entry.ID = int64(len(p.dbHandle.auditLogs) + 1)
+	p.dbHandle.auditLogs = append(p.dbHandle.auditLogs, *entry)
+	return nil
+}
+
+func (p *MemoryProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	entries := make([]AuditLogEntry, 0, limit)
+	if from < 0 {
+		from = 0
+	}
+	// IDs are assigned sequentially starting from 1
+	for idx := from; idx < int64(len(p.dbHandle.auditLogs)); idx++ {
+		if len(entries) >= limit {
+			break
+		}
+		entries = append(entries, p.dbHandle.auditLogs[idx])
+	}
+	return entries, nil
+}
+
+func (p *MemoryProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return AuditLogEntry{}, errMemoryProviderClosed
+	}
+	for idx := len(p.dbHandle.auditLogs) - 1; idx >= 0; idx-- {
+		if p.dbHandle.auditLogs[idx].Node == node {
+			return p.dbHandle.auditLogs[idx], nil
+		}
+	}
+	return AuditLogEntry{}, util.NewRecordNotFoundError(fmt.Sprintf("no audit log entry for node %q", node))
+}
+
 func (p *MemoryProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2969,6 +3331,16 @@ func (p *MemoryProvider) getNextRoleID() int64 {
 	return nextID
 }
 
+func (p *MemoryProvider) getNextTenantID() int64 {
+	nextID := int64(1)
+	for _, t := range p.dbHandle.tenants {
+		if t.ID >= nextID {
+			nextID = t.ID + 1
+		}
+	}
+	return nextID
+}
+
 func (p *MemoryProvider) clear() {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2991,6 +3363,8 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.rulesNames = []string{}
 	p.dbHandle.roles = map[string]Role{}
 	p.dbHandle.roleNames = []string{}
+	p.dbHandle.tenants = map[string]Tenant{}
+	p.dbHandle.tenantNames = []string{}
 	p.dbHandle.ipListEntries = map[string]IPListEntry{}
 	p.dbHandle.ipListEntriesKeys = []string{}
 	p.dbHandle.configs = Configs{}
@@ -3041,6 +3415,10 @@ func (p *MemoryProvider) restoreDump(dump *BackupData) error {
 		return err
 	}
 
+	if err := p.restoreTenants(dump); err != nil {
+		return err
+	}
+
 	if err := p.restoreRoles(dump); err != nil {
 		return err
 	}
@@ -3227,6 +3605,29 @@ func (p *MemoryProvider) restoreIPListEntries(dump *BackupData) error {
 	return nil
 }
 
+func (p *MemoryProvider) restoreTenants(dump *BackupData) error {
+	for idx := range dump.Tenants {
+		tenant := dump.Tenants[idx]
+		tenant.Name = config.convertName(tenant.Name)
+		t, err := p.tenantExists(tenant.Name)
+		if err == nil {
+			tenant.ID = t.ID
+			err = UpdateTenant(&tenant, ActionExecutorSystem, "")
+			if err != nil {
+				providerLog(logger.LevelError, "error updating tenant %q: %v", tenant.Name, err)
+				return err
+			}
+		} else {
+			err = AddTenant(&tenant, ActionExecutorSystem, "")
+			if err != nil {
+				providerLog(logger.LevelError, "error adding tenant %q: %v", tenant.Name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) restoreRoles(dump *BackupData) error {
 	for idx := range dump.Roles {
 		role := dump.Roles[idx]
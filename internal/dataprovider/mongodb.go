@@ -0,0 +1,4000 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nomongodb
+// +build !nomongodb
+
+package dataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	mongoDBDatabaseVersion = 34
+	mongoDBDefaultPort     = 27017
+)
+
+const (
+	mongoCollUsers           = "users"
+	mongoCollGroups          = "groups"
+	mongoCollFolders         = "folders"
+	mongoCollAdmins          = "admins"
+	mongoCollAPIKeys         = "api_keys"
+	mongoCollShares          = "shares"
+	mongoCollActions         = "events_actions"
+	mongoCollRules           = "events_rules"
+	mongoCollRoles           = "roles"
+	mongoCollTenants         = "tenants"
+	mongoCollAuditLogs       = "audit_logs"
+	mongoCollIPLists         = "ip_lists"
+	mongoCollConfigs         = "configs"
+	mongoCollSchemaVersion   = "schema_version"
+	mongoCollSequences       = "sequences"
+	mongoCollDefenderHosts   = "defender_hosts"
+	mongoCollDefenderEvents  = "defender_events"
+	mongoCollActiveTransfers = "active_transfers"
+	mongoCollSharedSessions  = "shared_sessions"
+	mongoCollTasks           = "tasks"
+	mongoCollNodes           = "nodes"
+)
+
+var (
+	mongoCollections = []string{mongoCollUsers, mongoCollGroups, mongoCollFolders, mongoCollAdmins, mongoCollAPIKeys,
+		mongoCollShares, mongoCollActions, mongoCollRules, mongoCollRoles, mongoCollTenants, mongoCollIPLists,
+		mongoCollConfigs, mongoCollSchemaVersion, mongoCollSequences, mongoCollDefenderHosts, mongoCollDefenderEvents,
+		mongoCollActiveTransfers, mongoCollSharedSessions, mongoCollTasks, mongoCollNodes, mongoCollAuditLogs}
+	mongoTenantCollections = []string{mongoCollUsers, mongoCollAdmins, mongoCollGroups, mongoCollFolders,
+		mongoCollRoles, mongoCollRules}
+)
+
+// MongoDBProvider defines the auth provider for MongoDB/DocumentDB database.
+// Multi-document updates are executed inside transactions so a replica set or
+// a sharded cluster is required
+type MongoDBProvider struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// mongoDocument is the stored representation of the provider objects.
+// The objects are serialized as JSON, as for the bolt provider, the
+// other fields are a copy of the attributes used for filtering and indexing
+type mongoDocument struct {
+	Key       string `bson:"_id"`
+	Data      string `bson:"data"`
+	Tenant    string `bson:"tenant,omitempty"`
+	Role      string `bson:"role,omitempty"`
+	Username  string `bson:"username,omitempty"`
+	User      string `bson:"user,omitempty"`
+	Admin     string `bson:"admin,omitempty"`
+	ListType  int    `bson:"list_type,omitempty"`
+	IPOrNet   string `bson:"ipornet,omitempty"`
+	IPType    int    `bson:"ip_type,omitempty"`
+	First     []byte `bson:"first,omitempty"`
+	Last      []byte `bson:"last,omitempty"`
+	UpdatedAt int64  `bson:"updated_at"`
+	DeletedAt int64  `bson:"deleted_at"`
+}
+
+type mongoDefenderHost struct {
+	IP        string `bson:"_id"`
+	UpdatedAt int64  `bson:"updated_at"`
+	BanTime   int64  `bson:"ban_time"`
+}
+
+type mongoActiveTransfer struct {
+	TransferID    int64  `bson:"transfer_id"`
+	ConnID        string `bson:"connection_id"`
+	Type          int    `bson:"transfer_type"`
+	Username      string `bson:"username"`
+	FolderName    string `bson:"folder_name"`
+	IP            string `bson:"ip"`
+	TruncatedSize int64  `bson:"truncated_size"`
+	CurrentULSize int64  `bson:"current_ul_size"`
+	CurrentDLSize int64  `bson:"current_dl_size"`
+	CreatedAt     int64  `bson:"created_at"`
+	UpdatedAt     int64  `bson:"updated_at"`
+}
+
+type mongoSession struct {
+	Key       string      `bson:"_id"`
+	Data      []byte      `bson:"data"`
+	Type      SessionType `bson:"type"`
+	Timestamp int64       `bson:"timestamp"`
+}
+
+type mongoNode struct {
+	Name      string `bson:"_id"`
+	Data      []byte `bson:"data"`
+	CreatedAt int64  `bson:"created_at"`
+	UpdatedAt int64  `bson:"updated_at"`
+}
+
+type mongoAuditLogEntry struct {
+	ID   int64  `bson:"_id"`
+	Node string `bson:"node"`
+	Data string `bson:"data"`
+}
+
+type mongoTask struct {
+	Name      string `bson:"_id"`
+	UpdatedAt int64  `bson:"updated_at"`
+	Version   int64  `bson:"version"`
+}
+
+// mongoBucket wraps a collection and exposes the same primitives used by the
+// bolt provider so the relations between objects are handled the same way
+type mongoBucket struct {
+	ctx       context.Context
+	name      string
+	coll      *mongo.Collection
+	sequences *mongo.Collection
+}
+
+func init() {
+	version.AddFeature("+mongodb")
+}
+
+func initializeMongoDBProvider() error {
+	clientOptions, err := getMongoDBClientOptions()
+	if err != nil {
+		providerLog(logger.LevelError, "error creating mongodb client options: %v", err)
+		return err
+	}
+	if config.Name == "" {
+		return errors.New("mongodb: the database name is mandatory")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err == nil {
+		err = client.Ping(ctx, readpref.Primary())
+	}
+	if err != nil {
+		providerLog(logger.LevelError, "error creating mongodb database handler, hosts: %+v, database: %q, error: %v",
+			clientOptions.Hosts, config.Name, err)
+		if client != nil {
+			client.Disconnect(ctx) //nolint:errcheck
+		}
+		return err
+	}
+	providerLog(logger.LevelDebug, "mongodb database handle created, hosts: %+v, database: %q, pool size: %d",
+		clientOptions.Hosts, config.Name, config.PoolSize)
+	provider = &MongoDBProvider{client: client, db: client.Database(config.Name)}
+	return nil
+}
+
+func getMongoDBClientOptions() (*options.ClientOptions, error) {
+	clientOptions := options.Client()
+	if config.ConnectionString != "" {
+		clientOptions.ApplyURI(config.ConnectionString)
+	} else {
+		port := config.Port
+		if port <= 0 {
+			port = mongoDBDefaultPort
+		}
+		var hosts []string
+		for _, host := range strings.Split(config.Host, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			hosts = append(hosts, host)
+		}
+		clientOptions.SetHosts(hosts)
+		if config.Username != "" {
+			clientOptions.SetAuth(options.Credential{
+				Username: config.Username,
+				Password: config.Password,
+			})
+		}
+		if config.SSLMode > 0 {
+			tlsConfig, err := getMongoDBTLSConfig()
+			if err != nil {
+				return nil, err
+			}
+			clientOptions.SetTLSConfig(tlsConfig)
+		}
+	}
+	if config.PoolSize > 0 {
+		clientOptions.SetMaxPoolSize(uint64(config.PoolSize))
+	}
+	clientOptions.SetConnectTimeout(10 * time.Second)
+	clientOptions.SetServerSelectionTimeout(10 * time.Second)
+	return clientOptions, clientOptions.Validate()
+}
+
+func getMongoDBTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.RootCert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCrt, err := os.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load root certificate %q: %v", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(rootCrt) {
+			return nil, fmt.Errorf("unable to parse root certificate %q", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" && config.ClientKey != "" {
+		tlsCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key pair %q, %q: %v", config.ClientCert, config.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if config.SSLMode == 2 {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	providerLog(logger.LevelInfo, "using custom TLS config, root cert %q, client cert %q, client key %q, skip verify? %v",
+		config.RootCert, config.ClientCert, config.ClientKey, tlsConfig.InsecureSkipVerify)
+	return tlsConfig, nil
+}
+
+func (p *MongoDBProvider) checkAvailability() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return p.client.Ping(ctx, readpref.Primary())
+}
+
+func (p *MongoDBProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
+	var user User
+	if tlsCert == nil {
+		return user, errors.New("TLS certificate cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+}
+
+func (p *MongoDBProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (p *MongoDBProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
+	admin, err := p.adminExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating admin %q: %v", username, err)
+		return admin, err
+	}
+	err = admin.checkUserAndPass(password, ip)
+	return admin, err
+}
+
+func (p *MongoDBProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+}
+
+func (p *MongoDBProvider) updateAPIKeyLastUse(keyID string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		var apiKey APIKey
+		found, err := bucket.getObject(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("key %q does not exist, unable to update last use", keyID))
+		}
+		apiKey.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err = bucket.put(keyID, &apiKey); err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for key %q: %v", keyID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "last use updated for key %q", keyID)
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) setUpdatedAt(username string) {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update updated at", username))
+		}
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "updated at set for user %q", username)
+		setLastUserUpdate()
+	} else {
+		providerLog(logger.LevelWarn, "error setting updated_at for user %q: %v", username, err)
+	}
+}
+
+func (p *MongoDBProvider) updateLastLogin(username string) error {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update last login", username))
+		}
+		user.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last login for user %q: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "last login updated for user %q", username)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) updateAdminPassword(username, password string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		var admin Admin
+		found, err := bucket.getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist", username))
+		}
+		admin.Password = password
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &admin)
+	})
+}
+
+func (p *MongoDBProvider) updateAdminLastLogin(username string) error {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		var admin Admin
+		found, err := bucket.getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %q does not exist, unable to update last login", username))
+		}
+		admin.LastLogin = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &admin)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last login for admin %q: %v", username, err)
+	} else {
+		providerLog(logger.LevelDebug, "last login updated for admin %q", username)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update transfer quota",
+				username))
+		}
+		if !reset {
+			user.UsedUploadDataTransfer += uploadSize
+			user.UsedDownloadDataTransfer += downloadSize
+		} else {
+			user.UsedUploadDataTransfer = uploadSize
+			user.UsedDownloadDataTransfer = downloadSize
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		err = bucket.put(username, &user)
+		providerLog(logger.LevelDebug, "transfer quota updated for user %q, ul increment: %v dl increment: %v is reset? %v",
+			username, uploadSize, downloadSize, reset)
+		return err
+	})
+}
+
+func (p *MongoDBProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update quota", username))
+		}
+		if reset {
+			user.UsedQuotaSize = sizeAdd
+			user.UsedQuotaFiles = filesAdd
+		} else {
+			user.UsedQuotaSize += sizeAdd
+			user.UsedQuotaFiles += filesAdd
+		}
+		user.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		err = bucket.put(username, &user)
+		providerLog(logger.LevelDebug, "quota updated for user %q, files increment: %v size increment: %v is reset? %v",
+			username, filesAdd, sizeAdd, reset)
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getUsedQuota(username string) (int, int64, int64, int64, error) {
+	user, err := p.userExists(username, "")
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, err
+}
+
+func (p *MongoDBProvider) adminExists(username string) (Admin, error) {
+	var admin Admin
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollAdmins).getObject(username, &admin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", username))
+		}
+		return nil
+	})
+	return admin, err
+}
+
+func (p *MongoDBProvider) addAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		exists, err := bucket.exists(admin.Username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: admin %q already exists", ErrDuplicatedKey, admin.Username),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		admin.ID = id
+		admin.LastLogin = 0
+		admin.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		sort.Slice(admin.Groups, func(i, j int) bool {
+			return admin.Groups[i].Name < admin.Groups[j].Name
+		})
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		for idx := range admin.Groups {
+			if err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, p.getBucket(ctx, mongoCollRoles)); err != nil {
+			return err
+		}
+		return bucket.put(admin.Username, admin)
+	})
+}
+
+func (p *MongoDBProvider) updateAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		rolesBucket := p.getBucket(ctx, mongoCollRoles)
+		var oldAdmin Admin
+		found, err := bucket.getObject(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
+			return err
+		}
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.addAdminToRole(admin.Username, admin.Role, rolesBucket); err != nil {
+			return err
+		}
+		sort.Slice(admin.Groups, func(i, j int) bool {
+			return admin.Groups[i].Name < admin.Groups[j].Name
+		})
+		for idx := range admin.Groups {
+			if err = p.addAdminToGroupMapping(admin.Username, admin.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		admin.ID = oldAdmin.ID
+		admin.CreatedAt = oldAdmin.CreatedAt
+		admin.LastLogin = oldAdmin.LastLogin
+		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(admin.Username, admin)
+	})
+}
+
+func (p *MongoDBProvider) deleteAdmin(admin Admin) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		var oldAdmin Admin
+		found, err := bucket.getObject(admin.Username, &oldAdmin)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("admin %v does not exist", admin.Username))
+		}
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		for idx := range oldAdmin.Groups {
+			err = p.removeAdminFromGroupMapping(oldAdmin.Username, oldAdmin.Groups[idx].Name, groupsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, p.getBucket(ctx, mongoCollRoles)); err != nil {
+			return err
+		}
+		if err = p.getBucket(ctx, mongoCollAPIKeys).deleteMany(bson.D{{Key: "admin", Value: admin.Username}}); err != nil {
+			return err
+		}
+		return bucket.delete(admin.Username)
+	})
+}
+
+func (p *MongoDBProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
+	admins := make([]Admin, 0, limit)
+	if limit <= 0 {
+		return admins, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		return bucket.find(mongoFilter(tenant), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var admin Admin
+			if err := json.Unmarshal(data, &admin); err != nil {
+				return err
+			}
+			admin.HideConfidentialData()
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+	return admins, err
+}
+
+func (p *MongoDBProvider) dumpAdmins() ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAdmins)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var admin Admin
+			if err := json.Unmarshal(data, &admin); err != nil {
+				return err
+			}
+			admins = append(admins, admin)
+			return nil
+		})
+	})
+	return admins, err
+}
+
+func (p *MongoDBProvider) userExists(username, role string) (User, error) {
+	var user User
+	err := p.view(func(ctx context.Context) error {
+		data, err := p.getBucket(ctx, mongoCollUsers).get(username)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		user, err = p.joinUserAndFolders(data, p.getBucket(ctx, mongoCollFolders))
+		if err != nil {
+			return err
+		}
+		if !user.hasRole(role) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (p *MongoDBProvider) addUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		doc, err := bucket.getDocument(user.Username)
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			if doc.DeletedAt == 0 {
+				return util.NewI18nError(
+					fmt.Errorf("%w: username %v already exists", ErrDuplicatedKey, user.Username),
+					util.I18nErrorDuplicatedUsername,
+				)
+			}
+			var oldUser User
+			if err = json.Unmarshal([]byte(doc.Data), &oldUser); err != nil {
+				return err
+			}
+			if err = p.deleteUserInternal(ctx, oldUser); err != nil {
+				return err
+			}
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		user.ID = id
+		user.LastQuotaUpdate = 0
+		user.UsedQuotaSize = 0
+		user.UsedQuotaFiles = 0
+		user.UsedUploadDataTransfer = 0
+		user.UsedDownloadDataTransfer = 0
+		user.LastLogin = 0
+		user.FirstDownload = 0
+		user.FirstUpload = 0
+		user.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err := p.addUserToRole(user.Username, user.Role, p.getBucket(ctx, mongoCollRoles)); err != nil {
+			return err
+		}
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		sort.Slice(user.VirtualFolders, func(i, j int) bool {
+			return user.VirtualFolders[i].Name < user.VirtualFolders[j].Name
+		})
+		for idx := range user.VirtualFolders {
+			err = p.addRelationToFolderMapping(user.VirtualFolders[idx].Name, user, nil, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		sort.Slice(user.Groups, func(i, j int) bool {
+			return user.Groups[i].Name < user.Groups[j].Name
+		})
+		for idx := range user.Groups {
+			if err = p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.put(user.Username, user)
+	})
+}
+
+func (p *MongoDBProvider) updateUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	err = p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var oldUser User
+		found, err := bucket.getObject(user.Username, &oldUser)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		if err = p.updateUserRelations(ctx, user, oldUser); err != nil {
+			return err
+		}
+		user.ID = oldUser.ID
+		user.LastQuotaUpdate = oldUser.LastQuotaUpdate
+		user.UsedQuotaSize = oldUser.UsedQuotaSize
+		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
+		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+		user.LastLogin = oldUser.LastLogin
+		user.FirstDownload = oldUser.FirstDownload
+		user.FirstUpload = oldUser.FirstUpload
+		user.CreatedAt = oldUser.CreatedAt
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(user.Username, user)
+	})
+	if err == nil {
+		setLastUserUpdate()
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteUser(user User, softDelete bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		doc, err := bucket.getDocument(user.Username)
+		if err != nil {
+			return err
+		}
+		if doc == nil || (softDelete && doc.DeletedAt > 0) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+		}
+		var oldUser User
+		if err = json.Unmarshal([]byte(doc.Data), &oldUser); err != nil {
+			return err
+		}
+		if softDelete {
+			if err = p.removeUserFromFoldersAndGroups(ctx, oldUser); err != nil {
+				return err
+			}
+			ts := util.GetTimeAsMsSinceEpoch(time.Now())
+			oldUser.VirtualFolders = nil
+			oldUser.Groups = nil
+			oldUser.UpdatedAt = ts
+			return bucket.softDelete(oldUser.Username, &oldUser, ts)
+		}
+		return p.deleteUserInternal(ctx, oldUser)
+	})
+}
+
+func (p *MongoDBProvider) updateUserPassword(username, password string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		user.Password = password
+		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *MongoDBProvider) dumpUsers() ([]User, error) {
+	users := make([]User, 0, 100)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			user, err := p.joinUserAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
+	users := make([]User, 0, 10)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		return bucket.findDocuments(mongoRecentlyUpdatedFilter(after), mongoFindOptions(OrderASC, 0, 0),
+			func(doc *mongoDocument) error {
+				user, err := p.joinUserAndFolders([]byte(doc.Data), foldersBucket)
+				if err != nil {
+					return err
+				}
+				user.DeletedAt = doc.DeletedAt
+				if len(user.Groups) > 0 {
+					groupMapping := make(map[string]Group)
+					for idx := range user.Groups {
+						group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+						if err != nil {
+							continue
+						}
+						groupMapping[group.Name] = group
+					}
+					user.applyGroupSettings(groupMapping)
+				}
+				users = append(users, user)
+				return nil
+			})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
+	users := make([]User, 0, 10)
+	if len(toFetch) == 0 {
+		return users, nil
+	}
+	usernames := make([]string, 0, len(toFetch))
+	for username := range toFetch {
+		usernames = append(usernames, username)
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		filter := append(mongoFilter(""), bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: usernames}}})
+		return bucket.find(filter, mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var user User
+			if err := json.Unmarshal(data, &user); err != nil {
+				return err
+			}
+			if toFetch[user.Username] && len(user.VirtualFolders) > 0 {
+				user.VirtualFolders = p.getUserOrGroupFolders(user.VirtualFolders, foldersBucket)
+			}
+			if len(user.Groups) > 0 {
+				groupMapping := make(map[string]Group)
+				for idx := range user.Groups {
+					group, err := p.groupExistsInternal(user.Groups[idx].Name, groupsBucket)
+					if err != nil {
+						continue
+					}
+					groupMapping[group.Name] = group
+				}
+				user.applyGroupSettings(groupMapping)
+			}
+			user.SetEmptySecretsIfNil()
+			user.PrepareForRendering()
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		filter := mongoFilter(tenant)
+		if role != "" {
+			filter = append(filter, bson.E{Key: "role", Value: role})
+		}
+		return bucket.find(filter, mongoFindOptions(order, offset, limit), func(data []byte) error {
+			user, err := p.joinUserAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			user.PrepareForRendering()
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (p *MongoDBProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var folder vfs.BaseVirtualFolder
+			if err := json.Unmarshal(data, &folder); err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *MongoDBProvider) getFolders(limit, offset int, order string, _ bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, limit)
+	if limit <= 0 {
+		return folders, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		return bucket.find(mongoFilter(tenant), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var folder vfs.BaseVirtualFolder
+			if err := json.Unmarshal(data, &folder); err != nil {
+				return err
+			}
+			folder.PrepareForRendering()
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p *MongoDBProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	err := p.view(func(ctx context.Context) error {
+		var err error
+		folder, err = p.folderExistsInternal(name, p.getBucket(ctx, mongoCollFolders))
+		return err
+	})
+	return folder, err
+}
+
+func (p *MongoDBProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		exists, err := bucket.exists(folder.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: folder %q already exists", ErrDuplicatedKey, folder.Name),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		folder.ID = id
+		folder.Users = nil
+		folder.Groups = nil
+		return bucket.put(folder.Name, folder)
+	})
+}
+
+func (p *MongoDBProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	err := ValidateFolder(folder)
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		var oldFolder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(folder.Name, &oldFolder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+		}
+		folder.ID = oldFolder.ID
+		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+		folder.Users = oldFolder.Users
+		folder.Groups = oldFolder.Groups
+		return bucket.put(folder.Name, folder)
+	})
+}
+
+func (p *MongoDBProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		var folder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(baseFolder.Name, &folder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", baseFolder.Name))
+		}
+		usersBucket := p.getBucket(ctx, mongoCollUsers)
+		for _, username := range folder.Users {
+			var user User
+			found, err := usersBucket.getObject(username, &user)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			user.VirtualFolders = mongoRemoveVirtualFolder(user.VirtualFolders, folder.Name)
+			if err = usersBucket.put(user.Username, &user); err != nil {
+				return err
+			}
+		}
+		groupsBucket := p.getBucket(ctx, mongoCollGroups)
+		for _, groupname := range folder.Groups {
+			var group Group
+			found, err := groupsBucket.getObject(groupname, &group)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			group.VirtualFolders = mongoRemoveVirtualFolder(group.VirtualFolders, folder.Name)
+			if err = groupsBucket.put(group.Name, &group); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(folder.Name)
+	})
+}
+
+func (p *MongoDBProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollFolders)
+		var folder vfs.BaseVirtualFolder
+		found, err := bucket.getObject(name, &folder)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist, unable to update quota", name))
+		}
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(folder.Name, &folder)
+	})
+}
+
+func (p *MongoDBProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.getFolderByName(name)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get quota for folder %q error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p *MongoDBProvider) getGroups(limit, offset int, order string, _ bool, tenant string) ([]Group, error) {
+	groups := make([]Group, 0, limit)
+	if limit <= 0 {
+		return groups, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		return bucket.find(mongoFilter(tenant), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			group.PrepareForRendering()
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) getGroupsWithNames(names []string) ([]Group, error) {
+	var groups []Group
+	if len(names) == 0 {
+		return groups, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		filter := append(mongoFilter(""), bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: names}}})
+		return bucket.find(filter, mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) getUsersInGroups(names []string) ([]string, error) {
+	var usernames []string
+	if len(names) == 0 {
+		return usernames, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		filter := append(mongoFilter(""), bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: names}}})
+		return bucket.find(filter, mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var group Group
+			if err := json.Unmarshal(data, &group); err != nil {
+				return err
+			}
+			usernames = append(usernames, group.Users...)
+			return nil
+		})
+	})
+	return usernames, err
+}
+
+func (p *MongoDBProvider) groupExists(name string) (Group, error) {
+	var group Group
+	err := p.view(func(ctx context.Context) error {
+		data, err := p.getBucket(ctx, mongoCollGroups).get(name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+		}
+		group, err = p.joinGroupAndFolders(data, p.getBucket(ctx, mongoCollFolders))
+		return err
+	})
+	return group, err
+}
+
+func (p *MongoDBProvider) addGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		exists, err := bucket.exists(group.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: group %q already exists", ErrDuplicatedKey, group.Name),
+				util.I18nErrorDuplicatedUsername,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		group.ID = id
+		group.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		group.Users = nil
+		group.Admins = nil
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		sort.Slice(group.VirtualFolders, func(i, j int) bool {
+			return group.VirtualFolders[i].Name < group.VirtualFolders[j].Name
+		})
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(group.VirtualFolders[idx].Name, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.put(group.Name, group)
+	})
+}
+
+func (p *MongoDBProvider) updateGroup(group *Group) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		var oldGroup Group
+		found, err := bucket.getObject(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		sort.Slice(group.VirtualFolders, func(i, j int) bool {
+			return group.VirtualFolders[i].Name < group.VirtualFolders[j].Name
+		})
+		for idx := range group.VirtualFolders {
+			err = p.addRelationToFolderMapping(group.VirtualFolders[idx].Name, nil, group, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		group.ID = oldGroup.ID
+		group.CreatedAt = oldGroup.CreatedAt
+		group.Users = oldGroup.Users
+		group.Admins = oldGroup.Admins
+		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(group.Name, group)
+	})
+}
+
+func (p *MongoDBProvider) deleteGroup(group Group) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		var oldGroup Group
+		found, err := bucket.getObject(group.Name, &oldGroup)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+		}
+		if len(oldGroup.Users) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", oldGroup.Name))
+		}
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		for idx := range oldGroup.VirtualFolders {
+			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
+			if err != nil {
+				return err
+			}
+		}
+		adminsBucket := p.getBucket(ctx, mongoCollAdmins)
+		for idx := range oldGroup.Admins {
+			if err = p.removeGroupFromAdminMapping(oldGroup.Name, oldGroup.Admins[idx], adminsBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(group.Name)
+	})
+}
+
+func (p *MongoDBProvider) dumpGroups() ([]Group, error) {
+	groups := make([]Group, 0, 50)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollGroups)
+		foldersBucket := p.getBucket(ctx, mongoCollFolders)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			group, err := p.joinGroupAndFolders(data, foldersBucket)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+func (p *MongoDBProvider) apiKeyExists(keyID string) (APIKey, error) {
+	var apiKey APIKey
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollAPIKeys).getObject(keyID, &apiKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", keyID))
+		}
+		return nil
+	})
+	return apiKey, err
+}
+
+func (p *MongoDBProvider) addAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		exists, err := bucket.exists(apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("API key %v already exists", apiKey.KeyID)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		apiKey.ID = id
+		apiKey.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.LastUseAt = 0
+		if err = p.checkAPIKeyRelations(ctx, apiKey); err != nil {
+			return err
+		}
+		return bucket.put(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *MongoDBProvider) updateAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		var oldAPIKey APIKey
+		found, err := bucket.getObject(apiKey.KeyID, &oldAPIKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		apiKey.ID = oldAPIKey.ID
+		apiKey.KeyID = oldAPIKey.KeyID
+		apiKey.Key = oldAPIKey.Key
+		apiKey.CreatedAt = oldAPIKey.CreatedAt
+		apiKey.LastUseAt = oldAPIKey.LastUseAt
+		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if err = p.checkAPIKeyRelations(ctx, apiKey); err != nil {
+			return err
+		}
+		return bucket.put(apiKey.KeyID, apiKey)
+	})
+}
+
+func (p *MongoDBProvider) deleteAPIKey(apiKey APIKey) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		exists, err := bucket.exists(apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
+		}
+		return bucket.delete(apiKey.KeyID)
+	})
+}
+
+func (p *MongoDBProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+	if limit <= 0 {
+		return apiKeys, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		return bucket.find(mongoFilter(""), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var apiKey APIKey
+			if err := json.Unmarshal(data, &apiKey); err != nil {
+				return err
+			}
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+	return apiKeys, err
+}
+
+func (p *MongoDBProvider) dumpAPIKeys() ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollAPIKeys)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var apiKey APIKey
+			if err := json.Unmarshal(data, &apiKey); err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+			return nil
+		})
+	})
+	return apiKeys, err
+}
+
+func (p *MongoDBProvider) shareExists(shareID, username string) (Share, error) {
+	var share Share
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollShares).getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found || (username != "" && share.Username != username) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", shareID))
+		}
+		return nil
+	})
+	return share, err
+}
+
+func (p *MongoDBProvider) addShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		exists, err := bucket.exists(share.ShareID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("share %q already exists", share.ShareID)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		share.ID = id
+		if !share.IsRestore {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			share.UpdatedAt = share.CreatedAt
+			share.LastUseAt = 0
+			share.UsedTokens = 0
+			share.Stats = ShareStats{}
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		exists, err = p.getBucket(ctx, mongoCollUsers).exists(share.Username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		return bucket.put(share.ShareID, share)
+	})
+}
+
+func (p *MongoDBProvider) updateShare(share *Share) error {
+	if err := share.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		var oldObject Share
+		found, err := bucket.getObject(share.ShareID, &oldObject)
+		if err != nil {
+			return err
+		}
+		if !found || oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		share.ID = oldObject.ID
+		share.ShareID = oldObject.ShareID
+		if !share.IsRestore {
+			share.UsedTokens = oldObject.UsedTokens
+			share.CreatedAt = oldObject.CreatedAt
+			share.LastUseAt = oldObject.LastUseAt
+			share.Stats = oldObject.Stats
+			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.CreatedAt == 0 {
+			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		}
+		if share.UpdatedAt == 0 {
+			share.UpdatedAt = share.CreatedAt
+		}
+		exists, err := p.getBucket(ctx, mongoCollUsers).exists(share.Username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
+		}
+		return bucket.put(share.ShareID, share)
+	})
+}
+
+func (p *MongoDBProvider) deleteShare(share Share) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		var oldObject Share
+		found, err := bucket.getObject(share.ShareID, &oldObject)
+		if err != nil {
+			return err
+		}
+		if !found || oldObject.Username != share.Username {
+			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
+		}
+		return bucket.delete(share.ShareID)
+	})
+}
+
+func (p *MongoDBProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+	if limit <= 0 {
+		return shares, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		filter := append(mongoFilter(""), bson.E{Key: "username", Value: username})
+		return bucket.find(filter, mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var share Share
+			if err := json.Unmarshal(data, &share); err != nil {
+				return err
+			}
+			share.HideConfidentialData()
+			shares = append(shares, share)
+			return nil
+		})
+	})
+	return shares, err
+}
+
+func (p *MongoDBProvider) dumpShares() ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var share Share
+			if err := json.Unmarshal(data, &share); err != nil {
+				return err
+			}
+			shares = append(shares, share)
+			return nil
+		})
+	})
+	return shares, err
+}
+
+func (p *MongoDBProvider) updateShareLastUse(shareID string, numTokens int) error {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		var share Share
+		found, err := bucket.getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update last use", shareID))
+		}
+		share.LastUseAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		share.UsedTokens += numTokens
+		return bucket.put(shareID, &share)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating last use for share %q: %v", shareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "last use updated for share %q", shareID)
+	return nil
+}
+
+func (p *MongoDBProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollShares)
+		var share Share
+		found, err := bucket.getObject(shareID, &share)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist, unable to update stats", shareID))
+		}
+		share.Stats.update(ipAddress, filePaths)
+		return bucket.put(shareID, &share)
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating stats for share %q: %v", shareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "stats updated for share %q", shareID)
+	return nil
+}
+
+func (p *MongoDBProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
+	hosts := make([]DefenderEntry, 0, 100)
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "updated_at", Value: bson.D{{Key: "$gte", Value: from}}}},
+			bson.D{{Key: "ban_time", Value: bson.D{{Key: "$gt", Value: 0}}}},
+		}}}
+		opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}).SetLimit(int64(limit))
+		cursor, err := p.getCollection(mongoCollDefenderHosts).Find(ctx, filter, opts)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get defender hosts: %v", err)
+			return err
+		}
+		var rows []mongoDefenderHost
+		if err = cursor.All(ctx, &rows); err != nil {
+			providerLog(logger.LevelError, "unable to iterate over defender hosts: %v", err)
+			return err
+		}
+		for _, row := range rows {
+			hosts = append(hosts, mongoGetDefenderEntry(row))
+		}
+		hosts, err = p.getDefenderHostsWithScores(ctx, hosts, from)
+		return err
+	})
+	return hosts, err
+}
+
+func (p *MongoDBProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	var host DefenderEntry
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.D{
+			{Key: "_id", Value: ip},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "updated_at", Value: bson.D{{Key: "$gte", Value: from}}}},
+				bson.D{{Key: "ban_time", Value: bson.D{{Key: "$gt", Value: 0}}}},
+			}},
+		}
+		var row mongoDefenderHost
+		err := p.getCollection(mongoCollDefenderHosts).FindOne(ctx, filter).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError("host not found")
+			}
+			providerLog(logger.LevelError, "unable to get host for ip %q: %v", ip, err)
+			return err
+		}
+		host = mongoGetDefenderEntry(row)
+		if !host.BanTime.IsZero() {
+			return nil
+		}
+		hosts, err := p.getDefenderHostsWithScores(ctx, []DefenderEntry{host}, from)
+		if err != nil {
+			return err
+		}
+		if len(hosts) == 0 {
+			return util.NewRecordNotFoundError("host not found")
+		}
+		host = hosts[0]
+		return nil
+	})
+	return host, err
+}
+
+func (p *MongoDBProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	var host DefenderEntry
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.D{
+			{Key: "_id", Value: ip},
+			{Key: "ban_time", Value: bson.D{{Key: "$gte", Value: util.GetTimeAsMsSinceEpoch(time.Now())}}},
+		}
+		var row mongoDefenderHost
+		err := p.getCollection(mongoCollDefenderHosts).FindOne(ctx, filter).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError("host not found")
+			}
+			providerLog(logger.LevelError, "unable to check ban status for host %q: %v", ip, err)
+			return err
+		}
+		host = mongoGetDefenderEntry(row)
+		return nil
+	})
+	return host, err
+}
+
+func (p *MongoDBProvider) updateDefenderBanTime(ip string, minutes int) error {
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollDefenderHosts).UpdateOne(ctx, bson.D{{Key: "_id", Value: ip}},
+			bson.D{{Key: "$inc", Value: bson.D{{Key: "ban_time", Value: int64(minutes) * 60000}}}})
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ban time updated for ip %q, increment (minutes): %v", ip, minutes)
+	} else {
+		providerLog(logger.LevelError, "error updating ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteDefenderHost(ip string) error {
+	return p.update(func(ctx context.Context) error {
+		res, err := p.getCollection(mongoCollDefenderHosts).DeleteOne(ctx, bson.D{{Key: "_id", Value: ip}})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to delete defender host %q: %v", ip, err)
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError("no host deleted")
+		}
+		_, err = p.getCollection(mongoCollDefenderEvents).DeleteMany(ctx, bson.D{{Key: "host", Value: ip}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) addDefenderEvent(ip string, score int) error {
+	return p.update(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.getCollection(mongoCollDefenderHosts).UpdateOne(ctx, bson.D{{Key: "_id", Value: ip}},
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "ban_time", Value: int64(0)}}},
+			}, options.Update().SetUpsert(true))
+		if err != nil {
+			providerLog(logger.LevelError, "unable to add defender host %q: %v", ip, err)
+			return err
+		}
+		_, err = p.getCollection(mongoCollDefenderEvents).InsertOne(ctx, bson.D{
+			{Key: "host", Value: ip},
+			{Key: "date_time", Value: now},
+			{Key: "score", Value: score},
+		})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to add defender event for %q: %v", ip, err)
+		}
+		return err
+	})
+}
+
+func (p *MongoDBProvider) setDefenderBanTime(ip string, banTime int64) error {
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollDefenderHosts).UpdateOne(ctx, bson.D{{Key: "_id", Value: ip}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "ban_time", Value: banTime}}}})
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "ip %q banned until %v", ip, util.GetTimeFromMsecSinceEpoch(banTime))
+	} else {
+		providerLog(logger.LevelError, "error setting ban time for ip %q: %v", ip, err)
+	}
+	return err
+}
+
+func (p *MongoDBProvider) cleanupDefender(from int64) error {
+	return p.view(func(ctx context.Context) error {
+		events := p.getCollection(mongoCollDefenderEvents)
+		_, err := events.DeleteMany(ctx, bson.D{{Key: "date_time", Value: bson.D{{Key: "$lt", Value: from}}}})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup defender events: %v", err)
+			return err
+		}
+		activeHosts, err := events.Distinct(ctx, "host", bson.D{{Key: "date_time", Value: bson.D{{Key: "$gt", Value: from}}}})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup defender hosts: %v", err)
+			return err
+		}
+		if activeHosts == nil {
+			activeHosts = bson.A{}
+		}
+		_, err = p.getCollection(mongoCollDefenderHosts).DeleteMany(ctx, bson.D{
+			{Key: "ban_time", Value: bson.D{{Key: "$lt", Value: util.GetTimeAsMsSinceEpoch(time.Now())}}},
+			{Key: "_id", Value: bson.D{{Key: "$nin", Value: activeHosts}}},
+		})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup defender hosts: %v", err)
+		}
+		return err
+	})
+}
+
+func (p *MongoDBProvider) addActiveTransfer(transfer ActiveTransfer) error {
+	return p.view(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.getCollection(mongoCollActiveTransfers).InsertOne(ctx, mongoActiveTransfer{
+			TransferID:    transfer.ID,
+			ConnID:        transfer.ConnID,
+			Type:          transfer.Type,
+			Username:      transfer.Username,
+			FolderName:    transfer.FolderName,
+			IP:            transfer.IP,
+			TruncatedSize: transfer.TruncatedSize,
+			CurrentULSize: transfer.CurrentULSize,
+			CurrentDLSize: transfer.CurrentDLSize,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) updateActiveTransferSizes(ulSize, dlSize, transferID int64, connectionID string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollActiveTransfers).UpdateOne(ctx,
+			bson.D{{Key: "connection_id", Value: connectionID}, {Key: "transfer_id", Value: transferID}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "current_ul_size", Value: ulSize},
+				{Key: "current_dl_size", Value: dlSize},
+				{Key: "updated_at", Value: util.GetTimeAsMsSinceEpoch(time.Now())},
+			}}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) removeActiveTransfer(transferID int64, connectionID string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollActiveTransfers).DeleteOne(ctx,
+			bson.D{{Key: "connection_id", Value: connectionID}, {Key: "transfer_id", Value: transferID}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) cleanupActiveTransfers(before time.Time) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollActiveTransfers).DeleteMany(ctx,
+			bson.D{{Key: "updated_at", Value: bson.D{{Key: "$lt", Value: util.GetTimeAsMsSinceEpoch(before)}}}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getActiveTransfers(from time.Time) ([]ActiveTransfer, error) {
+	transfers := make([]ActiveTransfer, 0, 30)
+	err := p.viewLong(func(ctx context.Context) error {
+		cursor, err := p.getCollection(mongoCollActiveTransfers).Find(ctx,
+			bson.D{{Key: "updated_at", Value: bson.D{{Key: "$gt", Value: util.GetTimeAsMsSinceEpoch(from)}}}})
+		if err != nil {
+			return err
+		}
+		var rows []mongoActiveTransfer
+		if err = cursor.All(ctx, &rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			transfers = append(transfers, ActiveTransfer{
+				ID:            row.TransferID,
+				Type:          row.Type,
+				ConnID:        row.ConnID,
+				Username:      row.Username,
+				FolderName:    row.FolderName,
+				IP:            row.IP,
+				TruncatedSize: row.TruncatedSize,
+				CurrentULSize: row.CurrentULSize,
+				CurrentDLSize: row.CurrentDLSize,
+				CreatedAt:     row.CreatedAt,
+				UpdatedAt:     row.UpdatedAt,
+			})
+		}
+		return nil
+	})
+	return transfers, err
+}
+
+func (p *MongoDBProvider) addSharedSession(session Session) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollSharedSessions).ReplaceOne(ctx, bson.D{{Key: "_id", Value: session.Key}},
+			mongoSession{
+				Key:       session.Key,
+				Data:      data,
+				Type:      session.Type,
+				Timestamp: session.Timestamp,
+			}, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (p *MongoDBProvider) deleteSharedSession(key string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.getCollection(mongoCollSharedSessions).DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return util.NewRecordNotFoundError("no session deleted")
+		}
+		return nil
+	})
+}
+
+func (p *MongoDBProvider) getSharedSession(key string) (Session, error) {
+	var session Session
+	err := p.view(func(ctx context.Context) error {
+		var row mongoSession
+		err := p.getCollection(mongoCollSharedSessions).FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(err.Error())
+			}
+			return err
+		}
+		session = Session{
+			Key:       row.Key,
+			Data:      row.Data,
+			Type:      row.Type,
+			Timestamp: row.Timestamp,
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (p *MongoDBProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	var sessions []Session
+	err := p.view(func(ctx context.Context) error {
+		query := bson.D{{Key: "type", Value: sessionType}}
+		if keyPrefix != "" {
+			query = append(query, bson.E{Key: "_id", Value: bson.D{
+				{Key: "$regex", Value: "^" + regexp.QuoteMeta(keyPrefix)},
+			}})
+		}
+		cursor, err := p.getCollection(mongoCollSharedSessions).Find(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var row mongoSession
+			if err := cursor.Decode(&row); err != nil {
+				return err
+			}
+			sessions = append(sessions, Session{
+				Key:       row.Key,
+				Data:      row.Data,
+				Type:      row.Type,
+				Timestamp: row.Timestamp,
+			})
+		}
+		return cursor.Err()
+	})
+	return sessions, err
+}
+
+func (p *MongoDBProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollSharedSessions).DeleteMany(ctx, bson.D{
+			{Key: "type", Value: sessionType},
+			{Key: "timestamp", Value: bson.D{{Key: "$lt", Value: before}}},
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getEventActions(limit, offset int, order string, _ bool) ([]BaseEventAction, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	actions := make([]BaseEventAction, 0, limit)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollActions)
+		return bucket.find(mongoFilter(""), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var action BaseEventAction
+			if err := json.Unmarshal(data, &action); err != nil {
+				return err
+			}
+			action.PrepareForRendering()
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *MongoDBProvider) dumpEventActions() ([]BaseEventAction, error) {
+	actions := make([]BaseEventAction, 0, 50)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollActions)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var action BaseEventAction
+			if err := json.Unmarshal(data, &action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+			return nil
+		})
+	})
+	return actions, err
+}
+
+func (p *MongoDBProvider) eventActionExists(name string) (BaseEventAction, error) {
+	var action BaseEventAction
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollActions).getObject(name, &action)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %q does not exist", name))
+		}
+		return nil
+	})
+	return action, err
+}
+
+func (p *MongoDBProvider) addEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollActions)
+		exists, err := bucket.exists(action.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: event action %q already exists", ErrDuplicatedKey, action.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		action.ID = id
+		action.Rules = nil
+		return bucket.put(action.Name, action)
+	})
+}
+
+func (p *MongoDBProvider) updateEventAction(action *BaseEventAction) error {
+	err := action.validate()
+	if err != nil {
+		return err
+	}
+	err = p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollActions)
+		var oldAction BaseEventAction
+		found, err := bucket.getObject(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event action %s does not exist", action.Name))
+		}
+		action.ID = oldAction.ID
+		action.Name = oldAction.Name
+		action.Rules = nil
+		rulesBucket := p.getBucket(ctx, mongoCollRules)
+		var relatedRules []string
+		for _, ruleName := range oldAction.Rules {
+			var rule EventRule
+			found, err := rulesBucket.getObject(ruleName, &rule)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			relatedRules = append(relatedRules, ruleName)
+			rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			if err = rulesBucket.put(rule.Name, &rule); err != nil {
+				return err
+			}
+		}
+		action.Rules = relatedRules
+		return bucket.put(action.Name, action)
+	})
+	if err == nil && len(action.Rules) > 0 {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteEventAction(action BaseEventAction) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollActions)
+		var oldAction BaseEventAction
+		found, err := bucket.getObject(action.Name, &oldAction)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("action %s does not exist", action.Name))
+		}
+		if len(oldAction.Rules) > 0 {
+			return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
+		}
+		return bucket.delete(action.Name)
+	})
+}
+
+func (p *MongoDBProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	rules := make([]EventRule, 0, limit)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		return bucket.find(mongoFilter(tenant), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			rule, err := p.joinRuleAndActions(data, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rule.PrepareForRendering()
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) dumpEventRules() ([]EventRule, error) {
+	rules := make([]EventRule, 0, 50)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			rule, err := p.joinRuleAndActions(data, actionsBucket)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
+	rules := make([]EventRule, 0, 10)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		return bucket.findDocuments(mongoRecentlyUpdatedFilter(after), mongoFindOptions(OrderASC, 0, 0),
+			func(doc *mongoDocument) error {
+				rule, err := p.joinRuleAndActions([]byte(doc.Data), actionsBucket)
+				if err != nil {
+					return err
+				}
+				rule.DeletedAt = doc.DeletedAt
+				rules = append(rules, rule)
+				return nil
+			})
+	})
+	return rules, err
+}
+
+func (p *MongoDBProvider) eventRuleExists(name string) (EventRule, error) {
+	var rule EventRule
+	err := p.view(func(ctx context.Context) error {
+		data, err := p.getBucket(ctx, mongoCollRules).get(name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", name))
+		}
+		rule, err = p.joinRuleAndActions(data, p.getBucket(ctx, mongoCollActions))
+		return err
+	})
+	return rule, err
+}
+
+func (p *MongoDBProvider) addEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		doc, err := bucket.getDocument(rule.Name)
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			if doc.DeletedAt == 0 {
+				return util.NewI18nError(
+					fmt.Errorf("%w: event rule %q already exists", ErrDuplicatedKey, rule.Name),
+					util.I18nErrorDuplicatedName,
+				)
+			}
+			if err = bucket.delete(rule.Name); err != nil {
+				return err
+			}
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		rule.ID = id
+		rule.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		rule.UpdatedAt = rule.CreatedAt
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.put(rule.Name, rule)
+	})
+	if err == nil {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *MongoDBProvider) updateEventRule(rule *EventRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	err := p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		var oldRule EventRule
+		found, err := bucket.getObject(rule.Name, &oldRule)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		for idx := range rule.Actions {
+			if err = p.addRuleToActionMapping(rule.Name, rule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		rule.ID = oldRule.ID
+		rule.CreatedAt = oldRule.CreatedAt
+		rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		sort.Slice(rule.Actions, func(i, j int) bool {
+			return rule.Actions[i].Order < rule.Actions[j].Order
+		})
+		return bucket.put(rule.Name, rule)
+	})
+	if err == nil {
+		setLastRuleUpdate()
+	}
+	return err
+}
+
+func (p *MongoDBProvider) deleteEventRule(rule EventRule, softDelete bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRules)
+		doc, err := bucket.getDocument(rule.Name)
+		if err != nil {
+			return err
+		}
+		if doc == nil || (softDelete && doc.DeletedAt > 0) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("event rule %q does not exist", rule.Name))
+		}
+		var oldRule EventRule
+		if err = json.Unmarshal([]byte(doc.Data), &oldRule); err != nil {
+			return err
+		}
+		actionsBucket := p.getBucket(ctx, mongoCollActions)
+		for idx := range oldRule.Actions {
+			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
+				return err
+			}
+		}
+		if softDelete {
+			ts := util.GetTimeAsMsSinceEpoch(time.Now())
+			oldRule.Actions = nil
+			oldRule.UpdatedAt = ts
+			return bucket.softDelete(oldRule.Name, &oldRule, ts)
+		}
+		if err = bucket.delete(rule.Name); err != nil {
+			return err
+		}
+		_, err = p.getCollection(mongoCollTasks).DeleteOne(ctx, bson.D{{Key: "_id", Value: rule.Name}})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getTaskByName(name string) (Task, error) {
+	task := Task{
+		Name: name,
+	}
+	err := p.view(func(ctx context.Context) error {
+		var row mongoTask
+		err := p.getCollection(mongoCollTasks).FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(err.Error())
+			}
+			return err
+		}
+		task.UpdateAt = row.UpdatedAt
+		task.Version = row.Version
+		return nil
+	})
+	return task, err
+}
+
+func (p *MongoDBProvider) addTask(name string) error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollTasks).InsertOne(ctx, mongoTask{
+			Name:      name,
+			UpdatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+			Version:   0,
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) updateTask(name string, version int64) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.getCollection(mongoCollTasks).UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: name}, {Key: "version", Value: version}},
+			bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+				{Key: "$set", Value: bson.D{{Key: "updated_at", Value: util.GetTimeAsMsSinceEpoch(time.Now())}}},
+			})
+		if err != nil {
+			return err
+		}
+		return mongoRequireMatch(res)
+	})
+}
+
+func (p *MongoDBProvider) updateTaskTimestamp(name string) error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.getCollection(mongoCollTasks).UpdateOne(ctx, bson.D{{Key: "_id", Value: name}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: util.GetTimeAsMsSinceEpoch(time.Now())}}}})
+		if err != nil {
+			return err
+		}
+		return mongoRequireMatch(res)
+	})
+}
+
+func (p *MongoDBProvider) addNode() error {
+	if err := currentNode.validate(); err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	data, err := json.Marshal(currentNode.Data)
+	if err != nil {
+		return err
+	}
+	err = p.view(func(ctx context.Context) error {
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		_, err := p.getCollection(mongoCollNodes).ReplaceOne(ctx, bson.D{{Key: "_id", Value: currentNode.Name}},
+			mongoNode{
+				Name:      currentNode.Name,
+				Data:      data,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}, options.Replace().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to register cluster node: %w", err)
+	}
+	providerLog(logger.LevelInfo, "registered as cluster node %q, port: %d, proto: %s",
+		currentNode.Name, currentNode.Data.Port, currentNode.Data.Proto)
+	return nil
+}
+
+func (p *MongoDBProvider) getNodeByName(name string) (Node, error) {
+	var node Node
+	err := p.view(func(ctx context.Context) error {
+		var row mongoNode
+		filter := bson.D{
+			{Key: "_id", Value: name},
+			{Key: "updated_at", Value: bson.D{{Key: "$gt", Value: util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))}}},
+		}
+		err := p.getCollection(mongoCollNodes).FindOne(ctx, filter).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(err.Error())
+			}
+			return err
+		}
+		node, err = mongoGetNode(row)
+		return err
+	})
+	return node, err
+}
+
+func (p *MongoDBProvider) getNodes() ([]Node, error) {
+	var nodes []Node
+	err := p.view(func(ctx context.Context) error {
+		filter := bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$ne", Value: currentNode.Name}}},
+			{Key: "updated_at", Value: bson.D{{Key: "$gt", Value: util.GetTimeAsMsSinceEpoch(time.Now().Add(activeNodeTimeDiff))}}},
+		}
+		cursor, err := p.getCollection(mongoCollNodes).Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var rows []mongoNode
+		if err = cursor.All(ctx, &rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			node, err := mongoGetNode(row)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+func (p *MongoDBProvider) updateNodeTimestamp() error {
+	return p.view(func(ctx context.Context) error {
+		res, err := p.getCollection(mongoCollNodes).UpdateOne(ctx, bson.D{{Key: "_id", Value: currentNode.Name}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: util.GetTimeAsMsSinceEpoch(time.Now())}}}})
+		if err != nil {
+			return err
+		}
+		return mongoRequireMatch(res)
+	})
+}
+
+func (p *MongoDBProvider) cleanupNodes() error {
+	return p.view(func(ctx context.Context) error {
+		_, err := p.getCollection(mongoCollNodes).DeleteMany(ctx, bson.D{
+			{Key: "updated_at", Value: bson.D{{Key: "$lt", Value: util.GetTimeAsMsSinceEpoch(time.Now().Add(10 * activeNodeTimeDiff))}}},
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) roleExists(name string) (Role, error) {
+	var role Role
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollRoles).getObject(name, &role)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", name))
+		}
+		return nil
+	})
+	return role, err
+}
+
+func (p *MongoDBProvider) addRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRoles)
+		exists, err := bucket.exists(role.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: role %q already exists", ErrDuplicatedKey, role.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		role.ID = id
+		role.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = nil
+		role.Admins = nil
+		return bucket.put(role.Name, role)
+	})
+}
+
+func (p *MongoDBProvider) updateRole(role *Role) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRoles)
+		var oldRole Role
+		found, err := bucket.getObject(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		role.ID = oldRole.ID
+		role.CreatedAt = oldRole.CreatedAt
+		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.Users = oldRole.Users
+		role.Admins = oldRole.Admins
+		return bucket.put(role.Name, role)
+	})
+}
+
+func (p *MongoDBProvider) deleteRole(role Role) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRoles)
+		var oldRole Role
+		found, err := bucket.getObject(role.Name, &oldRole)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("role %q does not exist", role.Name))
+		}
+		if len(oldRole.Admins) > 0 {
+			return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
+		}
+		usersBucket := p.getBucket(ctx, mongoCollUsers)
+		for _, username := range oldRole.Users {
+			if err = p.removeRoleFromUser(username, oldRole.Name, usersBucket); err != nil {
+				return err
+			}
+		}
+		return bucket.delete(role.Name)
+	})
+}
+
+func (p *MongoDBProvider) getRoles(limit int, offset int, order string, _ bool, tenant string) ([]Role, error) {
+	roles := make([]Role, 0, limit)
+	if limit <= 0 {
+		return roles, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRoles)
+		return bucket.find(mongoFilter(tenant), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var role Role
+			if err := json.Unmarshal(data, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *MongoDBProvider) dumpRoles() ([]Role, error) {
+	roles := make([]Role, 0, 10)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollRoles)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var role Role
+			if err := json.Unmarshal(data, &role); err != nil {
+				return err
+			}
+			roles = append(roles, role)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (p *MongoDBProvider) tenantExists(name string) (Tenant, error) {
+	var tenant Tenant
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollTenants).getObject(name, &tenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", name))
+		}
+		return nil
+	})
+	return tenant, err
+}
+
+func (p *MongoDBProvider) addTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollTenants)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return util.NewI18nError(
+				fmt.Errorf("%w: tenant %q already exists", ErrDuplicatedKey, tenant.Name),
+				util.I18nErrorDuplicatedName,
+			)
+		}
+		id, err := bucket.nextSequence()
+		if err != nil {
+			return err
+		}
+		tenant.ID = id
+		tenant.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(tenant.Name, tenant)
+	})
+}
+
+func (p *MongoDBProvider) updateTenant(tenant *Tenant) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollTenants)
+		var oldTenant Tenant
+		found, err := bucket.getObject(tenant.Name, &oldTenant)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		tenant.ID = oldTenant.ID
+		tenant.CreatedAt = oldTenant.CreatedAt
+		tenant.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(tenant.Name, tenant)
+	})
+}
+
+func (p *MongoDBProvider) deleteTenant(tenant Tenant) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollTenants)
+		exists, err := bucket.exists(tenant.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return util.NewRecordNotFoundError(fmt.Sprintf("tenant %q does not exist", tenant.Name))
+		}
+		for _, name := range mongoTenantCollections {
+			count, err := p.getCollection(name).CountDocuments(ctx, bson.D{{Key: "tenant", Value: tenant.Name}},
+				options.Count().SetLimit(1))
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return util.NewValidationError(fmt.Sprintf("the tenant %q is referenced, it cannot be removed",
+					tenant.Name))
+			}
+		}
+		return bucket.delete(tenant.Name)
+	})
+}
+
+func (p *MongoDBProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, limit)
+	if limit <= 0 {
+		return tenants, nil
+	}
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollTenants)
+		return bucket.find(mongoFilter(""), mongoFindOptions(order, offset, limit), func(data []byte) error {
+			var tenant Tenant
+			if err := json.Unmarshal(data, &tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *MongoDBProvider) dumpTenants() ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollTenants)
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var tenant Tenant
+			if err := json.Unmarshal(data, &tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, tenant)
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (p *MongoDBProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		id, err := p.getBucket(ctx, mongoCollAuditLogs).nextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = p.getCollection(mongoCollAuditLogs).InsertOne(ctx, &mongoAuditLogEntry{
+			ID:   entry.ID,
+			Node: entry.Node,
+			Data: string(data),
+		})
+		return err
+	})
+}
+
+func (p *MongoDBProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	entries := make([]AuditLogEntry, 0, limit)
+	err := p.viewLong(func(ctx context.Context) error {
+		cursor, err := p.getCollection(mongoCollAuditLogs).Find(ctx,
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: from}}}}, mongoFindOptions(OrderASC, 0, limit))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var row mongoAuditLogEntry
+			if err := cursor.Decode(&row); err != nil {
+				return err
+			}
+			var entry AuditLogEntry
+			if err := json.Unmarshal([]byte(row.Data), &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return cursor.Err()
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	var entry AuditLogEntry
+	err := p.view(func(ctx context.Context) error {
+		var row mongoAuditLogEntry
+		err := p.getCollection(mongoCollAuditLogs).FindOne(ctx, bson.D{{Key: "node", Value: node}},
+			options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&row)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("no audit log entry for node %q", node))
+			}
+			return err
+		}
+		return json.Unmarshal([]byte(row.Data), &entry)
+	})
+	return entry, err
+}
+
+func (p *MongoDBProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
+	entry := IPListEntry{
+		IPOrNet: ipOrNet,
+		Type:    listType,
+	}
+	err := p.view(func(ctx context.Context) error {
+		found, err := p.getBucket(ctx, mongoCollIPLists).getObject(entry.getKey(), &entry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.PrepareForRendering()
+		return nil
+	})
+	return entry, err
+}
+
+func (p *MongoDBProvider) addIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		doc, err := bucket.getDocument(entry.getKey())
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			if doc.DeletedAt == 0 {
+				return util.NewI18nError(
+					fmt.Errorf("%w: entry %q already exists", ErrDuplicatedKey, entry.IPOrNet),
+					util.I18nErrorDuplicatedIPNet,
+				)
+			}
+			if err = bucket.delete(entry.getKey()); err != nil {
+				return err
+			}
+		}
+		entry.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(entry.getKey(), entry)
+	})
+}
+
+func (p *MongoDBProvider) updateIPListEntry(entry *IPListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		var oldEntry IPListEntry
+		found, err := bucket.getObject(entry.getKey(), &oldEntry)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		entry.CreatedAt = oldEntry.CreatedAt
+		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(entry.getKey(), entry)
+	})
+}
+
+func (p *MongoDBProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		doc, err := bucket.getDocument(entry.getKey())
+		if err != nil {
+			return err
+		}
+		if doc == nil || (softDelete && doc.DeletedAt > 0) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("entry %q does not exist", entry.IPOrNet))
+		}
+		if softDelete {
+			var oldEntry IPListEntry
+			if err = json.Unmarshal([]byte(doc.Data), &oldEntry); err != nil {
+				return err
+			}
+			ts := util.GetTimeAsMsSinceEpoch(time.Now())
+			oldEntry.UpdatedAt = ts
+			return bucket.softDelete(entry.getKey(), &oldEntry, ts)
+		}
+		return bucket.delete(entry.getKey())
+	})
+}
+
+func (p *MongoDBProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 15)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		query := append(mongoFilter(""), bson.E{Key: "list_type", Value: listType})
+		var conditions bson.D
+		if filter != "" {
+			conditions = append(conditions, bson.E{Key: "$regex", Value: "^" + regexp.QuoteMeta(filter)})
+		}
+		if from != "" {
+			if order == OrderASC {
+				conditions = append(conditions, bson.E{Key: "$gt", Value: from})
+			} else {
+				conditions = append(conditions, bson.E{Key: "$lt", Value: from})
+			}
+		}
+		if len(conditions) > 0 {
+			query = append(query, bson.E{Key: "ipornet", Value: conditions})
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "ipornet", Value: mongoSortDirection(order)}})
+		if limit > 0 {
+			opts.SetLimit(int64(limit))
+		}
+		return bucket.find(query, opts, func(data []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 10)
+	err := p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		return bucket.findDocuments(mongoRecentlyUpdatedFilter(after), mongoFindOptions(OrderASC, 0, 0),
+			func(doc *mongoDocument) error {
+				var entry IPListEntry
+				if err := json.Unmarshal([]byte(doc.Data), &entry); err != nil {
+					return err
+				}
+				entry.DeletedAt = doc.DeletedAt
+				entries = append(entries, entry)
+				return nil
+			})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) dumpIPListEntries() ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 10)
+	err := p.viewLong(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		count, err := bucket.coll.CountDocuments(ctx, mongoFilter(""))
+		if err != nil {
+			return err
+		}
+		if count > ipListMemoryLimit {
+			providerLog(logger.LevelInfo, "IP lists excluded from dump, too many entries: %d", count)
+			return nil
+		}
+		return bucket.find(mongoFilter(""), mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) countIPListEntries(listType IPListType) (int64, error) {
+	var count int64
+	err := p.view(func(ctx context.Context) error {
+		filter := mongoFilter("")
+		if listType != 0 {
+			filter = append(filter, bson.E{Key: "list_type", Value: listType})
+		}
+		var err error
+		count, err = p.getCollection(mongoCollIPLists).CountDocuments(ctx, filter)
+		return err
+	})
+	return count, err
+}
+
+func (p *MongoDBProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 3)
+	ipAddr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return entries, fmt.Errorf("invalid ip address %s", ip)
+	}
+	var netType int
+	var ipBytes []byte
+	if ipAddr.Is4() || ipAddr.Is4In6() {
+		netType = ipTypeV4
+		as4 := ipAddr.As4()
+		ipBytes = as4[:]
+	} else {
+		netType = ipTypeV6
+		as16 := ipAddr.As16()
+		ipBytes = as16[:]
+	}
+	err = p.view(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollIPLists)
+		// binary values with the same length are compared byte by byte
+		filter := append(mongoFilter(""),
+			bson.E{Key: "list_type", Value: listType},
+			bson.E{Key: "ip_type", Value: netType},
+			bson.E{Key: "first", Value: bson.D{{Key: "$lte", Value: ipBytes}}},
+			bson.E{Key: "last", Value: bson.D{{Key: "$gte", Value: ipBytes}}},
+		)
+		return bucket.find(filter, mongoFindOptions(OrderASC, 0, 0), func(data []byte) error {
+			var entry IPListEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entry.PrepareForRendering()
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (p *MongoDBProvider) getConfigs() (Configs, error) {
+	var configs Configs
+	err := p.view(func(ctx context.Context) error {
+		_, err := p.getBucket(ctx, mongoCollConfigs).getObject(mongoCollConfigs, &configs)
+		return err
+	})
+	return configs, err
+}
+
+func (p *MongoDBProvider) setConfigs(configs *Configs) error {
+	if err := configs.validate(); err != nil {
+		return err
+	}
+	return p.view(func(ctx context.Context) error {
+		return p.getBucket(ctx, mongoCollConfigs).put(mongoCollConfigs, configs)
+	})
+}
+
+func (p *MongoDBProvider) setFirstDownloadTimestamp(username string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set download timestamp",
+				username))
+		}
+		if user.FirstDownload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first download already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstDownload)))
+		}
+		user.FirstDownload = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *MongoDBProvider) setFirstUploadTimestamp(username string) error {
+	return p.update(func(ctx context.Context) error {
+		bucket := p.getBucket(ctx, mongoCollUsers)
+		var user User
+		found, err := bucket.getObject(username, &user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to set upload timestamp",
+				username))
+		}
+		if user.FirstUpload > 0 {
+			return util.NewGenericError(fmt.Sprintf("first upload already set to %v",
+				util.GetTimeFromMsecSinceEpoch(user.FirstUpload)))
+		}
+		user.FirstUpload = util.GetTimeAsMsSinceEpoch(time.Now())
+		return bucket.put(username, &user)
+	})
+}
+
+func (p *MongoDBProvider) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return p.client.Disconnect(ctx)
+}
+
+func (p *MongoDBProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase creates the collections indexes and sets the initial schema version
+func (p *MongoDBProvider) initializeDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err == nil && dbVersion.Version > 0 {
+		return ErrNoInitRequired
+	}
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	logger.InfoToConsole("creating initial database schema, version %d", mongoDBDatabaseVersion)
+	providerLog(logger.LevelInfo, "creating initial database schema, version %d", mongoDBDatabaseVersion)
+	if err := p.createIndexes(); err != nil {
+		return err
+	}
+	return p.setDatabaseVersion(mongoDBDatabaseVersion)
+}
+
+func (p *MongoDBProvider) migrateDatabase() error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	switch version := dbVersion.Version; {
+	case version == mongoDBDatabaseVersion:
+		providerLog(logger.LevelDebug, "mongodb database is up to date, current version: %d", version)
+		return ErrNoInitRequired
+	case version == 33:
+		return p.updateDatabaseFrom33To34()
+	default:
+		if version > mongoDBDatabaseVersion {
+			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
+				mongoDBDatabaseVersion)
+			logger.WarnToConsole("database schema version %d is newer than the supported one: %d", version,
+				mongoDBDatabaseVersion)
+			return nil
+		}
+		return fmt.Errorf("database schema version not handled: %d", version)
+	}
+}
+
+func (p *MongoDBProvider) revertDatabase(targetVersion int) error {
+	dbVersion, err := p.getDatabaseVersion()
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == targetVersion {
+		return errors.New("current version match target version, nothing to do")
+	}
+	return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
+}
+
+func (p *MongoDBProvider) resetDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	for _, name := range mongoCollections {
+		if err := p.getCollection(name).Drop(ctx); err != nil {
+			return fmt.Errorf("unable to remove collection %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		mongoCollUsers: {
+			{Keys: bson.D{{Key: "tenant", Value: 1}}},
+			{Keys: bson.D{{Key: "role", Value: 1}}},
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		},
+		mongoCollGroups:  {{Keys: bson.D{{Key: "tenant", Value: 1}}}},
+		mongoCollFolders: {{Keys: bson.D{{Key: "tenant", Value: 1}}}},
+		mongoCollAdmins:  {{Keys: bson.D{{Key: "tenant", Value: 1}}}},
+		mongoCollRoles:   {{Keys: bson.D{{Key: "tenant", Value: 1}}}},
+		mongoCollRules: {
+			{Keys: bson.D{{Key: "tenant", Value: 1}}},
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		},
+		mongoCollAPIKeys: {
+			{Keys: bson.D{{Key: "user", Value: 1}}},
+			{Keys: bson.D{{Key: "admin", Value: 1}}},
+		},
+		mongoCollShares: {{Keys: bson.D{{Key: "username", Value: 1}}}},
+		mongoCollIPLists: {
+			{Keys: bson.D{{Key: "list_type", Value: 1}, {Key: "ipornet", Value: 1}}},
+			{Keys: bson.D{{Key: "list_type", Value: 1}, {Key: "ip_type", Value: 1}, {Key: "first", Value: 1}, {Key: "last", Value: 1}}},
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		},
+		mongoCollDefenderHosts: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "ban_time", Value: 1}}},
+		},
+		mongoCollDefenderEvents: {
+			{Keys: bson.D{{Key: "host", Value: 1}}},
+			{Keys: bson.D{{Key: "date_time", Value: 1}}},
+		},
+		mongoCollActiveTransfers: {
+			{Keys: bson.D{{Key: "connection_id", Value: 1}, {Key: "transfer_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		},
+		mongoCollSharedSessions: {{Keys: bson.D{{Key: "type", Value: 1}, {Key: "timestamp", Value: 1}}}},
+		mongoCollNodes:          {{Keys: bson.D{{Key: "updated_at", Value: 1}}}},
+		mongoCollAuditLogs:      {{Keys: bson.D{{Key: "node", Value: 1}, {Key: "_id", Value: -1}}}},
+	}
+	for _, name := range mongoCollections {
+		if err := p.db.CreateCollection(ctx, p.getCollection(name).Name()); err != nil {
+			var cmdErr mongo.CommandError
+			// NamespaceExists
+			if !errors.As(err, &cmdErr) || cmdErr.Code != 48 {
+				return fmt.Errorf("unable to create collection %q: %w", name, err)
+			}
+		}
+		if models, ok := indexes[name]; ok {
+			if _, err := p.getCollection(name).Indexes().CreateMany(ctx, models); err != nil {
+				return fmt.Errorf("unable to create indexes for collection %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) updateDatabaseFrom33To34() error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	// creating the indexes is idempotent and adds the audit logs collection
+	if err := p.createIndexes(); err != nil {
+		return err
+	}
+	return p.setDatabaseVersion(34)
+}
+
+func (p *MongoDBProvider) getDatabaseVersion() (schemaVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	var dbVersion schemaVersion
+	var row struct {
+		Version int `bson:"version"`
+	}
+	err := p.getCollection(mongoCollSchemaVersion).FindOne(ctx, bson.D{{Key: "_id", Value: "version"}}).Decode(&row)
+	dbVersion.Version = row.Version
+	return dbVersion, err
+}
+
+func (p *MongoDBProvider) setDatabaseVersion(version int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	_, err := p.getCollection(mongoCollSchemaVersion).ReplaceOne(ctx, bson.D{{Key: "_id", Value: "version"}},
+		bson.D{{Key: "_id", Value: "version"}, {Key: "version", Value: version}}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// view executes fn using a context with the default timeout
+func (p *MongoDBProvider) view(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// viewLong executes fn using a context with the long timeout, used for dumps
+func (p *MongoDBProvider) viewLong(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// update executes fn inside a transaction, fn can be retried on transient errors
+func (p *MongoDBProvider) update(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	session, err := p.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (any, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+func (p *MongoDBProvider) getCollection(name string) *mongo.Collection {
+	return p.db.Collection(config.SQLTablesPrefix + name)
+}
+
+func (p *MongoDBProvider) getBucket(ctx context.Context, name string) *mongoBucket {
+	return &mongoBucket{
+		ctx:       ctx,
+		name:      name,
+		coll:      p.getCollection(name),
+		sequences: p.getCollection(mongoCollSequences),
+	}
+}
+
+func (p *MongoDBProvider) getDefenderHostsWithScores(ctx context.Context, hosts []DefenderEntry, from int64,
+) ([]DefenderEntry, error) {
+	var ipForScores []string
+	for _, host := range hosts {
+		if host.BanTime.IsZero() {
+			ipForScores = append(ipForScores, host.IP)
+		}
+	}
+	if len(ipForScores) == 0 {
+		return hosts, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "host", Value: bson.D{{Key: "$in", Value: ipForScores}}},
+			{Key: "date_time", Value: bson.D{{Key: "$gte", Value: from}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$host"},
+			{Key: "score", Value: bson.D{{Key: "$sum", Value: "$score"}}},
+		}}},
+	}
+	cursor, err := p.getCollection(mongoCollDefenderEvents).Aggregate(ctx, pipeline)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get score for hosts %+v: %v", ipForScores, err)
+		return nil, err
+	}
+	var scores []struct {
+		Host  string `bson:"_id"`
+		Score int    `bson:"score"`
+	}
+	if err = cursor.All(ctx, &scores); err != nil {
+		return hosts, err
+	}
+	hostsWithScores := make(map[string]int)
+	for _, s := range scores {
+		if s.Score > 0 {
+			hostsWithScores[s.Host] = s.Score
+		}
+	}
+	result := make([]DefenderEntry, 0, len(hosts))
+	for idx := range hosts {
+		hosts[idx].Score = hostsWithScores[hosts[idx].IP]
+		if hosts[idx].Score > 0 || !hosts[idx].BanTime.IsZero() {
+			result = append(result, hosts[idx])
+		}
+	}
+	return result, nil
+}
+
+func (p *MongoDBProvider) checkAPIKeyRelations(ctx context.Context, apiKey *APIKey) error {
+	if apiKey.User != "" {
+		exists, err := p.getBucket(ctx, mongoCollUsers).exists(apiKey.User)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: related user %q does not exists", ErrForeignKeyViolated, apiKey.User)
+		}
+	}
+	if apiKey.Admin != "" {
+		exists, err := p.getBucket(ctx, mongoCollAdmins).exists(apiKey.Admin)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: related admin %q does not exists", ErrForeignKeyViolated, apiKey.Admin)
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) deleteUserInternal(ctx context.Context, user User) error {
+	if err := p.removeUserFromRole(user.Username, user.Role, p.getBucket(ctx, mongoCollRoles)); err != nil {
+		return err
+	}
+	if err := p.removeUserFromFoldersAndGroups(ctx, user); err != nil {
+		return err
+	}
+	if err := p.getBucket(ctx, mongoCollAPIKeys).deleteMany(bson.D{{Key: "user", Value: user.Username}}); err != nil {
+		return err
+	}
+	if err := p.getBucket(ctx, mongoCollShares).deleteMany(bson.D{{Key: "username", Value: user.Username}}); err != nil {
+		return err
+	}
+	return p.getBucket(ctx, mongoCollUsers).delete(user.Username)
+}
+
+func (p *MongoDBProvider) removeUserFromFoldersAndGroups(ctx context.Context, user User) error {
+	foldersBucket := p.getBucket(ctx, mongoCollFolders)
+	for idx := range user.VirtualFolders {
+		err := p.removeRelationFromFolderMapping(user.VirtualFolders[idx], user.Username, "", foldersBucket)
+		if err != nil {
+			return err
+		}
+	}
+	groupsBucket := p.getBucket(ctx, mongoCollGroups)
+	for idx := range user.Groups {
+		if err := p.removeUserFromGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) updateUserRelations(ctx context.Context, user *User, oldUser User) error {
+	if err := p.removeUserFromFoldersAndGroups(ctx, oldUser); err != nil {
+		return err
+	}
+	rolesBucket := p.getBucket(ctx, mongoCollRoles)
+	if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
+		return err
+	}
+	foldersBucket := p.getBucket(ctx, mongoCollFolders)
+	sort.Slice(user.VirtualFolders, func(i, j int) bool {
+		return user.VirtualFolders[i].Name < user.VirtualFolders[j].Name
+	})
+	for idx := range user.VirtualFolders {
+		if err := p.addRelationToFolderMapping(user.VirtualFolders[idx].Name, user, nil, foldersBucket); err != nil {
+			return err
+		}
+	}
+	groupsBucket := p.getBucket(ctx, mongoCollGroups)
+	sort.Slice(user.Groups, func(i, j int) bool {
+		return user.Groups[i].Name < user.Groups[j].Name
+	})
+	for idx := range user.Groups {
+		if err := p.addUserToGroupMapping(user.Username, user.Groups[idx].Name, groupsBucket); err != nil {
+			return err
+		}
+	}
+	return p.addUserToRole(user.Username, user.Role, rolesBucket)
+}
+
+func (p *MongoDBProvider) joinRuleAndActions(r []byte, actionsBucket *mongoBucket) (EventRule, error) {
+	var rule EventRule
+	err := json.Unmarshal(r, &rule)
+	if err != nil {
+		return rule, err
+	}
+	var actions []EventAction
+	for idx := range rule.Actions {
+		action := &rule.Actions[idx]
+		var baseAction BaseEventAction
+		found, err := actionsBucket.getObject(action.Name, &baseAction)
+		if err != nil {
+			return rule, err
+		}
+		if !found {
+			continue
+		}
+		baseAction.Options.SetEmptySecretsIfNil()
+		action.BaseEventAction = baseAction
+		actions = append(actions, *action)
+	}
+	rule.Actions = actions
+	return rule, nil
+}
+
+func (p *MongoDBProvider) joinGroupAndFolders(g []byte, foldersBucket *mongoBucket) (Group, error) {
+	var group Group
+	err := json.Unmarshal(g, &group)
+	if err != nil {
+		return group, err
+	}
+	if len(group.VirtualFolders) > 0 {
+		group.VirtualFolders = p.getUserOrGroupFolders(group.VirtualFolders, foldersBucket)
+	}
+	group.SetEmptySecretsIfNil()
+	return group, err
+}
+
+func (p *MongoDBProvider) joinUserAndFolders(u []byte, foldersBucket *mongoBucket) (User, error) {
+	var user User
+	err := json.Unmarshal(u, &user)
+	if err != nil {
+		return user, err
+	}
+	if len(user.VirtualFolders) > 0 {
+		user.VirtualFolders = p.getUserOrGroupFolders(user.VirtualFolders, foldersBucket)
+	}
+	user.SetEmptySecretsIfNil()
+	return user, err
+}
+
+func (p *MongoDBProvider) getUserOrGroupFolders(virtualFolders []vfs.VirtualFolder, foldersBucket *mongoBucket,
+) []vfs.VirtualFolder {
+	var folders []vfs.VirtualFolder
+	for idx := range virtualFolders {
+		folder := &virtualFolders[idx]
+		baseFolder, err := p.folderExistsInternal(folder.Name, foldersBucket)
+		if err != nil {
+			continue
+		}
+		folder.BaseVirtualFolder = baseFolder
+		folders = append(folders, *folder)
+	}
+	return folders
+}
+
+func (p *MongoDBProvider) groupExistsInternal(name string, bucket *mongoBucket) (Group, error) {
+	var group Group
+	found, err := bucket.getObject(name, &group)
+	if err == nil && !found {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", name))
+	}
+	return group, err
+}
+
+func (p *MongoDBProvider) folderExistsInternal(name string, bucket *mongoBucket) (vfs.BaseVirtualFolder, error) {
+	var folder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(name, &folder)
+	if err == nil && !found {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
+	}
+	return folder, err
+}
+
+func (p *MongoDBProvider) removeRoleFromUser(username, role string, bucket *mongoBucket) error {
+	var user User
+	found, err := bucket.getObject(username, &user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "user %q does not exist, cannot remove role %q", username, role)
+		return nil
+	}
+	if user.Role == role {
+		user.Role = ""
+		return bucket.put(user.Username, &user)
+	}
+	providerLog(logger.LevelError, "user %q does not have the expected role %q, actual %q", username, role, user.Role)
+	return nil
+}
+
+func (p *MongoDBProvider) addAdminToRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: role %q does not exist", ErrForeignKeyViolated, roleName)
+	}
+	if !util.Contains(role.Admins, username) {
+		role.Admins = append(role.Admins, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeAdminFromRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove admin %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Admins, username) {
+		role.Admins = mongoRemoveString(role.Admins, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addUserToRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: role %q does not exist", ErrForeignKeyViolated, roleName)
+	}
+	if !util.Contains(role.Users, username) {
+		role.Users = append(role.Users, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeUserFromRole(username, roleName string, bucket *mongoBucket) error {
+	if roleName == "" {
+		return nil
+	}
+	var role Role
+	found, err := bucket.getObject(roleName, &role)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "role %q does not exist, cannot remove user %q", roleName, username)
+		return nil
+	}
+	if util.Contains(role.Users, username) {
+		role.Users = mongoRemoveString(role.Users, username)
+		return bucket.put(role.Name, &role)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addRuleToActionMapping(ruleName, actionName string, bucket *mongoBucket) error {
+	var action BaseEventAction
+	found, err := bucket.getObject(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("action %q does not exist", actionName))
+	}
+	if !util.Contains(action.Rules, ruleName) {
+		action.Rules = append(action.Rules, ruleName)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeRuleFromActionMapping(ruleName, actionName string, bucket *mongoBucket) error {
+	var action BaseEventAction
+	found, err := bucket.getObject(actionName, &action)
+	if err != nil {
+		return err
+	}
+	if !found {
+		providerLog(logger.LevelWarn, "action %q does not exist, cannot remove from mapping", actionName)
+		return nil
+	}
+	if util.Contains(action.Rules, ruleName) {
+		action.Rules = mongoRemoveString(action.Rules, ruleName)
+		return bucket.put(action.Name, &action)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) addUserToGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	if !util.Contains(group.Users, username) {
+		group.Users = append(group.Users, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeUserFromGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	group.Users = mongoRemoveString(group.Users, username)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *MongoDBProvider) addAdminToGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	if !util.Contains(group.Admins, username) {
+		group.Admins = append(group.Admins, username)
+		return bucket.put(group.Name, &group)
+	}
+	return nil
+}
+
+func (p *MongoDBProvider) removeAdminFromGroupMapping(username, groupname string, bucket *mongoBucket) error {
+	var group Group
+	found, err := bucket.getObject(groupname, &group)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", groupname))
+	}
+	group.Admins = mongoRemoveString(group.Admins, username)
+	return bucket.put(group.Name, &group)
+}
+
+func (p *MongoDBProvider) removeGroupFromAdminMapping(groupName, adminName string, bucket *mongoBucket) error {
+	var admin Admin
+	found, err := bucket.getObject(adminName, &admin)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the admin does not exist so there is no associated group
+		return nil
+	}
+	var newGroups []AdminGroupMapping
+	for _, g := range admin.Groups {
+		if g.Name != groupName {
+			newGroups = append(newGroups, g)
+		}
+	}
+	admin.Groups = newGroups
+	return bucket.put(adminName, &admin)
+}
+
+func (p *MongoDBProvider) addRelationToFolderMapping(folderName string, user *User, group *Group, bucket *mongoBucket) error {
+	var folder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(folderName, &folder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return util.NewGenericError(fmt.Sprintf("folder %q does not exist", folderName))
+	}
+	updated := false
+	if user != nil && !util.Contains(folder.Users, user.Username) {
+		folder.Users = append(folder.Users, user.Username)
+		updated = true
+	}
+	if group != nil && !util.Contains(folder.Groups, group.Name) {
+		folder.Groups = append(folder.Groups, group.Name)
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	return bucket.put(folder.Name, &folder)
+}
+
+func (p *MongoDBProvider) removeRelationFromFolderMapping(folder vfs.VirtualFolder, username, groupname string,
+	bucket *mongoBucket,
+) error {
+	var baseFolder vfs.BaseVirtualFolder
+	found, err := bucket.getObject(folder.Name, &baseFolder)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the folder does not exist so there is no associated user/group
+		return nil
+	}
+	if username == "" && groupname == "" {
+		return nil
+	}
+	if username != "" {
+		baseFolder.Users = mongoRemoveString(baseFolder.Users, username)
+	}
+	if groupname != "" {
+		baseFolder.Groups = mongoRemoveString(baseFolder.Groups, groupname)
+	}
+	return bucket.put(folder.Name, &baseFolder)
+}
+
+// getDocument returns the stored document with the given key, soft deleted
+// documents are included. A nil document is returned if the key does not exist
+func (b *mongoBucket) getDocument(key string) (*mongoDocument, error) {
+	var doc mongoDocument
+	err := b.coll.FindOne(b.ctx, bson.D{{Key: "_id", Value: key}}).Decode(&doc)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// get returns the serialized object with the given key or nil if it does not exist
+func (b *mongoBucket) get(key string) ([]byte, error) {
+	doc, err := b.getDocument(key)
+	if err != nil || doc == nil || doc.DeletedAt > 0 {
+		return nil, err
+	}
+	return []byte(doc.Data), nil
+}
+
+func (b *mongoBucket) getObject(key string, obj any) (bool, error) {
+	data, err := b.get(key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, obj)
+}
+
+func (b *mongoBucket) exists(key string) (bool, error) {
+	data, err := b.get(key)
+	return data != nil, err
+}
+
+func (b *mongoBucket) put(key string, obj any) error {
+	return b.putDocument(key, obj, 0)
+}
+
+func (b *mongoBucket) softDelete(key string, obj any, deletedAt int64) error {
+	return b.putDocument(key, obj, deletedAt)
+}
+
+func (b *mongoBucket) putDocument(key string, obj any, deletedAt int64) error {
+	doc, err := newMongoDocument(key, obj)
+	if err != nil {
+		return err
+	}
+	doc.DeletedAt = deletedAt
+	_, err = b.coll.ReplaceOne(b.ctx, bson.D{{Key: "_id", Value: key}}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (b *mongoBucket) delete(key string) error {
+	_, err := b.coll.DeleteOne(b.ctx, bson.D{{Key: "_id", Value: key}})
+	return err
+}
+
+func (b *mongoBucket) deleteMany(filter bson.D) error {
+	_, err := b.coll.DeleteMany(b.ctx, filter)
+	return err
+}
+
+// nextSequence returns the next numeric ID for the objects stored in this bucket
+func (b *mongoBucket) nextSequence() (int64, error) {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := b.sequences.FindOneAndUpdate(b.ctx, bson.D{{Key: "_id", Value: b.name}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "value", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	return counter.Value, err
+}
+
+func (b *mongoBucket) find(filter bson.D, opts *options.FindOptions, fn func(data []byte) error) error {
+	return b.findDocuments(filter, opts, func(doc *mongoDocument) error {
+		return fn([]byte(doc.Data))
+	})
+}
+
+func (b *mongoBucket) findDocuments(filter bson.D, opts *options.FindOptions, fn func(doc *mongoDocument) error) error {
+	cursor, err := b.coll.Find(b.ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(b.ctx)
+
+	for cursor.Next(b.ctx) {
+		var doc mongoDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func newMongoDocument(key string, obj any) (*mongoDocument, error) {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	doc := &mongoDocument{
+		Key:  key,
+		Data: string(buf),
+	}
+	switch v := obj.(type) {
+	case *User:
+		doc.Tenant = v.Tenant
+		doc.Role = v.Role
+		doc.UpdatedAt = v.UpdatedAt
+	case *Admin:
+		doc.Tenant = v.Tenant
+		doc.Role = v.Role
+		doc.UpdatedAt = v.UpdatedAt
+	case *Group:
+		doc.Tenant = v.Tenant
+		doc.UpdatedAt = v.UpdatedAt
+	case *vfs.BaseVirtualFolder:
+		doc.Tenant = v.Tenant
+	case *Role:
+		doc.Tenant = v.Tenant
+		doc.UpdatedAt = v.UpdatedAt
+	case *EventRule:
+		doc.Tenant = v.Tenant
+		doc.UpdatedAt = v.UpdatedAt
+	case *APIKey:
+		doc.User = v.User
+		doc.Admin = v.Admin
+		doc.UpdatedAt = v.UpdatedAt
+	case *Share:
+		doc.Username = v.Username
+		doc.UpdatedAt = v.UpdatedAt
+	case *Tenant:
+		doc.UpdatedAt = v.UpdatedAt
+	case *IPListEntry:
+		doc.ListType = int(v.Type)
+		doc.IPOrNet = v.IPOrNet
+		doc.IPType = v.IPType
+		doc.First = v.First
+		doc.Last = v.Last
+		doc.UpdatedAt = v.UpdatedAt
+	}
+	return doc, nil
+}
+
+// mongoFilter returns a filter for the objects not soft deleted and
+// belonging to the specified tenant, if any
+func mongoFilter(tenant string) bson.D {
+	filter := bson.D{{Key: "deleted_at", Value: int64(0)}}
+	if tenant != "" {
+		filter = append(filter, bson.E{Key: "tenant", Value: tenant})
+	}
+	return filter
+}
+
+func mongoRecentlyUpdatedFilter(after int64) bson.D {
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "updated_at", Value: bson.D{{Key: "$gte", Value: after}}}},
+		bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$gt", Value: 0}}}},
+	}}}
+}
+
+func mongoSortDirection(order string) int {
+	if order == OrderASC {
+		return 1
+	}
+	return -1
+}
+
+func mongoFindOptions(order string, offset, limit int) *options.FindOptions {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: mongoSortDirection(order)}})
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	return opts
+}
+
+func mongoRequireMatch(res *mongo.UpdateResult) error {
+	if res.MatchedCount != 1 {
+		return util.NewGenericError(fmt.Sprintf("unexpected number of rows affected: %d", res.MatchedCount))
+	}
+	return nil
+}
+
+func mongoGetDefenderEntry(row mongoDefenderHost) DefenderEntry {
+	host := DefenderEntry{
+		IP: row.IP,
+	}
+	if row.BanTime > 0 {
+		banTime := util.GetTimeFromMsecSinceEpoch(row.BanTime)
+		if banTime.After(time.Now()) {
+			host.BanTime = banTime
+		}
+	}
+	return host
+}
+
+func mongoGetNode(row mongoNode) (Node, error) {
+	node := Node{
+		Name:      row.Name,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+	err := json.Unmarshal(row.Data, &node.Data)
+	return node, err
+}
+
+func mongoRemoveString(values []string, toRemove string) []string {
+	var result []string
+	for _, v := range values {
+		if v != toRemove {
+			result = append(result, v)
+		}
+	}
+	return util.RemoveDuplicates(result, false)
+}
+
+func mongoRemoveVirtualFolder(folders []vfs.VirtualFolder, name string) []vfs.VirtualFolder {
+	var result []vfs.VirtualFolder
+	for _, folder := range folders {
+		if folder.Name != name {
+			result = append(result, folder)
+		}
+	}
+	return result
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -59,6 +60,8 @@ const (
 		"DROP TABLE IF EXISTS `{{tasks}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{nodes}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{roles}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{tenants}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{audit_logs}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{ip_lists}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{configs}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
@@ -194,11 +197,61 @@ const (
 		"CREATE INDEX `{{prefix}}ip_lists_deleted_at_idx` ON `{{ip_lists}}` (`deleted_at`);" +
 		"CREATE INDEX `{{prefix}}ip_lists_first_last_idx` ON `{{ip_lists}}` (`first`, `last`);" +
 		"INSERT INTO {{schema_version}} (version) VALUES (29);"
+	mysqlV30SQL     = "ALTER TABLE `{{api_keys}}` ADD COLUMN `filters` longtext NULL;"
+	mysqlV30DownSQL = "ALTER TABLE `{{api_keys}}` DROP COLUMN `filters`;"
+	mysqlV31SQL     = "ALTER TABLE `{{shares}}` ADD COLUMN `options` longtext NULL;"
+	mysqlV31DownSQL = "ALTER TABLE `{{shares}}` DROP COLUMN `options`;"
+	mysqlV32SQL     = "ALTER TABLE `{{shares}}` ADD COLUMN `stats` longtext NULL;"
+	mysqlV32DownSQL = "ALTER TABLE `{{shares}}` DROP COLUMN `stats`;"
+	mysqlV33SQL     = "CREATE TABLE `{{tenants}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `name` varchar(255) NOT NULL UNIQUE, " +
+		"`description` varchar(512) NULL, `branding` longtext NULL, `created_at` bigint NOT NULL, `updated_at` bigint NOT NULL);" +
+		"ALTER TABLE `{{admins}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{users}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{groups}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{roles}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{folders}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{events_rules}}` ADD COLUMN `tenant_id` integer NULL;" +
+		"ALTER TABLE `{{admins}}` ADD CONSTRAINT `{{prefix}}admins_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;" +
+		"ALTER TABLE `{{users}}` ADD CONSTRAINT `{{prefix}}users_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;" +
+		"ALTER TABLE `{{groups}}` ADD CONSTRAINT `{{prefix}}groups_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;" +
+		"ALTER TABLE `{{roles}}` ADD CONSTRAINT `{{prefix}}roles_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;" +
+		"ALTER TABLE `{{folders}}` ADD CONSTRAINT `{{prefix}}folders_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;" +
+		"ALTER TABLE `{{events_rules}}` ADD CONSTRAINT `{{prefix}}events_rules_tenant_id_fk_tenants_id` FOREIGN KEY (`tenant_id`) " +
+		"REFERENCES `{{tenants}}`(`id`) ON DELETE NO ACTION;"
+	mysqlV33DownSQL = "ALTER TABLE `{{admins}}` DROP FOREIGN KEY `{{prefix}}admins_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{users}}` DROP FOREIGN KEY `{{prefix}}users_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{groups}}` DROP FOREIGN KEY `{{prefix}}groups_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{roles}}` DROP FOREIGN KEY `{{prefix}}roles_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{folders}}` DROP FOREIGN KEY `{{prefix}}folders_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{events_rules}}` DROP FOREIGN KEY `{{prefix}}events_rules_tenant_id_fk_tenants_id`;" +
+		"ALTER TABLE `{{admins}}` DROP COLUMN `tenant_id`;" +
+		"ALTER TABLE `{{users}}` DROP COLUMN `tenant_id`;" +
+		"ALTER TABLE `{{groups}}` DROP COLUMN `tenant_id`;" +
+		"ALTER TABLE `{{roles}}` DROP COLUMN `tenant_id`;" +
+		"ALTER TABLE `{{folders}}` DROP COLUMN `tenant_id`;" +
+		"ALTER TABLE `{{events_rules}}` DROP COLUMN `tenant_id`;" +
+		"DROP TABLE IF EXISTS `{{tenants}}` CASCADE;"
+	mysqlV34SQL = "CREATE TABLE `{{audit_logs}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `created_at` bigint NOT NULL, " +
+		"`node` varchar(255) NOT NULL, `category` varchar(50) NOT NULL, `action` varchar(100) NOT NULL, " +
+		"`username` varchar(255) NOT NULL, `ip` varchar(50) NOT NULL, `protocol` varchar(30) NOT NULL, " +
+		"`object_type` varchar(50) NOT NULL, `object_name` varchar(255) NOT NULL, `role` varchar(255) NOT NULL, " +
+		"`status` integer NOT NULL, `info` longtext NULL, `prev_hash` varchar(64) NOT NULL, `hash` varchar(64) NOT NULL);" +
+		"CREATE INDEX `{{prefix}}audit_logs_node_id_idx` ON `{{audit_logs}}` (`node`, `id`);"
+	mysqlV34DownSQL = "DROP INDEX `{{prefix}}audit_logs_node_id_idx` ON `{{audit_logs}}`;" +
+		"DROP TABLE IF EXISTS `{{audit_logs}}` CASCADE;"
+	mysqlV35SQL     = "ALTER TABLE `{{tenants}}` ADD COLUMN `storage_base_path` varchar(512) NULL;"
+	mysqlV35DownSQL = "ALTER TABLE `{{tenants}}` DROP COLUMN `storage_base_path`;"
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReplicas
 }
 
 func init() {
@@ -230,13 +283,59 @@ func initializeMySQLProvider() error {
 	}
 	dbHandle.SetConnMaxLifetime(240 * time.Second)
 	dbHandle.SetConnMaxIdleTime(120 * time.Second)
-	provider = &MySQLProvider{dbHandle: dbHandle}
+	replicas, err := newSQLReplicas("mysql", getMySQLReplicaLag)
+	if err != nil {
+		dbHandle.Close()
+		return err
+	}
+	provider = &MySQLProvider{dbHandle: dbHandle, replicas: replicas}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return dbHandle.PingContext(ctx)
 }
+
+// getMySQLReplicaLag returns the replication lag as reported by the replica status
+func getMySQLReplicaLag(ctx context.Context, dbHandle *sql.DB) (time.Duration, error) {
+	rows, err := dbHandle.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		// not a replica
+		return 0, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for idx, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[idx].Valid {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.ParseInt(values[idx].String, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid replication lag %q: %w", values[idx].String, err)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("unable to find the replication lag")
+}
+
 func getMySQLConnectionString(redactedPwd bool) (string, error) {
 	var connectionString string
 	if config.ConnectionString == "" {
@@ -302,15 +401,15 @@ func (p *MySQLProvider) checkAvailability() error {
 }
 
 func (p *MySQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -357,6 +456,10 @@ func (p *MySQLProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateAdminPassword(username, password string) error {
+	return sqlCommonUpdateAdminPassword(username, password, p.dbHandle)
+}
+
 func (p *MySQLProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -365,8 +468,8 @@ func (p *MySQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *MySQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *MySQLProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, role, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -377,8 +480,8 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -407,8 +510,8 @@ func (p *MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool, tenant string) ([]Group, error) {
+	return sqlCommonGetGroups(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -455,8 +558,8 @@ func (p *MySQLProvider) deleteAdmin(admin Admin) error {
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
-func (p *MySQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+func (p *MySQLProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
+	return sqlCommonGetAdmins(limit, offset, order, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpAdmins() ([]Admin, error) {
@@ -484,7 +587,7 @@ func (p *MySQLProvider) deleteAPIKey(apiKey APIKey) error {
 }
 
 func (p *MySQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAPIKeys(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -512,7 +615,7 @@ func (p *MySQLProvider) deleteShare(share Share) error {
 }
 
 func (p *MySQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlCommonGetShares(limit, offset, order, username, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpShares() ([]Share, error) {
@@ -523,6 +626,10 @@ func (p *MySQLProvider) updateShareLastUse(shareID string, numTokens int) error
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	return sqlCommonUpdateShareStats(shareID, ipAddress, filePaths, p.dbHandle)
+}
+
 func (p *MySQLProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
 	return sqlCommonGetDefenderHosts(from, limit, p.dbHandle)
 }
@@ -587,12 +694,16 @@ func (p *MySQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *MySQLProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, keyPrefix, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *MySQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetEventActions(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -615,8 +726,8 @@ func (p *MySQLProvider) deleteEventAction(action BaseEventAction) error {
 	return sqlCommonDeleteEventAction(action, p.dbHandle)
 }
 
-func (p *MySQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+func (p *MySQLProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	return sqlCommonGetEventRules(limit, offset, order, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -695,14 +806,50 @@ func (p *MySQLProvider) deleteRole(role Role) error {
 	return sqlCommonDeleteRole(role, p.dbHandle)
 }
 
-func (p *MySQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+func (p *MySQLProvider) getRoles(limit int, offset int, order string, minimal bool, tenant string) ([]Role, error) {
+	return sqlCommonGetRoles(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *MySQLProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *MySQLProvider) addTenant(tenant *Tenant) error {
+	return p.normalizeError(sqlCommonAddTenant(tenant, p.dbHandle), fieldName)
+}
+
+func (p *MySQLProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *MySQLProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *MySQLProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
+}
+
+func (p *MySQLProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogEntries(from, limit, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *MySQLProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	return sqlCommonGetLastAuditLogEntry(node, p.dbHandle)
+}
+
 func (p *MySQLProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -720,7 +867,7 @@ func (p *MySQLProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) er
 }
 
 func (p *MySQLProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
-	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.dbHandle)
+	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
@@ -736,7 +883,7 @@ func (p *MySQLProvider) countIPListEntries(listType IPListType) (int64, error) {
 }
 
 func (p *MySQLProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
-	return sqlCommonGetListEntriesForIP(ip, listType, p.dbHandle)
+	return sqlCommonGetListEntriesForIP(ip, listType, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *MySQLProvider) getConfigs() (Configs, error) {
@@ -756,6 +903,7 @@ func (p *MySQLProvider) setFirstUploadTimestamp(username string) error {
 }
 
 func (p *MySQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -794,6 +942,18 @@ func (p *MySQLProvider) migrateDatabase() error {
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 29:
+		return updateMySQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateMySQLDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updateMySQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateMySQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateMySQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateMySQLDatabaseFromV34(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -816,6 +976,18 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 30:
+		return downgradeMySQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeMySQLDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradeMySQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeMySQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeMySQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeMySQLDatabaseFromV35(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -853,3 +1025,165 @@ func (p *MySQLProvider) normalizeError(err error, fieldType int) error {
 	}
 	return err
 }
+
+func updateMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV30(dbHandle)
+}
+
+func updateMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV31(dbHandle)
+}
+
+func updateMySQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV32(dbHandle)
+}
+
+func updateMySQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV33(dbHandle)
+}
+
+func updateMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV34(dbHandle)
+}
+
+func updateMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom34To35(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return downgradeMySQLDatabaseFrom30To29(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV30(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV31(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV32(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV33(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV34(dbHandle)
+}
+
+func updateMySQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(mysqlV30SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, true)
+}
+
+func downgradeMySQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(mysqlV30DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, false)
+}
+
+func updateMySQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(mysqlV31SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 31, true)
+}
+
+func downgradeMySQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(mysqlV31DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, false)
+}
+
+func updateMySQLDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(mysqlV32SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 32, true)
+}
+
+func downgradeMySQLDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(mysqlV32DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 31, false)
+}
+
+func updateMySQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := sqlReplaceAll(mysqlV33SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, true)
+}
+
+func downgradeMySQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := sqlReplaceAll(mysqlV33DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 32, false)
+}
+
+func updateMySQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := sqlReplaceAll(mysqlV34SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 34, true)
+}
+
+func downgradeMySQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := sqlReplaceAll(mysqlV34DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 33, false)
+}
+
+func updateMySQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(mysqlV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 35, true)
+}
+
+func downgradeMySQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(mysqlV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 34, false)
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported OAuth2 application scopes
+const (
+	// OAuth2ScopeFilesRead allows to list and download files
+	OAuth2ScopeFilesRead = "files:read"
+	// OAuth2ScopeFilesWrite allows to list, download, upload and modify files
+	OAuth2ScopeFilesWrite = "files:write"
+)
+
+const (
+	defaultOAuth2TokenValidity = 60
+	maxOAuth2TokenValidity     = 1440
+)
+
+// OAuth2App defines a third party application registered by an admin.
+// Registered applications can obtain user consented, scope limited tokens
+// for the WebClient REST API using the OAuth2 authorization code flow
+type OAuth2App struct {
+	// ClientID is the unique application identifier, auto generated
+	ClientID string `json:"client_id"`
+	// ClientSecret is the hashed application secret, auto generated
+	ClientSecret string `json:"client_secret,omitempty"`
+	// Name of the application, displayed on the consent page
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Allowed redirect URIs, they must match exactly
+	RedirectURIs []string `json:"redirect_uris"`
+	// Scopes the application can request
+	Scopes []string `json:"scopes"`
+	// Validity for the issued access tokens, as minutes
+	TokenValidity int   `json:"token_validity"`
+	CreatedAt     int64 `json:"created_at"`
+	// plain text secret, only available after creation
+	plainSecret string
+}
+
+func (a *OAuth2App) getACopy() OAuth2App {
+	redirectURIs := make([]string, len(a.RedirectURIs))
+	copy(redirectURIs, a.RedirectURIs)
+	scopes := make([]string, len(a.Scopes))
+	copy(scopes, a.Scopes)
+
+	return OAuth2App{
+		ClientID:      a.ClientID,
+		ClientSecret:  a.ClientSecret,
+		Name:          a.Name,
+		Description:   a.Description,
+		RedirectURIs:  redirectURIs,
+		Scopes:        scopes,
+		TokenValidity: a.TokenValidity,
+		CreatedAt:     a.CreatedAt,
+		plainSecret:   a.plainSecret,
+	}
+}
+
+// HideConfidentialData hides application confidential data
+func (a *OAuth2App) HideConfidentialData() {
+	a.ClientSecret = ""
+}
+
+// GetPlainSecret returns the plain text client secret.
+// It is only available for newly created applications
+func (a *OAuth2App) GetPlainSecret() string {
+	return a.plainSecret
+}
+
+// GetTokenValidity returns the validity for the issued access tokens
+func (a *OAuth2App) GetTokenValidity() time.Duration {
+	return time.Duration(a.TokenValidity) * time.Minute
+}
+
+// HasRedirectURI returns true if the specified redirect URI is allowed
+func (a *OAuth2App) HasRedirectURI(redirectURI string) bool {
+	return util.Contains(a.RedirectURIs, redirectURI)
+}
+
+// HasScope returns true if the application can request the specified scope
+func (a *OAuth2App) HasScope(scope string) bool {
+	return util.Contains(a.Scopes, scope)
+}
+
+func (a *OAuth2App) generateSecret() error {
+	if a.ClientID != "" || a.ClientSecret != "" {
+		return nil
+	}
+	a.ClientID = util.GenerateUniqueID()
+	a.plainSecret = util.GenerateUniqueID() + util.GenerateUniqueID()
+	if config.PasswordHashing.Algo == HashingAlgoBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(a.plainSecret), config.PasswordHashing.BcryptOptions.Cost)
+		if err != nil {
+			return err
+		}
+		a.ClientSecret = util.BytesToString(hashed)
+	} else {
+		hashed, err := argon2id.CreateHash(a.plainSecret, argon2Params)
+		if err != nil {
+			return err
+		}
+		a.ClientSecret = hashed
+	}
+	a.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return nil
+}
+
+func (a *OAuth2App) validate() error {
+	if a.ClientID == "" || a.ClientSecret == "" {
+		return util.NewValidationError("oauth2 app: client id and secret are mandatory")
+	}
+	if !util.IsStringPrefixInSlice(a.ClientSecret, internalHashPwdPrefixes) {
+		return util.NewValidationError(fmt.Sprintf("oauth2 app %q: the client secret must be hashed", a.ClientID))
+	}
+	if a.Name == "" {
+		return util.NewValidationError(fmt.Sprintf("oauth2 app %q: name is mandatory", a.ClientID))
+	}
+	if len(a.RedirectURIs) == 0 {
+		return util.NewValidationError(fmt.Sprintf("oauth2 app %q: at least a redirect URI is required", a.Name))
+	}
+	for _, redirectURI := range a.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return util.NewValidationError(fmt.Sprintf("oauth2 app %q: invalid redirect URI %q", a.Name, redirectURI))
+		}
+		if u.Scheme != "https" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+			return util.NewValidationError(fmt.Sprintf("oauth2 app %q: redirect URI %q must use https", a.Name,
+				redirectURI))
+		}
+	}
+	a.Scopes = util.RemoveDuplicates(a.Scopes, true)
+	if len(a.Scopes) == 0 {
+		return util.NewValidationError(fmt.Sprintf("oauth2 app %q: at least a scope is required", a.Name))
+	}
+	for _, scope := range a.Scopes {
+		if scope != OAuth2ScopeFilesRead && scope != OAuth2ScopeFilesWrite {
+			return util.NewValidationError(fmt.Sprintf("oauth2 app %q: invalid scope %q", a.Name, scope))
+		}
+	}
+	if a.TokenValidity == 0 {
+		a.TokenValidity = defaultOAuth2TokenValidity
+	}
+	if a.TokenValidity < 0 || a.TokenValidity > maxOAuth2TokenValidity {
+		return util.NewValidationError(fmt.Sprintf("oauth2 app %q: invalid token validity %d, allowed range 1-%d minutes",
+			a.Name, a.TokenValidity, maxOAuth2TokenValidity))
+	}
+	return nil
+}
+
+// Authenticate checks the provided client secret
+func (a *OAuth2App) Authenticate(secret string) error {
+	if strings.HasPrefix(a.ClientSecret, bcryptPwdPrefix) {
+		if err := bcrypt.CompareHashAndPassword([]byte(a.ClientSecret), []byte(secret)); err != nil {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	if strings.HasPrefix(a.ClientSecret, argonPwdPrefix) {
+		match, err := argon2id.ComparePasswordAndHash(secret, a.ClientSecret)
+		if err != nil || !match {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	return ErrInvalidCredentials
+}
+
+// GetOAuth2Apps returns the registered OAuth2 applications
+func GetOAuth2Apps() ([]OAuth2App, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]OAuth2App, 0, len(configs.OAuth2Apps))
+	for idx := range configs.OAuth2Apps {
+		apps = append(apps, configs.OAuth2Apps[idx].getACopy())
+	}
+	return apps, nil
+}
+
+// OAuth2AppExists returns the OAuth2 application with the specified client ID
+func OAuth2AppExists(clientID string) (OAuth2App, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return OAuth2App{}, err
+	}
+	for idx := range configs.OAuth2Apps {
+		if configs.OAuth2Apps[idx].ClientID == clientID {
+			return configs.OAuth2Apps[idx].getACopy(), nil
+		}
+	}
+	return OAuth2App{}, util.NewRecordNotFoundError(fmt.Sprintf("OAuth2 app %q does not exist", clientID))
+}
+
+// AddOAuth2App registers a new OAuth2 application. The client ID and secret
+// are generated, the plain text secret is available using GetPlainSecret
+func AddOAuth2App(app *OAuth2App, executor, ipAddress, role string) error {
+	app.ClientID = ""
+	app.ClientSecret = ""
+	if err := app.generateSecret(); err != nil {
+		return err
+	}
+	if err := app.validate(); err != nil {
+		return err
+	}
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	configs.OAuth2Apps = append(configs.OAuth2Apps, app.getACopy())
+	return UpdateConfigs(&configs, executor, ipAddress, role)
+}
+
+// DeleteOAuth2App removes the OAuth2 application with the specified client ID.
+// The tokens already issued remain valid until they expire
+func DeleteOAuth2App(clientID, executor, ipAddress, role string) error {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	apps := make([]OAuth2App, 0, len(configs.OAuth2Apps))
+	for _, app := range configs.OAuth2Apps {
+		if app.ClientID != clientID {
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) == len(configs.OAuth2Apps) {
+		return util.NewRecordNotFoundError(fmt.Sprintf("OAuth2 app %q does not exist", clientID))
+	}
+	configs.OAuth2Apps = apps
+	return UpdateConfigs(&configs, executor, ipAddress, role)
+}
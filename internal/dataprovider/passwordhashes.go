@@ -0,0 +1,157 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	passwordHashSchemes = map[string]string{
+		argonPwdPrefix:            HashingAlgoArgon2ID,
+		bcryptPwdPrefix:           HashingAlgoBcrypt,
+		pbkdf2SHA1Prefix:          "pbkdf2-sha1",
+		pbkdf2SHA256Prefix:        "pbkdf2-sha256",
+		pbkdf2SHA512Prefix:        "pbkdf2-sha512",
+		pbkdf2SHA256B64SaltPrefix: "pbkdf2-b64salt-sha256",
+		md5cryptPwdPrefix:         "md5crypt",
+		md5cryptApr1PwdPrefix:     "apr1",
+		sha256cryptPwdPrefix:      "sha256crypt",
+		sha512cryptPwdPrefix:      "sha512crypt",
+		yescryptPwdPrefix:         "yescrypt",
+		md5DigestPwdPrefix:        "md5",
+		sha256DigestPwdPrefix:     "sha256",
+		sha512DigestPwdPrefix:     "sha512",
+	}
+)
+
+// WeakPasswordHash defines a password hash that will be upgraded to
+// the configured algorithm and parameters on the next successful login
+type WeakPasswordHash struct {
+	Username string `json:"username"`
+	// Hashing scheme, for example bcrypt, argon2id, sha512crypt
+	Scheme string `json:"scheme"`
+	Reason string `json:"reason"`
+}
+
+// PasswordHashesReport lists the users and admins with a password hash weaker
+// than the configured algorithm and parameters
+type PasswordHashesReport struct {
+	// Configured hashing algorithm
+	Algo   string             `json:"algo"`
+	Users  []WeakPasswordHash `json:"users"`
+	Admins []WeakPasswordHash `json:"admins"`
+}
+
+func getBcryptCost() int {
+	// bcrypt uses the default cost if the configured one is too low
+	if config.PasswordHashing.BcryptOptions.Cost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return config.PasswordHashing.BcryptOptions.Cost
+}
+
+func getPasswordHashScheme(hash string) string {
+	for prefix, scheme := range passwordHashSchemes {
+		if strings.HasPrefix(hash, prefix) {
+			return scheme
+		}
+	}
+	return "unknown"
+}
+
+// getPasswordHashUpgradeReason returns the reason why the specified hash should
+// be replaced using the configured algorithm and parameters, an empty string
+// means that no upgrade is needed
+func getPasswordHashUpgradeReason(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, bcryptPwdPrefix):
+		if config.PasswordHashing.Algo != HashingAlgoBcrypt {
+			return fmt.Sprintf("the configured algorithm is %s", config.PasswordHashing.Algo)
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return ""
+		}
+		if expected := getBcryptCost(); cost < expected {
+			return fmt.Sprintf("bcrypt cost %d is lower than %d", cost, expected)
+		}
+		return ""
+	case strings.HasPrefix(hash, argonPwdPrefix):
+		if config.PasswordHashing.Algo != HashingAlgoArgon2ID {
+			return fmt.Sprintf("the configured algorithm is %s", config.PasswordHashing.Algo)
+		}
+		params, _, _, err := argon2id.DecodeHash(hash)
+		if err != nil || argon2Params == nil {
+			return ""
+		}
+		if params.Memory < argon2Params.Memory || params.Iterations < argon2Params.Iterations ||
+			params.KeyLength < argon2Params.KeyLength {
+			return fmt.Sprintf("argon2id parameters memory %d, iterations %d are weaker than the configured ones",
+				params.Memory, params.Iterations)
+		}
+		return ""
+	default:
+		return "legacy hashing scheme"
+	}
+}
+
+func isPasswordHashUpgradeNeeded(hash string) bool {
+	return getPasswordHashUpgradeReason(hash) != ""
+}
+
+// GetPasswordHashesReport returns the users and admins whose password hash will be
+// upgraded on the next successful login
+func GetPasswordHashesReport() (PasswordHashesReport, error) {
+	report := PasswordHashesReport{
+		Algo:   config.PasswordHashing.Algo,
+		Users:  []WeakPasswordHash{},
+		Admins: []WeakPasswordHash{},
+	}
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return report, err
+	}
+	for idx := range users {
+		if users[idx].Password == "" {
+			continue
+		}
+		if reason := getPasswordHashUpgradeReason(users[idx].Password); reason != "" {
+			report.Users = append(report.Users, WeakPasswordHash{
+				Username: users[idx].Username,
+				Scheme:   getPasswordHashScheme(users[idx].Password),
+				Reason:   reason,
+			})
+		}
+	}
+	admins, err := provider.dumpAdmins()
+	if err != nil {
+		return report, err
+	}
+	for idx := range admins {
+		if reason := getPasswordHashUpgradeReason(admins[idx].Password); reason != "" {
+			report.Admins = append(report.Admins, WeakPasswordHash{
+				Username: admins[idx].Username,
+				Scheme:   getPasswordHashScheme(admins[idx].Password),
+				Reason:   reason,
+			})
+		}
+	}
+	return report, nil
+}
@@ -60,6 +60,8 @@ DROP TABLE IF EXISTS "{{events_rules}}" CASCADE;
 DROP TABLE IF EXISTS "{{tasks}}" CASCADE;
 DROP TABLE IF EXISTS "{{nodes}}" CASCADE;
 DROP TABLE IF EXISTS "{{roles}}" CASCADE;
+DROP TABLE IF EXISTS "{{tenants}}" CASCADE;
+DROP TABLE IF EXISTS "{{audit_logs}}" CASCADE;
 DROP TABLE IF EXISTS "{{ip_lists}}" CASCADE;
 DROP TABLE IF EXISTS "{{configs}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
@@ -209,6 +211,66 @@ INSERT INTO {{schema_version}} (version) VALUES (29);
 `
 	// not supported in CockroachDB
 	ipListsLikeIndex = `CREATE INDEX "{{prefix}}ip_lists_ipornet_like_idx" ON "{{ip_lists}}" ("ipornet" varchar_pattern_ops);`
+	pgsqlV30SQL      = `ALTER TABLE "{{api_keys}}" ADD COLUMN "filters" text NULL;`
+	pgsqlV30DownSQL  = `ALTER TABLE "{{api_keys}}" DROP COLUMN "filters" CASCADE;`
+	pgsqlV31SQL      = `ALTER TABLE "{{shares}}" ADD COLUMN "options" text NULL;`
+	pgsqlV31DownSQL  = `ALTER TABLE "{{shares}}" DROP COLUMN "options" CASCADE;`
+	pgsqlV32SQL      = `ALTER TABLE "{{shares}}" ADD COLUMN "stats" text NULL;`
+	pgsqlV32DownSQL  = `ALTER TABLE "{{shares}}" DROP COLUMN "stats" CASCADE;`
+	pgsqlV33SQL      = `CREATE TABLE "{{tenants}}" ("id" integer NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(512) NULL, "branding" text NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);
+ALTER TABLE "{{admins}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{users}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{groups}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{roles}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{folders}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{events_rules}}" ADD COLUMN "tenant_id" integer NULL;
+ALTER TABLE "{{admins}}" ADD CONSTRAINT "{{prefix}}admins_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{users}}" ADD CONSTRAINT "{{prefix}}users_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{groups}}" ADD CONSTRAINT "{{prefix}}groups_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{roles}}" ADD CONSTRAINT "{{prefix}}roles_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{folders}}" ADD CONSTRAINT "{{prefix}}folders_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{events_rules}}" ADD CONSTRAINT "{{prefix}}events_rules_tenant_id_fk_tenants_id" FOREIGN KEY ("tenant_id")
+REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+CREATE INDEX "{{prefix}}admins_tenant_id_idx" ON "{{admins}}" ("tenant_id");
+CREATE INDEX "{{prefix}}users_tenant_id_idx" ON "{{users}}" ("tenant_id");
+CREATE INDEX "{{prefix}}groups_tenant_id_idx" ON "{{groups}}" ("tenant_id");
+CREATE INDEX "{{prefix}}roles_tenant_id_idx" ON "{{roles}}" ("tenant_id");
+CREATE INDEX "{{prefix}}folders_tenant_id_idx" ON "{{folders}}" ("tenant_id");
+CREATE INDEX "{{prefix}}events_rules_tenant_id_idx" ON "{{events_rules}}" ("tenant_id");
+`
+	pgsqlV33DownSQL = `DROP INDEX IF EXISTS "{{prefix}}admins_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}users_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}groups_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}roles_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}folders_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}events_rules_tenant_id_idx";
+ALTER TABLE "{{admins}}" DROP COLUMN "tenant_id" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "tenant_id" CASCADE;
+ALTER TABLE "{{groups}}" DROP COLUMN "tenant_id" CASCADE;
+ALTER TABLE "{{roles}}" DROP COLUMN "tenant_id" CASCADE;
+ALTER TABLE "{{folders}}" DROP COLUMN "tenant_id" CASCADE;
+ALTER TABLE "{{events_rules}}" DROP COLUMN "tenant_id" CASCADE;
+DROP TABLE IF EXISTS "{{tenants}}" CASCADE;
+`
+	pgsqlV34SQL = `CREATE TABLE "{{audit_logs}}" ("id" bigint NOT NULL PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+"created_at" bigint NOT NULL, "node" varchar(255) NOT NULL, "category" varchar(50) NOT NULL,
+"action" varchar(100) NOT NULL, "username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL,
+"protocol" varchar(30) NOT NULL, "object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL,
+"role" varchar(255) NOT NULL, "status" integer NOT NULL, "info" text NULL, "prev_hash" varchar(64) NOT NULL,
+"hash" varchar(64) NOT NULL);
+CREATE INDEX "{{prefix}}audit_logs_node_id_idx" ON "{{audit_logs}}" ("node", "id");
+`
+	pgsqlV34DownSQL = `DROP INDEX IF EXISTS "{{prefix}}audit_logs_node_id_idx";
+DROP TABLE IF EXISTS "{{audit_logs}}" CASCADE;
+`
+	pgsqlV35SQL     = `ALTER TABLE "{{tenants}}" ADD COLUMN "storage_base_path" varchar(512) NULL;`
+	pgsqlV35DownSQL = `ALTER TABLE "{{tenants}}" DROP COLUMN "storage_base_path" CASCADE;`
 )
 
 var (
@@ -218,6 +280,7 @@ var (
 // PGSQLProvider defines the auth provider for PostgreSQL database
 type PGSQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReplicas
 }
 
 func init() {
@@ -253,7 +316,12 @@ func initializePGSQLProvider() error {
 	}
 	dbHandle.SetConnMaxLifetime(240 * time.Second)
 	dbHandle.SetConnMaxIdleTime(120 * time.Second)
-	provider = &PGSQLProvider{dbHandle: dbHandle}
+	replicas, err := newSQLReplicas("pgx", getPGSQLReplicaLag)
+	if err != nil {
+		dbHandle.Close()
+		return err
+	}
+	provider = &PGSQLProvider{dbHandle: dbHandle, replicas: replicas}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -261,6 +329,19 @@ func initializePGSQLProvider() error {
 	return dbHandle.PingContext(ctx)
 }
 
+// getPGSQLReplicaLag returns the time since the last replayed transaction,
+// the lag is 0 if there is nothing to replay
+func getPGSQLReplicaLag(ctx context.Context, dbHandle *sql.DB) (time.Duration, error) {
+	var lag float64
+	err := dbHandle.QueryRowContext(ctx, `SELECT CAST(CASE WHEN NOT pg_is_in_recovery() OR
+pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END AS DOUBLE PRECISION)`).Scan(&lag)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(lag * float64(time.Second)), nil
+}
+
 func getPGSQLHostsAndPorts(configHost string, configPort int) (string, string) {
 	var hosts, ports []string
 	defaultPort := strconv.Itoa(configPort)
@@ -319,15 +400,15 @@ func (p *PGSQLProvider) checkAvailability() error {
 }
 
 func (p *PGSQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
-	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
+	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -374,6 +455,10 @@ func (p *PGSQLProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateAdminPassword(username, password string) error {
+	return sqlCommonUpdateAdminPassword(username, password, p.dbHandle)
+}
+
 func (p *PGSQLProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -382,8 +467,8 @@ func (p *PGSQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *PGSQLProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, role, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -394,8 +479,8 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -424,8 +509,8 @@ func (p *PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool, tenant string) ([]Group, error) {
+	return sqlCommonGetGroups(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -472,8 +557,8 @@ func (p *PGSQLProvider) deleteAdmin(admin Admin) error {
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+func (p *PGSQLProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
+	return sqlCommonGetAdmins(limit, offset, order, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpAdmins() ([]Admin, error) {
@@ -501,7 +586,7 @@ func (p *PGSQLProvider) deleteAPIKey(apiKey APIKey) error {
 }
 
 func (p *PGSQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
-	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+	return sqlCommonGetAPIKeys(limit, offset, order, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpAPIKeys() ([]APIKey, error) {
@@ -529,7 +614,7 @@ func (p *PGSQLProvider) deleteShare(share Share) error {
 }
 
 func (p *PGSQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+	return sqlCommonGetShares(limit, offset, order, username, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpShares() ([]Share, error) {
@@ -540,6 +625,10 @@ func (p *PGSQLProvider) updateShareLastUse(shareID string, numTokens int) error
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	return sqlCommonUpdateShareStats(shareID, ipAddress, filePaths, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
 	return sqlCommonGetDefenderHosts(from, limit, p.dbHandle)
 }
@@ -604,12 +693,16 @@ func (p *PGSQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, keyPrefix, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return sqlCommonGetEventActions(limit, offset, order, minimal, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -632,8 +725,8 @@ func (p *PGSQLProvider) deleteEventAction(action BaseEventAction) error {
 	return sqlCommonDeleteEventAction(action, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+func (p *PGSQLProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	return sqlCommonGetEventRules(limit, offset, order, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -712,14 +805,50 @@ func (p *PGSQLProvider) deleteRole(role Role) error {
 	return sqlCommonDeleteRole(role, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+func (p *PGSQLProvider) getRoles(limit int, offset int, order string, minimal bool, tenant string) ([]Role, error) {
+	return sqlCommonGetRoles(limit, offset, order, minimal, tenant, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *PGSQLProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addTenant(tenant *Tenant) error {
+	return p.normalizeError(sqlCommonAddTenant(tenant, p.dbHandle), fieldName)
+}
+
+func (p *PGSQLProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *PGSQLProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
+}
+
+func (p *PGSQLProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogEntries(from, limit, p.replicas.getHandle(p.dbHandle))
+}
+
+func (p *PGSQLProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	return sqlCommonGetLastAuditLogEntry(node, p.dbHandle)
+}
+
 func (p *PGSQLProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -737,7 +866,7 @@ func (p *PGSQLProvider) deleteIPListEntry(entry IPListEntry, softDelete bool) er
 }
 
 func (p *PGSQLProvider) getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error) {
-	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.dbHandle)
+	return sqlCommonGetIPListEntries(listType, filter, from, order, limit, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error) {
@@ -753,7 +882,7 @@ func (p *PGSQLProvider) countIPListEntries(listType IPListType) (int64, error) {
 }
 
 func (p *PGSQLProvider) getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error) {
-	return sqlCommonGetListEntriesForIP(ip, listType, p.dbHandle)
+	return sqlCommonGetListEntriesForIP(ip, listType, p.replicas.getHandle(p.dbHandle))
 }
 
 func (p *PGSQLProvider) getConfigs() (Configs, error) {
@@ -773,6 +902,7 @@ func (p *PGSQLProvider) setFirstUploadTimestamp(username string) error {
 }
 
 func (p *PGSQLProvider) close() error {
+	p.replicas.close()
 	return p.dbHandle.Close()
 }
 
@@ -817,6 +947,18 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 29:
+		return updatePGSQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updatePGSQLDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updatePGSQLDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updatePGSQLDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updatePGSQLDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updatePGSQLDatabaseFromV34(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -839,6 +981,18 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 30:
+		return downgradePGSQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradePGSQLDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradePGSQLDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradePGSQLDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradePGSQLDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradePGSQLDatabaseFromV35(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -876,3 +1030,165 @@ func (p *PGSQLProvider) normalizeError(err error, fieldType int) error {
 	}
 	return err
 }
+
+func updatePGSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV30(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV31(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV32(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV33(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV34(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	return updatePGSQLDatabaseFrom34To35(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return downgradePGSQLDatabaseFrom30To29(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV30(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV31(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV32(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV33(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV34(dbHandle)
+}
+
+func updatePGSQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(pgsqlV30SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func downgradePGSQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(pgsqlV30DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func updatePGSQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(pgsqlV31SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
+func downgradePGSQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(pgsqlV31DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+func updatePGSQLDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(pgsqlV32SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, true)
+}
+
+func downgradePGSQLDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(pgsqlV32DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, false)
+}
+
+func updatePGSQLDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := sqlReplaceAll(pgsqlV33SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func downgradePGSQLDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := sqlReplaceAll(pgsqlV33DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func updatePGSQLDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := sqlReplaceAll(pgsqlV34SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func downgradePGSQLDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := sqlReplaceAll(pgsqlV34DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func updatePGSQLDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(pgsqlV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func downgradePGSQLDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(pgsqlV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// PortForwardingPolicy defines the SSH port forwarding allowed for a user.
+// Port forwarding is disabled by default
+type PortForwardingPolicy struct {
+	// Allow local port forwarding, the client asks the server to connect to
+	// the specified destinations (direct-tcpip channels)
+	AllowLocal bool `json:"allow_local,omitempty"`
+	// Destinations allowed for local port forwarding as "host:port".
+	// The host can be "*", an hostname, an IP address or a network in CIDR
+	// notation, the port can be "*" or a port number. IPv6 addresses and
+	// networks must be enclosed in square brackets, for example "[fd00::/8]:22"
+	PermitOpen []string `json:"permit_open,omitempty"`
+	// Allow remote port forwarding, the server listens on the specified addresses
+	// and forwards the accepted connections to the client (tcpip-forward requests)
+	AllowRemote bool `json:"allow_remote,omitempty"`
+	// Addresses on which the server is allowed to listen for remote port forwarding
+	// as "host:port". The host is the bind address requested by the client or
+	// "*", the port can be "*" or a port number. Dynamically allocated ports,
+	// requested using port 0, are allowed only if the port is "*"
+	PermitListen []string `json:"permit_listen,omitempty"`
+	// Maximum number of concurrent forwarded connections and listeners for each
+	// SSH connection. 0 means no limit
+	MaxForwards int `json:"max_forwards,omitempty"`
+}
+
+// IsEnabled returns true if local or remote port forwarding is allowed
+func (p *PortForwardingPolicy) IsEnabled() bool {
+	return p.AllowLocal || p.AllowRemote
+}
+
+func (p *PortForwardingPolicy) getACopy() PortForwardingPolicy {
+	permitOpen := make([]string, len(p.PermitOpen))
+	copy(permitOpen, p.PermitOpen)
+	permitListen := make([]string, len(p.PermitListen))
+	copy(permitListen, p.PermitListen)
+
+	return PortForwardingPolicy{
+		AllowLocal:   p.AllowLocal,
+		PermitOpen:   permitOpen,
+		AllowRemote:  p.AllowRemote,
+		PermitListen: permitListen,
+		MaxForwards:  p.MaxForwards,
+	}
+}
+
+func (p *PortForwardingPolicy) validate() error {
+	if p.MaxForwards < 0 {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("invalid max forwards: %d", p.MaxForwards)),
+			util.I18nErrorPortForwardingInvalid,
+		)
+	}
+	p.PermitOpen = util.RemoveDuplicates(p.PermitOpen, true)
+	for _, rule := range p.PermitOpen {
+		if _, _, err := parsePortForwardingRule(rule, true); err != nil {
+			return util.NewI18nError(err, util.I18nErrorPortForwardingInvalid)
+		}
+	}
+	p.PermitListen = util.RemoveDuplicates(p.PermitListen, true)
+	for _, rule := range p.PermitListen {
+		if _, _, err := parsePortForwardingRule(rule, false); err != nil {
+			return util.NewI18nError(err, util.I18nErrorPortForwardingInvalid)
+		}
+	}
+	if p.AllowLocal && len(p.PermitOpen) == 0 {
+		return util.NewI18nError(
+			util.NewValidationError("local port forwarding requires at least one permitted destination"),
+			util.I18nErrorPortForwardingInvalid,
+		)
+	}
+	if p.AllowRemote && len(p.PermitListen) == 0 {
+		return util.NewI18nError(
+			util.NewValidationError("remote port forwarding requires at least one permitted listen address"),
+			util.I18nErrorPortForwardingInvalid,
+		)
+	}
+	return nil
+}
+
+// IsOpenAllowed returns true if the specified destination is allowed for
+// local port forwarding. The host name, if any, and its resolved IP address
+// are both checked, callers must connect to the checked IP address
+func (p *PortForwardingPolicy) IsOpenAllowed(host string, ip net.IP, port uint32) bool {
+	if !p.AllowLocal {
+		return false
+	}
+	for _, rule := range p.PermitOpen {
+		ruleHost, rulePort, err := parsePortForwardingRule(rule, true)
+		if err != nil || (rulePort != "*" && rulePort != strconv.FormatUint(uint64(port), 10)) {
+			continue
+		}
+		if ruleHost == "*" || strings.EqualFold(ruleHost, host) {
+			return true
+		}
+		if ruleIP := net.ParseIP(ruleHost); ruleIP != nil {
+			if ruleIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(ruleHost); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsListenAllowed returns true if the server is allowed to listen on the
+// specified address for remote port forwarding
+func (p *PortForwardingPolicy) IsListenAllowed(host string, port uint32) bool {
+	if !p.AllowRemote {
+		return false
+	}
+	for _, rule := range p.PermitListen {
+		ruleHost, rulePort, err := parsePortForwardingRule(rule, false)
+		if err != nil {
+			continue
+		}
+		if rulePort != "*" && (port == 0 || rulePort != strconv.FormatUint(uint64(port), 10)) {
+			continue
+		}
+		if ruleHost == "*" || strings.EqualFold(ruleHost, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePortForwardingRule(rule string, allowNetworks bool) (string, string, error) {
+	host, port, err := net.SplitHostPort(rule)
+	if err != nil {
+		return "", "", util.NewValidationError(fmt.Sprintf("invalid port forwarding rule %q: %v", rule, err))
+	}
+	if host == "" {
+		return "", "", util.NewValidationError(fmt.Sprintf("invalid port forwarding rule %q: empty host", rule))
+	}
+	if port != "*" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return "", "", util.NewValidationError(fmt.Sprintf("invalid port forwarding rule %q: invalid port %q", rule, port))
+		}
+	}
+	if strings.Contains(host, "/") {
+		if !allowNetworks {
+			return "", "", util.NewValidationError(fmt.Sprintf("invalid port forwarding rule %q: networks are not allowed", rule))
+		}
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return "", "", util.NewValidationError(fmt.Sprintf("invalid port forwarding rule %q: %v", rule, err))
+		}
+	}
+	return host, port, nil
+}
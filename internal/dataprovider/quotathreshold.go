@@ -0,0 +1,71 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxQuotaWarnThresholds = 5
+)
+
+func validateQuotaWarnThresholds(thresholds []int) ([]int, error) {
+	if len(thresholds) == 0 {
+		return nil, nil
+	}
+	if len(thresholds) > maxQuotaWarnThresholds {
+		return nil, util.NewValidationError(fmt.Sprintf("too many quota warning thresholds, max allowed: %d",
+			maxQuotaWarnThresholds))
+	}
+	result := make([]int, 0, len(thresholds))
+	for _, t := range thresholds {
+		if t < 1 || t > 99 {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid quota warning threshold %d, allowed range: 1-99", t))
+		}
+		result = append(result, t)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// GetCrossedQuotaThreshold returns the highest quota warning threshold crossed
+// when the usage changes from usedBefore to usedAfter, or 0 if no threshold
+// is crossed. Thresholds are crossed only while the usage increases
+func (u *User) GetCrossedQuotaThreshold(quota, usedBefore, usedAfter int64) int {
+	if quota <= 0 || usedAfter <= usedBefore {
+		return 0
+	}
+	result := 0
+	for _, t := range u.Filters.QuotaWarnThresholds {
+		limit := quota * int64(t)
+		if 100*usedBefore < limit && 100*usedAfter >= limit {
+			result = t
+		}
+	}
+	return result
+}
+
+// GetLowestQuotaThreshold returns the lowest quota warning threshold or 0 if
+// no threshold is defined
+func (u *User) GetLowestQuotaThreshold() int {
+	if len(u.Filters.QuotaWarnThresholds) == 0 {
+		return 0
+	}
+	return slices.Min(u.Filters.QuotaWarnThresholds)
+}
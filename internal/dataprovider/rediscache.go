@@ -0,0 +1,364 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	redisCacheTimeout         = 2 * time.Second
+	redisCacheObjectUser      = "user"
+	redisCacheObjectGroup     = "group"
+	redisCacheObjectAllGroups = "groups"
+)
+
+// RedisCacheConfig defines a cache for users and groups shared between the
+// nodes of a cluster and backed by Redis. Updates are propagated to all the
+// nodes using Redis pub/sub, so they are visible immediately.
+// Virtual folders are cached as part of the users and groups
+type RedisCacheConfig struct {
+	// Redis URL, for example "redis://:password@localhost:6379/0".
+	// Use the "rediss" scheme for TLS connections. Leave empty to disable the cache
+	URL string `json:"url" mapstructure:"url"`
+	// Prefix for the cache keys and the pub/sub channel, it allows to share
+	// the same Redis instance between multiple SFTPGo clusters
+	KeyPrefix string `json:"key_prefix" mapstructure:"key_prefix"`
+	// Time, in seconds, after which a cached object expires
+	TTL int `json:"ttl" mapstructure:"ttl"`
+}
+
+func (c *RedisCacheConfig) isEnabled() bool {
+	return c.URL != ""
+}
+
+func (c *RedisCacheConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !util.Contains(sharedProviders, config.Driver) {
+		return fmt.Errorf("the Redis cache is not supported for the %q provider", config.Driver)
+	}
+	if _, err := redis.ParseURL(c.URL); err != nil {
+		return fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if c.KeyPrefix == "" {
+		return errors.New("the Redis cache key prefix is required")
+	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("invalid Redis cache TTL: %d", c.TTL)
+	}
+	return nil
+}
+
+type redisCacheMessage struct {
+	Node   string `json:"node"`
+	Object string `json:"object"`
+	Name   string `json:"name,omitempty"`
+}
+
+// redisCachedProvider wraps a provider and caches the users and groups read
+// for logins. Any change is propagated to the cache, the methods not overridden
+// here are forwarded to the wrapped provider
+type redisCachedProvider struct {
+	Provider
+	client  *redis.Client
+	pubSub  *redis.PubSub
+	prefix  string
+	channel string
+	nodeID  string
+	ttl     time.Duration
+}
+
+func newRedisCachedProvider(p Provider) (*redisCachedProvider, error) {
+	opts, err := redis.ParseURL(config.RedisCache.URL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to connect to Redis: %w", err)
+	}
+	c := &redisCachedProvider{
+		Provider: p,
+		client:   client,
+		prefix:   config.RedisCache.KeyPrefix,
+		channel:  config.RedisCache.KeyPrefix + ":invalidate",
+		nodeID:   util.GenerateUniqueID(),
+		ttl:      time.Duration(config.RedisCache.TTL) * time.Second,
+	}
+	c.pubSub = client.Subscribe(context.Background(), c.channel)
+	go c.handleMessages()
+	providerLog(logger.LevelDebug, "Redis cache enabled, address: %q, key prefix: %q, TTL: %s",
+		opts.Addr, c.prefix, c.ttl)
+	return c, nil
+}
+
+func (c *redisCachedProvider) getKey(object, name string) string {
+	return fmt.Sprintf("%s:%s:%s", c.prefix, object, name)
+}
+
+func (c *redisCachedProvider) getCachedObject(key string, obj any) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			providerLog(logger.LevelWarn, "unable to get %q from the Redis cache: %v", key, err)
+		}
+		return false
+	}
+	if err := json.Unmarshal(data, obj); err != nil {
+		providerLog(logger.LevelWarn, "unable to decode the Redis cached object %q: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (c *redisCachedProvider) setCachedObject(key string, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	if err := c.client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+		providerLog(logger.LevelWarn, "unable to add %q to the Redis cache: %v", key, err)
+	}
+}
+
+// invalidate removes the specified object from the cache and, if notify is true,
+// asks the other nodes to invalidate their in-memory caches
+func (c *redisCachedProvider) invalidate(object, name string, notify bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	if object == redisCacheObjectAllGroups {
+		iter := c.client.Scan(ctx, 0, c.getKey(redisCacheObjectGroup, "*"), 100).Iterator()
+		for iter.Next(ctx) {
+			if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+				providerLog(logger.LevelWarn, "unable to remove %q from the Redis cache: %v", iter.Val(), err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			providerLog(logger.LevelWarn, "unable to remove the groups from the Redis cache: %v", err)
+		}
+	} else {
+		key := c.getKey(object, name)
+		if err := c.client.Del(ctx, key).Err(); err != nil {
+			providerLog(logger.LevelWarn, "unable to remove %q from the Redis cache: %v", key, err)
+		}
+	}
+	if !notify {
+		return
+	}
+	msg, err := json.Marshal(redisCacheMessage{
+		Node:   c.nodeID,
+		Object: object,
+		Name:   name,
+	})
+	if err != nil {
+		return
+	}
+	if err := c.client.Publish(ctx, c.channel, msg).Err(); err != nil {
+		providerLog(logger.LevelWarn, "unable to publish the invalidation for %s %q: %v", object, name, err)
+	}
+}
+
+func (c *redisCachedProvider) handleMessages() {
+	for msg := range c.pubSub.Channel() {
+		var m redisCacheMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			providerLog(logger.LevelWarn, "invalid Redis cache message %q: %v", msg.Payload, err)
+			continue
+		}
+		if m.Node == c.nodeID {
+			continue
+		}
+		providerLog(logger.LevelDebug, "received Redis cache invalidation for %s %q", m.Object, m.Name)
+		if m.Object == redisCacheObjectUser {
+			removeCachedUser(m.Name)
+			cachedUserPasswords.Remove(m.Name)
+		}
+	}
+	providerLog(logger.LevelDebug, "Redis cache subscription closed")
+}
+
+func (c *redisCachedProvider) getUser(username string) (User, error) {
+	var user User
+	key := c.getKey(redisCacheObjectUser, username)
+	if c.getCachedObject(key, &user) {
+		return user, nil
+	}
+	user, err := c.Provider.userExists(username, "")
+	if err != nil {
+		return user, err
+	}
+	c.setCachedObject(key, &user)
+	return user, nil
+}
+
+func (c *redisCachedProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	user, err := c.getUser(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (c *redisCachedProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("credentials cannot be null or empty")
+	}
+	user, err := c.getUser(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+}
+
+func (c *redisCachedProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
+	var user User
+	if tlsCert == nil {
+		return user, errors.New("TLS certificate cannot be null or empty")
+	}
+	user, err := c.getUser(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
+		return user, err
+	}
+	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
+}
+
+func (c *redisCachedProvider) updateUser(user *User) error {
+	err := c.Provider.updateUser(user)
+	c.invalidate(redisCacheObjectUser, user.Username, true)
+	return err
+}
+
+func (c *redisCachedProvider) deleteUser(user User, softDelete bool) error {
+	err := c.Provider.deleteUser(user, softDelete)
+	c.invalidate(redisCacheObjectUser, user.Username, true)
+	return err
+}
+
+func (c *redisCachedProvider) updateUserPassword(username, password string) error {
+	err := c.Provider.updateUserPassword(username, password)
+	c.invalidate(redisCacheObjectUser, username, true)
+	return err
+}
+
+// setUpdatedAt is called for the users affected by changes to groups, folders and roles
+func (c *redisCachedProvider) setUpdatedAt(username string) {
+	c.Provider.setUpdatedAt(username)
+	c.invalidate(redisCacheObjectUser, username, true)
+}
+
+func (c *redisCachedProvider) updateLastLogin(username string) error {
+	err := c.Provider.updateLastLogin(username)
+	// the last login does not affect the in-memory caches
+	c.invalidate(redisCacheObjectUser, username, false)
+	return err
+}
+
+func (c *redisCachedProvider) groupExists(name string) (Group, error) {
+	var group Group
+	key := c.getKey(redisCacheObjectGroup, name)
+	if c.getCachedObject(key, &group) {
+		return group, nil
+	}
+	group, err := c.Provider.groupExists(name)
+	if err != nil {
+		return group, err
+	}
+	c.setCachedObject(key, &group)
+	return group, nil
+}
+
+func (c *redisCachedProvider) getGroupsWithNames(names []string) ([]Group, error) {
+	groups := make([]Group, 0, len(names))
+	var missing []string
+	for _, name := range names {
+		var group Group
+		if c.getCachedObject(c.getKey(redisCacheObjectGroup, name), &group) {
+			groups = append(groups, group)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return groups, nil
+	}
+	missingGroups, err := c.Provider.getGroupsWithNames(missing)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range missingGroups {
+		c.setCachedObject(c.getKey(redisCacheObjectGroup, missingGroups[idx].Name), &missingGroups[idx])
+	}
+	return append(groups, missingGroups...), nil
+}
+
+// groups are not cached in memory, the affected users are invalidated using setUpdatedAt
+func (c *redisCachedProvider) updateGroup(group *Group) error {
+	err := c.Provider.updateGroup(group)
+	c.invalidate(redisCacheObjectGroup, group.Name, false)
+	return err
+}
+
+func (c *redisCachedProvider) deleteGroup(group Group) error {
+	err := c.Provider.deleteGroup(group)
+	c.invalidate(redisCacheObjectGroup, group.Name, false)
+	return err
+}
+
+// folders are embedded in groups, the users are invalidated using setUpdatedAt
+func (c *redisCachedProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	err := c.Provider.updateFolder(folder)
+	c.invalidate(redisCacheObjectAllGroups, "", false)
+	return err
+}
+
+func (c *redisCachedProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
+	err := c.Provider.deleteFolder(folder)
+	c.invalidate(redisCacheObjectAllGroups, "", false)
+	return err
+}
+
+func (c *redisCachedProvider) close() error {
+	c.pubSub.Close()
+	c.client.Close()
+	return c.Provider.close()
+}
@@ -38,6 +38,8 @@ type Role struct {
 	Admins []string `json:"admins,omitempty"`
 	// list of usernames associated with this role
 	Users []string `json:"users,omitempty"`
+	// Tenant the role belongs to, empty means no tenant
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // RenderAsJSON implements the renderer interface used within plugins
@@ -83,5 +85,6 @@ func (r *Role) getACopy() Role {
 		UpdatedAt:   r.UpdatedAt,
 		Users:       users,
 		Admins:      admins,
+		Tenant:      r.Tenant,
 	}
 }
@@ -0,0 +1,421 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	scheduledBackupPrefix     = "sftpgo_backup_"
+	scheduledBackupTimeFormat = "20060102T150405Z"
+	scheduledBackupAgeExt     = ".json.gz.age"
+	scheduledBackupArchiveExt = ".sftpgobak"
+	scheduledBackupTaskName   = "@scheduled_provider_backup"
+	// executions of the scheduled backup task on other nodes more recent
+	// than this interval prevent the execution on this node
+	scheduledBackupTaskInterval = 10 * time.Minute
+)
+
+var (
+	scheduledBackups scheduledBackupsStatus
+)
+
+// ScheduledBackupsConfig defines the configuration for encrypted data provider backups
+// executed on a schedule and uploaded to a virtual folder
+type ScheduledBackupsConfig struct {
+	// Cron expression in standard format, for example "0 2 * * *" to run a backup
+	// every day at 2:00 UTC. Empty means disabled
+	Schedule string `json:"schedule" mapstructure:"schedule"`
+	// Name of the virtual folder to upload the backups to. Any storage backend supported by
+	// virtual folders can be used, for example S3, Google Cloud Storage or Azure Blob Storage
+	Folder string `json:"folder" mapstructure:"folder"`
+	// Directory, relative to the folder root, for the backups
+	Path string `json:"path" mapstructure:"path"`
+	// age public keys, starting with "age1", used to encrypt the backups. They can be
+	// decrypted using the age CLI and the matching identities
+	AgeRecipients []string `json:"age_recipients" mapstructure:"age_recipients"`
+	// Path to a file containing the passphrase used to encrypt the backups in the SFTPGo
+	// encrypted archive format, they can be restored using the "restore" command.
+	// Ignored if age recipients are set
+	PassphraseFile string `json:"passphrase_file" mapstructure:"passphrase_file"`
+	// Number of backups to keep, older backups are removed. 0 means no pruning
+	Retention  int `json:"retention" mapstructure:"retention"`
+	recipients []age.Recipient
+	passphrase string
+}
+
+func (c *ScheduledBackupsConfig) isEnabled() bool {
+	return c.Schedule != ""
+}
+
+func (c *ScheduledBackupsConfig) validate(basePath string) error {
+	c.recipients = nil
+	c.passphrase = ""
+	if !c.isEnabled() {
+		return nil
+	}
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		return fmt.Errorf("invalid scheduled backups schedule %q: %w", c.Schedule, err)
+	}
+	if c.Folder == "" {
+		return errors.New("scheduled backups: a virtual folder is required")
+	}
+	c.Path = util.CleanPath(c.Path)
+	if c.Retention < 0 {
+		return fmt.Errorf("invalid scheduled backups retention: %d", c.Retention)
+	}
+	for _, r := range c.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return fmt.Errorf("scheduled backups: invalid age recipient %q: %w", r, err)
+		}
+		c.recipients = append(c.recipients, recipient)
+	}
+	if len(c.recipients) > 0 {
+		return nil
+	}
+	if c.PassphraseFile == "" {
+		return errors.New("scheduled backups: age recipients or a passphrase file are required to encrypt the backups")
+	}
+	passphrase, err := util.ReadConfigFromFile(c.PassphraseFile, basePath)
+	if err != nil {
+		return fmt.Errorf("scheduled backups: unable to read the passphrase file: %w", err)
+	}
+	if passphrase == "" {
+		return errors.New("scheduled backups: the passphrase file is empty")
+	}
+	c.passphrase = passphrase
+	return nil
+}
+
+// encrypt returns the encrypted backup and the file extension to use
+func (c *ScheduledBackupsConfig) encrypt(data *BackupData) ([]byte, string, error) {
+	if len(c.recipients) == 0 {
+		content, err := EncryptBackup(data, c.passphrase)
+		return content, scheduledBackupArchiveExt, err
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, c.recipients...)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to initialize age encryption: %w", err)
+	}
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(data); err != nil {
+		return nil, "", fmt.Errorf("unable to encode the backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", fmt.Errorf("unable to compress the backup: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", fmt.Errorf("unable to encrypt the backup: %w", err)
+	}
+	return buf.Bytes(), scheduledBackupAgeExt, nil
+}
+
+func (c *ScheduledBackupsConfig) getFilesystem() (vfs.Fs, error) {
+	baseFolder, err := provider.getFolderByName(c.Folder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get folder %q: %w", c.Folder, err)
+	}
+	folder := vfs.VirtualFolder{
+		BaseVirtualFolder: baseFolder,
+		VirtualPath:       "/",
+	}
+	fs, err := folder.GetFilesystem(xid.New().String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the filesystem for folder %q: %w", c.Folder, err)
+	}
+	return fs, nil
+}
+
+func (c *ScheduledBackupsConfig) createDirs(fs vfs.Fs) error {
+	fs.CheckRootPath("", -1, -1)
+	dirs := util.GetDirsForVirtualPath(c.Path)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if dirs[idx] == "/" {
+			continue
+		}
+		fsPath, err := fs.ResolvePath(dirs[idx])
+		if err != nil {
+			return err
+		}
+		if _, err := fs.Stat(fsPath); err == nil || !fs.IsNotExist(err) {
+			continue
+		}
+		if err := fs.Mkdir(fsPath); err != nil {
+			return fmt.Errorf("unable to create dir %q: %w", dirs[idx], err)
+		}
+	}
+	return nil
+}
+
+func (c *ScheduledBackupsConfig) upload(fs vfs.Fs, name string, content []byte) error {
+	if err := c.createDirs(fs); err != nil {
+		return err
+	}
+	fsPath, err := fs.ResolvePath(path.Join(c.Path, name))
+	if err != nil {
+		return err
+	}
+	f, w, cancelFn, err := fs.Create(fsPath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", name, err)
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	defer cancelFn()
+
+	var writer io.WriteCloser = w
+	if f != nil {
+		writer = f
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return fmt.Errorf("unable to write %q: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("unable to upload %q: %w", name, err)
+	}
+	return nil
+}
+
+// prune removes the oldest backups exceeding the configured retention
+func (c *ScheduledBackupsConfig) prune(fs vfs.Fs) error {
+	if c.Retention == 0 {
+		return nil
+	}
+	dirPath, err := fs.ResolvePath(c.Path)
+	if err != nil {
+		return err
+	}
+	lister, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	defer lister.Close()
+
+	var backups []string
+	for {
+		entries, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, info := range entries {
+			if isScheduledBackupFile(info) {
+				backups = append(backups, info.Name())
+			}
+		}
+		if finished {
+			break
+		}
+	}
+	if len(backups) <= c.Retention {
+		return nil
+	}
+	// the names include the creation time so they sort chronologically
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-c.Retention] {
+		fsPath, err := fs.ResolvePath(path.Join(c.Path, name))
+		if err != nil {
+			return err
+		}
+		if err := fs.Remove(fsPath, false); err != nil {
+			return fmt.Errorf("unable to remove backup %q: %w", name, err)
+		}
+		providerLog(logger.LevelDebug, "scheduled backup %q removed", name)
+	}
+	return nil
+}
+
+func (c *ScheduledBackupsConfig) execute() (string, int64, error) {
+	data, err := DumpData(nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to dump backup data: %w", err)
+	}
+	content, ext, err := c.encrypt(&data)
+	if err != nil {
+		return "", 0, err
+	}
+	name := scheduledBackupPrefix + time.Now().UTC().Format(scheduledBackupTimeFormat) + ext
+	fs, err := c.getFilesystem()
+	if err != nil {
+		return name, 0, err
+	}
+	defer fs.Close()
+
+	if err := c.upload(fs, name, content); err != nil {
+		return name, 0, err
+	}
+	if err := c.prune(fs); err != nil {
+		// the backup was uploaded, pruning will be retried on the next execution
+		providerLog(logger.LevelError, "unable to prune scheduled backups: %v", err)
+	}
+	return name, int64(len(content)), nil
+}
+
+func isScheduledBackupFile(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || !strings.HasPrefix(info.Name(), scheduledBackupPrefix) {
+		return false
+	}
+	return strings.HasSuffix(info.Name(), scheduledBackupAgeExt) ||
+		strings.HasSuffix(info.Name(), scheduledBackupArchiveExt)
+}
+
+// ScheduledBackupStatus defines the status of the scheduled backups
+type ScheduledBackupStatus struct {
+	Enabled bool `json:"enabled"`
+	// Last execution as unix timestamp in milliseconds
+	LastRun int64 `json:"last_run,omitempty"`
+	// Last successful execution as unix timestamp in milliseconds
+	LastSuccess int64 `json:"last_success,omitempty"`
+	// Name of the last uploaded backup and its size in bytes
+	LastFile string `json:"last_file,omitempty"`
+	LastSize int64  `json:"last_size,omitempty"`
+	// Error for the last execution, if any
+	LastError string `json:"last_error,omitempty"`
+}
+
+type scheduledBackupsStatus struct {
+	sync.RWMutex
+	status ScheduledBackupStatus
+}
+
+func (s *scheduledBackupsStatus) reset(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.status = ScheduledBackupStatus{Enabled: enabled}
+}
+
+func (s *scheduledBackupsStatus) update(name string, size int64, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.status.LastRun = util.GetTimeAsMsSinceEpoch(time.Now())
+	if err != nil {
+		s.status.LastError = err.Error()
+	} else {
+		s.status.LastSuccess = s.status.LastRun
+		s.status.LastFile = name
+		s.status.LastSize = size
+		s.status.LastError = ""
+	}
+	metric.UpdateProviderBackupStatus(size, err)
+}
+
+func (s *scheduledBackupsStatus) get() ScheduledBackupStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.status
+}
+
+// canRunScheduledBackup returns false if another node in a shared setup is
+// already executing, or recently executed, the scheduled backup
+func canRunScheduledBackup() bool {
+	if config.IsShared != 1 {
+		return true
+	}
+	task, err := provider.getTaskByName(scheduledBackupTaskName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			providerLog(logger.LevelWarn, "unable to get the scheduled backup task: %v", err)
+			return false
+		}
+		if err := provider.addTask(scheduledBackupTaskName); err != nil {
+			providerLog(logger.LevelDebug, "unable to add the scheduled backup task: %v", err)
+			return false
+		}
+		task = Task{Name: scheduledBackupTaskName}
+	}
+	updatedAt := util.GetTimeFromMsecSinceEpoch(task.UpdateAt)
+	if updatedAt.Add(scheduledBackupTaskInterval).After(time.Now()) {
+		providerLog(logger.LevelDebug, "scheduled backup executed by another node at %s, skip", updatedAt)
+		return false
+	}
+	if err := provider.updateTask(scheduledBackupTaskName, task.Version); err != nil {
+		providerLog(logger.LevelDebug, "unable to update the scheduled backup task, skip execution: %v", err)
+		return false
+	}
+	return true
+}
+
+func executeScheduledBackup() {
+	if !canRunScheduledBackup() {
+		return
+	}
+	providerLog(logger.LevelInfo, "starting scheduled backup")
+	startTime := time.Now()
+	name, size, err := config.ScheduledBackups.execute()
+	if err != nil {
+		providerLog(logger.LevelError, "scheduled backup failed, elapsed %s: %v", time.Since(startTime), err)
+	} else {
+		providerLog(logger.LevelInfo, "scheduled backup %q uploaded, size %d, elapsed %s", name, size,
+			time.Since(startTime))
+	}
+	scheduledBackups.update(name, size, err)
+}
+
+func addScheduledBackups() error {
+	scheduledBackups.reset(config.ScheduledBackups.isEnabled())
+	if !config.ScheduledBackups.isEnabled() {
+		return nil
+	}
+	_, err := scheduler.AddFunc(config.ScheduledBackups.Schedule, executeScheduledBackup)
+	if err != nil {
+		return fmt.Errorf("unable to schedule backups: %w", err)
+	}
+	providerLog(logger.LevelInfo, "scheduled backups enabled, schedule %q, folder %q", config.ScheduledBackups.Schedule,
+		config.ScheduledBackups.Folder)
+	return nil
+}
+
+// GetScheduledBackupStatus returns the status of the scheduled backups
+func GetScheduledBackupStatus() ScheduledBackupStatus {
+	return scheduledBackups.get()
+}
+
+// ExecuteScheduledBackup runs the configured scheduled backup immediately
+func ExecuteScheduledBackup() (ScheduledBackupStatus, error) {
+	if !config.ScheduledBackups.isEnabled() {
+		return scheduledBackups.get(), errors.New("scheduled backups are not enabled")
+	}
+	name, size, err := config.ScheduledBackups.execute()
+	scheduledBackups.update(name, size, err)
+	return scheduledBackups.get(), err
+}
@@ -55,8 +55,15 @@ func startScheduler() error {
 	if err != nil {
 		return err
 	}
+	err = addScheduledBackups()
+	if err != nil {
+		return err
+	}
 	if currentNode != nil {
 		_, err = scheduler.AddFunc("@every 30m", func() {
+			if !IsClusterLeader() {
+				return
+			}
 			err := provider.cleanupNodes()
 			if err != nil {
 				providerLog(logger.LevelError, "unable to cleanup nodes: %v", err)
@@ -68,6 +75,13 @@ func startScheduler() error {
 	if err != nil {
 		return fmt.Errorf("unable to schedule nodes cleanup: %w", err)
 	}
+	if config.IsShared == 1 {
+		clusterLeader.check()
+		_, err = scheduler.AddFunc(fmt.Sprintf("@every %s", clusterLeaderCheckInterval), clusterLeader.check)
+		if err != nil {
+			return fmt.Errorf("unable to schedule cluster leader election: %w", err)
+		}
+	}
 	scheduler.Start()
 	return nil
 }
@@ -103,6 +117,7 @@ func checkCacheUpdates() {
 	cachedUserPasswords.cleanup()
 	cachedAdminPasswords.cleanup()
 	cachedAPIKeys.cleanup()
+	ldapEntries.cleanup()
 }
 
 func checkUserCache() {
@@ -122,15 +137,15 @@ func checkUserCache() {
 		providerLog(logger.LevelDebug, "invalidate caches for user %q", user.Username)
 		if user.DeletedAt > 0 {
 			deletedAt := util.GetTimeFromMsecSinceEpoch(user.DeletedAt)
-			if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
+			if deletedAt.Add(30*time.Minute).Before(time.Now()) && IsClusterLeader() {
 				providerLog(logger.LevelDebug, "removing user %q deleted at %s", user.Username, deletedAt)
 				go provider.deleteUser(user, false) //nolint:errcheck
 			}
-			webDAVUsersCache.remove(user.Username)
+			removeCachedUser(user.Username)
 			cachedUserPasswords.Remove(user.Username)
 			delayedQuotaUpdater.resetUserQuota(user.Username)
 		} else {
-			webDAVUsersCache.swap(&user, "")
+			swapCachedUser(&user, "")
 		}
 	}
 	lastUserCacheUpdate.Store(checkTime)
@@ -163,7 +178,7 @@ func checkIPListEntryCache() {
 		providerLog(logger.LevelDebug, "update cache for IP list entry %q", e.getName())
 		if e.DeletedAt > 0 {
 			deletedAt := util.GetTimeFromMsecSinceEpoch(e.DeletedAt)
-			if deletedAt.Add(30 * time.Minute).Before(time.Now()) {
+			if deletedAt.Add(30*time.Minute).Before(time.Now()) && IsClusterLeader() {
 				providerLog(logger.LevelDebug, "removing IP list entry %q deleted at %s", e.getName(), deletedAt)
 				go provider.deleteIPListEntry(e, false) //nolint:errcheck
 			}
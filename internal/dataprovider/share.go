@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -37,6 +38,9 @@ const (
 	ShareScopeRead ShareScope = iota + 1
 	ShareScopeWrite
 	ShareScopeReadWrite
+	// ShareScopeFileRequest allows external users to upload files, identifying
+	// themselves, without listing the existing contents
+	ShareScopeFileRequest
 )
 
 const (
@@ -70,12 +74,116 @@ type Share struct {
 	UsedTokens int `json:"used_tokens,omitempty"`
 	// Limit the share availability to these IPs/CIDR networks
 	AllowFrom []string `json:"allow_from,omitempty"`
+	// Additional options
+	Options ShareOptions `json:"options"`
+	// Download statistics, they are updated by SFTPGo and cannot be modified
+	Stats ShareStats `json:"stats"`
 	// set for restores, we don't have to validate the expiration date
 	// otherwise we fail to restore existing shares and we have to insert
 	// all the previous values with no modifications
 	IsRestore bool `json:"-"`
 }
 
+// ShareOptions defines additional options for shares
+type ShareOptions struct {
+	// Instructions displayed to the uploaders on the file request form
+	Instructions string `json:"instructions,omitempty"`
+	// Maximum total size, as bytes, each uploader can upload to a file request.
+	// Uploaders are identified by their email address. 0 means no limit
+	MaxUploaderSize int64 `json:"max_uploader_size,omitempty"`
+	// Maximum number of times each shared file can be downloaded, it does not
+	// apply to file requests. 0 means no limit
+	MaxFileDownloads int `json:"max_file_downloads,omitempty"`
+}
+
+// ShareStats defines the download statistics for a share
+type ShareStats struct {
+	// Total number of downloads
+	Downloads int `json:"downloads,omitempty"`
+	// Last access as unix timestamp in milliseconds and related IP address
+	LastAccessAt int64  `json:"last_access_at,omitempty"`
+	LastAccessIP string `json:"last_access_ip,omitempty"`
+	// Per file statistics, the key is the file path as seen by the share owner
+	Files map[string]ShareFileStats `json:"files,omitempty"`
+}
+
+// ShareFileStats defines the download statistics for a shared file
+type ShareFileStats struct {
+	Downloads      int    `json:"downloads"`
+	LastDownloadAt int64  `json:"last_download_at,omitempty"`
+	LastDownloadIP string `json:"last_download_ip,omitempty"`
+}
+
+func (s *ShareStats) getACopy() ShareStats {
+	var files map[string]ShareFileStats
+	if len(s.Files) > 0 {
+		files = make(map[string]ShareFileStats, len(s.Files))
+		for k, v := range s.Files {
+			files[k] = v
+		}
+	}
+	return ShareStats{
+		Downloads:    s.Downloads,
+		LastAccessAt: s.LastAccessAt,
+		LastAccessIP: s.LastAccessIP,
+		Files:        files,
+	}
+}
+
+func (s *ShareStats) update(ipAddress string, filePaths []string) {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	s.LastAccessAt = now
+	s.LastAccessIP = ipAddress
+	if len(filePaths) == 0 {
+		return
+	}
+	s.Downloads++
+	if s.Files == nil {
+		s.Files = make(map[string]ShareFileStats)
+	}
+	for _, p := range filePaths {
+		stats := s.Files[p]
+		stats.Downloads++
+		stats.LastDownloadAt = now
+		stats.LastDownloadIP = ipAddress
+		s.Files[p] = stats
+	}
+}
+
+// GetRemainingTokens returns the remaining access tokens, -1 means no limit
+func (s *Share) GetRemainingTokens() int {
+	if s.MaxTokens <= 0 {
+		return -1
+	}
+	return max(s.MaxTokens-s.UsedTokens, 0)
+}
+
+// GetFileRemainingDownloads returns the remaining downloads for the specified
+// file path, -1 means no limit
+func (s *Share) GetFileRemainingDownloads(filePath string) int {
+	if s.Options.MaxFileDownloads <= 0 {
+		return -1
+	}
+	return max(s.Options.MaxFileDownloads-s.Stats.Files[filePath].Downloads, 0)
+}
+
+// CheckFileDownloads returns an error if any of the specified file paths
+// cannot be downloaded anymore
+func (s *Share) CheckFileDownloads(filePaths []string) error {
+	for _, p := range filePaths {
+		if s.GetFileRemainingDownloads(p) == 0 {
+			return util.NewI18nError(fmt.Errorf("max downloads exceeded for %q: %w", p, os.ErrPermission),
+				util.I18nErrorShareFileDownloads)
+		}
+	}
+	return nil
+}
+
+// IsFileRequest returns true if the share is an upload only inbox
+func (s *Share) IsFileRequest() bool {
+	return s.Scope == ShareScopeFileRequest
+}
+
 // IsExpired returns true if the share is expired
 func (s *Share) IsExpired() bool {
 	if s.ExpiresAt > 0 {
@@ -109,6 +217,8 @@ func (s *Share) getACopy() Share {
 		MaxTokens:   s.MaxTokens,
 		UsedTokens:  s.UsedTokens,
 		AllowFrom:   allowFrom,
+		Options:     s.Options,
+		Stats:       s.Stats.getACopy(),
 	}
 }
 
@@ -208,7 +318,7 @@ func (s *Share) validate() error {
 	if s.Name == "" {
 		return util.NewI18nError(util.NewValidationError("name is mandatory"), util.I18nErrorNameRequired)
 	}
-	if s.Scope < ShareScopeRead || s.Scope > ShareScopeReadWrite {
+	if s.Scope < ShareScopeRead || s.Scope > ShareScopeFileRequest {
 		return util.NewI18nError(util.NewValidationError(fmt.Sprintf("invalid scope: %v", s.Scope)), util.I18nErrorShareScope)
 	}
 	if err := s.validatePaths(); err != nil {
@@ -224,6 +334,18 @@ func (s *Share) validate() error {
 	if s.MaxTokens < 0 {
 		return util.NewI18nError(util.NewValidationError("invalid max tokens"), util.I18nErrorShareMaxTokens)
 	}
+	if s.IsFileRequest() {
+		s.Options.MaxFileDownloads = 0
+	} else {
+		s.Options.Instructions = ""
+		s.Options.MaxUploaderSize = 0
+	}
+	if s.Options.MaxUploaderSize < 0 {
+		return util.NewI18nError(util.NewValidationError("invalid max uploader size"), util.I18nErrorShareMaxUploaderSize)
+	}
+	if s.Options.MaxFileDownloads < 0 {
+		return util.NewI18nError(util.NewValidationError("invalid max file downloads"), util.I18nErrorShareMaxFileDownloads)
+	}
 	if s.Username == "" {
 		return util.NewI18nError(util.NewValidationError("username is mandatory"), util.I18nErrorUsernameRequired)
 	}
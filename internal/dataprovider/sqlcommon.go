@@ -35,7 +35,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 29
+	sqlDatabaseVersion     = 35
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -58,6 +58,15 @@ type sqlScanner interface {
 	Scan(dest ...any) error
 }
 
+// getListQueryArgs returns the arguments for the queries built using getTenantFilter,
+// the tenant argument is omitted if empty
+func getListQueryArgs(tenant string, limit, offset int) []any {
+	if tenant == "" {
+		return []any{limit, offset}
+	}
+	return []any{tenant, limit, offset}
+}
+
 func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{schema_version}}", sqlTableSchemaVersion)
 	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
@@ -80,6 +89,8 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{tasks}}", sqlTableTasks)
 	sql = strings.ReplaceAll(sql, "{{nodes}}", sqlTableNodes)
 	sql = strings.ReplaceAll(sql, "{{roles}}", sqlTableRoles)
+	sql = strings.ReplaceAll(sql, "{{tenants}}", sqlTableTenants)
+	sql = strings.ReplaceAll(sql, "{{audit_logs}}", sqlTableAuditLogs)
 	sql = strings.ReplaceAll(sql, "{{ip_lists}}", sqlTableIPLists)
 	sql = strings.ReplaceAll(sql, "{{configs}}", sqlTableConfigs)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
@@ -125,6 +136,10 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 			allowFrom = res
 		}
 	}
+	options, err := json.Marshal(share.Options)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -134,6 +149,7 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 	createdAt := util.GetTimeAsMsSinceEpoch(time.Now())
 	updatedAt := createdAt
 	lastUseAt := int64(0)
+	var stats []byte
 	if share.IsRestore {
 		usedTokens = share.UsedTokens
 		if share.CreatedAt > 0 {
@@ -143,10 +159,14 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 			updatedAt = share.UpdatedAt
 		}
 		lastUseAt = share.LastUseAt
+		stats, err = json.Marshal(share.Stats)
+		if err != nil {
+			return err
+		}
 	}
 	_, err = dbHandle.ExecContext(ctx, q, share.ShareID, share.Name, share.Description, share.Scope,
 		paths, createdAt, updatedAt, lastUseAt, share.ExpiresAt, share.Password,
-		share.MaxTokens, usedTokens, allowFrom, user.ID)
+		share.MaxTokens, usedTokens, allowFrom, user.ID, util.BytesToString(options), stats)
 	return err
 }
 
@@ -168,6 +188,10 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 			allowFrom = res
 		}
 	}
+	options, err := json.Marshal(share.Options)
+	if err != nil {
+		return err
+	}
 
 	user, err := provider.userExists(share.Username, "")
 	if err != nil {
@@ -192,13 +216,17 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		if share.UpdatedAt == 0 {
 			share.UpdatedAt = share.CreatedAt
 		}
+		stats, err := json.Marshal(share.Stats)
+		if err != nil {
+			return err
+		}
 		res, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
 			share.CreatedAt, share.UpdatedAt, share.LastUseAt, share.ExpiresAt, share.Password, share.MaxTokens,
-			share.UsedTokens, allowFrom, user.ID, share.ShareID)
+			share.UsedTokens, allowFrom, user.ID, util.BytesToString(options), util.BytesToString(stats), share.ShareID)
 	} else {
 		res, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
 			util.GetTimeAsMsSinceEpoch(time.Now()), share.ExpiresAt, share.Password, share.MaxTokens,
-			allowFrom, user.ID, share.ShareID)
+			allowFrom, user.ID, util.BytesToString(options), share.ShareID)
 	}
 	if err != nil {
 		return err
@@ -290,13 +318,18 @@ func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 		return err
 	}
 
+	filters, err := json.Marshal(apiKey.Filters)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	q := getAddAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope,
 		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.LastUseAt,
-		apiKey.ExpiresAt, apiKey.Description, userID, adminID)
+		apiKey.ExpiresAt, apiKey.Description, userID, adminID, util.BytesToString(filters))
 	return err
 }
 
@@ -311,12 +344,17 @@ func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 		return err
 	}
 
+	filters, err := json.Marshal(apiKey.Filters)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	q := getUpdateAPIKeyQuery()
 	res, err := dbHandle.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
-		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
+		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), util.BytesToString(filters), apiKey.KeyID)
 	if err != nil {
 		return err
 	}
@@ -442,10 +480,10 @@ func sqlCommonAddAdmin(admin *Admin, dbHandle *sql.DB) error {
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getAddAdminQuery(admin.Role)
+		q := getAddAdminQuery(admin.Role, admin.Tenant)
 		_, err = tx.ExecContext(ctx, q, admin.Username, admin.Password, admin.Status, admin.Email, perms,
 			filters, admin.AdditionalInfo, admin.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), admin.Role)
+			util.GetTimeAsMsSinceEpoch(time.Now()), admin.Role, admin.Tenant)
 		if err != nil {
 			return err
 		}
@@ -495,13 +533,13 @@ func sqlCommonDeleteAdmin(admin Admin, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
-func sqlCommonGetAdmins(limit, offset int, order string, dbHandle sqlQuerier) ([]Admin, error) {
+func sqlCommonGetAdmins(limit, offset int, order, tenant string, dbHandle sqlQuerier) ([]Admin, error) {
 	admins := make([]Admin, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getAdminsQuery(order)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	q := getAdminsQuery(order, tenant)
+	rows, err := dbHandle.QueryContext(ctx, q, getListQueryArgs(tenant, limit, offset)...)
 	if err != nil {
 		return admins, err
 	}
@@ -826,14 +864,14 @@ func sqlCommonDumpRoles(dbHandle sqlQuerier) ([]Role, error) {
 	return roles, rows.Err()
 }
 
-func sqlCommonGetRoles(limit int, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]Role, error) {
+func sqlCommonGetRoles(limit int, offset int, order string, minimal bool, tenant string, dbHandle sqlQuerier) ([]Role, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getRolesQuery(order, minimal)
+	q := getRolesQuery(order, minimal, tenant)
 
 	roles := make([]Role, 0, limit)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, getListQueryArgs(tenant, limit, offset)...)
 	if err != nil {
 		return roles, err
 	}
@@ -872,9 +910,9 @@ func sqlCommonAddRole(role *Role, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getAddRoleQuery()
+	q := getAddRoleQuery(role.Tenant)
 	_, err := dbHandle.ExecContext(ctx, q, role.Name, role.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-		util.GetTimeAsMsSinceEpoch(time.Now()))
+		util.GetTimeAsMsSinceEpoch(time.Now()), role.Tenant)
 	return err
 }
 
@@ -905,6 +943,170 @@ func sqlCommonDeleteRole(role Role, dbHandle *sql.DB) error {
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonGetTenantByName(name string, dbHandle sqlQuerier) (Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTenantByNameQuery()
+	row := dbHandle.QueryRowContext(ctx, q, name)
+	return getTenantFromDbRow(row)
+}
+
+func sqlCommonDumpTenants(dbHandle sqlQuerier) ([]Tenant, error) {
+	tenants := make([]Tenant, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getDumpTenantsQuery()
+
+	rows, err := dbHandle.QueryContext(ctx, q)
+	if err != nil {
+		return tenants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tenant, err := getTenantFromDbRow(rows)
+		if err != nil {
+			return tenants, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+func sqlCommonGetTenants(limit int, offset int, order string, dbHandle sqlQuerier) ([]Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getTenantsQuery(order)
+
+	tenants := make([]Tenant, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	if err != nil {
+		return tenants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tenant, err := getTenantFromDbRow(rows)
+		if err != nil {
+			return tenants, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+func sqlCommonAddTenant(tenant *Tenant, dbHandle *sql.DB) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	branding, err := json.Marshal(tenant.Branding)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddTenantQuery()
+	_, err = dbHandle.ExecContext(ctx, q, tenant.Name, tenant.Description, branding, tenant.StorageBasePath,
+		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()))
+	return err
+}
+
+func sqlCommonUpdateTenant(tenant *Tenant, dbHandle *sql.DB) error {
+	if err := tenant.validate(); err != nil {
+		return err
+	}
+	branding, err := json.Marshal(tenant.Branding)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateTenantQuery()
+	res, err := dbHandle.ExecContext(ctx, q, tenant.Description, branding, tenant.StorageBasePath,
+		util.GetTimeAsMsSinceEpoch(time.Now()), tenant.Name)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonDeleteTenant(tenant Tenant, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		var references sql.NullInt64
+		q := getTenantReferencesQuery()
+		args := make([]any, 0, 6)
+		for idx := 0; idx < 6; idx++ {
+			args = append(args, tenant.Name)
+		}
+		if err := tx.QueryRowContext(ctx, q, args...).Scan(&references); err != nil {
+			return err
+		}
+		if references.Int64 > 0 {
+			return util.NewValidationError(fmt.Sprintf("tenant %q is still referenced by %d objects", tenant.Name,
+				references.Int64))
+		}
+		q = getDeleteTenantQuery()
+		res, err := tx.ExecContext(ctx, q, tenant.Name)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
+}
+
+func sqlCommonAddAuditLogEntry(entry *AuditLogEntry, dbHandle *sql.DB) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddAuditLogEntryQuery()
+	_, err := dbHandle.ExecContext(ctx, q, entry.Timestamp, entry.Node, entry.Category, entry.Action, entry.Username,
+		entry.IP, entry.Protocol, entry.ObjectType, entry.ObjectName, entry.Role, entry.Status, entry.Info,
+		entry.PrevHash, entry.Hash)
+	return err
+}
+
+func sqlCommonGetAuditLogEntries(from int64, limit int, dbHandle sqlQuerier) ([]AuditLogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getAuditLogEntriesQuery()
+	entries := make([]AuditLogEntry, 0, limit)
+	rows, err := dbHandle.QueryContext(ctx, q, from, limit)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := getAuditLogEntryFromDbRow(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func sqlCommonGetLastAuditLogEntry(node string, dbHandle sqlQuerier) (AuditLogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getLastAuditLogEntryQuery()
+	row := dbHandle.QueryRowContext(ctx, q, node)
+	return getAuditLogEntryFromDbRow(row)
+}
+
 func sqlCommonGetGroupByName(name string, dbHandle sqlQuerier) (Group, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1041,14 +1243,14 @@ func sqlCommonGetGroupsWithNames(names []string, dbHandle sqlQuerier) ([]Group,
 	return getGroupsWithVirtualFolders(ctx, groups, dbHandle)
 }
 
-func sqlCommonGetGroups(limit int, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]Group, error) {
+func sqlCommonGetGroups(limit int, offset int, order string, minimal bool, tenant string, dbHandle sqlQuerier) ([]Group, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getGroupsQuery(order, minimal)
+	q := getGroupsQuery(order, minimal, tenant)
 
 	groups := make([]Group, 0, limit)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, getListQueryArgs(tenant, limit, offset)...)
 	if err != nil {
 		return groups, err
 	}
@@ -1103,9 +1305,9 @@ func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getAddGroupQuery()
+		q := getAddGroupQuery(group.Tenant)
 		_, err := tx.ExecContext(ctx, q, group.Name, group.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), settings)
+			util.GetTimeAsMsSinceEpoch(time.Now()), settings, group.Tenant)
 		if err != nil {
 			return err
 		}
@@ -1277,6 +1479,40 @@ func sqlCommonUpdateShareLastUse(shareID string, numTokens int, dbHandle *sql.DB
 	return err
 }
 
+func sqlCommonUpdateShareStats(shareID, ipAddress string, filePaths []string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	err := sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		var data sql.NullString
+		if err := tx.QueryRowContext(ctx, getShareStatsQuery(), shareID).Scan(&data); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.NewRecordNotFoundError(fmt.Sprintf("share %q does not exist", shareID))
+			}
+			return err
+		}
+		var stats ShareStats
+		if data.Valid && data.String != "" {
+			if err := json.Unmarshal(util.StringToBytes(data.String), &stats); err != nil {
+				return err
+			}
+		}
+		stats.update(ipAddress, filePaths)
+		buf, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, getUpdateShareStatsQuery(), util.BytesToString(buf), shareID)
+		return err
+	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "stats updated for shared object %q", shareID)
+	} else {
+		providerLog(logger.LevelWarn, "error updating stats for shared object %q: %v", shareID, err)
+	}
+	return err
+}
+
 func sqlCommonUpdateAPIKeyLastUse(keyID string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1388,12 +1624,12 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 				return err
 			}
 		}
-		q := getAddUserQuery(user.Role)
+		q := getAddUserQuery(user.Role, user.Tenant)
 		_, err := tx.ExecContext(ctx, q, user.Username, user.Password, publicKeys, user.HomeDir, user.UID, user.GID,
 			user.MaxSessions, user.QuotaSize, user.QuotaFiles, permissions, user.UploadBandwidth,
 			user.DownloadBandwidth, user.Status, user.ExpirationDate, filters, fsConfig, user.AdditionalInfo,
 			user.Description, user.Email, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()),
-			user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer, user.Role, user.LastPasswordChange)
+			user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer, user.Role, user.LastPasswordChange, user.Tenant)
 		if err != nil {
 			return err
 		}
@@ -1416,6 +1652,18 @@ func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) er
 	return sqlCommonRequireRowAffected(res)
 }
 
+func sqlCommonUpdateAdminPassword(username, password string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateAdminPasswordQuery()
+	res, err := dbHandle.ExecContext(ctx, q, password, util.GetTimeAsMsSinceEpoch(time.Now()), username)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 	err := ValidateUser(user)
 	if err != nil {
@@ -1763,18 +2011,17 @@ func sqlCommonGetActiveTransfers(from time.Time, dbHandle sqlQuerier) ([]ActiveT
 	return transfers, rows.Err()
 }
 
-func sqlCommonGetUsers(limit int, offset int, order, role string, dbHandle sqlQuerier) ([]User, error) {
+func sqlCommonGetUsers(limit int, offset int, order, role, tenant string, dbHandle sqlQuerier) ([]User, error) {
 	users := make([]User, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUsersQuery(order, role)
+	q := getUsersQuery(order, role, tenant)
 	var args []any
-	if role == "" {
-		args = append(args, limit, offset)
-	} else {
-		args = append(args, role, limit, offset)
+	if role != "" {
+		args = append(args, role)
 	}
+	args = append(args, getListQueryArgs(tenant, limit, offset)...)
 	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return users, err
@@ -2010,12 +2257,12 @@ func sqlCommonCleanupDefenderEvents(from int64, dbHandle *sql.DB) error {
 func getShareFromDbRow(row sqlScanner) (Share, error) {
 	var share Share
 	var description, password sql.NullString
-	var allowFrom, paths []byte
+	var allowFrom, paths, options, stats []byte
 
 	err := row.Scan(&share.ShareID, &share.Name, &description, &share.Scope,
 		&paths, &share.Username, &share.CreatedAt, &share.UpdatedAt,
 		&share.LastUseAt, &share.ExpiresAt, &password, &share.MaxTokens,
-		&share.UsedTokens, &allowFrom)
+		&share.UsedTokens, &allowFrom, &options, &stats)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return share, util.NewRecordNotFoundError(err.Error())
@@ -2039,6 +2286,20 @@ func getShareFromDbRow(row sqlScanner) (Share, error) {
 	if err == nil {
 		share.AllowFrom = list
 	}
+	if len(options) > 0 {
+		var shareOptions ShareOptions
+		err = json.Unmarshal(options, &shareOptions)
+		if err == nil {
+			share.Options = shareOptions
+		}
+	}
+	if len(stats) > 0 {
+		var shareStats ShareStats
+		err = json.Unmarshal(stats, &shareStats)
+		if err == nil {
+			share.Stats = shareStats
+		}
+	}
 	return share, nil
 }
 
@@ -2046,9 +2307,10 @@ func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	var apiKey APIKey
 	var userID, adminID sql.NullInt64
 	var description sql.NullString
+	var filters []byte
 
 	err := row.Scan(&apiKey.KeyID, &apiKey.Name, &apiKey.Key, &apiKey.Scope, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID)
+		&apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &userID, &adminID, &filters)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -2066,17 +2328,24 @@ func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
 	if description.Valid {
 		apiKey.Description = description.String
 	}
+	if len(filters) > 0 {
+		var keyFilters APIKeyFilters
+		err = json.Unmarshal(filters, &keyFilters)
+		if err == nil {
+			apiKey.Filters = keyFilters
+		}
+	}
 
 	return apiKey, nil
 }
 
 func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	var admin Admin
-	var email, additionalInfo, description, role sql.NullString
+	var email, additionalInfo, description, role, tenant sql.NullString
 	var permissions, filters []byte
 
 	err := row.Scan(&admin.ID, &admin.Username, &admin.Password, &admin.Status, &email, &permissions,
-		&filters, &additionalInfo, &description, &admin.CreatedAt, &admin.UpdatedAt, &admin.LastLogin, &role)
+		&filters, &additionalInfo, &description, &admin.CreatedAt, &admin.UpdatedAt, &admin.LastLogin, &role, &tenant)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -2110,6 +2379,9 @@ func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	if role.Valid {
 		admin.Role = role.String
 	}
+	if tenant.Valid {
+		admin.Tenant = tenant.String
+	}
 
 	admin.SetEmptySecretsIfNil()
 	return admin, nil
@@ -2140,11 +2412,11 @@ func getEventActionFromDbRow(row sqlScanner) (BaseEventAction, error) {
 
 func getEventRuleFromDbRow(row sqlScanner) (EventRule, error) {
 	var rule EventRule
-	var description sql.NullString
+	var description, tenant sql.NullString
 	var conditions []byte
 
 	err := row.Scan(&rule.ID, &rule.Name, &description, &rule.CreatedAt, &rule.UpdatedAt, &rule.Trigger,
-		&conditions, &rule.DeletedAt, &rule.Status)
+		&conditions, &rule.DeletedAt, &rule.Status, &tenant)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return rule, util.NewRecordNotFoundError(err.Error())
@@ -2160,6 +2432,9 @@ func getEventRuleFromDbRow(row sqlScanner) (EventRule, error) {
 	if description.Valid {
 		rule.Description = description.String
 	}
+	if tenant.Valid {
+		rule.Tenant = tenant.String
+	}
 	return rule, nil
 }
 
@@ -2183,9 +2458,9 @@ func getIPListEntryFromDbRow(row sqlScanner) (IPListEntry, error) {
 
 func getRoleFromDbRow(row sqlScanner) (Role, error) {
 	var role Role
-	var description sql.NullString
+	var description, tenant sql.NullString
 
-	err := row.Scan(&role.ID, &role.Name, &description, &role.CreatedAt, &role.UpdatedAt)
+	err := row.Scan(&role.ID, &role.Name, &description, &role.CreatedAt, &role.UpdatedAt, &tenant)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return role, util.NewRecordNotFoundError(err.Error())
@@ -2195,16 +2470,66 @@ func getRoleFromDbRow(row sqlScanner) (Role, error) {
 	if description.Valid {
 		role.Description = description.String
 	}
+	if tenant.Valid {
+		role.Tenant = tenant.String
+	}
 
 	return role, nil
 }
 
+func getTenantFromDbRow(row sqlScanner) (Tenant, error) {
+	var tenant Tenant
+	var description, storageBasePath sql.NullString
+	var branding []byte
+
+	err := row.Scan(&tenant.ID, &tenant.Name, &description, &branding, &storageBasePath, &tenant.CreatedAt,
+		&tenant.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return tenant, util.NewRecordNotFoundError(err.Error())
+		}
+		return tenant, err
+	}
+	if description.Valid {
+		tenant.Description = description.String
+	}
+	if storageBasePath.Valid {
+		tenant.StorageBasePath = storageBasePath.String
+	}
+	var tenantBranding TenantBranding
+	err = json.Unmarshal(branding, &tenantBranding)
+	if err == nil {
+		tenant.Branding = tenantBranding
+	}
+
+	return tenant, nil
+}
+
+func getAuditLogEntryFromDbRow(row sqlScanner) (AuditLogEntry, error) {
+	var entry AuditLogEntry
+	var info sql.NullString
+
+	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.Node, &entry.Category, &entry.Action, &entry.Username,
+		&entry.IP, &entry.Protocol, &entry.ObjectType, &entry.ObjectName, &entry.Role, &entry.Status, &info,
+		&entry.PrevHash, &entry.Hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, util.NewRecordNotFoundError(err.Error())
+		}
+		return entry, err
+	}
+	if info.Valid {
+		entry.Info = info.String
+	}
+	return entry, nil
+}
+
 func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	var group Group
-	var description sql.NullString
+	var description, tenant sql.NullString
 	var userSettings []byte
 
-	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings)
+	err := row.Scan(&group.ID, &group.Name, &description, &group.CreatedAt, &group.UpdatedAt, &userSettings, &tenant)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return group, util.NewRecordNotFoundError(err.Error())
@@ -2214,6 +2539,9 @@ func getGroupFromDbRow(row sqlScanner) (Group, error) {
 	if description.Valid {
 		group.Description = description.String
 	}
+	if tenant.Valid {
+		group.Tenant = tenant.String
+	}
 
 	var settings GroupUserSettings
 	err = json.Unmarshal(userSettings, &settings)
@@ -2228,14 +2556,14 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	var user User
 	var password sql.NullString
 	var permissions, publicKey, filters, fsConfig []byte
-	var additionalInfo, description, email, role sql.NullString
+	var additionalInfo, description, email, role, tenant sql.NullString
 
 	err := row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 		&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 		&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
 		&additionalInfo, &description, &email, &user.CreatedAt, &user.UpdatedAt, &user.UploadDataTransfer, &user.DownloadDataTransfer,
 		&user.TotalDataTransfer, &user.UsedUploadDataTransfer, &user.UsedDownloadDataTransfer, &user.DeletedAt, &user.FirstDownload,
-		&user.FirstUpload, &role, &user.LastPasswordChange)
+		&user.FirstUpload, &role, &user.LastPasswordChange, &tenant)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return user, util.NewRecordNotFoundError(err.Error())
@@ -2282,6 +2610,9 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	if role.Valid {
 		user.Role = role.String
 	}
+	if tenant.Valid {
+		user.Tenant = tenant.String
+	}
 	user.SetEmptySecretsIfNil()
 	return user, nil
 }
@@ -2290,10 +2621,10 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	var folder vfs.BaseVirtualFolder
 	q := getFolderByNameQuery()
 	row := dbHandle.QueryRowContext(ctx, q, name)
-	var mappedPath, description sql.NullString
+	var mappedPath, description, tenant sql.NullString
 	var fsConfig []byte
 	err := row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &tenant)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return folder, util.NewRecordNotFoundError(err.Error())
@@ -2306,6 +2637,9 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	if description.Valid {
 		folder.Description = description.String
 	}
+	if tenant.Valid {
+		folder.Tenant = tenant.String
+	}
 	var fs vfs.Filesystem
 	err = json.Unmarshal(fsConfig, &fs)
 	if err == nil {
@@ -2348,9 +2682,9 @@ func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) erro
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getAddFolderQuery()
+	q := getAddFolderQuery(folder.Tenant)
 	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
-		folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig)
+		folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig, folder.Tenant)
 	return err
 }
 
@@ -2399,10 +2733,10 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	defer rows.Close()
 	for rows.Next() {
 		var folder vfs.BaseVirtualFolder
-		var mappedPath, description sql.NullString
+		var mappedPath, description, tenant sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &tenant)
 		if err != nil {
 			return folders, err
 		}
@@ -2412,6 +2746,9 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 		if description.Valid {
 			folder.Description = description.String
 		}
+		if tenant.Valid {
+			folder.Tenant = tenant.String
+		}
 		var fs vfs.Filesystem
 		err = json.Unmarshal(fsConfig, &fs)
 		if err == nil {
@@ -2422,13 +2759,15 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	return folders, rows.Err()
 }
 
-func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+func sqlCommonGetFolders(limit, offset int, order string, minimal bool, tenant string,
+	dbHandle sqlQuerier,
+) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getFoldersQuery(order, minimal)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	q := getFoldersQuery(order, minimal, tenant)
+	rows, err := dbHandle.QueryContext(ctx, q, getListQueryArgs(tenant, limit, offset)...)
 	if err != nil {
 		return folders, err
 	}
@@ -2441,10 +2780,10 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 				return folders, err
 			}
 		} else {
-			var mappedPath, description sql.NullString
+			var mappedPath, description, tenant sql.NullString
 			var fsConfig []byte
 			err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &tenant)
 			if err != nil {
 				return folders, err
 			}
@@ -2454,6 +2793,9 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 			if description.Valid {
 				folder.Description = description.String
 			}
+			if tenant.Valid {
+				folder.Tenant = tenant.String
+			}
 			var fs vfs.Filesystem
 			err = json.Unmarshal(fsConfig, &fs)
 			if err == nil {
@@ -3256,6 +3598,34 @@ func sqlCommonGetSession(key string, dbHandle sqlQuerier) (Session, error) {
 	return session, nil
 }
 
+func sqlCommonGetSessions(sessionType SessionType, keyPrefix string, dbHandle sqlQuerier) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSessionsQuery(keyPrefix)
+	args := []any{sessionType}
+	if keyPrefix != "" {
+		args = append(args, keyPrefix+"%")
+	}
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var data []byte
+		if err := rows.Scan(&session.Key, &data, &session.Type, &session.Timestamp); err != nil {
+			return nil, err
+		}
+		session.Data = data
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 func sqlCommonDeleteSession(key string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3596,14 +3966,14 @@ func sqlCommonGetRecentlyUpdatedRules(after int64, dbHandle sqlQuerier) ([]Event
 	return getRulesWithActions(ctx, rules, dbHandle)
 }
 
-func sqlCommonGetEventRules(limit int, offset int, order string, dbHandle sqlQuerier) ([]EventRule, error) {
+func sqlCommonGetEventRules(limit int, offset int, order, tenant string, dbHandle sqlQuerier) ([]EventRule, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getEventRulesQuery(order)
+	q := getEventRulesQuery(order, tenant)
 
 	rules := make([]EventRule, 0, limit)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, getListQueryArgs(tenant, limit, offset)...)
 	if err != nil {
 		return rules, err
 	}
@@ -3648,9 +4018,9 @@ func sqlCommonAddEventRule(rule *EventRule, dbHandle *sql.DB) error {
 				return err
 			}
 		}
-		q := getAddEventRuleQuery()
+		q := getAddEventRuleQuery(rule.Tenant)
 		_, err := tx.ExecContext(ctx, q, rule.Name, rule.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), rule.Trigger, conditions, rule.Status)
+			util.GetTimeAsMsSinceEpoch(time.Now()), rule.Trigger, conditions, rule.Status, rule.Tenant)
 		if err != nil {
 			return err
 		}
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
@@ -55,6 +56,8 @@ DROP TABLE IF EXISTS "{{events_rules}}";
 DROP TABLE IF EXISTS "{{events_actions}}";
 DROP TABLE IF EXISTS "{{tasks}}";
 DROP TABLE IF EXISTS "{{roles}}";
+DROP TABLE IF EXISTS "{{tenants}}";
+DROP TABLE IF EXISTS "{{audit_logs}}";
 DROP TABLE IF EXISTS "{{ip_lists}}";
 DROP TABLE IF EXISTS "{{configs}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
@@ -180,6 +183,53 @@ CREATE INDEX "{{prefix}}ip_lists_ip_deleted_at_idx" ON "{{ip_lists}}" ("deleted_
 CREATE INDEX "{{prefix}}ip_lists_first_last_idx" ON "{{ip_lists}}" ("first", "last");
 INSERT INTO {{schema_version}} (version) VALUES (29);
 `
+	sqliteV30SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "filters" text NULL;`
+	sqliteV30DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "filters";`
+	sqliteV31SQL     = `ALTER TABLE "{{shares}}" ADD COLUMN "options" text NULL;`
+	sqliteV31DownSQL = `ALTER TABLE "{{shares}}" DROP COLUMN "options";`
+	sqliteV32SQL     = `ALTER TABLE "{{shares}}" ADD COLUMN "stats" text NULL;`
+	sqliteV32DownSQL = `ALTER TABLE "{{shares}}" DROP COLUMN "stats";`
+	sqliteV33SQL     = `CREATE TABLE "{{tenants}}" ("id" integer NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(512) NULL, "branding" text NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);
+ALTER TABLE "{{admins}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{users}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{groups}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{roles}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{folders}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+ALTER TABLE "{{events_rules}}" ADD COLUMN "tenant_id" integer NULL REFERENCES "{{tenants}}" ("id") ON DELETE NO ACTION;
+CREATE INDEX "{{prefix}}admins_tenant_id_idx" ON "{{admins}}" ("tenant_id");
+CREATE INDEX "{{prefix}}users_tenant_id_idx" ON "{{users}}" ("tenant_id");
+CREATE INDEX "{{prefix}}groups_tenant_id_idx" ON "{{groups}}" ("tenant_id");
+CREATE INDEX "{{prefix}}roles_tenant_id_idx" ON "{{roles}}" ("tenant_id");
+CREATE INDEX "{{prefix}}folders_tenant_id_idx" ON "{{folders}}" ("tenant_id");
+CREATE INDEX "{{prefix}}events_rules_tenant_id_idx" ON "{{events_rules}}" ("tenant_id");
+`
+	sqliteV33DownSQL = `DROP INDEX IF EXISTS "{{prefix}}admins_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}users_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}groups_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}roles_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}folders_tenant_id_idx";
+DROP INDEX IF EXISTS "{{prefix}}events_rules_tenant_id_idx";
+ALTER TABLE "{{admins}}" DROP COLUMN "tenant_id";
+ALTER TABLE "{{users}}" DROP COLUMN "tenant_id";
+ALTER TABLE "{{groups}}" DROP COLUMN "tenant_id";
+ALTER TABLE "{{roles}}" DROP COLUMN "tenant_id";
+ALTER TABLE "{{folders}}" DROP COLUMN "tenant_id";
+ALTER TABLE "{{events_rules}}" DROP COLUMN "tenant_id";
+DROP TABLE IF EXISTS "{{tenants}}";
+`
+	sqliteV34SQL = `CREATE TABLE "{{audit_logs}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "created_at" bigint NOT NULL,
+"node" varchar(255) NOT NULL, "category" varchar(50) NOT NULL, "action" varchar(100) NOT NULL,
+"username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "protocol" varchar(30) NOT NULL,
+"object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL, "role" varchar(255) NOT NULL,
+"status" integer NOT NULL, "info" text NULL, "prev_hash" varchar(64) NOT NULL, "hash" varchar(64) NOT NULL);
+CREATE INDEX "{{prefix}}audit_logs_node_id_idx" ON "{{audit_logs}}" ("node", "id");
+`
+	sqliteV34DownSQL = `DROP INDEX IF EXISTS "{{prefix}}audit_logs_node_id_idx";
+DROP TABLE IF EXISTS "{{audit_logs}}";
+`
+	sqliteV35SQL     = `ALTER TABLE "{{tenants}}" ADD COLUMN "storage_base_path" varchar(512) NULL;`
+	sqliteV35DownSQL = `ALTER TABLE "{{tenants}}" DROP COLUMN "storage_base_path";`
 )
 
 // SQLiteProvider defines the auth provider for SQLite database
@@ -278,6 +328,10 @@ func (p *SQLiteProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateAdminPassword(username, password string) error {
+	return sqlCommonUpdateAdminPassword(username, password, p.dbHandle)
+}
+
 func (p *SQLiteProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -286,8 +340,8 @@ func (p *SQLiteProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *SQLiteProvider) getUsers(limit int, offset int, order, role, tenant string) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, role, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -298,8 +352,8 @@ func (p *SQLiteProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool, tenant string) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, minimal, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -328,8 +382,8 @@ func (p *SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool, tenant string) ([]Group, error) {
+	return sqlCommonGetGroups(limit, offset, order, minimal, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -376,8 +430,8 @@ func (p *SQLiteProvider) deleteAdmin(admin Admin) error {
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+func (p *SQLiteProvider) getAdmins(limit int, offset int, order, tenant string) ([]Admin, error) {
+	return sqlCommonGetAdmins(limit, offset, order, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpAdmins() ([]Admin, error) {
@@ -444,6 +498,10 @@ func (p *SQLiteProvider) updateShareLastUse(shareID string, numTokens int) error
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateShareStats(shareID, ipAddress string, filePaths []string) error {
+	return sqlCommonUpdateShareStats(shareID, ipAddress, filePaths, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getDefenderHosts(from int64, limit int) ([]DefenderEntry, error) {
 	return sqlCommonGetDefenderHosts(from, limit, p.dbHandle)
 }
@@ -508,6 +566,10 @@ func (p *SQLiteProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getSharedSessions(sessionType SessionType, keyPrefix string) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, keyPrefix, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -536,8 +598,8 @@ func (p *SQLiteProvider) deleteEventAction(action BaseEventAction) error {
 	return sqlCommonDeleteEventAction(action, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+func (p *SQLiteProvider) getEventRules(limit, offset int, order, tenant string) ([]EventRule, error) {
+	return sqlCommonGetEventRules(limit, offset, order, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpEventRules() ([]EventRule, error) {
@@ -616,14 +678,50 @@ func (p *SQLiteProvider) deleteRole(role Role) error {
 	return sqlCommonDeleteRole(role, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+func (p *SQLiteProvider) getRoles(limit int, offset int, order string, minimal bool, tenant string) ([]Role, error) {
+	return sqlCommonGetRoles(limit, offset, order, minimal, tenant, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpRoles() ([]Role, error) {
 	return sqlCommonDumpRoles(p.dbHandle)
 }
 
+func (p *SQLiteProvider) tenantExists(name string) (Tenant, error) {
+	return sqlCommonGetTenantByName(name, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addTenant(tenant *Tenant) error {
+	return p.normalizeError(sqlCommonAddTenant(tenant, p.dbHandle), fieldName)
+}
+
+func (p *SQLiteProvider) updateTenant(tenant *Tenant) error {
+	return sqlCommonUpdateTenant(tenant, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteTenant(tenant Tenant) error {
+	return sqlCommonDeleteTenant(tenant, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getTenants(limit int, offset int, order string) ([]Tenant, error) {
+	return sqlCommonGetTenants(limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpTenants() ([]Tenant, error) {
+	return sqlCommonDumpTenants(p.dbHandle)
+}
+
+func (p *SQLiteProvider) addAuditLogEntry(entry *AuditLogEntry) error {
+	return sqlCommonAddAuditLogEntry(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getAuditLogEntries(from int64, limit int) ([]AuditLogEntry, error) {
+	return sqlCommonGetAuditLogEntries(from, limit, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getLastAuditLogEntry(node string) (AuditLogEntry, error) {
+	return sqlCommonGetLastAuditLogEntry(node, p.dbHandle)
+}
+
 func (p *SQLiteProvider) ipListEntryExists(ipOrNet string, listType IPListType) (IPListEntry, error) {
 	return sqlCommonGetIPListEntry(ipOrNet, listType, p.dbHandle)
 }
@@ -714,6 +812,18 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 29:
+		return updateSQLiteDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateSQLiteDatabaseFromV30(p.dbHandle)
+	case version == 31:
+		return updateSQLiteDatabaseFromV31(p.dbHandle)
+	case version == 32:
+		return updateSQLiteDatabaseFromV32(p.dbHandle)
+	case version == 33:
+		return updateSQLiteDatabaseFromV33(p.dbHandle)
+	case version == 34:
+		return updateSQLiteDatabaseFromV34(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -736,6 +846,18 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 30:
+		return downgradeSQLiteDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeSQLiteDatabaseFromV31(p.dbHandle)
+	case 32:
+		return downgradeSQLiteDatabaseFromV32(p.dbHandle)
+	case 33:
+		return downgradeSQLiteDatabaseFromV33(p.dbHandle)
+	case 34:
+		return downgradeSQLiteDatabaseFromV34(p.dbHandle)
+	case 35:
+		return downgradeSQLiteDatabaseFromV35(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -782,3 +904,165 @@ func (p *SQLiteProvider) normalizeError(err error, fieldType int) error {
 	_, err := dbHandle.ExecContext(ctx, sql)
 	return err
 }*/
+
+func updateSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV30(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom30To31(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV31(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom31To32(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV32(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom32To33(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV33(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom33To34(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV34(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom34To35(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	return downgradeSQLiteDatabaseFrom30To29(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV30(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV32(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom32To31(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV31(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV33(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom33To32(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV32(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV34(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom34To33(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV33(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV35(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom35To34(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV34(dbHandle)
+}
+
+func updateSQLiteDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(sqliteV30SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func downgradeSQLiteDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(sqliteV30DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func updateSQLiteDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(sqliteV31SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
+func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(sqliteV31DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+func updateSQLiteDatabaseFrom31To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 31 -> 32")
+	providerLog(logger.LevelInfo, "updating database schema version: 31 -> 32")
+	sql := strings.ReplaceAll(sqliteV32SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, true)
+}
+
+func downgradeSQLiteDatabaseFrom32To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 32 -> 31")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 32 -> 31")
+	sql := strings.ReplaceAll(sqliteV32DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, false)
+}
+
+func updateSQLiteDatabaseFrom32To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 32 -> 33")
+	providerLog(logger.LevelInfo, "updating database schema version: 32 -> 33")
+	sql := sqlReplaceAll(sqliteV33SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, true)
+}
+
+func downgradeSQLiteDatabaseFrom33To32(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 33 -> 32")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 33 -> 32")
+	sql := sqlReplaceAll(sqliteV33DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 32, false)
+}
+
+func updateSQLiteDatabaseFrom33To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 33 -> 34")
+	providerLog(logger.LevelInfo, "updating database schema version: 33 -> 34")
+	sql := sqlReplaceAll(sqliteV34SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, true)
+}
+
+func downgradeSQLiteDatabaseFrom34To33(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 34 -> 33")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 34 -> 33")
+	sql := sqlReplaceAll(sqliteV34DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 33, false)
+}
+
+func updateSQLiteDatabaseFrom34To35(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 34 -> 35")
+	providerLog(logger.LevelInfo, "updating database schema version: 34 -> 35")
+	sql := sqlReplaceAll(sqliteV35SQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 35, true)
+}
+
+func downgradeSQLiteDatabaseFrom35To34(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 35 -> 34")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 35 -> 34")
+	sql := sqlReplaceAll(sqliteV35DownSQL)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 34, false)
+}
@@ -29,13 +29,17 @@ const (
 		"u.updated_at,u.upload_data_transfer,u.download_data_transfer,u.total_data_transfer," +
 		"u.used_upload_data_transfer,u.used_download_data_transfer,u.deleted_at,u.first_download,u.first_upload,r.name,u.last_password_change"
 	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
-	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at,a.updated_at,a.last_login,r.name"
-	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
+	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at," +
+		"a.updated_at,a.last_login,r.name"
+	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,filters"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
-		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
+		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from,s.options,s.stats"
 	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
 	selectEventActionFields = "id,name,description,type,options"
 	selectRoleFields        = "id,name,description,created_at,updated_at"
+	selectTenantFields      = "id,name,description,branding,storage_base_path,created_at,updated_at"
+	selectAuditLogFields    = "id,created_at,node,category,action,username,ip,protocol,object_type,object_name,role," +
+		"status,info,prev_hash,hash"
 	selectIPListEntryFields = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
 	selectMinimalFields     = "id,name"
 )
@@ -62,10 +66,55 @@ func getSQLQuotedName(name string) string {
 
 func getSelectEventRuleFields() string {
 	if config.Driver == MySQLDataProviderName {
-		return "id,name,description,created_at,updated_at,`trigger`,conditions,deleted_at,status"
+		return "id,name,description,created_at,updated_at,`trigger`,conditions,deleted_at,status," + getTenantNameField("tenant_id")
 	}
 
-	return `id,name,description,created_at,updated_at,"trigger",conditions,deleted_at,status`
+	return `id,name,description,created_at,updated_at,"trigger",conditions,deleted_at,status,` + getTenantNameField("tenant_id")
+}
+
+func getSelectUserFields() string {
+	return selectUserFields + "," + getTenantNameField("u.tenant_id")
+}
+
+func getSelectFolderFields() string {
+	return selectFolderFields + "," + getTenantNameField("tenant_id")
+}
+
+func getSelectAdminFields() string {
+	return selectAdminFields + "," + getTenantNameField("a.tenant_id")
+}
+
+func getSelectGroupFields() string {
+	return selectGroupFields + "," + getTenantNameField("tenant_id")
+}
+
+func getSelectRoleFields() string {
+	return selectRoleFields + "," + getTenantNameField("tenant_id")
+}
+
+// getTenantNameField returns the expression to select the name of the tenant
+// referenced by the specified column
+func getTenantNameField(column string) string {
+	return fmt.Sprintf("(SELECT t.name FROM %s t WHERE t.id = %s)", sqlTableTenants, column)
+}
+
+// getTenantIDValue returns the expression to resolve the tenant name at the specified
+// placeholder to its id. An unknown tenant resolves to an invalid id, so the foreign
+// key check fails, the empty one to NULL
+func getTenantIDValue(tenant string, placeholderIdx int) string {
+	return fmt.Sprintf("COALESCE((SELECT id FROM %s WHERE name = %s),%s)", sqlTableTenants,
+		sqlPlaceholders[placeholderIdx], getCoalesceDefaultForRole(tenant))
+}
+
+// getTenantFilter returns the condition, introduced by the specified keyword, to
+// filter the specified tenant id column by tenant name and the index of the next
+// free placeholder
+func getTenantFilter(keyword, column, tenant string, placeholderIdx int) (string, int) {
+	if tenant == "" {
+		return "", placeholderIdx
+	}
+	return fmt.Sprintf(" %s %s = (SELECT t.id FROM %s t WHERE t.name = %s)", keyword, column, sqlTableTenants,
+		sqlPlaceholders[placeholderIdx]), placeholderIdx + 1
 }
 
 func getCoalesceDefaultForRole(role string) string {
@@ -102,6 +151,26 @@ func getSessionQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getSessionsQuery(keyPrefix string) string {
+	var sb strings.Builder
+	if config.Driver == MySQLDataProviderName {
+		sb.WriteString(fmt.Sprintf("SELECT `key`,`data`,`type`,`timestamp` FROM %s WHERE `type` = %s",
+			sqlTableSharedSessions, sqlPlaceholders[0]))
+		if keyPrefix != "" {
+			sb.WriteString(" AND `key` LIKE ")
+			sb.WriteString(sqlPlaceholders[1])
+		}
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf(`SELECT key,data,type,timestamp FROM %s WHERE type = %s`, sqlTableSharedSessions,
+		sqlPlaceholders[0]))
+	if keyPrefix != "" {
+		sb.WriteString(" AND key LIKE ")
+		sb.WriteString(sqlPlaceholders[1])
+	}
+	return sb.String()
+}
+
 func getCleanupSessionsQuery() string {
 	return fmt.Sprintf(`DELETE from %s WHERE type = %s AND timestamp < %s`,
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -283,19 +352,20 @@ func getUpdateConfigsQuery() string {
 }
 
 func getRoleByNameQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectRoleFields, sqlTableRoles,
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, getSelectRoleFields(), sqlTableRoles,
 		sqlPlaceholders[0])
 }
 
-func getRolesQuery(order string, minimal bool) string {
+func getRolesQuery(order string, minimal bool, tenant string) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
-		fieldSelection = selectRoleFields
+		fieldSelection = getSelectRoleFields()
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
-		sqlTableRoles, order, sqlPlaceholders[0], sqlPlaceholders[1])
+	tenantFilter, idx := getTenantFilter("WHERE", "tenant_id", tenant, 0)
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
+		sqlTableRoles, tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 }
 
 func getUsersWithRolesQuery(roles []Role) string {
@@ -333,13 +403,13 @@ func getAdminsWithRolesQuery(roles []Role) string {
 }
 
 func getDumpRolesQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s`, selectRoleFields, sqlTableRoles)
+	return fmt.Sprintf(`SELECT %s FROM %s`, getSelectRoleFields(), sqlTableRoles)
 }
 
-func getAddRoleQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at)
-		VALUES (%s,%s,%s,%s)`, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3])
+func getAddRoleQuery(tenant string) string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,tenant_id)
+		VALUES (%s,%s,%s,%s,%s)`, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], getTenantIDValue(tenant, 4))
 }
 
 func getUpdateRoleQuery() string {
@@ -351,20 +421,82 @@ func getDeleteRoleQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableRoles, sqlPlaceholders[0])
 }
 
+func getTenantByNameQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectTenantFields, sqlTableTenants,
+		sqlPlaceholders[0])
+}
+
+func getTenantsQuery(order string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, selectTenantFields,
+		sqlTableTenants, order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpTenantsQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s`, selectTenantFields, sqlTableTenants)
+}
+
+func getAddTenantQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,branding,storage_base_path,created_at,updated_at)
+		VALUES (%s,%s,%s,%s,%s,%s)`, sqlTableTenants, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5])
+}
+
+func getUpdateTenantQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET description=%s,branding=%s,storage_base_path=%s,updated_at=%s
+		WHERE name = %s`, sqlTableTenants, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+}
+
+func getDeleteTenantQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableTenants, sqlPlaceholders[0])
+}
+
+func getTenantReferencesQuery() string {
+	var sb strings.Builder
+	for idx, table := range []string{sqlTableAdmins, sqlTableUsers, getSQLQuotedName(sqlTableGroups), sqlTableRoles,
+		sqlTableFolders, sqlTableEventsRules} {
+		if idx > 0 {
+			sb.WriteString(" UNION ALL ")
+		}
+		sb.WriteString(fmt.Sprintf(`SELECT COUNT(*) AS num FROM %s WHERE tenant_id = (SELECT id FROM %s WHERE name = %s)`,
+			table, sqlTableTenants, sqlPlaceholders[idx]))
+	}
+	return fmt.Sprintf(`SELECT SUM(num) FROM (%s) t`, sb.String())
+}
+
+func getAddAuditLogEntryQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (created_at,node,category,action,username,ip,protocol,object_type,object_name,role,
+		status,info,prev_hash,hash) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAuditLogs,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
+		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
+		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13])
+}
+
+func getAuditLogEntriesQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE id > %s ORDER BY id ASC LIMIT %s`, selectAuditLogFields,
+		sqlTableAuditLogs, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getLastAuditLogEntryQuery() string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE node = %s ORDER BY id DESC LIMIT 1`, selectAuditLogFields,
+		sqlTableAuditLogs, sqlPlaceholders[0])
+}
+
 func getGroupByNameQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectGroupFields, getSQLQuotedName(sqlTableGroups),
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, getSelectGroupFields(), getSQLQuotedName(sqlTableGroups),
 		sqlPlaceholders[0])
 }
 
-func getGroupsQuery(order string, minimal bool) string {
+func getGroupsQuery(order string, minimal bool, tenant string) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
-		fieldSelection = selectGroupFields
+		fieldSelection = getSelectGroupFields()
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
-		getSQLQuotedName(sqlTableGroups), order, sqlPlaceholders[0], sqlPlaceholders[1])
+	tenantFilter, idx := getTenantFilter("WHERE", "tenant_id", tenant, 0)
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
+		getSQLQuotedName(sqlTableGroups), tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 }
 
 func getGroupsWithNamesQuery(numArgs int) string {
@@ -382,7 +514,7 @@ func getGroupsWithNamesQuery(numArgs int) string {
 	} else {
 		sb.WriteString("('')")
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE name in %s`, selectGroupFields, getSQLQuotedName(sqlTableGroups), sb.String())
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name in %s`, getSelectGroupFields(), getSQLQuotedName(sqlTableGroups), sb.String())
 }
 
 func getUsersInGroupsQuery(numArgs int) string {
@@ -405,13 +537,13 @@ func getUsersInGroupsQuery(numArgs int) string {
 }
 
 func getDumpGroupsQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s`, selectGroupFields, getSQLQuotedName(sqlTableGroups))
+	return fmt.Sprintf(`SELECT %s FROM %s`, getSelectGroupFields(), getSQLQuotedName(sqlTableGroups))
 }
 
-func getAddGroupQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings)
-		VALUES (%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+func getAddGroupQuery(tenant string) string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,user_settings,tenant_id)
+		VALUES (%s,%s,%s,%s,%s,%s)`, getSQLQuotedName(sqlTableGroups), sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], getTenantIDValue(tenant, 5))
 }
 
 func getUpdateGroupQuery() string {
@@ -426,25 +558,26 @@ func getDeleteGroupQuery() string {
 
 func getAdminByUsernameQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s a LEFT JOIN %s r on r.id = a.role_id WHERE a.username = %s`,
-		selectAdminFields, sqlTableAdmins, sqlTableRoles, sqlPlaceholders[0])
+		getSelectAdminFields(), sqlTableAdmins, sqlTableRoles, sqlPlaceholders[0])
 }
 
-func getAdminsQuery(order string) string {
-	return fmt.Sprintf(`SELECT %s FROM %s a LEFT JOIN %s r on r.id = a.role_id ORDER BY a.username %s LIMIT %s OFFSET %s`,
-		selectAdminFields, sqlTableAdmins, sqlTableRoles, order, sqlPlaceholders[0], sqlPlaceholders[1])
+func getAdminsQuery(order, tenant string) string {
+	tenantFilter, idx := getTenantFilter("WHERE", "a.tenant_id", tenant, 0)
+	return fmt.Sprintf(`SELECT %s FROM %s a LEFT JOIN %s r on r.id = a.role_id%s ORDER BY a.username %s LIMIT %s OFFSET %s`,
+		getSelectAdminFields(), sqlTableAdmins, sqlTableRoles, tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 }
 
 func getDumpAdminsQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s a LEFT JOIN %s r on r.id = a.role_id`,
-		selectAdminFields, sqlTableAdmins, sqlTableRoles)
+		getSelectAdminFields(), sqlTableAdmins, sqlTableRoles)
 }
 
-func getAddAdminQuery(role string) string {
-	return fmt.Sprintf(`INSERT INTO %s (username,password,status,email,permissions,filters,additional_info,description,created_at,updated_at,last_login,role_id)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,COALESCE((SELECT id from %s WHERE name = %s),%s))`,
+func getAddAdminQuery(role, tenant string) string {
+	return fmt.Sprintf(`INSERT INTO %s (username,password,status,email,permissions,filters,additional_info,description,created_at,updated_at,last_login,role_id,tenant_id)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,COALESCE((SELECT id from %s WHERE name = %s),%s),%s)`,
 		sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlTableRoles, sqlPlaceholders[10], getCoalesceDefaultForRole(role))
+		sqlTableRoles, sqlPlaceholders[10], getCoalesceDefaultForRole(role), getTenantIDValue(tenant, 11))
 }
 
 func getUpdateAdminQuery(role string) string {
@@ -479,27 +612,29 @@ func getDumpSharesQuery() string {
 
 func getAddShareQuery() string {
 	return fmt.Sprintf(`INSERT INTO %s (share_id,name,description,scope,paths,created_at,updated_at,last_use_at,
-		expires_at,password,max_tokens,used_tokens,allow_from,user_id) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`,
+		expires_at,password,max_tokens,used_tokens,allow_from,user_id,options,stats) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`,
 		sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
 		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11],
-		sqlPlaceholders[12], sqlPlaceholders[13])
+		sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15])
 }
 
 func getUpdateShareRestoreQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET name=%s,description=%s,scope=%s,paths=%s,created_at=%s,updated_at=%s,
-		last_use_at=%s,expires_at=%s,password=%s,max_tokens=%s,used_tokens=%s,allow_from=%s,user_id=%s WHERE share_id = %s`, sqlTableShares,
+		last_use_at=%s,expires_at=%s,password=%s,max_tokens=%s,used_tokens=%s,allow_from=%s,user_id=%s,options=%s,
+		stats=%s WHERE share_id = %s`, sqlTableShares,
 		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13])
+		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14],
+		sqlPlaceholders[15])
 }
 
 func getUpdateShareQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET name=%s,description=%s,scope=%s,paths=%s,updated_at=%s,expires_at=%s,
-		password=%s,max_tokens=%s,allow_from=%s,user_id=%s WHERE share_id = %s`, sqlTableShares,
+		password=%s,max_tokens=%s,allow_from=%s,user_id=%s,options=%s WHERE share_id = %s`, sqlTableShares,
 		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10])
+		sqlPlaceholders[10], sqlPlaceholders[11])
 }
 
 func getDeleteShareQuery() string {
@@ -520,16 +655,17 @@ func getDumpAPIKeysQuery() string {
 }
 
 func getAddAPIKeyQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id)
-		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
+	return fmt.Sprintf(`INSERT INTO %s (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id,filters)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
-		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10])
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11])
 }
 
 func getUpdateAPIKeyQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s
-		WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	return fmt.Sprintf(`UPDATE %s SET name=%s,scope=%s,expires_at=%s,user_id=%s,admin_id=%s,description=%s,updated_at=%s,
+		filters=%s WHERE key_id = %s`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8])
 }
 
 func getDeleteAPIKeyQuery() string {
@@ -581,22 +717,25 @@ func getRelatedAdminsForAPIKeysQuery(apiKeys []APIKey) string {
 func getUserByUsernameQuery(role string) string {
 	if role == "" {
 		return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE u.username = %s AND u.deleted_at = 0`,
-			selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0])
+			getSelectUserFields(), sqlTableUsers, sqlTableRoles, sqlPlaceholders[0])
 	}
 	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE u.username = %s AND u.deleted_at = 0
 		AND u.role_id is NOT NULL AND r.name = %s`,
-		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1])
+		getSelectUserFields(), sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
-func getUsersQuery(order, role string) string {
+func getUsersQuery(order, role, tenant string) string {
 	if role == "" {
+		tenantFilter, idx := getTenantFilter("AND", "u.tenant_id", tenant, 0)
 		return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-			u.deleted_at = 0 ORDER BY u.username %s LIMIT %s OFFSET %s`,
-			selectUserFields, sqlTableUsers, sqlTableRoles, order, sqlPlaceholders[0], sqlPlaceholders[1])
+			u.deleted_at = 0%s ORDER BY u.username %s LIMIT %s OFFSET %s`,
+			getSelectUserFields(), sqlTableUsers, sqlTableRoles, tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 	}
+	tenantFilter, idx := getTenantFilter("AND", "u.tenant_id", tenant, 1)
 	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-		u.deleted_at = 0 AND u.role_id is NOT NULL AND r.name = %s ORDER BY u.username %s LIMIT %s OFFSET %s`,
-		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], order, sqlPlaceholders[1], sqlPlaceholders[2])
+		u.deleted_at = 0 AND u.role_id is NOT NULL AND r.name = %s%s ORDER BY u.username %s LIMIT %s OFFSET %s`,
+		getSelectUserFields(), sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], tenantFilter, order, sqlPlaceholders[idx],
+		sqlPlaceholders[idx+1])
 }
 
 func getUsersForQuotaCheckQuery(numArgs int) string {
@@ -619,16 +758,16 @@ func getUsersForQuotaCheckQuery(numArgs int) string {
 
 func getRecentlyUpdatedUsersQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE u.updated_at >= %s OR u.deleted_at > 0`,
-		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0])
+		getSelectUserFields(), sqlTableUsers, sqlTableRoles, sqlPlaceholders[0])
 }
 
 func getDumpUsersQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE u.deleted_at = 0`,
-		selectUserFields, sqlTableUsers, sqlTableRoles)
+		getSelectUserFields(), sqlTableUsers, sqlTableRoles)
 }
 
 func getDumpFoldersQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s`, selectFolderFields, sqlTableFolders)
+	return fmt.Sprintf(`SELECT %s FROM %s`, getSelectFolderFields(), sqlTableFolders)
 }
 
 func getUpdateTransferQuotaQuery(reset bool) string {
@@ -681,25 +820,34 @@ func getUpdateShareLastUseQuery() string {
 		sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
 }
 
+func getShareStatsQuery() string {
+	return fmt.Sprintf(`SELECT stats FROM %s WHERE share_id = %s`, sqlTableShares, sqlPlaceholders[0])
+}
+
+func getUpdateShareStatsQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET stats = %s WHERE share_id = %s`, sqlTableShares, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
 func getQuotaQuery() string {
 	return fmt.Sprintf(`SELECT used_quota_size,used_quota_files,used_upload_data_transfer,
 		used_download_data_transfer FROM %s WHERE username = %s`,
 		sqlTableUsers, sqlPlaceholders[0])
 }
 
-func getAddUserQuery(role string) string {
+func getAddUserQuery(role, tenant string) string {
 	return fmt.Sprintf(`INSERT INTO %s (username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,
 		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,status,last_login,expiration_date,filters,
 		filesystem,additional_info,description,email,created_at,updated_at,upload_data_transfer,download_data_transfer,total_data_transfer,
-		used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload,role_id,last_password_change)
+		used_upload_data_transfer,used_download_data_transfer,deleted_at,first_download,first_upload,role_id,last_password_change,tenant_id)
 		VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,%s,%s,%s,0,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,0,0,0,0,0,
-		COALESCE((SELECT id from %s WHERE name=%s),%s),%s)`,
+		COALESCE((SELECT id from %s WHERE name=%s),%s),%s,%s)`,
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
 		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14],
 		sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18], sqlPlaceholders[19],
 		sqlPlaceholders[20], sqlPlaceholders[21], sqlPlaceholders[22], sqlPlaceholders[23], sqlTableRoles,
-		sqlPlaceholders[24], getCoalesceDefaultForRole(role), sqlPlaceholders[25])
+		sqlPlaceholders[24], getCoalesceDefaultForRole(role), sqlPlaceholders[25], getTenantIDValue(tenant, 26))
 }
 
 func getUpdateUserQuery(role string) string {
@@ -720,6 +868,11 @@ func getUpdateUserPasswordQuery() string {
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
 }
 
+func getUpdateAdminPasswordQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET password=%s,updated_at=%s WHERE username = %s`,
+		sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
 func getDeleteUserQuery(softDelete bool) string {
 	if softDelete {
 		return fmt.Sprintf(`UPDATE %s SET updated_at=%s,deleted_at=%s WHERE username = %s`,
@@ -733,13 +886,13 @@ func getRemoveSoftDeletedUserQuery() string {
 }
 
 func getFolderByNameQuery() string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, selectFolderFields, sqlTableFolders, sqlPlaceholders[0])
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE name = %s`, getSelectFolderFields(), sqlTableFolders, sqlPlaceholders[0])
 }
 
-func getAddFolderQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+func getAddFolderQuery(tenant string) string {
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,tenant_id)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], getTenantIDValue(tenant, 7))
 }
 
 func getUpdateFolderQuery() string {
@@ -799,15 +952,16 @@ func getAddUserFolderMappingQuery() string {
 		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
 }
 
-func getFoldersQuery(order string, minimal bool) string {
+func getFoldersQuery(order string, minimal bool, tenant string) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
-		fieldSelection = selectFolderFields
+		fieldSelection = getSelectFolderFields()
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
-		order, sqlPlaceholders[0], sqlPlaceholders[1])
+	tenantFilter, idx := getTenantFilter("WHERE", "tenant_id", tenant, 0)
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
+		tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 }
 
 func getUpdateFolderQuotaQuery(reset bool) string {
@@ -1042,9 +1196,10 @@ func getDeleteEventActionQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE name = %s`, sqlTableEventsActions, sqlPlaceholders[0])
 }
 
-func getEventRulesQuery(order string) string {
-	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0 ORDER BY name %s LIMIT %s OFFSET %s`,
-		getSelectEventRuleFields(), sqlTableEventsRules, order, sqlPlaceholders[0], sqlPlaceholders[1])
+func getEventRulesQuery(order, tenant string) string {
+	tenantFilter, idx := getTenantFilter("AND", "tenant_id", tenant, 0)
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE deleted_at = 0%s ORDER BY name %s LIMIT %s OFFSET %s`,
+		getSelectEventRuleFields(), sqlTableEventsRules, tenantFilter, order, sqlPlaceholders[idx], sqlPlaceholders[idx+1])
 }
 
 func getDumpEventRulesQuery() string {
@@ -1061,11 +1216,11 @@ func getEventRulesByNameQuery() string {
 		sqlPlaceholders[0])
 }
 
-func getAddEventRuleQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,%s,conditions,deleted_at,status)
-		VALUES (%s,%s,%s,%s,%s,%s,0,%s)`,
+func getAddEventRuleQuery(tenant string) string {
+	return fmt.Sprintf(`INSERT INTO %s (name,description,created_at,updated_at,%s,conditions,deleted_at,status,tenant_id)
+		VALUES (%s,%s,%s,%s,%s,%s,0,%s,%s)`,
 		sqlTableEventsRules, getSQLQuotedName("trigger"), sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], getTenantIDValue(tenant, 7))
 }
 
 func getUpdateEventRuleQuery() string {
@@ -0,0 +1,198 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	sqlReplicasCheckInterval = 10 * time.Second
+)
+
+// ReadReplicasConfig defines the read replicas for the mysql and postgresql providers.
+// Logins, lookups and list queries are routed to the replicas, all the other queries,
+// including the ones executed before an update, use the main connection
+type ReadReplicasConfig struct {
+	// Connection strings for the read replicas, the format is the same as for the
+	// main connection string
+	ConnectionStrings []string `json:"connection_strings" mapstructure:"connection_strings"`
+	// Maximum allowed replication lag, in seconds. A replica lagging behind more than
+	// this value is not used until it catches up. 0 means the lag is not checked
+	MaxLag int `json:"max_lag" mapstructure:"max_lag"`
+}
+
+func (c *ReadReplicasConfig) isEnabled() bool {
+	return len(c.ConnectionStrings) > 0
+}
+
+func (c *ReadReplicasConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if config.Driver != MySQLDataProviderName && config.Driver != PGSQLDataProviderName {
+		return fmt.Errorf("read replicas are not supported for the %q provider", config.Driver)
+	}
+	for _, connString := range c.ConnectionStrings {
+		if strings.TrimSpace(connString) == "" {
+			return errors.New("read replicas: empty connection strings are not allowed")
+		}
+	}
+	if c.MaxLag < 0 {
+		return fmt.Errorf("invalid read replicas max lag: %d", c.MaxLag)
+	}
+	return nil
+}
+
+// sqlReplicaLagFunc returns the replication lag for the given database handle
+type sqlReplicaLagFunc func(ctx context.Context, dbHandle *sql.DB) (time.Duration, error)
+
+type sqlReplica struct {
+	idx      int
+	dbHandle *sql.DB
+	isActive atomic.Bool
+}
+
+// sqlReplicas distributes the read queries between the active replicas.
+// A replica is active if it is reachable and its replication lag is below
+// the configured maximum, if no replica is active the main connection is used
+type sqlReplicas struct {
+	replicas []*sqlReplica
+	getLag   sqlReplicaLagFunc
+	maxLag   time.Duration
+	counter  atomic.Uint64
+	cancel   context.CancelFunc
+}
+
+func newSQLReplicas(driverName string, getLag sqlReplicaLagFunc) (*sqlReplicas, error) {
+	if !config.ReadReplicas.isEnabled() {
+		return nil, nil
+	}
+	r := &sqlReplicas{
+		getLag: getLag,
+		maxLag: time.Duration(config.ReadReplicas.MaxLag) * time.Second,
+	}
+	for idx, connString := range config.ReadReplicas.ConnectionStrings {
+		dbHandle, err := sql.Open(driverName, connString)
+		if err != nil {
+			providerLog(logger.LevelError, "error creating database handler for read replica %d: %v", idx, err)
+			r.closeHandles()
+			return nil, err
+		}
+		dbHandle.SetMaxOpenConns(config.PoolSize)
+		if config.PoolSize > 0 {
+			dbHandle.SetMaxIdleConns(config.PoolSize)
+		} else {
+			dbHandle.SetMaxIdleConns(2)
+		}
+		dbHandle.SetConnMaxLifetime(240 * time.Second)
+		dbHandle.SetConnMaxIdleTime(120 * time.Second)
+		r.replicas = append(r.replicas, &sqlReplica{
+			idx:      idx,
+			dbHandle: dbHandle,
+		})
+	}
+	providerLog(logger.LevelDebug, "%d read replicas configured, max lag: %s", len(r.replicas), r.maxLag)
+	r.check()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.monitor(ctx)
+	return r, nil
+}
+
+// getHandle returns the handle for an active replica or the main handle
+func (r *sqlReplicas) getHandle(dbHandle *sql.DB) *sql.DB {
+	if r == nil {
+		return dbHandle
+	}
+	numReplicas := uint64(len(r.replicas))
+	start := r.counter.Add(1)
+	for i := uint64(0); i < numReplicas; i++ {
+		replica := r.replicas[(start+i)%numReplicas]
+		if replica.isActive.Load() {
+			return replica.dbHandle
+		}
+	}
+	return dbHandle
+}
+
+func (r *sqlReplicas) monitor(ctx context.Context) {
+	ticker := time.NewTicker(sqlReplicasCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func (r *sqlReplicas) check() {
+	for _, replica := range r.replicas {
+		isActive, reason := r.checkReplica(replica)
+		if replica.isActive.Swap(isActive) != isActive {
+			if isActive {
+				providerLog(logger.LevelInfo, "read replica %d is now active", replica.idx)
+			} else {
+				providerLog(logger.LevelWarn, "read replica %d is now inactive: %s", replica.idx, reason)
+			}
+		}
+	}
+}
+
+func (r *sqlReplicas) checkReplica(replica *sqlReplica) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	if err := replica.dbHandle.PingContext(ctx); err != nil {
+		return false, err.Error()
+	}
+	if r.maxLag == 0 {
+		return true, ""
+	}
+	lag, err := r.getLag(ctx, replica.dbHandle)
+	if err != nil {
+		return false, fmt.Sprintf("unable to get the replication lag: %v", err)
+	}
+	if lag > r.maxLag {
+		return false, fmt.Sprintf("replication lag %s exceeds the allowed maximum", lag)
+	}
+	return true, ""
+}
+
+func (r *sqlReplicas) closeHandles() {
+	for _, replica := range r.replicas {
+		replica.dbHandle.Close()
+	}
+}
+
+func (r *sqlReplicas) close() {
+	if r == nil {
+		return
+	}
+	r.cancel()
+	r.closeHandles()
+}
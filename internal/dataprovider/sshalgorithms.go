@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const keyExchangeCurve25519SHA256LibSSH = "curve25519-sha256@libssh.org"
+
+// SSHAlgorithmsPolicy restricts the SSH algorithms a user can negotiate.
+// The algorithms must also be enabled in the SFTP service configuration,
+// an empty list means that all the globally enabled algorithms are allowed.
+// The key exchange, cipher and MAC are negotiated before the authentication,
+// so clients using a different algorithm are rejected at login
+type SSHAlgorithmsPolicy struct {
+	// Allowed key exchange algorithms
+	KexAlgorithms []string `json:"kex_algorithms,omitempty"`
+	// Allowed ciphers
+	Ciphers []string `json:"ciphers,omitempty"`
+	// Allowed MAC algorithms. MACs are not negotiated for AEAD ciphers
+	MACs []string `json:"macs,omitempty"`
+	// Allowed public key algorithms for public key authentication.
+	// For RSA keys the key is allowed if any of the RSA algorithms is
+	// allowed, the signature algorithm is restricted by the service
+	// configuration. For certificates the underlying key is checked
+	PublicKeyAlgorithms []string `json:"public_key_algorithms,omitempty"`
+}
+
+// IsSet returns true if at least an algorithm restriction is defined
+func (p *SSHAlgorithmsPolicy) IsSet() bool {
+	return len(p.KexAlgorithms) > 0 || len(p.Ciphers) > 0 || len(p.MACs) > 0 || len(p.PublicKeyAlgorithms) > 0
+}
+
+func (p *SSHAlgorithmsPolicy) getACopy() SSHAlgorithmsPolicy {
+	kexs := make([]string, len(p.KexAlgorithms))
+	copy(kexs, p.KexAlgorithms)
+	ciphers := make([]string, len(p.Ciphers))
+	copy(ciphers, p.Ciphers)
+	macs := make([]string, len(p.MACs))
+	copy(macs, p.MACs)
+	pubKeyAlgos := make([]string, len(p.PublicKeyAlgorithms))
+	copy(pubKeyAlgos, p.PublicKeyAlgorithms)
+
+	return SSHAlgorithmsPolicy{
+		KexAlgorithms:       kexs,
+		Ciphers:             ciphers,
+		MACs:                macs,
+		PublicKeyAlgorithms: pubKeyAlgos,
+	}
+}
+
+// merge sets the missing restrictions from the specified policy
+func (p *SSHAlgorithmsPolicy) merge(policy *SSHAlgorithmsPolicy) {
+	groupPolicy := policy.getACopy()
+	if len(p.KexAlgorithms) == 0 {
+		p.KexAlgorithms = groupPolicy.KexAlgorithms
+	}
+	if len(p.Ciphers) == 0 {
+		p.Ciphers = groupPolicy.Ciphers
+	}
+	if len(p.MACs) == 0 {
+		p.MACs = groupPolicy.MACs
+	}
+	if len(p.PublicKeyAlgorithms) == 0 {
+		p.PublicKeyAlgorithms = groupPolicy.PublicKeyAlgorithms
+	}
+}
+
+func (p *SSHAlgorithmsPolicy) validate() error {
+	supported := ssh.SupportedAlgorithms()
+	insecure := ssh.InsecureAlgorithms()
+	kexs := append(joinSSHAlgorithms(supported.KeyExchanges, insecure.KeyExchanges), keyExchangeCurve25519SHA256LibSSH)
+
+	var err error
+	p.KexAlgorithms, err = validateSSHAlgorithms(p.KexAlgorithms, kexs, "key exchange algorithm")
+	if err != nil {
+		return err
+	}
+	p.Ciphers, err = validateSSHAlgorithms(p.Ciphers, joinSSHAlgorithms(supported.Ciphers, insecure.Ciphers), "cipher")
+	if err != nil {
+		return err
+	}
+	p.MACs, err = validateSSHAlgorithms(p.MACs, joinSSHAlgorithms(supported.MACs, insecure.MACs), "MAC algorithm")
+	if err != nil {
+		return err
+	}
+	p.PublicKeyAlgorithms, err = validateSSHAlgorithms(p.PublicKeyAlgorithms,
+		joinSSHAlgorithms(supported.PublicKeyAuths, insecure.PublicKeyAuths), "public key algorithm")
+	return err
+}
+
+// IsKexAllowed returns true if the specified key exchange algorithm is allowed
+func (p *SSHAlgorithmsPolicy) IsKexAllowed(kex string) bool {
+	if len(p.KexAlgorithms) == 0 {
+		return true
+	}
+	if util.Contains(p.KexAlgorithms, kex) {
+		return true
+	}
+	// curve25519-sha256 and curve25519-sha256@libssh.org are the same algorithm
+	switch kex {
+	case ssh.KeyExchangeCurve25519SHA256:
+		return util.Contains(p.KexAlgorithms, keyExchangeCurve25519SHA256LibSSH)
+	case keyExchangeCurve25519SHA256LibSSH:
+		return util.Contains(p.KexAlgorithms, ssh.KeyExchangeCurve25519SHA256)
+	}
+	return false
+}
+
+// IsCipherAllowed returns true if the specified cipher is allowed
+func (p *SSHAlgorithmsPolicy) IsCipherAllowed(cipher string) bool {
+	return len(p.Ciphers) == 0 || util.Contains(p.Ciphers, cipher)
+}
+
+// IsMACAllowed returns true if the specified MAC algorithm is allowed.
+// An empty MAC, negotiated for AEAD ciphers, is always allowed
+func (p *SSHAlgorithmsPolicy) IsMACAllowed(mac string) bool {
+	return len(p.MACs) == 0 || mac == "" || util.Contains(p.MACs, mac)
+}
+
+// IsPublicKeyAllowed returns true if the specified public key can be used
+// for authentication
+func (p *SSHAlgorithmsPolicy) IsPublicKeyAllowed(key ssh.PublicKey) bool {
+	if len(p.PublicKeyAlgorithms) == 0 {
+		return true
+	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	keyType := key.Type()
+	if keyType == ssh.KeyAlgoRSA {
+		for _, algo := range []string{ssh.KeyAlgoRSA, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512} {
+			if util.Contains(p.PublicKeyAlgorithms, algo) {
+				return true
+			}
+		}
+		return false
+	}
+	return util.Contains(p.PublicKeyAlgorithms, keyType)
+}
+
+func validateSSHAlgorithms(algos, supported []string, algoType string) ([]string, error) {
+	algos = util.RemoveDuplicates(algos, true)
+	for _, algo := range algos {
+		if !util.Contains(supported, algo) {
+			return nil, util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("unsupported SSH %s %q", algoType, algo)),
+				util.I18nErrorSSHAlgorithmsInvalid,
+			)
+		}
+	}
+	return algos, nil
+}
+
+// joinSSHAlgorithms returns a new slice, the ones returned by the ssh package
+// must not be modified
+func joinSSHAlgorithms(supported, insecure []string) []string {
+	result := make([]string, 0, len(supported)+len(insecure))
+	result = append(result, supported...)
+	return append(result, insecure...)
+}
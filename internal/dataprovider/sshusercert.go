@@ -0,0 +1,55 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// SSHUserCertificate describes an SSH user certificate issued by the SFTPGo
+// user certificate authority
+type SSHUserCertificate struct {
+	// Username of the user the certificate was issued for
+	Username string `json:"username"`
+	// Certificate key ID, it matches the username
+	KeyID string `json:"key_id"`
+	// Certificate serial number
+	Serial uint64 `json:"serial"`
+	// Principals the certificate is valid for
+	Principals []string `json:"principals"`
+	// SHA256 fingerprint of the certified public key
+	Fingerprint string `json:"fingerprint"`
+	// Validity interval as unix timestamp in milliseconds
+	ValidAfter  int64 `json:"valid_after"`
+	ValidBefore int64 `json:"valid_before"`
+	// Certificate in authorized keys format
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// RenderAsJSON implements the renderer interface used within plugins.
+// The certificate itself is not rendered
+func (c *SSHUserCertificate) RenderAsJSON(_ bool) ([]byte, error) {
+	cert := *c
+	cert.Certificate = ""
+	return json.Marshal(cert)
+}
+
+// NotifySSHUserCertificateIssued executes the configured provider actions
+// and event rules for an issued SSH user certificate
+func NotifySSHUserCertificateIssued(cert *SSHUserCertificate, executor, ipAddress, role string) {
+	executeAction(operationAdd, executor, ipAddress, actionObjectSSHUserCert, strconv.FormatUint(cert.Serial, 10),
+		role, cert)
+}
@@ -0,0 +1,358 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// permissions that tenant admins cannot have, they allow to view or manage
+	// objects and information shared by all the tenants
+	forbiddenPermsForTenantAdmins = []string{PermAdminAny, PermAdminViewConnections, PermAdminCloseConnections,
+		PermAdminViewServerStatus, PermAdminManageAPIKeys, PermAdminQuotaScans, PermAdminManageSystem,
+		PermAdminManageDefender, PermAdminViewDefender, PermAdminRetentionChecks, PermAdminViewEvents,
+		PermAdminManageIPLists, PermAdminDisableMFA, PermAdminManageTenants, PermAdminViewIPLists,
+		PermAdminAddIPLists, PermAdminChangeIPLists, PermAdminDeleteIPLists, PermAdminViewTenants,
+		PermAdminAddTenants, PermAdminChangeTenants, PermAdminDeleteTenants, PermAdminViewAuditLog}
+	// event triggers allowed for tenant rules, the other triggers are not
+	// associated to a tenant
+	allowedTenantRuleTriggers = []int{EventTriggerFsEvent, EventTriggerProviderEvent}
+)
+
+// TenantUIBranding defines the customizations for a web UI that override
+// the ones configured for the HTTP binding
+type TenantUIBranding struct {
+	// Name to show at the login page and as HTML title
+	Name string `json:"name,omitempty"`
+	// Name to show next to the logo image
+	ShortName string `json:"short_name,omitempty"`
+	// Path to the logo relative to "static_files_path"
+	LogoPath string `json:"logo_path,omitempty"`
+	// Path to the favicon relative to "static_files_path"
+	FaviconPath string `json:"favicon_path,omitempty"`
+	// Name for the link to the optional disclaimer
+	DisclaimerName string `json:"disclaimer_name,omitempty"`
+	// Path to the HTML page for the disclaimer relative to "static_files_path"
+	// or an absolute http/https URL
+	DisclaimerPath string `json:"disclaimer_path,omitempty"`
+}
+
+func (b *TenantUIBranding) validate() error {
+	for _, p := range []*string{&b.LogoPath, &b.FaviconPath} {
+		if *p != "" {
+			*p = util.CleanPath(*p)
+		}
+	}
+	if b.DisclaimerPath != "" && !strings.HasPrefix(b.DisclaimerPath, "https://") &&
+		!strings.HasPrefix(b.DisclaimerPath, "http://") {
+		b.DisclaimerPath = util.CleanPath(b.DisclaimerPath)
+	}
+	if b.DisclaimerName != "" && b.DisclaimerPath == "" {
+		return util.NewValidationError("branding: disclaimer path is required if a disclaimer name is set")
+	}
+	return nil
+}
+
+// TenantBranding defines the branding customizations for a tenant
+type TenantBranding struct {
+	WebAdmin  TenantUIBranding `json:"web_admin,omitempty"`
+	WebClient TenantUIBranding `json:"web_client,omitempty"`
+}
+
+// Tenant defines an isolated set of admins, users, groups, roles, folders
+// and event rules. Tenant admins can only view and manage the objects
+// within their tenant
+type Tenant struct {
+	// Data provider unique identifier
+	ID int64 `json:"id"`
+	// Tenant name
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// Web UIs customizations
+	Branding TenantBranding `json:"branding,omitempty"`
+	// Optional absolute path. If set, the home directories of the tenant users
+	// and groups and the mapped paths of the tenant folders must be inside it
+	StorageBasePath string `json:"storage_base_path,omitempty"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// RenderAsJSON implements the renderer interface used within plugins
+func (t *Tenant) RenderAsJSON(reload bool) ([]byte, error) {
+	if reload {
+		tenant, err := provider.tenantExists(t.Name)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to reload tenant before rendering as json: %v", err)
+			return nil, err
+		}
+		return json.Marshal(tenant)
+	}
+	return json.Marshal(t)
+}
+
+func (t *Tenant) validate() error {
+	if t.Name == "" {
+		return util.NewI18nError(util.NewValidationError("name is mandatory"), util.I18nErrorNameRequired)
+	}
+	if len(t.Name) > 255 {
+		return util.NewValidationError("name is too long, 255 is the maximum length allowed")
+	}
+	if config.NamingRules&1 == 0 && !usernameRegex.MatchString(t.Name) {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("name %q is not valid, the following characters are allowed: a-zA-Z0-9-_.~", t.Name)),
+			util.I18nErrorInvalidName,
+		)
+	}
+	if t.StorageBasePath != "" {
+		t.StorageBasePath = filepath.Clean(t.StorageBasePath)
+		if !filepath.IsAbs(t.StorageBasePath) {
+			return util.NewValidationError(fmt.Sprintf("storage base path must be an absolute path, actual value: %v",
+				t.StorageBasePath))
+		}
+	}
+	if err := t.Branding.WebAdmin.validate(); err != nil {
+		return err
+	}
+	return t.Branding.WebClient.validate()
+}
+
+// isStoragePathAllowed returns true if the specified local path is inside
+// the storage base path or no storage base path is set
+func (t *Tenant) isStoragePathAllowed(p string) bool {
+	if t.StorageBasePath == "" {
+		return true
+	}
+	p = filepath.Clean(p)
+	if p == t.StorageBasePath {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(t.StorageBasePath, string(os.PathSeparator))+string(os.PathSeparator))
+}
+
+func (t *Tenant) getACopy() Tenant {
+	return Tenant{
+		ID:              t.ID,
+		Name:            t.Name,
+		Description:     t.Description,
+		Branding:        t.Branding,
+		StorageBasePath: t.StorageBasePath,
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+	}
+}
+
+// GetObjectTenant returns the tenant for the specified provider object,
+// an empty string means that the object is not associated to any tenant
+func GetObjectTenant(object plugin.Renderer) string {
+	switch o := object.(type) {
+	case *User:
+		return o.Tenant
+	case *Admin:
+		return o.Tenant
+	case *Group:
+		return o.Tenant
+	case *Role:
+		return o.Tenant
+	case *wrappedFolder:
+		return o.Folder.Tenant
+	case *EventRule:
+		return o.Tenant
+	case *Tenant:
+		return o.Name
+	default:
+		return ""
+	}
+}
+
+func checkTenantExists(tenant string) error {
+	if tenant == "" {
+		return nil
+	}
+	if _, err := provider.tenantExists(tenant); err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return util.NewValidationError(fmt.Sprintf("tenant %q does not exist", tenant))
+		}
+		return err
+	}
+	return nil
+}
+
+// checkTenantStoragePaths checks that the specified local paths are inside
+// the storage base path of the tenant, if any. Empty paths are ignored
+func checkTenantStoragePaths(tenant string, paths ...string) error {
+	if tenant == "" {
+		return nil
+	}
+	t, err := provider.tenantExists(tenant)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return util.NewValidationError(fmt.Sprintf("tenant %q does not exist", tenant))
+		}
+		return err
+	}
+	for _, p := range paths {
+		if p != "" && !t.isStoragePathAllowed(p) {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("path %q is outside the storage base path %q of the tenant %q",
+					p, t.StorageBasePath, t.Name)),
+				util.I18nErrorTenantStoragePath,
+			)
+		}
+	}
+	return nil
+}
+
+func getFoldersMappedPaths(folders []vfs.VirtualFolder) []string {
+	paths := make([]string, 0, len(folders))
+	for _, f := range folders {
+		paths = append(paths, f.MappedPath)
+	}
+	return paths
+}
+
+func newTenantMismatchError(objectType, name, tenant string) error {
+	if tenant == "" {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("%s %q belongs to a tenant, it cannot be used outside of it", objectType, name)),
+			util.I18nErrorTenantMismatch,
+		)
+	}
+	return util.NewI18nError(
+		util.NewValidationError(fmt.Sprintf("%s %q does not belong to the tenant %q", objectType, name, tenant)),
+		util.I18nErrorTenantMismatch,
+	)
+}
+
+func checkGroupsTenant(names []string, tenant string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	groups, err := provider.getGroupsWithNames(names)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if group.Tenant != tenant {
+			return newTenantMismatchError("group", group.Name, tenant)
+		}
+	}
+	return nil
+}
+
+func checkFoldersTenant(folders []vfs.VirtualFolder, tenant string) error {
+	for _, f := range folders {
+		folder, err := provider.getFolderByName(f.Name)
+		if err != nil {
+			// the provider will report the missing folder
+			continue
+		}
+		if folder.Tenant != tenant {
+			return newTenantMismatchError("folder", folder.Name, tenant)
+		}
+	}
+	return nil
+}
+
+func checkRoleTenant(roleName, tenant string) error {
+	if roleName == "" {
+		return nil
+	}
+	role, err := provider.roleExists(roleName)
+	if err != nil {
+		// the provider will report the missing role
+		return nil
+	}
+	if role.Tenant != tenant {
+		return newTenantMismatchError("role", role.Name, tenant)
+	}
+	return nil
+}
+
+// checkUserTenant checks that the tenant exists, that the user paths are inside
+// the tenant storage base path and that the groups, folders and role associated
+// to the user belong to the same tenant
+func checkUserTenant(user *User) error {
+	// check the home dir that will be saved, the default one if not set
+	buildUserHomeDir(user)
+	paths := append(getFoldersMappedPaths(user.VirtualFolders), user.HomeDir)
+	if err := checkTenantStoragePaths(user.Tenant, paths...); err != nil {
+		return err
+	}
+	groups := make([]string, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		groups = append(groups, g.Name)
+	}
+	if err := checkGroupsTenant(groups, user.Tenant); err != nil {
+		return err
+	}
+	if err := checkFoldersTenant(user.VirtualFolders, user.Tenant); err != nil {
+		return err
+	}
+	return checkRoleTenant(user.Role, user.Tenant)
+}
+
+func checkAdminTenant(admin *Admin) error {
+	if err := checkTenantExists(admin.Tenant); err != nil {
+		return err
+	}
+	groups := make([]string, 0, len(admin.Groups))
+	for _, g := range admin.Groups {
+		groups = append(groups, g.Name)
+	}
+	if err := checkGroupsTenant(groups, admin.Tenant); err != nil {
+		return err
+	}
+	return checkRoleTenant(admin.Role, admin.Tenant)
+}
+
+func checkGroupTenant(group *Group) error {
+	paths := append(getFoldersMappedPaths(group.VirtualFolders), group.UserSettings.HomeDir)
+	if err := checkTenantStoragePaths(group.Tenant, paths...); err != nil {
+		return err
+	}
+	return checkFoldersTenant(group.VirtualFolders, group.Tenant)
+}
+
+func checkFolderTenant(folder *vfs.BaseVirtualFolder) error {
+	return checkTenantStoragePaths(folder.Tenant, folder.MappedPath)
+}
+
+func checkEventRuleTenant(rule *EventRule) error {
+	if rule.Tenant == "" {
+		return nil
+	}
+	if err := checkTenantExists(rule.Tenant); err != nil {
+		return err
+	}
+	if !util.Contains(allowedTenantRuleTriggers, rule.Trigger) {
+		return util.NewValidationError(fmt.Sprintf("trigger %q is not supported for tenant rules",
+			getTriggerTypeAsString(rule.Trigger)))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// TrashDirName is the name of the hidden directory, inside the user's home,
+// where deleted files are moved if the trash is enabled
+const TrashDirName = ".trash"
+
+const maxTrashRetentionDays = 3650
+
+// TrashPolicy defines the trash configuration for a user.
+// If enabled, files deleted using any protocol are moved to a hidden trash
+// directory, from where they can be restored using the WebClient or the REST API.
+// Files deleted inside virtual folders are removed permanently
+type TrashPolicy struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Files older than the specified number of days are automatically
+	// removed from the trash. 0 means no automatic removal
+	RetentionDays int `json:"retention_days,omitempty"`
+	// If set, the files in the trash are not included in the user quota
+	ExcludeFromQuota bool `json:"exclude_from_quota,omitempty"`
+}
+
+// IsEnabled returns true if the trash is enabled
+func (p *TrashPolicy) IsEnabled() bool {
+	return p.Enabled
+}
+
+func (p *TrashPolicy) validate() error {
+	if !p.Enabled {
+		p.RetentionDays = 0
+		p.ExcludeFromQuota = false
+		return nil
+	}
+	if p.RetentionDays < 0 || p.RetentionDays > maxTrashRetentionDays {
+		return util.NewValidationError(fmt.Sprintf("invalid trash retention %d, allowed range 0-%d days",
+			p.RetentionDays, maxTrashRetentionDays))
+	}
+	return nil
+}
+
+// GetTrashPath returns the virtual path for the trash directory
+func GetTrashPath() string {
+	return "/" + TrashDirName
+}
+
+// IsTrashPath returns true if the trash is enabled and the specified
+// virtual path is the trash directory or is inside it
+func (u *User) IsTrashPath(virtualPath string) bool {
+	if !u.Filters.Trash.IsEnabled() {
+		return false
+	}
+	trashPath := GetTrashPath()
+	return virtualPath == trashPath || strings.HasPrefix(virtualPath, trashPath+"/")
+}
@@ -85,9 +85,11 @@ const (
 	SSHLoginMethodKeyboardInteractive = "keyboard-interactive"
 	SSHLoginMethodKeyAndPassword      = "publickey+password"
 	SSHLoginMethodKeyAndKeyboardInt   = "publickey+keyboard-interactive"
+	SSHLoginMethodGSSAPI              = "gssapi-with-mic"
 	LoginMethodTLSCertificate         = "TLSCertificate"
 	LoginMethodTLSCertificateAndPwd   = "TLSCertificate+password"
 	LoginMethodIDP                    = "IDP"
+	LoginMethodS3Credentials          = "S3Credentials"
 )
 
 var (
@@ -117,6 +119,163 @@ type UserTOTPConfig struct {
 	Protocols []string `json:"protocols,omitempty"`
 }
 
+// TwoFactorAuthExemption defines the source networks from which the second
+// factor is not required for the specified protocols
+type TwoFactorAuthExemption struct {
+	// Protocols for which the exemption applies, must be a subset of the MFA protocols
+	Protocols []string `json:"protocols"`
+	// Source networks in CIDR notation as defined in RFC 4632 and RFC 4291
+	Networks []string `json:"networks"`
+}
+
+func (e *TwoFactorAuthExemption) getACopy() TwoFactorAuthExemption {
+	protocols := make([]string, len(e.Protocols))
+	copy(protocols, e.Protocols)
+	networks := make([]string, len(e.Networks))
+	copy(networks, e.Networks)
+
+	return TwoFactorAuthExemption{
+		Protocols: protocols,
+		Networks:  networks,
+	}
+}
+
+func (e *TwoFactorAuthExemption) isExempted(protocol string, ip net.IP) bool {
+	if !util.Contains(e.Protocols, protocol) {
+		return false
+	}
+	for _, network := range e.Networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *TwoFactorAuthExemption) validate() error {
+	if len(e.Protocols) == 0 {
+		return util.NewValidationError("no protocol specified for the two factor exemption")
+	}
+	e.Protocols = util.RemoveDuplicates(e.Protocols, false)
+	for _, p := range e.Protocols {
+		if !util.Contains(MFAProtocols, p) {
+			return util.NewValidationError(fmt.Sprintf("invalid two factor exemption protocol %q", p))
+		}
+	}
+	if len(e.Networks) == 0 {
+		return util.NewValidationError("no network specified for the two factor exemption")
+	}
+	e.Networks = util.RemoveDuplicates(e.Networks, false)
+	for _, network := range e.Networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not parse two factor exemption network %q: %v", network, err))
+		}
+	}
+	return nil
+}
+
+func validateTwoFactorAuthExemptions(exemptions []TwoFactorAuthExemption) error {
+	for idx := range exemptions {
+		if err := exemptions[idx].validate(); err != nil {
+			return util.NewI18nError(err, util.I18nError2FAExemptionInvalid)
+		}
+	}
+	return nil
+}
+
+func copyTwoFactorAuthExemptions(exemptions []TwoFactorAuthExemption) []TwoFactorAuthExemption {
+	result := make([]TwoFactorAuthExemption, 0, len(exemptions))
+	for idx := range exemptions {
+		result = append(result, exemptions[idx].getACopy())
+	}
+	return result
+}
+
+// ProtocolBandwidthLimit defines the upload and download bandwidth for the
+// specified protocols. It overrides the user bandwidth and the source based
+// bandwidth limits, this way, for example, SCP transfers can have different
+// limits than SFTP transfers
+type ProtocolBandwidthLimit struct {
+	// Protocols for which the limit applies
+	Protocols []string `json:"protocols"`
+	// Maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth,omitempty"`
+	// Maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth,omitempty"`
+}
+
+func (l *ProtocolBandwidthLimit) getACopy() ProtocolBandwidthLimit {
+	protocols := make([]string, len(l.Protocols))
+	copy(protocols, l.Protocols)
+
+	return ProtocolBandwidthLimit{
+		Protocols:         protocols,
+		UploadBandwidth:   l.UploadBandwidth,
+		DownloadBandwidth: l.DownloadBandwidth,
+	}
+}
+
+func (l *ProtocolBandwidthLimit) validate() error {
+	if len(l.Protocols) == 0 {
+		return util.NewValidationError("no protocol specified for the bandwidth limit")
+	}
+	l.Protocols = util.RemoveDuplicates(l.Protocols, false)
+	for _, p := range l.Protocols {
+		if !util.Contains(BandwidthLimitProtocols, p) {
+			return util.NewValidationError(fmt.Sprintf("invalid bandwidth limit protocol %q", p))
+		}
+	}
+	if l.UploadBandwidth < 0 {
+		l.UploadBandwidth = 0
+	}
+	if l.DownloadBandwidth < 0 {
+		l.DownloadBandwidth = 0
+	}
+	return nil
+}
+
+func validateProtocolBandwidthLimits(limits []ProtocolBandwidthLimit) error {
+	protocols := make(map[string]bool)
+	for idx := range limits {
+		if err := limits[idx].validate(); err != nil {
+			return util.NewI18nError(err, util.I18nErrorProtocolBandwidthInvalid)
+		}
+		for _, p := range limits[idx].Protocols {
+			if protocols[p] {
+				return util.NewI18nError(
+					util.NewValidationError(fmt.Sprintf("duplicated bandwidth limit for protocol %q", p)),
+					util.I18nErrorProtocolBandwidthInvalid,
+				)
+			}
+			protocols[p] = true
+		}
+	}
+	return nil
+}
+
+func validateFTPPassiveIP(passiveIP string) error {
+	if passiveIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(passiveIP)
+	if ip == nil || ip.To4() == nil {
+		return util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("invalid FTP passive IP %q, an IPv4 address is required", passiveIP)),
+			util.I18nErrorFTPPassiveIPInvalid,
+		)
+	}
+	return nil
+}
+
+func copyProtocolBandwidthLimits(limits []ProtocolBandwidthLimit) []ProtocolBandwidthLimit {
+	result := make([]ProtocolBandwidthLimit, 0, len(limits))
+	for idx := range limits {
+		result = append(result, limits[idx].getACopy())
+	}
+	return result
+}
+
 // UserFilters defines additional restrictions for a user
 // TODO: rename to UserOptions in v3
 type UserFilters struct {
@@ -129,6 +288,35 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// Source networks from which the second factor is not required for the
+	// specified protocols
+	TwoFactorAuthExemptions []TwoFactorAuthExemption `json:"two_factor_exemptions,omitempty"`
+	// SSH port forwarding policy
+	PortForwarding PortForwardingPolicy `json:"port_forwarding,omitempty"`
+	// Per-protocol bandwidth limits
+	ProtocolBandwidthLimits []ProtocolBandwidthLimit `json:"protocol_bandwidth_limits,omitempty"`
+	// If enabled existing files cannot be deleted or overwritten, whatever the
+	// configured permissions are. Useful for backup clients such as borg and restic
+	AppendOnly bool `json:"append_only,omitempty"`
+	// SSH algorithms allowed for this user
+	SSHAlgorithms SSHAlgorithmsPolicy `json:"ssh_algorithms,omitempty"`
+	// IPv4 address to advertise in FTP passive mode responses. It overrides
+	// the one configured for the FTP binding, useful if some users connect
+	// over a private link and others through a public NAT
+	FTPPassiveIP string `json:"ftp_passive_ip,omitempty"`
+	// Secret access key used to sign requests to the S3 compatible API.
+	// The access key ID is the username
+	S3Secret *kms.Secret `json:"s3_secret,omitempty"`
+	// Trash configuration
+	Trash TrashPolicy `json:"trash,omitempty"`
+	// Versioning rules for overwritten and deleted files
+	FileVersioning []VersioningRule `json:"file_versioning,omitempty"`
+	// Quota limits for directories inside the user's filesystem
+	DirQuotas []DirQuota `json:"dir_quotas,omitempty"`
+	// Disk quota usage percentages that trigger a "quota-threshold" filesystem
+	// event when crossed. They apply to the user quota and to the quota of the
+	// virtual folders not included in the user quota
+	QuotaWarnThresholds []int `json:"quota_warn_thresholds,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -142,6 +330,8 @@ type User struct {
 	FsConfig vfs.Filesystem `json:"filesystem"`
 	// groups associated with this user
 	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// Tenant the user belongs to, empty means no tenant
+	Tenant string `json:"tenant,omitempty"`
 	// we store the filesystem here using the base path as key.
 	fsCache map[string]vfs.Fs `json:"-"`
 	// true if group settings are already applied for this user
@@ -158,7 +348,7 @@ func (u *User) GetFilesystem(connectionID string) (fs vfs.Fs, err error) {
 func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 	switch u.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
-		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)
+		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config.ForUser(u.Username))
 	case sdk.GCSFilesystemProvider:
 		return vfs.NewGCSFs(connectionID, u.GetHomeDir(), "", u.FsConfig.GCSConfig)
 	case sdk.AzureBlobFilesystemProvider:
@@ -174,6 +364,22 @@ func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 		return vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return vfs.NewHTTPFs(connectionID, u.GetHomeDir(), "", u.FsConfig.HTTPConfig)
+	case vfs.B2FilesystemProvider:
+		return vfs.NewB2Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.B2Config)
+	case vfs.DropboxFilesystemProvider:
+		return vfs.NewDropboxFs(connectionID, u.GetHomeDir(), "", u.FsConfig.DropboxConfig)
+	case vfs.GDriveFilesystemProvider:
+		return vfs.NewGDriveFs(connectionID, u.GetHomeDir(), "", u.FsConfig.GDriveConfig)
+	case vfs.OneDriveFilesystemProvider:
+		return vfs.NewOneDriveFs(connectionID, u.GetHomeDir(), "", u.FsConfig.OneDriveConfig)
+	case vfs.WebDAVFilesystemProvider:
+		return vfs.NewWebDAVFs(connectionID, u.GetHomeDir(), "", u.FsConfig.WebDAVConfig)
+	case vfs.SMBFilesystemProvider:
+		return vfs.NewSMBFs(connectionID, u.GetHomeDir(), "", u.FsConfig.SMBConfig)
+	case vfs.HDFSFilesystemProvider:
+		return vfs.NewHDFSFs(connectionID, u.GetHomeDir(), "", u.FsConfig.HDFSConfig)
+	case vfs.SwiftFilesystemProvider:
+		return vfs.NewSwiftFs(connectionID, u.GetHomeDir(), "", u.FsConfig.SwiftConfig.ForOwner(u.Username))
 	default:
 		return vfs.NewOsFs(connectionID, u.GetHomeDir(), "", &u.FsConfig.OSConfig), nil
 	}
@@ -381,6 +587,9 @@ func (u *User) hideConfidentialData() {
 	if u.Filters.TOTPConfig.Secret != nil {
 		u.Filters.TOTPConfig.Secret.Hide()
 	}
+	if u.Filters.S3Secret != nil {
+		u.Filters.S3Secret.Hide()
+	}
 	for _, code := range u.Filters.RecoveryCodes {
 		if code.Secret != nil {
 			code.Secret.Hide()
@@ -473,6 +682,10 @@ func (u *User) hasRedactedSecret() bool {
 		}
 	}
 
+	if u.Filters.S3Secret != nil && u.Filters.S3Secret.IsRedacted() {
+		return true
+	}
+
 	return u.Filters.TOTPConfig.Secret.IsRedacted()
 }
 
@@ -516,6 +729,13 @@ func (u *User) SetEmptySecrets() {
 		folder.FsConfig.SetEmptySecrets()
 	}
 	u.Filters.TOTPConfig.Secret = kms.NewEmptySecret()
+	u.Filters.S3Secret = nil
+}
+
+// HasS3Credentials returns true if the user has a secret access key for the
+// S3 compatible API
+func (u *User) HasS3Credentials() bool {
+	return u.Filters.S3Secret != nil && !u.Filters.S3Secret.IsEmpty()
 }
 
 // GetPermissionsForPath returns the permissions for the given path.
@@ -525,7 +745,7 @@ func (u *User) GetPermissionsForPath(p string) []string {
 	if perms, ok := u.Permissions["/"]; ok {
 		// if only root permissions are defined returns them unconditionally
 		if len(u.Permissions) == 1 {
-			return perms
+			return u.applyAppendOnly(perms)
 		}
 		// fallback permissions
 		permissions = perms
@@ -537,15 +757,33 @@ func (u *User) GetPermissionsForPath(p string) []string {
 	// so the first match is the one we are interested to
 	for idx := range dirsForPath {
 		if perms, ok := u.Permissions[dirsForPath[idx]]; ok {
-			return perms
+			return u.applyAppendOnly(perms)
 		}
 		for dir, perms := range u.Permissions {
 			if match, err := path.Match(dir, dirsForPath[idx]); err == nil && match {
-				return perms
+				return u.applyAppendOnly(perms)
 			}
 		}
 	}
-	return permissions
+	return u.applyAppendOnly(permissions)
+}
+
+// applyAppendOnly removes the permissions that allow to delete or overwrite
+// existing files if the user is in append-only mode
+func (u *User) applyAppendOnly(perms []string) []string {
+	if !u.Filters.AppendOnly {
+		return perms
+	}
+	if util.Contains(perms, PermAny) {
+		perms = ValidPerms
+	}
+	result := make([]string, 0, len(perms))
+	for _, p := range perms {
+		if !util.Contains(appendOnlyDeniedPerms, p) {
+			result = append(result, p)
+		}
+	}
+	return result
 }
 
 func (u *User) getForbiddenSFTPSelfUsers(username string) ([]string, error) {
@@ -611,6 +849,9 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 				}
 				forbiddenSelfUsers = append(forbiddenSelfUsers, forbiddens...)
 			}
+			if folder.FsConfig.Provider == sdk.S3FilesystemProvider {
+				folder.FsConfig.S3Config = folder.FsConfig.S3Config.ForUser(u.Username)
+			}
 			fs, err := folder.GetFilesystem(connectionID, forbiddenSelfUsers)
 			if err == nil {
 				u.fsCache[folder.VirtualPath] = fs
@@ -650,15 +891,30 @@ func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, e
 }
 
 // ScanQuota scans the user home dir and virtual folders, included in its quota,
-// and returns the number of files and their size
-func (u *User) ScanQuota() (int, int64, error) {
+// and returns the number of files and their size. If incremental is true only
+// the directories changed since the previous scan are scanned again
+func (u *User) ScanQuota(incremental bool) (int, int64, error) {
 	fs, err := u.getRootFs(xid.New().String())
 	if err != nil {
 		return 0, 0, err
 	}
 	defer fs.Close()
 
-	numFiles, size, err := fs.ScanRootDirContents()
+	opts := vfs.QuotaScanOptions{
+		Incremental: incremental,
+	}
+	// trash and versions are also changed by the periodic cleanups
+	if u.Filters.Trash.IsEnabled() {
+		if u.Filters.Trash.ExcludeFromQuota {
+			opts.ExcludedPaths = append(opts.ExcludedPaths, GetTrashPath())
+		} else {
+			opts.RescanPaths = append(opts.RescanPaths, GetTrashPath())
+		}
+	}
+	if u.IsVersioningEnabled() {
+		opts.RescanPaths = append(opts.RescanPaths, GetVersionsPath())
+	}
+	numFiles, size, err := vfs.ScanQuota(fs, vfs.UserQuotaScanKey(u.Username), opts)
 	if err != nil {
 		return numFiles, size, err
 	}
@@ -667,7 +923,7 @@ func (u *User) ScanQuota() (int, int64, error) {
 		if !v.IsIncludedInUserQuota() {
 			continue
 		}
-		num, s, err := v.ScanQuota()
+		num, s, err := v.ScanQuota(incremental)
 		if err != nil {
 			return numFiles, size, err
 		}
@@ -748,7 +1004,8 @@ func (u *User) GetVirtualFoldersInfo(virtualPath string) []os.FileInfo {
 // FilterListDir removes hidden items from the given files list
 func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os.FileInfo {
 	filter := u.getPatternsFilterForPath(virtualPath)
-	if !u.hasVirtualDirs() && filter.DenyPolicy != sdk.DenyPolicyHide {
+	hideInternalDirs := virtualPath == "/" && (u.Filters.Trash.IsEnabled() || u.IsVersioningEnabled())
+	if !u.hasVirtualDirs() && filter.DenyPolicy != sdk.DenyPolicyHide && !hideInternalDirs {
 		return dirContents
 	}
 	vdirs := make(map[string]bool)
@@ -770,6 +1027,9 @@ func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os
 			if _, ok := vdirs[fi.Name()]; ok {
 				continue
 			}
+			if hideInternalDirs && u.isInternalDir(fi.Name()) {
+				continue
+			}
 			if filter.DenyPolicy == sdk.DenyPolicyHide {
 				if !filter.CheckAllowed(fi.Name()) {
 					continue
@@ -966,7 +1226,7 @@ func (u *User) GetNextAuthMethods() []string {
 func (u *User) IsPartialAuth() bool {
 	for _, method := range u.GetAllowedLoginMethods() {
 		if method == LoginMethodTLSCertificate || method == LoginMethodTLSCertificateAndPwd ||
-			method == SSHLoginMethodPassword {
+			method == SSHLoginMethodPassword || method == SSHLoginMethodGSSAPI {
 			continue
 		}
 		if method == LoginMethodPassword && util.Contains(u.Filters.DeniedLoginMethods, SSHLoginMethodPassword) {
@@ -1046,6 +1306,9 @@ func (u *User) getMinPasswordEntropy() float64 {
 // IsFileAllowed returns true if the specified file is allowed by the file restrictions filters.
 // The second parameter returned is the deny policy
 func (u *User) IsFileAllowed(virtualPath string) (bool, int) {
+	if u.IsTrashPath(virtualPath) || u.IsVersionsPath(virtualPath) {
+		return false, sdk.DenyPolicyHide
+	}
 	dirPath := path.Dir(virtualPath)
 	if u.isDirHidden(dirPath) {
 		return false, sdk.DenyPolicyHide
@@ -1225,6 +1488,33 @@ func (u *User) MustSetSecondFactor() bool {
 	return false
 }
 
+// IsSecondFactorExempted returns true if the second factor is not required
+// for the specified protocol when connecting from the specified IP address
+func (u *User) IsSecondFactorExempted(protocol, clientIP string) bool {
+	if len(u.Filters.TwoFactorAuthExemptions) == 0 {
+		return false
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for idx := range u.Filters.TwoFactorAuthExemptions {
+		if u.Filters.TwoFactorAuthExemptions[idx].isExempted(protocol, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSecondFactorRequired returns true if the second factor authentication is enabled
+// for the specified protocol and the client IP is not exempted
+func (u *User) IsSecondFactorRequired(protocol, clientIP string) bool {
+	if !u.Filters.TOTPConfig.Enabled || !util.Contains(u.Filters.TOTPConfig.Protocols, protocol) {
+		return false
+	}
+	return !u.IsSecondFactorExempted(protocol, clientIP)
+}
+
 // MustSetSecondFactorForProtocol returns true if the user must set a second factor authentication
 // for the specified protocol
 func (u *User) MustSetSecondFactorForProtocol(protocol string) bool {
@@ -1267,6 +1557,18 @@ func (u *User) GetBandwidthForIP(clientIP, connectionID string) (int64, int64) {
 	return u.UploadBandwidth, u.DownloadBandwidth
 }
 
+// GetBandwidthForProtocol returns the upload and download bandwidth for the
+// specified protocol. The last value is false if there is no bandwidth limit
+// for the protocol and so the other limits apply
+func (u *User) GetBandwidthForProtocol(protocol string) (int64, int64, bool) {
+	for _, limit := range u.Filters.ProtocolBandwidthLimits {
+		if util.Contains(limit.Protocols, protocol) {
+			return limit.UploadBandwidth, limit.DownloadBandwidth, true
+		}
+	}
+	return 0, 0, false
+}
+
 // IsLoginFromAddrAllowed returns true if the login is allowed from the specified remoteAddr.
 // If AllowedIP is defined only the specified IP/Mask can login.
 // If DeniedIP is defined the specified IP/Mask cannot login.
@@ -1558,6 +1860,26 @@ func (u *User) replaceFsConfigPlaceholders(fsConfig vfs.Filesystem, replacer *st
 		fsConfig.SFTPConfig.Prefix = u.replacePlaceholder(fsConfig.SFTPConfig.Prefix, replacer)
 	case sdk.HTTPFilesystemProvider:
 		fsConfig.HTTPConfig.Username = u.replacePlaceholder(fsConfig.HTTPConfig.Username, replacer)
+	case vfs.B2FilesystemProvider:
+		fsConfig.B2Config.KeyPrefix = u.replacePlaceholder(fsConfig.B2Config.KeyPrefix, replacer)
+	case vfs.DropboxFilesystemProvider:
+		fsConfig.DropboxConfig.RootPath = u.replacePlaceholder(fsConfig.DropboxConfig.RootPath, replacer)
+	case vfs.GDriveFilesystemProvider:
+		fsConfig.GDriveConfig.Subject = u.replacePlaceholder(fsConfig.GDriveConfig.Subject, replacer)
+	case vfs.OneDriveFilesystemProvider:
+		fsConfig.OneDriveConfig.RootPath = u.replacePlaceholder(fsConfig.OneDriveConfig.RootPath, replacer)
+	case vfs.WebDAVFilesystemProvider:
+		fsConfig.WebDAVConfig.Endpoint = u.replacePlaceholder(fsConfig.WebDAVConfig.Endpoint, replacer)
+		fsConfig.WebDAVConfig.Username = u.replacePlaceholder(fsConfig.WebDAVConfig.Username, replacer)
+	case vfs.SMBFilesystemProvider:
+		fsConfig.SMBConfig.Username = u.replacePlaceholder(fsConfig.SMBConfig.Username, replacer)
+		fsConfig.SMBConfig.Prefix = u.replacePlaceholder(fsConfig.SMBConfig.Prefix, replacer)
+	case vfs.HDFSFilesystemProvider:
+		fsConfig.HDFSConfig.Username = u.replacePlaceholder(fsConfig.HDFSConfig.Username, replacer)
+		fsConfig.HDFSConfig.DoAs = u.replacePlaceholder(fsConfig.HDFSConfig.DoAs, replacer)
+		fsConfig.HDFSConfig.Prefix = u.replacePlaceholder(fsConfig.HDFSConfig.Prefix, replacer)
+	case vfs.SwiftFilesystemProvider:
+		fsConfig.SwiftConfig.KeyPrefix = u.replacePlaceholder(fsConfig.SwiftConfig.KeyPrefix, replacer)
 	}
 	return fsConfig
 }
@@ -1611,6 +1933,16 @@ func (u *User) mergeWithPrimaryGroup(group *Group, replacer *strings.Replacer) {
 	if u.ExpirationDate == 0 && group.UserSettings.ExpiresIn > 0 {
 		u.ExpirationDate = u.CreatedAt + int64(group.UserSettings.ExpiresIn)*86400000
 	}
+	if !u.Filters.PortForwarding.IsEnabled() {
+		u.Filters.PortForwarding = group.UserSettings.PortForwarding.getACopy()
+	}
+	if !u.Filters.AppendOnly {
+		u.Filters.AppendOnly = group.UserSettings.AppendOnly
+	}
+	u.Filters.SSHAlgorithms.merge(&group.UserSettings.SSHAlgorithms)
+	if u.Filters.FTPPassiveIP == "" {
+		u.Filters.FTPPassiveIP = group.UserSettings.FTPPassiveIP
+	}
 	u.mergePrimaryGroupFilters(&group.UserSettings.Filters, replacer)
 	u.mergeAdditiveProperties(group, sdk.GroupTypePrimary, replacer)
 }
@@ -1675,6 +2007,10 @@ func (u *User) mergeAdditiveProperties(group *Group, groupType int, replacer *st
 	u.Filters.WebClient = append(u.Filters.WebClient, group.UserSettings.Filters.WebClient...)
 	u.Filters.TwoFactorAuthProtocols = append(u.Filters.TwoFactorAuthProtocols, group.UserSettings.Filters.TwoFactorAuthProtocols...)
 	u.Filters.AccessTime = append(u.Filters.AccessTime, group.UserSettings.Filters.AccessTime...)
+	u.Filters.TwoFactorAuthExemptions = append(u.Filters.TwoFactorAuthExemptions,
+		copyTwoFactorAuthExemptions(group.UserSettings.TwoFactorAuthExemptions)...)
+	u.Filters.ProtocolBandwidthLimits = append(u.Filters.ProtocolBandwidthLimits,
+		copyProtocolBandwidthLimits(group.UserSettings.ProtocolBandwidthLimits)...)
 }
 
 func (u *User) mergeVirtualFolders(group *Group, groupType int, replacer *strings.Replacer) {
@@ -1783,6 +2119,21 @@ func (u *User) getACopy() User {
 	filters.TOTPConfig.Secret = u.Filters.TOTPConfig.Secret.Clone()
 	filters.TOTPConfig.Protocols = make([]string, len(u.Filters.TOTPConfig.Protocols))
 	copy(filters.TOTPConfig.Protocols, u.Filters.TOTPConfig.Protocols)
+	filters.TwoFactorAuthExemptions = copyTwoFactorAuthExemptions(u.Filters.TwoFactorAuthExemptions)
+	filters.PortForwarding = u.Filters.PortForwarding.getACopy()
+	filters.ProtocolBandwidthLimits = copyProtocolBandwidthLimits(u.Filters.ProtocolBandwidthLimits)
+	filters.SSHAlgorithms = u.Filters.SSHAlgorithms.getACopy()
+	filters.FTPPassiveIP = u.Filters.FTPPassiveIP
+	filters.Trash = u.Filters.Trash
+	filters.FileVersioning = make([]VersioningRule, len(u.Filters.FileVersioning))
+	copy(filters.FileVersioning, u.Filters.FileVersioning)
+	filters.DirQuotas = make([]DirQuota, len(u.Filters.DirQuotas))
+	copy(filters.DirQuotas, u.Filters.DirQuotas)
+	filters.QuotaWarnThresholds = make([]int, len(u.Filters.QuotaWarnThresholds))
+	copy(filters.QuotaWarnThresholds, u.Filters.QuotaWarnThresholds)
+	if u.Filters.S3Secret != nil {
+		filters.S3Secret = u.Filters.S3Secret.Clone()
+	}
 	filters.RecoveryCodes = make([]RecoveryCode, 0, len(u.Filters.RecoveryCodes))
 	for _, code := range u.Filters.RecoveryCodes {
 		if code.Secret == nil {
@@ -1835,6 +2186,7 @@ func (u *User) getACopy() User {
 		VirtualFolders:       virtualFolders,
 		Groups:               groups,
 		FsConfig:             u.FsConfig.GetACopy(),
+		Tenant:               u.Tenant,
 		groupSettingsApplied: u.groupSettingsApplied,
 	}
 }
@@ -0,0 +1,146 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// VersionsDirName is the name of the hidden directory, inside the user's home,
+// where the previous versions of overwritten and deleted files are stored
+const VersionsDirName = ".versions"
+
+const (
+	maxFileVersions           = 1000
+	maxVersionsRetentionDays  = 3650
+	maxVersioningRulesPerUser = 100
+)
+
+// VersioningRule defines the versioning configuration for the files
+// inside a directory and its sub directories
+type VersioningRule struct {
+	// Virtual path, the rule applies to the files inside this path
+	Path string `json:"path"`
+	// Maximum number of versions to keep for each file, 0 means unlimited
+	MaxVersions int `json:"max_versions,omitempty"`
+	// Versions older than the specified number of days are automatically
+	// removed, 0 means no age based removal
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+func (r *VersioningRule) validate() error {
+	if r.Path == "" {
+		return util.NewValidationError("versioning rule: path is mandatory")
+	}
+	r.Path = util.CleanPath(r.Path)
+	if r.MaxVersions < 0 || r.MaxVersions > maxFileVersions {
+		return util.NewValidationError(fmt.Sprintf("versioning rule %q: invalid max versions %d, allowed range 0-%d",
+			r.Path, r.MaxVersions, maxFileVersions))
+	}
+	if r.RetentionDays < 0 || r.RetentionDays > maxVersionsRetentionDays {
+		return util.NewValidationError(fmt.Sprintf("versioning rule %q: invalid retention %d, allowed range 0-%d days",
+			r.Path, r.RetentionDays, maxVersionsRetentionDays))
+	}
+	if r.MaxVersions == 0 && r.RetentionDays == 0 {
+		return util.NewValidationError(fmt.Sprintf("versioning rule %q: max versions or retention days is required",
+			r.Path))
+	}
+	if r.Path == GetVersionsPath() || strings.HasPrefix(r.Path, GetVersionsPath()+"/") ||
+		r.Path == GetTrashPath() || strings.HasPrefix(r.Path, GetTrashPath()+"/") {
+		return util.NewValidationError(fmt.Sprintf("versioning rule %q: path not allowed", r.Path))
+	}
+	return nil
+}
+
+func validateVersioningRules(rules []VersioningRule) ([]VersioningRule, error) {
+	if len(rules) > maxVersioningRulesPerUser {
+		return nil, util.NewValidationError(fmt.Sprintf("too many versioning rules, max allowed: %d",
+			maxVersioningRulesPerUser))
+	}
+	var result []VersioningRule
+	paths := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if paths[rule.Path] {
+			return nil, util.NewValidationError(fmt.Sprintf("duplicate versioning rule for path %q", rule.Path))
+		}
+		paths[rule.Path] = true
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// GetVersionsPath returns the virtual path for the versions directory
+func GetVersionsPath() string {
+	return "/" + VersionsDirName
+}
+
+// GetFileVersionsPath returns the virtual path of the directory where the
+// versions for the specified file are stored
+func GetFileVersionsPath(virtualPath string) string {
+	return path.Join(GetVersionsPath(), virtualPath)
+}
+
+// IsVersioningEnabled returns true if at least a versioning rule is defined
+func (u *User) IsVersioningEnabled() bool {
+	return len(u.Filters.FileVersioning) > 0
+}
+
+// IsVersionsPath returns true if the versioning is enabled and the specified
+// virtual path is the versions directory or is inside it
+func (u *User) IsVersionsPath(virtualPath string) bool {
+	if !u.IsVersioningEnabled() {
+		return false
+	}
+	versionsPath := GetVersionsPath()
+	return virtualPath == versionsPath || strings.HasPrefix(virtualPath, versionsPath+"/")
+}
+
+// GetVersioningRule returns the versioning rule for the specified file.
+// The rule with the longest path matching the file directory is returned
+func (u *User) GetVersioningRule(virtualPath string) (VersioningRule, bool) {
+	var result VersioningRule
+	found := false
+	dirPath := path.Dir(virtualPath)
+	for _, rule := range u.Filters.FileVersioning {
+		if rule.Path != "/" && dirPath != rule.Path && !strings.HasPrefix(dirPath, rule.Path+"/") {
+			continue
+		}
+		if !found || len(rule.Path) > len(result.Path) {
+			result = rule
+			found = true
+		}
+	}
+	return result, found
+}
+
+// isInternalDir returns true if the specified name, inside the root
+// directory, is used to store trash or versions data
+func (u *User) isInternalDir(name string) bool {
+	switch name {
+	case TrashDirName:
+		return u.Filters.Trash.IsEnabled()
+	case VersionsDirName:
+		return u.IsVersioningEnabled()
+	default:
+		return false
+	}
+}
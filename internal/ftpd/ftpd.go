@@ -17,12 +17,15 @@ package ftpd
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
@@ -38,8 +41,9 @@ const (
 )
 
 var (
-	certMgr       *common.CertManager
-	serviceStatus ServiceStatus
+	certMgr         *common.CertManager
+	serviceStatus   ServiceStatus
+	runningBindings atomic.Pointer[common.BindingsRegistry[Binding]]
 )
 
 // PassiveIPOverride defines an exception for the configured passive IP
@@ -55,6 +59,44 @@ func (p *PassiveIPOverride) GetNetworksAsString() string {
 	return strings.Join(p.Networks, ", ")
 }
 
+// Supported TLS certificate fields for username mappings
+const (
+	TLSCertFieldCommonName = "CN"
+	TLSCertFieldEmail      = "email"
+	TLSCertFieldDNS        = "DNS"
+	TLSCertFieldURI        = "URI"
+)
+
+// TLSUsernameMapping defines a rule to map a TLS client certificate to an SFTPGo username
+type TLSUsernameMapping struct {
+	// Certificate field to match: "CN" for the subject common name or "email", "DNS", "URI"
+	// for the subject alternative names. Each SAN value is checked
+	Field string `json:"field" mapstructure:"field"`
+	// Regular expression to match against the field value, for example "^(.+)@example\.com$"
+	Regexp string `json:"regexp" mapstructure:"regexp"`
+	// Template for the username, "$1" is replaced with the first capturing group and so on
+	Replacement string `json:"replacement" mapstructure:"replacement"`
+	re          *regexp.Regexp
+}
+
+func (m *TLSUsernameMapping) getValues(cert *x509.Certificate) []string {
+	switch m.Field {
+	case TLSCertFieldCommonName:
+		return []string{cert.Subject.CommonName}
+	case TLSCertFieldEmail:
+		return cert.EmailAddresses
+	case TLSCertFieldDNS:
+		return cert.DNSNames
+	case TLSCertFieldURI:
+		values := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+		return values
+	}
+	return nil
+}
+
 // Binding defines the configuration for a network listener
 type Binding struct {
 	// The address to listen on. A blank value means listen on all available network interfaces.
@@ -88,6 +130,13 @@ type Binding struct {
 	// the client is allowed not to send a certificate.
 	// You need to define at least a certificate authority for this to work
 	ClientAuthType int `json:"client_auth_type" mapstructure:"client_auth_type"`
+	// Rules to map verified TLS client certificates to SFTPGo usernames. The first
+	// matching rule is applied and the mapped username must match the one sent by
+	// the client. A matching certificate identifies the user as the per-user TLS
+	// settings do: the password is not required if the user is allowed to login
+	// using the "TLSCertificate" method, otherwise both are checked.
+	// Certificates not matching any rule are checked against the user settings
+	TLSUsernameMappings []TLSUsernameMapping `json:"tls_username_mappings" mapstructure:"tls_username_mappings"`
 	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
 	// If CipherSuites is nil/empty, a default list of secure cipher suites
 	// is used, with a preference order based on hardware performance.
@@ -115,7 +164,10 @@ type Binding struct {
 	// useful in circumstances involving older/mainframe clients and EBCDIC files.
 	IgnoreASCIITransferType int `json:"ignore_ascii_transfer_type" mapstructure:"ignore_ascii_transfer_type"`
 	// Debug enables the FTP debug mode. In debug mode, every FTP command will be logged
-	Debug   bool `json:"debug" mapstructure:"debug"`
+	Debug bool `json:"debug" mapstructure:"debug"`
+	// Tenant restricts this binding to the users of the specified tenant.
+	// An empty value means that any user is allowed
+	Tenant  string `json:"tenant" mapstructure:"tenant"`
 	ciphers []uint16
 }
 
@@ -155,6 +207,42 @@ func (b *Binding) checkSecuritySettings() error {
 	return nil
 }
 
+func (b *Binding) checkTLSUsernameMappings() error {
+	for idx := range b.TLSUsernameMappings {
+		mapping := &b.TLSUsernameMappings[idx]
+		if !util.Contains([]string{TLSCertFieldCommonName, TLSCertFieldEmail, TLSCertFieldDNS, TLSCertFieldURI},
+			mapping.Field) {
+			return fmt.Errorf("invalid TLS username mapping field: %q", mapping.Field)
+		}
+		re, err := regexp.Compile(mapping.Regexp)
+		if err != nil {
+			return fmt.Errorf("invalid TLS username mapping %q: %w", mapping.Regexp, err)
+		}
+		mapping.re = re
+	}
+	return nil
+}
+
+// getTLSUsername returns the username mapped to the specified certificate
+// or an empty string if no rule matches
+func (b *Binding) getTLSUsername(cert *x509.Certificate) string {
+	for _, mapping := range b.TLSUsernameMappings {
+		if mapping.re == nil {
+			continue
+		}
+		for _, value := range mapping.getValues(cert) {
+			match := mapping.re.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			if username := string(mapping.re.ExpandString(nil, mapping.Replacement, value, match)); username != "" {
+				return username
+			}
+		}
+	}
+	return ""
+}
+
 func (b *Binding) checkPassiveIP() error {
 	if b.ForcePassiveIP != "" {
 		ip, err := parsePassiveIP(b.ForcePassiveIP)
@@ -256,6 +344,40 @@ type PortRange struct {
 	End int `json:"end" mapstructure:"end"`
 }
 
+// TarpitConfig defines the delays to apply to the responses for failed
+// logins. The delay grows with the defender score of the client IP, so
+// suspicious hosts are progressively slowed down before they are banned.
+// Hosts behind a shared NAT are not immediately blocked this way
+type TarpitConfig struct {
+	// Delay in milliseconds for each point of the defender score.
+	// 0 means disabled. The defender must be enabled too
+	Delay int `json:"delay" mapstructure:"delay"`
+	// Maximum delay in milliseconds, 0 means no limit
+	MaxDelay int `json:"max_delay" mapstructure:"max_delay"`
+}
+
+func (t *TarpitConfig) getDelay(ipAddr string) time.Duration {
+	if t.Delay <= 0 {
+		return 0
+	}
+	score, err := common.GetDefenderScore(ipAddr)
+	if err != nil || score <= 0 {
+		return 0
+	}
+	delay := int64(score) * int64(t.Delay)
+	if t.MaxDelay > 0 && delay > int64(t.MaxDelay) {
+		delay = int64(t.MaxDelay)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+func (t *TarpitConfig) apply(ipAddr string) {
+	if delay := t.getDelay(ipAddr); delay > 0 {
+		logger.Debug(logSender, "", "tarpit, delaying the failed login response for ip %q, delay: %s", ipAddr, delay)
+		time.Sleep(delay)
+	}
+}
+
 // ServiceStatus defines the service status
 type ServiceStatus struct {
 	IsActive         bool      `json:"is_active"`
@@ -291,7 +413,9 @@ type Configuration struct {
 	// These FTP commands will be enabled: HASH, XCRC, MD5/XMD5, XSHA/XSHA1, XSHA256, XSHA512.
 	// Please keep in mind that to calculate the hash we need to read the whole file, for
 	// remote backends this means downloading the file, for the encrypted backend this means
-	// decrypting the file
+	// decrypting the file. The file is read as a download, so the download permission,
+	// bandwidth limits and transfer quotas apply. Clients can select the algorithm used
+	// by the HASH command using "OPTS HASH", SHA-256 is the default
 	HASHSupport int `json:"hash_support" mapstructure:"hash_support"`
 	// Set to 1 to enable support for the non standard "COMB" FTP command.
 	// Combine is only supported for local filesystem, for cloud backends it has
@@ -300,7 +424,10 @@ type Configuration struct {
 	CombineSupport int `json:"combine_support" mapstructure:"combine_support"`
 	// Port Range for data connections. Random if not specified
 	PassivePortRange PortRange `json:"passive_port_range" mapstructure:"passive_port_range"`
-	acmeDomain       string
+	// Progressively delays the responses for failed USER/PASS logins from
+	// hosts with a defender score
+	Tarpit     TarpitConfig `json:"tarpit" mapstructure:"tarpit"`
+	acmeDomain string
 }
 
 // ShouldBind returns true if there is at least a valid binding
@@ -403,22 +530,26 @@ func (c *Configuration) Initialize(configDir string) error {
 	}
 
 	exitChannel := make(chan error, 1)
+	var serverID atomic.Int32
+	registry := common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		return serve(NewServer(c, configDir, b, int(serverID.Add(1)-1)), l)
+	})
+	runningBindings.Store(registry)
 
-	for idx, binding := range c.Bindings {
+	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
 		}
 
-		server := NewServer(c, configDir, binding, idx)
-
-		go func(s *Server) {
-			ftpLogger := logger.LeveledLogger{Sender: "ftpserverlib"}
-			ftpServer := ftpserver.NewFtpServer(s)
-			ftpServer.Logger = ftpLogger.With("server_id", fmt.Sprintf("FTP_%v", s.ID))
-			logger.Info(logSender, "", "starting FTP serving, binding: %v", s.binding.GetAddress())
-			util.CheckTCP4Port(s.binding.Port)
-			exitChannel <- ftpServer.ListenAndServe()
-		}(server)
+		go func(b Binding) {
+			err := registry.Serve(b)
+			if errors.Is(err, common.ErrBindingRemoved) {
+				return
+			}
+			exitChannel <- err
+		}(binding)
 
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
 	}
@@ -428,6 +559,56 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
+func serve(s *Server, l *common.BindingListener) error {
+	ftpLogger := logger.LeveledLogger{Sender: "ftpserverlib"}
+	ftpServer := ftpserver.NewFtpServer(s)
+	ftpServer.Logger = ftpLogger.With("server_id", fmt.Sprintf("FTP_%v", s.ID))
+	logger.Info(logSender, "", "starting FTP serving, binding: %v", s.binding.GetAddress())
+	util.CheckTCP4Port(s.binding.Port)
+	if err := ftpServer.Listen(); err != nil {
+		return err
+	}
+	if err := l.SetStopFunc(ftpServer.Stop); err != nil {
+		return err
+	}
+	return ftpServer.Serve()
+}
+
+// ReloadBindings applies the configured bindings to the running FTP server.
+// New bindings are started and removed bindings stop accepting new connections,
+// the active connections are not affected. Changing the settings of an existing
+// binding requires a restart
+func (c *Configuration) ReloadBindings() error {
+	registry := runningBindings.Load()
+	if registry == nil {
+		return errors.New("the FTP server is not running")
+	}
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			bindings = append(bindings, binding)
+		}
+	}
+	serviceStatus.Bindings = registry.Reload(bindings, checkBindingCertificate)
+	return nil
+}
+
+// checkBindingCertificate returns an error if the binding requires TLS and
+// no certificate was loaded for it
+func checkBindingCertificate(b Binding) error {
+	if b.TLSMode == 0 && b.TLSSessionReuse == 0 {
+		return nil
+	}
+	certID := common.DefaultTLSKeyPaidID
+	if getConfigPath(b.CertificateFile, "") != "" && getConfigPath(b.CertificateKeyFile, "") != "" {
+		certID = b.GetAddress()
+	}
+	if certMgr == nil || !certMgr.HasCertificate(certID) {
+		return fmt.Errorf("no TLS certificate loaded for binding %q", b.GetAddress())
+	}
+	return nil
+}
+
 // ReloadCertificateMgr reloads the certificate manager
 func ReloadCertificateMgr() error {
 	if certMgr != nil {
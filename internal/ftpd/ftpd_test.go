@@ -17,11 +17,13 @@ package ftpd_test
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"net"
@@ -243,6 +245,7 @@ ubwF00Drdvk2+kDZfxIM137nBiy7wgCJi2Ksm5ihN3dUF6Q0oNPl
 	testFileName          = "test_file_ftp.dat"
 	testDLFileName        = "test_download_ftp.dat"
 	tlsClient1Username    = "client1"
+	tlsMappedUsername     = "mapped_client1"
 	tlsClient2Username    = "client2"
 	httpFsPort            = 23456
 	defaultHTTPFsUsername = "httpfs_ftp_user"
@@ -443,6 +446,13 @@ func TestMain(m *testing.M) { //nolint:gocyclo
 			TLSMode:            1,
 			TLSSessionReuse:    1,
 			ClientAuthType:     2,
+			TLSUsernameMappings: []ftpd.TLSUsernameMapping{
+				{
+					Field:       ftpd.TLSCertFieldCommonName,
+					Regexp:      `^client1$`,
+					Replacement: tlsMappedUsername,
+				},
+			},
 		},
 	}
 	ftpdConf.CACertificates = []string{caCrtPath}
@@ -951,7 +961,7 @@ func TestAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	user.Password = emptyPwdPlaceholder
 	client, err := getFTPClient(user, true, nil)
@@ -1323,6 +1333,82 @@ func TestLoginExternalAuth(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLDAPAuthConfig(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.LDAPAuth.URL = "http://127.0.0.1:389"
+	providerConf.LDAPAuth.BaseDN = "dc=example,dc=com"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "unsupported scheme")
+	providerConf.LDAPAuth.URL = "ldaps://127.0.0.1:636"
+	providerConf.LDAPAuth.StartTLS = true
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "StartTLS")
+	providerConf.LDAPAuth.URL = "ldap://127.0.0.1:1"
+	providerConf.LDAPAuth.SearchFilter = "(uid=*)"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "placeholder")
+	providerConf.LDAPAuth.SearchFilter = "(uid=%username%)"
+	providerConf.LDAPAuth.GroupMappings = []dataprovider.LDAPGroupMapping{
+		{
+			LDAPGroup: "cn=sftp,dc=example,dc=com",
+			Group:     "sftp",
+			Type:      10,
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "invalid LDAP group mapping type")
+	providerConf.LDAPAuth.GroupMappings = nil
+	providerConf.LDAPAuth.CACertificates = []string{"missing_ca.crt"}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "unable to load LDAP CA certificate")
+	providerConf.LDAPAuth.CACertificates = nil
+	providerConf.LDAPAuth.ReadThrough = true
+	providerConf.LDAPAuth.CacheTTL = -1
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "invalid LDAP cache TTL")
+	providerConf.LDAPAuth.CacheTTL = 60
+	providerConf.ExternalAuthHook = "http://127.0.0.1:8080/auth"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "mutually exclusive")
+	providerConf.ExternalAuthHook = ""
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	// the LDAP server is not reachable
+	_, err = getFTPClient(user, true, nil)
+	assert.Error(t, err)
+	// local authentication is used if the external authentication is disabled for the user
+	user.Filters.Hooks.ExternalAuthDisabled = true
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err := client.Quit()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
 func TestPreLoginHook(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -1457,7 +1543,7 @@ func TestPreLoginHookReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 	// now the same with an existing user
 	client, err = getFTPClient(u, false, nil)
 	if assert.NoError(t, err) {
@@ -2994,6 +3080,24 @@ func TestAllocateAvailable(t *testing.T) {
 		assert.Equal(t, ftp.StatusFile, code)
 		assert.Equal(t, "1", response)
 	}
+	// the quota exceeds the free space on the storage
+	user.QuotaSize = 1 << 60
+	user.Filters.MaxUploadFileSize = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getFTPClient(user, false, nil)
+	if assert.NoError(t, err) {
+		code, response, err := client.SendCommand("AVBL")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFile, code)
+		avblSize, err := strconv.ParseInt(response, 10, 64)
+		assert.NoError(t, err)
+		assert.Greater(t, avblSize, int64(0))
+		assert.Less(t, avblSize, user.QuotaSize)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -3404,6 +3508,69 @@ func TestHASH(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHASHAlgorithmsAndPermissions(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65536)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(testFilePath)
+	assert.NoError(t, err)
+	sha512Sum := sha512.Sum512(content)
+	crc32Sum := crc32.ChecksumIEEE(content)
+
+	client, err := getFTPClientImplicitTLS(user)
+	if assert.NoError(t, err) {
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.NoError(t, err)
+
+		code, response, err := client.SendCommand("OPTS HASH SHA-512")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Contains(t, response, "SHA-512")
+		code, response, err = client.SendCommand(fmt.Sprintf("HASH %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFile, code)
+		assert.Contains(t, response, hex.EncodeToString(sha512Sum[:]))
+
+		code, _, err = client.SendCommand("OPTS HASH CRC32")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		code, response, err = client.SendCommand(fmt.Sprintf("HASH %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFile, code)
+		assert.Contains(t, response, fmt.Sprintf("%08x", crc32Sum))
+
+		code, _, err = client.SendCommand("OPTS HASH unknown")
+		assert.NoError(t, err)
+		assert.NotEqual(t, ftp.StatusCommandOK, code)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// the hash is computed reading the file so the download permission is required
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getFTPClientImplicitTLS(user)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCommand(fmt.Sprintf("HASH %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestCombine(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -3542,6 +3709,63 @@ func TestClientCertificateAuthRevokedCert(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestClientCertificateAuthMappedUsername(t *testing.T) {
+	u := getTestUser()
+	u.Username = tlsMappedUsername
+	u.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	tlsConfig := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	tlsCert, err := tls.X509KeyPair([]byte(client1Crt), []byte(client1Key))
+	assert.NoError(t, err)
+	tlsConfig.Certificates = append(tlsConfig.Certificates, tlsCert)
+	// the certificate is mapped to the user, no TLS settings are required
+	userNoPwd := user
+	userNoPwd.Password = emptyPwdPlaceholder
+	client, err := getFTPClientWithSessionReuse(userNoPwd, tlsConfig)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// the certificate is not mapped to this user
+	u = getTestUser()
+	u.Username = tlsClient1Username
+	u.Filters.TLSUsername = sdk.TLSUsernameCN
+	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	_, err = getFTPClientWithSessionReuse(user1, tlsConfig)
+	assert.Error(t, err)
+	// without the certificate login method the password is required too
+	user.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword, dataprovider.LoginMethodTLSCertificate}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getFTPClientWithSessionReuse(userNoPwd, tlsConfig)
+	assert.Error(t, err)
+	client, err = getFTPClientWithSessionReuse(user, tlsConfig)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestClientCertificateAuth(t *testing.T) {
 	u := getTestUser()
 	u.Username = tlsClient1Username
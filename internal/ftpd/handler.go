@@ -229,20 +229,17 @@ func (c *Connection) GetAvailableSpace(dirName string) (int64, error) {
 
 	if diskQuota.AllowedSize == 0 && transferQuota.AllowedULSize == 0 && transferQuota.AllowedTotalSize == 0 {
 		// no quota restrictions
-		if c.User.Filters.MaxUploadFileSize > 0 {
-			return c.User.Filters.MaxUploadFileSize, nil
-		}
-
-		fs, p, err := c.GetFsAndResolvedPath(dirName)
+		freeSpace, err := c.getFreeDiskSpace(dirName)
 		if err != nil {
+			if c.User.Filters.MaxUploadFileSize > 0 {
+				return c.User.Filters.MaxUploadFileSize, nil
+			}
 			return 0, err
 		}
-
-		statVFS, err := fs.GetAvailableDiskSize(p)
-		if err != nil {
-			return 0, c.GetFsError(fs, err)
+		if c.User.Filters.MaxUploadFileSize > 0 && c.User.Filters.MaxUploadFileSize < freeSpace {
+			return c.User.Filters.MaxUploadFileSize, nil
 		}
-		return int64(statVFS.FreeSpace()), nil
+		return freeSpace, nil
 	}
 
 	allowedDiskSize := diskQuota.AllowedSize
@@ -259,16 +256,32 @@ func (c *Connection) GetAvailableSpace(dirName string) (int64, error) {
 		}
 	}
 	// the available space is the minimum between MaxUploadFileSize, if setted,
-	// and quota allowed size
+	// quota allowed size and the free space on the storage, if available
 	if c.User.Filters.MaxUploadFileSize > 0 {
 		if c.User.Filters.MaxUploadFileSize < allowedSize {
-			return c.User.Filters.MaxUploadFileSize, nil
+			allowedSize = c.User.Filters.MaxUploadFileSize
 		}
 	}
+	if freeSpace, err := c.getFreeDiskSpace(dirName); err == nil && freeSpace < allowedSize {
+		return freeSpace, nil
+	}
 
 	return allowedSize, nil
 }
 
+func (c *Connection) getFreeDiskSpace(dirName string) (int64, error) {
+	fs, p, err := c.GetFsAndResolvedPath(dirName)
+	if err != nil {
+		return 0, err
+	}
+
+	statVFS, err := fs.GetAvailableDiskSize(p)
+	if err != nil {
+		return 0, c.GetFsError(fs, err)
+	}
+	return int64(statVFS.FreeSpace()), nil
+}
+
 // AllocateSpace implements ClientDriverExtensionAllocate interface
 func (c *Connection) AllocateSpace(_ int) error {
 	c.UpdateLastActivity()
@@ -357,7 +370,9 @@ func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int6
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	startTime := time.Now()
 	file, r, cancelFn, err := fs.Open(fsPath, offset)
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "could not open file %q for reading: %+v", fsPath, err)
 		return nil, c.GetFsError(fs, err)
@@ -418,7 +433,9 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
 		return nil, ftpserver.ErrFileNameNotAllowed
 	}
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, flags, c.GetCreateChecks(requestPath, true, false))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "error creating file %q, flags %v: %+v", resolvedPath, flags, err)
 		return nil, c.GetFsError(fs, err)
@@ -463,6 +480,11 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
 		return nil, ftpserver.ErrFileNameNotAllowed
 	}
+	if !isResume {
+		if err := c.CreateFileVersion(fs, resolvedPath, requestPath, fileSize); err != nil {
+			return nil, err
+		}
+	}
 
 	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		_, _, err = fs.Rename(resolvedPath, filePath)
@@ -473,7 +495,9 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		}
 	}
 
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, flags, c.GetCreateChecks(requestPath, false, isResume))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, flags: %v, source: %q, err: %+v", flags, filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -491,6 +515,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		}
 	} else {
 		if vfs.HasTruncateSupport(fs) {
+			c.UpdateDirQuotaUsage(requestPath, 0, -fileSize)
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
 				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -19,8 +19,10 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -1024,6 +1026,68 @@ func TestCiphers(t *testing.T) {
 	require.Equal(t, []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384}, b.ciphers)
 }
 
+func TestTLSUsernameMappings(t *testing.T) {
+	b := Binding{
+		TLSUsernameMappings: []TLSUsernameMapping{
+			{
+				Field:  "invalid",
+				Regexp: "^(.+)$",
+			},
+		},
+	}
+	err := b.checkTLSUsernameMappings()
+	assert.ErrorContains(t, err, "invalid TLS username mapping field")
+	b.TLSUsernameMappings[0].Field = TLSCertFieldCommonName
+	b.TLSUsernameMappings[0].Regexp = "(["
+	err = b.checkTLSUsernameMappings()
+	assert.ErrorContains(t, err, "invalid TLS username mapping")
+
+	b = Binding{
+		TLSUsernameMappings: []TLSUsernameMapping{
+			{
+				Field:       TLSCertFieldCommonName,
+				Regexp:      `^(.+)\.example\.com$`,
+				Replacement: "$1",
+			},
+			{
+				Field:       TLSCertFieldEmail,
+				Regexp:      `^(.+)@example\.com$`,
+				Replacement: "mail_$1",
+			},
+			{
+				Field:       TLSCertFieldURI,
+				Regexp:      `^spiffe://example\.com/(.+)$`,
+				Replacement: "$1",
+			},
+			{
+				Field:       TLSCertFieldDNS,
+				Regexp:      `^(.+)\.example\.net$`,
+				Replacement: "",
+			},
+		},
+	}
+	cert := &x509.Certificate{}
+	// mappings not validated yet
+	assert.Empty(t, b.getTLSUsername(cert))
+	err = b.checkTLSUsernameMappings()
+	assert.NoError(t, err)
+	assert.Empty(t, b.getTLSUsername(cert))
+	cert.Subject.CommonName = "user1.example.com"
+	assert.Equal(t, "user1", b.getTLSUsername(cert))
+	cert.Subject.CommonName = "user1"
+	cert.EmailAddresses = []string{"user2@example.org", "user2@example.com"}
+	assert.Equal(t, "mail_user2", b.getTLSUsername(cert))
+	cert.EmailAddresses = nil
+	spiffeURI, err := url.Parse("spiffe://example.com/svc")
+	require.NoError(t, err)
+	cert.URIs = []*url.URL{spiffeURI}
+	assert.Equal(t, "svc", b.getTLSUsername(cert))
+	cert.URIs = nil
+	// empty usernames are ignored
+	cert.DNSNames = []string{"host.example.net"}
+	assert.Empty(t, b.getTLSUsername(cert))
+}
+
 func TestPassiveIPResolver(t *testing.T) {
 	b := Binding{
 		PassiveIPOverrides: []PassiveIPOverride{
@@ -1089,6 +1153,16 @@ func TestPassiveIPResolver(t *testing.T) {
 	passiveIP, err = b.passiveIPResolver(mockCC)
 	assert.NoError(t, err)
 	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+	// the passive IP configured for the logged in user takes precedence
+	server := NewServer(&Configuration{}, configDir, b, 0)
+	server.passiveIPs.Store(mockCC.ID(), "10.8.0.1")
+	passiveIP, err = server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.8.0.1", passiveIP)
+	server.ClientDisconnected(mockCC)
+	passiveIP, err = server.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, b.ForcePassiveIP, passiveIP)
 }
 
 func TestRelativePath(t *testing.T) {
@@ -1194,3 +1268,107 @@ func TestPassiveHost(t *testing.T) {
 	assert.NoError(t, err, ip)
 	assert.Equal(t, "127.0.0.1", ip)
 }
+
+func TestTarpitDelay(t *testing.T) {
+	oldConfig := common.Config
+
+	ipAddr := "172.16.10.1"
+	tarpit := TarpitConfig{}
+	assert.Equal(t, time.Duration(0), tarpit.getDelay(ipAddr))
+	tarpit.Delay = 100
+	// the defender is disabled
+	assert.Equal(t, time.Duration(0), tarpit.getDelay(ipAddr))
+
+	cfg := common.Config
+	cfg.DefenderConfig.Enabled = true
+	cfg.DefenderConfig.Threshold = 10
+	cfg.DefenderConfig.ScoreInvalid = 2
+	cfg.DefenderConfig.ScoreValid = 1
+	err := common.Initialize(cfg, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Duration(0), tarpit.getDelay(ipAddr))
+	common.AddDefenderEvent(ipAddr, common.ProtocolFTP, common.HostEventLoginFailed)
+	assert.Equal(t, 100*time.Millisecond, tarpit.getDelay(ipAddr))
+	common.AddDefenderEvent(ipAddr, common.ProtocolFTP, common.HostEventUserNotFound)
+	assert.Equal(t, 300*time.Millisecond, tarpit.getDelay(ipAddr))
+	tarpit.MaxDelay = 250
+	assert.Equal(t, 250*time.Millisecond, tarpit.getDelay(ipAddr))
+	tarpit.Delay = 0
+	assert.Equal(t, time.Duration(0), tarpit.getDelay(ipAddr))
+	assert.True(t, common.DeleteDefenderHost(ipAddr))
+
+	err = common.Initialize(oldConfig, 0)
+	require.NoError(t, err)
+}
+
+func TestReloadBindings(t *testing.T) {
+	registry := runningBindings.Load()
+	bindings := serviceStatus.Bindings
+	defer func() {
+		runningBindings.Store(registry)
+		serviceStatus.Bindings = bindings
+	}()
+
+	runningBindings.Store(nil)
+	c := Configuration{
+		Bindings: []Binding{
+			{
+				Address: "127.0.0.1",
+				Port:    2137,
+			},
+		},
+	}
+	err := c.ReloadBindings()
+	assert.Error(t, err)
+
+	runningBindings.Store(common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		return serve(NewServer(&c, configDir, b, 0), l)
+	}))
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	address := c.Bindings[0].GetAddress()
+	var conn net.Conn
+	assert.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", address)
+		return err == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	require.NotNil(t, conn)
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	assert.NoError(t, err)
+	greeting := make([]byte, 3)
+	_, err = io.ReadFull(conn, greeting)
+	assert.NoError(t, err)
+	assert.Equal(t, "220", string(greeting))
+	// a new binding with a certificate not loaded at startup requires a restart
+	c.Bindings = append(c.Bindings, Binding{
+		Address:            "127.0.0.1",
+		Port:               2138,
+		TLSMode:            1,
+		CertificateFile:    filepath.Join(configDir, "missing.crt"),
+		CertificateKeyFile: filepath.Join(configDir, "missing.key"),
+	})
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	assert.Equal(t, 1, runningBindings.Load().Len())
+
+	c.Bindings = nil
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 0)
+	assert.Equal(t, 0, runningBindings.Load().Len())
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
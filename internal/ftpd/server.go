@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 	"github.com/sftpgo/sdk/plugin/notifier"
@@ -43,6 +44,8 @@ type Server struct {
 	statusBanner string
 	binding      Binding
 	tlsConfig    *tls.Config
+	// passive IPs configured for the logged in users, client ID -> IP
+	passiveIPs sync.Map
 }
 
 // NewServer returns a new FTP server driver
@@ -81,6 +84,9 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	if err := s.binding.checkSecuritySettings(); err != nil {
 		return nil, err
 	}
+	if err := s.binding.checkTLSUsernameMappings(); err != nil {
+		return nil, err
+	}
 	var portRange *ftpserver.PortRange
 	if s.config.PassivePortRange.Start > 0 && s.config.PassivePortRange.End > s.config.PassivePortRange.Start {
 		portRange = &ftpserver.PortRange{
@@ -88,23 +94,6 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 			End:   s.config.PassivePortRange.End,
 		}
 	}
-	var ftpListener net.Listener
-	if s.binding.HasProxy() {
-		listener, err := net.Listen("tcp", s.binding.GetAddress())
-		if err != nil {
-			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
-			return nil, err
-		}
-		ftpListener, err = common.Config.GetProxyListener(listener)
-		if err != nil {
-			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-			return nil, err
-		}
-		if s.binding.TLSMode == 2 && s.tlsConfig != nil {
-			ftpListener = tls.NewListener(ftpListener, s.tlsConfig)
-		}
-	}
-
 	if !s.binding.isTLSModeValid() {
 		return nil, fmt.Errorf("unsupported TLS mode: %d", s.binding.TLSMode)
 	}
@@ -117,10 +106,33 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 		return nil, errors.New("to enable TLS you need to provide a certificate")
 	}
 
+	var ftpListener net.Listener
+	// ftpserverlib creates the listener itself if we don't provide one. We need our own
+	// listener to support the proxy protocol, socket activation and SO_REUSEPORT
+	if s.binding.HasProxy() || util.IsReusePortEnabled() || util.HasActivatedListeners() {
+		listener, err := util.Listen("tcp", s.binding.GetAddress())
+		if err != nil {
+			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+			return nil, err
+		}
+		ftpListener = listener
+		if s.binding.HasProxy() {
+			ftpListener, err = common.Config.GetProxyListener(listener)
+			if err != nil {
+				listener.Close()
+				logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+				return nil, err
+			}
+		}
+		if s.binding.TLSMode == 2 && s.tlsConfig != nil {
+			ftpListener = tls.NewListener(ftpListener, s.tlsConfig)
+		}
+	}
+
 	return &ftpserver.Settings{
 		Listener:                 ftpListener,
 		ListenAddr:               s.binding.GetAddress(),
-		PublicIPResolver:         s.binding.passiveIPResolver,
+		PublicIPResolver:         s.passiveIPResolver,
 		PassiveTransferPortRange: portRange,
 		ActiveTransferPortNon20:  s.config.ActiveTransfersPortNon20,
 		IdleTimeout:              -1,
@@ -175,6 +187,16 @@ func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 	connID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
 	common.Connections.Remove(connID)
 	common.Connections.RemoveClientConnection(util.GetIPFromRemoteAddress(cc.RemoteAddr().String()))
+	s.passiveIPs.Delete(cc.ID())
+}
+
+// passiveIPResolver returns the passive IP configured for the logged in user,
+// if any, or the one configured for the binding
+func (s *Server) passiveIPResolver(cc ftpserver.ClientContext) (string, error) {
+	if val, ok := s.passiveIPs.Load(cc.ID()); ok {
+		return val.(string), nil
+	}
+	return s.binding.passiveIPResolver(cc)
 }
 
 // AuthUser authenticates the user and selects an handling driver
@@ -188,6 +210,9 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		if err != common.ErrInternalFailure {
+			s.config.Tarpit.apply(ipAddr)
+		}
 		return nil, dataprovider.ErrInvalidCredentials
 	}
 
@@ -242,14 +267,27 @@ func (s *Server) VerifyConnection(cc ftpserver.ClientContext, user string, tlsCo
 		state := tlsConn.ConnectionState()
 		if len(state.PeerCertificates) > 0 {
 			ipAddr := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+			mappedUsername := s.binding.getTLSUsername(state.PeerCertificates[0])
+			if mappedUsername != "" && mappedUsername != user {
+				var dbUser dataprovider.User
+				dbUser.Username = user
+				err := fmt.Errorf("TLS certificate is mapped to username %q not to %q", mappedUsername, user)
+				updateLoginMetrics(&dbUser, ipAddr, dataprovider.LoginMethodTLSCertificate, err)
+				return nil, dataprovider.ErrInvalidCredentials
+			}
 			dbUser, err := dataprovider.CheckUserBeforeTLSAuth(user, ipAddr, common.ProtocolFTP, state.PeerCertificates[0])
 			if err != nil {
 				dbUser.Username = user
 				updateLoginMetrics(&dbUser, ipAddr, dataprovider.LoginMethodTLSCertificate, err)
 				return nil, dataprovider.ErrInvalidCredentials
 			}
-			if dbUser.IsTLSVerificationEnabled() {
-				dbUser, err = dataprovider.CheckUserAndTLSCert(user, ipAddr, common.ProtocolFTP, state.PeerCertificates[0])
+			if mappedUsername != "" || dbUser.IsTLSVerificationEnabled() {
+				if mappedUsername != "" {
+					// the certificate identifies the user by the binding mapping rules
+					err = dbUser.CheckLoginConditions()
+				} else {
+					dbUser, err = dataprovider.CheckUserAndTLSCert(user, ipAddr, common.ProtocolFTP, state.PeerCertificates[0])
+				}
 				if err != nil {
 					return nil, err
 				}
@@ -365,12 +403,18 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		logger.Info(logSender, connectionID, "cannot login user %q, protocol FTP is not allowed", user.Username)
 		return nil, fmt.Errorf("protocol FTP is not allowed for user %q", user.Username)
 	}
+	if s.binding.Tenant != "" && user.Tenant != s.binding.Tenant {
+		logger.Info(logSender, connectionID, "cannot login user %q, tenant %q is not allowed on this binding",
+			user.Username, user.Tenant)
+		return nil, fmt.Errorf("login for user %q is not allowed on this binding", user.Username)
+	}
 	if !user.IsLoginMethodAllowed(loginMethod, common.ProtocolFTP) {
 		logger.Info(logSender, connectionID, "cannot login user %q, %v login method is not allowed",
 			user.Username, loginMethod)
 		return nil, fmt.Errorf("login method %v is not allowed for user %q", loginMethod, user.Username)
 	}
-	if user.MustSetSecondFactorForProtocol(common.ProtocolFTP) {
+	if user.MustSetSecondFactorForProtocol(common.ProtocolFTP) &&
+		!user.IsSecondFactorExempted(common.ProtocolFTP, util.GetIPFromRemoteAddress(cc.RemoteAddr().String())) {
 		logger.Info(logSender, connectionID, "cannot login user %q, second factor authentication is not set",
 			user.Username)
 		return nil, fmt.Errorf("second factor authentication is not set for user %q", user.Username)
@@ -406,6 +450,11 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		logger.Warn(logSender, connectionID, "unable to swap connection: %v, close fs error: %v", err, errClose)
 		return nil, err
 	}
+	if user.Filters.FTPPassiveIP != "" {
+		s.passiveIPs.Store(cc.ID(), user.Filters.FTPPassiveIP)
+	} else {
+		s.passiveIPs.Delete(cc.ID())
+	}
 	return connection, nil
 }
 
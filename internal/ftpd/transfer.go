@@ -17,6 +17,7 @@ package ftpd
 import (
 	"errors"
 	"io"
+	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -57,7 +58,9 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, p
 func (t *transfer) Read(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = t.reader.Read(p)
+	t.UpdateIOMetrics(startTime)
 	t.BytesSent.Add(int64(n))
 
 	if err == nil {
@@ -76,7 +79,9 @@ func (t *transfer) Read(p []byte) (n int, err error) {
 func (t *transfer) Write(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = t.writer.Write(p)
+	t.UpdateIOMetrics(startTime)
 	t.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -133,6 +138,9 @@ func (t *transfer) closeIO() error {
 			t.ErrTransfer = err
 		}
 		t.Unlock()
+		if metadater, ok := t.writer.(vfs.Metadater); ok {
+			t.BaseTransfer.AddMetadata(metadater.Metadata())
+		}
 	} else if t.reader != nil {
 		err = t.reader.Close()
 		if metadater, ok := t.reader.(vfs.Metadater); ok {
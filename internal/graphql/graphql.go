@@ -0,0 +1,587 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package graphql implements a minimal GraphQL executor.
+// Queries, mutations, variables, aliases, fragments and the @skip/@include
+// directives are supported. Types are not declared for leaf fields: they are
+// resolved from the JSON representation of the parent object, so any field
+// exposed by the REST API can be selected. Introspection is not supported.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// maximum allowed nesting for selection sets
+	maxDepth = 15
+)
+
+// Request defines a GraphQL request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Error defines a GraphQL error
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Response defines a GraphQL response
+type Response struct {
+	Data   *OrderedMap `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// HasRequestError returns true if the request was not executed
+// because it is invalid, for example it contains syntax errors
+func (r *Response) HasRequestError() bool {
+	return r.Data == nil && len(r.Errors) > 0
+}
+
+// OrderedMap is a JSON object preserving the keys insertion order,
+// GraphQL results must respect the order of the requested fields
+type OrderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap() *OrderedMap {
+	return &OrderedMap{
+		values: make(map[string]any),
+	}
+}
+
+// Set sets the value for the specified key
+func (m *OrderedMap) Set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value for the specified key
+func (m *OrderedMap) Get(key string) any {
+	return m.values[key]
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, key := range m.keys {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ResolveFunc resolves a field. source is the value of the parent object
+// and args the field arguments, with variables already replaced
+type ResolveFunc func(ctx context.Context, source any, args map[string]any) (any, error)
+
+// Field defines an object field
+type Field struct {
+	// Type is the object type returned by the field, a nil type means that
+	// the field value is returned as is or filtered based on its JSON
+	// representation if a selection set is specified.
+	// If the resolved value is a slice each element has this type
+	Type *Object
+	// Args defines the allowed arguments
+	Args []string
+	// Resolve is the resolver for the field. If nil the field is resolved
+	// from the JSON representation of the parent object
+	Resolve ResolveFunc
+}
+
+// Object defines an object type. Fields not explicitly defined are
+// resolved from the JSON representation of the source value
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Schema defines the root types
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+}
+
+// Execute parses and executes the specified request
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	op, err := doc.getOperation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	root := s.Query
+	if op.opType == "mutation" {
+		root = s.Mutation
+	}
+	if root == nil {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.opType)}}}
+	}
+	e := &executor{
+		doc: doc,
+	}
+	if err = e.setVariables(op, req.Variables); err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	data := e.executeSelectionSet(ctx, root, nil, op.selections, nil)
+	return &Response{
+		Data:   data,
+		Errors: e.errors,
+	}
+}
+
+func (d *document) getOperation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("the operation name is required if the document contains multiple operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func (e *executor) setVariables(op *operation, values map[string]any) error {
+	e.vars = make(map[string]any)
+	for _, def := range op.vars {
+		e.vars[def.name] = nil
+		if val, ok := values[def.name]; ok {
+			e.vars[def.name] = val
+		} else if def.hasDefault {
+			val, err := e.resolveValue(def.defaultValue)
+			if err != nil {
+				return err
+			}
+			e.vars[def.name] = val
+		}
+		if e.vars[def.name] == nil && def.nonNull {
+			return fmt.Errorf("variable %q is required", def.name)
+		}
+	}
+	return nil
+}
+
+type executor struct {
+	doc    *document
+	vars   map[string]any
+	errors []*Error
+}
+
+func (e *executor) addError(path []any, err error) {
+	e.errors = append(e.errors, &Error{
+		Message: err.Error(),
+		Path:    append([]any(nil), path...),
+	})
+}
+
+func (e *executor) resolveValue(value any) (any, error) {
+	switch v := value.(type) {
+	case variable:
+		val, ok := e.vars[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable %q is not defined", string(v))
+		}
+		return val, nil
+	case enumValue:
+		return string(v), nil
+	case []any:
+		list := make([]any, 0, len(v))
+		for _, item := range v {
+			val, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
+	case objectValue:
+		obj := make(map[string]any)
+		for _, f := range v {
+			val, err := e.resolveValue(f.value)
+			if err != nil {
+				return nil, err
+			}
+			obj[f.name] = val
+		}
+		return obj, nil
+	default:
+		return value, nil
+	}
+}
+
+func (e *executor) getArguments(args []*argument) (map[string]any, error) {
+	result := make(map[string]any)
+	for _, arg := range args {
+		if _, ok := result[arg.name]; ok {
+			return nil, fmt.Errorf("argument %q is specified more than once", arg.name)
+		}
+		val, err := e.resolveValue(arg.value)
+		if err != nil {
+			return nil, err
+		}
+		result[arg.name] = val
+	}
+	return result, nil
+}
+
+func (e *executor) shouldInclude(s selection) (bool, error) {
+	for _, d := range s.getDirectives() {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		args, err := e.getArguments(d.args)
+		if err != nil {
+			return false, err
+		}
+		val, ok := args["if"].(bool)
+		if !ok {
+			return false, fmt.Errorf("directive @%s requires a boolean \"if\" argument", d.name)
+		}
+		if d.name == "skip" && val {
+			return false, nil
+		}
+		if d.name == "include" && !val {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// collectFields returns the fields to resolve grouped by response key.
+// Fragments are expanded if their type condition matches typeName
+func (e *executor) collectFields(typeName string, selections []selection, keys *[]string,
+	fields map[string][]*field, visited map[string]bool,
+) error {
+	for _, s := range selections {
+		include, err := e.shouldInclude(s)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		switch sel := s.(type) {
+		case *field:
+			key := sel.responseKey()
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			} else if fields[key][0].name != sel.name {
+				return fmt.Errorf("fields %q and %q conflict because they have the same response name", fields[key][0].name,
+					sel.name)
+			}
+			fields[key] = append(fields[key], sel)
+		case *inlineFragment:
+			if sel.typeCond != "" && sel.typeCond != typeName {
+				continue
+			}
+			if err := e.collectFields(typeName, sel.selections, keys, fields, visited); err != nil {
+				return err
+			}
+		case *fragmentSpread:
+			if visited[sel.name] {
+				continue
+			}
+			visited[sel.name] = true
+			f, ok := e.doc.fragments[sel.name]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", sel.name)
+			}
+			if f.typeCond != typeName {
+				continue
+			}
+			if err := e.collectFields(typeName, f.selections, keys, fields, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) executeSelectionSet(ctx context.Context, obj *Object, source any, selections []selection,
+	path []any,
+) *OrderedMap {
+	typeName := ""
+	if obj != nil {
+		typeName = obj.Name
+	}
+	var keys []string
+	fields := make(map[string][]*field)
+	if err := e.collectFields(typeName, selections, &keys, fields, make(map[string]bool)); err != nil {
+		e.addError(path, err)
+		return nil
+	}
+	var sourceMap map[string]any
+	var sourceErr error
+	getSourceMap := func() (map[string]any, error) {
+		if sourceMap == nil && sourceErr == nil {
+			sourceMap, sourceErr = toJSONObject(source)
+		}
+		return sourceMap, sourceErr
+	}
+
+	result := newOrderedMap()
+	for _, key := range keys {
+		fieldPath := append(append([]any(nil), path...), key)
+		result.Set(key, e.resolveField(ctx, obj, source, getSourceMap, fields[key], fieldPath))
+	}
+	return result
+}
+
+func (e *executor) resolveField(ctx context.Context, obj *Object, source any,
+	getSourceMap func() (map[string]any, error), fields []*field, path []any,
+) any {
+	f := fields[0]
+	if f.name == "__typename" {
+		if obj == nil {
+			return nil
+		}
+		return obj.Name
+	}
+	if strings.HasPrefix(f.name, "__") {
+		e.addError(path, fmt.Errorf("introspection is not supported, unable to resolve %q", f.name))
+		return nil
+	}
+	var def *Field
+	if obj != nil {
+		def = obj.Fields[f.name]
+	}
+	args, err := e.getArguments(f.args)
+	if err != nil {
+		e.addError(path, err)
+		return nil
+	}
+	for name := range args {
+		if def == nil || !containsString(def.Args, name) {
+			e.addError(path, fmt.Errorf("unknown argument %q for field %q", name, f.name))
+			return nil
+		}
+	}
+	var value any
+	if def != nil && def.Resolve != nil {
+		value, err = def.Resolve(ctx, source, args)
+	} else {
+		var sourceMap map[string]any
+		sourceMap, err = getSourceMap()
+		if sourceMap != nil {
+			value = sourceMap[f.name]
+		}
+	}
+	if err != nil {
+		e.addError(path, err)
+		return nil
+	}
+	var selections []selection
+	for _, f := range fields {
+		selections = append(selections, f.selections...)
+	}
+	var typ *Object
+	if def != nil {
+		typ = def.Type
+	}
+	return e.completeValue(ctx, typ, value, selections, path)
+}
+
+func (e *executor) completeValue(ctx context.Context, typ *Object, value any, selections []selection, path []any) any {
+	if isNil(value) {
+		return nil
+	}
+	if len(path) > maxDepth {
+		e.addError(path, fmt.Errorf("maximum query depth of %d exceeded", maxDepth))
+		return nil
+	}
+	if typ != nil {
+		if len(selections) == 0 {
+			e.addError(path, fmt.Errorf("field of type %q must have a selection of subfields", typ.Name))
+			return nil
+		}
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice {
+			list := make([]any, 0, rv.Len())
+			for idx := 0; idx < rv.Len(); idx++ {
+				list = append(list, e.completeValue(ctx, typ, rv.Index(idx).Interface(), selections, append(path, idx)))
+			}
+			return list
+		}
+		return e.executeSelectionSet(ctx, typ, value, selections, path)
+	}
+	if len(selections) == 0 {
+		return value
+	}
+	generic, err := toJSONValue(value)
+	if err != nil {
+		e.addError(path, err)
+		return nil
+	}
+	switch v := generic.(type) {
+	case map[string]any:
+		return e.executeSelectionSet(ctx, nil, v, selections, path)
+	case []any:
+		list := make([]any, 0, len(v))
+		for idx, item := range v {
+			list = append(list, e.completeValue(ctx, nil, item, selections, append(path, idx)))
+		}
+		return list
+	default:
+		e.addError(path, errors.New("a selection of subfields is not allowed for scalar values"))
+		return nil
+	}
+}
+
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// toJSONValue converts the specified value to its generic JSON representation
+func toJSONValue(value any) (any, error) {
+	switch value.(type) {
+	case map[string]any, []any, string, bool, json.Number:
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&result)
+	return result, err
+}
+
+func toJSONObject(value any) (map[string]any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	generic, err := toJSONValue(value)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := generic.(map[string]any)
+	if !ok {
+		return nil, errors.New("the source value is not an object")
+	}
+	return obj, nil
+}
+
+// DecodeArg decodes the specified argument, using its JSON representation, into dst
+func DecodeArg(args map[string]any, name string, dst any) error {
+	val, ok := args[name]
+	if !ok || val == nil {
+		return fmt.Errorf("argument %q is required", name)
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("invalid argument %q: %w", name, err)
+	}
+	return nil
+}
+
+// GetStringArg returns the specified string argument
+func GetStringArg(args map[string]any, name string) (string, error) {
+	val, ok := args[name]
+	if !ok || val == nil {
+		return "", fmt.Errorf("argument %q is required", name)
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+	return s, nil
+}
+
+// GetIntArg returns the specified integer argument or the default value if missing
+func GetIntArg(args map[string]any, name string, defaultValue int) (int, error) {
+	val, ok := args[name]
+	if !ok || val == nil {
+		return defaultValue, nil
+	}
+	switch v := val.(type) {
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an integer", name)
+}
+
+// GetBoolArg returns the specified boolean argument or false if missing
+func GetBoolArg(args map[string]any, name string) (bool, error) {
+	val, ok := args[name]
+	if !ok || val == nil {
+		return false, nil
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("argument %q must be a boolean", name)
+	}
+	return b, nil
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testGroup struct {
+	Name string `json:"name"`
+}
+
+type testUser struct {
+	Username string            `json:"username"`
+	Quota    int64             `json:"quota_size"`
+	Groups   []string          `json:"groups,omitempty"`
+	Filters  map[string]string `json:"filters,omitempty"`
+}
+
+func getTestSchema() *Schema {
+	groups := map[string]testGroup{
+		"g1": {Name: "g1"},
+		"g2": {Name: "g2"},
+	}
+	users := []testUser{
+		{Username: "user1", Quota: 100, Groups: []string{"g1", "g2"}, Filters: map[string]string{"a": "b", "c": "d"}},
+		{Username: "user2", Quota: 200},
+	}
+	groupType := &Object{
+		Name:   "Group",
+		Fields: map[string]*Field{},
+	}
+	userType := &Object{
+		Name: "User",
+		Fields: map[string]*Field{
+			"group_details": {
+				Type: groupType,
+				Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+					var result []testGroup
+					for _, name := range source.(testUser).Groups {
+						result = append(result, groups[name])
+					}
+					return result, nil
+				},
+			},
+		},
+	}
+	return &Schema{
+		Query: &Object{
+			Name: "Query",
+			Fields: map[string]*Field{
+				"users": {
+					Type: userType,
+					Args: []string{"limit"},
+					Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+						limit, err := GetIntArg(args, "limit", 100)
+						if err != nil {
+							return nil, err
+						}
+						if limit < len(users) {
+							return users[:limit], nil
+						}
+						return users, nil
+					},
+				},
+				"user": {
+					Type: userType,
+					Args: []string{"username"},
+					Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+						username, err := GetStringArg(args, "username")
+						if err != nil {
+							return nil, err
+						}
+						for _, u := range users {
+							if u.Username == username {
+								return u, nil
+							}
+						}
+						return nil, errors.New("not found")
+					},
+				},
+			},
+		},
+		Mutation: &Object{
+			Name: "Mutation",
+			Fields: map[string]*Field{
+				"add_user": {
+					Type: userType,
+					Args: []string{"user", "notify"},
+					Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+						var u testUser
+						if err := DecodeArg(args, "user", &u); err != nil {
+							return nil, err
+						}
+						if _, err := GetBoolArg(args, "notify"); err != nil {
+							return nil, err
+						}
+						users = append(users, u)
+						return u, nil
+					},
+				},
+			},
+		},
+	}
+}
+
+func executeTestQuery(t *testing.T, schema *Schema, req Request) (string, *Response) {
+	resp := schema.Execute(context.Background(), req)
+	data, err := json.Marshal(resp)
+	require.NoError(t, err)
+	return string(data), resp
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	schema := getTestSchema()
+	data, resp := executeTestQuery(t, schema, Request{
+		Query: `# comment
+		query Users($limit: Int = 5) {
+			users(limit: $limit) {
+				username, quota_size
+				__typename
+				filters { c }
+				group_details { name }
+				missing
+			}
+			u2: user(username: "user2") { ...userFields }
+		}
+		fragment userFields on User {
+			name: username
+			... on User { groups }
+			... on Group { ignored }
+		}`,
+	})
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"data":{"users":[{"username":"user1","quota_size":100,"__typename":"User","filters":{"c":"d"},
+		"group_details":[{"name":"g1"},{"name":"g2"}],"missing":null},{"username":"user2","quota_size":200,
+		"__typename":"User","filters":null,"group_details":null,"missing":null}],"u2":{"name":"user2","groups":null}}}`, data)
+	// the requested fields order must be preserved
+	assert.Regexp(t, `^\{"data":\{"users":\[\{"username":"user1","quota_size":100,"__typename"`, data)
+
+	data, resp = executeTestQuery(t, schema, Request{
+		Query: `query Users($limit: Int, $skip: Boolean!) {
+			users(limit: $limit) { username quota_size @skip(if: $skip) filters @include(if: false) }
+		}`,
+		Variables: map[string]any{"limit": float64(1), "skip": true},
+	})
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"data":{"users":[{"username":"user1"}]}}`, data)
+
+	data, resp = executeTestQuery(t, schema, Request{
+		Query: `{ users { username } user(username: "missing") { username } }`,
+	})
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, []any{"user"}, resp.Errors[0].Path)
+	assert.JSONEq(t, `{"data":{"users":[{"username":"user1"},{"username":"user2"}],"user":null},
+		"errors":[{"message":"not found","path":["user"]}]}`, data)
+}
+
+func TestGraphQLMutation(t *testing.T) {
+	schema := getTestSchema()
+	data, resp := executeTestQuery(t, schema, Request{
+		Query: `mutation Add($user: Any!) {
+			add_user(user: $user, notify: true) { username quota_size }
+			second: add_user(user: {username: "user4", quota_size: 10, groups: ["g1"]}) { username groups }
+		}`,
+		Variables: map[string]any{"user": map[string]any{"username": "user3", "quota_size": 50}},
+	})
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"data":{"add_user":{"username":"user3","quota_size":50},
+		"second":{"username":"user4","groups":["g1"]}}}`, data)
+	_, resp = executeTestQuery(t, schema, Request{
+		Query: `{ users { username } }`,
+	})
+	assert.Empty(t, resp.Errors)
+	assert.Len(t, resp.Data.Get("users"), 4)
+
+	_, resp = executeTestQuery(t, schema, Request{
+		Query: `mutation { add_user(user: "invalid") { username } }`,
+	})
+	require.Len(t, resp.Errors, 1)
+	assert.Contains(t, resp.Errors[0].Message, `invalid argument "user"`)
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	schema := getTestSchema()
+	tests := []struct {
+		req         Request
+		errContains string
+		reqError    bool
+	}{
+		{Request{Query: `{ users { username }`}, "unexpected end of document", true},
+		{Request{Query: `{ users { username } } }`}, `unexpected "}"`, true},
+		{Request{Query: `{ users(limit: "a" { username } }`}, `unexpected "{"`, true},
+		{Request{Query: `subscription { users { username } }`}, "not supported", true},
+		{Request{Query: `query { users { username } } query B { users { username } }`}, "operation name is required", true},
+		{Request{Query: `query A { users { username } }`, OperationName: "B"}, `unknown operation "B"`, true},
+		{Request{Query: `query A($limit: Int!) { users(limit: $limit) { username } }`}, `variable "limit" is required`, true},
+		{Request{Query: `{ users(limit: $limit) { username } }`}, `variable "limit" is not defined`, false},
+		{Request{Query: `{ users(limit: 1.5) { username } }`}, `must be an integer`, false},
+		{Request{Query: `{ users(unknown: 1) { username } }`}, `unknown argument "unknown"`, false},
+		{Request{Query: `{ users(limit: 1, limit: 2) { username } }`}, `specified more than once`, false},
+		{Request{Query: `{ users }`}, `must have a selection of subfields`, false},
+		{Request{Query: `{ users { username { name } } }`}, `not allowed for scalar values`, false},
+		{Request{Query: `{ users { ...missing } }`}, `unknown fragment "missing"`, false},
+		{Request{Query: `{ users { username: quota_size username } }`}, `conflict`, false},
+		{Request{Query: `{ __schema { types { name } } }`}, `introspection is not supported`, false},
+		{Request{Query: `{ users { username @skip } }`}, `requires a boolean "if" argument`, false},
+		{Request{Query: `{ user(username: "aè\n") { username } }`}, `not found`, false},
+		{Request{Query: "{ user(username: \"unterminated) { username } }"}, "unterminated string", true},
+		{Request{Query: `mutation { add_user { username } }`}, `argument "user" is required`, false},
+		{Request{Query: `fragment A on User { username }`}, "does not contain any operation", true},
+	}
+	for _, tc := range tests {
+		_, resp := executeTestQuery(t, schema, tc.req)
+		if assert.NotEmpty(t, resp.Errors, tc.req.Query) {
+			assert.Contains(t, resp.Errors[0].Message, tc.errContains, tc.req.Query)
+		}
+		assert.Equal(t, tc.reqError, resp.HasRequestError(), tc.req.Query)
+	}
+	schema.Mutation = nil
+	_, resp := executeTestQuery(t, schema, Request{Query: `mutation { add_user { username } }`})
+	assert.True(t, resp.HasRequestError())
+}
+
+func TestGraphQLParser(t *testing.T) {
+	doc, err := parse(`query Q($a: [String!]! = ["a", "b"], $b: Input = {k: ENUM, n: null, f: -1.5e3}) @dir {
+		f(s: """block "string" """, l: [1, 2]) @include(if: true)
+	}`)
+	require.NoError(t, err)
+	require.Len(t, doc.operations, 1)
+	op := doc.operations[0]
+	assert.Equal(t, "Q", op.name)
+	require.Len(t, op.vars, 2)
+	assert.True(t, op.vars[0].nonNull)
+	assert.Equal(t, []any{"a", "b"}, op.vars[0].defaultValue)
+	assert.False(t, op.vars[1].nonNull)
+	obj := op.vars[1].defaultValue.(objectValue)
+	require.Len(t, obj, 3)
+	assert.Equal(t, enumValue("ENUM"), obj[0].value)
+	assert.Nil(t, obj[1].value)
+	assert.Equal(t, -1500.0, obj[2].value)
+	f := op.selections[0].(*field)
+	assert.Equal(t, `block "string"`, f.args[0].value)
+	assert.Equal(t, []any{int64(1), int64(2)}, f.args[1].value)
+
+	for _, q := range []string{`query ($a: Int = $b) { f }`, `{ f(a: 1-) }`, `{ f(a: "\x") }`, `{ ... on }`,
+		`fragment on on User { f }`, `{ f(a: .5) }`, `{}`, `{ f() }`, `query Q ( { f }`} {
+		_, err := parse(q)
+		assert.Error(t, err, q)
+	}
+}
@@ -0,0 +1,711 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) errorf(pos int, format string, v ...any) error {
+	line := strings.Count(l.src[:pos], "\n") + 1
+	return fmt.Errorf("syntax error at line %d: %s", line, fmt.Sprintf(format, v...))
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\ufeff"):
+			l.pos += len("\ufeff")
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, pos: l.pos}, nil
+	}
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), pos: start}, nil
+	case c == '.':
+		if strings.HasPrefix(l.src[l.pos:], "...") {
+			l.pos += 3
+			return token{kind: tokenPunctuator, value: "...", pos: start}, nil
+		}
+		return token{}, l.errorf(start, "unexpected character %q", c)
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.readNumber()
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.readBlockString()
+		}
+		return l.readString()
+	default:
+		return token{}, l.errorf(start, "unexpected character %q", c)
+	}
+}
+
+func (l *lexer) readDigits() int {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos - start
+}
+
+func (l *lexer) readNumber() (token, error) {
+	start := l.pos
+	kind := tokenInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if l.readDigits() == 0 {
+		return token{}, l.errorf(start, "invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if l.readDigits() == 0 {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if l.readDigits() == 0 {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) readString() (token, error) {
+	start := l.pos
+	l.pos++
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return token{kind: tokenString, value: sb.String(), pos: start}, nil
+		case '\n', '\r':
+			return token{}, l.errorf(start, "unterminated string")
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(start, "unterminated string")
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				sb.WriteByte(esc)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, l.errorf(start, "invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(start, "invalid unicode escape")
+				}
+				sb.WriteRune(rune(code))
+				l.pos += 4
+			default:
+				return token{}, l.errorf(start, "invalid escape sequence \\%c", esc)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			sb.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return token{}, l.errorf(start, "unterminated string")
+}
+
+func (l *lexer) readBlockString() (token, error) {
+	start := l.pos
+	l.pos += 3
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			l.pos += 3
+			return token{kind: tokenString, value: strings.TrimSpace(sb.String()), pos: start}, nil
+		}
+		if strings.HasPrefix(l.src[l.pos:], `\"""`) {
+			sb.WriteString(`"""`)
+			l.pos += 4
+			continue
+		}
+		sb.WriteByte(l.src[l.pos])
+		l.pos++
+	}
+	return token{}, l.errorf(start, "unterminated block string")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	opType     string
+	name       string
+	vars       []*variableDefinition
+	selections []selection
+}
+
+type variableDefinition struct {
+	name         string
+	nonNull      bool
+	defaultValue any
+	hasDefault   bool
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	directives []*directive
+	selections []selection
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+type argument struct {
+	name  string
+	value any
+}
+
+// selection is one of *field, *fragmentSpread, *inlineFragment
+type selection interface {
+	getDirectives() []*directive
+}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+}
+
+func (f *field) getDirectives() []*directive {
+	return f.directives
+}
+
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+func (f *fragmentSpread) getDirectives() []*directive {
+	return f.directives
+}
+
+type inlineFragment struct {
+	typeCond   string
+	directives []*directive
+	selections []selection
+}
+
+func (f *inlineFragment) getDirectives() []*directive {
+	return f.directives
+}
+
+// variable is a reference to a variable within a value
+type variable string
+
+// enumValue is an enum literal, it is resolved as string
+type enumValue string
+
+// objectValue is an input object literal, fields order is preserved
+type objectValue []*argument
+
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lexer: &lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{
+		fragments: make(map[string]*fragment),
+	}
+	for p.tok.kind != tokenEOF {
+		if p.peekName("fragment") {
+			f, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+			continue
+		}
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		doc.operations = append(doc.operations, op)
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document does not contain any operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return p.lexer.errorf(p.tok.pos, "unexpected end of document")
+	}
+	return p.lexer.errorf(p.tok.pos, "unexpected %q", p.tok.value)
+}
+
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+func (p *parser) peekName(name string) bool {
+	return p.tok.kind == tokenName && p.tok.value == name
+}
+
+func (p *parser) skip(punctuator string) (bool, error) {
+	if p.peek(punctuator) {
+		return true, p.advance()
+	}
+	return false, nil
+}
+
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) parseOperation() (*operation, error) {
+	op := &operation{opType: "query"}
+	if p.peek("{") {
+		selections, err := p.parseSelectionSet()
+		op.selections = selections
+		return op, err
+	}
+	opType, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	switch opType {
+	case "query", "mutation":
+		op.opType = opType
+	case "subscription":
+		return nil, fmt.Errorf("subscriptions are not supported")
+	default:
+		return nil, p.lexer.errorf(p.tok.pos, "unexpected %q", opType)
+	}
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if op.vars, err = p.parseVariableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	op.selections, err = p.parseSelectionSet()
+	return op, err
+}
+
+func (p *parser) parseVariableDefinitions() ([]*variableDefinition, error) {
+	var vars []*variableDefinition
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		nonNull, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		def := &variableDefinition{name: name, nonNull: nonNull}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if def.defaultValue, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+			def.hasDefault = true
+		}
+		if _, err := p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		vars = append(vars, def)
+	}
+	return vars, p.advance()
+}
+
+// parseType parses a type reference, types are not validated, we only
+// report if the outer type is non null
+func (p *parser) parseType() (bool, error) {
+	if ok, err := p.skip("["); err != nil {
+		return false, err
+	} else if ok {
+		if _, err := p.parseType(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return false, err
+	}
+	return p.skip("!")
+}
+
+func (p *parser) parseFragment() (*fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.lexer.errorf(p.tok.pos, "invalid fragment name %q", name)
+	}
+	if !p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	f := &fragment{name: name}
+	if f.typeCond, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	f.selections, err = p.parseSelectionSet()
+	return f, err
+}
+
+func (p *parser) parseSelectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []selection
+	for !p.peek("}") {
+		s, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) parseSelection() (selection, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			spread := &fragmentSpread{name: p.tok.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			spread.directives, err = p.parseDirectives()
+			return spread, err
+		}
+		f := &inlineFragment{}
+		if p.peekName("on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if f.typeCond, err = p.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if f.directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		f.selections, err = p.parseSelectionSet()
+		return f, err
+	}
+	return p.parseField()
+}
+
+func (p *parser) parseField() (*field, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	f := &field{name: name}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.alias = name
+		if f.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if f.args, err = p.parseArguments(); err != nil {
+			return nil, err
+		}
+	}
+	if f.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		f.selections, err = p.parseSelectionSet()
+	}
+	return f, err
+}
+
+func (p *parser) parseArguments() ([]*argument, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []*argument
+	for !p.peek(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &argument{name: name, value: value})
+	}
+	if len(args) == 0 {
+		return nil, p.unexpected()
+	}
+	return args, p.advance()
+}
+
+func (p *parser) parseDirectives() ([]*directive, error) {
+	var directives []*directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		d := &directive{name: name}
+		if p.peek("(") {
+			if d.args, err = p.parseArguments(); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) parseValue(isConst bool) (any, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenPunctuator:
+		switch tok.value {
+		case "$":
+			if isConst {
+				return nil, p.unexpected()
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.expectName()
+			return variable(name), err
+		case "[":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			list := make([]any, 0)
+			for !p.peek("]") {
+				val, err := p.parseValue(isConst)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, val)
+			}
+			return list, p.advance()
+		case "{":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			obj := make(objectValue, 0)
+			for !p.peek("}") {
+				name, err := p.expectName()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				val, err := p.parseValue(isConst)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, &argument{name: name, value: val})
+			}
+			return obj, p.advance()
+		}
+	case tokenInt:
+		val, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(tok.pos, "invalid integer %q", tok.value)
+		}
+		return val, p.advance()
+	case tokenFloat:
+		val, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(tok.pos, "invalid float %q", tok.value)
+		}
+		return val, p.advance()
+	case tokenString:
+		return tok.value, p.advance()
+	case tokenName:
+		var val any
+		switch tok.value {
+		case "true":
+			val = true
+		case "false":
+			val = false
+		case "null":
+			val = nil
+		default:
+			val = enumValue(tok.value)
+		}
+		return val, p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type ctxKey int
+
+const requestContextKey ctxKey = iota
+
+// requestContext holds the authenticated admin and the client IP
+type requestContext struct {
+	admin dataprovider.Admin
+	ip    string
+}
+
+// methodPermission defines the API key resource and the admin permission
+// required to call an RPC
+type methodPermission struct {
+	resource string
+	level    string
+	perm     string
+}
+
+var methodPermissions = map[string]methodPermission{
+	proto.Admin_GetUsers_FullMethodName: {"users", dataprovider.APIKeyPermissionRead, dataprovider.PermAdminViewUsers},
+	proto.Admin_GetUser_FullMethodName:  {"users", dataprovider.APIKeyPermissionRead, dataprovider.PermAdminViewUsers},
+	proto.Admin_AddUser_FullMethodName:  {"users", dataprovider.APIKeyPermissionWrite, dataprovider.PermAdminAddUsers},
+	proto.Admin_UpdateUser_FullMethodName: {"users", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminChangeUsers},
+	proto.Admin_DeleteUser_FullMethodName: {"users", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminDeleteUsers},
+	proto.Admin_GetGroups_FullMethodName: {"groups", dataprovider.APIKeyPermissionRead,
+		dataprovider.PermAdminViewGroups},
+	proto.Admin_GetGroup_FullMethodName: {"groups", dataprovider.APIKeyPermissionRead,
+		dataprovider.PermAdminViewGroups},
+	proto.Admin_AddGroup_FullMethodName: {"groups", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminAddGroups},
+	proto.Admin_UpdateGroup_FullMethodName: {"groups", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminChangeGroups},
+	proto.Admin_DeleteGroup_FullMethodName: {"groups", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminDeleteGroups},
+	proto.Admin_GetFolders_FullMethodName: {"folders", dataprovider.APIKeyPermissionRead,
+		dataprovider.PermAdminViewFolders},
+	proto.Admin_GetFolder_FullMethodName: {"folders", dataprovider.APIKeyPermissionRead,
+		dataprovider.PermAdminViewFolders},
+	proto.Admin_AddFolder_FullMethodName: {"folders", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminAddFolders},
+	proto.Admin_UpdateFolder_FullMethodName: {"folders", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminChangeFolders},
+	proto.Admin_DeleteFolder_FullMethodName: {"folders", dataprovider.APIKeyPermissionWrite,
+		dataprovider.PermAdminDeleteFolders},
+	proto.Admin_StreamLiveEvents_FullMethodName: {"events", dataprovider.APIKeyPermissionRead,
+		dataprovider.PermAdminViewEvents},
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+func getRemoteIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return util.GetIPFromRemoteAddress(p.Addr.String())
+	}
+	return ""
+}
+
+func handleDefenderEventLoginFailed(ip string, err error) {
+	event := common.HostEventLoginFailed
+	if errors.Is(err, util.ErrNotFound) {
+		event = common.HostEventUserNotFound
+		err = dataprovider.ErrInvalidCredentials
+	}
+	common.AddDefenderEvent(ip, common.ProtocolHTTP, event)
+	common.DelayLogin(err)
+}
+
+func authenticate(ctx context.Context, method string) (context.Context, error) {
+	ip := getRemoteIP(ctx)
+	if common.IsBanned(ip, common.ProtocolHTTP) {
+		return ctx, status.Error(codes.PermissionDenied, "your IP address is blocked")
+	}
+	perm, ok := methodPermissions[method]
+	if !ok {
+		return ctx, status.Error(codes.Unimplemented, "unknown method")
+	}
+	var apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(apiKeyMetadata); len(values) > 0 {
+			apiKey = values[0]
+		}
+	}
+	keyParams := strings.SplitN(apiKey, ".", 3)
+	if len(keyParams) < 2 {
+		return ctx, status.Errorf(codes.Unauthenticated, "missing or invalid %q metadata", apiKeyMetadata)
+	}
+	keyID := keyParams[0]
+	key := keyParams[1]
+	apiUser := ""
+	if len(keyParams) > 2 {
+		apiUser = keyParams[2]
+	}
+	k, err := dataprovider.APIKeyExists(keyID)
+	if err != nil {
+		handleDefenderEventLoginFailed(ip, util.NewRecordNotFoundError("invalid api key"))
+		logger.Debug(logSender, "", "invalid api key %q: %v", keyID, err)
+		return ctx, status.Error(codes.Unauthenticated, "the provided api key is not valid")
+	}
+	if k.Scope != dataprovider.APIKeyScopeAdmin {
+		handleDefenderEventLoginFailed(ip, dataprovider.ErrInvalidCredentials)
+		logger.Debug(logSender, "", "unable to authenticate api key %q: invalid scope %d", keyID, k.Scope)
+		return ctx, status.Error(codes.PermissionDenied, "the provided api key is invalid for this request")
+	}
+	if err := k.Authenticate(key); err != nil {
+		handleDefenderEventLoginFailed(ip, dataprovider.ErrInvalidCredentials)
+		logger.Debug(logSender, "", "unable to authenticate api key %q: %v", keyID, err)
+		return ctx, status.Error(codes.Unauthenticated, "the provided api key cannot be authenticated")
+	}
+	if !k.IsAllowedFromIP(ip) {
+		handleDefenderEventLoginFailed(ip, dataprovider.ErrInvalidCredentials)
+		logger.Debug(logSender, "", "api key %q is not allowed from IP %q", keyID, ip)
+		return ctx, status.Error(codes.Unauthenticated, "the provided api key cannot be used from this IP address")
+	}
+	if !k.HasPermission(perm.resource, perm.level) {
+		logger.Debug(logSender, "", "api key %q has no %q permission for resource %q, method: %q",
+			keyID, perm.level, perm.resource, method)
+		return ctx, status.Error(codes.PermissionDenied, "the provided api key has no permission for this method")
+	}
+	if k.Admin != "" {
+		apiUser = k.Admin
+	}
+	admin, err := authenticateAdmin(apiUser, ip)
+	if err != nil {
+		handleDefenderEventLoginFailed(ip, err)
+		logger.Debug(logSender, "", "unable to authenticate admin %q associated with api key %q: %v",
+			apiUser, keyID, err)
+		return ctx, status.Error(codes.Unauthenticated, "the admin associated with the provided api key cannot be authenticated")
+	}
+	if !admin.HasPermission(perm.perm) {
+		return ctx, status.Errorf(codes.PermissionDenied, "the %q permission is required", perm.perm)
+	}
+	dataprovider.UpdateAPIKeyLastUse(&k) //nolint:errcheck
+	return context.WithValue(ctx, requestContextKey, &requestContext{
+		admin: admin,
+		ip:    ip,
+	}), nil
+}
+
+func authenticateAdmin(username, ip string) (dataprovider.Admin, error) {
+	if username == "" {
+		return dataprovider.Admin{}, errors.New("the provided key is not associated with any admin and no username was provided")
+	}
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		return admin, err
+	}
+	if !admin.Filters.AllowAPIKeyAuth {
+		return admin, fmt.Errorf("API key authentication disabled for admin %q", admin.Username)
+	}
+	// the gRPC API is not tenant aware and it is reserved to global admins
+	if admin.Tenant != "" {
+		return admin, fmt.Errorf("admin %q belongs to a tenant, gRPC API access is not allowed", admin.Username)
+	}
+	if err := admin.CanLogin(ip); err != nil {
+		return admin, err
+	}
+	dataprovider.UpdateAdminLastLogin(&admin)
+	common.DelayLogin(nil)
+	return admin, nil
+}
+
+func getRequestContext(ctx context.Context) (*requestContext, error) {
+	reqCtx, ok := ctx.Value(requestContextKey).(*requestContext)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated request")
+	}
+	return reqCtx, nil
+}
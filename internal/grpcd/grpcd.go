@@ -0,0 +1,304 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package grpcd implements the gRPC management API. The protobuf definitions
+// are in the proto directory, the Go code is generated using buf
+package grpcd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	logSender = "grpcd"
+	// metadata key for the API key authentication
+	apiKeyMetadata = "x-sftpgo-api-key"
+)
+
+var (
+	certMgr         *common.CertManager
+	serviceStatus   ServiceStatus
+	runningBindings atomic.Pointer[common.BindingsRegistry[Binding]]
+)
+
+// ServiceStatus defines the service status
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Binding defines the configuration for a network listener
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving requests
+	Port int `json:"port" mapstructure:"port"`
+	// you also need to provide a certificate for enabling TLS
+	EnableTLS bool `json:"enable_tls" mapstructure:"enable_tls"`
+	// Certificate and matching private key for this specific binding, if empty the global
+	// ones will be used, if any
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Defines the minimum TLS version. 13 means TLS 1.3, default is TLS 1.2
+	MinTLSVersion int `json:"min_tls_version" mapstructure:"min_tls_version"`
+	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
+	// If CipherSuites is nil/empty, a default list of secure cipher suites
+	// is used, with a preference order based on hardware performance.
+	// Note that TLS 1.3 ciphersuites are not configurable.
+	// The supported ciphersuites names are defined here:
+	//
+	// https://github.com/golang/go/blob/master/src/crypto/tls/cipher_suites.go#L53
+	//
+	// any invalid name will be silently ignored.
+	// The order matters, the ciphers listed first will be the preferred ones.
+	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+}
+
+// GetAddress returns the binding address
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// IsValid returns true if the binding port is > 0
+func (b *Binding) IsValid() bool {
+	return b.Port > 0
+}
+
+// Configuration defines the configuration for the gRPC management API
+type Configuration struct {
+	// Addresses and ports to bind to
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// If files containing a certificate and matching private key for the server are provided you
+	// can enable TLS connections for the configured bindings.
+	// Certificate and key files can be reloaded on demand sending a "SIGHUP" signal on Unix based systems and a
+	// "paramchange" request to the running service on Windows.
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+}
+
+// GetStatus returns the server status
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// ShouldBind returns true if there is at least a valid binding
+func (c *Configuration) ShouldBind() bool {
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
+	var keyPairs []common.TLSKeyPair
+
+	for _, binding := range c.Bindings {
+		certificateFile := getConfigPath(binding.CertificateFile, configDir)
+		certificateKeyFile := getConfigPath(binding.CertificateKeyFile, configDir)
+		if certificateFile != "" && certificateKeyFile != "" {
+			keyPairs = append(keyPairs, common.TLSKeyPair{
+				Cert: certificateFile,
+				Key:  certificateKeyFile,
+				ID:   binding.GetAddress(),
+			})
+		}
+	}
+	certificateFile := getConfigPath(c.CertificateFile, configDir)
+	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if certificateFile != "" && certificateKeyFile != "" {
+		keyPairs = append(keyPairs, common.TLSKeyPair{
+			Cert: certificateFile,
+			Key:  certificateKeyFile,
+			ID:   common.DefaultTLSKeyPaidID,
+		})
+	}
+	return keyPairs
+}
+
+// Initialize configures and starts the gRPC server
+func (c *Configuration) Initialize(configDir string) error {
+	logger.Info(logSender, "", "initializing gRPC server with config %+v", *c)
+	if !c.ShouldBind() {
+		return common.ErrNoBinding
+	}
+
+	keyPairs := c.getKeyPairs(configDir)
+	if len(keyPairs) > 0 {
+		mgr, err := common.NewCertManager(keyPairs, configDir, logSender)
+		if err != nil {
+			return err
+		}
+		certMgr = mgr
+	}
+
+	serviceStatus = ServiceStatus{
+		Bindings: nil,
+	}
+
+	exitChannel := make(chan error, 1)
+
+	registry := common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, serve)
+	runningBindings.Store(registry)
+
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		serviceStatus.Bindings = append(serviceStatus.Bindings, getBindingsStatus([]Binding{binding})...)
+
+		go func(binding Binding) {
+			err := registry.Serve(binding)
+			if errors.Is(err, common.ErrBindingRemoved) {
+				return
+			}
+			exitChannel <- err
+		}(binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return <-exitChannel
+}
+
+func serve(binding Binding, l *common.BindingListener) error {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(unaryAuthInterceptor),
+		grpc.StreamInterceptor(streamAuthInterceptor),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             30 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	if certMgr != nil && binding.EnableTLS {
+		certID := common.DefaultTLSKeyPaidID
+		if getConfigPath(binding.CertificateFile, "") != "" && getConfigPath(binding.CertificateKeyFile, "") != "" {
+			certID = binding.GetAddress()
+		}
+		tlsConfig := &tls.Config{
+			GetCertificate: certMgr.GetCertificateFunc(certID),
+			MinVersion:     util.GetTLSVersion(binding.MinTLSVersion),
+			CipherSuites:   util.GetTLSCiphersFromNames(binding.TLSCipherSuites),
+		}
+		logger.Debug(logSender, "", "configured TLS cipher suites for binding %q: %v, certID: %v",
+			binding.GetAddress(), tlsConfig.CipherSuites, certID)
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else {
+		binding.EnableTLS = false
+	}
+
+	util.CheckTCP4Port(binding.Port)
+	listener, err := util.Listen("tcp", binding.GetAddress())
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", binding.GetAddress(), err)
+		return err
+	}
+	logger.Info(logSender, "", "server listener registered, address: %s TLS enabled: %t",
+		listener.Addr().String(), binding.EnableTLS)
+
+	server := grpc.NewServer(opts...)
+	proto.RegisterAdminServer(server, &adminServer{})
+	if err := l.SetStopFunc(func() error {
+		server.GracefulStop()
+		return nil
+	}); err != nil {
+		listener.Close()
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// ReloadBindings applies the configured bindings to the running gRPC server.
+// New bindings are started and removed bindings stop accepting new connections,
+// the pending RPCs are not affected. Changing the settings of an existing
+// binding requires a restart
+func (c *Configuration) ReloadBindings() error {
+	registry := runningBindings.Load()
+	if registry == nil {
+		return errors.New("the gRPC server is not running")
+	}
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			bindings = append(bindings, binding)
+		}
+	}
+	serviceStatus.Bindings = getBindingsStatus(registry.Reload(bindings, checkBindingCertificate))
+	return nil
+}
+
+// checkBindingCertificate returns an error if the binding requires TLS and
+// no certificate was loaded for it
+func checkBindingCertificate(b Binding) error {
+	if !b.EnableTLS {
+		return nil
+	}
+	certID := common.DefaultTLSKeyPaidID
+	if getConfigPath(b.CertificateFile, "") != "" && getConfigPath(b.CertificateKeyFile, "") != "" {
+		certID = b.GetAddress()
+	}
+	if certMgr == nil || !certMgr.HasCertificate(certID) {
+		return fmt.Errorf("no TLS certificate loaded for binding %q", b.GetAddress())
+	}
+	return nil
+}
+
+// getBindingsStatus returns the bindings to report in the service status,
+// TLS is disabled if no certificate is configured
+func getBindingsStatus(bindings []Binding) []Binding {
+	result := make([]Binding, 0, len(bindings))
+	for _, b := range bindings {
+		if certMgr == nil {
+			b.EnableTLS = false
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// ReloadCertificateMgr reloads the certificate manager
+func ReloadCertificateMgr() error {
+	if certMgr != nil {
+		return certMgr.Reload()
+	}
+	return nil
+}
+
+func getConfigPath(name, configDir string) string {
+	if !util.IsFileInputValid(name) {
+		return ""
+	}
+	if name != "" && !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name)
+	}
+	return name
+}
@@ -0,0 +1,494 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	logSender       = "grpcdTesting"
+	grpcServerAddr  = "127.0.0.1:9099"
+	defaultUsername = "test_user_grpc"
+	defaultAdmin    = "test_admin_grpc"
+)
+
+var (
+	configDir    = filepath.Join(".", "..", "..")
+	homeBasePath string
+)
+
+func TestMain(m *testing.M) {
+	logFilePath := filepath.Join(configDir, "sftpgo_grpcd_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting gRPC tests, provider: %v", providerConf.Driver)
+	homeBasePath = os.TempDir()
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+	err = common.Initialize(config.GetCommonConfig(), 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+
+	grpcConf := config.GetGRPCDConfig()
+	grpcConf.Bindings = []grpcd.Binding{
+		{
+			Address: "127.0.0.1",
+			Port:    9099,
+		},
+	}
+	go func() {
+		logger.Debug(logSender, "", "initializing gRPC server with config %+v", grpcConf)
+		if err := grpcConf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start gRPC server: %v", err)
+			os.Exit(1)
+		}
+	}()
+	waitTCPListening(grpcServerAddr)
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestUserGroupFolderCRUD(t *testing.T) {
+	admin, apiKey := addTestAdmin(t, []string{dataprovider.PermAdminAny}, nil)
+	defer removeTestAdmin(t, admin)
+
+	client, conn := getClient(t)
+	defer conn.Close()
+	ctx := getAuthContext(apiKey)
+
+	folderName := "test_folder_grpc"
+	folder, err := client.AddFolder(ctx, &proto.AddFolderRequest{
+		Data: asJSON(t, vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: filepath.Join(os.TempDir(), folderName),
+		}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, folderName, folder.GetName())
+	folder, err = client.UpdateFolder(ctx, &proto.UpdateFolderRequest{
+		Name: folderName,
+		Data: asJSON(t, vfs.BaseVirtualFolder{
+			MappedPath:  filepath.Join(os.TempDir(), folderName),
+			Description: "folder desc",
+		}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "folder desc", folder.GetDescription())
+
+	groupName := "test_group_grpc"
+	group, err := client.AddGroup(ctx, &proto.AddGroupRequest{
+		Data: asJSON(t, dataprovider.Group{
+			BaseGroup: sdk.BaseGroup{
+				Name: groupName,
+			},
+			VirtualFolders: []vfs.VirtualFolder{
+				{
+					BaseVirtualFolder: vfs.BaseVirtualFolder{
+						Name: folderName,
+					},
+					VirtualPath: "/vdir",
+				},
+			},
+		}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, groupName, group.GetName())
+	assert.Equal(t, []string{folderName}, group.GetVirtualFolders())
+	group, err = client.UpdateGroup(ctx, &proto.UpdateGroupRequest{
+		Name: groupName,
+		Data: asJSON(t, dataprovider.Group{
+			BaseGroup: sdk.BaseGroup{
+				Description: "group desc",
+			},
+			VirtualFolders: []vfs.VirtualFolder{
+				{
+					BaseVirtualFolder: vfs.BaseVirtualFolder{
+						Name: folderName,
+					},
+					VirtualPath: "/vdir1",
+				},
+			},
+		}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "group desc", group.GetDescription())
+	assert.Equal(t, []string{folderName}, group.GetVirtualFolders())
+
+	u := getTestUser()
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: groupName,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, err := client.AddUser(ctx, &proto.AddUserRequest{
+		Data: asJSON(t, u),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, u.Username, user.GetUsername())
+	assert.Equal(t, int32(1), user.GetStatus())
+	if assert.Len(t, user.GetGroups(), 1) {
+		assert.Equal(t, groupName, user.GetGroups()[0].GetName())
+		assert.Equal(t, int32(sdk.GroupTypePrimary), user.GetGroups()[0].GetType())
+	}
+	var userFromJSON dataprovider.User
+	err = json.Unmarshal(user.GetData(), &userFromJSON)
+	require.NoError(t, err)
+	assert.Equal(t, u.Username, userFromJSON.Username)
+	assert.Empty(t, userFromJSON.Password)
+
+	u.Status = 0
+	user, err = client.UpdateUser(ctx, &proto.UpdateUserRequest{
+		Username:   u.Username,
+		Data:       asJSON(t, u),
+		Disconnect: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), user.GetStatus())
+	// the password must be preserved
+	dbUser, err := dataprovider.UserExists(u.Username, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, dbUser.Password)
+
+	users, err := client.GetUsers(ctx, &proto.ListRequest{})
+	require.NoError(t, err)
+	assert.Len(t, users.GetUsers(), 1)
+	groups, err := client.GetGroups(ctx, &proto.ListRequest{Limit: 10, Order: dataprovider.OrderDESC})
+	require.NoError(t, err)
+	if assert.Len(t, groups.GetGroups(), 1) {
+		assert.Equal(t, []string{u.Username}, groups.GetGroups()[0].GetUsers())
+	}
+	folders, err := client.GetFolders(ctx, &proto.ListRequest{})
+	require.NoError(t, err)
+	if assert.Len(t, folders.GetFolders(), 1) {
+		assert.Equal(t, []string{groupName}, folders.GetFolders()[0].GetGroups())
+	}
+	_, err = client.GetUsers(ctx, &proto.ListRequest{Limit: 1000})
+	assertStatusCode(t, err, codes.InvalidArgument)
+	_, err = client.GetUsers(ctx, &proto.ListRequest{Order: "invalid"})
+	assertStatusCode(t, err, codes.InvalidArgument)
+	_, err = client.AddUser(ctx, &proto.AddUserRequest{})
+	assertStatusCode(t, err, codes.InvalidArgument)
+	_, err = client.AddUser(ctx, &proto.AddUserRequest{Data: asJSON(t, u)})
+	assertStatusCode(t, err, codes.AlreadyExists)
+	_, err = client.AddUser(ctx, &proto.AddUserRequest{Data: []byte(`{"username":"invalid"}`)})
+	assertStatusCode(t, err, codes.InvalidArgument)
+
+	_, err = client.DeleteUser(ctx, &proto.UserRequest{Username: u.Username})
+	assert.NoError(t, err)
+	_, err = client.GetUser(ctx, &proto.UserRequest{Username: u.Username})
+	assertStatusCode(t, err, codes.NotFound)
+	_, err = client.DeleteGroup(ctx, &proto.GroupRequest{Name: groupName})
+	assert.NoError(t, err)
+	_, err = client.GetGroup(ctx, &proto.GroupRequest{Name: groupName})
+	assertStatusCode(t, err, codes.NotFound)
+	_, err = client.DeleteFolder(ctx, &proto.FolderRequest{Name: folderName})
+	assert.NoError(t, err)
+	_, err = client.GetFolder(ctx, &proto.FolderRequest{Name: folderName})
+	assertStatusCode(t, err, codes.NotFound)
+
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestAuthentication(t *testing.T) {
+	admin, apiKey := addTestAdmin(t, []string{dataprovider.PermAdminViewUsers}, nil)
+	defer removeTestAdmin(t, admin)
+
+	client, conn := getClient(t)
+	defer conn.Close()
+
+	_, err := client.GetUsers(context.Background(), &proto.ListRequest{})
+	assertStatusCode(t, err, codes.Unauthenticated)
+	_, err = client.GetUsers(getAuthContext("invalid"), &proto.ListRequest{})
+	assertStatusCode(t, err, codes.Unauthenticated)
+	_, err = client.GetUsers(getAuthContext("missing.key"), &proto.ListRequest{})
+	assertStatusCode(t, err, codes.Unauthenticated)
+	_, err = client.GetUsers(getAuthContext(apiKey+"invalid"), &proto.ListRequest{})
+	assertStatusCode(t, err, codes.Unauthenticated)
+
+	ctx := getAuthContext(apiKey)
+	_, err = client.GetUsers(ctx, &proto.ListRequest{})
+	assert.NoError(t, err)
+	// the admin has no permission to manage groups
+	_, err = client.GetGroups(ctx, &proto.ListRequest{})
+	assertStatusCode(t, err, codes.PermissionDenied)
+	_, err = client.DeleteUser(ctx, &proto.UserRequest{Username: defaultUsername})
+	assertStatusCode(t, err, codes.PermissionDenied)
+
+	admin1, apiKey1 := addTestAdmin(t, []string{dataprovider.PermAdminAny}, []string{"users:read"})
+	defer removeTestAdmin(t, admin1)
+
+	ctx = getAuthContext(apiKey1)
+	_, err = client.GetUsers(ctx, &proto.ListRequest{})
+	assert.NoError(t, err)
+	// the API key has no permission for this resource or level
+	_, err = client.GetFolders(ctx, &proto.ListRequest{})
+	assertStatusCode(t, err, codes.PermissionDenied)
+	_, err = client.DeleteUser(ctx, &proto.UserRequest{Username: defaultUsername})
+	assertStatusCode(t, err, codes.PermissionDenied)
+}
+
+func TestStreamLiveEvents(t *testing.T) {
+	admin, apiKey := addTestAdmin(t, []string{dataprovider.PermAdminAny}, nil)
+	defer removeTestAdmin(t, admin)
+
+	client, conn := getClient(t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(getAuthContext(apiKey), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamLiveEvents(ctx, &proto.LiveEventsRequest{Types: []string{"invalid"}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertStatusCode(t, err, codes.InvalidArgument)
+
+	stream, err = client.StreamLiveEvents(ctx, &proto.LiveEventsRequest{
+		Types:     []string{common.LiveEventTypeProvider},
+		Usernames: []string{admin.Username},
+	})
+	require.NoError(t, err)
+	// wait for the subscription
+	time.Sleep(100 * time.Millisecond)
+
+	u := getTestUser()
+	_, err = client.AddUser(getAuthContext(apiKey), &proto.AddUserRequest{Data: asJSON(t, u)})
+	require.NoError(t, err)
+
+	ev, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, common.LiveEventTypeProvider, ev.GetType())
+	assert.Equal(t, "add", ev.GetAction())
+	assert.Equal(t, admin.Username, ev.GetUsername())
+	assert.Equal(t, "user", ev.GetObjectType())
+	assert.Equal(t, u.Username, ev.GetObjectName())
+	assert.Greater(t, ev.GetTimestamp(), int64(0))
+	cancel()
+
+	err = dataprovider.DeleteUser(u.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestReloadBindings(t *testing.T) {
+	admin, apiKey := addTestAdmin(t, []string{dataprovider.PermAdminAny}, nil)
+	defer removeTestAdmin(t, admin)
+
+	grpcConf := config.GetGRPCDConfig()
+	grpcConf.Bindings = []grpcd.Binding{
+		{
+			Address: "127.0.0.1",
+			Port:    9099,
+		},
+		{
+			Address: "127.0.0.1",
+			Port:    9097,
+		},
+	}
+	err := grpcConf.ReloadBindings()
+	require.NoError(t, err)
+	assert.Len(t, grpcd.GetStatus().Bindings, 2)
+	waitTCPListening(grpcConf.Bindings[1].GetAddress())
+
+	conn, err := grpc.NewClient(grpcConf.Bindings[1].GetAddress(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := proto.NewAdminClient(conn)
+	_, err = client.GetUsers(getAuthContext(apiKey), &proto.ListRequest{})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(getAuthContext(apiKey), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamLiveEvents(ctx, &proto.LiveEventsRequest{
+		Types:     []string{common.LiveEventTypeProvider},
+		Usernames: []string{admin.Username},
+	})
+	require.NoError(t, err)
+	// wait for the subscription
+	time.Sleep(100 * time.Millisecond)
+	// remove the binding, the pending RPCs are not affected
+	grpcConf.Bindings = grpcConf.Bindings[:1]
+	err = grpcConf.ReloadBindings()
+	require.NoError(t, err)
+	assert.Len(t, grpcd.GetStatus().Bindings, 1)
+	assert.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", "127.0.0.1:9097")
+		if err != nil {
+			return true
+		}
+		c.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+
+	mainClient, mainConn := getClient(t)
+	defer mainConn.Close()
+
+	u := getTestUser()
+	_, err = mainClient.AddUser(getAuthContext(apiKey), &proto.AddUserRequest{Data: asJSON(t, u)})
+	require.NoError(t, err)
+
+	ev, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "add", ev.GetAction())
+	assert.Equal(t, u.Username, ev.GetObjectName())
+	cancel()
+
+	err = dataprovider.DeleteUser(u.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func assertStatusCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if assert.Error(t, err) {
+		assert.Equal(t, code, status.Code(err), err.Error())
+	}
+}
+
+func asJSON(t *testing.T, value any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return data
+}
+
+func getClient(t *testing.T) (proto.AdminClient, *grpc.ClientConn) {
+	t.Helper()
+
+	conn, err := grpc.NewClient(grpcServerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	return proto.NewAdminClient(conn), conn
+}
+
+func getAuthContext(apiKey string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-sftpgo-api-key", apiKey)
+}
+
+func addTestAdmin(t *testing.T, permissions, keyPermissions []string) (dataprovider.Admin, string) {
+	t.Helper()
+
+	admin := dataprovider.Admin{
+		Username:    defaultAdmin,
+		Password:    "password",
+		Status:      1,
+		Permissions: permissions,
+	}
+	if len(keyPermissions) > 0 {
+		admin.Username += "1"
+	}
+	admin.Filters.AllowAPIKeyAuth = true
+	err := dataprovider.AddAdmin(&admin, "", "", "")
+	require.NoError(t, err)
+	apiKey := dataprovider.APIKey{
+		Name:  admin.Username,
+		Scope: dataprovider.APIKeyScopeAdmin,
+		Admin: admin.Username,
+		Filters: dataprovider.APIKeyFilters{
+			Permissions: keyPermissions,
+		},
+	}
+	err = dataprovider.AddAPIKey(&apiKey, "", "", "")
+	require.NoError(t, err)
+	return admin, apiKey.DisplayKey()
+}
+
+func removeTestAdmin(t *testing.T, admin dataprovider.Admin) {
+	t.Helper()
+
+	// the API keys associated with the admin are removed too
+	err := dataprovider.DeleteAdmin(admin.Username, "", "", "")
+	assert.NoError(t, err)
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: defaultUsername,
+			HomeDir:  filepath.Join(homeBasePath, defaultUsername),
+			Status:   1,
+		},
+	}
+	user.Password = "password"
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Filters.S3Secret = kms.NewPlainSecret("secret")
+	return user
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
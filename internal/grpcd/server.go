@@ -0,0 +1,530 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package grpcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd/proto"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 500
+)
+
+// adminServer implements the Admin gRPC service
+type adminServer struct{}
+
+func getStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, util.ErrValidation):
+		code = codes.InvalidArgument
+	case errors.Is(err, util.ErrMethodDisabled), errors.Is(err, fs.ErrPermission):
+		code = codes.PermissionDenied
+	case errors.Is(err, util.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, dataprovider.ErrNotImplemented):
+		code = codes.Unimplemented
+	case errors.Is(err, dataprovider.ErrDuplicatedKey), errors.Is(err, dataprovider.ErrForeignKeyViolated):
+		code = codes.AlreadyExists
+	}
+	return status.Error(code, err.Error())
+}
+
+func getListParams(req *proto.ListRequest) (int, int, string, error) {
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return 0, 0, "", status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxListLimit)
+	}
+	offset := int(req.GetOffset())
+	if offset < 0 {
+		return 0, 0, "", status.Error(codes.InvalidArgument, "offset cannot be negative")
+	}
+	order := req.GetOrder()
+	if order == "" {
+		order = dataprovider.OrderASC
+	}
+	if order != dataprovider.OrderASC && order != dataprovider.OrderDESC {
+		return 0, 0, "", status.Error(codes.InvalidArgument, "order must be ASC or DESC")
+	}
+	return limit, offset, order, nil
+}
+
+func decodeJSON(data []byte, dst any) error {
+	if len(data) == 0 {
+		return status.Error(codes.InvalidArgument, "data is required")
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid data: %v", err)
+	}
+	return nil
+}
+
+func userToProto(user *dataprovider.User) (*proto.User, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	u := &proto.User{
+		Username:       user.Username,
+		Status:         int32(user.Status),
+		Email:          user.Email,
+		Description:    user.Description,
+		HomeDir:        user.HomeDir,
+		Role:           user.Role,
+		ExpirationDate: user.ExpirationDate,
+		LastLogin:      user.LastLogin,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+		QuotaSize:      user.QuotaSize,
+		QuotaFiles:     int32(user.QuotaFiles),
+		UsedQuotaSize:  user.UsedQuotaSize,
+		UsedQuotaFiles: int32(user.UsedQuotaFiles),
+		Data:           data,
+	}
+	for _, g := range user.Groups {
+		u.Groups = append(u.Groups, &proto.GroupMapping{
+			Name: g.Name,
+			Type: int32(g.Type),
+		})
+	}
+	for _, f := range user.VirtualFolders {
+		u.VirtualFolders = append(u.VirtualFolders, f.Name)
+	}
+	return u, nil
+}
+
+func groupToProto(group *dataprovider.Group) (*proto.Group, error) {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return nil, err
+	}
+	g := &proto.Group{
+		Name:        group.Name,
+		Description: group.Description,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+		Users:       group.Users,
+		Data:        data,
+	}
+	for _, f := range group.VirtualFolders {
+		g.VirtualFolders = append(g.VirtualFolders, f.Name)
+	}
+	return g, nil
+}
+
+func folderToProto(folder *vfs.BaseVirtualFolder) (*proto.Folder, error) {
+	data, err := json.Marshal(folder)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Folder{
+		Name:            folder.Name,
+		Description:     folder.Description,
+		MappedPath:      folder.MappedPath,
+		UsedQuotaSize:   folder.UsedQuotaSize,
+		UsedQuotaFiles:  int32(folder.UsedQuotaFiles),
+		LastQuotaUpdate: folder.LastQuotaUpdate,
+		Users:           folder.Users,
+		Groups:          folder.Groups,
+		Data:            data,
+	}, nil
+}
+
+func (s *adminServer) getUser(username, role string) (*proto.User, error) {
+	user, err := dataprovider.UserExists(username, role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	user.PrepareForRendering()
+	return userToProto(&user)
+}
+
+func (s *adminServer) getGroup(name string) (*proto.Group, error) {
+	group, err := dataprovider.GroupExists(name)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	group.PrepareForRendering()
+	return groupToProto(&group)
+}
+
+func (s *adminServer) getFolder(name string) (*proto.Folder, error) {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	folder.PrepareForRendering()
+	return folderToProto(&folder)
+}
+
+// GetUsers implements the Admin service
+func (s *adminServer) GetUsers(ctx context.Context, req *proto.ListRequest) (*proto.Users, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset, order, err := getListParams(req)
+	if err != nil {
+		return nil, err
+	}
+	users, err := dataprovider.GetUsers(limit, offset, order, reqCtx.admin.Role, "")
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	resp := &proto.Users{}
+	for idx := range users {
+		u, err := userToProto(&users[idx])
+		if err != nil {
+			return nil, getStatusError(err)
+		}
+		resp.Users = append(resp.Users, u)
+	}
+	return resp, nil
+}
+
+// GetUser implements the Admin service
+func (s *adminServer) GetUser(ctx context.Context, req *proto.UserRequest) (*proto.User, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.getUser(req.GetUsername(), reqCtx.admin.Role)
+}
+
+// AddUser implements the Admin service
+func (s *adminServer) AddUser(ctx context.Context, req *proto.AddUserRequest) (*proto.User, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var user dataprovider.User
+	if reqCtx.admin.Filters.Preferences.DefaultUsersExpiration > 0 {
+		user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour *
+			time.Duration(reqCtx.admin.Filters.Preferences.DefaultUsersExpiration)))
+	}
+	if err := decodeJSON(req.GetData(), &user); err != nil {
+		return nil, err
+	}
+	if reqCtx.admin.Role != "" {
+		user.Role = reqCtx.admin.Role
+	}
+	user.LastPasswordChange = 0
+	user.Filters.RecoveryCodes = nil
+	user.Filters.S3Secret = nil
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled: false,
+	}
+	if err := dataprovider.AddUser(&user, reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role); err != nil {
+		return nil, getStatusError(err)
+	}
+	return s.getUser(user.Username, reqCtx.admin.Role)
+}
+
+// UpdateUser implements the Admin service
+func (s *adminServer) UpdateUser(ctx context.Context, req *proto.UpdateUserRequest) (*proto.User, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := dataprovider.UserExists(req.GetUsername(), reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	var updatedUser dataprovider.User
+	updatedUser.Password = user.Password
+	if err := decodeJSON(req.GetData(), &updatedUser); err != nil {
+		return nil, err
+	}
+	updatedUser.ID = user.ID
+	updatedUser.Username = user.Username
+	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
+	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.S3Secret = user.Filters.S3Secret
+	updatedUser.LastPasswordChange = user.LastPasswordChange
+	updatedUser.SetEmptySecretsIfNil()
+	updatedUser.FsConfig.KeepEncryptedSecrets(&user.FsConfig)
+	if reqCtx.admin.Role != "" {
+		updatedUser.Role = reqCtx.admin.Role
+	}
+	err = dataprovider.UpdateUser(&updatedUser, reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	if req.GetDisconnect() {
+		disconnectUser(user.Username)
+	}
+	return s.getUser(user.Username, reqCtx.admin.Role)
+}
+
+// DeleteUser implements the Admin service
+func (s *adminServer) DeleteUser(ctx context.Context, req *proto.UserRequest) (*emptypb.Empty, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = dataprovider.DeleteUser(req.GetUsername(), reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	disconnectUser(dataprovider.ConvertName(req.GetUsername()))
+	return &emptypb.Empty{}, nil
+}
+
+// GetGroups implements the Admin service
+func (s *adminServer) GetGroups(_ context.Context, req *proto.ListRequest) (*proto.Groups, error) {
+	limit, offset, order, err := getListParams(req)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := dataprovider.GetGroups(limit, offset, order, false, "")
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	resp := &proto.Groups{}
+	for idx := range groups {
+		g, err := groupToProto(&groups[idx])
+		if err != nil {
+			return nil, getStatusError(err)
+		}
+		resp.Groups = append(resp.Groups, g)
+	}
+	return resp, nil
+}
+
+// GetGroup implements the Admin service
+func (s *adminServer) GetGroup(_ context.Context, req *proto.GroupRequest) (*proto.Group, error) {
+	return s.getGroup(req.GetName())
+}
+
+// AddGroup implements the Admin service
+func (s *adminServer) AddGroup(ctx context.Context, req *proto.AddGroupRequest) (*proto.Group, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var group dataprovider.Group
+	if err := decodeJSON(req.GetData(), &group); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddGroup(&group, reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role); err != nil {
+		return nil, getStatusError(err)
+	}
+	return s.getGroup(group.Name)
+}
+
+// UpdateGroup implements the Admin service
+func (s *adminServer) UpdateGroup(ctx context.Context, req *proto.UpdateGroupRequest) (*proto.Group, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	group, err := dataprovider.GroupExists(req.GetName())
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	var updatedGroup dataprovider.Group
+	if err := decodeJSON(req.GetData(), &updatedGroup); err != nil {
+		return nil, err
+	}
+	updatedGroup.ID = group.ID
+	updatedGroup.Name = group.Name
+	updatedGroup.UserSettings.FsConfig.SetEmptySecretsIfNil()
+	updatedGroup.UserSettings.FsConfig.KeepEncryptedSecrets(&group.UserSettings.FsConfig)
+	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	return s.getGroup(group.Name)
+}
+
+// DeleteGroup implements the Admin service
+func (s *adminServer) DeleteGroup(ctx context.Context, req *proto.GroupRequest) (*emptypb.Empty, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = dataprovider.DeleteGroup(req.GetName(), reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// GetFolders implements the Admin service
+func (s *adminServer) GetFolders(_ context.Context, req *proto.ListRequest) (*proto.Folders, error) {
+	limit, offset, order, err := getListParams(req)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := dataprovider.GetFolders(limit, offset, order, false, "")
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	resp := &proto.Folders{}
+	for idx := range folders {
+		f, err := folderToProto(&folders[idx])
+		if err != nil {
+			return nil, getStatusError(err)
+		}
+		resp.Folders = append(resp.Folders, f)
+	}
+	return resp, nil
+}
+
+// GetFolder implements the Admin service
+func (s *adminServer) GetFolder(_ context.Context, req *proto.FolderRequest) (*proto.Folder, error) {
+	return s.getFolder(req.GetName())
+}
+
+// AddFolder implements the Admin service
+func (s *adminServer) AddFolder(ctx context.Context, req *proto.AddFolderRequest) (*proto.Folder, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var folder vfs.BaseVirtualFolder
+	if err := decodeJSON(req.GetData(), &folder); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddFolder(&folder, reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role); err != nil {
+		return nil, getStatusError(err)
+	}
+	return s.getFolder(folder.Name)
+}
+
+// UpdateFolder implements the Admin service
+func (s *adminServer) UpdateFolder(ctx context.Context, req *proto.UpdateFolderRequest) (*proto.Folder, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := dataprovider.GetFolderByName(req.GetName())
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	var updatedFolder vfs.BaseVirtualFolder
+	if err := decodeJSON(req.GetData(), &updatedFolder); err != nil {
+		return nil, err
+	}
+	updatedFolder.ID = folder.ID
+	updatedFolder.Name = folder.Name
+	updatedFolder.FsConfig.SetEmptySecretsIfNil()
+	updatedFolder.FsConfig.KeepEncryptedSecrets(&folder.FsConfig)
+	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, reqCtx.admin.Username,
+		reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	return s.getFolder(folder.Name)
+}
+
+// DeleteFolder implements the Admin service
+func (s *adminServer) DeleteFolder(ctx context.Context, req *proto.FolderRequest) (*emptypb.Empty, error) {
+	reqCtx, err := getRequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = dataprovider.DeleteFolder(req.GetName(), reqCtx.admin.Username, reqCtx.ip, reqCtx.admin.Role)
+	if err != nil {
+		return nil, getStatusError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// StreamLiveEvents implements the Admin service
+func (s *adminServer) StreamLiveEvents(req *proto.LiveEventsRequest, stream proto.Admin_StreamLiveEventsServer) error {
+	reqCtx, err := getRequestContext(stream.Context())
+	if err != nil {
+		return err
+	}
+	filter := common.LiveEventsFilter{
+		Types:     req.GetTypes(),
+		Usernames: req.GetUsernames(),
+		Protocols: req.GetProtocols(),
+		Role:      reqCtx.admin.Role,
+	}
+	for _, t := range filter.Types {
+		if !util.Contains(common.LiveEventTypes, t) {
+			return status.Errorf(codes.InvalidArgument, "invalid event type %q", t)
+		}
+	}
+	subscription := common.SubscribeLiveEvents(filter)
+	defer common.UnsubscribeLiveEvents(subscription)
+
+	logger.Debug(logSender, "", "live events stream started for admin %q, types: %v", reqCtx.admin.Username, filter.Types)
+	for {
+		select {
+		case <-stream.Context().Done():
+			logger.Debug(logSender, "", "live events stream closed for admin %q, dropped events: %d",
+				reqCtx.admin.Username, subscription.Dropped())
+			return nil
+		case ev := <-subscription.Events():
+			err := stream.Send(&proto.LiveEvent{
+				Type:         ev.Type,
+				Action:       ev.Action,
+				Timestamp:    ev.Timestamp,
+				Username:     ev.Username,
+				Ip:           ev.IP,
+				Protocol:     ev.Protocol,
+				Role:         ev.Role,
+				ConnectionId: ev.ConnectionID,
+				VirtualPath:  ev.VirtualPath,
+				FileSize:     ev.FileSize,
+				Elapsed:      ev.Elapsed,
+				Status:       int32(ev.Status),
+				ObjectType:   ev.ObjectType,
+				ObjectName:   ev.ObjectName,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to send live event: %w", err)
+			}
+		}
+	}
+}
+
+// disconnectUser closes the connections for the specified user on this node
+func disconnectUser(username string) {
+	for _, stat := range common.Connections.GetStats("") {
+		if stat.Username == username {
+			common.Connections.Close(stat.ConnectionID, "")
+		}
+	}
+}
@@ -31,12 +31,17 @@ import (
 
 func getAdmins(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
 
-	admins, err := dataprovider.GetAdmins(limit, offset, order)
+	admins, err := dataprovider.GetAdmins(limit, offset, order, claims.Tenant)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -46,12 +51,17 @@ func getAdmins(w http.ResponseWriter, r *http.Request) {
 
 func getAdminByUsername(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	username := getURLParam(r, "username")
-	renderAdmin(w, r, username, http.StatusOK)
+	renderAdmin(w, r, username, &claims, http.StatusOK)
 }
 
-func renderAdmin(w http.ResponseWriter, r *http.Request, username string, status int) {
-	admin, err := dataprovider.AdminExists(username)
+func renderAdmin(w http.ResponseWriter, r *http.Request, username string, claims *jwtTokenClaims, status int) {
+	admin, err := adminExistsForClaims(username, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -79,13 +89,16 @@ func addAdmin(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		admin.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddAdmin(&admin, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
 	w.Header().Add("Location", fmt.Sprintf("%s/%s", adminPath, url.PathEscape(admin.Username)))
-	renderAdmin(w, r, admin.Username, http.StatusCreated)
+	renderAdmin(w, r, admin.Username, &claims, http.StatusCreated)
 }
 
 func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
@@ -95,7 +108,7 @@ func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
-	admin, err := dataprovider.AdminExists(getURLParam(r, "username"))
+	admin, err := adminExistsForClaims(getURLParam(r, "username"), &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -125,7 +138,12 @@ func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
 func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	username := getURLParam(r, "username")
-	admin, err := dataprovider.AdminExists(username)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := adminExistsForClaims(username, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -137,12 +155,6 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-
-	claims, err := getTokenClaims(r)
-	if err != nil || claims.Username == "" {
-		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
-		return
-	}
 	if username == claims.Username {
 		if claims.APIKeyID != "" {
 			sendAPIResponse(w, r, errors.New("updating the admin impersonated with an API key is not allowed"), "",
@@ -191,6 +203,10 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, errors.New("you cannot delete yourself"), "", http.StatusBadRequest)
 		return
 	}
+	if _, err := adminExistsForClaims(username, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 
 	err = dataprovider.DeleteAdmin(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
@@ -0,0 +1,64 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+func restoreUserArchivedFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	job, err := connection.RestoreArchivedFile(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore the archived file %q", name), getMappedStatusCode(err))
+		return
+	}
+	w.Header().Set("Location", path.Join(userJobsPath, job.ID))
+	resp := apiResponse{
+		Message: "Restore started",
+		JobID:   job.ID,
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusAccepted)
+	render.JSON(w, r.WithContext(ctx), resp)
+}
+
+func getUserJobByID(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	job, err := common.Jobs.GetForUser(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, job)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+const (
+	auditLogDefaultLimit = 100
+	auditLogMaxLimit     = 1000
+)
+
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var from int64
+	var err error
+	limit := auditLogDefaultLimit
+	if _, ok := r.URL.Query()["from"]; ok {
+		from, err = strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil || from < 0 {
+			sendAPIResponse(w, r, errors.New("invalid from"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["limit"]; ok {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			sendAPIResponse(w, r, errors.New("invalid limit"), "", http.StatusBadRequest)
+			return
+		}
+		if limit > auditLogMaxLimit {
+			limit = auditLogMaxLimit
+		}
+	}
+	entries, err := dataprovider.GetAuditLogEntries(from, limit)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func verifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	result, err := dataprovider.VerifyAuditLog()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
@@ -20,11 +20,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-chi/render"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -64,7 +67,14 @@ func deleteDefenderHostByID(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	if !common.DeleteDefenderHost(ip) {
+	deleted := common.DeleteDefenderHost(ip)
+	if common.IsDefenderReplicated() {
+		claims, err := getTokenClaims(r)
+		if err == nil && claims.NodeID == "" && deleteNodesDefenderHost(claims.Username, claims.Role, ip) {
+			deleted = true
+		}
+	}
+	if !deleted {
 		sendAPIResponse(w, r, nil, "Not found", http.StatusNotFound)
 		return
 	}
@@ -72,6 +82,36 @@ func deleteDefenderHostByID(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "OK", http.StatusOK)
 }
 
+// deleteNodesDefenderHost removes the specified host from the defender of the
+// other nodes, so the ban is not replicated again. Returns true if the host
+// was removed from at least one node
+func deleteNodesDefenderHost(admin, role, ip string) bool {
+	nodes, err := dataprovider.GetNodes()
+	if err != nil || len(nodes) == 0 {
+		return false
+	}
+	var deleted atomic.Bool
+	var wg sync.WaitGroup
+
+	for _, n := range nodes {
+		wg.Add(1)
+
+		go func(node dataprovider.Node) {
+			defer wg.Done()
+
+			entry := dataprovider.DefenderEntry{IP: ip}
+			if err := node.SendDeleteRequest(admin, role, fmt.Sprintf("%s/%s", defenderHosts, entry.GetID())); err != nil {
+				logger.Debug(logSender, "", "unable to delete defender host %q from node %q: %v", ip, node.Name, err)
+				return
+			}
+			deleted.Store(true)
+		}(n)
+	}
+	wg.Wait()
+
+	return deleted.Load()
+}
+
 func getIPFromID(r *http.Request) (string, error) {
 	decoded, err := hex.DecodeString(getURLParam(r, "id"))
 	if err != nil {
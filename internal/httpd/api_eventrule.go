@@ -78,6 +78,10 @@ func addEventAction(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		sendAPIResponse(w, r, errTenantEventActions, "", http.StatusForbidden)
+		return
+	}
 	var action dataprovider.BaseEventAction
 	err = render.DecodeJSON(r.Body, &action)
 	if err != nil {
@@ -101,6 +105,10 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		sendAPIResponse(w, r, errTenantEventActions, "", http.StatusForbidden)
+		return
+	}
 
 	name := getURLParam(r, "name")
 	action, err := dataprovider.EventActionExists(name)
@@ -141,6 +149,10 @@ func deleteEventAction(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		sendAPIResponse(w, r, errTenantEventActions, "", http.StatusForbidden)
+		return
+	}
 	name := getURLParam(r, "name")
 	err = dataprovider.DeleteEventAction(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
@@ -152,12 +164,17 @@ func deleteEventAction(w http.ResponseWriter, r *http.Request) {
 
 func getEventRules(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
 
-	rules, err := dataprovider.GetEventRules(limit, offset, order)
+	rules, err := dataprovider.GetEventRules(limit, offset, order, claims.Tenant)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -166,7 +183,7 @@ func getEventRules(w http.ResponseWriter, r *http.Request) {
 }
 
 func renderEventRule(w http.ResponseWriter, r *http.Request, name string, claims *jwtTokenClaims, status int) {
-	rule, err := dataprovider.EventRuleExists(name)
+	rule, err := eventRuleExistsForClaims(name, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -207,6 +224,9 @@ func addEventRule(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		rule.Tenant = claims.Tenant
+	}
 	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 	if err := dataprovider.AddEventRule(&rule, claims.Username, ipAddr, claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -224,7 +244,7 @@ func updateEventRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rule, err := dataprovider.EventRuleExists(getURLParam(r, "name"))
+	rule, err := eventRuleExistsForClaims(getURLParam(r, "name"), &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -255,6 +275,10 @@ func deleteEventRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := getURLParam(r, "name")
+	if _, err := eventRuleExistsForClaims(name, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteEventRule(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -266,7 +290,16 @@ func deleteEventRule(w http.ResponseWriter, r *http.Request) {
 func runOnDemandRule(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	name := getURLParam(r, "name")
+	if _, err := eventRuleExistsForClaims(name, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	if err := common.RunOnDemandRule(name); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
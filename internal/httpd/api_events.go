@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -23,6 +24,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-chi/render"
 	"github.com/sftpgo/sdk/plugin/eventsearcher"
 	"github.com/sftpgo/sdk/plugin/notifier"
 
@@ -31,6 +33,246 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
+const (
+	// maximum number of batches to request to the plugin to fill a page
+	// when filters not supported by the plugin are used
+	maxEventsPageIterations = 10
+	// maximum number of batches to request to the plugin to compute aggregations
+	maxEventsAggregationIterations = 100
+	eventsBatchSize                = 1000
+)
+
+// eventsCursor identifies the last returned event, it is exposed to the
+// clients as an opaque string
+type eventsCursor struct {
+	Timestamp int64  `json:"ts"`
+	ID        string `json:"id"`
+	Order     int    `json:"o"`
+}
+
+func (c *eventsCursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// apply sets the search params to continue after the event identified by the cursor.
+// If from_id is set, the plugin uses the start timestamp as cursor position for both
+// ascending and descending order
+func (c *eventsCursor) apply(params *eventsearcher.CommonSearchParams) {
+	if c == nil {
+		return
+	}
+	params.StartTimestamp = c.Timestamp
+	params.FromID = c.ID
+}
+
+func decodeEventsCursor(value string, order int) (*eventsCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, util.NewValidationError("invalid cursor")
+	}
+	var c eventsCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, util.NewValidationError("invalid cursor")
+	}
+	if c.Order != order {
+		return nil, util.NewValidationError("the cursor does not match the requested order")
+	}
+	return &c, nil
+}
+
+// eventsSearchOptions defines the search options handled by SFTPGo itself
+// and not by the eventsearcher plugin
+type eventsSearchOptions struct {
+	cursor           *eventsCursor
+	usernames        []string
+	pathPrefix       string
+	objectNamePrefix string
+	cursorPagination bool
+	aggregate        bool
+}
+
+// hasPostFilters returns true if the events returned by the plugin must be filtered
+func (o *eventsSearchOptions) hasPostFilters() bool {
+	return len(o.usernames) > 1 || o.pathPrefix != "" || o.objectNamePrefix != ""
+}
+
+func (o *eventsSearchOptions) matchUsername(username string) bool {
+	if len(o.usernames) < 2 {
+		return true
+	}
+	return util.Contains(o.usernames, username)
+}
+
+func (o *eventsSearchOptions) matchPath(p string) bool {
+	if o.pathPrefix == "" || o.pathPrefix == "/" {
+		return true
+	}
+	p = util.CleanPath(p)
+	return p == o.pathPrefix || strings.HasPrefix(p, o.pathPrefix+"/")
+}
+
+func getEventsSearchOptionsFromRequest(r *http.Request, params *eventsearcher.CommonSearchParams) (eventsSearchOptions, error) {
+	o := eventsSearchOptions{
+		cursorPagination: getBoolQueryParam(r, "cursor_pagination"),
+		aggregate:        getBoolQueryParam(r, "aggregate"),
+	}
+	if val := r.URL.Query().Get("cursor"); val != "" {
+		if params.FromID != "" {
+			return o, util.NewValidationError("cursor and from_id cannot be used together")
+		}
+		c, err := decodeEventsCursor(val, params.Order)
+		if err != nil {
+			return o, err
+		}
+		o.cursor = c
+	}
+	o.usernames = getCommaSeparatedQueryParam(r, "usernames")
+	if params.Username != "" && !util.Contains(o.usernames, params.Username) {
+		o.usernames = append(o.usernames, params.Username)
+	}
+	if len(o.usernames) == 1 {
+		params.Username = o.usernames[0]
+	}
+	if val := r.URL.Query().Get("path_prefix"); val != "" {
+		o.pathPrefix = util.CleanPath(val)
+	}
+	o.objectNamePrefix = r.URL.Query().Get("object_name_prefix")
+	return o, nil
+}
+
+// searchEventsPage returns up to limit events matching the specified filters
+// and the cursor to use to get the next page, if any.
+// If match is not nil, more batches are requested to the plugin until the
+// page is full or the maximum number of iterations is reached
+func searchEventsPage[T any](fetch func(*eventsCursor, int) ([]T, error), cursor *eventsCursor, limit, order int,
+	getCursor func(*T) eventsCursor, match func(*T) bool,
+) ([]T, *eventsCursor, error) {
+	batchSize := limit
+	iterations := 1
+	if match != nil {
+		batchSize = eventsBatchSize
+		iterations = maxEventsPageIterations
+	}
+	results := make([]T, 0, limit)
+	for i := 0; i < iterations; i++ {
+		events, err := fetch(cursor, batchSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		for idx := range events {
+			if match == nil || match(&events[idx]) {
+				results = append(results, events[idx])
+			}
+			if len(results) == limit {
+				c := getCursor(&events[idx])
+				c.Order = order
+				return results, &c, nil
+			}
+		}
+		if len(events) < batchSize {
+			return results, nil, nil
+		}
+		c := getCursor(&events[len(events)-1])
+		c.Order = order
+		cursor = &c
+	}
+	return results, cursor, nil
+}
+
+type eventsAggregations struct {
+	Total     int                       `json:"total"`
+	Truncated bool                      `json:"truncated"`
+	Counts    map[string]map[string]int `json:"counts"`
+}
+
+// aggregateEvents counts the events matching the specified filters grouped by
+// the keys returned by getKeys. The number of scanned events is limited, the
+// result is marked as truncated if the limit is reached
+func aggregateEvents[T any](fetch func(*eventsCursor, int) ([]T, error), order int,
+	getCursor func(*T) eventsCursor, match func(*T) bool, getKeys func(*T) map[string]string,
+) (*eventsAggregations, error) {
+	result := &eventsAggregations{
+		Counts: make(map[string]map[string]int),
+	}
+	var cursor *eventsCursor
+	for i := 0; i < maxEventsAggregationIterations; i++ {
+		events, err := fetch(cursor, eventsBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range events {
+			if match != nil && !match(&events[idx]) {
+				continue
+			}
+			result.Total++
+			for k, v := range getKeys(&events[idx]) {
+				if _, ok := result.Counts[k]; !ok {
+					result.Counts[k] = make(map[string]int)
+				}
+				result.Counts[k][v]++
+			}
+		}
+		if len(events) < eventsBatchSize {
+			return result, nil
+		}
+		c := getCursor(&events[len(events)-1])
+		c.Order = order
+		cursor = &c
+	}
+	result.Truncated = true
+	return result, nil
+}
+
+type eventsSearchResponse[T any] struct {
+	Events       []T                 `json:"events"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+	Aggregations *eventsAggregations `json:"aggregations,omitempty"`
+}
+
+func renderEventsSearch[T any](w http.ResponseWriter, r *http.Request, opts *eventsSearchOptions, limit, order int,
+	fetch func(*eventsCursor, int) ([]T, error), getCursor func(*T) eventsCursor, match func(*T) bool,
+	getKeys func(*T) map[string]string,
+) {
+	events, next, err := searchEventsPage(fetch, opts.cursor, limit, order, getCursor, match)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !opts.cursorPagination && !opts.aggregate {
+		render.JSON(w, r, events)
+		return
+	}
+	resp := eventsSearchResponse[T]{
+		Events: events,
+	}
+	if next != nil {
+		resp.NextCursor = next.encode()
+	}
+	if opts.aggregate {
+		resp.Aggregations, err = aggregateEvents(fetch, order, getCursor, match, getKeys)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+	render.JSON(w, r, resp)
+}
+
+func unmarshalEvents[T any](data []byte, err error) ([]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	var results []T
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 func getCommonSearchParamsFromRequest(r *http.Request) (eventsearcher.CommonSearchParams, error) {
 	c := eventsearcher.CommonSearchParams{}
 	c.Limit = 100
@@ -99,16 +341,41 @@ func getFsSearchParamsFromRequest(r *http.Request) (eventsearcher.FsEventSearch,
 	s.Protocols = getCommaSeparatedQueryParam(r, "protocols")
 	statuses := getCommaSeparatedQueryParam(r, "statuses")
 	for _, status := range statuses {
-		val, err := strconv.ParseInt(status, 10, 32)
+		vals, err := parseEventStatus(status)
 		if err != nil {
-			return s, util.NewValidationError(fmt.Sprintf("invalid status: %v", status))
+			return s, err
+		}
+		for _, val := range vals {
+			if !util.Contains(s.Statuses, val) {
+				s.Statuses = append(s.Statuses, val)
+			}
 		}
-		s.Statuses = append(s.Statuses, int32(val))
 	}
 
 	return s, nil
 }
 
+// parseEventStatus parses a status or an inclusive range of statuses, for example "1-3"
+func parseEventStatus(status string) ([]int32, error) {
+	start, end, isRange := strings.Cut(status, "-")
+	first, err := strconv.ParseInt(strings.TrimSpace(start), 10, 32)
+	if err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid status: %v", status))
+	}
+	last := first
+	if isRange {
+		last, err = strconv.ParseInt(strings.TrimSpace(end), 10, 32)
+		if err != nil || last < first || last-first > 100 {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid status range: %v", status))
+		}
+	}
+	result := make([]int32, 0, last-first+1)
+	for val := first; val <= last; val++ {
+		result = append(result, int32(val))
+	}
+	return result, nil
+}
+
 func getProviderSearchParamsFromRequest(r *http.Request) (eventsearcher.ProviderEventSearch, error) {
 	var err error
 	s := eventsearcher.ProviderEventSearch{}
@@ -154,24 +421,51 @@ func searchFsEvents(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	opts, err := getEventsSearchOptionsFromRequest(r, &filters.CommonSearchParams)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	filters.Role = getRoleFilterForEventSearch(r, claims.Role)
 
+	var match func(*fsEvent) bool
+	if opts.hasPostFilters() {
+		match = func(ev *fsEvent) bool {
+			return opts.matchUsername(ev.Username) &&
+				(opts.matchPath(ev.VirtualPath) || (ev.VirtualTargetPath != "" && opts.matchPath(ev.VirtualTargetPath)))
+		}
+	}
+
 	if getBoolQueryParam(r, "csv_export") {
 		filters.Limit = 100
-		if err := exportFsEvents(w, &filters); err != nil {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		if err := exportFsEvents(w, &filters, match); err != nil {
 			panic(http.ErrAbortHandler)
 		}
 		return
 	}
 
-	data, err := plugin.Handler.SearchFsEvents(&filters)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	if match == nil && !opts.cursorPagination && !opts.aggregate {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		data, err := plugin.Handler.SearchFsEvents(&filters)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data) //nolint:errcheck
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data) //nolint:errcheck
+	fetch := func(cursor *eventsCursor, limit int) ([]fsEvent, error) {
+		f := filters
+		f.Limit = limit
+		cursor.apply(&f.CommonSearchParams)
+		return unmarshalEvents[fsEvent](plugin.Handler.SearchFsEvents(&f))
+	}
+	renderEventsSearch(w, r, &opts, filters.Limit, filters.Order, fetch, (*fsEvent).getCursor, match,
+		(*fsEvent).getAggregationKeys)
 }
 
 func searchProviderEvents(w http.ResponseWriter, r *http.Request) {
@@ -187,26 +481,52 @@ func searchProviderEvents(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	opts, err := getEventsSearchOptionsFromRequest(r, &filters.CommonSearchParams)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	filters.Role = getRoleFilterForEventSearch(r, claims.Role)
 	filters.OmitObjectData = getBoolQueryParam(r, "omit_object_data")
 
+	var match func(*providerEvent) bool
+	if opts.hasPostFilters() {
+		match = func(ev *providerEvent) bool {
+			return opts.matchUsername(ev.Username) && strings.HasPrefix(ev.ObjectName, opts.objectNamePrefix)
+		}
+	}
+
 	if getBoolQueryParam(r, "csv_export") {
 		filters.Limit = 100
 		filters.OmitObjectData = true
-		if err := exportProviderEvents(w, &filters); err != nil {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		if err := exportProviderEvents(w, &filters, match); err != nil {
 			panic(http.ErrAbortHandler)
 		}
 		return
 	}
 
-	data, err := plugin.Handler.SearchProviderEvents(&filters)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	if match == nil && !opts.cursorPagination && !opts.aggregate {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		data, err := plugin.Handler.SearchProviderEvents(&filters)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data) //nolint:errcheck
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data) //nolint:errcheck
+	fetch := func(cursor *eventsCursor, limit int) ([]providerEvent, error) {
+		f := filters
+		f.Limit = limit
+		cursor.apply(&f.CommonSearchParams)
+		return unmarshalEvents[providerEvent](plugin.Handler.SearchProviderEvents(&f))
+	}
+	renderEventsSearch(w, r, &opts, filters.Limit, filters.Order, fetch, (*providerEvent).getCursor, match,
+		(*providerEvent).getAggregationKeys)
 }
 
 func searchLogEvents(w http.ResponseWriter, r *http.Request) {
@@ -222,27 +542,53 @@ func searchLogEvents(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	opts, err := getEventsSearchOptionsFromRequest(r, &filters.CommonSearchParams)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	filters.Role = getRoleFilterForEventSearch(r, claims.Role)
 
+	var match func(*logEvent) bool
+	if opts.hasPostFilters() {
+		match = func(ev *logEvent) bool {
+			return opts.matchUsername(ev.Username)
+		}
+	}
+
 	if getBoolQueryParam(r, "csv_export") {
 		filters.Limit = 100
-		if err := exportLogEvents(w, &filters); err != nil {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		if err := exportLogEvents(w, &filters, match); err != nil {
 			panic(http.ErrAbortHandler)
 		}
 		return
 	}
 
-	data, err := plugin.Handler.SearchLogEvents(&filters)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	if match == nil && !opts.cursorPagination && !opts.aggregate {
+		opts.cursor.apply(&filters.CommonSearchParams)
+		data, err := plugin.Handler.SearchLogEvents(&filters)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data) //nolint:errcheck
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data) //nolint:errcheck
+	fetch := func(cursor *eventsCursor, limit int) ([]logEvent, error) {
+		f := filters
+		f.Limit = limit
+		cursor.apply(&f.CommonSearchParams)
+		return unmarshalEvents[logEvent](plugin.Handler.SearchLogEvents(&f))
+	}
+	renderEventsSearch(w, r, &opts, filters.Limit, filters.Order, fetch, (*logEvent).getCursor, match,
+		(*logEvent).getAggregationKeys)
 }
 
-func exportFsEvents(w http.ResponseWriter, filters *eventsearcher.FsEventSearch) error {
+func exportFsEvents(w http.ResponseWriter, filters *eventsearcher.FsEventSearch, match func(*fsEvent) bool) error {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=fslogs-%s.csv", time.Now().Format("2006-01-02T15-04-05")))
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Accept-Ranges", "none")
@@ -264,6 +610,9 @@ func exportFsEvents(w http.ResponseWriter, filters *eventsearcher.FsEventSearch)
 			return err
 		}
 		for _, event := range results {
+			if match != nil && !match(&event) {
+				continue
+			}
 			if err := csvWriter.Write(event.getCSVData()); err != nil {
 				return err
 			}
@@ -271,15 +620,17 @@ func exportFsEvents(w http.ResponseWriter, filters *eventsearcher.FsEventSearch)
 		if len(results) == 0 || len(results) < filters.Limit {
 			break
 		}
-		filters.StartTimestamp = results[len(results)-1].Timestamp
-		filters.FromID = results[len(results)-1].ID
+		cursor := results[len(results)-1].getCursor()
+		cursor.apply(&filters.CommonSearchParams)
 		results = nil
 	}
 	csvWriter.Flush()
 	return csvWriter.Error()
 }
 
-func exportProviderEvents(w http.ResponseWriter, filters *eventsearcher.ProviderEventSearch) error {
+func exportProviderEvents(w http.ResponseWriter, filters *eventsearcher.ProviderEventSearch,
+	match func(*providerEvent) bool,
+) error {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=providerlogs-%s.csv", time.Now().Format("2006-01-02T15-04-05")))
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Accept-Ranges", "none")
@@ -301,6 +652,9 @@ func exportProviderEvents(w http.ResponseWriter, filters *eventsearcher.Provider
 			return err
 		}
 		for _, event := range results {
+			if match != nil && !match(&event) {
+				continue
+			}
 			if err := csvWriter.Write(event.getCSVData()); err != nil {
 				return err
 			}
@@ -308,15 +662,15 @@ func exportProviderEvents(w http.ResponseWriter, filters *eventsearcher.Provider
 		if len(results) < filters.Limit || len(results) == 0 {
 			break
 		}
-		filters.FromID = results[len(results)-1].ID
-		filters.StartTimestamp = results[len(results)-1].Timestamp
+		cursor := results[len(results)-1].getCursor()
+		cursor.apply(&filters.CommonSearchParams)
 		results = nil
 	}
 	csvWriter.Flush()
 	return csvWriter.Error()
 }
 
-func exportLogEvents(w http.ResponseWriter, filters *eventsearcher.LogEventSearch) error {
+func exportLogEvents(w http.ResponseWriter, filters *eventsearcher.LogEventSearch, match func(*logEvent) bool) error {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=logs-%s.csv", time.Now().Format("2006-01-02T15-04-05")))
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Accept-Ranges", "none")
@@ -338,6 +692,9 @@ func exportLogEvents(w http.ResponseWriter, filters *eventsearcher.LogEventSearc
 			return err
 		}
 		for _, event := range results {
+			if match != nil && !match(&event) {
+				continue
+			}
 			if err := csvWriter.Write(event.getCSVData()); err != nil {
 				return err
 			}
@@ -345,8 +702,8 @@ func exportLogEvents(w http.ResponseWriter, filters *eventsearcher.LogEventSearc
 		if len(results) == 0 || len(results) < filters.Limit {
 			break
 		}
-		filters.StartTimestamp = results[len(results)-1].Timestamp
-		filters.FromID = results[len(results)-1].ID
+		cursor := results[len(results)-1].getCursor()
+		cursor.apply(&filters.CommonSearchParams)
 		results = nil
 	}
 	csvWriter.Flush()
@@ -384,6 +741,19 @@ type fsEvent struct {
 	InstanceID        string `json:"instance_id,omitempty"`
 }
 
+func (e *fsEvent) getCursor() eventsCursor {
+	return eventsCursor{Timestamp: e.Timestamp, ID: e.ID}
+}
+
+func (e *fsEvent) getAggregationKeys() map[string]string {
+	return map[string]string{
+		"action":   e.Action,
+		"username": e.Username,
+		"protocol": e.Protocol,
+		"status":   strconv.Itoa(e.Status),
+	}
+}
+
 func (e *fsEvent) getCSVHeader() []string {
 	return []string{"Time", "Action", "Path", "Size", "Elapsed", "Status", "User", "Protocol",
 		"IP", "SSH command"}
@@ -431,6 +801,18 @@ type providerEvent struct {
 	InstanceID string `json:"instance_id,omitempty"`
 }
 
+func (e *providerEvent) getCursor() eventsCursor {
+	return eventsCursor{Timestamp: e.Timestamp, ID: e.ID}
+}
+
+func (e *providerEvent) getAggregationKeys() map[string]string {
+	return map[string]string{
+		"action":      e.Action,
+		"username":    e.Username,
+		"object_type": e.ObjectType,
+	}
+}
+
 func (e *providerEvent) getCSVHeader() []string {
 	return []string{"Time", "Action", "Object Type", "Object Name", "User", "IP"}
 }
@@ -442,14 +824,27 @@ func (e *providerEvent) getCSVData() []string {
 }
 
 type logEvent struct {
-	ID        string `json:"id"`
-	Timestamp int64  `json:"timestamp"`
-	Event     int    `json:"event"`
-	Protocol  string `json:"protocol"`
-	Username  string `json:"username,omitempty"`
-	IP        string `json:"ip,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Role      string `json:"role,omitempty"`
+	ID         string `json:"id"`
+	Timestamp  int64  `json:"timestamp"`
+	Event      int    `json:"event"`
+	Protocol   string `json:"protocol"`
+	Username   string `json:"username,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Role       string `json:"role,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+}
+
+func (e *logEvent) getCursor() eventsCursor {
+	return eventsCursor{Timestamp: e.Timestamp, ID: e.ID}
+}
+
+func (e *logEvent) getAggregationKeys() map[string]string {
+	return map[string]string{
+		"event":    strconv.Itoa(e.Event),
+		"username": e.Username,
+		"protocol": e.Protocol,
+	}
 }
 
 func (e *logEvent) getCSVHeader() []string {
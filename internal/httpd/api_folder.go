@@ -29,12 +29,17 @@ import (
 
 func getFolders(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
 
-	folders, err := dataprovider.GetFolders(limit, offset, order, false)
+	folders, err := dataprovider.GetFolders(limit, offset, order, false, claims.Tenant)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -57,6 +62,9 @@ func addFolder(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		folder.Tenant = claims.Tenant
+	}
 	if err := dataprovider.AddFolder(&folder, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -74,7 +82,7 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := getURLParam(r, "name")
-	folder, err := dataprovider.GetFolderByName(name)
+	folder, err := folderExistsForClaims(name, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -89,7 +97,7 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	updatedFolder.ID = folder.ID
 	updatedFolder.Name = folder.Name
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&updatedFolder.FsConfig, &folder.FsConfig)
+	updatedFolder.FsConfig.KeepEncryptedSecrets(&folder.FsConfig)
 
 	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, claims.Username,
 		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
@@ -101,7 +109,7 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 }
 
 func renderFolder(w http.ResponseWriter, r *http.Request, name string, claims *jwtTokenClaims, status int) {
-	folder, err := dataprovider.GetFolderByName(name)
+	folder, err := folderExistsForClaims(name, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -136,6 +144,10 @@ func deleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := getURLParam(r, "name")
+	if _, err := folderExistsForClaims(name, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteFolder(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -0,0 +1,521 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/graphql"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	errGraphQLInvalidContext = errors.New("invalid GraphQL request context")
+	graphQLSchema            = getGraphQLSchema()
+)
+
+type graphQLContextKey struct{}
+
+// graphQLRequestContext holds the request details needed by the resolvers
+type graphQLRequestContext struct {
+	claims               *jwtTokenClaims
+	ip                   string
+	hideConfidentialData bool
+}
+
+func getGraphQLRequestContext(ctx context.Context, perm string) (*graphQLRequestContext, error) {
+	reqCtx, ok := ctx.Value(graphQLContextKey{}).(*graphQLRequestContext)
+	if !ok {
+		return nil, errGraphQLInvalidContext
+	}
+	if perm != "" && !reqCtx.claims.hasPerm(perm) {
+		return nil, fmt.Errorf("%s, the %q permission is required", http.StatusText(http.StatusForbidden), perm)
+	}
+	return reqCtx, nil
+}
+
+func getGraphQLSearchArgs(args map[string]any) (int, int, string, error) {
+	limit, err := graphql.GetIntArg(args, "limit", 100)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if limit <= 0 || limit > 500 {
+		return 0, 0, "", util.NewValidationError("limit must be between 1 and 500")
+	}
+	offset, err := graphql.GetIntArg(args, "offset", 0)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if offset < 0 {
+		return 0, 0, "", util.NewValidationError("offset cannot be negative")
+	}
+	order := dataprovider.OrderASC
+	if _, ok := args["order"]; ok {
+		order, err = graphql.GetStringArg(args, "order")
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if order != dataprovider.OrderASC && order != dataprovider.OrderDESC {
+			return 0, 0, "", util.NewValidationError("order must be ASC or DESC")
+		}
+	}
+	return limit, offset, order, nil
+}
+
+func getGraphQLUser(reqCtx *graphQLRequestContext, username string) (dataprovider.User, error) {
+	user, err := dataprovider.UserExists(username, reqCtx.claims.Role)
+	if err != nil {
+		return user, err
+	}
+	if reqCtx.hideConfidentialData {
+		user.PrepareForRendering()
+	}
+	return user, nil
+}
+
+func getGraphQLGroup(reqCtx *graphQLRequestContext, name string) (dataprovider.Group, error) {
+	group, err := dataprovider.GroupExists(name)
+	if err != nil {
+		return group, err
+	}
+	if reqCtx.hideConfidentialData {
+		group.PrepareForRendering()
+	}
+	return group, nil
+}
+
+func getGraphQLFolder(reqCtx *graphQLRequestContext, name string) (vfs.BaseVirtualFolder, error) {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return folder, err
+	}
+	if reqCtx.hideConfidentialData {
+		folder.PrepareForRendering()
+	}
+	return folder, nil
+}
+
+func getGraphQLSchema() *graphql.Schema {
+	folderType := &graphql.Object{
+		Name:   "Folder",
+		Fields: map[string]*graphql.Field{},
+	}
+	groupType := &graphql.Object{
+		Name:   "Group",
+		Fields: map[string]*graphql.Field{},
+	}
+	userGroupType := &graphql.Object{
+		Name: "UserGroup",
+		Fields: map[string]*graphql.Field{
+			"group": {
+				Type:    groupType,
+				Resolve: resolveGraphQLUserGroup,
+			},
+		},
+	}
+	userType := &graphql.Object{
+		Name: "User",
+		Fields: map[string]*graphql.Field{
+			"groups": {
+				Type: userGroupType,
+				Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+					return source.(dataprovider.User).Groups, nil
+				},
+			},
+			"connections": {
+				Resolve: resolveGraphQLUserConnections,
+			},
+		},
+	}
+	searchArgs := []string{"limit", "offset", "order"}
+
+	return &graphql.Schema{
+		Query: &graphql.Object{
+			Name: "Query",
+			Fields: map[string]*graphql.Field{
+				"users":       {Type: userType, Args: searchArgs, Resolve: resolveGraphQLUsers},
+				"user":        {Type: userType, Args: []string{"username"}, Resolve: resolveGraphQLUser},
+				"groups":      {Type: groupType, Args: searchArgs, Resolve: resolveGraphQLGroups},
+				"group":       {Type: groupType, Args: []string{"name"}, Resolve: resolveGraphQLGroup},
+				"folders":     {Type: folderType, Args: searchArgs, Resolve: resolveGraphQLFolders},
+				"folder":      {Type: folderType, Args: []string{"name"}, Resolve: resolveGraphQLFolder},
+				"connections": {Resolve: resolveGraphQLConnections},
+			},
+		},
+		Mutation: &graphql.Object{
+			Name: "Mutation",
+			Fields: map[string]*graphql.Field{
+				"add_user":      {Type: userType, Args: []string{"user"}, Resolve: resolveGraphQLAddUser},
+				"update_user":   {Type: userType, Args: []string{"username", "user", "disconnect"}, Resolve: resolveGraphQLUpdateUser},
+				"delete_user":   {Args: []string{"username"}, Resolve: resolveGraphQLDeleteUser},
+				"add_group":     {Type: groupType, Args: []string{"group"}, Resolve: resolveGraphQLAddGroup},
+				"update_group":  {Type: groupType, Args: []string{"name", "group"}, Resolve: resolveGraphQLUpdateGroup},
+				"delete_group":  {Args: []string{"name"}, Resolve: resolveGraphQLDeleteGroup},
+				"add_folder":    {Type: folderType, Args: []string{"folder"}, Resolve: resolveGraphQLAddFolder},
+				"update_folder": {Type: folderType, Args: []string{"name", "folder"}, Resolve: resolveGraphQLUpdateFolder},
+				"delete_folder": {Args: []string{"name"}, Resolve: resolveGraphQLDeleteFolder},
+			},
+		},
+	}
+}
+
+func resolveGraphQLUsers(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewUsers)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset, order, err := getGraphQLSearchArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return dataprovider.GetUsers(limit, offset, order, reqCtx.claims.Role, "")
+}
+
+func resolveGraphQLUser(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewUsers)
+	if err != nil {
+		return nil, err
+	}
+	username, err := graphql.GetStringArg(args, "username")
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLUser(reqCtx, username)
+}
+
+func resolveGraphQLUserGroup(ctx context.Context, source any, _ map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewGroups)
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLGroup(reqCtx, source.(sdk.GroupMapping).Name)
+}
+
+func resolveGraphQLUserConnections(ctx context.Context, source any, _ map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewConnections)
+	if err != nil {
+		return nil, err
+	}
+	username := source.(dataprovider.User).Username
+	connections := make([]common.ConnectionStatus, 0)
+	for _, stat := range common.Connections.GetStats(reqCtx.claims.Role) {
+		if stat.Username == username {
+			connections = append(connections, stat)
+		}
+	}
+	return connections, nil
+}
+
+func resolveGraphQLGroups(ctx context.Context, _ any, args map[string]any) (any, error) {
+	if _, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewGroups); err != nil {
+		return nil, err
+	}
+	limit, offset, order, err := getGraphQLSearchArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return dataprovider.GetGroups(limit, offset, order, false, "")
+}
+
+func resolveGraphQLGroup(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewGroups)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLGroup(reqCtx, name)
+}
+
+func resolveGraphQLFolders(ctx context.Context, _ any, args map[string]any) (any, error) {
+	if _, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewFolders); err != nil {
+		return nil, err
+	}
+	limit, offset, order, err := getGraphQLSearchArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return dataprovider.GetFolders(limit, offset, order, false, "")
+}
+
+func resolveGraphQLFolder(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewFolders)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLFolder(reqCtx, name)
+}
+
+func resolveGraphQLConnections(ctx context.Context, _ any, _ map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminViewConnections)
+	if err != nil {
+		return nil, err
+	}
+	return common.Connections.GetStats(reqCtx.claims.Role), nil
+}
+
+func resolveGraphQLAddUser(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminAddUsers)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := dataprovider.AdminExists(reqCtx.claims.Username)
+	if err != nil {
+		return nil, err
+	}
+	var user dataprovider.User
+	if admin.Filters.Preferences.DefaultUsersExpiration > 0 {
+		user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(admin.Filters.Preferences.DefaultUsersExpiration)))
+	}
+	if err := graphql.DecodeArg(args, "user", &user); err != nil {
+		return nil, err
+	}
+	if reqCtx.claims.Role != "" {
+		user.Role = reqCtx.claims.Role
+	}
+	user.LastPasswordChange = 0
+	user.Filters.RecoveryCodes = nil
+	user.Filters.S3Secret = nil
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled: false,
+	}
+	if err := dataprovider.AddUser(&user, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	return getGraphQLUser(reqCtx, user.Username)
+}
+
+func resolveGraphQLUpdateUser(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminChangeUsers)
+	if err != nil {
+		return nil, err
+	}
+	username, err := graphql.GetStringArg(args, "username")
+	if err != nil {
+		return nil, err
+	}
+	disconnect, err := graphql.GetBoolArg(args, "disconnect")
+	if err != nil {
+		return nil, err
+	}
+	user, err := dataprovider.UserExists(username, reqCtx.claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	var updatedUser dataprovider.User
+	updatedUser.Password = user.Password
+	if err := graphql.DecodeArg(args, "user", &updatedUser); err != nil {
+		return nil, err
+	}
+	updatedUser.ID = user.ID
+	updatedUser.Username = user.Username
+	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
+	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.S3Secret = user.Filters.S3Secret
+	updatedUser.LastPasswordChange = user.LastPasswordChange
+	updatedUser.SetEmptySecretsIfNil()
+	updatedUser.FsConfig.KeepEncryptedSecrets(&user.FsConfig)
+	if reqCtx.claims.Role != "" {
+		updatedUser.Role = reqCtx.claims.Role
+	}
+	err = dataprovider.UpdateUser(&updatedUser, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	if disconnect {
+		disconnectUser(user.Username, reqCtx.claims.Username, reqCtx.claims.Role)
+	}
+	return getGraphQLUser(reqCtx, user.Username)
+}
+
+func resolveGraphQLDeleteUser(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminDeleteUsers)
+	if err != nil {
+		return nil, err
+	}
+	username, err := graphql.GetStringArg(args, "username")
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.DeleteUser(username, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	disconnectUser(dataprovider.ConvertName(username), reqCtx.claims.Username, reqCtx.claims.Role)
+	return true, nil
+}
+
+func resolveGraphQLAddGroup(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminAddGroups)
+	if err != nil {
+		return nil, err
+	}
+	var group dataprovider.Group
+	if err := graphql.DecodeArg(args, "group", &group); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddGroup(&group, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	return getGraphQLGroup(reqCtx, group.Name)
+}
+
+func resolveGraphQLUpdateGroup(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminChangeGroups)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	group, err := dataprovider.GroupExists(name)
+	if err != nil {
+		return nil, err
+	}
+	var updatedGroup dataprovider.Group
+	if err := graphql.DecodeArg(args, "group", &updatedGroup); err != nil {
+		return nil, err
+	}
+	updatedGroup.ID = group.ID
+	updatedGroup.Name = group.Name
+	updatedGroup.UserSettings.FsConfig.SetEmptySecretsIfNil()
+	updatedGroup.UserSettings.FsConfig.KeepEncryptedSecrets(&group.UserSettings.FsConfig)
+	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLGroup(reqCtx, group.Name)
+}
+
+func resolveGraphQLDeleteGroup(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminDeleteGroups)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.DeleteGroup(name, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func resolveGraphQLAddFolder(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminAddFolders)
+	if err != nil {
+		return nil, err
+	}
+	var folder vfs.BaseVirtualFolder
+	if err := graphql.DecodeArg(args, "folder", &folder); err != nil {
+		return nil, err
+	}
+	if err := dataprovider.AddFolder(&folder, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	return getGraphQLFolder(reqCtx, folder.Name)
+}
+
+func resolveGraphQLUpdateFolder(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminChangeFolders)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return nil, err
+	}
+	var updatedFolder vfs.BaseVirtualFolder
+	if err := graphql.DecodeArg(args, "folder", &updatedFolder); err != nil {
+		return nil, err
+	}
+	updatedFolder.ID = folder.ID
+	updatedFolder.Name = folder.Name
+	updatedFolder.FsConfig.SetEmptySecretsIfNil()
+	updatedFolder.FsConfig.KeepEncryptedSecrets(&folder.FsConfig)
+	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, reqCtx.claims.Username,
+		reqCtx.ip, reqCtx.claims.Role)
+	if err != nil {
+		return nil, err
+	}
+	return getGraphQLFolder(reqCtx, folder.Name)
+}
+
+func resolveGraphQLDeleteFolder(ctx context.Context, _ any, args map[string]any) (any, error) {
+	reqCtx, err := getGraphQLRequestContext(ctx, dataprovider.PermAdminDeleteFolders)
+	if err != nil {
+		return nil, err
+	}
+	name, err := graphql.GetStringArg(args, "name")
+	if err != nil {
+		return nil, err
+	}
+	if err := dataprovider.DeleteFolder(name, reqCtx.claims.Username, reqCtx.ip, reqCtx.claims.Role); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func executeGraphQL(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	// the GraphQL API is not tenant aware and it is reserved to global admins
+	if claims.Tenant != "" {
+		sendAPIResponse(w, r, nil, "GraphQL API is not available for tenant admins", http.StatusForbidden)
+		return
+	}
+	var req graphql.Request
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(r.Context(), graphQLContextKey{}, &graphQLRequestContext{
+		claims:               &claims,
+		ip:                   util.GetIPFromRemoteAddress(r.RemoteAddr),
+		hideConfidentialData: hideConfidentialData(&claims, r),
+	})
+	resp := graphQLSchema.Execute(ctx, req)
+	if resp.HasRequestError() {
+		ctx = context.WithValue(r.Context(), render.StatusCtxKey, http.StatusBadRequest)
+		render.JSON(w, r.WithContext(ctx), resp)
+		return
+	}
+	render.JSON(w, r, resp)
+}
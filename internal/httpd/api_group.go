@@ -28,12 +28,17 @@ import (
 
 func getGroups(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
 
-	groups, err := dataprovider.GetGroups(limit, offset, order, false)
+	groups, err := dataprovider.GetGroups(limit, offset, order, false, claims.Tenant)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -55,6 +60,9 @@ func addGroup(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		group.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddGroup(&group, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -73,7 +81,7 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := getURLParam(r, "name")
-	group, err := dataprovider.GroupExists(name)
+	group, err := groupExistsForClaims(name, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -88,7 +96,7 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	updatedGroup.ID = group.ID
 	updatedGroup.Name = group.Name
 	updatedGroup.UserSettings.FsConfig.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
+	updatedGroup.UserSettings.FsConfig.KeepEncryptedSecrets(&group.UserSettings.FsConfig)
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
 		claims.Role)
 	if err != nil {
@@ -99,7 +107,7 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func renderGroup(w http.ResponseWriter, r *http.Request, name string, claims *jwtTokenClaims, status int) {
-	group, err := dataprovider.GroupExists(name)
+	group, err := groupExistsForClaims(name, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -134,6 +142,10 @@ func deleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := getURLParam(r, "name")
+	if _, err := groupExistsForClaims(name, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteGroup(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
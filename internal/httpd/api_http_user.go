@@ -32,6 +32,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -53,6 +54,7 @@ func getUserConnection(w http.ResponseWriter, r *http.Request) (*Connection, err
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return nil, err
 	}
+	restrictUserForOAuth2(&user, claims)
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
 			r.RemoteAddr, user),
@@ -301,7 +303,7 @@ func doUploadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 		sendAPIResponse(w, r, err, fmt.Sprintf("Error closing file %q", filePath), getMappedStatusCode(err))
 		return err
 	}
-	setModificationTimeFromHeader(r, connection, filePath)
+	setModificationTime(connection, filePath, r.Header.Get(mTimeHeader))
 	sendAPIResponse(w, r, nil, "Upload completed", http.StatusCreated)
 	return nil
 }
@@ -432,6 +434,11 @@ func getUserFilesAsZipStream(w http.ResponseWriter, r *http.Request) {
 	}
 	defer common.Connections.Remove(connection.GetID())
 
+	method, err := getZipCompressionMethod(r.URL.Query().Get("compression"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 	var filesList []string
 	err = render.DecodeJSON(r.Body, &filesList)
 	if err != nil {
@@ -448,7 +455,7 @@ func getUserFilesAsZipStream(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"",
 		getCompressedFileName(connection.GetUsername(), filesList)))
-	renderCompressedFiles(w, connection, baseDir, filesList, nil)
+	renderCompressedFiles(w, connection, baseDir, filesList, nil, method)
 }
 
 func getUserProfile(w http.ResponseWriter, r *http.Request) {
@@ -517,6 +524,34 @@ func updateUserProfile(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, err, "Profile updated", http.StatusOK)
 }
 
+func issueUserSSHCert(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req sshCertRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	cert, err := sftpd.IssueUserCertificate(&user, req.PublicKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	dataprovider.NotifySSHUserCertificateIssued(cert, user.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
+		user.Role)
+	render.JSON(w, r, cert)
+}
+
 func changeUserPassword(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -565,8 +600,7 @@ func doChangeUserPassword(r *http.Request, currentPassword, newPassword, confirm
 		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 }
 
-func setModificationTimeFromHeader(r *http.Request, c *Connection, filePath string) {
-	mTimeString := r.Header.Get(mTimeHeader)
+func setModificationTime(c *Connection, filePath, mTimeString string) {
 	if mTimeString != "" {
 		// we don't return an error here if we fail to set the modification time
 		mTime, err := strconv.ParseInt(mTimeString, 10, 64)
@@ -0,0 +1,110 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// canAccessJob returns true if the admin identified by the given claims can
+// view or cancel the specified job. Admins without the "*" permission can
+// only access the jobs they started
+func canAccessJob(claims *jwtTokenClaims, job *common.Job) bool {
+	if util.Contains(claims.Permissions, dataprovider.PermAdminAny) {
+		return true
+	}
+	return job.Admin == claims.Username
+}
+
+func getJobs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	jobs := common.Jobs.List(claims.Role)
+	result := make([]common.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if canAccessJob(&claims, &job) {
+			result = append(result, job)
+		}
+	}
+	render.JSON(w, r, result)
+}
+
+func getJobByID(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	job, err := common.Jobs.Get(getURLParam(r, "id"), claims.Role)
+	if err == nil && !canAccessJob(&claims, &job) {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", job.ID))
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	job, err := common.Jobs.Get(getURLParam(r, "id"), claims.Role)
+	if err == nil && !canAccessJob(&claims, &job) {
+		err = util.NewRecordNotFoundError(fmt.Sprintf("job %q does not exist", job.ID))
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if err := common.Jobs.Cancel(job.ID, claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Job canceled", http.StatusOK)
+}
+
+// startJob starts an asynchronous job and sends an accepted response
+// with the job ID and its location
+func startJob(w http.ResponseWriter, r *http.Request, claims *jwtTokenClaims, jobType, message string,
+	cancelable bool, fn common.JobFunc,
+) {
+	job := common.Jobs.Start(jobType, claims.Username, claims.Role, cancelable, fn)
+	w.Header().Set("Location", path.Join(jobsPath, job.ID))
+	resp := apiResponse{
+		Message: message,
+		JobID:   job.ID,
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusAccepted)
+	render.JSON(w, r.WithContext(ctx), resp)
+}
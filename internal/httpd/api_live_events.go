@@ -0,0 +1,118 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	liveEventsWriteTimeout = 10 * time.Second
+)
+
+var errLiveEventsOrigin = errors.New("cross origin WebSocket connections are not allowed")
+
+func getLiveEventsFilter(r *http.Request, role string) (common.LiveEventsFilter, error) {
+	filter := common.LiveEventsFilter{
+		Types:     getCommaSeparatedQueryParam(r, "types"),
+		Usernames: getCommaSeparatedQueryParam(r, "usernames"),
+		Protocols: getCommaSeparatedQueryParam(r, "protocols"),
+		Role:      role,
+	}
+	for _, t := range filter.Types {
+		if !util.Contains(common.LiveEventTypes, t) {
+			return filter, util.NewValidationError(fmt.Sprintf("invalid event type %q", t))
+		}
+	}
+	return filter, nil
+}
+
+// checkLiveEventsOrigin rejects the WebSocket handshake if the Origin header,
+// sent by browsers, does not match the requested host. This prevents cross-site
+// WebSocket hijacking for cookie based authentication
+func checkLiveEventsOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != r.Host {
+		return errLiveEventsOrigin
+	}
+	config.Origin = origin
+	return nil
+}
+
+func streamLiveEvents(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	filter, err := getLiveEventsFilter(r, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	server := websocket.Server{
+		Handshake: checkLiveEventsOrigin,
+		Handler: func(ws *websocket.Conn) {
+			handleLiveEventsConn(ws, filter, claims.Username)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func handleLiveEventsConn(ws *websocket.Conn, filter common.LiveEventsFilter, admin string) {
+	defer ws.Close()
+
+	subscription := common.SubscribeLiveEvents(filter)
+	defer common.UnsubscribeLiveEvents(subscription)
+
+	logger.Debug(logSender, "", "live events stream started for admin %q, types: %v", admin, filter.Types)
+	// we don't expect messages from the client, we read to detect when the
+	// connection is closed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		ws.SetReadDeadline(time.Time{}) //nolint:errcheck
+		io.Copy(io.Discard, ws)         //nolint:errcheck
+	}()
+
+	for {
+		select {
+		case <-closed:
+			logger.Debug(logSender, "", "live events stream closed for admin %q, dropped events: %d",
+				admin, subscription.Dropped())
+			return
+		case ev := <-subscription.Events():
+			ws.SetWriteDeadline(time.Now().Add(liveEventsWriteTimeout)) //nolint:errcheck
+			if err := websocket.JSON.Send(ws, ev); err != nil {
+				logger.Debug(logSender, "", "unable to send live event to admin %q: %v", admin, err)
+				return
+			}
+		}
+	}
+}
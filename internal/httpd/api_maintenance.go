@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -82,21 +83,54 @@ func dumpData(w http.ResponseWriter, r *http.Request) {
 		logger.Debug(logSender, "", "dumping data to: %q", outputFile)
 	}
 
-	backup, err := dataprovider.DumpData(scopes)
-	if err != nil {
-		logger.Error(logSender, "", "dumping data error: %v, output file: %q", err, outputFile)
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	if getBoolQueryParam(r, "async") {
+		if outputData == "1" {
+			sendAPIResponse(w, r, errors.New("output-data is not supported for asynchronous backups"), "",
+				http.StatusBadRequest)
+			return
+		}
+		claims, err := getTokenClaims(r)
+		if err != nil || claims.Username == "" {
+			sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+			return
+		}
+		startJob(w, r, &claims, common.JobTypeBackup, "Backup started", false,
+			func(_ context.Context, _ func(int)) (any, error) {
+				if err := writeBackupFile(outputFile, indent == "1", scopes); err != nil {
+					return nil, err
+				}
+				return map[string]string{"output_file": filepath.Base(outputFile)}, nil
+			})
 		return
 	}
 
 	if outputData == "1" {
+		backup, err := dataprovider.DumpData(scopes)
+		if err != nil {
+			logger.Error(logSender, "", "dumping data error: %v, output file: %q", err, outputFile)
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
 		w.Header().Set("Content-Disposition", "attachment; filename=\"sftpgo-backup.json\"")
 		render.JSON(w, r, backup)
 		return
 	}
 
+	if err := writeBackupFile(outputFile, indent == "1", scopes); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Data saved", http.StatusOK)
+}
+
+func writeBackupFile(outputFile string, indent bool, scopes []string) error {
+	backup, err := dataprovider.DumpData(scopes)
+	if err != nil {
+		logger.Error(logSender, "", "dumping data error: %v, output file: %q", err, outputFile)
+		return err
+	}
 	var dump []byte
-	if indent == "1" {
+	if indent {
 		dump, err = json.MarshalIndent(backup, "", "  ")
 	} else {
 		dump, err = json.Marshal(backup)
@@ -106,11 +140,10 @@ func dumpData(w http.ResponseWriter, r *http.Request) {
 	}
 	if err != nil {
 		logger.Warn(logSender, "", "dumping data error: %v, output file: %q", err, outputFile)
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
+		return err
 	}
-	logger.Debug(logSender, "", "dumping data completed, output file: %q, error: %v", outputFile, err)
-	sendAPIResponse(w, r, err, "Data saved", http.StatusOK)
+	logger.Debug(logSender, "", "dumping data completed, output file: %q", outputFile)
+	return nil
 }
 
 func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
@@ -141,6 +174,29 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
 }
 
+func reloadConfig(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if fnReloadConfig == nil {
+		sendAPIResponse(w, r, nil, "Configuration reload is not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := fnReloadConfig(); err != nil {
+		sendAPIResponse(w, r, err, "Unable to reload the configuration", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Configuration reloaded", http.StatusOK)
+}
+
+func getPasswordHashesReport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	report, err := dataprovider.GetPasswordHashesReport()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, report)
+}
+
 func loadData(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -198,6 +254,10 @@ func restoreBackup(content []byte, inputFile string, scanQuota, mode int, execut
 		return err
 	}
 
+	if err = RestoreTenants(dump.Tenants, inputFile, mode, executor, ipAddress); err != nil {
+		return err
+	}
+
 	if err = RestoreRoles(dump.Roles, inputFile, mode, executor, ipAddress, role); err != nil {
 		return err
 	}
@@ -288,7 +348,7 @@ func RestoreFolders(folders []vfs.BaseVirtualFolder, inputFile string, mode, sca
 		if scanQuota >= 1 {
 			if common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
 				logger.Debug(logSender, "", "starting quota scan for restored folder: %q", folder.Name)
-				go doFolderQuotaScan(folder) //nolint:errcheck
+				go doFolderQuotaScan(folder, false) //nolint:errcheck
 			}
 		}
 	}
@@ -477,6 +537,30 @@ func RestoreIPListEntries(entries []dataprovider.IPListEntry, inputFile string,
 	return nil
 }
 
+// RestoreTenants restores the specified tenants
+func RestoreTenants(tenants []dataprovider.Tenant, inputFile string, mode int, executor, ipAddress string) error {
+	for idx := range tenants {
+		tenant := tenants[idx]
+		t, err := dataprovider.TenantExists(tenant.Name)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing tenant %q not updated", t.Name)
+				continue
+			}
+			tenant.ID = t.ID
+			err = dataprovider.UpdateTenant(&tenant, executor, ipAddress)
+			logger.Debug(logSender, "", "restoring existing tenant: %q, dump file: %q, error: %v", tenant.Name, inputFile, err)
+		} else {
+			err = dataprovider.AddTenant(&tenant, executor, ipAddress)
+			logger.Debug(logSender, "", "adding new tenant: %q, dump file: %q, error: %v", tenant.Name, inputFile, err)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to restore tenant %q: %w", tenant.Name, err)
+		}
+	}
+	return nil
+}
+
 // RestoreRoles restores the specified roles
 func RestoreRoles(roles []dataprovider.Role, inputFile string, mode int, executor, ipAddress, executorRole string) error {
 	for idx := range roles {
@@ -553,7 +637,7 @@ func RestoreUsers(users []dataprovider.User, inputFile string, mode, scanQuota i
 		if scanQuota == 1 || (scanQuota == 2 && user.HasQuotaRestrictions()) {
 			if common.QuotaScans.AddUserQuotaScan(user.Username, user.Role) {
 				logger.Debug(logSender, "", "starting quota scan for restored user: %q", user.Username)
-				go doUserQuotaScan(user) //nolint:errcheck
+				go doUserQuotaScan(user, false) //nolint:errcheck
 			}
 		}
 	}
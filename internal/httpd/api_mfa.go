@@ -256,6 +256,35 @@ func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, recoveryCodes)
 }
 
+func (s *httpdServer) getTrustedDevices(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	devices, err := listTrustedDevices(r, s.csrfTokenAuth, claims.Username, !claims.hasUserAudience())
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to get trusted devices", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, devices)
+}
+
+func revokeTrustedDevice(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if err := deleteTrustedDevice(getURLParam(r, "id"), claims.Username, !claims.hasUserAudience()); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Trusted device revoked", http.StatusOK)
+}
+
 func getNewRecoveryCode() string {
 	return fmt.Sprintf("RC-%v", strings.ToUpper(util.GenerateUniqueID()))
 }
@@ -0,0 +1,99 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getOAuth2Apps(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	apps, err := dataprovider.GetOAuth2Apps()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	for idx := range apps {
+		apps[idx].HideConfidentialData()
+	}
+	render.JSON(w, r, apps)
+}
+
+func getOAuth2AppByID(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	app, err := dataprovider.OAuth2AppExists(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	app.HideConfidentialData()
+
+	render.JSON(w, r, app)
+}
+
+func addOAuth2App(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var app dataprovider.OAuth2App
+	err = render.DecodeJSON(r.Body, &app)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddOAuth2App(&app, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	response := make(map[string]string)
+	response["message"] = "OAuth2 app created. This is the only time the client secret is visible, please save it."
+	response["client_id"] = app.ClientID
+	response["client_secret"] = app.GetPlainSecret()
+	w.Header().Add("Location", fmt.Sprintf("%s/%s", oauth2AppsPath, url.PathEscape(app.ClientID)))
+	w.Header().Add("X-Object-ID", app.ClientID)
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusCreated)
+	render.JSON(w, r.WithContext(ctx), response)
+}
+
+func deleteOAuth2App(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	err = dataprovider.DeleteOAuth2App(getURLParam(r, "id"), claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
+		claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "OAuth2 app deleted", http.StatusOK)
+}
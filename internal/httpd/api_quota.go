@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -113,6 +114,9 @@ func updateUserTransferQuotaUsage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(getURLParam(r, "username"), claims.Role)
+	if err == nil {
+		err = checkTenantAccess(&claims, "user", user.Username, user.Tenant)
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -148,6 +152,9 @@ func doUpdateUserQuotaUsage(w http.ResponseWriter, r *http.Request, username str
 		return
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(username, claims.Role)
+	if err == nil {
+		err = checkTenantAccess(&claims, "user", user.Username, user.Tenant)
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -171,6 +178,11 @@ func doUpdateUserQuotaUsage(w http.ResponseWriter, r *http.Request, username str
 }
 
 func doUpdateFolderQuotaUsage(w http.ResponseWriter, r *http.Request, name string, usage quotaUsage) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	if usage.UsedQuotaFiles < 0 || usage.UsedQuotaSize < 0 {
 		sendAPIResponse(w, r, errors.New("invalid used quota parameters, negative values are not allowed"),
 			"", http.StatusBadRequest)
@@ -181,7 +193,7 @@ func doUpdateFolderQuotaUsage(w http.ResponseWriter, r *http.Request, name strin
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	folder, err := dataprovider.GetFolderByName(name)
+	folder, err := folderExistsForClaims(name, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -210,6 +222,9 @@ func doStartUserQuotaScan(w http.ResponseWriter, r *http.Request, username strin
 		return
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(username, claims.Role)
+	if err == nil {
+		err = checkTenantAccess(&claims, "user", user.Username, user.Tenant)
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -219,8 +234,11 @@ func doStartUserQuotaScan(w http.ResponseWriter, r *http.Request, username strin
 			http.StatusConflict)
 		return
 	}
-	go doUserQuotaScan(user) //nolint:errcheck
-	sendAPIResponse(w, r, err, "Scan started", http.StatusAccepted)
+	incremental := getBoolQueryParam(r, "incremental")
+	startJob(w, r, &claims, common.JobTypeUserQuotaScan, "Scan started", false,
+		func(_ context.Context, _ func(int)) (any, error) {
+			return doUserQuotaScan(user, incremental)
+		})
 }
 
 func doStartFolderQuotaScan(w http.ResponseWriter, r *http.Request, name string) {
@@ -228,7 +246,12 @@ func doStartFolderQuotaScan(w http.ResponseWriter, r *http.Request, name string)
 		sendAPIResponse(w, r, nil, "Quota tracking is disabled!", http.StatusForbidden)
 		return
 	}
-	folder, err := dataprovider.GetFolderByName(name)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	folder, err := folderExistsForClaims(name, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -238,36 +261,46 @@ func doStartFolderQuotaScan(w http.ResponseWriter, r *http.Request, name string)
 			http.StatusConflict)
 		return
 	}
-	go doFolderQuotaScan(folder) //nolint:errcheck
-	sendAPIResponse(w, r, err, "Scan started", http.StatusAccepted)
+	incremental := getBoolQueryParam(r, "incremental")
+	startJob(w, r, &claims, common.JobTypeFolderQuotaScan, "Scan started", false,
+		func(_ context.Context, _ func(int)) (any, error) {
+			return doFolderQuotaScan(folder, incremental)
+		})
 }
 
-func doUserQuotaScan(user dataprovider.User) error {
+func doUserQuotaScan(user dataprovider.User, incremental bool) (*quotaUsage, error) {
 	defer common.QuotaScans.RemoveUserQuotaScan(user.Username)
-	numFiles, size, err := user.ScanQuota()
+	numFiles, size, err := user.ScanQuota(incremental)
 	if err != nil {
 		logger.Warn(logSender, "", "error scanning user quota %q: %v", user.Username, err)
-		return err
+		return nil, err
 	}
+	common.ResetDirQuotaUsages(&user)
 	err = dataprovider.UpdateUserQuota(&user, numFiles, size, true)
-	logger.Debug(logSender, "", "user quota scanned, user: %q, error: %v", user.Username, err)
-	return err
+	logger.Debug(logSender, "", "user quota scanned, user: %q, incremental: %t, error: %v", user.Username, incremental, err)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaUsage{UsedQuotaSize: size, UsedQuotaFiles: numFiles}, nil
 }
 
-func doFolderQuotaScan(folder vfs.BaseVirtualFolder) error {
+func doFolderQuotaScan(folder vfs.BaseVirtualFolder, incremental bool) (*quotaUsage, error) {
 	defer common.QuotaScans.RemoveVFolderQuotaScan(folder.Name)
 	f := vfs.VirtualFolder{
 		BaseVirtualFolder: folder,
 		VirtualPath:       "/",
 	}
-	numFiles, size, err := f.ScanQuota()
+	numFiles, size, err := f.ScanQuota(incremental)
 	if err != nil {
 		logger.Warn(logSender, "", "error scanning folder %q: %v", folder.Name, err)
-		return err
+		return nil, err
 	}
 	err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
-	logger.Debug(logSender, "", "virtual folder %q scanned, error: %v", folder.Name, err)
-	return err
+	logger.Debug(logSender, "", "virtual folder %q scanned, incremental: %t, error: %v", folder.Name, incremental, err)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaUsage{UsedQuotaSize: size, UsedQuotaFiles: numFiles}, nil
 }
 
 func getQuotaUpdateMode(r *http.Request) (string, error) {
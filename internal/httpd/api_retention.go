@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -76,6 +77,8 @@ func startRetentionCheck(w http.ResponseWriter, r *http.Request) {
 			http.StatusConflict)
 		return
 	}
-	go c.Start() //nolint:errcheck
-	sendAPIResponse(w, r, err, "Check started", http.StatusAccepted)
+	startJob(w, r, &claims, common.JobTypeRetentionCheck, "Check started", false,
+		func(_ context.Context, _ func(int)) (any, error) {
+			return nil, c.Start()
+		})
 }
@@ -28,12 +28,17 @@ import (
 
 func getRoles(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
 
-	roles, err := dataprovider.GetRoles(limit, offset, order, false)
+	roles, err := dataprovider.GetRoles(limit, offset, order, false, claims.Tenant)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -56,12 +61,15 @@ func addRole(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	if claims.Tenant != "" {
+		role.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddRole(&role, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	} else {
 		w.Header().Add("Location", fmt.Sprintf("%s/%s", rolesPath, url.PathEscape(role.Name)))
-		renderRole(w, r, role.Name, http.StatusCreated)
+		renderRole(w, r, role.Name, &claims, http.StatusCreated)
 	}
 }
 
@@ -74,7 +82,7 @@ func updateRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := getURLParam(r, "name")
-	role, err := dataprovider.RoleExists(name)
+	role, err := roleExistsForClaims(name, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -97,8 +105,8 @@ func updateRole(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "Role updated", http.StatusOK)
 }
 
-func renderRole(w http.ResponseWriter, r *http.Request, name string, status int) {
-	role, err := dataprovider.RoleExists(name)
+func renderRole(w http.ResponseWriter, r *http.Request, name string, claims *jwtTokenClaims, status int) {
+	role, err := roleExistsForClaims(name, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -113,8 +121,13 @@ func renderRole(w http.ResponseWriter, r *http.Request, name string, status int)
 
 func getRoleByName(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
 	name := getURLParam(r, "name")
-	renderRole(w, r, name, http.StatusOK)
+	renderRole(w, r, name, &claims, http.StatusOK)
 }
 
 func deleteRole(w http.ResponseWriter, r *http.Request) {
@@ -125,6 +138,10 @@ func deleteRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name := getURLParam(r, "name")
+	if _, err := roleExistsForClaims(name, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteRole(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -0,0 +1,123 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var errS3ProtocolDenied = errors.New("the S3 protocol is not allowed for this user")
+
+type s3Credentials struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// getNewS3SecretAccessKey returns a random secret with the same length as
+// the AWS generated ones
+func getNewS3SecretAccessKey() string {
+	return base64.RawURLEncoding.EncodeToString(util.GenerateRandomBytes(30))
+}
+
+func setUserS3Credentials(user *dataprovider.User, executor, ipAddress, role string) (s3Credentials, error) {
+	secret := getNewS3SecretAccessKey()
+	user.Filters.S3Secret = kms.NewPlainSecret(secret)
+	if err := dataprovider.UpdateUser(user, executor, ipAddress, role); err != nil {
+		return s3Credentials{}, err
+	}
+	return s3Credentials{
+		AccessKeyID:     user.Username,
+		SecretAccessKey: secret,
+	}, nil
+}
+
+func generateUserS3Credentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := userExistsForClaims(getURLParam(r, "username"), &claims)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	credentials, err := setUserS3Credentials(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
+		claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, credentials)
+}
+
+func revokeUserS3Credentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := userExistsForClaims(getURLParam(r, "username"), &claims)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !user.HasS3Credentials() {
+		sendAPIResponse(w, r, nil, "S3 credentials are not configured", http.StatusBadRequest)
+		return
+	}
+	user.Filters.S3Secret = nil
+	if err := dataprovider.UpdateUser(&user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "S3 credentials revoked", http.StatusOK)
+}
+
+func generateS3Credentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, userMerged, err := dataprovider.GetUserVariants(claims.Username, "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if util.Contains(userMerged.Filters.DeniedProtocols, common.ProtocolS3) {
+		sendAPIResponse(w, r, errS3ProtocolDenied, "", http.StatusForbidden)
+		return
+	}
+	credentials, err := setUserS3Credentials(&user, dataprovider.ActionExecutorSelf,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), user.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, credentials)
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func getSearchQueryFromRequest(r *http.Request) (common.SearchQuery, error) {
+	q := r.URL.Query()
+	query := common.SearchQuery{
+		Name:    q.Get("name"),
+		Path:    q.Get("path"),
+		Type:    q.Get("type"),
+		Content: q.Get("content"),
+	}
+	for _, ext := range q["ext"] {
+		query.Extensions = append(query.Extensions, strings.Split(ext, ",")...)
+	}
+	int64Params := map[string]*int64{
+		"min_size":        &query.MinSize,
+		"max_size":        &query.MaxSize,
+		"modified_after":  &query.ModifiedAfter,
+		"modified_before": &query.ModifiedBefore,
+	}
+	for name, val := range int64Params {
+		if q.Has(name) {
+			v, err := strconv.ParseInt(q.Get(name), 10, 64)
+			if err != nil {
+				return query, util.NewI18nError(util.NewValidationError(fmt.Sprintf("invalid %s: %v", name, err)),
+					util.I18nErrorSearchInvalidQuery)
+			}
+			*val = v
+		}
+	}
+	if q.Has("limit") {
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil {
+			return query, util.NewI18nError(util.NewValidationError(fmt.Sprintf("invalid limit: %v", err)),
+				util.I18nErrorSearchInvalidQuery)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+func getSearchErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, util.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrSearchIndexNotReady):
+		return http.StatusServiceUnavailable
+	default:
+		return getMappedStatusCode(err)
+	}
+}
+
+func searchUserFiles(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	query, err := getSearchQueryFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	query.Path = connection.User.GetCleanedPath(query.Path)
+	results, err := connection.SearchFiles(query)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to search files", getSearchErrorStatusCode(err))
+		return
+	}
+	render.JSON(w, r, results)
+}
@@ -18,10 +18,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -74,6 +76,59 @@ func getShareByID(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, share)
 }
 
+type shareFileStats struct {
+	Path               string `json:"path"`
+	Downloads          int    `json:"downloads"`
+	LastDownloadAt     int64  `json:"last_download_at,omitempty"`
+	LastDownloadIP     string `json:"last_download_ip,omitempty"`
+	RemainingDownloads int    `json:"remaining_downloads"`
+}
+
+type shareStats struct {
+	UsedTokens      int              `json:"used_tokens"`
+	RemainingTokens int              `json:"remaining_tokens"`
+	Downloads       int              `json:"downloads"`
+	LastAccessAt    int64            `json:"last_access_at,omitempty"`
+	LastAccessIP    string           `json:"last_access_ip,omitempty"`
+	Files           []shareFileStats `json:"files"`
+}
+
+func getShareStats(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	share, err := dataprovider.ShareExists(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	stats := shareStats{
+		UsedTokens:      share.UsedTokens,
+		RemainingTokens: share.GetRemainingTokens(),
+		Downloads:       share.Stats.Downloads,
+		LastAccessAt:    share.Stats.LastAccessAt,
+		LastAccessIP:    share.Stats.LastAccessIP,
+		Files:           make([]shareFileStats, 0, len(share.Stats.Files)),
+	}
+	for p, f := range share.Stats.Files {
+		stats.Files = append(stats.Files, shareFileStats{
+			Path:               p,
+			Downloads:          f.Downloads,
+			LastDownloadAt:     f.LastDownloadAt,
+			LastDownloadIP:     f.LastDownloadIP,
+			RemainingDownloads: share.GetFileRemainingDownloads(p),
+		})
+	}
+	sort.Slice(stats.Files, func(i, j int) bool {
+		return stats.Files[i].Path < stats.Files[j].Path
+	})
+
+	render.JSON(w, r, stats)
+}
+
 func addShare(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -255,6 +310,11 @@ func (s *httpdServer) downloadBrowsableSharedFile(w http.ResponseWriter, r *http
 		return
 	}
 
+	if err := share.CheckFileDownloads([]string{name}); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
 	inline := r.URL.Query().Get("inline") != ""
 	dataprovider.UpdateShareLastUse(&share, 1) //nolint:errcheck
 	if status, err := downloadFile(w, r, connection, name, info, inline, &share); err != nil {
@@ -268,7 +328,9 @@ func (s *httpdServer) downloadBrowsableSharedFile(w http.ResponseWriter, r *http
 			ctx = context.WithValue(ctx, render.StatusCtxKey, status)
 		}
 		render.JSON(w, r.WithContext(ctx), resp)
+		return
 	}
+	updateShareStats(r, &share, []string{name})
 }
 
 func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request) {
@@ -285,6 +347,11 @@ func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request)
 	}
 	defer common.Connections.Remove(connection.GetID())
 
+	method, err := getZipCompressionMethod(r.URL.Query().Get("compression"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 	compress := true
 	var info os.FileInfo
 	if len(share.Paths) == 1 {
@@ -298,6 +365,13 @@ func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	sharedPaths := make([]string, len(share.Paths))
+	copy(sharedPaths, share.Paths)
+	if err := share.CheckFileDownloads(sharedPaths); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
 	dataprovider.UpdateShareLastUse(&share, 1) //nolint:errcheck
 	if compress {
 		transferQuota := connection.GetTransferQuota()
@@ -314,7 +388,8 @@ func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request)
 			share.Paths[0] = "/"
 		}
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"share-%v.zip\"", share.Name))
-		renderCompressedFiles(w, connection, baseDir, share.Paths, &share)
+		renderCompressedFiles(w, connection, baseDir, share.Paths, &share, method)
+		updateShareStats(r, &share, sharedPaths)
 		return
 	}
 	if status, err := downloadFile(w, r, connection, share.Paths[0], info, false, &share); err != nil {
@@ -328,7 +403,9 @@ func (s *httpdServer) downloadFromShare(w http.ResponseWriter, r *http.Request)
 			ctx = context.WithValue(ctx, render.StatusCtxKey, status)
 		}
 		render.JSON(w, r.WithContext(ctx), resp)
+		return
 	}
+	updateShareStats(r, &share, sharedPaths)
 }
 
 func (s *httpdServer) uploadFileToShare(w http.ResponseWriter, r *http.Request) {
@@ -367,14 +444,17 @@ func (s *httpdServer) uploadFileToShare(w http.ResponseWriter, r *http.Request)
 	}
 	if err := doUploadFile(w, r, connection, filePath); err != nil {
 		dataprovider.UpdateShareLastUse(&share, -1) //nolint:errcheck
+		return
 	}
+	updateShareStats(r, &share, nil)
 }
 
 func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request) {
 	if maxUploadFileSize > 0 {
 		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileSize)
 	}
-	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeWrite, dataprovider.ShareScopeReadWrite}
+	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeWrite, dataprovider.ShareScopeReadWrite,
+		dataprovider.ShareScopeFileRequest}
 	share, connection, err := s.checkPublicShare(w, r, validScopes)
 	if err != nil {
 		return
@@ -416,13 +496,83 @@ func (s *httpdServer) uploadFilesToShare(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	parentDir := share.Paths[0]
+	if share.IsFileRequest() {
+		parentDir, err = prepareFileRequestUpload(r, connection, &share, files)
+		if err != nil {
+			statusCode := getRespStatus(err)
+			if errors.Is(err, common.ErrQuotaExceeded) {
+				statusCode = http.StatusRequestEntityTooLarge
+			}
+			sendAPIResponse(w, r, err, "", statusCode)
+			return
+		}
+	}
 	dataprovider.UpdateShareLastUse(&share, len(files)) //nolint:errcheck
 
-	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
-	numUploads := doUploadFiles(w, r, connection, share.Paths[0], files)
+	numUploads := doUploadFiles(w, r, connection, parentDir, files)
 	if numUploads != len(files) {
 		dataprovider.UpdateShareLastUse(&share, numUploads-len(files)) //nolint:errcheck
 	}
+	updateShareStats(r, &share, nil)
+}
+
+// updateShareStats records the last access and the specified downloaded
+// files for the given share. A nil filePaths only records the access
+func updateShareStats(r *http.Request, share *dataprovider.Share, filePaths []string) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	dataprovider.UpdateShareStats(share, util.GetIPFromRemoteAddress(r.RemoteAddr), filePaths) //nolint:errcheck
+}
+
+// prepareFileRequestUpload validates the uploader details for a file request
+// share, checks the per uploader size limit and returns the directory where
+// the files must be saved. Each uploader has its own sub directory, named
+// after the provided email address
+func prepareFileRequestUpload(r *http.Request, connection *Connection, share *dataprovider.Share,
+	files []*multipart.FileHeader,
+) (string, error) {
+	name := strings.TrimSpace(r.Form.Get("uploader_name"))
+	email := strings.ToLower(strings.TrimSpace(r.Form.Get("uploader_email")))
+	if name == "" || !util.IsEmailValid(email) {
+		return "", util.NewI18nError(util.NewValidationError("uploader name and a valid email are required"),
+			util.I18nErrorShareUploaderRequired)
+	}
+	uploaderDir := strings.NewReplacer("/", "_", "\\", "_").Replace(email)
+	parentDir := path.Join(share.Paths[0], uploaderDir)
+
+	if share.Options.MaxUploaderSize > 0 {
+		var uploadSize int64
+		for _, f := range files {
+			uploadSize += f.Size
+		}
+		fs, fsPath, err := connection.GetFsAndResolvedPath(parentDir)
+		if err != nil {
+			return "", err
+		}
+		if _, size, err := fs.GetDirSize(fsPath); err == nil {
+			uploadSize += size
+		}
+		if uploadSize > share.Options.MaxUploaderSize {
+			connection.Log(logger.LevelInfo, "denying file request upload for %q, size %d exceeds the allowed limit %d",
+				email, uploadSize, share.Options.MaxUploaderSize)
+			return "", util.NewI18nError(common.ErrQuotaExceeded, util.I18nErrorShareUploaderSize)
+		}
+	}
+	if err := connection.CheckParentDirs(parentDir); err != nil {
+		return "", err
+	}
+	connection.metadata = map[string]string{
+		"share_id":       share.ShareID,
+		"uploader_name":  name,
+		"uploader_email": email,
+	}
+	if notes := strings.TrimSpace(r.Form.Get("uploader_notes")); notes != "" {
+		connection.metadata["uploader_notes"] = notes
+	}
+	return parentDir, nil
 }
 
 func (s *httpdServer) getShareClaims(r *http.Request, shareID string) (*jwtTokenClaims, error) {
@@ -0,0 +1,262 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// event actions are shared by all the tenants, tenant admins can only
+// reference them in their rules
+var errTenantEventActions = util.NewI18nError(
+	errors.New("event actions cannot be modified by tenant admins"),
+	util.I18nErrorTenantEventActions,
+)
+
+// checkTenantAccess returns a not found error if the object with the specified
+// tenant is not visible for the admin identified by the given claims.
+// Global admins can access any object
+func checkTenantAccess(claims *jwtTokenClaims, objectType, name, tenant string) error {
+	if claims.Tenant == "" || claims.Tenant == tenant {
+		return nil
+	}
+	return util.NewRecordNotFoundError(fmt.Sprintf("%s %q does not exist", objectType, name))
+}
+
+// getTenantFromRequest returns the tenant for the admin performing the request,
+// an empty string means a global admin
+func getTenantFromRequest(r *http.Request) string {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return ""
+	}
+	return claims.Tenant
+}
+
+// getWebAdminBranding returns the WebAdmin branding for the admin performing
+// the request, tenant customizations override the binding ones
+func (s *httpdServer) getWebAdminBranding(r *http.Request) UIBranding {
+	tenant := getTenantFromRequest(r)
+	if tenant == "" {
+		return s.binding.Branding.WebAdmin
+	}
+	t, err := dataprovider.TenantExists(tenant)
+	if err != nil {
+		return s.binding.Branding.WebAdmin
+	}
+	return s.binding.Branding.WebAdmin.withTenant(t.Branding.WebAdmin)
+}
+
+// getWebClientBranding returns the WebClient branding for the user performing
+// the request, tenant customizations override the binding ones
+func (s *httpdServer) getWebClientBranding(r *http.Request) UIBranding {
+	tenant := getTenantFromRequest(r)
+	if tenant == "" {
+		return s.binding.Branding.WebClient
+	}
+	t, err := dataprovider.TenantExists(tenant)
+	if err != nil {
+		return s.binding.Branding.WebClient
+	}
+	return s.binding.Branding.WebClient.withTenant(t.Branding.WebClient)
+}
+
+func adminExistsForClaims(username string, claims *jwtTokenClaims) (dataprovider.Admin, error) {
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		return admin, err
+	}
+	return admin, checkTenantAccess(claims, "admin", username, admin.Tenant)
+}
+
+func userExistsForClaims(username string, claims *jwtTokenClaims) (dataprovider.User, error) {
+	user, err := dataprovider.UserExists(username, claims.Role)
+	if err != nil {
+		return user, err
+	}
+	return user, checkTenantAccess(claims, "user", username, user.Tenant)
+}
+
+// checkImpersonator returns an error if the specified admin does not exist, is
+// disabled or is no longer allowed to impersonate the given user. The admin
+// permissions, role and tenant are loaded from the data provider and not from
+// the token claims, so the changes apply to the active sessions too
+func checkImpersonator(impersonator, username, ipAddr string) error {
+	admin, err := dataprovider.AdminExists(impersonator)
+	if err != nil {
+		return fmt.Errorf("unable to get admin %q: %w", impersonator, err)
+	}
+	if err := admin.CanLogin(ipAddr); err != nil {
+		return err
+	}
+	if !admin.HasPermission(dataprovider.PermAdminImpersonateUsers) {
+		return fmt.Errorf("admin %q is not allowed to impersonate users", admin.Username)
+	}
+	claims := jwtTokenClaims{
+		Role:   admin.Role,
+		Tenant: admin.Tenant,
+	}
+	_, err = userExistsForClaims(username, &claims)
+	return err
+}
+
+func groupExistsForClaims(name string, claims *jwtTokenClaims) (dataprovider.Group, error) {
+	group, err := dataprovider.GroupExists(name)
+	if err != nil {
+		return group, err
+	}
+	return group, checkTenantAccess(claims, "group", name, group.Tenant)
+}
+
+func folderExistsForClaims(name string, claims *jwtTokenClaims) (vfs.BaseVirtualFolder, error) {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return folder, err
+	}
+	return folder, checkTenantAccess(claims, "folder", name, folder.Tenant)
+}
+
+func roleExistsForClaims(name string, claims *jwtTokenClaims) (dataprovider.Role, error) {
+	role, err := dataprovider.RoleExists(name)
+	if err != nil {
+		return role, err
+	}
+	return role, checkTenantAccess(claims, "role", name, role.Tenant)
+}
+
+func eventRuleExistsForClaims(name string, claims *jwtTokenClaims) (dataprovider.EventRule, error) {
+	rule, err := dataprovider.EventRuleExists(name)
+	if err != nil {
+		return rule, err
+	}
+	return rule, checkTenantAccess(claims, "event rule", name, rule.Tenant)
+}
+
+func getTenants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+
+	tenants, err := dataprovider.GetTenants(limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, tenants)
+}
+
+func addTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var tenant dataprovider.Tenant
+	err = render.DecodeJSON(r.Body, &tenant)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddTenant(&tenant, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		w.Header().Add("Location", fmt.Sprintf("%s/%s", tenantsPath, url.PathEscape(tenant.Name)))
+		renderTenant(w, r, tenant.Name, http.StatusCreated)
+	}
+}
+
+func updateTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	name := getURLParam(r, "name")
+	tenant, err := dataprovider.TenantExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	var updatedTenant dataprovider.Tenant
+	err = render.DecodeJSON(r.Body, &updatedTenant)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+
+	updatedTenant.ID = tenant.ID
+	updatedTenant.Name = tenant.Name
+	err = dataprovider.UpdateTenant(&updatedTenant, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Tenant updated", http.StatusOK)
+}
+
+func renderTenant(w http.ResponseWriter, r *http.Request, name string, status int) {
+	tenant, err := dataprovider.TenantExists(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if status != http.StatusOK {
+		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+		render.JSON(w, r.WithContext(ctx), tenant)
+	} else {
+		render.JSON(w, r, tenant)
+	}
+}
+
+func getTenantByName(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	name := getURLParam(r, "name")
+	renderTenant(w, r, name, http.StatusOK)
+}
+
+func deleteTenant(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	name := getURLParam(r, "name")
+	err = dataprovider.DeleteTenant(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "Tenant deleted", http.StatusOK)
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+// getTrashConnection returns a connection to manage the trash. Tokens issued
+// to OAuth2 applications are limited to a path so they cannot access the trash
+func getTrashConnection(w http.ResponseWriter, r *http.Request) (*Connection, error) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid token claims %w", err)
+	}
+	if claims.OAuth2ClientID != "" {
+		err = errors.New("the trash cannot be managed by OAuth2 applications")
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return nil, err
+	}
+	return getUserConnection(w, r)
+}
+
+func getUserTrash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	entries, err := connection.GetTrashEntries()
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to get the trash contents", getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func restoreUserTrashEntry(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	entry, err := connection.RestoreTrashEntry(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to restore the file", getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, fmt.Sprintf("File %q restored", entry.Path), http.StatusOK)
+}
+
+func deleteUserTrashEntry(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.DeleteTrashEntry(getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, "Unable to delete the file", getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "File permanently deleted", http.StatusOK)
+}
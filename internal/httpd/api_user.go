@@ -23,14 +23,12 @@ import (
 	"time"
 
 	"github.com/go-chi/render"
-	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
-	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 func getUsers(w http.ResponseWriter, r *http.Request) {
@@ -45,7 +43,7 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role)
+	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role, claims.Tenant)
 	if err == nil {
 		render.JSON(w, r, users)
 	} else {
@@ -65,7 +63,7 @@ func getUserByUsername(w http.ResponseWriter, r *http.Request) {
 }
 
 func renderUser(w http.ResponseWriter, r *http.Request, username string, claims *jwtTokenClaims, status int) {
-	user, err := dataprovider.UserExists(username, claims.Role)
+	user, err := userExistsForClaims(username, claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -106,8 +104,12 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 	if claims.Role != "" {
 		user.Role = claims.Role
 	}
+	if claims.Tenant != "" {
+		user.Tenant = claims.Tenant
+	}
 	user.LastPasswordChange = 0
 	user.Filters.RecoveryCodes = nil
+	user.Filters.S3Secret = nil
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
@@ -128,7 +130,7 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username := getURLParam(r, "username")
-	user, err := dataprovider.UserExists(username, claims.Role)
+	user, err := userExistsForClaims(username, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -166,7 +168,7 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	user, err := dataprovider.UserExists(username, claims.Role)
+	user, err := userExistsForClaims(username, &claims)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -183,9 +185,10 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.S3Secret = user.Filters.S3Secret
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&updatedUser.FsConfig, &user.FsConfig)
+	updatedUser.FsConfig.KeepEncryptedSecrets(&user.FsConfig)
 	if claims.Role != "" {
 		updatedUser.Role = claims.Role
 	}
@@ -208,6 +211,10 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	username := getURLParam(r, "username")
+	if _, err := userExistsForClaims(username, &claims); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteUser(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -270,55 +277,3 @@ func disconnectUser(username, admin, role string) {
 		}
 	}
 }
-
-func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
-	// we use the new access secret if plain or empty, otherwise the old value
-	switch fsConfig.Provider {
-	case sdk.S3FilesystemProvider:
-		if fsConfig.S3Config.AccessSecret.IsNotPlainAndNotEmpty() {
-			fsConfig.S3Config.AccessSecret = currentFsConfig.S3Config.AccessSecret
-		}
-	case sdk.AzureBlobFilesystemProvider:
-		if fsConfig.AzBlobConfig.AccountKey.IsNotPlainAndNotEmpty() {
-			fsConfig.AzBlobConfig.AccountKey = currentFsConfig.AzBlobConfig.AccountKey
-		}
-		if fsConfig.AzBlobConfig.SASURL.IsNotPlainAndNotEmpty() {
-			fsConfig.AzBlobConfig.SASURL = currentFsConfig.AzBlobConfig.SASURL
-		}
-	case sdk.GCSFilesystemProvider:
-		// for GCS credentials will be cleared if we enable automatic credentials
-		// so keep the old credentials here if no new credentials are provided
-		if !fsConfig.GCSConfig.Credentials.IsPlain() {
-			fsConfig.GCSConfig.Credentials = currentFsConfig.GCSConfig.Credentials
-		}
-	case sdk.CryptedFilesystemProvider:
-		if fsConfig.CryptConfig.Passphrase.IsNotPlainAndNotEmpty() {
-			fsConfig.CryptConfig.Passphrase = currentFsConfig.CryptConfig.Passphrase
-		}
-	case sdk.SFTPFilesystemProvider:
-		updateSFTPFsEncryptedSecrets(fsConfig, currentFsConfig)
-	case sdk.HTTPFilesystemProvider:
-		updateHTTPFsEncryptedSecrets(fsConfig, currentFsConfig)
-	}
-}
-
-func updateSFTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
-	if fsConfig.SFTPConfig.Password.IsNotPlainAndNotEmpty() {
-		fsConfig.SFTPConfig.Password = currentFsConfig.SFTPConfig.Password
-	}
-	if fsConfig.SFTPConfig.PrivateKey.IsNotPlainAndNotEmpty() {
-		fsConfig.SFTPConfig.PrivateKey = currentFsConfig.SFTPConfig.PrivateKey
-	}
-	if fsConfig.SFTPConfig.KeyPassphrase.IsNotPlainAndNotEmpty() {
-		fsConfig.SFTPConfig.KeyPassphrase = currentFsConfig.SFTPConfig.KeyPassphrase
-	}
-}
-
-func updateHTTPFsEncryptedSecrets(fsConfig *vfs.Filesystem, currentFsConfig *vfs.Filesystem) {
-	if fsConfig.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
-		fsConfig.HTTPConfig.Password = currentFsConfig.HTTPConfig.Password
-	}
-	if fsConfig.HTTPConfig.APIKey.IsNotPlainAndNotEmpty() {
-		fsConfig.HTTPConfig.APIKey = currentFsConfig.HTTPConfig.APIKey
-	}
-}
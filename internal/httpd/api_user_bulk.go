@@ -0,0 +1,765 @@
+// Copyright (C) 2024 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	bulkUserActionCreate = "create"
+	bulkUserActionUpdate = "update"
+	bulkUserActionSkip   = "skip"
+	bulkUserActionError  = "error"
+	bulkUsersFormatJSON  = "json"
+	bulkUsersFormatCSV   = "csv"
+)
+
+var (
+	// supported CSV columns, only the username is required
+	bulkUsersCSVColumns = []string{"username", "status", "email", "description", "password", "public_keys",
+		"home_dir", "uid", "gid", "max_sessions", "quota_size", "quota_files", "upload_bandwidth",
+		"download_bandwidth", "expiration_date", "permissions", "primary_group", "secondary_groups",
+		"membership_groups", "role"}
+	// fields ignored when computing the differences between the existing and the imported users
+	bulkUsersIgnoredDiffFields = []string{"id", "password", "has_password", "created_at", "updated_at",
+		"last_login", "last_password_change", "used_quota_size", "used_quota_files", "used_upload_data_transfer",
+		"used_download_data_transfer", "first_download", "first_upload", "oidc_custom_fields"}
+)
+
+type bulkUserChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+type bulkUserResult struct {
+	Username string           `json:"username"`
+	Action   string           `json:"action"`
+	Error    string           `json:"error,omitempty"`
+	Changes  []bulkUserChange `json:"changes,omitempty"`
+}
+
+type bulkUsersResponse struct {
+	DryRun  bool             `json:"dry_run"`
+	Applied bool             `json:"applied"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Skipped int              `json:"skipped"`
+	Errors  int              `json:"errors"`
+	Results []bulkUserResult `json:"results"`
+}
+
+type bulkUserInput struct {
+	user dataprovider.User
+	// CSV columns to apply, nil means that the whole user is replaced
+	columns []string
+	values  []string
+}
+
+type bulkUserRecord struct {
+	user     dataprovider.User
+	existing *dataprovider.User
+	result   bulkUserResult
+}
+
+func getBulkUsersMode(r *http.Request) (int, error) {
+	mode := 0
+	if val := r.URL.Query().Get("mode"); val != "" {
+		m, err := strconv.Atoi(val)
+		if err != nil || m < 0 || m > 2 {
+			return 0, util.NewValidationError(fmt.Sprintf("invalid mode %q, supported values: 0, 1, 2", val))
+		}
+		mode = m
+	}
+	return mode, nil
+}
+
+func getBulkUsersFromRequest(r *http.Request) ([]bulkUserInput, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "text/csv" {
+		return getBulkUsersFromCSV(r.Body)
+	}
+	var users []dataprovider.User
+	if err := render.DecodeJSON(r.Body, &users); err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("unable to decode users: %v", err))
+	}
+	result := make([]bulkUserInput, 0, len(users))
+	for _, user := range users {
+		result = append(result, bulkUserInput{user: user})
+	}
+	return result, nil
+}
+
+func getBulkUsersFromCSV(reader io.Reader) ([]bulkUserInput, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("unable to read the CSV header: %v", err))
+	}
+	hasUsername := false
+	for idx := range header {
+		header[idx] = strings.ToLower(strings.TrimSpace(header[idx]))
+		if !util.Contains(bulkUsersCSVColumns, header[idx]) {
+			return nil, util.NewValidationError(fmt.Sprintf("unsupported CSV column %q", header[idx]))
+		}
+		if header[idx] == "username" {
+			hasUsername = true
+		}
+	}
+	if !hasUsername {
+		return nil, util.NewValidationError("the username column is required")
+	}
+	if len(util.RemoveDuplicates(header, false)) != len(header) {
+		return nil, util.NewValidationError("duplicate CSV columns are not allowed")
+	}
+	var result []bulkUserInput
+	for {
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("unable to read CSV record: %v", err))
+		}
+		input := bulkUserInput{
+			columns: header,
+			values:  row,
+		}
+		for idx, col := range header {
+			if col == "username" {
+				input.user.Username = strings.TrimSpace(row[idx])
+			}
+		}
+		result = append(result, input)
+	}
+	return result, nil
+}
+
+func parseBulkUserInt(column, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid value %q for column %q", value, column))
+	}
+	return val, nil
+}
+
+func setBulkUserGroups(user *dataprovider.User, groupType int, value string) {
+	groups := make([]sdk.GroupMapping, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		if g.Type != groupType {
+			groups = append(groups, g)
+		}
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			groups = append(groups, sdk.GroupMapping{
+				Name: name,
+				Type: groupType,
+			})
+		}
+	}
+	user.Groups = groups
+}
+
+// applyBulkUserCSVValues sets the CSV values to the specified user, the
+// fields without a matching CSV column are not modified
+func applyBulkUserCSVValues(user *dataprovider.User, columns, values []string) error {
+	for idx, col := range columns {
+		value := strings.TrimSpace(values[idx])
+		var intVal int64
+		var err error
+		switch col {
+		case "username", "email", "description", "password", "home_dir", "role", "permissions", "public_keys",
+			"primary_group", "secondary_groups", "membership_groups":
+		default:
+			intVal, err = parseBulkUserInt(col, value)
+			if err != nil {
+				return err
+			}
+		}
+		switch col {
+		case "username":
+			user.Username = value
+		case "status":
+			user.Status = int(intVal)
+		case "email":
+			user.Email = value
+		case "description":
+			user.Description = value
+		case "password":
+			if value != "" {
+				user.Password = value
+			}
+		case "public_keys":
+			user.PublicKeys = nil
+			for _, k := range strings.Split(value, "\n") {
+				if k = strings.TrimSpace(k); k != "" {
+					user.PublicKeys = append(user.PublicKeys, k)
+				}
+			}
+		case "home_dir":
+			user.HomeDir = value
+		case "uid":
+			user.UID = int(intVal)
+		case "gid":
+			user.GID = int(intVal)
+		case "max_sessions":
+			user.MaxSessions = int(intVal)
+		case "quota_size":
+			user.QuotaSize = intVal
+		case "quota_files":
+			user.QuotaFiles = int(intVal)
+		case "upload_bandwidth":
+			user.UploadBandwidth = intVal
+		case "download_bandwidth":
+			user.DownloadBandwidth = intVal
+		case "expiration_date":
+			user.ExpirationDate = intVal
+		case "permissions":
+			if user.Permissions == nil {
+				user.Permissions = make(map[string][]string)
+			}
+			var perms []string
+			for _, p := range strings.Split(value, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					perms = append(perms, p)
+				}
+			}
+			user.Permissions["/"] = perms
+		case "primary_group":
+			setBulkUserGroups(user, sdk.GroupTypePrimary, value)
+		case "secondary_groups":
+			setBulkUserGroups(user, sdk.GroupTypeSecondary, value)
+		case "membership_groups":
+			setBulkUserGroups(user, sdk.GroupTypeMembership, value)
+		}
+	}
+	return nil
+}
+
+func getBulkUserCSVValues(user *dataprovider.User) []string {
+	var primaryGroup string
+	var secondaryGroups, membershipGroups []string
+	for _, g := range user.Groups {
+		switch g.Type {
+		case sdk.GroupTypePrimary:
+			primaryGroup = g.Name
+		case sdk.GroupTypeSecondary:
+			secondaryGroups = append(secondaryGroups, g.Name)
+		case sdk.GroupTypeMembership:
+			membershipGroups = append(membershipGroups, g.Name)
+		}
+	}
+	values := make([]string, 0, len(bulkUsersCSVColumns))
+	for _, col := range bulkUsersCSVColumns {
+		switch col {
+		case "username":
+			values = append(values, user.Username)
+		case "status":
+			values = append(values, strconv.Itoa(user.Status))
+		case "email":
+			values = append(values, user.Email)
+		case "description":
+			values = append(values, user.Description)
+		case "password":
+			// passwords are never exported
+			values = append(values, "")
+		case "public_keys":
+			values = append(values, strings.Join(user.PublicKeys, "\n"))
+		case "home_dir":
+			values = append(values, user.HomeDir)
+		case "uid":
+			values = append(values, strconv.Itoa(user.UID))
+		case "gid":
+			values = append(values, strconv.Itoa(user.GID))
+		case "max_sessions":
+			values = append(values, strconv.Itoa(user.MaxSessions))
+		case "quota_size":
+			values = append(values, strconv.FormatInt(user.QuotaSize, 10))
+		case "quota_files":
+			values = append(values, strconv.Itoa(user.QuotaFiles))
+		case "upload_bandwidth":
+			values = append(values, strconv.FormatInt(user.UploadBandwidth, 10))
+		case "download_bandwidth":
+			values = append(values, strconv.FormatInt(user.DownloadBandwidth, 10))
+		case "expiration_date":
+			values = append(values, strconv.FormatInt(user.ExpirationDate, 10))
+		case "permissions":
+			values = append(values, strings.Join(user.Permissions["/"], ","))
+		case "primary_group":
+			values = append(values, primaryGroup)
+		case "secondary_groups":
+			values = append(values, strings.Join(secondaryGroups, ","))
+		case "membership_groups":
+			values = append(values, strings.Join(membershipGroups, ","))
+		case "role":
+			values = append(values, user.Role)
+		}
+	}
+	return values
+}
+
+// copyUserForBulk returns a deep copy of the specified user
+func copyUserForBulk(user *dataprovider.User) (dataprovider.User, error) {
+	var result dataprovider.User
+	data, err := json.Marshal(user)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func flattenBulkUserFields(prefix string, value any, fields map[string]any) {
+	if m, ok := value.(map[string]any); ok && len(m) > 0 {
+		for k, v := range m {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenBulkUserFields(key, v, fields)
+		}
+		return
+	}
+	if prefix != "" {
+		fields[prefix] = value
+	}
+}
+
+func getBulkUserFields(user *dataprovider.User) (map[string]any, error) {
+	u, err := copyUserForBulk(user)
+	if err != nil {
+		return nil, err
+	}
+	u.PrepareForRendering()
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for _, field := range bulkUsersIgnoredDiffFields {
+		delete(m, field)
+	}
+	fields := make(map[string]any)
+	flattenBulkUserFields("", m, fields)
+	return fields, nil
+}
+
+// getBulkUserChanges returns the differences between the existing and the updated user.
+// Confidential data are not included in the differences
+func getBulkUserChanges(existing, updated *dataprovider.User, passwordChanged bool) ([]bulkUserChange, error) {
+	oldFields, err := getBulkUserFields(existing)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := getBulkUserFields(updated)
+	if err != nil {
+		return nil, err
+	}
+	var changes []bulkUserChange
+	if passwordChanged {
+		changes = append(changes, bulkUserChange{Field: "password"})
+	}
+	for k, v := range newFields {
+		if old, ok := oldFields[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes = append(changes, bulkUserChange{Field: k, Old: old, New: v})
+		}
+	}
+	for k, v := range oldFields {
+		if _, ok := newFields[k]; !ok {
+			changes = append(changes, bulkUserChange{Field: k, Old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+func prepareBulkUserRecord(input *bulkUserInput, mode int, admin *dataprovider.Admin, role string) *bulkUserRecord {
+	rec := &bulkUserRecord{
+		result: bulkUserResult{
+			Username: input.user.Username,
+		},
+	}
+	if err := prepareBulkUser(rec, input, mode, admin, role); err != nil {
+		rec.result.Action = bulkUserActionError
+		rec.result.Error = err.Error()
+	}
+	return rec
+}
+
+func prepareBulkUser(rec *bulkUserRecord, input *bulkUserInput, mode int, admin *dataprovider.Admin, role string) error {
+	if input.user.Username == "" {
+		return util.NewValidationError("username is mandatory")
+	}
+	existing, err := dataprovider.UserExists(input.user.Username, "")
+	if err == nil {
+		if role != "" && existing.Role != role {
+			return util.NewValidationError(fmt.Sprintf("user %q already exists and is not associated with your role",
+				existing.Username))
+		}
+		if admin.Tenant != "" && existing.Tenant != admin.Tenant {
+			return util.NewValidationError(fmt.Sprintf("user %q already exists and is not associated with your tenant",
+				existing.Username))
+		}
+		if mode == 1 {
+			rec.user = existing
+			rec.result.Action = bulkUserActionSkip
+			return nil
+		}
+		return prepareBulkUserUpdate(rec, input, &existing, role)
+	}
+	if !errors.Is(err, util.ErrNotFound) {
+		return err
+	}
+	return prepareBulkUserCreate(rec, input, admin, role)
+}
+
+func prepareBulkUserCreate(rec *bulkUserRecord, input *bulkUserInput, admin *dataprovider.Admin, role string) error {
+	user := input.user
+	if input.columns != nil {
+		user = dataprovider.User{}
+		user.Status = 1
+		if err := applyBulkUserCSVValues(&user, input.columns, input.values); err != nil {
+			return err
+		}
+	}
+	if user.ExpirationDate == 0 && admin.Filters.Preferences.DefaultUsersExpiration > 0 {
+		user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour *
+			time.Duration(admin.Filters.Preferences.DefaultUsersExpiration)))
+	}
+	if role != "" {
+		user.Role = role
+	}
+	if admin.Tenant != "" {
+		user.Tenant = admin.Tenant
+	}
+	user.ID = 0
+	user.LastPasswordChange = 0
+	user.Filters.RecoveryCodes = nil
+	user.Filters.S3Secret = nil
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled: false,
+	}
+	validated, err := copyUserForBulk(&user)
+	if err != nil {
+		return err
+	}
+	if err := dataprovider.ValidateUser(&validated); err != nil {
+		return err
+	}
+	rec.user = user
+	rec.result.Action = bulkUserActionCreate
+	return nil
+}
+
+func prepareBulkUserUpdate(rec *bulkUserRecord, input *bulkUserInput, existing *dataprovider.User, role string) error {
+	var user dataprovider.User
+	passwordChanged := false
+	if input.columns != nil {
+		u, err := copyUserForBulk(existing)
+		if err != nil {
+			return err
+		}
+		user = u
+		if err := applyBulkUserCSVValues(&user, input.columns, input.values); err != nil {
+			return err
+		}
+	} else {
+		user = input.user
+		if user.Password == "" {
+			user.Password = existing.Password
+		}
+		user.SetEmptySecretsIfNil()
+		user.FsConfig.KeepEncryptedSecrets(&existing.FsConfig)
+	}
+	passwordChanged = user.Password != existing.Password
+	user.ID = existing.ID
+	user.Username = existing.Username
+	user.Filters.RecoveryCodes = existing.Filters.RecoveryCodes
+	user.Filters.TOTPConfig = existing.Filters.TOTPConfig
+	user.Filters.S3Secret = existing.Filters.S3Secret
+	user.LastPasswordChange = existing.LastPasswordChange
+	if role != "" {
+		user.Role = role
+	}
+	validated, err := copyUserForBulk(&user)
+	if err != nil {
+		return err
+	}
+	if err := dataprovider.ValidateUser(&validated); err != nil {
+		return err
+	}
+	changes, err := getBulkUserChanges(existing, &validated, passwordChanged)
+	if err != nil {
+		return err
+	}
+	rec.user = user
+	rec.existing = existing
+	if len(changes) == 0 {
+		rec.result.Action = bulkUserActionSkip
+		return nil
+	}
+	rec.result.Action = bulkUserActionUpdate
+	rec.result.Changes = changes
+	return nil
+}
+
+// applyBulkUsers applies the specified records. If an error occurs the changes
+// already applied are rolled back
+func applyBulkUsers(records []*bulkUserRecord, executor, ipAddress, role string) error {
+	applied := make([]*bulkUserRecord, 0, len(records))
+	for _, rec := range records {
+		var err error
+		switch rec.result.Action {
+		case bulkUserActionCreate:
+			err = dataprovider.AddUser(&rec.user, executor, ipAddress, role)
+		case bulkUserActionUpdate:
+			err = dataprovider.UpdateUser(&rec.user, executor, ipAddress, role)
+		default:
+			continue
+		}
+		if err != nil {
+			logger.Warn(logSender, "", "unable to apply bulk changes for user %q: %v, rolling back %d changes",
+				rec.user.Username, err, len(applied))
+			rollbackBulkUsers(applied, executor, ipAddress, role)
+			return fmt.Errorf("unable to apply changes for user %q: %w", rec.user.Username, err)
+		}
+		applied = append(applied, rec)
+	}
+	return nil
+}
+
+func rollbackBulkUsers(applied []*bulkUserRecord, executor, ipAddress, role string) {
+	for idx := len(applied) - 1; idx >= 0; idx-- {
+		rec := applied[idx]
+		var err error
+		switch rec.result.Action {
+		case bulkUserActionCreate:
+			err = dataprovider.DeleteUser(rec.user.Username, executor, ipAddress, role)
+		case bulkUserActionUpdate:
+			err = dataprovider.UpdateUser(rec.existing, executor, ipAddress, role)
+		}
+		if err != nil {
+			logger.Error(logSender, "", "unable to rollback bulk changes for user %q: %v", rec.user.Username, err)
+		}
+	}
+}
+
+func importUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRestoreSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	mode, err := getBulkUsersMode(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	inputs, err := getBulkUsersFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if len(inputs) == 0 {
+		sendAPIResponse(w, r, nil, "No user to import", http.StatusBadRequest)
+		return
+	}
+	resp := bulkUsersResponse{
+		DryRun:  getBoolQueryParam(r, "dry_run"),
+		Results: make([]bulkUserResult, 0, len(inputs)),
+	}
+	records := make([]*bulkUserRecord, 0, len(inputs))
+	usernames := make(map[string]bool)
+	for idx := range inputs {
+		rec := prepareBulkUserRecord(&inputs[idx], mode, &admin, claims.Role)
+		if rec.result.Action != bulkUserActionError && usernames[rec.user.Username] {
+			rec.result.Action = bulkUserActionError
+			rec.result.Error = fmt.Sprintf("duplicate username %q", rec.user.Username)
+		}
+		usernames[rec.user.Username] = true
+		switch rec.result.Action {
+		case bulkUserActionCreate:
+			resp.Created++
+		case bulkUserActionUpdate:
+			resp.Updated++
+		case bulkUserActionSkip:
+			resp.Skipped++
+		default:
+			resp.Errors++
+		}
+		records = append(records, rec)
+		resp.Results = append(resp.Results, rec.result)
+	}
+	status := http.StatusOK
+	if resp.Errors > 0 {
+		if !resp.DryRun {
+			status = http.StatusBadRequest
+		}
+	} else if !resp.DryRun {
+		ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+		if err := applyBulkUsers(records, claims.Username, ipAddr, claims.Role); err != nil {
+			sendAPIResponse(w, r, err, "Unable to import users, the changes were rolled back", getRespStatus(err))
+			return
+		}
+		resp.Applied = true
+		if mode == 2 {
+			for _, rec := range records {
+				if rec.result.Action == bulkUserActionUpdate {
+					disconnectUser(rec.user.Username, claims.Username, claims.Role)
+				}
+			}
+		}
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+	render.JSON(w, r.WithContext(ctx), resp)
+}
+
+func exportUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = bulkUsersFormatJSON
+	}
+	if format != bulkUsersFormatJSON && format != bulkUsersFormatCSV {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported format %q", format), http.StatusBadRequest)
+		return
+	}
+	var users []dataprovider.User
+	limit := 100
+	for offset := 0; ; offset += limit {
+		batch, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, claims.Role, claims.Tenant)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		users = append(users, batch...)
+		if len(batch) < limit {
+			break
+		}
+	}
+	hideData := hideConfidentialData(&claims, r)
+	for idx := range users {
+		if hideData {
+			users[idx].PrepareForRendering()
+		}
+	}
+	if format == bulkUsersFormatJSON {
+		w.Header().Set("Content-Disposition", "attachment; filename=\"sftpgo-users.json\"")
+		render.JSON(w, r, users)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"sftpgo-users.csv\"")
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(bulkUsersCSVColumns) //nolint:errcheck
+	for idx := range users {
+		csvWriter.Write(getBulkUserCSVValues(&users[idx])) //nolint:errcheck
+	}
+	csvWriter.Flush()
+}
+
+type bulkUsersDeleteResult struct {
+	Deleted int               `json:"deleted"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+func deleteUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var usernames []string
+	if err := render.DecodeJSON(r.Body, &usernames); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	usernames = util.RemoveDuplicates(usernames, true)
+	if len(usernames) == 0 {
+		sendAPIResponse(w, r, errors.New("no users to delete"), "", http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+
+	startJob(w, r, &claims, common.JobTypeUsersDelete, "Delete started", true,
+		func(ctx context.Context, setProgress func(int)) (any, error) {
+			result := &bulkUsersDeleteResult{
+				Errors: make(map[string]string),
+			}
+			for idx, username := range usernames {
+				if err := ctx.Err(); err != nil {
+					return result, err
+				}
+				_, err := userExistsForClaims(username, &claims)
+				if err == nil {
+					err = dataprovider.DeleteUser(username, claims.Username, ipAddr, claims.Role)
+				}
+				if err != nil {
+					result.Errors[username] = err.Error()
+				} else {
+					result.Deleted++
+					disconnectUser(dataprovider.ConvertName(username), claims.Username, claims.Role)
+				}
+				setProgress((idx + 1) * 100 / len(usernames))
+			}
+			logger.Debug(logSender, "", "bulk delete completed, users deleted: %d, errors: %d",
+				result.Deleted, len(result.Errors))
+			return result, nil
+		})
+}
@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -54,6 +55,10 @@ type pwdChange struct {
 	NewPassword     string `json:"new_password"`
 }
 
+type sshCertRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
 type pwdReset struct {
 	Code     string `json:"code"`
 	Password string `json:"password"`
@@ -129,6 +134,8 @@ func getMappedStatusCode(err error) int {
 		statusCode = http.StatusRequestEntityTooLarge
 	case errors.Is(err, common.ErrOpUnsupported):
 		statusCode = http.StatusBadRequest
+	case errors.Is(err, vfs.ErrObjectArchived):
+		statusCode = http.StatusConflict
 	default:
 		if _, ok := err.(*http.MaxBytesError); ok {
 			statusCode = http.StatusRequestEntityTooLarge
@@ -371,8 +378,25 @@ func getCompressedFileName(username string, files []string) string {
 	return fmt.Sprintf("%s-download.zip", username)
 }
 
+// getZipCompressionMethod returns the zip compression method for the specified
+// value, deflate is used if no compression method is specified
+func getZipCompressionMethod(value string) (uint16, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", zipCompressionDeflate:
+		return zip.Deflate, nil
+	case zipCompressionStore:
+		return zip.Store, nil
+	default:
+		return 0, util.NewValidationError(fmt.Sprintf("invalid compression method %q, supported values: %q, %q",
+			value, zipCompressionStore, zipCompressionDeflate))
+	}
+}
+
+// renderCompressedFiles streams the specified files as a zip archive. The
+// Zip64 format is automatically used for entries larger than 4GB and for
+// archives with more than 65535 entries
 func renderCompressedFiles(w http.ResponseWriter, conn *Connection, baseDir string, files []string,
-	share *dataprovider.Share,
+	share *dataprovider.Share, method uint16,
 ) {
 	conn.User.CheckFsRoot(conn.ID) //nolint:errcheck
 	w.Header().Set("Content-Type", "application/zip")
@@ -384,7 +408,7 @@ func renderCompressedFiles(w http.ResponseWriter, conn *Connection, baseDir stri
 
 	for _, file := range files {
 		fullPath := util.CleanPath(path.Join(baseDir, file))
-		if err := addZipEntry(wr, conn, fullPath, baseDir, 0); err != nil {
+		if err := addZipEntry(wr, conn, fullPath, baseDir, method, 0); err != nil {
 			if share != nil {
 				dataprovider.UpdateShareLastUse(share, -1) //nolint:errcheck
 			}
@@ -400,7 +424,7 @@ func renderCompressedFiles(w http.ResponseWriter, conn *Connection, baseDir stri
 	}
 }
 
-func addZipEntry(wr *zip.Writer, conn *Connection, entryPath, baseDir string, recursion int) error {
+func addZipEntry(wr *zip.Writer, conn *Connection, entryPath, baseDir string, method uint16, recursion int) error {
 	if recursion >= util.MaxRecursion {
 		conn.Log(logger.LevelDebug, "unable to add zip entry %q, recursion too depth: %d", entryPath, recursion)
 		return util.ErrRecursionTooDeep
@@ -441,7 +465,7 @@ func addZipEntry(wr *zip.Writer, conn *Connection, entryPath, baseDir string, re
 			}
 			for _, info := range contents {
 				fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
-				if err := addZipEntry(wr, conn, fullPath, baseDir, recursion); err != nil {
+				if err := addZipEntry(wr, conn, fullPath, baseDir, method, recursion); err != nil {
 					return err
 				}
 			}
@@ -455,10 +479,12 @@ func addZipEntry(wr *zip.Writer, conn *Connection, entryPath, baseDir string, re
 		conn.Log(logger.LevelInfo, "skipping zip entry for non regular file %q", entryPath)
 		return nil
 	}
-	return addFileToZipEntry(wr, conn, entryPath, entryName, info)
+	return addFileToZipEntry(wr, conn, entryPath, entryName, info, method)
 }
 
-func addFileToZipEntry(wr *zip.Writer, conn *Connection, entryPath, entryName string, info os.FileInfo) error {
+func addFileToZipEntry(wr *zip.Writer, conn *Connection, entryPath, entryName string, info os.FileInfo,
+	method uint16,
+) error {
 	reader, err := conn.getFileReader(entryPath, 0, http.MethodGet)
 	if err != nil {
 		conn.Log(logger.LevelDebug, "unable to add zip entry %q, cannot open file: %v", entryPath, err)
@@ -468,7 +494,7 @@ func addFileToZipEntry(wr *zip.Writer, conn *Connection, entryPath, entryName st
 
 	f, err := wr.CreateHeader(&zip.FileHeader{
 		Name:     entryName,
-		Method:   zip.Deflate,
+		Method:   method,
 		Modified: info.ModTime(),
 	})
 	if err != nil {
@@ -502,8 +528,9 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
+	etag := vfs.GetETag(info)
 	rangeHeader := r.Header.Get("Range")
-	if rangeHeader != "" && checkIfRange(r, info.ModTime()) == condFalse {
+	if rangeHeader != "" && checkIfRange(r, info.ModTime(), etag) == condFalse {
 		rangeHeader = ""
 	}
 	offset := int64(0)
@@ -525,8 +552,23 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	}
 	defer reader.Close()
 
+	var bufReader *bufio.Reader
+	if r.Method != http.MethodHead && size > 0 {
+		// archived objects are reported on the first read, we need to know
+		// before sending the response headers. The buffered reader must not
+		// read past the requested range, the read bytes count as downloaded
+		bufReader = bufio.NewReader(io.LimitReader(reader, size))
+		if _, err := bufReader.Peek(1); errors.Is(err, vfs.ErrObjectArchived) {
+			return getMappedStatusCode(err), util.NewI18nError(fmt.Errorf("unable to read file %q: %w", name, err),
+				util.I18nErrorFsArchived)
+		}
+	}
+
 	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
-	if checkPreconditions(w, r, info.ModTime()) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if checkPreconditions(w, r, info.ModTime(), etag) {
 		return 0, fmt.Errorf("%v", http.StatusText(http.StatusPreconditionFailed))
 	}
 	ctype := mime.TypeByExtension(path.Ext(name))
@@ -542,9 +584,20 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
 	}
 	w.Header().Set("Accept-Ranges", "bytes")
+	closeWriter := func() error { return nil }
+	if responseStatus == http.StatusOK {
+		w, closeWriter = common.WrapCompressedResponse(w, r)
+	}
 	w.WriteHeader(responseStatus)
 	if r.Method != http.MethodHead {
-		_, err = io.CopyN(w, reader, size)
+		if bufReader != nil {
+			_, err = io.CopyN(w, bufReader, size)
+		} else {
+			_, err = io.CopyN(w, reader, size)
+		}
+		if err == nil {
+			err = closeWriter()
+		}
 		if err != nil {
 			if share != nil {
 				dataprovider.UpdateShareLastUse(share, -1) //nolint:errcheck
@@ -556,18 +609,68 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	return http.StatusOK, nil
 }
 
-func checkPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
-	if checkIfUnmodifiedSince(r, modtime) == condFalse {
+func checkPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time, etag string) bool {
+	ch := checkIfMatch(r, etag)
+	if ch == condNone {
+		ch = checkIfUnmodifiedSince(r, modtime)
+	}
+	if ch == condFalse {
 		w.WriteHeader(http.StatusPreconditionFailed)
 		return true
 	}
-	if checkIfModifiedSince(r, modtime) == condFalse {
+	switch checkIfNoneMatch(r, etag) {
+	case condFalse:
 		w.WriteHeader(http.StatusNotModified)
 		return true
+	case condNone:
+		if checkIfModifiedSince(r, modtime) == condFalse {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
 	}
 	return false
 }
 
+// checkIfMatch evaluates the If-Match header using the strong comparison,
+// weak entity tags never match
+func checkIfMatch(r *http.Request, etag string) condResult {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return condNone
+	}
+	for _, val := range strings.Split(im, ",") {
+		val = strings.TrimSpace(val)
+		if val == "*" {
+			return condTrue
+		}
+		if etag != "" && val == etag && !strings.HasPrefix(val, "W/") {
+			return condTrue
+		}
+	}
+	return condFalse
+}
+
+// checkIfNoneMatch evaluates the If-None-Match header using the weak comparison
+func checkIfNoneMatch(r *http.Request, etag string) condResult {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return condNone
+	}
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return condNone
+	}
+	for _, val := range strings.Split(inm, ",") {
+		val = strings.TrimSpace(val)
+		if val == "*" {
+			return condFalse
+		}
+		if etag != "" && strings.TrimPrefix(val, "W/") == strings.TrimPrefix(etag, "W/") {
+			return condFalse
+		}
+	}
+	return condTrue
+}
+
 func checkIfUnmodifiedSince(r *http.Request, modtime time.Time) condResult {
 	ius := r.Header.Get("If-Unmodified-Since")
 	if ius == "" || isZeroTime(modtime) {
@@ -608,7 +711,7 @@ func checkIfModifiedSince(r *http.Request, modtime time.Time) condResult {
 	return condTrue
 }
 
-func checkIfRange(r *http.Request, modtime time.Time) condResult {
+func checkIfRange(r *http.Request, modtime time.Time, etag string) condResult {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		return condNone
 	}
@@ -616,6 +719,12 @@ func checkIfRange(r *http.Request, modtime time.Time) condResult {
 	if ir == "" {
 		return condNone
 	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		if etag != "" && ir == etag && !strings.HasPrefix(ir, "W/") {
+			return condTrue
+		}
+		return condFalse
+	}
 	if modtime.IsZero() {
 		return condFalse
 	}
@@ -724,6 +833,14 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 			util.I18nErrorProtocolForbidden,
 		)
 	}
+	if tenant, ok := r.Context().Value(bindingTenantKey).(string); ok && user.Tenant != tenant {
+		logger.Info(logSender, connectionID, "cannot login user %q, tenant %q is not allowed on this binding",
+			user.Username, user.Tenant)
+		return util.NewI18nError(
+			fmt.Errorf("login for user %q is not allowed on this binding", user.Username),
+			util.I18nErrorTenantBindingForbidden,
+		)
+	}
 	if !isLoggedInWithOIDC(r) && !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, common.ProtocolHTTP) {
 		logger.Info(logSender, connectionID, "cannot login user %q, password login method is not allowed", user.Username)
 		return util.NewI18nError(
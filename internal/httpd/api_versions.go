@@ -0,0 +1,73 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+func getUserFileVersions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	versions, err := connection.GetFileVersions(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to get the versions for file %q", name), getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, versions)
+}
+
+func restoreUserFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if _, err := connection.RestoreFileVersion(name, getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore the version for file %q", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Version restored", http.StatusOK)
+}
+
+func deleteUserFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if err := connection.DeleteFileVersion(name, getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to delete the version for file %q", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Version deleted", http.StatusOK)
+}
@@ -42,6 +42,8 @@ const (
 	tokenAudienceCSRF             tokenAudience = "CSRF"
 	tokenAudienceOAuth2           tokenAudience = "OAuth2"
 	tokenAudienceWebLogin         tokenAudience = "WebLogin"
+	tokenAudienceTrustedDevice    tokenAudience = "TrustedDevice"
+	tokenAudienceWOPI             tokenAudience = "WOPI"
 )
 
 type tokenValidation = int
@@ -55,6 +57,7 @@ const (
 	claimUsernameKey                = "username"
 	claimPermissionsKey             = "permissions"
 	claimRole                       = "role"
+	claimTenant                     = "tenant"
 	claimAPIKey                     = "api_key"
 	claimNodeID                     = "node_id"
 	claimMustChangePasswordKey      = "chpwd"
@@ -62,6 +65,10 @@ const (
 	claimRequiredTwoFactorProtocols = "2fa_protos"
 	claimHideUserPageSection        = "hus"
 	claimRef                        = "ref"
+	claimOAuth2ClientKey            = "oauth2_client"
+	claimOAuth2ScopeKey             = "oauth2_scope"
+	claimOAuth2PathKey              = "oauth2_path"
+	claimImpersonatorKey            = "impersonator"
 	basicRealm                      = "Basic realm=\"SFTPGo\""
 	jwtCookieKey                    = "jwt"
 )
@@ -73,13 +80,16 @@ var (
 	// with the login form
 	csrfTokenDuration     = 4 * time.Hour
 	tokenRefreshThreshold = 10 * time.Minute
-	tokenValidationMode   = tokenValidationFull
+	// impersonation tokens are not refreshed
+	impersonationTokenDuration = 15 * time.Minute
+	tokenValidationMode        = tokenValidationFull
 )
 
 type jwtTokenClaims struct {
 	Username                   string
 	Permissions                []string
 	Role                       string
+	Tenant                     string
 	Signature                  string
 	Audience                   []string
 	APIKeyID                   string
@@ -90,6 +100,13 @@ type jwtTokenClaims struct {
 	HideUserPageSections       int
 	JwtID                      string
 	Ref                        string
+	OAuth2ClientID             string
+	OAuth2Scope                string
+	OAuth2Path                 string
+	// username of the admin impersonating the user
+	Impersonator string
+	// if set, overrides the default token duration
+	duration time.Duration
 }
 
 func (c *jwtTokenClaims) hasUserAudience() bool {
@@ -116,12 +133,23 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.Role != "" {
 		claims[claimRole] = c.Role
 	}
+	if c.Tenant != "" {
+		claims[claimTenant] = c.Tenant
+	}
 	if c.APIKeyID != "" {
 		claims[claimAPIKey] = c.APIKeyID
 	}
 	if c.NodeID != "" {
 		claims[claimNodeID] = c.NodeID
 	}
+	if c.OAuth2ClientID != "" {
+		claims[claimOAuth2ClientKey] = c.OAuth2ClientID
+		claims[claimOAuth2ScopeKey] = c.OAuth2Scope
+		claims[claimOAuth2PathKey] = c.OAuth2Path
+	}
+	if c.Impersonator != "" {
+		claims[claimImpersonatorKey] = c.Impersonator
+	}
 	claims[jwt.SubjectKey] = c.Signature
 	if c.MustChangePassword {
 		claims[claimMustChangePasswordKey] = c.MustChangePassword
@@ -203,6 +231,20 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 		c.Role = c.decodeString(val)
 	}
 
+	if val, ok := token[claimTenant]; ok {
+		c.Tenant = c.decodeString(val)
+	}
+
+	if val, ok := token[claimOAuth2ClientKey]; ok {
+		c.OAuth2ClientID = c.decodeString(val)
+		c.OAuth2Scope = c.decodeString(token[claimOAuth2ScopeKey])
+		c.OAuth2Path = c.decodeString(token[claimOAuth2PathKey])
+	}
+
+	if val, ok := token[claimImpersonatorKey]; ok {
+		c.Impersonator = c.decodeString(val)
+	}
+
 	permissions := token[claimPermissionsKey]
 	c.Permissions = c.decodeSliceString(permissions)
 
@@ -227,24 +269,12 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 }
 
 func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
-	if util.Contains(permissions, dataprovider.PermAdminAny) {
-		return false
-	}
-	if (util.Contains(c.Permissions, dataprovider.PermAdminManageAdmins) ||
-		util.Contains(c.Permissions, dataprovider.PermAdminAny)) &&
-		!util.Contains(permissions, dataprovider.PermAdminManageAdmins) &&
-		!util.Contains(permissions, dataprovider.PermAdminAny) {
-		return true
-	}
-	return false
+	return dataprovider.HasAdminPermission(c.Permissions, dataprovider.PermAdminChangeAdmins) &&
+		!dataprovider.HasAdminPermission(permissions, dataprovider.PermAdminChangeAdmins)
 }
 
 func (c *jwtTokenClaims) hasPerm(perm string) bool {
-	if util.Contains(c.Permissions, dataprovider.PermAdminAny) {
-		return true
-	}
-
-	return util.Contains(c.Permissions, perm)
+	return dataprovider.HasAdminPermission(c.Permissions, perm)
 }
 
 func (c *jwtTokenClaims) createToken(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, ip string) (jwt.Token, string, error) {
@@ -255,9 +285,12 @@ func (c *jwtTokenClaims) createToken(tokenAuth *jwtauth.JWTAuth, audience tokenA
 		claims[jwt.JwtIDKey] = xid.New().String()
 	}
 	claims[jwt.NotBeforeKey] = now.Add(-30 * time.Second)
-	if audience == tokenAudienceWebLogin {
+	switch {
+	case audience == tokenAudienceWebLogin:
 		claims[jwt.ExpirationKey] = now.Add(csrfTokenDuration)
-	} else {
+	case c.duration > 0:
+		claims[jwt.ExpirationKey] = now.Add(c.duration)
+	default:
 		claims[jwt.ExpirationKey] = now.Add(tokenDuration)
 	}
 	claims[jwt.AudienceKey] = []string{audience, ip}
@@ -295,6 +328,9 @@ func (c *jwtTokenClaims) createAndSetCookie(w http.ResponseWriter, r *http.Reque
 	if audience == tokenAudienceWebShare {
 		duration = shareTokenDuration
 	}
+	if c.duration > 0 {
+		duration = c.duration
+	}
 	setCookie(w, r, basePath, resp["access_token"].(string), duration)
 
 	return nil
@@ -391,6 +427,7 @@ func getUserFromToken(r *http.Request) *dataprovider.User {
 	user.Username = tokenClaims.Username
 	user.Filters.WebClient = tokenClaims.Permissions
 	user.Role = tokenClaims.Role
+	user.Tenant = tokenClaims.Tenant
 	return user
 }
 
@@ -406,6 +443,7 @@ func getAdminFromToken(r *http.Request) *dataprovider.Admin {
 	admin.Permissions = tokenClaims.Permissions
 	admin.Filters.Preferences.HideUserPageSections = tokenClaims.HideUserPageSections
 	admin.Role = tokenClaims.Role
+	admin.Tenant = tokenClaims.Tenant
 	return admin
 }
 
@@ -16,6 +16,7 @@ package httpd
 
 import (
 	"io"
+	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -57,7 +58,9 @@ func (f *httpdFile) Read(p []byte) (n int, err error) {
 
 	f.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = f.reader.Read(p)
+	f.UpdateIOMetrics(startTime)
 	f.BytesSent.Add(int64(n))
 
 	if err == nil {
@@ -82,7 +85,9 @@ func (f *httpdFile) Write(p []byte) (n int, err error) {
 
 	f.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = f.writer.Write(p)
+	f.UpdateIOMetrics(startTime)
 	f.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -123,6 +128,9 @@ func (f *httpdFile) closeIO() error {
 			f.ErrTransfer = err
 		}
 		f.Unlock()
+		if metadater, ok := f.writer.(vfs.Metadater); ok {
+			f.BaseTransfer.AddMetadata(metadater.Metadata())
+		}
 	} else if f.reader != nil {
 		err = f.reader.Close()
 		if metadater, ok := f.reader.(vfs.Metadater); ok {
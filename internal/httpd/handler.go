@@ -35,6 +35,9 @@ import (
 type Connection struct {
 	*common.BaseConnection
 	request *http.Request
+	// metadata to attach to the uploaded files, for example the uploader
+	// details for file request shares
+	metadata map[string]string
 }
 
 // GetClientVersion returns the connected client's version.
@@ -94,22 +97,31 @@ func (c *Connection) ReadDir(name string) (vfs.DirLister, error) {
 	return c.ListDir(name)
 }
 
-func (c *Connection) getFileReader(name string, offset int64, method string) (io.ReadCloser, error) {
-	c.UpdateLastActivity()
-
-	transferQuota := c.GetTransferQuota()
+// checkReadAllowed returns an error if the transfer quota, the download
+// permission or the file patterns do not allow to read the specified file
+func (c *Connection) checkReadAllowed(name string, transferQuota dataprovider.TransferQuota) error {
 	if !transferQuota.HasDownloadSpace() {
 		c.Log(logger.LevelInfo, "denying file read due to quota limits")
-		return nil, util.NewI18nError(c.GetReadQuotaExceededError(), util.I18nErrorQuotaRead)
+		return util.NewI18nError(c.GetReadQuotaExceededError(), util.I18nErrorQuotaRead)
 	}
 
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(name)) {
-		return nil, util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
+		return util.NewI18nError(c.GetPermissionDeniedError(), util.I18nError403Message)
 	}
 
 	if ok, policy := c.User.IsFileAllowed(name); !ok {
 		c.Log(logger.LevelWarn, "reading file %q is not allowed", name)
-		return nil, util.NewI18nError(c.GetErrorForDeniedFile(policy), util.I18nError403Message)
+		return util.NewI18nError(c.GetErrorForDeniedFile(policy), util.I18nError403Message)
+	}
+	return nil
+}
+
+func (c *Connection) getFileReader(name string, offset int64, method string) (io.ReadCloser, error) {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if err := c.checkReadAllowed(name, transferQuota); err != nil {
+		return nil, err
 	}
 
 	fs, p, err := c.GetFsAndResolvedPath(name)
@@ -124,7 +136,9 @@ func (c *Connection) getFileReader(name string, offset int64, method string) (io
 		}
 	}
 
+	startTime := time.Now()
 	file, r, cancelFn, err := fs.Open(p, offset)
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "could not open file %q for reading: %+v", p, err)
 		return nil, c.GetFsError(fs, err)
@@ -200,8 +214,15 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 	}
 
 	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile {
+		if err := c.CreateFileVersion(fs, filePath, requestPath, fileSize); err != nil {
+			return nil, err
+		}
+	}
 
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.GetCreateChecks(requestPath, isNewFile, false))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, source: %q, err: %+v", filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -233,6 +254,9 @@ func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, request
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	if len(c.metadata) > 0 {
+		baseTransfer.SetMetadata(c.metadata)
+	}
 	return newHTTPDFile(baseTransfer, w, nil), nil
 }
 
@@ -30,14 +30,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/v2/internal/acme"
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/s3gateway"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
@@ -58,6 +61,8 @@ const (
 	serverStatusPath                      = "/api/v2/status"
 	dumpDataPath                          = "/api/v2/dumpdata"
 	loadDataPath                          = "/api/v2/loaddata"
+	passwordHashesPath                    = "/api/v2/passwordhashes"
+	reloadConfigPath                      = "/api/v2/reload"
 	defenderHosts                         = "/api/v2/defender/hosts"
 	adminPath                             = "/api/v2/admins"
 	adminPwdPath                          = "/api/v2/admin/changepwd"
@@ -68,30 +73,48 @@ const (
 	userFileActionsPath                   = "/api/v2/user/file-actions"
 	userStreamZipPath                     = "/api/v2/user/streamzip"
 	userUploadFilePath                    = "/api/v2/user/files/upload"
+	userThumbnailsPath                    = "/api/v2/user/thumbnails"
+	userTusPath                           = "/api/v2/user/tus"
 	userFilesDirsMetadataPath             = "/api/v2/user/files/metadata"
+	userFileVersionsPath                  = "/api/v2/user/files/versions"
+	userJobsPath                          = "/api/v2/user/jobs"
 	apiKeysPath                           = "/api/v2/apikeys"
 	adminTOTPConfigsPath                  = "/api/v2/admin/totp/configs"
 	adminTOTPGeneratePath                 = "/api/v2/admin/totp/generate"
 	adminTOTPValidatePath                 = "/api/v2/admin/totp/validate"
 	adminTOTPSavePath                     = "/api/v2/admin/totp/save"
 	admin2FARecoveryCodesPath             = "/api/v2/admin/2fa/recoverycodes"
+	adminTrustedDevicesPath               = "/api/v2/admin/2fa/trusteddevices"
 	userTOTPConfigsPath                   = "/api/v2/user/totp/configs"
 	userTOTPGeneratePath                  = "/api/v2/user/totp/generate"
 	userTOTPValidatePath                  = "/api/v2/user/totp/validate"
 	userTOTPSavePath                      = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath              = "/api/v2/user/2fa/recoverycodes"
+	userTrustedDevicesPath                = "/api/v2/user/2fa/trusteddevices"
 	userProfilePath                       = "/api/v2/user/profile"
+	userSSHCertPath                       = "/api/v2/user/sshcert"
+	userS3CredentialsPath                 = "/api/v2/user/s3credentials"
 	userSharesPath                        = "/api/v2/user/shares"
+	userTrashPath                         = "/api/v2/user/trash"
+	userSearchPath                        = "/api/v2/user/search"
 	retentionBasePath                     = "/api/v2/retention/users"
 	retentionChecksPath                   = "/api/v2/retention/users/checks"
 	fsEventsPath                          = "/api/v2/events/fs"
 	providerEventsPath                    = "/api/v2/events/provider"
 	logEventsPath                         = "/api/v2/events/logs"
+	liveEventsPath                        = "/api/v2/events/live"
+	graphQLPath                           = "/api/v2/graphql"
 	sharesPath                            = "/api/v2/shares"
 	eventActionsPath                      = "/api/v2/eventactions"
 	eventRulesPath                        = "/api/v2/eventrules"
 	rolesPath                             = "/api/v2/roles"
+	tenantsPath                           = "/api/v2/tenants"
 	ipListsPath                           = "/api/v2/iplists"
+	jobsPath                              = "/api/v2/jobs"
+	auditLogPath                          = "/api/v2/auditlog"
+	auditLogVerifyPath                    = "/api/v2/auditlog/verify"
+	oauth2AppsPath                        = "/api/v2/oauth2/apps"
+	oauth2TokenPath                       = "/api/v2/oauth2/token"
 	healthzPath                           = "/healthz"
 	webRootPathDefault                    = "/"
 	webBasePathDefault                    = "/web"
@@ -101,6 +124,10 @@ const (
 	webAdminLoginPathDefault              = "/web/admin/login"
 	webAdminOIDCLoginPathDefault          = "/web/admin/oidclogin"
 	webOIDCRedirectPathDefault            = "/web/oidc/redirect"
+	webSAMLMetadataPathDefault            = "/web/saml/metadata"
+	webSAMLACSPathDefault                 = "/web/saml/acs"
+	webSAMLSLOPathDefault                 = "/web/saml/slo"
+	webAdminSAMLLoginPathDefault          = "/web/admin/samllogin"
 	webOAuth2RedirectPathDefault          = "/web/oauth2/redirect"
 	webOAuth2TokenPathDefault             = "/web/admin/oauth2/token"
 	webAdminTwoFactorPathDefault          = "/web/admin/twofactor"
@@ -132,10 +159,13 @@ const (
 	webAdminEventActionPathDefault        = "/web/admin/eventaction"
 	webAdminRolesPathDefault              = "/web/admin/roles"
 	webAdminRolePathDefault               = "/web/admin/role"
+	webAdminTenantsPathDefault            = "/web/admin/tenants"
+	webAdminTenantPathDefault             = "/web/admin/tenant"
 	webAdminTOTPGeneratePathDefault       = "/web/admin/totp/generate"
 	webAdminTOTPValidatePathDefault       = "/web/admin/totp/validate"
 	webAdminTOTPSavePathDefault           = "/web/admin/totp/save"
 	webAdminRecoveryCodesPathDefault      = "/web/admin/recoverycodes"
+	webAdminTrustedDevicesPathDefault     = "/web/admin/trusteddevices"
 	webTemplateUserDefault                = "/web/admin/template/user"
 	webTemplateFolderDefault              = "/web/admin/template/folder"
 	webDefenderPathDefault                = "/web/admin/defender"
@@ -146,16 +176,23 @@ const (
 	webEventsFsSearchPathDefault          = "/web/admin/events/fs"
 	webEventsProviderSearchPathDefault    = "/web/admin/events/provider"
 	webEventsLogSearchPathDefault         = "/web/admin/events/logs"
+	webLiveEventsPathDefault              = "/web/admin/events/live"
+	webLiveEventsStreamPathDefault        = "/web/admin/events/live/stream"
 	webConfigsPathDefault                 = "/web/admin/configs"
 	webClientLoginPathDefault             = "/web/client/login"
 	webClientOIDCLoginPathDefault         = "/web/client/oidclogin"
+	webClientSAMLLoginPathDefault         = "/web/client/samllogin"
 	webClientTwoFactorPathDefault         = "/web/client/twofactor"
 	webClientTwoFactorRecoveryPathDefault = "/web/client/twofactor-recovery"
 	webClientFilesPathDefault             = "/web/client/files"
 	webClientFilePathDefault              = "/web/client/file"
+	webClientTusPathDefault               = "/web/client/tus"
 	webClientFileActionsPathDefault       = "/web/client/file-actions"
 	webClientSharesPathDefault            = "/web/client/shares"
 	webClientSharePathDefault             = "/web/client/share"
+	webClientTrashPathDefault             = "/web/client/trash"
+	webClientFileVersionsPathDefault      = "/web/client/files/versions"
+	webClientSearchPathDefault            = "/web/client/search"
 	webClientEditFilePathDefault          = "/web/client/editfile"
 	webClientDirsPathDefault              = "/web/client/dirs"
 	webClientDownloadZipPathDefault       = "/web/client/downloadzip"
@@ -166,6 +203,8 @@ const (
 	webClientTOTPValidatePathDefault      = "/web/client/totp/validate"
 	webClientTOTPSavePathDefault          = "/web/client/totp/save"
 	webClientRecoveryCodesPathDefault     = "/web/client/recoverycodes"
+	webClientTrustedDevicesPathDefault    = "/web/client/trusteddevices"
+	webClientSSHCertPathDefault           = "/web/client/sshcert"
 	webChangeClientPwdPathDefault         = "/web/client/changepwd"
 	webClientLogoutPathDefault            = "/web/client/logout"
 	webClientPubSharesPathDefault         = "/web/client/pubshares"
@@ -173,22 +212,70 @@ const (
 	webClientResetPwdPathDefault          = "/web/client/reset-password"
 	webClientViewPDFPathDefault           = "/web/client/viewpdf"
 	webClientGetPDFPathDefault            = "/web/client/getpdf"
+	webClientPreviewPathDefault           = "/web/client/preview"
+	webClientGetPreviewPathDefault        = "/web/client/getpreview"
+	webClientThumbnailsPathDefault        = "/web/client/thumbnails"
+	webClientWOPIPathDefault              = "/web/client/wopi"
+	wopiFilesPathDefault                  = "/wopi/files"
 	webClientExistPathDefault             = "/web/client/exist"
 	webClientTasksPathDefault             = "/web/client/tasks"
+	webClientOAuth2AuthorizePathDefault   = "/web/client/oauth2/authorize"
 	webStaticFilesPathDefault             = "/static"
 	webOpenAPIPathDefault                 = "/openapi"
 	// MaxRestoreSize defines the max size for the loaddata input file
-	MaxRestoreSize       = 20 * 1048576 // 20 MB
-	maxRequestSize       = 1048576      // 1MB
-	maxLoginBodySize     = 262144       // 256 KB
-	httpdMaxEditFileSize = 2 * 1048576  // 2 MB
-	maxMultipartMem      = 10 * 1048576 // 10 MB
-	osWindows            = "windows"
-	otpHeaderCode        = "X-SFTPGO-OTP"
-	mTimeHeader          = "X-SFTPGO-MTIME"
-	acmeChallengeURI     = "/.well-known/acme-challenge/"
+	MaxRestoreSize         = 20 * 1048576 // 20 MB
+	maxRequestSize         = 1048576      // 1MB
+	maxLoginBodySize       = 262144       // 256 KB
+	httpdMaxEditFileSize   = 2 * 1048576  // 2 MB
+	maxMultipartMem        = 10 * 1048576 // 10 MB
+	maxClientSearchResults = 500
+	osWindows              = "windows"
+	otpHeaderCode          = "X-SFTPGO-OTP"
+	mTimeHeader            = "X-SFTPGO-MTIME"
+	acmeChallengeURI       = "/.well-known/acme-challenge/"
+	zipCompressionStore    = "store"
+	zipCompressionDeflate  = "deflate"
 )
 
+// apiKeyResources maps the API endpoints to the resources that can be granted to API keys
+var apiKeyResources = []struct {
+	path     string
+	resource string
+}{
+	{path: userPath, resource: "users"},
+	{path: groupPath, resource: "groups"},
+	{path: folderPath, resource: "folders"},
+	{path: adminPath, resource: "admins"},
+	{path: rolesPath, resource: "roles"},
+	{path: tenantsPath, resource: "tenants"},
+	{path: quotasBasePath, resource: "quotas"},
+	{path: retentionBasePath, resource: "retention"},
+	{path: activeConnectionsPath, resource: "connections"},
+	{path: fsEventsPath, resource: "events"},
+	{path: providerEventsPath, resource: "events"},
+	{path: logEventsPath, resource: "events"},
+	{path: liveEventsPath, resource: "events"},
+	{path: eventActionsPath, resource: "eventmanager"},
+	{path: eventRulesPath, resource: "eventmanager"},
+	{path: ipListsPath, resource: "iplists"},
+	{path: defenderHosts, resource: "defender"},
+	{path: dumpDataPath, resource: "maintenance"},
+	{path: loadDataPath, resource: "maintenance"},
+	{path: reloadConfigPath, resource: "maintenance"},
+	{path: passwordHashesPath, resource: "maintenance"},
+	{path: serverStatusPath, resource: "status"},
+	{path: jobsPath, resource: "jobs"},
+	{path: versionPath, resource: "status"},
+	{path: userDirsPath, resource: "files"},
+	{path: userFilesPath, resource: "files"},
+	{path: userFileActionsPath, resource: "files"},
+	{path: userStreamZipPath, resource: "files"},
+	{path: userThumbnailsPath, resource: "files"},
+	{path: userTusPath, resource: "files"},
+	{path: userJobsPath, resource: "files"},
+	{path: userSharesPath, resource: "shares"},
+}
+
 var (
 	certMgr                        *common.CertManager
 	cleanupTicker                  *time.Ticker
@@ -199,10 +286,14 @@ var (
 	webBaseAdminPath               string
 	webBaseClientPath              string
 	webOIDCRedirectPath            string
+	webSAMLMetadataPath            string
+	webSAMLACSPath                 string
+	webSAMLSLOPath                 string
 	webOAuth2RedirectPath          string
 	webOAuth2TokenPath             string
 	webAdminSetupPath              string
 	webAdminOIDCLoginPath          string
+	webAdminSAMLLoginPath          string
 	webAdminLoginPath              string
 	webAdminTwoFactorPath          string
 	webAdminTwoFactorRecoveryPath  string
@@ -230,10 +321,13 @@ var (
 	webAdminEventActionPath        string
 	webAdminRolesPath              string
 	webAdminRolePath               string
+	webAdminTenantsPath            string
+	webAdminTenantPath             string
 	webAdminTOTPGeneratePath       string
 	webAdminTOTPValidatePath       string
 	webAdminTOTPSavePath           string
 	webAdminRecoveryCodesPath      string
+	webAdminTrustedDevicesPath     string
 	webChangeAdminPwdPath          string
 	webAdminForgotPwdPath          string
 	webAdminResetPwdPath           string
@@ -246,17 +340,24 @@ var (
 	webEventsFsSearchPath          string
 	webEventsProviderSearchPath    string
 	webEventsLogSearchPath         string
+	webLiveEventsPath              string
+	webLiveEventsStreamPath        string
 	webConfigsPath                 string
 	webDefenderHostsPath           string
 	webClientLoginPath             string
 	webClientOIDCLoginPath         string
+	webClientSAMLLoginPath         string
 	webClientTwoFactorPath         string
 	webClientTwoFactorRecoveryPath string
 	webClientFilesPath             string
 	webClientFilePath              string
+	webClientTusPath               string
 	webClientFileActionsPath       string
 	webClientSharesPath            string
 	webClientSharePath             string
+	webClientTrashPath             string
+	webClientFileVersionsPath      string
+	webClientSearchPath            string
 	webClientEditFilePath          string
 	webClientDirsPath              string
 	webClientDownloadZipPath       string
@@ -268,14 +369,22 @@ var (
 	webClientTOTPValidatePath      string
 	webClientTOTPSavePath          string
 	webClientRecoveryCodesPath     string
+	webClientTrustedDevicesPath    string
+	webClientSSHCertPath           string
 	webClientPubSharesPath         string
 	webClientLogoutPath            string
 	webClientForgotPwdPath         string
 	webClientResetPwdPath          string
 	webClientViewPDFPath           string
 	webClientGetPDFPath            string
+	webClientPreviewPath           string
+	webClientGetPreviewPath        string
+	webClientThumbnailsPath        string
+	webClientWOPIPath              string
+	wopiFilesPath                  string
 	webClientExistPath             string
 	webClientTasksPath             string
+	webClientOAuth2AuthorizePath   string
 	webStaticFilesPath             string
 	webOpenAPIPath                 string
 	// max upload size for http clients, 1GB by default
@@ -284,6 +393,7 @@ var (
 	installationCode           string
 	installationCodeHint       string
 	fnInstallationCodeResolver FnInstallationCodeResolver
+	fnReloadConfig             func() error
 	configurationDir           string
 )
 
@@ -440,6 +550,31 @@ func (b *UIBranding) check() {
 	}
 }
 
+// withTenant returns a copy of the branding with the non empty tenant
+// customizations applied
+func (b UIBranding) withTenant(t dataprovider.TenantUIBranding) UIBranding {
+	if t.Name != "" {
+		b.Name = t.Name
+	}
+	if t.ShortName != "" {
+		b.ShortName = t.ShortName
+	}
+	if t.LogoPath != "" {
+		b.LogoPath = t.LogoPath
+	}
+	if t.FaviconPath != "" {
+		b.FaviconPath = t.FaviconPath
+	}
+	if t.DisclaimerPath != "" {
+		b.DisclaimerName = t.DisclaimerName
+		b.DisclaimerPath = t.DisclaimerPath
+		if !strings.HasPrefix(b.DisclaimerPath, "https://") && !strings.HasPrefix(b.DisclaimerPath, "http://") {
+			b.DisclaimerPath = path.Join(webStaticFilesPath, b.DisclaimerPath)
+		}
+	}
+	return b
+}
+
 // Branding defines the branding-related customizations supported
 type Branding struct {
 	WebAdmin  UIBranding `json:"web_admin" mapstructure:"web_admin"`
@@ -470,11 +605,13 @@ type Binding struct {
 	EnableRESTAPI bool `json:"enable_rest_api" mapstructure:"enable_rest_api"`
 	// Defines the login methods available for the WebAdmin and WebClient UIs:
 	//
-	// - 0 means any configured method: username/password login form and OIDC, if enabled
+	// - 0 means any configured method: username/password login form, OIDC and SAML, if enabled
 	// - 1 means OIDC for the WebAdmin UI
 	// - 2 means OIDC for the WebClient UI
 	// - 4 means login form for the WebAdmin UI
 	// - 8 means login form for the WebClient UI
+	// - 16 means SAML for the WebAdmin UI
+	// - 32 means SAML for the WebClient UI
 	//
 	// You can combine the values. For example 3 means that you can only login using OIDC on
 	// both WebClient and WebAdmin UI.
@@ -522,8 +659,18 @@ type Binding struct {
 	HideLoginURL int `json:"hide_login_url" mapstructure:"hide_login_url"`
 	// Enable the built-in OpenAPI renderer
 	RenderOpenAPI bool `json:"render_openapi" mapstructure:"render_openapi"`
+	// Tenant restricts this binding to the users of the specified tenant.
+	// An empty value means that any user is allowed
+	Tenant string `json:"tenant" mapstructure:"tenant"`
 	// Defining an OIDC configuration the web admin and web client UI will use OpenID to authenticate users.
 	OIDC OIDC `json:"oidc" mapstructure:"oidc"`
+	// Additional OpenID providers. Each provider must have a unique name, users can
+	// select the provider on the login page or can be routed to it based on the
+	// domain of their email address
+	OIDCProviders []OIDC `json:"oidc_providers" mapstructure:"oidc_providers"`
+	// Defining a SAML configuration the web admin and web client UI will use a SAML 2.0
+	// identity provider to authenticate users.
+	SAML SAML `json:"saml" mapstructure:"saml"`
 	// Security defines security headers to add to HTTP responses and allows to restrict allowed hosts
 	Security SecurityConf `json:"security" mapstructure:"security"`
 	// Branding defines customizations to suit your brand
@@ -601,6 +748,120 @@ func (b *Binding) isWebClientOIDCLoginDisabled() bool {
 	return false
 }
 
+// getOIDC returns the OpenID provider with the specified name, an empty name
+// means the default provider
+func (b *Binding) getOIDC(name string) *OIDC {
+	if name == "" {
+		return &b.OIDC
+	}
+	for idx := range b.OIDCProviders {
+		if b.OIDCProviders[idx].Name == name {
+			return &b.OIDCProviders[idx]
+		}
+	}
+	return nil
+}
+
+// getOIDCForEmail returns the OpenID provider configured for the domain of the specified email
+func (b *Binding) getOIDCForEmail(email string) *OIDC {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok || domain == "" {
+		return nil
+	}
+	if b.OIDC.hasDomain(domain) {
+		return &b.OIDC
+	}
+	for idx := range b.OIDCProviders {
+		if b.OIDCProviders[idx].hasDomain(domain) {
+			return &b.OIDCProviders[idx]
+		}
+	}
+	return nil
+}
+
+func (b *Binding) hasOIDC() bool {
+	if b.OIDC.isEnabled() {
+		return true
+	}
+	for idx := range b.OIDCProviders {
+		if b.OIDCProviders[idx].isEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Binding) hasOIDCRoles() bool {
+	if b.OIDC.hasRoles() {
+		return true
+	}
+	for idx := range b.OIDCProviders {
+		if b.OIDCProviders[idx].hasRoles() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Binding) hasOIDCDomains(adminOnly bool) bool {
+	providers := append([]OIDC{b.OIDC}, b.OIDCProviders...)
+	for idx := range providers {
+		if len(providers[idx].Domains) == 0 || !providers[idx].isEnabled() {
+			continue
+		}
+		if !adminOnly || providers[idx].hasRoles() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Binding) initializeOIDC() error {
+	b.OIDC.Name = ""
+	if err := b.OIDC.initialize(); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for idx := range b.OIDCProviders {
+		provider := &b.OIDCProviders[idx]
+		provider.Name = strings.TrimSpace(provider.Name)
+		if provider.Name == "" {
+			return fmt.Errorf("oidc: the provider at index %d has no name", idx)
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("oidc: duplicate provider name %q", provider.Name)
+		}
+		names[provider.Name] = true
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+		}
+		if err := provider.initialize(); err != nil {
+			return fmt.Errorf("oidc: unable to initialize provider %q: %w", provider.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *Binding) isWebAdminSAMLLoginDisabled() bool {
+	if b.EnableWebAdmin {
+		if b.EnabledLoginMethods == 0 {
+			return false
+		}
+		return b.EnabledLoginMethods&16 == 0
+	}
+	return false
+}
+
+func (b *Binding) isWebClientSAMLLoginDisabled() bool {
+	if b.EnableWebClient {
+		if b.EnabledLoginMethods == 0 {
+			return false
+		}
+		return b.EnabledLoginMethods&32 == 0
+	}
+	return false
+}
+
 func (b *Binding) isWebAdminLoginFormDisabled() bool {
 	if b.EnableWebAdmin {
 		if b.EnabledLoginMethods == 0 {
@@ -622,19 +883,17 @@ func (b *Binding) isWebClientLoginFormDisabled() bool {
 }
 
 func (b *Binding) checkLoginMethods() error {
-	if b.isWebAdminLoginFormDisabled() && b.isWebAdminOIDCLoginDisabled() {
-		return errors.New("no login method available for WebAdmin UI")
-	}
-	if !b.isWebAdminOIDCLoginDisabled() {
-		if b.isWebAdminLoginFormDisabled() && !b.OIDC.hasRoles() {
+	if b.isWebAdminLoginFormDisabled() {
+		hasOIDC := !b.isWebAdminOIDCLoginDisabled() && b.hasOIDCRoles()
+		hasSAML := !b.isWebAdminSAMLLoginDisabled() && b.SAML.hasRoles()
+		if !hasOIDC && !hasSAML {
 			return errors.New("no login method available for WebAdmin UI")
 		}
 	}
-	if b.isWebClientLoginFormDisabled() && b.isWebClientOIDCLoginDisabled() {
-		return errors.New("no login method available for WebClient UI")
-	}
-	if !b.isWebClientOIDCLoginDisabled() {
-		if b.isWebClientLoginFormDisabled() && !b.OIDC.isEnabled() {
+	if b.isWebClientLoginFormDisabled() {
+		hasOIDC := !b.isWebClientOIDCLoginDisabled() && b.hasOIDC()
+		hasSAML := !b.isWebClientSAMLLoginDisabled() && b.SAML.isEnabled()
+		if !hasOIDC && !hasSAML {
 			return errors.New("no login method available for WebClient UI")
 		}
 	}
@@ -688,11 +947,15 @@ type ServicesStatus struct {
 	SSH          sftpd.ServiceStatus         `json:"ssh"`
 	FTP          ftpd.ServiceStatus          `json:"ftp"`
 	WebDAV       webdavd.ServiceStatus       `json:"webdav"`
+	S3           s3gateway.ServiceStatus     `json:"s3"`
+	GRPC         grpcd.ServiceStatus         `json:"grpc"`
 	DataProvider dataprovider.ProviderStatus `json:"data_provider"`
 	Defender     defenderStatus              `json:"defender"`
 	MFA          mfa.ServiceStatus           `json:"mfa"`
 	AllowList    allowListStatus             `json:"allow_list"`
 	RateLimiters rateLimiters                `json:"rate_limiters"`
+	// Status of the scheduled data provider backups for this node
+	ScheduledBackups dataprovider.ScheduledBackupStatus `json:"scheduled_backups"`
 }
 
 // SetupConfig defines the configuration parameters for the initial web admin setup
@@ -767,12 +1030,17 @@ type Conf struct {
 	Setup SetupConfig `json:"setup" mapstructure:"setup"`
 	// If enabled, the link to the sponsors section will not appear on the setup screen page
 	HideSupportLink bool `json:"hide_support_link" mapstructure:"hide_support_link"`
-	acmeDomain      string
+	// Thumbnails generation configuration
+	Thumbnails ThumbnailsConfig `json:"thumbnails" mapstructure:"thumbnails"`
+	// WOPI host configuration, allows to edit office documents using Collabora Online or OnlyOffice
+	WOPI       WOPIConfig `json:"wopi" mapstructure:"wopi"`
+	acmeDomain string
 }
 
 type apiResponse struct {
 	Error   string `json:"error,omitempty"`
 	Message string `json:"message"`
+	JobID   string `json:"job_id,omitempty"`
 }
 
 // ShouldBind returns true if there is at least a valid binding
@@ -829,6 +1097,17 @@ func (c *Conf) getRedacted() Conf {
 		if binding.OIDC.ClientSecret != "" {
 			binding.OIDC.ClientSecret = redacted
 		}
+		providers := make([]OIDC, 0, len(binding.OIDCProviders))
+		for _, provider := range binding.OIDCProviders {
+			if provider.ClientID != "" {
+				provider.ClientID = redacted
+			}
+			if provider.ClientSecret != "" {
+				provider.ClientSecret = redacted
+			}
+			providers = append(providers, provider)
+		}
+		binding.OIDCProviders = providers
 		conf.Bindings = append(conf.Bindings, binding)
 	}
 	return conf
@@ -932,6 +1211,8 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 	oidcMgr = newOIDCManager(isShared)
 	oauth2Mgr = newOAuth2Manager(isShared)
 	webTaskMgr = newWebTaskManager(isShared)
+	trustedDevicesMgr = newTrustedDeviceManager()
+	oauth2CodesMgr = newOAuth2CodeManager(isShared)
 	staticFilesPath := util.FindSharedDataPath(c.StaticFilesPath, configDir)
 	templatesPath := util.FindSharedDataPath(c.TemplatesPath, configDir)
 	openAPIPath := util.FindSharedDataPath(c.OpenAPIPath, configDir)
@@ -939,6 +1220,12 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 		return err
 	}
 	c.loadTemplates(templatesPath)
+	if err := c.Thumbnails.initialize(configDir); err != nil {
+		return err
+	}
+	if err := c.WOPI.initialize(); err != nil {
+		return err
+	}
 	keyPairs := c.getKeyPairs(configDir)
 	if len(keyPairs) > 0 {
 		mgr, err := common.NewCertManager(keyPairs, configDir, logSender)
@@ -967,6 +1254,13 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 	hideSupportLink = c.HideSupportLink
 
 	exitChannel := make(chan error, 1)
+	signingPassphrase := c.SigningPassphrase
+	cors := c.Cors
+	runningServers.setServerBuilder(func(b Binding) *httpdServer {
+		server := newHttpdServer(b, staticFilesPath, signingPassphrase, cors, openAPIPath)
+		server.setShared(isShared)
+		return server
+	})
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
@@ -979,7 +1273,11 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 		binding.Security.updateProxyHeaders()
 
 		go func(b Binding) {
-			if err := b.OIDC.initialize(); err != nil {
+			if err := b.initializeOIDC(); err != nil {
+				exitChannel <- err
+				return
+			}
+			if err := b.SAML.initialize(); err != nil {
 				exitChannel <- err
 				return
 			}
@@ -990,7 +1288,11 @@ func (c *Conf) Initialize(configDir string, isShared int) error {
 			server := newHttpdServer(b, staticFilesPath, c.SigningPassphrase, c.Cors, openAPIPath)
 			server.setShared(isShared)
 
-			exitChannel <- server.listenAndServe()
+			err := server.listenAndServe(runningServers.add(b))
+			if errors.Is(err, errBindingRemoved) {
+				return
+			}
+			exitChannel <- err
 		}(binding)
 	}
 
@@ -1034,9 +1336,11 @@ func getServicesStatus() *ServicesStatus {
 		SSH:          sftpd.GetStatus(),
 		FTP:          ftpd.GetStatus(),
 		WebDAV:       webdavd.GetStatus(),
+		S3:           s3gateway.GetStatus(),
+		GRPC:         grpcd.GetStatus(),
 		DataProvider: dataprovider.GetProviderStatus(),
 		Defender: defenderStatus{
-			IsActive: common.Config.DefenderConfig.Enabled,
+			IsActive: common.IsDefenderEnabled(),
 		},
 		MFA: mfa.GetStatus(),
 		AllowList: allowListStatus{
@@ -1046,10 +1350,47 @@ func getServicesStatus() *ServicesStatus {
 			IsActive:  rtlEnabled,
 			Protocols: rtlProtocols,
 		},
+		ScheduledBackups: dataprovider.GetScheduledBackupStatus(),
 	}
 	return status
 }
 
+// healthStatus defines the details returned by the health check endpoint.
+// The endpoint does not require authentication so no error details are included
+type healthStatus struct {
+	Status           string                 `json:"status"`
+	ScheduledBackups *scheduledBackupHealth `json:"scheduled_backups,omitempty"`
+}
+
+type scheduledBackupHealth struct {
+	// ok, failed or pending if no backup was executed yet
+	Status      string `json:"status"`
+	LastRun     int64  `json:"last_run,omitempty"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+}
+
+func getHealthStatus() healthStatus {
+	result := healthStatus{
+		Status: "ok",
+	}
+	backupStatus := dataprovider.GetScheduledBackupStatus()
+	if backupStatus.Enabled {
+		result.ScheduledBackups = &scheduledBackupHealth{
+			Status:      "pending",
+			LastRun:     backupStatus.LastRun,
+			LastSuccess: backupStatus.LastSuccess,
+		}
+		if backupStatus.LastRun > 0 {
+			if backupStatus.LastError != "" {
+				result.ScheduledBackups.Status = "failed"
+			} else {
+				result.ScheduledBackups.Status = "ok"
+			}
+		}
+	}
+	return result
+}
+
 func fileServer(r chi.Router, path string, root http.FileSystem, disableDirectoryIndex bool) {
 	if path != "/" && path[len(path)-1] != '/' {
 		r.Get(path, http.RedirectHandler(path+"/", http.StatusMovedPermanently).ServeHTTP)
@@ -1076,16 +1417,24 @@ func updateWebClientURLs(baseURL string) {
 	webBasePath = path.Join(baseURL, webBasePathDefault)
 	webBaseClientPath = path.Join(baseURL, webBasePathClientDefault)
 	webOIDCRedirectPath = path.Join(baseURL, webOIDCRedirectPathDefault)
+	webSAMLMetadataPath = path.Join(baseURL, webSAMLMetadataPathDefault)
+	webSAMLACSPath = path.Join(baseURL, webSAMLACSPathDefault)
+	webSAMLSLOPath = path.Join(baseURL, webSAMLSLOPathDefault)
 	webClientLoginPath = path.Join(baseURL, webClientLoginPathDefault)
 	webClientOIDCLoginPath = path.Join(baseURL, webClientOIDCLoginPathDefault)
+	webClientSAMLLoginPath = path.Join(baseURL, webClientSAMLLoginPathDefault)
 	webClientTwoFactorPath = path.Join(baseURL, webClientTwoFactorPathDefault)
 	webClientTwoFactorRecoveryPath = path.Join(baseURL, webClientTwoFactorRecoveryPathDefault)
 	webClientFilesPath = path.Join(baseURL, webClientFilesPathDefault)
 	webClientFilePath = path.Join(baseURL, webClientFilePathDefault)
+	webClientTusPath = path.Join(baseURL, webClientTusPathDefault)
 	webClientFileActionsPath = path.Join(baseURL, webClientFileActionsPathDefault)
 	webClientSharesPath = path.Join(baseURL, webClientSharesPathDefault)
 	webClientPubSharesPath = path.Join(baseURL, webClientPubSharesPathDefault)
 	webClientSharePath = path.Join(baseURL, webClientSharePathDefault)
+	webClientTrashPath = path.Join(baseURL, webClientTrashPathDefault)
+	webClientFileVersionsPath = path.Join(baseURL, webClientFileVersionsPathDefault)
+	webClientSearchPath = path.Join(baseURL, webClientSearchPathDefault)
 	webClientEditFilePath = path.Join(baseURL, webClientEditFilePathDefault)
 	webClientDirsPath = path.Join(baseURL, webClientDirsPathDefault)
 	webClientDownloadZipPath = path.Join(baseURL, webClientDownloadZipPathDefault)
@@ -1098,12 +1447,20 @@ func updateWebClientURLs(baseURL string) {
 	webClientTOTPValidatePath = path.Join(baseURL, webClientTOTPValidatePathDefault)
 	webClientTOTPSavePath = path.Join(baseURL, webClientTOTPSavePathDefault)
 	webClientRecoveryCodesPath = path.Join(baseURL, webClientRecoveryCodesPathDefault)
+	webClientTrustedDevicesPath = path.Join(baseURL, webClientTrustedDevicesPathDefault)
+	webClientSSHCertPath = path.Join(baseURL, webClientSSHCertPathDefault)
 	webClientForgotPwdPath = path.Join(baseURL, webClientForgotPwdPathDefault)
 	webClientResetPwdPath = path.Join(baseURL, webClientResetPwdPathDefault)
 	webClientViewPDFPath = path.Join(baseURL, webClientViewPDFPathDefault)
 	webClientGetPDFPath = path.Join(baseURL, webClientGetPDFPathDefault)
+	webClientPreviewPath = path.Join(baseURL, webClientPreviewPathDefault)
+	webClientGetPreviewPath = path.Join(baseURL, webClientGetPreviewPathDefault)
+	webClientThumbnailsPath = path.Join(baseURL, webClientThumbnailsPathDefault)
+	webClientWOPIPath = path.Join(baseURL, webClientWOPIPathDefault)
+	wopiFilesPath = path.Join(baseURL, wopiFilesPathDefault)
 	webClientExistPath = path.Join(baseURL, webClientExistPathDefault)
 	webClientTasksPath = path.Join(baseURL, webClientTasksPathDefault)
+	webClientOAuth2AuthorizePath = path.Join(baseURL, webClientOAuth2AuthorizePathDefault)
 	webStaticFilesPath = path.Join(baseURL, webStaticFilesPathDefault)
 	webOpenAPIPath = path.Join(baseURL, webOpenAPIPathDefault)
 }
@@ -1116,11 +1473,15 @@ func updateWebAdminURLs(baseURL string) {
 	webBasePath = path.Join(baseURL, webBasePathDefault)
 	webBaseAdminPath = path.Join(baseURL, webBasePathAdminDefault)
 	webOIDCRedirectPath = path.Join(baseURL, webOIDCRedirectPathDefault)
+	webSAMLMetadataPath = path.Join(baseURL, webSAMLMetadataPathDefault)
+	webSAMLACSPath = path.Join(baseURL, webSAMLACSPathDefault)
+	webSAMLSLOPath = path.Join(baseURL, webSAMLSLOPathDefault)
 	webOAuth2RedirectPath = path.Join(baseURL, webOAuth2RedirectPathDefault)
 	webOAuth2TokenPath = path.Join(baseURL, webOAuth2TokenPathDefault)
 	webAdminSetupPath = path.Join(baseURL, webAdminSetupPathDefault)
 	webAdminLoginPath = path.Join(baseURL, webAdminLoginPathDefault)
 	webAdminOIDCLoginPath = path.Join(baseURL, webAdminOIDCLoginPathDefault)
+	webAdminSAMLLoginPath = path.Join(baseURL, webAdminSAMLLoginPathDefault)
 	webAdminTwoFactorPath = path.Join(baseURL, webAdminTwoFactorPathDefault)
 	webAdminTwoFactorRecoveryPath = path.Join(baseURL, webAdminTwoFactorRecoveryPathDefault)
 	webLogoutPath = path.Join(baseURL, webLogoutPathDefault)
@@ -1150,10 +1511,13 @@ func updateWebAdminURLs(baseURL string) {
 	webAdminEventActionPath = path.Join(baseURL, webAdminEventActionPathDefault)
 	webAdminRolesPath = path.Join(baseURL, webAdminRolesPathDefault)
 	webAdminRolePath = path.Join(baseURL, webAdminRolePathDefault)
+	webAdminTenantsPath = path.Join(baseURL, webAdminTenantsPathDefault)
+	webAdminTenantPath = path.Join(baseURL, webAdminTenantPathDefault)
 	webAdminTOTPGeneratePath = path.Join(baseURL, webAdminTOTPGeneratePathDefault)
 	webAdminTOTPValidatePath = path.Join(baseURL, webAdminTOTPValidatePathDefault)
 	webAdminTOTPSavePath = path.Join(baseURL, webAdminTOTPSavePathDefault)
 	webAdminRecoveryCodesPath = path.Join(baseURL, webAdminRecoveryCodesPathDefault)
+	webAdminTrustedDevicesPath = path.Join(baseURL, webAdminTrustedDevicesPathDefault)
 	webTemplateUser = path.Join(baseURL, webTemplateUserDefault)
 	webTemplateFolder = path.Join(baseURL, webTemplateFolderDefault)
 	webDefenderHostsPath = path.Join(baseURL, webDefenderHostsPathDefault)
@@ -1164,6 +1528,8 @@ func updateWebAdminURLs(baseURL string) {
 	webEventsFsSearchPath = path.Join(baseURL, webEventsFsSearchPathDefault)
 	webEventsProviderSearchPath = path.Join(baseURL, webEventsProviderSearchPathDefault)
 	webEventsLogSearchPath = path.Join(baseURL, webEventsLogSearchPathDefault)
+	webLiveEventsPath = path.Join(baseURL, webLiveEventsPathDefault)
+	webLiveEventsStreamPath = path.Join(baseURL, webLiveEventsStreamPathDefault)
 	webConfigsPath = path.Join(baseURL, webConfigsPathDefault)
 	webStaticFilesPath = path.Join(baseURL, webStaticFilesPathDefault)
 	webOpenAPIPath = path.Join(baseURL, webOpenAPIPathDefault)
@@ -1193,6 +1559,12 @@ func startCleanupTicker(duration time.Duration) {
 				invalidatedJWTTokens.Cleanup()
 				resetCodesMgr.Cleanup()
 				webTaskMgr.Cleanup()
+				tusUploadsMgr.cleanup()
+				trustedDevicesMgr.Cleanup()
+				oauth2CodesMgr.Cleanup()
+				if wopiMgr != nil {
+					wopiMgr.cleanup()
+				}
 				if counter%2 == 0 {
 					oidcMgr.cleanup()
 					oauth2Mgr.cleanup()
@@ -1223,6 +1595,11 @@ func SetInstallationCodeResolver(fn FnInstallationCodeResolver) {
 	fnInstallationCodeResolver = fn
 }
 
+// SetReloadConfigFn sets the function to call to reload the configuration
+func SetReloadConfigFn(fn func() error) {
+	fnReloadConfig = fn
+}
+
 func resolveInstallationCode() string {
 	if fnInstallationCodeResolver != nil {
 		return fnInstallationCodeResolver(installationCode)
@@ -15,11 +15,22 @@
 package httpd_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"io/fs"
 	"math"
@@ -39,9 +50,11 @@ import (
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/go-chi/render"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/klauspost/compress/gzip"
 	"github.com/lithammer/shortuuid/v3"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mhale/smtpd"
@@ -56,6 +69,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/html"
+	"golang.org/x/net/websocket"
 
 	"github.com/drakkan/sftpgo/v2/internal/acme"
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -106,30 +120,49 @@ const (
 	userFileActionsPath            = "/api/v2/user/file-actions"
 	userStreamZipPath              = "/api/v2/user/streamzip"
 	userUploadFilePath             = "/api/v2/user/files/upload"
+	userTusPath                    = "/api/v2/user/tus"
 	userFilesDirsMetadataPath      = "/api/v2/user/files/metadata"
+	userTrashPath                  = "/api/v2/user/trash"
+	userFileVersionsPath           = "/api/v2/user/files/versions"
+	userSearchPath                 = "/api/v2/user/search"
+	userJobsPath                   = "/api/v2/user/jobs"
 	apiKeysPath                    = "/api/v2/apikeys"
 	adminTOTPConfigsPath           = "/api/v2/admin/totp/configs"
 	adminTOTPGeneratePath          = "/api/v2/admin/totp/generate"
 	adminTOTPValidatePath          = "/api/v2/admin/totp/validate"
 	adminTOTPSavePath              = "/api/v2/admin/totp/save"
 	admin2FARecoveryCodesPath      = "/api/v2/admin/2fa/recoverycodes"
+	adminTrustedDevicesPath        = "/api/v2/admin/2fa/trusteddevices"
 	adminProfilePath               = "/api/v2/admin/profile"
 	userTOTPConfigsPath            = "/api/v2/user/totp/configs"
 	userTOTPGeneratePath           = "/api/v2/user/totp/generate"
 	userTOTPValidatePath           = "/api/v2/user/totp/validate"
 	userTOTPSavePath               = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath       = "/api/v2/user/2fa/recoverycodes"
+	userTrustedDevicesPath         = "/api/v2/user/2fa/trusteddevices"
 	userProfilePath                = "/api/v2/user/profile"
+	userSSHCertPath                = "/api/v2/user/sshcert"
 	userSharesPath                 = "/api/v2/user/shares"
 	retentionBasePath              = "/api/v2/retention/users"
 	fsEventsPath                   = "/api/v2/events/fs"
 	providerEventsPath             = "/api/v2/events/provider"
 	logEventsPath                  = "/api/v2/events/logs"
+	liveEventsPath                 = "/api/v2/events/live"
+	graphQLPath                    = "/api/v2/graphql"
 	sharesPath                     = "/api/v2/shares"
+	userThumbnailsPath             = "/api/v2/user/thumbnails"
 	eventActionsPath               = "/api/v2/eventactions"
 	eventRulesPath                 = "/api/v2/eventrules"
 	rolesPath                      = "/api/v2/roles"
 	ipListsPath                    = "/api/v2/iplists"
+	jobsPath                       = "/api/v2/jobs"
+	auditLogPath                   = "/api/v2/auditlog"
+	auditLogVerifyPath             = "/api/v2/auditlog/verify"
+	dumpDataPath                   = "/api/v2/dumpdata"
+	passwordHashesPath             = "/api/v2/passwordhashes"
+	reloadConfigPath               = "/api/v2/reload"
+	oauth2AppsPath                 = "/api/v2/oauth2/apps"
+	oauth2TokenPath                = "/api/v2/oauth2/token"
 	healthzPath                    = "/healthz"
 	webBasePath                    = "/web"
 	webBasePathAdmin               = "/web/admin"
@@ -168,11 +201,17 @@ const (
 	webAdminRolesPath              = "/web/admin/roles"
 	webAdminRolePath               = "/web/admin/role"
 	webEventsPath                  = "/web/admin/events"
+	webLiveEventsPath              = "/web/admin/events/live"
 	webConfigsPath                 = "/web/admin/configs"
 	webOAuth2TokenPath             = "/web/admin/oauth2/token"
 	webBasePathClient              = "/web/client"
 	webClientLoginPath             = "/web/client/login"
 	webClientFilesPath             = "/web/client/files"
+	webClientTrashPath             = "/web/client/trash"
+	webClientFileVersionsPath      = "/web/client/files/versions"
+	webClientSearchPath            = "/web/client/search"
+	webClientFileActionsPath       = "/web/client/file-actions"
+	webClientTusPath               = "/web/client/tus"
 	webClientEditFilePath          = "/web/client/editfile"
 	webClientDirsPath              = "/web/client/dirs"
 	webClientDownloadZipPath       = "/web/client/downloadzip"
@@ -183,6 +222,8 @@ const (
 	webClientTwoFactorRecoveryPath = "/web/client/twofactor-recovery"
 	webClientLogoutPath            = "/web/client/logout"
 	webClientMFAPath               = "/web/client/mfa"
+	webClientTrustedDevicesPath    = "/web/client/trusteddevices"
+	webClientOAuth2AuthorizePath   = "/web/client/oauth2/authorize"
 	webClientTOTPSavePath          = "/web/client/totp/save"
 	webClientSharesPath            = "/web/client/shares"
 	webClientSharePath             = "/web/client/share"
@@ -191,6 +232,11 @@ const (
 	webClientResetPwdPath          = "/web/client/reset-password"
 	webClientViewPDFPath           = "/web/client/viewpdf"
 	webClientGetPDFPath            = "/web/client/getpdf"
+	webClientPreviewPath           = "/web/client/preview"
+	webClientWOPIPath              = "/web/client/wopi"
+	wopiFilesPath                  = "/wopi/files"
+	webClientGetPreviewPath        = "/web/client/getpreview"
+	webClientThumbnailsPath        = "/web/client/thumbnails"
 	webClientExistPath             = "/web/client/exist"
 	webClientTasksPath             = "/web/client/tasks"
 	webClientFileMovePath          = "/web/client/file-actions/move"
@@ -406,6 +452,12 @@ func TestMain(m *testing.M) {
 	httpdConf := config.GetHTTPDConfig()
 
 	httpdConf.Bindings[0].Port = 8081
+	httpdConf.Thumbnails.Enabled = true
+	httpdConf.Thumbnails.CachePath = filepath.Join(os.TempDir(), "test_thumbnails")
+	httpdConf.Thumbnails.MaxFileSize = 1
+	httpdConf.WOPI.Enabled = true
+	httpdConf.WOPI.ServerURL = "http://" + oidcMockAddr
+	httpdConf.WOPI.HostURL = httpBaseURL
 	httpdConf.Bindings[0].Security = httpd.SecurityConf{
 		Enabled: true,
 		HTTPSProxyHeaders: []httpd.HTTPSProxyHeader{
@@ -480,6 +532,7 @@ func TestMain(m *testing.M) {
 	exitCode := m.Run()
 	os.Remove(logfilePath)
 	os.RemoveAll(backupsPath)
+	os.RemoveAll(httpdConf.Thumbnails.CachePath)
 	os.Remove(certPath)
 	os.Remove(keyPath)
 	os.Remove(hostKeyPath)
@@ -704,6 +757,55 @@ func TestBasicRoleHandling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAdminGranularPermissions(t *testing.T) {
+	group, _, err := httpdtest.AddGroup(getTestGroup(), http.StatusCreated)
+	assert.NoError(t, err)
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Permissions = []string{dataprovider.PermAdminViewGroups, dataprovider.PermAdminAddGroups,
+		dataprovider.PermAdminViewEventRules}
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+
+	token, _, err := httpdtest.GetToken(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken(token)
+	groups, _, err := httpdtest.GetGroups(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(groups), 1)
+	g := getTestGroup()
+	g.Name += "_granular"
+	newGroup, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.UpdateGroup(group, http.StatusForbidden)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusForbidden)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetEventRules(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.AddEventRule(dataprovider.EventRule{Name: "rule"}, http.StatusForbidden)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetFolders(0, 0, http.StatusForbidden)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken("")
+	// the manage permissions grant all the granular ones
+	admin.Permissions = []string{dataprovider.PermAdminManageGroups}
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	token, _, err = httpdtest.GetToken(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken(token)
+	_, err = httpdtest.RemoveGroup(newGroup, http.StatusOK)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken("")
+
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestRoleRelations(t *testing.T) {
 	r := getTestRole()
 	role, resp, err := httpdtest.AddRole(r, http.StatusCreated)
@@ -842,6 +944,210 @@ func TestRoleRelations(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBasicTenantHandling(t *testing.T) {
+	tn := getTestTenant()
+	tenant, resp, err := httpdtest.AddTenant(tn, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Greater(t, tenant.CreatedAt, int64(0))
+	assert.Greater(t, tenant.UpdatedAt, int64(0))
+	tenantGet, _, err := httpdtest.GetTenantByName(tenant.Name, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant, tenantGet)
+
+	tenants, _, err := httpdtest.GetTenants(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(tenants), 1)
+	tenants, _, err = httpdtest.GetTenants(0, int64(len(tenants)), http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, tenants, 0)
+
+	tenant.Description = "updated desc"
+	tenant.Branding.WebClient.Name = "tenant web client"
+	_, _, err = httpdtest.UpdateTenant(tenant, http.StatusOK)
+	assert.NoError(t, err)
+	tenantGet, _, err = httpdtest.GetTenantByName(tenant.Name, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.Description, tenantGet.Description)
+	assert.Equal(t, tenant.Branding.WebClient.Name, tenantGet.Branding.WebClient.Name)
+
+	tenant.Branding.WebAdmin.DisclaimerName = "disclaimer"
+	_, resp, err = httpdtest.UpdateTenant(tenant, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "disclaimer path is required")
+
+	_, _, err = httpdtest.GetTenantByName(tenant.Name+"_", http.StatusNotFound)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.AddTenant(tn, http.StatusConflict)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveTenant(tenant, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestTenantAdminIsolation(t *testing.T) {
+	tenant, _, err := httpdtest.AddTenant(getTestTenant(), http.StatusCreated)
+	assert.NoError(t, err)
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Tenant = tenant.Name
+	_, resp, err := httpdtest.AddAdmin(a, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	a.Permissions = []string{dataprovider.PermAdminAddUsers, dataprovider.PermAdminChangeUsers,
+		dataprovider.PermAdminDeleteUsers, dataprovider.PermAdminViewUsers}
+	a.Tenant = "missing tenant"
+	_, resp, err = httpdtest.AddAdmin(a, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "does not exist")
+	a.Tenant = tenant.Name
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.Name, admin.Tenant)
+	// the tenant cannot be changed after creation
+	admin.Tenant = ""
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	admin, _, err = httpdtest.GetAdminByUsername(admin.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.Name, admin.Tenant)
+
+	u := getTestUser()
+	globalUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	token, _, err := httpdtest.GetToken(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken(token)
+	// users created by a tenant admin belong to its tenant
+	u1 := getTestUser()
+	u1.Username = defaultUsername + "1"
+	tenantUser, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.Name, tenantUser.Tenant)
+	// objects outside the tenant are not visible
+	users, _, err := httpdtest.GetUsers(0, 0, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, tenantUser.Username, users[0].Username)
+	}
+	_, _, err = httpdtest.GetUserByUsername(globalUser.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.UpdateUser(globalUser, http.StatusNotFound, "")
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(globalUser, http.StatusNotFound)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken("")
+
+	resp, err = httpdtest.RemoveTenant(tenant, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "referenced")
+
+	_, err = httpdtest.RemoveUser(tenantUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(globalUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveTenant(tenant, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(tenantUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(globalUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestTenantStorageBasePath(t *testing.T) {
+	tn := getTestTenant()
+	tn.StorageBasePath = "relative"
+	_, resp, err := httpdtest.AddTenant(tn, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "must be an absolute path")
+	tn.StorageBasePath = filepath.Join(homeBasePath, "tenant_storage") + string(os.PathSeparator)
+	tenant, _, err := httpdtest.AddTenant(tn, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(homeBasePath, "tenant_storage"), tenant.StorageBasePath)
+
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Tenant = tenant.Name
+	a.Permissions = []string{dataprovider.PermAdminAddUsers, dataprovider.PermAdminChangeUsers,
+		dataprovider.PermAdminDeleteUsers, dataprovider.PermAdminViewUsers, dataprovider.PermAdminManageFolders,
+		dataprovider.PermAdminManageGroups}
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+
+	token, _, err := httpdtest.GetToken(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken(token)
+	// a tenant admin cannot point a user outside the tenant storage base path
+	u := getTestUser()
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	u.HomeDir = filepath.Join(tenant.StorageBasePath, "..", "tenant_storage1", defaultUsername)
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	u.HomeDir = filepath.Join(tenant.StorageBasePath, defaultUsername)
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       "tenant_vfolder",
+			MappedPath: filepath.Join(homeBasePath, "tenant_vfolder"),
+		},
+		VirtualPath: "/vdir",
+	})
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	u.VirtualFolders = nil
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, tenant.Name, user.Tenant)
+	user.HomeDir = filepath.Join(homeBasePath, defaultUsername)
+	_, resp, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	// the same applies to folders
+	f := vfs.BaseVirtualFolder{
+		Name:       "tenant_folder",
+		MappedPath: filepath.Join(homeBasePath, "tenant_folder"),
+	}
+	_, resp, err = httpdtest.AddFolder(f, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	f.MappedPath = filepath.Join(tenant.StorageBasePath, "tenant_folder")
+	folder, _, err := httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	folder.MappedPath = filepath.Join(homeBasePath, "tenant_folder")
+	_, resp, err = httpdtest.UpdateFolder(folder, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	// and to groups
+	g := getTestGroup()
+	g.UserSettings.HomeDir = filepath.Join(homeBasePath, "%username%")
+	_, resp, err = httpdtest.AddGroup(g, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "outside the storage base path")
+	g.UserSettings.HomeDir = filepath.Join(tenant.StorageBasePath, "%username%")
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	httpdtest.SetJWTToken("")
+
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveTenant(tenant, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(tenant.StorageBasePath)
+	assert.NoError(t, err)
+}
+
 func TestRSAKeyInvalidSize(t *testing.T) {
 	u := getTestUser()
 	u.PublicKeys = append(u.PublicKeys, rsa1024PubKey)
@@ -2753,6 +3059,77 @@ func TestEventRuleValidation(t *testing.T) {
 	assert.Contains(t, string(resp), "invalid Identity Provider login event")
 }
 
+func TestUserProtocolBandwidthLimits(t *testing.T) {
+	u := getTestUser()
+	u.UploadBandwidth = 128
+	u.DownloadBandwidth = 96
+	u.Filters.BandwidthLimits = []sdk.BandwidthLimit{
+		{
+			Sources:         []string{"10.0.0.0/8"},
+			UploadBandwidth: 512,
+		},
+	}
+	u.Filters.ProtocolBandwidthLimits = []dataprovider.ProtocolBandwidthLimit{
+		{
+			Protocols: []string{"invalid"},
+		},
+	}
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "invalid bandwidth limit protocol")
+	u.Filters.ProtocolBandwidthLimits = []dataprovider.ProtocolBandwidthLimit{
+		{
+			Protocols: nil,
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.ProtocolBandwidthLimits = []dataprovider.ProtocolBandwidthLimit{
+		{
+			Protocols:       []string{common.ProtocolSCP},
+			UploadBandwidth: 64,
+		},
+		{
+			Protocols:         []string{common.ProtocolSCP, common.ProtocolFTP},
+			DownloadBandwidth: 64,
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "duplicated bandwidth limit")
+	u.Filters.ProtocolBandwidthLimits = []dataprovider.ProtocolBandwidthLimit{
+		{
+			Protocols:         []string{common.ProtocolSCP, common.ProtocolFTP},
+			UploadBandwidth:   64,
+			DownloadBandwidth: -1,
+		},
+	}
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	if assert.Len(t, user.Filters.ProtocolBandwidthLimits, 1) {
+		assert.Equal(t, int64(64), user.Filters.ProtocolBandwidthLimits[0].UploadBandwidth)
+		assert.Equal(t, int64(0), user.Filters.ProtocolBandwidthLimits[0].DownloadBandwidth)
+	}
+
+	connID := xid.New().String()
+	localAddr := "127.0.0.1"
+	conn := common.NewBaseConnection(connID, common.ProtocolSFTP, localAddr, "10.1.1.1", user)
+	assert.Equal(t, int64(512), conn.User.UploadBandwidth)
+	assert.Equal(t, int64(0), conn.User.DownloadBandwidth)
+	conn = common.NewBaseConnection(connID, common.ProtocolFTP, localAddr, "10.1.1.1", user)
+	assert.Equal(t, int64(64), conn.User.UploadBandwidth)
+	assert.Equal(t, int64(0), conn.User.DownloadBandwidth)
+	conn = common.NewBaseConnection(connID, common.ProtocolSSH, localAddr, "192.168.1.1", user)
+	assert.Equal(t, int64(128), conn.User.UploadBandwidth)
+	assert.Equal(t, int64(96), conn.User.DownloadBandwidth)
+	conn.SetProtocol(common.ProtocolSCP)
+	assert.Equal(t, int64(64), conn.User.UploadBandwidth)
+	assert.Equal(t, int64(0), conn.User.DownloadBandwidth)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestUserBandwidthLimits(t *testing.T) {
 	u := getTestUser()
 	u.UploadBandwidth = 128
@@ -2856,73 +3233,336 @@ func TestUserBandwidthLimits(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestAccessTimeValidation(t *testing.T) {
+func TestTwoFactorAuthExemptions(t *testing.T) {
 	u := getTestUser()
-	u.Filters.AccessTime = []sdk.TimePeriod{
+	u.Filters.TwoFactorAuthExemptions = []dataprovider.TwoFactorAuthExemption{
 		{
-			DayOfWeek: 8,
-			From:      "10:00",
-			To:        "18:00",
+			Protocols: []string{common.ProtocolSSH},
+			Networks:  []string{"invalid"},
 		},
 	}
 	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err, string(resp))
-	assert.Contains(t, string(resp), "invalid day of week")
-	u.Filters.AccessTime = []sdk.TimePeriod{
+	assert.Contains(t, string(resp), "could not parse two factor exemption network")
+	u.Filters.TwoFactorAuthExemptions = []dataprovider.TwoFactorAuthExemption{
 		{
-			DayOfWeek: 6,
-			From:      "10:00",
-			To:        "18",
+			Protocols: []string{"unknown"},
+			Networks:  []string{"10.0.0.0/8"},
 		},
 	}
 	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err, string(resp))
-	assert.Contains(t, string(resp), "invalid time of day")
-	u.Filters.AccessTime = []sdk.TimePeriod{
+	u.Filters.TwoFactorAuthExemptions = []dataprovider.TwoFactorAuthExemption{
 		{
-			DayOfWeek: 6,
-			From:      "11:00",
-			To:        "10:58",
+			Protocols: []string{common.ProtocolSSH},
+			Networks:  []string{"10.0.0.0/8", "192.168.1.0/24"},
 		},
 	}
-	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err, string(resp))
-	assert.Contains(t, string(resp), "The end time cannot be earlier than the start time")
+	assert.Equal(t, u.Filters.TwoFactorAuthExemptions, user.Filters.TwoFactorAuthExemptions)
+	// the second factor cannot be enabled using the REST API
+	assert.False(t, user.IsSecondFactorRequired(common.ProtocolSSH, "172.16.1.2"))
+	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
+		Enabled:    true,
+		ConfigName: mfa.GetAvailableTOTPConfigNames()[0],
+		Secret:     kms.NewPlainSecret("secret"),
+		Protocols:  []string{common.ProtocolSSH, common.ProtocolHTTP},
+	}
+
+	assert.True(t, user.IsSecondFactorExempted(common.ProtocolSSH, "10.1.2.3"))
+	assert.False(t, user.IsSecondFactorRequired(common.ProtocolSSH, "10.1.2.3"))
+	assert.True(t, user.IsSecondFactorRequired(common.ProtocolSSH, "172.16.1.2"))
+	assert.True(t, user.IsSecondFactorRequired(common.ProtocolSSH, "invalid"))
+	assert.False(t, user.IsSecondFactorExempted(common.ProtocolHTTP, "192.168.1.5"))
+	assert.True(t, user.IsSecondFactorRequired(common.ProtocolHTTP, "192.168.1.5"))
+	assert.False(t, user.IsSecondFactorRequired(common.ProtocolFTP, "172.16.1.2"))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
 }
 
-func TestUserTimestamps(t *testing.T) {
-	user, resp, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestSSHAlgorithmsPolicyValidation(t *testing.T) {
+	u := getTestUser()
+	u.Filters.SSHAlgorithms.Ciphers = []string{"not a cipher"}
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err, string(resp))
-	createdAt := user.CreatedAt
-	updatedAt := user.UpdatedAt
-	assert.Equal(t, int64(0), user.LastLogin)
-	assert.Equal(t, int64(0), user.FirstDownload)
-	assert.Equal(t, int64(0), user.FirstUpload)
-	assert.Greater(t, createdAt, int64(0))
-	assert.Greater(t, updatedAt, int64(0))
-	mappedPath := filepath.Join(os.TempDir(), "mapped_dir")
-	folderName := filepath.Base(mappedPath)
-	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
-		BaseVirtualFolder: vfs.BaseVirtualFolder{
-			Name:       folderName,
-			MappedPath: mappedPath,
-		},
-		VirtualPath: "/vdir",
-	})
-	f := vfs.BaseVirtualFolder{
-		Name:       folderName,
-		MappedPath: mappedPath,
-	}
-	_, _, err = httpdtest.AddFolder(f, http.StatusCreated)
-	assert.NoError(t, err)
-	time.Sleep(10 * time.Millisecond)
-	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Contains(t, string(resp), "unsupported SSH cipher")
+	u.Filters.SSHAlgorithms.Ciphers = []string{"aes256-gcm@openssh.com", "aes256-gcm@openssh.com"}
+	u.Filters.SSHAlgorithms.MACs = []string{"not a MAC"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err, string(resp))
-	assert.Equal(t, int64(0), user.LastLogin)
-	assert.Equal(t, int64(0), user.FirstDownload)
-	assert.Equal(t, int64(0), user.FirstUpload)
-	assert.Equal(t, createdAt, user.CreatedAt)
-	assert.Greater(t, user.UpdatedAt, updatedAt)
+	assert.Contains(t, string(resp), "unsupported SSH MAC algorithm")
+	u.Filters.SSHAlgorithms.MACs = []string{"hmac-sha2-256-etm@openssh.com"}
+	u.Filters.SSHAlgorithms.KexAlgorithms = []string{"not a kex"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.SSHAlgorithms.KexAlgorithms = []string{"curve25519-sha256@libssh.org"}
+	u.Filters.SSHAlgorithms.PublicKeyAlgorithms = []string{"ssh-rsa-cert-v01@openssh.com"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.SSHAlgorithms.PublicKeyAlgorithms = nil
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, []string{"aes256-gcm@openssh.com"}, user.Filters.SSHAlgorithms.Ciphers)
+	assert.Len(t, user.Filters.SSHAlgorithms.PublicKeyAlgorithms, 0)
+
+	g := getTestGroup()
+	g.UserSettings.SSHAlgorithms.PublicKeyAlgorithms = []string{"invalid"}
+	_, resp, err = httpdtest.AddGroup(g, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	g.UserSettings.SSHAlgorithms.PublicKeyAlgorithms = []string{"ecdsa-sha2-nistp256", "rsa-sha2-256"}
+	g.UserSettings.SSHAlgorithms.Ciphers = []string{"aes128-gcm@openssh.com"}
+	group, resp, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	// the restrictions not defined for the user are taken from the primary group
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	userWithGroups, err := dataprovider.GetUserWithGroupSettings(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aes256-gcm@openssh.com"}, userWithGroups.Filters.SSHAlgorithms.Ciphers)
+	assert.Equal(t, []string{"ecdsa-sha2-nistp256", "rsa-sha2-256"}, userWithGroups.Filters.SSHAlgorithms.PublicKeyAlgorithms)
+	assert.Equal(t, []string{"curve25519-sha256@libssh.org"}, userWithGroups.Filters.SSHAlgorithms.KexAlgorithms)
+
+	policy := userWithGroups.Filters.SSHAlgorithms
+	assert.True(t, policy.IsKexAllowed("curve25519-sha256"))
+	assert.False(t, policy.IsKexAllowed("ecdh-sha2-nistp256"))
+	assert.True(t, policy.IsMACAllowed(""))
+	assert.False(t, policy.IsMACAllowed("hmac-sha2-256"))
+	assert.False(t, policy.IsCipherAllowed("aes128-gcm@openssh.com"))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestFTPPassiveIPValidation(t *testing.T) {
+	u := getTestUser()
+	u.Filters.FTPPassiveIP = "invalid"
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "invalid FTP passive IP")
+	u.Filters.FTPPassiveIP = "::1"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.FTPPassiveIP = ""
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+
+	g := getTestGroup()
+	g.UserSettings.FTPPassiveIP = "172.16.1"
+	_, resp, err = httpdtest.AddGroup(g, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	g.UserSettings.FTPPassiveIP = "172.16.1.1"
+	group, resp, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, "172.16.1.1", group.UserSettings.FTPPassiveIP)
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	userWithGroups, err := dataprovider.GetUserWithGroupSettings(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "172.16.1.1", userWithGroups.Filters.FTPPassiveIP)
+	// the user setting takes precedence
+	user.Filters.FTPPassiveIP = "10.1.1.1"
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	userWithGroups, err = dataprovider.GetUserWithGroupSettings(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.1.1", userWithGroups.Filters.FTPPassiveIP)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserSSHCertIssuanceDisabled(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSSHCertPath, bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// no user CA is configured for the SFTP service
+	asJSON, err := json.Marshal(map[string]string{"public_key": testPubKey})
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSSHCertPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPortForwardingPolicyValidation(t *testing.T) {
+	u := getTestUser()
+	u.Filters.PortForwarding = dataprovider.PortForwardingPolicy{
+		AllowLocal: true,
+	}
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "requires at least one permitted destination")
+	u.Filters.PortForwarding.PermitOpen = []string{"127.0.0.1"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.PortForwarding.PermitOpen = []string{"127.0.0.1:0"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.PortForwarding.PermitOpen = []string{"10.0.0.0/33:22"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.PortForwarding.PermitOpen = []string{"10.0.0.0/8:22", "[fd00::/8]:*", "db.example.com:5432"}
+	u.Filters.PortForwarding.AllowRemote = true
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "requires at least one permitted listen address")
+	u.Filters.PortForwarding.PermitListen = []string{"10.0.0.0/8:8080"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.PortForwarding.PermitListen = []string{"localhost:8080"}
+	u.Filters.PortForwarding.MaxForwards = -1
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	u.Filters.PortForwarding.MaxForwards = 5
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, u.Filters.PortForwarding, user.Filters.PortForwarding)
+
+	policy := user.Filters.PortForwarding
+	assert.True(t, policy.IsOpenAllowed("10.1.2.3", net.ParseIP("10.1.2.3"), 22))
+	assert.False(t, policy.IsOpenAllowed("10.1.2.3", net.ParseIP("10.1.2.3"), 23))
+	assert.True(t, policy.IsOpenAllowed("fd00::1", net.ParseIP("fd00::1"), 80))
+	assert.True(t, policy.IsOpenAllowed("DB.example.com", net.ParseIP("192.168.1.1"), 5432))
+	assert.False(t, policy.IsOpenAllowed("db1.example.com", net.ParseIP("192.168.1.1"), 5432))
+	assert.True(t, policy.IsListenAllowed("localhost", 8080))
+	assert.False(t, policy.IsListenAllowed("localhost", 0))
+	assert.False(t, policy.IsListenAllowed("0.0.0.0", 8080))
+
+	g := getTestGroup()
+	g.UserSettings.PortForwarding = dataprovider.PortForwardingPolicy{
+		AllowRemote:  true,
+		PermitListen: []string{"*:*"},
+		PermitOpen:   []string{"invalid"},
+	}
+	_, resp, err = httpdtest.AddGroup(g, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	g.UserSettings.PortForwarding.PermitOpen = nil
+	group, resp, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.True(t, group.UserSettings.PortForwarding.IsListenAllowed("0.0.0.0", 0))
+	// the group policy is applied only to users without a policy
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	userWithGroups, err := dataprovider.GetUserWithGroupSettings(user.Username, "")
+	assert.NoError(t, err)
+	assert.Equal(t, policy, userWithGroups.Filters.PortForwarding)
+	user.Filters.PortForwarding = dataprovider.PortForwardingPolicy{}
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	userWithGroups, err = dataprovider.GetUserWithGroupSettings(user.Username, "")
+	assert.NoError(t, err)
+	assert.True(t, userWithGroups.Filters.PortForwarding.AllowRemote)
+	assert.False(t, userWithGroups.Filters.PortForwarding.AllowLocal)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestAccessTimeValidation(t *testing.T) {
+	u := getTestUser()
+	u.Filters.AccessTime = []sdk.TimePeriod{
+		{
+			DayOfWeek: 8,
+			From:      "10:00",
+			To:        "18:00",
+		},
+	}
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "invalid day of week")
+	u.Filters.AccessTime = []sdk.TimePeriod{
+		{
+			DayOfWeek: 6,
+			From:      "10:00",
+			To:        "18",
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "invalid time of day")
+	u.Filters.AccessTime = []sdk.TimePeriod{
+		{
+			DayOfWeek: 6,
+			From:      "11:00",
+			To:        "10:58",
+		},
+	}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err, string(resp))
+	assert.Contains(t, string(resp), "The end time cannot be earlier than the start time")
+}
+
+func TestUserTimestamps(t *testing.T) {
+	user, resp, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	createdAt := user.CreatedAt
+	updatedAt := user.UpdatedAt
+	assert.Equal(t, int64(0), user.LastLogin)
+	assert.Equal(t, int64(0), user.FirstDownload)
+	assert.Equal(t, int64(0), user.FirstUpload)
+	assert.Greater(t, createdAt, int64(0))
+	assert.Greater(t, updatedAt, int64(0))
+	mappedPath := filepath.Join(os.TempDir(), "mapped_dir")
+	folderName := filepath.Base(mappedPath)
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+		},
+		VirtualPath: "/vdir",
+	})
+	f := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: mappedPath,
+	}
+	_, _, err = httpdtest.AddFolder(f, http.StatusCreated)
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	user, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	assert.Equal(t, int64(0), user.LastLogin)
+	assert.Equal(t, int64(0), user.FirstDownload)
+	assert.Equal(t, int64(0), user.FirstUpload)
+	assert.Equal(t, createdAt, user.CreatedAt)
+	assert.Greater(t, user.UpdatedAt, updatedAt)
 	updatedAt = user.UpdatedAt
 	// after a folder update or delete the user updated_at field should change
 	folder, _, err := httpdtest.GetFolderByName(folderName, http.StatusOK)
@@ -3017,7 +3657,7 @@ func TestHTTPAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v%v", httpBaseURL, userTokenPath), nil)
 	assert.NoError(t, err)
@@ -4358,6 +4998,93 @@ func TestAdminPasswordHashing(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPasswordHashesUpgrade(t *testing.T) {
+	weakHash, err := bcrypt.GenerateFromPassword([]byte(defaultPassword), bcrypt.MinCost)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Password = string(weakHash)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser()
+	u.Username += "_sha512crypt"
+	u.Password = "$6$459ead56b72e44bc$uog86fUxscjt28BZxqFBE2pp2QD8P/1e98MNF75Z9xJfQvOckZnQ/1YJqiq1XeytPuDieHZvDAMoP7352ELkO1"
+	legacyUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = string(weakHash)
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	getReport := func() dataprovider.PasswordHashesReport {
+		req, err := http.NewRequest(http.MethodGet, passwordHashesPath, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		var report dataprovider.PasswordHashesReport
+		err = json.Unmarshal(rr.Body.Bytes(), &report)
+		assert.NoError(t, err)
+		return report
+	}
+	findHash := func(hashes []dataprovider.WeakPasswordHash, username string) *dataprovider.WeakPasswordHash {
+		for idx := range hashes {
+			if hashes[idx].Username == username {
+				return &hashes[idx]
+			}
+		}
+		return nil
+	}
+	report := getReport()
+	assert.Equal(t, dataprovider.HashingAlgoBcrypt, report.Algo)
+	if h := findHash(report.Users, user.Username); assert.NotNil(t, h) {
+		assert.Equal(t, dataprovider.HashingAlgoBcrypt, h.Scheme)
+		assert.Contains(t, h.Reason, "bcrypt cost")
+	}
+	if h := findHash(report.Users, legacyUser.Username); assert.NotNil(t, h) {
+		assert.Equal(t, "sha512crypt", h.Scheme)
+	}
+	if h := findHash(report.Admins, admin.Username); assert.NotNil(t, h) {
+		assert.Equal(t, dataprovider.HashingAlgoBcrypt, h.Scheme)
+	}
+	assert.Nil(t, findHash(report.Admins, defaultTokenAuthUser))
+	// weak hashes are upgraded after a successful login
+	_, err = getJWTAPIUserTokenFromTestServer(user.Username, defaultPassword)
+	assert.NoError(t, err)
+	_, err = getJWTAPIUserTokenFromTestServer(legacyUser.Username, "secret")
+	assert.NoError(t, err)
+	_, err = getJWTAPITokenFromTestServer(altAdminUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	user, err = dataprovider.UserExists(user.Username, "")
+	assert.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+	legacyUser, err = dataprovider.UserExists(legacyUser.Username, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(legacyUser.Password, "$2a$"))
+	dbAdmin, err := dataprovider.AdminExists(altAdminUsername)
+	assert.NoError(t, err)
+	cost, err = bcrypt.Cost([]byte(dbAdmin.Password))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+
+	report = getReport()
+	assert.Nil(t, findHash(report.Users, user.Username))
+	assert.Nil(t, findHash(report.Users, legacyUser.Username))
+	assert.Nil(t, findHash(report.Admins, admin.Username))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(legacyUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestDefaultUsersExpiration(t *testing.T) {
 	a := getTestAdmin()
 	a.Username = altAdminUsername
@@ -4776,6 +5503,49 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 		assert.Contains(t, string(resp), "invalid download concurrency")
 	}
 	u.FsConfig.S3Config.DownloadConcurrency = 0
+	u.FsConfig.S3Config.ObjectLockMode = "invalid"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid object_lock_mode")
+	}
+	u.FsConfig.S3Config.ObjectLockMode = "COMPLIANCE"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "object_lock_retention_days must be greater than 0")
+	}
+	u.FsConfig.S3Config.ObjectLockMode = ""
+	u.FsConfig.S3Config.ObjectLockRetentionDays = 10
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "object_lock_retention_days requires an object_lock_mode")
+	}
+	u.FsConfig.S3Config.ObjectLockRetentionDays = 0
+	u.FsConfig.S3Config.AssumeRoleWithWebIdentity = true
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "role_arn is required to assume a role with web identity")
+	}
+	u.FsConfig.S3Config.RoleARN = "arn:aws:iam::123456789012:role/sftpgo"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "cannot be used to assume a role with web identity")
+	}
+	u.FsConfig.S3Config.AssumeRoleWithWebIdentity = false
+	u.FsConfig.S3Config.RoleARN = ""
+	u.FsConfig.S3Config.SSECustomerKey = kms.NewPlainSecret("invalid key")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "sse_customer_key must be a base64 encoded 256-bit key")
+	}
+	u.FsConfig.S3Config.SSECustomerKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(
+		[]byte("0123456789abcdef0123456789abcdef")))
+	u.FsConfig.S3Config.SSEKMSKeyID = "alias/sftpgo"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "sse_kms_key_id and sse_customer_key cannot be used together")
+	}
+	u.FsConfig.S3Config.SSECustomerKey = nil
+	u.FsConfig.S3Config.SSEKMSKeyID = ""
 	u.FsConfig.S3Config.Endpoint = ""
 	u.FsConfig.S3Config.Region = ""
 	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
@@ -4804,9 +5574,22 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	u.FsConfig.GCSConfig.Credentials = kms.NewSecret(sdkkms.SecretStatusSecretBox, "invalid", "", "")
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
-
-	u = getTestUser()
-	u.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
+	u.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret("fake credentials")
+	u.FsConfig.GCSConfig.EncryptionKey = kms.NewPlainSecret("invalid key")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "encryption_key must be a base64 encoded 256-bit key")
+	}
+	u.FsConfig.GCSConfig.EncryptionKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(
+		[]byte("0123456789abcdef0123456789abcdef")))
+	u.FsConfig.GCSConfig.KMSKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "kms_key_name and encryption_key cannot be used together")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
 	u.FsConfig.AzBlobConfig.SASURL = kms.NewPlainSecret("http://foo\x7f.com/")
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
@@ -4834,6 +5617,31 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	u.FsConfig.AzBlobConfig.UploadPartSize = 101
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.FsConfig.AzBlobConfig.UploadPartSize = 0
+	u.FsConfig.AzBlobConfig.AccessTierRules = []vfs.AzBlobAccessTierRule{
+		{
+			Path:       "/archive",
+			AccessTier: "Frozen",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.AzBlobConfig.AccessTierRules = []vfs.AzBlobAccessTierRule{
+		{
+			Path:       "/archive",
+			AccessTier: "Archive",
+		},
+		{
+			Path:       "archive/",
+			AccessTier: "Cool",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.AzBlobConfig.AccessTierRules = nil
+	u.FsConfig.AzBlobConfig.RehydratePriority = "Low"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
 
 	u = getTestUser()
 	u.FsConfig.Provider = sdk.CryptedFilesystemProvider
@@ -4867,6 +5675,343 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 		assert.Contains(t, string(resp), "invalid buffer_size")
 	}
 
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.B2FilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "bucket cannot be empty")
+	}
+	u.FsConfig.B2Config.Bucket = "bucket"
+	u.FsConfig.B2Config.KeyPrefix = "/somedir/subdir/"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "key_prefix cannot start with /")
+	}
+	u.FsConfig.B2Config.KeyPrefix = "somedir/subdir/"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "key_id cannot be empty")
+	}
+	u.FsConfig.B2Config.KeyID = "keyID"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid application_key")
+	}
+	u.FsConfig.B2Config.ApplicationKey = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted application_key")
+	}
+	u.FsConfig.B2Config.ApplicationKey = kms.NewPlainSecret("appKey")
+	u.FsConfig.B2Config.UploadPartSize = 4
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "upload_part_size cannot be")
+	}
+	u.FsConfig.B2Config.UploadPartSize = 0
+	u.FsConfig.B2Config.UploadConcurrency = 65
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid upload concurrency")
+	}
+	u.FsConfig.B2Config.UploadConcurrency = 0
+	u.FsConfig.B2Config.DownloadPartSize = -1
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "download_part_size cannot be")
+	}
+	u.FsConfig.B2Config.DownloadPartSize = 0
+	u.FsConfig.B2Config.DownloadConcurrency = -1
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid download concurrency")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.DropboxFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "client_id cannot be empty")
+	}
+	u.FsConfig.DropboxConfig.ClientID = "client-id"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid refresh_token")
+	}
+	u.FsConfig.DropboxConfig.RefreshToken = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted refresh_token")
+	}
+	u.FsConfig.DropboxConfig.RefreshToken = kms.NewPlainSecret("refresh-token")
+	u.FsConfig.DropboxConfig.ClientSecret = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted client_secret")
+	}
+	u.FsConfig.DropboxConfig.ClientSecret = kms.NewEmptySecret()
+	u.FsConfig.DropboxConfig.UploadPartSize = 151
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "upload_part_size cannot be")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.GDriveFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid credentials")
+	}
+	u.FsConfig.GDriveConfig.Credentials = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted credentials")
+	}
+	u.FsConfig.GDriveConfig.Credentials = kms.NewPlainSecret("{}")
+	u.FsConfig.GDriveConfig.ExportFormat = 3
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid export_format")
+	}
+	u.FsConfig.GDriveConfig.ExportFormat = 0
+	u.FsConfig.GDriveConfig.UploadPartSize = -1
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "upload_part_size cannot be")
+	}
+	u.FsConfig.GDriveConfig.UploadPartSize = 0
+	u.FsConfig.GDriveConfig.AuthMethod = 2
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid auth_method")
+	}
+	u.FsConfig.GDriveConfig.AuthMethod = vfs.GDriveAuthOAuth2
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "client_id cannot be empty")
+	}
+	u.FsConfig.GDriveConfig.ClientID = "client-id"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid client_secret")
+	}
+	u.FsConfig.GDriveConfig.ClientSecret = kms.NewPlainSecret("client-secret")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid refresh_token")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.OneDriveFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "client_id cannot be empty")
+	}
+	u.FsConfig.OneDriveConfig.ClientID = "client-id"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "tenant_id is required")
+	}
+	u.FsConfig.OneDriveConfig.TenantID = "tenant-id"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "client_secret is required")
+	}
+	u.FsConfig.OneDriveConfig.ClientSecret = kms.NewPlainSecret("client-secret")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "drive_id is required")
+	}
+	u.FsConfig.OneDriveConfig.DriveID = "drive-id"
+	u.FsConfig.OneDriveConfig.UploadPartSize = 61
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "upload_part_size cannot be")
+	}
+	u.FsConfig.OneDriveConfig.UploadPartSize = 0
+	u.FsConfig.OneDriveConfig.RefreshToken = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted refresh_token")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.WebDAVFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "endpoint cannot be empty")
+	}
+	u.FsConfig.WebDAVConfig.Endpoint = "ftp://127.0.0.1/dav"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "an http or https URL is required")
+	}
+	u.FsConfig.WebDAVConfig.Endpoint = "https://127.0.0.1/dav"
+	u.FsConfig.WebDAVConfig.AuthMethod = vfs.WebDAVAuthDigest
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "username is required for digest authentication")
+	}
+	u.FsConfig.WebDAVConfig.AuthMethod = vfs.WebDAVAuthBearer
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "bearer_token is required for bearer authentication")
+	}
+	u.FsConfig.WebDAVConfig.AuthMethod = 10
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid auth_method")
+	}
+	u.FsConfig.WebDAVConfig.AuthMethod = vfs.WebDAVAuthBasic
+	u.FsConfig.WebDAVConfig.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted password")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.SMBFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "endpoint cannot be empty")
+	}
+	u.FsConfig.SMBConfig.Endpoint = "127.0.0.1:port"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid endpoint")
+	}
+	u.FsConfig.SMBConfig.Endpoint = "127.0.0.1"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "share cannot be empty")
+	}
+	u.FsConfig.SMBConfig.Share = "share/subdir"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid share")
+	}
+	u.FsConfig.SMBConfig.Share = "share"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "username cannot be empty")
+	}
+	u.FsConfig.SMBConfig.Username = "smbuser"
+	u.FsConfig.SMBConfig.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted password")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.HDFSFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "endpoint cannot be empty")
+	}
+	u.FsConfig.HDFSConfig.Endpoint = "namenode:9870"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid endpoint")
+	}
+	u.FsConfig.HDFSConfig.Endpoint = "http://namenode:9870"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "username cannot be empty")
+	}
+	u.FsConfig.HDFSConfig.AuthMethod = vfs.HDFSAuthKerberos
+	u.FsConfig.HDFSConfig.KerberosPrincipal = "sftpgo"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "the realm is required")
+	}
+	u.FsConfig.HDFSConfig.KerberosPrincipal = "sftpgo@EXAMPLE.COM"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "a Kerberos password or keytab is required")
+	}
+	u.FsConfig.HDFSConfig.KerberosKeytab = kms.NewPlainSecret("not base64")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "kerberos_keytab must be base64 encoded")
+	}
+	u.FsConfig.HDFSConfig.KerberosKeytab = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted kerberos_keytab")
+	}
+	u.FsConfig.HDFSConfig.AuthMethod = 10
+	u.FsConfig.HDFSConfig.KerberosKeytab = nil
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid auth_method")
+	}
+
+	u = getTestUser()
+	u.FsConfig.Provider = vfs.SwiftFilesystemProvider
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "auth_url cannot be empty")
+	}
+	u.FsConfig.SwiftConfig.AuthURL = "keystone:5000/v3"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid auth_url")
+	}
+	u.FsConfig.SwiftConfig.AuthURL = "https://keystone:5000/v3"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "username cannot be empty")
+	}
+	u.FsConfig.SwiftConfig.Username = "swiftuser"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "password cannot be empty")
+	}
+	u.FsConfig.SwiftConfig.Password = kms.NewPlainSecret("swiftpwd")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "a project name or ID is required")
+	}
+	u.FsConfig.SwiftConfig.ProjectName = "project"
+	u.FsConfig.SwiftConfig.EndpointType = "private"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid endpoint_type")
+	}
+	u.FsConfig.SwiftConfig.EndpointType = "internal"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "container cannot be empty")
+	}
+	u.FsConfig.SwiftConfig.Container = "container/sub"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "container cannot contain /")
+	}
+	u.FsConfig.SwiftConfig.Container = "container"
+	u.FsConfig.SwiftConfig.KeyPrefix = "/prefix"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "key_prefix cannot start with /")
+	}
+	u.FsConfig.SwiftConfig.KeyPrefix = ""
+	u.FsConfig.SwiftConfig.UploadPartSize = 5121
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "upload_part_size cannot be lower than 0 or greater than 5120")
+	}
+	u.FsConfig.SwiftConfig.UploadPartSize = 0
+	u.FsConfig.SwiftConfig.ApplicationCredentialID = "appid"
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "application_credential_secret cannot be empty")
+	}
+	u.FsConfig.SwiftConfig.ApplicationCredentialSecret = kms.NewSecret(sdkkms.SecretStatusSecretBox, "", "", "")
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(resp), "invalid encrypted application_credential_secret")
+	}
+
 	u = getTestUser()
 	u.FsConfig.Provider = sdk.HTTPFilesystemProvider
 	u.FsConfig.HTTPConfig.Endpoint = "http://foo\x7f.com/"
@@ -5650,6 +6795,11 @@ func TestUserS3Config(t *testing.T) {
 	user.FsConfig.S3Config.ForcePathStyle = true
 	user.FsConfig.S3Config.SkipTLSVerify = true
 	user.FsConfig.S3Config.DownloadPartSize = 6
+	user.FsConfig.S3Config.ObjectLockMode = "GOVERNANCE"
+	user.FsConfig.S3Config.ObjectLockRetentionDays = 30
+	user.FsConfig.S3Config.ObjectLockLegalHold = true
+	user.FsConfig.S3Config.SSECustomerKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(
+		[]byte("0123456789abcdef0123456789abcdef")))
 	folderName := "vfolderName"
 	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
 		BaseVirtualFolder: vfs.BaseVirtualFolder{
@@ -5678,6 +6828,11 @@ func TestUserS3Config(t *testing.T) {
 	assert.Equal(t, 60, user.FsConfig.S3Config.DownloadPartMaxTime)
 	assert.Equal(t, 40, user.FsConfig.S3Config.UploadPartMaxTime)
 	assert.True(t, user.FsConfig.S3Config.SkipTLSVerify)
+	assert.Equal(t, "GOVERNANCE", user.FsConfig.S3Config.ObjectLockMode)
+	assert.Equal(t, 30, user.FsConfig.S3Config.ObjectLockRetentionDays)
+	assert.True(t, user.FsConfig.S3Config.ObjectLockLegalHold)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.S3Config.SSECustomerKey.GetStatus())
+	assert.NotEmpty(t, user.FsConfig.S3Config.SSECustomerKey.GetPayload())
 	if assert.Len(t, user.VirtualFolders, 1) {
 		folder := user.VirtualFolders[0]
 		assert.Equal(t, sdkkms.SecretStatusSecretBox, folder.FsConfig.CryptConfig.Passphrase.GetStatus())
@@ -5699,6 +6854,7 @@ func TestUserS3Config(t *testing.T) {
 	user.ID = 0
 	user.CreatedAt = 0
 	user.VirtualFolders = nil
+	user.FsConfig.S3Config.SSECustomerKey = nil
 	secret := kms.NewSecret(sdkkms.SecretStatusSecretBox, "Server-Access-Secret", "", "")
 	user.FsConfig.S3Config.AccessSecret = secret
 	_, _, err = httpdtest.AddUser(user, http.StatusCreated)
@@ -5738,6 +6894,30 @@ func TestUserS3Config(t *testing.T) {
 	user, body, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err, string(body))
 	assert.Nil(t, user.FsConfig.S3Config.AccessSecret)
+	// credentials obtained using the OpenID Connect identity
+	user.FsConfig.S3Config.AssumeRoleWithWebIdentity = true
+	user, body, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(body))
+	assert.True(t, user.FsConfig.S3Config.AssumeRoleWithWebIdentity)
+	// the filesystem is cached inside the user, so we get a new user each time
+	getUserFs := func() error {
+		dbUser, err := dataprovider.UserExists(user.Username, "")
+		if err != nil {
+			return err
+		}
+		_, err = dbUser.GetFilesystem(xid.New().String())
+		return err
+	}
+	assert.ErrorIs(t, getUserFs(), vfs.ErrWebIdentityTokenUnavailable)
+	vfs.SetWebIdentityToken(user.Username, "id-token", time.Now().Add(-1*time.Minute))
+	assert.ErrorIs(t, getUserFs(), vfs.ErrWebIdentityTokenUnavailable)
+	vfs.SetWebIdentityToken(user.Username, "id-token", time.Now().Add(10*time.Minute))
+	assert.NoError(t, getUserFs())
+	vfs.RemoveWebIdentityToken(user.Username, "another-token")
+	assert.NoError(t, getUserFs())
+	vfs.RemoveWebIdentityToken(user.Username, "id-token")
+	assert.ErrorIs(t, getUserFs(), vfs.ErrWebIdentityTokenUnavailable)
+	user.FsConfig.S3Config.AssumeRoleWithWebIdentity = false
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	user.Password = defaultPassword
@@ -5829,8 +7009,24 @@ func TestUserAzureBlobConfig(t *testing.T) {
 	user.FsConfig.AzBlobConfig.Endpoint = "http://127.0.0.1:9000"
 	user.FsConfig.AzBlobConfig.UploadPartSize = 8
 	user.FsConfig.AzBlobConfig.DownloadPartSize = 6
+	user.FsConfig.AzBlobConfig.HierarchicalNamespace = true
+	user.FsConfig.AzBlobConfig.AccessTier = "Cool"
+	user.FsConfig.AzBlobConfig.AccessTierRules = []vfs.AzBlobAccessTierRule{
+		{
+			Path:       "/archive",
+			AccessTier: "Archive",
+		},
+		{
+			Path:       "/reports/*",
+			AccessTier: "Hot",
+		},
+	}
+	user.FsConfig.AzBlobConfig.RehydratePriority = "High"
 	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
+	assert.True(t, user.FsConfig.AzBlobConfig.HierarchicalNamespace)
+	assert.Len(t, user.FsConfig.AzBlobConfig.AccessTierRules, 2)
+	assert.Equal(t, "High", user.FsConfig.AzBlobConfig.RehydratePriority)
 	initialPayload := user.FsConfig.AzBlobConfig.AccountKey.GetPayload()
 	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.AzBlobConfig.AccountKey.GetStatus())
 	assert.NotEmpty(t, initialPayload)
@@ -5924,18 +7120,423 @@ func TestUserAzureBlobConfig(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestUserCryptFs(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestUserB2Config(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.B2FilesystemProvider
+	u.FsConfig.B2Config.Bucket = "test-bucket"
+	u.FsConfig.B2Config.KeyPrefix = "somedir/subdir"
+	u.FsConfig.B2Config.KeyID = "key-id"
+	u.FsConfig.B2Config.ApplicationKey = kms.NewPlainSecret("application-key")
+	u.FsConfig.B2Config.UploadPartSize = 10
+	u.FsConfig.B2Config.UploadConcurrency = 4
+	u.FsConfig.B2Config.DownloadPartSize = 20
+	u.FsConfig.B2Config.DownloadConcurrency = 3
+	u.FsConfig.B2Config.HideOnDelete = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	user.FsConfig.Provider = sdk.CryptedFilesystemProvider
-	user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("crypt passphrase")
+	assert.Equal(t, "somedir/subdir/", user.FsConfig.B2Config.KeyPrefix)
+	initialPayload := user.FsConfig.B2Config.ApplicationKey.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.B2Config.ApplicationKey.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.B2Config.ApplicationKey.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.B2Config.ApplicationKey.GetKey())
+	// the encrypted secret must be preserved on update
+	user.FsConfig.B2Config.ApplicationKey.SetAdditionalData("data")
+	user.FsConfig.B2Config.ApplicationKey.SetKey("fake key")
+	user.FsConfig.B2Config.HideOnDelete = false
 	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	initialPayload := user.FsConfig.CryptConfig.Passphrase.GetPayload()
-	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.CryptConfig.Passphrase.GetStatus())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.B2Config.ApplicationKey.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.B2Config.ApplicationKey.GetPayload())
+	assert.Empty(t, user.FsConfig.B2Config.ApplicationKey.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.B2Config.ApplicationKey.GetKey())
+	assert.False(t, user.FsConfig.B2Config.HideOnDelete)
+	// switching to another provider must clear the B2 config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.B2Config = vfs.B2FsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.B2Config.Bucket)
+	assert.Nil(t, user.FsConfig.B2Config.ApplicationKey)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserDropboxConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.DropboxFilesystemProvider
+	u.FsConfig.DropboxConfig.RootPath = "/somedir/subdir"
+	u.FsConfig.DropboxConfig.ClientID = "client-id"
+	u.FsConfig.DropboxConfig.ClientSecret = kms.NewPlainSecret("client-secret")
+	u.FsConfig.DropboxConfig.RefreshToken = kms.NewPlainSecret("refresh-token")
+	u.FsConfig.DropboxConfig.NamespaceID = "12345"
+	u.FsConfig.DropboxConfig.UploadPartSize = 32
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	initialPayload := user.FsConfig.DropboxConfig.RefreshToken.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.DropboxConfig.RefreshToken.GetStatus())
 	assert.NotEmpty(t, initialPayload)
-	assert.Empty(t, user.FsConfig.CryptConfig.Passphrase.GetAdditionalData())
-	assert.Empty(t, user.FsConfig.CryptConfig.Passphrase.GetKey())
+	assert.Empty(t, user.FsConfig.DropboxConfig.RefreshToken.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.DropboxConfig.RefreshToken.GetKey())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.DropboxConfig.ClientSecret.GetStatus())
+	assert.NotEmpty(t, user.FsConfig.DropboxConfig.ClientSecret.GetPayload())
+	// the encrypted secrets must be preserved on update
+	user.FsConfig.DropboxConfig.RefreshToken.SetAdditionalData("data")
+	user.FsConfig.DropboxConfig.RefreshToken.SetKey("fake key")
+	user.FsConfig.DropboxConfig.ClientSecret = kms.NewEmptySecret()
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.DropboxConfig.RefreshToken.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.DropboxConfig.RefreshToken.GetPayload())
+	assert.Empty(t, user.FsConfig.DropboxConfig.RefreshToken.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.DropboxConfig.RefreshToken.GetKey())
+	// the client secret is optional
+	assert.Nil(t, user.FsConfig.DropboxConfig.ClientSecret)
+	// switching to another provider must clear the Dropbox config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.DropboxConfig = vfs.DropboxFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.DropboxConfig.ClientID)
+	assert.Nil(t, user.FsConfig.DropboxConfig.RefreshToken)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserGDriveConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.GDriveFilesystemProvider
+	u.FsConfig.GDriveConfig.DriveID = "drive-id"
+	u.FsConfig.GDriveConfig.RootFolderID = "folder-id"
+	u.FsConfig.GDriveConfig.Credentials = kms.NewPlainSecret(`{"type":"service_account"}`)
+	u.FsConfig.GDriveConfig.Subject = "user@example.com"
+	u.FsConfig.GDriveConfig.ExportFormat = vfs.GDriveExportOpenDocument
+	u.FsConfig.GDriveConfig.UploadPartSize = 8
+	u.FsConfig.GDriveConfig.UseTrash = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	initialPayload := user.FsConfig.GDriveConfig.Credentials.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.GDriveConfig.Credentials.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.GDriveConfig.Credentials.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.GDriveConfig.Credentials.GetKey())
+	// the encrypted credentials must be preserved on update
+	user.FsConfig.GDriveConfig.Credentials.SetAdditionalData("data")
+	user.FsConfig.GDriveConfig.Credentials.SetKey("fake key")
+	user.FsConfig.GDriveConfig.UseTrash = false
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.GDriveConfig.Credentials.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.GDriveConfig.Credentials.GetPayload())
+	assert.Empty(t, user.FsConfig.GDriveConfig.Credentials.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.GDriveConfig.Credentials.GetKey())
+	assert.False(t, user.FsConfig.GDriveConfig.UseTrash)
+	// switching to OAuth2 must clear the service account settings
+	user.FsConfig.GDriveConfig.AuthMethod = vfs.GDriveAuthOAuth2
+	user.FsConfig.GDriveConfig.ClientID = "client-id"
+	user.FsConfig.GDriveConfig.ClientSecret = kms.NewPlainSecret("client-secret")
+	user.FsConfig.GDriveConfig.RefreshToken = kms.NewPlainSecret("refresh-token")
+	user.FsConfig.GDriveConfig.Credentials = kms.NewEmptySecret()
+	user.FsConfig.GDriveConfig.Subject = ""
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Nil(t, user.FsConfig.GDriveConfig.Credentials)
+	assert.Empty(t, user.FsConfig.GDriveConfig.Subject)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.GDriveConfig.ClientSecret.GetStatus())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.GDriveConfig.RefreshToken.GetStatus())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserOneDriveConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.OneDriveFilesystemProvider
+	u.FsConfig.OneDriveConfig.TenantID = "tenant-id"
+	u.FsConfig.OneDriveConfig.ClientID = "client-id"
+	u.FsConfig.OneDriveConfig.ClientSecret = kms.NewPlainSecret("client-secret")
+	u.FsConfig.OneDriveConfig.DriveID = "drive-id"
+	u.FsConfig.OneDriveConfig.RootPath = "/somedir/subdir"
+	u.FsConfig.OneDriveConfig.UploadPartSize = 20
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, "/somedir/subdir", user.FsConfig.OneDriveConfig.RootPath)
+	initialPayload := user.FsConfig.OneDriveConfig.ClientSecret.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.OneDriveConfig.ClientSecret.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.OneDriveConfig.ClientSecret.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.OneDriveConfig.ClientSecret.GetKey())
+	assert.Nil(t, user.FsConfig.OneDriveConfig.RefreshToken)
+	// the encrypted secrets must be preserved on update
+	user.FsConfig.OneDriveConfig.ClientSecret.SetAdditionalData("data")
+	user.FsConfig.OneDriveConfig.ClientSecret.SetKey("fake key")
+	user.FsConfig.OneDriveConfig.UploadPartSize = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.OneDriveConfig.ClientSecret.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.OneDriveConfig.ClientSecret.GetPayload())
+	assert.Empty(t, user.FsConfig.OneDriveConfig.ClientSecret.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.OneDriveConfig.ClientSecret.GetKey())
+	// with a refresh token the tenant and the drive are optional
+	user.FsConfig.OneDriveConfig.TenantID = ""
+	user.FsConfig.OneDriveConfig.DriveID = ""
+	user.FsConfig.OneDriveConfig.RefreshToken = kms.NewPlainSecret("refresh-token")
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.OneDriveConfig.RefreshToken.GetStatus())
+	// switching to another provider must clear the OneDrive config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.OneDriveConfig = vfs.OneDriveFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.OneDriveConfig.ClientID)
+	assert.Nil(t, user.FsConfig.OneDriveConfig.ClientSecret)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserWebDAVConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.WebDAVFilesystemProvider
+	u.FsConfig.WebDAVConfig.Endpoint = "https://127.0.0.1/remote.php/dav/files/user/"
+	u.FsConfig.WebDAVConfig.Username = "user"
+	u.FsConfig.WebDAVConfig.Password = kms.NewPlainSecret("password")
+	u.FsConfig.WebDAVConfig.BufferUploads = true
+	// the trailing slash is removed from the endpoint
+	_, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.Error(t, err)
+	user, _, err := httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1/remote.php/dav/files/user", user.FsConfig.WebDAVConfig.Endpoint)
+	initialPayload := user.FsConfig.WebDAVConfig.Password.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.WebDAVConfig.Password.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Password.GetKey())
+	assert.Nil(t, user.FsConfig.WebDAVConfig.BearerToken)
+	// the encrypted secrets must be preserved on update
+	user.FsConfig.WebDAVConfig.Password.SetAdditionalData("data")
+	user.FsConfig.WebDAVConfig.Password.SetKey("fake key")
+	user.FsConfig.WebDAVConfig.AuthMethod = vfs.WebDAVAuthDigest
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.WebDAVConfig.Password.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.WebDAVConfig.Password.GetPayload())
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Password.GetKey())
+	user.FsConfig.WebDAVConfig.AuthMethod = vfs.WebDAVAuthBearer
+	user.FsConfig.WebDAVConfig.BearerToken = kms.NewPlainSecret("token")
+	// bearer authentication does not use the username and the password
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Error(t, err)
+	user, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Username)
+	assert.Nil(t, user.FsConfig.WebDAVConfig.Password)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.WebDAVConfig.BearerToken.GetStatus())
+	// switching to another provider must clear the WebDAV config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.WebDAVConfig.Endpoint)
+	assert.Nil(t, user.FsConfig.WebDAVConfig.BearerToken)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserSMBConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.SMBFilesystemProvider
+	u.FsConfig.SMBConfig.Endpoint = "127.0.0.1:445"
+	u.FsConfig.SMBConfig.Share = "share"
+	u.FsConfig.SMBConfig.Username = "smbuser"
+	u.FsConfig.SMBConfig.Password = kms.NewPlainSecret("smbpassword")
+	u.FsConfig.SMBConfig.Domain = "WORKGROUP"
+	u.FsConfig.SMBConfig.Prefix = "/somedir/subdir"
+	u.FsConfig.SMBConfig.RequireSigning = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	initialPayload := user.FsConfig.SMBConfig.Password.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.SMBConfig.Password.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetKey())
+	// the encrypted password must be preserved on update
+	user.FsConfig.SMBConfig.Password.SetAdditionalData("data")
+	user.FsConfig.SMBConfig.Password.SetKey("fake key")
+	user.FsConfig.SMBConfig.RequireSigning = false
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.SMBConfig.Password.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.SMBConfig.Password.GetPayload())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetKey())
+	// the default port is added to the endpoint
+	user.FsConfig.SMBConfig.Endpoint = "127.0.0.1"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Error(t, err)
+	user, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:445", user.FsConfig.SMBConfig.Endpoint)
+	// switching to another provider must clear the SMB config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.SMBConfig = vfs.SMBFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.SMBConfig.Endpoint)
+	assert.Nil(t, user.FsConfig.SMBConfig.Password)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserHDFSConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.HDFSFilesystemProvider
+	u.FsConfig.HDFSConfig.Endpoint = "https://namenode.example.com:9871/"
+	u.FsConfig.HDFSConfig.AuthMethod = vfs.HDFSAuthKerberos
+	u.FsConfig.HDFSConfig.Username = "hdfsuser"
+	u.FsConfig.HDFSConfig.KerberosPrincipal = "sftpgo@EXAMPLE.COM"
+	u.FsConfig.HDFSConfig.KerberosKeytab = kms.NewPlainSecret(base64.StdEncoding.EncodeToString([]byte("keytab")))
+	u.FsConfig.HDFSConfig.ServicePrincipal = "HTTP/namenode.example.com"
+	u.FsConfig.HDFSConfig.DoAs = "%username%"
+	u.FsConfig.HDFSConfig.Prefix = "/user/data"
+	_, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.Error(t, err)
+	user, _, err := httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	// the trailing slash is removed and the username is ignored for Kerberos
+	assert.Equal(t, "https://namenode.example.com:9871", user.FsConfig.HDFSConfig.Endpoint)
+	assert.Empty(t, user.FsConfig.HDFSConfig.Username)
+	assert.Equal(t, "%username%", user.FsConfig.HDFSConfig.DoAs)
+	initialPayload := user.FsConfig.HDFSConfig.KerberosKeytab.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.HDFSConfig.KerberosKeytab.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.HDFSConfig.KerberosKeytab.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.HDFSConfig.KerberosKeytab.GetKey())
+	assert.Nil(t, user.FsConfig.HDFSConfig.KerberosPassword)
+	// the encrypted keytab must be preserved on update
+	user.FsConfig.HDFSConfig.KerberosKeytab.SetAdditionalData("data")
+	user.FsConfig.HDFSConfig.KerberosKeytab.SetKey("fake key")
+	user.FsConfig.HDFSConfig.SkipTLSVerify = true
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.HDFSConfig.KerberosKeytab.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.HDFSConfig.KerberosKeytab.GetPayload())
+	assert.Empty(t, user.FsConfig.HDFSConfig.KerberosKeytab.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.HDFSConfig.KerberosKeytab.GetKey())
+	// switching to simple authentication clears the Kerberos settings
+	user.FsConfig.HDFSConfig.AuthMethod = vfs.HDFSAuthSimple
+	user.FsConfig.HDFSConfig.Username = "hdfsuser"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Error(t, err)
+	user, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "hdfsuser", user.FsConfig.HDFSConfig.Username)
+	assert.Empty(t, user.FsConfig.HDFSConfig.KerberosPrincipal)
+	assert.Empty(t, user.FsConfig.HDFSConfig.ServicePrincipal)
+	assert.Nil(t, user.FsConfig.HDFSConfig.KerberosKeytab)
+	// switching to another provider must clear the HDFS config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.HDFSConfig = vfs.HDFSFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.HDFSConfig.Endpoint)
+	assert.Nil(t, user.FsConfig.HDFSConfig.KerberosKeytab)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserSwiftConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.SwiftFilesystemProvider
+	u.FsConfig.SwiftConfig.AuthURL = "https://keystone.example.com:5000/v3/"
+	u.FsConfig.SwiftConfig.Username = "swiftuser"
+	u.FsConfig.SwiftConfig.Password = kms.NewPlainSecret("swiftpwd")
+	u.FsConfig.SwiftConfig.ProjectName = "project"
+	u.FsConfig.SwiftConfig.Region = "RegionOne"
+	u.FsConfig.SwiftConfig.Container = "sftpgo"
+	u.FsConfig.SwiftConfig.ContainerPerUser = true
+	u.FsConfig.SwiftConfig.KeyPrefix = "%username%/data"
+	u.FsConfig.SwiftConfig.UploadPartSize = 200
+	_, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.Error(t, err)
+	user, _, err := httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	// the defaults are applied
+	assert.Equal(t, "https://keystone.example.com:5000/v3", user.FsConfig.SwiftConfig.AuthURL)
+	assert.Equal(t, "Default", user.FsConfig.SwiftConfig.UserDomain)
+	assert.Equal(t, "Default", user.FsConfig.SwiftConfig.ProjectDomain)
+	assert.Equal(t, "public", user.FsConfig.SwiftConfig.EndpointType)
+	assert.Equal(t, "%username%/data/", user.FsConfig.SwiftConfig.KeyPrefix)
+	assert.True(t, user.FsConfig.SwiftConfig.ContainerPerUser)
+	initialPayload := user.FsConfig.SwiftConfig.Password.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.SwiftConfig.Password.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.SwiftConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SwiftConfig.Password.GetKey())
+	assert.Nil(t, user.FsConfig.SwiftConfig.ApplicationCredentialSecret)
+	// the encrypted password must be preserved on update
+	user.FsConfig.SwiftConfig.Password.SetAdditionalData("data")
+	user.FsConfig.SwiftConfig.Password.SetKey("fake key")
+	user.FsConfig.SwiftConfig.SkipTLSVerify = true
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.SwiftConfig.Password.GetStatus())
+	assert.Equal(t, initialPayload, user.FsConfig.SwiftConfig.Password.GetPayload())
+	assert.Empty(t, user.FsConfig.SwiftConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SwiftConfig.Password.GetKey())
+	// a project ID has precedence over the project name
+	user.FsConfig.SwiftConfig.ProjectID = "projectid"
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Error(t, err)
+	user, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "projectid", user.FsConfig.SwiftConfig.ProjectID)
+	assert.Empty(t, user.FsConfig.SwiftConfig.ProjectName)
+	assert.Empty(t, user.FsConfig.SwiftConfig.ProjectDomain)
+	// application credentials clear the password authentication settings
+	user.FsConfig.SwiftConfig.ApplicationCredentialID = "appid"
+	user.FsConfig.SwiftConfig.ApplicationCredentialSecret = kms.NewPlainSecret("appsecret")
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.Error(t, err)
+	user, _, err = httpdtest.GetUserByUsername(u.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "appid", user.FsConfig.SwiftConfig.ApplicationCredentialID)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.SwiftConfig.ApplicationCredentialSecret.GetStatus())
+	assert.Empty(t, user.FsConfig.SwiftConfig.Username)
+	assert.Empty(t, user.FsConfig.SwiftConfig.ProjectID)
+	assert.Nil(t, user.FsConfig.SwiftConfig.Password)
+	// switching to another provider must clear the Swift config
+	user.FsConfig.Provider = sdk.LocalFilesystemProvider
+	user.FsConfig.SwiftConfig = vfs.SwiftFsConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Empty(t, user.FsConfig.SwiftConfig.AuthURL)
+	assert.Nil(t, user.FsConfig.SwiftConfig.Password)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserCryptFs(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	user.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("crypt passphrase")
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	initialPayload := user.FsConfig.CryptConfig.Passphrase.GetPayload()
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user.FsConfig.CryptConfig.Passphrase.GetStatus())
+	assert.NotEmpty(t, initialPayload)
+	assert.Empty(t, user.FsConfig.CryptConfig.Passphrase.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.CryptConfig.Passphrase.GetKey())
 	user.FsConfig.CryptConfig.Passphrase.SetStatus(sdkkms.SecretStatusSecretBox)
 	user.FsConfig.CryptConfig.Passphrase.SetAdditionalData("data")
 	user.FsConfig.CryptConfig.Passphrase.SetKey("fake pass key")
@@ -6093,6 +7694,8 @@ func TestUserHiddenFields(t *testing.T) {
 	u2.FsConfig.GCSConfig.ACL = "bucketOwnerRead"
 	u2.FsConfig.GCSConfig.UploadPartSize = 5
 	u2.FsConfig.GCSConfig.UploadPartMaxTime = 20
+	u2.FsConfig.GCSConfig.EncryptionKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(
+		[]byte("0123456789abcdef0123456789abcdef")))
 	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
 	assert.NoError(t, err)
 
@@ -6162,6 +7765,8 @@ func TestUserHiddenFields(t *testing.T) {
 	assert.Empty(t, user2.FsConfig.GCSConfig.Credentials.GetAdditionalData())
 	assert.NotEmpty(t, user2.FsConfig.GCSConfig.Credentials.GetStatus())
 	assert.NotEmpty(t, user2.FsConfig.GCSConfig.Credentials.GetPayload())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, user2.FsConfig.GCSConfig.EncryptionKey.GetStatus())
+	assert.NotEmpty(t, user2.FsConfig.GCSConfig.EncryptionKey.GetPayload())
 
 	user3, _, err = httpdtest.GetUserByUsername(user3.Username, http.StatusOK)
 	assert.NoError(t, err)
@@ -8506,6 +10111,107 @@ func TestLoaddata(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestReloadConfig(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, reloadConfigPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotImplemented, rr)
+
+	reloaded := false
+	httpd.SetReloadConfigFn(func() error {
+		reloaded = true
+		return nil
+	})
+	req, err = http.NewRequest(http.MethodPost, reloadConfigPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.True(t, reloaded)
+
+	httpd.SetReloadConfigFn(func() error {
+		return errors.New("reload error")
+	})
+	req, err = http.NewRequest(http.MethodPost, reloadConfigPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr)
+
+	httpd.SetReloadConfigFn(nil)
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	u := getTestUser()
+	u.Username = "backup_team_user"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	data, err := dataprovider.DumpData([]string{dataprovider.DumpScopeUsers, dataprovider.DumpScopeRules})
+	assert.NoError(t, err)
+	_, err = dataprovider.EncryptBackup(&data, "")
+	assert.Error(t, err)
+	archive, err := dataprovider.EncryptBackup(&data, "passphrase")
+	assert.NoError(t, err)
+	assert.True(t, dataprovider.IsEncryptedBackup(archive))
+	_, err = dataprovider.DecryptBackup(archive, "wrong passphrase")
+	assert.Error(t, err)
+	_, err = dataprovider.DecryptBackup(archive[:20], "passphrase")
+	assert.Error(t, err)
+	backup, err := dataprovider.DecryptBackup(archive, "passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.DumpVersion, backup.Version)
+	assert.Len(t, backup.Users, len(data.Users))
+	assert.NoError(t, dataprovider.CheckBackupVersion(&backup))
+	backup.Version = dataprovider.DumpVersion + 1
+	assert.Error(t, dataprovider.CheckBackupVersion(&backup))
+	backup.Version = dataprovider.DumpVersion
+
+	filter := dataprovider.BackupFilter{
+		Scopes: []string{"invalid scope"},
+	}
+	assert.Error(t, filter.Validate())
+	filter.Scopes = []string{dataprovider.DumpScopeUsers}
+	filter.UsersPattern = "["
+	assert.Error(t, filter.Validate())
+	filter.UsersPattern = "backup_team_*"
+	assert.NoError(t, filter.Validate())
+	filter.Apply(&backup)
+	assert.Nil(t, backup.EventRules)
+	if assert.Len(t, backup.Users, 1) {
+		assert.Equal(t, user.Username, backup.Users[0].Username)
+	}
+
+	changes, err := dataprovider.GetBackupChanges(&backup, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, dataprovider.BackupChangeUnchanged, changes[0].Action)
+	}
+	changes, err = dataprovider.GetBackupChanges(&backup, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, dataprovider.BackupChangeSkip, changes[0].Action)
+	}
+	backup.Users[0].MaxSessions = 10
+	changes, err = dataprovider.GetBackupChanges(&backup, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, dataprovider.BackupChangeUpdate, changes[0].Action)
+		assert.Equal(t, []string{"max_sessions"}, changes[0].Fields)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	changes, err = dataprovider.GetBackupChanges(&backup, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, dataprovider.BackupChangeAdd, changes[0].Action)
+	}
+}
+
 func TestLoaddataMode(t *testing.T) {
 	err := dataprovider.UpdateConfigs(nil, "", "", "")
 	assert.NoError(t, err)
@@ -8854,6 +10560,76 @@ func TestRateLimiter(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRateLimiterByIdentity(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.RateLimitersConfig = []common.RateLimiterConfig{
+		{
+			Average:          1,
+			Period:           1000,
+			Burst:            1,
+			Type:             3,
+			Protocols:        []string{common.ProtocolHTTP},
+			EntriesSoftLimit: 100,
+			EntriesHardLimit: 150,
+		},
+	}
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Filters.AllowAPIKeyAuth = true
+	admin, _, err := httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	apiKey, _, err := httpdtest.AddAPIKey(dataprovider.APIKey{
+		Name:  "rate limited key",
+		Scope: dataprovider.APIKeyScopeAdmin,
+		Admin: admin.Username,
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+
+	err = common.Initialize(cfg, 0)
+	assert.NoError(t, err)
+	// the rate limiter must not prevent the token generation
+	token, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, versionPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, versionPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.NotEmpty(t, rr.Header().Get("X-Retry-In"))
+	// the API key has its own bucket
+	req, err = http.NewRequest(http.MethodGet, versionPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, versionPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	err = common.Initialize(oldConfig, 0)
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestHTTPSConnection(t *testing.T) {
 	client := &http.Client{
 		Timeout: 5 * time.Second,
@@ -8916,27 +10692,209 @@ func TestBasicUserHandlingMock(t *testing.T) {
 	checkResponseCode(t, http.StatusOK, rr)
 }
 
-func TestAddUserNoUsernameMock(t *testing.T) {
+func TestBulkUsersMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	user := getTestUser()
-	user.Username = ""
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	existing, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	// a user named "bulk" must be reachable using the standard endpoints
+	bulkUser := getTestUser()
+	bulkUser.Username = "bulk"
+	bulkUser.HomeDir = filepath.Join(homeBasePath, bulkUser.Username)
+	newUser := getTestUser()
+	newUser.Username = defaultUsername + "_new"
+	newUser.HomeDir = filepath.Join(homeBasePath, newUser.Username)
+	updatedUser := existing
+	updatedUser.MaxSessions = 7
+	updatedUser.Password = ""
+	users := []dataprovider.User{bulkUser, newUser, updatedUser}
+	asJSON, err := json.Marshal(users)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, userPath+"/bulk?dry_run=true", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var result map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, true, result["dry_run"])
+	assert.Equal(t, false, result["applied"])
+	assert.Equal(t, float64(2), result["created"])
+	assert.Equal(t, float64(1), result["updated"])
+	results := result["results"].([]any)
+	if assert.Len(t, results, 3) {
+		res := results[2].(map[string]any)
+		assert.Equal(t, "update", res["action"])
+		changes := res["changes"].([]any)
+		if assert.Len(t, changes, 1) {
+			change := changes[0].(map[string]any)
+			assert.Equal(t, "max_sessions", change["field"])
+			assert.Equal(t, float64(7), change["new"])
+		}
+	}
+	_, _, err = httpdtest.GetUserByUsername(newUser.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	// invalid records, nothing is applied
+	invalidUser := getTestUser()
+	invalidUser.Username = defaultUsername + "_invalid"
+	invalidUser.HomeDir = "relative"
+	asJSON, err = json.Marshal([]dataprovider.User{newUser, invalidUser, newUser})
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-}
+	result = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, false, result["applied"])
+	assert.Equal(t, float64(2), result["errors"])
+	_, _, err = httpdtest.GetUserByUsername(newUser.Username, http.StatusNotFound)
+	assert.NoError(t, err)
 
-func TestAddUserInvalidHomeDirMock(t *testing.T) {
-	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk?mode=3", bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	user := getTestUser()
-	user.HomeDir = "relative_path"
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
 	setBearerForReq(req, token)
-	rr := executeRequest(req)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk", bytes.NewBuffer([]byte("[]")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk", bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// apply the changes
+	asJSON, err = json.Marshal(users)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk?mode=2", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	result = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, true, result["applied"])
+	user, _, err := httpdtest.GetUserByUsername(existing.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.MaxSessions)
+	user, _, err = httpdtest.GetUserByUsername(bulkUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, bulkUser.HomeDir, user.HomeDir)
+	_, _, err = httpdtest.GetUserByUsername(newUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	// the password for existing users must be preserved
+	_, err = getJWTAPIUserTokenFromTestServer(existing.Username, defaultPassword)
+	assert.NoError(t, err)
+	// mode 1 skips existing users
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk?mode=1", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	result = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), result["skipped"])
+	// CSV partial update
+	csvData := "username,max_sessions,permissions,secondary_groups\n" +
+		existing.Username + ",3,\"list,download\",\n" +
+		defaultUsername + "_csv,0,*,\n"
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk?dry_run=true", bytes.NewBuffer([]byte(csvData)))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	result = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	// the user created from CSV has no home dir and so it is not valid
+	assert.Equal(t, float64(1), result["errors"])
+	assert.Equal(t, float64(1), result["updated"])
+	csvData = "username,max_sessions,permissions,secondary_groups\n" +
+		existing.Username + ",3,\"list,download\",\n"
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk", bytes.NewBuffer([]byte(csvData)))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	user, _, err = httpdtest.GetUserByUsername(existing.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.MaxSessions)
+	assert.Equal(t, []string{dataprovider.PermListItems, dataprovider.PermDownload}, user.Permissions["/"])
+	assert.Equal(t, existing.HomeDir, user.HomeDir)
+	req, err = http.NewRequest(http.MethodPost, userPath+"/bulk", bytes.NewBuffer([]byte("username,unknown\na,b\n")))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// export
+	req, err = http.NewRequest(http.MethodGet, userPath+"/bulk/export", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var exported []dataprovider.User
+	err = json.Unmarshal(rr.Body.Bytes(), &exported)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(exported), 3)
+	for _, u := range exported {
+		assert.Empty(t, u.Password)
+	}
+	req, err = http.NewRequest(http.MethodGet, userPath+"/bulk/export?format=csv", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(records), 4)
+	req, err = http.NewRequest(http.MethodGet, userPath+"/bulk/export?format=xml", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	for _, username := range []string{existing.Username, bulkUser.Username, newUser.Username} {
+		_, err = httpdtest.RemoveUser(dataprovider.User{BaseUser: sdk.BaseUser{Username: username}}, http.StatusOK)
+		assert.NoError(t, err)
+	}
+	err = os.RemoveAll(existing.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestAddUserNoUsernameMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	user := getTestUser()
+	user.Username = ""
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+}
+
+func TestAddUserInvalidHomeDirMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	user := getTestUser()
+	user.HomeDir = "relative_path"
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
 }
 
@@ -9990,6 +11948,199 @@ func TestMFAPermission(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebClientTrustedDevices(t *testing.T) {
+	mfaConfig := mfa.Config{
+		TOTP: []mfa.TOTPConfig{
+			{
+				Name:                "trusted",
+				Issuer:              "SFTPGo",
+				Algo:                mfa.TOTPAlgoSHA1,
+				TrustedDeviceMaxAge: 7,
+			},
+		},
+	}
+	err := mfaConfig.Initialize()
+	require.NoError(t, err)
+	defer func() {
+		mfaConfig = config.GetMFAConfig()
+		err = mfaConfig.Initialize()
+		assert.NoError(t, err)
+	}()
+
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	configName, key, _, err := mfa.GenerateTOTPSecret("trusted", user.Username)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	userTOTPConfig := dataprovider.UserTOTPConfig{
+		Enabled:    true,
+		ConfigName: configName,
+		Secret:     kms.NewPlainSecret(key.Secret()),
+		Protocols:  []string{common.ProtocolHTTP},
+	}
+	asJSON, err := json.Marshal(userTOTPConfig)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userTOTPSavePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	getDevices := func() []map[string]any {
+		req, err := http.NewRequest(http.MethodGet, userTrustedDevicesPath, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		var devices []map[string]any
+		err = json.Unmarshal(rr.Body.Bytes(), &devices)
+		assert.NoError(t, err)
+		return devices
+	}
+	assert.Len(t, getDevices(), 0)
+	// login and remember the device
+	loginCookie, csrfToken, err := getCSRFTokenMock(webClientLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form := getLoginForm(defaultUsername, defaultPassword, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, webClientLoginPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientTwoFactorPath, rr.Header().Get("Location"))
+	cookie, err := getCookieFromResponse(rr)
+	assert.NoError(t, err)
+	passcode, err := generateTOTPPasscode(key.Secret())
+	assert.NoError(t, err)
+	csrfToken, err = getCSRFTokenFromInternalPageMock(webClientTwoFactorPath, cookie)
+	assert.NoError(t, err)
+	form = make(url.Values)
+	form.Set(csrfFormToken, csrfToken)
+	form.Set("passcode", passcode)
+	form.Set("remember_device", "1")
+	req, err = http.NewRequest(http.MethodPost, webClientTwoFactorPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, cookie)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "trusted test agent")
+	rr = executeRequest(req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientFilesPath, rr.Header().Get("Location"))
+	var webToken string
+	var deviceCookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		switch c.Name {
+		case "jwt":
+			webToken = c.Value
+		case "trusted_device":
+			deviceCookie = c
+		}
+	}
+	require.NotEmpty(t, webToken)
+	require.NotNil(t, deviceCookie)
+
+	devices := getDevices()
+	require.Len(t, devices, 1)
+	deviceID := devices[0]["id"].(string)
+	assert.Equal(t, "trusted test agent", devices[0]["user_agent"])
+	assert.Nil(t, devices[0]["fingerprint"])
+	assert.Nil(t, devices[0]["current"])
+	// the device is marked as current from the WebClient
+	req, err = http.NewRequest(http.MethodGet, webClientTrustedDevicesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	req.AddCookie(deviceCookie)
+	req.RemoteAddr = defaultRemoteAddr
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	profileReq, err := http.NewRequest(http.MethodGet, webClientProfilePath, nil)
+	assert.NoError(t, err)
+	profileReq.RemoteAddr = defaultRemoteAddr
+	setJWTCookieForReq(profileReq, webToken)
+	rr = executeRequest(profileReq)
+	checkResponseCode(t, http.StatusOK, rr)
+	webCSRFToken, err := getCSRFTokenFromBody(rr.Body)
+	assert.NoError(t, err)
+	req.Header.Set("X-CSRF-TOKEN", webCSRFToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var webDevices []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &webDevices)
+	assert.NoError(t, err)
+	if assert.Len(t, webDevices, 1) {
+		assert.Equal(t, deviceID, webDevices[0]["id"])
+		assert.Equal(t, true, webDevices[0]["current"])
+	}
+	// devices are not visible to admins with the same username
+	adminToken, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, adminTrustedDevicesPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, adminToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "[]", strings.TrimSpace(rr.Body.String()))
+	req, err = http.NewRequest(http.MethodDelete, path.Join(adminTrustedDevicesPath, deviceID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, adminToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// the trusted device can skip the second factor
+	loginCookie, csrfToken, err = getCSRFTokenMock(webClientLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form = getLoginForm(defaultUsername, defaultPassword, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, webClientLoginPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.AddCookie(deviceCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientFilesPath, rr.Header().Get("Location"))
+	// revoke the device
+	req, err = http.NewRequest(http.MethodDelete, path.Join(webClientTrustedDevicesPath, deviceID), nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	req.RemoteAddr = defaultRemoteAddr
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req.Header.Set("X-CSRF-TOKEN", webCSRFToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Len(t, getDevices(), 0)
+	// a revoked device must use the second factor again
+	loginCookie, csrfToken, err = getCSRFTokenMock(webClientLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form = getLoginForm(defaultUsername, defaultPassword, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, webClientLoginPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.AddCookie(deviceCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientTwoFactorPath, rr.Header().Get("Location"))
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userTrustedDevicesPath, "missing"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestWebUserTwoFactorLogin(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -10710,54 +12861,173 @@ func TestSearchEvents(t *testing.T) {
 	checkResponseCode(t, http.StatusOK, rr)
 }
 
-func TestMFAErrors(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-	assert.False(t, user.Filters.TOTPConfig.Enabled)
-	userToken, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
-	assert.NoError(t, err)
-	adminToken, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+func TestSearchEventsCursorPagination(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
 
-	// invalid config name
-	totpReq := generateTOTPRequest{
-		ConfigName: "invalid config name",
-	}
-	asJSON, err := json.Marshal(totpReq)
-	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userTOTPGeneratePath, bytes.NewBuffer(asJSON))
+	req, err := http.NewRequest(http.MethodGet, fsEventsPath+"?limit=1&order=ASC&cursor_pagination=true", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, userToken)
+	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	// invalid JSON
-	invalidJSON := []byte("not a JSON")
-	req, err = http.NewRequest(http.MethodPost, userTOTPGeneratePath, bytes.NewBuffer(invalidJSON))
+	checkResponseCode(t, http.StatusOK, rr)
+	var resp map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
 	assert.NoError(t, err)
-	setBearerForReq(req, userToken)
+	assert.Len(t, resp["events"], 1)
+	cursor, ok := resp["next_cursor"].(string)
+	assert.True(t, ok)
+	assert.NotEmpty(t, cursor)
+	assert.Nil(t, resp["aggregations"])
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?limit=1&order=ASC&cursor_pagination=true&cursor="+
+		url.QueryEscape(cursor), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodPost, userTOTPSavePath, bytes.NewBuffer(invalidJSON))
+	checkResponseCode(t, http.StatusOK, rr)
+	// the cursor cannot be used with a different order
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?cursor="+url.QueryEscape(cursor), nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, userToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodPost, adminTOTPSavePath, bytes.NewBuffer(invalidJSON))
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?cursor=invalid", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, adminToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodPost, adminTOTPValidatePath, bytes.NewBuffer(invalidJSON))
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?order=ASC&from_id=1&cursor="+url.QueryEscape(cursor), nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, adminToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	// invalid TOTP config name
-	userTOTPConfig := dataprovider.UserTOTPConfig{
-		Enabled:    true,
+	// filters handled by SFTPGo
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?path_prefix=/file.txt&usernames=username1,username2&statuses=1-3", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	events := make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &events)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?path_prefix=/dir&aggregate=true", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	resp = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp["events"], 0)
+	assert.Empty(t, resp["next_cursor"])
+	aggregations := resp["aggregations"].(map[string]any)
+	assert.Equal(t, float64(0), aggregations["total"])
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?statuses=3-1", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodGet, fsEventsPath+"?path_prefix=/dir&csv_export=true", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	req, err = http.NewRequest(http.MethodGet, providerEventsPath+"?aggregate=true&object_name_prefix=12", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	resp = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp["events"], 1)
+	aggregations = resp["aggregations"].(map[string]any)
+	assert.Equal(t, float64(1), aggregations["total"])
+	assert.Equal(t, false, aggregations["truncated"])
+	counts := aggregations["counts"].(map[string]any)
+	assert.Equal(t, map[string]any{"api_key": float64(1)}, counts["object_type"])
+	req, err = http.NewRequest(http.MethodGet, providerEventsPath+"?object_name_prefix=abc", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	events = make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &events)
+	assert.NoError(t, err)
+	assert.Len(t, events, 0)
+
+	req, err = http.NewRequest(http.MethodGet, logEventsPath+"?cursor_pagination=true&aggregate=true", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	resp = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp["events"], 1)
+	aggregations = resp["aggregations"].(map[string]any)
+	counts = aggregations["counts"].(map[string]any)
+	assert.Equal(t, map[string]any{"SSH": float64(1)}, counts["protocol"])
+	// the test eventsearcher plugin returns error if start_timestamp < 0
+	req, err = http.NewRequest(http.MethodGet, logEventsPath+"?start_timestamp=-1&cursor_pagination=true", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr)
+}
+
+func TestMFAErrors(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	assert.False(t, user.Filters.TOTPConfig.Enabled)
+	userToken, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	adminToken, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+
+	// invalid config name
+	totpReq := generateTOTPRequest{
+		ConfigName: "invalid config name",
+	}
+	asJSON, err := json.Marshal(totpReq)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userTOTPGeneratePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// invalid JSON
+	invalidJSON := []byte("not a JSON")
+	req, err = http.NewRequest(http.MethodPost, userTOTPGeneratePath, bytes.NewBuffer(invalidJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodPost, userTOTPSavePath, bytes.NewBuffer(invalidJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodPost, adminTOTPSavePath, bytes.NewBuffer(invalidJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, adminToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodPost, adminTOTPValidatePath, bytes.NewBuffer(invalidJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, adminToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// invalid TOTP config name
+	userTOTPConfig := dataprovider.UserTOTPConfig{
+		Enabled:    true,
 		ConfigName: "missing name",
 		Secret:     kms.NewPlainSecret(xid.New().String()),
 		Protocols:  []string{common.ProtocolSSH},
@@ -11940,6 +14210,129 @@ func TestAdminHandlingWithAPIKeys(t *testing.T) {
 	assert.False(t, sysAdmin.Filters.AllowAPIKeyAuth)
 }
 
+func TestAPIKeyPermissionsAndAllowList(t *testing.T) {
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Filters.AllowAPIKeyAuth = true
+	admin, _, err := httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	user.Filters.AllowAPIKeyAuth = true
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	apiKey := dataprovider.APIKey{
+		Name:  "scoped admin API key",
+		Scope: dataprovider.APIKeyScopeAdmin,
+		Admin: admin.Username,
+		Filters: dataprovider.APIKeyFilters{
+			Permissions: []string{"files:read"},
+		},
+	}
+	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	apiKey.Filters.Permissions = []string{"users:delete"}
+	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	apiKey.Filters.Permissions = []string{"users:read", "quotas:write"}
+	apiKey.Filters.AllowList = []string{"invalid"}
+	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	apiKey.Filters.AllowList = []string{"192.0.2.0/24", "::1/128"}
+	apiKey, resp, err := httpdtest.AddAPIKey(apiKey, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+
+	req, err := http.NewRequest(http.MethodGet, userPath+"/"+user.Username, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodPut, quotasBasePath+"/users/"+user.Username+"/usage",
+		bytes.NewBuffer([]byte(`{"used_quota_size":0,"used_quota_files":0}`)))
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodDelete, userPath+"/"+user.Username, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, groupPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// endpoints without a resource mapping are not allowed for restricted keys
+	req, err = http.NewRequest(http.MethodGet, apiKeysPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// not allowed IP
+	plainKey := apiKey.Key
+	apiKey.Filters.AllowList = []string{"172.16.1.0/24"}
+	apiKey.Key = ""
+	_, _, err = httpdtest.UpdateAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, userPath+"/"+user.Username, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, plainKey, "")
+	req.RemoteAddr = "192.0.2.1:4567"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	k, err := dataprovider.APIKeyExists(apiKey.KeyID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"172.16.1.0/24"}, k.Filters.AllowList)
+	assert.Len(t, k.Filters.Permissions, 2)
+
+	userAPIKey := dataprovider.APIKey{
+		Name:  "scoped user API key",
+		Scope: dataprovider.APIKeyScopeUser,
+		User:  user.Username,
+		Filters: dataprovider.APIKeyFilters{
+			Permissions: []string{"users:read"},
+		},
+	}
+	_, _, err = httpdtest.AddAPIKey(userAPIKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	userAPIKey.Filters.Permissions = []string{"files:read"}
+	userAPIKey, resp, err = httpdtest.AddAPIKey(userAPIKey, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, userAPIKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodPost, userDirsPath+"?path=adir", nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, userAPIKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, userAPIKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveAPIKey(userAPIKey, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAPIKey(apiKey, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestUserHandlingWithAPIKey(t *testing.T) {
 	admin := getTestAdmin()
 	admin.Username = altAdminUsername
@@ -12380,181 +14773,684 @@ func TestStartQuotaScanMock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestUpdateFolderQuotaUsageMock(t *testing.T) {
+func TestAsyncJobsMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	mappedPath := filepath.Join(os.TempDir(), "vfolder")
-	folderName := filepath.Base(mappedPath)
-	f := vfs.BaseVirtualFolder{
-		MappedPath: mappedPath,
-		Name:       folderName,
-	}
-	usedQuotaFiles := 1
-	usedQuotaSize := int64(65535)
-	f.UsedQuotaFiles = usedQuotaFiles
-	f.UsedQuotaSize = usedQuotaSize
-	var folder vfs.BaseVirtualFolder
-	folderAsJSON, err := json.Marshal(f)
+	u1 := getTestUser()
+	u1.Username += "_job1"
+	user1, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	u2 := getTestUser()
+	u2.Username += "_job2"
+	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(user1.HomeDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user1.HomeDir, "file.txt"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "users", user1.Username, "scan"), nil)
 	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer(folderAsJSON))
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	err = render.DecodeJSON(rr.Body, &folder)
+	checkResponseCode(t, http.StatusAccepted, rr)
+	var resp map[string]string
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	jobID := resp["job_id"]
+	assert.NotEmpty(t, jobID)
+	assert.Equal(t, path.Join(jobsPath, jobID), rr.Header().Get("Location"))
+	job := waitForJob(t, token, jobID)
+	assert.Equal(t, common.JobStatusCompleted, job.Status)
+	assert.Equal(t, common.JobTypeUserQuotaScan, job.Type)
+	assert.Equal(t, defaultTokenAuthUser, job.Admin)
+	assert.Equal(t, 100, job.Progress)
+	result, ok := job.Result.(map[string]any)
+	if assert.True(t, ok) {
+		assert.Equal(t, float64(1), result["used_quota_files"])
+		assert.Equal(t, float64(7), result["used_quota_size"])
+	}
+	// a finished job cannot be canceled
+	req, err = http.NewRequest(http.MethodDelete, path.Join(jobsPath, jobID), nil)
 	assert.NoError(t, err)
-	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"), bytes.NewBuffer(folderAsJSON))
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	var folderGet vfs.BaseVirtualFolder
-	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodGet, path.Join(jobsPath, "missing"), nil)
+	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	err = render.DecodeJSON(rr.Body, &folderGet)
-	assert.NoError(t, err)
-	assert.Equal(t, usedQuotaFiles, folderGet.UsedQuotaFiles)
-	assert.Equal(t, usedQuotaSize, folderGet.UsedQuotaSize)
-	// now update only quota size
-	f.UsedQuotaFiles = 0
-	folderAsJSON, err = json.Marshal(f)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(jobsPath, "missing"), nil)
 	assert.NoError(t, err)
-	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage")+"?mode=add",
-		bytes.NewBuffer(folderAsJSON))
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	folderGet = vfs.BaseVirtualFolder{}
-	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
-	setBearerForReq(req, token)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// an admin without the "*" permission can only see its own jobs
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Permissions = []string{dataprovider.PermAdminViewUsers, dataprovider.PermAdminManageSystem}
+	admin, _, err = httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	altToken, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, path.Join(jobsPath, jobID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	err = render.DecodeJSON(rr.Body, &folderGet)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(jobsPath, jobID), nil)
 	assert.NoError(t, err)
-	assert.Equal(t, usedQuotaFiles, folderGet.UsedQuotaFiles)
-	assert.Equal(t, usedQuotaSize*2, folderGet.UsedQuotaSize)
-	// now update only quota files
-	f.UsedQuotaSize = 0
-	f.UsedQuotaFiles = 1
-	folderAsJSON, err = json.Marshal(f)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, "bulk", "delete"),
+		bytes.NewBuffer([]byte(`["`+user2.Username+`"]`)))
 	assert.NoError(t, err)
-	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage")+"?mode=add",
-		bytes.NewBuffer(folderAsJSON))
-	setBearerForReq(req, token)
+	setBearerForReq(req, altToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	folderGet = vfs.BaseVirtualFolder{}
-	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	err = render.DecodeJSON(rr.Body, &folderGet)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, err = http.NewRequest(http.MethodGet, dumpDataPath+"?async=true&output-data=1", nil)
 	assert.NoError(t, err)
-	assert.Equal(t, usedQuotaFiles*2, folderGet.UsedQuotaFiles)
-	assert.Equal(t, usedQuotaSize*2, folderGet.UsedQuotaSize)
-	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"),
-		bytes.NewBuffer([]byte("not a json")))
-	setBearerForReq(req, token)
+	setBearerForReq(req, altToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
+	backupFile := "async_backup.json"
+	req, err = http.NewRequest(http.MethodGet, dumpDataPath+"?async=true&scopes=users&output-file="+backupFile, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	job = waitForJob(t, altToken, resp["job_id"])
+	assert.Equal(t, common.JobStatusCompleted, job.Status, job.Error)
+	assert.Equal(t, common.JobTypeBackup, job.Type)
+	assert.FileExists(t, filepath.Join(backupsPath, backupFile))
+	err = os.Remove(filepath.Join(backupsPath, backupFile))
+	assert.NoError(t, err)
 
-	assert.True(t, common.QuotaScans.AddVFolderQuotaScan(folderName))
-	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"),
-		bytes.NewBuffer(folderAsJSON))
-	setBearerForReq(req, token)
+	req, err = http.NewRequest(http.MethodGet, jobsPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, altToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusConflict, rr)
-	assert.True(t, common.QuotaScans.RemoveVFolderQuotaScan(folderName))
+	checkResponseCode(t, http.StatusOK, rr)
+	var jobs []common.Job
+	err = json.Unmarshal(rr.Body.Bytes(), &jobs)
+	assert.NoError(t, err)
+	for _, j := range jobs {
+		assert.Equal(t, altAdminUsername, j.Admin)
+	}
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
 
-	req, _ = http.NewRequest(http.MethodDelete, path.Join(folderPath, folderName), nil)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, "bulk", "delete"), bytes.NewBuffer([]byte(`[]`)))
+	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, "bulk", "delete"),
+		bytes.NewBuffer([]byte(`["`+user1.Username+`","`+user2.Username+`","missing_user"]`)))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	job = waitForJob(t, token, resp["job_id"])
+	assert.Equal(t, common.JobStatusCompleted, job.Status)
+	assert.True(t, job.Cancelable)
+	result, ok = job.Result.(map[string]any)
+	if assert.True(t, ok) {
+		assert.Equal(t, float64(2), result["deleted"])
+		errs, ok := result["errors"].(map[string]any)
+		if assert.True(t, ok) {
+			assert.Contains(t, errs, "missing_user")
+		}
+	}
+	_, _, err = httpdtest.GetUserByUsername(user1.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetUserByUsername(user2.Username, http.StatusNotFound)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(user2.GetHomeDir())
+	assert.NoError(t, err)
 }
 
-func TestStartFolderQuotaScanMock(t *testing.T) {
+func TestAuditLog(t *testing.T) {
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	providerConf.AuditLog.Enabled = true
+	providerConf.AuditLog.HMACSecret = "audit log secret"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	assert.True(t, dataprovider.IsAuditLogEnabled())
+
+	_, err = getJWTAPITokenFromTestServer(defaultTokenAuthUser, "wrong password")
+	assert.Error(t, err)
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	mappedPath := filepath.Join(os.TempDir(), "vfolder")
-	folderName := filepath.Base(mappedPath)
-	folder := vfs.BaseVirtualFolder{
-		Name:       folderName,
-		MappedPath: mappedPath,
-	}
-	folderAsJSON, err := json.Marshal(folder)
+	u := getTestUser()
+	u.Username += "_audit"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer(folderAsJSON))
-	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	_, err = os.Stat(mappedPath)
-	if err == nil {
-		err = os.Remove(mappedPath)
+
+	var entries []dataprovider.AuditLogEntry
+	var rr *httptest.ResponseRecorder
+	var req *http.Request
+	var from int64
+	for {
+		req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s?limit=1000&from=%d", auditLogPath, from), nil)
 		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		var page []dataprovider.AuditLogEntry
+		err = json.Unmarshal(rr.Body.Bytes(), &page)
+		require.NoError(t, err)
+		entries = append(entries, page...)
+		if len(page) > 0 {
+			from = page[len(page)-1].ID
+		}
+		if len(page) < 1000 {
+			break
+		}
 	}
-	// simulate a duplicate quota scan
-	common.QuotaScans.AddVFolderQuotaScan(folderName)
-	req, _ = http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
+	var hasLoginOK, hasLoginKO, hasAddUser bool
+	var lastID int64
+	for _, entry := range entries {
+		assert.Greater(t, entry.ID, lastID)
+		lastID = entry.ID
+		assert.NotEmpty(t, entry.Hash)
+		switch {
+		case entry.Category == dataprovider.AuditLogCategoryAuth && entry.Username == defaultTokenAuthUser:
+			if entry.Status == 1 {
+				hasLoginOK = true
+			} else {
+				hasLoginKO = true
+				assert.NotEmpty(t, entry.Info)
+			}
+		case entry.Category == dataprovider.AuditLogCategoryProvider && entry.ObjectName == user.Username &&
+			entry.Action == "add":
+			assert.Equal(t, defaultTokenAuthUser, entry.Username)
+			hasAddUser = true
+		}
+	}
+	assert.True(t, hasLoginOK)
+	assert.True(t, hasLoginKO)
+	assert.True(t, hasAddUser)
+
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s?from=%d", auditLogPath, lastID), nil)
+	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusConflict, rr)
-	assert.True(t, common.QuotaScans.RemoveVFolderQuotaScan(folderName))
-	// and now a real quota scan
-	_, err = os.Stat(mappedPath)
-	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		err = os.MkdirAll(mappedPath, os.ModePerm)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "[]", strings.TrimSpace(rr.Body.String()))
+
+	for _, query := range []string{"?from=a", "?from=-1", "?limit=a", "?limit=0"} {
+		req, err = http.NewRequest(http.MethodGet, auditLogPath+query, nil)
 		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr)
 	}
-	req, _ = http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusAccepted, rr)
-	waitForFoldersQuotaScanPath(t, token)
-	// cleanup
-	req, _ = http.NewRequest(http.MethodDelete, path.Join(folderPath, folderName), nil)
+
+	req, err = http.NewRequest(http.MethodGet, auditLogVerifyPath, nil)
+	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	err = os.RemoveAll(folderPath)
+	var result dataprovider.AuditLogVerifyResult
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
 	assert.NoError(t, err)
-	err = os.RemoveAll(mappedPath)
+	assert.True(t, result.Valid, result.Error)
+	assert.GreaterOrEqual(t, result.Entries, int64(len(entries)))
+	assert.Len(t, result.Nodes, 1)
+	// the view_audit_log permission is required
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Permissions = []string{dataprovider.PermAdminViewEvents}
+	admin, _, err = httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	altToken, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	for _, p := range []string{auditLogPath, auditLogVerifyPath} {
+		req, err = http.NewRequest(http.MethodGet, p, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, altToken)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusForbidden, rr)
+	}
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	// entries recorded using a different secret cannot be verified
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.AuditLog.HMACSecret = "different secret"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	result, err = dataprovider.VerifyAuditLog()
+	assert.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Greater(t, result.InvalidID, int64(0))
+	assert.NotEmpty(t, result.Error)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
 	assert.NoError(t, err)
+	assert.False(t, dataprovider.IsAuditLogEnabled())
 }
 
-func TestStartQuotaScanNonExistentUserMock(t *testing.T) {
-	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+func TestLiveEventsStream(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v%v", httpBaseURL, tokenPath), nil)
 	assert.NoError(t, err)
-	user := getTestUser()
+	req.SetBasicAuth(defaultTokenAuthUser, defaultTokenAuthPass)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	require.NoError(t, err)
+	responseHolder := make(map[string]any)
+	err = render.DecodeJSON(resp.Body, &responseHolder)
+	assert.NoError(t, err)
+	err = resp.Body.Close()
+	assert.NoError(t, err)
+	token := responseHolder["access_token"].(string)
 
-	req, _ := http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "users", user.Username, "scan"), nil)
-	setBearerForReq(req, token)
+	wsURL := strings.Replace(httpBaseURL, "http://", "ws://", 1) + liveEventsPath
+	// cross origin connections are not allowed
+	config, err := websocket.NewConfig(wsURL, "http://example.com")
+	require.NoError(t, err)
+	config.Header.Set("Authorization", "Bearer "+token)
+	_, err = websocket.DialConfig(config)
+	assert.Error(t, err)
+	// missing authentication
+	config, err = websocket.NewConfig(wsURL, httpBaseURL)
+	require.NoError(t, err)
+	_, err = websocket.DialConfig(config)
+	assert.Error(t, err)
+
+	config, err = websocket.NewConfig(wsURL+"?types=provider&usernames="+defaultTokenAuthUser, httpBaseURL)
+	require.NoError(t, err)
+	config.Header.Set("Authorization", "Bearer "+token)
+	ws, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	// wait for the subscription
+	time.Sleep(100 * time.Millisecond)
+
+	u := getTestUser()
+	u.Username += "_live"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	err = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, err)
+	var ev common.LiveEvent
+	err = websocket.JSON.Receive(ws, &ev)
+	assert.NoError(t, err)
+	assert.Equal(t, common.LiveEventTypeProvider, ev.Type)
+	assert.Equal(t, "add", ev.Action)
+	assert.Equal(t, defaultTokenAuthUser, ev.Username)
+	assert.Equal(t, "user", ev.ObjectType)
+	assert.Equal(t, user.Username, ev.ObjectName)
+	assert.Greater(t, ev.Timestamp, int64(0))
+	err = ws.Close()
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	apiToken, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, liveEventsPath+"?types=invalid", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, apiToken)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "invalid event type")
+
+	webToken, err := getJWTWebTokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webLiveEventsPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
 }
 
-func TestStartQuotaScanNonExistentFolderMock(t *testing.T) {
+func TestGraphQLMock(t *testing.T) {
+	group, _, err := httpdtest.AddGroup(getTestGroup(), http.StatusCreated)
+	assert.NoError(t, err)
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	folder := vfs.BaseVirtualFolder{
-		MappedPath: os.TempDir(),
-		Name:       "afolder",
+
+	executeGraphQL := func(token string, gqlReq map[string]any, expectedStatusCode int) map[string]any {
+		asJSON, err := json.Marshal(gqlReq)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, graphQLPath, bytes.NewBuffer(asJSON))
+		require.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, expectedStatusCode, rr)
+		resp := make(map[string]any)
+		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		require.NoError(t, err)
+		return resp
 	}
-	req, _ := http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
-	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+
+	u := getTestUser()
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	resp := executeGraphQL(token, map[string]any{
+		"query": `mutation AddUser($user: User!) {
+			add_user(user: $user) { username status }
+		}`,
+		"variables": map[string]any{"user": u},
+	}, http.StatusOK)
+	assert.Nil(t, resp["errors"])
+	assert.Equal(t, map[string]any{"username": u.Username, "status": float64(1)},
+		resp["data"].(map[string]any)["add_user"])
+
+	resp = executeGraphQL(token, map[string]any{
+		"query": `query {
+			user(username: "` + u.Username + `") {
+				username
+				used_quota_size
+				used_quota_files
+				groups { name type group { name description } }
+				connections { connection_id }
+			}
+			groups(limit: 10) { name users }
+		}`,
+	}, http.StatusOK)
+	assert.Nil(t, resp["errors"])
+	data := resp["data"].(map[string]any)
+	user := data["user"].(map[string]any)
+	assert.Equal(t, u.Username, user["username"])
+	assert.Contains(t, user, "used_quota_size")
+	assert.Contains(t, user, "used_quota_files")
+	assert.Len(t, user["connections"], 0)
+	if groups, ok := user["groups"].([]any); assert.True(t, ok) && assert.Len(t, groups, 1) {
+		assert.Equal(t, map[string]any{
+			"name": group.Name,
+			"type": float64(sdk.GroupTypePrimary),
+			"group": map[string]any{
+				"name":        group.Name,
+				"description": group.Description,
+			},
+		}, groups[0])
+	}
+	if groups, ok := data["groups"].([]any); assert.True(t, ok) && assert.Len(t, groups, 1) {
+		assert.Equal(t, []any{u.Username}, groups[0].(map[string]any)["users"])
+	}
+
+	resp = executeGraphQL(token, map[string]any{
+		"query": `mutation UpdateUser($username: String!, $user: User!) {
+			update_user(username: $username, user: $user, disconnect: true) { status groups { name } }
+		}`,
+		"variables": map[string]any{
+			"username": u.Username,
+			"user": map[string]any{
+				"status":      0,
+				"home_dir":    u.HomeDir,
+				"permissions": u.Permissions,
+				"groups":      u.Groups,
+			},
+		},
+	}, http.StatusOK)
+	assert.Nil(t, resp["errors"])
+	assert.Equal(t, map[string]any{"status": float64(0), "groups": []any{map[string]any{"name": group.Name}}},
+		resp["data"].(map[string]any)["update_user"])
+	// invalid arguments and syntax errors
+	resp = executeGraphQL(token, map[string]any{
+		"query": `{ users(limit: 1000) { username } }`,
+	}, http.StatusOK)
+	assert.Contains(t, fmt.Sprint(resp["errors"]), "limit must be between 1 and 500")
+	resp = executeGraphQL(token, map[string]any{
+		"query": `{ users { username }`,
+	}, http.StatusBadRequest)
+	assert.Nil(t, resp["data"])
+	assert.NotNil(t, resp["errors"])
+	// an admin without the required permissions gets an error for the denied fields
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Permissions = []string{dataprovider.PermAdminViewUsers}
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+	altToken, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	resp = executeGraphQL(altToken, map[string]any{
+		"query": `{
+			users { username groups { group { name } } }
+			folders { name }
+		}`,
+	}, http.StatusOK)
+	data = resp["data"].(map[string]any)
+	assert.Len(t, data["users"], 1)
+	assert.Nil(t, data["folders"])
+	if errs, ok := resp["errors"].([]any); assert.True(t, ok) {
+		assert.Len(t, errs, 2)
+		assert.Contains(t, fmt.Sprint(errs), dataprovider.PermAdminViewGroups)
+		assert.Contains(t, fmt.Sprint(errs), dataprovider.PermAdminViewFolders)
+	}
+	resp = executeGraphQL(altToken, map[string]any{
+		"query": `mutation { delete_user(username: "` + u.Username + `") }`,
+	}, http.StatusOK)
+	assert.Contains(t, fmt.Sprint(resp["errors"]), dataprovider.PermAdminDeleteUsers)
+
+	resp = executeGraphQL(token, map[string]any{
+		"query": `mutation {
+			delete_user(username: "` + u.Username + `")
+			missing: delete_group(name: "missing")
+		}`,
+	}, http.StatusOK)
+	assert.Equal(t, true, resp["data"].(map[string]any)["delete_user"])
+	assert.Len(t, resp["errors"], 1)
+
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
 }
 
-func TestGetFoldersMock(t *testing.T) {
+func TestUpdateFolderQuotaUsageMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
 	mappedPath := filepath.Join(os.TempDir(), "vfolder")
 	folderName := filepath.Base(mappedPath)
-	folder := vfs.BaseVirtualFolder{
-		Name:       folderName,
+	f := vfs.BaseVirtualFolder{
 		MappedPath: mappedPath,
+		Name:       folderName,
 	}
-	folderAsJSON, err := json.Marshal(folder)
+	usedQuotaFiles := 1
+	usedQuotaSize := int64(65535)
+	f.UsedQuotaFiles = usedQuotaFiles
+	f.UsedQuotaSize = usedQuotaSize
+	var folder vfs.BaseVirtualFolder
+	folderAsJSON, err := json.Marshal(f)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	err = render.DecodeJSON(rr.Body, &folder)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"), bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var folderGet vfs.BaseVirtualFolder
+	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = render.DecodeJSON(rr.Body, &folderGet)
+	assert.NoError(t, err)
+	assert.Equal(t, usedQuotaFiles, folderGet.UsedQuotaFiles)
+	assert.Equal(t, usedQuotaSize, folderGet.UsedQuotaSize)
+	// now update only quota size
+	f.UsedQuotaFiles = 0
+	folderAsJSON, err = json.Marshal(f)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage")+"?mode=add",
+		bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	folderGet = vfs.BaseVirtualFolder{}
+	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = render.DecodeJSON(rr.Body, &folderGet)
+	assert.NoError(t, err)
+	assert.Equal(t, usedQuotaFiles, folderGet.UsedQuotaFiles)
+	assert.Equal(t, usedQuotaSize*2, folderGet.UsedQuotaSize)
+	// now update only quota files
+	f.UsedQuotaSize = 0
+	f.UsedQuotaFiles = 1
+	folderAsJSON, err = json.Marshal(f)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage")+"?mode=add",
+		bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	folderGet = vfs.BaseVirtualFolder{}
+	req, _ = http.NewRequest(http.MethodGet, path.Join(folderPath, folderName), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = render.DecodeJSON(rr.Body, &folderGet)
+	assert.NoError(t, err)
+	assert.Equal(t, usedQuotaFiles*2, folderGet.UsedQuotaFiles)
+	assert.Equal(t, usedQuotaSize*2, folderGet.UsedQuotaSize)
+	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"),
+		bytes.NewBuffer([]byte("not a json")))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	assert.True(t, common.QuotaScans.AddVFolderQuotaScan(folderName))
+	req, _ = http.NewRequest(http.MethodPut, path.Join(quotasBasePath, "folders", folder.Name, "usage"),
+		bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.True(t, common.QuotaScans.RemoveVFolderQuotaScan(folderName))
+
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(folderPath, folderName), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+}
+
+func TestStartFolderQuotaScanMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	mappedPath := filepath.Join(os.TempDir(), "vfolder")
+	folderName := filepath.Base(mappedPath)
+	folder := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: mappedPath,
+	}
+	folderAsJSON, err := json.Marshal(folder)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer(folderAsJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	_, err = os.Stat(mappedPath)
+	if err == nil {
+		err = os.Remove(mappedPath)
+		assert.NoError(t, err)
+	}
+	// simulate a duplicate quota scan
+	common.QuotaScans.AddVFolderQuotaScan(folderName)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.True(t, common.QuotaScans.RemoveVFolderQuotaScan(folderName))
+	// and now a real quota scan
+	_, err = os.Stat(mappedPath)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		err = os.MkdirAll(mappedPath, os.ModePerm)
+		assert.NoError(t, err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, rr)
+	waitForFoldersQuotaScanPath(t, token)
+	// cleanup
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(folderPath, folderName), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = os.RemoveAll(folderPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
+func TestStartQuotaScanNonExistentUserMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	user := getTestUser()
+
+	req, _ := http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "users", user.Username, "scan"), nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+}
+
+func TestStartQuotaScanNonExistentFolderMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	folder := vfs.BaseVirtualFolder{
+		MappedPath: os.TempDir(),
+		Name:       "afolder",
+	}
+	req, _ := http.NewRequest(http.MethodPost, path.Join(quotasBasePath, "folders", folder.Name, "scan"), nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+}
+
+func TestGetFoldersMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	mappedPath := filepath.Join(os.TempDir(), "vfolder")
+	folderName := filepath.Base(mappedPath)
+	folder := vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: mappedPath,
+	}
+	folderAsJSON, err := json.Marshal(folder)
 	assert.NoError(t, err)
 	req, _ := http.NewRequest(http.MethodPost, folderPath, bytes.NewBuffer(folderAsJSON))
 	setBearerForReq(req, token)
@@ -12659,6 +15555,143 @@ func TestMethodNotAllowedMock(t *testing.T) {
 	checkResponseCode(t, http.StatusMethodNotAllowed, rr)
 }
 
+func TestScheduledBackups(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	mappedPath := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	backupsDir := filepath.Join(mappedPath, "provider", "backups")
+	err = os.MkdirAll(backupsDir, os.ModePerm)
+	assert.NoError(t, err)
+	oldBackups := []string{"sftpgo_backup_20200101T000000Z.json.gz.age", "sftpgo_backup_20200102T000000Z.sftpgobak"}
+	for _, name := range append(oldBackups, "other_file.age") {
+		err = os.WriteFile(filepath.Join(backupsDir, name), []byte("content"), 0666)
+		assert.NoError(t, err)
+	}
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	providerConf.ScheduledBackups.Schedule = "0 2 * * *"
+	providerConf.ScheduledBackups.Folder = folder.Name
+	providerConf.ScheduledBackups.Path = "/provider/backups"
+	providerConf.ScheduledBackups.Retention = 2
+	providerConf.ScheduledBackups.AgeRecipients = []string{"invalid"}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "invalid age recipient")
+	providerConf.ScheduledBackups.AgeRecipients = nil
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.ErrorContains(t, err, "passphrase file are required")
+	providerConf.ScheduledBackups.AgeRecipients = []string{identity.Recipient().String()}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, healthzPath+"?details=true", nil)
+	assert.NoError(t, err)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), `"scheduled_backups":{"status":"pending"}`)
+
+	status, err := dataprovider.ExecuteScheduledBackup()
+	assert.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Greater(t, status.LastSuccess, int64(0))
+	assert.Greater(t, status.LastSize, int64(0))
+	assert.Empty(t, status.LastError)
+	// the oldest backup exceeds the retention and must be removed, other files are ignored
+	assert.NoFileExists(t, filepath.Join(backupsDir, oldBackups[0]))
+	assert.FileExists(t, filepath.Join(backupsDir, oldBackups[1]))
+	assert.FileExists(t, filepath.Join(backupsDir, "other_file.age"))
+	encrypted, err := os.ReadFile(filepath.Join(backupsDir, status.LastFile))
+	require.NoError(t, err)
+	assert.Len(t, encrypted, int(status.LastSize))
+	r, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	backup, err := dataprovider.ParseDumpData(decrypted)
+	assert.NoError(t, err)
+	assert.Greater(t, len(backup.Admins), 0)
+
+	req, err = http.NewRequest(http.MethodGet, healthzPath+"?details=true", nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), `"scheduled_backups":{"status":"ok"`)
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, serverStatusPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var servicesStatus httpd.ServicesStatus
+	err = json.Unmarshal(rr.Body.Bytes(), &servicesStatus)
+	assert.NoError(t, err)
+	assert.Equal(t, status.LastFile, servicesStatus.ScheduledBackups.LastFile)
+	// the passphrase is used if no age recipient is set
+	passphraseFile := filepath.Join(os.TempDir(), util.GenerateUniqueID())
+	err = os.WriteFile(passphraseFile, []byte("backup passphrase\n"), 0600)
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.ScheduledBackups.AgeRecipients = nil
+	providerConf.ScheduledBackups.PassphraseFile = passphraseFile
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	status, err = dataprovider.ExecuteScheduledBackup()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(status.LastFile, ".sftpgobak"))
+	encrypted, err = os.ReadFile(filepath.Join(backupsDir, status.LastFile))
+	require.NoError(t, err)
+	assert.True(t, dataprovider.IsEncryptedBackup(encrypted))
+	backup, err = dataprovider.DecryptBackup(encrypted, "backup passphrase")
+	assert.NoError(t, err)
+	assert.Greater(t, len(backup.Admins), 0)
+	// a missing folder is reported as failure
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.ScheduledBackups.Folder = "missing folder"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	status, err = dataprovider.ExecuteScheduledBackup()
+	assert.Error(t, err)
+	assert.NotEmpty(t, status.LastError)
+	req, err = http.NewRequest(http.MethodGet, healthzPath+"?details=true", nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), `"scheduled_backups":{"status":"failed"`)
+	assert.NotContains(t, rr.Body.String(), "missing folder")
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	providerConf.BackupsPath = backupsPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	_, err = dataprovider.ExecuteScheduledBackup()
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.Remove(passphraseFile)
+	assert.NoError(t, err)
+}
+
 func TestHealthCheck(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodGet, healthzPath, nil)
 	rr := executeRequest(req)
@@ -13786,24 +16819,183 @@ func TestWebClientChangePwd(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestPreDownloadHook(t *testing.T) {
-	if runtime.GOOS == osWindows {
-		t.Skip("this test is not available on Windows")
-	}
-	oldExecuteOn := common.Config.Actions.ExecuteOn
-	oldHook := common.Config.Actions.Hook
-
-	common.Config.Actions.ExecuteOn = []string{common.OperationPreDownload}
-	common.Config.Actions.Hook = preActionPath
-
-	u := getTestUser()
-	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+func TestWebAdminImpersonateUser(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
-	err = os.WriteFile(preActionPath, getExitCodeScriptContent(0), os.ModePerm)
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Permissions = []string{dataprovider.PermAdminViewUsers}
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
 	assert.NoError(t, err)
 
-	testFileName := "testfile"
-	testFileContents := []byte("file contents")
+	impersonatePath := path.Join(webUserPath, user.Username, "impersonate")
+	webToken, err := getJWTWebTokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webAdminProfilePath, webToken)
+	assert.NoError(t, err)
+	form := make(url.Values)
+	form.Set(csrfFormToken, csrfToken)
+	req, err := http.NewRequest(http.MethodPost, impersonatePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	admin.Permissions = append(admin.Permissions, dataprovider.PermAdminImpersonateUsers)
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	webToken, err = getJWTWebTokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	csrfToken, err = getCSRFTokenFromInternalPageMock(webAdminProfilePath, webToken)
+	assert.NoError(t, err)
+	// no csrf token
+	req, err = http.NewRequest(http.MethodPost, impersonatePath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCSRF)
+
+	form.Set(csrfFormToken, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webUserPath, "missing", "impersonate"),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodPost, impersonatePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Equal(t, webClientFilesPath, rr.Header().Get("Location"))
+	var clientToken string
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "jwt" {
+			clientToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, clientToken)
+
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "impersonation.badge")
+	// credentials cannot be changed while impersonating
+	req, err = http.NewRequest(http.MethodGet, webChangeClientPwdPath, nil)
+	assert.NoError(t, err)
+	req.RequestURI = webChangeClientPwdPath
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorImpersonationNotAllowed)
+	req, err = http.NewRequest(http.MethodGet, webClientMFAPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// an impersonation token is not valid for the WebAdmin
+	req, err = http.NewRequest(http.MethodGet, webUsersPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// the impersonating admin is checked for each request
+	admin.Permissions = []string{dataprovider.PermAdminViewUsers}
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorImpersonationFailed)
+	// the session is ended
+	admin.Permissions = append(admin.Permissions, dataprovider.PermAdminImpersonateUsers)
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+
+	impersonate := func() string {
+		req, err := http.NewRequest(http.MethodPost, impersonatePath, bytes.NewBuffer([]byte(form.Encode())))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		setJWTCookieForReq(req, webToken)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusFound, rr)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == "jwt" {
+				return cookie.Value
+			}
+		}
+		return ""
+	}
+	clientToken = impersonate()
+	require.NotEmpty(t, clientToken)
+	admin.Status = 0
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// a disabled admin cannot start a new session using an existing token
+	req, err = http.NewRequest(http.MethodPost, impersonatePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	admin.Status = 1
+	_, _, err = httpdtest.UpdateAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+
+	clientToken = impersonate()
+	require.NotEmpty(t, clientToken)
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, clientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPreDownloadHook(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	oldExecuteOn := common.Config.Actions.ExecuteOn
+	oldHook := common.Config.Actions.Hook
+
+	common.Config.Actions.ExecuteOn = []string{common.OperationPreDownload}
+	common.Config.Actions.Hook = preActionPath
+
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.WriteFile(preActionPath, getExitCodeScriptContent(0), os.ModePerm)
+	assert.NoError(t, err)
+
+	testFileName := "testfile"
+	testFileContents := []byte("file contents")
 	err = os.MkdirAll(filepath.Join(user.GetHomeDir()), os.ModePerm)
 	assert.NoError(t, err)
 	err = os.WriteFile(filepath.Join(user.GetHomeDir(), testFileName), testFileContents, os.ModePerm)
@@ -13920,31 +17112,601 @@ func TestPreUploadHook(t *testing.T) {
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	common.Config.Actions.ExecuteOn = oldExecuteOn
-	common.Config.Actions.Hook = oldHook
+
+	common.Config.Actions.ExecuteOn = oldExecuteOn
+	common.Config.Actions.Hook = oldHook
+}
+
+func TestShareUsage(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	testFileName := "testfile.dat"
+	testFileSize := int64(65536)
+	testFilePath := filepath.Join(user.GetHomeDir(), testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	share := dataprovider.Share{
+		Name:      "test share",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{"/"},
+		Password:  defaultPassword,
+		MaxTokens: 2,
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Second)),
+	}
+	asJSON, err := json.Marshal(share)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/unknownid", nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+
+	req.SetBasicAuth(defaultUsername, "wrong password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	time.Sleep(2 * time.Second)
+
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"_mod", nil)
+	assert.NoError(t, err)
+	req.RequestURI = webClientPubSharesPath + "/" + objectID + "_mod"
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	share.ExpiresAt = 0
+	jsonReq := make(map[string]any)
+	jsonReq["name"] = share.Name
+	jsonReq["scope"] = share.Scope
+	jsonReq["paths"] = share.Paths
+	jsonReq["password"] = share.Password
+	jsonReq["max_tokens"] = share.MaxTokens
+	jsonReq["expires_at"] = share.ExpiresAt
+	asJSON, err = json.Marshal(jsonReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "invalid share scope")
+
+	share.MaxTokens = 3
+	share.Scope = dataprovider.ShareScopeWrite
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part1, err := writer.CreateFormFile("filenames", "file1.txt")
+	assert.NoError(t, err)
+	_, err = part1.Write([]byte("file1 content"))
+	assert.NoError(t, err)
+	part2, err := writer.CreateFormFile("filenames", "file2.txt")
+	assert.NoError(t, err)
+	_, err = part2.Write([]byte("file2 content"))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+	reader := bytes.NewReader(body.Bytes())
+
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Unable to parse multipart form")
+
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	// set the proper content type
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Allowed usage exceeded")
+
+	share.MaxTokens = 6
+	share.Scope = dataprovider.ShareScopeWrite
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	share, err = dataprovider.ShareExists(objectID, user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, share.UsedTokens)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	share.MaxTokens = 0
+	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	assert.NoError(t, err)
+
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "permission denied")
+
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("filename", "file1.txt")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte("file content"))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+	reader = bytes.NewReader(body.Bytes())
+
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "No files uploaded!")
+
+	user.Filters.WebClient = []string{sdk.WebClientSharesDisabled}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	user.Filters.WebClient = []string{sdk.WebClientShareNoPasswordDisabled}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	share.Password = ""
+	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "sharing without a password was disabled")
+
+	user.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	share.Scope = dataprovider.ShareScopeRead
+	share.Paths = []string{"/missing1", "/missing2"}
+	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	assert.NoError(t, err)
+
+	defer func() {
+		rcv := recover()
+		assert.Equal(t, http.ErrAbortHandler, rcv)
+
+		share, err = dataprovider.ShareExists(objectID, user.Username)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, share.UsedTokens)
+
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}()
+
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	executeRequest(req)
+}
+
+func TestShareMaxExpiration(t *testing.T) {
+	u := getTestUser()
+	u.Filters.MaxSharesExpiration = 5
+	u.Filters.DefaultSharesExpiration = 10
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "must be less than or equal to max shares expiration")
+
+	u.Filters.DefaultSharesExpiration = 0
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	webClientToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	s := dataprovider.Share{
+		Name:      "test share",
+		Scope:     dataprovider.ShareScopeRead,
+		Password:  defaultPassword,
+		Paths:     []string{"/"},
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(u.Filters.MaxSharesExpiration+2))),
+	}
+	asJSON, err := json.Marshal(s)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "share must expire before")
+
+	req, err = http.NewRequest(http.MethodPut, path.Join(userSharesPath, "shareID"), bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// expiresAt is mandatory
+	s.ExpiresAt = 0
+	asJSON, err = json.Marshal(s)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "share must expire before")
+
+	s.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Hour))
+	asJSON, err = json.Marshal(s)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	shareID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, shareID)
+
+	s.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(u.Filters.MaxSharesExpiration+2)))
+	asJSON, err = json.Marshal(s)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, path.Join(userSharesPath, shareID), bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "share must expire before")
+
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientSharePath, webClientToken)
+	assert.NoError(t, err)
+	form := make(url.Values)
+	form.Set("name", s.Name)
+	form.Set("scope", strconv.Itoa(int(s.Scope)))
+	form.Set("max_tokens", "0")
+	form.Set("paths[0][path]", "/")
+	form.Set("expiration_date", time.Now().Add(24*time.Hour*time.Duration(u.Filters.MaxSharesExpiration+2)).Format("2006-01-02 15:04:05"))
+	form.Set(csrfFormToken, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, webClientSharePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareExpirationOutOfRange)
+
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientSharePath, shareID), bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareExpirationOutOfRange)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, webClientSharePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorGetUser)
+}
+
+func TestWebClientShareCredentials(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	shareRead := dataprovider.Share{
+		Name:     "test share read",
+		Scope:    dataprovider.ShareScopeRead,
+		Password: defaultPassword,
+		Paths:    []string{"/"},
+	}
+
+	shareWrite := dataprovider.Share{
+		Name:     "test share write",
+		Scope:    dataprovider.ShareScopeReadWrite,
+		Password: defaultPassword,
+		Paths:    []string{"/"},
+	}
+
+	asJSON, err := json.Marshal(shareRead)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	shareReadID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, shareReadID)
+
+	asJSON, err = json.Marshal(shareWrite)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	shareWriteID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, shareWriteID)
+
+	uri := path.Join(webClientPubSharesPath, shareReadID, "browse")
+	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	location := rr.Header().Get("Location")
+	assert.Contains(t, location, url.QueryEscape(uri))
+	// get the login form
+	req, err = http.NewRequest(http.MethodGet, location, nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// now set the user token, it is not valid for the share
+	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// get a share token
+	form := make(url.Values)
+	form.Set("share_password", defaultPassword)
+	loginURI := path.Join(webClientPubSharesPath, shareReadID, "login")
+	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCSRF)
+	// set the CSRF token
+	loginCookie, csrfToken, err := getCSRFTokenMock(loginURI, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form.Set(csrfFormToken, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nShareLoginOK)
+	cookie := rr.Header().Get("Set-Cookie")
+	cookie = strings.TrimPrefix(cookie, "jwt=")
+	assert.NotEmpty(t, cookie)
+	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// get the download page
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "download?a=b"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// get the download page for a missing share
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, "invalidshareid", "download"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// the same cookie will not work for the other share
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareWriteID, "browse"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// IP address does not match
+	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	req.RemoteAddr = "1.2.3.4:1234"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// logout to a different share, the cookie is not valid.
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareWriteID, "logout"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidToken)
+	// logout
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "logout"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// the cookie is no longer valid
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "download?b=c"), nil)
+	assert.NoError(t, err)
+	req.RequestURI = uri
+	setJWTCookieForReq(req, cookie)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Contains(t, rr.Header().Get("Location"), "/login")
+
+	// try to login with invalid credentials
+	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form.Set(csrfFormToken, csrfToken)
+	form.Set("share_password", "")
+	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCredentials)
+	// login with the next param set
+	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form.Set(csrfFormToken, csrfToken)
+	form.Set("share_password", defaultPassword)
+	nextURI := path.Join(webClientPubSharesPath, shareReadID, "browse")
+	loginURI = path.Join(webClientPubSharesPath, shareReadID, fmt.Sprintf("login?next=%s", url.QueryEscape(nextURI)))
+	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Equal(t, nextURI, rr.Header().Get("Location"))
+	// try to login to a missing share
+	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	assert.NoError(t, err)
+	form.Set(csrfFormToken, csrfToken)
+	loginURI = path.Join(webClientPubSharesPath, "missing", "login")
+	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	setLoginCookie(req, loginCookie)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCredentials)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
 }
 
-func TestShareUsage(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-
-	testFileName := "testfile.dat"
-	testFileSize := int64(65536)
-	testFilePath := filepath.Join(user.GetHomeDir(), testFileName)
-	err = createTestFile(testFilePath, testFileSize)
+func TestShareMaxSessions(t *testing.T) {
+	u := getTestUser()
+	u.MaxSessions = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-
 	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
 	share := dataprovider.Share{
-		Name:      "test share",
-		Scope:     dataprovider.ShareScopeRead,
-		Paths:     []string{"/"},
-		Password:  defaultPassword,
-		MaxTokens: 2,
-		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Second)),
+		Name:  "test share max sessions read",
+		Scope: dataprovider.ShareScopeRead,
+		Paths: []string{"/"},
 	}
 	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
@@ -13956,79 +17718,96 @@ func TestShareUsage(t *testing.T) {
 	objectID := rr.Header().Get("X-Object-ID")
 	assert.NotEmpty(t, objectID)
 
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/unknownid", nil)
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/dirs", nil) //nolint:goconst
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusOK, rr)
+	// add a fake connection
+	fs := vfs.NewOsFs("id", os.TempDir(), "", nil)
+	connection := &httpd.Connection{
+		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolHTTP, "", "", user),
+	}
+	err = common.Connections.Add(connection)
+	assert.NoError(t, err)
 
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/dirs", nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	req.SetBasicAuth(defaultUsername, "wrong password")
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"/dirs", nil)
+	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=file.pdf"), nil)
+	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-
-	time.Sleep(2 * time.Second)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
 
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"/browse", nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
 
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"_mod", nil)
+	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/browse/exist", nil)
 	assert.NoError(t, err)
-	req.RequestURI = webClientPubSharesPath + "/" + objectID + "_mod"
-	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "invalid share scope")
 
-	share.ExpiresAt = 0
-	jsonReq := make(map[string]any)
-	jsonReq["name"] = share.Name
-	jsonReq["scope"] = share.Scope
-	jsonReq["paths"] = share.Paths
-	jsonReq["password"] = share.Password
-	jsonReq["max_tokens"] = share.MaxTokens
-	jsonReq["expires_at"] = share.ExpiresAt
-	asJSON, err = json.Marshal(jsonReq)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/files?path=afile", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID, nil)
+	form := make(url.Values)
+	form.Set("files", `[]`)
+	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/partial",
+		bytes.NewBuffer([]byte(form.Encode())))
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
 
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, nil)
+	req, err = http.NewRequest(http.MethodDelete, userSharesPath+"/"+objectID, nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "invalid share scope")
+	checkResponseCode(t, http.StatusOK, rr)
 
-	share.MaxTokens = 3
-	share.Scope = dataprovider.ShareScopeWrite
+	// now test a write share
+	share = dataprovider.Share{
+		Name:  "test share max sessions write",
+		Scope: dataprovider.ShareScopeWrite,
+		Paths: []string{"/"},
+	}
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file.txt"), bytes.NewBuffer([]byte("content")))
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
@@ -14036,466 +17815,549 @@ func TestShareUsage(t *testing.T) {
 	assert.NoError(t, err)
 	_, err = part1.Write([]byte("file1 content"))
 	assert.NoError(t, err)
-	part2, err := writer.CreateFormFile("filenames", "file2.txt")
-	assert.NoError(t, err)
-	_, err = part2.Write([]byte("file2 content"))
-	assert.NoError(t, err)
 	err = writer.Close()
 	assert.NoError(t, err)
 	reader := bytes.NewReader(body.Bytes())
-
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
-	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Unable to parse multipart form")
-
-	_, err = reader.Seek(0, io.SeekStart)
-	assert.NoError(t, err)
-	// set the proper content type
 	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
 	assert.NoError(t, err)
 	req.Header.Add("Content-Type", writer.FormDataContentType())
-	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Allowed usage exceeded")
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	share.MaxTokens = 6
-	share.Scope = dataprovider.ShareScopeWrite
+	share = dataprovider.Share{
+		Name:  "test share max sessions read/write",
+		Scope: dataprovider.ShareScopeReadWrite,
+		Paths: []string{"/"},
+	}
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userSharesPath+"/"+objectID, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
 
-	_, err = reader.Seek(0, io.SeekStart)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/browse/exist", nil)
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
+	checkResponseCode(t, http.StatusTooManyRequests, rr)
+	assert.Contains(t, rr.Body.String(), "too many open sessions")
 
-	_, err = reader.Seek(0, io.SeekStart)
+	common.Connections.Remove(connection.GetID())
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID, reader)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
+	assert.Len(t, common.Connections.GetStats(""), 0)
+}
 
-	share, err = dataprovider.ShareExists(objectID, user.Username)
+func TestShareUploadSingle(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	assert.Equal(t, 6, share.UsedTokens)
 
-	_, err = reader.Seek(0, io.SeekStart)
+	share := dataprovider.Share{
+		Name:      "test share",
+		Scope:     dataprovider.ShareScopeWrite,
+		Paths:     []string{"/"},
+		Password:  defaultPassword,
+		MaxTokens: 0,
+	}
+	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+
+	content := []byte("shared file content")
+	modTime := time.Now().Add(-12 * time.Hour)
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file.txt"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.SetBasicAuth(defaultUsername, defaultPassword)
+	req.Header.Set("X-SFTPGO-MTIME", strconv.FormatInt(util.GetTimeAsMsSinceEpoch(modTime), 10))
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	share.MaxTokens = 0
-	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	checkResponseCode(t, http.StatusCreated, rr)
+	info, err := os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
+	if assert.NoError(t, err) {
+		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(modTime), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(1000))
+	}
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
 	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
 
-	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "file.txt"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	info, err = os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
+	if assert.NoError(t, err) {
+		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(3000))
+	}
 
-	_, err = reader.Seek(0, io.SeekStart)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir", "file.dat"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "permission denied")
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	user.Permissions["/"] = []string{dataprovider.PermAny}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "%2F"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "operation unsupported")
 
-	body = new(bytes.Buffer)
-	writer = multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("filename", "file1.txt")
-	assert.NoError(t, err)
-	_, err = part.Write([]byte("file content"))
-	assert.NoError(t, err)
-	err = writer.Close()
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
 	assert.NoError(t, err)
-	reader = bytes.NewReader(body.Bytes())
-
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "No files uploaded!")
-
-	user.Filters.WebClient = []string{sdk.WebClientSharesDisabled}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
-	assert.NoError(t, err)
+	assert.Contains(t, rr.Body.String(), "operation unsupported")
 
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	// only uploads to the share root dir are allowed
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir", "file.dat"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusNotFound, rr)
 
-	user.Filters.WebClient = []string{sdk.WebClientShareNoPasswordDisabled}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	share, err = dataprovider.ShareExists(objectID, user.Username)
 	assert.NoError(t, err)
-	share.Password = ""
-	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	assert.Equal(t, 2, share.UsedTokens)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
 
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file1.txt"), bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "sharing without a password was disabled")
+	checkResponseCode(t, http.StatusNotFound, rr)
+}
 
-	user.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+func TestShareFileRequest(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
-	share.Scope = dataprovider.ShareScopeRead
-	share.Paths = []string{"/missing1", "/missing2"}
-	err = dataprovider.UpdateShare(&share, user.Username, "", "")
+	share := dataprovider.Share{
+		Name:  "file request",
+		Scope: dataprovider.ShareScopeFileRequest,
+		Paths: []string{"/inbox"},
+		Options: dataprovider.ShareOptions{
+			Instructions:    "upload your documents",
+			MaxUploaderSize: -1,
+		},
+	}
+	asJSON, err := json.Marshal(share)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	defer func() {
-		rcv := recover()
-		assert.Equal(t, http.ErrAbortHandler, rcv)
+	share.Options.MaxUploaderSize = 20
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
 
-		share, err = dataprovider.ShareExists(objectID, user.Username)
-		assert.NoError(t, err)
-		assert.Equal(t, 6, share.UsedTokens)
+	req, err = http.NewRequest(http.MethodGet, userSharesPath+"/"+objectID, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var shareGet dataprovider.Share
+	err = json.Unmarshal(rr.Body.Bytes(), &shareGet)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ShareScopeFileRequest, shareGet.Scope)
+	assert.Equal(t, share.Options.Instructions, shareGet.Options.Instructions)
+	assert.Equal(t, share.Options.MaxUploaderSize, shareGet.Options.MaxUploaderSize)
 
-		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "inbox"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "inbox", "existing.txt"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+
+	getUploadRequest := func(fields map[string]string, content []byte) *http.Request {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		for k, v := range fields {
+			err := writer.WriteField(k, v)
+			assert.NoError(t, err)
+		}
+		part, err := writer.CreateFormFile("filenames", "file.txt")
 		assert.NoError(t, err)
-		err = os.RemoveAll(user.GetHomeDir())
+		_, err = part.Write(content)
 		assert.NoError(t, err)
-	}()
+		err = writer.Close()
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, bytes.NewReader(body.Bytes()))
+		assert.NoError(t, err)
+		req.Header.Add("Content-Type", writer.FormDataContentType())
+		return req
+	}
 
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	rr = executeRequest(getUploadRequest(nil, []byte("content")))
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	rr = executeRequest(getUploadRequest(map[string]string{
+		"uploader_name":  "John",
+		"uploader_email": "invalid email",
+	}, []byte("content")))
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	fields := map[string]string{
+		"uploader_name":  "John",
+		"uploader_email": "John@Example.com",
+		"uploader_notes": "my notes",
+	}
+	rr = executeRequest(getUploadRequest(fields, []byte("content")))
+	checkResponseCode(t, http.StatusCreated, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "john@example.com", "file.txt"))
+	// the uploaded files plus the existing ones exceed the per uploader limit
+	rr = executeRequest(getUploadRequest(fields, []byte("more than 20 bytes content")))
+	checkResponseCode(t, http.StatusRequestEntityTooLarge, rr)
+	// another uploader has its own limit
+	fields["uploader_email"] = "jane@example.com"
+	rr = executeRequest(getUploadRequest(fields, []byte("content")))
+	checkResponseCode(t, http.StatusCreated, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "inbox", "jane@example.com", "file.txt"))
+	// existing contents cannot be listed or downloaded
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files")+"?path=existing.txt", nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	executeRequest(req)
-}
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// single file uploads are not allowed, the uploader details are required
+	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file.txt"), bytes.NewBuffer([]byte("content")))
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
 
-func TestShareMaxExpiration(t *testing.T) {
-	u := getTestUser()
-	u.Filters.MaxSharesExpiration = 5
-	u.Filters.DefaultSharesExpiration = 10
-	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
 	assert.NoError(t, err)
-	assert.Contains(t, string(resp), "must be less than or equal to max shares expiration")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), share.Options.Instructions)
+	assert.Contains(t, rr.Body.String(), "uploader_email")
 
-	u.Filters.DefaultSharesExpiration = 0
-	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	share, err = dataprovider.ShareExists(objectID, user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, share.UsedTokens)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+}
 
+func TestShareDownloadStats(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
 	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	webClientToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("content"), os.ModePerm)
 	assert.NoError(t, err)
 
-	s := dataprovider.Share{
-		Name:      "test share",
-		Scope:     dataprovider.ShareScopeRead,
-		Password:  defaultPassword,
-		Paths:     []string{"/"},
-		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(u.Filters.MaxSharesExpiration+2))),
+	share := dataprovider.Share{
+		Name:  "test share stats",
+		Scope: dataprovider.ShareScopeRead,
+		Paths: []string{"/"},
+		Options: dataprovider.ShareOptions{
+			MaxFileDownloads: -1,
+		},
 	}
-	asJSON, err := json.Marshal(s)
+	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
 	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "share must expire before")
 
-	req, err = http.NewRequest(http.MethodPut, path.Join(userSharesPath, "shareID"), bytes.NewBuffer(asJSON))
-	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	// expiresAt is mandatory
-	s.ExpiresAt = 0
-	asJSON, err = json.Marshal(s)
+	share.Options.MaxFileDownloads = 2
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
 	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "share must expire before")
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
 
-	s.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Hour))
-	asJSON, err = json.Marshal(s)
+	for i := 0; i < 2; i++ {
+		req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files")+"?path=file.txt", nil)
+		assert.NoError(t, err)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		assert.Equal(t, "content", rr.Body.String())
+	}
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files")+"?path=file.txt", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// the whole share includes the file that cannot be downloaded anymore
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID), nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	shareID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, shareID)
-
-	s.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour * time.Duration(u.Filters.MaxSharesExpiration+2)))
-	asJSON, err = json.Marshal(s)
+	checkResponseCode(t, http.StatusOK, rr)
+	// updating the share must preserve the statistics
+	share.Description = "updated"
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, path.Join(userSharesPath, shareID), bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPut, path.Join(userSharesPath, objectID), bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "share must expire before")
+	checkResponseCode(t, http.StatusOK, rr)
 
-	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientSharePath, webClientToken)
-	assert.NoError(t, err)
-	form := make(url.Values)
-	form.Set("name", s.Name)
-	form.Set("scope", strconv.Itoa(int(s.Scope)))
-	form.Set("max_tokens", "0")
-	form.Set("paths[0][path]", "/")
-	form.Set("expiration_date", time.Now().Add(24*time.Hour*time.Duration(u.Filters.MaxSharesExpiration+2)).Format("2006-01-02 15:04:05"))
-	form.Set(csrfFormToken, csrfToken)
-	req, err = http.NewRequest(http.MethodPost, webClientSharePath, bytes.NewBuffer([]byte(form.Encode())))
+	req, err = http.NewRequest(http.MethodGet, path.Join(userSharesPath, objectID, "stats"), nil)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	setJWTCookieForReq(req, webClientToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareExpirationOutOfRange)
+	var stats map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &stats)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), stats["used_tokens"])
+	assert.Equal(t, float64(-1), stats["remaining_tokens"])
+	assert.Equal(t, float64(3), stats["downloads"])
+	assert.Greater(t, stats["last_access_at"], float64(0))
+	files, ok := stats["files"].([]any)
+	if assert.True(t, ok) && assert.Len(t, files, 2) {
+		fileStats := files[0].(map[string]any)
+		assert.Equal(t, "/", fileStats["path"])
+		assert.Equal(t, float64(1), fileStats["downloads"])
+		assert.Equal(t, float64(1), fileStats["remaining_downloads"])
+		fileStats = files[1].(map[string]any)
+		assert.Equal(t, "/file.txt", fileStats["path"])
+		assert.Equal(t, float64(2), fileStats["downloads"])
+		assert.Equal(t, float64(0), fileStats["remaining_downloads"])
+	}
 
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientSharePath, shareID), bytes.NewBuffer([]byte(form.Encode())))
+	share, err = dataprovider.ShareExists(objectID, user.Username)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	setJWTCookieForReq(req, webClientToken)
+	assert.Equal(t, 3, share.Stats.Downloads)
+	assert.Len(t, share.Stats.Files, 2)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(userSharesPath, "missing", "stats"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareExpirationOutOfRange)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodPost, webClientSharePath, bytes.NewBuffer([]byte(form.Encode())))
-	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	setJWTCookieForReq(req, webClientToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorGetUser)
 }
 
-func TestWebClientShareCredentials(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestUserTrash(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.Filters.Trash = dataprovider.TrashPolicy{
+		Enabled:       true,
+		RetentionDays: -1,
+	}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Trash.RetentionDays = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
 	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
 
-	shareRead := dataprovider.Share{
-		Name:     "test share read",
-		Scope:    dataprovider.ShareScopeRead,
-		Password: defaultPassword,
-		Paths:    []string{"/"},
-	}
-
-	shareWrite := dataprovider.Share{
-		Name:     "test share write",
-		Scope:    dataprovider.ShareScopeReadWrite,
-		Password: defaultPassword,
-		Paths:    []string{"/"},
-	}
-
-	asJSON, err := json.Marshal(shareRead)
+	content := []byte("trash content")
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
 	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	for _, name := range []string{"file.txt", "dir/file.txt"} {
+		req, err := http.NewRequest(http.MethodPost, userUploadFilePath+"?path="+url.QueryEscape(name),
+			bytes.NewBuffer(content))
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, rr)
+	}
+	req, err := http.NewRequest(http.MethodDelete, userFilesPath+"?path=file.txt", nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	shareReadID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, shareReadID)
-
-	asJSON, err = json.Marshal(shareWrite)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodDelete, userDirsPath+"?path=dir", nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	shareWriteID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, shareWriteID)
-
-	uri := path.Join(webClientPubSharesPath, shareReadID, "browse")
-	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+	assert.NoDirExists(t, filepath.Join(user.GetHomeDir(), "dir"))
+	// the files in the trash are still included in the quota
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	location := rr.Header().Get("Location")
-	assert.Contains(t, location, url.QueryEscape(uri))
-	// get the login form
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(2*len(content)), user.UsedQuotaSize)
+	// the trash is hidden
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path=%2F", nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	// now set the user token, it is not valid for the share
-	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	assert.NotContains(t, rr.Body.String(), dataprovider.TrashDirName)
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(dataprovider.GetTrashPath()), nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, token)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	// get a share token
-	form := make(url.Values)
-	form.Set("share_password", defaultPassword)
-	loginURI := path.Join(webClientPubSharesPath, shareReadID, "login")
-	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, userTrashPath, nil)
 	assert.NoError(t, err)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCSRF)
-	// set the CSRF token
-	loginCookie, csrfToken, err := getCSRFTokenMock(loginURI, defaultRemoteAddr)
+	var entries []common.TrashEntry
+	err = json.Unmarshal(rr.Body.Bytes(), &entries)
 	assert.NoError(t, err)
-	form.Set(csrfFormToken, csrfToken)
-	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "/dir/file.txt", entries[0].Path)
+		assert.Equal(t, "/file.txt", entries[1].Path)
+		assert.Equal(t, int64(len(content)), entries[1].Size)
+		assert.Greater(t, entries[1].DeletedAt, int64(0))
+	}
+	// restoring over an existing file is not allowed
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("new"), os.ModePerm)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	setLoginCookie(req, loginCookie)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nShareLoginOK)
-	cookie := rr.Header().Get("Set-Cookie")
-	cookie = strings.TrimPrefix(cookie, "jwt=")
-	assert.NotEmpty(t, cookie)
-	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userTrashPath, entries[1].ID, "restore"), nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	// get the download page
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "download?a=b"), nil)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	err = os.Remove(filepath.Join(user.GetHomeDir(), "file.txt"))
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userTrashPath, entries[1].ID, "restore"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	// get the download page for a missing share
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, "invalidshareid", "download"), nil)
+	restored, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "file.txt"))
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	assert.Equal(t, content, restored)
+	// the entry is not in the trash anymore
+	req, err = http.NewRequest(http.MethodPost, path.Join(userTrashPath, entries[1].ID, "restore"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusNotFound, rr)
-	// the same cookie will not work for the other share
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareWriteID, "browse"), nil)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userTrashPath, "invalid"), nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	// IP address does not match
-	req, err = http.NewRequest(http.MethodGet, uri, nil)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// restoring a file inside a deleted directory recreates the directory
+	req, err = http.NewRequest(http.MethodPost, webClientTrashPath+"/"+entries[0].ID+"/restore", nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
-	req.RemoteAddr = "1.2.3.4:1234"
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	// logout to a different share, the cookie is not valid.
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareWriteID, "logout"), nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	req.Header.Set("X-CSRF-TOKEN", csrfToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidToken)
-	// logout
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "logout"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "dir", "file.txt"))
+	// permanently delete a file
+	req, err = http.NewRequest(http.MethodDelete, userFilesPath+"?path=file.txt", nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	// the cookie is no longer valid
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, shareReadID, "download?b=c"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodGet, webClientTrashPath+jsonAPISuffix, nil)
 	assert.NoError(t, err)
-	req.RequestURI = uri
-	setJWTCookieForReq(req, cookie)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	assert.Contains(t, rr.Header().Get("Location"), "/login")
-
-	// try to login with invalid credentials
-	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	checkResponseCode(t, http.StatusOK, rr)
+	entries = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &entries)
 	assert.NoError(t, err)
-	form.Set(csrfFormToken, csrfToken)
-	form.Set("share_password", "")
-	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	if assert.Len(t, entries, 1) {
+		req, err = http.NewRequest(http.MethodDelete, path.Join(userTrashPath, entries[0].ID), nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+	}
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	setLoginCookie(req, loginCookie)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, 1, user.UsedQuotaFiles)
+	assert.Equal(t, int64(len(content)), user.UsedQuotaSize)
+	trashEntries, err := os.ReadDir(filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName))
+	assert.NoError(t, err)
+	assert.Len(t, trashEntries, 0)
+	// files older than the retention are purged
+	req, err = http.NewRequest(http.MethodDelete, userFilesPath+"?path="+url.QueryEscape("dir/file.txt"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCredentials)
-	// login with the next param set
-	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	conn := common.NewBaseConnection(xid.New().String(), common.ProtocolDataRetention, "", "", user)
+	removed, err := conn.PurgeTrash(time.Now().Add(-1 * time.Hour))
 	assert.NoError(t, err)
-	form.Set(csrfFormToken, csrfToken)
-	form.Set("share_password", defaultPassword)
-	nextURI := path.Join(webClientPubSharesPath, shareReadID, "browse")
-	loginURI = path.Join(webClientPubSharesPath, shareReadID, fmt.Sprintf("login?next=%s", url.QueryEscape(nextURI)))
-	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	assert.Equal(t, 0, removed)
+	removed, err = conn.PurgeTrash(time.Now().Add(1 * time.Hour))
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	setLoginCookie(req, loginCookie)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, 1, removed)
+
+	req, err = http.NewRequest(http.MethodGet, webClientTrashPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	assert.Equal(t, nextURI, rr.Header().Get("Location"))
-	// try to login to a missing share
-	loginCookie, csrfToken, err = getCSRFTokenMock(webLoginPath, defaultRemoteAddr)
+	checkResponseCode(t, http.StatusOK, rr)
+	// disable the trash
+	user.Filters.Trash = dataprovider.TrashPolicy{}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	form.Set(csrfFormToken, csrfToken)
-	loginURI = path.Join(webClientPubSharesPath, "missing", "login")
-	req, err = http.NewRequest(http.MethodPost, loginURI, bytes.NewBuffer([]byte(form.Encode())))
+	req, err = http.NewRequest(http.MethodGet, userTrashPath, nil)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	setLoginCookie(req, loginCookie)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorInvalidCredentials)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodGet, webClientTrashPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -14503,259 +18365,547 @@ func TestWebClientShareCredentials(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestShareMaxSessions(t *testing.T) {
+func TestUserFileVersioning(t *testing.T) {
 	u := getTestUser()
-	u.MaxSessions = 1
+	u.QuotaFiles = 100
+	u.Filters.FileVersioning = []dataprovider.VersioningRule{
+		{
+			Path: "/",
+		},
+	}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.FileVersioning[0].Path = dataprovider.GetVersionsPath()
+	u.Filters.FileVersioning[0].MaxVersions = 2
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.FileVersioning[0].Path = "/"
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
 	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
 
-	share := dataprovider.Share{
-		Name:  "test share max sessions read",
-		Scope: dataprovider.ShareScopeRead,
-		Paths: []string{"/"},
+	uploadContent := func(content string) {
+		req, err := http.NewRequest(http.MethodPost, userUploadFilePath+"?path=file.txt", bytes.NewBufferString(content))
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, rr)
 	}
-	asJSON, err := json.Marshal(share)
+	getVersions := func() []common.FileVersion {
+		req, err := http.NewRequest(http.MethodGet, userFileVersionsPath+"?path=file.txt", nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		var versions []common.FileVersion
+		err = json.Unmarshal(rr.Body.Bytes(), &versions)
+		assert.NoError(t, err)
+		return versions
+	}
+	checkContent := func(expected string) {
+		content, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "file.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	uploadContent("v1")
+	assert.Len(t, getVersions(), 0)
+	uploadContent("v22")
+	uploadContent("v333")
+	uploadContent("v4444")
+	// only the last two versions are kept
+	versions := getVersions()
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, "/file.txt", versions[0].Path)
+		assert.Equal(t, int64(4), versions[0].Size)
+		assert.Equal(t, int64(3), versions[1].Size)
+		assert.Greater(t, versions[0].CreatedAt, int64(0))
+	}
+	checkContent("v4444")
+	// the versions are included in the user quota
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.Equal(t, 3, user.UsedQuotaFiles)
+	assert.Equal(t, int64(12), user.UsedQuotaSize)
+	// the versions directory is hidden
+	req, err := http.NewRequest(http.MethodGet, userDirsPath+"?path=%2F", nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/dirs", nil) //nolint:goconst
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotContains(t, rr.Body.String(), dataprovider.VersionsDirName)
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(dataprovider.GetVersionsPath()), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// restore the oldest version, the current file is stored as a new version
+	req, err = http.NewRequest(http.MethodPost, path.Join(userFileVersionsPath, versions[1].ID, "restore")+"?path=file.txt", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	// add a fake connection
-	fs := vfs.NewOsFs("id", os.TempDir(), "", nil)
-	connection := &httpd.Connection{
-		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolHTTP, "", "", user),
+	checkContent("v22")
+	versions = getVersions()
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, int64(5), versions[0].Size)
+		assert.Equal(t, int64(4), versions[1].Size)
 	}
-	err = common.Connections.Add(connection)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/dirs", nil)
+	assert.Equal(t, 3, user.UsedQuotaFiles)
+	assert.Equal(t, int64(12), user.UsedQuotaSize)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userFileVersionsPath, "invalid", "restore")+"?path=file.txt", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
-
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"/dirs", nil)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// delete a version
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userFileVersionsPath, versions[1].ID)+"?path=file.txt", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=file.pdf"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(userFileVersionsPath, versions[1].ID)+"?path=file.txt", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
-
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"/browse", nil)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(8), user.UsedQuotaSize)
+	// deleted files are stored as versions
+	req, err = http.NewRequest(http.MethodDelete, userFilesPath+"?path=file.txt", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
-
-	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/browse/exist", nil)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+	versions = getVersions()
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, int64(3), versions[0].Size)
+	}
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "invalid share scope")
-
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"/files?path=afile", nil)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(8), user.UsedQuotaSize)
+	// restore the deleted file from the WebClient
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
 	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
-
-	form := make(url.Values)
-	form.Set("files", `[]`)
-	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/partial",
-		bytes.NewBuffer([]byte(form.Encode())))
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "modal_versions")
+	req, err = http.NewRequest(http.MethodGet, webClientFileVersionsPath+"?path=file.txt", nil)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nError429Message)
-
-	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID, nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodPost, webClientFileVersionsPath+"/"+versions[0].ID+"/restore?path=file.txt", nil)
 	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
-
-	req, err = http.NewRequest(http.MethodDelete, userSharesPath+"/"+objectID, nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	req.Header.Set("X-CSRF-TOKEN", csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-
-	// now test a write share
-	share = dataprovider.Share{
-		Name:  "test share max sessions write",
-		Scope: dataprovider.ShareScopeWrite,
-		Paths: []string{"/"},
+	checkContent("v22")
+	assert.Len(t, getVersions(), 1)
+	// reduce the max versions and prune
+	uploadContent("v55555")
+	assert.Len(t, getVersions(), 2)
+	user.Filters.FileVersioning[0].MaxVersions = 1
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn := common.NewBaseConnection(xid.New().String(), common.ProtocolDataRetention, "", "", user)
+	removed, err := conn.PruneFileVersions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	versions = getVersions()
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, int64(3), versions[0].Size)
 	}
-	asJSON, err = json.Marshal(share)
+	// disable the versioning
+	user.Filters.FileVersioning = nil
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, userFileVersionsPath+"?path=file.txt", nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file.txt"), bytes.NewBuffer([]byte("content")))
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
 
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part1, err := writer.CreateFormFile("filenames", "file1.txt")
+func TestUserRestoreArchivedFile(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/nodownload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	_, err = part1.Write([]byte("file1 content"))
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	err = writer.Close()
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	reader := bytes.NewReader(body.Bytes())
-	req, err = http.NewRequest(http.MethodPost, sharesPath+"/"+objectID, reader)
+
+	req, err := http.NewRequest(http.MethodPost, userUploadFilePath+"?path=file.txt", bytes.NewBufferString("content"))
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	// the local filesystem has no archive tiers
+	req, err = http.NewRequest(http.MethodPost, userFileActionsPath+"/restore-archived?path=file.txt", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
-
-	share = dataprovider.Share{
-		Name:  "test share max sessions read/write",
-		Scope: dataprovider.ShareScopeReadWrite,
-		Paths: []string{"/"},
-	}
-	asJSON, err = json.Marshal(share)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, userFileActionsPath+"/restore-archived?path=missing.txt", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, userFileActionsPath+"/restore-archived?path=nodownload/file.txt", nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-
-	req, err = http.NewRequest(http.MethodPost, webClientPubSharesPath+"/"+objectID+"/browse/exist", nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, userJobsPath+"/missing", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusTooManyRequests, rr)
-	assert.Contains(t, rr.Body.String(), "too many open sessions")
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// jobs started by other users or by admins are not visible
+	job := common.Jobs.Start(common.JobTypeArchiveRestore, defaultTokenAuthUser, "", false,
+		func(_ context.Context, _ func(int)) (any, error) {
+			return nil, nil
+		})
+	req, err = http.NewRequest(http.MethodGet, userJobsPath+"/"+job.ID, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	job = common.Jobs.StartForUser(common.JobTypeArchiveRestore, defaultUsername, "", false,
+		func(_ context.Context, _ func(int)) (any, error) {
+			return nil, nil
+		})
+	req, err = http.NewRequest(http.MethodGet, userJobsPath+"/"+job.ID, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// WebClient
+	req, err = http.NewRequest(http.MethodPost, webClientFileActionsPath+"/restore-archived?path=file.txt", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
+	assert.NoError(t, err)
+	req.Header.Set("X-CSRF-TOKEN", csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorArchiveRestoreUnsupported)
 
-	common.Connections.Remove(connection.GetID())
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	assert.Len(t, common.Connections.GetStats(""), 0)
 }
 
-func TestShareUploadSingle(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestUserSearchIndex(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/private"] = []string{dataprovider.PermUpload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
 	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
 
-	share := dataprovider.Share{
-		Name:      "test share",
-		Scope:     dataprovider.ShareScopeWrite,
-		Paths:     []string{"/"},
-		Password:  defaultPassword,
-		MaxTokens: 0,
+	search := func(query string, expectedStatusCode int) []common.SearchResult {
+		req, err := http.NewRequest(http.MethodGet, userSearchPath+"?"+query, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, expectedStatusCode, rr)
+		var results []common.SearchResult
+		if expectedStatusCode == http.StatusOK {
+			err = json.Unmarshal(rr.Body.Bytes(), &results)
+			assert.NoError(t, err)
+		}
+		return results
 	}
-	asJSON, err := json.Marshal(share)
-	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	getPaths := func(results []common.SearchResult) []string {
+		var paths []string
+		for _, res := range results {
+			paths = append(paths, res.Path)
+		}
+		return paths
+	}
+	// the search index is disabled
+	search("name=report", http.StatusBadRequest)
+
+	oldConfig := config.GetCommonConfig()
+	indexPath := filepath.Join(os.TempDir(), "search_index_test.db")
+	cfg := config.GetCommonConfig()
+	cfg.SearchIndex.Enabled = true
+	cfg.SearchIndex.Path = indexPath
+	err = common.Initialize(cfg, 0)
+	require.NoError(t, err)
+	defer func() {
+		err := common.Initialize(oldConfig, 0)
+		assert.NoError(t, err)
+		err = os.Remove(indexPath)
+		assert.NoError(t, err)
+	}()
+
+	for name, size := range map[string]int{
+		"docs/report.PDF":    10,
+		"docs/notes.txt":     20,
+		"sub/dir/photo.jpg":  30,
+		"private/secret.pdf": 40,
+	} {
+		fsPath := filepath.Join(user.GetHomeDir(), filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fsPath), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(fsPath, make([]byte, size), 0666)
+		assert.NoError(t, err)
+	}
+	// the index is built in background on the first search
+	search("name=report", http.StatusServiceUnavailable)
+	assert.Eventually(t, func() bool {
+		return len(search("name=report", http.StatusOK)) == 1
+	}, 2*time.Second, 100*time.Millisecond)
+
+	results := search("name=REPORT", http.StatusOK)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "/docs/report.PDF", results[0].Path)
+		assert.Equal(t, "report.PDF", results[0].Name)
+		assert.Equal(t, int64(10), results[0].Size)
+		assert.Greater(t, results[0].LastModified, int64(0))
+		assert.False(t, results[0].IsDir)
+	}
+	// the files inside directories that cannot be listed are not returned
+	assert.Equal(t, []string{"/docs/report.PDF"}, getPaths(search("ext=pdf", http.StatusOK)))
+	assert.Equal(t, []string{"/docs/report.PDF"}, getPaths(search("name=*.p?f", http.StatusOK)))
+	assert.Equal(t, []string{"/docs/notes.txt", "/sub/dir/photo.jpg"}, getPaths(search("min_size=15", http.StatusOK)))
+	assert.Equal(t, []string{"/docs/notes.txt"}, getPaths(search("min_size=15&max_size=25", http.StatusOK)))
+	assert.Equal(t, []string{"/sub/dir/photo.jpg"}, getPaths(search("path=%2Fsub&type=file", http.StatusOK)))
+	assert.Equal(t, []string{"/docs", "/private", "/sub", "/sub/dir"}, getPaths(search("type=dir", http.StatusOK)))
+	assert.Len(t, search("limit=2", http.StatusOK), 2)
+	futureTime := util.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour))
+	assert.Len(t, search(fmt.Sprintf("modified_after=%d", futureTime), http.StatusOK), 0)
+	assert.Len(t, search(fmt.Sprintf("modified_before=%d&type=file", futureTime), http.StatusOK), 3)
+	// invalid queries
+	search("min_size=a", http.StatusBadRequest)
+	search("type=invalid", http.StatusBadRequest)
+	search("min_size=20&max_size=10", http.StatusBadRequest)
+	search("name=%5B", http.StatusBadRequest)
+	// the index is updated from the filesystem events
+	req, err := http.NewRequest(http.MethodPost, userUploadFilePath+"?path="+url.QueryEscape("/docs/new.pdf"),
+		bytes.NewBuffer(make([]byte, 50)))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusCreated, rr)
-	objectID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-
-	content := []byte("shared file content")
-	modTime := time.Now().Add(-12 * time.Hour)
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file.txt"), bytes.NewBuffer(content))
+	assert.Eventually(t, func() bool {
+		return len(search("ext=pdf", http.StatusOK)) == 2
+	}, 2*time.Second, 100*time.Millisecond)
+	req, err = http.NewRequest(http.MethodPost, userFileActionsPath+"/move?path=%2Fdocs&target=%2Fdocuments", nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	req.Header.Set("X-SFTPGO-MTIME", strconv.FormatInt(util.GetTimeAsMsSinceEpoch(modTime), 10))
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	info, err := os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
-	if assert.NoError(t, err) {
-		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(modTime), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(1000))
-	}
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Eventually(t, func() bool {
+		paths := getPaths(search("ext=pdf", http.StatusOK))
+		return len(paths) == 2 && paths[0] == "/documents/new.pdf" && paths[1] == "/documents/report.PDF"
+	}, 2*time.Second, 100*time.Millisecond)
+	req, err = http.NewRequest(http.MethodDelete, userFilesPath+"?path="+url.QueryEscape("/documents/new.pdf"), nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "file.txt"), bytes.NewBuffer(content))
+	assert.Eventually(t, func() bool {
+		return len(search("ext=pdf", http.StatusOK)) == 1
+	}, 2*time.Second, 100*time.Millisecond)
+	// WebClient
+	req, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	info, err = os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
-	if assert.NoError(t, err) {
-		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(3000))
-	}
-
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir", "file.dat"), bytes.NewBuffer(content))
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), webClientSearchPath)
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath+"?path=%2Fdocuments", nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "%2F"), bytes.NewBuffer(content))
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath+"/json?name=notes", nil)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "/documents/notes.txt")
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath+"/json?max_size=a", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "operation unsupported")
+	assert.Contains(t, rr.Body.String(), util.I18nErrorSearchInvalidQuery)
 
-	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "dir"), os.ModePerm)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir"), bytes.NewBuffer(content))
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "operation unsupported")
+}
 
-	// only uploads to the share root dir are allowed
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "dir", "file.dat"), bytes.NewBuffer(content))
+func TestUserSearchContent(t *testing.T) {
+	u := getTestUser()
+	u.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.log"},
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
 
-	share, err = dataprovider.ShareExists(objectID, user.Username)
+	search := func(query string, expectedStatusCode int) []common.SearchResult {
+		req, err := http.NewRequest(http.MethodGet, userSearchPath+"?"+query, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, expectedStatusCode, rr)
+		var results []common.SearchResult
+		if expectedStatusCode == http.StatusOK {
+			err = json.Unmarshal(rr.Body.Bytes(), &results)
+			assert.NoError(t, err)
+		}
+		return results
+	}
+	upload := func(name, content string) {
+		req, err := http.NewRequest(http.MethodPost, userUploadFilePath+"?path="+url.QueryEscape(name),
+			bytes.NewBufferString(content))
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, rr)
+	}
+
+	oldConfig := config.GetCommonConfig()
+	indexPath := filepath.Join(os.TempDir(), "search_content_test.db")
+	cfg := config.GetCommonConfig()
+	cfg.SearchIndex.Enabled = true
+	cfg.SearchIndex.Path = indexPath
+	err = common.Initialize(cfg, 0)
+	require.NoError(t, err)
+	// content indexing is disabled
+	search("content=text", http.StatusBadRequest)
+	err = common.Initialize(oldConfig, 0)
+	require.NoError(t, err)
+	err = os.Remove(indexPath)
+	require.NoError(t, err)
+
+	cfg.SearchIndex.Content.Enabled = true
+	err = common.Initialize(cfg, 0)
+	require.NoError(t, err)
+	defer func() {
+		err := common.Initialize(oldConfig, 0)
+		assert.NoError(t, err)
+		err = os.Remove(indexPath)
+		assert.NoError(t, err)
+	}()
+
+	fsPath := filepath.Join(user.GetHomeDir(), "existing.md")
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(fsPath, []byte("Meeting minutes, the budget was approved"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "hidden.log"), []byte("budget approved"), 0666)
+	assert.NoError(t, err)
+	// existing files are indexed while building the index
+	search("content=budget", http.StatusServiceUnavailable)
+	assert.Eventually(t, func() bool {
+		return len(search("content=budget", http.StatusOK)) == 1
+	}, 2*time.Second, 100*time.Millisecond)
+	results := search("content=BUDGET+approved", http.StatusOK)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "/existing.md", results[0].Path)
+		assert.Equal(t, "Meeting minutes, the budget was approved", results[0].Snippet)
+	}
+	assert.Len(t, search("content=budget+rejected", http.StatusOK), 0)
+	assert.Len(t, search("content=budget&path=%2Fsub", http.StatusOK), 0)
+	// uploaded files are indexed asynchronously
+	upload("/sub/notes.txt", "The budget for the next year")
+	upload("/sub/image.png", "budget")
+	assert.Eventually(t, func() bool {
+		return len(search("content=budget", http.StatusOK)) == 2
+	}, 2*time.Second, 100*time.Millisecond)
+	// the contents are moved and removed with the files
+	req, err := http.NewRequest(http.MethodPost, userFileActionsPath+"/move?path=%2Fsub&target=%2Fdocs", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Eventually(t, func() bool {
+		results := search("content=next+year", http.StatusOK)
+		return len(results) == 1 && results[0].Path == "/docs/notes.txt"
+	}, 2*time.Second, 100*time.Millisecond)
+	req, err = http.NewRequest(http.MethodDelete, userFilesPath+"?path="+url.QueryEscape("/existing.md"), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Eventually(t, func() bool {
+		return len(search("content=budget", http.StatusOK)) == 1
+	}, 2*time.Second, 100*time.Millisecond)
+	// WebClient
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), `name="content"`)
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath+"/json?content=year", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "The budget for the next year")
+	// the contents of files that cannot be downloaded are not searchable
+	user.Permissions["/docs"] = []string{dataprovider.PermListItems}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	assert.Equal(t, 2, share.UsedTokens)
+	assert.Len(t, search("content=next+year", http.StatusOK), 0)
+	results = search("name=notes", http.StatusOK)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "/docs/notes.txt", results[0].Path)
+		assert.Empty(t, results[0].Snippet)
+	}
+	req, err = http.NewRequest(http.MethodGet, webClientSearchPath+"/json?content=year", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotContains(t, rr.Body.String(), "The budget for the next year")
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodPost, path.Join(sharesPath, objectID, "file1.txt"), bytes.NewBuffer(content))
-	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
 func TestShareReadWrite(t *testing.T) {
@@ -14960,6 +19110,19 @@ func TestShareUncompressed(t *testing.T) {
 	checkResponseCode(t, http.StatusOK, rr)
 	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
 
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"?compression=store", nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+
+	req, err = http.NewRequest(http.MethodGet, sharesPath+"/"+objectID+"?compression=bzip2", nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
 	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"?compress=false", nil) //nolint:goconst
 	assert.NoError(t, err)
 	req.SetBasicAuth(defaultUsername, defaultPassword)
@@ -15130,490 +19293,824 @@ func TestBrowseShares(t *testing.T) {
 	objectID := rr.Header().Get("X-Object-ID")
 	assert.NotEmpty(t, objectID)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "invalid share scope")
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=%2F"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Please set the path to a valid file")
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contents := make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contents = make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"+subDir), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contents = make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F.."), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F.."), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F.."), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=%2F..%2F"+testFileName), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+testFileName), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contentDisposition := rr.Header().Get("Content-Disposition")
+	assert.NotEmpty(t, contentDisposition)
+
+	form := make(url.Values)
+	form.Set("files", `[]`)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial?path=%2F.."),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil) //nolint:goconst
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contentDisposition = rr.Header().Get("Content-Disposition")
+	assert.NotEmpty(t, contentDisposition)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+subDir+"%2F"+testFileName), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contentDisposition = rr.Header().Get("Content-Disposition")
+	assert.NotEmpty(t, contentDisposition)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=missing"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=missing"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=missing"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=missing"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+testFileNameLink), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileNameLink), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "non regular files are not supported for shares")
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path="+testFileName), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=missing"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=%2F"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=%2F.."), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
+
+	fakePDF := []byte(`%PDF-1.6`)
+	for i := 0; i < 128; i++ {
+		fakePDF = append(fakePDF, []byte(fmt.Sprintf("%d", i))...)
+	}
+	pdfPath := filepath.Join(user.GetHomeDir(), shareDir, "test.pdf")
+	pdfLinkPath := filepath.Join(user.GetHomeDir(), shareDir, "link.pdf")
+	err = os.WriteFile(pdfPath, fakePDF, 0666)
+	assert.NoError(t, err)
+	err = os.Symlink(pdfPath, pdfLinkPath)
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "viewpdf?path=test.pdf"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=test.pdf"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	s, err := dataprovider.ShareExists(objectID, defaultUsername)
+	assert.NoError(t, err)
+	usedTokens := s.UsedTokens
+	assert.Greater(t, usedTokens, 0)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=link.pdf"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// downloading a symlink will fail, usage should not change
+	s, err = dataprovider.ShareExists(objectID, defaultUsername)
+	assert.NoError(t, err)
+	assert.Equal(t, usedTokens, s.UsedTokens)
+
+	// share a symlink
+	share = dataprovider.Share{
+		Name:      "test share browse",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{path.Join(shareDir, testFileNameLink)},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	// uncompressed download should not work
+	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"?compress=false", nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	// this share is not browsable, it does not contains a directory
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	form = make(url.Values)
+	form.Set("files", `[]`)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial"),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path="+testFileName), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "invalid share scope")
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "the shared object is not a directory and so it is not browsable")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=%2F"), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Please set the path to a valid file")
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
+	// now test a missing shareID
+	objectID = "123456"
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	contents := make([]map[string]any, 0)
-	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil)
 	assert.NoError(t, err)
-	assert.Len(t, contents, 2)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	contents = make([]map[string]any, 0)
-	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
 	assert.NoError(t, err)
-	assert.Len(t, contents, 2)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"+subDir), nil)
+	form = make(url.Values)
+	form.Set("files", `[]`)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial?path=%2F"),
+		bytes.NewBuffer([]byte(form.Encode())))
 	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	contents = make([]map[string]any, 0)
-	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "viewpdf?path=p"), nil)
 	assert.NoError(t, err)
-	assert.Len(t, contents, 1)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F.."), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=p"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// share a missing base path
+	share = dataprovider.Share{
+		Name:      "test share",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{path.Join(shareDir, "missingdir")},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F.."), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F.."), nil)
+	assert.Contains(t, rr.Body.String(), "unable to check the share directory")
+	// share multiple paths
+	share = dataprovider.Share{
+		Name:      "test share",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{shareDir, "/anotherdir"},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowsePaths)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=%2F..%2F"+testFileName), nil)
+	share = dataprovider.Share{
+		Name:      "test share rw",
+		Scope:     dataprovider.ShareScopeReadWrite,
+		Paths:     []string{"/missingdir"},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "/browse/exist?path=%2F"), nil)
 	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "unable to check the share directory")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+testFileName), nil)
+	share = dataprovider.Share{
+		Name:      "test share rw",
+		Scope:     dataprovider.ShareScopeReadWrite,
+		Paths:     []string{shareDir},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	contentDisposition := rr.Header().Get("Content-Disposition")
-	assert.NotEmpty(t, contentDisposition)
-
-	form := make(url.Values)
-	form.Set("files", `[]`)
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial?path=%2F.."),
-		bytes.NewBuffer([]byte(form.Encode())))
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "/browse/exist?path=%2F.."), nil)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil) //nolint:goconst
+	assert.Contains(t, rr.Body.String(), "Invalid path")
+	// share the root path
+	share = dataprovider.Share{
+		Name:      "test share root",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{"/"},
+		MaxTokens: 0,
+	}
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	contentDisposition = rr.Header().Get("Content-Disposition")
-	assert.NotEmpty(t, contentDisposition)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+subDir+"%2F"+testFileName), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	contentDisposition = rr.Header().Get("Content-Disposition")
-	assert.NotEmpty(t, contentDisposition)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=missing"), nil)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path=missing"), nil)
+	contents = make([]map[string]any, 0)
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	// if we require two-factor auth for HTTP protocol the share should not work anymore
+	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=missing"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH, common.ProtocolHTTP}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=missing"), nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "two-factor authentication requirements not met")
+	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	// share read/write
+	share.Scope = dataprovider.ShareScopeReadWrite
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path="+testFileNameLink), nil)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID = rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
 	assert.NoError(t, err)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileNameLink), nil)
+	// on upload we should be redirected
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
 	assert.NoError(t, err)
+	req.SetBasicAuth(defaultUsername, defaultPassword)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "non regular files are not supported for shares")
+	checkResponseCode(t, http.StatusFound, rr)
+	location := rr.Header().Get("Location")
+	assert.Equal(t, path.Join(webClientPubSharesPath, objectID, "browse"), location)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path="+testFileName), nil)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=missing"), nil)
+func TestUserAPIShareErrors(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=%2F"), nil)
+	share := dataprovider.Share{
+		Scope: 1000,
+	}
+	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=%2F.."), nil)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "invalid scope")
+	// invalid json
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer([]byte("{")))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPathInvalid)
 
-	fakePDF := []byte(`%PDF-1.6`)
-	for i := 0; i < 128; i++ {
-		fakePDF = append(fakePDF, []byte(fmt.Sprintf("%d", i))...)
-	}
-	pdfPath := filepath.Join(user.GetHomeDir(), shareDir, "test.pdf")
-	pdfLinkPath := filepath.Join(user.GetHomeDir(), shareDir, "link.pdf")
-	err = os.WriteFile(pdfPath, fakePDF, 0666)
-	assert.NoError(t, err)
-	err = os.Symlink(pdfPath, pdfLinkPath)
+	share.Scope = dataprovider.ShareScopeWrite
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "viewpdf?path=test.pdf"), nil)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "at least a shared path is required")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=test.pdf"), nil)
+	share.Paths = []string{"path1", "../path1", "/path2"}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "the write share scope requires exactly one path")
 
-	s, err := dataprovider.ShareExists(objectID, defaultUsername)
+	share.Paths = []string{"", ""}
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	usedTokens := s.UsedTokens
-	assert.Greater(t, usedTokens, 0)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=link.pdf"), nil)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	// downloading a symlink will fail, usage should not change
-	s, err = dataprovider.ShareExists(objectID, defaultUsername)
-	assert.NoError(t, err)
-	assert.Equal(t, usedTokens, s.UsedTokens)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "at least a shared path is required")
 
-	// share a symlink
-	share = dataprovider.Share{
-		Name:      "test share browse",
-		Scope:     dataprovider.ShareScopeRead,
-		Paths:     []string{path.Join(shareDir, testFileNameLink)},
-		MaxTokens: 0,
-	}
+	share.Paths = []string{"path1", "../path1", "/path1"}
+	share.Password = redactedSecret
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
 	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	// uncompressed download should not work
-	req, err = http.NewRequest(http.MethodGet, webClientPubSharesPath+"/"+objectID+"?compress=false", nil)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "cannot save a share with a redacted password")
+
+	share.Password = "newpass"
+	share.AllowFrom = []string{"not valid"}
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
-	// this share is not browsable, it does not contains a directory
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "could not parse allow from entry")
 
-	form = make(url.Values)
-	form.Set("files", `[]`)
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial"),
-		bytes.NewBuffer([]byte(form.Encode())))
+	share.AllowFrom = []string{"127.0.0.1/8"}
+	share.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-12 * time.Hour))
+	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
+	assert.Contains(t, rr.Body.String(), "expiration must be in the future")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path="+testFileName), nil)
+	share.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(12 * time.Hour))
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil)
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
+	assert.Contains(t, rr.Body.String(), "name is mandatory")
+	// invalid json
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer([]byte("}")))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "the shared object is not a directory and so it is not browsable")
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	req, err = http.NewRequest(http.MethodGet, userSharesPath+"?limit=a", nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowseNoDir)
 
-	// now test a missing shareID
-	objectID = "123456"
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "files?path="+testFileName), nil)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+}
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs?path=%2F"), nil)
+func TestUserAPIShares(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
 
-	form = make(url.Values)
-	form.Set("files", `[]`)
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "partial?path=%2F"),
-		bytes.NewBuffer([]byte(form.Encode())))
+	u := getTestUser()
+	u.Username = altAdminUsername
+	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	req.RemoteAddr = defaultRemoteAddr
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "viewpdf?path=p"), nil)
+	token1, err := getJWTAPIUserTokenFromTestServer(user1.Username, defaultPassword)
 	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "getpdf?path=p"), nil)
-	assert.NoError(t, err)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	// share a missing base path
-	share = dataprovider.Share{
-		Name:      "test share",
-		Scope:     dataprovider.ShareScopeRead,
-		Paths:     []string{path.Join(shareDir, "missingdir")},
-		MaxTokens: 0,
+	// the share username will be set from the claims
+	share := dataprovider.Share{
+		Name:        "share1",
+		Description: "description1",
+		Scope:       dataprovider.ShareScopeRead,
+		Paths:       []string{"/"},
+		CreatedAt:   1,
+		UpdatedAt:   2,
+		LastUseAt:   3,
+		ExpiresAt:   util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Hour)),
+		Password:    defaultPassword,
+		MaxTokens:   10,
+		UsedTokens:  2,
+		AllowFrom:   []string{"192.168.1.0/24"},
 	}
-	asJSON, err = json.Marshal(share)
+	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
-	rr = executeRequest(req)
+	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
+	location := rr.Header().Get("Location")
+	assert.NotEmpty(t, location)
+	objectID := rr.Header().Get("X-Object-ID")
 	assert.NotEmpty(t, objectID)
+	assert.Equal(t, fmt.Sprintf("%v/%v", userSharesPath, objectID), location)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs"), nil)
+	req, err = http.NewRequest(http.MethodGet, location, nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "unable to check the share directory")
-	// share multiple paths
-	share = dataprovider.Share{
-		Name:      "test share",
-		Scope:     dataprovider.ShareScopeRead,
-		Paths:     []string{shareDir, "/anotherdir"},
-		MaxTokens: 0,
-	}
-	asJSON, err = json.Marshal(share)
+	checkResponseCode(t, http.StatusOK, rr)
+	var shareGet dataprovider.Share
+	err = json.Unmarshal(rr.Body.Bytes(), &shareGet)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.Equal(t, objectID, shareGet.ShareID)
+	assert.Equal(t, share.Name, shareGet.Name)
+	assert.Equal(t, share.Description, shareGet.Description)
+	assert.Equal(t, share.Scope, shareGet.Scope)
+	assert.Equal(t, share.Paths, shareGet.Paths)
+	assert.Equal(t, int64(0), shareGet.LastUseAt)
+	assert.Greater(t, shareGet.CreatedAt, share.CreatedAt)
+	assert.Greater(t, shareGet.UpdatedAt, share.UpdatedAt)
+	assert.Equal(t, share.ExpiresAt, shareGet.ExpiresAt)
+	assert.Equal(t, share.MaxTokens, shareGet.MaxTokens)
+	assert.Equal(t, 0, shareGet.UsedTokens)
+	assert.Equal(t, share.Paths, shareGet.Paths)
+	assert.Equal(t, redactedSecret, shareGet.Password)
+
+	req, err = http.NewRequest(http.MethodGet, location, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setBearerForReq(req, token1)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	s, err := dataprovider.ShareExists(objectID, defaultUsername)
+	assert.NoError(t, err)
+	match, err := s.CheckCredentials(defaultPassword)
+	assert.True(t, match)
+	assert.NoError(t, err)
+	match, err = s.CheckCredentials(defaultPassword + "mod")
+	assert.False(t, match)
+	assert.Error(t, err)
+
+	shareGet.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(3 * time.Hour))
+	asJSON, err = json.Marshal(shareGet)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorShareBrowsePaths)
+	checkResponseCode(t, http.StatusOK, rr)
 
-	share = dataprovider.Share{
-		Name:      "test share rw",
-		Scope:     dataprovider.ShareScopeReadWrite,
-		Paths:     []string{"/missingdir"},
-		MaxTokens: 0,
-	}
-	asJSON, err = json.Marshal(share)
+	s, err = dataprovider.ShareExists(objectID, defaultUsername)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	match, err = s.CheckCredentials(defaultPassword)
+	assert.True(t, match)
+	assert.NoError(t, err)
+	match, err = s.CheckCredentials(defaultPassword + "mod")
+	assert.False(t, match)
+	assert.Error(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, location, nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "/browse/exist?path=%2F"), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	var shareGetNew dataprovider.Share
+	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "unable to check the share directory")
+	assert.NotEqual(t, shareGet.UpdatedAt, shareGetNew.UpdatedAt)
+	shareGet.UpdatedAt = shareGetNew.UpdatedAt
+	assert.Equal(t, shareGet, shareGetNew)
 
-	share = dataprovider.Share{
-		Name:      "test share rw",
-		Scope:     dataprovider.ShareScopeReadWrite,
-		Paths:     []string{shareDir},
-		MaxTokens: 0,
-	}
-	asJSON, err = json.Marshal(share)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	req, err = http.NewRequest(http.MethodPost, path.Join(webClientPubSharesPath, objectID, "/browse/exist?path=%2F.."), nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	var shares []dataprovider.Share
+	err = json.Unmarshal(rr.Body.Bytes(), &shares)
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Invalid path")
-	// share the root path
-	share = dataprovider.Share{
-		Name:      "test share root",
-		Scope:     dataprovider.ShareScopeRead,
-		Paths:     []string{"/"},
-		MaxTokens: 0,
+	if assert.Len(t, shares, 1) {
+		assert.Equal(t, shareGetNew, shares[0])
 	}
-	asJSON, err = json.Marshal(share)
+
+	err = dataprovider.UpdateShareLastUse(&shareGetNew, 2)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, location, nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
+	checkResponseCode(t, http.StatusOK, rr)
+	shareGetNew = dataprovider.Share{}
+	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, shareGetNew.UsedTokens, "share: %v", shareGetNew)
+	assert.Greater(t, shareGetNew.LastUseAt, int64(0), "share: %v", shareGetNew)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token1)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
+	shares = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &shares)
+	assert.NoError(t, err)
+	assert.Len(t, shares, 0)
 
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
+	// set an empty password
+	shareGet.Password = ""
+	asJSON, err = json.Marshal(shareGet)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	contents = make([]map[string]any, 0)
-	err = json.Unmarshal(rr.Body.Bytes(), &contents)
-	assert.NoError(t, err)
-	assert.Len(t, contents, 1)
-	// if we require two-factor auth for HTTP protocol the share should not work anymore
-	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH}
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
+
+	req, err = http.NewRequest(http.MethodGet, location, nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH, common.ProtocolHTTP}
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	shareGetNew = dataprovider.Share{}
+	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "dirs?path=%2F"), nil)
+	assert.Empty(t, shareGetNew.Password)
+
+	req, err = http.NewRequest(http.MethodDelete, location, nil)
 	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "two-factor authentication requirements not met")
-	user.Filters.TwoFactorAuthProtocols = []string{common.ProtocolSSH}
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
-	assert.NoError(t, err)
-	// share read/write
-	share.Scope = dataprovider.ShareScopeReadWrite
+	checkResponseCode(t, http.StatusOK, rr)
+
+	share.Name = ""
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
+
 	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusCreated, rr)
-	objectID = rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "browse?path=%2F"), nil)
+	location = rr.Header().Get("Location")
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+	// the share should be deleted with the associated user
+	req, err = http.NewRequest(http.MethodGet, location, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	// on upload we should be redirected
-	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "upload"), nil)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	req.SetBasicAuth(defaultUsername, defaultPassword)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	location := rr.Header().Get("Location")
-	assert.Equal(t, path.Join(webClientPubSharesPath, objectID, "browse"), location)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	req, err = http.NewRequest(http.MethodDelete, location, nil)
 	assert.NoError(t, err)
-	err = os.RemoveAll(user.GetHomeDir())
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
 	assert.NoError(t, err)
 }
 
-func TestUserAPIShareErrors(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestUsersAPISharesNoPasswordDisabled(t *testing.T) {
+	u := getTestUser()
+	u.Filters.WebClient = []string{sdk.WebClientShareNoPasswordDisabled}
+	u.Filters.PasswordStrength = 70
+	u.Password = "ahpoo8baa6EeZieshies"
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, u.Password)
 	assert.NoError(t, err)
 
 	share := dataprovider.Share{
-		Scope: 1000,
+		Name:  "s",
+		Scope: dataprovider.ShareScopeRead,
+		Paths: []string{"/"},
 	}
 	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
-
 	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "invalid scope")
-	// invalid json
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer([]byte("{")))
-	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "You are not authorized to share files/folders without a password")
 
-	share.Scope = dataprovider.ShareScopeWrite
+	share.Password = defaultPassword
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
 	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
@@ -15621,614 +20118,690 @@ func TestUserAPIShareErrors(t *testing.T) {
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "at least a shared path is required")
 
-	share.Paths = []string{"path1", "../path1", "/path2"}
+	share.Password = "vi5eiJoovee5ya9yahpi"
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
 	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "the write share scope requires exactly one path")
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
+	assert.NotEmpty(t, location)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	assert.Equal(t, fmt.Sprintf("%v/%v", userSharesPath, objectID), location)
 
-	share.Paths = []string{"", ""}
+	share.Password = ""
 	asJSON, err = json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "at least a shared path is required")
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "You are not authorized to share files/folders without a password")
 
-	share.Paths = []string{"path1", "../path1", "/path1"}
-	share.Password = redactedSecret
-	asJSON, err = json.Marshal(share)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUserAPIKey(t *testing.T) {
+	u := getTestUser()
+	u.Filters.AllowAPIKeyAuth = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	apiKey := dataprovider.APIKey{
+		Name:  "testkey",
+		User:  user.Username + "1",
+		Scope: dataprovider.APIKeyScopeUser,
+	}
+	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
+	assert.NoError(t, err)
+	apiKey.User = user.Username
+	apiKey, _, err = httpdtest.AddAPIKey(apiKey, http.StatusCreated)
+	assert.NoError(t, err)
+
+	adminAPIKey := dataprovider.APIKey{
+		Name:  "testadminkey",
+		Scope: dataprovider.APIKeyScopeAdmin,
+	}
+	adminAPIKey, _, err = httpdtest.AddAPIKey(adminAPIKey, http.StatusCreated)
+	assert.NoError(t, err)
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("filenames", "filenametest")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte("test file content"))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+	reader := bytes.NewReader(body.Bytes())
+	_, err = reader.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userFilesPath, reader)
+	assert.NoError(t, err)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	setAPIKeyForReq(req, apiKey.Key, "")
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKey.Key, "")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var dirEntries []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &dirEntries)
+	assert.NoError(t, err)
+	assert.Len(t, dirEntries, 1)
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, adminAPIKey.Key, user.Username)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	user.Status = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKey.Key, "")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "cannot save a share with a redacted password")
+	checkResponseCode(t, http.StatusUnauthorized, rr)
 
-	share.Password = "newpass"
-	share.AllowFrom = []string{"not valid"}
-	asJSON, err = json.Marshal(share)
+	user.Status = 1
+	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKey.Key, "")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "could not parse allow from entry")
+	checkResponseCode(t, http.StatusUnauthorized, rr)
 
-	share.AllowFrom = []string{"127.0.0.1/8"}
-	share.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-12 * time.Hour))
-	asJSON, err = json.Marshal(share)
+	user.Filters.DeniedProtocols = []string{common.ProtocolFTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKey.Key, "")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "expiration must be in the future")
+	checkResponseCode(t, http.StatusOK, rr)
+	apiKeyNew := dataprovider.APIKey{
+		Name:  apiKey.Name,
+		Scope: dataprovider.APIKeyScopeUser,
+	}
 
-	share.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(12 * time.Hour))
-	asJSON, err = json.Marshal(share)
+	apiKeyNew, _, err = httpdtest.AddAPIKey(apiKeyNew, http.StatusCreated)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKeyNew.Key, "")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	location := rr.Header().Get("Location")
-
-	asJSON, err = json.Marshal(share)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	// now associate a user
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	setAPIKeyForReq(req, apiKeyNew.Key, user.Username)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	// now with a missing user
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKeyNew.Key, user.Username+"1")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "name is mandatory")
-	// invalid json
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer([]byte("}")))
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	// empty user and key not associated to any user
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setAPIKeyForReq(req, apiKeyNew.Key, "")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodGet, userSharesPath+"?limit=a", nil)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	apiKeyNew.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-24 * time.Hour))
+	_, _, err = httpdtest.UpdateAPIKey(apiKeyNew, http.StatusOK)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	// expired API key
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	assert.NoError(t, err)
+	setAPIKeyForReq(req, apiKeyNew.Key, user.Username)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-}
 
-func TestUserAPIShares(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	_, err = httpdtest.RemoveAPIKey(apiKeyNew, http.StatusOK)
 	assert.NoError(t, err)
 
-	u := getTestUser()
-	u.Username = altAdminUsername
-	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	_, err = httpdtest.RemoveAPIKey(adminAPIKey, http.StatusOK)
 	assert.NoError(t, err)
-	token1, err := getJWTAPIUserTokenFromTestServer(user1.Username, defaultPassword)
+}
+
+func TestWebClientExistenceCheck(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
 
-	// the share username will be set from the claims
-	share := dataprovider.Share{
-		Name:        "share1",
-		Description: "description1",
-		Scope:       dataprovider.ShareScopeRead,
-		Paths:       []string{"/"},
-		CreatedAt:   1,
-		UpdatedAt:   2,
-		LastUseAt:   3,
-		ExpiresAt:   util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Hour)),
-		Password:    defaultPassword,
-		MaxTokens:   10,
-		UsedTokens:  2,
-		AllowFrom:   []string{"192.168.1.0/24"},
-	}
-	asJSON, err := json.Marshal(share)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
 	assert.NoError(t, err)
 
-	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err := http.NewRequest(http.MethodPost, webClientExistPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	location := rr.Header().Get("Location")
-	assert.NotEmpty(t, location)
-	objectID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	assert.Equal(t, fmt.Sprintf("%v/%v", userSharesPath, objectID), location)
+	checkResponseCode(t, http.StatusForbidden, rr) // no CSRF header
 
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer([]byte(`[]`)))
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	var shareGet dataprovider.Share
-	err = json.Unmarshal(rr.Body.Bytes(), &shareGet)
-	assert.NoError(t, err)
-	assert.Equal(t, objectID, shareGet.ShareID)
-	assert.Equal(t, share.Name, shareGet.Name)
-	assert.Equal(t, share.Description, shareGet.Description)
-	assert.Equal(t, share.Scope, shareGet.Scope)
-	assert.Equal(t, share.Paths, shareGet.Paths)
-	assert.Equal(t, int64(0), shareGet.LastUseAt)
-	assert.Greater(t, shareGet.CreatedAt, share.CreatedAt)
-	assert.Greater(t, shareGet.UpdatedAt, share.UpdatedAt)
-	assert.Equal(t, share.ExpiresAt, shareGet.ExpiresAt)
-	assert.Equal(t, share.MaxTokens, shareGet.MaxTokens)
-	assert.Equal(t, 0, shareGet.UsedTokens)
-	assert.Equal(t, share.Paths, shareGet.Paths)
-	assert.Equal(t, redactedSecret, shareGet.Password)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	filesToCheck := make(map[string]any)
+	filesToCheck["files"] = nil
+	asJSON, err := json.Marshal(filesToCheck)
 	assert.NoError(t, err)
-	setBearerForReq(req, token1)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "files to be checked are mandatory")
 
-	s, err := dataprovider.ShareExists(objectID, defaultUsername)
+	testFileName := "file.dat"
+	testDirName := "adirname"
+	filesToCheck["files"] = []string{testFileName}
+	asJSON, err = json.Marshal(filesToCheck)
 	assert.NoError(t, err)
-	match, err := s.CheckCredentials(defaultPassword)
-	assert.True(t, match)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2Fmissingdir", bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	match, err = s.CheckCredentials(defaultPassword + "mod")
-	assert.False(t, match)
-	assert.Error(t, err)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	shareGet.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(3 * time.Hour))
-	asJSON, err = json.Marshal(shareGet)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-
-	s, err = dataprovider.ShareExists(objectID, defaultUsername)
-	assert.NoError(t, err)
-	match, err = s.CheckCredentials(defaultPassword)
-	assert.True(t, match)
+	var fileList []any
+	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
 	assert.NoError(t, err)
-	match, err = s.CheckCredentials(defaultPassword + "mod")
-	assert.False(t, match)
-	assert.Error(t, err)
+	assert.Len(t, fileList, 0)
 
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), testFileName), 100)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	var shareGetNew dataprovider.Share
-	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
+	err = os.Mkdir(filepath.Join(user.GetHomeDir(), testDirName), 0755)
 	assert.NoError(t, err)
-	assert.NotEqual(t, shareGet.UpdatedAt, shareGetNew.UpdatedAt)
-	shareGet.UpdatedAt = shareGetNew.UpdatedAt
-	assert.Equal(t, shareGet, shareGetNew)
 
-	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	var shares []dataprovider.Share
-	err = json.Unmarshal(rr.Body.Bytes(), &shares)
+	fileList = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
 	assert.NoError(t, err)
-	if assert.Len(t, shares, 1) {
-		assert.Equal(t, shareGetNew, shares[0])
-	}
+	assert.Len(t, fileList, 1)
 
-	err = dataprovider.UpdateShareLastUse(&shareGetNew, 2)
+	filesToCheck["files"] = []string{testFileName, testDirName}
+	asJSON, err = json.Marshal(filesToCheck)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	shareGetNew = dataprovider.Share{}
-	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
+	fileList = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
 	assert.NoError(t, err)
-	assert.Equal(t, 2, shareGetNew.UsedTokens, "share: %v", shareGetNew)
-	assert.Greater(t, shareGetNew.LastUseAt, int64(0), "share: %v", shareGetNew)
+	assert.Len(t, fileList, 2)
 
-	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F"+testDirName, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setBearerForReq(req, token1)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	shares = nil
-	err = json.Unmarshal(rr.Body.Bytes(), &shares)
+	fileList = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
 	assert.NoError(t, err)
-	assert.Len(t, shares, 0)
+	assert.Len(t, fileList, 0)
 
-	// set an empty password
-	shareGet.Password = ""
-	asJSON, err = json.Marshal(shareGet)
+	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
+	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
+	checkResponseCode(t, http.StatusForbidden, rr)
 
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	shareGetNew = dataprovider.Share{}
-	err = json.Unmarshal(rr.Body.Bytes(), &shareGetNew)
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	assert.Empty(t, shareGetNew.Password)
+}
 
-	req, err = http.NewRequest(http.MethodDelete, location, nil)
+func TestWebClientViewPDF(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
 
-	share.Name = ""
-	asJSON, err = json.Marshal(share)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err := http.NewRequest(http.MethodGet, webClientViewPDFPath, nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	location = rr.Header().Get("Location")
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	_, err = httpdtest.RemoveUser(user, http.StatusOK)
-	assert.NoError(t, err)
-	err = os.RemoveAll(user.GetHomeDir())
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath, nil)
 	assert.NoError(t, err)
-	// the share should be deleted with the associated user
-	req, err = http.NewRequest(http.MethodGet, location, nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, err = http.NewRequest(http.MethodGet, webClientViewPDFPath+"?path=test.pdf", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusOK, rr)
 
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=test.pdf", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
 
-	req, err = http.NewRequest(http.MethodDelete, location, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2F", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
 
-	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "test.pdf"), []byte("some text data"), 0666)
 	assert.NoError(t, err)
-	err = os.RemoveAll(user1.GetHomeDir())
+
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil) //nolint:goconst
 	assert.NoError(t, err)
-}
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
 
-func TestUsersAPISharesNoPasswordDisabled(t *testing.T) {
-	u := getTestUser()
-	u.Filters.WebClient = []string{sdk.WebClientShareNoPasswordDisabled}
-	u.Filters.PasswordStrength = 70
-	u.Password = "ahpoo8baa6EeZieshies"
-	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "test.pdf"), 1024)
 	assert.NoError(t, err)
-	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, u.Password)
+
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
 	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
 
-	share := dataprovider.Share{
-		Name:  "s",
-		Scope: dataprovider.ShareScopeRead,
-		Paths: []string{"/"},
+	fakePDF := []byte(`%PDF-1.6`)
+	for i := 0; i < 128; i++ {
+		fakePDF = append(fakePDF, []byte(fmt.Sprintf("%d", i))...)
 	}
-	asJSON, err := json.Marshal(share)
-	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "test.pdf"), fakePDF, 0666)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "You are not authorized to share files/folders without a password")
 
-	share.Password = defaultPassword
-	asJSON, err = json.Marshal(share)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
+	checkResponseCode(t, http.StatusOK, rr)
 
-	share.Password = "vi5eiJoovee5ya9yahpi"
-	asJSON, err = json.Marshal(share)
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.pdf"},
+		},
+	}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
-	location := rr.Header().Get("Location")
-	assert.NotEmpty(t, location)
-	objectID := rr.Header().Get("X-Object-ID")
-	assert.NotEmpty(t, objectID)
-	assert.Equal(t, fmt.Sprintf("%v/%v", userSharesPath, objectID), location)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nError403Message)
 
-	share.Password = ""
-	asJSON, err = json.Marshal(share)
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.txt"},
+		},
+	}
+	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
 	assert.NoError(t, err)
-	setBearerForReq(req, token)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "You are not authorized to share files/folders without a password")
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
-func TestUserAPIKey(t *testing.T) {
+func TestWebClientPreview(t *testing.T) {
 	u := getTestUser()
-	u.Filters.AllowAPIKeyAuth = true
+	u.DownloadDataTransfer = 1
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	apiKey := dataprovider.APIKey{
-		Name:  "testkey",
-		User:  user.Username + "1",
-		Scope: dataprovider.APIKeyScopeUser,
-	}
-	_, _, err = httpdtest.AddAPIKey(apiKey, http.StatusBadRequest)
-	assert.NoError(t, err)
-	apiKey.User = user.Username
-	apiKey, _, err = httpdtest.AddAPIKey(apiKey, http.StatusCreated)
-	assert.NoError(t, err)
 
-	adminAPIKey := dataprovider.APIKey{
-		Name:  "testadminkey",
-		Scope: dataprovider.APIKeyScopeAdmin,
-	}
-	adminAPIKey, _, err = httpdtest.AddAPIKey(adminAPIKey, http.StatusCreated)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("filenames", "filenametest")
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "adir"), os.ModePerm)
 	assert.NoError(t, err)
-	_, err = part.Write([]byte("test file content"))
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "main.go"), []byte("package main"), 0666)
 	assert.NoError(t, err)
-	err = writer.Close()
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "video.mp4"), 4096)
 	assert.NoError(t, err)
-	reader := bytes.NewReader(body.Bytes())
-	_, err = reader.Seek(0, io.SeekStart)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "file.bin"), 1024)
 	assert.NoError(t, err)
-	req, err := http.NewRequest(http.MethodPost, userFilesPath, reader)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "big.txt"), 3*1048576)
 	assert.NoError(t, err)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	setAPIKeyForReq(req, apiKey.Key, "")
+
+	req, err := http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=%2Fmain.go", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "package main")
 
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=%2Fvideo.mp4", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKey.Key, "")
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	var dirEntries []map[string]any
-	err = json.Unmarshal(rr.Body.Bytes(), &dirEntries)
-	assert.NoError(t, err)
-	assert.Len(t, dirEntries, 1)
+	assert.Contains(t, rr.Body.String(), "<video")
+	assert.Contains(t, rr.Body.String(), webClientGetPreviewPath)
 
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=adir", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, adminAPIKey.Key, user.Username)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPreviewDir)
 
-	user.Status = 0
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=file.bin", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPreviewUnsupported)
+
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=big.txt", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKey.Key, "")
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorPreviewSize)
 
-	user.Status = 1
-	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=missing.txt", nil)
 	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
 
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=%2Fvideo.mp4", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKey.Key, "")
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Range", "bytes=1024-2047")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
+	checkResponseCode(t, http.StatusPartialContent, rr)
+	assert.Equal(t, "bytes 1024-2047/4096", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "sandbox", rr.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, rr.Header().Get("Content-Disposition"))
+	assert.Len(t, rr.Body.Bytes(), 1024)
 
-	user.Filters.DeniedProtocols = []string{common.ProtocolFTP}
-	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=%2Fmain.go", nil)
 	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=adir", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKey.Key, "")
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	apiKeyNew := dataprovider.APIKey{
-		Name:  apiKey.Name,
-		Scope: dataprovider.APIKeyScopeUser,
-	}
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	apiKeyNew, _, err = httpdtest.AddAPIKey(apiKeyNew, http.StatusCreated)
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=missing.mp4", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// previews are counted against the transfer quota
+	err = dataprovider.UpdateUserTransferQuota(&user, 0, 2*1048576, true)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKeyNew.Key, "")
+
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=%2Fmain.go", nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
-	// now associate a user
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorQuotaRead)
+
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=%2Fvideo.mp4", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKeyNew.Key, user.Username)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	// now with a missing user
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	user.DownloadDataTransfer = 0
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKeyNew.Key, user.Username+"1")
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
-	// empty user and key not associated to any user
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+
+	req, err = http.NewRequest(http.MethodGet, webClientPreviewPath+"?path=%2Fvideo.mp4", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKeyNew.Key, "")
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
-	apiKeyNew.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-24 * time.Hour))
-	_, _, err = httpdtest.UpdateAPIKey(apiKeyNew, http.StatusOK)
-	assert.NoError(t, err)
-	// expired API key
-	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nError403Message)
+
+	req, err = http.NewRequest(http.MethodGet, webClientGetPreviewPath+"?path=%2Fvideo.mp4", nil)
 	assert.NoError(t, err)
-	setAPIKeyForReq(req, apiKeyNew.Key, user.Username)
+	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusUnauthorized, rr)
+	checkResponseCode(t, http.StatusForbidden, rr)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+}
 
-	_, err = httpdtest.RemoveAPIKey(apiKeyNew, http.StatusOK)
+func TestThumbnails(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
 
-	_, err = httpdtest.RemoveAPIKey(adminAPIKey, http.StatusOK)
+	img := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 300; y++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 10, B: 10, A: 255})
+		}
+	}
+	var b bytes.Buffer
+	err = png.Encode(&b, img)
 	assert.NoError(t, err)
-}
-
-func TestWebClientExistenceCheck(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "adir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "img.png"), b.Bytes(), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "bad.jpg"), []byte("not an image"), 0666)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "big.png"), 2*1048576)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "file.txt"), 100)
 	assert.NoError(t, err)
 
-	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
-	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
-	req, err := http.NewRequest(http.MethodPost, webClientExistPath, nil)
+	req, err := http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=img.png", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
+	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr) // no CSRF header
-
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer([]byte(`[]`)))
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	thumb, err := jpeg.Decode(rr.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 256, thumb.Bounds().Dx())
+		assert.Equal(t, 128, thumb.Bounds().Dy())
+	}
+	// cached thumbnail
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=img.png", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
+	setBearerForReq(req, token)
+	req.Header.Set("If-None-Match", etag)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
+	checkResponseCode(t, http.StatusNotModified, rr)
 
-	filesToCheck := make(map[string]any)
-	filesToCheck["files"] = nil
-	asJSON, err := json.Marshal(filesToCheck)
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=img.png&size=64", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+	thumb, err = jpeg.Decode(rr.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 64, thumb.Bounds().Dx())
+		assert.Equal(t, 32, thumb.Bounds().Dy())
+	}
+
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=img.png&size=100", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "files to be checked are mandatory")
 
-	testFileName := "file.dat"
-	testDirName := "adirname"
-	filesToCheck["files"] = []string{testFileName}
-	asJSON, err = json.Marshal(filesToCheck)
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=bad.jpg", nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2Fmissingdir", bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, rr)
+
+	for _, name := range []string{"big.png", "file.txt", "adir"} {
+		req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path="+name, nil)
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=missing.png", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientThumbnailsPath+"?path=img.png", nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	var fileList []any
-	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
-	assert.NoError(t, err)
-	assert.Len(t, fileList, 0)
-
-	err = createTestFile(filepath.Join(user.GetHomeDir(), testFileName), 100)
-	assert.NoError(t, err)
-	err = os.Mkdir(filepath.Join(user.GetHomeDir(), testDirName), 0755)
-	assert.NoError(t, err)
 
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, webClientDirsPath, nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	fileList = nil
-	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
+	var dirContents []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &dirContents)
 	assert.NoError(t, err)
-	assert.Len(t, fileList, 1)
+	for _, entry := range dirContents {
+		switch entry["name"] {
+		case "img.png", "bad.jpg":
+			assert.Contains(t, entry, "thumbnail_url")
+		default:
+			assert.NotContains(t, entry, "thumbnail_url")
+		}
+	}
 
-	filesToCheck["files"] = []string{testFileName, testDirName}
-	asJSON, err = json.Marshal(filesToCheck)
+	share := dataprovider.Share{
+		Name:  "thumbnails share",
+		Scope: dataprovider.ShareScopeRead,
+		Paths: []string{"/"},
+	}
+	asJSON, err := json.Marshal(share)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F", bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	fileList = nil
-	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
+	checkResponseCode(t, http.StatusCreated, rr)
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "thumbnails")+"?path=img.png", nil)
 	assert.NoError(t, err)
-	assert.Len(t, fileList, 2)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
 
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath+"?path=%2F"+testDirName, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "thumbnails")+"?path=img.png&size=128", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	fileList = nil
-	err = json.Unmarshal(rr.Body.Bytes(), &fileList)
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(webClientPubSharesPath, objectID, "dirs")+"?path=%2F", nil)
 	assert.NoError(t, err)
-	assert.Len(t, fileList, 0)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), path.Join(webClientPubSharesPath, objectID, "thumbnails"))
 
-	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID, "thumbnails")+"?path=missing.png", nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// the download permission is required
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
 	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPost, webClientExistPath, bytes.NewBuffer(asJSON))
+
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=img.png", nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	setCSRFHeaderForReq(req, csrfToken)
+	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusForbidden, rr)
 
@@ -16238,103 +20811,225 @@ func TestWebClientExistenceCheck(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestWebClientViewPDF(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+func TestWOPI(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/ro"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "ro"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "doc.docx"), []byte("docx content"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "ro", "doc.docx"), []byte("ro content"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("txt"), 0666)
 	assert.NoError(t, err)
 
 	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
 
-	req, err := http.NewRequest(http.MethodGet, webClientViewPDFPath, nil)
+	req, err := http.NewRequest(http.MethodGet, webClientDirsPath+"?path=%2F", nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath, nil)
+	checkResponseCode(t, http.StatusOK, rr)
+	var contents []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
+	for _, c := range contents {
+		switch c["name"] {
+		case "doc.docx":
+			assert.Contains(t, c["wopi_url"], webClientWOPIPath)
+		case "file.txt", "ro":
+			assert.Nil(t, c["wopi_url"])
+		}
+	}
 
-	req, err = http.NewRequest(http.MethodGet, webClientViewPDFPath+"?path=test.pdf", nil)
+	accessTokenRegex := regexp.MustCompile(`name="access_token" value="([^"]+)"`)
+	getAccessToken := func(name string) string {
+		req, err := http.NewRequest(http.MethodGet, webClientWOPIPath+"?path="+url.QueryEscape(name), nil)
+		assert.NoError(t, err)
+		setJWTCookieForReq(req, webToken)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr)
+		matches := accessTokenRegex.FindStringSubmatch(rr.Body.String())
+		if assert.Len(t, matches, 2) {
+			return matches[1]
+		}
+		return ""
+	}
+	getFileID := func(name string) string {
+		h := sha256.Sum256([]byte(user.Username + "\x00" + name))
+		return hex.EncodeToString(h[:])
+	}
+
+	req, err = http.NewRequest(http.MethodGet, webClientWOPIPath+"?path=doc.docx", nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=test.pdf", nil)
+	assert.Contains(t, rr.Body.String(), "http://127.0.0.1:11111/browser/cool.html?WOPISrc=")
+	assert.Contains(t, rr.Body.String(), url.QueryEscape(httpBaseURL+path.Join(wopiFilesPath, getFileID("/doc.docx"))))
+	// the view action is used for odt files and the placeholders are removed
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "doc.odt"), []byte("odt content"), 0666)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorFsGeneric)
-
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2F", nil)
+	req, err = http.NewRequest(http.MethodGet, webClientWOPIPath+"?path=doc.odt", nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "http://127.0.0.1:11111/browser/cool.html?WOPISrc=")
+	assert.NotContains(t, rr.Body.String(), "UI_LLCC")
 
-	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "test.pdf"), []byte("some text data"), 0666)
-	assert.NoError(t, err)
+	for _, name := range []string{"file.txt", "ro", "missing.docx"} {
+		req, err = http.NewRequest(http.MethodGet, webClientWOPIPath+"?path="+name, nil)
+		assert.NoError(t, err)
+		setJWTCookieForReq(req, webToken)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr)
+	}
 
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil) //nolint:goconst
+	accessToken := getAccessToken("doc.docx")
+	fileURL := path.Join(wopiFilesPath, getFileID("/doc.docx"))
+	req, err = http.NewRequest(http.MethodGet, fileURL+"?access_token="+accessToken, nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusInternalServerError, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
-
-	err = createTestFile(filepath.Join(user.GetHomeDir(), "test.pdf"), 1024)
+	checkResponseCode(t, http.StatusOK, rr)
+	var fileInfo map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &fileInfo)
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
+	assert.Equal(t, "doc.docx", fileInfo["BaseFileName"])
+	assert.Equal(t, user.Username, fileInfo["UserId"])
+	assert.Equal(t, float64(12), fileInfo["Size"])
+	assert.Equal(t, true, fileInfo["UserCanWrite"])
+	assert.Equal(t, true, fileInfo["SupportsLocks"])
+	// the token is valid only for the file it was issued for
+	req, err = http.NewRequest(http.MethodGet, path.Join(wopiFilesPath, getFileID("/doc.odt"))+"?access_token="+
+		accessToken, nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nErrorPDFMessage)
-
-	fakePDF := []byte(`%PDF-1.6`)
-	for i := 0; i < 128; i++ {
-		fakePDF = append(fakePDF, []byte(fmt.Sprintf("%d", i))...)
-	}
-	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "test.pdf"), fakePDF, 0666)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	req, err = http.NewRequest(http.MethodGet, fileURL+"?access_token="+webToken, nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	req, err = http.NewRequest(http.MethodGet, fileURL, nil)
 	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
 
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
+	req, err = http.NewRequest(http.MethodGet, fileURL+"/contents?access_token="+accessToken, nil)
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "docx content", rr.Body.String())
+	assert.NotEmpty(t, rr.Header().Get("X-WOPI-ItemVersion"))
 
-	user.Filters.FilePatterns = []sdk.PatternsFilter{
-		{
-			Path:           "/",
-			DeniedPatterns: []string{"*.pdf"},
-		},
+	doFileOperation := func(operation, lock, oldLock string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, fileURL+"?access_token="+accessToken, nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-WOPI-Override", operation)
+		if lock != "" {
+			req.Header.Set("X-WOPI-Lock", lock)
+		}
+		if oldLock != "" {
+			req.Header.Set("X-WOPI-OldLock", oldLock)
+		}
+		return executeRequest(req)
 	}
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	putFile := func(content, lock string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, fileURL+"/contents?access_token="+accessToken,
+			bytes.NewBuffer([]byte(content)))
+		assert.NoError(t, err)
+		req.Header.Set("X-WOPI-Override", "PUT")
+		if lock != "" {
+			req.Header.Set("X-WOPI-Lock", lock)
+		}
+		return executeRequest(req)
+	}
+	// non empty files cannot be written without a lock
+	rr = putFile("new content", "")
+	checkResponseCode(t, http.StatusConflict, rr)
+	rr = doFileOperation("LOCK", "", "")
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	rr = doFileOperation("LOCK", strings.Repeat("a", 1025), "")
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	rr = doFileOperation("LOCK", "lock1", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = doFileOperation("LOCK", "lock1", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = doFileOperation("LOCK", "lock2", "")
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.Equal(t, "lock1", rr.Header().Get("X-WOPI-Lock"))
+	rr = doFileOperation("GET_LOCK", "", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "lock1", rr.Header().Get("X-WOPI-Lock"))
+	rr = doFileOperation("REFRESH_LOCK", "lock1", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = doFileOperation("REFRESH_LOCK", "lock2", "")
+	checkResponseCode(t, http.StatusConflict, rr)
+	rr = putFile("new content", "lock2")
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.Equal(t, "lock1", rr.Header().Get("X-WOPI-Lock"))
+	rr = putFile("new content", "lock1")
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotEmpty(t, rr.Header().Get("X-WOPI-ItemVersion"))
+	content, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "doc.docx"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+	// unlock and relock
+	rr = doFileOperation("LOCK", "lock2", "lock3")
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.Equal(t, "lock1", rr.Header().Get("X-WOPI-Lock"))
+	rr = doFileOperation("LOCK", "lock2", "lock1")
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = doFileOperation("UNLOCK", "lock1", "")
+	checkResponseCode(t, http.StatusConflict, rr)
+	rr = doFileOperation("UNLOCK", "lock2", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	rr = doFileOperation("UNLOCK", "lock2", "")
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.Empty(t, rr.Header().Get("X-WOPI-Lock"))
+	rr = doFileOperation("PUT_RELATIVE", "", "")
+	checkResponseCode(t, http.StatusNotImplemented, rr)
+	// empty files can be written without a lock
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "doc.docx"), nil, 0666)
+	assert.NoError(t, err)
+	rr = putFile("content", "")
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodPost, fileURL+"/contents?access_token="+accessToken, nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-WOPI-Override", "PUT_RELATIVE")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotImplemented, rr)
+	// read only file
+	roToken := getAccessToken("/ro/doc.docx")
+	roURL := path.Join(wopiFilesPath, getFileID("/ro/doc.docx"))
+	req, err = http.NewRequest(http.MethodGet, roURL+"?access_token="+roToken, nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	fileInfo = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &fileInfo)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
+	assert.Equal(t, false, fileInfo["UserCanWrite"])
+	assert.Equal(t, true, fileInfo["ReadOnly"])
+	req, err = http.NewRequest(http.MethodPost, roURL+"/contents?access_token="+roToken,
+		bytes.NewBuffer([]byte("content")))
 	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
+	req.Header.Set("X-WOPI-Override", "PUT")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), util.I18nError403Message)
-
-	user.Filters.FilePatterns = []sdk.PatternsFilter{
-		{
-			Path:           "/",
-			DeniedPatterns: []string{"*.txt"},
-		},
-	}
-	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	// the token is invalidated if the user is modified
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
 	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
+	req, err = http.NewRequest(http.MethodGet, fileURL+"?access_token="+accessToken, nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	// the download permission is required
+	req, err = http.NewRequest(http.MethodGet, webClientWOPIPath+"?path=doc.docx", nil)
 	assert.NoError(t, err)
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
@@ -16344,12 +21039,6 @@ func TestWebClientViewPDF(t *testing.T) {
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	req, err = http.NewRequest(http.MethodGet, webClientGetPDFPath+"?path=%2Ftest.pdf", nil)
-	assert.NoError(t, err)
-	setJWTCookieForReq(req, webToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
 func TestWebEditFile(t *testing.T) {
@@ -16525,8 +21214,49 @@ func TestWebGetFiles(t *testing.T) {
 	setBearerForReq(req, webAPIToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
+	zipReader, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if assert.NoError(t, err) {
+		for _, f := range zipReader.File {
+			if !f.FileInfo().IsDir() {
+				assert.Equal(t, zip.Deflate, f.Method)
+			}
+		}
+	}
+	req, _ = http.NewRequest(http.MethodPost, userStreamZipPath+"?compression=store", bytes.NewBuffer(asJSON))
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	zipReader, err = zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if assert.NoError(t, err) {
+		assert.Greater(t, len(zipReader.File), 0)
+		for _, f := range zipReader.File {
+			if !f.FileInfo().IsDir() {
+				assert.Equal(t, zip.Store, f.Method)
+			}
+		}
+	}
+	req, _ = http.NewRequest(http.MethodPost, userStreamZipPath+"?compression=lzma", bytes.NewBuffer(asJSON))
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	form.Set("compression", "store")
+	req, _ = http.NewRequest(http.MethodPost, webClientDownloadZipPath+"?path="+url.QueryEscape("/"),
+		bytes.NewBuffer([]byte(form.Encode())))
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	form.Set("compression", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webClientDownloadZipPath+"?path="+url.QueryEscape("/"),
+		bytes.NewBuffer([]byte(form.Encode())))
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
 
-	assert.NoError(t, err)
 	req, _ = http.NewRequest(http.MethodPost, userStreamZipPath, bytes.NewBuffer([]byte(`file`)))
 	setBearerForReq(req, webAPIToken)
 	rr = executeRequest(req)
@@ -16763,6 +21493,63 @@ func TestWebGetFiles(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebClientCompressedDownload(t *testing.T) {
+	compressionConfig := common.Config.HTTPCompression
+	common.Config.HTTPCompression = common.HTTPCompressionConfig{
+		Enabled:   true,
+		MinSize:   100,
+		MimeTypes: []string{"text/*"},
+	}
+	defer func() {
+		common.Config.HTTPCompression = compressionConfig
+	}()
+
+	u := getTestUser()
+	u.DownloadDataTransfer = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileName := "testfile.txt"
+	testFileContents := []byte(strings.Repeat("compressible file contents ", 100))
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), testFileName), testFileContents, os.ModePerm)
+	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, webClientFilesPath+"?path="+testFileName, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Empty(t, rr.Header().Get("Content-Length"))
+	reader, err := gzip.NewReader(rr.Body)
+	if assert.NoError(t, err) {
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, testFileContents, data)
+	}
+	// range requests are not compressed
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath+"?path="+testFileName, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusPartialContent, rr)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, testFileContents[:10], rr.Body.Bytes())
+	// the transfer quota is updated using the uncompressed size
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(testFileContents)+10), user.UsedDownloadDataTransfer)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestRenameDifferentResource(t *testing.T) {
 	folderName := "foldercryptfs"
 	f := vfs.BaseVirtualFolder{
@@ -17109,84 +21896,472 @@ func TestWebUploadSingleFile(t *testing.T) {
 	setBearerForReq(req, webAPIToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusNotFound, rr)
-	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?mkdir_parents=true&path="+url.QueryEscape("/subdir/file.txt"), bytes.NewBuffer(content))
+	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?mkdir_parents=true&path="+url.QueryEscape("/subdir/file.txt"), bytes.NewBuffer(content))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	metadataReq := make(map[string]int64)
+	metadataReq["modification_time"] = util.GetTimeAsMsSinceEpoch(modTime)
+	asJSON, err := json.Marshal(metadataReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	info, err = os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
+	if assert.NoError(t, err) {
+		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(modTime), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(1000))
+	}
+	// missing file
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file2.txt", bytes.NewBuffer(asJSON)) //nolint:goconst
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Contains(t, rr.Body.String(), "Unable to set metadata for path")
+	// invalid JSON
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(content))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// missing mandatory parameter
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "please set a modification_time and a path")
+
+	metadataReq = make(map[string]int64)
+	asJSON, err = json.Marshal(metadataReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "please set a modification_time and a path")
+
+	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?path=%2Fdir%2Ffile.txt", bytes.NewBuffer(content))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Contains(t, rr.Body.String(), "Unable to write file")
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?path=file.txt", bytes.NewBuffer(content))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Contains(t, rr.Body.String(), "Unable to retrieve your user")
+
+	metadataReq["modification_time"] = util.GetTimeAsMsSinceEpoch(modTime)
+	asJSON, err = json.Marshal(metadataReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, webAPIToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Contains(t, rr.Body.String(), "Unable to retrieve your user")
+}
+
+func TestTusUpload(t *testing.T) {
+	u := getTestUser()
+	u.Filters.MaxUploadFileSize = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	webAPIToken, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	newTusRequest := func(method, url string, body io.Reader) *http.Request {
+		req, err := http.NewRequest(method, url, body)
+		assert.NoError(t, err)
+		setBearerForReq(req, webAPIToken)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		return req
+	}
+	content := []byte("tus upload content")
+	modTime := time.Now().Add(-48 * time.Hour)
+	metadata := fmt.Sprintf("filename %s,mtime %s", base64.StdEncoding.EncodeToString([]byte("file.txt")),
+		base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(util.GetTimeAsMsSinceEpoch(modTime), 10))))
+
+	req := newTusRequest(http.MethodOptions, userTusPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+	assert.Equal(t, "1.0.0", rr.Header().Get("Tus-Version"))
+	assert.Contains(t, rr.Header().Get("Tus-Extension"), "checksum")
+	// unsupported protocol version
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusPreconditionFailed, rr)
+	// missing or invalid length
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Defer-Length", "1")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// missing path
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "please set a file path")
+	// invalid metadata
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	req.Header.Set("Upload-Metadata", "filename invalid-base64")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// the size exceeds the user limit
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Length", "101")
+	req.Header.Set("Upload-Metadata", metadata)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusRequestEntityTooLarge, rr)
+
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	req.Header.Set("Upload-Metadata", metadata)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, userTusPath+"/"))
+	assert.NotEmpty(t, rr.Header().Get("Upload-Expires"))
+
+	req = newTusRequest(http.MethodHead, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "0", rr.Header().Get("Upload-Offset"))
+	assert.Equal(t, strconv.Itoa(len(content)), rr.Header().Get("Upload-Length"))
+	// invalid content type
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[:5]))
+	req.Header.Set("Upload-Offset", "0")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnsupportedMediaType, rr)
+	// offset mismatch
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[:5]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "1")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr)
+	assert.Equal(t, "0", rr.Header().Get("Upload-Offset"))
+	// checksum mismatch, the data will be discarded
+	sum := sha1.Sum([]byte("invalid"))
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[:5]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	req.Header.Set("Upload-Checksum", "sha1 "+base64.StdEncoding.EncodeToString(sum[:]))
+	rr = executeRequest(req)
+	checkResponseCode(t, 460, rr)
+	assert.Equal(t, "0", rr.Header().Get("Upload-Offset"))
+	// unsupported checksum algorithm
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[:5]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	req.Header.Set("Upload-Checksum", "crc32 "+base64.StdEncoding.EncodeToString(sum[:]))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	sum = sha1.Sum(content[:5])
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[:5]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	req.Header.Set("Upload-Checksum", "sha1 "+base64.StdEncoding.EncodeToString(sum[:]))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+	assert.Equal(t, "5", rr.Header().Get("Upload-Offset"))
+	// the file is written when the upload is complete
+	_, err = os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	req = newTusRequest(http.MethodHead, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "5", rr.Header().Get("Upload-Offset"))
+	// more data than declared
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(append(content[5:], 'a')))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "5")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusRequestEntityTooLarge, rr)
+
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content[5:]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "5")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+	assert.Equal(t, strconv.Itoa(len(content)), rr.Header().Get("Upload-Offset"))
+
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	info, err := os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
+	if assert.NoError(t, err) {
+		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(modTime), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(1000))
+	}
+	// the upload was removed
+	req = newTusRequest(http.MethodHead, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// upload to a missing dir will fail without the mkdir_parents param
+	req = newTusRequest(http.MethodPost, userTusPath+"?path="+url.QueryEscape("/sub dir/file.txt"), nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location = rr.Header().Get("Location")
+	req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(content))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// the data are retained so the final write can be retried
+	req = newTusRequest(http.MethodHead, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, strconv.Itoa(len(content)), rr.Header().Get("Upload-Offset"))
+	err = os.Mkdir(filepath.Join(user.GetHomeDir(), "sub dir"), os.ModePerm)
+	assert.NoError(t, err)
+	req = newTusRequest(http.MethodPatch, location, nil)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+	data, err = os.ReadFile(filepath.Join(user.GetHomeDir(), "sub dir", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	req = newTusRequest(http.MethodPost, userTusPath+"?mkdir_parents=true&path="+url.QueryEscape("/a/b/empty.txt"), nil)
+	req.Header.Set("Upload-Length", "0")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	info, err = os.Stat(filepath.Join(user.GetHomeDir(), "a", "b", "empty.txt"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), info.Size())
+	}
+	// termination
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file1.txt", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location = rr.Header().Get("Location")
+	req = newTusRequest(http.MethodDelete, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+	req = newTusRequest(http.MethodDelete, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req = newTusRequest(http.MethodHead, userTusPath+"/invalid", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// uploads are not shared between users
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file1.txt", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location = rr.Header().Get("Location")
+
+	u1 := getTestUser()
+	u1.Username = xid.New().String()
+	user1, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	webAPIToken1, err := getJWTAPIUserTokenFromTestServer(user1.Username, defaultPassword)
+	assert.NoError(t, err)
+	req = newTusRequest(http.MethodHead, location, nil)
+	setBearerForReq(req, webAPIToken1)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// the user cannot upload
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file2.txt", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr)
+	checkResponseCode(t, http.StatusForbidden, rr)
 
-	metadataReq := make(map[string]int64)
-	metadataReq["modification_time"] = util.GetTimeAsMsSinceEpoch(modTime)
-	asJSON, err := json.Marshal(metadataReq)
+	req = newTusRequest(http.MethodDelete, location, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
+	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	info, err = os.Stat(filepath.Join(user.GetHomeDir(), "file.txt"))
-	if assert.NoError(t, err) {
-		assert.InDelta(t, util.GetTimeAsMsSinceEpoch(modTime), util.GetTimeAsMsSinceEpoch(info.ModTime()), float64(1000))
-	}
-	// missing file
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file2.txt", bytes.NewBuffer(asJSON)) //nolint:goconst
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	assert.Contains(t, rr.Body.String(), "Unable to set metadata for path")
-	// invalid JSON
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(content))
+	err = os.RemoveAll(user1.GetHomeDir())
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+}
+
+func TestTusConcatenation(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	webAPIToken, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	newTusRequest := func(method, url string, body io.Reader) *http.Request {
+		req, err := http.NewRequest(method, url, body)
+		assert.NoError(t, err)
+		setBearerForReq(req, webAPIToken)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		return req
+	}
+	createPartialUpload := func(data []byte, complete bool) string {
+		req := newTusRequest(http.MethodPost, userTusPath, nil)
+		req.Header.Set("Upload-Length", strconv.Itoa(len(data)))
+		req.Header.Set("Upload-Concat", "partial")
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, rr)
+		location := rr.Header().Get("Location")
+		if complete {
+			req = newTusRequest(http.MethodPatch, location, bytes.NewBuffer(data))
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.Header.Set("Upload-Offset", "0")
+			rr = executeRequest(req)
+			checkResponseCode(t, http.StatusNoContent, rr)
+		}
+		return location
+	}
+	parts := [][]byte{[]byte("part1 "), []byte("part2 "), []byte("part3")}
+	var locations []string
+	for _, part := range parts {
+		locations = append(locations, createPartialUpload(part, true))
+	}
+	// completed partial uploads are not written to the user's filesystem
+	req := newTusRequest(http.MethodHead, locations[0], nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "partial", rr.Header().Get("Upload-Concat"))
+	assert.Equal(t, strconv.Itoa(len(parts[0])), rr.Header().Get("Upload-Offset"))
+
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Concat", "invalid")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	// missing mandatory parameter
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath, bytes.NewBuffer(asJSON))
-	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+	// missing path
+	req = newTusRequest(http.MethodPost, userTusPath, nil)
+	req.Header.Set("Upload-Concat", "final;"+strings.Join(locations, " "))
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "please set a modification_time and a path")
-
-	metadataReq = make(map[string]int64)
-	asJSON, err = json.Marshal(metadataReq)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
-	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+	// the length is not allowed for final uploads
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file.txt", nil)
+	req.Header.Set("Upload-Concat", "final;"+strings.Join(locations, " "))
+	req.Header.Set("Upload-Length", "17")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "please set a modification_time and a path")
+	// duplicated parts
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file.txt", nil)
+	req.Header.Set("Upload-Concat", "final;"+locations[0]+" "+locations[0])
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// incomplete part
+	incompleteLocation := createPartialUpload([]byte("data"), false)
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file.txt", nil)
+	req.Header.Set("Upload-Concat", "final;"+locations[0]+" "+incompleteLocation)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "is not a completed partial upload")
+	// the target directory does not exist, the partial uploads are retained
+	req = newTusRequest(http.MethodPost, userTusPath+"?path="+url.QueryEscape("/dir/file.txt"), nil)
+	req.Header.Set("Upload-Concat", "final;"+strings.Join(locations, " "))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
 
-	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?path=%2Fdir%2Ffile.txt", bytes.NewBuffer(content))
+	req = newTusRequest(http.MethodPost, userTusPath+"?mkdir_parents=true&path="+url.QueryEscape("/dir/file.txt"), nil)
+	req.Header.Set("Upload-Concat", "final;"+strings.Join(locations, " "))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	assert.NotEmpty(t, rr.Header().Get("Location"))
+
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "dir", "file.txt"))
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+	assert.Equal(t, bytes.Join(parts, nil), data)
+	for _, location := range locations {
+		req = newTusRequest(http.MethodHead, location, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusNotFound, rr)
+	}
+	// a regular upload cannot be concatenated
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file1.txt", nil)
+	req.Header.Set("Upload-Length", "10")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	assert.Contains(t, rr.Body.String(), "Unable to write file")
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
+	req = newTusRequest(http.MethodPost, userTusPath+"?path=file.txt", nil)
+	req.Header.Set("Upload-Concat", "final;"+location)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	for _, l := range []string{location, incompleteLocation} {
+		req = newTusRequest(http.MethodDelete, l, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusNoContent, rr)
+	}
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+}
 
-	req, err = http.NewRequest(http.MethodPost, userUploadFilePath+"?path=file.txt", bytes.NewBuffer(content))
+func TestWebClientTusUpload(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientProfilePath, webToken)
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	assert.Contains(t, rr.Body.String(), "Unable to retrieve your user")
 
-	metadataReq["modification_time"] = util.GetTimeAsMsSinceEpoch(modTime)
-	asJSON, err = json.Marshal(metadataReq)
+	content := []byte("web client tus upload")
+	req, err := http.NewRequest(http.MethodPost, webClientTusPath+"?path="+url.QueryEscape("/file.txt"), nil)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPatch, userFilesDirsMetadataPath+"?path=file.txt", bytes.NewBuffer(asJSON))
+	req.RemoteAddr = defaultRemoteAddr
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req.Header.Set("X-CSRF-TOKEN", csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, webClientTusPath+"/"))
+
+	req, err = http.NewRequest(http.MethodPatch, location, bytes.NewBuffer(content))
 	assert.NoError(t, err)
-	setBearerForReq(req, webAPIToken)
+	req.RemoteAddr = defaultRemoteAddr
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("X-CSRF-TOKEN", csrfToken)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr)
-	assert.Contains(t, rr.Body.String(), "Unable to retrieve your user")
+	checkResponseCode(t, http.StatusNoContent, rr)
+
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
 }
 
 func TestWebFilesAPI(t *testing.T) {
@@ -18808,7 +23983,7 @@ func TestWebAdminSetupMock(t *testing.T) {
 	checkResponseCode(t, http.StatusFound, rr)
 	assert.Equal(t, webLoginPath, rr.Header().Get("Location"))
 	// now delete all the admins
-	admins, err := dataprovider.GetAdmins(100, 0, dataprovider.OrderASC)
+	admins, err := dataprovider.GetAdmins(100, 0, dataprovider.OrderASC, "")
 	assert.NoError(t, err)
 	for _, admin := range admins {
 		err = dataprovider.DeleteAdmin(admin.Username, "", "", "")
@@ -20057,6 +25232,218 @@ func TestAPIKeysManagement(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestOAuth2AuthorizationServer(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "docs"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "docs", "file.txt"), []byte("content"), os.ModePerm)
+	assert.NoError(t, err)
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	app := dataprovider.OAuth2App{
+		Name:         "test app",
+		RedirectURIs: []string{"http://app.example.com/callback"},
+		Scopes:       []string{dataprovider.OAuth2ScopeFilesRead},
+	}
+	asJSON, err := json.Marshal(app)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, oauth2AppsPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "must use https")
+
+	redirectURI := "https://app.example.com/callback"
+	app.RedirectURIs = []string{redirectURI}
+	asJSON, err = json.Marshal(app)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, oauth2AppsPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	resp := make(map[string]string)
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	clientID := resp["client_id"]
+	clientSecret := resp["client_secret"]
+	assert.NotEmpty(t, clientID)
+	assert.NotEmpty(t, clientSecret)
+	assert.Equal(t, clientID, rr.Header().Get("X-Object-ID"))
+
+	req, err = http.NewRequest(http.MethodGet, path.Join(oauth2AppsPath, clientID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var savedApp dataprovider.OAuth2App
+	err = json.Unmarshal(rr.Body.Bytes(), &savedApp)
+	assert.NoError(t, err)
+	assert.Equal(t, app.Name, savedApp.Name)
+	assert.Empty(t, savedApp.ClientSecret)
+	assert.Equal(t, 60, savedApp.TokenValidity)
+
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "missing client")
+	params.Set("redirect_uri", redirectURI)
+	req, err = http.NewRequest(http.MethodGet, webClientOAuth2AuthorizePath+"?"+params.Encode(), nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), util.I18nErrorOAuth2InvalidRequest)
+
+	params.Set("client_id", clientID)
+	params.Set("scope", dataprovider.OAuth2ScopeFilesWrite)
+	req, err = http.NewRequest(http.MethodGet, webClientOAuth2AuthorizePath+"?"+params.Encode(), nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Contains(t, rr.Header().Get("Location"), "error=invalid_scope")
+
+	codeVerifier := util.GenerateUniqueID()
+	hash := sha256.Sum256([]byte(codeVerifier))
+	params.Set("scope", dataprovider.OAuth2ScopeFilesRead)
+	params.Set("state", "test_state")
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(hash[:]))
+	params.Set("code_challenge_method", "S256")
+	csrfToken, err := getCSRFTokenFromInternalPageMock(webClientOAuth2AuthorizePath+"?"+params.Encode(), webToken)
+	assert.NoError(t, err)
+
+	form := make(url.Values)
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("path", "/docs")
+	form.Set("consent", "deny")
+	req, err = http.NewRequest(http.MethodPost, webClientOAuth2AuthorizePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	form.Set(csrfFormToken, csrfToken)
+	req, err = http.NewRequest(http.MethodPost, webClientOAuth2AuthorizePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Equal(t, redirectURI+"?error=access_denied&state=test_state", rr.Header().Get("Location"))
+
+	form.Set("consent", "allow")
+	req, err = http.NewRequest(http.MethodPost, webClientOAuth2AuthorizePath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	redirectURL, err := url.Parse(rr.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "test_state", redirectURL.Query().Get("state"))
+	code := redirectURL.Query().Get("code")
+	assert.NotEmpty(t, code)
+
+	tokenForm := make(url.Values)
+	tokenForm.Set("grant_type", "authorization_code")
+	tokenForm.Set("code", code)
+	tokenForm.Set("redirect_uri", redirectURI)
+	tokenForm.Set("code_verifier", codeVerifier)
+	req, err = http.NewRequest(http.MethodPost, oauth2TokenPath, bytes.NewBuffer([]byte(tokenForm.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, "wrong secret")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+	assert.Contains(t, rr.Body.String(), "invalid_client")
+
+	req, err = http.NewRequest(http.MethodPost, oauth2TokenPath, bytes.NewBuffer([]byte(tokenForm.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	tokenResp := make(map[string]any)
+	err = json.Unmarshal(rr.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", tokenResp["token_type"])
+	assert.Equal(t, dataprovider.OAuth2ScopeFilesRead, tokenResp["scope"])
+	assert.Equal(t, float64(3600), tokenResp["expires_in"])
+	accessToken, ok := tokenResp["access_token"].(string)
+	assert.True(t, ok)
+	// authorization codes can be used only once
+	req, err = http.NewRequest(http.MethodPost, oauth2TokenPath, bytes.NewBuffer([]byte(tokenForm.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "invalid_grant")
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path=%2Fdocs", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var contents []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	assert.Len(t, contents, 1)
+	req, err = http.NewRequest(http.MethodGet, userFilesPath+"?path=%2Fdocs%2Ffile.txt", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "content", rr.Body.String())
+	// outside the granted path
+	req, err = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// the read scope does not allow to write
+	req, err = http.NewRequest(http.MethodPost, userDirsPath+"?path=%2Fdocs%2Fsub", nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// profile, shares and other features are not available to OAuth2 tokens
+	req, err = http.NewRequest(http.MethodGet, userProfilePath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	req, err = http.NewRequest(http.MethodGet, userSharesPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, accessToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, err = http.NewRequest(http.MethodDelete, path.Join(oauth2AppsPath, clientID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(oauth2AppsPath, clientID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestAPIKeySearch(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
@@ -22879,6 +28266,12 @@ func TestWebUserAzureBlobMock(t *testing.T) {
 	form.Set("az_endpoint", user.FsConfig.AzBlobConfig.Endpoint)
 	form.Set("az_key_prefix", user.FsConfig.AzBlobConfig.KeyPrefix)
 	form.Set("az_use_emulator", "checked")
+	form.Set("az_access_tier_rules[10][az_tier_rule_path]", "/reports/*")
+	form.Set("az_access_tier_rules[10][az_tier_rule_tier]", "Hot")
+	form.Set("az_access_tier_rules[2][az_tier_rule_path]", "/archive")
+	form.Set("az_access_tier_rules[2][az_tier_rule_tier]", "Archive")
+	form.Set("az_access_tier_rules[3][az_tier_rule_path]", " ")
+	form.Set("az_rehydrate_priority", "High")
 	form.Set("directory_patterns[0][pattern_path]", "/dir1")
 	form.Set("directory_patterns[0][patterns]", "*.jpg,*.png")
 	form.Set("directory_patterns[0][pattern_type]", "allowed")
@@ -22950,6 +28343,13 @@ func TestWebUserAzureBlobMock(t *testing.T) {
 	assert.Equal(t, updateUser.FsConfig.AzBlobConfig.DownloadPartSize, user.FsConfig.AzBlobConfig.DownloadPartSize)
 	assert.Equal(t, updateUser.FsConfig.AzBlobConfig.DownloadConcurrency, user.FsConfig.AzBlobConfig.DownloadConcurrency)
 	assert.Equal(t, 2, len(updateUser.Filters.FilePatterns))
+	assert.Equal(t, "High", updateUser.FsConfig.AzBlobConfig.RehydratePriority)
+	if assert.Len(t, updateUser.FsConfig.AzBlobConfig.AccessTierRules, 2) {
+		assert.Equal(t, "/archive", updateUser.FsConfig.AzBlobConfig.AccessTierRules[0].Path)
+		assert.Equal(t, "Archive", updateUser.FsConfig.AzBlobConfig.AccessTierRules[0].AccessTier)
+		assert.Equal(t, "/reports/*", updateUser.FsConfig.AzBlobConfig.AccessTierRules[1].Path)
+		assert.Equal(t, "Hot", updateUser.FsConfig.AzBlobConfig.AccessTierRules[1].AccessTier)
+	}
 	assert.Equal(t, sdkkms.SecretStatusSecretBox, updateUser.FsConfig.AzBlobConfig.AccountKey.GetStatus())
 	assert.NotEmpty(t, updateUser.FsConfig.AzBlobConfig.AccountKey.GetPayload())
 	assert.Empty(t, updateUser.FsConfig.AzBlobConfig.AccountKey.GetKey())
@@ -26331,6 +31731,9 @@ func startOIDCMockServer() {
 		http.HandleFunc("/auth/realms/sftpgo/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
 			fmt.Fprintf(w, `{"issuer":"http://127.0.0.1:11111/auth/realms/sftpgo","authorization_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/auth","token_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/token","introspection_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/token/introspect","userinfo_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/userinfo","end_session_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/logout","frontchannel_logout_session_supported":true,"frontchannel_logout_supported":true,"jwks_uri":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/certs","check_session_iframe":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/login-status-iframe.html","grant_types_supported":["authorization_code","implicit","refresh_token","password","client_credentials","urn:ietf:params:oauth:grant-type:device_code","urn:openid:params:grant-type:ciba"],"response_types_supported":["code","none","id_token","token","id_token token","code id_token","code token","code id_token token"],"subject_types_supported":["public","pairwise"],"id_token_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512"],"id_token_encryption_alg_values_supported":["RSA-OAEP","RSA-OAEP-256","RSA1_5"],"id_token_encryption_enc_values_supported":["A256GCM","A192GCM","A128GCM","A128CBC-HS256","A192CBC-HS384","A256CBC-HS512"],"userinfo_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512","none"],"request_object_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512","none"],"request_object_encryption_alg_values_supported":["RSA-OAEP","RSA-OAEP-256","RSA1_5"],"request_object_encryption_enc_values_supported":["A256GCM","A192GCM","A128GCM","A128CBC-HS256","A192CBC-HS384","A256CBC-HS512"],"response_modes_supported":["query","fragment","form_post","query.jwt","fragment.jwt","form_post.jwt","jwt"],"registration_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/clients-registrations/openid-connect","token_endpoint_auth_methods_supported":["private_key_jwt","client_secret_basic","client_secret_post","tls_client_auth","client_secret_jwt"],"token_endpoint_auth_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512"],"introspection_endpoint_auth_methods_supported":["private_key_jwt","client_secret_basic","client_secret_post","tls_client_auth","client_secret_jwt"],"introspection_endpoint_auth_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512"],"authorization_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512"],"authorization_encryption_alg_values_supported":["RSA-OAEP","RSA-OAEP-256","RSA1_5"],"authorization_encryption_enc_values_supported":["A256GCM","A192GCM","A128GCM","A128CBC-HS256","A192CBC-HS384","A256CBC-HS512"],"claims_supported":["aud","sub","iss","auth_time","name","given_name","family_name","preferred_username","email","acr"],"claim_types_supported":["normal"],"claims_parameter_supported":true,"scopes_supported":["openid","phone","email","web-origins","offline_access","microprofile-jwt","profile","address","roles"],"request_parameter_supported":true,"request_uri_parameter_supported":true,"require_request_uri_registration":true,"code_challenge_methods_supported":["plain","S256"],"tls_client_certificate_bound_access_tokens":true,"revocation_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/revoke","revocation_endpoint_auth_methods_supported":["private_key_jwt","client_secret_basic","client_secret_post","tls_client_auth","client_secret_jwt"],"revocation_endpoint_auth_signing_alg_values_supported":["PS384","ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","PS256","PS512","RS512"],"backchannel_logout_supported":true,"backchannel_logout_session_supported":true,"device_authorization_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/auth/device","backchannel_token_delivery_modes_supported":["poll","ping"],"backchannel_authentication_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/ext/ciba/auth","backchannel_authentication_request_signing_alg_values_supported":["PS384","ES384","RS384","ES256","RS256","ES512","PS256","PS512","RS512"],"require_pushed_authorization_requests":false,"pushed_authorization_request_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/ext/par/request","mtls_endpoint_aliases":{"token_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/token","revocation_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/revoke","introspection_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/token/introspect","device_authorization_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/auth/device","registration_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/clients-registrations/openid-connect","userinfo_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/userinfo","pushed_authorization_request_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/ext/par/request","backchannel_authentication_endpoint":"http://127.0.0.1:11111/auth/realms/sftpgo/protocol/openid-connect/ext/ciba/auth"}}`)
 		})
+		http.HandleFunc("/hosting/discovery", func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><wopi-discovery><net-zone name="external-http"><app name="writer"><action default="true" ext="docx" name="edit" urlsrc="http://127.0.0.1:11111/browser/cool.html?"/><action ext="odt" name="view" urlsrc="http://127.0.0.1:11111/browser/cool.html?&lt;ui=UI_LLCC&amp;&gt;"/></app><app name="Capabilities"><action ext="" name="getinfo" urlsrc="http://127.0.0.1:11111/hosting/capabilities"/></app></net-zone></wopi-discovery>`)
+		})
 		http.HandleFunc("/404", func(w http.ResponseWriter, _ *http.Request) {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprintf(w, "Not found\n")
@@ -26343,6 +31746,21 @@ func startOIDCMockServer() {
 	waitTCPListening(oidcMockAddr)
 }
 
+func waitForJob(t *testing.T, token, jobID string) common.Job {
+	var job common.Job
+	assert.Eventually(t, func() bool {
+		req, _ := http.NewRequest(http.MethodGet, path.Join(jobsPath, jobID), nil)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		if rr.Code != http.StatusOK {
+			return false
+		}
+		err := render.DecodeJSON(rr.Body, &job)
+		return err == nil && job.Status != common.JobStatusRunning
+	}, 5*time.Second, 100*time.Millisecond)
+	return job
+}
+
 func waitForUsersQuotaScan(t *testing.T, token string) {
 	for {
 		var scans []common.ActiveQuotaScan
@@ -26436,6 +31854,13 @@ func getTestRole() dataprovider.Role {
 	}
 }
 
+func getTestTenant() dataprovider.Tenant {
+	return dataprovider.Tenant{
+		Name:        "test_tenant",
+		Description: "test tenant description",
+	}
+}
+
 func getTestUser() dataprovider.User {
 	user := dataprovider.User{
 		BaseUser: sdk.BaseUser{
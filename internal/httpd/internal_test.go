@@ -16,13 +16,18 @@ package httpd
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"html/template"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/fs"
 	"net/http"
@@ -32,6 +37,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -45,6 +51,7 @@ import (
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
+	"github.com/sftpgo/sdk/plugin/eventsearcher"
 	"github.com/sftpgo/sdk/plugin/notifier"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -398,6 +405,9 @@ func TestMappedStatusCode(t *testing.T) {
 	err = &http.MaxBytesError{}
 	code = getMappedStatusCode(err)
 	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	err = fmt.Errorf("%w: InvalidObjectState", vfs.ErrObjectArchived)
+	code = getMappedStatusCode(err)
+	assert.Equal(t, http.StatusConflict, code)
 }
 
 func TestGCSWebInvalidFormFile(t *testing.T) {
@@ -1961,7 +1971,7 @@ func TestQuotaScanInvalidFs(t *testing.T) {
 		},
 	}
 	common.QuotaScans.AddUserQuotaScan(user.Username, "")
-	err := doUserQuotaScan(user)
+	_, err := doUserQuotaScan(user, false)
 	assert.Error(t, err)
 }
 
@@ -2451,7 +2461,7 @@ func TestCompressorAbortHandler(t *testing.T) {
 		request:        nil,
 	}
 	share := &dataprovider.Share{}
-	renderCompressedFiles(&failingWriter{}, connection, "", nil, share)
+	renderCompressedFiles(&failingWriter{}, connection, "", nil, share, zip.Deflate)
 }
 
 func TestStreamDataAbortHandler(t *testing.T) {
@@ -2486,14 +2496,15 @@ func TestZipErrors(t *testing.T) {
 		assert.Contains(t, err.Error(), "write error")
 	}
 
-	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", 0)
+	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", zip.Deflate, 0)
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "write error")
 	}
-	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", 2000)
+	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", zip.Deflate, 2000)
 	assert.ErrorIs(t, err, util.ErrRecursionTooDeep)
 
-	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), path.Join("/", filepath.Base(testDir), "dir"), 0)
+	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), path.Join("/", filepath.Base(testDir), "dir"),
+		zip.Deflate, 0)
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "is outside base dir")
 	}
@@ -2502,14 +2513,14 @@ func TestZipErrors(t *testing.T) {
 	err = os.WriteFile(testFilePath, util.GenerateRandomBytes(65535), os.ModePerm)
 	assert.NoError(t, err)
 	err = addZipEntry(wr, connection, path.Join("/", filepath.Base(testDir), filepath.Base(testFilePath)),
-		"/"+filepath.Base(testDir), 0)
+		"/"+filepath.Base(testDir), zip.Deflate, 0)
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "write error")
 	}
 
 	connection.User.Permissions["/"] = []string{dataprovider.PermListItems}
 	err = addZipEntry(wr, connection, path.Join("/", filepath.Base(testDir), filepath.Base(testFilePath)),
-		"/"+filepath.Base(testDir), 0)
+		"/"+filepath.Base(testDir), zip.Deflate, 0)
 	assert.ErrorIs(t, err, os.ErrPermission)
 
 	// creating a virtual folder to a missing path stat is ok but readdir fails
@@ -2521,14 +2532,14 @@ func TestZipErrors(t *testing.T) {
 	})
 	connection.User = user
 	wr = zip.NewWriter(bytes.NewBuffer(make([]byte, 0)))
-	err = addZipEntry(wr, connection, user.VirtualFolders[0].VirtualPath, "/", 0)
+	err = addZipEntry(wr, connection, user.VirtualFolders[0].VirtualPath, "/", zip.Deflate, 0)
 	assert.Error(t, err)
 
 	user.Filters.FilePatterns = append(user.Filters.FilePatterns, sdk.PatternsFilter{
 		Path:           "/",
 		DeniedPatterns: []string{"*.zip"},
 	})
-	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", 0)
+	err = addZipEntry(wr, connection, "/"+filepath.Base(testDir), "/", zip.Deflate, 0)
 	assert.ErrorIs(t, err, os.ErrPermission)
 
 	err = os.RemoveAll(testDir)
@@ -2657,7 +2668,7 @@ func TestRequestHeaderErrors(t *testing.T) {
 	assert.Equal(t, condNone, res)
 
 	req, _ = http.NewRequest(http.MethodPost, webClientFilesPath, nil)
-	res = checkIfRange(req, time.Now())
+	res = checkIfRange(req, time.Now(), "")
 	assert.Equal(t, condNone, res)
 
 	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
@@ -2667,16 +2678,63 @@ func TestRequestHeaderErrors(t *testing.T) {
 
 	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
 	req.Header.Set("If-Range", time.Now().Format(http.TimeFormat))
-	res = checkIfRange(req, time.Time{})
+	res = checkIfRange(req, time.Time{}, "")
 	assert.Equal(t, condFalse, res)
 
 	req.Header.Set("If-Range", "invalid if range date")
-	res = checkIfRange(req, time.Now())
+	res = checkIfRange(req, time.Now(), "")
 	assert.Equal(t, condFalse, res)
 	modTime := getFileObjectModTime(time.Time{})
 	assert.Empty(t, modTime)
 }
 
+func TestETagPreconditions(t *testing.T) {
+	etag := `"0123456789abcdef"`
+	modTime := time.Now()
+	req, _ := http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	assert.Equal(t, condNone, checkIfMatch(req, etag))
+	assert.Equal(t, condNone, checkIfNoneMatch(req, etag))
+	req.Header.Set("If-Match", `"other", `+etag)
+	assert.Equal(t, condTrue, checkIfMatch(req, etag))
+	req.Header.Set("If-Match", "W/"+etag)
+	assert.Equal(t, condFalse, checkIfMatch(req, etag))
+	req.Header.Set("If-Match", "*")
+	assert.Equal(t, condTrue, checkIfMatch(req, ""))
+	req.Header.Set("If-Match", etag)
+	assert.Equal(t, condFalse, checkIfMatch(req, ""))
+	req.Header.Set("If-None-Match", "W/"+etag)
+	assert.Equal(t, condFalse, checkIfNoneMatch(req, etag))
+	req.Header.Set("If-None-Match", `"other"`)
+	assert.Equal(t, condTrue, checkIfNoneMatch(req, etag))
+	req.Header.Set("If-Range", etag)
+	assert.Equal(t, condTrue, checkIfRange(req, modTime, etag))
+	req.Header.Set("If-Range", "W/"+etag)
+	assert.Equal(t, condFalse, checkIfRange(req, modTime, etag))
+	req.Header.Set("If-Range", `"other"`)
+	assert.Equal(t, condFalse, checkIfRange(req, modTime, etag))
+
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	req.Header.Set("If-Match", `"other"`)
+	rr := httptest.NewRecorder()
+	assert.True(t, checkPreconditions(rr, req, modTime, etag))
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	// If-Match takes precedence over If-Unmodified-Since
+	req.Header.Set("If-Match", etag)
+	req.Header.Set("If-Unmodified-Since", modTime.Add(-time.Hour).UTC().Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.False(t, checkPreconditions(rr, req, modTime, etag))
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	assert.True(t, checkPreconditions(rr, req, modTime, etag))
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	// If-None-Match takes precedence over If-Modified-Since
+	req.Header.Set("If-None-Match", `"other"`)
+	req.Header.Set("If-Modified-Since", modTime.Add(time.Hour).UTC().Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.False(t, checkPreconditions(rr, req, modTime, etag))
+}
+
 func TestConnection(t *testing.T) {
 	user := dataprovider.User{
 		BaseUser: sdk.BaseUser{
@@ -3075,6 +3133,114 @@ func TestResetCodesCleanup(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestTrustedDevices(t *testing.T) {
+	server := httpdServer{
+		csrfTokenAuth: jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil),
+	}
+	fingerprint := getTrustedDeviceFingerprint("config", "secret")
+	assert.NotEqual(t, fingerprint, getTrustedDeviceFingerprint("config", "secret1"))
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, webClientTwoFactorPath, nil)
+	assert.NoError(t, err)
+	assert.False(t, isTrustedDevice(rr, req, server.csrfTokenAuth, "user", fingerprint, webBaseClientPath, false, 10))
+	rememberDevice(rr, req, server.csrfTokenAuth, "user", fingerprint, webBaseClientPath, false, 0)
+	assert.Len(t, rr.Result().Cookies(), 0)
+	rr = httptest.NewRecorder()
+	rememberDevice(rr, req, server.csrfTokenAuth, "user", fingerprint, webBaseClientPath, false, 10)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, trustedDeviceCookieKey, cookies[0].Name)
+
+	req, err = http.NewRequest(http.MethodPost, webClientLoginPath, nil)
+	assert.NoError(t, err)
+	req.AddCookie(cookies[0])
+	device, err := getTrustedDeviceFromCookie(req, server.csrfTokenAuth, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", device.Username)
+	// the device is scoped to the user account and is not found for an admin with the same name
+	_, err = getTrustedDeviceFromCookie(req, server.csrfTokenAuth, true)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	rr = httptest.NewRecorder()
+	assert.True(t, isTrustedDevice(rr, req, server.csrfTokenAuth, "user", fingerprint, webBaseClientPath, false, 10))
+	assert.False(t, isTrustedDevice(rr, req, server.csrfTokenAuth, "user", fingerprint, webBaseClientPath, false, 0))
+	// lowering the max age or changing the second factor must invalidate the device
+	device.CreatedAt = time.Now().Add(-48 * time.Hour)
+	assert.False(t, device.isValidFor("user", fingerprint, false, 1))
+	assert.True(t, device.isValidFor("user", fingerprint, false, 3))
+	assert.False(t, device.isValidFor("user", fingerprint, true, 3))
+	assert.False(t, isTrustedDevice(rr, req, server.csrfTokenAuth, "user",
+		getTrustedDeviceFingerprint("config", "secret1"), webBaseClientPath, false, 10))
+	_, err = trustedDevicesMgr.Get(device.Username, device.IsAdmin, device.ID)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	// a cookie signed with a different key is not accepted
+	otherAuth := jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil)
+	_, err = getTrustedDeviceFromCookie(req, otherAuth, false)
+	assert.Error(t, err)
+
+	device = newTrustedDevice("user1", fingerprint, "127.0.0.1", "agent", false, 1)
+	device.ExpiresAt = time.Now().Add(-1 * time.Minute).UTC()
+	err = trustedDevicesMgr.Add(device)
+	assert.NoError(t, err)
+	devices, err := trustedDevicesMgr.List("user1", false)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 0)
+	trustedDevicesMgr.Cleanup()
+	_, err = trustedDevicesMgr.Get(device.Username, device.IsAdmin, device.ID)
+	assert.Error(t, err)
+	// list and revoke
+	device1 := newTrustedDevice("user2", fingerprint, "127.0.0.1", "agent1", false, 1)
+	device1.CreatedAt = device1.CreatedAt.Add(-1 * time.Minute)
+	err = trustedDevicesMgr.Add(device1)
+	assert.NoError(t, err)
+	device2 := newTrustedDevice("user2", fingerprint, "127.0.0.2", "agent2", false, 1)
+	err = trustedDevicesMgr.Add(device2)
+	assert.NoError(t, err)
+	device3 := newTrustedDevice("user2", fingerprint, "127.0.0.3", "agent3", true, 1)
+	err = trustedDevicesMgr.Add(device3)
+	assert.NoError(t, err)
+	device4 := newTrustedDevice("user22", fingerprint, "127.0.0.4", "agent4", false, 1)
+	err = trustedDevicesMgr.Add(device4)
+	assert.NoError(t, err)
+	// only the sessions for the requested account are loaded from the provider
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeTrustedDevice,
+		getTrustedDeviceKeyPrefix("user2", false))
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	for _, session := range sessions {
+		assert.True(t, strings.HasSuffix(session.Key, device1.ID) || strings.HasSuffix(session.Key, device2.ID))
+	}
+	devices, err = trustedDevicesMgr.List("user2", false)
+	assert.NoError(t, err)
+	if assert.Len(t, devices, 2) {
+		assert.Equal(t, device2.ID, devices[0].ID)
+		assert.Equal(t, device1.ID, devices[1].ID)
+	}
+	info := device2.getInfo(device2.ID)
+	assert.True(t, info.Current)
+	assert.Equal(t, "127.0.0.2", info.IP)
+	assert.Equal(t, "agent2", info.UserAgent)
+	assert.False(t, device1.getInfo(device2.ID).Current)
+	err = deleteTrustedDevice(device3.ID, "user2", false)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	err = deleteTrustedDevice(device1.ID, "user1", false)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	err = deleteTrustedDevice(device1.ID, "user2", false)
+	assert.NoError(t, err)
+	err = deleteTrustedDevice(device3.ID, "user2", true)
+	assert.NoError(t, err)
+	devices, err = trustedDevicesMgr.List("user2", false)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	err = trustedDevicesMgr.Delete(device2.Username, device2.IsAdmin, device2.ID)
+	assert.NoError(t, err)
+	err = trustedDevicesMgr.Delete(device4.Username, device4.IsAdmin, device4.ID)
+	assert.NoError(t, err)
+	devices, err = trustedDevicesMgr.List("user22", false)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 0)
+}
+
 func TestUserCanResetPassword(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, webClientLoginPath, nil)
 	assert.NoError(t, err)
@@ -3263,7 +3429,7 @@ func TestRESTAPIDisabled(t *testing.T) {
 func TestWebAdminSetupWithInstallCode(t *testing.T) {
 	installationCode = "1234"
 	// delete all the admins
-	admins, err := dataprovider.GetAdmins(100, 0, dataprovider.OrderASC)
+	admins, err := dataprovider.GetAdmins(100, 0, dataprovider.OrderASC, "")
 	assert.NoError(t, err)
 	for _, admin := range admins {
 		err = dataprovider.DeleteAdmin(admin.Username, "", "", "")
@@ -3702,6 +3868,13 @@ func TestUserQuotaUsage(t *testing.T) {
 	assert.True(t, usage.IsQuotaSizeLow())
 	assert.True(t, usage.IsDiskQuotaLow())
 	assert.True(t, usage.IsQuotaLow())
+	assert.False(t, usage.IsQuotaWarnThresholdReached())
+	usage.QuotaWarnThreshold = 95
+	assert.False(t, usage.IsQuotaWarnThresholdReached())
+	usage.QuotaWarnThreshold = 90
+	assert.True(t, usage.IsQuotaWarnThresholdReached())
+	assert.Equal(t, 90, usage.GetDiskQuotaPercentage())
+	usage.QuotaWarnThreshold = 0
 	usage.DownloadDataTransfer = 1
 	assert.True(t, usage.HasQuotaInfo())
 	assert.True(t, usage.HasTranferQuota())
@@ -3800,6 +3973,8 @@ func TestI18NMessages(t *testing.T) {
 	require.Equal(t, util.I18nError403Message, msg)
 	msg = i18nFsMsg(http.StatusInternalServerError)
 	require.Equal(t, util.I18nErrorFsGeneric, msg)
+	msg = i18nFsMsg(http.StatusConflict)
+	require.Equal(t, util.I18nErrorFsArchived, msg)
 }
 
 func TestI18NErrors(t *testing.T) {
@@ -3839,3 +4014,661 @@ func isSharedProviderSupported() bool {
 		return false
 	}
 }
+
+func TestReloadBindings(t *testing.T) {
+	registry := runningServers
+	runningServers = &serversRegistry{
+		servers: make(map[string]*runningServer),
+	}
+	defer func() {
+		runningServers = registry
+	}()
+
+	b := Binding{
+		Address:         "127.0.0.1",
+		Port:            8099,
+		EnableWebAdmin:  true,
+		EnableWebClient: true,
+		EnableRESTAPI:   true,
+	}
+	c := Conf{
+		Bindings: []Binding{b},
+	}
+	err := c.ReloadBindings()
+	assert.Error(t, err)
+	runningServers.setServerBuilder(func(b Binding) *httpdServer {
+		return newHttpdServer(b, "../static", "", CorsConfig{}, "../openapi")
+	})
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	healthzURL := fmt.Sprintf("http://%s%s", b.GetAddress(), healthzPath)
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(healthzURL)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 50*time.Millisecond)
+
+	s, ok := runningServers.servers[b.GetAddress()]
+	require.True(t, ok)
+	router := s.router.Load()
+	c.Bindings[0].Branding.WebAdmin.Name = "reloaded"
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.NotEqual(t, router, s.router.Load())
+	assert.Equal(t, "reloaded", s.binding.Branding.WebAdmin.Name)
+	// listener changes require a restart
+	router = s.router.Load()
+	c.Bindings[0].MinTLSVersion = 13
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Equal(t, router, s.router.Load())
+	assert.Equal(t, 0, s.binding.MinTLSVersion)
+	// invalid bindings are rejected
+	c.Bindings[0].MinTLSVersion = 0
+	c.Bindings[0].ProxyAllowed = []string{"invalid ip"}
+	err = c.ReloadBindings()
+	assert.Error(t, err)
+	assert.Len(t, runningServers.servers, 1)
+	// remove the binding
+	c.Bindings = nil
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, runningServers.servers, 0)
+	assert.True(t, s.removed.Load())
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(healthzURL)
+		if err != nil {
+			return true
+		}
+		resp.Body.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestGetPreviewType(t *testing.T) {
+	assert.Equal(t, previewTypeImage, getPreviewType("/a/b.JPG"))
+	assert.Equal(t, previewTypePDF, getPreviewType("doc.pdf"))
+	assert.Equal(t, previewTypeVideo, getPreviewType("movie.webm"))
+	assert.Equal(t, previewTypeAudio, getPreviewType("song.flac"))
+	assert.Equal(t, previewTypeText, getPreviewType("main.go"))
+	assert.Equal(t, previewTypeText, getPreviewType("/dir/Dockerfile"))
+	assert.Equal(t, previewTypeText, getPreviewType("README"))
+	assert.Empty(t, getPreviewType("archive.zip"))
+	assert.Empty(t, getPreviewType("noext"))
+}
+
+func TestThumbnailsConfig(t *testing.T) {
+	oldMgr := thumbnailsMgr
+	defer func() {
+		thumbnailsMgr = oldMgr
+	}()
+
+	c := ThumbnailsConfig{
+		Enabled:    true,
+		PDFCommand: "pdftoppm",
+	}
+	err := c.initialize(configDir)
+	assert.Error(t, err)
+	c.PDFCommand = ""
+	c.VideoCommand = "ffmpeg"
+	err = c.initialize(configDir)
+	assert.Error(t, err)
+	c.VideoCommand = ""
+	c.CachePath = "thumbs_cache"
+	c.CacheSize = 1
+	err = c.initialize(os.TempDir())
+	assert.NoError(t, err)
+	if assert.NotNil(t, thumbnailsMgr) {
+		assert.Equal(t, filepath.Join(os.TempDir(), "thumbs_cache"), thumbnailsMgr.cachePath)
+		assert.Equal(t, int64(1048576), thumbnailsMgr.maxCacheSize)
+		assert.Equal(t, int64(defaultThumbnailsMaxSrc*1048576), thumbnailsMgr.maxFileSize)
+		assert.Equal(t, thumbnailTypeImage, thumbnailsMgr.getThumbnailType("a.JPG"))
+		assert.Empty(t, thumbnailsMgr.getThumbnailType("a.pdf"))
+		assert.Empty(t, thumbnailsMgr.getThumbnailType("a.mp4"))
+	}
+	thumbnailsMgr = nil
+	err = os.RemoveAll(filepath.Join(os.TempDir(), "thumbs_cache"))
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, "/?size=512", nil)
+	assert.NoError(t, err)
+	size, err := getThumbnailSize(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 512, size)
+	req, err = http.NewRequest(http.MethodGet, "/?size=a", nil)
+	assert.NoError(t, err)
+	_, err = getThumbnailSize(req)
+	assert.Error(t, err)
+
+	rr := httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, userThumbnailsPath+"?path=a.png", nil)
+	assert.NoError(t, err)
+	getUserThumbnail(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	server := httpdServer{}
+	rr = httptest.NewRecorder()
+	server.getBrowsableShareThumbnail(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestThumbnailsCache(t *testing.T) {
+	cachePath := filepath.Join(os.TempDir(), "thumbs_cache_lru")
+	err := os.MkdirAll(cachePath, os.ModePerm)
+	assert.NoError(t, err)
+	// leftover temporary file
+	err = os.WriteFile(filepath.Join(cachePath, "thumb-1.tmp"), []byte("tmp"), 0666)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cachePath, "old"+thumbnailCacheExtension), make([]byte, 60), 0666)
+	assert.NoError(t, err)
+	err = os.Chtimes(filepath.Join(cachePath, "old"+thumbnailCacheExtension), time.Now().Add(-time.Hour),
+		time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cachePath, "recent"+thumbnailCacheExtension), make([]byte, 60), 0666)
+	assert.NoError(t, err)
+
+	mgr := &thumbnailManager{
+		cachePath:    cachePath,
+		maxCacheSize: 100,
+		maxFileSize:  1048576,
+		sem:          make(chan struct{}, 1),
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+	err = mgr.loadCache()
+	assert.NoError(t, err)
+	// the least recently used thumbnail is evicted on load
+	assert.Equal(t, 1, mgr.lru.Len())
+	assert.Equal(t, int64(60), mgr.cacheSize)
+	assert.NoFileExists(t, filepath.Join(cachePath, "thumb-1.tmp"))
+	assert.NoFileExists(t, filepath.Join(cachePath, "old"+thumbnailCacheExtension))
+	_, ok := mgr.get("old")
+	assert.False(t, ok)
+	data, ok := mgr.get("recent")
+	assert.True(t, ok)
+	assert.Len(t, data, 60)
+
+	err = mgr.add("k1", make([]byte, 30))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(90), mgr.cacheSize)
+	_, ok = mgr.get("recent")
+	assert.True(t, ok)
+	err = mgr.add("k2", make([]byte, 30))
+	assert.NoError(t, err)
+	// k1 is the least recently used
+	_, ok = mgr.get("k1")
+	assert.False(t, ok)
+	assert.Equal(t, int64(90), mgr.cacheSize)
+	// replace an existing entry
+	err = mgr.add("k2", make([]byte, 20))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(80), mgr.cacheSize)
+	// a cached file removed from disk is removed from the index too
+	err = os.Remove(mgr.getCacheFilePath("k2"))
+	assert.NoError(t, err)
+	_, ok = mgr.get("k2")
+	assert.False(t, ok)
+	assert.Equal(t, 1, mgr.lru.Len())
+	assert.Equal(t, int64(60), mgr.cacheSize)
+
+	err = os.RemoveAll(cachePath)
+	assert.NoError(t, err)
+	err = mgr.add("k3", []byte("data"))
+	assert.Error(t, err)
+}
+
+func TestResizeThumbnail(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 400))
+	resized := resizeThumbnail(src, 64)
+	assert.Equal(t, 16, resized.Bounds().Dx())
+	assert.Equal(t, 64, resized.Bounds().Dy())
+	// transparent pixels are rendered on a white background
+	r, g, b, a := resized.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	assert.Equal(t, uint32(0xffff), g)
+	assert.Equal(t, uint32(0xffff), b)
+	assert.Equal(t, uint32(0xffff), a)
+	// images are never upscaled
+	resized = resizeThumbnail(image.NewRGBA(image.Rect(10, 10, 30, 20)), 256)
+	assert.Equal(t, 20, resized.Bounds().Dx())
+	assert.Equal(t, 10, resized.Bounds().Dy())
+}
+
+func TestThumbnailsWithCommands(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	cachePath := filepath.Join(os.TempDir(), "thumbs_cache_cmd")
+	err := os.MkdirAll(cachePath, os.ModePerm)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 300, 600))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var b bytes.Buffer
+	err = png.Encode(&b, img)
+	assert.NoError(t, err)
+	pngPath := filepath.Join(cachePath, "src.png")
+	err = os.WriteFile(pngPath, b.Bytes(), 0666)
+	assert.NoError(t, err)
+	// the PDF command receives the output prefix as last argument, the video command the output file
+	pdfCmd := filepath.Join(cachePath, "pdftoppm.sh")
+	err = os.WriteFile(pdfCmd, []byte(fmt.Sprintf("#!/bin/sh\nfor last; do true; done\ncp %q \"$last.png\"\n", pngPath)), 0755)
+	assert.NoError(t, err)
+	videoCmd := filepath.Join(cachePath, "ffmpeg.sh")
+	err = os.WriteFile(videoCmd, []byte(fmt.Sprintf("#!/bin/sh\nfor last; do true; done\ncp %q \"$last\"\n", pngPath)), 0755)
+	assert.NoError(t, err)
+	failCmd := filepath.Join(cachePath, "fail.sh")
+	err = os.WriteFile(failCmd, []byte("#!/bin/sh\necho failure\nexit 1\n"), 0755)
+	assert.NoError(t, err)
+
+	mgr := &thumbnailManager{
+		cachePath:    cachePath,
+		maxCacheSize: 1048576,
+		maxFileSize:  100,
+		pdfCommand:   pdfCmd,
+		videoCommand: videoCmd,
+	}
+	assert.Equal(t, thumbnailTypePDF, mgr.getThumbnailType("a.pdf"))
+	assert.Equal(t, thumbnailTypeVideo, mgr.getThumbnailType("a.MKV"))
+	res, err := mgr.renderWithCommand(bytes.NewBufferString("pdf"), thumbnailTypePDF, 128)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 300, res.Bounds().Dx())
+	}
+	res, err = mgr.renderWithCommand(bytes.NewBufferString("video"), thumbnailTypeVideo, 128)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 600, res.Bounds().Dy())
+	}
+	_, err = mgr.renderWithCommand(bytes.NewReader(make([]byte, 101)), thumbnailTypeVideo, 128)
+	assert.ErrorIs(t, err, errThumbnailTooLarge)
+	_, err = mgr.decodeImage(bytes.NewReader(make([]byte, 101)))
+	assert.ErrorIs(t, err, errThumbnailTooLarge)
+	_, err = mgr.decodeImage(bytes.NewBufferString("invalid"))
+	assert.ErrorIs(t, err, errThumbnailInvalidSource)
+	mgr.pdfCommand = failCmd
+	_, err = mgr.renderWithCommand(bytes.NewBufferString("pdf"), thumbnailTypePDF, 128)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failure")
+	}
+	// the temporary files are removed
+	entries, err := os.ReadDir(cachePath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	err = os.RemoveAll(cachePath)
+	assert.NoError(t, err)
+}
+
+func TestTusHelpers(t *testing.T) {
+	metadata, err := parseTusMetadata("filename ZmlsZS50eHQ=, is_confidential,mtime MTAw")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "file.txt", "is_confidential": "", "mtime": "100"}, metadata)
+	_, err = parseTusMetadata("filename ZmlsZS50eHQ=,,")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = parseTusMetadata("filename a")
+	assert.ErrorIs(t, err, util.ErrValidation)
+
+	_, _, err = parseTusChecksum("sha1")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, _, err = parseTusChecksum("sha1 a")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	for _, algo := range strings.Split(tusChecksumAlgorithms, ",") {
+		_, _, err = parseTusChecksum(algo + " YQ==")
+		assert.NoError(t, err)
+	}
+
+	id1 := xid.New().String()
+	id2 := xid.New().String()
+	ids, err := parseTusConcatParts(fmt.Sprintf(" %s/%s https://example.com%s/%s ", userTusPath, id1, userTusPath, id2))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{id1, id2}, ids)
+	for _, parts := range []string{"", " ", userTusPath + "/invalid", id1 + " " + id1, "%gh&%ij"} {
+		_, err = parseTusConcatParts(parts)
+		assert.ErrorIs(t, err, util.ErrValidation, parts)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, userTusPath, nil)
+	assert.Empty(t, getTusUploadPath(r, map[string]string{}))
+	assert.Equal(t, "file.txt", getTusUploadPath(r, map[string]string{"filename": "../dir/file.txt"}))
+	assert.Equal(t, "/dir/file.txt", getTusUploadPath(r, map[string]string{"path": "/dir/file.txt", "filename": "f"}))
+	r = httptest.NewRequest(http.MethodPost, userTusPath+"?path=%2Fa.txt", nil)
+	assert.Equal(t, "/a.txt", getTusUploadPath(r, map[string]string{"path": "/dir/file.txt"}))
+}
+
+func TestTusUploadsCleanup(t *testing.T) {
+	mgr := newTusUploadManager()
+	upload := tusUpload{
+		ID:        xid.New().String(),
+		Username:  "user",
+		Path:      "/file.txt",
+		Size:      10,
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Minute)),
+	}
+	err := mgr.create(&upload)
+	require.NoError(t, err)
+	_, _, err = mgr.get(upload.ID, "user")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	assert.NoFileExists(t, mgr.getInfoPath(upload.ID))
+
+	upload.ID = xid.New().String()
+	err = mgr.create(&upload)
+	require.NoError(t, err)
+	validUpload := upload
+	validUpload.ID = xid.New().String()
+	validUpload.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Hour))
+	err = mgr.create(&validUpload)
+	require.NoError(t, err)
+	_, _, err = mgr.get(validUpload.ID, "other user")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	_, offset, err := mgr.get(validUpload.ID, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	// orphaned data file
+	orphanedID := xid.New().String()
+	err = os.WriteFile(mgr.getDataPath(orphanedID), []byte("data"), 0600)
+	require.NoError(t, err)
+	err = os.Chtimes(mgr.getDataPath(orphanedID), time.Now(), time.Now().Add(-2*tusUploadExpiration))
+	require.NoError(t, err)
+
+	mgr.cleanup()
+	assert.NoFileExists(t, mgr.getInfoPath(upload.ID))
+	assert.NoFileExists(t, mgr.getDataPath(upload.ID))
+	assert.NoFileExists(t, mgr.getDataPath(orphanedID))
+	assert.FileExists(t, mgr.getInfoPath(validUpload.ID))
+	assert.FileExists(t, mgr.getDataPath(validUpload.ID))
+
+	err = mgr.lock(validUpload.ID)
+	assert.NoError(t, err)
+	err = mgr.lock(validUpload.ID)
+	assert.ErrorIs(t, err, errTusUploadLocked)
+	mgr.unlock(validUpload.ID)
+	mgr.remove(validUpload.ID)
+	assert.NoFileExists(t, mgr.getInfoPath(validUpload.ID))
+}
+
+func TestWOPIConfig(t *testing.T) {
+	oldMgr := wopiMgr
+	defer func() {
+		wopiMgr = oldMgr
+	}()
+
+	wopiMgr = nil
+	c := WOPIConfig{
+		Enabled:   true,
+		ServerURL: "collabora:9980",
+		HostURL:   "https://sftpgo.example.com",
+	}
+	err := c.initialize()
+	assert.Error(t, err)
+	c.ServerURL = "http://127.0.0.1:9980/"
+	c.HostURL = "/relative"
+	err = c.initialize()
+	assert.Error(t, err)
+	c.HostURL = "https://sftpgo.example.com/"
+	c.TokenValidity = -1
+	err = c.initialize()
+	assert.Error(t, err)
+	assert.Nil(t, wopiMgr)
+	c.TokenValidity = 0
+	err = c.initialize()
+	assert.NoError(t, err)
+	if assert.NotNil(t, wopiMgr) {
+		assert.Equal(t, "http://127.0.0.1:9980", wopiMgr.serverURL)
+		assert.Equal(t, "https://sftpgo.example.com", wopiMgr.hostURL)
+		assert.Equal(t, defaultWOPITokenValidity*time.Minute, wopiMgr.tokenValidity)
+		// the WOPI client is not reachable
+		assert.False(t, wopiMgr.isSupported("file.docx"))
+		assert.False(t, wopiMgr.isSupported("file"))
+		assert.Empty(t, wopiMgr.getActionURL("file.docx", "id", true))
+		assert.True(t, wopiMgr.nextLoad.After(time.Now()))
+	}
+	wopiMgr = nil
+	c.Enabled = false
+	err = c.initialize()
+	assert.NoError(t, err)
+	assert.Nil(t, wopiMgr)
+}
+
+func TestWOPIActions(t *testing.T) {
+	discovery := `<wopi-discovery><net-zone name="external-https"><app name="word">
+<action name="view" ext="DOCX" urlsrc="https://office.example.com/wv/wordviewerframe.aspx?&lt;ui=UI_LLCC&amp;&gt;&lt;rs=DC_LLCC&amp;&gt;"/>
+<action name="edit" ext="docx" urlsrc="https://office.example.com/we/wordeditorframe.aspx?a=b"/>
+<action name="view" ext="pdf" urlsrc="https://office.example.com/pdf"/>
+<action name="view" ext="" urlsrc="https://office.example.com/noext"/>
+</app></net-zone></wopi-discovery>`
+	var d wopiDiscovery
+	err := xml.Unmarshal([]byte(discovery), &d)
+	require.NoError(t, err)
+	mgr := &wopiManager{
+		hostURL:  "https://sftpgo.example.com",
+		actions:  d.getActions(),
+		nextLoad: time.Now().Add(time.Hour),
+		locks:    make(map[string]wopiLock),
+	}
+	assert.Len(t, mgr.actions, 2)
+	assert.True(t, mgr.isSupported("a/b.DOCX"))
+	assert.False(t, mgr.isSupported("a/b.txt"))
+	wopiSrc := url.QueryEscape("https://sftpgo.example.com" + path.Join(wopiFilesPath, "fileid"))
+	assert.Equal(t, "https://office.example.com/we/wordeditorframe.aspx?a=b&WOPISrc="+wopiSrc,
+		mgr.getActionURL("b.docx", "fileid", true))
+	assert.Equal(t, "https://office.example.com/wv/wordviewerframe.aspx?WOPISrc="+wopiSrc,
+		mgr.getActionURL("b.docx", "fileid", false))
+	assert.Equal(t, "https://office.example.com/pdf?WOPISrc="+wopiSrc,
+		mgr.getActionURL("b.pdf", "fileid", true))
+	assert.Empty(t, mgr.getActionURL("b.txt", "fileid", true))
+}
+
+func TestWOPILocks(t *testing.T) {
+	mgr := &wopiManager{
+		locks: make(map[string]wopiLock),
+	}
+	current, ok := mgr.lock("id", "lock1", "")
+	assert.True(t, ok)
+	assert.Equal(t, "lock1", current)
+	current, ok = mgr.lock("id", "lock2", "")
+	assert.False(t, ok)
+	assert.Equal(t, "lock1", current)
+	current, ok = mgr.lock("id1", "lock2", "lock1")
+	assert.False(t, ok)
+	assert.Empty(t, current)
+	current, ok = mgr.refreshLock("id1", "lock2")
+	assert.False(t, ok)
+	assert.Empty(t, current)
+	_, ok = mgr.refreshLock("id", "lock1")
+	assert.True(t, ok)
+	_, ok = mgr.lock("id", "lock2", "lock1")
+	assert.True(t, ok)
+	assert.Equal(t, "lock2", mgr.getLock("id"))
+	current, ok = mgr.unlock("id", "lock1")
+	assert.False(t, ok)
+	assert.Equal(t, "lock2", current)
+	_, ok = mgr.unlock("id", "lock2")
+	assert.True(t, ok)
+	assert.Empty(t, mgr.getLock("id"))
+	// expired locks are ignored and removed
+	mgr.locks["id"] = wopiLock{
+		id:        "lock1",
+		expiresAt: time.Now().Add(-1 * time.Minute),
+	}
+	mgr.locks["id1"] = wopiLock{
+		id:        "lock1",
+		expiresAt: time.Now().Add(-1 * time.Minute),
+	}
+	_, ok = mgr.lock("id", "lock2", "")
+	assert.True(t, ok)
+	mgr.cleanup()
+	assert.Len(t, mgr.locks, 1)
+	assert.Equal(t, "lock2", mgr.getLock("id"))
+}
+
+func TestGetZipCompressionMethod(t *testing.T) {
+	method, err := getZipCompressionMethod("")
+	assert.NoError(t, err)
+	assert.Equal(t, zip.Deflate, method)
+	method, err = getZipCompressionMethod("Deflate")
+	assert.NoError(t, err)
+	assert.Equal(t, zip.Deflate, method)
+	method, err = getZipCompressionMethod(" store")
+	assert.NoError(t, err)
+	assert.Equal(t, zip.Store, method)
+	_, err = getZipCompressionMethod("zstd")
+	assert.ErrorIs(t, err, util.ErrValidation)
+}
+
+func TestBulkUsersCSV(t *testing.T) {
+	_, err := getBulkUsersFromCSV(strings.NewReader(""))
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = getBulkUsersFromCSV(strings.NewReader("email,status\n"))
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = getBulkUsersFromCSV(strings.NewReader("username,Username\n"))
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = getBulkUsersFromCSV(strings.NewReader("username,status\nuser1\n"))
+	assert.ErrorIs(t, err, util.ErrValidation)
+	inputs, err := getBulkUsersFromCSV(strings.NewReader("username, Quota_Size,public_keys,primary_group,secondary_groups\n" +
+		"user1,100,\"key1\nkey2\",g1,\"g2, g3\"\n"))
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "user1", inputs[0].user.Username)
+
+	user := dataprovider.User{}
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: "g4",
+			Type: sdk.GroupTypeSecondary,
+		},
+		{
+			Name: "g5",
+			Type: sdk.GroupTypeMembership,
+		},
+	}
+	err = applyBulkUserCSVValues(&user, inputs[0].columns, inputs[0].values)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), user.QuotaSize)
+	assert.Equal(t, []string{"key1", "key2"}, user.PublicKeys)
+	assert.Len(t, user.Groups, 4)
+	values := getBulkUserCSVValues(&user)
+	assert.Len(t, values, len(bulkUsersCSVColumns))
+	assert.Contains(t, values, "g1")
+	assert.Contains(t, values, "g2,g3")
+	assert.Contains(t, values, "g5")
+	err = applyBulkUserCSVValues(&user, []string{"uid"}, []string{"a"})
+	assert.ErrorIs(t, err, util.ErrValidation)
+}
+
+func TestBulkUserChanges(t *testing.T) {
+	existing := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			ID:          1,
+			Username:    "user",
+			HomeDir:     filepath.Clean(os.TempDir()),
+			Status:      1,
+			MaxSessions: 1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	updated, err := copyUserForBulk(&existing)
+	require.NoError(t, err)
+	changes, err := getBulkUserChanges(&existing, &updated, false)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 0)
+	updated.ID = 2
+	updated.UsedQuotaSize = 100
+	updated.MaxSessions = 2
+	updated.Description = "desc"
+	updated.Permissions["/dir"] = []string{dataprovider.PermListItems}
+	changes, err = getBulkUserChanges(&existing, &updated, true)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 4) {
+		assert.Equal(t, "description", changes[0].Field)
+		assert.Nil(t, changes[0].Old)
+		assert.Equal(t, "max_sessions", changes[1].Field)
+		assert.Equal(t, "password", changes[2].Field)
+		assert.Nil(t, changes[2].Old)
+		assert.Nil(t, changes[2].New)
+		assert.Equal(t, "permissions./dir", changes[3].Field)
+	}
+}
+
+func TestEventsCursorPagination(t *testing.T) {
+	var allEvents []fsEvent
+	for i := 0; i < 2500; i++ {
+		username := "user1"
+		if i%2 == 0 {
+			username = "user2"
+		}
+		allEvents = append(allEvents, fsEvent{
+			ID:        fmt.Sprintf("%05d", i),
+			Timestamp: int64(i),
+			Username:  username,
+			Action:    "upload",
+		})
+	}
+	fetch := func(cursor *eventsCursor, limit int) ([]fsEvent, error) {
+		start := 0
+		if cursor != nil {
+			id, err := strconv.Atoi(cursor.ID)
+			if err != nil {
+				return nil, err
+			}
+			start = id + 1
+		}
+		end := min(start+limit, len(allEvents))
+		return allEvents[start:end], nil
+	}
+	match := func(ev *fsEvent) bool {
+		return ev.Username == "user1"
+	}
+	events, cursor, err := searchEventsPage(fetch, nil, 10, 1, (*fsEvent).getCursor, nil)
+	assert.NoError(t, err)
+	assert.Len(t, events, 10)
+	require.NotNil(t, cursor)
+	assert.Equal(t, "00009", cursor.ID)
+	assert.Equal(t, 1, cursor.Order)
+	decoded, err := decodeEventsCursor(cursor.encode(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+	_, err = decodeEventsCursor(cursor.encode(), 0)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = decodeEventsCursor("e30", 1)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	params := eventsearcher.CommonSearchParams{}
+	cursor.apply(&params)
+	assert.Equal(t, int64(9), params.StartTimestamp)
+	assert.Equal(t, "00009", params.FromID)
+
+	var c *eventsCursor
+	count := 0
+	for {
+		events, c, err = searchEventsPage(fetch, c, 1000, 1, (*fsEvent).getCursor, match)
+		assert.NoError(t, err)
+		for _, ev := range events {
+			assert.Equal(t, "user1", ev.Username)
+		}
+		count += len(events)
+		if c == nil {
+			break
+		}
+	}
+	assert.Equal(t, 1250, count)
+
+	aggregations, err := aggregateEvents(fetch, 1, (*fsEvent).getCursor, nil, (*fsEvent).getAggregationKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, 2500, aggregations.Total)
+	assert.False(t, aggregations.Truncated)
+	assert.Equal(t, 1250, aggregations.Counts["username"]["user2"])
+	assert.Equal(t, 2500, aggregations.Counts["action"]["upload"])
+	aggregations, err = aggregateEvents(fetch, 1, (*fsEvent).getCursor, match, (*fsEvent).getAggregationKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, 1250, aggregations.Total)
+	assert.Len(t, aggregations.Counts["username"], 1)
+
+	statuses, err := parseEventStatus("1-3")
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{1, 2, 3}, statuses)
+	_, err = parseEventStatus("1-a")
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = parseEventStatus("1-1000")
+	assert.ErrorIs(t, err, util.ErrValidation)
+}
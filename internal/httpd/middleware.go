@@ -34,6 +34,7 @@ import (
 
 var (
 	forwardedProtoKey = &contextKey{"forwarded proto"}
+	bindingTenantKey  = &contextKey{"binding tenant"}
 	errInvalidToken   = errors.New("invalid JWT token")
 )
 
@@ -53,7 +54,7 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 		redirectPath = webAdminLoginPath
 	} else {
 		redirectPath = webClientLoginPath
-		if uri := r.RequestURI; strings.HasPrefix(uri, webClientFilesPath) {
+		if uri := r.RequestURI; isAllowedClientNextURL(uri) {
 			redirectPath += "?next=" + url.QueryEscape(uri) //nolint:goconst
 		}
 	}
@@ -214,6 +215,77 @@ func (s *httpdServer) checkHTTPUserPerm(perm string) func(next http.Handler) htt
 	}
 }
 
+// checkImpersonatedRequest logs the WebClient requests performed by an admin
+// impersonating a user and ends the impersonation session if the admin is no
+// longer allowed to impersonate the user
+func (s *httpdServer) checkImpersonatedRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, err := getTokenClaims(r); err == nil && claims.Impersonator != "" {
+			ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+			if err := checkImpersonator(claims.Impersonator, claims.Username, ipAddr); err != nil {
+				logger.Warn(logSender, "", "impersonation of user %q by admin %q denied: %v, ip: %q",
+					claims.Username, claims.Impersonator, err, ipAddr)
+				removeCookie(w, r, webBaseClientPath)
+				s.renderClientForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorImpersonationFailed))
+				return
+			}
+			logger.Info(logSender, "", "admin %q impersonating user %q: %s %s, ip: %q", claims.Impersonator,
+				claims.Username, r.Method, r.URL.Path, ipAddr)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// denyImpersonation rejects the requests changing the user credentials if the
+// user is impersonated by an admin
+func (s *httpdServer) denyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, err := getTokenClaims(r); err == nil && claims.Impersonator != "" {
+			if isWebRequest(r) {
+				s.renderClientForbiddenPage(w, r, util.NewI18nError(errImpersonationNotAllowed,
+					util.I18nErrorImpersonationNotAllowed))
+			} else {
+				sendAPIResponse(w, r, errImpersonationNotAllowed, "", http.StatusForbidden)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRateByIdentity applies the per-identity rate limiters to authenticated
+// REST API requests. API keys have their own buckets, otherwise the bucket is
+// shared between all the tokens issued for the same admin or user.
+// Inter-node requests are not limited
+func (s *httpdServer) limitRateByIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := getTokenClaims(r)
+		if err != nil || claims.Username == "" || claims.NodeID != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var identity string
+		switch {
+		case claims.APIKeyID != "":
+			identity = "apikey:" + claims.APIKeyID
+		case claims.hasUserAudience():
+			identity = "user:" + claims.Username
+		default:
+			identity = "admin:" + claims.Username
+		}
+		ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+		if delay, err := common.LimitRateByIdentity(common.ProtocolHTTP, ipAddr, identity); err != nil {
+			setRetryAfterHeaders(w, delay)
+			s.sendTooManyRequestResponse(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // checkAuthRequirements checks if the user must set a second factor auth or change the password
 func (s *httpdServer) checkAuthRequirements(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -347,6 +419,11 @@ func (s *httpdServer) verifyCSRFHeader(next http.Handler) http.Handler {
 	})
 }
 
+// permissions for the requests authenticated using a node token. The defender
+// permissions are required to replicate the defender bans between the nodes
+var nodeTokenPermissions = []string{dataprovider.PermAdminViewConnections, dataprovider.PermAdminCloseConnections,
+	dataprovider.PermAdminViewDefender, dataprovider.PermAdminManageDefender}
+
 func checkNodeToken(tokenAuth *jwtauth.JWTAuth) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -366,7 +443,7 @@ func checkNodeToken(tokenAuth *jwtauth.JWTAuth) func(next http.Handler) http.Han
 			}
 			c := jwtTokenClaims{
 				Username:    admin,
-				Permissions: []string{dataprovider.PermAdminViewConnections, dataprovider.PermAdminCloseConnections},
+				Permissions: nodeTokenPermissions,
 				NodeID:      dataprovider.GetNodeName(),
 				Role:        role,
 			}
@@ -423,6 +500,19 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 				sendAPIResponse(w, r, fmt.Errorf("the provided api key cannot be authenticated"), "", http.StatusUnauthorized)
 				return
 			}
+			if !k.IsAllowedFromIP(util.GetIPFromRemoteAddress(r.RemoteAddr)) {
+				handleDefenderEventLoginFailed(util.GetIPFromRemoteAddress(r.RemoteAddr), dataprovider.ErrInvalidCredentials) //nolint:errcheck
+				logger.Debug(logSender, "", "api key %q is not allowed from IP %q", keyID, r.RemoteAddr)
+				sendAPIResponse(w, r, fmt.Errorf("the provided api key cannot be used from this IP address"), "",
+					http.StatusUnauthorized)
+				return
+			}
+			if resource, level := getAPIKeyPermission(r); !k.HasPermission(resource, level) {
+				logger.Debug(logSender, "", "api key %q has no %q permission for resource %q, path: %q",
+					keyID, level, resource, r.URL.Path)
+				sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
 			if scope == dataprovider.APIKeyScopeAdmin {
 				if k.Admin != "" {
 					apiUser = k.Admin
@@ -463,6 +553,22 @@ func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope)
 	}
 }
 
+// getAPIKeyPermission returns the resource and the permission level required to
+// access the requested API endpoint. An empty resource means that the endpoint
+// cannot be accessed using an API key with restricted permissions
+func getAPIKeyPermission(r *http.Request) (string, string) {
+	level := dataprovider.APIKeyPermissionWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		level = dataprovider.APIKeyPermissionRead
+	}
+	for _, m := range apiKeyResources {
+		if r.URL.Path == m.path || strings.HasPrefix(r.URL.Path, m.path+"/") {
+			return m.resource, level
+		}
+	}
+	return "", level
+}
+
 func forbidAPIKeyAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, err := getTokenClaims(r)
@@ -474,6 +580,10 @@ func forbidAPIKeyAuthentication(next http.Handler) http.Handler {
 			sendAPIResponse(w, r, nil, "API key authentication is not allowed", http.StatusForbidden)
 			return
 		}
+		if claims.OAuth2ClientID != "" {
+			sendAPIResponse(w, r, nil, "OAuth2 token authentication is not allowed", http.StatusForbidden)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -499,6 +609,7 @@ func authenticateAdminWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTA
 		Permissions: admin.Permissions,
 		Signature:   admin.GetSignature(),
 		Role:        admin.Role,
+		Tenant:      admin.Tenant,
 		APIKeyID:    keyID,
 	}
 
@@ -555,6 +666,7 @@ func authenticateUserWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTAu
 		Permissions: user.Filters.WebClient,
 		Signature:   user.GetSignature(),
 		Role:        user.Role,
+		Tenant:      user.Tenant,
 		APIKeyID:    keyID,
 	}
 
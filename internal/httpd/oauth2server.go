@@ -0,0 +1,480 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	oauth2CodeValidity = 2 * time.Minute
+)
+
+// OAuth2 error codes as defined in RFC 6749
+const (
+	oauth2ErrInvalidRequest       = "invalid_request"
+	oauth2ErrInvalidClient        = "invalid_client"
+	oauth2ErrInvalidGrant         = "invalid_grant"
+	oauth2ErrInvalidScope         = "invalid_scope"
+	oauth2ErrAccessDenied         = "access_denied"
+	oauth2ErrUnsupportedGrantType = "unsupported_grant_type"
+	oauth2ErrUnsupportedResponse  = "unsupported_response_type"
+	oauth2ErrServerError          = "server_error"
+)
+
+var (
+	oauth2CodesMgr oauth2CodeManager
+)
+
+// isAllowedClientNextURL returns true if the WebClient can redirect to the
+// specified URL after the login
+func isAllowedClientNextURL(next string) bool {
+	return strings.HasPrefix(next, webClientFilesPath) || strings.HasPrefix(next, webClientOAuth2AuthorizePath)
+}
+
+type oauth2CodeManager interface {
+	Add(code *oauth2AuthCode) error
+	// Get returns and removes the specified authorization code, codes can be used only once
+	Get(code string) (*oauth2AuthCode, error)
+	Cleanup()
+}
+
+func newOAuth2CodeManager(isShared int) oauth2CodeManager {
+	if isShared == 1 {
+		logger.Info(logSender, "", "using provider OAuth2 authorization code manager")
+		return &dbOAuth2CodeManager{}
+	}
+	logger.Info(logSender, "", "using memory OAuth2 authorization code manager")
+	return &memoryOAuth2CodeManager{}
+}
+
+// oauth2AuthCode is an authorization code issued after the user consent
+type oauth2AuthCode struct {
+	Code          string    `json:"code"`
+	ClientID      string    `json:"client_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Username      string    `json:"username"`
+	Scope         string    `json:"scope"`
+	Path          string    `json:"path"`
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func (c *oauth2AuthCode) isExpired() bool {
+	return c.ExpiresAt.Before(time.Now().UTC())
+}
+
+// verifyCodeChallenge checks the PKCE code verifier, only the S256 method is supported
+func (c *oauth2AuthCode) verifyCodeChallenge(verifier string) bool {
+	if c.CodeChallenge == "" {
+		return true
+	}
+	if verifier == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(c.CodeChallenge)) == 1
+}
+
+type memoryOAuth2CodeManager struct {
+	codes sync.Map
+}
+
+func (m *memoryOAuth2CodeManager) Add(code *oauth2AuthCode) error {
+	m.codes.Store(code.Code, code)
+	return nil
+}
+
+func (m *memoryOAuth2CodeManager) Get(code string) (*oauth2AuthCode, error) {
+	c, ok := m.codes.LoadAndDelete(code)
+	if !ok {
+		return nil, util.NewRecordNotFoundError("authorization code not found")
+	}
+	return c.(*oauth2AuthCode), nil
+}
+
+func (m *memoryOAuth2CodeManager) Cleanup() {
+	m.codes.Range(func(key, value any) bool {
+		c, ok := value.(*oauth2AuthCode)
+		if !ok || c.isExpired() {
+			m.codes.Delete(key)
+		}
+		return true
+	})
+}
+
+type dbOAuth2CodeManager struct{}
+
+func (m *dbOAuth2CodeManager) Add(code *oauth2AuthCode) error {
+	session := dataprovider.Session{
+		Key:       code.Code,
+		Data:      code,
+		Type:      dataprovider.SessionTypeOAuth2Code,
+		Timestamp: util.GetTimeAsMsSinceEpoch(code.ExpiresAt),
+	}
+	return dataprovider.AddSharedSession(session)
+}
+
+func (m *dbOAuth2CodeManager) Get(code string) (*oauth2AuthCode, error) {
+	session, err := dataprovider.GetSharedSession(code)
+	if err != nil {
+		return nil, err
+	}
+	dataprovider.DeleteSharedSession(code) //nolint:errcheck
+	data, ok := session.Data.([]byte)
+	if !ok {
+		logger.Error(logSender, "", "invalid OAuth2 authorization code data type %T", session.Data)
+		return nil, errors.New("invalid authorization code data")
+	}
+	var c oauth2AuthCode
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (m *dbOAuth2CodeManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeOAuth2Code, time.Now()) //nolint:errcheck
+}
+
+type oauth2AuthorizeRequest struct {
+	ResponseType  string
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+	Path          string
+}
+
+func getOAuth2AuthorizeRequest(values url.Values) oauth2AuthorizeRequest {
+	req := oauth2AuthorizeRequest{
+		ResponseType:  values.Get("response_type"),
+		ClientID:      values.Get("client_id"),
+		RedirectURI:   values.Get("redirect_uri"),
+		Scope:         values.Get("scope"),
+		State:         values.Get("state"),
+		CodeChallenge: values.Get("code_challenge"),
+		Path:          util.CleanPath(values.Get("path")),
+	}
+	return req
+}
+
+// validate checks the authorization request. If the client or the redirect URI
+// are not valid, no redirect can be done and the returned OAuth2 error code is empty
+func (r *oauth2AuthorizeRequest) validate(values url.Values) (dataprovider.OAuth2App, string, error) {
+	app, err := dataprovider.OAuth2AppExists(r.ClientID)
+	if err != nil {
+		return app, "", fmt.Errorf("invalid client id %q: %w", r.ClientID, err)
+	}
+	if !app.HasRedirectURI(r.RedirectURI) {
+		return app, "", fmt.Errorf("invalid redirect URI %q", r.RedirectURI)
+	}
+	if r.ResponseType != "code" {
+		return app, oauth2ErrUnsupportedResponse, fmt.Errorf("unsupported response type %q", r.ResponseType)
+	}
+	if method := values.Get("code_challenge_method"); r.CodeChallenge != "" && method != "S256" {
+		return app, oauth2ErrInvalidRequest, fmt.Errorf("unsupported code challenge method %q", method)
+	}
+	scope, err := getOAuth2RequestedScope(&app, r.Scope)
+	if err != nil {
+		return app, oauth2ErrInvalidScope, err
+	}
+	r.Scope = scope
+	return app, "", nil
+}
+
+func (r *oauth2AuthorizeRequest) getRedirectURL(params url.Values) string {
+	if r.State != "" {
+		params.Set("state", r.State)
+	}
+	separator := "?"
+	if strings.Contains(r.RedirectURI, "?") {
+		separator = "&"
+	}
+	return r.RedirectURI + separator + params.Encode()
+}
+
+// getOAuth2RequestedScope returns the scope to grant. If both the read and
+// write scopes are requested the write one is returned. An empty scope means
+// read access
+func getOAuth2RequestedScope(app *dataprovider.OAuth2App, requested string) (string, error) {
+	scope := dataprovider.OAuth2ScopeFilesRead
+	for _, s := range strings.Fields(requested) {
+		switch s {
+		case dataprovider.OAuth2ScopeFilesRead:
+		case dataprovider.OAuth2ScopeFilesWrite:
+			scope = dataprovider.OAuth2ScopeFilesWrite
+		default:
+			return "", fmt.Errorf("unsupported scope %q", s)
+		}
+	}
+	if !app.HasScope(scope) {
+		return "", fmt.Errorf("scope %q is not allowed for this application", scope)
+	}
+	return scope, nil
+}
+
+type clientOAuth2ConsentPage struct {
+	baseClientPage
+	App     dataprovider.OAuth2App
+	Request oauth2AuthorizeRequest
+	IsWrite bool
+}
+
+func (s *httpdServer) renderClientOAuth2ConsentPage(w http.ResponseWriter, r *http.Request, app dataprovider.OAuth2App,
+	req oauth2AuthorizeRequest,
+) {
+	data := clientOAuth2ConsentPage{
+		baseClientPage: s.getBaseClientPageData(util.I18nOAuth2ConsentTitle, webClientOAuth2AuthorizePath, w, r),
+		App:            app,
+		Request:        req,
+		IsWrite:        req.Scope == dataprovider.OAuth2ScopeFilesWrite,
+	}
+	renderClientTemplate(w, templateClientOAuth2, data)
+}
+
+func (s *httpdServer) handleWebClientOAuth2Authorize(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	req := getOAuth2AuthorizeRequest(r.URL.Query())
+	app, errCode, err := req.validate(r.URL.Query())
+	if err != nil {
+		logger.Debug(logSender, "", "invalid OAuth2 authorize request: %v", err)
+		if errCode == "" {
+			s.renderClientBadRequestPage(w, r, util.NewI18nError(err, util.I18nErrorOAuth2InvalidRequest))
+			return
+		}
+		http.Redirect(w, r, req.getRedirectURL(url.Values{"error": {errCode}}), http.StatusFound)
+		return
+	}
+	s.renderClientOAuth2ConsentPage(w, r, app, req)
+}
+
+func (s *httpdServer) handleWebClientOAuth2AuthorizePost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderClientBadRequestPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidForm))
+		return
+	}
+	if err := verifyCSRFToken(r, s.csrfTokenAuth); err != nil {
+		s.renderClientForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
+		return
+	}
+	req := getOAuth2AuthorizeRequest(r.Form)
+	_, errCode, err := req.validate(r.Form)
+	if err != nil {
+		logger.Debug(logSender, "", "invalid OAuth2 authorize request: %v", err)
+		if errCode == "" {
+			s.renderClientBadRequestPage(w, r, util.NewI18nError(err, util.I18nErrorOAuth2InvalidRequest))
+			return
+		}
+		http.Redirect(w, r, req.getRedirectURL(url.Values{"error": {errCode}}), http.StatusFound)
+		return
+	}
+	if r.Form.Get("consent") != "allow" {
+		logger.Debug(logSender, "", "user %q denied access to OAuth2 app %q", claims.Username, req.ClientID)
+		http.Redirect(w, r, req.getRedirectURL(url.Values{"error": {oauth2ErrAccessDenied}}), http.StatusFound)
+		return
+	}
+	code := &oauth2AuthCode{
+		Code:          util.GenerateUniqueID() + util.GenerateUniqueID(),
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Username:      claims.Username,
+		Scope:         req.Scope,
+		Path:          req.Path,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauth2CodeValidity).UTC(),
+	}
+	if err := oauth2CodesMgr.Add(code); err != nil {
+		logger.Error(logSender, "", "unable to save OAuth2 authorization code: %v", err)
+		http.Redirect(w, r, req.getRedirectURL(url.Values{"error": {oauth2ErrServerError}}), http.StatusFound)
+		return
+	}
+	logger.Info(logSender, "", "user %q granted %q access to OAuth2 app %q, path %q", claims.Username, req.Scope,
+		req.ClientID, req.Path)
+	http.Redirect(w, r, req.getRedirectURL(url.Values{"code": {code.Code}}), http.StatusFound)
+}
+
+func sendOAuth2Error(w http.ResponseWriter, r *http.Request, errCode, description string, status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="SFTPGo OAuth2"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+	render.JSON(w, r.WithContext(ctx), map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+func getOAuth2ClientCredentials(r *http.Request) (string, string) {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		clientID, errID := url.QueryUnescape(clientID)
+		clientSecret, errSecret := url.QueryUnescape(clientSecret)
+		if errID == nil && errSecret == nil {
+			return clientID, clientSecret
+		}
+	}
+	return r.Form.Get("client_id"), r.Form.Get("client_secret")
+}
+
+// handleOAuth2Token exchanges an authorization code for a WebClient REST API
+// access token
+func (s *httpdServer) handleOAuth2Token(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	if err := r.ParseForm(); err != nil {
+		sendOAuth2Error(w, r, oauth2ErrInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if grantType := r.Form.Get("grant_type"); grantType != "authorization_code" {
+		sendOAuth2Error(w, r, oauth2ErrUnsupportedGrantType, fmt.Sprintf("unsupported grant type %q", grantType),
+			http.StatusBadRequest)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	clientID, clientSecret := getOAuth2ClientCredentials(r)
+	app, err := dataprovider.OAuth2AppExists(clientID)
+	if err == nil {
+		err = app.Authenticate(clientSecret)
+	}
+	if err != nil {
+		logger.Debug(logSender, "", "unable to authenticate OAuth2 client %q: %v", clientID, err)
+		handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+		sendOAuth2Error(w, r, oauth2ErrInvalidClient, "client authentication failed", http.StatusUnauthorized)
+		return
+	}
+	code, err := oauth2CodesMgr.Get(r.Form.Get("code"))
+	if err != nil || code.isExpired() || code.ClientID != app.ClientID ||
+		code.RedirectURI != r.Form.Get("redirect_uri") || !code.verifyCodeChallenge(r.Form.Get("code_verifier")) {
+		logger.Debug(logSender, "", "invalid authorization code for OAuth2 client %q, err: %v", clientID, err)
+		sendOAuth2Error(w, r, oauth2ErrInvalidGrant, "invalid authorization code", http.StatusBadRequest)
+		return
+	}
+	if !app.HasScope(code.Scope) {
+		sendOAuth2Error(w, r, oauth2ErrInvalidScope, fmt.Sprintf("scope %q is no longer allowed", code.Scope),
+			http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(code.Username, "")
+	if err == nil {
+		err = user.CheckLoginConditions()
+	}
+	if err == nil {
+		err = checkHTTPClientUser(&user, r, xid.New().String(), true)
+	}
+	if err != nil {
+		logger.Debug(logSender, "", "unable to issue OAuth2 token for user %q: %v", code.Username, err)
+		sendOAuth2Error(w, r, oauth2ErrInvalidGrant, "the user cannot be authenticated", http.StatusBadRequest)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:       user.Username,
+		Permissions:    getOAuth2WebClientPermissions(user.Filters.WebClient, code.Scope),
+		Signature:      user.GetSignature(),
+		Role:           user.Role,
+		Tenant:         user.Tenant,
+		OAuth2ClientID: app.ClientID,
+		OAuth2Scope:    code.Scope,
+		OAuth2Path:     code.Path,
+		duration:       app.GetTokenValidity(),
+	}
+	_, tokenString, err := c.createToken(s.tokenAuth, tokenAudienceAPIUser, ipAddr)
+	if err != nil {
+		sendOAuth2Error(w, r, oauth2ErrServerError, "unable to create the access token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	render.JSON(w, r, map[string]any{
+		"access_token": tokenString,
+		"token_type":   "Bearer",
+		"expires_in":   int(app.GetTokenValidity() / time.Second),
+		"scope":        code.Scope,
+	})
+}
+
+// getOAuth2WebClientPermissions returns the disabled WebClient features for
+// OAuth2 tokens. Shares cannot be managed and the read scope disables writes
+func getOAuth2WebClientPermissions(webClient []string, scope string) []string {
+	permissions := make([]string, 0, len(webClient)+2)
+	permissions = append(permissions, webClient...)
+	permissions = append(permissions, sdk.WebClientSharesDisabled)
+	if scope != dataprovider.OAuth2ScopeFilesWrite {
+		permissions = append(permissions, sdk.WebClientWriteDisabled)
+	}
+	return util.RemoveDuplicates(permissions, false)
+}
+
+// restrictUserForOAuth2 limits the user permissions based on the scope and
+// path granted to an OAuth2 token. Outside the granted path no permission is
+// allowed, the read scope only allows to list and download files
+func restrictUserForOAuth2(user *dataprovider.User, claims jwtTokenClaims) {
+	if claims.OAuth2ClientID == "" {
+		return
+	}
+	filterPerms := func(perms []string) []string {
+		if claims.OAuth2Scope == dataprovider.OAuth2ScopeFilesWrite {
+			return perms
+		}
+		result := make([]string, 0, 2)
+		for _, p := range []string{dataprovider.PermListItems, dataprovider.PermDownload} {
+			if util.Contains(perms, dataprovider.PermAny) || util.Contains(perms, p) {
+				result = append(result, p)
+			}
+		}
+		return result
+	}
+	restrictedPath := util.CleanPath(claims.OAuth2Path)
+	permissions := make(map[string][]string)
+	for dir, perms := range user.Permissions {
+		if restrictedPath == "/" || strings.HasPrefix(dir, restrictedPath+"/") {
+			permissions[dir] = filterPerms(perms)
+		} else {
+			permissions[dir] = []string{}
+		}
+	}
+	if restrictedPath != "/" {
+		permissions[restrictedPath] = filterPerms(user.GetPermissionsForPath(restrictedPath))
+	}
+	user.Permissions = permissions
+}
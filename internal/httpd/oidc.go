@@ -33,6 +33,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 const (
@@ -62,6 +63,13 @@ type OIDCTokenVerifier interface {
 
 // OIDC defines the OpenID Connect configuration
 type OIDC struct {
+	// Name identifies an additional OpenID provider. It is required for the providers
+	// defined in "oidc_providers", it is displayed on the login pages and used
+	// to select the provider to authenticate with
+	Name string `json:"name" mapstructure:"name"`
+	// Email domains, for example "example.com", routed to this provider. Users can enter
+	// their email address on the login page to be redirected to the matching provider
+	Domains []string `json:"domains" mapstructure:"domains"`
 	// ClientID is the application's ID
 	ClientID string `json:"client_id" mapstructure:"client_id"`
 	// ClientSecret is the application's secret
@@ -96,6 +104,15 @@ type OIDC struct {
 	// It's intended for special cases where providers, such as Azure, use the "none"
 	// algorithm. Skipping the signature validation can cause security issues
 	InsecureSkipSignatureCheck bool `json:"insecure_skip_signature_check" mapstructure:"insecure_skip_signature_check"`
+	// Optional ID token claims field containing the user groups. It can be a string
+	// or a list of strings
+	GroupsField string `json:"groups_field" mapstructure:"groups_field"`
+	// Map the values of the groups claim to SFTPGo groups. The mapped groups are
+	// updated on each login, so changes on the identity provider take effect
+	// without manual user edits
+	GroupMappings []OIDCGroupMapping `json:"group_mappings" mapstructure:"group_mappings"`
+	// Provisioning allows to automatically create the missing Web Client users
+	Provisioning OIDCProvisioning `json:"provisioning" mapstructure:"provisioning"`
 	// Debug enables the OIDC debug mode. In debug mode, the received id_token will be logged
 	// at the debug level
 	Debug             bool `json:"debug" mapstructure:"debug"`
@@ -123,6 +140,15 @@ func (o *OIDC) getForcedRole(audience string) string {
 	return ""
 }
 
+func (o *OIDC) hasDomain(domain string) bool {
+	for _, d := range o.Domains {
+		if strings.EqualFold(strings.TrimSpace(d), domain) {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *OIDC) getRedirectURL() string {
 	url := o.RedirectBaseURL
 	if strings.HasSuffix(o.RedirectBaseURL, "/") {
@@ -146,6 +172,10 @@ func (o *OIDC) initialize() error {
 	if !util.Contains(o.Scopes, oidc.ScopeOpenID) {
 		return fmt.Errorf("oidc: required scope %q is not set", oidc.ScopeOpenID)
 	}
+	o.setGroupMappingDefaults()
+	if err := o.validateGroupMappings(); err != nil {
+		return err
+	}
 	if o.ClientSecretFile != "" {
 		secret, err := util.ReadConfigFromFile(o.ClientSecretFile, configurationDir)
 		if err != nil {
@@ -199,6 +229,7 @@ type oidcPendingAuth struct {
 	Nonce    string        `json:"nonce"`
 	Audience tokenAudience `json:"audience"`
 	IssuedAt int64         `json:"issued_at"`
+	Provider string        `json:"provider,omitempty"`
 }
 
 func newOIDCPendingAuth(audience tokenAudience) oidcPendingAuth {
@@ -221,11 +252,22 @@ type oidcToken struct {
 	Username             string          `json:"username"`
 	Permissions          []string        `json:"permissions"`
 	HideUserPageSections int             `json:"hide_user_page_sections,omitempty"`
-	TokenRole            string          `json:"token_role,omitempty"` // SFTPGo role name
-	Role                 any             `json:"role"`                 // oidc user role: SFTPGo user or admin
+	TokenRole            string          `json:"token_role,omitempty"`   // SFTPGo role name
+	TokenTenant          string          `json:"token_tenant,omitempty"` // SFTPGo tenant name
+	Role                 any             `json:"role"`                   // oidc user role: SFTPGo user or admin
 	CustomFields         *map[string]any `json:"custom_fields,omitempty"`
 	Cookie               string          `json:"cookie"`
 	UsedAt               int64           `json:"used_at"`
+	Protocol             string          `json:"protocol,omitempty"`     // empty for OIDC
+	SAMLNameID           string          `json:"saml_name_id,omitempty"` // SAML subject, used for single logout
+	Provider             string          `json:"provider,omitempty"`     // OIDC provider name, empty for the default one
+}
+
+func (t *oidcToken) getProtocol() string {
+	if t.Protocol == "" {
+		return common.ProtocolOIDC
+	}
+	return t.Protocol
 }
 
 func (t *oidcToken) parseClaims(claims map[string]any, usernameField, roleField string, customFields []string,
@@ -368,6 +410,9 @@ func (t *oidcToken) refresh(ctx context.Context, config OAuth2Config, verifier O
 	}
 	logger.Debug(logSender, "", "oidc token refreshed for user %q, cookie %q", t.Username, t.Cookie)
 	oidcMgr.addToken(*t)
+	if !t.isAdmin() {
+		vfs.SetWebIdentityToken(t.Username, rawIDToken, idToken.Expiry)
+	}
 
 	return nil
 }
@@ -383,6 +428,7 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 		}
 		t.Permissions = admin.Permissions
 		t.TokenRole = admin.Role
+		t.TokenTenant = admin.Tenant
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
 		return nil
 	}
@@ -398,6 +444,7 @@ func (t *oidcToken) refreshUser(r *http.Request) error {
 	}
 	t.Permissions = user.Filters.WebClient
 	t.TokenRole = user.Role
+	t.TokenTenant = user.Tenant
 	return nil
 }
 
@@ -406,7 +453,7 @@ func (t *oidcToken) getUser(r *http.Request) error {
 	params := common.EventParams{
 		Name:      t.Username,
 		IP:        ipAddr,
-		Protocol:  common.ProtocolOIDC,
+		Protocol:  t.getProtocol(),
 		Timestamp: time.Now().UnixNano(),
 		Status:    1,
 	}
@@ -428,6 +475,7 @@ func (t *oidcToken) getUser(r *http.Request) error {
 		}
 		t.Permissions = admin.Permissions
 		t.TokenRole = admin.Role
+		t.TokenTenant = admin.Tenant
 		t.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
 		dataprovider.UpdateAdminLastLogin(admin)
 		common.DelayLogin(nil)
@@ -439,13 +487,13 @@ func (t *oidcToken) getUser(r *http.Request) error {
 		return err
 	}
 	if user == nil {
-		u, err := dataprovider.GetUserAfterIDPAuth(t.Username, ipAddr, common.ProtocolOIDC, t.CustomFields)
+		u, err := dataprovider.GetUserAfterIDPAuth(t.Username, ipAddr, t.getProtocol(), t.CustomFields)
 		if err != nil {
 			return err
 		}
 		user = &u
 	}
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolOIDC); err != nil {
+	if err := common.Config.ExecutePostConnectHook(ipAddr, t.getProtocol()); err != nil {
 		updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, err)
 		return fmt.Errorf("access denied: %w", err)
 	}
@@ -453,7 +501,7 @@ func (t *oidcToken) getUser(r *http.Request) error {
 		updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, err)
 		return err
 	}
-	connectionID := fmt.Sprintf("%s_%s", common.ProtocolOIDC, xid.New().String())
+	connectionID := fmt.Sprintf("%s_%s", t.getProtocol(), xid.New().String())
 	if err := checkHTTPClientUser(user, r, connectionID, true); err != nil {
 		updateLoginMetrics(user, dataprovider.LoginMethodIDP, ipAddr, err)
 		return err
@@ -469,6 +517,7 @@ func (t *oidcToken) getUser(r *http.Request) error {
 	dataprovider.UpdateLastLogin(user)
 	t.Permissions = user.Filters.WebClient
 	t.TokenRole = user.Role
+	t.TokenTenant = user.Tenant
 	return nil
 }
 
@@ -494,12 +543,26 @@ func (s *httpdServer) validateOIDCToken(w http.ResponseWriter, r *http.Request,
 		doRedirect()
 		return oidcToken{}, errInvalidToken
 	}
+	if token.isExpired() && token.Protocol == common.ProtocolSAML {
+		logger.Debug(logSender, "", "saml session associated with cookie %q is expired", token.Cookie)
+		oidcMgr.removeToken(token.Cookie)
+		setFlashMessage(w, r, newFlashMessage("Your SAML session is expired, please log-in again", util.I18nSAMLSessionExpired))
+		doRedirect()
+		return oidcToken{}, errInvalidToken
+	}
 	if token.isExpired() {
 		logger.Debug(logSender, "", "oidc token associated with cookie %q is expired", token.Cookie)
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
-		if err = token.refresh(ctx, s.binding.OIDC.oauth2Config, s.binding.OIDC.getVerifier(ctx), r); err != nil {
+		provider := s.binding.getOIDC(token.Provider)
+		if provider == nil {
+			logger.Debug(logSender, "", "oidc provider %q associated with cookie %q not found", token.Provider, token.Cookie)
+			err = errors.New("oidc provider not found")
+		} else {
+			err = token.refresh(ctx, provider.oauth2Config, provider.getVerifier(ctx), r)
+		}
+		if err != nil {
 			setFlashMessage(w, r, newFlashMessage("Your OpenID token is expired, please log-in again", util.I18nOIDCTokenExpired))
 			doRedirect()
 			return oidcToken{}, errInvalidToken
@@ -547,6 +610,7 @@ func (s *httpdServer) oidcTokenAuthenticator(audience tokenAudience) func(next h
 				Username:             token.Username,
 				Permissions:          token.Permissions,
 				Role:                 token.TokenRole,
+				Tenant:               token.TokenTenant,
 				HideUserPageSections: token.HideUserPageSections,
 			}
 			_, tokenString, err := jwtTokenClaims.createToken(s.tokenAuth, audience, util.GetIPFromRemoteAddress(r.RemoteAddr))
@@ -575,15 +639,38 @@ func (s *httpdServer) handleWebClientOIDCLogin(w http.ResponseWriter, r *http.Re
 	s.oidcLoginRedirect(w, r, tokenAudienceWebClient)
 }
 
+// oidcLoginRedirect redirects to the OpenID provider selected using the "provider" query
+// parameter or, if an email address is provided, to the provider configured for its domain
 func (s *httpdServer) oidcLoginRedirect(w http.ResponseWriter, r *http.Request, audience tokenAudience) {
+	var provider *OIDC
+	var opts []oauth2.AuthCodeOption
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if email != "" {
+		provider = s.binding.getOIDCForEmail(email)
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", email))
+	} else {
+		provider = s.binding.getOIDC(r.URL.Query().Get("provider"))
+	}
+	if provider == nil || !provider.isEnabled() || (audience == tokenAudienceWebAdmin && !provider.hasRoles()) {
+		logger.Debug(logSender, "", "no oidc provider found, selector %q, email %q",
+			r.URL.Query().Get("provider"), email)
+		setFlashMessage(w, r, newFlashMessage("No OpenID provider found", util.I18nOIDCProviderNotFound))
+		if audience == tokenAudienceWebAdmin {
+			http.Redirect(w, r, webAdminLoginPath, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, webClientLoginPath, http.StatusFound)
+		return
+	}
 	pendingAuth := newOIDCPendingAuth(audience)
+	pendingAuth.Provider = provider.Name
 	oidcMgr.addPendingAuth(pendingAuth)
-	http.Redirect(w, r, s.binding.OIDC.oauth2Config.AuthCodeURL(pendingAuth.State,
-		oidc.Nonce(pendingAuth.Nonce)), http.StatusFound)
+	opts = append(opts, oidc.Nonce(pendingAuth.Nonce))
+	http.Redirect(w, r, provider.oauth2Config.AuthCodeURL(pendingAuth.State, opts...), http.StatusFound)
 }
 
-func (s *httpdServer) debugTokenClaims(claims map[string]any, rawIDToken string) {
-	if s.binding.OIDC.Debug {
+func (s *httpdServer) debugTokenClaims(provider *OIDC, claims map[string]any, rawIDToken string) {
+	if provider.Debug {
 		if claims == nil {
 			logger.Debug(logSender, "", "raw id token %q", rawIDToken)
 		} else {
@@ -603,6 +690,13 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	oidcMgr.removePendingAuth(state)
+	provider := s.binding.getOIDC(authReq.Provider)
+	if provider == nil || !provider.isEnabled() {
+		logger.Debug(logSender, "", "oidc provider %q not found", authReq.Provider)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(errors.New("oidc provider not found"), util.I18nInvalidAuth), "")
+		return
+	}
 
 	doRedirect := func() {
 		if authReq.Audience == tokenAudienceWebAdmin {
@@ -612,13 +706,13 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, webClientLoginPath, http.StatusFound)
 	}
 	doLogout := func(rawIDToken string) {
-		s.logoutFromOIDCOP(rawIDToken)
+		s.logoutFromOIDCOP(provider, rawIDToken)
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
 	defer cancel()
 
-	oauth2Token, err := s.binding.OIDC.oauth2Config.Exchange(ctx, r.URL.Query().Get("code"))
+	oauth2Token, err := provider.oauth2Config.Exchange(ctx, r.URL.Query().Get("code"))
 	if err != nil {
 		logger.Debug(logSender, "", "failed to exchange oidc token: %v", err)
 		setFlashMessage(w, r, newFlashMessage("Failed to exchange OpenID token", util.I18nOIDCErrTokenExchange))
@@ -632,8 +726,8 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		doRedirect()
 		return
 	}
-	s.debugTokenClaims(nil, rawIDToken)
-	idToken, err := s.binding.OIDC.getVerifier(ctx).Verify(ctx, rawIDToken)
+	s.debugTokenClaims(provider, nil, rawIDToken)
+	idToken, err := provider.getVerifier(ctx).Verify(ctx, rawIDToken)
 	if err != nil {
 		logger.Debug(logSender, "", "failed to verify oidc token: %v", err)
 		setFlashMessage(w, r, newFlashMessage("Failed to verify OpenID token", util.I18nOIDCTokenInvalid))
@@ -658,7 +752,7 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		doLogout(rawIDToken)
 		return
 	}
-	s.debugTokenClaims(claims, rawIDToken)
+	s.debugTokenClaims(provider, claims, rawIDToken)
 	token := oidcToken{
 		AccessToken:  oauth2Token.AccessToken,
 		TokenType:    oauth2Token.TokenType,
@@ -666,12 +760,13 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		IDToken:      rawIDToken,
 		Nonce:        idToken.Nonce,
 		Cookie:       xid.New().String(),
+		Provider:     provider.Name,
 	}
 	if !oauth2Token.Expiry.IsZero() {
 		token.ExpiresAt = util.GetTimeAsMsSinceEpoch(oauth2Token.Expiry)
 	}
-	err = token.parseClaims(claims, s.binding.OIDC.UsernameField, s.binding.OIDC.RoleField,
-		s.binding.OIDC.CustomFields, s.binding.OIDC.getForcedRole(authReq.Audience))
+	err = token.parseClaims(claims, provider.UsernameField, provider.RoleField,
+		provider.CustomFields, provider.getForcedRole(authReq.Audience))
 	if err != nil {
 		logger.Debug(logSender, "", "unable to parse oidc token claims: %v", err)
 		setFlashMessage(w, r, newFlashMessage(fmt.Sprintf("Unable to parse OpenID token claims: %v", err), util.I18nOIDCTokenInvalid))
@@ -702,7 +797,10 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
-	err = token.getUser(r)
+	err = provider.provisionUser(&token, claims, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err == nil {
+		err = token.getUser(r)
+	}
 	if err != nil {
 		logger.Debug(logSender, "", "unable to get the sftpgo user associated with oidc token: %v", err)
 		setFlashMessage(w, r, newFlashMessage("Unable to get the user associated with the OpenID token", util.I18nOIDCErrGetUser))
@@ -710,6 +808,10 @@ func (s *httpdServer) handleOIDCRedirect(w http.ResponseWriter, r *http.Request)
 		doLogout(rawIDToken)
 		return
 	}
+	if !token.isAdmin() {
+		// the ID token can be used to assume IAM roles with web identity
+		vfs.SetWebIdentityToken(token.Username, rawIDToken, idToken.Expiry)
+	}
 
 	loginOIDCUser(w, r, token)
 }
@@ -736,27 +838,40 @@ func loginOIDCUser(w http.ResponseWriter, r *http.Request, token oidcToken) {
 	http.Redirect(w, r, webClientFilesPath, http.StatusFound)
 }
 
-func (s *httpdServer) logoutOIDCUser(w http.ResponseWriter, r *http.Request) {
+// logoutOIDCUser terminates the OIDC or SAML session, if any. For SAML sessions
+// the identity provider logout URL is returned, if available
+func (s *httpdServer) logoutOIDCUser(w http.ResponseWriter, r *http.Request) string {
+	var logoutURL string
 	if oidcKey, ok := r.Context().Value(oidcTokenKey).(string); ok {
 		removeOIDCCookie(w, r)
 		token, err := oidcMgr.getToken(oidcKey)
 		if err == nil {
-			s.logoutFromOIDCOP(token.IDToken)
+			if token.Protocol == common.ProtocolSAML {
+				logoutURL = s.getSAMLLogoutURL(token)
+			} else {
+				if !token.isAdmin() {
+					vfs.RemoveWebIdentityToken(token.Username, token.IDToken)
+				}
+				if provider := s.binding.getOIDC(token.Provider); provider != nil {
+					s.logoutFromOIDCOP(provider, token.IDToken)
+				}
+			}
 		}
 		oidcMgr.removeToken(oidcKey)
 	}
+	return logoutURL
 }
 
-func (s *httpdServer) logoutFromOIDCOP(idToken string) {
-	if s.binding.OIDC.providerLogoutURL == "" {
+func (s *httpdServer) logoutFromOIDCOP(provider *OIDC, idToken string) {
+	if provider.providerLogoutURL == "" {
 		logger.Debug(logSender, "", "oidc: provider logout URL not set, unable to logout from the OP")
 		return
 	}
-	go s.doOIDCFromLogout(idToken)
+	go s.doOIDCFromLogout(provider.providerLogoutURL, idToken)
 }
 
-func (s *httpdServer) doOIDCFromLogout(idToken string) {
-	logoutURL, err := url.Parse(s.binding.OIDC.providerLogoutURL)
+func (s *httpdServer) doOIDCFromLogout(providerLogoutURL, idToken string) {
+	logoutURL, err := url.Parse(providerLogoutURL)
 	if err != nil {
 		logger.Warn(logSender, "", "oidc: unable to parse logout URL: %v", err)
 		return
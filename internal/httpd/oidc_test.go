@@ -16,10 +16,18 @@ package httpd
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/fs"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -27,11 +35,13 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/crewjam/saml"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -48,6 +58,14 @@ import (
 
 const (
 	oidcMockAddr = "127.0.0.1:11111"
+	// identity provider metadata used to test the SAML service provider
+	samlTestIDPMetadata = `<?xml version="1.0" encoding="UTF-8"?>
+<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com/metadata">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <SingleLogoutService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/slo"/>
+    <SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>
+  </IDPSSODescriptor>
+</EntityDescriptor>`
 )
 
 type mockTokenSource struct {
@@ -847,11 +865,9 @@ func TestSkipOIDCAuth(t *testing.T) {
 func TestOIDCLogoutErrors(t *testing.T) {
 	server := getTestOIDCServer()
 	assert.Empty(t, server.binding.OIDC.providerLogoutURL)
-	server.logoutFromOIDCOP("")
-	server.binding.OIDC.providerLogoutURL = "http://foo\x7f.com/"
-	server.doOIDCFromLogout("")
-	server.binding.OIDC.providerLogoutURL = "http://127.0.0.1:11234"
-	server.doOIDCFromLogout("")
+	server.logoutFromOIDCOP(&server.binding.OIDC, "")
+	server.doOIDCFromLogout("http://foo\x7f.com/", "")
+	server.doOIDCFromLogout("http://127.0.0.1:11234", "")
 }
 
 func TestOIDCToken(t *testing.T) {
@@ -1737,6 +1753,494 @@ func TestDbOIDCManager(t *testing.T) {
 	}
 }
 
+func TestOIDCMultipleProviders(t *testing.T) {
+	oidcMgr, ok := oidcMgr.(*memoryOIDCManager)
+	require.True(t, ok)
+
+	server := getTestOIDCServer()
+	server.binding.OIDC.Domains = []string{"example.com"}
+	partner := server.binding.OIDC
+	partner.Name = "Partner"
+	partner.Domains = []string{" Partner.com "}
+	partner.RoleField = ""
+	partner.Scopes = nil
+	server.binding.OIDCProviders = []OIDC{partner}
+	err := server.binding.initializeOIDC()
+	require.NoError(t, err)
+	assert.Equal(t, []string{oidc.ScopeOpenID, "profile", "email"}, server.binding.OIDCProviders[0].Scopes)
+	assert.True(t, server.binding.hasOIDC())
+	assert.True(t, server.binding.hasOIDCRoles())
+	assert.True(t, server.binding.hasOIDCDomains(true))
+	assert.Equal(t, &server.binding.OIDC, server.binding.getOIDC(""))
+	assert.Equal(t, &server.binding.OIDCProviders[0], server.binding.getOIDC("Partner"))
+	assert.Nil(t, server.binding.getOIDC("missing"))
+	assert.Equal(t, &server.binding.OIDC, server.binding.getOIDCForEmail("user@EXAMPLE.com"))
+	assert.Equal(t, &server.binding.OIDCProviders[0], server.binding.getOIDCForEmail("user@partner.com"))
+	assert.Nil(t, server.binding.getOIDCForEmail("user@unknown.com"))
+	assert.Nil(t, server.binding.getOIDCForEmail("user"))
+
+	server.binding.OIDC.oauth2Config = &mockOAuth2Config{
+		authCodeURL: "/default",
+	}
+	server.binding.OIDCProviders[0].oauth2Config = &mockOAuth2Config{
+		authCodeURL: "/partner",
+		err:         common.ErrGenericFailure,
+	}
+	server.initializeRouter()
+
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, webClientLoginPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), webClientOIDCLoginPath+"?provider=Partner")
+	assert.Contains(t, rr.Body.String(), `name="email"`)
+	// the partner provider has no roles, so it is not available for the WebAdmin UI
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webAdminLoginPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "?provider=Partner")
+
+	for _, tc := range []struct {
+		query    string
+		location string
+		provider string
+	}{
+		{query: "", location: "/default", provider: ""},
+		{query: "?provider=Partner", location: "/partner", provider: "Partner"},
+		{query: "?email=user%40partner.com", location: "/partner", provider: "Partner"},
+		{query: "?email=user%40example.com", location: "/default", provider: ""},
+		{query: "?email=user%40unknown.com", location: webClientLoginPath},
+		{query: "?provider=missing", location: webClientLoginPath},
+	} {
+		rr = httptest.NewRecorder()
+		r, err = http.NewRequest(http.MethodGet, webClientOIDCLoginPath+tc.query, nil)
+		require.NoError(t, err)
+		server.router.ServeHTTP(rr, r)
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Equal(t, tc.location, rr.Header().Get("Location"), tc.query)
+		if tc.location == webClientLoginPath {
+			assert.Len(t, oidcMgr.pendingAuths, 0)
+			continue
+		}
+		require.Len(t, oidcMgr.pendingAuths, 1)
+		for k, v := range oidcMgr.pendingAuths {
+			assert.Equal(t, tc.provider, v.Provider)
+			oidcMgr.removePendingAuth(k)
+		}
+	}
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webAdminOIDCLoginPath+"?provider=Partner", nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webAdminLoginPath, rr.Header().Get("Location"))
+	// the redirect must use the provider that started the authentication
+	authReq := newOIDCPendingAuth(tokenAudienceWebClient)
+	authReq.Provider = "Partner"
+	oidcMgr.addPendingAuth(authReq)
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webOIDCRedirectPath+"?state="+authReq.State, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+	// removed provider
+	authReq = newOIDCPendingAuth(tokenAudienceWebClient)
+	authReq.Provider = "missing"
+	oidcMgr.addPendingAuth(authReq)
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webOIDCRedirectPath+"?state="+authReq.State, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	// expired token for a removed provider
+	token := oidcToken{
+		Cookie:    xid.New().String(),
+		Username:  "user",
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Minute)),
+		Provider:  "missing",
+		Role:      "user",
+	}
+	oidcMgr.addToken(token)
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	require.NoError(t, err)
+	r.Header.Set("Cookie", fmt.Sprintf("%v=%v", oidcCookieKey, token.Cookie))
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+	oidcMgr.removeToken(token.Cookie)
+
+	server.binding.OIDCProviders = append(server.binding.OIDCProviders, partner)
+	err = server.binding.initializeOIDC()
+	assert.ErrorContains(t, err, "duplicate provider name")
+	server.binding.OIDCProviders[1].Name = ""
+	err = server.binding.initializeOIDC()
+	assert.ErrorContains(t, err, "has no name")
+	server.binding.OIDCProviders[1].Name = "other"
+	server.binding.OIDCProviders[1].UsernameField = ""
+	err = server.binding.initializeOIDC()
+	assert.ErrorContains(t, err, "unable to initialize provider \"other\"")
+}
+
+func TestOIDCGroupsProvisioning(t *testing.T) {
+	config := OIDC{
+		GroupsField: "groups",
+		GroupMappings: []OIDCGroupMapping{
+			{Claim: "sales", Group: "oidc_sales", Type: sdk.GroupTypePrimary},
+			{Claim: "dev", Group: "oidc_dev"},
+			{Claim: "ops", Group: "oidc_ops", Type: sdk.GroupTypePrimary},
+		},
+		Provisioning: OIDCProvisioning{
+			Enabled: true,
+			HomeDir: filepath.Join(os.TempDir(), "%username%"),
+			Groups:  []OIDCGroupMapping{{Group: "oidc_default", Type: sdk.GroupTypeMembership}},
+		},
+	}
+	config.setGroupMappingDefaults()
+	assert.Equal(t, sdk.GroupTypeSecondary, config.GroupMappings[1].Type)
+	assert.NoError(t, config.validateGroupMappings())
+	config.GroupsField = ""
+	assert.Error(t, config.validateGroupMappings())
+	config.GroupsField = "groups"
+	config.GroupMappings[1].Type = 5
+	assert.Error(t, config.validateGroupMappings())
+	config.GroupMappings[1].Type = sdk.GroupTypeSecondary
+	config.GroupMappings[1].Claim = ""
+	assert.Error(t, config.validateGroupMappings())
+	config.GroupMappings[1].Claim = "dev"
+
+	assert.Equal(t, []string{"sales"}, config.getClaimGroups(map[string]any{"groups": "sales"}))
+	assert.Equal(t, []string{"sales", "dev"}, config.getClaimGroups(map[string]any{"groups": []any{"sales", 1, "dev"}}))
+	assert.Nil(t, config.getClaimGroups(map[string]any{"groups": 1}))
+	assert.Nil(t, config.getClaimGroups(map[string]any{}))
+	// only one primary group is allowed, unmapped groups are preserved
+	groups := config.getUserGroups([]sdk.GroupMapping{
+		{Name: "other", Type: sdk.GroupTypeSecondary},
+		{Name: "oidc_dev", Type: sdk.GroupTypeSecondary},
+	}, []string{"sales", "ops"}, false)
+	assert.Equal(t, []sdk.GroupMapping{
+		{Name: "other", Type: sdk.GroupTypeSecondary},
+		{Name: "oidc_sales", Type: sdk.GroupTypePrimary},
+	}, groups)
+
+	for _, name := range []string{"oidc_sales", "oidc_dev", "oidc_ops", "oidc_default"} {
+		group := dataprovider.Group{
+			BaseGroup: sdk.BaseGroup{
+				Name: name,
+			},
+		}
+		err := dataprovider.AddGroup(&group, "", "", "")
+		require.NoError(t, err)
+	}
+	username := "oidc_provisioned_user"
+	token := oidcToken{
+		Username: username,
+		Role:     "user",
+	}
+	err := config.provisionUser(&token, map[string]any{"groups": []any{"sales", "dev"}}, "127.0.0.1")
+	require.NoError(t, err)
+	user, err := dataprovider.UserExists(username, "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(os.TempDir(), username), user.HomeDir)
+	assert.Equal(t, []string{dataprovider.PermAny}, user.Permissions["/"])
+	assert.True(t, isSameGroupMappings([]sdk.GroupMapping{
+		{Name: "oidc_sales", Type: sdk.GroupTypePrimary},
+		{Name: "oidc_dev", Type: sdk.GroupTypeSecondary},
+		{Name: "oidc_default", Type: sdk.GroupTypeMembership},
+	}, user.Groups), "%+v", user.Groups)
+	// the groups change on the identity provider, the default groups are preserved
+	err = config.provisionUser(&token, map[string]any{"groups": "ops"}, "127.0.0.1")
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(username, "")
+	require.NoError(t, err)
+	assert.True(t, isSameGroupMappings([]sdk.GroupMapping{
+		{Name: "oidc_ops", Type: sdk.GroupTypePrimary},
+		{Name: "oidc_default", Type: sdk.GroupTypeMembership},
+	}, user.Groups), "%+v", user.Groups)
+	// admins are never provisioned
+	token.Role = adminRoleFieldValue
+	token.Username = "oidc_provisioned_admin"
+	err = config.provisionUser(&token, nil, "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = dataprovider.UserExists(token.Username, "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	// provisioning disabled
+	token.Role = "user"
+	config.Provisioning.Enabled = false
+	err = config.provisionUser(&token, nil, "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = dataprovider.UserExists(token.Username, "")
+	assert.ErrorIs(t, err, util.ErrNotFound)
+	// invalid template
+	config.Provisioning.Enabled = true
+	config.Provisioning.HomeDir = "relative"
+	err = config.provisionUser(&token, nil, "127.0.0.1")
+	assert.Error(t, err)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	for _, name := range []string{"oidc_sales", "oidc_dev", "oidc_ops", "oidc_default"} {
+		err = dataprovider.DeleteGroup(name, "", "", "")
+		assert.NoError(t, err)
+	}
+}
+
+func TestOIDCProvisioningFromClaims(t *testing.T) {
+	config := OIDC{
+		Provisioning: OIDCProvisioning{
+			Enabled:         true,
+			HomeDir:         filepath.Join(os.TempDir(), "%username%"),
+			HomeDirField:    "home",
+			QuotaSize:       1024,
+			QuotaFiles:      10,
+			QuotaSizeField:  "quota.size",
+			QuotaFilesField: "quota.files",
+		},
+	}
+	username := "oidc_claims_user"
+	user := config.getProvisionedUser(username, nil)
+	assert.Equal(t, filepath.Join(os.TempDir(), username), user.HomeDir)
+	assert.Equal(t, int64(1024), user.QuotaSize)
+	assert.Equal(t, 10, user.QuotaFiles)
+	// invalid claims are ignored
+	user = config.getProvisionedUser(username, map[string]any{
+		"home": "relative",
+		"quota": map[string]any{
+			"size":  "invalid",
+			"files": float64(-1),
+		},
+	})
+	assert.Equal(t, filepath.Join(os.TempDir(), username), user.HomeDir)
+	assert.Equal(t, int64(1024), user.QuotaSize)
+	assert.Equal(t, 10, user.QuotaFiles)
+
+	homeDir := filepath.Join(os.TempDir(), "oidc_claims_home")
+	token := oidcToken{
+		Username: username,
+		Role:     "user",
+	}
+	err := config.provisionUser(&token, map[string]any{
+		"home": homeDir,
+		"quota": map[string]any{
+			"size":  "10 MB",
+			"files": "100",
+		},
+	}, "127.0.0.1")
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(username, "")
+	require.NoError(t, err)
+	assert.Equal(t, homeDir, user.HomeDir)
+	assert.Equal(t, int64(10000000), user.QuotaSize)
+	assert.Equal(t, 100, user.QuotaFiles)
+
+	_, err = getClaimAsInt(true, false)
+	assert.Error(t, err)
+	val, err := getClaimAsInt(float64(5), false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), val)
+	_, err = getClaimAsInt("10 MB", false)
+	assert.Error(t, err)
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestSAMLServiceProvider(t *testing.T) {
+	certPath, keyPath := writeSAMLTestKeyPair(t)
+	metadataPath := filepath.Join(t.TempDir(), "idp.xml")
+	err := os.WriteFile(metadataPath, []byte(samlTestIDPMetadata), 0600)
+	require.NoError(t, err)
+
+	s := SAML{}
+	assert.NoError(t, s.initialize())
+	assert.False(t, s.isEnabled())
+	s.IDPMetadata = metadataPath
+	assert.Error(t, s.initialize())
+	s.BaseURL = "http://127.0.0.1:8081"
+	assert.Error(t, s.initialize())
+	s.CertificateFile = certPath
+	s.KeyFile = certPath
+	assert.Error(t, s.initialize())
+	s.KeyFile = keyPath
+	s.SignRequests = true
+	s.RoleAttribute = "role"
+	assert.NoError(t, s.initialize())
+	assert.True(t, s.isEnabled())
+	assert.True(t, s.hasRoles())
+	assert.Equal(t, samlNameIDKey, s.getUsernameAttribute())
+	assert.Equal(t, "http://127.0.0.1:8081"+webSAMLACSPath, s.sp.AcsURL.String())
+
+	entities := `<EntitiesDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata">` +
+		strings.TrimPrefix(samlTestIDPMetadata, `<?xml version="1.0" encoding="UTF-8"?>`) + `</EntitiesDescriptor>`
+	entity, err := parseSAMLMetadata([]byte(entities))
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com/metadata", entity.EntityID)
+	_, err = parseSAMLMetadata([]byte("not xml"))
+	assert.Error(t, err)
+
+	assertion := &saml.Assertion{
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Value: "user@example.com"},
+		},
+		AttributeStatements: []saml.AttributeStatement{
+			{
+				Attributes: []saml.Attribute{
+					{
+						Name:         "urn:oid:0.9.2342.19200300.100.1.1",
+						FriendlyName: "uid",
+						Values:       []saml.AttributeValue{{Value: "user1"}},
+					},
+					{
+						Name:   "groups",
+						Values: []saml.AttributeValue{{Value: "g1"}, {Value: "g2"}},
+					},
+				},
+			},
+		},
+	}
+	claims := s.getClaims(assertion)
+	assert.Equal(t, "user@example.com", claims[samlNameIDKey])
+	assert.Equal(t, "user1", claims["uid"])
+	assert.Equal(t, "user1", claims["urn:oid:0.9.2342.19200300.100.1.1"])
+	assert.Equal(t, []any{"g1", "g2"}, claims["groups"])
+
+	var b bytes.Buffer
+	fw, err := flate.NewWriter(&b, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-123" Version="2.0"></samlp:LogoutRequest>`))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+	requestID, err := getSAMLLogoutRequestID(base64.StdEncoding.EncodeToString(b.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "id-123", requestID)
+	_, err = getSAMLLogoutRequestID("")
+	assert.Error(t, err)
+	_, err = getSAMLLogoutRequestID("invalid base64")
+	assert.Error(t, err)
+
+	server := &httpdServer{
+		binding: Binding{
+			EnableWebAdmin:      true,
+			EnableWebClient:     true,
+			EnabledLoginMethods: 48,
+			SAML:                s,
+		},
+		enableWebAdmin:  true,
+		enableWebClient: true,
+	}
+	assert.NoError(t, server.binding.checkLoginMethods())
+	server.binding.EnabledLoginMethods = 32
+	assert.Error(t, server.binding.checkLoginMethods())
+	server.binding.EnabledLoginMethods = 48
+	server.initializeRouter()
+
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, webSAMLMetadataPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "AssertionConsumerService")
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webClientLoginPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), webClientSAMLLoginPath)
+
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webAdminSAMLLoginPath, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "idp.example.com", location.Host)
+	relayState := location.Query().Get("RelayState")
+	authReq, err := oidcMgr.getPendingAuth(relayState)
+	assert.NoError(t, err)
+	assert.Equal(t, tokenAudienceWebAdmin, authReq.Audience)
+	// an invalid response must redirect to the login page
+	form := make(url.Values)
+	form.Set("RelayState", relayState)
+	form.Set("SAMLResponse", "invalid")
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodPost, webSAMLACSPath, bytes.NewBuffer([]byte(form.Encode())))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webAdminLoginPath, rr.Header().Get("Location"))
+	_, err = oidcMgr.getPendingAuth(relayState)
+	assert.Error(t, err)
+	// unsolicited responses are not allowed
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodPost, webSAMLACSPath, bytes.NewBuffer([]byte(form.Encode())))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	// logout request from the identity provider
+	token := oidcToken{
+		Cookie:     xid.New().String(),
+		Username:   "user1",
+		Protocol:   common.ProtocolSAML,
+		SAMLNameID: "user1",
+		Role:       "user",
+	}
+	oidcMgr.addToken(token)
+	form = make(url.Values)
+	form.Set("SAMLRequest", base64.StdEncoding.EncodeToString(b.Bytes()))
+	form.Set("RelayState", "state")
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webSAMLSLOPath+"?"+form.Encode(), nil)
+	require.NoError(t, err)
+	r.Header.Set("Cookie", fmt.Sprintf("%v=%v", oidcCookieKey, token.Cookie))
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Contains(t, rr.Header().Get("Location"), "https://idp.example.com/slo")
+	_, err = oidcMgr.getToken(token.Cookie)
+	assert.Error(t, err)
+	assert.Contains(t, server.getSAMLLogoutURL(token), "https://idp.example.com/slo")
+	token.SAMLNameID = ""
+	assert.Empty(t, server.getSAMLLogoutURL(token))
+	// logout response
+	rr = httptest.NewRecorder()
+	r, err = http.NewRequest(http.MethodGet, webSAMLSLOPath+"?SAMLResponse=invalid&RelayState="+samlRelayStateAdmin, nil)
+	require.NoError(t, err)
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webAdminLoginPath, rr.Header().Get("Location"))
+}
+
+func writeSAMLTestKeyPair(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sftpgo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "saml.crt")
+	keyPath := filepath.Join(dir, "saml.key")
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600)
+	require.NoError(t, err)
+	return certPath, keyPath
+}
+
 func getTestOIDCServer() *httpdServer {
 	return &httpdServer{
 		binding: Binding{
@@ -0,0 +1,312 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// OIDCGroupMapping maps a value of the OpenID groups claim to an SFTPGo group
+type OIDCGroupMapping struct {
+	// Value of the groups claim
+	Claim string `json:"claim" mapstructure:"claim"`
+	// SFTPGo group name
+	Group string `json:"group" mapstructure:"group"`
+	// Group type: 1 primary, 2 secondary, 3 membership only. Default: 2
+	Type int `json:"type" mapstructure:"type"`
+}
+
+// OIDCProvisioning defines the template used to automatically create the Web Client
+// users authenticated using OpenID that do not exist in the data provider
+type OIDCProvisioning struct {
+	// Set to true to create the missing users
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Home directory for the created users, the "%username%" placeholder is replaced
+	// with the username. If empty, the "users_base_dir" data provider setting is used.
+	// A primary group can override the home directory
+	HomeDir string `json:"home_dir" mapstructure:"home_dir"`
+	// Optional ID token claims field containing the home directory. If the claim
+	// contains an absolute path it overrides "home_dir"
+	HomeDirField string `json:"home_dir_field" mapstructure:"home_dir_field"`
+	// Quota for the created users. 0 means unlimited
+	QuotaSize  int64 `json:"quota_size" mapstructure:"quota_size"`
+	QuotaFiles int   `json:"quota_files" mapstructure:"quota_files"`
+	// Optional ID token claims fields containing the quota. If found, they override
+	// the static values above. The size can be a number of bytes or a string
+	// like "10GB"
+	QuotaSizeField  string `json:"quota_size_field" mapstructure:"quota_size_field"`
+	QuotaFilesField string `json:"quota_files_field" mapstructure:"quota_files_field"`
+	// Permissions for the root directory. Default: "*"
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+	// Groups assigned to all the created users, in addition to the mapped ones
+	Groups []OIDCGroupMapping `json:"groups" mapstructure:"groups"`
+}
+
+func (o *OIDC) validateGroupMappings() error {
+	mappings := make([]OIDCGroupMapping, 0, len(o.GroupMappings)+len(o.Provisioning.Groups))
+	mappings = append(mappings, o.GroupMappings...)
+	mappings = append(mappings, o.Provisioning.Groups...)
+	for idx := range mappings {
+		m := &mappings[idx]
+		if m.Group == "" {
+			return errors.New("oidc: group mappings require an SFTPGo group")
+		}
+		if m.Type < sdk.GroupTypePrimary || m.Type > sdk.GroupTypeMembership {
+			return fmt.Errorf("oidc: invalid type %d for group %q", m.Type, m.Group)
+		}
+	}
+	for _, m := range o.GroupMappings {
+		if m.Claim == "" {
+			return fmt.Errorf("oidc: the mapping for group %q has no claim value", m.Group)
+		}
+	}
+	if len(o.GroupMappings) > 0 && o.GroupsField == "" {
+		return errors.New("oidc: group mappings require a groups field")
+	}
+	return nil
+}
+
+func (o *OIDC) setGroupMappingDefaults() {
+	for idx := range o.GroupMappings {
+		if o.GroupMappings[idx].Type == 0 {
+			o.GroupMappings[idx].Type = sdk.GroupTypeSecondary
+		}
+	}
+	for idx := range o.Provisioning.Groups {
+		if o.Provisioning.Groups[idx].Type == 0 {
+			o.Provisioning.Groups[idx].Type = sdk.GroupTypeSecondary
+		}
+	}
+}
+
+func (o *OIDC) isMappedGroup(name string) bool {
+	for _, m := range o.GroupMappings {
+		if m.Group == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getClaimGroups returns the values of the groups claim. Both string and list
+// of strings claims are supported
+func (o *OIDC) getClaimGroups(claims map[string]any) []string {
+	val, ok := getOIDCFieldFromClaims(claims, o.GroupsField)
+	if !ok {
+		return nil
+	}
+	switch v := val.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	case []string:
+		return v
+	default:
+		return nil
+	}
+}
+
+// getUserGroups returns the groups for the specified user based on the claims.
+// The groups not managed by the mappings are preserved
+func (o *OIDC) getUserGroups(current []sdk.GroupMapping, claimGroups []string, isNewUser bool) []sdk.GroupMapping {
+	groups := make([]sdk.GroupMapping, 0, len(current))
+	hasPrimaryGroup := false
+	addGroup := func(name string, groupType int) {
+		for _, g := range groups {
+			if g.Name == name {
+				return
+			}
+		}
+		if groupType == sdk.GroupTypePrimary {
+			if hasPrimaryGroup {
+				return
+			}
+			hasPrimaryGroup = true
+		}
+		groups = append(groups, sdk.GroupMapping{
+			Name: name,
+			Type: groupType,
+		})
+	}
+
+	for _, g := range current {
+		if !o.isMappedGroup(g.Name) {
+			addGroup(g.Name, g.Type)
+		}
+	}
+	for _, m := range o.GroupMappings {
+		if util.Contains(claimGroups, m.Claim) {
+			addGroup(m.Group, m.Type)
+		}
+	}
+	if isNewUser {
+		for _, m := range o.Provisioning.Groups {
+			addGroup(m.Group, m.Type)
+		}
+	}
+	return groups
+}
+
+func (o *OIDC) hasProvisioning() bool {
+	return o.Provisioning.Enabled || len(o.GroupMappings) > 0
+}
+
+func (o *OIDC) getProvisionedUser(username string, claims map[string]any) dataprovider.User {
+	permissions := o.Provisioning.Permissions
+	if len(permissions) == 0 {
+		permissions = []string{dataprovider.PermAny}
+	}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    username,
+			Status:      1,
+			HomeDir:     strings.ReplaceAll(o.Provisioning.HomeDir, "%username%", username),
+			Permissions: map[string][]string{"/": permissions},
+			QuotaSize:   o.Provisioning.QuotaSize,
+			QuotaFiles:  o.Provisioning.QuotaFiles,
+		},
+	}
+	if val, ok := getOIDCFieldFromClaims(claims, o.Provisioning.HomeDirField); ok {
+		if homeDir, ok := val.(string); ok && filepath.IsAbs(homeDir) {
+			user.HomeDir = filepath.Clean(homeDir)
+		} else {
+			logger.Warn(logSender, "", "oidc: invalid home dir claim %q for user %q: %v",
+				o.Provisioning.HomeDirField, username, val)
+		}
+	}
+	if val, ok := getOIDCFieldFromClaims(claims, o.Provisioning.QuotaSizeField); ok {
+		if size, err := getClaimAsInt(val, true); err == nil {
+			user.QuotaSize = size
+		} else {
+			logger.Warn(logSender, "", "oidc: invalid quota size claim %q for user %q: %v",
+				o.Provisioning.QuotaSizeField, username, err)
+		}
+	}
+	if val, ok := getOIDCFieldFromClaims(claims, o.Provisioning.QuotaFilesField); ok {
+		if files, err := getClaimAsInt(val, false); err == nil {
+			user.QuotaFiles = int(files)
+		} else {
+			logger.Warn(logSender, "", "oidc: invalid quota files claim %q for user %q: %v",
+				o.Provisioning.QuotaFilesField, username, err)
+		}
+	}
+	return user
+}
+
+// provisionUser creates the Web Client user associated with the token, if it
+// does not exist, and updates the mapped groups based on the token claims
+func (o *OIDC) provisionUser(token *oidcToken, claims map[string]any, ip string) error {
+	if !o.hasProvisioning() || token.isAdmin() {
+		return nil
+	}
+	claimGroups := o.getClaimGroups(claims)
+	user, err := dataprovider.UserExists(token.Username, "")
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if !o.Provisioning.Enabled {
+			return nil
+		}
+		user = o.getProvisionedUser(token.Username, claims)
+		user.Groups = o.getUserGroups(nil, claimGroups, true)
+		if err := dataprovider.AddUser(&user, dataprovider.ActionExecutorSystem, ip, ""); err != nil {
+			logger.Warn(logSender, "", "oidc: unable to provision user %q: %v", token.Username, err)
+			return err
+		}
+		logger.Info(logSender, "", "oidc: user %q provisioned, groups: %+v", token.Username, user.Groups)
+		return nil
+	}
+	if len(o.GroupMappings) == 0 {
+		return nil
+	}
+	groups := o.getUserGroups(user.Groups, claimGroups, false)
+	if isSameGroupMappings(groups, user.Groups) {
+		return nil
+	}
+	user.Groups = groups
+	if err := dataprovider.UpdateUser(&user, dataprovider.ActionExecutorSystem, ip, ""); err != nil {
+		logger.Warn(logSender, "", "oidc: unable to update groups for user %q: %v", token.Username, err)
+		return err
+	}
+	logger.Debug(logSender, "", "oidc: groups for user %q updated: %+v", token.Username, user.Groups)
+	return nil
+}
+
+// getClaimAsInt converts a numeric claim to an integer. If isSize is true,
+// strings like "10GB" are also accepted
+func getClaimAsInt(val any, isSize bool) (int64, error) {
+	var result int64
+	switch v := val.(type) {
+	case float64:
+		result = int64(v)
+	case int64:
+		result = v
+	case int:
+		result = int64(v)
+	case string:
+		var err error
+		if isSize {
+			result, err = util.ParseBytes(v)
+		} else {
+			result, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported claim type %T", val)
+	}
+	if result < 0 {
+		return 0, fmt.Errorf("invalid negative value %d", result)
+	}
+	return result, nil
+}
+
+func isSameGroupMappings(groups, other []sdk.GroupMapping) bool {
+	if len(groups) != len(other) {
+		return false
+	}
+	for _, g := range groups {
+		found := false
+		for _, o := range other {
+			if g.Name == o.Name && g.Type == o.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+// Copyright (C) 2024 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	previewTypeImage = "image"
+	previewTypePDF   = "pdf"
+	previewTypeVideo = "video"
+	previewTypeAudio = "audio"
+	previewTypeText  = "text"
+)
+
+var (
+	previewImageExtensions = []string{".jpeg", ".jpg", ".png", ".gif", ".webp", ".bmp", ".svg", ".ico", ".avif"}
+	previewVideoExtensions = []string{".mp4", ".mov", ".webm", ".ogv", ".m4v"}
+	previewAudioExtensions = []string{".mp3", ".wav", ".ogg", ".oga", ".opus", ".flac", ".m4a", ".aac"}
+	previewTextExtensions  = []string{".txt", ".log", ".md", ".csv", ".json", ".yaml", ".yml", ".toml", ".ini",
+		".conf", ".cfg", ".env", ".properties", ".xml", ".html", ".htm", ".css", ".scss", ".js", ".mjs", ".ts",
+		".jsx", ".tsx", ".vue", ".go", ".mod", ".py", ".rb", ".php", ".pl", ".lua", ".java", ".kt", ".scala",
+		".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".rs", ".swift", ".sh", ".bash", ".zsh", ".ps1", ".bat",
+		".sql", ".r", ".dart", ".diff", ".patch", ".proto", ".tf", ".dockerfile"}
+	previewTextFilenames = []string{"readme", "license", "dockerfile", "makefile", "pkgbuild", "vagrantfile"}
+)
+
+type previewPage struct {
+	baseClientPage
+	CurrentDir  string
+	Path        string
+	Name        string
+	PreviewType string
+	URL         string
+	Data        string
+}
+
+// getPreviewType returns the preview type for the specified file name or
+// an empty string if the file cannot be previewed
+func getPreviewType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case util.Contains(previewImageExtensions, ext):
+		return previewTypeImage
+	case ext == ".pdf":
+		return previewTypePDF
+	case util.Contains(previewVideoExtensions, ext):
+		return previewTypeVideo
+	case util.Contains(previewAudioExtensions, ext):
+		return previewTypeAudio
+	case util.Contains(previewTextExtensions, ext):
+		return previewTypeText
+	case util.Contains(previewTextFilenames, strings.ToLower(path.Base(name))):
+		return previewTypeText
+	}
+	if strings.HasPrefix(mime.TypeByExtension(ext), "text/") {
+		return previewTypeText
+	}
+	return ""
+}
+
+func getPreviewURL(name string) string {
+	return fmt.Sprintf("%s?path=%s&_=%d", webClientGetPreviewPath, url.QueryEscape(name), time.Now().UTC().Unix())
+}
+
+func (s *httpdServer) renderPreviewPage(w http.ResponseWriter, r *http.Request, name, previewType, fileData string) {
+	data := previewPage{
+		baseClientPage: s.getBaseClientPageData(util.I18nPreviewTitle, webClientPreviewPath, w, r),
+		CurrentDir:     path.Dir(name),
+		Path:           name,
+		Name:           path.Base(name),
+		PreviewType:    previewType,
+		Data:           fileData,
+	}
+	if previewType != previewTypeText {
+		data.URL = getPreviewURL(name)
+	}
+
+	renderClientTemplate(w, templateClientPreview, data)
+}
+
+func (s *httpdServer) handleClientPreview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		s.renderClientMessagePage(w, r, util.I18nError500Title, getRespStatus(err),
+			util.NewI18nError(err, util.I18nErrorGetUser), "")
+		return
+	}
+
+	connID := xid.New().String()
+	protocol := getProtocolFromRequest(r)
+	connectionID := fmt.Sprintf("%v_%v", protocol, connID)
+	if err := checkHTTPClientUser(&user, r, connectionID, false); err != nil {
+		s.renderClientForbiddenPage(w, r, err)
+		return
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nError429Title, http.StatusTooManyRequests,
+			util.NewI18nError(err, util.I18nError429Message), "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		status := getRespStatus(err)
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, status, util.NewI18nError(err, i18nFsMsg(status)), "")
+		return
+	}
+	if info.IsDir() {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, http.StatusBadRequest,
+			util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("The path %q does not point to a file", name)),
+				util.I18nErrorPreviewDir,
+			), "")
+		return
+	}
+	previewType := getPreviewType(name)
+	if previewType == "" {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, http.StatusBadRequest,
+			util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("Preview is not supported for %q", name)),
+				util.I18nErrorPreviewUnsupported,
+			), "")
+		return
+	}
+	if err := connection.checkReadAllowed(name, connection.GetTransferQuota()); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, getMappedStatusCode(err), err, "")
+		return
+	}
+	if previewType != previewTypeText {
+		s.renderPreviewPage(w, r, name, previewType, "")
+		return
+	}
+	if info.Size() > httpdMaxEditFileSize {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, http.StatusBadRequest,
+			util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("The file size %v for %q exceeds the maximum allowed size",
+					util.ByteCountIEC(info.Size()), name)),
+				util.I18nErrorPreviewSize,
+			), "")
+		return
+	}
+
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	reader, err := connection.getFileReader(name, 0, r.Method)
+	if err != nil {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, getRespStatus(err),
+			util.NewI18nError(err, util.I18nError500Message), "")
+		return
+	}
+	defer reader.Close()
+
+	var b bytes.Buffer
+	_, err = io.Copy(&b, reader)
+	if err != nil {
+		s.renderClientMessagePage(w, r, util.I18nErrorPreviewTitle, getRespStatus(err),
+			util.NewI18nError(err, util.I18nError500Message), "")
+		return
+	}
+
+	s.renderPreviewPage(w, r, name, previewType, b.String())
+}
+
+func (s *httpdServer) handleClientGetPreview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Please set the path to a valid file, %q is a directory", name),
+			http.StatusBadRequest)
+		return
+	}
+	switch getPreviewType(name) {
+	case previewTypeImage, previewTypePDF, previewTypeVideo, previewTypeAudio:
+	default:
+		sendAPIResponse(w, r, errors.New("unsupported file type"),
+			fmt.Sprintf("Preview is not supported for %q", name), http.StatusBadRequest)
+		return
+	}
+	// previewed files are served inline, make sure the browser does not
+	// execute active contents, for example scripts embedded in SVG images
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if status, err := downloadFile(w, r, connection, name, info, true, nil); err != nil && status > 0 {
+		sendAPIResponse(w, r, err, http.StatusText(status), status)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+var (
+	errBindingRemoved = errors.New("binding removed reloading the configuration")
+	runningServers    = &serversRegistry{
+		servers: make(map[string]*runningServer),
+	}
+)
+
+// runningServer is the HTTP server started for a binding. The router can be
+// replaced, reloading the configuration, without affecting the active requests
+type runningServer struct {
+	binding Binding
+	server  atomic.Pointer[http.Server]
+	router  atomic.Pointer[chi.Mux]
+	removed atomic.Bool
+}
+
+func (s *runningServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.Load().ServeHTTP(w, r)
+}
+
+func (s *runningServer) shutdown() {
+	s.removed.Store(true)
+	server := s.server.Load()
+	if server == nil {
+		return
+	}
+	go func() {
+		// Shutdown closes the listener and waits for the active requests, including transfers
+		err := server.Shutdown(context.Background())
+		logger.Info(logSender, "", "server for binding %q stopped, err: %v", s.binding.GetAddress(), err)
+	}()
+}
+
+type serversRegistry struct {
+	sync.Mutex
+	servers   map[string]*runningServer
+	newServer func(b Binding) *httpdServer
+}
+
+func (r *serversRegistry) setServerBuilder(fn func(b Binding) *httpdServer) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.newServer = fn
+}
+
+func (r *serversRegistry) add(b Binding) *runningServer {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.addLocked(b)
+}
+
+func (r *serversRegistry) addLocked(b Binding) *runningServer {
+	s := &runningServer{
+		binding: b,
+	}
+	r.servers[b.GetAddress()] = s
+	return s
+}
+
+func (r *serversRegistry) startLocked(b Binding) {
+	server := r.newServer(b)
+	s := r.addLocked(b)
+
+	go func() {
+		err := server.listenAndServe(s)
+		if !errors.Is(err, errBindingRemoved) {
+			logger.Error(logSender, "", "server for binding %q stopped, err: %v", b.GetAddress(), err)
+		}
+	}()
+}
+
+// hasSameListener returns true if the listener settings are the same.
+// Changing these settings requires a restart
+func (b *Binding) hasSameListener(other *Binding) bool {
+	if b.Address != other.Address || b.Port != other.Port || b.EnableHTTPS != other.EnableHTTPS {
+		return false
+	}
+	if b.CertificateFile != other.CertificateFile || b.CertificateKeyFile != other.CertificateKeyFile {
+		return false
+	}
+	if b.MinTLSVersion != other.MinTLSVersion || b.ClientAuthType != other.ClientAuthType {
+		return false
+	}
+	return strings.Join(b.TLSCipherSuites, ",") == strings.Join(other.TLSCipherSuites, ",") &&
+		strings.Join(b.Protocols, ",") == strings.Join(other.Protocols, ",")
+}
+
+func (b *Binding) prepareForReload() error {
+	if err := b.parseAllowedProxy(); err != nil {
+		return err
+	}
+	b.checkBranding()
+	b.Security.updateProxyHeaders()
+	if err := b.initializeOIDC(); err != nil {
+		return err
+	}
+	if err := b.SAML.initialize(); err != nil {
+		return err
+	}
+	return b.checkLoginMethods()
+}
+
+// ReloadBindings applies the configured bindings to the running HTTP server
+// without interrupting the active connections. New bindings are started,
+// removed bindings are gracefully stopped and the existing bindings get a new
+// router with the updated settings, for example branding, login methods and OpenID
+// Connect configuration. Changes to the listener settings, such as TLS, are
+// only applied after a restart
+func (c *Conf) ReloadBindings() error {
+	if err := c.loadFromProvider(); err != nil {
+		return err
+	}
+	runningServers.Lock()
+	defer runningServers.Unlock()
+
+	if runningServers.newServer == nil {
+		return errors.New("the HTTP server is not running")
+	}
+	bindings := make(map[string]Binding)
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.prepareForReload(); err != nil {
+			return err
+		}
+		bindings[binding.GetAddress()] = binding
+	}
+	for address, s := range runningServers.servers {
+		if _, ok := bindings[address]; !ok {
+			logger.Info(logSender, "", "binding %q removed, stopping the server", address)
+			delete(runningServers.servers, address)
+			s.shutdown()
+		}
+	}
+	for address, binding := range bindings {
+		s, ok := runningServers.servers[address]
+		if !ok {
+			logger.Info(logSender, "", "binding %q added, starting the server", address)
+			runningServers.startLocked(binding)
+			continue
+		}
+		if !s.binding.hasSameListener(&binding) {
+			logger.Warn(logSender, "", "the listener settings for binding %q changed, a restart is required to apply them",
+				address)
+			continue
+		}
+		server := runningServers.newServer(binding)
+		server.initializeRouter()
+		s.binding = binding
+		s.router.Store(server.router)
+		logger.Debug(logSender, "", "binding %q reloaded", address)
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/crewjam/saml"
+	"github.com/rs/xid"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	samlNameIDKey          = "NameID"
+	samlRelayStateAdmin    = "admin"
+	samlRelayStateClient   = "client"
+	maxSAMLMetadataSize    = 2 * 1048576
+	maxSAMLLogoutReqLength = 256 * 1024
+)
+
+// SAML defines the SAML 2.0 service provider configuration
+type SAML struct {
+	// URL or path to the identity provider metadata. Relative paths are resolved
+	// against the configuration directory. Leave empty to disable SAML
+	IDPMetadata string `json:"idp_metadata" mapstructure:"idp_metadata"`
+	// Service provider entity ID. If empty the metadata URL will be used
+	EntityID string `json:"entity_id" mapstructure:"entity_id"`
+	// BaseURL is the externally reachable base URL for this binding, for example
+	// "https://sftpgo.example.com". The SAML endpoints "/web/saml/metadata",
+	// "/web/saml/acs" and "/web/saml/slo" will be added to this base URL, adding
+	// also the "web_root" if configured
+	BaseURL string `json:"base_url" mapstructure:"base_url"`
+	// Service provider certificate and RSA private key used to sign requests
+	// and decrypt assertions
+	CertificateFile string `json:"certificate_file" mapstructure:"certificate_file"`
+	KeyFile         string `json:"key_file" mapstructure:"key_file"`
+	// Sign the authentication and logout requests
+	SignRequests bool `json:"sign_requests" mapstructure:"sign_requests"`
+	// Assertion attribute to map to the SFTPGo username. If empty the subject NameID is used
+	UsernameAttribute string `json:"username_attribute" mapstructure:"username_attribute"`
+	// Optional assertion attribute to map to a SFTPGo role. If the attribute value is
+	// "admin" the authenticated user is mapped to an SFTPGo admin
+	RoleAttribute string `json:"role_attribute" mapstructure:"role_attribute"`
+	// If set, the `RoleAttribute` is ignored and the SFTPGo role is assumed based on
+	// the login link used
+	ImplicitRoles bool `json:"implicit_roles" mapstructure:"implicit_roles"`
+	// Custom assertion attributes to pass to the pre-login hook
+	CustomFields []string `json:"custom_fields" mapstructure:"custom_fields"`
+	// Allow unsolicited responses, started from the identity provider
+	AllowIDPInitiated bool `json:"allow_idp_initiated" mapstructure:"allow_idp_initiated"`
+	// Debug enables the SAML debug mode. In debug mode, the received assertion
+	// attributes will be logged at the debug level
+	Debug bool `json:"debug" mapstructure:"debug"`
+	sp    *saml.ServiceProvider
+}
+
+func (s *SAML) isEnabled() bool {
+	return s.sp != nil
+}
+
+func (s *SAML) hasRoles() bool {
+	return s.isEnabled() && (s.RoleAttribute != "" || s.ImplicitRoles)
+}
+
+func (s *SAML) getForcedRole(audience string) string {
+	if !s.ImplicitRoles {
+		return ""
+	}
+	if audience == tokenAudienceWebAdmin {
+		return adminRoleFieldValue
+	}
+	return ""
+}
+
+func (s *SAML) getUsernameAttribute() string {
+	if s.UsernameAttribute == "" {
+		return samlNameIDKey
+	}
+	return s.UsernameAttribute
+}
+
+func (s *SAML) getURL(baseURL *url.URL, p string) url.URL {
+	u := *baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	return u
+}
+
+func (s *SAML) initialize() error {
+	s.sp = nil
+	if s.IDPMetadata == "" {
+		return nil
+	}
+	if s.BaseURL == "" {
+		return errors.New("saml: base URL cannot be empty")
+	}
+	baseURL, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return fmt.Errorf("saml: invalid base URL %q: %w", s.BaseURL, err)
+	}
+	if s.CertificateFile == "" || s.KeyFile == "" {
+		return errors.New("saml: certificate and key files are required")
+	}
+	keyPair, err := tls.LoadX509KeyPair(getConfigPath(s.CertificateFile, configurationDir),
+		getConfigPath(s.KeyFile, configurationDir))
+	if err != nil {
+		return fmt.Errorf("saml: unable to load the certificate: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("saml: unsupported private key type %T, only RSA keys are supported", keyPair.PrivateKey)
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("saml: unable to parse the certificate: %w", err)
+	}
+	idpMetadata, err := s.loadIDPMetadata()
+	if err != nil {
+		return err
+	}
+	sp := &saml.ServiceProvider{
+		EntityID:          s.EntityID,
+		Key:               key,
+		Certificate:       cert,
+		HTTPClient:        httpclient.GetHTTPClient(),
+		MetadataURL:       s.getURL(baseURL, webSAMLMetadataPath),
+		AcsURL:            s.getURL(baseURL, webSAMLACSPath),
+		SloURL:            s.getURL(baseURL, webSAMLSLOPath),
+		IDPMetadata:       idpMetadata,
+		AllowIDPInitiated: s.AllowIDPInitiated,
+		LogoutBindings:    []string{saml.HTTPRedirectBinding},
+	}
+	if s.SignRequests {
+		sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return errors.New("saml: the identity provider does not support the HTTP-Redirect binding")
+	}
+	logger.Debug(logSender, "", "saml: service provider initialized, metadata URL %q, ACS URL %q",
+		sp.MetadataURL.String(), sp.AcsURL.String())
+	s.sp = sp
+	return nil
+}
+
+func (s *SAML) loadIDPMetadata() (*saml.EntityDescriptor, error) {
+	var data []byte
+	if strings.HasPrefix(s.IDPMetadata, "http://") || strings.HasPrefix(s.IDPMetadata, "https://") {
+		resp, err := httpclient.Get(s.IDPMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("saml: unable to fetch the identity provider metadata: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("saml: unexpected status code %d fetching the identity provider metadata",
+				resp.StatusCode)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, maxSAMLMetadataSize))
+		if err != nil {
+			return nil, fmt.Errorf("saml: unable to read the identity provider metadata: %w", err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(getConfigPath(s.IDPMetadata, configurationDir))
+		if err != nil {
+			return nil, fmt.Errorf("saml: unable to read the identity provider metadata: %w", err)
+		}
+	}
+	return parseSAMLMetadata(data)
+}
+
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, entity); err == nil {
+		return entity, nil
+	}
+	// some identity providers return an EntitiesDescriptor
+	entities := &saml.EntitiesDescriptor{}
+	if err := xml.Unmarshal(data, entities); err != nil {
+		return nil, fmt.Errorf("saml: unable to parse the identity provider metadata: %w", err)
+	}
+	for idx := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[idx].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[idx], nil
+		}
+	}
+	return nil, errors.New("saml: no identity provider found in metadata")
+}
+
+// getClaims returns the assertion attributes in a format suitable for oidcToken.parseClaims.
+// Multi valued attributes are returned as slices
+func (s *SAML) getClaims(assertion *saml.Assertion) map[string]any {
+	claims := make(map[string]any)
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		claims[samlNameIDKey] = assertion.Subject.NameID.Value
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			values := make([]any, 0, len(attr.Values))
+			for _, v := range attr.Values {
+				values = append(values, v.Value)
+			}
+			var val any = values
+			if len(values) == 1 {
+				val = values[0]
+			}
+			claims[attr.Name] = val
+			if attr.FriendlyName != "" {
+				claims[attr.FriendlyName] = val
+			}
+		}
+	}
+	return claims
+}
+
+func (s *httpdServer) handleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	data, err := xml.MarshalIndent(s.binding.SAML.sp.Metadata(), "", "  ")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(data) //nolint:errcheck
+}
+
+func (s *httpdServer) handleWebAdminSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	s.samlLoginRedirect(w, r, tokenAudienceWebAdmin)
+}
+
+func (s *httpdServer) handleWebClientSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	s.samlLoginRedirect(w, r, tokenAudienceWebClient)
+}
+
+func (s *httpdServer) samlLoginRedirect(w http.ResponseWriter, r *http.Request, audience tokenAudience) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	sp := s.binding.SAML.sp
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		logger.Warn(logSender, "", "saml: unable to create the authentication request: %v", err)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusInternalServerError,
+			util.NewI18nError(err, util.I18nError500Message), "")
+		return
+	}
+	// the request ID is used as relay state and it is stored as pending auth so we can validate
+	// the response and restore the requested audience
+	pendingAuth := newOIDCPendingAuth(audience)
+	pendingAuth.State = req.ID
+	redirectURL, err := req.Redirect(pendingAuth.State, sp)
+	if err != nil {
+		logger.Warn(logSender, "", "saml: unable to create the redirect URL: %v", err)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusInternalServerError,
+			util.NewI18nError(err, util.I18nError500Message), "")
+		return
+	}
+	oidcMgr.addPendingAuth(pendingAuth)
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (s *httpdServer) handleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(err, util.I18nInvalidAuth), "")
+		return
+	}
+	var audience tokenAudience
+	var possibleRequestIDs []string
+	relayState := r.PostForm.Get("RelayState")
+	authReq, err := oidcMgr.getPendingAuth(relayState)
+	if err == nil {
+		oidcMgr.removePendingAuth(relayState)
+		audience = authReq.Audience
+		possibleRequestIDs = []string{authReq.State}
+	} else if !s.binding.SAML.AllowIDPInitiated {
+		logger.Debug(logSender, "", "saml: no pending authentication for relay state %q", relayState)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(errors.New("no pending authentication"), util.I18nInvalidAuth), "")
+		return
+	}
+
+	doRedirect := func() {
+		if audience == tokenAudienceWebAdmin {
+			http.Redirect(w, r, webAdminLoginPath, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, webClientLoginPath, http.StatusFound)
+	}
+
+	assertion, err := s.binding.SAML.sp.ParseResponse(r, possibleRequestIDs)
+	if err != nil {
+		var invalidResponseErr *saml.InvalidResponseError
+		if errors.As(err, &invalidResponseErr) {
+			logger.Debug(logSender, "", "saml: invalid response: %v", invalidResponseErr.PrivateErr)
+		} else {
+			logger.Debug(logSender, "", "saml: unable to parse the response: %v", err)
+		}
+		setFlashMessage(w, r, newFlashMessage("Invalid SAML response", util.I18nSAMLResponseInvalid))
+		doRedirect()
+		return
+	}
+	claims := s.binding.SAML.getClaims(assertion)
+	if s.binding.SAML.Debug {
+		logger.Debug(logSender, "", "saml: assertion %q, parsed attributes %+v", assertion.ID, claims)
+	}
+	token := oidcToken{
+		Protocol: common.ProtocolSAML,
+		Cookie:   xid.New().String(),
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		token.SAMLNameID = assertion.Subject.NameID.Value
+	}
+	for _, statement := range assertion.AuthnStatements {
+		token.SessionID = statement.SessionIndex
+		if statement.SessionNotOnOrAfter != nil {
+			token.ExpiresAt = util.GetTimeAsMsSinceEpoch(*statement.SessionNotOnOrAfter)
+		}
+	}
+	err = token.parseClaims(claims, s.binding.SAML.getUsernameAttribute(), s.binding.SAML.RoleAttribute,
+		s.binding.SAML.CustomFields, s.binding.SAML.getForcedRole(audience))
+	if err != nil {
+		logger.Debug(logSender, "", "saml: unable to parse the assertion attributes: %v", err)
+		setFlashMessage(w, r, newFlashMessage(fmt.Sprintf("Unable to parse SAML attributes: %v", err),
+			util.I18nSAMLResponseInvalid))
+		doRedirect()
+		return
+	}
+	switch audience {
+	case tokenAudienceWebAdmin:
+		if !token.isAdmin() {
+			logger.Debug(logSender, "", "saml: wrong role, the mapped user is not an SFTPGo admin")
+			setFlashMessage(w, r, newFlashMessage(
+				"Wrong SAML role, the logged in user is not an SFTPGo admin",
+				util.I18nSAMLInvalidRoleAdmin))
+			doRedirect()
+			return
+		}
+	case tokenAudienceWebClient:
+		if token.isAdmin() {
+			logger.Debug(logSender, "", "saml: wrong role, the mapped user is an SFTPGo admin")
+			setFlashMessage(w, r, newFlashMessage(
+				"Wrong SAML role, the logged in user is an SFTPGo admin",
+				util.I18nSAMLInvalidRoleUser,
+			))
+			doRedirect()
+			return
+		}
+	default:
+		// IdP initiated login, the role defines the audience
+		if token.isAdmin() {
+			audience = tokenAudienceWebAdmin
+		} else {
+			audience = tokenAudienceWebClient
+		}
+	}
+	if audience == tokenAudienceWebAdmin && !s.enableWebAdmin || audience == tokenAudienceWebClient && !s.enableWebClient {
+		logger.Debug(logSender, "", "saml: the web interface for audience %q is disabled", audience)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(errors.New("web interface disabled"), util.I18nInvalidAuth), "")
+		return
+	}
+	err = token.getUser(r)
+	if err != nil {
+		logger.Debug(logSender, "", "saml: unable to get the sftpgo user associated with the assertion: %v", err)
+		setFlashMessage(w, r, newFlashMessage("Unable to get the user associated with the SAML assertion",
+			util.I18nSAMLErrGetUser))
+		doRedirect()
+		return
+	}
+
+	loginOIDCUser(w, r, token)
+}
+
+// getSAMLLogoutURL returns the URL to use to logout from the identity provider, if supported
+func (s *httpdServer) getSAMLLogoutURL(token oidcToken) string {
+	if !s.binding.SAML.isEnabled() || token.SAMLNameID == "" {
+		return ""
+	}
+	sp := s.binding.SAML.sp
+	if sp.GetSLOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		logger.Debug(logSender, "", "saml: the identity provider does not support single logout")
+		return ""
+	}
+	relayState := samlRelayStateClient
+	if token.isAdmin() {
+		relayState = samlRelayStateAdmin
+	}
+	logoutURL, err := sp.MakeRedirectLogoutRequest(token.SAMLNameID, relayState)
+	if err != nil {
+		logger.Warn(logSender, "", "saml: unable to create the logout request: %v", err)
+		return ""
+	}
+	return logoutURL.String()
+}
+
+// handleSAMLSLO handles the logout responses for the logout requests sent by SFTPGo
+// and the logout requests started from the identity provider
+func (s *httpdServer) handleSAMLSLO(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
+	if err := r.ParseForm(); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(err, util.I18nInvalidAuth), "")
+		return
+	}
+	relayState := r.Form.Get("RelayState")
+	if r.Form.Get("SAMLResponse") != "" {
+		if err := s.binding.SAML.sp.ValidateLogoutResponseRequest(r); err != nil {
+			logger.Debug(logSender, "", "saml: invalid logout response: %v", err)
+		}
+		if relayState == samlRelayStateAdmin && s.enableWebAdmin {
+			http.Redirect(w, r, webAdminLoginPath, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, webClientLoginPath, http.StatusFound)
+		return
+	}
+	requestID, err := getSAMLLogoutRequestID(r.Form.Get("SAMLRequest"))
+	if err != nil {
+		logger.Debug(logSender, "", "saml: invalid logout request: %v", err)
+		s.renderClientMessagePage(w, r, util.I18nInvalidAuthReqTitle, http.StatusBadRequest,
+			util.NewI18nError(err, util.I18nInvalidAuth), "")
+		return
+	}
+	// the logout request is received through the user's browser, so we can only
+	// terminate the session associated with this browser, if any
+	if cookie, err := r.Cookie(oidcCookieKey); err == nil {
+		if token, err := oidcMgr.getToken(cookie.Value); err == nil && token.Protocol == common.ProtocolSAML {
+			oidcMgr.removeToken(token.Cookie)
+			logger.Debug(logSender, "", "saml: session for user %q terminated by the identity provider", token.Username)
+		}
+		removeOIDCCookie(w, r)
+	}
+	logoutURL, err := s.binding.SAML.sp.MakeRedirectLogoutResponse(requestID, relayState)
+	if err != nil {
+		logger.Warn(logSender, "", "saml: unable to create the logout response: %v", err)
+		http.Redirect(w, r, webClientLoginPath, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, logoutURL.String(), http.StatusFound)
+}
+
+func getSAMLLogoutRequestID(encoded string) (string, error) {
+	if encoded == "" {
+		return "", errors.New("no logout request")
+	}
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode the logout request: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxSAMLLogoutReqLength))
+	if err != nil {
+		return "", fmt.Errorf("unable to inflate the logout request: %w", err)
+	}
+	var req saml.LogoutRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return "", fmt.Errorf("unable to parse the logout request: %w", err)
+	}
+	if req.ID == "" {
+		return "", errors.New("the logout request has no ID")
+	}
+	return req.ID, nil
+}
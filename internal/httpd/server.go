@@ -96,10 +96,11 @@ func (s *httpdServer) setShared(value int) {
 	s.isShared = value
 }
 
-func (s *httpdServer) listenAndServe() error {
+func (s *httpdServer) listenAndServe(rs *runningServer) error {
 	s.initializeRouter()
+	rs.router.Store(s.router)
 	httpServer := &http.Server{
-		Handler:           s.router,
+		Handler:           rs,
 		ReadHeaderTimeout: 30 * time.Second,
 		ReadTimeout:       60 * time.Second,
 		WriteTimeout:      60 * time.Second,
@@ -126,9 +127,16 @@ func (s *httpdServer) listenAndServe() error {
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
-		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
-	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+	rs.server.Store(httpServer)
+	if rs.removed.Load() {
+		return errBindingRemoved
+	}
+	err := util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, httpServer.TLSConfig != nil, logSender)
+	if rs.removed.Load() {
+		return errBindingRemoved
+	}
+	return err
 }
 
 func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
@@ -176,7 +184,7 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, r *http.Reque
 		FormDisabled:   s.binding.isWebClientLoginFormDisabled(),
 		CheckRedirect:  true,
 	}
-	if next := r.URL.Query().Get("next"); strings.HasPrefix(next, webClientFilesPath) {
+	if next := r.URL.Query().Get("next"); isAllowedClientNextURL(next) {
 		data.CurrentURL += "?next=" + url.QueryEscape(next)
 	}
 	if s.binding.showAdminLoginURL() {
@@ -186,8 +194,11 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, r *http.Reque
 	if smtp.IsEnabled() && !data.FormDisabled {
 		data.ForgotPwdURL = webClientForgotPwdPath
 	}
-	if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
-		data.OpenIDLoginURL = webClientOIDCLoginPath
+	if !s.binding.isWebClientOIDCLoginDisabled() {
+		data.setOIDCProviders(&s.binding, webClientOIDCLoginPath, false)
+	}
+	if s.binding.SAML.isEnabled() && !s.binding.isWebClientSAMLLoginDisabled() {
+		data.SAMLLoginURL = webClientSAMLLoginPath
 	}
 	renderClientTemplate(w, templateCommonLogin, data)
 }
@@ -195,7 +206,10 @@ func (s *httpdServer) renderClientLoginPage(w http.ResponseWriter, r *http.Reque
 func (s *httpdServer) handleWebClientLogout(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	removeCookie(w, r, webBaseClientPath)
-	s.logoutOIDCUser(w, r)
+	if logoutURL := s.logoutOIDCUser(w, r); logoutURL != "" {
+		http.Redirect(w, r, logoutURL, http.StatusFound)
+		return
+	}
 
 	http.Redirect(w, r, webClientLoginPath, http.StatusFound)
 }
@@ -441,6 +455,11 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 			util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
 		return
 	}
+	if r.Form.Get("remember_device") != "" {
+		rememberDevice(w, r, s.csrfTokenAuth, user.Username,
+			getTrustedDeviceFingerprint(user.Filters.TOTPConfig.ConfigName, user.Filters.TOTPConfig.Secret.GetPayload()),
+			webBaseClientPath, false, mfa.GetTrustedDeviceMaxAge(user.Filters.TOTPConfig.ConfigName))
+	}
 	connectionID := fmt.Sprintf("%s_%s", getProtocolFromRequest(r), xid.New().String())
 	s.loginUser(w, r, &user, connectionID, ipAddr, true, s.renderClientTwoFactorPage)
 }
@@ -553,6 +572,11 @@ func (s *httpdServer) handleWebAdminTwoFactorPost(w http.ResponseWriter, r *http
 		s.renderTwoFactorPage(w, r, util.NewI18nError(dataprovider.ErrInvalidCredentials, util.I18nErrorInvalidCredentials))
 		return
 	}
+	if r.Form.Get("remember_device") != "" {
+		rememberDevice(w, r, s.csrfTokenAuth, admin.Username,
+			getTrustedDeviceFingerprint(admin.Filters.TOTPConfig.ConfigName, admin.Filters.TOTPConfig.Secret.GetPayload()),
+			webBaseAdminPath, true, mfa.GetTrustedDeviceMaxAge(admin.Filters.TOTPConfig.ConfigName))
+	}
 	s.loginAdmin(w, r, &admin, true, s.renderTwoFactorPage, ipAddr)
 }
 
@@ -601,8 +625,11 @@ func (s *httpdServer) renderAdminLoginPage(w http.ResponseWriter, r *http.Reques
 	if smtp.IsEnabled() && !data.FormDisabled {
 		data.ForgotPwdURL = webAdminForgotPwdPath
 	}
-	if s.binding.OIDC.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
-		data.OpenIDLoginURL = webAdminOIDCLoginPath
+	if !s.binding.isWebAdminOIDCLoginDisabled() {
+		data.setOIDCProviders(&s.binding, webAdminOIDCLoginPath, true)
+	}
+	if s.binding.SAML.hasRoles() && !s.binding.isWebAdminSAMLLoginDisabled() {
+		data.SAMLLoginURL = webAdminSAMLLoginPath
 	}
 	renderAdminTemplate(w, templateCommonLogin, data)
 }
@@ -620,7 +647,10 @@ func (s *httpdServer) handleWebAdminLogin(w http.ResponseWriter, r *http.Request
 func (s *httpdServer) handleWebAdminLogout(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	removeCookie(w, r, webBaseAdminPath)
-	s.logoutOIDCUser(w, r)
+	if logoutURL := s.logoutOIDCUser(w, r); logoutURL != "" {
+		http.Redirect(w, r, logoutURL, http.StatusFound)
+		return
+	}
 
 	http.Redirect(w, r, webAdminLoginPath, http.StatusFound)
 }
@@ -736,14 +766,15 @@ func (s *httpdServer) loginUser(
 		Permissions:                user.Filters.WebClient,
 		Signature:                  user.GetSignature(),
 		Role:                       user.Role,
+		Tenant:                     user.Tenant,
 		MustSetTwoFactorAuth:       user.MustSetSecondFactor(),
 		MustChangePassword:         user.MustChangePassword(),
 		RequiredTwoFactorProtocols: user.Filters.TwoFactorAuthProtocols,
 	}
 
 	audience := tokenAudienceWebClient
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) &&
-		user.CanManageMFA() && !isSecondFactorAuth {
+	if user.IsSecondFactorRequired(common.ProtocolHTTP, ipAddr) &&
+		user.CanManageMFA() && !isSecondFactorAuth && !s.isTrustedUserDevice(w, r, user) {
 		audience = tokenAudienceWebClientPartial
 	}
 
@@ -757,7 +788,7 @@ func (s *httpdServer) loginUser(
 	invalidateToken(r, !isSecondFactorAuth)
 	if audience == tokenAudienceWebClientPartial {
 		redirectPath := webClientTwoFactorPath
-		if next := r.URL.Query().Get("next"); strings.HasPrefix(next, webClientFilesPath) {
+		if next := r.URL.Query().Get("next"); isAllowedClientNextURL(next) {
 			redirectPath += "?next=" + url.QueryEscape(next)
 		}
 		http.Redirect(w, r, redirectPath, http.StatusFound)
@@ -765,7 +796,7 @@ func (s *httpdServer) loginUser(
 	}
 	updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, err)
 	dataprovider.UpdateLastLogin(user)
-	if next := r.URL.Query().Get("next"); strings.HasPrefix(next, webClientFilesPath) {
+	if next := r.URL.Query().Get("next"); isAllowedClientNextURL(next) {
 		http.Redirect(w, r, next, http.StatusFound)
 		return
 	}
@@ -781,6 +812,7 @@ func (s *httpdServer) loginAdmin(
 		Username:             admin.Username,
 		Permissions:          admin.Permissions,
 		Role:                 admin.Role,
+		Tenant:               admin.Tenant,
 		Signature:            admin.GetSignature(),
 		HideUserPageSections: admin.Filters.Preferences.HideUserPageSections,
 		MustSetTwoFactorAuth: admin.Filters.RequireTwoFactor && !admin.Filters.TOTPConfig.Enabled,
@@ -788,7 +820,8 @@ func (s *httpdServer) loginAdmin(
 	}
 
 	audience := tokenAudienceWebAdmin
-	if admin.Filters.TOTPConfig.Enabled && admin.CanManageMFA() && !isSecondFactorAuth {
+	if admin.Filters.TOTPConfig.Enabled && admin.CanManageMFA() && !isSecondFactorAuth &&
+		!s.isTrustedAdminDevice(w, r, admin) {
 		audience = tokenAudienceWebAdminPartial
 	}
 
@@ -816,6 +849,32 @@ func (s *httpdServer) loginAdmin(
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
 
+func (s *httpdServer) isTrustedUserDevice(w http.ResponseWriter, r *http.Request, user *dataprovider.User) bool {
+	maxAge := mfa.GetTrustedDeviceMaxAge(user.Filters.TOTPConfig.ConfigName)
+	if maxAge <= 0 {
+		return false
+	}
+	if err := user.Filters.TOTPConfig.Secret.TryDecrypt(); err != nil {
+		return false
+	}
+	fingerprint := getTrustedDeviceFingerprint(user.Filters.TOTPConfig.ConfigName,
+		user.Filters.TOTPConfig.Secret.GetPayload())
+	return isTrustedDevice(w, r, s.csrfTokenAuth, user.Username, fingerprint, webBaseClientPath, false, maxAge)
+}
+
+func (s *httpdServer) isTrustedAdminDevice(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) bool {
+	maxAge := mfa.GetTrustedDeviceMaxAge(admin.Filters.TOTPConfig.ConfigName)
+	if maxAge <= 0 {
+		return false
+	}
+	if err := admin.Filters.TOTPConfig.Secret.TryDecrypt(); err != nil {
+		return false
+	}
+	fingerprint := getTrustedDeviceFingerprint(admin.Filters.TOTPConfig.ConfigName,
+		admin.Filters.TOTPConfig.Secret.GetPayload())
+	return isTrustedDevice(w, r, s.csrfTokenAuth, admin.Username, fingerprint, webBaseAdminPath, true, maxAge)
+}
+
 func (s *httpdServer) logout(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 	invalidateToken(r, false)
@@ -862,7 +921,7 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
+	if user.IsSecondFactorRequired(common.ProtocolHTTP, ipAddr) {
 		passcode := r.Header.Get(otpHeaderCode)
 		if passcode == "" {
 			logger.Debug(logSender, "", "TOTP enabled for user %q and not passcode provided, authentication refused", user.Username)
@@ -908,6 +967,7 @@ func (s *httpdServer) generateAndSendUserToken(w http.ResponseWriter, r *http.Re
 		Permissions:                user.Filters.WebClient,
 		Signature:                  user.GetSignature(),
 		Role:                       user.Role,
+		Tenant:                     user.Tenant,
 		MustSetTwoFactorAuth:       user.MustSetSecondFactor(),
 		MustChangePassword:         user.MustChangePassword(),
 		RequiredTwoFactorProtocols: user.Filters.TwoFactorAuthProtocols,
@@ -975,6 +1035,7 @@ func (s *httpdServer) generateAndSendToken(w http.ResponseWriter, r *http.Reques
 		Username:             admin.Username,
 		Permissions:          admin.Permissions,
 		Role:                 admin.Role,
+		Tenant:               admin.Tenant,
 		Signature:            admin.GetSignature(),
 		MustSetTwoFactorAuth: admin.Filters.RequireTwoFactor && !admin.Filters.TOTPConfig.Enabled,
 		MustChangePassword:   admin.Filters.RequirePasswordChange,
@@ -1008,6 +1069,9 @@ func (s *httpdServer) checkCookieExpiration(w http.ResponseWriter, r *http.Reque
 	if time.Until(token.Expiration()) > tokenRefreshThreshold {
 		return
 	}
+	if tokenClaims.Impersonator != "" {
+		return
+	}
 	if util.Contains(token.Audience(), tokenAudienceWebClient) {
 		s.refreshClientToken(w, r, &tokenClaims)
 	} else {
@@ -1035,6 +1099,7 @@ func (s *httpdServer) refreshClientToken(w http.ResponseWriter, r *http.Request,
 
 	tokenClaims.Permissions = user.Filters.WebClient
 	tokenClaims.Role = user.Role
+	tokenClaims.Tenant = user.Tenant
 	logger.Debug(logSender, "", "cookie refreshed for user %q", user.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient, util.GetIPFromRemoteAddress(r.RemoteAddr)) //nolint:errcheck
 }
@@ -1055,6 +1120,7 @@ func (s *httpdServer) refreshAdminToken(w http.ResponseWriter, r *http.Request,
 	}
 	tokenClaims.Permissions = admin.Permissions
 	tokenClaims.Role = admin.Role
+	tokenClaims.Tenant = admin.Tenant
 	tokenClaims.HideUserPageSections = admin.Filters.Preferences.HideUserPageSections
 	logger.Debug(logSender, "", "cookie refreshed for admin %q", admin.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebAdmin, ipAddr) //nolint:errcheck
@@ -1077,6 +1143,9 @@ func (s *httpdServer) updateContextFromCookie(r *http.Request) *http.Request {
 func (s *httpdServer) parseHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Server", version.GetServerVersion("/", false))
+		if s.binding.Tenant != "" {
+			r = r.WithContext(context.WithValue(r.Context(), bindingTenantKey, s.binding.Tenant))
+		}
 		ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
 		var ip net.IP
 		isUnixSocket := filepath.IsAbs(s.binding.Address)
@@ -1130,9 +1199,7 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 			return
 		}
 		if delay, err := common.LimitRate(common.ProtocolHTTP, ipAddr); err != nil {
-			delay += 499999999 * time.Nanosecond
-			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
-			w.Header().Set("X-Retry-In", delay.String())
+			setRetryAfterHeaders(w, delay)
 			s.sendTooManyRequestResponse(w, r, err)
 			return
 		}
@@ -1141,6 +1208,12 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 	})
 }
 
+func setRetryAfterHeaders(w http.ResponseWriter, delay time.Duration) {
+	delay += 499999999 * time.Nanosecond
+	w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+	w.Header().Set("X-Retry-In", delay.String())
+}
+
 func (s *httpdServer) sendTooManyRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	if (s.enableWebAdmin || s.enableWebClient) && isWebRequest(r) {
 		r = s.updateContextFromCookie(r)
@@ -1272,6 +1345,10 @@ func (s *httpdServer) initializeRouter() {
 	s.router.NotFound(s.notFoundHandler)
 
 	s.router.Get(healthzPath, func(w http.ResponseWriter, r *http.Request) {
+		if getBoolQueryParam(r, "details") {
+			render.JSON(w, r, getHealthStatus())
+			return
+		}
 		render.PlainText(w, r, "ok")
 	})
 
@@ -1288,8 +1365,10 @@ func (s *httpdServer) initializeRouter() {
 		s.router.Post(sharesPath+"/{id}/{name}", s.uploadFileToShare)
 		s.router.With(compressor.Handler).Get(sharesPath+"/{id}/dirs", s.readBrowsableShareContents)
 		s.router.Get(sharesPath+"/{id}/files", s.downloadBrowsableSharedFile)
+		s.router.Get(sharesPath+"/{id}/thumbnails", s.getBrowsableShareThumbnail)
 
 		s.router.Get(tokenPath, s.getToken)
+		s.router.Post(oauth2TokenPath, s.handleOAuth2Token)
 		s.router.Post(adminPath+"/{username}/forgot-password", forgotAdminPassword)
 		s.router.Post(adminPath+"/{username}/reset-password", resetAdminPassword)
 		s.router.Post(userPath+"/{username}/forgot-password", forgotUserPassword)
@@ -1300,6 +1379,7 @@ func (s *httpdServer) initializeRouter() {
 			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeAdmin))
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticatorAPI)
+			router.Use(s.limitRateByIdentity)
 
 			router.Get(versionPath, func(w http.ResponseWriter, r *http.Request) {
 				r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
@@ -1317,6 +1397,8 @@ func (s *httpdServer) initializeRouter() {
 			router.With(forbidAPIKeyAuthentication).Post(adminTOTPSavePath, saveTOTPConfig)
 			router.With(forbidAPIKeyAuthentication).Get(admin2FARecoveryCodesPath, getRecoveryCodes)
 			router.With(forbidAPIKeyAuthentication).Post(admin2FARecoveryCodesPath, generateRecoveryCodes)
+			router.With(forbidAPIKeyAuthentication).Get(adminTrustedDevicesPath, s.getTrustedDevices)
+			router.With(forbidAPIKeyAuthentication).Delete(adminTrustedDevicesPath+"/{id}", revokeTrustedDevice)
 
 			router.With(forbidAPIKeyAuthentication, s.checkPerm(dataprovider.PermAdminManageAPIKeys)).
 				Get(apiKeysPath, getAPIKeys)
@@ -1347,23 +1429,37 @@ func (s *httpdServer) initializeRouter() {
 				router.With(s.checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotasBasePath+"/folders/{name}/scan", startFolderQuotaScan)
 				router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath, getUsers)
 				router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(userPath, addUser)
+				router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/bulk/export", exportUsers)
+				router.With(s.checkPerm(dataprovider.PermAdminAddUsers), s.checkPerm(dataprovider.PermAdminChangeUsers)).
+					Post(userPath+"/bulk", importUsers)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Post(userPath+"/bulk/delete", deleteUsers)
 				router.With(s.checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername) //nolint:goconst
 				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
 				router.With(s.checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
 				router.With(s.checkPerm(dataprovider.PermAdminDisableMFA)).Put(userPath+"/{username}/2fa/disable", disableUser2FA) //nolint:goconst
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Get(folderPath, getFolders)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Get(folderPath+"/{name}", getFolderByName) //nolint:goconst
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Post(folderPath, addFolder)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Put(folderPath+"/{name}", updateFolder)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Delete(folderPath+"/{name}", deleteFolder)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath, getGroups)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Get(groupPath+"/{name}", getGroupByName)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(groupPath, addGroup)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Put(groupPath+"/{name}", updateGroup)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Delete(groupPath+"/{name}", deleteGroup)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(userPath+"/{username}/s3credentials",
+					generateUserS3Credentials)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Delete(userPath+"/{username}/s3credentials",
+					revokeUserS3Credentials)
+				router.With(s.checkPerm(dataprovider.PermAdminViewFolders)).Get(folderPath, getFolders)
+				router.With(s.checkPerm(dataprovider.PermAdminViewFolders)).Get(folderPath+"/{name}", getFolderByName) //nolint:goconst
+				router.With(s.checkPerm(dataprovider.PermAdminAddFolders)).Post(folderPath, addFolder)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeFolders)).Put(folderPath+"/{name}", updateFolder)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteFolders)).Delete(folderPath+"/{name}", deleteFolder)
+				router.With(s.checkPerm(dataprovider.PermAdminViewGroups)).Get(groupPath, getGroups)
+				router.With(s.checkPerm(dataprovider.PermAdminViewGroups)).Get(groupPath+"/{name}", getGroupByName)
+				router.With(s.checkPerm(dataprovider.PermAdminAddGroups)).Post(groupPath, addGroup)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeGroups)).Put(groupPath+"/{name}", updateGroup)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteGroups)).Delete(groupPath+"/{name}", deleteGroup)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(passwordHashesPath, getPasswordHashesReport)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(reloadConfigPath, reloadConfig)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(oauth2AppsPath, getOAuth2Apps)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(oauth2AppsPath, addOAuth2App)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(oauth2AppsPath+"/{id}", getOAuth2AppByID)
+				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(oauth2AppsPath+"/{id}", deleteOAuth2App)
 				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/usage",
 					updateUserQuotaUsage)
 				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/transfer-usage",
@@ -1373,12 +1469,15 @@ func (s *httpdServer) initializeRouter() {
 				router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts, getDefenderHosts)
 				router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderHosts+"/{id}", getDefenderHostByID)
 				router.With(s.checkPerm(dataprovider.PermAdminManageDefender)).Delete(defenderHosts+"/{id}", deleteDefenderHostByID)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath, getAdmins)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(adminPath, addAdmin)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath+"/{username}", getAdminByUsername)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Put(adminPath+"/{username}", updateAdmin)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Delete(adminPath+"/{username}", deleteAdmin)
+				router.With(s.checkPerm(dataprovider.PermAdminViewAdmins)).Get(adminPath, getAdmins)
+				router.With(s.checkPerm(dataprovider.PermAdminAddAdmins)).Post(adminPath, addAdmin)
+				router.With(s.checkPerm(dataprovider.PermAdminViewAdmins)).Get(adminPath+"/{username}", getAdminByUsername)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeAdmins)).Put(adminPath+"/{username}", updateAdmin)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteAdmins)).Delete(adminPath+"/{username}", deleteAdmin)
 				router.With(s.checkPerm(dataprovider.PermAdminDisableMFA)).Put(adminPath+"/{username}/2fa/disable", disableAdmin2FA)
+				router.Get(jobsPath, getJobs)
+				router.Get(jobsPath+"/{id}", getJobByID)
+				router.Delete(jobsPath+"/{id}", cancelJob)
 				router.With(s.checkPerm(dataprovider.PermAdminRetentionChecks)).Get(retentionChecksPath, getRetentionChecks)
 				router.With(s.checkPerm(dataprovider.PermAdminRetentionChecks)).Post(retentionBasePath+"/{username}/check",
 					startRetentionCheck)
@@ -1388,27 +1487,36 @@ func (s *httpdServer) initializeRouter() {
 					Get(providerEventsPath, searchProviderEvents)
 				router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler).
 					Get(logEventsPath, searchLogEvents)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventActionsPath, getEventActions)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventActionsPath+"/{name}", getEventActionByName)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventActionsPath, addEventAction)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Put(eventActionsPath+"/{name}", updateEventAction)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventActionsPath+"/{name}", deleteEventAction)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath, getEventRules)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Get(eventRulesPath+"/{name}", getEventRuleByName)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath, addEventRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Put(eventRulesPath+"/{name}", updateEventRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Delete(eventRulesPath+"/{name}", deleteEventRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(eventRulesPath+"/run/{name}", runOnDemandRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Get(rolesPath, getRoles)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Post(rolesPath, addRole)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Get(rolesPath+"/{name}", getRoleByName)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Put(rolesPath+"/{name}", updateRole)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Delete(rolesPath+"/{name}", deleteRole)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), compressor.Handler).Get(ipListsPath+"/{type}", getIPListEntries) //nolint:goconst
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Post(ipListsPath+"/{type}", addIPListEntry)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Get(ipListsPath+"/{type}/{ipornet}", getIPListEntry) //nolint:goconst
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Put(ipListsPath+"/{type}/{ipornet}", updateIPListEntry)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Delete(ipListsPath+"/{type}/{ipornet}", deleteIPListEntry)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEvents)).Get(liveEventsPath, streamLiveEvents)
+				router.With(s.checkPerm(dataprovider.PermAdminViewAuditLog)).Get(auditLogPath, getAuditLog)
+				router.With(s.checkPerm(dataprovider.PermAdminViewAuditLog)).Get(auditLogVerifyPath, verifyAuditLog)
+				router.Post(graphQLPath, executeGraphQL)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules)).Get(eventActionsPath, getEventActions)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules)).Get(eventActionsPath+"/{name}", getEventActionByName)
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules)).Post(eventActionsPath, addEventAction)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules)).Put(eventActionsPath+"/{name}", updateEventAction)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteEventRules)).Delete(eventActionsPath+"/{name}", deleteEventAction)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules)).Get(eventRulesPath, getEventRules)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules)).Get(eventRulesPath+"/{name}", getEventRuleByName)
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules)).Post(eventRulesPath, addEventRule)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules)).Put(eventRulesPath+"/{name}", updateEventRule)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteEventRules)).Delete(eventRulesPath+"/{name}", deleteEventRule)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules)).Post(eventRulesPath+"/run/{name}", runOnDemandRule)
+				router.With(s.checkPerm(dataprovider.PermAdminViewRoles)).Get(rolesPath, getRoles)
+				router.With(s.checkPerm(dataprovider.PermAdminAddRoles)).Post(rolesPath, addRole)
+				router.With(s.checkPerm(dataprovider.PermAdminViewRoles)).Get(rolesPath+"/{name}", getRoleByName)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeRoles)).Put(rolesPath+"/{name}", updateRole)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteRoles)).Delete(rolesPath+"/{name}", deleteRole)
+				router.With(s.checkPerm(dataprovider.PermAdminViewTenants)).Get(tenantsPath, getTenants)
+				router.With(s.checkPerm(dataprovider.PermAdminAddTenants)).Post(tenantsPath, addTenant)
+				router.With(s.checkPerm(dataprovider.PermAdminViewTenants)).Get(tenantsPath+"/{name}", getTenantByName)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeTenants)).Put(tenantsPath+"/{name}", updateTenant)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteTenants)).Delete(tenantsPath+"/{name}", deleteTenant)
+				router.With(s.checkPerm(dataprovider.PermAdminViewIPLists), compressor.Handler).Get(ipListsPath+"/{type}", getIPListEntries) //nolint:goconst
+				router.With(s.checkPerm(dataprovider.PermAdminAddIPLists)).Post(ipListsPath+"/{type}", addIPListEntry)
+				router.With(s.checkPerm(dataprovider.PermAdminViewIPLists)).Get(ipListsPath+"/{type}/{ipornet}", getIPListEntry) //nolint:goconst
+				router.With(s.checkPerm(dataprovider.PermAdminChangeIPLists)).Put(ipListsPath+"/{type}/{ipornet}", updateIPListEntry)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteIPLists)).Delete(ipListsPath+"/{type}/{ipornet}", deleteIPListEntry)
 			})
 		})
 
@@ -1418,12 +1526,15 @@ func (s *httpdServer) initializeRouter() {
 			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeUser))
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticatorAPIUser)
+			router.Use(s.limitRateByIdentity)
 
 			router.With(forbidAPIKeyAuthentication).Get(userLogoutPath, s.logout)
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
 				Put(userPwdPath, changeUserPassword)
 			router.With(forbidAPIKeyAuthentication).Get(userProfilePath, getUserProfile)
 			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).Put(userProfilePath, updateUserProfile)
+			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).Post(userSSHCertPath, issueUserSSHCert)
+			router.With(forbidAPIKeyAuthentication, s.checkAuthRequirements).Post(userS3CredentialsPath, generateS3Credentials)
 			// user TOTP APIs
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
 				Get(userTOTPConfigsPath, getTOTPConfigs)
@@ -1437,6 +1548,10 @@ func (s *httpdServer) initializeRouter() {
 				Get(user2FARecoveryCodesPath, getRecoveryCodes)
 			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
 				Post(user2FARecoveryCodesPath, generateRecoveryCodes)
+			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
+				Get(userTrustedDevicesPath, s.getTrustedDevices)
+			router.With(forbidAPIKeyAuthentication, s.checkHTTPUserPerm(sdk.WebClientMFADisabled)).
+				Delete(userTrustedDevicesPath+"/{id}", revokeTrustedDevice)
 
 			router.With(s.checkAuthRequirements, compressor.Handler).Get(userDirsPath, readUserFolder)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
@@ -1446,6 +1561,7 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Delete(userDirsPath, deleteUserDir)
 			router.With(s.checkAuthRequirements).Get(userFilesPath, getUserFile)
+			router.With(s.checkAuthRequirements).Get(userThumbnailsPath, getUserThumbnail)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Post(userFilesPath, uploadUserFiles)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
@@ -1456,6 +1572,8 @@ func (s *httpdServer) initializeRouter() {
 				Post(userFileActionsPath+"/move", renameUserFsEntry)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Post(userFileActionsPath+"/copy", copyUserFsEntry)
+			router.With(s.checkAuthRequirements).Post(userFileActionsPath+"/restore-archived", restoreUserArchivedFile)
+			router.With(s.checkAuthRequirements).Get(userJobsPath+"/{id}", getUserJobByID)
 			router.With(s.checkAuthRequirements).Post(userStreamZipPath, getUserFilesAsZipStream)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Get(userSharesPath, getShares)
@@ -1463,12 +1581,34 @@ func (s *httpdServer) initializeRouter() {
 				Post(userSharesPath, addShare)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Get(userSharesPath+"/{id}", getShareByID)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
+				Get(userSharesPath+"/{id}/stats", getShareStats)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Put(userSharesPath+"/{id}", updateShare)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Delete(userSharesPath+"/{id}", deleteShare)
+			router.With(s.checkAuthRequirements).Get(userTrashPath, getUserTrash)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userTrashPath+"/{id}/restore", restoreUserTrashEntry)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Delete(userTrashPath+"/{id}", deleteUserTrashEntry)
+			router.With(s.checkAuthRequirements).Get(userFileVersionsPath, getUserFileVersions)
+			router.With(s.checkAuthRequirements).Get(userSearchPath, searchUserFiles)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userFileVersionsPath+"/{id}/restore", restoreUserFileVersion)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Delete(userFileVersionsPath+"/{id}", deleteUserFileVersion)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Post(userUploadFilePath, uploadUserFile)
+			router.With(s.checkAuthRequirements).Options(userTusPath, tusOptions)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Post(userTusPath, createTusUpload)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Head(userTusPath+"/{id}", getTusUploadOffset)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Patch(userTusPath+"/{id}", appendTusUploadData)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
+				Delete(userTusPath+"/{id}", deleteTusUpload)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled)).
 				Patch(userFilesDirsMetadataPath, setFileDirMetadata)
 		})
@@ -1486,9 +1626,15 @@ func (s *httpdServer) initializeRouter() {
 			router.Use(compressor.Handler)
 			serveStaticDir(router, webStaticFilesPath, s.staticFilesPath, true)
 		})
-		if s.binding.OIDC.isEnabled() {
+		if s.binding.hasOIDC() {
 			s.router.Get(webOIDCRedirectPath, s.handleOIDCRedirect)
 		}
+		if s.binding.SAML.isEnabled() {
+			s.router.Get(webSAMLMetadataPath, s.handleSAMLMetadata)
+			s.router.Post(webSAMLACSPath, s.handleSAMLACS)
+			s.router.Get(webSAMLSLOPath, s.handleSAMLSLO)
+			s.router.Post(webSAMLSLOPath, s.handleSAMLSLO)
+		}
 		if s.enableWebClient {
 			s.router.Get(webRootPath, func(w http.ResponseWriter, r *http.Request) {
 				r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
@@ -1521,9 +1667,12 @@ func (s *httpdServer) setupWebClientRoutes() {
 			http.Redirect(w, r, webClientLoginPath, http.StatusFound)
 		})
 		s.router.Get(webClientLoginPath, s.handleClientWebLogin)
-		if s.binding.OIDC.isEnabled() && !s.binding.isWebClientOIDCLoginDisabled() {
+		if s.binding.hasOIDC() && !s.binding.isWebClientOIDCLoginDisabled() {
 			s.router.Get(webClientOIDCLoginPath, s.handleWebClientOIDCLogin)
 		}
+		if s.binding.SAML.isEnabled() && !s.binding.isWebClientSAMLLoginDisabled() {
+			s.router.Get(webClientSAMLLoginPath, s.handleWebClientSAMLLogin)
+		}
 		if !s.binding.isWebClientLoginFormDisabled() {
 			s.router.With(jwtauth.Verify(s.csrfTokenAuth, jwtauth.TokenFromCookie)).
 				Post(webClientLoginPath, s.handleWebClientLoginPost)
@@ -1563,23 +1712,43 @@ func (s *httpdServer) setupWebClientRoutes() {
 		s.router.Post(webClientPubSharesPath+"/{id}/{name}", s.uploadFileToShare)
 		s.router.Get(webClientPubSharesPath+"/{id}/viewpdf", s.handleShareViewPDF)
 		s.router.Get(webClientPubSharesPath+"/{id}/getpdf", s.handleShareGetPDF)
+		s.router.Get(webClientPubSharesPath+"/{id}/thumbnails", s.getBrowsableShareThumbnail)
+		if wopiMgr != nil {
+			s.router.Get(wopiFilesPath+"/{id}", s.wopiCheckFileInfo)
+			s.router.Post(wopiFilesPath+"/{id}", s.wopiFileOperation)
+			s.router.Get(wopiFilesPath+"/{id}/contents", s.wopiGetFile)
+			s.router.Post(wopiFilesPath+"/{id}/contents", s.wopiPutFile)
+		}
 
 		s.router.Group(func(router chi.Router) {
-			if s.binding.OIDC.isEnabled() {
+			if s.binding.hasOIDC() || s.binding.SAML.isEnabled() {
 				router.Use(s.oidcTokenAuthenticator(tokenAudienceWebClient))
 			}
 			router.Use(jwtauth.Verify(s.tokenAuth, oidcTokenFromContext, jwtauth.TokenFromCookie))
 			router.Use(jwtAuthenticatorWebClient)
+			router.Use(s.checkImpersonatedRequest)
 
 			router.Get(webClientLogoutPath, s.handleWebClientLogout)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientFilesPath, s.handleClientGetFiles)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientViewPDFPath, s.handleClientViewPDF)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientGetPDFPath, s.handleClientGetPDF)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientPreviewPath, s.handleClientPreview)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientGetPreviewPath, s.handleClientGetPreview)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientThumbnailsPath, getUserThumbnail)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientWOPIPath, s.handleClientWOPI)
 			router.With(s.checkAuthRequirements, s.refreshCookie, s.verifyCSRFHeader).Get(webClientFilePath, getUserFile)
 			router.With(s.checkAuthRequirements, s.refreshCookie, s.verifyCSRFHeader).Get(webClientTasksPath+"/{id}",
 				getWebTask)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
 				Post(webClientFilePath, uploadUserFile)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Post(webClientTusPath, createTusUpload)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Head(webClientTusPath+"/{id}", getTusUploadOffset)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Patch(webClientTusPath+"/{id}", appendTusUploadData)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Delete(webClientTusPath+"/{id}", deleteTusUpload)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
 				Post(webClientExistPath, s.handleClientCheckExist)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientEditFilePath, s.handleClientEditFile)
@@ -1595,30 +1764,43 @@ func (s *httpdServer) setupWebClientRoutes() {
 				Post(webClientFileActionsPath+"/move", taskRenameFsEntry)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
 				Post(webClientFileActionsPath+"/copy", taskCopyFsEntry)
+			router.With(s.checkAuthRequirements, s.verifyCSRFHeader).
+				Post(webClientFileActionsPath+"/restore-archived", s.handleClientRestoreArchivedFile)
 			router.With(s.checkAuthRequirements, s.refreshCookie).
 				Post(webClientDownloadZipPath, s.handleWebClientDownloadZip)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientPingPath, handlePingRequest)
 			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientProfilePath,
 				s.handleClientGetProfile)
-			router.With(s.checkAuthRequirements).Post(webClientProfilePath, s.handleWebClientProfilePost)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
+			router.With(s.denyImpersonation, s.checkAuthRequirements).
+				Post(webClientProfilePath, s.handleWebClientProfilePost)
+			router.With(s.denyImpersonation, s.checkAuthRequirements, s.verifyCSRFHeader).
+				Post(webClientSSHCertPath, issueUserSSHCert)
+			router.With(s.checkAuthRequirements, s.refreshCookie).
+				Get(webClientOAuth2AuthorizePath, s.handleWebClientOAuth2Authorize)
+			router.With(s.denyImpersonation, s.checkAuthRequirements).
+				Post(webClientOAuth2AuthorizePath, s.handleWebClientOAuth2AuthorizePost)
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
 				Get(webChangeClientPwdPath, s.handleWebClientChangePwd)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientPasswordChangeDisabled)).
 				Post(webChangeClientPwdPath, s.handleWebClientChangePwdPost)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.refreshCookie).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.refreshCookie).
 				Get(webClientMFAPath, s.handleWebClientMFA)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.refreshCookie).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.refreshCookie).
 				Get(webClientMFAPath+"/qrcode", getQRCode)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
 				Post(webClientTOTPGeneratePath, generateTOTPSecret)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
 				Post(webClientTOTPValidatePath, validateTOTPPasscode)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
 				Post(webClientTOTPSavePath, saveTOTPConfig)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader, s.refreshCookie).
-				Get(webClientRecoveryCodesPath, getRecoveryCodes)
-			router.With(s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader,
+				s.refreshCookie).Get(webClientRecoveryCodesPath, getRecoveryCodes)
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
 				Post(webClientRecoveryCodesPath, generateRecoveryCodes)
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader,
+				s.refreshCookie).Get(webClientTrustedDevicesPath, s.getTrustedDevices)
+			router.With(s.denyImpersonation, s.checkHTTPUserPerm(sdk.WebClientMFADisabled), s.verifyCSRFHeader).
+				Delete(webClientTrustedDevicesPath+"/{id}", revokeTrustedDevice)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), compressor.Handler, s.refreshCookie).
 				Get(webClientSharesPath+jsonAPISuffix, getAllShares)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
@@ -1629,10 +1811,28 @@ func (s *httpdServer) setupWebClientRoutes() {
 				Post(webClientSharePath, s.handleClientAddSharePost)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
 				Get(webClientSharePath+"/{id}", s.handleClientUpdateShareGet)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.refreshCookie).
+				Get(webClientSharePath+"/{id}/stats", getShareStats)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled)).
 				Post(webClientSharePath+"/{id}", s.handleClientUpdateSharePost)
 			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientSharesDisabled), s.verifyCSRFHeader).
 				Delete(webClientSharePath+"/{id}", deleteShare)
+			router.With(s.checkAuthRequirements, compressor.Handler, s.refreshCookie).
+				Get(webClientTrashPath+jsonAPISuffix, s.handleClientGetTrashEntries)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientTrashPath, s.handleClientGetTrash)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Post(webClientTrashPath+"/{id}/restore", s.handleClientRestoreTrashEntry)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Delete(webClientTrashPath+"/{id}", s.handleClientDeleteTrashEntry)
+			router.With(s.checkAuthRequirements, s.refreshCookie).
+				Get(webClientFileVersionsPath, s.handleClientGetFileVersions)
+			router.With(s.checkAuthRequirements, s.refreshCookie).Get(webClientSearchPath, s.handleClientGetSearch)
+			router.With(s.checkAuthRequirements, compressor.Handler, s.refreshCookie).
+				Get(webClientSearchPath+jsonAPISuffix, s.handleClientSearchFiles)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Post(webClientFileVersionsPath+"/{id}/restore", s.handleClientRestoreFileVersion)
+			router.With(s.checkAuthRequirements, s.checkHTTPUserPerm(sdk.WebClientWriteDisabled), s.verifyCSRFHeader).
+				Delete(webClientFileVersionsPath+"/{id}", s.handleClientDeleteFileVersion)
 		})
 	}
 }
@@ -1644,9 +1844,12 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			s.redirectToWebPath(w, r, webAdminLoginPath)
 		})
 		s.router.Get(webAdminLoginPath, s.handleWebAdminLogin)
-		if s.binding.OIDC.hasRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
+		if s.binding.hasOIDCRoles() && !s.binding.isWebAdminOIDCLoginDisabled() {
 			s.router.Get(webAdminOIDCLoginPath, s.handleWebAdminOIDCLogin)
 		}
+		if s.binding.SAML.hasRoles() && !s.binding.isWebAdminSAMLLoginDisabled() {
+			s.router.Get(webAdminSAMLLoginPath, s.handleWebAdminSAMLLogin)
+		}
 		s.router.Get(webOAuth2RedirectPath, s.handleOAuth2TokenRedirect)
 		s.router.Get(webAdminSetupPath, s.handleWebAdminSetupGet)
 		s.router.With(jwtauth.Verify(s.csrfTokenAuth, jwtauth.TokenFromCookie)).
@@ -1676,7 +1879,7 @@ func (s *httpdServer) setupWebAdminRoutes() {
 		}
 
 		s.router.Group(func(router chi.Router) {
-			if s.binding.OIDC.isEnabled() {
+			if s.binding.hasOIDC() || s.binding.SAML.isEnabled() {
 				router.Use(s.oidcTokenAuthenticator(tokenAudienceWebAdmin))
 			}
 			router.Use(jwtauth.Verify(s.tokenAuth, oidcTokenFromContext, jwtauth.TokenFromCookie))
@@ -1697,6 +1900,10 @@ func (s *httpdServer) setupWebAdminRoutes() {
 			router.With(s.verifyCSRFHeader, s.requireBuiltinLogin, s.refreshCookie).Get(webAdminRecoveryCodesPath,
 				getRecoveryCodes)
 			router.With(s.verifyCSRFHeader, s.requireBuiltinLogin).Post(webAdminRecoveryCodesPath, generateRecoveryCodes)
+			router.With(s.verifyCSRFHeader, s.requireBuiltinLogin, s.refreshCookie).Get(webAdminTrustedDevicesPath,
+				s.getTrustedDevices)
+			router.With(s.verifyCSRFHeader, s.requireBuiltinLogin).Delete(webAdminTrustedDevicesPath+"/{id}",
+				revokeTrustedDevice)
 
 			router.Group(func(router chi.Router) {
 				router.Use(s.checkAuthRequirements)
@@ -1712,54 +1919,54 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				router.With(s.checkPerm(dataprovider.PermAdminAddUsers)).Post(webUserPath, s.handleWebAddUserPost)
 				router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(webUserPath+"/{username}",
 					s.handleWebUpdateUserPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewGroups), s.refreshCookie).
 					Get(webGroupsPath, s.handleWebGetGroups)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewGroups), compressor.Handler, s.refreshCookie).
 					Get(webGroupsPath+jsonAPISuffix, getAllGroups)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddGroups), s.refreshCookie).
 					Get(webGroupPath, s.handleWebAddGroupGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(webGroupPath, s.handleWebAddGroupPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddGroups)).Post(webGroupPath, s.handleWebAddGroupPost)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeGroups), s.refreshCookie).
 					Get(webGroupPath+"/{name}", s.handleWebUpdateGroupGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(webGroupPath+"/{name}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeGroups)).Post(webGroupPath+"/{name}",
 					s.handleWebUpdateGroupPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageGroups), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteGroups), s.verifyCSRFHeader).
 					Delete(webGroupPath+"/{name}", deleteGroup)
 				router.With(s.checkPerm(dataprovider.PermAdminViewConnections), s.refreshCookie).
 					Get(webConnectionsPath, s.handleWebGetConnections)
 				router.With(s.checkPerm(dataprovider.PermAdminViewConnections), s.refreshCookie).
 					Get(webConnectionsPath+jsonAPISuffix, getActiveConnections)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewFolders), s.refreshCookie).
 					Get(webFoldersPath, s.handleWebGetFolders)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewFolders), compressor.Handler, s.refreshCookie).
 					Get(webFoldersPath+jsonAPISuffix, getAllFolders)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddFolders), s.refreshCookie).
 					Get(webFolderPath, s.handleWebAddFolderGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Post(webFolderPath, s.handleWebAddFolderPost)
+				router.With(s.checkPerm(dataprovider.PermAdminAddFolders)).Post(webFolderPath, s.handleWebAddFolderPost)
 				router.With(s.checkPerm(dataprovider.PermAdminViewServerStatus), s.refreshCookie).
 					Get(webStatusPath, s.handleWebGetStatus)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewAdmins), s.refreshCookie).
 					Get(webAdminsPath, s.handleGetWebAdmins)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewAdmins), compressor.Handler, s.refreshCookie).
 					Get(webAdminsPath+jsonAPISuffix, getAllAdmins)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddAdmins), s.refreshCookie).
 					Get(webAdminPath, s.handleWebAddAdminGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminChangeAdmins), s.refreshCookie).
 					Get(webAdminPath+"/{username}", s.handleWebUpdateAdminGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(webAdminPath, s.handleWebAddAdminPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins)).Post(webAdminPath+"/{username}",
+				router.With(s.checkPerm(dataprovider.PermAdminAddAdmins)).Post(webAdminPath, s.handleWebAddAdminPost)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeAdmins)).Post(webAdminPath+"/{username}",
 					s.handleWebUpdateAdminPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageAdmins), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteAdmins), s.verifyCSRFHeader).
 					Delete(webAdminPath+"/{username}", deleteAdmin)
 				router.With(s.checkPerm(dataprovider.PermAdminDisableMFA), s.verifyCSRFHeader).
 					Put(webAdminPath+"/{username}/2fa/disable", disableAdmin2FA)
 				router.With(s.checkPerm(dataprovider.PermAdminCloseConnections), s.verifyCSRFHeader).
 					Delete(webConnectionsPath+"/{connectionID}", handleCloseConnection)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminChangeFolders), s.refreshCookie).
 					Get(webFolderPath+"/{name}", s.handleWebUpdateFolderGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders)).Post(webFolderPath+"/{name}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeFolders)).Post(webFolderPath+"/{name}",
 					s.handleWebUpdateFolderPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageFolders), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteFolders), s.verifyCSRFHeader).
 					Delete(webFolderPath+"/{name}", deleteFolder)
 				router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), s.verifyCSRFHeader).
 					Post(webScanVFolderPath+"/{name}", startFolderQuotaScan)
@@ -1767,6 +1974,8 @@ func (s *httpdServer) setupWebAdminRoutes() {
 					Delete(webUserPath+"/{username}", deleteUser)
 				router.With(s.checkPerm(dataprovider.PermAdminDisableMFA), s.verifyCSRFHeader).
 					Put(webUserPath+"/{username}/2fa/disable", disableUser2FA)
+				router.With(s.checkPerm(dataprovider.PermAdminImpersonateUsers)).
+					Post(webUserPath+"/{username}/impersonate", s.handleWebImpersonateUser)
 				router.With(s.checkPerm(dataprovider.PermAdminQuotaScans), s.verifyCSRFHeader).
 					Post(webQuotaScanPath+"/{username}", startUserQuotaScan)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(webMaintenancePath, s.handleWebMaintenance)
@@ -1782,49 +1991,62 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				router.With(s.checkPerm(dataprovider.PermAdminViewDefender)).Get(webDefenderHostsPath, getDefenderHosts)
 				router.With(s.checkPerm(dataprovider.PermAdminManageDefender)).Delete(webDefenderHostsPath+"/{id}",
 					deleteDefenderHostByID)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules), compressor.Handler, s.refreshCookie).
 					Get(webAdminEventActionsPath+jsonAPISuffix, getAllActions)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules), s.refreshCookie).
 					Get(webAdminEventActionsPath, s.handleWebGetEventActions)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules), s.refreshCookie).
 					Get(webAdminEventActionPath, s.handleWebAddEventActionGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventActionPath,
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules)).Post(webAdminEventActionPath,
 					s.handleWebAddEventActionPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules), s.refreshCookie).
 					Get(webAdminEventActionPath+"/{name}", s.handleWebUpdateEventActionGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventActionPath+"/{name}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules)).Post(webAdminEventActionPath+"/{name}",
 					s.handleWebUpdateEventActionPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteEventRules), s.verifyCSRFHeader).
 					Delete(webAdminEventActionPath+"/{name}", deleteEventAction)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules), compressor.Handler, s.refreshCookie).
 					Get(webAdminEventRulesPath+jsonAPISuffix, getAllRules)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewEventRules), s.refreshCookie).
 					Get(webAdminEventRulesPath, s.handleWebGetEventRules)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules), s.refreshCookie).
 					Get(webAdminEventRulePath, s.handleWebAddEventRuleGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventRulePath,
+				router.With(s.checkPerm(dataprovider.PermAdminAddEventRules)).Post(webAdminEventRulePath,
 					s.handleWebAddEventRulePost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules), s.refreshCookie).
 					Get(webAdminEventRulePath+"/{name}", s.handleWebUpdateEventRuleGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules)).Post(webAdminEventRulePath+"/{name}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules)).Post(webAdminEventRulePath+"/{name}",
 					s.handleWebUpdateEventRulePost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteEventRules), s.verifyCSRFHeader).
 					Delete(webAdminEventRulePath+"/{name}", deleteEventRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageEventRules), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminChangeEventRules), s.verifyCSRFHeader).
 					Post(webAdminEventRulePath+"/run/{name}", runOnDemandRule)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewRoles), s.refreshCookie).
 					Get(webAdminRolesPath, s.handleWebGetRoles)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewRoles), compressor.Handler, s.refreshCookie).
 					Get(webAdminRolesPath+jsonAPISuffix, getAllRoles)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddRoles), s.refreshCookie).
 					Get(webAdminRolePath, s.handleWebAddRoleGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Post(webAdminRolePath, s.handleWebAddRolePost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminAddRoles)).Post(webAdminRolePath, s.handleWebAddRolePost)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeRoles), s.refreshCookie).
 					Get(webAdminRolePath+"/{name}", s.handleWebUpdateRoleGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles)).Post(webAdminRolePath+"/{name}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeRoles)).Post(webAdminRolePath+"/{name}",
 					s.handleWebUpdateRolePost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageRoles), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteRoles), s.verifyCSRFHeader).
 					Delete(webAdminRolePath+"/{name}", deleteRole)
+				router.With(s.checkPerm(dataprovider.PermAdminViewTenants), s.refreshCookie).
+					Get(webAdminTenantsPath, s.handleWebGetTenants)
+				router.With(s.checkPerm(dataprovider.PermAdminViewTenants), compressor.Handler, s.refreshCookie).
+					Get(webAdminTenantsPath+jsonAPISuffix, getAllTenants)
+				router.With(s.checkPerm(dataprovider.PermAdminAddTenants), s.refreshCookie).
+					Get(webAdminTenantPath, s.handleWebAddTenantGet)
+				router.With(s.checkPerm(dataprovider.PermAdminAddTenants)).Post(webAdminTenantPath, s.handleWebAddTenantPost)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeTenants), s.refreshCookie).
+					Get(webAdminTenantPath+"/{name}", s.handleWebUpdateTenantGet)
+				router.With(s.checkPerm(dataprovider.PermAdminChangeTenants)).Post(webAdminTenantPath+"/{name}",
+					s.handleWebUpdateTenantPost)
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteTenants), s.verifyCSRFHeader).
+					Delete(webAdminTenantPath+"/{name}", deleteTenant)
 				router.With(s.checkPerm(dataprovider.PermAdminViewEvents), s.refreshCookie).Get(webEventsPath,
 					s.handleWebGetEvents)
 				router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler, s.refreshCookie).
@@ -1833,18 +2055,21 @@ func (s *httpdServer) setupWebAdminRoutes() {
 					Get(webEventsProviderSearchPath, searchProviderEvents)
 				router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler, s.refreshCookie).
 					Get(webEventsLogSearchPath, searchLogEvents)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Get(webIPListsPath, s.handleWebIPListsPage)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), compressor.Handler, s.refreshCookie).
+				router.With(s.checkPerm(dataprovider.PermAdminViewEvents), s.refreshCookie).Get(webLiveEventsPath,
+					s.handleWebGetLiveEvents)
+				router.With(s.checkPerm(dataprovider.PermAdminViewEvents)).Get(webLiveEventsStreamPath, streamLiveEvents)
+				router.With(s.checkPerm(dataprovider.PermAdminViewIPLists)).Get(webIPListsPath, s.handleWebIPListsPage)
+				router.With(s.checkPerm(dataprovider.PermAdminViewIPLists), compressor.Handler, s.refreshCookie).
 					Get(webIPListsPath+"/{type}", getIPListEntries)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), s.refreshCookie).Get(webIPListPath+"/{type}",
+				router.With(s.checkPerm(dataprovider.PermAdminAddIPLists), s.refreshCookie).Get(webIPListPath+"/{type}",
 					s.handleWebAddIPListEntryGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Post(webIPListPath+"/{type}",
+				router.With(s.checkPerm(dataprovider.PermAdminAddIPLists)).Post(webIPListPath+"/{type}",
 					s.handleWebAddIPListEntryPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), s.refreshCookie).Get(webIPListPath+"/{type}/{ipornet}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeIPLists), s.refreshCookie).Get(webIPListPath+"/{type}/{ipornet}",
 					s.handleWebUpdateIPListEntryGet)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Post(webIPListPath+"/{type}/{ipornet}",
+				router.With(s.checkPerm(dataprovider.PermAdminChangeIPLists)).Post(webIPListPath+"/{type}/{ipornet}",
 					s.handleWebUpdateIPListEntryPost)
-				router.With(s.checkPerm(dataprovider.PermAdminManageIPLists), s.verifyCSRFHeader).
+				router.With(s.checkPerm(dataprovider.PermAdminDeleteIPLists), s.verifyCSRFHeader).
 					Delete(webIPListPath+"/{type}/{ipornet}", deleteIPListEntry)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem), s.refreshCookie).Get(webConfigsPath, s.handleWebConfigs)
 				router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(webConfigsPath, s.handleWebConfigsPost)
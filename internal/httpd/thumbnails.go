@@ -0,0 +1,598 @@
+// Copyright (C) 2024 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	thumbnailTypeImage      = "image"
+	thumbnailTypePDF        = "pdf"
+	thumbnailTypeVideo      = "video"
+	thumbnailDefaultSize    = 256
+	thumbnailMaxPixels      = 50000000
+	thumbnailJPEGQuality    = 80
+	thumbnailCacheExtension = ".jpg"
+	thumbnailCommandTimeout = 60 * time.Second
+	defaultThumbnailsCache  = 100 // MB
+	defaultThumbnailsMaxSrc = 20  // MB
+)
+
+var (
+	thumbnailsMgr             *thumbnailManager
+	thumbnailSizes            = []int{64, 128, 256, 512}
+	thumbnailImageExtensions  = []string{".jpg", ".jpeg", ".png", ".gif"}
+	thumbnailVideoExtensions  = []string{".mp4", ".mov", ".webm", ".mkv", ".avi", ".m4v"}
+	errThumbnailUnsupported   = errors.New("thumbnails are not supported for this file type")
+	errThumbnailsDisabled     = errors.New("thumbnails are disabled")
+	errThumbnailTooLarge      = errors.New("the file is too large to generate a thumbnail")
+	errThumbnailInvalidSource = errors.New("unable to decode the source image")
+)
+
+// ThumbnailsConfig defines the configuration for the server side thumbnails generation
+type ThumbnailsConfig struct {
+	// Set to true to enable thumbnails generation
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to the directory used to cache the generated thumbnails. This can be an absolute path
+	// or a path relative to the config dir. If empty a "thumbnails" directory inside the
+	// configured temp path, or the system temp directory, will be used
+	CachePath string `json:"cache_path" mapstructure:"cache_path"`
+	// Maximum size for the thumbnails cache as MB. The least recently used thumbnails are removed
+	// when this limit is exceeded. 0 means the default: 100 MB
+	CacheSize int `json:"cache_size" mapstructure:"cache_size"`
+	// Maximum size, as MB, for the files to generate thumbnails for. 0 means the default: 20 MB
+	MaxFileSize int `json:"max_file_size" mapstructure:"max_file_size"`
+	// Absolute path to the "pdftoppm" executable used to render the first page of PDF files.
+	// Leave empty to disable thumbnails for PDF files
+	PDFCommand string `json:"pdf_command" mapstructure:"pdf_command"`
+	// Absolute path to the "ffmpeg" executable used to extract the first keyframe from video files.
+	// Leave empty to disable thumbnails for video files
+	VideoCommand string `json:"video_command" mapstructure:"video_command"`
+}
+
+func (c *ThumbnailsConfig) validate() error {
+	if c.PDFCommand != "" && !filepath.IsAbs(c.PDFCommand) {
+		return fmt.Errorf("invalid thumbnails PDF command %q, it must be an absolute path", c.PDFCommand)
+	}
+	if c.VideoCommand != "" && !filepath.IsAbs(c.VideoCommand) {
+		return fmt.Errorf("invalid thumbnails video command %q, it must be an absolute path", c.VideoCommand)
+	}
+	return nil
+}
+
+func (c *ThumbnailsConfig) initialize(configDir string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cachePath := c.CachePath
+	if cachePath == "" {
+		cachePath = common.Config.TempPath
+		if cachePath == "" {
+			cachePath = os.TempDir()
+		}
+		cachePath = filepath.Join(cachePath, "thumbnails")
+	} else if !filepath.IsAbs(cachePath) {
+		cachePath = filepath.Join(configDir, cachePath)
+	}
+	cacheSize := c.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = defaultThumbnailsCache
+	}
+	maxFileSize := c.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = defaultThumbnailsMaxSrc
+	}
+	mgr := &thumbnailManager{
+		cachePath:    cachePath,
+		maxCacheSize: int64(cacheSize) * 1048576,
+		maxFileSize:  int64(maxFileSize) * 1048576,
+		pdfCommand:   c.PDFCommand,
+		videoCommand: c.VideoCommand,
+		sem:          make(chan struct{}, runtime.NumCPU()),
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+	if err := mgr.loadCache(); err != nil {
+		return err
+	}
+	thumbnailsMgr = mgr
+	logger.Info(logSender, "", "thumbnails generation enabled, cache path %q, cached thumbnails: %d",
+		cachePath, mgr.lru.Len())
+	return nil
+}
+
+type thumbnailCacheEntry struct {
+	key  string
+	size int64
+}
+
+// thumbnailManager generates thumbnails and caches them on disk. The least
+// recently used thumbnails are removed when the cache size limit is exceeded
+type thumbnailManager struct {
+	cachePath    string
+	maxCacheSize int64
+	maxFileSize  int64
+	pdfCommand   string
+	videoCommand string
+	// limits the concurrent thumbnails generations
+	sem       chan struct{}
+	mu        sync.Mutex
+	cacheSize int64
+	entries   map[string]*list.Element
+	lru       *list.List
+}
+
+func (m *thumbnailManager) loadCache() error {
+	if err := os.MkdirAll(m.cachePath, 0700); err != nil {
+		return fmt.Errorf("unable to create thumbnails cache dir %q: %w", m.cachePath, err)
+	}
+	entries, err := os.ReadDir(m.cachePath)
+	if err != nil {
+		return fmt.Errorf("unable to read thumbnails cache dir %q: %w", m.cachePath, err)
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), thumbnailCacheExtension) {
+			// leftover temporary files from an interrupted generation
+			os.Remove(filepath.Join(m.cachePath, entry.Name()))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	// the access time is not reliable, we update the modification time on cache hits
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, info := range infos {
+		key := strings.TrimSuffix(info.Name(), thumbnailCacheExtension)
+		m.entries[key] = m.lru.PushFront(&thumbnailCacheEntry{key: key, size: info.Size()})
+		m.cacheSize += info.Size()
+	}
+	m.evict()
+	return nil
+}
+
+func (m *thumbnailManager) getCacheFilePath(key string) string {
+	return filepath.Join(m.cachePath, key+thumbnailCacheExtension)
+}
+
+func (m *thumbnailManager) getThumbnailType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case util.Contains(thumbnailImageExtensions, ext):
+		return thumbnailTypeImage
+	case ext == ".pdf" && m.pdfCommand != "":
+		return thumbnailTypePDF
+	case util.Contains(thumbnailVideoExtensions, ext) && m.videoCommand != "":
+		return thumbnailTypeVideo
+	}
+	return ""
+}
+
+// isSupported returns true if a thumbnail can be generated for the specified file
+func (m *thumbnailManager) isSupported(name string, info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Size() <= m.maxFileSize && m.getThumbnailType(name) != ""
+}
+
+func (m *thumbnailManager) getCacheKey(username, name string, info os.FileInfo, size int) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d", username, name, info.Size(),
+		info.ModTime().UnixNano(), size)))
+	return hex.EncodeToString(h[:])
+}
+
+func (m *thumbnailManager) get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	elem, ok := m.entries[key]
+	if ok {
+		m.lru.MoveToFront(elem)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	cacheFile := m.getCacheFilePath(key)
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to read cached thumbnail %q: %v", cacheFile, err)
+		m.remove(key)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(cacheFile, now, now) //nolint:errcheck
+	return data, true
+}
+
+func (m *thumbnailManager) add(key string, data []byte) error {
+	f, err := os.CreateTemp(m.cachePath, "thumb-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), m.getCacheFilePath(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*thumbnailCacheEntry)
+		m.cacheSize -= entry.size
+		entry.size = int64(len(data))
+		m.lru.MoveToFront(elem)
+	} else {
+		m.entries[key] = m.lru.PushFront(&thumbnailCacheEntry{key: key, size: int64(len(data))})
+	}
+	m.cacheSize += int64(len(data))
+	m.evict()
+	return nil
+}
+
+func (m *thumbnailManager) remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.removeElement(elem)
+	}
+}
+
+// evict removes the least recently used thumbnails until the cache size is
+// below the limit. The caller must hold the lock
+func (m *thumbnailManager) evict() {
+	for m.cacheSize > m.maxCacheSize {
+		elem := m.lru.Back()
+		if elem == nil {
+			return
+		}
+		m.removeElement(elem)
+	}
+}
+
+func (m *thumbnailManager) removeElement(elem *list.Element) {
+	entry := elem.Value.(*thumbnailCacheEntry)
+	m.lru.Remove(elem)
+	delete(m.entries, entry.key)
+	m.cacheSize -= entry.size
+	if err := os.Remove(m.getCacheFilePath(entry.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(logSender, "", "unable to remove cached thumbnail %q: %v", entry.key, err)
+	}
+}
+
+func (m *thumbnailManager) generate(connection *Connection, name string, size int, method string) ([]byte, error) {
+	m.sem <- struct{}{}
+	defer func() { <-m.sem }()
+
+	reader, err := connection.getFileReader(name, 0, method)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var img image.Image
+	switch m.getThumbnailType(name) {
+	case thumbnailTypeImage:
+		img, err = m.decodeImage(reader)
+	case thumbnailTypePDF:
+		img, err = m.renderWithCommand(reader, thumbnailTypePDF, size)
+	case thumbnailTypeVideo:
+		img, err = m.renderWithCommand(reader, thumbnailTypeVideo, size)
+	default:
+		err = errThumbnailUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, resizeThumbnail(img, size), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (m *thumbnailManager) decodeImage(reader io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, m.maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > m.maxFileSize {
+		return nil, errThumbnailTooLarge
+	}
+	// check the image dimensions before decoding to avoid decompression bombs
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errThumbnailInvalidSource, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > thumbnailMaxPixels {
+		return nil, errThumbnailTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errThumbnailInvalidSource, err)
+	}
+	return img, nil
+}
+
+// renderWithCommand copies the source file to a temporary file and uses the
+// configured external command to render a PNG image from it
+func (m *thumbnailManager) renderWithCommand(reader io.Reader, thumbnailType string, size int) (image.Image, error) {
+	src, err := os.CreateTemp(m.cachePath, "src-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(src.Name())
+
+	n, err := io.Copy(src, io.LimitReader(reader, m.maxFileSize+1))
+	if errClose := src.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > m.maxFileSize {
+		return nil, errThumbnailTooLarge
+	}
+	outPrefix := strings.TrimSuffix(src.Name(), ".tmp") + "-out"
+	outFile := outPrefix + ".png"
+	defer os.Remove(outFile)
+
+	var cmdPath string
+	var args []string
+	switch thumbnailType {
+	case thumbnailTypePDF:
+		cmdPath = m.pdfCommand
+		args = []string{"-png", "-singlefile", "-f", "1", "-l", "1", "-scale-to", strconv.Itoa(size * 2),
+			src.Name(), outPrefix}
+	default:
+		cmdPath = m.videoCommand
+		args = []string{"-y", "-loglevel", "error", "-skip_frame", "nokey", "-i", src.Name(), "-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", size*2), outFile}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailCommandTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	cmd := exec.CommandContext(ctx, cmdPath, args...)
+	out, err := cmd.CombinedOutput()
+	logger.Debug(logSender, "", "executed thumbnail command %q, elapsed: %s, error: %v", cmdPath,
+		time.Since(startTime), err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render thumbnail: %w, output: %s", err, bytes.TrimSpace(out))
+	}
+	f, err := os.Open(outFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errThumbnailInvalidSource, err)
+	}
+	return img, nil
+}
+
+// resizeThumbnail scales the source image so that its largest side is at most
+// maxSize pixels. Images are never upscaled and transparent areas are filled
+// with a white background, JPEG has no alpha channel
+func resizeThumbnail(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW > maxSize || srcH > maxSize {
+		if srcW >= srcH {
+			dstW = maxSize
+			dstH = max(1, srcH*maxSize/srcW)
+		} else {
+			dstH = maxSize
+			dstW = max(1, srcW*maxSize/srcH)
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	if dstW == 0 || dstH == 0 {
+		return dst
+	}
+	// average a grid of up to 4x4 samples for each destination pixel
+	samplesX := min(4, max(1, srcW/dstW))
+	samplesY := min(4, max(1, srcH/dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var r, g, b uint64
+			for sy := 0; sy < samplesY; sy++ {
+				srcY := bounds.Min.Y + (y*samplesY+sy)*srcH/(dstH*samplesY)
+				for sx := 0; sx < samplesX; sx++ {
+					srcX := bounds.Min.X + (x*samplesX+sx)*srcW/(dstW*samplesX)
+					cr, cg, cb, ca := src.At(srcX, srcY).RGBA()
+					// colors are alpha-premultiplied, blend them over a white background
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+				}
+			}
+			count := uint64(samplesX * samplesY)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r / count) >> 8),
+				G: uint8((g / count) >> 8),
+				B: uint8((b / count) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+func getThumbnailSize(r *http.Request) (int, error) {
+	sizeParam := r.URL.Query().Get("size")
+	if sizeParam == "" {
+		return thumbnailDefaultSize, nil
+	}
+	size, err := strconv.Atoi(sizeParam)
+	if err != nil || !util.Contains(thumbnailSizes, size) {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid thumbnail size %q, supported sizes: %v",
+			sizeParam, thumbnailSizes))
+	}
+	return size, nil
+}
+
+func serveThumbnail(w http.ResponseWriter, r *http.Request, connection *Connection, name string, info os.FileInfo) {
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Please set the path to a valid file, %q is a directory", name),
+			http.StatusBadRequest)
+		return
+	}
+	size, err := getThumbnailSize(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if !thumbnailsMgr.isSupported(name, info) {
+		sendAPIResponse(w, r, errThumbnailUnsupported, "", http.StatusBadRequest)
+		return
+	}
+	if err := connection.checkReadAllowed(name, connection.GetTransferQuota()); err != nil {
+		sendAPIResponse(w, r, err, "", getMappedStatusCode(err))
+		return
+	}
+
+	key := thumbnailsMgr.getCacheKey(connection.GetUsername(), name, info, size)
+	data, ok := thumbnailsMgr.get(key)
+	if !ok {
+		connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+		data, err = thumbnailsMgr.generate(connection, name, size, r.Method)
+		if err != nil {
+			connection.Log(logger.LevelDebug, "unable to generate thumbnail for %q: %v", name, err)
+			status := getMappedStatusCode(err)
+			if errors.Is(err, errThumbnailInvalidSource) || errors.Is(err, errThumbnailTooLarge) {
+				status = http.StatusUnprocessableEntity
+			}
+			sendAPIResponse(w, r, err, "Unable to generate thumbnail", status)
+			return
+		}
+		if err := thumbnailsMgr.add(key, data); err != nil {
+			connection.Log(logger.LevelWarn, "unable to cache thumbnail for %q: %v", name, err)
+		}
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", fmt.Sprintf("%q", key))
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+func getUserThumbnail(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if thumbnailsMgr == nil {
+		sendAPIResponse(w, r, errThumbnailsDisabled, "", http.StatusNotFound)
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	serveThumbnail(w, r, connection, name, info)
+}
+
+func (s *httpdServer) getBrowsableShareThumbnail(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if thumbnailsMgr == nil {
+		sendAPIResponse(w, r, errThumbnailsDisabled, "", http.StatusNotFound)
+		return
+	}
+	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeRead, dataprovider.ShareScopeReadWrite}
+	share, connection, err := s.checkPublicShare(w, r, validScopes)
+	if err != nil {
+		return
+	}
+	if err := validateBrowsableShare(share, connection); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	name, err := getBrowsableSharedPath(share.Paths[0], r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	if err = common.Connections.Add(connection); err != nil {
+		sendAPIResponse(w, r, err, "Unable to add connection", http.StatusTooManyRequests)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	info, err := connection.Stat(name, 1)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	serveThumbnail(w, r, connection, name, info)
+}
@@ -0,0 +1,366 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	trustedDeviceCookieKey = "trusted_device"
+)
+
+var (
+	trustedDevicesMgr trustedDeviceManager
+)
+
+type trustedDeviceManager interface {
+	Add(device *trustedDevice) error
+	Get(username string, isAdmin bool, id string) (*trustedDevice, error)
+	List(username string, isAdmin bool) ([]*trustedDevice, error)
+	Delete(username string, isAdmin bool, id string) error
+	Cleanup()
+}
+
+// newTrustedDeviceManager returns the trusted device manager. Trusted devices
+// are always persisted within the data provider, so they survive restarts and
+// can be listed and revoked from any node
+func newTrustedDeviceManager() trustedDeviceManager {
+	return &dbTrustedDeviceManager{}
+}
+
+// trustedDevice is a device that can skip the second factor authentication
+// until it expires. The fingerprint is derived from the TOTP secret so
+// changing or disabling the second factor revokes all the trusted devices
+type trustedDevice struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	IsAdmin     bool      `json:"is_admin"`
+	Fingerprint string    `json:"fingerprint"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func newTrustedDevice(username, fingerprint, ip, userAgent string, isAdmin bool, maxAge int) *trustedDevice {
+	now := time.Now().UTC()
+	return &trustedDevice{
+		ID:          util.GenerateUniqueID(),
+		Username:    username,
+		IsAdmin:     isAdmin,
+		Fingerprint: fingerprint,
+		IP:          ip,
+		UserAgent:   userAgent,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(getTrustedDeviceLifetime(maxAge)),
+	}
+}
+
+func (d *trustedDevice) getInfo(currentID string) trustedDeviceInfo {
+	return trustedDeviceInfo{
+		ID:        d.ID,
+		IP:        d.IP,
+		UserAgent: d.UserAgent,
+		CreatedAt: util.GetTimeAsMsSinceEpoch(d.CreatedAt),
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(d.ExpiresAt),
+		Current:   d.ID == currentID,
+	}
+}
+
+func (d *trustedDevice) getKey() string {
+	return getTrustedDeviceKeyPrefix(d.Username, d.IsAdmin) + d.ID
+}
+
+func (d *trustedDevice) isExpired() bool {
+	return d.ExpiresAt.Before(time.Now().UTC())
+}
+
+// isValidFor checks the device against the given account. The max age is
+// checked again so lowering it in the configuration also affects existing devices
+func (d *trustedDevice) isValidFor(username, fingerprint string, isAdmin bool, maxAge int) bool {
+	if d.isExpired() || time.Since(d.CreatedAt) > getTrustedDeviceLifetime(maxAge) {
+		return false
+	}
+	return d.Username == username && d.IsAdmin == isAdmin && d.Fingerprint == fingerprint
+}
+
+// trustedDeviceInfo is the trusted device representation returned to the
+// account owner. The fingerprint is never exposed
+type trustedDeviceInfo struct {
+	ID        string `json:"id"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	Current   bool   `json:"current,omitempty"`
+}
+
+// dbTrustedDeviceManager stores the trusted devices as shared sessions. The
+// session keys are prefixed with a digest of the account so the devices for
+// a given account can be listed without loading the other ones
+type dbTrustedDeviceManager struct{}
+
+func (m *dbTrustedDeviceManager) Add(device *trustedDevice) error {
+	session := dataprovider.Session{
+		Key:       device.getKey(),
+		Data:      device,
+		Type:      dataprovider.SessionTypeTrustedDevice,
+		Timestamp: util.GetTimeAsMsSinceEpoch(device.ExpiresAt),
+	}
+	return dataprovider.AddSharedSession(session)
+}
+
+func (m *dbTrustedDeviceManager) Get(username string, isAdmin bool, id string) (*trustedDevice, error) {
+	session, err := dataprovider.GetSharedSession(getTrustedDeviceKeyPrefix(username, isAdmin) + id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Timestamp < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		// expired
+		return nil, util.NewRecordNotFoundError("trusted device expired")
+	}
+	return m.decodeData(session.Data)
+}
+
+// List returns the not expired devices for the specified account, the most
+// recent first
+func (m *dbTrustedDeviceManager) List(username string, isAdmin bool) ([]*trustedDevice, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeTrustedDevice,
+		getTrustedDeviceKeyPrefix(username, isAdmin))
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*trustedDevice, 0, len(sessions))
+	for _, session := range sessions {
+		device, err := m.decodeData(session.Data)
+		if err != nil {
+			continue
+		}
+		if device.Username != username || device.IsAdmin != isAdmin || device.isExpired() {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].CreatedAt.After(devices[j].CreatedAt)
+	})
+	return devices, nil
+}
+
+func (m *dbTrustedDeviceManager) decodeData(data any) (*trustedDevice, error) {
+	if val, ok := data.([]byte); ok {
+		d := &trustedDevice{}
+		err := json.Unmarshal(val, d)
+		return d, err
+	}
+	logger.Error(logSender, "", "invalid trusted device data type %T", data)
+	return nil, util.NewRecordNotFoundError("invalid trusted device")
+}
+
+func (m *dbTrustedDeviceManager) Delete(username string, isAdmin bool, id string) error {
+	return dataprovider.DeleteSharedSession(getTrustedDeviceKeyPrefix(username, isAdmin) + id)
+}
+
+func (m *dbTrustedDeviceManager) Cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeTrustedDevice, time.Now()) //nolint:errcheck
+}
+
+func getTrustedDeviceLifetime(maxAge int) time.Duration {
+	return time.Duration(maxAge) * 24 * time.Hour
+}
+
+// getTrustedDeviceKeyPrefix returns the key prefix for the devices of the
+// specified account. Usernames are hashed to fit within the session key size
+func getTrustedDeviceKeyPrefix(username string, isAdmin bool) string {
+	role := "user"
+	if isAdmin {
+		role = "admin"
+	}
+	digest := sha256.Sum256([]byte(role + ":" + username))
+	return hex.EncodeToString(digest[:]) + "."
+}
+
+func getTrustedDeviceFingerprint(configName, secret string) string {
+	digest := sha256.Sum256([]byte(configName + ":" + secret))
+	return hex.EncodeToString(digest[:])
+}
+
+func setTrustedDeviceCookie(w http.ResponseWriter, r *http.Request, csrfTokenAuth *jwtauth.JWTAuth,
+	device *trustedDevice, cookiePath string,
+) error {
+	claims := make(map[string]any)
+	now := time.Now().UTC()
+
+	claims[jwt.JwtIDKey] = device.ID
+	claims[jwt.NotBeforeKey] = now.Add(-30 * time.Second)
+	claims[jwt.ExpirationKey] = device.ExpiresAt
+	claims[jwt.AudienceKey] = []string{tokenAudienceTrustedDevice}
+	claims[claimUsernameKey] = device.Username
+
+	_, tokenString, err := csrfTokenAuth.Encode(claims)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     trustedDeviceCookieKey,
+		Value:    tokenString,
+		Path:     cookiePath,
+		Expires:  device.ExpiresAt,
+		MaxAge:   int(time.Until(device.ExpiresAt) / time.Second),
+		HttpOnly: true,
+		Secure:   isTLS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+func removeTrustedDeviceCookie(w http.ResponseWriter, r *http.Request, cookiePath string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     trustedDeviceCookieKey,
+		Value:    "",
+		Path:     cookiePath,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isTLS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// getTrustedDeviceFromCookie returns the trusted device referenced by the
+// signed device cookie, if any
+func getTrustedDeviceFromCookie(r *http.Request, csrfTokenAuth *jwtauth.JWTAuth, isAdmin bool) (*trustedDevice, error) {
+	cookie, err := r.Cookie(trustedDeviceCookieKey)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwtauth.VerifyToken(csrfTokenAuth, cookie.Value)
+	if err != nil || token == nil {
+		return nil, errors.New("invalid trusted device cookie")
+	}
+	if !util.Contains(token.Audience(), tokenAudienceTrustedDevice) {
+		return nil, errors.New("invalid trusted device cookie audience")
+	}
+	claim, _ := token.Get(claimUsernameKey)
+	username, ok := claim.(string)
+	if !ok || username == "" {
+		return nil, errors.New("invalid trusted device cookie username")
+	}
+	device, err := trustedDevicesMgr.Get(username, isAdmin, token.JwtID())
+	if err != nil {
+		return nil, err
+	}
+	if username != device.Username || isAdmin != device.IsAdmin {
+		return nil, errors.New("trusted device cookie does not match")
+	}
+	return device, nil
+}
+
+// isTrustedDevice returns true if the request comes from a device remembered
+// for the specified account and second factor configuration.
+// Invalid devices are removed
+func isTrustedDevice(w http.ResponseWriter, r *http.Request, csrfTokenAuth *jwtauth.JWTAuth,
+	username, fingerprint, cookiePath string, isAdmin bool, maxAge int,
+) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	device, err := getTrustedDeviceFromCookie(r, csrfTokenAuth, isAdmin)
+	if err != nil {
+		return false
+	}
+	if !device.isValidFor(username, fingerprint, isAdmin, maxAge) {
+		logger.Debug(logSender, "", "trusted device %q is not valid for %q, is admin? %t, removing",
+			device.ID, username, isAdmin)
+		trustedDevicesMgr.Delete(device.Username, device.IsAdmin, device.ID) //nolint:errcheck
+		removeTrustedDeviceCookie(w, r, cookiePath)
+		return false
+	}
+	return true
+}
+
+// rememberDevice stores a new trusted device and sets the related cookie
+func rememberDevice(w http.ResponseWriter, r *http.Request, csrfTokenAuth *jwtauth.JWTAuth,
+	username, fingerprint, cookiePath string, isAdmin bool, maxAge int,
+) {
+	if maxAge <= 0 {
+		return
+	}
+	// replace any existing device for this browser
+	if device, err := getTrustedDeviceFromCookie(r, csrfTokenAuth, isAdmin); err == nil {
+		trustedDevicesMgr.Delete(device.Username, device.IsAdmin, device.ID) //nolint:errcheck
+	}
+	device := newTrustedDevice(username, fingerprint, util.GetIPFromRemoteAddress(r.RemoteAddr), r.UserAgent(),
+		isAdmin, maxAge)
+	if err := trustedDevicesMgr.Add(device); err != nil {
+		logger.Warn(logSender, "", "unable to save trusted device for %q: %v", username, err)
+		return
+	}
+	if err := setTrustedDeviceCookie(w, r, csrfTokenAuth, device, cookiePath); err != nil {
+		logger.Warn(logSender, "", "unable to set trusted device cookie for %q: %v", username, err)
+		trustedDevicesMgr.Delete(device.Username, device.IsAdmin, device.ID) //nolint:errcheck
+		return
+	}
+	logger.Debug(logSender, "", "device %q remembered for %q, is admin? %t, expiration: %s",
+		device.ID, username, isAdmin, device.ExpiresAt)
+}
+
+// listTrustedDevices returns the trusted devices for the specified account.
+// The device used for the current request, if any, is marked
+func listTrustedDevices(r *http.Request, csrfTokenAuth *jwtauth.JWTAuth, username string, isAdmin bool,
+) ([]trustedDeviceInfo, error) {
+	devices, err := trustedDevicesMgr.List(username, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	currentID := ""
+	if device, err := getTrustedDeviceFromCookie(r, csrfTokenAuth, isAdmin); err == nil {
+		currentID = device.ID
+	}
+	result := make([]trustedDeviceInfo, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, device.getInfo(currentID))
+	}
+	return result, nil
+}
+
+// deleteTrustedDevice removes the specified trusted device if it belongs to
+// the given account
+func deleteTrustedDevice(id, username string, isAdmin bool) error {
+	device, err := trustedDevicesMgr.Get(username, isAdmin, id)
+	if err != nil {
+		return err
+	}
+	if device.Username != username || device.IsAdmin != isAdmin {
+		return util.NewRecordNotFoundError(fmt.Sprintf("trusted device %q does not exist", id))
+	}
+	logger.Debug(logSender, "", "revoking trusted device %q for %q, is admin? %t", id, username, isAdmin)
+	return trustedDevicesMgr.Delete(username, isAdmin, id)
+}
@@ -0,0 +1,782 @@
+// Copyright (C) 2024 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// tus resumable upload protocol, see https://tus.io/protocols/resumable-upload
+const (
+	tusVersion             = "1.0.0"
+	tusExtensions          = "creation,expiration,checksum,termination,concatenation"
+	tusChecksumAlgorithms  = "md5,sha1,sha256,sha512"
+	tusUploadExpiration    = 24 * time.Hour
+	tusContentType         = "application/offset+octet-stream"
+	tusStatusChecksumError = 460
+	tusStagingDirName      = "sftpgo-tus"
+	tusInfoFileExt         = ".info"
+	tusDataFileExt         = ".bin"
+	tusMaxConcatParts      = 1000
+)
+
+var (
+	tusUploadsMgr          = newTusUploadManager()
+	errTusUploadLocked     = errors.New("the upload is locked by another request")
+	errTusChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// tusUpload defines a resumable upload. The uploaded data are staged on the
+// local filesystem and moved to the user's filesystem, using the same code
+// path of the other uploads, once all the declared bytes are received.
+// Partial uploads have no target path, they are concatenated, and so moved
+// to the user's filesystem, by a final upload. This allows clients to upload
+// the parts of a file in parallel
+type tusUpload struct {
+	ID           string            `json:"id"`
+	Username     string            `json:"username"`
+	Path         string            `json:"path,omitempty"`
+	Size         int64             `json:"size"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	MkdirParents bool              `json:"mkdir_parents,omitempty"`
+	IsPartial    bool              `json:"is_partial,omitempty"`
+	Parts        []string          `json:"-"`
+	CreatedAt    int64             `json:"created_at"`
+	ExpiresAt    int64             `json:"expires_at"`
+}
+
+func (u *tusUpload) isExpired() bool {
+	return u.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (u *tusUpload) getExpiration() string {
+	return util.GetTimeFromMsecSinceEpoch(u.ExpiresAt).UTC().Format(http.TimeFormat)
+}
+
+// tusUploadManager tracks the resumable uploads. The upload info and data
+// are stored on disk, so uploads can be resumed after a service restart
+type tusUploadManager struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func newTusUploadManager() *tusUploadManager {
+	return &tusUploadManager{
+		locked: make(map[string]bool),
+	}
+}
+
+func (m *tusUploadManager) getStagingDir() string {
+	tempPath := common.Config.TempPath
+	if tempPath == "" {
+		tempPath = os.TempDir()
+	}
+	return filepath.Join(tempPath, tusStagingDirName)
+}
+
+func (m *tusUploadManager) getInfoPath(id string) string {
+	return filepath.Join(m.getStagingDir(), id+tusInfoFileExt)
+}
+
+func (m *tusUploadManager) getDataPath(id string) string {
+	return filepath.Join(m.getStagingDir(), id+tusDataFileExt)
+}
+
+func (m *tusUploadManager) create(upload *tusUpload) error {
+	if err := os.MkdirAll(m.getStagingDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.getDataPath(upload.ID), nil, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.getInfoPath(upload.ID), data, 0600); err != nil {
+		os.Remove(m.getDataPath(upload.ID)) //nolint:errcheck
+		return err
+	}
+	return nil
+}
+
+// get returns the upload with the specified ID and the current offset
+func (m *tusUploadManager) get(id, username string) (tusUpload, int64, error) {
+	var upload tusUpload
+
+	if _, err := xid.FromString(id); err != nil {
+		return upload, 0, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+	}
+	data, err := os.ReadFile(m.getInfoPath(id))
+	if err != nil {
+		return upload, 0, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+	if upload.Username != username {
+		return upload, 0, util.NewRecordNotFoundError(fmt.Sprintf("upload %q not found", id))
+	}
+	if upload.isExpired() {
+		m.remove(id)
+		return upload, 0, util.NewRecordNotFoundError(fmt.Sprintf("upload %q expired", id))
+	}
+	info, err := os.Stat(m.getDataPath(id))
+	if err != nil {
+		return upload, 0, err
+	}
+	return upload, info.Size(), nil
+}
+
+func (m *tusUploadManager) lock(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locked[id] {
+		return errTusUploadLocked
+	}
+	m.locked[id] = true
+	return nil
+}
+
+func (m *tusUploadManager) unlock(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.locked, id)
+}
+
+func (m *tusUploadManager) remove(id string) {
+	os.Remove(m.getDataPath(id)) //nolint:errcheck
+	os.Remove(m.getInfoPath(id)) //nolint:errcheck
+}
+
+// cleanup removes the expired uploads and the orphaned data files
+func (m *tusUploadManager) cleanup() {
+	entries, err := os.ReadDir(m.getStagingDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch filepath.Ext(name) {
+		case tusInfoFileExt:
+			id := strings.TrimSuffix(name, tusInfoFileExt)
+			data, err := os.ReadFile(m.getInfoPath(id))
+			if err != nil {
+				continue
+			}
+			var upload tusUpload
+			if err := json.Unmarshal(data, &upload); err != nil || upload.isExpired() {
+				logger.Debug(logSender, "", "removing expired tus upload %q", id)
+				m.remove(id)
+			}
+		case tusDataFileExt:
+			id := strings.TrimSuffix(name, tusDataFileExt)
+			if _, err := os.Stat(m.getInfoPath(id)); err == nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err == nil && info.ModTime().Before(time.Now().Add(-tusUploadExpiration)) {
+				logger.Debug(logSender, "", "removing orphaned tus upload data %q", name)
+				m.remove(id)
+			}
+		}
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header. It consists of comma
+// separated key/value pairs, the key and the value are separated by a space
+// and the value is base64 encoded. The value is optional
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, util.NewValidationError("invalid upload metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid value for upload metadata %q", key))
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseTusChecksum parses the Upload-Checksum header, the checksum algorithm
+// and the base64 encoded checksum are separated by a space
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	algo, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, nil, util.NewValidationError("invalid upload checksum")
+	}
+	checksum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, util.NewValidationError("invalid upload checksum")
+	}
+	switch algo {
+	case "md5":
+		return md5.New(), checksum, nil
+	case "sha1":
+		return sha1.New(), checksum, nil
+	case "sha256":
+		return sha256.New(), checksum, nil
+	case "sha512":
+		return sha512.New(), checksum, nil
+	default:
+		return nil, nil, util.NewValidationError(fmt.Sprintf("unsupported checksum algorithm %q", algo))
+	}
+}
+
+// parseTusConcatParts returns the upload IDs from the space separated list of
+// partial upload URLs
+func parseTusConcatParts(parts string) ([]string, error) {
+	var ids []string
+	for _, part := range strings.Fields(parts) {
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid partial upload URL %q", part))
+		}
+		id := path.Base(u.Path)
+		if _, err := xid.FromString(id); err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid partial upload URL %q", part))
+		}
+		if util.Contains(ids, id) {
+			return nil, util.NewValidationError(fmt.Sprintf("duplicated partial upload %q", id))
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > tusMaxConcatParts {
+		return nil, util.NewValidationError(fmt.Sprintf("the number of partial uploads must be between 1 and %d",
+			tusMaxConcatParts))
+	}
+	return ids, nil
+}
+
+func parseTusInt64Header(r *http.Request, name string) (int64, error) {
+	val, err := strconv.ParseInt(r.Header.Get(name), 10, 64)
+	if err != nil || val < 0 {
+		return 0, util.NewValidationError(fmt.Sprintf("invalid %s header", name))
+	}
+	return val, nil
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusResumable returns false, after sending the response, if the client
+// protocol version is not supported
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		sendAPIResponse(w, r, nil, "Unsupported tus protocol version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func getTusUploadPath(r *http.Request, metadata map[string]string) string {
+	if r.URL.Query().Has("path") {
+		return r.URL.Query().Get("path")
+	}
+	if val, ok := metadata["path"]; ok {
+		return val
+	}
+	if val, ok := metadata["filename"]; ok && val != "" {
+		return path.Base(util.CleanPath(val))
+	}
+	return ""
+}
+
+func newTusUpload(r *http.Request, username, filePath string, size int64, metadata map[string]string) tusUpload {
+	now := time.Now()
+	return tusUpload{
+		ID:           xid.New().String(),
+		Username:     username,
+		Path:         filePath,
+		Size:         size,
+		Metadata:     metadata,
+		MkdirParents: getBoolQueryParam(r, "mkdir_parents") || metadata["mkdir_parents"] == "true",
+		CreatedAt:    util.GetTimeAsMsSinceEpoch(now),
+		ExpiresAt:    util.GetTimeAsMsSinceEpoch(now.Add(tusUploadExpiration)),
+	}
+}
+
+func tusOptions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	if maxUploadFileSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadFileSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !checkTusResumable(w, r) {
+		return
+	}
+	concat := r.Header.Get("Upload-Concat")
+	if parts, ok := strings.CutPrefix(concat, "final;"); ok {
+		concatenateTusUploads(w, r, parts)
+		return
+	}
+	if concat != "" && concat != "partial" {
+		sendAPIResponse(w, r, nil, "Invalid Upload-Concat header", http.StatusBadRequest)
+		return
+	}
+	isPartial := concat == "partial"
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		sendAPIResponse(w, r, nil, "Deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	size, err := parseTusInt64Header(r, "Upload-Length")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if maxUploadFileSize > 0 && size > maxUploadFileSize {
+		sendAPIResponse(w, r, nil, "The upload length exceeds the maximum allowed size",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	uploadPath := getTusUploadPath(r, metadata)
+	if uploadPath == "" && !isPartial {
+		sendAPIResponse(w, r, errors.New("please set a file path"), "", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	var filePath string
+	if isPartial {
+		err = connection.checkTusPartialUpload(size)
+	} else {
+		filePath = connection.User.GetCleanedPath(uploadPath)
+		err = connection.checkTusUpload(filePath, size)
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to upload file %q", filePath), getMappedStatusCode(err))
+		return
+	}
+	upload := newTusUpload(r, connection.User.Username, filePath, size, metadata)
+	upload.IsPartial = isPartial
+	if err := tusUploadsMgr.create(&upload); err != nil {
+		connection.Log(logger.LevelError, "unable to create tus upload for file %q: %v", filePath, err)
+		sendAPIResponse(w, r, err, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+	connection.Log(logger.LevelDebug, "tus upload %q created for file %q, size: %d, partial: %t", upload.ID, filePath,
+		size, isPartial)
+	if size == 0 && !isPartial {
+		if err := connection.finalizeTusUpload(&upload, r); err != nil {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %q", filePath), getMappedStatusCode(err))
+			return
+		}
+	}
+	w.Header().Set("Location", path.Join(r.URL.Path, upload.ID))
+	w.Header().Set("Upload-Expires", upload.getExpiration())
+	sendAPIResponse(w, r, nil, "Upload created", http.StatusCreated)
+}
+
+// concatenateTusUploads writes the concatenation of the specified partial
+// uploads to the target path. The partial uploads are retained on error so the
+// client can retry the concatenation
+func concatenateTusUploads(w http.ResponseWriter, r *http.Request, partialUploads string) {
+	if r.Header.Get("Upload-Length") != "" {
+		sendAPIResponse(w, r, nil, "The upload length is not allowed for the final upload", http.StatusBadRequest)
+		return
+	}
+	ids, err := parseTusConcatParts(partialUploads)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	uploadPath := getTusUploadPath(r, metadata)
+	if uploadPath == "" {
+		sendAPIResponse(w, r, errors.New("please set a file path"), "", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	for idx, id := range ids {
+		if err := tusUploadsMgr.lock(id); err != nil {
+			for _, lockedID := range ids[:idx] {
+				tusUploadsMgr.unlock(lockedID)
+			}
+			sendAPIResponse(w, r, err, "", http.StatusLocked)
+			return
+		}
+	}
+	defer func() {
+		for _, id := range ids {
+			tusUploadsMgr.unlock(id)
+		}
+	}()
+
+	var size int64
+	for _, id := range ids {
+		part, offset, err := tusUploadsMgr.get(id, connection.User.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		if !part.IsPartial || offset != part.Size {
+			sendAPIResponse(w, r, nil, fmt.Sprintf("Upload %q is not a completed partial upload", id),
+				http.StatusBadRequest)
+			return
+		}
+		size += part.Size
+	}
+	if maxUploadFileSize > 0 && size > maxUploadFileSize {
+		sendAPIResponse(w, r, nil, "The upload length exceeds the maximum allowed size",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	filePath := connection.User.GetCleanedPath(uploadPath)
+	if err := connection.checkTusUpload(filePath, size); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to upload file %q", filePath), getMappedStatusCode(err))
+		return
+	}
+	upload := newTusUpload(r, connection.User.Username, filePath, size, metadata)
+	upload.Parts = ids
+	if err := connection.finalizeTusUpload(&upload, r); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %q", filePath), getMappedStatusCode(err))
+		return
+	}
+	w.Header().Set("Location", path.Join(r.URL.Path, upload.ID))
+	sendAPIResponse(w, r, nil, "Upload created", http.StatusCreated)
+}
+
+func getTusUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	upload, offset, err := tusUploadsMgr.get(getURLParam(r, "id"), connection.User.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("Upload-Expires", upload.getExpiration())
+	if upload.IsPartial {
+		w.Header().Set("Upload-Concat", "partial")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func appendTusUploadData(w http.ResponseWriter, r *http.Request) {
+	if maxUploadFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileSize)
+	}
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Content type must be %q", tusContentType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := parseTusInt64Header(r, "Upload-Offset")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	var checksumHash hash.Hash
+	var checksum []byte
+	if val := r.Header.Get("Upload-Checksum"); val != "" {
+		checksumHash, checksum, err = parseTusChecksum(val)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	id := getURLParam(r, "id")
+	if err := tusUploadsMgr.lock(id); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusLocked)
+		return
+	}
+	defer tusUploadsMgr.unlock(id)
+
+	upload, currentOffset, err := tusUploadsMgr.get(id, connection.User.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if offset != currentOffset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(currentOffset, 10))
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Offset mismatch, expected: %d", currentOffset), http.StatusConflict)
+		return
+	}
+	if r.ContentLength > 0 && offset+r.ContentLength > upload.Size {
+		sendAPIResponse(w, r, nil, "The data exceed the upload length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	written, err := connection.writeTusUploadData(&upload, offset, r.Body, checksumHash, checksum)
+	offset += written
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", upload.getExpiration())
+	if err != nil {
+		if errors.Is(err, errTusChecksumMismatch) {
+			sendAPIResponse(w, r, err, "", tusStatusChecksumError)
+			return
+		}
+		sendAPIResponse(w, r, err, "Error saving upload data", getMappedStatusCode(err))
+		return
+	}
+	if offset == upload.Size && !upload.IsPartial {
+		if err := connection.finalizeTusUpload(&upload, r); err != nil {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %q", upload.Path), getMappedStatusCode(err))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteTusUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !checkTusResumable(w, r) {
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	id := getURLParam(r, "id")
+	if err := tusUploadsMgr.lock(id); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusLocked)
+		return
+	}
+	defer tusUploadsMgr.unlock(id)
+
+	if _, _, err := tusUploadsMgr.get(id, connection.User.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	tusUploadsMgr.remove(id)
+	connection.Log(logger.LevelDebug, "tus upload %q terminated", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTusUpload checks, before accepting any data, if the upload is allowed.
+// The final write performs all the usual checks again
+func (c *Connection) checkTusUpload(filePath string, size int64) error {
+	if ok, _ := c.User.IsFileAllowed(filePath); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", filePath)
+		return c.GetPermissionDeniedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(filePath)) &&
+		!c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(filePath)) {
+		return c.GetPermissionDeniedError()
+	}
+	diskQuota, transferQuota := c.HasSpace(true, false, filePath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying tus upload for file %q due to quota limits", filePath)
+		return c.GetQuotaExceededError()
+	}
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, 0, false)
+	if maxWriteSize > 0 && size > maxWriteSize {
+		c.Log(logger.LevelInfo, "denying tus upload for file %q, size %d exceeds the allowed size %d",
+			filePath, size, maxWriteSize)
+		return c.GetQuotaExceededError()
+	}
+	allowedULSize := transferQuota.AllowedULSize
+	if transferQuota.TotalSize > 0 {
+		allowedULSize = transferQuota.AllowedTotalSize
+	}
+	if allowedULSize > 0 && size > allowedULSize {
+		c.Log(logger.LevelInfo, "denying tus upload for file %q, size %d exceeds the transfer quota", filePath, size)
+		return c.GetQuotaExceededError()
+	}
+	return nil
+}
+
+// checkTusPartialUpload checks if a partial upload is allowed, the checks
+// that depend on the target path are executed for the final upload
+func (c *Connection) checkTusPartialUpload(size int64) error {
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying tus partial upload due to transfer quota limits")
+		return c.GetQuotaExceededError()
+	}
+	if c.User.Filters.MaxUploadFileSize > 0 && size > c.User.Filters.MaxUploadFileSize {
+		c.Log(logger.LevelInfo, "denying tus partial upload, size %d exceeds the allowed size %d",
+			size, c.User.Filters.MaxUploadFileSize)
+		return c.GetQuotaExceededError()
+	}
+	return nil
+}
+
+// writeTusUploadData appends the request body to the staged data. If a checksum
+// is provided and it does not match, the received data are discarded
+func (c *Connection) writeTusUploadData(upload *tusUpload, offset int64, body io.ReadCloser, checksumHash hash.Hash,
+	checksum []byte,
+) (int64, error) {
+	dataPath := tusUploadsMgr.getDataPath(upload.ID)
+	f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		c.Log(logger.LevelError, "unable to open tus upload data %q: %v", dataPath, err)
+		return 0, err
+	}
+
+	t := newThrottledReader(body, c.User.UploadBandwidth, c)
+	defer c.RemoveTransfer(t)
+
+	var reader io.Reader = io.LimitReader(t, upload.Size-offset)
+	if checksumHash != nil {
+		reader = io.TeeReader(reader, checksumHash)
+	}
+	written, err := io.Copy(f, reader)
+	if err == nil && checksumHash != nil && string(checksumHash.Sum(nil)) != string(checksum) {
+		err = errTusChecksumMismatch
+	}
+	if err != nil && checksumHash != nil {
+		// data not verified, we have to discard them
+		if errTruncate := f.Truncate(offset); errTruncate != nil {
+			c.Log(logger.LevelError, "unable to truncate tus upload data %q: %v", dataPath, errTruncate)
+			f.Close() //nolint:errcheck
+			tusUploadsMgr.remove(upload.ID)
+			return 0, errTruncate
+		}
+		written = 0
+	}
+	if errClose := f.Close(); errClose != nil && err == nil {
+		err = errClose
+	}
+	c.Log(logger.LevelDebug, "tus upload %q, offset %d, written %d bytes, err: %v", upload.ID, offset, written, err)
+	return written, err
+}
+
+// finalizeTusUpload writes the staged data to the user's filesystem. The
+// staged data are retained on error so the client can retry sending an empty
+// request with the final offset
+func (c *Connection) finalizeTusUpload(upload *tusUpload, r *http.Request) error {
+	c.User.CheckFsRoot(c.ID) //nolint:errcheck
+	if upload.MkdirParents {
+		if err := c.CheckParentDirs(path.Dir(upload.Path)); err != nil {
+			return err
+		}
+	}
+	ids := upload.Parts
+	if len(ids) == 0 {
+		ids = []string{upload.ID}
+	}
+	readers := make([]io.Reader, 0, len(ids))
+	for _, id := range ids {
+		dataPath := tusUploadsMgr.getDataPath(id)
+		f, err := os.Open(dataPath)
+		if err != nil {
+			c.Log(logger.LevelError, "unable to open tus upload data %q: %v", dataPath, err)
+			return err
+		}
+		defer f.Close()
+
+		readers = append(readers, f)
+	}
+
+	// the bandwidth was already limited while receiving the data
+	c.User.UploadBandwidth = 0
+	writer, err := c.getFileWriter(upload.Path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, io.MultiReader(readers...))
+	if err != nil {
+		writer.Close() //nolint:errcheck
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	mTime := upload.Metadata["mtime"]
+	if mTime == "" {
+		mTime = r.Header.Get(mTimeHeader)
+	}
+	setModificationTime(c, upload.Path, mTime)
+	for _, id := range ids {
+		tusUploadsMgr.remove(id)
+	}
+	c.Log(logger.LevelDebug, "tus upload %q completed for file %q", upload.ID, upload.Path)
+	return nil
+}
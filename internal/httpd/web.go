@@ -17,6 +17,7 @@ package httpd
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/go-chi/render"
@@ -47,7 +48,8 @@ const (
 )
 
 var (
-	errInvalidTokenClaims = errors.New("invalid token claims")
+	errInvalidTokenClaims      = errors.New("invalid token claims")
+	errImpersonationNotAllowed = errors.New("this action is not allowed while impersonating a user")
 )
 
 type commonBasePage struct {
@@ -65,20 +67,53 @@ type loginPage struct {
 	AltLoginName   string
 	ForgotPwdURL   string
 	OpenIDLoginURL string
+	SAMLLoginURL   string
+	OIDCProviders  []loginPageProvider
+	OIDCEmailURL   string
 	Title          string
 	Branding       UIBranding
 	FormDisabled   bool
 	CheckRedirect  bool
 }
 
+type loginPageProvider struct {
+	Name string
+	URL  string
+}
+
+func (p *loginPage) setOIDCProviders(binding *Binding, loginURL string, isAdmin bool) {
+	isAvailable := func(provider *OIDC) bool {
+		if isAdmin {
+			return provider.hasRoles()
+		}
+		return provider.isEnabled()
+	}
+	if isAvailable(&binding.OIDC) {
+		p.OpenIDLoginURL = loginURL
+	}
+	for idx := range binding.OIDCProviders {
+		provider := &binding.OIDCProviders[idx]
+		if isAvailable(provider) {
+			p.OIDCProviders = append(p.OIDCProviders, loginPageProvider{
+				Name: provider.Name,
+				URL:  loginURL + "?provider=" + url.QueryEscape(provider.Name),
+			})
+		}
+	}
+	if binding.hasOIDCDomains(isAdmin) {
+		p.OIDCEmailURL = loginURL
+	}
+}
+
 type twoFactorPage struct {
 	commonBasePage
-	CurrentURL  string
-	Error       *util.I18nError
-	CSRFToken   string
-	RecoveryURL string
-	Title       string
-	Branding    UIBranding
+	CurrentURL          string
+	Error               *util.I18nError
+	CSRFToken           string
+	RecoveryURL         string
+	Title               string
+	Branding            UIBranding
+	TrustedDeviceMaxAge int
 }
 
 type forgotPwdPage struct {
@@ -132,8 +167,11 @@ func i18nListDirMsg(status int) string {
 }
 
 func i18nFsMsg(status int) string {
-	if status == http.StatusForbidden {
+	switch status {
+	case http.StatusForbidden:
 		return util.I18nError403Message
+	case http.StatusConflict:
+		return util.I18nErrorFsArchived
 	}
 	return util.I18nErrorFsGeneric
 }
@@ -16,6 +16,7 @@ package httpd
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -90,8 +91,11 @@ const (
 	templateEventAction      = "eventaction.html"
 	templateRoles            = "roles.html"
 	templateRole             = "role.html"
+	templateTenants          = "tenants.html"
+	templateTenant           = "tenant.html"
 	templateEvents           = "events.html"
 	templateStatus           = "status.html"
+	templateLiveEvents       = "liveevents.html"
 	templateDefender         = "defender.html"
 	templateIPLists          = "iplists.html"
 	templateIPList           = "iplist.html"
@@ -128,6 +132,7 @@ type basePage struct {
 	IPListsURL          string
 	IPListURL           string
 	EventsURL           string
+	LiveEventsURL       string
 	ConfigsURL          string
 	LogoutURL           string
 	LoginURL            string
@@ -140,6 +145,8 @@ type basePage struct {
 	EventActionURL      string
 	RolesURL            string
 	RoleURL             string
+	TenantsURL          string
+	TenantURL           string
 	FolderQuotaScanURL  string
 	StatusURL           string
 	MaintenanceURL      string
@@ -184,17 +191,19 @@ type userPage struct {
 	VirtualFolders     []vfs.BaseVirtualFolder
 	Groups             []dataprovider.Group
 	Roles              []dataprovider.Role
+	Tenants            []dataprovider.Tenant
 	CanImpersonate     bool
 	FsWrapper          fsWrapper
 }
 
 type adminPage struct {
 	basePage
-	Admin  *dataprovider.Admin
-	Groups []dataprovider.Group
-	Roles  []dataprovider.Role
-	Error  *util.I18nError
-	IsAdd  bool
+	Admin   *dataprovider.Admin
+	Groups  []dataprovider.Group
+	Roles   []dataprovider.Role
+	Tenants []dataprovider.Tenant
+	Error   *util.I18nError
+	IsAdd   bool
 }
 
 type profilePage struct {
@@ -218,6 +227,7 @@ type mfaPage struct {
 	ValidateTOTPURL  string
 	SaveTOTPURL      string
 	RecCodesURL      string
+	TrustedDevURL    string
 	RequireTwoFactor bool
 }
 
@@ -290,6 +300,13 @@ type rolePage struct {
 	Mode  genericPageMode
 }
 
+type tenantPage struct {
+	basePage
+	Tenant *dataprovider.Tenant
+	Error  *util.I18nError
+	Mode   genericPageMode
+}
+
 type eventActionPage struct {
 	basePage
 	Action         dataprovider.BaseEventAction
@@ -322,6 +339,11 @@ type eventsPage struct {
 	LogEventsSearchURL      string
 }
 
+type liveEventsPage struct {
+	basePage
+	LiveEventsStreamURL string
+}
+
 type configsPage struct {
 	basePage
 	Configs           dataprovider.Configs
@@ -434,6 +456,11 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateStatus),
 	}
+	liveEventsPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateLiveEvents),
+	}
 	loginPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBaseLogin),
@@ -499,6 +526,16 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateRole),
 	}
+	tenantsPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateTenants),
+	}
+	tenantPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateTenant),
+	}
 	eventsPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -543,8 +580,11 @@ func loadAdminTemplates(templatesPath string) {
 	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
 	rolesTmpl := util.LoadTemplate(nil, rolesPaths...)
 	roleTmpl := util.LoadTemplate(nil, rolePaths...)
+	tenantsTmpl := util.LoadTemplate(nil, tenantsPaths...)
+	tenantTmpl := util.LoadTemplate(nil, tenantPaths...)
 	eventsTmpl := util.LoadTemplate(nil, eventsPaths...)
 	configsTmpl := util.LoadTemplate(nil, configsPaths...)
+	liveEventsTmpl := util.LoadTemplate(nil, liveEventsPaths...)
 
 	adminTemplates[templateUsers] = usersTmpl
 	adminTemplates[templateUser] = userTmpl
@@ -561,6 +601,7 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateEventActions] = eventActionsTmpl
 	adminTemplates[templateEventAction] = eventActionTmpl
 	adminTemplates[templateStatus] = statusTmpl
+	adminTemplates[templateLiveEvents] = liveEventsTmpl
 	adminTemplates[templateCommonLogin] = loginTmpl
 	adminTemplates[templateProfile] = profileTmpl
 	adminTemplates[templateChangePwd] = changePwdTmpl
@@ -576,6 +617,8 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateResetPassword] = resetPwdTmpl
 	adminTemplates[templateRoles] = rolesTmpl
 	adminTemplates[templateRole] = roleTmpl
+	adminTemplates[templateTenants] = tenantsTmpl
+	adminTemplates[templateTenant] = tenantTmpl
 	adminTemplates[templateEvents] = eventsTmpl
 	adminTemplates[templateConfigs] = configsTmpl
 }
@@ -611,7 +654,7 @@ func isIPListsResource(currentURL string) bool {
 
 func isServerManagerResource(currentURL string) bool {
 	return currentURL == webEventsPath || currentURL == webStatusPath || currentURL == webMaintenancePath ||
-		currentURL == webConfigsPath
+		currentURL == webConfigsPath || currentURL == webLiveEventsPath
 }
 
 func (s *httpdServer) getBasePageData(title, currentURL string, w http.ResponseWriter, r *http.Request) basePage {
@@ -637,6 +680,7 @@ func (s *httpdServer) getBasePageData(title, currentURL string, w http.ResponseW
 		IPListsURL:          webIPListsPath,
 		IPListURL:           webIPListPath,
 		EventsURL:           webEventsPath,
+		LiveEventsURL:       webLiveEventsPath,
 		ConfigsURL:          webConfigsPath,
 		LogoutURL:           webLogoutPath,
 		LoginURL:            webAdminLoginPath,
@@ -649,6 +693,8 @@ func (s *httpdServer) getBasePageData(title, currentURL string, w http.ResponseW
 		EventActionURL:      webAdminEventActionPath,
 		RolesURL:            webAdminRolesPath,
 		RoleURL:             webAdminRolePath,
+		TenantsURL:          webAdminTenantsPath,
+		TenantURL:           webAdminTenantPath,
 		QuotaScanURL:        webQuotaScanPath,
 		ConnectionsURL:      webConnectionsPath,
 		StatusURL:           webStatusPath,
@@ -658,11 +704,11 @@ func (s *httpdServer) getBasePageData(title, currentURL string, w http.ResponseW
 		IsEventManagerPage:  isEventManagerResource(currentURL),
 		IsIPManagerPage:     isIPListsResource(currentURL),
 		IsServerManagerPage: isServerManagerResource(currentURL),
-		HasDefender:         common.Config.DefenderConfig.Enabled,
+		HasDefender:         common.IsDefenderEnabled(),
 		HasSearcher:         plugin.Handler.HasSearcher(),
 		HasExternalLogin:    isLoggedInWithOIDC(r),
 		CSRFToken:           csrfToken,
-		Branding:            s.binding.Branding.WebAdmin,
+		Branding:            s.getWebAdminBranding(r),
 	}
 }
 
@@ -748,6 +794,11 @@ func (s *httpdServer) renderTwoFactorPage(w http.ResponseWriter, r *http.Request
 		RecoveryURL:    webAdminTwoFactorRecoveryPath,
 		Branding:       s.binding.Branding.WebAdmin,
 	}
+	if claims, errClaims := getTokenClaims(r); errClaims == nil {
+		if admin, errAdmin := dataprovider.AdminExists(claims.Username); errAdmin == nil {
+			data.TrustedDeviceMaxAge = mfa.GetTrustedDeviceMaxAge(admin.Filters.TOTPConfig.ConfigName)
+		}
+	}
 	renderAdminTemplate(w, templateTwoFactor, data)
 }
 
@@ -771,6 +822,7 @@ func (s *httpdServer) renderMFAPage(w http.ResponseWriter, r *http.Request) {
 		ValidateTOTPURL: webAdminTOTPValidatePath,
 		SaveTOTPURL:     webAdminTOTPSavePath,
 		RecCodesURL:     webAdminRecoveryCodesPath,
+		TrustedDevURL:   webAdminTrustedDevicesPath,
 	}
 	admin, err := dataprovider.AdminExists(data.LoggedUser.Username)
 	if err != nil {
@@ -871,6 +923,10 @@ func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Re
 	if errRoles != nil {
 		return
 	}
+	tenants, errTenants := s.getWebTenants(w, r, 10)
+	if errTenants != nil {
+		return
+	}
 	currentURL := webAdminPath
 	title := util.I18nAddAdminTitle
 	if !isAdd {
@@ -882,6 +938,7 @@ func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Re
 		Admin:    admin,
 		Groups:   groups,
 		Roles:    roles,
+		Tenants:  tenants,
 		Error:    getI18nError(err),
 		IsAdd:    isAdd,
 	}
@@ -936,6 +993,10 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 			return
 		}
 	}
+	tenants, errTenants := s.getWebTenants(w, r, 10)
+	if errTenants != nil {
+		return
+	}
 	folders, errFolders := s.getWebVirtualFolders(w, r, defaultQueryLimit, true)
 	if errFolders != nil {
 		return
@@ -958,6 +1019,7 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 		VirtualFolders:     folders,
 		Groups:             groups,
 		Roles:              roles,
+		Tenants:            tenants,
 		CanImpersonate:     os.Getuid() == 0,
 		FsWrapper: fsWrapper{
 			Filesystem:      user.FsConfig,
@@ -1013,6 +1075,27 @@ func (s *httpdServer) renderRolePage(w http.ResponseWriter, r *http.Request, rol
 	renderAdminTemplate(w, templateRole, data)
 }
 
+func (s *httpdServer) renderTenantPage(w http.ResponseWriter, r *http.Request, tenant dataprovider.Tenant,
+	mode genericPageMode, err error,
+) {
+	var title, currentURL string
+	switch mode {
+	case genericPageModeAdd:
+		title = util.I18nTenantAddTitle
+		currentURL = webAdminTenantPath
+	case genericPageModeUpdate:
+		title = util.I18nTenantUpdateTitle
+		currentURL = fmt.Sprintf("%s/%s", webAdminTenantPath, url.PathEscape(tenant.Name))
+	}
+	data := tenantPage{
+		basePage: s.getBasePageData(title, currentURL, w, r),
+		Error:    getI18nError(err),
+		Tenant:   &tenant,
+		Mode:     mode,
+	}
+	renderAdminTemplate(w, templateTenant, data)
+}
+
 func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, group dataprovider.Group,
 	mode genericPageMode, err error,
 ) {
@@ -1525,6 +1608,7 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	config.Region = strings.TrimSpace(r.Form.Get("s3_region"))
 	config.AccessKey = strings.TrimSpace(r.Form.Get("s3_access_key"))
 	config.RoleARN = strings.TrimSpace(r.Form.Get("s3_role_arn"))
+	config.AssumeRoleWithWebIdentity = r.Form.Get("s3_assume_role_with_web_identity") != ""
 	config.AccessSecret = getSecretFromFormField(r, "s3_access_secret")
 	config.Endpoint = strings.TrimSpace(r.Form.Get("s3_endpoint"))
 	config.StorageClass = strings.TrimSpace(r.Form.Get("s3_storage_class"))
@@ -1556,6 +1640,16 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 upload part max time: %w", err)
 	}
+	config.SSEKMSKeyID = strings.TrimSpace(r.Form.Get("s3_sse_kms_key_id"))
+	config.SSECustomerKey = getSecretFromFormField(r, "s3_sse_customer_key")
+	config.ObjectLockMode = strings.TrimSpace(r.Form.Get("s3_object_lock_mode"))
+	if val := r.Form.Get("s3_object_lock_retention_days"); val != "" {
+		config.ObjectLockRetentionDays, err = strconv.Atoi(val)
+		if err != nil {
+			return config, fmt.Errorf("invalid s3 object lock retention days: %w", err)
+		}
+	}
+	config.ObjectLockLegalHold = r.Form.Get("s3_object_lock_legal_hold") != ""
 	return config, nil
 }
 
@@ -1575,6 +1669,8 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	if err == nil {
 		config.UploadPartMaxTime = uploadPartMaxTime
 	}
+	config.KMSKeyName = strings.TrimSpace(r.Form.Get("gcs_kms_key_name"))
+	config.EncryptionKey = getSecretFromFormField(r, "gcs_encryption_key")
 	autoCredentials := r.Form.Get("gcs_auto_credentials")
 	if autoCredentials != "" {
 		config.AutomaticCredentials = 1
@@ -1640,6 +1736,193 @@ func getHTTPFsConfig(r *http.Request) vfs.HTTPFsConfig {
 	return config
 }
 
+func getB2Config(r *http.Request) (vfs.B2FsConfig, error) {
+	var err error
+	config := vfs.B2FsConfig{}
+	config.Bucket = strings.TrimSpace(r.Form.Get("b2_bucket"))
+	config.KeyPrefix = strings.TrimSpace(strings.TrimPrefix(r.Form.Get("b2_key_prefix"), "/"))
+	config.KeyID = strings.TrimSpace(r.Form.Get("b2_key_id"))
+	config.ApplicationKey = getSecretFromFormField(r, "b2_application_key")
+	config.HideOnDelete = r.Form.Get("b2_hide_on_delete") != ""
+	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("b2_upload_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid B2 upload part size: %w", err)
+	}
+	config.UploadConcurrency, err = strconv.Atoi(r.Form.Get("b2_upload_concurrency"))
+	if err != nil {
+		return config, fmt.Errorf("invalid B2 upload concurrency: %w", err)
+	}
+	config.DownloadPartSize, err = strconv.ParseInt(r.Form.Get("b2_download_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid B2 download part size: %w", err)
+	}
+	config.DownloadConcurrency, err = strconv.Atoi(r.Form.Get("b2_download_concurrency"))
+	if err != nil {
+		return config, fmt.Errorf("invalid B2 download concurrency: %w", err)
+	}
+	return config, nil
+}
+
+func getDropboxConfig(r *http.Request) (vfs.DropboxFsConfig, error) {
+	var err error
+	config := vfs.DropboxFsConfig{}
+	config.RootPath = strings.TrimSpace(r.Form.Get("dropbox_root_path"))
+	config.ClientID = strings.TrimSpace(r.Form.Get("dropbox_client_id"))
+	config.ClientSecret = getSecretFromFormField(r, "dropbox_client_secret")
+	config.RefreshToken = getSecretFromFormField(r, "dropbox_refresh_token")
+	config.NamespaceID = strings.TrimSpace(r.Form.Get("dropbox_namespace_id"))
+	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("dropbox_upload_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid Dropbox upload part size: %w", err)
+	}
+	return config, nil
+}
+
+func getOneDriveConfig(r *http.Request) (vfs.OneDriveFsConfig, error) {
+	var err error
+	config := vfs.OneDriveFsConfig{}
+	config.TenantID = strings.TrimSpace(r.Form.Get("onedrive_tenant_id"))
+	config.ClientID = strings.TrimSpace(r.Form.Get("onedrive_client_id"))
+	config.ClientSecret = getSecretFromFormField(r, "onedrive_client_secret")
+	config.RefreshToken = getSecretFromFormField(r, "onedrive_refresh_token")
+	config.DriveID = strings.TrimSpace(r.Form.Get("onedrive_drive_id"))
+	config.RootPath = strings.TrimSpace(r.Form.Get("onedrive_root_path"))
+	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("onedrive_upload_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid OneDrive upload part size: %w", err)
+	}
+	return config, nil
+}
+
+func getWebDAVConfig(r *http.Request) (vfs.WebDAVFsConfig, error) {
+	var err error
+	config := vfs.WebDAVFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("webdav_endpoint"))
+	config.AuthMethod, err = strconv.Atoi(r.Form.Get("webdav_auth_method"))
+	if err != nil {
+		return config, fmt.Errorf("invalid WebDAV auth method: %w", err)
+	}
+	config.Username = strings.TrimSpace(r.Form.Get("webdav_username"))
+	config.Password = getSecretFromFormField(r, "webdav_password")
+	config.BearerToken = getSecretFromFormField(r, "webdav_bearer_token")
+	config.SkipTLSVerify = r.Form.Get("webdav_skip_tls_verify") != ""
+	config.BufferUploads = r.Form.Get("webdav_buffer_uploads") != ""
+	return config, nil
+}
+
+func getSMBConfig(r *http.Request) vfs.SMBFsConfig {
+	config := vfs.SMBFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("smb_endpoint"))
+	config.Share = strings.TrimSpace(r.Form.Get("smb_share"))
+	config.Username = strings.TrimSpace(r.Form.Get("smb_username"))
+	config.Password = getSecretFromFormField(r, "smb_password")
+	config.Domain = strings.TrimSpace(r.Form.Get("smb_domain"))
+	config.Prefix = strings.TrimSpace(r.Form.Get("smb_prefix"))
+	config.RequireSigning = r.Form.Get("smb_require_signing") != ""
+	return config
+}
+
+func getHDFSConfig(r *http.Request) (vfs.HDFSFsConfig, error) {
+	var err error
+	config := vfs.HDFSFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("hdfs_endpoint"))
+	config.AuthMethod, err = strconv.Atoi(r.Form.Get("hdfs_auth_method"))
+	if err != nil {
+		return config, fmt.Errorf("invalid HDFS auth method: %w", err)
+	}
+	config.Username = strings.TrimSpace(r.Form.Get("hdfs_username"))
+	config.KerberosPrincipal = strings.TrimSpace(r.Form.Get("hdfs_kerberos_principal"))
+	config.KerberosPassword = getSecretFromFormField(r, "hdfs_kerberos_password")
+	config.Krb5Conf = strings.TrimSpace(r.Form.Get("hdfs_krb5_conf"))
+	config.ServicePrincipal = strings.TrimSpace(r.Form.Get("hdfs_service_principal"))
+	config.DoAs = strings.TrimSpace(r.Form.Get("hdfs_doas"))
+	config.Prefix = strings.TrimSpace(r.Form.Get("hdfs_prefix"))
+	config.SkipTLSVerify = r.Form.Get("hdfs_skip_tls_verify") != ""
+	keytab, _, err := r.FormFile("hdfs_kerberos_keytab_file")
+	if err == http.ErrMissingFile {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer keytab.Close()
+	fileBytes, err := io.ReadAll(keytab)
+	if err != nil || len(fileBytes) == 0 {
+		if len(fileBytes) == 0 {
+			err = errors.New("keytab file size must be greater than 0")
+		}
+		return config, err
+	}
+	config.KerberosKeytab = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(fileBytes))
+	return config, nil
+}
+
+func getSwiftConfig(r *http.Request) (vfs.SwiftFsConfig, error) {
+	var err error
+	config := vfs.SwiftFsConfig{}
+	config.AuthURL = strings.TrimSpace(r.Form.Get("swift_auth_url"))
+	config.Username = strings.TrimSpace(r.Form.Get("swift_username"))
+	config.UserDomain = strings.TrimSpace(r.Form.Get("swift_user_domain"))
+	config.Password = getSecretFromFormField(r, "swift_password")
+	config.ApplicationCredentialID = strings.TrimSpace(r.Form.Get("swift_application_credential_id"))
+	config.ApplicationCredentialSecret = getSecretFromFormField(r, "swift_application_credential_secret")
+	config.ProjectID = strings.TrimSpace(r.Form.Get("swift_project_id"))
+	config.ProjectName = strings.TrimSpace(r.Form.Get("swift_project_name"))
+	config.ProjectDomain = strings.TrimSpace(r.Form.Get("swift_project_domain"))
+	config.Region = strings.TrimSpace(r.Form.Get("swift_region"))
+	config.EndpointType = strings.TrimSpace(r.Form.Get("swift_endpoint_type"))
+	config.Container = strings.TrimSpace(r.Form.Get("swift_container"))
+	config.ContainerPerUser = r.Form.Get("swift_container_per_user") != ""
+	config.KeyPrefix = strings.TrimSpace(strings.TrimPrefix(r.Form.Get("swift_key_prefix"), "/"))
+	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("swift_upload_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid Swift upload part size: %w", err)
+	}
+	config.SkipTLSVerify = r.Form.Get("swift_skip_tls_verify") != ""
+	return config, nil
+}
+
+func getGDriveConfig(r *http.Request) (vfs.GDriveFsConfig, error) {
+	var err error
+	config := vfs.GDriveFsConfig{}
+	config.DriveID = strings.TrimSpace(r.Form.Get("gdrive_drive_id"))
+	config.RootFolderID = strings.TrimSpace(r.Form.Get("gdrive_root_folder_id"))
+	config.AuthMethod, err = strconv.Atoi(r.Form.Get("gdrive_auth_method"))
+	if err != nil {
+		return config, fmt.Errorf("invalid Google Drive auth method: %w", err)
+	}
+	config.Subject = strings.TrimSpace(r.Form.Get("gdrive_subject"))
+	config.ClientID = strings.TrimSpace(r.Form.Get("gdrive_client_id"))
+	config.ClientSecret = getSecretFromFormField(r, "gdrive_client_secret")
+	config.RefreshToken = getSecretFromFormField(r, "gdrive_refresh_token")
+	config.ExportFormat, err = strconv.Atoi(r.Form.Get("gdrive_export_format"))
+	if err != nil {
+		return config, fmt.Errorf("invalid Google Drive export format: %w", err)
+	}
+	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("gdrive_upload_part_size"), 10, 64)
+	if err != nil {
+		return config, fmt.Errorf("invalid Google Drive upload part size: %w", err)
+	}
+	config.UseTrash = r.Form.Get("gdrive_use_trash") != ""
+	credentials, _, err := r.FormFile("gdrive_credential_file")
+	if err == http.ErrMissingFile {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer credentials.Close()
+	fileBytes, err := io.ReadAll(credentials)
+	if err != nil || len(fileBytes) == 0 {
+		if len(fileBytes) == 0 {
+			err = errors.New("credentials file size must be greater than 0")
+		}
+		return config, err
+	}
+	config.Credentials = kms.NewPlainSecret(util.BytesToString(fileBytes))
+	return config, nil
+}
+
 func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	var err error
 	config := vfs.AzBlobFsConfig{}
@@ -1651,6 +1934,9 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.KeyPrefix = strings.TrimSpace(strings.TrimPrefix(r.Form.Get("az_key_prefix"), "/"))
 	config.AccessTier = strings.TrimSpace(r.Form.Get("az_access_tier"))
 	config.UseEmulator = r.Form.Get("az_use_emulator") != ""
+	config.HierarchicalNamespace = r.Form.Get("az_hierarchical_namespace") != ""
+	config.AccessTierRules = getAzAccessTierRulesFromPostFields(r)
+	config.RehydratePriority = strings.TrimSpace(r.Form.Get("az_rehydrate_priority"))
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("az_upload_part_size"), 10, 64)
 	if err != nil {
 		return config, fmt.Errorf("invalid azure upload part size: %w", err)
@@ -1670,6 +1956,44 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	return config, nil
 }
 
+func getAzAccessTierRulesFromPostFields(r *http.Request) []vfs.AzBlobAccessTierRule {
+	type indexedRule struct {
+		idx  int
+		rule vfs.AzBlobAccessTierRule
+	}
+
+	var rules []indexedRule
+	for k := range r.Form {
+		if hasPrefixAndSuffix(k, "az_access_tier_rules[", "][az_tier_rule_path]") {
+			p := strings.TrimSpace(r.Form.Get(k))
+			if p == "" {
+				continue
+			}
+			base, _ := strings.CutSuffix(k, "[az_tier_rule_path]")
+			idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(base, "az_access_tier_rules["), "]"))
+			if err != nil {
+				continue
+			}
+			rules = append(rules, indexedRule{
+				idx: idx,
+				rule: vfs.AzBlobAccessTierRule{
+					Path:       p,
+					AccessTier: strings.TrimSpace(r.Form.Get(base + "[az_tier_rule_tier]")),
+				},
+			})
+		}
+	}
+	// rules are evaluated in order, the form fields are not
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].idx < rules[j].idx
+	})
+	result := make([]vfs.AzBlobAccessTierRule, 0, len(rules))
+	for _, item := range rules {
+		result = append(result, item.rule)
+	}
+	return result
+}
+
 func getOsConfigFromPostFields(r *http.Request, readBufferField, writeBufferField string) sdk.OSFsConfig {
 	config := sdk.OSFsConfig{}
 	readBuffer, err := strconv.Atoi(r.Form.Get(readBufferField))
@@ -1718,6 +2042,50 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 		fs.SFTPConfig = config
 	case sdk.HTTPFilesystemProvider:
 		fs.HTTPConfig = getHTTPFsConfig(r)
+	case vfs.B2FilesystemProvider:
+		config, err := getB2Config(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.B2Config = config
+	case vfs.DropboxFilesystemProvider:
+		config, err := getDropboxConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.DropboxConfig = config
+	case vfs.GDriveFilesystemProvider:
+		config, err := getGDriveConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.GDriveConfig = config
+	case vfs.OneDriveFilesystemProvider:
+		config, err := getOneDriveConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.OneDriveConfig = config
+	case vfs.WebDAVFilesystemProvider:
+		config, err := getWebDAVConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.WebDAVConfig = config
+	case vfs.SMBFilesystemProvider:
+		fs.SMBConfig = getSMBConfig(r)
+	case vfs.HDFSFilesystemProvider:
+		config, err := getHDFSConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.HDFSConfig = config
+	case vfs.SwiftFilesystemProvider:
+		config, err := getSwiftConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.SwiftConfig = config
 	}
 	return fs, nil
 }
@@ -1763,6 +2131,7 @@ func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	admin.Email = strings.TrimSpace(r.Form.Get("email"))
 	admin.Status = status
 	admin.Role = strings.TrimSpace(r.Form.Get("role"))
+	admin.Tenant = strings.TrimSpace(r.Form.Get("tenant"))
 	admin.Filters.AllowList = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
 	admin.Filters.AllowAPIKeyAuth = r.Form.Get("allow_api_key_auth") != ""
 	admin.Filters.RequireTwoFactor = r.Form.Get("require_two_factor") != ""
@@ -1829,6 +2198,22 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 		folder.FsConfig.SFTPConfig = getSFTPFsFromTemplate(folder.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		folder.FsConfig.HTTPConfig = getHTTPFsFromTemplate(folder.FsConfig.HTTPConfig, replacements)
+	case vfs.B2FilesystemProvider:
+		folder.FsConfig.B2Config = getB2FsFromTemplate(folder.FsConfig.B2Config, replacements)
+	case vfs.DropboxFilesystemProvider:
+		folder.FsConfig.DropboxConfig = getDropboxFsFromTemplate(folder.FsConfig.DropboxConfig, replacements)
+	case vfs.GDriveFilesystemProvider:
+		folder.FsConfig.GDriveConfig = getGDriveFsFromTemplate(folder.FsConfig.GDriveConfig, replacements)
+	case vfs.OneDriveFilesystemProvider:
+		folder.FsConfig.OneDriveConfig = getOneDriveFsFromTemplate(folder.FsConfig.OneDriveConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		folder.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(folder.FsConfig.WebDAVConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		folder.FsConfig.SMBConfig = getSMBFsFromTemplate(folder.FsConfig.SMBConfig, replacements)
+	case vfs.HDFSFilesystemProvider:
+		folder.FsConfig.HDFSConfig = getHDFSFsFromTemplate(folder.FsConfig.HDFSConfig, replacements)
+	case vfs.SwiftFilesystemProvider:
+		folder.FsConfig.SwiftConfig = getSwiftFsFromTemplate(folder.FsConfig.SwiftConfig, replacements)
 	}
 
 	return folder
@@ -1884,6 +2269,50 @@ func getHTTPFsFromTemplate(fsConfig vfs.HTTPFsConfig, replacements map[string]st
 	return fsConfig
 }
 
+func getB2FsFromTemplate(fsConfig vfs.B2FsConfig, replacements map[string]string) vfs.B2FsConfig {
+	fsConfig.KeyPrefix = replacePlaceholders(fsConfig.KeyPrefix, replacements)
+	return fsConfig
+}
+
+func getDropboxFsFromTemplate(fsConfig vfs.DropboxFsConfig, replacements map[string]string) vfs.DropboxFsConfig {
+	fsConfig.RootPath = replacePlaceholders(fsConfig.RootPath, replacements)
+	return fsConfig
+}
+
+func getGDriveFsFromTemplate(fsConfig vfs.GDriveFsConfig, replacements map[string]string) vfs.GDriveFsConfig {
+	fsConfig.Subject = replacePlaceholders(fsConfig.Subject, replacements)
+	return fsConfig
+}
+
+func getOneDriveFsFromTemplate(fsConfig vfs.OneDriveFsConfig, replacements map[string]string) vfs.OneDriveFsConfig {
+	fsConfig.RootPath = replacePlaceholders(fsConfig.RootPath, replacements)
+	return fsConfig
+}
+
+func getWebDAVFsFromTemplate(fsConfig vfs.WebDAVFsConfig, replacements map[string]string) vfs.WebDAVFsConfig {
+	fsConfig.Endpoint = replacePlaceholders(fsConfig.Endpoint, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	return fsConfig
+}
+
+func getSMBFsFromTemplate(fsConfig vfs.SMBFsConfig, replacements map[string]string) vfs.SMBFsConfig {
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	return fsConfig
+}
+
+func getHDFSFsFromTemplate(fsConfig vfs.HDFSFsConfig, replacements map[string]string) vfs.HDFSFsConfig {
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	fsConfig.DoAs = replacePlaceholders(fsConfig.DoAs, replacements)
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	return fsConfig
+}
+
+func getSwiftFsFromTemplate(fsConfig vfs.SwiftFsConfig, replacements map[string]string) vfs.SwiftFsConfig {
+	fsConfig.KeyPrefix = replacePlaceholders(fsConfig.KeyPrefix, replacements)
+	return fsConfig
+}
+
 func getUserFromTemplate(user dataprovider.User, template userTemplateFields) dataprovider.User {
 	user.Username = template.Username
 	user.Password = template.Password
@@ -1920,6 +2349,22 @@ func getUserFromTemplate(user dataprovider.User, template userTemplateFields) da
 		user.FsConfig.SFTPConfig = getSFTPFsFromTemplate(user.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		user.FsConfig.HTTPConfig = getHTTPFsFromTemplate(user.FsConfig.HTTPConfig, replacements)
+	case vfs.B2FilesystemProvider:
+		user.FsConfig.B2Config = getB2FsFromTemplate(user.FsConfig.B2Config, replacements)
+	case vfs.DropboxFilesystemProvider:
+		user.FsConfig.DropboxConfig = getDropboxFsFromTemplate(user.FsConfig.DropboxConfig, replacements)
+	case vfs.GDriveFilesystemProvider:
+		user.FsConfig.GDriveConfig = getGDriveFsFromTemplate(user.FsConfig.GDriveConfig, replacements)
+	case vfs.OneDriveFilesystemProvider:
+		user.FsConfig.OneDriveConfig = getOneDriveFsFromTemplate(user.FsConfig.OneDriveConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		user.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(user.FsConfig.WebDAVConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		user.FsConfig.SMBConfig = getSMBFsFromTemplate(user.FsConfig.SMBConfig, replacements)
+	case vfs.HDFSFilesystemProvider:
+		user.FsConfig.HDFSConfig = getHDFSFsFromTemplate(user.FsConfig.HDFSConfig, replacements)
+	case vfs.SwiftFilesystemProvider:
+		user.FsConfig.SwiftConfig = getSwiftFsFromTemplate(user.FsConfig.SwiftConfig, replacements)
 	}
 
 	return user
@@ -2099,6 +2544,7 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 			Description:          r.Form.Get("description"),
 			Role:                 strings.TrimSpace(r.Form.Get("role")),
 		},
+		Tenant: strings.TrimSpace(r.Form.Get("tenant")),
 		Filters: dataprovider.UserFilters{
 			BaseUserFilters:       filters,
 			RequirePasswordChange: r.Form.Get("require_password_change") != "",
@@ -2654,6 +3100,34 @@ func getRoleFromPostFields(r *http.Request) (dataprovider.Role, error) {
 	}, nil
 }
 
+func getTenantUIBrandingFromPostFields(r *http.Request, prefix string) dataprovider.TenantUIBranding {
+	return dataprovider.TenantUIBranding{
+		Name:           strings.TrimSpace(r.Form.Get(prefix + "_name")),
+		ShortName:      strings.TrimSpace(r.Form.Get(prefix + "_short_name")),
+		LogoPath:       strings.TrimSpace(r.Form.Get(prefix + "_logo_path")),
+		FaviconPath:    strings.TrimSpace(r.Form.Get(prefix + "_favicon_path")),
+		DisclaimerName: strings.TrimSpace(r.Form.Get(prefix + "_disclaimer_name")),
+		DisclaimerPath: strings.TrimSpace(r.Form.Get(prefix + "_disclaimer_path")),
+	}
+}
+
+func getTenantFromPostFields(r *http.Request) (dataprovider.Tenant, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return dataprovider.Tenant{}, util.NewI18nError(err, util.I18nErrorInvalidForm)
+	}
+
+	return dataprovider.Tenant{
+		Name:            strings.TrimSpace(r.Form.Get("name")),
+		Description:     r.Form.Get("description"),
+		StorageBasePath: strings.TrimSpace(r.Form.Get("storage_base_path")),
+		Branding: dataprovider.TenantBranding{
+			WebAdmin:  getTenantUIBrandingFromPostFields(r, "webadmin"),
+			WebClient: getTenantUIBrandingFromPostFields(r, "webclient"),
+		},
+	}, nil
+}
+
 func getIPListEntryFromPostFields(r *http.Request, listType dataprovider.IPListType) (dataprovider.IPListEntry, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -2923,7 +3397,7 @@ func getAllAdmins(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetAdmins(limit, offset, dataprovider.OrderASC)
+		results, err := dataprovider.GetAdmins(limit, offset, dataprovider.OrderASC, claims.Tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -2961,8 +3435,13 @@ func (s *httpdServer) handleWebAddAdminGet(w http.ResponseWriter, r *http.Reques
 
 func (s *httpdServer) handleWebUpdateAdminGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
 	username := getURLParam(r, "username")
-	admin, err := dataprovider.AdminExists(username)
+	admin, err := adminExistsForClaims(username, &claims)
 	if err == nil {
 		s.renderAddUpdateAdminPage(w, r, &admin, nil, false)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -2992,6 +3471,9 @@ func (s *httpdServer) handleWebAddAdminPost(w http.ResponseWriter, r *http.Reque
 		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
 		return
 	}
+	if claims.Tenant != "" {
+		admin.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddAdmin(&admin, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderAddUpdateAdminPage(w, r, &admin, err, true)
@@ -3002,9 +3484,9 @@ func (s *httpdServer) handleWebAddAdminPost(w http.ResponseWriter, r *http.Reque
 
 func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-
+	claims, claimsErr := getTokenClaims(r)
 	username := getURLParam(r, "username")
-	admin, err := dataprovider.AdminExists(username)
+	admin, err := adminExistsForClaims(username, &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -3030,8 +3512,7 @@ func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Re
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
-	claims, err := getTokenClaims(r)
-	if err != nil || claims.Username == "" {
+	if claimsErr != nil || claims.Username == "" {
 		s.renderAddUpdateAdminPage(w, r, &updatedAdmin, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken), false)
 		return
 	}
@@ -3088,7 +3569,7 @@ func getAllUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, claims.Role)
+		results, err := dataprovider.GetUsers(limit, offset, dataprovider.OrderASC, claims.Role, claims.Tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -3110,6 +3591,67 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 	renderAdminTemplate(w, templateUsers, data)
 }
 
+func (s *httpdServer) handleWebImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderBadRequestPage(w, r, err)
+		return
+	}
+	if err := verifyCSRFToken(r, s.csrfTokenAuth); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
+		return
+	}
+	username := getURLParam(r, "username")
+	if _, err := userExistsForClaims(username, &claims); err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			s.renderNotFoundPage(w, r, err)
+		} else {
+			s.renderInternalServerErrorPage(w, r, err)
+		}
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := checkImpersonator(claims.Username, user.Username, ipAddr); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorImpersonationFailed))
+		return
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorImpersonationFailed))
+		return
+	}
+	if err := checkHTTPClientUser(&user, r, xid.New().String(), true); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorImpersonationFailed))
+		return
+	}
+	c := jwtTokenClaims{
+		Username:     user.Username,
+		Permissions:  user.Filters.WebClient,
+		Signature:    user.GetSignature(),
+		Role:         user.Role,
+		Tenant:       user.Tenant,
+		Impersonator: claims.Username,
+		duration:     impersonationTokenDuration,
+	}
+	if err := c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient, ipAddr); err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	logger.Info(logSender, "", "admin %q started impersonating user %q, ip: %q", claims.Username, user.Username,
+		ipAddr)
+	dataprovider.NotifyUserImpersonation(&user, claims.Username, ipAddr, claims.Role)
+	http.Redirect(w, r, webClientFilesPath, http.StatusFound)
+}
+
 func (s *httpdServer) handleWebTemplateFolderGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	if r.URL.Query().Get("from") != "" {
@@ -3319,7 +3861,7 @@ func (s *httpdServer) handleWebUpdateUserGet(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	username := getURLParam(r, "username")
-	user, err := dataprovider.UserExists(username, claims.Role)
+	user, err := userExistsForClaims(username, &claims)
 	if err == nil {
 		s.renderUserPage(w, r, &user, userPageModeUpdate, nil, nil)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -3358,6 +3900,9 @@ func (s *httpdServer) handleWebAddUserPost(w http.ResponseWriter, r *http.Reques
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
+	if claims.Tenant != "" {
+		user.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddUser(&user, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderUserPage(w, r, &user, userPageModeAdd, err, nil)
@@ -3374,7 +3919,7 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 		return
 	}
 	username := getURLParam(r, "username")
-	user, err := dataprovider.UserExists(username, claims.Role)
+	user, err := userExistsForClaims(username, &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -3396,12 +3941,27 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.S3Secret = user.Filters.S3Secret
+	// second factor exemptions, port forwarding, per-protocol bandwidth limits,
+	// append-only mode, SSH algorithms, FTP passive IP, trash, file versioning,
+	// directory quotas and quota warning thresholds cannot be edited from the
+	// WebAdmin yet
+	updatedUser.Filters.TwoFactorAuthExemptions = user.Filters.TwoFactorAuthExemptions
+	updatedUser.Filters.PortForwarding = user.Filters.PortForwarding
+	updatedUser.Filters.ProtocolBandwidthLimits = user.Filters.ProtocolBandwidthLimits
+	updatedUser.Filters.AppendOnly = user.Filters.AppendOnly
+	updatedUser.Filters.SSHAlgorithms = user.Filters.SSHAlgorithms
+	updatedUser.Filters.FTPPassiveIP = user.Filters.FTPPassiveIP
+	updatedUser.Filters.Trash = user.Filters.Trash
+	updatedUser.Filters.FileVersioning = user.Filters.FileVersioning
+	updatedUser.Filters.DirQuotas = user.Filters.DirQuotas
+	updatedUser.Filters.QuotaWarnThresholds = user.Filters.QuotaWarnThresholds
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
 		updatedUser.Password = user.Password
 	}
-	updateEncryptedSecrets(&updatedUser.FsConfig, &user.FsConfig)
+	updatedUser.FsConfig.KeepEncryptedSecrets(&user.FsConfig)
 
 	updatedUser = getUserFromTemplate(updatedUser, userTemplateFields{
 		Username:   updatedUser.Username,
@@ -3479,6 +4039,9 @@ func (s *httpdServer) handleWebAddFolderPost(w http.ResponseWriter, r *http.Requ
 	}
 	folder.FsConfig = fsConfig
 	folder = getFolderFromTemplate(folder, folder.Name)
+	if claims.Tenant != "" {
+		folder.Tenant = claims.Tenant
+	}
 
 	err = dataprovider.AddFolder(&folder, claims.Username, ipAddr, claims.Role)
 	if err == nil {
@@ -3490,8 +4053,13 @@ func (s *httpdServer) handleWebAddFolderPost(w http.ResponseWriter, r *http.Requ
 
 func (s *httpdServer) handleWebUpdateFolderGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
 	name := getURLParam(r, "name")
-	folder, err := dataprovider.GetFolderByName(name)
+	folder, err := folderExistsForClaims(name, &claims)
 	if err == nil {
 		s.renderFolderPage(w, r, folder, folderPageModeUpdate, nil)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -3509,7 +4077,7 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 		return
 	}
 	name := getURLParam(r, "name")
-	folder, err := dataprovider.GetFolderByName(name)
+	folder, err := folderExistsForClaims(name, &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -3543,7 +4111,7 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	updatedFolder.Name = folder.Name
 	updatedFolder.FsConfig = fsConfig
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&updatedFolder.FsConfig, &folder.FsConfig)
+	updatedFolder.FsConfig.KeepEncryptedSecrets(&folder.FsConfig)
 
 	updatedFolder = getFolderFromTemplate(updatedFolder, updatedFolder.Name)
 
@@ -3557,8 +4125,9 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 
 func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	tenant := getTenantFromRequest(r)
 	for {
-		f, err := dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal)
+		f, err := dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal, tenant)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return folders, err
@@ -3573,9 +4142,10 @@ func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Reques
 
 func getAllFolders(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant := getTenantFromRequest(r)
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetFolders(limit, offset, dataprovider.OrderASC, false)
+		results, err := dataprovider.GetFolders(limit, offset, dataprovider.OrderASC, false, tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -3595,8 +4165,9 @@ func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request
 
 func (s *httpdServer) getWebGroups(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]dataprovider.Group, error) {
 	groups := make([]dataprovider.Group, 0, 50)
+	tenant := getTenantFromRequest(r)
 	for {
-		f, err := dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal)
+		f, err := dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal, tenant)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return groups, err
@@ -3611,9 +4182,10 @@ func (s *httpdServer) getWebGroups(w http.ResponseWriter, r *http.Request, limit
 
 func getAllGroups(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant := getTenantFromRequest(r)
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetGroups(limit, offset, dataprovider.OrderASC, false)
+		results, err := dataprovider.GetGroups(limit, offset, dataprovider.OrderASC, false, tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -3653,6 +4225,9 @@ func (s *httpdServer) handleWebAddGroupPost(w http.ResponseWriter, r *http.Reque
 		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
 		return
 	}
+	if claims.Tenant != "" {
+		group.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddGroup(&group, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderGroupPage(w, r, group, genericPageModeAdd, err)
@@ -3663,8 +4238,13 @@ func (s *httpdServer) handleWebAddGroupPost(w http.ResponseWriter, r *http.Reque
 
 func (s *httpdServer) handleWebUpdateGroupGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
 	name := getURLParam(r, "name")
-	group, err := dataprovider.GroupExists(name)
+	group, err := groupExistsForClaims(name, &claims)
 	if err == nil {
 		s.renderGroupPage(w, r, group, genericPageModeUpdate, nil)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -3682,7 +4262,7 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 		return
 	}
 	name := getURLParam(r, "name")
-	group, err := dataprovider.GroupExists(name)
+	group, err := groupExistsForClaims(name, &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -3702,9 +4282,15 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 	}
 	updatedGroup.ID = group.ID
 	updatedGroup.Name = group.Name
+	updatedGroup.UserSettings.TwoFactorAuthExemptions = group.UserSettings.TwoFactorAuthExemptions
+	updatedGroup.UserSettings.PortForwarding = group.UserSettings.PortForwarding
+	updatedGroup.UserSettings.ProtocolBandwidthLimits = group.UserSettings.ProtocolBandwidthLimits
+	updatedGroup.UserSettings.AppendOnly = group.UserSettings.AppendOnly
+	updatedGroup.UserSettings.SSHAlgorithms = group.UserSettings.SSHAlgorithms
+	updatedGroup.UserSettings.FTPPassiveIP = group.UserSettings.FTPPassiveIP
 	updatedGroup.SetEmptySecretsIfNil()
 
-	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, &group.UserSettings.FsConfig)
+	updatedGroup.UserSettings.FsConfig.KeepEncryptedSecrets(&group.UserSettings.FsConfig)
 
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, ipAddr, claims.Role)
 	if err != nil {
@@ -3768,6 +4354,10 @@ func (s *httpdServer) handleWebAddEventActionPost(w http.ResponseWriter, r *http
 		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
 		return
 	}
+	if claims.Tenant != "" {
+		s.renderForbiddenPage(w, r, errTenantEventActions)
+		return
+	}
 	action, err := getEventActionFromPostFields(r)
 	if err != nil {
 		s.renderEventActionPage(w, r, action, genericPageModeAdd, err)
@@ -3805,6 +4395,10 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
 		return
 	}
+	if claims.Tenant != "" {
+		s.renderForbiddenPage(w, r, errTenantEventActions)
+		return
+	}
 	name := getURLParam(r, "name")
 	action, err := dataprovider.EventActionExists(name)
 	if errors.Is(err, util.ErrNotFound) {
@@ -3843,9 +4437,10 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 
 func getAllRules(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant := getTenantFromRequest(r)
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetEventRules(limit, offset, dataprovider.OrderASC)
+		results, err := dataprovider.GetEventRules(limit, offset, dataprovider.OrderASC, tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -3890,6 +4485,9 @@ func (s *httpdServer) handleWebAddEventRulePost(w http.ResponseWriter, r *http.R
 		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
 		return
 	}
+	if claims.Tenant != "" {
+		rule.Tenant = claims.Tenant
+	}
 	if err = dataprovider.AddEventRule(&rule, claims.Username, ipAddr, claims.Role); err != nil {
 		s.renderEventRulePage(w, r, rule, genericPageModeAdd, err)
 		return
@@ -3899,8 +4497,13 @@ func (s *httpdServer) handleWebAddEventRulePost(w http.ResponseWriter, r *http.R
 
 func (s *httpdServer) handleWebUpdateEventRuleGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
 	name := getURLParam(r, "name")
-	rule, err := dataprovider.EventRuleExists(name)
+	rule, err := eventRuleExistsForClaims(name, &claims)
 	if err == nil {
 		s.renderEventRulePage(w, r, rule, genericPageModeUpdate, nil)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -3918,7 +4521,7 @@ func (s *httpdServer) handleWebUpdateEventRulePost(w http.ResponseWriter, r *htt
 		return
 	}
 	name := getURLParam(r, "name")
-	rule, err := dataprovider.EventRuleExists(name)
+	rule, err := eventRuleExistsForClaims(name, &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -3946,10 +4549,31 @@ func (s *httpdServer) handleWebUpdateEventRulePost(w http.ResponseWriter, r *htt
 	http.Redirect(w, r, webAdminEventRulesPath, http.StatusSeeOther)
 }
 
+func (s *httpdServer) getWebTenants(w http.ResponseWriter, r *http.Request, limit int) ([]dataprovider.Tenant, error) {
+	tenants := make([]dataprovider.Tenant, 0, 10)
+	if getTenantFromRequest(r) != "" {
+		// tenant admins cannot see the other tenants
+		return tenants, nil
+	}
+	for {
+		res, err := dataprovider.GetTenants(limit, len(tenants), dataprovider.OrderASC)
+		if err != nil {
+			s.renderInternalServerErrorPage(w, r, err)
+			return tenants, err
+		}
+		tenants = append(tenants, res...)
+		if len(res) < limit {
+			break
+		}
+	}
+	return tenants, nil
+}
+
 func (s *httpdServer) getWebRoles(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]dataprovider.Role, error) {
 	roles := make([]dataprovider.Role, 0, 10)
+	tenant := getTenantFromRequest(r)
 	for {
-		res, err := dataprovider.GetRoles(limit, len(roles), dataprovider.OrderASC, minimal)
+		res, err := dataprovider.GetRoles(limit, len(roles), dataprovider.OrderASC, minimal, tenant)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return roles, err
@@ -3964,9 +4588,10 @@ func (s *httpdServer) getWebRoles(w http.ResponseWriter, r *http.Request, limit
 
 func getAllRoles(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant := getTenantFromRequest(r)
 
 	dataGetter := func(limit, offset int) ([]byte, int, error) {
-		results, err := dataprovider.GetRoles(limit, offset, dataprovider.OrderASC, false)
+		results, err := dataprovider.GetRoles(limit, offset, dataprovider.OrderASC, false, tenant)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -4006,6 +4631,9 @@ func (s *httpdServer) handleWebAddRolePost(w http.ResponseWriter, r *http.Reques
 		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
 		return
 	}
+	if claims.Tenant != "" {
+		role.Tenant = claims.Tenant
+	}
 	err = dataprovider.AddRole(&role, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderRolePage(w, r, role, genericPageModeAdd, err)
@@ -4016,7 +4644,12 @@ func (s *httpdServer) handleWebAddRolePost(w http.ResponseWriter, r *http.Reques
 
 func (s *httpdServer) handleWebUpdateRoleGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	role, err := dataprovider.RoleExists(getURLParam(r, "name"))
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+	role, err := roleExistsForClaims(getURLParam(r, "name"), &claims)
 	if err == nil {
 		s.renderRolePage(w, r, role, genericPageModeUpdate, nil)
 	} else if errors.Is(err, util.ErrNotFound) {
@@ -4033,7 +4666,7 @@ func (s *httpdServer) handleWebUpdateRolePost(w http.ResponseWriter, r *http.Req
 		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
 		return
 	}
-	role, err := dataprovider.RoleExists(getURLParam(r, "name"))
+	role, err := roleExistsForClaims(getURLParam(r, "name"), &claims)
 	if errors.Is(err, util.ErrNotFound) {
 		s.renderNotFoundPage(w, r, err)
 		return
@@ -4062,6 +4695,106 @@ func (s *httpdServer) handleWebUpdateRolePost(w http.ResponseWriter, r *http.Req
 	http.Redirect(w, r, webAdminRolesPath, http.StatusSeeOther)
 }
 
+func getAllTenants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	dataGetter := func(limit, offset int) ([]byte, int, error) {
+		results, err := dataprovider.GetTenants(limit, offset, dataprovider.OrderASC)
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := json.Marshal(results)
+		return data, len(results), err
+	}
+
+	streamJSONArray(w, defaultQueryLimit, dataGetter)
+}
+
+func (s *httpdServer) handleWebGetTenants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	data := s.getBasePageData(util.I18nTenantsTitle, webAdminTenantsPath, w, r)
+
+	renderAdminTemplate(w, templateTenants, data)
+}
+
+func (s *httpdServer) handleWebAddTenantGet(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	s.renderTenantPage(w, r, dataprovider.Tenant{}, genericPageModeAdd, nil)
+}
+
+func (s *httpdServer) handleWebAddTenantPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant, err := getTenantFromPostFields(r)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeAdd, err)
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := verifyCSRFToken(r, s.csrfTokenAuth); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
+		return
+	}
+	err = dataprovider.AddTenant(&tenant, claims.Username, ipAddr)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeAdd, err)
+		return
+	}
+	http.Redirect(w, r, webAdminTenantsPath, http.StatusSeeOther)
+}
+
+func (s *httpdServer) handleWebUpdateTenantGet(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	tenant, err := dataprovider.TenantExists(getURLParam(r, "name"))
+	if err == nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeUpdate, nil)
+	} else if errors.Is(err, util.ErrNotFound) {
+		s.renderNotFoundPage(w, r, err)
+	} else {
+		s.renderInternalServerErrorPage(w, r, err)
+	}
+}
+
+func (s *httpdServer) handleWebUpdateTenantPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+	tenant, err := dataprovider.TenantExists(getURLParam(r, "name"))
+	if errors.Is(err, util.ErrNotFound) {
+		s.renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+
+	updatedTenant, err := getTenantFromPostFields(r)
+	if err != nil {
+		s.renderTenantPage(w, r, tenant, genericPageModeUpdate, err)
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := verifyCSRFToken(r, s.csrfTokenAuth); err != nil {
+		s.renderForbiddenPage(w, r, util.NewI18nError(err, util.I18nErrorInvalidCSRF))
+		return
+	}
+	updatedTenant.ID = tenant.ID
+	updatedTenant.Name = tenant.Name
+	err = dataprovider.UpdateTenant(&updatedTenant, claims.Username, ipAddr)
+	if err != nil {
+		s.renderTenantPage(w, r, updatedTenant, genericPageModeUpdate, err)
+		return
+	}
+	http.Redirect(w, r, webAdminTenantsPath, http.StatusSeeOther)
+}
+
 func (s *httpdServer) handleWebGetEvents(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -4074,6 +4807,16 @@ func (s *httpdServer) handleWebGetEvents(w http.ResponseWriter, r *http.Request)
 	renderAdminTemplate(w, templateEvents, data)
 }
 
+func (s *httpdServer) handleWebGetLiveEvents(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	data := liveEventsPage{
+		basePage:            s.getBasePageData(util.I18nLiveEventsTitle, webLiveEventsPath, w, r),
+		LiveEventsStreamURL: webLiveEventsStreamPath,
+	}
+	renderAdminTemplate(w, templateLiveEvents, data)
+}
+
 func (s *httpdServer) handleWebIPListsPage(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	rtlStatus, rtlProtocols := common.Config.GetRateLimitersStatus()
@@ -39,6 +39,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
@@ -53,10 +54,15 @@ const (
 	templateClientEditFile = "editfile.html"
 	templateClientShare    = "share.html"
 	templateClientShares   = "shares.html"
+	templateClientTrash    = "trash.html"
+	templateClientSearch   = "search.html"
 	templateClientViewPDF  = "viewpdf.html"
+	templateClientPreview  = "preview.html"
+	templateClientWOPI     = "wopi.html"
 	templateShareLogin     = "sharelogin.html"
 	templateShareDownload  = "sharedownload.html"
 	templateUploadToShare  = "shareupload.html"
+	templateClientOAuth2   = "oauth2consent.html"
 )
 
 // condResult is the result of an HTTP request precondition check.
@@ -97,6 +103,8 @@ type baseClientPage struct {
 	LoggedUser      *dataprovider.User
 	IsLoggedToShare bool
 	Branding        UIBranding
+	// username of the admin impersonating the logged in user
+	ImpersonatedBy string
 }
 
 type dirMapping struct {
@@ -129,7 +137,9 @@ type filesPage struct {
 	CheckExistURL      string
 	DownloadURL        string
 	ViewPDFURL         string
+	PreviewURL         string
 	FileURL            string
+	TusURL             string
 	TasksURL           string
 	CanAddFiles        bool
 	CanCreateDirs      bool
@@ -138,6 +148,10 @@ type filesPage struct {
 	CanDownload        bool
 	CanShare           bool
 	CanCopy            bool
+	TrashURL           string
+	FileVersionsURL    string
+	ArchiveRestoreURL  string
+	SearchURL          string
 	ShareUploadBaseURL string
 	Error              *util.I18nError
 	Paths              []dirMapping
@@ -179,6 +193,7 @@ type clientProfilePage struct {
 	AllowAPIKeyAuth bool
 	Email           string
 	Description     string
+	SSHCertURL      string
 	Error           *util.I18nError
 }
 
@@ -195,6 +210,7 @@ type clientMFAPage struct {
 	ValidateTOTPURL   string
 	SaveTOTPURL       string
 	RecCodesURL       string
+	TrustedDevURL     string
 	Protocols         []string
 	RequiredProtocols []string
 }
@@ -204,6 +220,20 @@ type clientSharesPage struct {
 	BasePublicSharesURL string
 }
 
+type clientTrashPage struct {
+	baseClientPage
+	TrashURL      string
+	RetentionDays int
+}
+
+type clientSearchPage struct {
+	baseClientPage
+	SearchURL     string
+	CurrentDir    string
+	MaxResults    int
+	ContentSearch bool
+}
+
 type clientSharePage struct {
 	baseClientPage
 	Share *dataprovider.Share
@@ -221,6 +251,7 @@ type userQuotaUsage struct {
 	TotalDataTransfer        int64
 	UsedUploadDataTransfer   int64
 	UsedDownloadDataTransfer int64
+	QuotaWarnThreshold       int
 }
 
 func (u *userQuotaUsage) HasQuotaInfo() bool {
@@ -293,6 +324,23 @@ func (u *userQuotaUsage) IsDiskQuotaLow() bool {
 	return u.IsQuotaSizeLow() || u.IsQuotaFilesLow()
 }
 
+// IsQuotaWarnThresholdReached returns true if the disk quota usage reached the
+// lowest warning threshold configured for the user
+func (u *userQuotaUsage) IsQuotaWarnThresholdReached() bool {
+	if u.QuotaWarnThreshold <= 0 {
+		return false
+	}
+	threshold := int64(u.QuotaWarnThreshold)
+	if u.QuotaSize > 0 && 100*u.UsedQuotaSize >= threshold*u.QuotaSize {
+		return true
+	}
+	return u.QuotaFiles > 0 && 100*int64(u.UsedQuotaFiles) >= threshold*int64(u.QuotaFiles)
+}
+
+func (u *userQuotaUsage) GetDiskQuotaPercentage() int {
+	return max(u.GetQuotaSizePercentage(), u.GetQuotaFilesPercentage())
+}
+
 func (u *userQuotaUsage) GetTotalTransferQuota() string {
 	total := u.UsedUploadDataTransfer + u.UsedDownloadDataTransfer
 	if u.TotalDataTransfer > 0 {
@@ -387,6 +435,7 @@ func newUserQuotaUsage(u *dataprovider.User) *userQuotaUsage {
 		DownloadDataTransfer:     u.DownloadDataTransfer,
 		UsedUploadDataTransfer:   u.UsedUploadDataTransfer,
 		UsedDownloadDataTransfer: u.UsedDownloadDataTransfer,
+		QuotaWarnThreshold:       u.GetLowestQuotaThreshold(),
 	}
 }
 
@@ -412,11 +461,26 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientEditFile),
 	}
+	previewPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientPreview),
+	}
 	sharesPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientShares),
 	}
+	trashPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientTrash),
+	}
+	searchPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientSearch),
+	}
 	sharePaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
@@ -471,6 +535,10 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientViewPDF),
 	}
+	wopiPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientWOPI),
+	}
 	shareLoginPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBaseLogin),
@@ -481,6 +549,11 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateUploadToShare),
 	}
+	oauth2ConsentPath := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientOAuth2),
+	}
 	shareDownloadPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
@@ -496,14 +569,19 @@ func loadClientTemplates(templatesPath string) {
 	twoFactorTmpl := util.LoadTemplate(nil, twoFactorPaths...)
 	twoFactorRecoveryTmpl := util.LoadTemplate(nil, twoFactorRecoveryPaths...)
 	editFileTmpl := util.LoadTemplate(nil, editFilePath...)
+	previewTmpl := util.LoadTemplate(nil, previewPaths...)
 	shareLoginTmpl := util.LoadTemplate(nil, shareLoginPath...)
 	sharesTmpl := util.LoadTemplate(nil, sharesPaths...)
+	trashTmpl := util.LoadTemplate(nil, trashPaths...)
+	searchTmpl := util.LoadTemplate(nil, searchPaths...)
 	shareTmpl := util.LoadTemplate(nil, sharePaths...)
 	forgotPwdTmpl := util.LoadTemplate(nil, forgotPwdPaths...)
 	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
 	viewPDFTmpl := util.LoadTemplate(nil, viewPDFPaths...)
+	wopiTmpl := util.LoadTemplate(nil, wopiPaths...)
 	shareUploadTmpl := util.LoadTemplate(nil, shareUploadPath...)
 	shareDownloadTmpl := util.LoadTemplate(nil, shareDownloadPath...)
+	oauth2ConsentTmpl := util.LoadTemplate(nil, oauth2ConsentPath...)
 
 	clientTemplates[templateClientFiles] = filesTmpl
 	clientTemplates[templateClientProfile] = profileTmpl
@@ -514,14 +592,19 @@ func loadClientTemplates(templatesPath string) {
 	clientTemplates[templateTwoFactor] = twoFactorTmpl
 	clientTemplates[templateTwoFactorRecovery] = twoFactorRecoveryTmpl
 	clientTemplates[templateClientEditFile] = editFileTmpl
+	clientTemplates[templateClientPreview] = previewTmpl
 	clientTemplates[templateClientShares] = sharesTmpl
+	clientTemplates[templateClientTrash] = trashTmpl
+	clientTemplates[templateClientSearch] = searchTmpl
 	clientTemplates[templateClientShare] = shareTmpl
 	clientTemplates[templateForgotPassword] = forgotPwdTmpl
 	clientTemplates[templateResetPassword] = resetPwdTmpl
 	clientTemplates[templateClientViewPDF] = viewPDFTmpl
+	clientTemplates[templateClientWOPI] = wopiTmpl
 	clientTemplates[templateShareLogin] = shareLoginTmpl
 	clientTemplates[templateUploadToShare] = shareUploadTmpl
 	clientTemplates[templateShareDownload] = shareDownloadTmpl
+	clientTemplates[templateClientOAuth2] = oauth2ConsentTmpl
 }
 
 func (s *httpdServer) getBaseClientPageData(title, currentURL string, w http.ResponseWriter, r *http.Request) baseClientPage {
@@ -546,7 +629,10 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, w http.Res
 		CSRFToken:       csrfToken,
 		LoggedUser:      getUserFromToken(r),
 		IsLoggedToShare: false,
-		Branding:        s.binding.Branding.WebClient,
+		Branding:        s.getWebClientBranding(r),
+	}
+	if claims, err := getTokenClaims(r); err == nil {
+		data.ImpersonatedBy = claims.Impersonator
 	}
 	if !strings.HasPrefix(r.RequestURI, webClientPubSharesPath) {
 		data.LoginURL = webClientLoginPath
@@ -639,9 +725,14 @@ func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, r *http.R
 		RecoveryURL:    webClientTwoFactorRecoveryPath,
 		Branding:       s.binding.Branding.WebClient,
 	}
-	if next := r.URL.Query().Get("next"); strings.HasPrefix(next, webClientFilesPath) {
+	if next := r.URL.Query().Get("next"); isAllowedClientNextURL(next) {
 		data.CurrentURL += "?next=" + url.QueryEscape(next)
 	}
+	if claims, errClaims := getTokenClaims(r); errClaims == nil {
+		if user, errUser := dataprovider.UserExists(claims.Username, ""); errUser == nil {
+			data.TrustedDeviceMaxAge = mfa.GetTrustedDeviceMaxAge(user.Filters.TOTPConfig.ConfigName)
+		}
+	}
 	renderClientTemplate(w, templateTwoFactor, data)
 }
 
@@ -665,6 +756,7 @@ func (s *httpdServer) renderClientMFAPage(w http.ResponseWriter, r *http.Request
 		ValidateTOTPURL: webClientTOTPValidatePath,
 		SaveTOTPURL:     webClientTOTPSavePath,
 		RecCodesURL:     webClientRecoveryCodesPath,
+		TrustedDevURL:   webClientTrustedDevicesPath,
 		Protocols:       dataprovider.MFAProtocols,
 	}
 	user, err := dataprovider.GetUserWithGroupSettings(data.LoggedUser.Username, "")
@@ -753,7 +845,9 @@ func (s *httpdServer) renderSharedFilesPage(w http.ResponseWriter, r *http.Reque
 		ShareUploadBaseURL: path.Join(baseSharePath, url.PathEscape(dirName)),
 		ViewPDFURL:         path.Join(baseSharePath, "viewpdf"),
 		DirsURL:            path.Join(baseSharePath, "dirs"),
+		PreviewURL:         "",
 		FileURL:            "",
+		TusURL:             "",
 		FileActionsURL:     "",
 		CheckExistURL:      path.Join(baseSharePath, "browse", "exist"),
 		TasksURL:           "",
@@ -807,8 +901,10 @@ func (s *httpdServer) renderFilesPage(w http.ResponseWriter, r *http.Request, di
 		CurrentDir:         url.QueryEscape(dirName),
 		DownloadURL:        webClientDownloadZipPath,
 		ViewPDFURL:         webClientViewPDFPath,
+		PreviewURL:         webClientPreviewPath,
 		DirsURL:            webClientDirsPath,
 		FileURL:            webClientFilePath,
+		TusURL:             webClientTusPath,
 		FileActionsURL:     webClientFileActionsPath,
 		CheckExistURL:      webClientExistPath,
 		TasksURL:           webClientTasksPath,
@@ -823,6 +919,18 @@ func (s *httpdServer) renderFilesPage(w http.ResponseWriter, r *http.Request, di
 		Paths:              getDirMapping(dirName, webClientFilesPath),
 		QuotaUsage:         newUserQuotaUsage(user),
 	}
+	if user.Filters.Trash.IsEnabled() {
+		data.TrashURL = webClientTrashPath
+	}
+	if user.IsVersioningEnabled() {
+		data.FileVersionsURL = webClientFileVersionsPath
+	}
+	if data.CanDownload && isArchiveRestoreSupported(user.GetFsConfigForPath(dirName).Provider) {
+		data.ArchiveRestoreURL = webClientFileActionsPath + "/restore-archived"
+	}
+	if common.IsSearchIndexEnabled() {
+		data.SearchURL = webClientSearchPath
+	}
 	renderClientTemplate(w, templateClientFiles, data)
 }
 
@@ -842,6 +950,10 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 	data.Email = user.Email
 	data.Description = user.Description
 	data.CanSubmit = userMerged.CanUpdateProfile()
+	if sftpd.IsUserCAEnabled() && !util.Contains(userMerged.Filters.DeniedProtocols, common.ProtocolSSH) &&
+		userMerged.IsLoginMethodAllowed(dataprovider.SSHLoginMethodPublicKey, common.ProtocolSSH) {
+		data.SSHCertURL = webClientSSHCertPath
+	}
 	renderClientTemplate(w, templateClientProfile, data)
 }
 
@@ -897,6 +1009,11 @@ func (s *httpdServer) handleWebClientDownloadZip(w http.ResponseWriter, r *http.
 	defer common.Connections.Remove(connection.GetID())
 
 	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	method, err := getZipCompressionMethod(r.Form.Get("compression"))
+	if err != nil {
+		s.renderClientBadRequestPage(w, r, err)
+		return
+	}
 	files := r.Form.Get("files")
 	var filesList []string
 	err = json.Unmarshal(util.StringToBytes(files), &filesList)
@@ -907,7 +1024,7 @@ func (s *httpdServer) handleWebClientDownloadZip(w http.ResponseWriter, r *http.
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"",
 		getCompressedFileName(connection.GetUsername(), filesList)))
-	renderCompressedFiles(w, connection, name, filesList, nil)
+	renderCompressedFiles(w, connection, name, filesList, nil, method)
 }
 
 func (s *httpdServer) handleClientSharePartialDownload(w http.ResponseWriter, r *http.Request) {
@@ -944,6 +1061,11 @@ func (s *httpdServer) handleClientSharePartialDownload(w http.ResponseWriter, r
 		s.renderClientMessagePage(w, r, util.I18nShareAccessErrorTitle, getMappedStatusCode(err), err, "")
 		return
 	}
+	method, err := getZipCompressionMethod(r.Form.Get("compression"))
+	if err != nil {
+		s.renderClientBadRequestPage(w, r, err)
+		return
+	}
 	files := r.Form.Get("files")
 	var filesList []string
 	err = json.Unmarshal(util.StringToBytes(files), &filesList)
@@ -952,10 +1074,20 @@ func (s *httpdServer) handleClientSharePartialDownload(w http.ResponseWriter, r
 		return
 	}
 
+	filePaths := make([]string, 0, len(filesList))
+	for _, f := range filesList {
+		filePaths = append(filePaths, util.CleanPath(path.Join(name, f)))
+	}
+	if err := share.CheckFileDownloads(filePaths); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nShareAccessErrorTitle, getRespStatus(err), err, "")
+		return
+	}
+
 	dataprovider.UpdateShareLastUse(&share, 1) //nolint:errcheck
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"",
 		getCompressedFileName(fmt.Sprintf("share-%s", share.Name), filesList)))
-	renderCompressedFiles(w, connection, name, filesList, &share)
+	renderCompressedFiles(w, connection, name, filesList, &share, method)
+	updateShareStats(r, &share, filePaths)
 }
 
 func (s *httpdServer) handleShareGetDirContents(w http.ResponseWriter, r *http.Request) {
@@ -1007,6 +1139,10 @@ func (s *httpdServer) handleShareGetDirContents(w http.ResponseWriter, r *http.R
 			} else {
 				res["type"] = "2"
 				res["size"] = info.Size()
+				if thumbnailsMgr != nil && thumbnailsMgr.isSupported(info.Name(), info) {
+					res["thumbnail_url"] = getFileObjectURL(share.GetRelativePath(name), info.Name(),
+						path.Join(webClientPubSharesPath, share.ShareID, "thumbnails"))
+				}
 			}
 			res["meta"] = fmt.Sprintf("%v_%v", res["type"], info.Name())
 			res["name"] = info.Name()
@@ -1028,7 +1164,8 @@ func (s *httpdServer) handleShareGetDirContents(w http.ResponseWriter, r *http.R
 
 func (s *httpdServer) handleClientUploadToShare(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeWrite, dataprovider.ShareScopeReadWrite}
+	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeWrite, dataprovider.ShareScopeReadWrite,
+		dataprovider.ShareScopeFileRequest}
 	share, _, err := s.checkPublicShare(w, r, validScopes)
 	if err != nil {
 		return
@@ -1079,6 +1216,11 @@ func (s *httpdServer) handleShareGetFiles(w http.ResponseWriter, r *http.Request
 		s.renderSharedFilesPage(w, r, share.GetRelativePath(name), nil, share)
 		return
 	}
+	if err := share.CheckFileDownloads([]string{name}); err != nil {
+		s.renderSharedFilesPage(w, r, path.Dir(share.GetRelativePath(name)),
+			util.NewI18nError(err, util.I18nErrorShareFileDownloads), share)
+		return
+	}
 	dataprovider.UpdateShareLastUse(&share, 1) //nolint:errcheck
 	if status, err := downloadFile(w, r, connection, name, info, false, &share); err != nil {
 		dataprovider.UpdateShareLastUse(&share, -1) //nolint:errcheck
@@ -1086,7 +1228,9 @@ func (s *httpdServer) handleShareGetFiles(w http.ResponseWriter, r *http.Request
 			s.renderSharedFilesPage(w, r, path.Dir(share.GetRelativePath(name)),
 				util.NewI18nError(err, i18nFsMsg(getRespStatus(err))), share)
 		}
+		return
 	}
+	updateShareStats(r, &share, []string{name})
 }
 
 func (s *httpdServer) handleShareViewPDF(w http.ResponseWriter, r *http.Request) {
@@ -1146,10 +1290,16 @@ func (s *httpdServer) handleShareGetPDF(w http.ResponseWriter, r *http.Request)
 	if err := s.ensurePDF(w, r, name, connection); err != nil {
 		return
 	}
+	if err := share.CheckFileDownloads([]string{name}); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nShareAccessErrorTitle, getRespStatus(err), err, "")
+		return
+	}
 	dataprovider.UpdateShareLastUse(&share, 1) //nolint:errcheck
 	if _, err := downloadFile(w, r, connection, name, info, true, &share); err != nil {
 		dataprovider.UpdateShareLastUse(&share, -1) //nolint:errcheck
+		return
 	}
+	updateShareStats(r, &share, []string{name})
 }
 
 func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.Request) {
@@ -1222,6 +1372,12 @@ func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.
 					if info.Size() < httpdMaxEditFileSize {
 						res["edit_url"] = strings.Replace(res["url"].(string), webClientFilesPath, webClientEditFilePath, 1)
 					}
+					if thumbnailsMgr != nil && thumbnailsMgr.isSupported(info.Name(), info) {
+						res["thumbnail_url"] = getFileObjectURL(name, info.Name(), webClientThumbnailsPath)
+					}
+					if wopiMgr != nil && wopiMgr.isSupported(info.Name()) {
+						res["wopi_url"] = getFileObjectURL(name, info.Name(), webClientWOPIPath)
+					}
 				}
 			}
 			res["meta"] = fmt.Sprintf("%v_%v", res["type"], info.Name())
@@ -1599,6 +1755,185 @@ func (s *httpdServer) handleClientGetShares(w http.ResponseWriter, r *http.Reque
 	renderClientTemplate(w, templateClientShares, data)
 }
 
+func (s *httpdServer) handleClientGetTrash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	data := clientTrashPage{
+		baseClientPage: s.getBaseClientPageData(util.I18nTrashTitle, webClientTrashPath, w, r),
+		TrashURL:       webClientTrashPath,
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(data.LoggedUser.Username, "")
+	if err != nil {
+		s.renderClientInternalServerErrorPage(w, r, err)
+		return
+	}
+	if !user.Filters.Trash.IsEnabled() {
+		s.renderClientMessagePage(w, r, util.I18nError403Title, http.StatusForbidden,
+			util.NewI18nError(errors.New("trash is not enabled"), util.I18nErrorTrashDisabled), "")
+		return
+	}
+	data.RetentionDays = user.Filters.Trash.RetentionDays
+	renderClientTemplate(w, templateClientTrash, data)
+}
+
+func (s *httpdServer) handleClientGetTrashEntries(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	entries, err := connection.GetTrashEntries()
+	if err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorTrashList), getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func (s *httpdServer) handleClientRestoreTrashEntry(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if _, err := connection.RestoreTrashEntry(getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorTrashRestore), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "", http.StatusOK)
+}
+
+func (s *httpdServer) handleClientDeleteTrashEntry(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getTrashConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.DeleteTrashEntry(getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorTrashDelete), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "", http.StatusOK)
+}
+
+func (s *httpdServer) handleClientGetFileVersions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	versions, err := connection.GetFileVersions(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorVersionsList), getMappedStatusCode(err))
+		return
+	}
+	render.JSON(w, r, versions)
+}
+
+func (s *httpdServer) handleClientRestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if _, err := connection.RestoreFileVersion(name, getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorVersionRestore), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "", http.StatusOK)
+}
+
+func (s *httpdServer) handleClientDeleteFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if err := connection.DeleteFileVersion(name, getURLParam(r, "id")); err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorVersionDelete), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "", http.StatusOK)
+}
+
+func (s *httpdServer) handleClientRestoreArchivedFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	if _, err := connection.RestoreArchivedFile(name); err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorArchiveRestore), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "", http.StatusAccepted)
+}
+
+// isArchiveRestoreSupported returns true if the specified storage backend
+// supports archive storage tiers
+func isArchiveRestoreSupported(provider sdk.FilesystemProvider) bool {
+	return provider == sdk.S3FilesystemProvider || provider == sdk.AzureBlobFilesystemProvider
+}
+
+func (s *httpdServer) handleClientGetSearch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	if !common.IsSearchIndexEnabled() {
+		s.renderClientMessagePage(w, r, util.I18nError403Title, http.StatusForbidden,
+			util.NewI18nError(errors.New("search is not enabled"), util.I18nErrorSearchDisabled), "")
+		return
+	}
+	data := clientSearchPage{
+		baseClientPage: s.getBaseClientPageData(util.I18nSearchTitle, webClientSearchPath, w, r),
+		SearchURL:      webClientSearchPath,
+		CurrentDir:     util.CleanPath(r.URL.Query().Get("path")),
+		MaxResults:     maxClientSearchResults,
+		ContentSearch:  common.IsSearchContentEnabled(),
+	}
+	renderClientTemplate(w, templateClientSearch, data)
+}
+
+func (s *httpdServer) handleClientSearchFiles(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	query, err := getSearchQueryFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorSearchInvalidQuery), http.StatusBadRequest)
+		return
+	}
+	connection, err := getUserConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	query.Path = connection.User.GetCleanedPath(query.Path)
+	query.Limit = maxClientSearchResults
+	results, err := connection.SearchFiles(query)
+	if err != nil {
+		sendAPIResponse(w, r, err, getI18NErrorString(err, util.I18nErrorSearchGeneric), getSearchErrorStatusCode(err))
+		return
+	}
+	render.JSON(w, r, results)
+}
+
 func (s *httpdServer) handleClientGetProfile(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	s.renderClientProfilePage(w, r, nil)
@@ -1714,6 +2049,24 @@ func getShareFromPostFields(r *http.Request) (*dataprovider.Share, error) {
 		return share, util.NewI18nError(err, util.I18nErrorShareMaxTokens)
 	}
 	share.MaxTokens = maxTokens
+	if share.Scope == dataprovider.ShareScopeFileRequest {
+		share.Options.Instructions = strings.TrimSpace(r.Form.Get("instructions"))
+		maxUploaderSize := strings.TrimSpace(r.Form.Get("max_uploader_size"))
+		if maxUploaderSize != "" {
+			share.Options.MaxUploaderSize, err = strconv.ParseInt(maxUploaderSize, 10, 64)
+			if err != nil {
+				return share, util.NewI18nError(err, util.I18nErrorShareMaxUploaderSize)
+			}
+		}
+	} else {
+		maxFileDownloads := strings.TrimSpace(r.Form.Get("max_file_downloads"))
+		if maxFileDownloads != "" {
+			share.Options.MaxFileDownloads, err = strconv.Atoi(maxFileDownloads)
+			if err != nil {
+				return share, util.NewI18nError(err, util.I18nErrorShareMaxFileDownloads)
+			}
+		}
+	}
 	expirationDateMillis := int64(0)
 	expirationDateString := strings.TrimSpace(r.Form.Get("expiration_date"))
 	if expirationDateString != "" {
@@ -0,0 +1,727 @@
+// Copyright (C) 2024 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	wopiDiscoveryPath           = "/hosting/discovery"
+	wopiHeaderOverride          = "X-WOPI-Override"
+	wopiHeaderLock              = "X-WOPI-Lock"
+	wopiHeaderOldLock           = "X-WOPI-OldLock"
+	wopiHeaderItemVersion       = "X-WOPI-ItemVersion"
+	wopiHeaderLockFailureReason = "X-WOPI-LockFailureReason"
+	wopiActionEdit              = "edit"
+	wopiActionView              = "view"
+	wopiLockDuration            = 30 * time.Minute
+	wopiDiscoveryTTL            = time.Hour
+	wopiDiscoveryRetryInterval  = time.Minute
+	wopiMaxLockLength           = 1024
+	maxWOPIDiscoverySize        = 10 * 1048576
+	defaultWOPITokenValidity    = 600 // minutes
+	claimWOPIPathKey            = "wopi_path"
+)
+
+var (
+	wopiMgr                 *wopiManager
+	wopiURLPlaceholderRegex = regexp.MustCompile(`<[^>]*>`)
+	errWOPIDisabled         = errors.New("WOPI is disabled")
+	errWOPIInvalidToken     = errors.New("invalid WOPI access token")
+)
+
+// WOPIConfig defines the configuration for the WOPI host used to edit office documents
+// with WOPI clients such as Collabora Online or OnlyOffice
+type WOPIConfig struct {
+	// Set to true to enable the WOPI host
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Base URL of the WOPI client, for example https://collabora.example.com:9980.
+	// The discovery document is fetched from <server_url>/hosting/discovery
+	ServerURL string `json:"server_url" mapstructure:"server_url"`
+	// Base URL used by the WOPI client to reach SFTPGo, for example https://sftpgo.example.com.
+	// The WOPI endpoints must be reachable from the WOPI client using this URL
+	HostURL string `json:"host_url" mapstructure:"host_url"`
+	// Validity for the access tokens issued to the WOPI client as minutes.
+	// 0 means the default: 600 minutes
+	TokenValidity int `json:"token_validity" mapstructure:"token_validity"`
+}
+
+func (c *WOPIConfig) validate() error {
+	for _, u := range []string{c.ServerURL, c.HostURL} {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid WOPI URL %q, it must be an absolute http or https URL", u)
+		}
+	}
+	if c.TokenValidity < 0 {
+		return fmt.Errorf("invalid WOPI token validity %d", c.TokenValidity)
+	}
+	return nil
+}
+
+func (c *WOPIConfig) initialize() error {
+	if !c.Enabled {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	tokenValidity := c.TokenValidity
+	if tokenValidity == 0 {
+		tokenValidity = defaultWOPITokenValidity
+	}
+	wopiMgr = &wopiManager{
+		serverURL:     strings.TrimSuffix(c.ServerURL, "/"),
+		hostURL:       strings.TrimSuffix(c.HostURL, "/"),
+		tokenValidity: time.Duration(tokenValidity) * time.Minute,
+		locks:         make(map[string]wopiLock),
+	}
+	logger.Debug(logSender, "", "WOPI host enabled, WOPI client URL: %q, host URL: %q", wopiMgr.serverURL,
+		wopiMgr.hostURL)
+	return nil
+}
+
+type wopiDiscovery struct {
+	NetZones []struct {
+		Apps []struct {
+			Name    string `xml:"name,attr"`
+			Actions []struct {
+				Name   string `xml:"name,attr"`
+				Ext    string `xml:"ext,attr"`
+				URLSrc string `xml:"urlsrc,attr"`
+			} `xml:"action"`
+		} `xml:"app"`
+	} `xml:"net-zone"`
+}
+
+// getActions returns the supported actions indexed by file extension and action name
+func (d *wopiDiscovery) getActions() map[string]map[string]string {
+	actions := make(map[string]map[string]string)
+	for _, zone := range d.NetZones {
+		for _, app := range zone.Apps {
+			for _, action := range app.Actions {
+				ext := strings.ToLower(action.Ext)
+				if ext == "" || action.URLSrc == "" {
+					continue
+				}
+				if _, ok := actions[ext]; !ok {
+					actions[ext] = make(map[string]string)
+				}
+				name := strings.ToLower(action.Name)
+				if _, ok := actions[ext][name]; !ok {
+					actions[ext][name] = action.URLSrc
+				}
+			}
+		}
+	}
+	return actions
+}
+
+type wopiLock struct {
+	id        string
+	expiresAt time.Time
+}
+
+type wopiFileInfo struct {
+	BaseFileName               string `json:"BaseFileName"`
+	OwnerID                    string `json:"OwnerId"`
+	Size                       int64  `json:"Size"`
+	UserID                     string `json:"UserId"`
+	UserFriendlyName           string `json:"UserFriendlyName"`
+	Version                    string `json:"Version"`
+	LastModifiedTime           string `json:"LastModifiedTime"`
+	UserCanWrite               bool   `json:"UserCanWrite"`
+	ReadOnly                   bool   `json:"ReadOnly"`
+	SupportsLocks              bool   `json:"SupportsLocks"`
+	SupportsGetLock            bool   `json:"SupportsGetLock"`
+	SupportsExtendedLockLength bool   `json:"SupportsExtendedLockLength"`
+	SupportsUpdate             bool   `json:"SupportsUpdate"`
+	SupportsRename             bool   `json:"SupportsRename"`
+	UserCanNotWriteRelative    bool   `json:"UserCanNotWriteRelative"`
+}
+
+type wopiPage struct {
+	commonBasePage
+	Title          string
+	ActionURL      string
+	AccessToken    string
+	AccessTokenTTL int64
+	Branding       UIBranding
+}
+
+type wopiManager struct {
+	serverURL     string
+	hostURL       string
+	tokenValidity time.Duration
+	mu            sync.Mutex
+	actions       map[string]map[string]string
+	nextLoad      time.Time
+	locksMu       sync.Mutex
+	locks         map[string]wopiLock
+}
+
+func (m *wopiManager) loadDiscovery() (map[string]map[string]string, error) {
+	resp, err := httpclient.Get(m.serverURL + wopiDiscoveryPath)
+	if err != nil {
+		return nil, fmt.Errorf("wopi: unable to fetch the discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wopi: unexpected status code %d fetching the discovery document", resp.StatusCode)
+	}
+	var discovery wopiDiscovery
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxWOPIDiscoverySize)).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("wopi: unable to parse the discovery document: %w", err)
+	}
+	return discovery.getActions(), nil
+}
+
+// getActions returns the actions advertised by the WOPI client. The discovery
+// document is cached and reloaded periodically, if the WOPI client is not
+// reachable the previously loaded actions, if any, are returned
+func (m *wopiManager) getActions() map[string]map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Now().Before(m.nextLoad) {
+		return m.actions
+	}
+	actions, err := m.loadDiscovery()
+	if err != nil {
+		logger.Warn(logSender, "", "%v", err)
+		m.nextLoad = time.Now().Add(wopiDiscoveryRetryInterval)
+		return m.actions
+	}
+	logger.Debug(logSender, "", "WOPI discovery loaded, supported extensions: %d", len(actions))
+	m.actions = actions
+	m.nextLoad = time.Now().Add(wopiDiscoveryTTL)
+	return m.actions
+}
+
+func (m *wopiManager) isSupported(name string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	if ext == "" {
+		return false
+	}
+	_, ok := m.getActions()[ext]
+	return ok
+}
+
+// getActionURL returns the WOPI client URL to use to open the specified file,
+// edit actions are preferred if the user can write to the file
+func (m *wopiManager) getActionURL(name, fileID string, canWrite bool) string {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	actions := m.getActions()[ext]
+	if len(actions) == 0 {
+		return ""
+	}
+	preferred := []string{wopiActionView, wopiActionEdit}
+	if canWrite {
+		preferred = []string{wopiActionEdit, wopiActionView}
+	}
+	for _, action := range preferred {
+		if urlSrc, ok := actions[action]; ok {
+			return m.buildActionURL(urlSrc, fileID)
+		}
+	}
+	return ""
+}
+
+func (m *wopiManager) getWOPISrc(fileID string) string {
+	return m.hostURL + path.Join(wopiFilesPath, fileID)
+}
+
+func (m *wopiManager) buildActionURL(urlSrc, fileID string) string {
+	// remove the optional placeholders, for example <ui=UI_LLCC&>
+	actionURL := wopiURLPlaceholderRegex.ReplaceAllString(urlSrc, "")
+	switch {
+	case strings.HasSuffix(actionURL, "?") || strings.HasSuffix(actionURL, "&"):
+	case strings.Contains(actionURL, "?"):
+		actionURL += "&"
+	default:
+		actionURL += "?"
+	}
+	return actionURL + "WOPISrc=" + url.QueryEscape(m.getWOPISrc(fileID))
+}
+
+func (m *wopiManager) getLock(fileID string) string {
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+
+	return m.getLockUnlocked(fileID)
+}
+
+func (m *wopiManager) getLockUnlocked(fileID string) string {
+	lock, ok := m.locks[fileID]
+	if !ok {
+		return ""
+	}
+	if lock.expiresAt.Before(time.Now()) {
+		delete(m.locks, fileID)
+		return ""
+	}
+	return lock.id
+}
+
+// lock locks the specified file. If oldLockID is not empty the file must be
+// locked using oldLockID and the lock is replaced. It returns the current lock
+// and false if the file cannot be locked
+func (m *wopiManager) lock(fileID, lockID, oldLockID string) (string, bool) {
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+
+	current := m.getLockUnlocked(fileID)
+	if oldLockID != "" {
+		if current != oldLockID {
+			return current, false
+		}
+	} else if current != "" && current != lockID {
+		return current, false
+	}
+	m.locks[fileID] = wopiLock{
+		id:        lockID,
+		expiresAt: time.Now().Add(wopiLockDuration),
+	}
+	return lockID, true
+}
+
+func (m *wopiManager) refreshLock(fileID, lockID string) (string, bool) {
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+
+	current := m.getLockUnlocked(fileID)
+	if current == "" || current != lockID {
+		return current, false
+	}
+	m.locks[fileID] = wopiLock{
+		id:        lockID,
+		expiresAt: time.Now().Add(wopiLockDuration),
+	}
+	return lockID, true
+}
+
+func (m *wopiManager) unlock(fileID, lockID string) (string, bool) {
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+
+	current := m.getLockUnlocked(fileID)
+	if current == "" || current != lockID {
+		return current, false
+	}
+	delete(m.locks, fileID)
+	return "", true
+}
+
+func (m *wopiManager) cleanup() {
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+
+	for k, lock := range m.locks {
+		if lock.expiresAt.Before(time.Now()) {
+			delete(m.locks, k)
+		}
+	}
+}
+
+// getWOPIFileID returns an opaque and stable identifier for the specified user file
+func getWOPIFileID(username, name string) string {
+	h := sha256.Sum256([]byte(username + "\x00" + name))
+	return hex.EncodeToString(h[:])
+}
+
+func getWOPIFileVersion(modTime time.Time) string {
+	return strconv.FormatInt(modTime.UnixNano(), 10)
+}
+
+func canWriteWOPIFile(user *dataprovider.User, name string) bool {
+	if user.Filters.WebClient != nil && util.Contains(user.Filters.WebClient, sdk.WebClientWriteDisabled) {
+		return false
+	}
+	if ok, _ := user.IsFileAllowed(name); !ok {
+		return false
+	}
+	return user.HasPerm(dataprovider.PermOverwrite, path.Dir(name))
+}
+
+func (s *httpdServer) createWOPIToken(user *dataprovider.User, name string) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(wopiMgr.tokenValidity)
+
+	claims := make(map[string]any)
+	claims[jwt.JwtIDKey] = xid.New().String()
+	claims[jwt.SubjectKey] = user.GetSignature()
+	claims[jwt.NotBeforeKey] = now.Add(-30 * time.Second)
+	claims[jwt.ExpirationKey] = expiresAt
+	claims[jwt.AudienceKey] = []string{tokenAudienceWOPI}
+	claims[claimUsernameKey] = user.Username
+	claims[claimWOPIPathKey] = name
+
+	_, tokenString, err := s.tokenAuth.Encode(claims)
+	return tokenString, expiresAt, err
+}
+
+func getWOPITokenClaim(token jwt.Token, key string) string {
+	if val, ok := token.Get(key); ok {
+		if v, ok := val.(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// getWOPIConnection validates the access token sent by the WOPI client and returns
+// a connection for the user the token was issued to and the requested file path.
+// The user IP filters are not checked here, requests come from the WOPI client
+// and the user was authenticated when the token was issued
+func (s *httpdServer) getWOPIConnection(w http.ResponseWriter, r *http.Request) (*Connection, string, error) {
+	if wopiMgr == nil {
+		sendAPIResponse(w, r, errWOPIDisabled, "", http.StatusNotFound)
+		return nil, "", errWOPIDisabled
+	}
+	token, err := jwtauth.VerifyToken(s.tokenAuth, r.URL.Query().Get("access_token"))
+	if err != nil || token == nil {
+		logger.Debug(logSender, "", "unable to validate WOPI access token: %v", err)
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	if !util.Contains(token.Audience(), tokenAudienceWOPI) {
+		logger.Debug(logSender, "", "invalid WOPI token audience: %v", token.Audience())
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	username := getWOPITokenClaim(token, claimUsernameKey)
+	name := getWOPITokenClaim(token, claimWOPIPathKey)
+	if username == "" || name == "" || getURLParam(r, "id") != getWOPIFileID(username, name) {
+		logger.Debug(logSender, "", "WOPI token for user %q and path %q does not match file id %q",
+			username, name, getURLParam(r, "id"))
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get WOPI user %q: %v", username, err)
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	if user.GetSignature() != token.Subject() {
+		logger.Debug(logSender, "", "signature mismatch for WOPI user %q, the user was modified", username)
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		logger.Debug(logSender, "", "WOPI user %q cannot login: %v", username, err)
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolHTTP) {
+		logger.Debug(logSender, "", "protocol HTTP is not allowed for WOPI user %q", username)
+		sendAPIResponse(w, r, errWOPIInvalidToken, "", http.StatusUnauthorized)
+		return nil, "", errWOPIInvalidToken
+	}
+	connID := xid.New().String()
+	protocol := getProtocolFromRequest(r)
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		sendAPIResponse(w, r, err, "Unable to add connection", http.StatusTooManyRequests)
+		return nil, "", err
+	}
+	return connection, name, nil
+}
+
+func (s *httpdServer) wopiCheckFileInfo(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, name, err := s.getWOPIConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("%q is a directory", name), http.StatusNotFound)
+		return
+	}
+	if err := connection.checkReadAllowed(name, connection.GetTransferQuota()); err != nil {
+		sendAPIResponse(w, r, err, "", getMappedStatusCode(err))
+		return
+	}
+	canWrite := canWriteWOPIFile(&connection.User, name)
+	render.JSON(w, r, wopiFileInfo{
+		BaseFileName:               path.Base(name),
+		OwnerID:                    connection.User.Username,
+		Size:                       info.Size(),
+		UserID:                     connection.User.Username,
+		UserFriendlyName:           connection.User.Username,
+		Version:                    getWOPIFileVersion(info.ModTime()),
+		LastModifiedTime:           info.ModTime().UTC().Format(time.RFC3339Nano),
+		UserCanWrite:               canWrite,
+		ReadOnly:                   !canWrite,
+		SupportsLocks:              true,
+		SupportsGetLock:            true,
+		SupportsExtendedLockLength: true,
+		SupportsUpdate:             true,
+		SupportsRename:             false,
+		UserCanNotWriteRelative:    true,
+	})
+}
+
+func (s *httpdServer) wopiGetFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, name, err := s.getWOPIConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("%q is a directory", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set(wopiHeaderItemVersion, getWOPIFileVersion(info.ModTime()))
+	if status, err := downloadFile(w, r, connection, name, info, true, nil); err != nil && status > 0 {
+		sendAPIResponse(w, r, err, http.StatusText(status), status)
+	}
+}
+
+func (s *httpdServer) wopiPutFile(w http.ResponseWriter, r *http.Request) {
+	if maxUploadFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFileSize)
+	}
+	if override := r.Header.Get(wopiHeaderOverride); override != "PUT" {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported operation %q", override), http.StatusNotImplemented)
+		return
+	}
+	connection, name, err := s.getWOPIConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to stat the requested file", getMappedStatusCode(err))
+		return
+	}
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("%q is a directory", name), http.StatusNotFound)
+		return
+	}
+	if !canWriteWOPIFile(&connection.User, name) {
+		sendAPIResponse(w, r, nil, "Write access denied", http.StatusUnauthorized)
+		return
+	}
+	fileID := getURLParam(r, "id")
+	lockID := r.Header.Get(wopiHeaderLock)
+	current := wopiMgr.getLock(fileID)
+	// unlocked files can be written only if empty, for example after creating a new document
+	if (current == "" && info.Size() > 0) || (current != "" && current != lockID) {
+		w.Header().Set(wopiHeaderLock, current)
+		w.Header().Set(wopiHeaderLockFailureReason, "lock mismatch")
+		sendAPIResponse(w, r, nil, "Lock mismatch", http.StatusConflict)
+		return
+	}
+
+	connection.User.CheckFsRoot(connection.ID) //nolint:errcheck
+	writer, err := connection.getFileWriter(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %q", name), getMappedStatusCode(err))
+		return
+	}
+	_, err = io.Copy(writer, r.Body)
+	if err != nil {
+		writer.Close() //nolint:errcheck
+		sendAPIResponse(w, r, err, fmt.Sprintf("Error saving file %q", name), getMappedStatusCode(err))
+		return
+	}
+	if err = writer.Close(); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Error closing file %q", name), getMappedStatusCode(err))
+		return
+	}
+	if info, err = connection.Stat(name, 0); err == nil {
+		w.Header().Set(wopiHeaderItemVersion, getWOPIFileVersion(info.ModTime()))
+	}
+	sendAPIResponse(w, r, nil, "File saved", http.StatusOK)
+}
+
+func (s *httpdServer) wopiFileOperation(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connection, _, err := s.getWOPIConnection(w, r)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	fileID := getURLParam(r, "id")
+	lockID := r.Header.Get(wopiHeaderLock)
+	if len(lockID) > wopiMaxLockLength {
+		sendAPIResponse(w, r, nil, "Lock identifier too long", http.StatusBadRequest)
+		return
+	}
+	var current string
+	var ok bool
+
+	switch override := r.Header.Get(wopiHeaderOverride); override {
+	case "GET_LOCK":
+		w.Header().Set(wopiHeaderLock, wopiMgr.getLock(fileID))
+		sendAPIResponse(w, r, nil, "OK", http.StatusOK)
+		return
+	case "LOCK":
+		if lockID == "" {
+			sendAPIResponse(w, r, nil, "Missing lock identifier", http.StatusBadRequest)
+			return
+		}
+		current, ok = wopiMgr.lock(fileID, lockID, r.Header.Get(wopiHeaderOldLock))
+	case "REFRESH_LOCK":
+		current, ok = wopiMgr.refreshLock(fileID, lockID)
+	case "UNLOCK":
+		current, ok = wopiMgr.unlock(fileID, lockID)
+	default:
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported operation %q", override), http.StatusNotImplemented)
+		return
+	}
+	if !ok {
+		connection.Log(logger.LevelDebug, "WOPI lock conflict for file id %q, requested lock %q, current lock %q",
+			fileID, lockID, current)
+		w.Header().Set(wopiHeaderLock, current)
+		w.Header().Set(wopiHeaderLockFailureReason, "lock mismatch")
+		sendAPIResponse(w, r, nil, "Lock mismatch", http.StatusConflict)
+		return
+	}
+	sendAPIResponse(w, r, nil, "OK", http.StatusOK)
+}
+
+func (s *httpdServer) handleClientWOPI(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if wopiMgr == nil {
+		s.renderClientNotFoundPage(w, r, errWOPIDisabled)
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, util.NewI18nError(errInvalidTokenClaims, util.I18nErrorInvalidToken))
+		return
+	}
+
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		s.renderClientMessagePage(w, r, util.I18nError500Title, getRespStatus(err),
+			util.NewI18nError(err, util.I18nErrorGetUser), "")
+		return
+	}
+
+	connID := xid.New().String()
+	protocol := getProtocolFromRequest(r)
+	connectionID := fmt.Sprintf("%v_%v", protocol, connID)
+	if err := checkHTTPClientUser(&user, r, connectionID, false); err != nil {
+		s.renderClientForbiddenPage(w, r, err)
+		return
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, protocol, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nError429Title, http.StatusTooManyRequests,
+			util.NewI18nError(err, util.I18nError429Message), "")
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
+	info, err := connection.Stat(name, 0)
+	if err != nil {
+		status := getRespStatus(err)
+		s.renderClientMessagePage(w, r, util.I18nErrorWOPITitle, status, util.NewI18nError(err, i18nFsMsg(status)), "")
+		return
+	}
+	if info.IsDir() {
+		s.renderClientMessagePage(w, r, util.I18nErrorWOPITitle, http.StatusBadRequest,
+			util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("The path %q does not point to a file", name)),
+				util.I18nErrorEditDir,
+			), "")
+		return
+	}
+	if err := connection.checkReadAllowed(name, connection.GetTransferQuota()); err != nil {
+		s.renderClientMessagePage(w, r, util.I18nErrorWOPITitle, getMappedStatusCode(err), err, "")
+		return
+	}
+	actionURL := wopiMgr.getActionURL(name, getWOPIFileID(user.Username, name), canWriteWOPIFile(&user, name))
+	if actionURL == "" {
+		s.renderClientMessagePage(w, r, util.I18nErrorWOPITitle, http.StatusBadRequest,
+			util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("No office editor available for %q", name)),
+				util.I18nErrorWOPIUnsupported,
+			), "")
+		return
+	}
+	token, expiresAt, err := s.createWOPIToken(&user, name)
+	if err != nil {
+		s.renderClientMessagePage(w, r, util.I18nErrorWOPITitle, http.StatusInternalServerError,
+			util.NewI18nError(err, util.I18nError500Message), "")
+		return
+	}
+	connection.Log(logger.LevelInfo, "opening file %q with the WOPI client", name)
+	data := wopiPage{
+		commonBasePage: getCommonBasePage(r),
+		Title:          path.Base(name),
+		ActionURL:      actionURL,
+		AccessToken:    token,
+		AccessTokenTTL: expiresAt.UnixMilli(),
+		Branding:       s.binding.Branding.WebClient,
+	}
+	renderClientTemplate(w, templateClientWOPI, data)
+}
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -63,6 +64,7 @@ const (
 	eventActionsPath      = "/api/v2/eventactions"
 	eventRulesPath        = "/api/v2/eventrules"
 	rolesPath             = "/api/v2/roles"
+	tenantsPath           = "/api/v2/tenants"
 	ipListsPath           = "/api/v2/iplists"
 )
 
@@ -480,6 +482,115 @@ func GetRoles(limit, offset int64, expectedStatusCode int) ([]dataprovider.Role,
 	return roles, body, err
 }
 
+// AddTenant adds a new tenant and checks the received HTTP Status code against expectedStatusCode.
+func AddTenant(tenant dataprovider.Tenant, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var newTenant dataprovider.Tenant
+	var body []byte
+	asJSON, _ := json.Marshal(tenant)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(tenantsPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return newTenant, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusCreated {
+		body, _ = getResponseBody(resp)
+		return newTenant, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newTenant)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkTenant(tenant, newTenant)
+	}
+	return newTenant, body, err
+}
+
+// UpdateTenant updates an existing tenant and checks the received HTTP Status code against expectedStatusCode
+func UpdateTenant(tenant dataprovider.Tenant, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var newTenant dataprovider.Tenant
+	var body []byte
+
+	asJSON, _ := json.Marshal(tenant)
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(tenantsPath, url.PathEscape(tenant.Name)),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return newTenant, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newTenant, body, err
+	}
+	if err == nil {
+		newTenant, body, err = GetTenantByName(tenant.Name, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkTenant(tenant, newTenant)
+	}
+	return newTenant, body, err
+}
+
+// RemoveTenant removes an existing tenant and checks the received HTTP Status code against expectedStatusCode.
+func RemoveTenant(tenant dataprovider.Tenant, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(tenantsPath, url.PathEscape(tenant.Name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetTenantByName gets a tenant by name and checks the received HTTP Status code against expectedStatusCode.
+func GetTenantByName(name string, expectedStatusCode int) (dataprovider.Tenant, []byte, error) {
+	var tenant dataprovider.Tenant
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(tenantsPath, url.PathEscape(name)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return tenant, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &tenant)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return tenant, body, err
+}
+
+// GetTenants returns a list of tenants and checks the received HTTP Status code against expectedStatusCode.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetTenants(limit, offset int64, expectedStatusCode int) ([]dataprovider.Tenant, []byte, error) {
+	var tenants []dataprovider.Tenant
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(tenantsPath), limit, offset)
+	if err != nil {
+		return tenants, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return tenants, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &tenants)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return tenants, body, err
+}
+
 // AddIPListEntry adds a new IP list entry and checks the received HTTP Status code against expectedStatusCode.
 func AddIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) (dataprovider.IPListEntry, []byte, error) {
 	var newEntry dataprovider.IPListEntry
@@ -1831,6 +1942,40 @@ func checkRole(expected, actual dataprovider.Role) error {
 	return nil
 }
 
+func checkTenant(expected, actual dataprovider.Tenant) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual tenant ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("tenant ID mismatch")
+		}
+	}
+	if dataprovider.ConvertName(expected.Name) != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if expected.Branding.WebAdmin.Name != actual.Branding.WebAdmin.Name {
+		return errors.New("WebAdmin branding name mismatch")
+	}
+	if expected.Branding.WebClient.Name != actual.Branding.WebClient.Name {
+		return errors.New("WebClient branding name mismatch")
+	}
+	if expected.StorageBasePath != "" && filepath.Clean(expected.StorageBasePath) != actual.StorageBasePath {
+		return errors.New("storage base path mismatch")
+	}
+	if actual.CreatedAt == 0 {
+		return errors.New("created_at unset")
+	}
+	if actual.UpdatedAt == 0 {
+		return errors.New("updated_at unset")
+	}
+	return nil
+}
+
 func checkGroup(expected, actual dataprovider.Group) error {
 	if expected.ID <= 0 {
 		if actual.ID <= 0 {
@@ -1919,6 +2064,22 @@ func checkAPIKey(expected, actual *dataprovider.APIKey) error {
 	if expected.Admin != actual.Admin {
 		return errors.New("admin mismatch")
 	}
+	if len(expected.Filters.Permissions) != len(actual.Filters.Permissions) {
+		return errors.New("permissions mismatch")
+	}
+	for _, perm := range expected.Filters.Permissions {
+		if !util.Contains(actual.Filters.Permissions, perm) {
+			return errors.New("permissions content mismatch")
+		}
+	}
+	if len(expected.Filters.AllowList) != len(actual.Filters.AllowList) {
+		return errors.New("allow list mismatch")
+	}
+	for _, ipMask := range expected.Filters.AllowList {
+		if !util.Contains(actual.Filters.AllowList, ipMask) {
+			return errors.New("allow list content mismatch")
+		}
+	}
 
 	return nil
 }
@@ -2169,6 +2330,30 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if err := compareSFTPFsConfig(expected, actual); err != nil {
 		return err
 	}
+	if err := compareB2FsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareDropboxFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareGDriveFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareOneDriveFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareWebDAVFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareSMBFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareHDFSFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareSwiftFsConfig(expected, actual); err != nil {
+		return err
+	}
 	return compareHTTPFsConfig(expected, actual)
 }
 
@@ -2221,6 +2406,24 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	if expected.S3Config.UploadPartMaxTime != actual.S3Config.UploadPartMaxTime {
 		return errors.New("fs S3 upload part max time mismatch")
 	}
+	if expected.S3Config.SSEKMSKeyID != actual.S3Config.SSEKMSKeyID {
+		return errors.New("fs S3 SSE-KMS key ID mismatch")
+	}
+	if err := checkEncryptedSecret(expected.S3Config.SSECustomerKey, actual.S3Config.SSECustomerKey); err != nil {
+		return fmt.Errorf("fs S3 SSE customer key mismatch: %v", err)
+	}
+	if expected.S3Config.AssumeRoleWithWebIdentity != actual.S3Config.AssumeRoleWithWebIdentity {
+		return errors.New("fs S3 assume role with web identity mismatch")
+	}
+	if expected.S3Config.ObjectLockMode != actual.S3Config.ObjectLockMode {
+		return errors.New("fs S3 object lock mode mismatch")
+	}
+	if expected.S3Config.ObjectLockRetentionDays != actual.S3Config.ObjectLockRetentionDays {
+		return errors.New("fs S3 object lock retention days mismatch")
+	}
+	if expected.S3Config.ObjectLockLegalHold != actual.S3Config.ObjectLockLegalHold {
+		return errors.New("fs S3 object lock legal hold mismatch")
+	}
 	if expected.S3Config.KeyPrefix != actual.S3Config.KeyPrefix &&
 		expected.S3Config.KeyPrefix+"/" != actual.S3Config.KeyPrefix {
 		return errors.New("fs S3 key prefix mismatch")
@@ -2251,6 +2454,12 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.UploadPartMaxTime != actual.GCSConfig.UploadPartMaxTime {
 		return errors.New("GCS upload part max time mismatch")
 	}
+	if expected.GCSConfig.KMSKeyName != actual.GCSConfig.KMSKeyName {
+		return errors.New("GCS KMS key name mismatch")
+	}
+	if err := checkEncryptedSecret(expected.GCSConfig.EncryptionKey, actual.GCSConfig.EncryptionKey); err != nil {
+		return fmt.Errorf("GCS encryption key mismatch: %v", err)
+	}
 	return nil
 }
 
@@ -2276,6 +2485,270 @@ func compareHTTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	return nil
 }
 
+func compareB2FsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.B2Config.Bucket != actual.B2Config.Bucket {
+		return errors.New("B2 bucket mismatch")
+	}
+	if expected.B2Config.KeyID != actual.B2Config.KeyID {
+		return errors.New("B2 key ID mismatch")
+	}
+	if expected.B2Config.KeyPrefix != actual.B2Config.KeyPrefix &&
+		expected.B2Config.KeyPrefix+"/" != actual.B2Config.KeyPrefix {
+		return errors.New("B2 key prefix mismatch")
+	}
+	if expected.B2Config.UploadPartSize != actual.B2Config.UploadPartSize {
+		return errors.New("B2 upload part size mismatch")
+	}
+	if expected.B2Config.UploadConcurrency != actual.B2Config.UploadConcurrency {
+		return errors.New("B2 upload concurrency mismatch")
+	}
+	if expected.B2Config.DownloadPartSize != actual.B2Config.DownloadPartSize {
+		return errors.New("B2 download part size mismatch")
+	}
+	if expected.B2Config.DownloadConcurrency != actual.B2Config.DownloadConcurrency {
+		return errors.New("B2 download concurrency mismatch")
+	}
+	if expected.B2Config.HideOnDelete != actual.B2Config.HideOnDelete {
+		return errors.New("B2 hide on delete mismatch")
+	}
+	if err := checkEncryptedSecret(expected.B2Config.ApplicationKey, actual.B2Config.ApplicationKey); err != nil {
+		return fmt.Errorf("B2 application key mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareDropboxFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.DropboxConfig.RootPath != actual.DropboxConfig.RootPath &&
+		(expected.DropboxConfig.RootPath != "" || actual.DropboxConfig.RootPath != "/") {
+		return errors.New("Dropbox root path mismatch")
+	}
+	if expected.DropboxConfig.ClientID != actual.DropboxConfig.ClientID {
+		return errors.New("Dropbox client ID mismatch")
+	}
+	if expected.DropboxConfig.NamespaceID != actual.DropboxConfig.NamespaceID {
+		return errors.New("Dropbox namespace ID mismatch")
+	}
+	if expected.DropboxConfig.UploadPartSize != actual.DropboxConfig.UploadPartSize {
+		return errors.New("Dropbox upload part size mismatch")
+	}
+	if err := checkEncryptedSecret(expected.DropboxConfig.ClientSecret, actual.DropboxConfig.ClientSecret); err != nil {
+		return fmt.Errorf("Dropbox client secret mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.DropboxConfig.RefreshToken, actual.DropboxConfig.RefreshToken); err != nil {
+		return fmt.Errorf("Dropbox refresh token mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareGDriveFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.GDriveConfig.DriveID != actual.GDriveConfig.DriveID {
+		return errors.New("Google Drive drive ID mismatch")
+	}
+	if expected.GDriveConfig.RootFolderID != actual.GDriveConfig.RootFolderID {
+		return errors.New("Google Drive root folder ID mismatch")
+	}
+	if expected.GDriveConfig.AuthMethod != actual.GDriveConfig.AuthMethod {
+		return errors.New("Google Drive auth method mismatch")
+	}
+	if expected.GDriveConfig.Subject != actual.GDriveConfig.Subject {
+		return errors.New("Google Drive subject mismatch")
+	}
+	if expected.GDriveConfig.ClientID != actual.GDriveConfig.ClientID {
+		return errors.New("Google Drive client ID mismatch")
+	}
+	if expected.GDriveConfig.ExportFormat != actual.GDriveConfig.ExportFormat {
+		return errors.New("Google Drive export format mismatch")
+	}
+	if expected.GDriveConfig.UploadPartSize != actual.GDriveConfig.UploadPartSize {
+		return errors.New("Google Drive upload part size mismatch")
+	}
+	if expected.GDriveConfig.UseTrash != actual.GDriveConfig.UseTrash {
+		return errors.New("Google Drive use trash mismatch")
+	}
+	if err := checkEncryptedSecret(expected.GDriveConfig.Credentials, actual.GDriveConfig.Credentials); err != nil {
+		return fmt.Errorf("Google Drive credentials mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.GDriveConfig.ClientSecret, actual.GDriveConfig.ClientSecret); err != nil {
+		return fmt.Errorf("Google Drive client secret mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.GDriveConfig.RefreshToken, actual.GDriveConfig.RefreshToken); err != nil {
+		return fmt.Errorf("Google Drive refresh token mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareOneDriveFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.OneDriveConfig.TenantID != actual.OneDriveConfig.TenantID {
+		return errors.New("OneDrive tenant ID mismatch")
+	}
+	if expected.OneDriveConfig.ClientID != actual.OneDriveConfig.ClientID {
+		return errors.New("OneDrive client ID mismatch")
+	}
+	if expected.OneDriveConfig.DriveID != actual.OneDriveConfig.DriveID {
+		return errors.New("OneDrive drive ID mismatch")
+	}
+	if expected.OneDriveConfig.RootPath != actual.OneDriveConfig.RootPath &&
+		(expected.OneDriveConfig.RootPath != "" || actual.OneDriveConfig.RootPath != "/") {
+		return errors.New("OneDrive root path mismatch")
+	}
+	if expected.OneDriveConfig.UploadPartSize != actual.OneDriveConfig.UploadPartSize {
+		return errors.New("OneDrive upload part size mismatch")
+	}
+	if err := checkEncryptedSecret(expected.OneDriveConfig.ClientSecret, actual.OneDriveConfig.ClientSecret); err != nil {
+		return fmt.Errorf("OneDrive client secret mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.OneDriveConfig.RefreshToken, actual.OneDriveConfig.RefreshToken); err != nil {
+		return fmt.Errorf("OneDrive refresh token mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareWebDAVFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.WebDAVConfig.Endpoint != actual.WebDAVConfig.Endpoint {
+		return errors.New("WebDAV endpoint mismatch")
+	}
+	if expected.WebDAVConfig.AuthMethod != actual.WebDAVConfig.AuthMethod {
+		return errors.New("WebDAV auth method mismatch")
+	}
+	if expected.WebDAVConfig.Username != actual.WebDAVConfig.Username {
+		return errors.New("WebDAV username mismatch")
+	}
+	if expected.WebDAVConfig.SkipTLSVerify != actual.WebDAVConfig.SkipTLSVerify {
+		return errors.New("WebDAV skip TLS verify mismatch")
+	}
+	if expected.WebDAVConfig.BufferUploads != actual.WebDAVConfig.BufferUploads {
+		return errors.New("WebDAV buffer uploads mismatch")
+	}
+	if err := checkEncryptedSecret(expected.WebDAVConfig.Password, actual.WebDAVConfig.Password); err != nil {
+		return fmt.Errorf("WebDAV password mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.WebDAVConfig.BearerToken, actual.WebDAVConfig.BearerToken); err != nil {
+		return fmt.Errorf("WebDAV bearer token mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareSMBFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.SMBConfig.Endpoint != actual.SMBConfig.Endpoint {
+		return errors.New("SMB endpoint mismatch")
+	}
+	if expected.SMBConfig.Share != actual.SMBConfig.Share {
+		return errors.New("SMB share mismatch")
+	}
+	if expected.SMBConfig.Username != actual.SMBConfig.Username {
+		return errors.New("SMB username mismatch")
+	}
+	if expected.SMBConfig.Domain != actual.SMBConfig.Domain {
+		return errors.New("SMB domain mismatch")
+	}
+	if expected.SMBConfig.Prefix != actual.SMBConfig.Prefix &&
+		(expected.SMBConfig.Prefix != "" || actual.SMBConfig.Prefix != "/") {
+		return errors.New("SMB prefix mismatch")
+	}
+	if expected.SMBConfig.RequireSigning != actual.SMBConfig.RequireSigning {
+		return errors.New("SMB require signing mismatch")
+	}
+	if err := checkEncryptedSecret(expected.SMBConfig.Password, actual.SMBConfig.Password); err != nil {
+		return fmt.Errorf("SMB password mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareHDFSFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.HDFSConfig.Endpoint != actual.HDFSConfig.Endpoint {
+		return errors.New("HDFS endpoint mismatch")
+	}
+	if expected.HDFSConfig.AuthMethod != actual.HDFSConfig.AuthMethod {
+		return errors.New("HDFS auth method mismatch")
+	}
+	if expected.HDFSConfig.Username != actual.HDFSConfig.Username {
+		return errors.New("HDFS username mismatch")
+	}
+	if expected.HDFSConfig.KerberosPrincipal != actual.HDFSConfig.KerberosPrincipal {
+		return errors.New("HDFS Kerberos principal mismatch")
+	}
+	if expected.HDFSConfig.Krb5Conf != actual.HDFSConfig.Krb5Conf {
+		return errors.New("HDFS krb5 conf mismatch")
+	}
+	if expected.HDFSConfig.ServicePrincipal != actual.HDFSConfig.ServicePrincipal {
+		return errors.New("HDFS service principal mismatch")
+	}
+	if expected.HDFSConfig.DoAs != actual.HDFSConfig.DoAs {
+		return errors.New("HDFS doas mismatch")
+	}
+	if expected.HDFSConfig.Prefix != actual.HDFSConfig.Prefix &&
+		(expected.HDFSConfig.Prefix != "" || actual.HDFSConfig.Prefix != "/") {
+		return errors.New("HDFS prefix mismatch")
+	}
+	if expected.HDFSConfig.SkipTLSVerify != actual.HDFSConfig.SkipTLSVerify {
+		return errors.New("HDFS skip TLS verify mismatch")
+	}
+	if err := checkEncryptedSecret(expected.HDFSConfig.KerberosPassword, actual.HDFSConfig.KerberosPassword); err != nil {
+		return fmt.Errorf("HDFS Kerberos password mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.HDFSConfig.KerberosKeytab, actual.HDFSConfig.KerberosKeytab); err != nil {
+		return fmt.Errorf("HDFS Kerberos keytab mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareSwiftFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error { //nolint:gocyclo
+	if expected.SwiftConfig.AuthURL != actual.SwiftConfig.AuthURL {
+		return errors.New("Swift auth URL mismatch")
+	}
+	if expected.SwiftConfig.Username != actual.SwiftConfig.Username {
+		return errors.New("Swift username mismatch")
+	}
+	if expected.SwiftConfig.UserDomain != actual.SwiftConfig.UserDomain &&
+		(expected.SwiftConfig.UserDomain != "" || actual.SwiftConfig.UserDomain != "Default") {
+		return errors.New("Swift user domain mismatch")
+	}
+	if expected.SwiftConfig.ApplicationCredentialID != actual.SwiftConfig.ApplicationCredentialID {
+		return errors.New("Swift application credential ID mismatch")
+	}
+	if expected.SwiftConfig.ProjectID != actual.SwiftConfig.ProjectID {
+		return errors.New("Swift project ID mismatch")
+	}
+	if expected.SwiftConfig.ProjectName != actual.SwiftConfig.ProjectName {
+		return errors.New("Swift project name mismatch")
+	}
+	if expected.SwiftConfig.ProjectDomain != actual.SwiftConfig.ProjectDomain &&
+		(expected.SwiftConfig.ProjectDomain != "" || actual.SwiftConfig.ProjectDomain != "Default") {
+		return errors.New("Swift project domain mismatch")
+	}
+	if expected.SwiftConfig.Region != actual.SwiftConfig.Region {
+		return errors.New("Swift region mismatch")
+	}
+	if expected.SwiftConfig.EndpointType != actual.SwiftConfig.EndpointType &&
+		(expected.SwiftConfig.EndpointType != "" || actual.SwiftConfig.EndpointType != "public") {
+		return errors.New("Swift endpoint type mismatch")
+	}
+	if expected.SwiftConfig.Container != actual.SwiftConfig.Container {
+		return errors.New("Swift container mismatch")
+	}
+	if expected.SwiftConfig.ContainerPerUser != actual.SwiftConfig.ContainerPerUser {
+		return errors.New("Swift container per user mismatch")
+	}
+	if expected.SwiftConfig.KeyPrefix != actual.SwiftConfig.KeyPrefix &&
+		expected.SwiftConfig.KeyPrefix+"/" != actual.SwiftConfig.KeyPrefix {
+		return errors.New("Swift key prefix mismatch")
+	}
+	if expected.SwiftConfig.UploadPartSize != actual.SwiftConfig.UploadPartSize {
+		return errors.New("Swift upload part size mismatch")
+	}
+	if expected.SwiftConfig.SkipTLSVerify != actual.SwiftConfig.SkipTLSVerify {
+		return errors.New("Swift skip TLS verify mismatch")
+	}
+	if err := checkEncryptedSecret(expected.SwiftConfig.Password, actual.SwiftConfig.Password); err != nil {
+		return fmt.Errorf("Swift password mismatch: %v", err)
+	}
+	if err := checkEncryptedSecret(expected.SwiftConfig.ApplicationCredentialSecret,
+		actual.SwiftConfig.ApplicationCredentialSecret); err != nil {
+		return fmt.Errorf("Swift application credential secret mismatch: %v", err)
+	}
+	return nil
+}
+
 func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.SFTPConfig.Endpoint != actual.SFTPConfig.Endpoint {
 		return errors.New("SFTPFs endpoint mismatch")
@@ -2355,6 +2828,21 @@ func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.AzBlobConfig.AccessTier != actual.AzBlobConfig.AccessTier {
 		return errors.New("azure Blob access tier mismatch")
 	}
+	if expected.AzBlobConfig.HierarchicalNamespace != actual.AzBlobConfig.HierarchicalNamespace {
+		return errors.New("azure Blob hierarchical namespace mismatch")
+	}
+	if expected.AzBlobConfig.RehydratePriority != actual.AzBlobConfig.RehydratePriority {
+		return errors.New("azure Blob rehydrate priority mismatch")
+	}
+	if len(expected.AzBlobConfig.AccessTierRules) != len(actual.AzBlobConfig.AccessTierRules) {
+		return errors.New("azure Blob access tier rules mismatch")
+	}
+	for idx, rule := range expected.AzBlobConfig.AccessTierRules {
+		if rule.Path != actual.AzBlobConfig.AccessTierRules[idx].Path ||
+			rule.AccessTier != actual.AzBlobConfig.AccessTierRules[idx].AccessTier {
+			return fmt.Errorf("azure Blob access tier rule %q mismatch", rule.Path)
+		}
+	}
 	return nil
 }
 
@@ -19,6 +19,9 @@
 package metric
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -35,6 +38,10 @@ const (
 	loginMethodTLSCertificate       = "TLSCertificate"
 	loginMethodTLSCertificateAndPwd = "TLSCertificate+password"
 	loginMethodIDP                  = "IDP"
+	statusOK                        = "ok"
+	statusError                     = "error"
+	transferTypeUpload              = "upload"
+	transferTypeDownload            = "download"
 )
 
 func init() {
@@ -48,6 +55,30 @@ var (
 		Help: "Availability for the configured data provider, 1 means OK, 0 KO",
 	})
 
+	// providerBackupLastSuccess is the metric that reports the time of the last successful scheduled backup
+	providerBackupLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_backup_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful scheduled data provider backup",
+	})
+
+	// providerBackupLastStatus is the metric that reports the status of the last scheduled backup
+	providerBackupLastStatus = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_backup_last_status",
+		Help: "Status of the last scheduled data provider backup, 1 means OK, 0 KO",
+	})
+
+	// providerBackupLastSize is the metric that reports the size of the last successful scheduled backup
+	providerBackupLastSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_backup_last_size_bytes",
+		Help: "Size of the last successful scheduled data provider backup",
+	})
+
+	// totalProviderBackupErrors is the metric that reports the total number of failed scheduled backups
+	totalProviderBackupErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_backup_errors_total",
+		Help: "The total number of failed scheduled data provider backups",
+	})
+
 	// activeConnections is the metric that reports the total number of active connections
 	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "sftpgo_active_connections",
@@ -640,6 +671,74 @@ var (
 	})
 )
 
+var (
+	// userMetricsEnabled defines if the metrics with the username label are enabled
+	userMetricsEnabled atomic.Bool
+
+	// protocolTransfers is the metric that reports the completed transfers by protocol and storage backend
+	protocolTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_protocol_transfers_total",
+		Help: "The total number of transfers by protocol, storage backend, type and status",
+	}, []string{"protocol", "backend", "type", "status"})
+
+	// protocolTransferSize is the metric that reports the transferred bytes by protocol and storage backend
+	protocolTransferSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_protocol_transfer_bytes_total",
+		Help: "The total transferred bytes by protocol, storage backend and type, partial transfers are included",
+	}, []string{"protocol", "backend", "type"})
+
+	// transferThroughput is the metric that reports the throughput of the completed transfers
+	transferThroughput = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_transfer_throughput_bytes_per_second",
+		Help:    "The throughput of the completed transfers as bytes per second",
+		Buckets: prometheus.ExponentialBuckets(16*1024, 4, 10),
+	}, []string{"protocol", "backend", "type"})
+
+	// fsOperationDuration is the metric that reports the latency of filesystem operations
+	fsOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_fs_operation_duration_seconds",
+		Help:    "The latency of open, read, write and list operations by protocol and storage backend",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"protocol", "backend", "operation"})
+
+	// userTransfers is the metric that reports the completed transfers for each user
+	userTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_transfers_total",
+		Help: "The total number of transfers by user, type and status",
+	}, []string{"username", "type", "status"})
+
+	// userTransferSize is the metric that reports the transferred bytes for each user
+	userTransferSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_transfer_bytes_total",
+		Help: "The total transferred bytes by user and type, partial transfers are included",
+	}, []string{"username", "type"})
+
+	// dataproviderQueryDuration is the metric that reports the duration of data provider queries
+	dataproviderQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_dataprovider_query_duration_seconds",
+		Help:    "The duration of data provider queries by operation and status",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"operation", "status"})
+
+	// eventActions is the metric that reports the outcome of the executed event actions
+	eventActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_event_actions_total",
+		Help: "The total number of executed event actions by type and status",
+	}, []string{"type", "status"})
+
+	// defenderEvents is the metric that reports the events that increase the score of a host
+	defenderEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_defender_events_total",
+		Help: "The total number of defender events by protocol and event type",
+	}, []string{"protocol", "event"})
+
+	// defenderBans is the metric that reports the hosts banned by the defender
+	defenderBans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_defender_bans_total",
+		Help: "The total number of hosts banned by the defender by protocol",
+	}, []string{"protocol"})
+)
+
 // AddMetricsEndpoint publishes metrics to the specified endpoint
 func AddMetricsEndpoint(metricsPath string, handler chi.Router) {
 	handler.Handle(metricsPath, promhttp.Handler())
@@ -908,6 +1007,18 @@ func UpdateDataProviderAvailability(err error) {
 	}
 }
 
+// UpdateProviderBackupStatus updates the metrics for the scheduled data provider backups
+func UpdateProviderBackupStatus(size int64, err error) {
+	if err != nil {
+		providerBackupLastStatus.Set(0)
+		totalProviderBackupErrors.Inc()
+		return
+	}
+	providerBackupLastStatus.Set(1)
+	providerBackupLastSuccess.SetToCurrentTime()
+	providerBackupLastSize.Set(float64(size))
+}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {
 	totalLoginAttempts.Inc()
@@ -1006,3 +1117,72 @@ func HTTPRequestServed(status int) {
 func UpdateActiveConnectionsSize(size int) {
 	activeConnections.Set(float64(size))
 }
+
+// SetUserMetrics enables or disables the metrics with the username label.
+// They are disabled by default since the number of time series grows with the users
+func SetUserMetrics(enabled bool) {
+	userMetricsEnabled.Store(enabled)
+}
+
+func getStatus(err error) string {
+	if err == nil {
+		return statusOK
+	}
+	return statusError
+}
+
+// ProtocolTransferCompleted updates the protocol, storage backend and, if enabled,
+// user metrics after an upload or a download. A zero elapsed time means that the
+// transfer is not yet completed, so only the transferred bytes are updated
+func ProtocolTransferCompleted(protocol, backend, username string, bytesSent, bytesReceived int64,
+	transferKind TransferKind, elapsed time.Duration, err error,
+) {
+	transferType := transferTypeDownload
+	size := bytesSent
+	if transferKind == TransferKindUpload {
+		transferType = transferTypeUpload
+		size = bytesReceived
+	}
+	if size > 0 {
+		protocolTransferSize.WithLabelValues(protocol, backend, transferType).Add(float64(size))
+		if userMetricsEnabled.Load() {
+			userTransferSize.WithLabelValues(username, transferType).Add(float64(size))
+		}
+	}
+	if elapsed <= 0 {
+		return
+	}
+	status := getStatus(err)
+	protocolTransfers.WithLabelValues(protocol, backend, transferType, status).Inc()
+	if userMetricsEnabled.Load() {
+		userTransfers.WithLabelValues(username, transferType, status).Inc()
+	}
+	if err == nil && size > 0 {
+		transferThroughput.WithLabelValues(protocol, backend, transferType).Observe(float64(size) / elapsed.Seconds())
+	}
+}
+
+// FsOperationCompleted updates the latency metrics for the specified filesystem operation
+func FsOperationCompleted(protocol, backend, operation string, elapsed time.Duration) {
+	fsOperationDuration.WithLabelValues(protocol, backend, operation).Observe(elapsed.Seconds())
+}
+
+// ProviderQueryCompleted updates the metrics for the data provider queries
+func ProviderQueryCompleted(operation string, elapsed time.Duration, err error) {
+	dataproviderQueryDuration.WithLabelValues(operation, getStatus(err)).Observe(elapsed.Seconds())
+}
+
+// EventActionCompleted updates the metrics after an event action is executed
+func EventActionCompleted(actionType string, err error) {
+	eventActions.WithLabelValues(actionType, getStatus(err)).Inc()
+}
+
+// AddDefenderEvent increments the metrics for the defender events
+func AddDefenderEvent(protocol, event string) {
+	defenderEvents.WithLabelValues(protocol, event).Inc()
+}
+
+// AddDefenderBan increments the metrics for the hosts banned by the defender
+func AddDefenderBan(protocol string) {
+	defenderBans.WithLabelValues(protocol).Inc()
+}
@@ -4,6 +4,8 @@
 package metric
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -31,6 +33,9 @@ func S3CopyObjectCompleted(_ error) {}
 // S3DeleteObjectCompleted updates metrics after an S3 delete object request terminates
 func S3DeleteObjectCompleted(_ error) {}
 
+// S3HeadObjectCompleted updates metrics after a S3 head object request terminates
+func S3HeadObjectCompleted(_ error) {}
+
 // S3HeadBucketCompleted updates metrics after an S3 head bucket request terminates
 func S3HeadBucketCompleted(_ error) {}
 
@@ -46,9 +51,27 @@ func GCSCopyObjectCompleted(_ error) {}
 // GCSDeleteObjectCompleted updates metrics after a GCS delete object request terminates
 func GCSDeleteObjectCompleted(_ error) {}
 
+// GCSHeadObjectCompleted updates metrics after a GCS head object request terminates
+func GCSHeadObjectCompleted(_ error) {}
+
 // GCSHeadBucketCompleted updates metrics after a GCS head bucket request terminates
 func GCSHeadBucketCompleted(_ error) {}
 
+// AZTransferCompleted updates metrics after a Azure upload or a download
+func AZTransferCompleted(_ int64, _ int, _ error) {}
+
+// AZListObjectsCompleted updates metrics after a Azure list objects request terminates
+func AZListObjectsCompleted(_ error) {}
+
+// AZCopyObjectCompleted updates metrics after a Azure copy object request terminates
+func AZCopyObjectCompleted(_ error) {}
+
+// AZDeleteObjectCompleted updates metrics after a Azure delete object request terminates
+func AZDeleteObjectCompleted(_ error) {}
+
+// AZHeadObjectCompleted updates metrics after a Azure head object request terminates
+func AZHeadObjectCompleted(_ error) {}
+
 // HTTPFsTransferCompleted updates metrics after an HTTPFs upload or a download
 func HTTPFsTransferCompleted(_ int64, _ int, _ error) {}
 
@@ -58,6 +81,9 @@ func SSHCommandCompleted(_ error) {}
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(_ error) {}
 
+// UpdateProviderBackupStatus updates the metrics for the scheduled data provider backups
+func UpdateProviderBackupStatus(_ int64, _ error) {}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(_ string) {}
 
@@ -73,3 +99,26 @@ func HTTPRequestServed(_ int) {}
 
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(_ int) {}
+
+// SetUserMetrics enables or disables the metrics with the username label
+func SetUserMetrics(_ bool) {}
+
+// ProtocolTransferCompleted updates the protocol, storage backend and user metrics
+// after an upload or a download
+func ProtocolTransferCompleted(_, _, _ string, _, _ int64, _ TransferKind, _ time.Duration, _ error) {
+}
+
+// FsOperationCompleted updates the latency metrics for the specified filesystem operation
+func FsOperationCompleted(_, _, _ string, _ time.Duration) {}
+
+// ProviderQueryCompleted updates the metrics for the data provider queries
+func ProviderQueryCompleted(_ string, _ time.Duration, _ error) {}
+
+// EventActionCompleted updates the metrics after an event action is executed
+func EventActionCompleted(_ string, _ error) {}
+
+// AddDefenderEvent increments the metrics for the defender events
+func AddDefenderEvent(_, _ string) {}
+
+// AddDefenderBan increments the metrics for the hosts banned by the defender
+func AddDefenderBan(_ string) {}
@@ -96,6 +96,18 @@ func ValidateTOTPPasscode(configName, passcode, secret string) (bool, error) {
 	return false, fmt.Errorf("totp: no configuration %q", configName)
 }
 
+// GetTrustedDeviceMaxAge returns the maximum number of days a device can be
+// remembered for the TOTP configuration with the given name.
+// 0 means that remembering devices is not allowed
+func GetTrustedDeviceMaxAge(configName string) int {
+	for _, config := range totpConfigs {
+		if config.Name == configName {
+			return config.TrustedDeviceMaxAge
+		}
+	}
+	return 0
+}
+
 // GenerateTOTPSecret generates a new TOTP secret and QR code for the given username
 // using the configuration with configName
 func GenerateTOTPSecret(configName, username string) (string, *otp.Key, []byte, error) {
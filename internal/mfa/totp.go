@@ -48,7 +48,10 @@ type TOTPConfig struct {
 	Name   string       `json:"name" mapstructure:"name"`
 	Issuer string       `json:"issuer" mapstructure:"issuer"`
 	Algo   TOTPHMacAlgo `json:"algo" mapstructure:"algo"`
-	algo   otp.Algorithm
+	// Maximum number of days a device can be remembered after a successful
+	// two-factor authentication using this configuration. 0 means disabled
+	TrustedDeviceMaxAge int `json:"trusted_device_max_age" mapstructure:"trusted_device_max_age"`
+	algo                otp.Algorithm
 }
 
 func (c *TOTPConfig) validate() error {
@@ -58,6 +61,9 @@ func (c *TOTPConfig) validate() error {
 	if c.Issuer == "" {
 		return errors.New("totp: issuer is mandatory")
 	}
+	if c.TrustedDeviceMaxAge < 0 {
+		return fmt.Errorf("totp: invalid trusted device max age %d", c.TrustedDeviceMaxAge)
+	}
 	switch c.Algo {
 	case TOTPAlgoSHA1:
 		c.algo = otp.AlgorithmSHA1
@@ -0,0 +1,585 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// maximum size for XML request bodies
+	maxXMLBodySize = 2 * 1024 * 1024
+	// maximum number of keys for a DeleteObjects request
+	maxDeleteObjects = 1000
+	// MD5 of an empty content
+	emptyMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+)
+
+// subresources that are not supported, requests using them are rejected
+// instead of being handled as plain object or bucket requests
+var unsupportedSubresources = []string{"accelerate", "acl", "analytics", "attributes", "cors", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification",
+	"object-lock", "ownershipControls", "policy", "publicAccessBlock", "replication", "requestPayment",
+	"restore", "retention", "select", "tagging", "torrent", "versioning", "versions", "website"}
+
+// requestHandler handles a single authenticated S3 API request
+type requestHandler struct {
+	config *Configuration
+	conn   *Connection
+	info   *signingInfo
+	secret string
+	w      http.ResponseWriter
+	r      *http.Request
+	bucket string
+}
+
+func (h *requestHandler) serve() {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(h.r.URL.Path, "/"), "/")
+	if bucketName == "" {
+		if h.r.Method != http.MethodGet {
+			h.sendError(errMethodNotAllowed)
+			return
+		}
+		h.listBuckets()
+		return
+	}
+	if bucketName != h.bucket {
+		if h.r.Method == http.MethodPut && key == "" {
+			h.sendError(errAccessDenied)
+			return
+		}
+		h.sendError(errNoSuchBucket)
+		return
+	}
+	query := h.r.URL.Query()
+	for _, subresource := range unsupportedSubresources {
+		if query.Has(subresource) {
+			h.sendError(errNotImplemented)
+			return
+		}
+	}
+	if key == "" {
+		h.serveBucket(query)
+		return
+	}
+	name, err := getVirtualPath(key)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	h.serveObject(query, key, name)
+}
+
+func (h *requestHandler) serveBucket(query url.Values) {
+	switch h.r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			h.getBucketLocation()
+		case query.Has("uploads"):
+			h.listMultipartUploads()
+		case query.Get("list-type") == "2":
+			h.listObjectsV2(query)
+		default:
+			h.listObjectsV1(query)
+		}
+	case http.MethodHead:
+		h.w.Header().Set("x-amz-bucket-region", h.config.getRegion())
+		h.w.WriteHeader(http.StatusOK)
+		writeLog(h.r, http.StatusOK, nil)
+	case http.MethodPut:
+		h.sendError(errBucketAlreadyOwned)
+	case http.MethodPost:
+		if query.Has("delete") {
+			h.deleteObjects()
+			return
+		}
+		h.sendError(errNotImplemented)
+	default:
+		h.sendError(errMethodNotAllowed)
+	}
+}
+
+func (h *requestHandler) serveObject(query url.Values, key, name string) {
+	uploadID := query.Get("uploadId")
+
+	switch h.r.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			h.listParts(uploadID, key)
+			return
+		}
+		h.getObject(key, name)
+	case http.MethodPut:
+		if uploadID != "" {
+			if h.r.Header.Get("x-amz-copy-source") != "" {
+				h.sendError(errNotImplemented)
+				return
+			}
+			h.uploadPart(uploadID, key, name, query.Get("partNumber"))
+			return
+		}
+		if h.r.Header.Get("x-amz-copy-source") != "" {
+			h.copyObject(name)
+			return
+		}
+		h.putObject(key, name)
+	case http.MethodPost:
+		if query.Has("uploads") {
+			h.createMultipartUpload(key, name)
+			return
+		}
+		if uploadID != "" {
+			h.completeMultipartUpload(uploadID, key, name)
+			return
+		}
+		h.sendError(errNotImplemented)
+	case http.MethodDelete:
+		if uploadID != "" {
+			h.abortMultipartUpload(uploadID, key)
+			return
+		}
+		h.deleteObject(key, name)
+	default:
+		h.sendError(errMethodNotAllowed)
+	}
+}
+
+func (h *requestHandler) sendError(err error) {
+	writeErrorResponse(h.w, h.r, getAPIError(h.conn, err))
+}
+
+// disableDeadlines removes the server read and write timeouts for requests
+// transferring object contents, they can take a long time
+func (h *requestHandler) disableDeadlines() {
+	rc := http.NewResponseController(h.w)
+	rc.SetReadDeadline(time.Time{})  //nolint:errcheck
+	rc.SetWriteDeadline(time.Time{}) //nolint:errcheck
+}
+
+func (h *requestHandler) sendNoContent() {
+	h.w.WriteHeader(http.StatusNoContent)
+	writeLog(h.r, http.StatusNoContent, nil)
+}
+
+func (h *requestHandler) listBuckets() {
+	writeXMLResponse(h.w, h.r, http.StatusOK, listBucketsResponse{
+		Xmlns: s3XMLNamespace,
+		Owner: owner{ID: h.bucket, DisplayName: h.bucket},
+		Buckets: []bucket{
+			{
+				Name:         h.bucket,
+				CreationDate: formatTime(util.GetTimeFromMsecSinceEpoch(h.conn.User.CreatedAt)),
+			},
+		},
+	})
+}
+
+func (h *requestHandler) getBucketLocation() {
+	location := h.config.getRegion()
+	// us-east-1 is reported as an empty location constraint
+	if location == "us-east-1" {
+		location = ""
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, locationResponse{
+		Xmlns:    s3XMLNamespace,
+		Location: location,
+	})
+}
+
+func (h *requestHandler) getObject(key, name string) {
+	info, err := h.conn.Stat(name, 0)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	isDirKey := strings.HasSuffix(key, "/")
+	if info.IsDir() != isDirKey {
+		h.sendError(errNoSuchKey)
+		return
+	}
+	etag := getETag(info)
+	h.w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	h.w.Header().Set("ETag", etag)
+	if status := checkPreconditions(h.r, info.ModTime(), etag); status != 0 {
+		if status == http.StatusPreconditionFailed {
+			h.sendError(errPreconditionFailed)
+			return
+		}
+		h.w.WriteHeader(status)
+		writeLog(h.r, status, nil)
+		return
+	}
+	if isDirKey {
+		h.w.Header().Set("Content-Type", "application/x-directory")
+		h.w.Header().Set("Content-Length", "0")
+		h.w.WriteHeader(http.StatusOK)
+		writeLog(h.r, http.StatusOK, nil)
+		return
+	}
+	offset := int64(0)
+	size := info.Size()
+	responseStatus := http.StatusOK
+	if rangeHeader := h.r.Header.Get("Range"); rangeHeader != "" {
+		if !strings.HasPrefix(rangeHeader, "bytes=") || strings.Contains(rangeHeader, ",") {
+			h.sendError(errInvalidRange)
+			return
+		}
+		offset, size, err = parseRangeRequest(rangeHeader[6:], size)
+		if err != nil {
+			h.w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+			h.sendError(errInvalidRange)
+			return
+		}
+		responseStatus = http.StatusPartialContent
+		h.w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+size-1, info.Size()))
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	h.w.Header().Set("Content-Type", ctype)
+	h.w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	h.w.Header().Set("Accept-Ranges", "bytes")
+	if h.r.Method == http.MethodHead {
+		h.w.WriteHeader(responseStatus)
+		writeLog(h.r, responseStatus, nil)
+		return
+	}
+
+	reader, err := h.conn.getFileReader(name, offset)
+	if err != nil {
+		h.w.Header().Del("Content-Range")
+		h.sendError(err)
+		return
+	}
+	defer reader.Close()
+
+	h.disableDeadlines()
+	h.w.WriteHeader(responseStatus)
+	if _, err = io.CopyN(h.w, reader, size); err != nil {
+		reader.TransferError(err)
+		h.conn.Log(logger.LevelDebug, "error reading file to download: %v", err)
+	}
+	writeLog(h.r, responseStatus, err)
+}
+
+func (h *requestHandler) putObject(key, name string) {
+	reader, size, err := h.getBodyReader()
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		if err := h.conn.createDir(name); err != nil {
+			h.sendError(err)
+			return
+		}
+		h.w.Header().Set("ETag", fmt.Sprintf("%q", emptyMD5))
+		h.w.WriteHeader(http.StatusOK)
+		writeLog(h.r, http.StatusOK, nil)
+		return
+	}
+	if size > maxPartSize {
+		h.sendError(errEntityTooLarge)
+		return
+	}
+	diskQuota, transferQuota := h.conn.HasSpace(true, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		h.sendError(errQuotaExceeded)
+		return
+	}
+	h.disableDeadlines()
+	// the payload signature and digests are verified while staging the body,
+	// the target file is not touched if the verification fails
+	staged, err := h.stageObject(reader)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	defer func() {
+		staged.Close()           //nolint:errcheck
+		os.Remove(staged.Name()) //nolint:errcheck
+	}()
+
+	etag, err := h.conn.uploadFile(name, staged)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	h.w.Header().Set("ETag", etag)
+	h.w.WriteHeader(http.StatusOK)
+	writeLog(h.r, http.StatusOK, nil)
+}
+
+func (h *requestHandler) copyObject(name string) {
+	source, err := url.PathUnescape(h.r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		h.sendError(errInvalidArgument)
+		return
+	}
+	source, versionID, _ := strings.Cut(source, "?versionId=")
+	if versionID != "" && versionID != "null" {
+		h.sendError(errNotImplemented)
+		return
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if srcBucket != h.bucket {
+		h.sendError(errAccessDenied)
+		return
+	}
+	srcName, err := getVirtualPath(srcKey)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	srcInfo, err := h.conn.Stat(srcName, 1)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	if srcInfo.IsDir() {
+		h.sendError(errNoSuchKey)
+		return
+	}
+	// copying an object onto itself is used by clients to replace its
+	// metadata, that we don't store
+	if srcName != name {
+		if dstInfo, err := h.conn.Stat(name, 1); err == nil && dstInfo.IsDir() {
+			h.sendError(errInvalidRequest)
+			return
+		}
+		if err := h.conn.Copy(srcName, name); err != nil {
+			h.sendError(err)
+			return
+		}
+	}
+	info, err := h.conn.Stat(name, 1)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, copyObjectResponse{
+		Xmlns:        s3XMLNamespace,
+		LastModified: formatTime(info.ModTime()),
+		ETag:         getETag(info),
+	})
+}
+
+func (h *requestHandler) deleteObject(key, name string) {
+	var err error
+	if strings.HasSuffix(key, "/") {
+		err = h.conn.removeEmptyDir(name)
+	} else {
+		err = h.conn.removeFile(name)
+	}
+	// deleting a missing key is not an error
+	if err != nil && !h.conn.IsNotExistError(err) {
+		h.sendError(err)
+		return
+	}
+	h.sendNoContent()
+}
+
+func (h *requestHandler) deleteObjects() {
+	var req deleteObjectsRequest
+	if err := h.readXMLBody(&req); err != nil {
+		h.sendError(err)
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		h.sendError(errMalformedXML)
+		return
+	}
+	resp := deleteObjectsResponse{
+		Xmlns: s3XMLNamespace,
+	}
+	for _, obj := range req.Objects {
+		name, err := getVirtualPath(obj.Key)
+		if err == nil {
+			if strings.HasSuffix(obj.Key, "/") {
+				err = h.conn.removeEmptyDir(name)
+			} else {
+				err = h.conn.removeFile(name)
+			}
+			if err != nil && h.conn.IsNotExistError(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			var apiErr *apiError
+			errors.As(getAPIError(h.conn, err), &apiErr)
+			resp.Errors = append(resp.Errors, deleteError{
+				Key:     obj.Key,
+				Code:    apiErr.Code,
+				Message: apiErr.Message,
+			})
+			continue
+		}
+		if !req.Quiet {
+			resp.Deleted = append(resp.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, resp)
+}
+
+// getBodyReader returns a reader for the request body. The payload is
+// verified against the signed hash and the Content-MD5 header, if any
+func (h *requestHandler) getBodyReader() (io.Reader, int64, error) {
+	reader, size, err := h.info.getBodyReader(h.r, h.secret)
+	if err != nil {
+		return nil, 0, err
+	}
+	if contentMD5 := h.r.Header.Get("Content-MD5"); contentMD5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(expected) != md5.Size {
+			return nil, 0, &apiError{"InvalidDigest", "The Content-MD5 you specified is not valid", http.StatusBadRequest}
+		}
+		reader = &hashingReader{
+			r:        reader,
+			hasher:   md5.New(),
+			expected: expected,
+		}
+	}
+	return reader, size, nil
+}
+
+func (h *requestHandler) readXMLBody(v any) error {
+	reader, _, err := h.getBodyReader()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxXMLBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBodySize {
+		return errMalformedXML
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+// getVirtualPath returns the SFTPGo virtual path for the specified key.
+// Keys that cannot be mapped to a clean path are rejected, a trailing slash
+// identifies a directory
+func getVirtualPath(key string) (string, error) {
+	name := "/" + strings.TrimSuffix(key, "/")
+	if name == "/" || util.CleanPath(name) != name {
+		return "", errInvalidObjectName
+	}
+	return name, nil
+}
+
+// getETag returns the entity tag for the specified file info. If the storage
+// backend does not provide a content based one, a weak identifier based on
+// the modification time and size is returned. It contains a dash so that
+// clients don't compare it with the MD5 of the content
+func getETag(info os.FileInfo) string {
+	if etag := vfs.GetETag(info); etag != "" {
+		return etag
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func etagMatches(header, etag string) bool {
+	for _, val := range strings.Split(header, ",") {
+		val = strings.TrimSpace(val)
+		if val == "*" || val == etag || fmt.Sprintf("%q", val) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions returns the status code to send if a conditional
+// request header is not satisfied, 0 means the request can proceed
+func checkPreconditions(r *http.Request, modTime time.Time, etag string) int {
+	modTime = modTime.Truncate(time.Second)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if val := r.Header.Get("If-Unmodified-Since"); val != "" {
+		if t, err := http.ParseTime(val); err == nil && modTime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if val := r.Header.Get("If-Modified-Since"); val != "" {
+		if t, err := http.ParseTime(val); err == nil && !modTime.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// parseRangeRequest returns the offset and the length for the specified
+// single byte range, the range must be satisfiable
+func parseRangeRequest(bytesRange string, size int64) (int64, int64, error) {
+	first, last, ok := strings.Cut(bytesRange, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range %q", bytesRange)
+	}
+	if first == "" {
+		// we have something like -500
+		length, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || length <= 0 || size == 0 {
+			return 0, 0, fmt.Errorf("unacceptable range %q", bytesRange)
+		}
+		if length > size {
+			length = size
+		}
+		return size - length, length, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("unacceptable range %q", bytesRange)
+	}
+	end := size - 1
+	if last != "" {
+		// we have something like 500-600, otherwise 500-
+		val, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || val < start {
+			return 0, 0, fmt.Errorf("unacceptable range %q", bytesRange)
+		}
+		if val < end {
+			end = val
+		}
+	}
+	return start, end - start + 1, nil
+}
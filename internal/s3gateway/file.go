@@ -0,0 +1,164 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+type s3File struct {
+	*common.BaseTransfer
+	writer     io.WriteCloser
+	reader     io.ReadCloser
+	hasher     hash.Hash
+	isFinished bool
+}
+
+func newS3File(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, pipeReader vfs.PipeReader) *s3File {
+	var writer io.WriteCloser
+	var reader io.ReadCloser
+	if baseTransfer.File != nil {
+		writer = baseTransfer.File
+		reader = baseTransfer.File
+	} else if pipeWriter != nil {
+		writer = pipeWriter
+	} else if pipeReader != nil {
+		reader = pipeReader
+	}
+	return &s3File{
+		BaseTransfer: baseTransfer,
+		writer:       writer,
+		reader:       reader,
+		hasher:       md5.New(),
+		isFinished:   false,
+	}
+}
+
+// Read reads the contents to downloads.
+func (f *s3File) Read(p []byte) (n int, err error) {
+	if f.AbortTransfer.Load() {
+		err := f.GetAbortError()
+		f.TransferError(err)
+		return 0, err
+	}
+
+	f.Connection.UpdateLastActivity()
+
+	startTime := time.Now()
+	n, err = f.reader.Read(p)
+	f.UpdateIOMetrics(startTime)
+	f.BytesSent.Add(int64(n))
+
+	if err == nil {
+		err = f.CheckRead()
+	}
+	if err != nil && err != io.EOF {
+		f.TransferError(err)
+		err = f.ConvertError(err)
+		return
+	}
+	f.HandleThrottle()
+	return
+}
+
+// Write writes the contents to upload
+func (f *s3File) Write(p []byte) (n int, err error) {
+	if f.AbortTransfer.Load() {
+		err := f.GetAbortError()
+		f.TransferError(err)
+		return 0, err
+	}
+
+	f.Connection.UpdateLastActivity()
+
+	startTime := time.Now()
+	n, err = f.writer.Write(p)
+	f.UpdateIOMetrics(startTime)
+	f.BytesReceived.Add(int64(n))
+	f.hasher.Write(p[:n])
+
+	if err == nil {
+		err = f.CheckWrite()
+	}
+	if err != nil {
+		f.TransferError(err)
+		err = f.ConvertError(err)
+		return
+	}
+	f.HandleThrottle()
+	return
+}
+
+// getETag returns the MD5 of the uploaded contents, as S3 does for single
+// part uploads
+func (f *s3File) getETag() string {
+	return `"` + hex.EncodeToString(f.hasher.Sum(nil)) + `"`
+}
+
+// Close closes the current transfer
+func (f *s3File) Close() error {
+	if err := f.setFinished(); err != nil {
+		return err
+	}
+	err := f.closeIO()
+	errBaseClose := f.BaseTransfer.Close()
+	if errBaseClose != nil {
+		err = errBaseClose
+	}
+
+	return f.Connection.GetFsError(f.Fs, err)
+}
+
+func (f *s3File) closeIO() error {
+	var err error
+	if f.File != nil {
+		err = f.File.Close()
+	} else if f.writer != nil {
+		err = f.writer.Close()
+		f.Lock()
+		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
+		if err != nil && f.ErrTransfer == nil {
+			f.ErrTransfer = err
+		}
+		f.Unlock()
+		if metadater, ok := f.writer.(vfs.Metadater); ok {
+			f.BaseTransfer.AddMetadata(metadater.Metadata())
+		}
+	} else if f.reader != nil {
+		err = f.reader.Close()
+		if metadater, ok := f.reader.(vfs.Metadater); ok {
+			f.BaseTransfer.SetMetadata(metadater.Metadata())
+		}
+	}
+	return err
+}
+
+func (f *s3File) setFinished() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.isFinished {
+		return common.ErrTransferClosed
+	}
+	f.isFinished = true
+	return nil
+}
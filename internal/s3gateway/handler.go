@@ -0,0 +1,315 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Connection details for an S3 API request used to interact with an SFTPGo filesystem
+type Connection struct {
+	*common.BaseConnection
+	request *http.Request
+}
+
+// GetClientVersion returns the connected client's version.
+func (c *Connection) GetClientVersion() string {
+	if c.request != nil {
+		return c.request.UserAgent()
+	}
+	return ""
+}
+
+// GetLocalAddress returns local connection address
+func (c *Connection) GetLocalAddress() string {
+	return util.GetHTTPLocalAddress(c.request)
+}
+
+// GetRemoteAddress returns the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	if c.request != nil {
+		return c.request.RemoteAddr
+	}
+	return ""
+}
+
+// Disconnect closes the active transfer
+func (c *Connection) Disconnect() (err error) {
+	return c.SignalTransfersAbort()
+}
+
+// GetCommand returns the request method
+func (c *Connection) GetCommand() string {
+	if c.request != nil {
+		return strings.ToUpper(c.request.Method)
+	}
+	return ""
+}
+
+// Stat returns a FileInfo describing the named file/directory, or an error,
+// if any happens
+func (c *Connection) Stat(name string, mode int) (os.FileInfo, error) {
+	c.UpdateLastActivity()
+
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	return c.DoStat(name, mode, true)
+}
+
+// readDir returns all the entries for the specified directory
+func (c *Connection) readDir(name string) ([]os.FileInfo, error) {
+	c.UpdateLastActivity()
+
+	lister, err := c.ListDir(name)
+	if err != nil {
+		return nil, err
+	}
+	defer lister.Close()
+
+	var result []os.FileInfo
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		result = append(result, files...)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Connection) getFileReader(name string, offset int64) (*s3File, error) {
+	c.UpdateLastActivity()
+
+	transferQuota := c.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return nil, c.GetReadQuotaExceededError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	if ok, policy := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "reading file %q is not allowed", name)
+		return nil, c.GetErrorForDeniedFile(policy)
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, name, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", name, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	startTime := time.Now()
+	file, r, cancelFn, err := fs.Open(p, offset)
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
+	if err != nil {
+		c.Log(logger.LevelError, "could not open file %q for reading: %+v", p, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, name, common.TransferDownload,
+		0, 0, 0, 0, false, fs, transferQuota)
+	return newS3File(baseTransfer, nil, r), nil
+}
+
+func (c *Connection) getFileWriter(name string) (*s3File, error) {
+	c.UpdateLastActivity()
+
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+
+	stat, statErr := fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return nil, c.GetPermissionDeniedError()
+		}
+		return c.handleUploadFile(fs, p, filePath, name, true, 0)
+	}
+
+	if statErr != nil {
+		c.Log(logger.LevelError, "error performing file stat %q: %+v", p, statErr)
+		return nil, c.GetFsError(fs, statErr)
+	}
+
+	// This happen if we upload a file that has the same name of an existing directory
+	if stat.IsDir() {
+		c.Log(logger.LevelError, "attempted to open a directory for writing to: %q", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		_, _, err = fs.Rename(p, filePath)
+		if err != nil {
+			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %q, dest: %q, err: %+v",
+				p, filePath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+	}
+
+	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size())
+}
+
+func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool, fileSize int64) (*s3File, error) {
+	diskQuota, transferQuota := c.HasSpace(isNewFile, false, requestPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+	_, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	maxWriteSize, _ := c.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile {
+		if err := c.CreateFileVersion(fs, filePath, requestPath, fileSize); err != nil {
+			return nil, err
+		}
+	}
+
+	startTime := time.Now()
+	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.GetCreateChecks(requestPath, isNewFile, false))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
+	if err != nil {
+		c.Log(logger.LevelError, "error opening existing file, source: %q, err: %+v", filePath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	initialSize := int64(0)
+	truncatedSize := int64(0) // bytes truncated and not included in quota
+	if !isNewFile {
+		if vfs.HasTruncateSupport(fs) {
+			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+			if err == nil {
+				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+				if vfolder.IsIncludedInUserQuota() {
+					dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+				}
+			} else {
+				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+			}
+		} else {
+			initialSize = fileSize
+			truncatedSize = fileSize
+		}
+		if maxWriteSize > 0 {
+			maxWriteSize += fileSize
+		}
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	return newS3File(baseTransfer, w, nil), nil
+}
+
+// uploadFile writes the contents read from the specified reader to the given
+// virtual path. Missing parent directories are created. The reader must
+// return already verified contents, the upload is discarded if reading fails
+func (c *Connection) uploadFile(name string, reader io.Reader) (string, error) {
+	if parent := path.Dir(name); parent != "/" {
+		if err := c.CheckParentDirs(parent); err != nil {
+			c.Log(logger.LevelDebug, "unable to check parent dirs for %q: %v", name, err)
+			return "", err
+		}
+	}
+	writer, err := c.getFileWriter(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.TransferError(err)
+		writer.Close() //nolint:errcheck
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return writer.getETag(), nil
+}
+
+// createDir creates the specified directory and any missing parent
+func (c *Connection) createDir(name string) error {
+	c.UpdateLastActivity()
+
+	return c.CheckParentDirs(name)
+}
+
+// removeFile removes the specified file, directories are not allowed
+func (c *Connection) removeFile(name string) error {
+	c.UpdateLastActivity()
+
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Lstat(p)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to remove file %q: stat error: %+v", p, err)
+		return c.GetFsError(fs, err)
+	}
+	if info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+		// a key without the trailing slash never matches a directory
+		return c.GetNotExistError()
+	}
+	return c.RemoveFile(fs, p, name, info)
+}
+
+// removeEmptyDir removes the specified directory if it is empty, as for
+// directory markers in S3
+func (c *Connection) removeEmptyDir(name string) error {
+	c.UpdateLastActivity()
+
+	return c.RemoveDir(name)
+}
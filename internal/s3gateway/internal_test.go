@@ -0,0 +1,241 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+)
+
+const (
+	// values from the AWS documentation for the streaming payload signature
+	awsExampleSecret    = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+	awsExampleSeedSig   = "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9"
+	awsExampleChunk1Sig = "ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648"
+	awsExampleChunk2Sig = "0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497"
+	awsExampleFinalSig  = "b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"
+)
+
+var testTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func getAWSExampleChunkedBody(finalSig string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "10000;chunk-signature=%s\r\n%s\r\n", awsExampleChunk1Sig, strings.Repeat("a", 65536))
+	fmt.Fprintf(&b, "400;chunk-signature=%s\r\n%s\r\n", awsExampleChunk2Sig, strings.Repeat("a", 1024))
+	fmt.Fprintf(&b, "0;chunk-signature=%s\r\n\r\n", finalSig)
+	return b.String()
+}
+
+func TestChunkedReader(t *testing.T) {
+	info := &signingInfo{
+		amzDate:     "20130524T000000Z",
+		date:        "20130524",
+		region:      "us-east-1",
+		service:     "s3",
+		signature:   awsExampleSeedSig,
+		payloadHash: streamingPayload,
+	}
+	for _, finalSig := range []string{awsExampleFinalSig, awsExampleSeedSig} {
+		r := httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader(getAWSExampleChunkedBody(finalSig)))
+		r.Header.Set("X-Amz-Decoded-Content-Length", "66560")
+		reader, size, err := info.getBodyReader(r, awsExampleSecret)
+		require.NoError(t, err)
+		assert.Equal(t, int64(66560), size)
+		data, err := io.ReadAll(reader)
+		if finalSig == awsExampleFinalSig {
+			assert.NoError(t, err)
+			assert.Equal(t, bytes.Repeat([]byte("a"), 66560), data)
+		} else {
+			assert.ErrorIs(t, err, errSignatureMismatch)
+		}
+	}
+	// wrong decoded length
+	r := httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader(getAWSExampleChunkedBody(awsExampleFinalSig)))
+	r.Header.Set("X-Amz-Decoded-Content-Length", "66561")
+	reader, _, err := info.getBodyReader(r, awsExampleSecret)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, errIncompleteBody)
+	// the chunk signatures depend on the secret
+	r = httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader(getAWSExampleChunkedBody(awsExampleFinalSig)))
+	r.Header.Set("X-Amz-Decoded-Content-Length", "66560")
+	reader, _, err = info.getBodyReader(r, "secret")
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, errSignatureMismatch)
+}
+
+func TestPayloadHash(t *testing.T) {
+	info := &signingInfo{
+		payloadHash: sha256Hex([]byte("content")),
+	}
+	r := httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader("content"))
+	reader, _, err := info.getBodyReader(r, "")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+
+	r = httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader("modified content"))
+	reader, _, err = info.getBodyReader(r, "")
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, errBadDigest)
+
+	info.payloadHash = "invalid"
+	_, _, err = info.getBodyReader(r, "")
+	assert.ErrorIs(t, err, errNotImplemented)
+}
+
+func TestURIEncode(t *testing.T) {
+	assert.Equal(t, "a/b%20c/d~e", uriEncode("a/b c/d~e", false))
+	assert.Equal(t, "a%2Fb%2Bc", uriEncode("a/b+c", true))
+	assert.Equal(t, "%C3%A8", uriEncode("è", true))
+}
+
+func TestGetVirtualPath(t *testing.T) {
+	name, err := getVirtualPath("a/b/c.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/a/b/c.txt", name)
+	name, err = getVirtualPath("a/b/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/a/b", name)
+	for _, key := range []string{"/", "a//b", "a/../b", "./a", "a/./b", "a/b//"} {
+		_, err = getVirtualPath(key)
+		assert.ErrorIs(t, err, errInvalidObjectName, key)
+	}
+}
+
+func TestParseRangeRequest(t *testing.T) {
+	offset, size, err := parseRangeRequest("0-0", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	assert.Equal(t, int64(1), size)
+	offset, size, err = parseRangeRequest("2-", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), offset)
+	assert.Equal(t, int64(8), size)
+	offset, size, err = parseRangeRequest("-3", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), offset)
+	assert.Equal(t, int64(3), size)
+	offset, size, err = parseRangeRequest("-30", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	assert.Equal(t, int64(10), size)
+	offset, size, err = parseRangeRequest("5-100", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), offset)
+	assert.Equal(t, int64(5), size)
+	for _, val := range []string{"10-", "5-4", "-0", "a-", "1", "-"} {
+		_, _, err = parseRangeRequest(val, 10)
+		assert.Error(t, err, val)
+	}
+	_, _, err = parseRangeRequest("0-", 0)
+	assert.Error(t, err)
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	r.Header.Set("If-Match", `"etag1", "etag2"`)
+	assert.Equal(t, 0, checkPreconditions(r, testTime, `"etag2"`))
+	assert.Equal(t, http.StatusPreconditionFailed, checkPreconditions(r, testTime, `"etag3"`))
+	r = httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	r.Header.Set("If-None-Match", "*")
+	assert.Equal(t, http.StatusNotModified, checkPreconditions(r, testTime, `"etag"`))
+	r = httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	r.Header.Set("If-Modified-Since", testTime.Format(http.TimeFormat))
+	assert.Equal(t, http.StatusNotModified, checkPreconditions(r, testTime, `"etag"`))
+	r.Header.Set("If-Modified-Since", testTime.Add(-time.Hour).Format(http.TimeFormat))
+	assert.Equal(t, 0, checkPreconditions(r, testTime, `"etag"`))
+}
+
+func TestReloadBindings(t *testing.T) {
+	registry := runningBindings.Load()
+	bindings := serviceStatus.Bindings
+	defer func() {
+		runningBindings.Store(registry)
+		serviceStatus.Bindings = bindings
+	}()
+
+	runningBindings.Store(nil)
+	c := Configuration{
+		Bindings: []Binding{
+			{
+				Address: "127.0.0.1",
+				Port:    9237,
+			},
+		},
+	}
+	err := c.ReloadBindings()
+	assert.Error(t, err)
+
+	runningBindings.Store(common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		server := s3Server{
+			config:  &c,
+			binding: b,
+		}
+		return server.listenAndServe(l)
+	}))
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	s3URL := fmt.Sprintf("http://%s/", c.Bindings[0].GetAddress())
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(s3URL)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusForbidden
+	}, 2*time.Second, 50*time.Millisecond)
+	// a new HTTPS binding with a certificate not loaded at startup requires a restart
+	c.Bindings = append(c.Bindings, Binding{
+		Address:     "127.0.0.1",
+		Port:        9238,
+		EnableHTTPS: true,
+	})
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	assert.Equal(t, 1, runningBindings.Load().Len())
+
+	c.Bindings = nil
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 0)
+	assert.Equal(t, 0, runningBindings.Load().Len())
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(s3URL)
+		if err != nil {
+			return true
+		}
+		resp.Body.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
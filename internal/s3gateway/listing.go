@@ -0,0 +1,291 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxListKeys     = 1000
+	storageStandard = "STANDARD"
+)
+
+var errStopListing = errors.New("listing limit reached")
+
+type listEntry struct {
+	key   string
+	isDir bool
+	obj   object
+}
+
+// objectLister lists the keys, in lexicographical order, for a bucket.
+// Directories are mapped to keys ending with a slash and are returned as
+// common prefixes if the delimiter is set
+type objectLister struct {
+	conn        *Connection
+	prefix      string
+	delimiter   string
+	marker      string
+	maxKeys     int
+	objects     []object
+	prefixes    []commonPrefix
+	isTruncated bool
+	lastKey     string
+}
+
+func (l *objectLister) count() int {
+	return len(l.objects) + len(l.prefixes)
+}
+
+func (l *objectLister) add(entry listEntry) error {
+	if l.count() >= l.maxKeys {
+		l.isTruncated = true
+		return errStopListing
+	}
+	if entry.isDir {
+		l.prefixes = append(l.prefixes, commonPrefix{Prefix: entry.key})
+	} else {
+		l.objects = append(l.objects, entry.obj)
+	}
+	l.lastKey = entry.key
+	return nil
+}
+
+func (l *objectLister) list() error {
+	if l.maxKeys == 0 {
+		return nil
+	}
+	baseKey := ""
+	if idx := strings.LastIndex(l.prefix, "/"); idx >= 0 {
+		baseKey = l.prefix[:idx+1]
+	}
+	baseDir := "/"
+	if baseKey != "" {
+		name, err := getVirtualPath(baseKey)
+		if err != nil {
+			// no object can match the requested prefix
+			return nil
+		}
+		baseDir = name
+	}
+	err := l.walk(baseDir, baseKey, 0)
+	if errors.Is(err, errStopListing) {
+		return nil
+	}
+	return err
+}
+
+func (l *objectLister) walk(dir, dirKey string, recursion int) error {
+	if recursion >= util.MaxRecursion {
+		l.conn.Log(logger.LevelError, "unable to list %q, recursion too deep: %d", dir, recursion)
+		return util.ErrRecursionTooDeep
+	}
+	files, err := l.conn.readDir(dir)
+	if err != nil {
+		if recursion > 0 || l.conn.IsNotExistError(err) {
+			// missing or not accessible directories are not listed
+			l.conn.Log(logger.LevelDebug, "unable to list directory %q: %v", dir, err)
+			return nil
+		}
+		return err
+	}
+	entries := make([]listEntry, 0, len(files))
+	for _, info := range files {
+		entry := listEntry{
+			key:   dirKey + info.Name(),
+			isDir: info.IsDir(),
+		}
+		if entry.isDir {
+			entry.key += "/"
+		} else {
+			entry.obj = object{
+				Key:          entry.key,
+				LastModified: formatTime(info.ModTime()),
+				ETag:         getETag(info),
+				Size:         info.Size(),
+				StorageClass: storageStandard,
+			}
+		}
+		entries = append(entries, entry)
+	}
+	// the keys for the directory contents start with the directory key, so
+	// sorting the entries by key sorts the whole listing
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	for _, entry := range entries {
+		if !entry.isDir {
+			if strings.HasPrefix(entry.key, l.prefix) && entry.key > l.marker {
+				if err := l.add(entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		isInPrefix := strings.HasPrefix(entry.key, l.prefix)
+		if !isInPrefix && !strings.HasPrefix(l.prefix, entry.key) {
+			continue
+		}
+		if isInPrefix && l.delimiter != "" {
+			if entry.key > l.marker && !strings.HasPrefix(l.marker, entry.key) {
+				if err := l.add(entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if entry.key <= l.marker && !strings.HasPrefix(l.marker, entry.key) {
+			continue
+		}
+		if err := l.walk(path.Join(dir, path.Base(entry.key)), entry.key, recursion+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getListParams(query url.Values) (int, string, error) {
+	maxKeys := maxListKeys
+	if val := query.Get("max-keys"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return 0, "", errInvalidArgument
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+	delimiter := query.Get("delimiter")
+	if delimiter != "" && delimiter != "/" {
+		return 0, "", errNotImplemented
+	}
+	if encodingType := query.Get("encoding-type"); encodingType != "" && encodingType != "url" {
+		return 0, "", errInvalidArgument
+	}
+	return maxKeys, delimiter, nil
+}
+
+// encodeKey encodes the key if the client requested the url encoding type
+func encodeKey(key, encodingType string) string {
+	if encodingType == "url" {
+		return uriEncode(key, false)
+	}
+	return key
+}
+
+func (l *objectLister) encode(encodingType string) {
+	for idx := range l.objects {
+		l.objects[idx].Key = encodeKey(l.objects[idx].Key, encodingType)
+	}
+	for idx := range l.prefixes {
+		l.prefixes[idx].Prefix = encodeKey(l.prefixes[idx].Prefix, encodingType)
+	}
+}
+
+func (h *requestHandler) listObjectsV1(query url.Values) {
+	maxKeys, delimiter, err := getListParams(query)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	lister := objectLister{
+		conn:      h.conn,
+		prefix:    query.Get("prefix"),
+		delimiter: delimiter,
+		marker:    query.Get("marker"),
+		maxKeys:   maxKeys,
+	}
+	if err := lister.list(); err != nil {
+		h.sendError(err)
+		return
+	}
+	encodingType := query.Get("encoding-type")
+	lister.encode(encodingType)
+	resp := listObjectsV1Response{
+		Xmlns:          s3XMLNamespace,
+		Name:           h.bucket,
+		Prefix:         encodeKey(lister.prefix, encodingType),
+		Marker:         encodeKey(lister.marker, encodingType),
+		Delimiter:      delimiter,
+		MaxKeys:        maxKeys,
+		IsTruncated:    lister.isTruncated,
+		EncodingType:   encodingType,
+		Contents:       lister.objects,
+		CommonPrefixes: lister.prefixes,
+	}
+	if lister.isTruncated {
+		resp.NextMarker = encodeKey(lister.lastKey, encodingType)
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, resp)
+}
+
+func (h *requestHandler) listObjectsV2(query url.Values) {
+	maxKeys, delimiter, err := getListParams(query)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	continuationToken := query.Get("continuation-token")
+	marker := query.Get("start-after")
+	if continuationToken != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(continuationToken)
+		if err != nil {
+			h.sendError(&apiError{"InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest})
+			return
+		}
+		marker = string(decoded)
+	}
+	lister := objectLister{
+		conn:      h.conn,
+		prefix:    query.Get("prefix"),
+		delimiter: delimiter,
+		marker:    marker,
+		maxKeys:   maxKeys,
+	}
+	if err := lister.list(); err != nil {
+		h.sendError(err)
+		return
+	}
+	encodingType := query.Get("encoding-type")
+	lister.encode(encodingType)
+	resp := listObjectsV2Response{
+		Xmlns:             s3XMLNamespace,
+		Name:              h.bucket,
+		Prefix:            encodeKey(lister.prefix, encodingType),
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		KeyCount:          lister.count(),
+		IsTruncated:       lister.isTruncated,
+		ContinuationToken: continuationToken,
+		StartAfter:        encodeKey(query.Get("start-after"), encodingType),
+		EncodingType:      encodingType,
+		Contents:          lister.objects,
+		CommonPrefixes:    lister.prefixes,
+	}
+	if lister.isTruncated {
+		resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(lister.lastKey))
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, resp)
+}
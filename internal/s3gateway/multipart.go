@@ -0,0 +1,499 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	maxPartNumber = 10000
+	maxPartSize   = 5 * 1024 * 1024 * 1024
+	maxListParts  = 1000
+	// uploads are staged inside this directory, relative to the temp path
+	multipartDirName = "sftpgo-s3-multipart"
+)
+
+var multipartUploads = &multipartManager{
+	uploads: make(map[string]*multipartUpload),
+}
+
+type uploadedPart struct {
+	size    int64
+	etag    string
+	modTime time.Time
+}
+
+type multipartUpload struct {
+	id         string
+	username   string
+	key        string
+	initiated  time.Time
+	parts      map[int]uploadedPart
+	completing bool
+}
+
+// multipartManager keeps track of the in progress multipart uploads. Parts
+// are staged on the local filesystem and are copied to the user filesystem
+// when the upload is completed. Uploads are kept in memory, so they are lost
+// if the service is restarted
+type multipartManager struct {
+	sync.RWMutex
+	baseDir    string
+	expiration time.Duration
+	uploads    map[string]*multipartUpload
+	cleanup    sync.Once
+}
+
+func (m *multipartManager) init(expiration time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.baseDir = filepath.Join(getTempPath(), multipartDirName)
+	m.expiration = expiration
+	m.uploads = make(map[string]*multipartUpload)
+	// the uploads are not persisted, any staged part is stale
+	if err := os.RemoveAll(m.baseDir); err != nil {
+		return fmt.Errorf("unable to remove the multipart uploads dir %q: %w", m.baseDir, err)
+	}
+	if err := os.MkdirAll(m.baseDir, 0700); err != nil {
+		return fmt.Errorf("unable to create the multipart uploads dir %q: %w", m.baseDir, err)
+	}
+	m.cleanup.Do(func() {
+		go func() {
+			for range time.Tick(10 * time.Minute) {
+				m.removeExpired()
+			}
+		}()
+	})
+	return nil
+}
+
+func (m *multipartManager) getUploadDir(id string) string {
+	return filepath.Join(m.baseDir, id)
+}
+
+func (m *multipartManager) getPartPath(id string, partNumber int) string {
+	return filepath.Join(m.getUploadDir(id), strconv.Itoa(partNumber))
+}
+
+func (m *multipartManager) create(username, key string) (string, error) {
+	id := util.GenerateUniqueID()
+	if err := os.Mkdir(m.getUploadDir(id), 0700); err != nil {
+		return "", err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.uploads[id] = &multipartUpload{
+		id:        id,
+		username:  username,
+		key:       key,
+		initiated: time.Now(),
+		parts:     make(map[int]uploadedPart),
+	}
+	return id, nil
+}
+
+// get returns a copy of the specified upload
+func (m *multipartManager) get(id, username, key string) (multipartUpload, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.username != username || upload.key != key || upload.completing {
+		return multipartUpload{}, errNoSuchUpload
+	}
+	result := *upload
+	result.parts = make(map[int]uploadedPart, len(upload.parts))
+	for k, v := range upload.parts {
+		result.parts[k] = v
+	}
+	return result, nil
+}
+
+func (m *multipartManager) addPart(id string, partNumber int, part uploadedPart) error {
+	m.Lock()
+	defer m.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.completing {
+		return errNoSuchUpload
+	}
+	upload.parts[partNumber] = part
+	return nil
+}
+
+// setCompleting marks the upload as being completed, parts cannot be added
+// while completing and concurrent completions are not allowed
+func (m *multipartManager) setCompleting(id, username, key string, completing bool) (multipartUpload, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.username != username || upload.key != key || upload.completing == completing {
+		return multipartUpload{}, errNoSuchUpload
+	}
+	upload.completing = completing
+	return *upload, nil
+}
+
+func (m *multipartManager) remove(id string) {
+	m.Lock()
+	delete(m.uploads, id)
+	m.Unlock()
+
+	if err := os.RemoveAll(m.getUploadDir(id)); err != nil {
+		logger.Warn(logSender, "", "unable to remove the staging dir for multipart upload %q: %v", id, err)
+	}
+}
+
+func (m *multipartManager) list(username string) []multipartUpload {
+	m.RLock()
+	defer m.RUnlock()
+
+	var result []multipartUpload
+	for _, upload := range m.uploads {
+		if upload.username == username && !upload.completing {
+			result = append(result, *upload)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].key == result[j].key {
+			return result[i].initiated.Before(result[j].initiated)
+		}
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+func (m *multipartManager) removeExpired() {
+	var expired []string
+
+	m.RLock()
+	for id, upload := range m.uploads {
+		if !upload.completing && time.Since(upload.initiated) > m.expiration {
+			expired = append(expired, id)
+		}
+	}
+	m.RUnlock()
+
+	for _, id := range expired {
+		logger.Debug(logSender, "", "removing expired multipart upload %q", id)
+		m.remove(id)
+	}
+}
+
+func (h *requestHandler) createMultipartUpload(key, name string) {
+	if strings.HasSuffix(key, "/") {
+		h.sendError(errInvalidObjectName)
+		return
+	}
+	if !h.conn.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+		h.sendError(errAccessDenied)
+		return
+	}
+	if ok, _ := h.conn.User.IsFileAllowed(name); !ok {
+		h.sendError(errAccessDenied)
+		return
+	}
+	id, err := multipartUploads.create(h.conn.User.Username, key)
+	if err != nil {
+		h.conn.Log(logger.LevelError, "unable to create multipart upload for %q: %v", name, err)
+		h.sendError(errInternal)
+		return
+	}
+	h.conn.Log(logger.LevelDebug, "multipart upload %q created for %q", id, name)
+	writeXMLResponse(h.w, h.r, http.StatusOK, initiateMultipartUploadResponse{
+		Xmlns:    s3XMLNamespace,
+		Bucket:   h.bucket,
+		Key:      key,
+		UploadID: id,
+	})
+}
+
+func (h *requestHandler) uploadPart(uploadID, key, name, partNumberParam string) {
+	partNumber, err := strconv.Atoi(partNumberParam)
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		h.sendError(&apiError{"InvalidArgument", "Part number must be an integer between 1 and 10000",
+			http.StatusBadRequest})
+		return
+	}
+	if _, err := multipartUploads.get(uploadID, h.conn.User.Username, key); err != nil {
+		h.sendError(err)
+		return
+	}
+	reader, size, err := h.getBodyReader()
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	if size > maxPartSize {
+		h.sendError(errEntityTooLarge)
+		return
+	}
+	diskQuota, transferQuota := h.conn.HasSpace(true, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		h.sendError(errQuotaExceeded)
+		return
+	}
+	h.disableDeadlines()
+	part, err := h.writePart(uploadID, partNumber, reader)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	if err := multipartUploads.addPart(uploadID, partNumber, part); err != nil {
+		os.Remove(multipartUploads.getPartPath(uploadID, partNumber)) //nolint:errcheck
+		h.sendError(err)
+		return
+	}
+	h.w.Header().Set("ETag", part.etag)
+	h.w.WriteHeader(http.StatusOK)
+	writeLog(h.r, http.StatusOK, nil)
+}
+
+func (h *requestHandler) writePart(uploadID string, partNumber int, reader io.Reader) (uploadedPart, error) {
+	f, err := os.CreateTemp(multipartUploads.getUploadDir(uploadID), "upload-")
+	if err != nil {
+		h.conn.Log(logger.LevelError, "unable to create temporary file for multipart upload %q: %v", uploadID, err)
+		return uploadedPart{}, errNoSuchUpload
+	}
+	hasher := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, hasher), io.LimitReader(reader, maxPartSize+1))
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil && n > maxPartSize {
+		err = errEntityTooLarge
+	}
+	if err == nil {
+		// a part can be uploaded again, the last upload wins
+		err = os.Rename(f.Name(), multipartUploads.getPartPath(uploadID, partNumber))
+	}
+	if err != nil {
+		h.conn.Log(logger.LevelDebug, "unable to write part %d for multipart upload %q: %v", partNumber, uploadID, err)
+		os.Remove(f.Name()) //nolint:errcheck
+		return uploadedPart{}, err
+	}
+	h.conn.UpdateLastActivity()
+	return uploadedPart{
+		size:    n,
+		etag:    fmt.Sprintf("%q", hex.EncodeToString(hasher.Sum(nil))),
+		modTime: time.Now(),
+	}, nil
+}
+
+// stageObject writes the body of a single part upload to a temporary file
+// and returns it opened for reading. The file is removed if reading the body
+// fails, for example because the payload signature does not match
+func (h *requestHandler) stageObject(reader io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp(multipartUploads.baseDir, "put-")
+	if err != nil {
+		h.conn.Log(logger.LevelError, "unable to create temporary file for upload: %v", err)
+		return nil, err
+	}
+	n, err := io.Copy(f, io.LimitReader(reader, maxPartSize+1))
+	if err == nil && n > maxPartSize {
+		err = errEntityTooLarge
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		h.conn.Log(logger.LevelDebug, "unable to stage upload: %v", err)
+		f.Close()           //nolint:errcheck
+		os.Remove(f.Name()) //nolint:errcheck
+		return nil, err
+	}
+	h.conn.UpdateLastActivity()
+	return f, nil
+}
+
+func (h *requestHandler) completeMultipartUpload(uploadID, key, name string) {
+	var req completeMultipartUploadRequest
+	if err := h.readXMLBody(&req); err != nil {
+		h.sendError(err)
+		return
+	}
+	if len(req.Parts) == 0 {
+		h.sendError(errMalformedXML)
+		return
+	}
+	upload, err := multipartUploads.setCompleting(uploadID, h.conn.User.Username, key, true)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	etag, err := h.assembleParts(upload, req, name)
+	if err != nil {
+		multipartUploads.setCompleting(uploadID, h.conn.User.Username, key, false) //nolint:errcheck
+		h.sendError(err)
+		return
+	}
+	multipartUploads.remove(uploadID)
+	h.conn.Log(logger.LevelDebug, "multipart upload %q completed for %q", uploadID, name)
+	writeXMLResponse(h.w, h.r, http.StatusOK, completeMultipartUploadResponse{
+		Xmlns:  s3XMLNamespace,
+		Bucket: h.bucket,
+		Key:    key,
+		ETag:   etag,
+	})
+}
+
+// assembleParts writes the requested parts, in order, to the target file and
+// returns the multipart ETag
+func (h *requestHandler) assembleParts(upload multipartUpload, req completeMultipartUploadRequest, name string) (string, error) {
+	hasher := md5.New()
+	readers := make([]io.Reader, 0, len(req.Parts))
+	files := make([]*os.File, 0, len(req.Parts))
+	defer func() {
+		for _, f := range files {
+			f.Close() //nolint:errcheck
+		}
+	}()
+
+	for idx, p := range req.Parts {
+		if idx > 0 && p.PartNumber <= req.Parts[idx-1].PartNumber {
+			return "", errInvalidPartOrder
+		}
+		uploaded, ok := upload.parts[p.PartNumber]
+		if !ok || strings.Trim(uploaded.etag, `"`) != strings.Trim(p.ETag, `"`) {
+			return "", errInvalidPart
+		}
+		partMD5, _ := hex.DecodeString(strings.Trim(uploaded.etag, `"`))
+		hasher.Write(partMD5)
+		f, err := os.Open(multipartUploads.getPartPath(upload.id, p.PartNumber))
+		if err != nil {
+			h.conn.Log(logger.LevelError, "unable to open part %d for multipart upload %q: %v", p.PartNumber, upload.id, err)
+			return "", errInvalidPart
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+	h.disableDeadlines()
+	if _, err := h.conn.uploadFile(name, io.MultiReader(readers...)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(hasher.Sum(nil)), len(req.Parts)), nil
+}
+
+func (h *requestHandler) abortMultipartUpload(uploadID, key string) {
+	if _, err := multipartUploads.get(uploadID, h.conn.User.Username, key); err != nil {
+		h.sendError(err)
+		return
+	}
+	multipartUploads.remove(uploadID)
+	h.conn.Log(logger.LevelDebug, "multipart upload %q aborted", uploadID)
+	h.sendNoContent()
+}
+
+func (h *requestHandler) listParts(uploadID, key string) {
+	upload, err := multipartUploads.get(uploadID, h.conn.User.Username, key)
+	if err != nil {
+		h.sendError(err)
+		return
+	}
+	query := h.r.URL.Query()
+	maxParts := maxListParts
+	if val := query.Get("max-parts"); val != "" {
+		maxParts, err = strconv.Atoi(val)
+		if err != nil || maxParts < 0 {
+			h.sendError(errInvalidArgument)
+			return
+		}
+		maxParts = min(maxParts, maxListParts)
+	}
+	marker := 0
+	if val := query.Get("part-number-marker"); val != "" {
+		marker, err = strconv.Atoi(val)
+		if err != nil {
+			h.sendError(errInvalidArgument)
+			return
+		}
+	}
+	partNumbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		if number > marker {
+			partNumbers = append(partNumbers, number)
+		}
+	}
+	sort.Ints(partNumbers)
+
+	resp := listPartsResponse{
+		Xmlns:            s3XMLNamespace,
+		Bucket:           h.bucket,
+		Key:              key,
+		UploadID:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, number := range partNumbers {
+		if len(resp.Parts) >= maxParts {
+			resp.IsTruncated = true
+			break
+		}
+		p := upload.parts[number]
+		resp.Parts = append(resp.Parts, part{
+			PartNumber:   number,
+			LastModified: formatTime(p.modTime),
+			ETag:         p.etag,
+			Size:         p.size,
+		})
+		resp.NextPartNumberMarker = number
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, resp)
+}
+
+func (h *requestHandler) listMultipartUploads() {
+	resp := listMultipartUploadsResponse{
+		Xmlns:      s3XMLNamespace,
+		Bucket:     h.bucket,
+		MaxUploads: maxListParts,
+	}
+	prefix := h.r.URL.Query().Get("prefix")
+	for _, upload := range multipartUploads.list(h.conn.User.Username) {
+		if !strings.HasPrefix(upload.key, prefix) {
+			continue
+		}
+		if len(resp.Uploads) >= maxListParts {
+			resp.IsTruncated = true
+			break
+		}
+		resp.Uploads = append(resp.Uploads, multipartUploadInfo{
+			Key:       upload.key,
+			UploadID:  upload.id,
+			Initiated: formatTime(upload.initiated),
+		})
+	}
+	writeXMLResponse(h.w, h.r, http.StatusOK, resp)
+}
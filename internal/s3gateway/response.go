@@ -0,0 +1,304 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// apiError defines an S3 error response
+type apiError struct {
+	Code       string
+	Message    string
+	StatusCode int
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+var (
+	errAccessDenied = &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errBadDigest    = &apiError{"BadDigest", "The Content-SHA256 you specified did not match what was received",
+		http.StatusBadRequest}
+	errBucketAlreadyOwned = &apiError{"BucketAlreadyOwnedByYou",
+		"Your previous request to create the named bucket succeeded and you already own it", http.StatusConflict}
+	errEntityTooLarge = &apiError{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size",
+		http.StatusBadRequest}
+	errIncompleteBody = &apiError{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header",
+		http.StatusBadRequest}
+	errInternal          = &apiError{"InternalError", "We encountered an internal error, please try again", http.StatusInternalServerError}
+	errInvalidAccessKey  = &apiError{"InvalidAccessKeyId", "The access key ID you provided does not exist in our records", http.StatusForbidden}
+	errInvalidArgument   = &apiError{"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	errInvalidObjectName = &apiError{"InvalidObjectName", "The specified object name is not valid", http.StatusBadRequest}
+	errInvalidPart       = &apiError{"InvalidPart", "One or more of the specified parts could not be found", http.StatusBadRequest}
+	errInvalidPartOrder  = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest}
+	errInvalidRange      = &apiError{"InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable}
+	errInvalidRequest    = &apiError{"InvalidRequest", "Invalid request", http.StatusBadRequest}
+	errMalformedXML      = &apiError{"MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest}
+	errMethodNotAllowed  = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource",
+		http.StatusMethodNotAllowed}
+	errMissingAuth    = &apiError{"AccessDenied", "Missing or unsupported authentication", http.StatusForbidden}
+	errNoSuchBucket   = &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey      = &apiError{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errNoSuchUpload   = &apiError{"NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound}
+	errNotImplemented = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented",
+		http.StatusNotImplemented}
+	errPreconditionFailed = &apiError{"PreconditionFailed", "At least one of the preconditions you specified did not hold",
+		http.StatusPreconditionFailed}
+	errQuotaExceeded  = &apiError{"QuotaExceeded", "Quota exceeded", http.StatusForbidden}
+	errRequestExpired = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large",
+		http.StatusForbidden}
+	errSignatureMismatch = &apiError{"SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided", http.StatusForbidden}
+	errSlowDown           = &apiError{"SlowDown", "Please reduce your request rate", http.StatusServiceUnavailable}
+	errServiceUnavailable = &apiError{"ServiceUnavailable", "The server is unable to accept new connections", http.StatusServiceUnavailable}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketsResponse struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type locationResponse struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listObjectsV2Response struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listObjectsV1Response struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	Contents       []object       `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type copyObjectResponse struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type deleteObjectsRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteObjectsResponse struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type initiateMultipartUploadResponse struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadRequest struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResponse struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+type part struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type listPartsResponse struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Xmlns                string   `xml:"xmlns,attr"`
+	Bucket               string   `xml:"Bucket"`
+	Key                  string   `xml:"Key"`
+	UploadID             string   `xml:"UploadId"`
+	PartNumberMarker     int      `xml:"PartNumberMarker"`
+	NextPartNumberMarker int      `xml:"NextPartNumberMarker"`
+	MaxParts             int      `xml:"MaxParts"`
+	IsTruncated          bool     `xml:"IsTruncated"`
+	Parts                []part   `xml:"Part"`
+}
+
+type multipartUploadInfo struct {
+	Key       string `xml:"Key"`
+	UploadID  string `xml:"UploadId"`
+	Initiated string `xml:"Initiated"`
+}
+
+type listMultipartUploadsResponse struct {
+	XMLName     xml.Name              `xml:"ListMultipartUploadsResult"`
+	Xmlns       string                `xml:"xmlns,attr"`
+	Bucket      string                `xml:"Bucket"`
+	MaxUploads  int                   `xml:"MaxUploads"`
+	IsTruncated bool                  `xml:"IsTruncated"`
+	Uploads     []multipartUploadInfo `xml:"Upload"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func writeXMLResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		writeErrorResponse(w, r, errInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	w.Write(data)               //nolint:errcheck
+	writeLog(r, status, nil)
+}
+
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		logger.Debug(logSender, "", "unexpected error: %v", err)
+		apiErr = errInternal
+	}
+	resp := errorResponse{
+		Code:     apiErr.Code,
+		Message:  apiErr.Message,
+		Resource: r.URL.Path,
+	}
+	if reqID, ok := r.Context().Value(requestIDKey).(string); ok {
+		resp.RequestID = reqID
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(apiErr.StatusCode)
+	if r.Method != http.MethodHead {
+		data, _ := xml.Marshal(resp)
+		w.Write([]byte(xml.Header)) //nolint:errcheck
+		w.Write(data)               //nolint:errcheck
+	}
+	writeLog(r, apiErr.StatusCode, err)
+}
+
+// getAPIError maps the connection errors to the S3 ones
+func getAPIError(conn *Connection, err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	switch {
+	case conn.IsNotExistError(err):
+		return errNoSuchKey
+	case errors.Is(err, conn.GetPermissionDeniedError()), errors.Is(err, common.ErrPermissionDenied):
+		return errAccessDenied
+	case conn.IsQuotaExceededError(err), errors.Is(err, common.ErrQuotaExceeded),
+		errors.Is(err, conn.GetReadQuotaExceededError()):
+		return errQuotaExceeded
+	case errors.Is(err, conn.GetOpUnsupportedError()), errors.Is(err, common.ErrOpUnsupported):
+		return errNotImplemented
+	default:
+		return errInternal
+	}
+}
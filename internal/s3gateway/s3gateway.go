@@ -0,0 +1,366 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package s3gateway exposes the users virtual filesystems through a subset
+// of the S3 API. Each user has a single bucket named after the username and
+// requests must be signed using AWS Signature Version 4
+package s3gateway
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type ctxReqParams int
+
+const (
+	requestIDKey ctxReqParams = iota
+	requestStartKey
+)
+
+const (
+	logSender = "s3gateway"
+	// default multipart uploads expiration, in hours
+	defaultMultipartExpiration = 24
+)
+
+var (
+	certMgr         *common.CertManager
+	serviceStatus   ServiceStatus
+	runningBindings atomic.Pointer[common.BindingsRegistry[Binding]]
+)
+
+// ServiceStatus defines the service status
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Binding defines the configuration for a network listener
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving requests
+	Port int `json:"port" mapstructure:"port"`
+	// you also need to provide a certificate for enabling HTTPS
+	EnableHTTPS bool `json:"enable_https" mapstructure:"enable_https"`
+	// Certificate and matching private key for this specific binding, if empty the global
+	// ones will be used, if any
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Defines the minimum TLS version. 13 means TLS 1.3, default is TLS 1.2
+	MinTLSVersion int `json:"min_tls_version" mapstructure:"min_tls_version"`
+	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
+	// If CipherSuites is nil/empty, a default list of secure cipher suites
+	// is used, with a preference order based on hardware performance.
+	// Note that TLS 1.3 ciphersuites are not configurable.
+	// The supported ciphersuites names are defined here:
+	//
+	// https://github.com/golang/go/blob/master/src/crypto/tls/cipher_suites.go#L53
+	//
+	// any invalid name will be silently ignored.
+	// The order matters, the ciphers listed first will be the preferred ones.
+	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// List of IP addresses and IP ranges allowed to set client IP proxy headers
+	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// Allowed client IP proxy header such as "X-Forwarded-For", "X-Real-IP"
+	ClientIPProxyHeader string `json:"client_ip_proxy_header" mapstructure:"client_ip_proxy_header"`
+	// Some client IP headers such as "X-Forwarded-For" can contain multiple IP address, this setting
+	// define the position to trust starting from the right. For example if we have:
+	// "10.0.0.1,11.0.0.1,12.0.0.1,13.0.0.1" and the depth is 0, SFTPGo will use "13.0.0.1"
+	// as client IP, if depth is 1, "12.0.0.1" will be used and so on
+	ClientIPHeaderDepth int `json:"client_ip_header_depth" mapstructure:"client_ip_header_depth"`
+	allowHeadersFrom    []func(net.IP) bool
+}
+
+func (b *Binding) parseAllowedProxy() error {
+	if filepath.IsAbs(b.Address) && len(b.ProxyAllowed) > 0 {
+		// unix domain socket
+		b.allowHeadersFrom = []func(net.IP) bool{func(_ net.IP) bool { return true }}
+		return nil
+	}
+	allowedFuncs, err := util.ParseAllowedIPAndRanges(b.ProxyAllowed)
+	if err != nil {
+		return err
+	}
+	b.allowHeadersFrom = allowedFuncs
+	return nil
+}
+
+// GetAddress returns the binding address
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// IsValid returns true if the binding port is > 0
+func (b *Binding) IsValid() bool {
+	return b.Port > 0
+}
+
+// Configuration defines the configuration for the S3 compatible API
+type Configuration struct {
+	// Addresses and ports to bind to
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// If files containing a certificate and matching private key for the server are provided you
+	// can enable HTTPS connections for the configured bindings
+	// Certificate and key files can be reloaded on demand sending a "SIGHUP" signal on Unix based systems and a
+	// "paramchange" request to the running service on Windows.
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// Region to report to the clients, any region is accepted in signed requests
+	Region string `json:"region" mapstructure:"region"`
+	// Multipart uploads not completed or aborted within this number of hours
+	// are removed. 0 means the default (24 hours)
+	MultipartExpiration int `json:"multipart_expiration" mapstructure:"multipart_expiration"`
+	// Authenticated users are cached so the login side effects, for example
+	// the post-login hook and the last login update, only happen once
+	UsersCache UsersCacheConfig `json:"users_cache" mapstructure:"users_cache"`
+}
+
+// UsersCacheConfig defines the cache configuration for authenticated users
+type UsersCacheConfig struct {
+	// Cache expiration time in minutes, 0 means no expiration. Cached users
+	// are always removed when they are updated
+	ExpirationTime int `json:"expiration_time" mapstructure:"expiration_time"`
+	// Maximum number of cached users, 0 means unlimited
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+}
+
+func (c *UsersCacheConfig) getExpirationTime() time.Time {
+	if c.ExpirationTime > 0 {
+		return time.Now().Add(time.Duration(c.ExpirationTime) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// GetStatus returns the server status
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// ShouldBind returns true if there is at least a valid binding
+func (c *Configuration) ShouldBind() bool {
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Configuration) getRegion() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+func (c *Configuration) getMultipartExpiration() time.Duration {
+	if c.MultipartExpiration <= 0 {
+		return defaultMultipartExpiration * time.Hour
+	}
+	return time.Duration(c.MultipartExpiration) * time.Hour
+}
+
+func (c *Configuration) getKeyPairs(configDir string) []common.TLSKeyPair {
+	var keyPairs []common.TLSKeyPair
+
+	for _, binding := range c.Bindings {
+		certificateFile := getConfigPath(binding.CertificateFile, configDir)
+		certificateKeyFile := getConfigPath(binding.CertificateKeyFile, configDir)
+		if certificateFile != "" && certificateKeyFile != "" {
+			keyPairs = append(keyPairs, common.TLSKeyPair{
+				Cert: certificateFile,
+				Key:  certificateKeyFile,
+				ID:   binding.GetAddress(),
+			})
+		}
+	}
+	certificateFile := getConfigPath(c.CertificateFile, configDir)
+	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if certificateFile != "" && certificateKeyFile != "" {
+		keyPairs = append(keyPairs, common.TLSKeyPair{
+			Cert: certificateFile,
+			Key:  certificateKeyFile,
+			ID:   common.DefaultTLSKeyPaidID,
+		})
+	}
+	return keyPairs
+}
+
+// Initialize configures and starts the S3 compatible API server
+func (c *Configuration) Initialize(configDir string) error {
+	logger.Info(logSender, "", "initializing S3 compatible API server with config %+v", *c)
+	if !c.ShouldBind() {
+		return common.ErrNoBinding
+	}
+
+	keyPairs := c.getKeyPairs(configDir)
+	if len(keyPairs) > 0 {
+		mgr, err := common.NewCertManager(keyPairs, configDir, logSender)
+		if err != nil {
+			return err
+		}
+		certMgr = mgr
+	}
+	if err := multipartUploads.init(c.getMultipartExpiration()); err != nil {
+		return err
+	}
+	dataprovider.InitializeS3UserCache(c.UsersCache.MaxSize)
+
+	serviceStatus = ServiceStatus{
+		Bindings: nil,
+	}
+
+	exitChannel := make(chan error, 1)
+	registry := common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		server := s3Server{
+			config:  c,
+			binding: b,
+		}
+		return server.listenAndServe(l)
+	})
+	runningBindings.Store(registry)
+
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.parseAllowedProxy(); err != nil {
+			return err
+		}
+		serviceStatus.Bindings = append(serviceStatus.Bindings, getBindingsStatus([]Binding{binding})...)
+
+		go func(binding Binding) {
+			err := registry.Serve(binding)
+			if errors.Is(err, common.ErrBindingRemoved) {
+				return
+			}
+			exitChannel <- err
+		}(binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return <-exitChannel
+}
+
+// ReloadBindings applies the configured bindings to the running S3 server.
+// New bindings are started and removed bindings stop accepting new connections,
+// the active requests are not affected. Changing the settings of an existing
+// binding requires a restart
+func (c *Configuration) ReloadBindings() error {
+	registry := runningBindings.Load()
+	if registry == nil {
+		return errors.New("the S3 server is not running")
+	}
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.parseAllowedProxy(); err != nil {
+			return err
+		}
+		bindings = append(bindings, binding)
+	}
+	serviceStatus.Bindings = getBindingsStatus(registry.Reload(bindings, checkBindingCertificate))
+	return nil
+}
+
+// checkBindingCertificate returns an error if the binding requires TLS and
+// no certificate was loaded for it
+func checkBindingCertificate(b Binding) error {
+	if !b.EnableHTTPS {
+		return nil
+	}
+	certID := common.DefaultTLSKeyPaidID
+	if getConfigPath(b.CertificateFile, "") != "" && getConfigPath(b.CertificateKeyFile, "") != "" {
+		certID = b.GetAddress()
+	}
+	if certMgr == nil || !certMgr.HasCertificate(certID) {
+		return fmt.Errorf("no TLS certificate loaded for binding %q", b.GetAddress())
+	}
+	return nil
+}
+
+// getBindingsStatus returns the bindings to report in the service status,
+// HTTPS is disabled if no certificate is configured
+func getBindingsStatus(bindings []Binding) []Binding {
+	result := make([]Binding, 0, len(bindings))
+	for _, b := range bindings {
+		if certMgr == nil {
+			b.EnableHTTPS = false
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// ReloadCertificateMgr reloads the certificate manager
+func ReloadCertificateMgr() error {
+	if certMgr != nil {
+		return certMgr.Reload()
+	}
+	return nil
+}
+
+func getConfigPath(name, configDir string) string {
+	if !util.IsFileInputValid(name) {
+		return ""
+	}
+	if name != "" && !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name)
+	}
+	return name
+}
+
+func getTempPath() string {
+	if common.Config.TempPath != "" {
+		return common.Config.TempPath
+	}
+	return os.TempDir()
+}
+
+// validateUser checks the user state after a successful authentication
+func validateUser(user *dataprovider.User, remoteAddr, connectionID string) error {
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %q has an invalid home dir: %q. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return fmt.Errorf("cannot login user with invalid home dir: %q", user.HomeDir)
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolS3) {
+		logger.Info(logSender, connectionID, "cannot login user %q, protocol S3 is not allowed", user.Username)
+		return fmt.Errorf("protocol S3 is not allowed for user %q", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(remoteAddr) {
+		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
+			user.Username, remoteAddr)
+		return fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
+	}
+	return nil
+}
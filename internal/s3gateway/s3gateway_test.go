@@ -0,0 +1,547 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/s3gateway"
+)
+
+const (
+	logSender       = "s3gatewayTesting"
+	s3ServerAddr    = "127.0.0.1:9098"
+	defaultUsername = "test_user_s3"
+	defaultSecret   = "test_S3_secret_access_key"
+)
+
+var (
+	configDir    = filepath.Join(".", "..", "..")
+	allPerms     = []string{dataprovider.PermAny}
+	homeBasePath string
+)
+
+func TestMain(m *testing.M) {
+	logFilePath := filepath.Join(configDir, "sftpgo_s3gateway_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, false, zerolog.DebugLevel)
+	err := config.LoadConfig(configDir, "")
+	if err != nil {
+		logger.ErrorToConsole("error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	providerConf := config.GetProviderConf()
+	logger.InfoToConsole("Starting S3 gateway tests, provider: %v", providerConf.Driver)
+	homeBasePath = os.TempDir()
+
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+	err = common.Initialize(config.GetCommonConfig(), 0)
+	if err != nil {
+		logger.WarnToConsole("error initializing common: %v", err)
+		os.Exit(1)
+	}
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		logger.ErrorToConsole("error initializing kms: %v", err)
+		os.Exit(1)
+	}
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir) //nolint:errcheck
+
+	s3Conf := config.GetS3GatewayConfig()
+	s3Conf.Bindings = []s3gateway.Binding{
+		{
+			Address: "127.0.0.1",
+			Port:    9098,
+		},
+	}
+	go func() {
+		logger.Debug(logSender, "", "initializing S3 gateway with config %+v", s3Conf)
+		if err := s3Conf.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start S3 gateway: %v", err)
+			os.Exit(1)
+		}
+	}()
+	waitTCPListening(s3ServerAddr)
+
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestBasicObjectOperations(t *testing.T) {
+	user := addTestUser(t, getTestUser())
+	defer removeTestUser(t, user)
+
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+	content := []byte("test content for the S3 compatible API")
+
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("dir1/sub dir/file.txt"),
+		Body:   bytes.NewReader(content),
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "dir1", "sub dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	// the existing object is not changed if the payload verification fails
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:     aws.String(user.Username),
+		Key:        aws.String("dir1/sub dir/file.txt"),
+		Body:       bytes.NewReader([]byte("modified content")),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(md5.New().Sum(nil))),
+	})
+	assertAPIError(t, err, "BadDigest")
+	data, err = os.ReadFile(filepath.Join(user.GetHomeDir(), "dir1", "sub dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("dir1/sub dir/file.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), aws.ToInt64(head.ContentLength))
+	assert.Equal(t, "text/plain; charset=utf-8", aws.ToString(head.ContentType))
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("dir1/sub dir/file.txt"),
+		Range:  aws.String("bytes=5-11"),
+	})
+	require.NoError(t, err)
+	data, err = io.ReadAll(out.Body)
+	out.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content[5:12], data)
+
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(user.Username),
+		Key:        aws.String("copy.txt"),
+		CopySource: aws.String(fmt.Sprintf("%s/dir1/sub%%20dir/file.txt", user.Username)),
+	})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "copy.txt"))
+
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(user.Username),
+		Delimiter: aws.String("/"),
+	})
+	require.NoError(t, err)
+	if assert.Len(t, list.Contents, 1) {
+		assert.Equal(t, "copy.txt", aws.ToString(list.Contents[0].Key))
+	}
+	if assert.Len(t, list.CommonPrefixes, 1) {
+		assert.Equal(t, "dir1/", aws.ToString(list.CommonPrefixes[0].Prefix))
+	}
+	list, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(user.Username),
+		MaxKeys: aws.Int32(1),
+	})
+	require.NoError(t, err)
+	assert.True(t, aws.ToBool(list.IsTruncated))
+	if assert.Len(t, list.Contents, 1) {
+		assert.Equal(t, "copy.txt", aws.ToString(list.Contents[0].Key))
+	}
+	list, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:            aws.String(user.Username),
+		ContinuationToken: list.NextContinuationToken,
+	})
+	require.NoError(t, err)
+	assert.False(t, aws.ToBool(list.IsTruncated))
+	if assert.Len(t, list.Contents, 1) {
+		assert.Equal(t, "dir1/sub dir/file.txt", aws.ToString(list.Contents[0].Key))
+	}
+
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("copy.txt"),
+	})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "copy.txt"))
+	_, err = client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(user.Username),
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{
+				{Key: aws.String("dir1/sub dir/file.txt")},
+				{Key: aws.String("missing")},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "dir1", "sub dir", "file.txt"))
+
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("copy.txt"),
+	})
+	assertAPIError(t, err, "NoSuchKey")
+	_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String("other_bucket"),
+	})
+	assertAPIError(t, err, "NoSuchBucket")
+}
+
+func TestMultipartUpload(t *testing.T) {
+	user := addTestUser(t, getTestUser())
+	defer removeTestUser(t, user)
+
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+	key := aws.String("multipart/file.dat")
+	create, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(user.Username),
+		Key:    key,
+	})
+	require.NoError(t, err)
+
+	var completed []types.CompletedPart
+	var expected []byte
+	for i := int32(1); i <= 3; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, 1024*int(i))
+		expected = append(expected, content...)
+		part, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(user.Username),
+			Key:        key,
+			UploadId:   create.UploadId,
+			PartNumber: aws.Int32(i),
+			Body:       bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		completed = append(completed, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: aws.Int32(i),
+		})
+	}
+	parts, err := client.ListParts(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(user.Username),
+		Key:      key,
+		UploadId: create.UploadId,
+	})
+	require.NoError(t, err)
+	assert.Len(t, parts.Parts, 3)
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(user.Username),
+		Key:      key,
+		UploadId: create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: []types.CompletedPart{completed[1], completed[0]},
+		},
+	})
+	assertAPIError(t, err, "InvalidPartOrder")
+
+	complete, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(user.Username),
+		Key:      key,
+		UploadId: create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, aws.ToString(complete.ETag), "-3")
+	data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "multipart", "file.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(user.Username),
+		Key:        key,
+		UploadId:   create.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       bytes.NewReader([]byte("data")),
+	})
+	assertAPIError(t, err, "NoSuchUpload")
+
+	create, err = client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(user.Username),
+		Key:    key,
+	})
+	require.NoError(t, err)
+	uploads, err := client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(user.Username),
+	})
+	require.NoError(t, err)
+	assert.Len(t, uploads.Uploads, 1)
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(user.Username),
+		Key:      key,
+		UploadId: create.UploadId,
+	})
+	assert.NoError(t, err)
+	uploads, err = client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(user.Username),
+	})
+	require.NoError(t, err)
+	assert.Len(t, uploads.Uploads, 0)
+}
+
+func TestPresignedURL(t *testing.T) {
+	user := addTestUser(t, getTestUser())
+	defer removeTestUser(t, user)
+
+	content := []byte("presigned content")
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), content, os.ModePerm)
+	require.NoError(t, err)
+
+	presignClient := s3.NewPresignClient(getS3Client(user.Username, defaultSecret))
+	req, err := presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("file.txt"),
+	})
+	require.NoError(t, err)
+	resp, err := http.Get(req.URL)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, content, data)
+
+	resp, err = http.Get(req.URL + "0")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestAuthenticationErrors(t *testing.T) {
+	user := addTestUser(t, getTestUser())
+	defer removeTestUser(t, user)
+
+	ctx := context.Background()
+	_, err := getS3Client(user.Username, "wrong secret").ListBuckets(ctx, &s3.ListBucketsInput{})
+	assertAPIError(t, err, "SignatureDoesNotMatch")
+	_, err = getS3Client(user.Username+"_1", defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assertAPIError(t, err, "InvalidAccessKeyId")
+
+	buckets, err := getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	require.NoError(t, err)
+	if assert.Len(t, buckets.Buckets, 1) {
+		assert.Equal(t, user.Username, aws.ToString(buckets.Buckets[0].Name))
+	}
+
+	user.Filters.DeniedProtocols = []string{common.ProtocolS3}
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	require.NoError(t, err)
+	_, err = getS3Client(user.Username, defaultSecret).ListBuckets(ctx, &s3.ListBucketsInput{})
+	assertAPIError(t, err, "AccessDenied")
+
+	u := getTestUser()
+	u.Username += "_nocreds"
+	u.Filters.S3Secret = nil
+	noCredsUser := addTestUser(t, u)
+	defer removeTestUser(t, noCredsUser)
+	_, err = getS3Client(noCredsUser.Username, "").ListBuckets(ctx, &s3.ListBucketsInput{})
+	assert.Error(t, err)
+}
+
+func TestLoginSideEffectsCached(t *testing.T) {
+	var postConnectCalls, postLoginCalls atomic.Int32
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if r.URL.Query().Get("protocol") == common.ProtocolS3 && r.URL.Query().Get("status") == "1" {
+				postLoginCalls.Add(1)
+			}
+		} else {
+			postConnectCalls.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer hookServer.Close()
+
+	err := dataprovider.Close()
+	require.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.PostLoginHook = hookServer.URL
+	providerConf.PostLoginScope = 2
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	require.NoError(t, err)
+	common.Config.PostConnectHook = hookServer.URL
+	defer func() {
+		common.Config.PostConnectHook = ""
+		err := dataprovider.Close()
+		assert.NoError(t, err)
+		err = dataprovider.Initialize(config.GetProviderConf(), configDir, true)
+		assert.NoError(t, err)
+	}()
+
+	user := addTestUser(t, getTestUser())
+	defer removeTestUser(t, user)
+
+	client := getS3Client(user.Username, defaultSecret)
+	ctx := context.Background()
+	_, err = client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(user.Username, "")
+	require.NoError(t, err)
+	lastLogin := user.LastLogin
+	assert.Greater(t, lastLogin, int64(0))
+	for i := 0; i < 5; i++ {
+		_, err = client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(user.Username),
+			Key:    aws.String(fmt.Sprintf("file%d.txt", i)),
+			Body:   bytes.NewReader([]byte("content")),
+		})
+		require.NoError(t, err)
+		_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(user.Username),
+		})
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return postLoginCalls.Load() == 1
+	}, 2*time.Second, 50*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(1), postConnectCalls.Load())
+	assert.Equal(t, int32(1), postLoginCalls.Load())
+	user, err = dataprovider.UserExists(user.Username, "")
+	require.NoError(t, err)
+	assert.Equal(t, lastLogin, user.LastLogin)
+	// a wrong signature is still rejected for a cached user
+	_, err = getS3Client(user.Username, "wrong secret").ListBuckets(ctx, &s3.ListBucketsInput{})
+	assertAPIError(t, err, "SignatureDoesNotMatch")
+	// updating the user invalidates the cached one
+	user.Filters.S3Secret = kms.NewPlainSecret(defaultSecret + "_new")
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	require.NoError(t, err)
+	_, err = client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	assertAPIError(t, err, "SignatureDoesNotMatch")
+	_, err = getS3Client(user.Username, defaultSecret+"_new").ListBuckets(ctx, &s3.ListBucketsInput{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return postLoginCalls.Load() == 2
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(3), postConnectCalls.Load())
+}
+
+func TestPermissions(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user := addTestUser(t, u)
+	defer removeTestUser(t, user)
+
+	client := getS3Client(user.Username, defaultSecret)
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(user.Username),
+		Key:    aws.String("file.txt"),
+		Body:   bytes.NewReader([]byte("content")),
+	})
+	assertAPIError(t, err, "AccessDenied")
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+}
+
+func assertAPIError(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var apiErr smithy.APIError
+	if assert.True(t, errors.As(err, &apiErr), "unexpected error: %v", err) {
+		assert.Equal(t, code, apiErr.ErrorCode())
+	}
+}
+
+func getS3Client(accessKeyID, secret string) *s3.Client {
+	return s3.New(s3.Options{
+		BaseEndpoint: aws.String("http://" + s3ServerAddr),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(accessKeyID, secret, ""),
+	})
+}
+
+func addTestUser(t *testing.T, user dataprovider.User) dataprovider.User {
+	t.Helper()
+
+	err := dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(user.Username, "")
+	require.NoError(t, err)
+	return user
+}
+
+func removeTestUser(t *testing.T, user dataprovider.User) {
+	t.Helper()
+
+	err := dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: defaultUsername,
+			HomeDir:  filepath.Join(homeBasePath, defaultUsername),
+			Status:   1,
+		},
+	}
+	user.Password = "password"
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = allPerms
+	user.Filters.S3Secret = kms.NewPlainSecret(defaultSecret)
+	return user
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening", address)
+		conn.Close()
+		break
+	}
+}
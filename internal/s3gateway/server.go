@@ -0,0 +1,313 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk/plugin/notifier"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+type s3Server struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *s3Server) listenAndServe(l *common.BindingListener) error {
+	httpServer := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 16, // 64KB
+		ErrorLog:          log.New(&logger.StdLoggerWrapper{Sender: logSender}, "", 0),
+	}
+	if err := l.SetStopFunc(func() error {
+		return httpServer.Shutdown(context.Background())
+	}); err != nil {
+		return err
+	}
+	if certMgr != nil && s.binding.EnableHTTPS {
+		certID := common.DefaultTLSKeyPaidID
+		if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
+			certID = s.binding.GetAddress()
+		}
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate: certMgr.GetCertificateFunc(certID),
+			MinVersion:     util.GetTLSVersion(s.binding.MinTLSVersion),
+			NextProtos:     []string{"http/1.1", "h2"},
+			CipherSuites:   util.GetTLSCiphersFromNames(s.binding.TLSCipherSuites),
+		}
+		logger.Debug(logSender, "", "configured TLS cipher suites for binding %q: %v, certID: %v",
+			s.binding.GetAddress(), httpServer.TLSConfig.CipherSuites, certID)
+		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+	}
+	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+}
+
+// ServeHTTP implements the http.Handler interface
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in ServeHTTP: %q stack trace: %v", r, string(debug.Stack()))
+			http.Error(w, common.ErrGenericFailure.Error(), http.StatusInternalServerError)
+		}
+	}()
+
+	w.Header().Set("Server", version.GetServerVersion("/", false))
+	ipAddr := s.checkRemoteAddress(r)
+
+	common.Connections.AddClientConnection(ipAddr)
+	defer common.Connections.RemoveClientConnection(ipAddr)
+
+	if err := common.Connections.IsNewConnectionAllowed(ipAddr, common.ProtocolS3); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolS3, "", "connection not allowed from ip %q: %v", ipAddr, err)
+		writeErrorResponse(w, r, errServiceUnavailable)
+		return
+	}
+	if common.IsBanned(ipAddr, common.ProtocolS3) {
+		writeErrorResponse(w, r, errAccessDenied)
+		return
+	}
+	delay, err := common.LimitRate(common.ProtocolS3, ipAddr)
+	if err != nil {
+		delay += 499999999 * time.Nanosecond
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+		w.Header().Set("X-Retry-In", delay.String())
+		writeErrorResponse(w, r, errSlowDown)
+		return
+	}
+	user, info, secret, isCached, err := s.authenticate(r, ipAddr)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolS3, xid.New().String())
+	if err := validateUser(&user, r.RemoteAddr, connectionID); err != nil {
+		dataprovider.RemoveCachedS3User(user.Username)
+		updateLoginMetrics(&user, ipAddr, err)
+		writeErrorResponse(w, r, errAccessDenied)
+		return
+	}
+	if !isCached {
+		err = user.CheckFsRoot(connectionID)
+	} else {
+		_, err = user.GetFilesystemForPath("/", connectionID)
+	}
+	if err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, common.ErrInternalFailure)
+		writeErrorResponse(w, r, errInternal)
+		return
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolS3, util.GetHTTPLocalAddress(r),
+			r.RemoteAddr, user),
+		request: r,
+	}
+	if err = common.Connections.Add(connection); err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable add connection: %v close fs error: %v", err, errClose)
+		updateLoginMetrics(&user, ipAddr, err)
+		writeErrorResponse(w, r, errSlowDown)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	if !isCached {
+		// cache the user before the post-login hook hides its secrets
+		dataprovider.CacheS3User(&dataprovider.CachedUser{
+			User:       user,
+			Expiration: s.config.UsersCache.getExpirationTime(),
+		})
+		updateLoginMetrics(&user, ipAddr, nil)
+		dataprovider.UpdateLastLogin(&user)
+	}
+
+	ctx := context.WithValue(r.Context(), requestIDKey, connectionID)
+	ctx = context.WithValue(ctx, requestStartKey, time.Now())
+	w.Header().Set("x-amz-request-id", connectionID)
+
+	handler := requestHandler{
+		config: s.config,
+		conn:   connection,
+		info:   info,
+		secret: secret,
+		w:      w,
+		r:      r.WithContext(ctx),
+		bucket: user.Username,
+	}
+	handler.serve()
+}
+
+// authenticate verifies the request signature, the access key ID is the
+// username and the secret is the one generated using the REST API.
+// Users already authenticated are loaded from the cache, in this case the
+// post-connect hook is not executed again
+func (s *s3Server) authenticate(r *http.Request, ip string) (dataprovider.User, *signingInfo, string, bool, error) {
+	var user dataprovider.User
+
+	info, err := parseSigningInfo(r)
+	if err != nil {
+		return user, nil, "", false, err
+	}
+	if cachedUser, ok := dataprovider.GetCachedS3User(info.accessKeyID); ok {
+		if cachedUser.IsExpired() {
+			dataprovider.RemoveCachedS3User(info.accessKeyID)
+		} else {
+			user = cachedUser.User
+			secret := user.Filters.S3Secret.GetPayload()
+			if err := info.verify(r, secret); err != nil {
+				updateLoginMetrics(&user, ip, dataprovider.ErrInvalidCredentials)
+				return user, nil, "", false, err
+			}
+			// the expiration date can be reached without updating the user
+			if err := user.CheckLoginConditions(); err != nil {
+				dataprovider.RemoveCachedS3User(info.accessKeyID)
+				updateLoginMetrics(&user, ip, err)
+				return user, nil, "", false, errAccessDenied
+			}
+			return user, info, secret, true, nil
+		}
+	}
+	if err := common.Config.ExecutePostConnectHook(ip, common.ProtocolS3); err != nil {
+		return user, nil, "", false, errAccessDenied
+	}
+	user, err = dataprovider.GetUserWithGroupSettings(info.accessKeyID, "")
+	if err != nil {
+		user.Username = info.accessKeyID
+		updateLoginMetrics(&user, ip, err)
+		if errors.Is(err, util.ErrNotFound) {
+			return user, nil, "", false, errInvalidAccessKey
+		}
+		return user, nil, "", false, errInternal
+	}
+	if !user.HasS3Credentials() {
+		updateLoginMetrics(&user, ip, dataprovider.ErrInvalidCredentials)
+		return user, nil, "", false, errInvalidAccessKey
+	}
+	if err := user.Filters.S3Secret.TryDecrypt(); err != nil {
+		logger.Error(logSender, "", "unable to decrypt S3 secret for user %q: %v", user.Username, err)
+		updateLoginMetrics(&user, ip, common.ErrInternalFailure)
+		return user, nil, "", false, errInternal
+	}
+	secret := user.Filters.S3Secret.GetPayload()
+	if err := info.verify(r, secret); err != nil {
+		updateLoginMetrics(&user, ip, dataprovider.ErrInvalidCredentials)
+		return user, nil, "", false, err
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		updateLoginMetrics(&user, ip, err)
+		return user, nil, "", false, errAccessDenied
+	}
+	return user, info, secret, false, nil
+}
+
+func (s *s3Server) checkRemoteAddress(r *http.Request) string {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	var ip net.IP
+	isUnixSocket := filepath.IsAbs(s.binding.Address)
+	if !isUnixSocket {
+		ip = net.ParseIP(ipAddr)
+	}
+	if isUnixSocket || ip != nil {
+		for _, allow := range s.binding.allowHeadersFrom {
+			if allow(ip) {
+				parsedIP := util.GetRealIP(r, s.binding.ClientIPProxyHeader, s.binding.ClientIPHeaderDepth)
+				if parsedIP != "" {
+					ipAddr = parsedIP
+					r.RemoteAddr = ipAddr
+				}
+				break
+			}
+		}
+	}
+	return ipAddr
+}
+
+func writeLog(r *http.Request, status int, err error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	fields := map[string]any{
+		"remote_addr": r.RemoteAddr,
+		"proto":       r.Proto,
+		"method":      r.Method,
+		"user_agent":  r.UserAgent(),
+		"uri":         fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)}
+	if reqID, ok := r.Context().Value(requestIDKey).(string); ok {
+		fields["request_id"] = reqID
+	}
+	if reqStart, ok := r.Context().Value(requestStartKey).(time.Time); ok {
+		fields["elapsed_ms"] = time.Since(reqStart).Nanoseconds() / 1000000
+	}
+	if contentLength := r.Header.Get("Content-Length"); contentLength != "" {
+		fields["content_length"] = contentLength
+	}
+	if status != 0 {
+		fields["resp_status"] = status
+	}
+	logger.GetLogger().Info().
+		Timestamp().
+		Str("sender", logSender).
+		Fields(fields).
+		Err(err).
+		Send()
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
+	loginMethod := dataprovider.LoginMethodS3Credentials
+	metric.AddLoginAttempt(loginMethod)
+	if err == nil {
+		plugin.Handler.NotifyLogEvent(notifier.LogEventTypeLoginOK, common.ProtocolS3, user.Username, ip, "", nil)
+		common.DelayLogin(nil)
+	} else if err != common.ErrInternalFailure {
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolS3, err.Error())
+		event := common.HostEventLoginFailed
+		logEv := notifier.LogEventTypeLoginFailed
+		if errors.Is(err, util.ErrNotFound) {
+			event = common.HostEventUserNotFound
+			logEv = notifier.LogEventTypeLoginNoUser
+		}
+		common.AddDefenderEvent(ip, common.ProtocolS3, event)
+		plugin.Handler.NotifyLogEvent(logEv, common.ProtocolS3, user.Username, ip, "", err)
+		common.DelayLogin(err)
+	}
+	metric.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolS3, err)
+}
@@ -0,0 +1,565 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package s3gateway
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	signV4Algorithm          = "AWS4-HMAC-SHA256"
+	iso8601Format            = "20060102T150405Z"
+	yyyymmdd                 = "20060102"
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew             = 15 * time.Minute
+	// maximum validity for presigned URLs, 7 days
+	maxPresignedExpiration = 7 * 24 * time.Hour
+	maxChunkLineLength     = 4096
+)
+
+// signingInfo holds the parsed AWS Signature Version 4 authentication data
+type signingInfo struct {
+	accessKeyID   string
+	amzDate       string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+	requestTime   time.Time
+	expires       time.Duration
+}
+
+func (s *signingInfo) getScope() string {
+	return strings.Join([]string{s.date, s.region, s.service, "aws4_request"}, "/")
+}
+
+func parseCredential(val string, info *signingInfo) error {
+	parts := strings.Split(strings.TrimSpace(val), "/")
+	if len(parts) < 5 {
+		return errInvalidRequest
+	}
+	n := len(parts)
+	if parts[n-1] != "aws4_request" {
+		return errInvalidRequest
+	}
+	info.accessKeyID = strings.Join(parts[:n-4], "/")
+	info.date = parts[n-4]
+	info.region = parts[n-3]
+	info.service = parts[n-2]
+	if info.accessKeyID == "" || info.service != "s3" {
+		return errInvalidRequest
+	}
+	return nil
+}
+
+func parseSignedHeaders(val string) []string {
+	var headers []string
+	for _, h := range strings.Split(val, ";") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+// parseAuthorizationHeader parses an header like:
+// AWS4-HMAC-SHA256 Credential=AKID/20130524/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=xxx
+func parseAuthorizationHeader(r *http.Request) (*signingInfo, error) {
+	auth := r.Header.Get("Authorization")
+	params, ok := strings.CutPrefix(auth, signV4Algorithm+" ")
+	if !ok {
+		return nil, errMissingAuth
+	}
+	info := &signingInfo{}
+	for _, param := range strings.Split(params, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return nil, errInvalidRequest
+		}
+		switch key {
+		case "Credential":
+			if err := parseCredential(val, info); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			info.signedHeaders = parseSignedHeaders(val)
+		case "Signature":
+			info.signature = strings.TrimSpace(val)
+		}
+	}
+	if info.accessKeyID == "" || info.signature == "" || len(info.signedHeaders) == 0 {
+		return nil, errInvalidRequest
+	}
+	info.amzDate = r.Header.Get("X-Amz-Date")
+	if info.amzDate == "" {
+		t, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return nil, errInvalidRequest
+		}
+		info.amzDate = t.UTC().Format(iso8601Format)
+	}
+	info.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if info.payloadHash == "" {
+		return nil, errInvalidRequest
+	}
+	return info, nil
+}
+
+func parsePresignedURL(r *http.Request) (*signingInfo, error) {
+	q := r.URL.Query()
+	if q.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, errMissingAuth
+	}
+	info := &signingInfo{
+		presigned: true,
+		amzDate:   q.Get("X-Amz-Date"),
+		signature: q.Get("X-Amz-Signature"),
+	}
+	if err := parseCredential(q.Get("X-Amz-Credential"), info); err != nil {
+		return nil, err
+	}
+	info.signedHeaders = parseSignedHeaders(q.Get("X-Amz-SignedHeaders"))
+	expires, err := strconv.ParseInt(q.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires < 0 {
+		return nil, errInvalidRequest
+	}
+	info.expires = time.Duration(expires) * time.Second
+	if info.expires > maxPresignedExpiration {
+		return nil, errInvalidRequest
+	}
+	info.payloadHash = q.Get("X-Amz-Content-Sha256")
+	if info.payloadHash == "" {
+		info.payloadHash = unsignedPayload
+	}
+	if info.signature == "" || len(info.signedHeaders) == 0 {
+		return nil, errInvalidRequest
+	}
+	return info, nil
+}
+
+// parseSigningInfo returns the authentication data for the request, both
+// signed headers and presigned URLs are supported
+func parseSigningInfo(r *http.Request) (*signingInfo, error) {
+	var info *signingInfo
+	var err error
+
+	if r.Header.Get("Authorization") != "" {
+		info, err = parseAuthorizationHeader(r)
+	} else {
+		info, err = parsePresignedURL(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.requestTime, err = time.Parse(iso8601Format, info.amzDate)
+	if err != nil {
+		return nil, errInvalidRequest
+	}
+	if info.requestTime.Format(yyyymmdd) != info.date {
+		return nil, errSignatureMismatch
+	}
+	now := time.Now()
+	if info.presigned {
+		if now.After(info.requestTime.Add(info.expires)) || info.requestTime.After(now.Add(maxClockSkew)) {
+			return nil, errAccessDenied
+		}
+	} else if info.requestTime.Before(now.Add(-maxClockSkew)) || info.requestTime.After(now.Add(maxClockSkew)) {
+		return nil, errRequestExpired
+	}
+	return info, nil
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+// uriEncode encodes the given string as required by AWS Signature Version 4
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return sb.String()
+}
+
+func getCanonicalQuery(query url.Values, presigned bool) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func getCanonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if val := r.Header.Get("Content-Length"); val != "" {
+			return val
+		}
+		if r.ContentLength >= 0 {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+		return ""
+	case "transfer-encoding":
+		if val := r.Header.Get("Transfer-Encoding"); val != "" {
+			return val
+		}
+		return strings.Join(r.TransferEncoding, ",")
+	}
+	values := r.Header.Values(name)
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, strings.Join(strings.Fields(v), " "))
+	}
+	return strings.Join(result, ",")
+}
+
+func (s *signingInfo) getCanonicalRequest(r *http.Request) string {
+	var headers strings.Builder
+	for _, h := range s.signedHeaders {
+		headers.WriteString(h + ":" + getCanonicalHeaderValue(r, h) + "\n")
+	}
+	uri := r.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(uri, false),
+		getCanonicalQuery(r.URL.Query(), s.presigned),
+		headers.String(),
+		strings.Join(s.signedHeaders, ";"),
+		s.payloadHash,
+	}, "\n")
+}
+
+func (s *signingInfo) getSigningKey(secret string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), s.date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// verify checks the request signature using the specified secret access key
+func (s *signingInfo) verify(r *http.Request, secret string) error {
+	if !util.Contains(s.signedHeaders, "host") {
+		return errSignatureMismatch
+	}
+	stringToSign := strings.Join([]string{
+		signV4Algorithm,
+		s.amzDate,
+		s.getScope(),
+		sha256Hex([]byte(s.getCanonicalRequest(r))),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(s.getSigningKey(secret), stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(s.signature)) {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+// getBodyReader returns a reader for the request body that validates the
+// payload based on the signed content hash
+func (s *signingInfo) getBodyReader(r *http.Request, secret string) (io.Reader, int64, error) {
+	switch s.payloadHash {
+	case unsignedPayload:
+		return r.Body, r.ContentLength, nil
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || size < 0 {
+			return nil, 0, errInvalidArgument
+		}
+		reader := &chunkedReader{
+			r:          bufio.NewReader(r.Body),
+			signed:     s.payloadHash != streamingUnsignedTrailer,
+			trailer:    s.payloadHash != streamingPayload,
+			signingKey: s.getSigningKey(secret),
+			amzDate:    s.amzDate,
+			scope:      s.getScope(),
+			prevSig:    s.signature,
+			hasher:     sha256.New(),
+			expected:   size,
+		}
+		return reader, size, nil
+	default:
+		expected, err := hex.DecodeString(s.payloadHash)
+		if err != nil || len(expected) != sha256.Size {
+			return nil, 0, errNotImplemented
+		}
+		return &hashingReader{
+			r:        r.Body,
+			hasher:   sha256.New(),
+			expected: expected,
+		}, r.ContentLength, nil
+	}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// hashingReader verifies the hash of the payload once the whole body is read
+type hashingReader struct {
+	r        io.Reader
+	hasher   hash.Hash
+	expected []byte
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hasher.Write(p[:n])
+	if err == io.EOF {
+		if !hmac.Equal(h.hasher.Sum(nil), h.expected) {
+			return n, errBadDigest
+		}
+	}
+	return n, err
+}
+
+// chunkedReader decodes aws-chunked payloads, chunk signatures are verified
+// if the payload is signed
+type chunkedReader struct {
+	r          *bufio.Reader
+	signed     bool
+	trailer    bool
+	signingKey []byte
+	amzDate    string
+	scope      string
+	prevSig    string
+	chunkSig   string
+	hasher     hash.Hash
+	remaining  int64
+	expected   int64
+	read       int64
+	done       bool
+	err        error
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		data, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, data...)
+		if len(line) > maxChunkLineLength {
+			return "", errInvalidRequest
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if !c.signed {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		c.amzDate,
+		c.scope,
+		c.prevSig,
+		emptySHA256,
+		hex.EncodeToString(c.hasher.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return errSignatureMismatch
+	}
+	c.prevSig = c.chunkSig
+	return nil
+}
+
+func (c *chunkedReader) readTrailers() error {
+	var trailers strings.Builder
+	var trailerSig string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return errInvalidRequest
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-amz-trailer-signature" {
+			trailerSig = strings.TrimSpace(value)
+			continue
+		}
+		trailers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	if !c.signed {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-TRAILER",
+		c.amzDate,
+		c.scope,
+		c.prevSig,
+		sha256Hex([]byte(trailers.String())),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(trailerSig)) {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+func (c *chunkedReader) readChunkHeader() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return errInvalidRequest
+	}
+	if c.signed {
+		sig, ok := strings.CutPrefix(strings.TrimSpace(ext), "chunk-signature=")
+		if !ok {
+			return errSignatureMismatch
+		}
+		c.chunkSig = sig
+	}
+	c.hasher.Reset()
+	c.remaining = size
+	if size > 0 {
+		return nil
+	}
+	// final chunk
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	if c.trailer {
+		if err := c.readTrailers(); err != nil {
+			return err
+		}
+	} else if _, err := c.readLine(); err != nil {
+		return err
+	}
+	if c.read != c.expected {
+		return errIncompleteBody
+	}
+	c.done = true
+	return nil
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.readChunkHeader(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hasher.Write(p[:n])
+	c.remaining -= int64(n)
+	c.read += int64(n)
+	if c.read > c.expected {
+		c.err = errIncompleteBody
+		return n, c.err
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		return n, err
+	}
+	if c.remaining == 0 {
+		if err := c.verifyChunk(); err != nil {
+			c.err = err
+			return n, err
+		}
+		// the chunk data is followed by CRLF
+		line, err := c.readLine()
+		if err == nil && line != "" {
+			err = errInvalidRequest
+		}
+		if err != nil {
+			c.err = err
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 
 	"github.com/rs/zerolog"
 
@@ -27,11 +29,17 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/config"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/ftpd"
+	"github.com/drakkan/sftpgo/v2/internal/grpcd"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/s3gateway"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
+	"github.com/drakkan/sftpgo/v2/internal/telemetry"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
+	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
 const (
@@ -39,8 +47,9 @@ const (
 )
 
 var (
-	chars     = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
-	graceTime int
+	chars       = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+	graceTime   int
+	reloadMutex sync.Mutex
 )
 
 // Service defines the SFTPGo service
@@ -114,6 +123,9 @@ func (s *Service) Start(disableAWSInstallationCode bool) error {
 		return err
 	}
 
+	if s.PortableMode != 1 {
+		httpd.SetReloadConfigFn(s.reload)
+	}
 	s.startServices()
 	go common.Config.ExecuteStartupHook() //nolint:errcheck
 
@@ -154,7 +166,11 @@ func (s *Service) initializeServices(disableAWSInstallationCode bool) error {
 		logger.ErrorToConsole("unable to initialize SMTP configuration: %v", err)
 		return err
 	}
-	err = common.Initialize(config.GetCommonConfig(), providerConf.GetShared())
+	commonConfig := config.GetCommonConfig()
+	if commonConfig.SearchIndex.Path != "" && !filepath.IsAbs(commonConfig.SearchIndex.Path) {
+		commonConfig.SearchIndex.Path = filepath.Join(s.ConfigDir, commonConfig.SearchIndex.Path)
+	}
+	err = common.Initialize(commonConfig, providerConf.GetShared())
 	if err != nil {
 		logger.Error(logSender, "", "%v", err)
 		logger.ErrorToConsole("%v", err)
@@ -212,6 +228,8 @@ func (s *Service) startServices() {
 	ftpdConf := config.GetFTPDConfig()
 	httpdConf := config.GetHTTPDConfig()
 	webDavDConf := config.GetWebDAVDConfig()
+	s3GatewayConf := config.GetS3GatewayConfig()
+	grpcdConf := config.GetGRPCDConfig()
 	telemetryConf := config.GetTelemetryConfig()
 
 	if sftpdConf.ShouldBind() {
@@ -219,12 +237,8 @@ func (s *Service) startServices() {
 			redactedConf := sftpdConf
 			redactedConf.KeyboardInteractiveHook = util.GetRedactedURL(sftpdConf.KeyboardInteractiveHook)
 			logger.Info(logSender, "", "initializing SFTP server with config %+v", redactedConf)
-			if err := sftpdConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start SFTP server: %v", err)
-				logger.ErrorToConsole("could not start SFTP server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
+			err := sftpdConf.Initialize(s.ConfigDir)
+			s.serverStopped("SFTP", err)
 		}()
 	} else {
 		logger.Info(logSender, "", "SFTP server not started, disabled in config file")
@@ -233,12 +247,8 @@ func (s *Service) startServices() {
 	if httpdConf.ShouldBind() {
 		go func() {
 			providerConf := config.GetProviderConf()
-			if err := httpdConf.Initialize(s.ConfigDir, providerConf.GetShared()); err != nil {
-				logger.Error(logSender, "", "could not start HTTP server: %v", err)
-				logger.ErrorToConsole("could not start HTTP server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
+			err := httpdConf.Initialize(s.ConfigDir, providerConf.GetShared())
+			s.serverStopped("HTTP", err)
 		}()
 	} else {
 		logger.Info(logSender, "", "HTTP server not started, disabled in config file")
@@ -248,36 +258,40 @@ func (s *Service) startServices() {
 	}
 	if ftpdConf.ShouldBind() {
 		go func() {
-			if err := ftpdConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start FTP server: %v", err)
-				logger.ErrorToConsole("could not start FTP server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
+			err := ftpdConf.Initialize(s.ConfigDir)
+			s.serverStopped("FTP", err)
 		}()
 	} else {
 		logger.Info(logSender, "", "FTP server not started, disabled in config file")
 	}
 	if webDavDConf.ShouldBind() {
 		go func() {
-			if err := webDavDConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start WebDAV server: %v", err)
-				logger.ErrorToConsole("could not start WebDAV server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
+			err := webDavDConf.Initialize(s.ConfigDir)
+			s.serverStopped("WebDAV", err)
 		}()
 	} else {
 		logger.Info(logSender, "", "WebDAV server not started, disabled in config file")
 	}
+	if s3GatewayConf.ShouldBind() {
+		go func() {
+			err := s3GatewayConf.Initialize(s.ConfigDir)
+			s.serverStopped("S3", err)
+		}()
+	} else {
+		logger.Info(logSender, "", "S3 compatible API not started, disabled in config file")
+	}
+	if grpcdConf.ShouldBind() {
+		go func() {
+			err := grpcdConf.Initialize(s.ConfigDir)
+			s.serverStopped("gRPC", err)
+		}()
+	} else {
+		logger.Info(logSender, "", "gRPC server not started, disabled in config file")
+	}
 	if telemetryConf.ShouldBind() {
 		go func() {
-			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start telemetry server: %v", err)
-				logger.ErrorToConsole("could not start telemetry server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
+			err := telemetryConf.Initialize(s.ConfigDir)
+			s.serverStopped("telemetry", err)
 		}()
 	} else {
 		logger.Info(logSender, "", "telemetry server not started, disabled in config file")
@@ -287,10 +301,26 @@ func (s *Service) startServices() {
 	}
 }
 
+// serverStopped is called when a server exits. While draining the listeners
+// are closed on purpose, the service will exit once the ongoing transfers
+// are completed
+func (s *Service) serverStopped(name string, err error) {
+	if common.IsDraining() {
+		logger.Info(logSender, "", "%s server stopped accepting new connections", name)
+		return
+	}
+	if err != nil {
+		logger.Error(logSender, "", "could not start %s server: %v", name, err)
+		logger.ErrorToConsole("could not start %s server: %v", name, err)
+		s.Error = err
+	}
+	s.Shutdown <- true
+}
+
 // Wait blocks until the service exits
 func (s *Service) Wait() {
 	if s.PortableMode != 1 {
-		registerSignals()
+		registerSignals(s)
 	}
 	<-s.Shutdown
 }
@@ -351,50 +381,60 @@ func (s *Service) LoadInitialData() error {
 }
 
 func (s *Service) restoreDump(dump *dataprovider.BackupData) error {
-	err := httpd.RestoreConfigs(dump.Configs, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	return RestoreData(dump, s.LoadDataFrom, s.LoadDataMode, s.LoadDataQuotaScan)
+}
+
+// RestoreData restores the given backup data using the specified mode and quota scan
+// mode. The input file is only used for logging
+func RestoreData(dump *dataprovider.BackupData, inputFile string, mode, scanQuota int) error {
+	err := httpd.RestoreConfigs(dump.Configs, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore configs from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore configs from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreIPListEntries(dump.IPLists, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreIPListEntries(dump.IPLists, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore IP list entries from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore IP list entries from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreRoles(dump.Roles, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreTenants(dump.Tenants, inputFile, mode, dataprovider.ActionExecutorSystem, "")
 	if err != nil {
-		return fmt.Errorf("unable to restore roles from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore tenants from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreFolders(dump.Folders, s.LoadDataFrom, s.LoadDataMode, s.LoadDataQuotaScan, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreRoles(dump.Roles, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore folders from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore roles from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreGroups(dump.Groups, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreFolders(dump.Folders, inputFile, mode, scanQuota, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore groups from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore folders from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreUsers(dump.Users, s.LoadDataFrom, s.LoadDataMode, s.LoadDataQuotaScan, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreGroups(dump.Groups, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore users from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore groups from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreAdmins(dump.Admins, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreUsers(dump.Users, inputFile, mode, scanQuota, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore admins from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore users from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreAPIKeys(dump.APIKeys, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreAdmins(dump.Admins, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore API keys from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore admins from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreShares(dump.Shares, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreAPIKeys(dump.APIKeys, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore API keys from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore API keys from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreEventActions(dump.EventActions, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem, "", "")
+	err = httpd.RestoreShares(dump.Shares, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
 	if err != nil {
-		return fmt.Errorf("unable to restore event actions from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore API keys from file %q: %v", inputFile, err)
 	}
-	err = httpd.RestoreEventRules(dump.EventRules, s.LoadDataFrom, s.LoadDataMode, dataprovider.ActionExecutorSystem,
+	err = httpd.RestoreEventActions(dump.EventActions, inputFile, mode, dataprovider.ActionExecutorSystem, "", "")
+	if err != nil {
+		return fmt.Errorf("unable to restore event actions from file %q: %v", inputFile, err)
+	}
+	err = httpd.RestoreEventRules(dump.EventRules, inputFile, mode, dataprovider.ActionExecutorSystem,
 		"", "", dump.Version)
 	if err != nil {
-		return fmt.Errorf("unable to restore event rules from file %q: %v", s.LoadDataFrom, err)
+		return fmt.Errorf("unable to restore event rules from file %q: %v", inputFile, err)
 	}
 	return nil
 }
@@ -403,3 +443,136 @@ func (s *Service) restoreDump(dump *dataprovider.BackupData) error {
 func SetGraceTime(val int) {
 	graceTime = val
 }
+
+// reload applies the configuration changes that do not require a restart and
+// reloads certificates, revocation lists and the data provider configuration.
+// It is called on SIGHUP, or the equivalent Windows service request, and from
+// the REST API. The returned error is related to the configuration file
+func (s *Service) reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	logger.Debug(logSender, "", "Received reload request")
+	var errConfig error
+	if s.PortableMode != 1 {
+		errConfig = s.reloadConfig()
+		if errConfig != nil {
+			logger.Warn(logSender, "", "error reloading configuration: %v", errConfig)
+		}
+	}
+	err := dataprovider.ReloadConfig()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
+	}
+	err = httpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading cert manager: %v", err)
+	}
+	err = ftpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
+	}
+	err = webdavd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
+	}
+	err = s3gateway.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading S3 cert manager: %v", err)
+	}
+	err = grpcd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading gRPC cert manager: %v", err)
+	}
+	err = telemetry.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
+	}
+	err = common.Reload()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading common configs: %v", err)
+	}
+	err = sftpd.Reload()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading sftpd host keys and revoked certificates: %v", err)
+	}
+	return errConfig
+}
+
+// reloadConfig loads the configuration file again and applies the settings
+// that can be changed without interrupting the active connections.
+// New bindings are started and removed bindings stop accepting new connections
+// for all the services, changing the settings of an existing binding requires
+// a restart
+func (s *Service) reloadConfig() error {
+	sftpdConf := config.GetSFTPDConfig()
+	ftpdConf := config.GetFTPDConfig()
+	webDavDConf := config.GetWebDAVDConfig()
+	s3GatewayConf := config.GetS3GatewayConfig()
+	grpcdConf := config.GetGRPCDConfig()
+	httpdConf := config.GetHTTPDConfig()
+	if err := config.ReloadConfig(s.ConfigDir, s.ConfigFile); err != nil {
+		return fmt.Errorf("unable to load the configuration: %w", err)
+	}
+	smtpConfig := config.GetSMTPConfig()
+	if err := smtpConfig.Reload(); err != nil {
+		return fmt.Errorf("unable to reload the SMTP configuration: %w", err)
+	}
+	if err := common.ReloadConfig(config.GetCommonConfig()); err != nil {
+		return fmt.Errorf("unable to reload the common configuration: %w", err)
+	}
+	newHTTPDConf := config.GetHTTPDConfig()
+	if httpdConf.ShouldBind() {
+		if err := newHTTPDConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the HTTP bindings: %w", err)
+		}
+	} else if newHTTPDConf.ShouldBind() {
+		logger.Warn(logSender, "", "the HTTP server is not running, a restart is required to start it")
+	}
+	newSFTPDConf := config.GetSFTPDConfig()
+	if sftpdConf.ShouldBind() {
+		if err := newSFTPDConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the SFTP bindings: %w", err)
+		}
+	} else if newSFTPDConf.ShouldBind() {
+		logger.Warn(logSender, "", "the SFTP server is not running, a restart is required to start it")
+	}
+	if !reflect.DeepEqual(sftpdConf.HostKeys, newSFTPDConf.HostKeys) ||
+		!reflect.DeepEqual(sftpdConf.HostCertificates, newSFTPDConf.HostCertificates) {
+		logger.Warn(logSender, "", "the SFTP host keys or certificates paths changed, a restart is required to apply them")
+	}
+	newFTPDConf := config.GetFTPDConfig()
+	if ftpdConf.ShouldBind() {
+		if err := newFTPDConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the FTP bindings: %w", err)
+		}
+	} else if newFTPDConf.ShouldBind() {
+		logger.Warn(logSender, "", "the FTP server is not running, a restart is required to start it")
+	}
+	newWebDAVDConf := config.GetWebDAVDConfig()
+	if webDavDConf.ShouldBind() {
+		if err := newWebDAVDConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the WebDAV bindings: %w", err)
+		}
+	} else if newWebDAVDConf.ShouldBind() {
+		logger.Warn(logSender, "", "the WebDAV server is not running, a restart is required to start it")
+	}
+	newS3GatewayConf := config.GetS3GatewayConfig()
+	if s3GatewayConf.ShouldBind() {
+		if err := newS3GatewayConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the S3 bindings: %w", err)
+		}
+	} else if newS3GatewayConf.ShouldBind() {
+		logger.Warn(logSender, "", "the S3 server is not running, a restart is required to start it")
+	}
+	newGRPCDConf := config.GetGRPCDConfig()
+	if grpcdConf.ShouldBind() {
+		if err := newGRPCDConf.ReloadBindings(); err != nil {
+			return fmt.Errorf("unable to reload the gRPC bindings: %w", err)
+		}
+	} else if newGRPCDConf.ShouldBind() {
+		logger.Warn(logSender, "", "the gRPC server is not running, a restart is required to start it")
+	}
+	logger.Info(logSender, "", "configuration reloaded")
+	return nil
+}
@@ -26,14 +26,8 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
-	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
-	"github.com/drakkan/sftpgo/v2/internal/ftpd"
-	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
-	"github.com/drakkan/sftpgo/v2/internal/sftpd"
-	"github.com/drakkan/sftpgo/v2/internal/telemetry"
-	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
 const (
@@ -140,35 +134,7 @@ loop:
 			common.WaitForTransfers(graceTime)
 			break loop
 		case svc.ParamChange:
-			logger.Debug(logSender, "", "Received reload request")
-			err := dataprovider.ReloadConfig()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
-			}
-			err = httpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading cert manager: %v", err)
-			}
-			err = ftpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
-			}
-			err = webdavd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
-			}
-			err = telemetry.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
-			}
-			err = common.Reload()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading common configs: %v", err)
-			}
-			err = sftpd.Reload()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
-			}
+			s.Service.reload() //nolint:errcheck
 		case rotateLogCmd:
 			logger.Debug(logSender, "", "Received log file rotation request")
 			err := logger.RotateLogFile()
@@ -23,24 +23,18 @@ import (
 	"syscall"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
-	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
-	"github.com/drakkan/sftpgo/v2/internal/ftpd"
-	"github.com/drakkan/sftpgo/v2/internal/httpd"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
-	"github.com/drakkan/sftpgo/v2/internal/sftpd"
-	"github.com/drakkan/sftpgo/v2/internal/telemetry"
-	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
-func registerSignals() {
+func registerSignals(s *Service) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
 	go func() {
 		for sig := range c {
 			switch sig {
 			case syscall.SIGHUP:
-				handleSIGHUP()
+				s.reload() //nolint:errcheck
 			case syscall.SIGUSR1:
 				handleSIGUSR1()
 			case syscall.SIGINT, syscall.SIGTERM:
@@ -50,38 +44,6 @@ func registerSignals() {
 	}()
 }
 
-func handleSIGHUP() {
-	logger.Debug(logSender, "", "Received reload request")
-	err := dataprovider.ReloadConfig()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
-	}
-	err = httpd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading cert manager: %v", err)
-	}
-	err = ftpd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
-	}
-	err = webdavd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
-	}
-	err = telemetry.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
-	}
-	err = common.Reload()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading common configs: %v", err)
-	}
-	err = sftpd.Reload()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading sftpd revoked certificates: %v", err)
-	}
-}
-
 func handleSIGUSR1() {
 	logger.Debug(logSender, "", "Received log file rotation request")
 	err := logger.RotateLogFile()
@@ -23,7 +23,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 )
 
-func registerSignals() {
+func registerSignals(_ *Service) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
@@ -0,0 +1,327 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// the GSSAPI checksum in the authenticator contains the context flags, RFC 4121 section 4.1.1
+const gssapiChecksumMinLen = 24
+
+var gssapiKRB5TokenIDAPRep = []byte{0x02, 0x00}
+
+// GSSAPIPrincipalMapping defines a rule to map a Kerberos principal to an SFTPGo username
+type GSSAPIPrincipalMapping struct {
+	// Regular expression to match against the client principal, for example "^(.+)@EXAMPLE\.COM$"
+	Regexp string `json:"regexp" mapstructure:"regexp"`
+	// Template for the username, "$1" is replaced with the first capturing group and so on
+	Replacement string `json:"replacement" mapstructure:"replacement"`
+	re          *regexp.Regexp
+}
+
+// GSSAPIConfig defines the configuration for the "gssapi-with-mic" authentication
+type GSSAPIConfig struct {
+	// Set to true to enable Kerberos authentication
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to the keytab with the service keys.
+	// The path can be absolute or relative to the configuration directory
+	Keytab string `json:"keytab" mapstructure:"keytab"`
+	// Service principal to use from the keytab, for example "host/sftp.example.com".
+	// Leave empty to use the service principal requested by the client
+	ServicePrincipal string `json:"service_principal" mapstructure:"service_principal"`
+	// Kerberos realm of the service. Leave empty to use the realm of the service
+	// keys in the keytab
+	Realm string `json:"realm" mapstructure:"realm"`
+	// Rules to map the client principals to SFTPGo usernames. The first matching rule
+	// is applied. If no rule is defined the service realm is stripped from the principal
+	// and principals from other realms are rejected, if rules are defined principals
+	// not matching any rule are rejected.
+	// The mapped username must match the username requested by the client
+	PrincipalMappings []GSSAPIPrincipalMapping `json:"principal_mappings" mapstructure:"principal_mappings"`
+	keytab            *keytab.Keytab
+	realms            []string
+}
+
+func (c *GSSAPIConfig) initialize(configDir string) error {
+	c.keytab = nil
+	c.realms = nil
+	if !c.Enabled {
+		return nil
+	}
+	if !util.IsFileInputValid(c.Keytab) {
+		return fmt.Errorf("invalid GSSAPI keytab: %q", c.Keytab)
+	}
+	keytabPath := c.Keytab
+	if !filepath.IsAbs(keytabPath) {
+		keytabPath = filepath.Join(configDir, keytabPath)
+	}
+	for idx := range c.PrincipalMappings {
+		re, err := regexp.Compile(c.PrincipalMappings[idx].Regexp)
+		if err != nil {
+			return fmt.Errorf("invalid GSSAPI principal mapping %q: %w", c.PrincipalMappings[idx].Regexp, err)
+		}
+		c.PrincipalMappings[idx].re = re
+	}
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		logger.Warn(logSender, "", "error loading GSSAPI keytab %q: %v", keytabPath, err)
+		logger.WarnToConsole("error loading GSSAPI keytab %q: %v", keytabPath, err)
+		return err
+	}
+	c.realms = c.getServiceRealms(kt)
+	if len(c.realms) == 0 {
+		return fmt.Errorf("unable to find the GSSAPI service realm in keytab %q", keytabPath)
+	}
+	c.keytab = kt
+	logger.Info(logSender, "", "GSSAPI authentication enabled, keytab %q loaded, service principal: %q, realms: %v, mapping rules: %d",
+		keytabPath, c.ServicePrincipal, c.realms, len(c.PrincipalMappings))
+	return nil
+}
+
+// getServiceRealms returns the configured realm or the realms of the service
+// keys in the keytab
+func (c *GSSAPIConfig) getServiceRealms(kt *keytab.Keytab) []string {
+	if c.Realm != "" {
+		return []string{c.Realm}
+	}
+	var realms []string
+	for _, entry := range kt.Entries {
+		if c.ServicePrincipal != "" {
+			if strings.Join(entry.Principal.Components, "/") != c.ServicePrincipal &&
+				entry.Principal.String() != c.ServicePrincipal {
+				continue
+			}
+		}
+		if entry.Principal.Realm != "" && !util.Contains(realms, entry.Principal.Realm) {
+			realms = append(realms, entry.Principal.Realm)
+		}
+	}
+	return realms
+}
+
+func (c *GSSAPIConfig) isEnabled() bool {
+	return c.keytab != nil
+}
+
+// getUsername returns the SFTPGo username for the specified principal
+func (c *GSSAPIConfig) getUsername(principal string) (string, error) {
+	if len(c.PrincipalMappings) == 0 {
+		idx := strings.LastIndex(principal, "@")
+		if idx < 0 {
+			return "", fmt.Errorf("principal %q has no realm", principal)
+		}
+		realm := principal[idx+1:]
+		if !util.Contains(c.realms, realm) {
+			return "", fmt.Errorf("principal %q is from realm %q, not from the service realm, a mapping rule is required",
+				principal, realm)
+		}
+		return principal[:idx], nil
+	}
+	for _, mapping := range c.PrincipalMappings {
+		match := mapping.re.FindStringSubmatchIndex(principal)
+		if match == nil {
+			continue
+		}
+		username := string(mapping.re.ExpandString(nil, mapping.Replacement, principal, match))
+		if username == "" {
+			return "", fmt.Errorf("principal %q is mapped to an empty username", principal)
+		}
+		return username, nil
+	}
+	return "", fmt.Errorf("principal %q does not match any mapping rule", principal)
+}
+
+// getServerConfig returns the GSSAPI configuration for a new connection.
+// The GSSAPI server keeps the security context so a new one is required
+// for each connection
+func (c *GSSAPIConfig) getServerConfig(remoteAddr net.Addr) *ssh.GSSAPIWithMICConfig {
+	settings := []func(*service.Settings){service.DecodePAC(false)}
+	if c.ServicePrincipal != "" {
+		settings = append(settings, service.KeytabPrincipal(c.ServicePrincipal))
+	}
+	if ip := net.ParseIP(util.GetIPFromRemoteAddress(remoteAddr.String())); ip != nil {
+		settings = append(settings, service.ClientAddress(types.HostAddressFromNetIP(ip)))
+	}
+	return &ssh.GSSAPIWithMICConfig{
+		AllowLogin: c.validateCredentials,
+		Server: &gssapiServer{
+			settings: service.NewSettings(c.keytab, settings...),
+		},
+	}
+}
+
+func (c *GSSAPIConfig) validateCredentials(conn ssh.ConnMetadata, principal string) (*ssh.Permissions, error) {
+	var user dataprovider.User
+	var sshPerm *ssh.Permissions
+
+	method := dataprovider.SSHLoginMethodGSSAPI
+	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	username, err := c.getUsername(principal)
+	if err == nil && username != conn.User() {
+		err = fmt.Errorf("principal %q is mapped to username %q not to %q", principal, username, conn.User())
+	}
+	if err == nil {
+		if user, err = dataprovider.CheckUserAndGSSAPIAuth(conn.User(), ipAddr, common.ProtocolSSH); err == nil {
+			sshPerm, err = loginUser(&user, method, "", conn)
+		}
+	}
+	user.Username = conn.User()
+	updateLoginMetrics(&user, ipAddr, method, err)
+	if err != nil {
+		return nil, newAuthenticationError(fmt.Errorf("could not validate GSSAPI credentials: %w", err), method, conn.User())
+	}
+	logger.Debug(logSender, hex.EncodeToString(conn.SessionID()), "user %q authenticated using Kerberos principal %q",
+		conn.User(), principal)
+	return sshPerm, nil
+}
+
+// gssapiServer implements a Kerberos V5 GSSAPI acceptor for the SSH server
+type gssapiServer struct {
+	settings *service.Settings
+	// key used by the client to sign the MIC
+	micKey *types.EncryptionKey
+}
+
+func (s *gssapiServer) AcceptSecContext(token []byte) ([]byte, string, bool, error) {
+	s.micKey = nil
+
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(token); err != nil {
+		return nil, "", false, fmt.Errorf("unable to parse GSSAPI token: %w", err)
+	}
+	if !krb5Token.IsAPReq() {
+		return nil, "", false, errors.New("unexpected GSSAPI token, AP_REQ expected")
+	}
+	apReq := krb5Token.APReq
+	ok, creds, err := service.VerifyAPREQ(&apReq, s.settings)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("unable to verify Kerberos AP_REQ: %w", err)
+	}
+	if !ok {
+		return nil, "", false, errors.New("invalid Kerberos AP_REQ")
+	}
+	// we don't send an acceptor subkey so the client uses its subkey, if any,
+	// or the ticket session key, RFC 4121 section 2
+	key := apReq.Ticket.DecryptedEncPart.Key
+	if apReq.Authenticator.SubKey.KeyType != 0 {
+		key = apReq.Authenticator.SubKey
+	}
+	var outputToken []byte
+	if isGSSAPIMutualAuthRequested(&apReq) {
+		outputToken, err = getGSSAPIAPRepToken(&apReq)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("unable to create Kerberos AP_REP: %w", err)
+		}
+	}
+	s.micKey = &key
+	return outputToken, fmt.Sprintf("%s@%s", creds.CName().PrincipalNameString(), creds.Domain()), false, nil
+}
+
+func (s *gssapiServer) VerifyMIC(micField []byte, micToken []byte) error {
+	if s.micKey == nil {
+		return errors.New("GSSAPI security context not established")
+	}
+	var token gssapi.MICToken
+	if err := token.Unmarshal(micToken, false); err != nil {
+		return fmt.Errorf("unable to parse GSSAPI MIC token: %w", err)
+	}
+	token.Payload = micField
+	ok, err := token.Verify(*s.micKey, keyusage.GSSAPI_INITIATOR_SIGN)
+	if err != nil {
+		return fmt.Errorf("unable to verify GSSAPI MIC: %w", err)
+	}
+	if !ok {
+		return errors.New("invalid GSSAPI MIC")
+	}
+	return nil
+}
+
+func (s *gssapiServer) DeleteSecContext() error {
+	s.micKey = nil
+	return nil
+}
+
+func isGSSAPIMutualAuthRequested(apReq *messages.APReq) bool {
+	if types.IsFlagSet(&apReq.APOptions, flags.APOptionMutualRequired) {
+		return true
+	}
+	cksum := apReq.Authenticator.Cksum
+	if cksum.CksumType != chksumtype.GSSAPI || len(cksum.Checksum) < gssapiChecksumMinLen {
+		return false
+	}
+	return binary.LittleEndian.Uint32(cksum.Checksum[20:24])&uint32(gssapi.ContextFlagMutual) != 0
+}
+
+// getGSSAPIAPRepToken returns the AP_REP, wrapped in a GSSAPI token, needed
+// for mutual authentication. gokrb5 does not support AP_REP marshaling
+func getGSSAPIAPRepToken(apReq *messages.APReq) ([]byte, error) {
+	encPart, err := asn1.Marshal(messages.EncAPRepPart{
+		CTime: apReq.Authenticator.CTime,
+		Cusec: apReq.Authenticator.Cusec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(encPart, asnAppTag.EncAPRepPart),
+		apReq.Ticket.DecryptedEncPart.Key, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, err
+	}
+	apRep, err := asn1.Marshal(messages.APRep{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, err
+	}
+	token = append(token, gssapiKRB5TokenIDAPRep...)
+	token = append(token, asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...)
+	return asn1tools.AddASNAppTag(token, 0), nil
+}
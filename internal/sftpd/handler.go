@@ -42,6 +42,8 @@ type Connection struct {
 	channel      io.ReadWriteCloser
 	command      string
 	folderPrefix string
+	// open SFTP transfers, used by the copy-data extension
+	sftpTransfers sftpTransfers
 }
 
 // GetClientVersion returns the connected client's version
@@ -98,7 +100,9 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	startTime := time.Now()
 	file, r, cancelFn, err := fs.Open(p, 0)
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "could not open file %q for reading: %+v", p, err)
 		return nil, c.GetFsError(fs, err)
@@ -107,6 +111,7 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, request.Filepath, common.TransferDownload,
 		0, 0, 0, 0, false, fs, transferQuota)
 	t := newTransfer(baseTransfer, nil, r, nil)
+	c.sftpTransfers.add(t)
 
 	return t, nil
 }
@@ -409,7 +414,9 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, pflags sftp.FileOpenFl
 	}
 
 	osFlags := getOSOpenFlags(pflags)
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, osFlags, c.GetCreateChecks(requestPath, true, false))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "error creating file %q, os flags %d, pflags %+v: %+v", resolvedPath, osFlags, pflags, err)
 		return nil, c.GetFsError(fs, err)
@@ -423,6 +430,7 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, pflags sftp.FileOpenFl
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, 0, true, fs, transferQuota)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
+	c.sftpTransfers.add(t)
 
 	return t, nil
 }
@@ -456,6 +464,11 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		c.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
 	}
+	if !isResume {
+		if err := c.CreateFileVersion(fs, resolvedPath, requestPath, fileSize); err != nil {
+			return nil, err
+		}
+	}
 
 	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		_, _, err = fs.Rename(resolvedPath, filePath)
@@ -466,7 +479,9 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		}
 	}
 
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, osFlags, c.GetCreateChecks(requestPath, false, isResume))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.Log(logger.LevelError, "error opening existing file, os flags %v, pflags: %+v, source: %q, err: %+v",
 			osFlags, pflags, filePath, err)
@@ -498,6 +513,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, truncatedSize, false, fs, transferQuota)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
+	c.sftpTransfers.add(t)
 
 	return t, nil
 }
@@ -558,6 +574,7 @@ func (c *Connection) getStatVFSFromQuotaResult(fs vfs.Fs, name string, quotaResu
 }
 
 func (c *Connection) updateQuotaAfterTruncate(requestPath string, fileSize int64) {
+	c.UpdateDirQuotaUsage(requestPath, 0, -fileSize)
 	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 	if err == nil {
 		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
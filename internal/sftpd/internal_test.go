@@ -16,6 +16,7 @@ package sftpd
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -24,10 +25,22 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/eikenb/pipeat"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	krb5crypto "github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/pkg/sftp"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -688,6 +701,57 @@ func TestCommandsWithExtensionsFilter(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBorgServeCommand(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "test",
+			HomeDir:  os.TempDir(),
+			Status:   1,
+		},
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, "", "", user),
+	}
+	cmd := sshCommand{
+		command:    borgCmdName,
+		connection: connection,
+		args:       []string{"init"},
+	}
+	_, err := cmd.getSystemCommand()
+	assert.Error(t, err)
+
+	cmd.args = []string{"serve", "--umask=077", "--info", "--restrict-to-path", "/"}
+	command, err := cmd.getSystemCommand()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{borgCmdName, "serve", "--restrict-to-path", os.TempDir(), "--umask=077", "--info"},
+			command.cmd.Args)
+		assert.Equal(t, os.TempDir(), command.cmd.Dir)
+		assert.Equal(t, "/", command.virtualPath)
+	}
+	assert.Len(t, cmd.getSystemCommandPerms(), 6)
+
+	connection.User.Filters.AppendOnly = true
+	command, err = cmd.getSystemCommand()
+	if assert.NoError(t, err) {
+		assert.Contains(t, command.cmd.Args, "--append-only")
+	}
+	assert.Len(t, cmd.getSystemCommandPerms(), 4)
+	assert.True(t, connection.User.HasPerms(cmd.getSystemCommandPerms(), "/"))
+
+	connection.User.VirtualFolders = append(connection.User.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       "vfolder",
+			MappedPath: filepath.Join(os.TempDir(), "vfolder"),
+		},
+		VirtualPath: "/vdir",
+	})
+	_, err = cmd.getSystemCommand()
+	assert.EqualError(t, err, errUnsupportedConfig.Error())
+}
+
 func TestSSHCommandsRemoteFs(t *testing.T) {
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
@@ -1732,6 +1796,45 @@ func TestSCPUploadFiledata(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTransferCopyN(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), transferBufferSize+100)
+	var dst bytes.Buffer
+	n, err := copyN(&dst, bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, dst.Bytes())
+
+	dst.Reset()
+	n, err = copyN(&dst, bytes.NewReader(data), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, data[:10], dst.Bytes())
+
+	dst.Reset()
+	n, err = copyN(&dst, bytes.NewReader(data), int64(len(data)+1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, int64(len(data)), n)
+
+	buf := transferBuffers.Get().(*[]byte)
+	assert.Len(t, *buf, transferBufferSize)
+	transferBuffers.Put(buf)
+}
+
+func TestSCPParseTimeMessage(t *testing.T) {
+	atime, mtime, err := parseTimeMessage("T1183832947 0 1183833773 500\n")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1183832947, 0), mtime)
+	assert.Equal(t, time.Unix(1183833773, 500000), atime)
+	_, _, err = parseTimeMessage("T1183832947 0 1183833773\n")
+	assert.Error(t, err)
+	_, _, err = parseTimeMessage("T1183832947 0 a 0\n")
+	assert.Error(t, err)
+	_, _, err = parseTimeMessage("T1183832947 1000000 1183833773 0\n")
+	assert.Error(t, err)
+	_, _, err = parseTimeMessage("T-1 0 1183833773 0\n")
+	assert.Error(t, err)
+}
+
 func TestUploadError(t *testing.T) {
 	common.Config.UploadMode = common.UploadModeAtomic
 
@@ -1905,16 +2008,23 @@ func TestSupportedSecurityOptions(t *testing.T) {
 }
 
 func TestLoadHostKeys(t *testing.T) {
-	serverConfig := &ssh.ServerConfig{}
+	hostKeysMgr.mu.RLock()
+	initialConfig := hostKeysMgr.config
+	initialConfigDir := hostKeysMgr.configDir
+	initialSigners := hostKeysMgr.signers
+	initialKeys := serviceStatus.HostKeys
+	hostKeysMgr.mu.RUnlock()
+	defer hostKeysMgr.set(initialConfig, initialConfigDir, initialSigners, initialKeys)
+
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	err := c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = os.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1926,19 +2036,41 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	c.HostKeyAlgorithms = []string{ssh.KeyAlgoRSASHA256}
 	c.HostKeys = []string{ecdsaKeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	c.HostKeyAlgorithms = preferredHostKeyAlgos
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.NoError(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
 	assert.FileExists(t, ed25519KeyName)
 	assert.NoFileExists(t, nonDefaultKeyName)
+	hostKeysMgr.mu.RLock()
+	assert.Len(t, hostKeysMgr.signers, 1)
+	hostKeysMgr.mu.RUnlock()
+	serverConfig := hostKeysMgr.getServerConfig(&ssh.ServerConfig{})
+	assert.NotNil(t, serverConfig)
+	// replace the ECDSA key, the new key must be used after a reload
+	oldFingerprint := serviceStatus.HostKeys[0].Fingerprint
+	err = os.Remove(ecdsaKeyName)
+	assert.NoError(t, err)
+	err = util.GenerateECDSAKeys(ecdsaKeyName)
+	assert.NoError(t, err)
+	err = hostKeysMgr.reload()
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldFingerprint, serviceStatus.HostKeys[0].Fingerprint)
+	// an invalid key must not replace the loaded ones
+	err = os.WriteFile(ecdsaKeyName, []byte("invalid key"), 0600)
+	assert.NoError(t, err)
+	err = hostKeysMgr.reload()
+	assert.Error(t, err)
+	hostKeysMgr.mu.RLock()
+	assert.Len(t, hostKeysMgr.signers, 1)
+	hostKeysMgr.mu.RUnlock()
 	err = os.Remove(rsaKeyName)
 	assert.NoError(t, err)
 	err = os.Remove(ecdsaKeyName)
@@ -1949,16 +2081,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		err = c.checkAndLoadHostKeys(keysDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -2227,3 +2359,588 @@ func TestAuthenticationErrors(t *testing.T) {
 	assert.ErrorIs(t, err, sftpAuthError)
 	assert.NotErrorIs(t, err, util.ErrNotFound)
 }
+
+type sftpExtTestChannel struct {
+	reader io.Reader
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (c *sftpExtTestChannel) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *sftpExtTestChannel) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.buf.Write(p)
+}
+
+func (c *sftpExtTestChannel) Close() error {
+	return nil
+}
+
+func (c *sftpExtTestChannel) getWritten() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return bytes.Clone(c.buf.Bytes())
+}
+
+func getSFTPTestPacket(packetType byte, id uint32, payload []byte) []byte {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+5))
+	packet = append(packet, packetType)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	return append(packet, payload...)
+}
+
+func TestSFTPExtensionsChannel(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "sftp_ext")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "file"), []byte("data"), 0666)
+	assert.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    "sftp_ext_user",
+			HomeDir:     homeDir,
+			Permissions: make(map[string][]string),
+		},
+	}
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+	}
+
+	openPacket := getSFTPTestPacket(sftpPacketOpen, 1, binary.BigEndian.AppendUint64(sftpMarshalString(nil, "file"), 0))
+	clientPackets := bytes.Clone(openPacket)
+	copyFilePayload := sftpMarshalString(nil, sftpExtCopyFile)
+	copyFilePayload = sftpMarshalString(copyFilePayload, "/file")
+	copyFilePayload = sftpMarshalString(copyFilePayload, "file1")
+	copyFilePayload = append(copyFilePayload, 0)
+	clientPackets = append(clientPackets, getSFTPTestPacket(sftpPacketExtended, 2, copyFilePayload)...)
+	statPacket := getSFTPTestPacket(17, 3, sftpMarshalString(nil, "/file"))
+	clientPackets = append(clientPackets, statPacket...)
+	unknownExtPacket := getSFTPTestPacket(sftpPacketExtended, 4, sftpMarshalString(nil, "unknown"))
+	clientPackets = append(clientPackets, unknownExtPacket...)
+
+	channel := &sftpExtTestChannel{
+		reader: bytes.NewReader(clientPackets),
+	}
+	extChannel := newSFTPExtensionsChannel(channel, connection, "/")
+	// the copy-file request is not forwarded to the SFTP server
+	forwarded, err := io.ReadAll(extChannel)
+	assert.NoError(t, err)
+	assert.Equal(t, append(append(openPacket, statPacket...), unknownExtPacket...), forwarded)
+	assert.Eventually(t, func() bool {
+		return len(channel.getWritten()) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	written := channel.getWritten()
+	if assert.Greater(t, len(written), 13) {
+		assert.Equal(t, byte(sftpPacketStatus), written[4])
+		assert.Equal(t, uint32(2), binary.BigEndian.Uint32(written[5:]))
+		assert.Equal(t, uint32(sftpStatusOK), binary.BigEndian.Uint32(written[9:]))
+	}
+	content, err := os.ReadFile(filepath.Join(homeDir, "file1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), content)
+	// the target exists and overwrite is not requested
+	err = extChannel.copyFile(copyFilePayload[4+len(sftpExtCopyFile):])
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	copyFilePayload[len(copyFilePayload)-1] = 1
+	err = extChannel.copyFile(copyFilePayload[4+len(sftpExtCopyFile):])
+	assert.NoError(t, err)
+	err = extChannel.copyFile(copyFilePayload[4+len(sftpExtCopyFile) : len(copyFilePayload)-1])
+	assert.ErrorIs(t, err, errSFTPBadMessage)
+	err = extChannel.copyFile(append(append(sftpMarshalString(nil, "/"), sftpMarshalString(nil, "/dir")...), 1))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = extChannel.copyFile(append(append(sftpMarshalString(nil, "/missing"), sftpMarshalString(nil, "/dir")...), 1))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+
+	// server packets, they can be written using multiple writes
+	channel.buf.Reset()
+	// the open request is served by the SFTP server before the response is sent
+	reader, err := connection.Fileread(sftp.NewRequest("Get", "/file"))
+	assert.NoError(t, err)
+	versionPacket := binary.BigEndian.AppendUint32(nil, 5)
+	versionPacket = append(versionPacket, sftpPacketVersion)
+	versionPacket = binary.BigEndian.AppendUint32(versionPacket, 3)
+	handlePacket := getSFTPTestPacket(sftpPacketHandle, 1, sftpMarshalString(nil, "h1"))
+	dataPacket := getSFTPTestPacket(103, 5, sftpMarshalString(nil, "some data"))
+	serverPackets := append(append(bytes.Clone(versionPacket), handlePacket...), dataPacket...)
+	for _, chunk := range [][]byte{serverPackets[:3], serverPackets[3:11], serverPackets[11:20], serverPackets[20:]} {
+		n, err := extChannel.Write(chunk)
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	written = channel.getWritten()
+	expectedVersion := bytes.Clone(versionPacket)
+	for _, s := range extChannel.getExtensions() {
+		expectedVersion = sftpMarshalString(expectedVersion, s)
+		expectedVersion = sftpMarshalString(expectedVersion, "1")
+	}
+	binary.BigEndian.PutUint32(expectedVersion, uint32(len(expectedVersion)-4))
+	assert.Equal(t, append(append(expectedVersion, handlePacket...), dataPacket...), written)
+	extChannel.mu.Lock()
+	assert.Len(t, extChannel.pendingOpens, 0)
+	extChannel.mu.Unlock()
+	tr, err := connection.sftpTransfers.get("h1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, reader, tr)
+		assert.Equal(t, "/file", tr.GetVirtualPath())
+	}
+	// there is no open transfer for the second handle
+	err = extChannel.copyData(append(binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(
+		sftpMarshalString(nil, "h1"), 0), 0), binary.BigEndian.AppendUint64(sftpMarshalString(nil, "h2"), 0)...))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	err = extChannel.copyData(append(binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(
+		sftpMarshalString(nil, "h1"), 0), 0), binary.BigEndian.AppendUint64(sftpMarshalString(nil, "h1"), 0)...))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = extChannel.copyData(sftpMarshalString(nil, "h1"))
+	assert.ErrorIs(t, err, errSFTPBadMessage)
+	// h1 is open for reading
+	_, err = extChannel.getTransferForHandle("h1", common.TransferUpload)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	// the client closes the handle
+	closeChannel := &sftpExtTestChannel{
+		reader: bytes.NewReader(getSFTPTestPacket(sftpPacketClose, 7, sftpMarshalString(nil, "h1"))),
+	}
+	_, err = io.ReadAll(newSFTPExtensionsChannel(closeChannel, connection, "/"))
+	assert.NoError(t, err)
+	_, err = connection.sftpTransfers.get("h1")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	err = reader.(*transfer).Close()
+	assert.NoError(t, err)
+	// a failed open does not consume the queued transfers
+	extChannel.mu.Lock()
+	extChannel.pendingOpens[8] = true
+	extChannel.mu.Unlock()
+	_, err = extChannel.Write(getSFTPTestPacket(sftpPacketStatus, 8, binary.BigEndian.AppendUint32(nil, sftpStatusNoSuchFile)))
+	assert.NoError(t, err)
+	assert.Len(t, connection.sftpTransfers.opened, 0)
+
+	// limits@openssh.com
+	limitsChannel := &sftpExtTestChannel{
+		reader: bytes.NewReader(getSFTPTestPacket(sftpPacketExtended, 6, sftpMarshalString(nil, sftpExtLimits))),
+	}
+	extChannel = newSFTPExtensionsChannel(limitsChannel, connection, "/")
+	forwarded, err = io.ReadAll(extChannel)
+	assert.NoError(t, err)
+	assert.Len(t, forwarded, 0)
+	assert.Eventually(t, func() bool {
+		return len(limitsChannel.getWritten()) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	written = limitsChannel.getWritten()
+	if assert.Len(t, written, 41) {
+		assert.Equal(t, uint32(37), binary.BigEndian.Uint32(written))
+		assert.Equal(t, byte(sftpPacketExtendedReply), written[4])
+		assert.Equal(t, uint32(6), binary.BigEndian.Uint32(written[5:]))
+		assert.Equal(t, uint64(sftpLimitMaxPacketLength), binary.BigEndian.Uint64(written[9:]))
+		assert.Equal(t, uint64(sftpLimitMaxReadLength), binary.BigEndian.Uint64(written[17:]))
+		assert.Equal(t, uint64(sftpLimitMaxWriteLength), binary.BigEndian.Uint64(written[25:]))
+		assert.Equal(t, uint64(0), binary.BigEndian.Uint64(written[33:]))
+	}
+
+	code, _ := getSFTPStatusFromError(io.EOF)
+	assert.Equal(t, uint32(sftpStatusEOF), code)
+	code, _ = getSFTPStatusFromError(os.ErrPermission)
+	assert.Equal(t, uint32(sftpStatusPermissionDenied), code)
+	code, _ = getSFTPStatusFromError(errors.New("generic error"))
+	assert.Equal(t, uint32(sftpStatusFailure), code)
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+func TestSFTPExtensionsXattr(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "sftp_xattr")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	assert.NoError(t, err)
+	defer os.RemoveAll(homeDir)
+
+	filePath := filepath.Join(homeDir, "file")
+	err = os.WriteFile(filePath, []byte("data"), 0666)
+	assert.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    "sftp_xattr_user",
+			HomeDir:     homeDir,
+			Permissions: make(map[string][]string),
+		},
+	}
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/ro"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+	}
+	if err := connection.SetXattr("/file", "user.test", []byte("test")); err != nil {
+		t.Skipf("extended attributes are not supported: %v", err)
+	}
+	setPayload := sftpMarshalString(nil, sftpExtSetXattr)
+	setPayload = sftpMarshalString(setPayload, "file")
+	setPayload = sftpMarshalString(setPayload, "user.sftpgo")
+	setPayload = sftpMarshalString(setPayload, "value")
+	setPayload = binary.BigEndian.AppendUint32(setPayload, 0)
+	getPayload := sftpMarshalString(nil, sftpExtGetXattr)
+	getPayload = sftpMarshalString(getPayload, "/file")
+	getPayload = sftpMarshalString(getPayload, "user.sftpgo")
+	getPayload = binary.BigEndian.AppendUint32(getPayload, 0)
+	clientPackets := getSFTPTestPacket(sftpPacketExtended, 1, setPayload)
+	channel := &sftpExtTestChannel{
+		reader: bytes.NewReader(clientPackets),
+	}
+	extChannel := newSFTPExtensionsChannel(channel, connection, "/")
+	forwarded, err := io.ReadAll(extChannel)
+	assert.NoError(t, err)
+	assert.Len(t, forwarded, 0)
+	assert.Eventually(t, func() bool {
+		return len(channel.getWritten()) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	written := channel.getWritten()
+	if assert.Greater(t, len(written), 13) {
+		assert.Equal(t, byte(sftpPacketStatus), written[4])
+		assert.Equal(t, uint32(sftpStatusOK), binary.BigEndian.Uint32(written[9:]))
+	}
+	channel = &sftpExtTestChannel{
+		reader: bytes.NewReader(getSFTPTestPacket(sftpPacketExtended, 2, getPayload)),
+	}
+	extChannel = newSFTPExtensionsChannel(channel, connection, "/")
+	forwarded, err = io.ReadAll(extChannel)
+	assert.NoError(t, err)
+	assert.Len(t, forwarded, 0)
+	assert.Eventually(t, func() bool {
+		return len(channel.getWritten()) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	written = channel.getWritten()
+	if assert.Len(t, written, 18) {
+		assert.Equal(t, byte(sftpPacketExtendedReply), written[4])
+		assert.Equal(t, uint32(2), binary.BigEndian.Uint32(written[5:]))
+		value, _, err := sftpUnmarshalString(written[9:])
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+
+	reply, err := extChannel.listXattr(binary.BigEndian.AppendUint32(sftpMarshalString(nil, "/file"), 0), false)
+	assert.NoError(t, err)
+	count, reply, err := sftpUnmarshalUint32(reply)
+	assert.NoError(t, err)
+	var names []string
+	for i := uint32(0); i < count; i++ {
+		var name string
+		name, reply, err = sftpUnmarshalString(reply)
+		assert.NoError(t, err)
+		names = append(names, name)
+	}
+	assert.Contains(t, names, "user.test")
+	assert.Contains(t, names, "user.sftpgo")
+	// the attribute already exists
+	createPayload := bytes.Clone(setPayload[4+len(sftpExtSetXattr):])
+	binary.BigEndian.PutUint32(createPayload[len(createPayload)-4:], sftpXattrCreate)
+	err = extChannel.setXattr(createPayload, false)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	binary.BigEndian.PutUint32(createPayload[len(createPayload)-4:], sftpXattrReplace)
+	err = extChannel.setXattr(createPayload, false)
+	assert.NoError(t, err)
+	err = extChannel.setXattr(createPayload[:len(createPayload)-4], false)
+	assert.ErrorIs(t, err, errSFTPBadMessage)
+	attrPayload := sftpMarshalString(sftpMarshalString(nil, "/file"), "user.sftpgo")
+	err = extChannel.removeXattr(attrPayload, false)
+	assert.NoError(t, err)
+	err = extChannel.removeXattr(attrPayload, false)
+	assert.ErrorIs(t, err, vfs.ErrXattrNotFound)
+	_, err = extChannel.getXattr(binary.BigEndian.AppendUint32(attrPayload, 0), false)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	err = extChannel.setXattr(createPayload, false)
+	assert.ErrorIs(t, err, vfs.ErrXattrNotFound)
+	err = extChannel.removeXattr(sftpMarshalString(sftpMarshalString(nil, "/file"), ""), false)
+	assert.ErrorIs(t, err, errSFTPBadMessage)
+	_, err = extChannel.getXattr(attrPayload, false)
+	assert.ErrorIs(t, err, errSFTPBadMessage)
+	_, err = extChannel.getXattr(binary.BigEndian.AppendUint32(
+		sftpMarshalString(sftpMarshalString(nil, "/missing"), "user.test"), 0), false)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	// handles
+	reader, err := connection.Fileread(sftp.NewRequest("Get", "/file"))
+	assert.NoError(t, err)
+	connection.sftpTransfers.setHandle("h1")
+	defer reader.(*transfer).Close()
+	value, err := extChannel.getXattr(binary.BigEndian.AppendUint32(
+		sftpMarshalString(sftpMarshalString(nil, "h1"), "user.test"), 0), true)
+	assert.NoError(t, err)
+	assert.Equal(t, sftpMarshalString(nil, "test"), value)
+	_, err = extChannel.listXattr(binary.BigEndian.AppendUint32(sftpMarshalString(nil, "h2"), 0), true)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	// permissions
+	err = os.Mkdir(filepath.Join(homeDir, "ro"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "ro", "file"), []byte("data"), 0666)
+	assert.NoError(t, err)
+	roPayload := sftpMarshalString(sftpMarshalString(nil, "/ro/file"), "user.test")
+	err = extChannel.setXattr(append(sftpMarshalString(bytes.Clone(roPayload), "v"), 0, 0, 0, 0), false)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	err = extChannel.removeXattr(roPayload, false)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	_, err = extChannel.listXattr(binary.BigEndian.AppendUint32(sftpMarshalString(nil, "/ro/file"), 0), false)
+	assert.NoError(t, err)
+}
+
+func TestGSSAPIPrincipalMapping(t *testing.T) {
+	c := GSSAPIConfig{
+		realms: []string{"EXAMPLE.COM"},
+	}
+	username, err := c.getUsername("user1@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", username)
+	username, err = c.getUsername("host/user1@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "host/user1", username)
+	// principals from other realms require a mapping rule
+	_, err = c.getUsername("user1@OTHER.COM")
+	assert.ErrorContains(t, err, "not from the service realm")
+	_, err = c.getUsername("user1@EXAMPLE.COM@OTHER.COM")
+	assert.ErrorContains(t, err, "not from the service realm")
+	_, err = c.getUsername("user1")
+	assert.ErrorContains(t, err, "has no realm")
+
+	c.Enabled = true
+	c.Keytab = "missing keytab"
+	c.PrincipalMappings = []GSSAPIPrincipalMapping{
+		{
+			Regexp:      `^(\w+)@EXAMPLE\.COM$`,
+			Replacement: "ex_$1",
+		},
+		{
+			Regexp:      `^admin/(\w+)@EXAMPLE\.COM$`,
+			Replacement: "$1",
+		},
+		{
+			Regexp:      `@EMPTY\.COM$`,
+			Replacement: "",
+		},
+		{
+			Regexp:      `^(\w+)@TRUSTED\.COM$`,
+			Replacement: "trusted_$1",
+		},
+	}
+	err = c.initialize(configDir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.False(t, c.isEnabled())
+	username, err = c.getUsername("user1@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "ex_user1", username)
+	username, err = c.getUsername("admin/user2@EXAMPLE.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "user2", username)
+	_, err = c.getUsername("user1@OTHER.COM")
+	assert.ErrorContains(t, err, "does not match any mapping rule")
+	_, err = c.getUsername("user1@EMPTY.COM")
+	assert.ErrorContains(t, err, "empty username")
+	// mapping rules can allow other realms
+	username, err = c.getUsername("user1@TRUSTED.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "trusted_user1", username)
+
+	c.PrincipalMappings = []GSSAPIPrincipalMapping{
+		{
+			Regexp: "[",
+		},
+	}
+	err = c.initialize(configDir)
+	assert.ErrorContains(t, err, "invalid GSSAPI principal mapping")
+	c.Keytab = "."
+	err = c.initialize(configDir)
+	assert.ErrorContains(t, err, "invalid GSSAPI keytab")
+	c.Enabled = false
+	err = c.initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, c.isEnabled())
+}
+
+func TestGSSAPIServiceRealms(t *testing.T) {
+	kt := keytab.New()
+	err := kt.AddEntry("host/sftp.example.com", "EXAMPLE.COM", "password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	assert.NoError(t, err)
+	err = kt.AddEntry("host/sftp.other.com", "OTHER.COM", "password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	assert.NoError(t, err)
+
+	c := GSSAPIConfig{}
+	assert.Equal(t, []string{"EXAMPLE.COM", "OTHER.COM"}, c.getServiceRealms(kt))
+	c.ServicePrincipal = "host/sftp.other.com"
+	assert.Equal(t, []string{"OTHER.COM"}, c.getServiceRealms(kt))
+	c.ServicePrincipal = "host/sftp.example.com@EXAMPLE.COM"
+	assert.Equal(t, []string{"EXAMPLE.COM"}, c.getServiceRealms(kt))
+	c.ServicePrincipal = "host/missing"
+	assert.Len(t, c.getServiceRealms(kt), 0)
+	c.Realm = "CONFIGURED.COM"
+	assert.Equal(t, []string{"CONFIGURED.COM"}, c.getServiceRealms(kt))
+}
+
+func TestGSSAPISecurityContext(t *testing.T) {
+	realm := "EXAMPLE.COM"
+	spn := "host/sftp.example.com"
+	kt := keytab.New()
+	err := kt.AddEntry(spn, realm, "service password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	require.NoError(t, err)
+	ktData, err := kt.Marshal()
+	require.NoError(t, err)
+	keytabPath := filepath.Join(os.TempDir(), "sftpgo.keytab")
+	err = os.WriteFile(keytabPath, ktData, 0600)
+	require.NoError(t, err)
+	defer os.Remove(keytabPath)
+
+	c := GSSAPIConfig{
+		Enabled:          true,
+		Keytab:           keytabPath,
+		ServicePrincipal: spn,
+	}
+	err = c.initialize(configDir)
+	require.NoError(t, err)
+	assert.True(t, c.isEnabled())
+
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "user1")
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, realm, types.NewPrincipalName(nametype.KRB_NT_SRV_HST, spn),
+		realm, types.NewKrbFlags(), kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	cl := client.NewWithPassword("user1", realm, "user password", krb5config.New())
+	getToken := func(gssapiFlags []int) []byte {
+		krb5Token, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey, gssapiFlags, nil)
+		require.NoError(t, err)
+		token, err := krb5Token.Marshal()
+		require.NoError(t, err)
+		return token
+	}
+
+	gssapiConfig := c.getServerConfig(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2022})
+	server := gssapiConfig.Server
+	err = server.VerifyMIC([]byte("payload"), []byte("token"))
+	assert.ErrorContains(t, err, "security context not established")
+	_, _, _, err = server.AcceptSecContext([]byte("invalid token"))
+	assert.Error(t, err)
+	// no mutual authentication
+	outputToken, principal, needContinue, err := server.AcceptSecContext(getToken([]int{gssapi.ContextFlagInteg}))
+	require.NoError(t, err)
+	assert.False(t, needContinue)
+	assert.Equal(t, "user1@EXAMPLE.COM", principal)
+	assert.Len(t, outputToken, 0)
+	micToken, err := gssapi.NewInitiatorMICToken([]byte("payload"), sessionKey)
+	require.NoError(t, err)
+	micBytes, err := micToken.Marshal()
+	require.NoError(t, err)
+	err = server.VerifyMIC([]byte("payload"), micBytes)
+	assert.NoError(t, err)
+	err = server.VerifyMIC([]byte("modified payload"), micBytes)
+	assert.Error(t, err)
+	err = server.VerifyMIC([]byte("payload"), []byte("invalid token"))
+	assert.Error(t, err)
+	// the same AP_REQ cannot be reused
+	token := getToken([]int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual})
+	outputToken, principal, _, err = server.AcceptSecContext(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user1@EXAMPLE.COM", principal)
+	var apRepToken spnego.KRB5Token
+	err = apRepToken.Unmarshal(outputToken)
+	require.NoError(t, err)
+	require.True(t, apRepToken.IsAPRep())
+	encPart, err := krb5crypto.DecryptEncPart(apRepToken.APRep.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	require.NoError(t, err)
+	var apRepPart messages.EncAPRepPart
+	err = apRepPart.Unmarshal(encPart)
+	require.NoError(t, err)
+	_, _, _, err = server.AcceptSecContext(token)
+	assert.ErrorContains(t, err, "replay")
+	err = server.DeleteSecContext()
+	assert.NoError(t, err)
+	err = server.VerifyMIC([]byte("payload"), micBytes)
+	assert.Error(t, err)
+	// the ticket is valid but the client is from a foreign realm
+	foreignTkt, foreignKey, err := messages.NewTicket(cname, "OTHER.COM", types.NewPrincipalName(nametype.KRB_NT_SRV_HST, spn),
+		realm, types.NewKrbFlags(), kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	foreignToken, err := spnego.NewKRB5TokenAPREQ(client.NewWithPassword("user1", "OTHER.COM", "user password",
+		krb5config.New()), foreignTkt, foreignKey, []int{gssapi.ContextFlagInteg}, nil)
+	require.NoError(t, err)
+	token, err = foreignToken.Marshal()
+	require.NoError(t, err)
+	_, principal, _, err = server.AcceptSecContext(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user1@OTHER.COM", principal)
+	_, err = c.getUsername(principal)
+	assert.ErrorContains(t, err, "not from the service realm")
+	// wrong service principal
+	c.ServicePrincipal = "host/other.example.com"
+	server = c.getServerConfig(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2022}).Server
+	_, _, _, err = server.AcceptSecContext(getToken(nil))
+	assert.Error(t, err)
+}
+
+func TestReloadBindings(t *testing.T) {
+	registry := runningBindings.Load()
+	bindings := serviceStatus.Bindings
+	defer func() {
+		runningBindings.Store(registry)
+		serviceStatus.Bindings = bindings
+	}()
+
+	runningBindings.Store(nil)
+	c := Configuration{
+		Bindings: []Binding{
+			{
+				Address: "127.0.0.1",
+				Port:    2037,
+			},
+		},
+	}
+	err := c.ReloadBindings()
+	assert.Error(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("unable to authenticate")
+		},
+	}
+	runningBindings.Store(common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		return c.listenAndServe(b, l, serverConfig)
+	}))
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	address := c.Bindings[0].GetAddress()
+	var conn net.Conn
+	assert.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", address)
+		return err == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	require.NotNil(t, conn)
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	assert.NoError(t, err)
+	banner := make([]byte, 8)
+	_, err = io.ReadFull(conn, banner)
+	assert.NoError(t, err)
+	assert.Equal(t, "SSH-2.0-", string(banner))
+	// invalid bindings are ignored
+	c.Bindings = append(c.Bindings, Binding{})
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	assert.Equal(t, 1, runningBindings.Load().Len())
+
+	c.Bindings = nil
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 0)
+	assert.Equal(t, 0, runningBindings.Load().Len())
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
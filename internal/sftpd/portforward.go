@@ -0,0 +1,422 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	portForwardingDialTimeout = 15 * time.Second
+	channelTypeDirectTCPIP    = "direct-tcpip"
+	channelTypeForwardedTCPIP = "forwarded-tcpip"
+	requestTypeTCPIPForward   = "tcpip-forward"
+	requestTypeCancelForward  = "cancel-tcpip-forward"
+)
+
+var errMaxForwardsReached = errors.New("too many forwarded connections")
+
+// RFC 4254, section 7.2
+type directTCPIPPayload struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// RFC 4254, section 7.1
+type tcpipForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type tcpipForwardReply struct {
+	BindPort uint32
+}
+
+// RFC 4254, section 7.2
+type forwardedTCPIPPayload struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// portForwarder handles local and remote port forwarding for an SSH connection
+// according to the port forwarding policy configured for the user
+type portForwarder struct {
+	connectionID  string
+	username      string
+	policy        dataprovider.PortForwardingPolicy
+	sshConn       ssh.Conn
+	sshConnection *common.SSHConnection
+	mu            sync.Mutex
+	active        int
+	listeners     map[string]net.Listener
+	conns         map[net.Conn]bool
+	closed        bool
+}
+
+func newPortForwarder(connectionID string, user *dataprovider.User, sshConn ssh.Conn,
+	sshConnection *common.SSHConnection,
+) *portForwarder {
+	return &portForwarder{
+		connectionID:  connectionID,
+		username:      user.Username,
+		policy:        user.Filters.PortForwarding,
+		sshConn:       sshConn,
+		sshConnection: sshConnection,
+		listeners:     make(map[string]net.Listener),
+		conns:         make(map[net.Conn]bool),
+	}
+}
+
+func (f *portForwarder) log(level logger.LogLevel, format string, v ...any) {
+	logger.Log(level, common.ProtocolSSH, f.connectionID, format, v...)
+}
+
+// acquire reserves a slot for a forwarded connection or a listener
+func (f *portForwarder) acquire() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return net.ErrClosed
+	}
+	if f.policy.MaxForwards > 0 && f.active >= f.policy.MaxForwards {
+		return errMaxForwardsReached
+	}
+	f.active++
+	return nil
+}
+
+func (f *portForwarder) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active--
+}
+
+// handleGlobalRequests serves the remote port forwarding requests and
+// rejects any other global request
+func (f *portForwarder) handleGlobalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		var ok bool
+		var reply []byte
+
+		switch req.Type {
+		case requestTypeTCPIPForward:
+			ok, reply = f.handleTCPIPForward(req.Payload)
+		case requestTypeCancelForward:
+			ok = f.handleCancelTCPIPForward(req.Payload)
+		}
+		if req.WantReply {
+			req.Reply(ok, reply) //nolint:errcheck
+		}
+	}
+}
+
+// handleDirectTCPIP serves a local port forwarding request
+func (f *portForwarder) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload directTCPIPPayload
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload") //nolint:errcheck
+		return
+	}
+	if !f.policy.AllowLocal {
+		f.log(logger.LevelInfo, "local port forwarding to %q is not allowed for user %q",
+			net.JoinHostPort(payload.DestAddr, strconv.FormatUint(uint64(payload.DestPort), 10)), f.username)
+		newChannel.Reject(ssh.Prohibited, "port forwarding is disabled") //nolint:errcheck
+		return
+	}
+	ip, err := f.resolveDestination(payload.DestAddr, payload.DestPort)
+	dest := net.JoinHostPort(payload.DestAddr, strconv.FormatUint(uint64(payload.DestPort), 10))
+	if err != nil {
+		f.log(logger.LevelInfo, "local port forwarding to %q denied for user %q: %v", dest, f.username, err)
+		newChannel.Reject(ssh.Prohibited, "destination not allowed") //nolint:errcheck
+		return
+	}
+	if err := f.acquire(); err != nil {
+		f.log(logger.LevelInfo, "local port forwarding to %q denied for user %q: %v", dest, f.username, err)
+		newChannel.Reject(ssh.ResourceShortage, err.Error()) //nolint:errcheck
+		return
+	}
+	channelID, err := f.sshConnection.AddChannel(common.SSHChannelTypeForwarding, dest)
+	if err != nil {
+		f.release()
+		f.log(logger.LevelInfo, "local port forwarding to %q denied for user %q: %v", dest, f.username, err)
+		newChannel.Reject(ssh.ResourceShortage, err.Error()) //nolint:errcheck
+		return
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(payload.DestPort), 10)),
+		portForwardingDialTimeout)
+	if err != nil {
+		f.release()
+		f.sshConnection.RemoveChannel(channelID)
+		f.log(logger.LevelDebug, "unable to connect to %q: %v", dest, err)
+		newChannel.Reject(ssh.ConnectionFailed, "unable to connect to the destination") //nolint:errcheck
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		f.release()
+		f.sshConnection.RemoveChannel(channelID)
+		conn.Close()
+		f.log(logger.LevelWarn, "could not accept a %s channel: %v", channelTypeDirectTCPIP, err)
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	f.log(logger.LevelDebug, "local port forwarding started, destination %q, originator %q", dest,
+		net.JoinHostPort(payload.OriginAddr, strconv.FormatUint(uint64(payload.OriginPort), 10)))
+	go func() {
+		defer f.release()
+		defer f.sshConnection.RemoveChannel(channelID)
+
+		f.forward(channel, conn)
+		f.log(logger.LevelDebug, "local port forwarding to %q ended", dest)
+	}()
+}
+
+// resolveDestination returns the IP address to connect to, the address
+// is checked against the policy to avoid DNS rebinding
+func (f *portForwarder) resolveDestination(host string, port uint32) (net.IP, error) {
+	if port == 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if f.policy.IsOpenAllowed(host, ip, port) {
+			return ip, nil
+		}
+		return nil, errors.New("destination not allowed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), portForwardingDialTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if f.policy.IsOpenAllowed(host, addr.IP, port) {
+			return addr.IP, nil
+		}
+	}
+	return nil, errors.New("destination not allowed")
+}
+
+func (f *portForwarder) handleTCPIPForward(data []byte) (bool, []byte) {
+	var payload tcpipForwardPayload
+	if err := ssh.Unmarshal(data, &payload); err != nil || payload.BindPort > 65535 {
+		return false, nil
+	}
+	addr := net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(payload.BindPort), 10))
+	if !f.policy.IsListenAllowed(payload.BindAddr, payload.BindPort) {
+		f.log(logger.LevelInfo, "remote port forwarding on %q denied for user %q", addr, f.username)
+		return false, nil
+	}
+	if err := f.acquire(); err != nil {
+		f.log(logger.LevelInfo, "remote port forwarding on %q denied for user %q: %v", addr, f.username, err)
+		return false, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		f.release()
+		f.log(logger.LevelInfo, "unable to listen on %q for remote port forwarding: %v", addr, err)
+		return false, nil
+	}
+	bindPort := payload.BindPort
+	if bindPort == 0 {
+		bindPort = uint32(listener.Addr().(*net.TCPAddr).Port)
+	}
+	key := net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(bindPort), 10))
+
+	f.mu.Lock()
+	if _, ok := f.listeners[key]; ok || f.closed {
+		f.mu.Unlock()
+		f.release()
+		listener.Close()
+		return false, nil
+	}
+	f.listeners[key] = listener
+	f.mu.Unlock()
+
+	f.log(logger.LevelDebug, "remote port forwarding started, listening on %q", listener.Addr())
+	go f.serveListener(listener, payload.BindAddr, bindPort)
+
+	if payload.BindPort == 0 {
+		return true, ssh.Marshal(&tcpipForwardReply{BindPort: bindPort})
+	}
+	return true, nil
+}
+
+func (f *portForwarder) handleCancelTCPIPForward(data []byte) bool {
+	var payload tcpipForwardPayload
+	if err := ssh.Unmarshal(data, &payload); err != nil {
+		return false
+	}
+	key := net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(payload.BindPort), 10))
+
+	f.mu.Lock()
+	listener, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func (f *portForwarder) serveListener(listener net.Listener, bindAddr string, bindPort uint32) {
+	defer f.release()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			f.log(logger.LevelDebug, "remote port forwarding on %q ended: %v", listener.Addr(), err)
+			return
+		}
+		if err := f.acquire(); err != nil {
+			f.log(logger.LevelInfo, "remote port forwarding connection from %q rejected: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go f.forwardConnection(conn, bindAddr, bindPort)
+	}
+}
+
+func (f *portForwarder) forwardConnection(conn net.Conn, bindAddr string, bindPort uint32) {
+	defer f.release()
+
+	payload := forwardedTCPIPPayload{
+		Addr: bindAddr,
+		Port: bindPort,
+	}
+	if remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		payload.OriginAddr = remoteAddr.IP.String()
+		payload.OriginPort = uint32(remoteAddr.Port)
+	}
+	channel, requests, err := f.sshConn.OpenChannel(channelTypeForwardedTCPIP, ssh.Marshal(&payload))
+	if err != nil {
+		f.log(logger.LevelDebug, "unable to open a %s channel: %v", channelTypeForwardedTCPIP, err)
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	f.forward(channel, conn)
+}
+
+// forward copies data between the SSH channel and the network connection
+// until both directions are closed
+func (f *portForwarder) forward(channel ssh.Channel, conn net.Conn) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		channel.Close()
+		conn.Close()
+		return
+	}
+	f.conns[conn] = true
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		f.copy(conn, channel) //nolint:errcheck
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite() //nolint:errcheck
+		} else {
+			conn.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+
+		f.copy(channel, conn) //nolint:errcheck
+		channel.CloseWrite()  //nolint:errcheck
+	}()
+
+	wg.Wait()
+	channel.Close()
+	conn.Close()
+}
+
+// copy is like io.Copy but it updates the last activity for the SSH connection,
+// this way the forwarded connections are not closed as idle
+func (f *portForwarder) copy(dst io.Writer, src io.Reader) (int64, error) {
+	bufPtr := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(bufPtr)
+
+	buf := *bufPtr
+	var written int64
+	for {
+		n, errRead := src.Read(buf)
+		if n > 0 {
+			nw, errWrite := dst.Write(buf[:n])
+			written += int64(nw)
+			if errWrite != nil {
+				return written, errWrite
+			}
+			f.sshConnection.UpdateLastActivity()
+		}
+		if errRead != nil {
+			if errors.Is(errRead, io.EOF) {
+				return written, nil
+			}
+			return written, errRead
+		}
+	}
+}
+
+// close stops the listeners for remote port forwarding and closes the
+// forwarded connections
+func (f *portForwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for key, listener := range f.listeners {
+		listener.Close()
+		delete(f.listeners, key)
+	}
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
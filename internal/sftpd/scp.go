@@ -18,13 +18,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
@@ -41,6 +42,10 @@ var (
 
 type scpCommand struct {
 	sshCommand
+	// access and modification times sent by the client, using the T protocol
+	// message, for the next file to upload
+	uploadATime time.Time
+	uploadMTime time.Time
 }
 
 func (c *scpCommand) handle() (err error) {
@@ -75,7 +80,7 @@ func (c *scpCommand) handle() (err error) {
 		if err != nil {
 			return err
 		}
-		err = c.handleDownload(destPath)
+		err = c.handleGlobDownload(destPath)
 		if err != nil {
 			return err
 		}
@@ -186,30 +191,16 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *transfer) err
 	}
 
 	if sizeToRead > 0 {
-		// we could replace this method with io.CopyN implementing "Write" method in transfer struct
-		remaining := sizeToRead
-		buf := make([]byte, int64(math.Min(32768, float64(sizeToRead))))
-		for {
-			n, err := c.connection.channel.Read(buf)
-			if err != nil {
+		w := &transferWriter{t: transfer}
+		_, err = copyN(w, c.connection.channel, sizeToRead)
+		if err != nil {
+			if w.err == nil {
+				// write errors are already set in the transfer
 				transfer.TransferError(err)
-				transfer.Close()
-				c.sendErrorMessage(transfer.Fs, err)
-				return err
-			}
-			_, err = transfer.WriteAt(buf[:n], sizeToRead-remaining)
-			if err != nil {
-				transfer.Close()
-				c.sendErrorMessage(transfer.Fs, err)
-				return err
-			}
-			remaining -= int64(n)
-			if remaining <= 0 {
-				break
-			}
-			if remaining < int64(len(buf)) {
-				buf = make([]byte, remaining)
 			}
+			transfer.Close()
+			c.sendErrorMessage(transfer.Fs, err)
+			return err
 		}
 	}
 	err = c.readConfirmationMessage()
@@ -244,8 +235,16 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 	}
 
 	maxWriteSize, _ := c.connection.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+	if !isNewFile {
+		if err := c.connection.CreateFileVersion(fs, filePath, requestPath, fileSize); err != nil {
+			c.sendErrorMessage(fs, err)
+			return err
+		}
+	}
 
+	startTime := time.Now()
 	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.connection.GetCreateChecks(requestPath, isNewFile, false))
+	c.connection.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "error creating file %q: %v", resolvedPath, err)
 		c.sendErrorMessage(fs, err)
@@ -256,6 +255,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 	truncatedSize := int64(0) // bytes truncated and not included in quota
 	if !isNewFile {
 		if vfs.HasTruncateSupport(fs) {
+			c.connection.UpdateDirQuotaUsage(requestPath, 0, -fileSize)
 			vfolder, err := c.connection.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
 				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -278,6 +278,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	c.setUploadTimes(baseTransfer, resolvedPath, requestPath)
 	t := newTransfer(baseTransfer, w, nil, nil)
 
 	return c.getUploadFileData(sizeToRead, t)
@@ -464,7 +465,6 @@ func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.Fi
 	}
 
 	fileSize := stat.Size()
-	readed := int64(0)
 	fileMode := fmt.Sprintf("C%v %v %v\n", getFileModeAsString(stat.Mode(), stat.IsDir()), fileSize, filepath.Base(filePath))
 	err = c.sendProtocolMessage(fileMode)
 	if err != nil {
@@ -475,22 +475,8 @@ func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.Fi
 		return err
 	}
 
-	// we could replace this method with io.CopyN implementing "Read" method in transfer struct
-	buf := make([]byte, 32768)
-	var n int
-	for {
-		n, err = transfer.ReadAt(buf, readed)
-		if err == nil || err == io.EOF {
-			if n > 0 {
-				_, err = c.connection.channel.Write(buf[:n])
-			}
-		}
-		readed += int64(n)
-		if err != nil {
-			break
-		}
-	}
-	if err != io.EOF {
+	_, err = copyBuffer(c.connection.channel, &transferReader{t: transfer})
+	if err != nil {
 		c.sendErrorMessage(fs, err)
 		return err
 	}
@@ -554,7 +540,9 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		return common.ErrPermissionDenied
 	}
 
+	startTime := time.Now()
 	file, r, cancelFn, err := fs.Open(p, 0)
+	c.connection.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "could not open file %q for reading: %v", p, err)
 		c.sendErrorMessage(fs, err)
@@ -577,6 +565,73 @@ func (c *scpCommand) handleDownload(filePath string) error {
 	return err
 }
 
+// handleGlobDownload expands the wildcards, if any, in the last element of
+// the requested path, as a shell would do, and sends the matching files
+func (c *scpCommand) handleGlobDownload(filePath string) error {
+	paths, err := c.getDownloadPaths(filePath)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to expand download path %q: %v", filePath, err)
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+	for _, p := range paths {
+		if err := c.handleDownload(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *scpCommand) getDownloadPaths(filePath string) ([]string, error) {
+	pattern := path.Base(filePath)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{filePath}, nil
+	}
+	// a file whose name contains wildcards is sent as is
+	if _, err := c.connection.DoStat(filePath, 0, false); err == nil {
+		return []string{filePath}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	dirPath := path.Dir(filePath)
+	lister, err := c.connection.ListDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer lister.Close()
+
+	var paths []string
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return nil, err
+		}
+		for _, file := range files {
+			// hidden files must be explicitly matched
+			if strings.HasPrefix(file.Name(), ".") && !strings.HasPrefix(pattern, ".") {
+				continue
+			}
+			if file.IsDir() && !c.isRecursive() {
+				continue
+			}
+			if matched, _ := path.Match(pattern, file.Name()); matched {
+				paths = append(paths, path.Join(dirPath, file.Name()))
+			}
+		}
+		if finished {
+			break
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%q: no matches found", filePath)
+	}
+	sort.Strings(paths)
+	c.connection.Log(logger.LevelDebug, "download path %q expanded to %d paths", filePath, len(paths))
+	return paths, nil
+}
+
 func (c *scpCommand) sendFileTime() bool {
 	return c.hasFlag("p")
 }
@@ -683,16 +738,37 @@ func (c *scpCommand) sendProtocolMessage(message string) error {
 	return err
 }
 
-// get the next upload protocol message ignoring T command if any
+// setUploadTimes sets the times received from the client, they will be applied
+// after the upload completes
+func (c *scpCommand) setUploadTimes(t *common.BaseTransfer, resolvedPath, requestPath string) {
+	if c.uploadMTime.IsZero() || common.Config.SetstatMode == 1 {
+		return
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermChtimes, requestPath) {
+		c.connection.Log(logger.LevelDebug, "ignoring times for file %q, permission denied", requestPath)
+		return
+	}
+	t.SetTimes(resolvedPath, c.uploadATime, c.uploadMTime)
+}
+
+// get the next upload protocol message, the times sent using the T command,
+// if any, are stored and apply to the returned message
 func (c *scpCommand) getNextUploadProtocolMessage() (string, error) {
 	var command string
 	var err error
+	c.uploadATime = time.Time{}
+	c.uploadMTime = time.Time{}
 	for {
 		command, err = c.readProtocolMessage()
 		if err != nil {
 			return command, err
 		}
 		if strings.HasPrefix(command, "T") {
+			c.uploadATime, c.uploadMTime, err = parseTimeMessage(command)
+			if err != nil {
+				c.connection.Log(logger.LevelError, "error parsing time message %q: %v", command, err)
+				return command, err
+			}
 			err = c.sendConfirmationMessage()
 			if err != nil {
 				return command, err
@@ -781,6 +857,25 @@ func (c *scpCommand) getFileUploadDestPath(fs vfs.Fs, scpDestPath, fileName stri
 	return path.Join(scpDestPath, fileName)
 }
 
+// parseTimeMessage parses a message in the format "T<mtime> 0 <atime> 0",
+// the times are seconds since the epoch
+func parseTimeMessage(command string) (time.Time, time.Time, error) {
+	parts := strings.Fields(strings.TrimPrefix(command, "T"))
+	if len(parts) != 4 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time message: %q", command)
+	}
+	var values [4]int64
+	for idx, part := range parts {
+		val, err := strconv.ParseInt(part, 10, 64)
+		// odd fields are microseconds
+		if err != nil || val < 0 || (idx%2 == 1 && val > 999999) {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid time message: %q", command)
+		}
+		values[idx] = val
+	}
+	return time.Unix(values[2], values[3]*1000), time.Unix(values[0], values[1]*1000), nil
+}
+
 func getFileModeAsString(fileMode os.FileMode, isDir bool) string {
 	var defaultMode string
 	if isDir {
@@ -28,6 +28,7 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -76,6 +77,8 @@ var (
 	revokedCertManager = revokedCertificates{
 		certs: map[string]bool{},
 	}
+	hostKeysMgr     hostKeysManager
+	runningBindings atomic.Pointer[common.BindingsRegistry[Binding]]
 
 	sftpAuthError = newAuthenticationError(nil, "", "")
 )
@@ -113,6 +116,9 @@ type Configuration struct {
 	// If set to a negative number, the number of attempts is unlimited.
 	// If set to zero, the number of attempts are limited to 6.
 	MaxAuthTries int `json:"max_auth_tries" mapstructure:"max_auth_tries"`
+	// Maximum number of concurrent channels, sessions and port forwardings,
+	// per SSH connection. 0 means no limit
+	MaxChannelsPerConnection int `json:"max_channels_per_connection" mapstructure:"max_channels_per_connection"`
 	// HostKeys define the daemon's private host keys.
 	// Each host key can be defined as a path relative to the configuration directory or an absolute one.
 	// If empty or missing, the daemon will search or try to generate "id_rsa" and "id_ecdsa" host keys
@@ -148,6 +154,15 @@ type Configuration struct {
 	// Example content:
 	// ["SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es","SHA256:119+8cL/HH+NLMawRsJx6CzPF1I3xC+jpM60bQHXGE8"]
 	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
+	// Path to the private key of a certificate authority used to sign short-lived
+	// SSH user certificates for SFTPGo users using the REST API or the WebClient.
+	// The path can be absolute or relative to the configuration directory.
+	// The CA public key is automatically trusted for user authentication.
+	// Leave empty to disable certificates issuance
+	UserCAKey string `json:"user_ca_key" mapstructure:"user_ca_key"`
+	// Validity of the issued user certificates as minutes.
+	// 0 means the default: 60 minutes
+	UserCertValidity int `json:"user_cert_validity" mapstructure:"user_cert_validity"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -181,8 +196,10 @@ type Configuration struct {
 	KeyboardInteractiveHook string `json:"keyboard_interactive_auth_hook" mapstructure:"keyboard_interactive_auth_hook"`
 	// PasswordAuthentication specifies whether password authentication is allowed.
 	PasswordAuthentication bool `json:"password_authentication" mapstructure:"password_authentication"`
-	certChecker            *ssh.CertChecker
-	parsedUserCAKeys       []ssh.PublicKey
+	// GSSAPI defines the configuration for Kerberos authentication using "gssapi-with-mic"
+	GSSAPI           GSSAPIConfig `json:"gssapi" mapstructure:"gssapi"`
+	certChecker      *ssh.CertChecker
+	parsedUserCAKeys []ssh.PublicKey
 }
 
 type authenticationError struct {
@@ -334,19 +351,31 @@ func (c *Configuration) Initialize(configDir string) error {
 	if err := c.configureSecurityOptions(serverConfig); err != nil {
 		return err
 	}
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
+	if err := c.checkAndLoadHostKeys(configDir); err != nil {
 		serviceStatus.HostKeys = nil
 		return err
 	}
 	if err := c.initializeCertChecker(configDir); err != nil {
 		return err
 	}
+	if err := c.GSSAPI.initialize(configDir); err != nil {
+		return err
+	}
+	if c.GSSAPI.isEnabled() {
+		serviceStatus.Authentications = append(serviceStatus.Authentications, dataprovider.SSHLoginMethodGSSAPI)
+	}
 	c.configureKeyboardInteractiveAuth(serverConfig)
 	c.configureLoginBanner(serverConfig, configDir)
 	c.checkSSHCommands()
 
 	exitChannel := make(chan error, 1)
 	serviceStatus.Bindings = nil
+	registry := common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		return c.listenAndServe(b, l, serverConfig)
+	})
+	runningBindings.Store(registry)
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
@@ -355,26 +384,11 @@ func (c *Configuration) Initialize(configDir string) error {
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
 
 		go func(binding Binding) {
-			addr := binding.GetAddress()
-			util.CheckTCP4Port(binding.Port)
-			listener, err := net.Listen("tcp", addr)
-			if err != nil {
-				logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
-				exitChannel <- err
+			err := registry.Serve(binding)
+			if errors.Is(err, common.ErrBindingRemoved) {
 				return
 			}
-
-			if binding.ApplyProxyConfig && common.Config.ProxyProtocol > 0 {
-				proxyListener, err := common.Config.GetProxyListener(listener)
-				if err != nil {
-					logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-					exitChannel <- err
-					return
-				}
-				listener = proxyListener
-			}
-
-			exitChannel <- c.serve(listener, serverConfig)
+			exitChannel <- err
 		}(binding)
 	}
 
@@ -385,6 +399,50 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
+func (c *Configuration) listenAndServe(binding Binding, l *common.BindingListener, serverConfig *ssh.ServerConfig) error {
+	addr := binding.GetAddress()
+	util.CheckTCP4Port(binding.Port)
+	listener, err := util.Listen("tcp", addr)
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
+		return err
+	}
+
+	if binding.ApplyProxyConfig && common.Config.ProxyProtocol > 0 {
+		proxyListener, err := common.Config.GetProxyListener(listener)
+		if err != nil {
+			listener.Close()
+			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+			return err
+		}
+		listener = proxyListener
+	}
+	if err := l.SetStopFunc(listener.Close); err != nil {
+		return err
+	}
+
+	return c.serve(listener, serverConfig)
+}
+
+// ReloadBindings applies the configured bindings to the running SFTP server.
+// New bindings are started and removed bindings stop accepting new connections,
+// the active connections are not affected. Changing the settings of an existing
+// binding requires a restart
+func (c *Configuration) ReloadBindings() error {
+	registry := runningBindings.Load()
+	if registry == nil {
+		return errors.New("the SFTP server is not running")
+	}
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			bindings = append(bindings, binding)
+		}
+	}
+	serviceStatus.Bindings = registry.Reload(bindings, nil)
+	return nil
+}
+
 func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
 	logger.Info(logSender, "", "server listener registered, address: %s", listener.Addr().String())
 	var tempDelay time.Duration // how long to sleep on accept failure
@@ -411,7 +469,11 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 		}
 		tempDelay = 0
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		config := hostKeysMgr.getServerConfig(serverConfig)
+		if c.GSSAPI.isEnabled() {
+			config.GSSAPIWithMICConfig = c.GSSAPI.getServerConfig(conn.RemoteAddr())
+		}
+		go c.AcceptInboundConnection(conn, config)
 	}
 }
 
@@ -589,7 +651,6 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	}
 	// handshake completed so remove the deadline, we'll use IdleTimeout configuration from now on
 	conn.SetDeadline(time.Time{}) //nolint:errcheck
-	go ssh.DiscardRequests(reqs)
 
 	defer conn.Close()
 
@@ -604,6 +665,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	defer user.CloseFs() //nolint:errcheck
 	if err = user.CheckFsRoot(connectionID); err != nil {
 		logger.Warn(logSender, connectionID, "unable to check fs root for user %q: %v", user.Username, err)
+		go ssh.DiscardRequests(reqs)
 		go discardAllChannels(chans, "invalid root fs", connectionID)
 		return
 	}
@@ -615,12 +677,22 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	dataprovider.UpdateLastLogin(&user)
 
 	sshConnection := common.NewSSHConnection(connectionID, conn)
+	sshConnection.SetMaxChannels(c.MaxChannelsPerConnection)
 	common.Connections.AddSSHConnection(sshConnection)
 
 	defer common.Connections.RemoveSSHConnection(connectionID)
 
-	channelCounter := int64(0)
+	forwarder := newPortForwarder(connectionID, &user, sconn, sshConnection)
+	defer forwarder.close()
+
+	go forwarder.handleGlobalRequests(reqs)
+
 	for newChannel := range chans {
+		if newChannel.ChannelType() == channelTypeDirectTCPIP {
+			sshConnection.UpdateLastActivity()
+			go forwarder.handleDirectTCPIP(newChannel)
+			continue
+		}
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
@@ -630,17 +702,27 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 			continue
 		}
 
+		channelID, err := sshConnection.AddChannel(common.SSHChannelTypeSession, "")
+		if err != nil {
+			logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID, "session channel rejected for user %q: %v",
+				user.Username, err)
+			newChannel.Reject(ssh.ResourceShortage, err.Error()) //nolint:errcheck
+			continue
+		}
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
+			sshConnection.RemoveChannel(channelID)
 			logger.Log(logger.LevelWarn, common.ProtocolSSH, connectionID, "could not accept a channel: %v", err)
 			continue
 		}
 
-		channelCounter++
 		sshConnection.UpdateLastActivity()
 		// Channels have a type that is dependent on the protocol. For SFTP this is "subsystem"
 		// with a payload that (should) be "sftp". Discard anything else we receive ("pty", "shell", etc)
 		go func(in <-chan *ssh.Request, counter int64) {
+			// the requests channel is closed when the SSH channel is closed
+			defer sshConnection.RemoveChannel(counter)
+
 			for req := range in {
 				ok := false
 				connID := fmt.Sprintf("%s_%d", connectionID, counter)
@@ -657,6 +739,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 							LocalAddr:     conn.LocalAddr(),
 							channel:       channel,
 						}
+						sshConnection.UpdateChannel(counter, common.SSHChannelTypeSFTP, "")
 						go c.handleSftpConnection(channel, connection)
 					}
 				case "exec":
@@ -670,12 +753,19 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						channel:       channel,
 					}
 					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+					if ok {
+						channelType := common.SSHChannelTypeCommand
+						if connection.GetProtocol() == common.ProtocolSCP {
+							channelType = common.SSHChannelTypeSCP
+						}
+						sshConnection.UpdateChannel(counter, channelType, connection.command)
+					}
 				}
 				if req.WantReply {
 					req.Reply(ok, nil) //nolint:errcheck
 				}
 			}
-		}(requests, channelCounter)
+		}(requests, channelID)
 	}
 }
 
@@ -693,7 +783,8 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	defer common.Connections.Remove(connection.GetID())
 
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, c.createHandlers(connection), sftp.WithRSAllocator(),
+	server := sftp.NewRequestServer(newSFTPExtensionsChannel(channel, connection, connection.User.Filters.StartDirectory),
+		c.createHandlers(connection), sftp.WithRSAllocator(),
 		sftp.WithStartDirectory(connection.User.Filters.StartDirectory))
 
 	defer server.Close()
@@ -802,17 +893,22 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 			user.Username, loginMethod)
 		return nil, fmt.Errorf("login method %q is not allowed for user %q", loginMethod, user.Username)
 	}
-	if user.MustSetSecondFactorForProtocol(common.ProtocolSSH) {
+	remoteAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if user.MustSetSecondFactorForProtocol(common.ProtocolSSH) &&
+		!user.IsSecondFactorExempted(common.ProtocolSSH, remoteAddr) {
 		logger.Info(logSender, connectionID, "cannot login user %q, second factor authentication is not set",
 			user.Username)
 		return nil, fmt.Errorf("second factor authentication is not set for user %q", user.Username)
 	}
-	remoteAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
 		logger.Info(logSender, connectionID, "cannot login user %q, remote address is not allowed: %v",
 			user.Username, remoteAddr)
 		return nil, fmt.Errorf("login for user %q is not allowed from this address: %v", user.Username, remoteAddr)
 	}
+	if err := checkNegotiatedAlgorithms(user, conn); err != nil {
+		logger.Info(logSender, connectionID, "cannot login user %q: %v", user.Username, err)
+		return nil, err
+	}
 
 	json, err := json.Marshal(user)
 	if err != nil {
@@ -829,6 +925,32 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 	return p, nil
 }
 
+// checkNegotiatedAlgorithms checks the algorithms negotiated during the
+// handshake against the user's SSH algorithms policy
+func checkNegotiatedAlgorithms(user *dataprovider.User, conn ssh.ConnMetadata) error {
+	policy := &user.Filters.SSHAlgorithms
+	if !policy.IsSet() {
+		return nil
+	}
+	algoConn, ok := conn.(ssh.AlgorithmsConnMetadata)
+	if !ok {
+		return nil
+	}
+	algos := algoConn.Algorithms()
+	if !policy.IsKexAllowed(algos.KeyExchange) {
+		return fmt.Errorf("key exchange algorithm %q is not allowed for user %q", algos.KeyExchange, user.Username)
+	}
+	for _, dirAlgos := range []ssh.DirectionAlgorithms{algos.Read, algos.Write} {
+		if !policy.IsCipherAllowed(dirAlgos.Cipher) {
+			return fmt.Errorf("cipher %q is not allowed for user %q", dirAlgos.Cipher, user.Username)
+		}
+		if !policy.IsMACAllowed(dirAlgos.MAC) {
+			return fmt.Errorf("MAC algorithm %q is not allowed for user %q", dirAlgos.MAC, user.Username)
+		}
+	}
+	return nil
+}
+
 func (c *Configuration) checkSSHCommands() {
 	if util.Contains(c.EnabledSSHCommands, "*") {
 		c.EnabledSSHCommands = GetSupportedSSHCommands()
@@ -935,15 +1057,26 @@ func (c *Configuration) getHostKeyAlgorithms(keyFormat string) []string {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+// The loaded host keys are used for the new connections
+func (c *Configuration) checkAndLoadHostKeys(configDir string) error {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
 		return err
 	}
-	hostCertificates, err := c.loadHostCertificates(configDir)
+	signers, keys, err := c.loadHostKeys(configDir)
 	if err != nil {
 		return err
 	}
-	serviceStatus.HostKeys = nil
+	hostKeysMgr.set(c, configDir, signers, keys)
+	return nil
+}
+
+func (c *Configuration) loadHostKeys(configDir string) ([]ssh.Signer, []HostKey, error) {
+	hostCertificates, err := c.loadHostCertificates(configDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var signers []ssh.Signer
+	var keys []HostKey
 	for _, hostKey := range c.HostKeys {
 		hostKey = strings.TrimSpace(hostKey)
 		if !util.IsFileInputValid(hostKey) {
@@ -958,12 +1091,12 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 
 		privateBytes, err := os.ReadFile(hostKey)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		private, err := ssh.ParsePrivateKey(privateBytes)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		k := HostKey{
 			Path:        hostKey,
@@ -972,14 +1105,14 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		}
 		mas, err := ssh.NewSignerWithAlgorithms(private.(ssh.AlgorithmSigner), k.Algorithms)
 		if err != nil {
-			return fmt.Errorf("could not create signer for key %q with algorithms %+v: %w", k.Path, k.Algorithms, err)
+			return nil, nil, fmt.Errorf("could not create signer for key %q with algorithms %+v: %w", k.Path,
+				k.Algorithms, err)
 		}
-		serviceStatus.HostKeys = append(serviceStatus.HostKeys, k)
+		keys = append(keys, k)
 		logger.Info(logSender, "", "Host key %q loaded, type %q, fingerprint %q, algorithms %+v", hostKey,
 			private.PublicKey().Type(), k.Fingerprint, k.Algorithms)
 
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(mas)
+		signers = append(signers, mas)
 		for _, cert := range hostCertificates {
 			signer, err := ssh.NewCertSigner(cert.Certificate, mas)
 			if err == nil {
@@ -991,24 +1124,18 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 						}
 					}
 				}
-				serviceStatus.HostKeys = append(serviceStatus.HostKeys, HostKey{
+				keys = append(keys, HostKey{
 					Path:        cert.Path,
 					Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
 					Algorithms:  algos,
 				})
-				serverConfig.AddHostKey(signer)
+				signers = append(signers, signer)
 				logger.Info(logSender, "", "Host certificate loaded for host key %q, fingerprint %q, algorithms %+v",
 					hostKey, ssh.FingerprintSHA256(signer.PublicKey()), algos)
 			}
 		}
 	}
-	var fp []string
-	for idx := range serviceStatus.HostKeys {
-		h := &serviceStatus.HostKeys[idx]
-		fp = append(fp, h.Fingerprint)
-	}
-	vfs.SetSFTPFingerprints(fp)
-	return nil
+	return signers, keys, nil
 }
 
 func (c *Configuration) loadHostCertificates(configDir string) ([]hostCertificate, error) {
@@ -1071,6 +1198,9 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 		}
 		c.parsedUserCAKeys = append(c.parsedUserCAKeys, parsedKey)
 	}
+	if err := c.loadUserCA(configDir); err != nil {
+		return err
+	}
 	c.certChecker = &ssh.CertChecker{
 		SupportedCriticalOptions: []string{
 			sourceAddressCriticalOption,
@@ -1154,9 +1284,22 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
+		if err := userCA.checkCert(conn.User(), cert); err != nil {
+			user.Username = conn.User()
+			updateLoginMetrics(&user, ipAddr, method, err)
+			return nil, err
+		}
 		certPerm = &cert.Permissions
 	}
 	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH, ok); err == nil {
+		if !user.Filters.SSHAlgorithms.IsPublicKeyAllowed(pubKey) {
+			logger.Info(logSender, connectionID, "cannot login user %q, public key type %q is not allowed",
+				user.Username, pubKey.Type())
+			err = fmt.Errorf("public key type %q is not allowed for user %q", pubKey.Type(), user.Username)
+			user.Username = conn.User()
+			updateLoginMetrics(&user, ipAddr, method, err)
+			return nil, err
+		}
 		if ok {
 			keyID = fmt.Sprintf("%s: ID: %s, serial: %v, CA %s %s", certFingerprint,
 				cert.KeyId, cert.Serial, cert.Type(), ssh.FingerprintSHA256(cert.SignatureKey))
@@ -1295,9 +1438,72 @@ func (r *revokedCertificates) isRevoked(fp string) bool {
 	return r.certs[fp]
 }
 
-// Reload reloads the list of revoked user certificates
+// hostKeysManager holds the host keys and certificates used for new
+// connections. They can be reloaded without affecting the existing connections
+type hostKeysManager struct {
+	mu        sync.RWMutex
+	config    *Configuration
+	configDir string
+	signers   []ssh.Signer
+}
+
+func (m *hostKeysManager) set(c *Configuration, configDir string, signers []ssh.Signer, keys []HostKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config = &Configuration{
+		HostKeys:          c.HostKeys,
+		HostCertificates:  c.HostCertificates,
+		HostKeyAlgorithms: c.HostKeyAlgorithms,
+	}
+	m.configDir = configDir
+	m.signers = signers
+
+	serviceStatus.HostKeys = keys
+	var fp []string
+	for idx := range keys {
+		fp = append(fp, keys[idx].Fingerprint)
+	}
+	vfs.SetSFTPFingerprints(fp)
+}
+
+// reload loads the configured host keys and certificates again, the current
+// ones are preserved if an error is detected
+func (m *hostKeysManager) reload() error {
+	m.mu.RLock()
+	c := m.config
+	configDir := m.configDir
+	m.mu.RUnlock()
+
+	if c == nil {
+		return nil
+	}
+	signers, keys, err := c.loadHostKeys(configDir)
+	if err != nil {
+		return fmt.Errorf("unable to reload host keys: %w", err)
+	}
+	m.set(c, configDir, signers, keys)
+	logger.Info(logSender, "", "host keys reloaded, loaded keys and certificates: %d", len(keys))
+	return nil
+}
+
+// getServerConfig returns a copy of the specified server config with the
+// current host keys added
+func (m *hostKeysManager) getServerConfig(serverConfig *ssh.ServerConfig) *ssh.ServerConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	config := *serverConfig
+	for _, signer := range m.signers {
+		config.AddHostKey(signer)
+	}
+	return &config
+}
+
+// Reload reloads the host keys, the host certificates and the list of revoked
+// user certificates. The existing connections are not affected
 func Reload() error {
-	return revokedCertManager.load()
+	return errors.Join(hostKeysMgr.reload(), revokedCertManager.load())
 }
 
 func algorithmsForKeyFormat(keyFormat string) []string {
@@ -30,13 +30,17 @@ const (
 )
 
 var (
-	supportedSSHCommands = []string{"scp", "md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "cd", "pwd",
-		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "sftpgo-copy", "sftpgo-remove"}
+	supportedSSHCommands = []string{"scp", "md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "blake3sum",
+		"xxh128sum", "cd", "pwd", "git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "borg",
+		"sftpgo-copy", "sftpgo-remove", "sftpgo-tar"}
 	defaultSSHCommands = []string{"md5sum", "sha1sum", "sha256sum", "cd", "pwd", "scp"}
-	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum"}
-	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
-	serviceStatus      ServiceStatus
-	certKeyAlgoNames   = map[string]string{
+	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "blake3sum", "xxh128sum"}
+	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "borg"}
+	// system commands that only read from the filesystem, they don't require upload permissions
+	// and don't update the quota
+	readOnlySystemCommands = []string{"git-upload-pack", "git-upload-archive"}
+	serviceStatus          ServiceStatus
+	certKeyAlgoNames       = map[string]string{
 		ssh.CertAlgoRSAv01:         ssh.KeyAlgoRSA,
 		ssh.CertAlgoRSASHA256v01:   ssh.KeyAlgoRSASHA256,
 		ssh.CertAlgoRSASHA512v01:   ssh.KeyAlgoRSASHA512,
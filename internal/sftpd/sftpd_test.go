@@ -15,8 +15,10 @@
 package sftpd_test
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
@@ -51,12 +53,22 @@ import (
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/pkg/sftp"
 	"github.com/rs/zerolog"
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zeebo/blake3"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -151,6 +163,8 @@ qwlk5iw/jQekxThg==
 	// ssh-keygen -s ca_user_key -I test_user_sftp -V always:forever -O source-address=127.0.0.1 -z 1 /tmp/test.pub
 	testCertNoPrincipals = "ssh-rsa-cert-v01@openssh.com AAAAHHNzaC1yc2EtY2VydC12MDFAb3BlbnNzaC5jb20AAAAg2Bx0s8nafJtriqoBuQfbFByhdQMkjDIZhV90JZSGN8AAAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0AAAAAAAAAAQAAAAEAAAAOdGVzdF91c2VyX3NmdHAAAAAAAAAAAAAAAAD//////////wAAACMAAAAOc291cmNlLWFkZHJlc3MAAAANAAAACTEyNy4wLjAuMQAAAIIAAAAVcGVybWl0LVgxMS1mb3J3YXJkaW5nAAAAAAAAABdwZXJtaXQtYWdlbnQtZm9yd2FyZGluZwAAAAAAAAAWcGVybWl0LXBvcnQtZm9yd2FyZGluZwAAAAAAAAAKcGVybWl0LXB0eQAAAAAAAAAOcGVybWl0LXVzZXItcmMAAAAAAAAAAAAAAZcAAAAHc3NoLXJzYQAAAAMBAAEAAAGBAMXl9zBkeLKLGacToiU5kmlmFZeiHraA37Jp0ADQYnnT1IARplUs8M/xLlGwTyZSKRHfDHKdWyHEd6oyGuRL5GU1uFKU5cN02D3jJOur/EXxn8+ApEie95/viTmLtsAjK3NruMRHMUn+6NMTLfnftPmTkRhAnXllAa6/PKdJ2/7qj31KMjiMWmXJA5nZBxhsQCaEebkaBCUiIQUb9GUO0uSw66UpnE5jeo/M/QDJDG1klef/m8bjRpb0tNvDEImpaWCuQVcyoABUJu5TliynCGJeYq3U+yV2JfDbeiWhrhxoIo3WPNsWIa5k1cRTYRvHski+NAI9pRjAuMRuREPEOo3++bBmoG4piK4b0Rp/H6cVJCSvtBhvlv6ZP7/UgUeeZ5EaffzvfWQGq0fu2nML+36yhFf2nYe0kz70xiFuU7Y6pNI8ZOXGKFZSTKJEF6SkCFqIeV3XpOwb4Dds4keuiMZxf7mDqgZqsoYsAxzKQvVf6tmpP33cyjp3Znurjcw5cQAAAZQAAAAMcnNhLXNoYTItNTEyAAABgHgax/++NA5YZXDHH180BcQtDBve8Vc+XJzqQUe8xBiqd+KJnas6He7vW62qMaAfu63i0Uycj2Djfjy5dyx1GB9wup8YuP5mXlmJTx+7UPPjwbfrZWtk8iJ7KhFAwjh0KRZD4uIvoeecK8QE9zh64k2LNVqlWbFTdoPulRC29cGcXDpMU2eToFEyWbceHOZyyifXf98ZMZbaQzWzwSZ5rFucJ1b0aeT6aAJWB+Dq7mIQWf/jCWr8kNaeCzMKJsFQkQEfmHls29ChV92sNRhngUDxll0Ir0wpPea1fFEBnUhLRTLC8GhDDbWAzsZtXqx9fjoAkb/gwsU6TGxevuOMxEABjDA9PyJiTXJI9oTUCwDIAUVVFLsCEum3o/BblngXajUGibaif5ZSKBocpP70oTeAngQYB7r1/vquQzGsGFhTN4FUXLSpLu9Zqi1z58/qa7SgKSfNp98X/4zrhltAX73ZEvg0NUMv2HwlwlqHdpF3FYolAxInp7c2jBTncQ2l3w== nicola@p1"
 	osWindows            = "windows"
+	gssapiRealm          = "EXAMPLE.COM"
+	gssapiSPN            = "host/sftp.example.com"
 	testFileName         = "test_file_sftp.dat"
 	testDLFileName       = "test_download_sftp.dat"
 )
@@ -167,6 +181,7 @@ var (
 	pubKeyPath       string
 	privateKeyPath   string
 	trustedCAUserKey string
+	userCAKey        string
 	revokeUserCerts  string
 	gitWrapPath      string
 	extAuthPath      string
@@ -178,6 +193,8 @@ var (
 	checkPwdPath     string
 	logFilePath      string
 	hostKeyFPs       []string
+	gssapiKeytabPath string
+	gssapiKeytab     *keytab.Keytab
 )
 
 func TestMain(m *testing.M) {
@@ -276,6 +293,12 @@ func TestMain(m *testing.M) {
 	createInitialFiles(scriptArgs)
 	sftpdConf.TrustedUserCAKeys = append(sftpdConf.TrustedUserCAKeys, trustedCAUserKey)
 	sftpdConf.RevokedUserCertsFile = revokeUserCerts
+	sftpdConf.UserCAKey = userCAKey
+	sftpdConf.GSSAPI = sftpd.GSSAPIConfig{
+		Enabled:          true,
+		Keytab:           gssapiKeytabPath,
+		ServicePrincipal: gssapiSPN,
+	}
 
 	go func(cfg sftpd.Configuration) {
 		logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
@@ -359,6 +382,9 @@ func TestMain(m *testing.M) {
 	os.Remove(pubKeyPath)
 	os.Remove(privateKeyPath)
 	os.Remove(trustedCAUserKey)
+	os.Remove(userCAKey)
+	os.Remove(userCAKey + ".pub")
+	os.Remove(gssapiKeytabPath)
 	os.Remove(revokeUserCerts)
 	os.Remove(gitWrapPath)
 	os.Remove(extAuthPath)
@@ -487,6 +513,16 @@ func TestInitialization(t *testing.T) {
 	assert.NoError(t, err)
 	sftpdConf.HostKeys = nil
 	sftpdConf.HostCertificates = nil
+	sftpdConf.UserCAKey = "."
+	err = sftpdConf.Initialize(configDir)
+	assert.Error(t, err)
+	sftpdConf.UserCAKey = "missing user CA key"
+	err = sftpdConf.Initialize(configDir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	sftpdConf.UserCAKey = trustedCAUserKey
+	err = sftpdConf.Initialize(configDir)
+	assert.Error(t, err, "a public key is not a valid CA key")
+	sftpdConf.UserCAKey = userCAKey
 	sftpdConf.RevokedUserCertsFile = "."
 	err = sftpdConf.Initialize(configDir)
 	assert.Error(t, err)
@@ -2115,6 +2151,112 @@ func TestLogin(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserCACertificates(t *testing.T) {
+	assert.True(t, sftpd.IsUserCAEnabled())
+	group, _, err := httpdtest.AddGroup(getTestGroup(), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(true)
+	u.PublicKeys = nil
+	u.Password = defaultPassword
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser(true)
+	u.Username += "_1"
+	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	_, err = sftpd.IssueUserCertificate(&user, "invalid key")
+	assert.Error(t, err)
+	_, err = sftpd.IssueUserCertificate(&user, testCertValid)
+	assert.Error(t, err, "certificates cannot be signed")
+	cert, err := sftpd.IssueUserCertificate(&user, testPubKey)
+	if assert.NoError(t, err) {
+		assert.Equal(t, user.Username, cert.KeyID)
+		assert.Equal(t, []string{user.Username, group.Name}, cert.Principals)
+		assert.Greater(t, cert.ValidBefore, util.GetTimeAsMsSinceEpoch(time.Now().Add(50*time.Minute)))
+		// the public key is not associated to the user, login works using the certificate
+		signer, err := getSignerForUserCert([]byte(cert.Certificate))
+		assert.NoError(t, err)
+		conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+			conn.Close()
+		}
+		// the certificate is not valid for other users
+		_, _, err = getCustomAuthSftpClient(user1, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+		assert.Error(t, err)
+	}
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPublicKey}
+	_, err = sftpd.IssueUserCertificate(&user, testPubKey)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestGSSAPILogin(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	gssapiClient := &testGSSAPIClient{principal: user.Username}
+	authMethods := []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	conn, client, err := getCustomAuthSftpClient(user, authMethods, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	assert.True(t, gssapiClient.mutualAuth)
+	// the principal is mapped to another user
+	gssapiClient = &testGSSAPIClient{principal: user.Username + "1"}
+	authMethods = []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
+	// the principal is from a foreign realm
+	gssapiClient = &testGSSAPIClient{principal: user.Username, realm: "OTHER.COM"}
+	authMethods = []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
+	// invalid MIC
+	gssapiClient = &testGSSAPIClient{principal: user.Username, invalidMIC: true}
+	authMethods = []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
+
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodGSSAPI}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	gssapiClient = &testGSSAPIClient{principal: user.Username}
+	authMethods = []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	// the user does not exist anymore
+	gssapiClient = &testGSSAPIClient{principal: user.Username}
+	authMethods = []ssh.AuthMethod{ssh.GSSAPIWithMICAuthMethod(gssapiClient, "sftp.example.com")}
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
+}
+
 func TestLoginUserCert(t *testing.T) {
 	u := getTestUser(true)
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -2655,7 +2797,7 @@ func TestLoginAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 	_, _, err = getSftpClient(user, usePubKey)
 	assert.Error(t, err)
 
@@ -2878,6 +3020,29 @@ func TestInteractiveLoginWithPasscode(t *testing.T) {
 	assert.Error(t, err)
 	assert.True(t, passwordAsked)
 	assert.True(t, passcodeAsked)
+	// the passcode is not validated if the client IP is exempted
+	user.Filters.TwoFactorAuthExemptions = []dataprovider.TwoFactorAuthExemption{
+		{
+			Protocols: []string{common.ProtocolSSH},
+			Networks:  []string{"127.0.0.0/8"},
+		},
+	}
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	err = os.WriteFile(keyIntAuthPath, getKeyboardInteractiveScriptForBuiltinChecks(true, 1), os.ModePerm)
+	assert.NoError(t, err)
+	passcode = "invalid"
+	conn, client, err = getCustomAuthSftpClient(user, authMethods, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	user.Filters.TwoFactorAuthExemptions = nil
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	_, _, err = getCustomAuthSftpClient(user, authMethods, "")
+	assert.Error(t, err)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -4156,7 +4321,7 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	// test again, the user now exists
 	_, _, err = getSftpClient(u, usePubKey)
@@ -7777,6 +7942,93 @@ func TestPermOverwrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAppendOnlyUser(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Filters.AppendOnly = true
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.True(t, user.Filters.AppendOnly)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.Error(t, err, "file overwrite must fail in append-only mode")
+		err = client.Remove(testFileName)
+		assert.Error(t, err, "delete must fail in append-only mode")
+		err = sftpUploadFile(testFilePath, testFileName+".tmp", testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName+".tmp", testFileName)
+		assert.Error(t, err, "rename over an existing file must fail in append-only mode")
+		err = client.Rename(testFileName+".tmp", testFileName+".new")
+		assert.NoError(t, err)
+		err = client.Mkdir("adir")
+		assert.NoError(t, err)
+		err = client.RemoveDirectory("adir")
+		assert.Error(t, err, "directory removal must fail in append-only mode")
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSSHAlgorithmsPolicy(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Password = defaultPassword
+	u.Filters.SSHAlgorithms.PublicKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err, "RSA keys are not allowed")
+	conn, client, err := getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	// the test client negotiates AES-128-GCM
+	user.Filters.SSHAlgorithms.PublicKeyAlgorithms = []string{ssh.KeyAlgoRSASHA256}
+	user.Filters.SSHAlgorithms.Ciphers = []string{ssh.CipherAES256CTR}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err, "cipher not allowed")
+	_, _, err = getSftpClient(user, false)
+	assert.Error(t, err, "cipher not allowed")
+	user.Filters.SSHAlgorithms.Ciphers = []string{ssh.CipherAES128GCM, ssh.CipherAES256CTR}
+	user.Filters.SSHAlgorithms.KexAlgorithms = []string{ssh.KeyExchangeECDHP256}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, _, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err, "key exchange not allowed")
+	// curve25519-sha256 and curve25519-sha256@libssh.org are equivalent
+	user.Filters.SSHAlgorithms.KexAlgorithms = []string{ssh.KeyExchangeCurve25519SHA256}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPermDelete(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -8637,10 +8889,11 @@ func TestUserAllowedLoginMethods(t *testing.T) {
 		dataprovider.SSHLoginMethodKeyboardInteractive,
 	}
 	allowedMethods = user.GetAllowedLoginMethods()
-	assert.Equal(t, 4, len(allowedMethods))
+	assert.Equal(t, 5, len(allowedMethods))
 
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndKeyboardInt))
 	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodKeyAndPassword))
+	assert.True(t, util.Contains(allowedMethods, dataprovider.SSHLoginMethodGSSAPI))
 }
 
 func TestUserPartialAuth(t *testing.T) {
@@ -8996,6 +9249,14 @@ func TestSSHCommands(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(out), "38b060a751ac96384cd9327eb1b1e36a21fdb71114be07434c0cc7bf63f6e1da274edebfe76f65fbd51ad2f14898b95b")
 
+	out, err = runSSHCommand("blake3sum", user, usePubKey)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262")
+
+	out, err = runSSHCommand("xxh128sum", user, usePubKey)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "99aa06d3014798d86001c324468d497f")
+
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 }
@@ -9039,6 +9300,12 @@ func TestSSHFileHash(t *testing.T) {
 			if assert.NoError(t, err) {
 				assert.Contains(t, string(out), initialHash)
 			}
+			blake3Hash, err := computeHashForFile(blake3.New(), testFilePath)
+			assert.NoError(t, err)
+			out, err = runSSHCommand("blake3sum "+testFileName, user, usePubKey)
+			if assert.NoError(t, err) {
+				assert.Contains(t, string(out), blake3Hash)
+			}
 			_, err = runSSHCommand("sha512sum invalid_path", user, usePubKey)
 			assert.Error(t, err, "hash for an invalid path must fail")
 
@@ -9056,6 +9323,176 @@ func TestSSHFileHash(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSFTPCopyDataExtension(t *testing.T) {
+	sftpPath, err := exec.LookPath("sftp")
+	if err != nil {
+		t.Skip("sftp command not found, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	u.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFileSize := int64(131072)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("sub")
+		assert.NoError(t, err)
+
+		runSFTPBatch := func(commands string) error {
+			cmd := exec.Command(sftpPath, "-b", "-", "-P", "2022", "-o", "StrictHostKeyChecking=no", "-i", privateKeyPath,
+				fmt.Sprintf("%v@127.0.0.1", user.Username))
+			cmd.Stdin = strings.NewReader(commands)
+			return cmd.Run()
+		}
+		err = runSFTPBatch(fmt.Sprintf("cp %v %v\n", testFileName, testFileName+".copy"))
+		assert.NoError(t, err)
+		expectedContent, err := os.ReadFile(testFilePath)
+		assert.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName+".copy"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, expectedContent, content)
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+		// no upload permission in /sub
+		err = runSFTPBatch(fmt.Sprintf("cp %v %v\n", testFileName, path.Join("/sub", testFileName)))
+		assert.Error(t, err)
+		_, err = client.Stat(path.Join("/sub", testFileName))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSSHPortForwarding(t *testing.T) {
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echoListener.Close()
+
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+
+				io.Copy(c, c) //nolint:errcheck
+			}(conn)
+		}
+	}()
+	echoPort := echoListener.Addr().(*net.TCPAddr).Port
+
+	checkEcho := func(conn net.Conn) {
+		_, err := conn.Write([]byte("ping"))
+		assert.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ping"), buf)
+	}
+
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		// port forwarding is disabled by default
+		_, err = conn.Dial("tcp", echoListener.Addr().String())
+		assert.Error(t, err)
+		_, err = conn.Listen("tcp", "127.0.0.1:0")
+		assert.Error(t, err)
+		client.Close()
+		conn.Close()
+	}
+
+	user.Filters.PortForwarding = dataprovider.PortForwardingPolicy{
+		AllowLocal:   true,
+		PermitOpen:   []string{fmt.Sprintf("127.0.0.0/8:%d", echoPort)},
+		AllowRemote:  true,
+		PermitListen: []string{"127.0.0.1:*"},
+		MaxForwards:  3,
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		localConn, err := conn.Dial("tcp", echoListener.Addr().String())
+		if assert.NoError(t, err) {
+			checkEcho(localConn)
+		}
+		// the port is not allowed
+		_, err = conn.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", echoPort+1))
+		assert.Error(t, err)
+		_, err = conn.Listen("tcp", "0.0.0.0:0")
+		assert.Error(t, err)
+
+		listener, err := conn.Listen("tcp", "127.0.0.1:0")
+		if assert.NoError(t, err) {
+			go func() {
+				c, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+
+				io.Copy(c, c) //nolint:errcheck
+			}()
+			remoteConn, err := net.Dial("tcp", listener.Addr().String())
+			if assert.NoError(t, err) {
+				checkEcho(remoteConn)
+				defer remoteConn.Close()
+			}
+		}
+		// the listener and the forwarded connections use all the available slots
+		_, err = conn.Dial("tcp", echoListener.Addr().String())
+		assert.Error(t, err)
+		if localConn != nil {
+			localConn.Close()
+		}
+		assert.Eventually(t, func() bool {
+			c, err := conn.Dial("tcp", echoListener.Addr().String())
+			if err != nil {
+				return false
+			}
+			checkEcho(c)
+			c.Close()
+			return true
+		}, 2*time.Second, 100*time.Millisecond)
+		if listener != nil {
+			err = listener.Close()
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSSHCopy(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -9800,6 +10237,78 @@ func TestSSHRemoveCryptFs(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSSHTar(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testDir := "tardir"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(131072)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = client.MkdirAll(path.Join(testDir, "sub"))
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join(testDir, testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join(testDir, "sub", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+
+		for _, compress := range []bool{false, true} {
+			command := fmt.Sprintf("sftpgo-tar %s", testDir)
+			if compress {
+				command = fmt.Sprintf("sftpgo-tar -z %s", testDir)
+			}
+			out, err := runSSHCommand(command, user, usePubKey)
+			assert.NoError(t, err)
+			var r io.Reader = bytes.NewReader(out)
+			if compress {
+				r, err = gzip.NewReader(r)
+				assert.NoError(t, err)
+			}
+			tr := tar.NewReader(r)
+			entries := make(map[string]int64)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if !assert.NoError(t, err) {
+					break
+				}
+				n, err := io.Copy(io.Discard, tr)
+				assert.NoError(t, err)
+				entries[hdr.Name] = n
+			}
+			assert.Len(t, entries, 4)
+			assert.Contains(t, entries, testDir+"/")
+			assert.Contains(t, entries, testDir+"/sub/")
+			assert.Equal(t, testFileSize, entries[path.Join(testDir, testFileName)])
+			assert.Equal(t, testFileSize, entries[path.Join(testDir, "sub", testFileName)])
+		}
+
+		_, err = runSSHCommand("sftpgo-tar", user, usePubKey)
+		assert.Error(t, err)
+		_, err = runSSHCommand(fmt.Sprintf("sftpgo-tar -x %s", testDir), user, usePubKey)
+		assert.Error(t, err)
+		_, err = runSSHCommand("sftpgo-tar missingdir", user, usePubKey)
+		assert.Error(t, err)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestBasicGitCommands(t *testing.T) {
 	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
 		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
@@ -10035,6 +10544,61 @@ func TestGitErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGitReadOnlyPermissions(t *testing.T) {
+	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
+		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	u.DownloadDataTransfer = 100
+	u.UploadDataTransfer = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	repoName := "testrepo"
+	clonePath := filepath.Join(homeBasePath, repoName)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	out, err := initGitRepo(filepath.Join(user.HomeDir, repoName))
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	// clone and fetch only require the download permission
+	out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+
+	out, err = addFileToGitRepo(clonePath, 128)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = pushToGitRepo(clonePath)
+	assert.Error(t, err, "git push must fail without upload permissions, out: %v", string(out))
+
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.UsedQuotaFiles)
+	assert.Equal(t, int64(0), user.UsedQuotaSize)
+	// the sent pack counts against the download transfer quota only
+	assert.Greater(t, user.UsedDownloadDataTransfer, int64(0))
+	assert.Equal(t, int64(0), user.UsedUploadDataTransfer)
+	// clone is denied if the download transfer quota is exceeded
+	user.DownloadDataTransfer = 1
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	user.UsedDownloadDataTransfer = 1024 * 1024
+	_, err = httpdtest.UpdateTransferQuotaUsage(user, "", http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+	assert.Error(t, err, "git clone must fail if the download transfer quota is exceeded, out: %v", string(out))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+}
+
 // Start SCP tests
 func TestSCPBasicHandling(t *testing.T) {
 	if scpPath == "" {
@@ -10193,6 +10757,114 @@ func TestSCPUploadFileOverwrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSCPUploadPreserveTimes(t *testing.T) {
+	if scpPath == "" {
+		t.Skip("scp command not found, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermOverwrite}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65536)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	mtime := time.Date(2021, 3, 10, 12, 30, 15, 0, time.UTC)
+	err = os.Chtimes(testFilePath, mtime, mtime)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+
+	remoteUpPath := fmt.Sprintf("%v@127.0.0.1:%v", user.Username, path.Join("/", testFileName))
+	err = scpUpload(testFilePath, remoteUpPath, true, false)
+	assert.NoError(t, err)
+	fi, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+	if assert.NoError(t, err) {
+		assert.True(t, fi.ModTime().Equal(mtime), "unexpected mtime %v", fi.ModTime())
+	}
+	// without the preserve flag the upload time is used
+	err = scpUpload(testFilePath, remoteUpPath, false, false)
+	assert.NoError(t, err)
+	fi, err = os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+	if assert.NoError(t, err) {
+		assert.False(t, fi.ModTime().Equal(mtime))
+	}
+	// the times are ignored if the user cannot change them
+	remoteUpPath = fmt.Sprintf("%v@127.0.0.1:%v", user.Username, path.Join("/sub", testFileName))
+	err = scpUpload(testFilePath, remoteUpPath, true, false)
+	assert.NoError(t, err)
+	fi, err = os.Stat(filepath.Join(user.GetHomeDir(), "sub", testFileName))
+	if assert.NoError(t, err) {
+		assert.False(t, fi.ModTime().Equal(mtime))
+	}
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestSCPProtocolBandwidthLimits(t *testing.T) {
+	if scpPath == "" {
+		t.Skip("scp command not found, unable to execute this test")
+	}
+	usePubKey := true
+	testFileSize := int64(131072)
+	u := getTestUser(usePubKey)
+	u.Filters.ProtocolBandwidthLimits = []dataprovider.ProtocolBandwidthLimit{
+		{
+			Protocols:         []string{common.ProtocolSCP},
+			UploadBandwidth:   64,
+			DownloadBandwidth: 96,
+		},
+	}
+	wantedUploadElapsed := 1000*(testFileSize/1024)/64 - 100
+	wantedDownloadElapsed := 1000*(testFileSize/1024)/96 - 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	localPath := filepath.Join(homeBasePath, "scp_download.dat")
+	remoteUpPath := fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/")
+	remoteDownPath := fmt.Sprintf("%v@127.0.0.1:%v", user.Username, path.Join("/", testFileName))
+
+	startTime := time.Now()
+	err = scpUpload(testFilePath, remoteUpPath, false, false)
+	assert.NoError(t, err)
+	elapsed := time.Since(startTime).Milliseconds()
+	assert.GreaterOrEqual(t, elapsed, wantedUploadElapsed, "upload bandwidth throttling not respected")
+	startTime = time.Now()
+	err = scpDownload(localPath, remoteDownPath, false, false)
+	assert.NoError(t, err)
+	elapsed = time.Since(startTime).Milliseconds()
+	assert.GreaterOrEqual(t, elapsed, wantedDownloadElapsed, "download bandwidth throttling not respected")
+	// the SCP limits don't apply to SFTP
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		startTime = time.Now()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		elapsed = time.Since(startTime).Milliseconds()
+		assert.Less(t, elapsed, wantedUploadElapsed)
+	}
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.Remove(localPath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSCPRecursive(t *testing.T) {
 	if scpPath == "" {
 		t.Skip("scp command not found, unable to execute this test")
@@ -10346,6 +11018,61 @@ func TestSCPPatternsFilter(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSCPGlobDownload(t *testing.T) {
+	if scpPath == "" {
+		t.Skip("scp command not found, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/dir",
+			DeniedPatterns: []string{"b.csv"},
+			DenyPolicy:     sdk.DenyPolicyHide,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFileSize := int64(65535)
+	for _, name := range []string{"a.csv", "b.csv", "c.csv", "d.txt", ".e.csv", filepath.Join("f.csv", "g.csv")} {
+		err = createTestFile(filepath.Join(user.GetHomeDir(), "dir", name), testFileSize)
+		assert.NoError(t, err)
+	}
+	localDownloadPath := filepath.Join(homeBasePath, "scp_glob")
+	err = os.MkdirAll(localDownloadPath, os.ModePerm)
+	assert.NoError(t, err)
+	remoteDownPath := fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/dir/*.csv")
+	err = scpDownload(localDownloadPath, remoteDownPath, false, false)
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(localDownloadPath)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"a.csv", "c.csv"}, names)
+	err = os.RemoveAll(localDownloadPath)
+	assert.NoError(t, err)
+	err = os.MkdirAll(localDownloadPath, os.ModePerm)
+	assert.NoError(t, err)
+	err = scpDownload(localDownloadPath, remoteDownPath, false, true)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(localDownloadPath, "f.csv", "g.csv"))
+	assert.NoFileExists(t, filepath.Join(localDownloadPath, "b.csv"))
+	assert.NoFileExists(t, filepath.Join(localDownloadPath, ".e.csv"))
+
+	remoteDownPath = fmt.Sprintf("%v@127.0.0.1:%v", user.Username, "/dir/*.zip")
+	err = scpDownload(localDownloadPath, remoteDownPath, false, false)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(localDownloadPath)
+	assert.NoError(t, err)
+}
+
 func TestSCPTransferQuotaLimits(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -11221,6 +11948,66 @@ func runSSHCommand(command string, user dataprovider.User, usePubKey bool) ([]by
 	return stdout.Bytes(), err
 }
 
+// testGSSAPIClient is a Kerberos initiator that issues the service tickets
+// itself using the test keytab, so no KDC is required
+type testGSSAPIClient struct {
+	principal string
+	// realm of the client principal, the service realm is used if empty
+	realm      string
+	invalidMIC bool
+	mutualAuth bool
+	sessionKey types.EncryptionKey
+}
+
+func (c *testGSSAPIClient) InitSecContext(_ string, token []byte, _ bool) ([]byte, bool, error) {
+	if len(token) > 0 {
+		var krb5Token spnego.KRB5Token
+		if err := krb5Token.Unmarshal(token); err != nil {
+			return nil, false, err
+		}
+		if !krb5Token.IsAPRep() {
+			return nil, false, errors.New("AP_REP expected")
+		}
+		c.mutualAuth = true
+		return nil, false, nil
+	}
+	realm := c.realm
+	if realm == "" {
+		realm = gssapiRealm
+	}
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, c.principal),
+		realm, types.NewPrincipalName(nametype.KRB_NT_SRV_HST, gssapiSPN), gssapiRealm, types.NewKrbFlags(),
+		gssapiKeytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		return nil, false, err
+	}
+	cl := client.NewWithPassword(c.principal, realm, "password", krb5config.New())
+	krb5Token, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey,
+		[]int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	c.sessionKey = sessionKey
+	token, err = krb5Token.Marshal()
+	return token, true, err
+}
+
+func (c *testGSSAPIClient) GetMIC(micField []byte) ([]byte, error) {
+	if c.invalidMIC {
+		micField = append(micField, []byte("invalid")...)
+	}
+	token, err := gssapi.NewInitiatorMICToken(micField, c.sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	return token.Marshal()
+}
+
+func (c *testGSSAPIClient) DeleteSecContext() error {
+	return nil
+}
+
 func getSignerForUserCert(certBytes []byte) (ssh.Signer, error) {
 	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
 	if err != nil {
@@ -11849,6 +12636,8 @@ func createInitialFiles(scriptArgs string) {
 	pubKeyPath = filepath.Join(homeBasePath, "ssh_key.pub")
 	privateKeyPath = filepath.Join(homeBasePath, "ssh_key")
 	trustedCAUserKey = filepath.Join(homeBasePath, "ca_user_key")
+	userCAKey = filepath.Join(homeBasePath, "user_ca_key")
+	gssapiKeytabPath = filepath.Join(homeBasePath, "sftpgo.keytab")
 	gitWrapPath = filepath.Join(homeBasePath, "gitwrap.sh")
 	extAuthPath = filepath.Join(homeBasePath, "extauth.sh")
 	preLoginPath = filepath.Join(homeBasePath, "prelogin.sh")
@@ -11874,6 +12663,22 @@ func createInitialFiles(scriptArgs string) {
 	if err != nil {
 		logger.WarnToConsole("unable to save trusted CA user key: %v", err)
 	}
+	err = util.GenerateEd25519Keys(userCAKey)
+	if err != nil {
+		logger.WarnToConsole("unable to generate user CA key: %v", err)
+	}
+	gssapiKeytab = keytab.New()
+	err = gssapiKeytab.AddEntry(gssapiSPN, gssapiRealm, "service password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		logger.WarnToConsole("unable to generate GSSAPI keytab: %v", err)
+	}
+	keytabData, err := gssapiKeytab.Marshal()
+	if err == nil {
+		err = os.WriteFile(gssapiKeytabPath, keytabData, 0600)
+	}
+	if err != nil {
+		logger.WarnToConsole("unable to save GSSAPI keytab: %v", err)
+	}
 	err = os.WriteFile(revokeUserCerts, []byte(`[]`), 0644)
 	if err != nil {
 		logger.WarnToConsole("unable to save revoked user certs: %v", err)
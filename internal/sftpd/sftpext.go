@@ -0,0 +1,763 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	sftpPacketVersion       = 2
+	sftpPacketOpen          = 3
+	sftpPacketClose         = 4
+	sftpPacketStatus        = 101
+	sftpPacketHandle        = 102
+	sftpPacketExtended      = 200
+	sftpPacketExtendedReply = 201
+	// packets greater than this size are not inspected, they are
+	// passed to the SFTP server that will reject them, it uses the
+	// same limit
+	sftpMaxInspectedPacketLength = 256 * 1024
+	sftpExtCopyData              = "copy-data"
+	sftpExtCopyFile              = "copy-file"
+	sftpExtLimits                = "limits@openssh.com"
+	// extended attributes extensions, they are compatible with the ProFTPD ones
+	sftpExtGetXattr     = "getxattr@proftpd.org"
+	sftpExtFGetXattr    = "fgetxattr@proftpd.org"
+	sftpExtListXattr    = "listxattr@proftpd.org"
+	sftpExtFListXattr   = "flistxattr@proftpd.org"
+	sftpExtSetXattr     = "setxattr@proftpd.org"
+	sftpExtFSetXattr    = "fsetxattr@proftpd.org"
+	sftpExtRemoveXattr  = "removexattr@proftpd.org"
+	sftpExtFRemoveXattr = "fremovexattr@proftpd.org"
+	// flags for the setxattr requests, they match the setxattr(2) ones
+	sftpXattrCreate  = 1
+	sftpXattrReplace = 2
+	// limits advertised using the "limits@openssh.com" extension, they match
+	// the ones enforced by the SFTP server. Read requests are served using at
+	// most 32 KB of data, the write length leaves room for the packet headers
+	sftpLimitMaxPacketLength = 256 * 1024
+	sftpLimitMaxReadLength   = 32 * 1024
+	sftpLimitMaxWriteLength  = sftpLimitMaxPacketLength - 1024
+)
+
+const (
+	sftpStatusOK               = 0
+	sftpStatusEOF              = 1
+	sftpStatusNoSuchFile       = 2
+	sftpStatusPermissionDenied = 3
+	sftpStatusFailure          = 4
+	sftpStatusBadMessage       = 5
+	sftpStatusOpUnsupported    = 8
+)
+
+var errSFTPBadMessage = errors.New("bad message")
+
+// sftpExtensionsChannel wraps the channel used by the SFTP server to implement
+// the "copy-data", "copy-file", "limits@openssh.com" and the extended attributes
+// extensions, they cannot be registered in the SFTP server. The packets sent by
+// the client are inspected to serve the extension requests and to track the
+// open and close requests, the packets sent by the server are inspected to
+// advertise the extensions and to bind the returned handles to the transfers.
+// The other packets are passed through unchanged
+type sftpExtensionsChannel struct {
+	io.ReadWriteCloser
+	connection     *Connection
+	startDirectory string
+	// read side, it is used by a single goroutine
+	readHeader    [5]byte
+	readBuf       []byte
+	readRemaining int
+	// write side, a packet can be written using multiple writes
+	writeMu        sync.Mutex
+	writeCond      *sync.Cond
+	writeRemaining int
+	writePending   []byte
+	// IDs of the open requests waiting for a response
+	mu           sync.Mutex
+	pendingOpens map[uint32]bool
+}
+
+func newSFTPExtensionsChannel(channel io.ReadWriteCloser, connection *Connection, startDirectory string) *sftpExtensionsChannel {
+	c := &sftpExtensionsChannel{
+		ReadWriteCloser: channel,
+		connection:      connection,
+		startDirectory:  startDirectory,
+		pendingOpens:    make(map[uint32]bool),
+	}
+	if c.startDirectory == "" {
+		c.startDirectory = "/"
+	}
+	c.writeCond = sync.NewCond(&c.writeMu)
+	return c
+}
+
+// Read implements the io.Reader interface
+func (c *sftpExtensionsChannel) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 && c.readRemaining == 0 {
+		if err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.readBuf) > 0 {
+		n := copy(p, c.readBuf)
+		c.readBuf = c.readBuf[n:]
+		return n, nil
+	}
+	if len(p) > c.readRemaining {
+		p = p[:c.readRemaining]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	c.readRemaining -= n
+	return n, err
+}
+
+// readPacket reads the next packet header and, if the packet must be inspected,
+// the full packet. The packets used by the extensions are not forwarded
+func (c *sftpExtensionsChannel) readPacket() error {
+	if _, err := io.ReadFull(c.ReadWriteCloser, c.readHeader[:]); err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint32(c.readHeader[:4]))
+	packetType := c.readHeader[4]
+	if length < 1 || length > sftpMaxInspectedPacketLength ||
+		(packetType != sftpPacketOpen && packetType != sftpPacketClose && packetType != sftpPacketExtended) {
+		c.readBuf = c.readHeader[:]
+		c.readRemaining = length - 1
+		if c.readRemaining < 0 {
+			c.readRemaining = 0
+		}
+		return nil
+	}
+	packet := make([]byte, 4+length)
+	copy(packet, c.readHeader[:])
+	if _, err := io.ReadFull(c.ReadWriteCloser, packet[5:]); err != nil {
+		return err
+	}
+	if c.inspectClientPacket(packetType, packet[5:]) {
+		return nil
+	}
+	c.readBuf = packet
+	return nil
+}
+
+// inspectClientPacket returns true if the packet was handled and must not be forwarded
+func (c *sftpExtensionsChannel) inspectClientPacket(packetType byte, data []byte) bool {
+	id, data, err := sftpUnmarshalUint32(data)
+	if err != nil {
+		return false
+	}
+	switch packetType {
+	case sftpPacketOpen:
+		c.mu.Lock()
+		c.pendingOpens[id] = true
+		c.mu.Unlock()
+	case sftpPacketClose:
+		handle, _, err := sftpUnmarshalString(data)
+		if err == nil {
+			c.connection.sftpTransfers.remove(handle)
+		}
+	case sftpPacketExtended:
+		name, data, err := sftpUnmarshalString(data)
+		if err != nil {
+			return false
+		}
+		switch name {
+		case sftpExtCopyData:
+			go c.serveExtendedRequest(id, name, data, c.copyData)
+			return true
+		case sftpExtCopyFile:
+			go c.serveExtendedRequest(id, name, data, c.copyFile)
+			return true
+		case sftpExtGetXattr, sftpExtFGetXattr:
+			isHandle := name == sftpExtFGetXattr
+			go c.serveExtendedReplyRequest(id, name, data, func(data []byte) ([]byte, error) {
+				return c.getXattr(data, isHandle)
+			})
+			return true
+		case sftpExtListXattr, sftpExtFListXattr:
+			isHandle := name == sftpExtFListXattr
+			go c.serveExtendedReplyRequest(id, name, data, func(data []byte) ([]byte, error) {
+				return c.listXattr(data, isHandle)
+			})
+			return true
+		case sftpExtSetXattr, sftpExtFSetXattr:
+			isHandle := name == sftpExtFSetXattr
+			go c.serveExtendedRequest(id, name, data, func(data []byte) error {
+				return c.setXattr(data, isHandle)
+			})
+			return true
+		case sftpExtRemoveXattr, sftpExtFRemoveXattr:
+			isHandle := name == sftpExtFRemoveXattr
+			go c.serveExtendedRequest(id, name, data, func(data []byte) error {
+				return c.removeXattr(data, isHandle)
+			})
+			return true
+		case sftpExtLimits:
+			go func() {
+				if err := c.writeLimits(id); err != nil {
+					c.connection.Log(logger.LevelDebug, "unable to send the response for SFTP extension %q: %v",
+						name, err)
+				}
+			}()
+			return true
+		}
+	}
+	return false
+}
+
+func (c *sftpExtensionsChannel) serveExtendedRequest(id uint32, name string, data []byte, handler func([]byte) error) {
+	c.serveExtendedReplyRequest(id, name, data, func(data []byte) ([]byte, error) {
+		return nil, handler(data)
+	})
+}
+
+// serveExtendedReplyRequest serves an extension request. A status packet is sent
+// if the handler fails or returns no data, otherwise an extended reply is sent
+func (c *sftpExtensionsChannel) serveExtendedReplyRequest(id uint32, name string, data []byte,
+	handler func([]byte) ([]byte, error),
+) {
+	var reply []byte
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, c.connection.GetID(), "panic in SFTP extension %q: %q stack trace: %v",
+				name, r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+		var errWrite error
+		if err == nil && reply != nil {
+			errWrite = c.writeExtendedReply(id, reply)
+		} else {
+			errWrite = c.writeStatus(id, err)
+		}
+		if errWrite != nil {
+			c.connection.Log(logger.LevelDebug, "unable to send the response for SFTP extension %q: %v", name, errWrite)
+		}
+	}()
+
+	c.connection.UpdateLastActivity()
+	reply, err = handler(data)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "SFTP extension %q failed: %v", name, err)
+	}
+}
+
+// copyData implements the "copy-data" extension, the data are copied between
+// two open handles
+func (c *sftpExtensionsChannel) copyData(data []byte) error {
+	readHandle, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	readOffset, data, err := sftpUnmarshalUint64(data)
+	if err != nil {
+		return err
+	}
+	readLength, data, err := sftpUnmarshalUint64(data)
+	if err != nil {
+		return err
+	}
+	writeHandle, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	writeOffset, _, err := sftpUnmarshalUint64(data)
+	if err != nil {
+		return err
+	}
+	if readHandle == writeHandle {
+		return fmt.Errorf("copying data using the same handle is not supported: %w", c.connection.GetOpUnsupportedError())
+	}
+	src, err := c.getTransferForHandle(readHandle, common.TransferDownload)
+	if err != nil {
+		return err
+	}
+	dst, err := c.getTransferForHandle(writeHandle, common.TransferUpload)
+	if err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "copy data from %q, offset %d, length %d, to %q, offset %d",
+		src.GetVirtualPath(), readOffset, readLength, dst.GetVirtualPath(), writeOffset)
+
+	bufPtr := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(bufPtr)
+
+	buf := *bufPtr
+	var copied uint64
+	for readLength == 0 || copied < readLength {
+		toRead := uint64(len(buf))
+		if readLength > 0 && readLength-copied < toRead {
+			toRead = readLength - copied
+		}
+		n, errRead := src.ReadAt(buf[:toRead], int64(readOffset+copied))
+		if n > 0 {
+			if _, errWrite := dst.WriteAt(buf[:n], int64(writeOffset+copied)); errWrite != nil {
+				return errWrite
+			}
+			copied += uint64(n)
+		}
+		if errRead != nil {
+			if errors.Is(errRead, io.EOF) {
+				break
+			}
+			return errRead
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// copyFile implements the "copy-file" extension, files on the same cloud
+// storage backend are copied server side
+func (c *sftpExtensionsChannel) copyFile(data []byte) error {
+	source, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	target, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	if len(data) < 1 {
+		return errSFTPBadMessage
+	}
+	overwrite := data[0] != 0
+	source = util.CleanPathWithBase(c.startDirectory, source)
+	target = util.CleanPathWithBase(c.startDirectory, target)
+
+	srcInfo, err := c.connection.DoStat(source, 1, false)
+	if err != nil {
+		return err
+	}
+	if !srcInfo.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file: %w", source, c.connection.GetOpUnsupportedError())
+	}
+	if dstInfo, err := c.connection.DoStat(target, 1, false); err == nil {
+		if dstInfo.IsDir() {
+			return fmt.Errorf("%q is a directory: %w", target, sftp.ErrSSHFxFailure)
+		}
+		if !overwrite {
+			return fmt.Errorf("%q already exists: %w", target, sftp.ErrSSHFxFailure)
+		}
+	} else if !c.connection.IsNotExistError(err) {
+		return err
+	}
+	return c.connection.Copy(source, target)
+}
+
+// getXattrPath returns the virtual path for the extended attributes requests,
+// the path is specified directly or using an handle for the "f" variants
+func (c *sftpExtensionsChannel) getXattrPath(data []byte, isHandle bool) (string, []byte, error) {
+	name, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if !isHandle {
+		return util.CleanPathWithBase(c.startDirectory, name), data, nil
+	}
+	t, err := c.connection.sftpTransfers.get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return t.GetVirtualPath(), data, nil
+}
+
+func (c *sftpExtensionsChannel) getXattrPathAndName(data []byte, isHandle bool) (string, string, []byte, error) {
+	virtualPath, data, err := c.getXattrPath(data, isHandle)
+	if err != nil {
+		return "", "", nil, err
+	}
+	attr, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if attr == "" {
+		return "", "", nil, fmt.Errorf("empty attribute name: %w", errSFTPBadMessage)
+	}
+	return virtualPath, attr, data, nil
+}
+
+// getXattr implements the "getxattr@proftpd.org" and "fgetxattr@proftpd.org"
+// extensions, the reply contains the attribute value
+func (c *sftpExtensionsChannel) getXattr(data []byte, isHandle bool) ([]byte, error) {
+	virtualPath, attr, data, err := c.getXattrPathAndName(data, isHandle)
+	if err != nil {
+		return nil, err
+	}
+	// the maximum value size requested by the client is ignored
+	if _, _, err := sftpUnmarshalUint32(data); err != nil {
+		return nil, err
+	}
+	value, err := c.connection.GetXattr(virtualPath, attr)
+	if err != nil {
+		return nil, err
+	}
+	return sftpMarshalString(nil, string(value)), nil
+}
+
+// listXattr implements the "listxattr@proftpd.org" and "flistxattr@proftpd.org"
+// extensions, the reply contains the number of attributes followed by their names
+func (c *sftpExtensionsChannel) listXattr(data []byte, isHandle bool) ([]byte, error) {
+	virtualPath, data, err := c.getXattrPath(data, isHandle)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := sftpUnmarshalUint32(data); err != nil {
+		return nil, err
+	}
+	names, err := c.connection.ListXattr(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	reply := binary.BigEndian.AppendUint32(nil, uint32(len(names)))
+	for _, name := range names {
+		reply = sftpMarshalString(reply, name)
+	}
+	return reply, nil
+}
+
+// setXattr implements the "setxattr@proftpd.org" and "fsetxattr@proftpd.org" extensions
+func (c *sftpExtensionsChannel) setXattr(data []byte, isHandle bool) error {
+	virtualPath, attr, data, err := c.getXattrPathAndName(data, isHandle)
+	if err != nil {
+		return err
+	}
+	value, data, err := sftpUnmarshalString(data)
+	if err != nil {
+		return err
+	}
+	flags, _, err := sftpUnmarshalUint32(data)
+	if err != nil {
+		return err
+	}
+	if flags&(sftpXattrCreate|sftpXattrReplace) != 0 {
+		_, err := c.connection.GetXattr(virtualPath, attr)
+		switch {
+		case err == nil && flags&sftpXattrCreate != 0:
+			return fmt.Errorf("attribute %q already exists: %w", attr, sftp.ErrSSHFxFailure)
+		case err != nil && flags&sftpXattrReplace != 0:
+			return err
+		}
+	}
+	return c.connection.SetXattr(virtualPath, attr, []byte(value))
+}
+
+// removeXattr implements the "removexattr@proftpd.org" and "fremovexattr@proftpd.org" extensions
+func (c *sftpExtensionsChannel) removeXattr(data []byte, isHandle bool) error {
+	virtualPath, attr, _, err := c.getXattrPathAndName(data, isHandle)
+	if err != nil {
+		return err
+	}
+	return c.connection.RemoveXattr(virtualPath, attr)
+}
+
+func (c *sftpExtensionsChannel) getTransferForHandle(handle string, transferType int) (*transfer, error) {
+	t, err := c.connection.sftpTransfers.get(handle)
+	if err != nil {
+		return nil, err
+	}
+	if t.GetType() != transferType {
+		return nil, fmt.Errorf("handle %q does not match the requested transfer type: %w", handle,
+			sftp.ErrSSHFxFailure)
+	}
+	return t, nil
+}
+
+// getExtensions returns the extensions to advertise. The extended attributes
+// extensions are advertised if at least one of the user's filesystems
+// supports them
+func (c *sftpExtensionsChannel) getExtensions() []string {
+	extensions := []string{sftpExtCopyData, sftpExtCopyFile, sftpExtLimits}
+	virtualPaths := []string{"/"}
+	for idx := range c.connection.User.VirtualFolders {
+		virtualPaths = append(virtualPaths, c.connection.User.VirtualFolders[idx].VirtualPath)
+	}
+	for _, virtualPath := range virtualPaths {
+		fs, err := c.connection.User.GetFilesystemForPath(virtualPath, c.connection.GetID())
+		if err == nil && vfs.IsXattrSupported(fs) {
+			return append(extensions, sftpExtGetXattr, sftpExtFGetXattr, sftpExtListXattr, sftpExtFListXattr,
+				sftpExtSetXattr, sftpExtFSetXattr, sftpExtRemoveXattr, sftpExtFRemoveXattr)
+		}
+	}
+	return extensions
+}
+
+// Write implements the io.Writer interface
+func (c *sftpExtensionsChannel) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		if c.writeRemaining > 0 {
+			n := len(p)
+			if n > c.writeRemaining {
+				n = c.writeRemaining
+			}
+			if _, err := c.ReadWriteCloser.Write(p[:n]); err != nil {
+				return written, err
+			}
+			c.writeRemaining -= n
+			written += n
+			p = p[n:]
+			if c.writeRemaining == 0 {
+				c.writeCond.Broadcast()
+			}
+			continue
+		}
+		if len(c.writePending) < 5 {
+			n := 5 - len(c.writePending)
+			if n > len(p) {
+				n = len(p)
+			}
+			c.writePending = append(c.writePending, p[:n]...)
+			written += n
+			p = p[n:]
+			if len(c.writePending) < 5 {
+				continue
+			}
+		}
+		total := 4 + int(binary.BigEndian.Uint32(c.writePending[:4]))
+		packetType := c.writePending[4]
+		if total > sftpMaxInspectedPacketLength ||
+			(packetType != sftpPacketVersion && packetType != sftpPacketHandle && packetType != sftpPacketStatus) {
+			if _, err := c.ReadWriteCloser.Write(c.writePending); err != nil {
+				return written, err
+			}
+			c.writeRemaining = total - len(c.writePending)
+			c.writePending = c.writePending[:0]
+			if c.writeRemaining <= 0 {
+				c.writeRemaining = 0
+				c.writeCond.Broadcast()
+			}
+			continue
+		}
+		n := total - len(c.writePending)
+		if n > len(p) {
+			n = len(p)
+		}
+		c.writePending = append(c.writePending, p[:n]...)
+		written += n
+		p = p[n:]
+		if len(c.writePending) == total {
+			packet := c.inspectServerPacket(packetType, c.writePending)
+			c.writePending = nil
+			if _, err := c.ReadWriteCloser.Write(packet); err != nil {
+				return written, err
+			}
+			c.writeCond.Broadcast()
+		}
+	}
+	return written, nil
+}
+
+// inspectServerPacket binds the handles returned for the open requests to the
+// transfers and adds the supported extensions to the version packet
+func (c *sftpExtensionsChannel) inspectServerPacket(packetType byte, packet []byte) []byte {
+	if packetType == sftpPacketVersion {
+		for _, ext := range c.getExtensions() {
+			packet = sftpMarshalString(packet, ext)
+			packet = sftpMarshalString(packet, "1")
+		}
+		binary.BigEndian.PutUint32(packet[:4], uint32(len(packet)-4))
+		return packet
+	}
+	id, data, err := sftpUnmarshalUint32(packet[5:])
+	if err != nil {
+		return packet
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.pendingOpens[id] {
+		return packet
+	}
+	delete(c.pendingOpens, id)
+	if packetType == sftpPacketHandle {
+		if handle, _, err := sftpUnmarshalString(data); err == nil {
+			c.connection.sftpTransfers.setHandle(handle)
+		}
+	}
+	return packet
+}
+
+// writeStatus sends a status packet
+func (c *sftpExtensionsChannel) writeStatus(id uint32, err error) error {
+	code, msg := getSFTPStatusFromError(err)
+	packet := make([]byte, 4, 64+len(msg))
+	packet = append(packet, sftpPacketStatus)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = binary.BigEndian.AppendUint32(packet, code)
+	packet = sftpMarshalString(packet, msg)
+	packet = sftpMarshalString(packet, "")
+	binary.BigEndian.PutUint32(packet[:4], uint32(len(packet)-4))
+
+	return c.writePacket(packet)
+}
+
+// writeLimits sends the reply for the "limits@openssh.com" extension.
+// There is no limit for the open handles so 0 is returned
+func (c *sftpExtensionsChannel) writeLimits(id uint32) error {
+	packet := make([]byte, 4, 41)
+	packet = append(packet, sftpPacketExtendedReply)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = binary.BigEndian.AppendUint64(packet, sftpLimitMaxPacketLength)
+	packet = binary.BigEndian.AppendUint64(packet, sftpLimitMaxReadLength)
+	packet = binary.BigEndian.AppendUint64(packet, sftpLimitMaxWriteLength)
+	packet = binary.BigEndian.AppendUint64(packet, 0)
+	binary.BigEndian.PutUint32(packet[:4], uint32(len(packet)-4))
+
+	return c.writePacket(packet)
+}
+
+// writeExtendedReply sends an extended reply packet with the specified data
+func (c *sftpExtensionsChannel) writeExtendedReply(id uint32, data []byte) error {
+	packet := make([]byte, 4, 9+len(data))
+	packet = append(packet, sftpPacketExtendedReply)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = append(packet, data...)
+	binary.BigEndian.PutUint32(packet[:4], uint32(len(packet)-4))
+
+	return c.writePacket(packet)
+}
+
+// writePacket sends a packet generated by the extensions, it waits for the
+// packet being written by the SFTP server, if any, to be completed
+func (c *sftpExtensionsChannel) writePacket(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	for c.writeRemaining > 0 || len(c.writePending) > 0 {
+		c.writeCond.Wait()
+	}
+	_, err := c.ReadWriteCloser.Write(packet)
+	return err
+}
+
+// sftpTransfers binds the handles returned by the SFTP server to the
+// transfers. The SFTP server processes the open requests sequentially and
+// sends the responses in the same order, so a transfer is queued when a file
+// is opened and it is bound to the handle returned in the next successful
+// open response
+type sftpTransfers struct {
+	sync.Mutex
+	opened  []*transfer
+	handles map[string]*transfer
+}
+
+func (s *sftpTransfers) add(t *transfer) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.opened = append(s.opened, t)
+}
+
+func (s *sftpTransfers) setHandle(handle string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.opened) == 0 {
+		return
+	}
+	if s.handles == nil {
+		s.handles = make(map[string]*transfer)
+	}
+	s.handles[handle] = s.opened[0]
+	s.opened[0] = nil
+	s.opened = s.opened[1:]
+}
+
+func (s *sftpTransfers) remove(handle string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.handles, handle)
+}
+
+func (s *sftpTransfers) get(handle string) (*transfer, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	t, ok := s.handles[handle]
+	if !ok || t.isClosed() {
+		return nil, fmt.Errorf("invalid handle %q: %w", handle, sftp.ErrSSHFxFailure)
+	}
+	return t, nil
+}
+
+func getSFTPStatusFromError(err error) (uint32, string) {
+	switch {
+	case err == nil:
+		return sftpStatusOK, ""
+	case errors.Is(err, sftp.ErrSSHFxEOF), errors.Is(err, io.EOF):
+		return sftpStatusEOF, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxNoSuchFile), errors.Is(err, os.ErrNotExist):
+		return sftpStatusNoSuchFile, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxPermissionDenied), errors.Is(err, os.ErrPermission):
+		return sftpStatusPermissionDenied, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxBadMessage), errors.Is(err, errSFTPBadMessage):
+		return sftpStatusBadMessage, err.Error()
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return sftpStatusOpUnsupported, err.Error()
+	default:
+		return sftpStatusFailure, err.Error()
+	}
+}
+
+func sftpUnmarshalUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errSFTPBadMessage
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func sftpUnmarshalUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errSFTPBadMessage
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+func sftpUnmarshalString(b []byte) (string, []byte, error) {
+	n, b, err := sftpUnmarshalUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(b)) < n {
+		return "", nil, errSFTPBadMessage
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func sftpMarshalString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -33,6 +33,8 @@ import (
 
 	"github.com/google/shlex"
 	"github.com/sftpgo/sdk"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -45,11 +47,15 @@ import (
 
 const (
 	scpCmdName          = "scp"
+	borgCmdName         = "borg"
 	sshCommandLogSender = "SSHCommand"
 )
 
 var (
 	errUnsupportedConfig = errors.New("command unsupported for this configuration")
+	// borg serve options accepted from the clients, everything else is ignored
+	borgServeAllowedOptions = []string{"--info", "--debug", "--warning", "--error", "--critical", "--log-json",
+		"-v", "--verbose"}
 )
 
 type sshCommand struct {
@@ -62,6 +68,7 @@ type sshCommand struct {
 type systemCommand struct {
 	cmd            *exec.Cmd
 	fsPath         string
+	virtualPath    string
 	quotaCheckPath string
 	fs             vfs.Fs
 }
@@ -157,6 +164,8 @@ func (c *sshCommand) handle() (err error) {
 		return c.handleSFTPGoCopy()
 	} else if c.command == "sftpgo-remove" {
 		return c.handleSFTPGoRemove()
+	} else if c.command == tarCmdName {
+		return c.handleSFTPGoTar()
 	}
 	return
 }
@@ -211,6 +220,10 @@ func (c *sshCommand) handleHashCommands() error {
 		h = sha256.New()
 	} else if c.command == "sha384sum" {
 		h = sha512.New384()
+	} else if c.command == "blake3sum" {
+		h = blake3.New()
+	} else if c.command == "xxh128sum" {
+		h = newXXH128Hash()
 	} else {
 		h = sha512.New()
 	}
@@ -249,23 +262,30 @@ func (c *sshCommand) handleHashCommands() error {
 }
 
 func (c *sshCommand) executeSystemCommand(command systemCommand) error {
-	sshDestPath := c.getDestPath()
+	sshDestPath := command.virtualPath
 	if !c.isLocalPath(sshDestPath) {
 		return c.sendErrorResponse(errUnsupportedConfig)
 	}
-	diskQuota, transferQuota := c.connection.HasSpace(true, false, command.quotaCheckPath)
-	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() || !transferQuota.HasDownloadSpace() {
+	readOnly := c.isReadOnlySystemCommand()
+	diskQuota, transferQuota := c.connection.HasSpace(!readOnly, false, command.quotaCheckPath)
+	if !transferQuota.HasDownloadSpace() {
+		return c.sendErrorResponse(common.ErrQuotaExceeded)
+	}
+	if !readOnly && (!diskQuota.HasSpace || !transferQuota.HasUploadSpace()) {
 		return c.sendErrorResponse(common.ErrQuotaExceeded)
 	}
-	perms := []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs, dataprovider.PermListItems,
-		dataprovider.PermOverwrite, dataprovider.PermDelete}
-	if !c.connection.User.HasPerms(perms, sshDestPath) {
+	if !c.connection.User.HasPerms(c.getSystemCommandPerms(), sshDestPath) {
 		return c.sendErrorResponse(c.connection.GetPermissionDeniedError())
 	}
 
-	initialFiles, initialSize, err := c.getSizeForPath(command.fs, command.fsPath)
-	if err != nil {
-		return c.sendErrorResponse(err)
+	var initialFiles int
+	var initialSize int64
+	var err error
+	if !readOnly {
+		initialFiles, initialSize, err = c.getSizeForPath(command.fs, command.fsPath)
+		if err != nil {
+			return c.sendErrorResponse(err)
+		}
 	}
 
 	stdin, stdout, stderr, err := command.GetSTDs()
@@ -288,14 +308,27 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	commandResponse := make(chan bool)
 
 	remainingQuotaSize := diskQuota.GetRemainingSize()
+	uploadTransferQuota := transferQuota
+	if readOnly {
+		// the data sent by the client to a read only command, for example the
+		// wants and haves negotiated by git-upload-pack, are not stored
+		remainingQuotaSize = 0
+		uploadTransferQuota = dataprovider.TransferQuota{}
+	}
+	stdinTransfer := newTransfer(common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath,
+		command.fsPath, sshDestPath, common.TransferUpload, 0, 0, remainingQuotaSize, 0, false, command.fs,
+		uploadTransferQuota), nil, nil, nil)
+	stdoutTransfer := newTransfer(common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath,
+		command.fsPath, sshDestPath, common.TransferDownload, 0, 0, 0, 0, false, command.fs, transferQuota),
+		nil, nil, nil)
+	stderrTransfer := newTransfer(common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath,
+		command.fsPath, sshDestPath, common.TransferDownload, 0, 0, 0, 0, false, command.fs, transferQuota),
+		nil, nil, nil)
 
 	go func() {
 		defer stdin.Close()
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, command.fsPath, sshDestPath,
-			common.TransferUpload, 0, 0, remainingQuotaSize, 0, false, command.fs, transferQuota)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
 
-		w, e := transfer.copyFromReaderToWriter(stdin, c.connection.channel)
+		w, e := stdinTransfer.copyFromReaderToWriter(stdin, c.connection.channel)
 		c.connection.Log(logger.LevelDebug, "command: %q, copy from remote command to sdtin ended, written: %v, "+
 			"initial remaining quota: %v, err: %v", c.connection.command, w, remainingQuotaSize, e)
 		if e != nil {
@@ -304,11 +337,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	}()
 
 	go func() {
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, command.fsPath, sshDestPath,
-			common.TransferDownload, 0, 0, 0, 0, false, command.fs, transferQuota)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
-
-		w, e := transfer.copyFromReaderToWriter(c.connection.channel, stdout)
+		w, e := stdoutTransfer.copyFromReaderToWriter(c.connection.channel, stdout)
 		c.connection.Log(logger.LevelDebug, "command: %q, copy from sdtout to remote command ended, written: %v err: %v",
 			c.connection.command, w, e)
 		if e != nil {
@@ -318,11 +347,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	}()
 
 	go func() {
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, command.fsPath, sshDestPath,
-			common.TransferDownload, 0, 0, 0, 0, false, command.fs, transferQuota)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
-
-		w, e := transfer.copyFromReaderToWriter(c.connection.channel.(ssh.Channel).Stderr(), stderr)
+		w, e := stderrTransfer.copyFromReaderToWriter(c.connection.channel.(ssh.Channel).Stderr(), stderr)
 		c.connection.Log(logger.LevelDebug, "command: %q, copy from sdterr to remote command ended, written: %v err: %v",
 			c.connection.command, w, e)
 		// os.ErrClosed means that the command is finished so we don't need to do anything
@@ -334,7 +359,16 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	<-commandResponse
 	err = command.cmd.Wait()
 	c.sendExitStatus(err)
+	c.updateSystemCommandTransferQuota(transferQuota, stdinTransfer.BytesReceived.Load(),
+		stdoutTransfer.BytesSent.Load()+stderrTransfer.BytesSent.Load(), readOnly)
 
+	if readOnly {
+		c.connection.Log(logger.LevelDebug, "read only command %q finished for path %q", c.connection.command,
+			command.fsPath)
+		return c.connection.GetFsError(command.fs, err)
+	}
+	// system commands can modify any file inside the destination path
+	c.connection.InvalidateDirQuotaUsage(sshDestPath)
 	numFiles, dirSize, errSize := c.getSizeForPath(command.fs, command.fsPath)
 	if errSize == nil {
 		c.updateQuota(sshDestPath, numFiles-initialFiles, dirSize-initialSize)
@@ -345,8 +379,43 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	return c.connection.GetFsError(command.fs, err)
 }
 
-func (c *sshCommand) isSystemCommandAllowed() error {
-	sshDestPath := c.getDestPath()
+// updateSystemCommandTransferQuota adds the bytes exchanged with a system command
+// to the user's transfer quota. For read only commands only the sent bytes are
+// counted, they are charged against the download transfer quota
+func (c *sshCommand) updateSystemCommandTransferQuota(transferQuota dataprovider.TransferQuota, ulSize, dlSize int64,
+	readOnly bool,
+) {
+	if !transferQuota.HasSizeLimits() {
+		return
+	}
+	if readOnly {
+		ulSize = 0
+	}
+	c.connection.Log(logger.LevelDebug, "command %q, update transfer quota, uploaded: %d, downloaded: %d",
+		c.connection.command, ulSize, dlSize)
+	dataprovider.UpdateUserTransferQuota(&c.connection.User, ulSize, dlSize, false) //nolint:errcheck
+}
+
+// isReadOnlySystemCommand returns true if the system command only reads from
+// the filesystem, for example git-upload-pack used for git clone and fetch
+func (c *sshCommand) isReadOnlySystemCommand() bool {
+	return util.Contains(readOnlySystemCommands, c.command)
+}
+
+func (c *sshCommand) getSystemCommandPerms() []string {
+	if c.isReadOnlySystemCommand() {
+		return []string{dataprovider.PermDownload, dataprovider.PermListItems}
+	}
+	if c.command == borgCmdName && c.connection.User.Filters.AppendOnly {
+		// borg serve enforces the append-only mode itself
+		return []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
+			dataprovider.PermListItems}
+	}
+	return []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
+		dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete}
+}
+
+func (c *sshCommand) isSystemCommandAllowed(sshDestPath string) error {
 	if c.connection.User.IsVirtualFolder(sshDestPath) {
 		// overlapped virtual path are not allowed
 		return nil
@@ -384,6 +453,9 @@ func (c *sshCommand) isSystemCommandAllowed() error {
 }
 
 func (c *sshCommand) getSystemCommand() (systemCommand, error) {
+	if c.command == borgCmdName {
+		return c.getBorgServeCommand()
+	}
 	command := systemCommand{
 		cmd:            nil,
 		fs:             nil,
@@ -421,7 +493,7 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 		args = args[:len(args)-1]
 		args = append(args, fsPath)
 	}
-	if err := c.isSystemCommandAllowed(); err != nil {
+	if err := c.isSystemCommandAllowed(sshPath); err != nil {
 		return command, errUnsupportedConfig
 	}
 	if c.command == "rsync" {
@@ -449,11 +521,61 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	cmd = wrapCmd(cmd, uid, gid)
 	command.cmd = cmd
 	command.fsPath = fsPath
+	command.virtualPath = sshPath
 	command.quotaCheckPath = quotaPath
 	command.fs = fs
 	return command, nil
 }
 
+// getBorgServeCommand returns the command to execute for "borg serve".
+// The repository paths are sent by the client using the borg RPC protocol,
+// so borg is restricted to the user home dir and it is started inside it,
+// this way relative repository paths such as ssh://user@host:2022/./repo work.
+// If the user is in append-only mode borg will not remove data from the
+// repositories
+func (c *sshCommand) getBorgServeCommand() (systemCommand, error) {
+	command := systemCommand{}
+	if err := common.CheckClosing(); err != nil {
+		return command, err
+	}
+	if len(c.args) == 0 || c.args[0] != "serve" {
+		return command, errors.New("only borg serve is supported")
+	}
+	sshPath := "/"
+	if err := c.isSystemCommandAllowed(sshPath); err != nil {
+		return command, errUnsupportedConfig
+	}
+	fs, err := c.connection.User.GetFilesystemForPath(sshPath, c.connection.ID)
+	if err != nil {
+		return command, err
+	}
+	fsPath, err := fs.ResolvePath(sshPath)
+	if err != nil {
+		return command, c.connection.GetFsError(fs, err)
+	}
+	args := []string{"serve", "--restrict-to-path", fsPath}
+	if c.connection.User.Filters.AppendOnly {
+		args = append(args, "--append-only")
+	}
+	for _, arg := range c.args[1:] {
+		if util.Contains(borgServeAllowedOptions, arg) || strings.HasPrefix(arg, "--umask=") {
+			args = append(args, arg)
+			continue
+		}
+		c.connection.Log(logger.LevelDebug, "borg serve option %q not allowed, ignored", arg)
+	}
+	c.connection.Log(logger.LevelDebug, "new borg command, with args: %+v fs path %q", args, fsPath)
+	cmd := exec.Command(c.command, args...)
+	cmd.Dir = fsPath
+	cmd = wrapCmd(cmd, c.connection.User.GetUID(), c.connection.User.GetGID())
+	command.cmd = cmd
+	command.fsPath = fsPath
+	command.virtualPath = sshPath
+	command.quotaCheckPath = sshPath
+	command.fs = fs
+	return command, nil
+}
+
 // for the supported commands, the destination path, if any, is the last argument
 func (c *sshCommand) getDestPath() string {
 	if len(c.args) == 0 {
@@ -590,13 +712,34 @@ func (c *sshCommand) computeHashForFile(fs vfs.Fs, hasher hash.Hash, path string
 		reader = r
 	}
 	defer reader.Close()
-	_, err = io.Copy(hasher, reader)
+	_, err = copyBuffer(hasher, reader)
 	if err == nil {
 		hash = fmt.Sprintf("%x", hasher.Sum(nil))
 	}
 	return hash, err
 }
 
+// xxh128Hash is an hash.Hash returning the 128 bit XXH3 digest, in canonical
+// big endian format, as xxh128sum does
+type xxh128Hash struct {
+	*xxh3.Hasher
+}
+
+func newXXH128Hash() hash.Hash {
+	return &xxh128Hash{
+		Hasher: xxh3.New(),
+	}
+}
+
+func (h *xxh128Hash) Size() int {
+	return 16
+}
+
+func (h *xxh128Hash) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
+
 func parseCommandPayload(command string) (string, []string, error) {
 	parts, err := shlex.Split(command)
 	if err == nil && len(parts) == 0 {
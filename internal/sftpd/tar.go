@@ -0,0 +1,213 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const tarCmdName = "sftpgo-tar"
+
+var errTarUsage = errors.New("usage sftpgo-tar [-z] <source path>")
+
+// handleSFTPGoTar streams the requested path as a tar archive, gzip compressed
+// if the "-z" flag is set. This way a whole tree can be downloaded using a
+// single command without the per file round trips required by SCP
+func (c *sshCommand) handleSFTPGoTar() error {
+	compress, err := c.parseTarArgs()
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	sshSourcePath := c.getDestPath()
+	info, err := c.connection.DoStat(sshSourcePath, 1, true)
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	if !info.IsDir() && !c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(sshSourcePath)) {
+		return c.sendErrorResponse(c.connection.GetPermissionDeniedError())
+	}
+	c.connection.Log(logger.LevelDebug, "requested tar archive for %q, compress: %t", sshSourcePath, compress)
+
+	var w io.Writer = c.connection.channel
+	var gzw *gzip.Writer
+	if compress {
+		gzw = gzip.NewWriter(w)
+		w = gzw
+	}
+	tw := tar.NewWriter(w)
+	baseDir := path.Dir(sshSourcePath)
+	if err = c.addTarEntry(tw, sshSourcePath, baseDir, 0); err == nil {
+		err = tw.Close()
+		if err == nil && gzw != nil {
+			err = gzw.Close()
+		}
+	}
+	if err != nil {
+		// the archive is sent on stdout so we can only report errors on stderr
+		c.connection.channel.(ssh.Channel).Stderr().Write([]byte(fmt.Sprintf("%v: %v %v\n", //nolint:errcheck
+			c.command, sshSourcePath, err)))
+	}
+	c.sendExitStatus(err)
+	return err
+}
+
+func (c *sshCommand) parseTarArgs() (bool, error) {
+	if len(c.args) == 0 || c.getDestPath() == "" {
+		return false, errTarUsage
+	}
+	compress := false
+	for _, arg := range c.args[:len(c.args)-1] {
+		if arg != "-z" {
+			return false, errTarUsage
+		}
+		compress = true
+	}
+	return compress, nil
+}
+
+func (c *sshCommand) addTarEntry(tw *tar.Writer, entryPath, baseDir string, recursion int) error {
+	if recursion >= util.MaxRecursion {
+		c.connection.Log(logger.LevelDebug, "unable to add tar entry %q, recursion too depth: %d", entryPath, recursion)
+		return util.ErrRecursionTooDeep
+	}
+	recursion++
+	c.connection.UpdateLastActivity()
+	info, err := c.connection.DoStat(entryPath, 1, true)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to add tar entry %q, stat error: %v", entryPath, err)
+		return err
+	}
+	entryName := strings.TrimPrefix(strings.TrimPrefix(entryPath, baseDir), "/")
+	if entryName == "" {
+		entryName = path.Base(entryPath)
+	}
+	if info.IsDir() {
+		if !c.connection.User.HasPerm(dataprovider.PermDownload, entryPath) {
+			return c.connection.GetPermissionDeniedError()
+		}
+		if err := tw.WriteHeader(getTarHeader(info, entryName+"/")); err != nil {
+			return err
+		}
+		lister, err := c.connection.ListDir(entryPath)
+		if err != nil {
+			c.connection.Log(logger.LevelDebug, "unable to add tar entry %q, list dir error: %v", entryPath, err)
+			return err
+		}
+		defer lister.Close()
+
+		for {
+			contents, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				return err
+			}
+			for _, info := range contents {
+				fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
+				if err := c.addTarEntry(tw, fullPath, baseDir, recursion); err != nil {
+					return err
+				}
+			}
+			if finished {
+				return nil
+			}
+		}
+	}
+	if !info.Mode().IsRegular() {
+		// we only allow regular files
+		c.connection.Log(logger.LevelInfo, "skipping tar entry for non regular file %q", entryPath)
+		return nil
+	}
+	return c.addFileToTarEntry(tw, entryPath, entryName, info)
+}
+
+func (c *sshCommand) addFileToTarEntry(tw *tar.Writer, entryPath, entryName string, info os.FileInfo) error {
+	transferQuota := c.connection.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.connection.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return c.connection.GetReadQuotaExceededError()
+	}
+	if ok, policy := c.connection.User.IsFileAllowed(entryPath); !ok {
+		c.connection.Log(logger.LevelWarn, "reading file %q is not allowed", entryPath)
+		return c.connection.GetErrorForDeniedFile(policy)
+	}
+	fs, p, err := c.connection.GetFsAndResolvedPath(entryPath)
+	if err != nil {
+		return err
+	}
+	if _, err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreDownload, p, entryPath, 0, 0); err != nil {
+		c.connection.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", entryPath, err)
+		return c.connection.GetPermissionDeniedError()
+	}
+	startTime := time.Now()
+	file, r, cancelFn, err := fs.Open(p, 0)
+	c.connection.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
+	if err != nil {
+		c.connection.Log(logger.LevelError, "could not open file %q for reading: %v", p, err)
+		return c.connection.GetFsError(fs, err)
+	}
+	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, p, entryPath,
+		common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+	t := newTransfer(baseTransfer, nil, r, nil)
+
+	err = tw.WriteHeader(getTarHeader(info, entryName))
+	if err == nil {
+		var n int64
+		n, err = copyBuffer(tw, &transferReader{t: t})
+		if err == nil && n != info.Size() {
+			err = fmt.Errorf("file %q changed while reading, expected size: %d, read: %d", entryPath, info.Size(), n)
+		}
+	}
+	// we need to call Close anyway and return close error if any and
+	// if we have no previous error
+	if err == nil {
+		err = t.Close()
+	} else {
+		t.TransferError(err)
+		t.Close()
+	}
+	return err
+}
+
+func getTarHeader(info os.FileInfo, name string) *tar.Header {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	if info.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+	} else {
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+	}
+	return hdr
+}
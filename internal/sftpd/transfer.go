@@ -17,12 +17,25 @@ package sftpd
 import (
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
+const transferBufferSize = 32768
+
+// transferBuffers recycles the buffers used to copy data for SCP and SSH commands,
+// this way we don't allocate a new buffer for each transfer
+var transferBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, transferBufferSize)
+		return &buf
+	},
+}
+
 type writerAtCloser interface {
 	io.WriterAt
 	io.Closer
@@ -103,7 +116,9 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter vfs.PipeWriter, p
 func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = t.readerAt.ReadAt(p, off)
+	t.UpdateIOMetrics(startTime)
 	t.BytesSent.Add(int64(n))
 
 	if err == nil {
@@ -130,7 +145,9 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 		return 0, err
 	}
 
+	startTime := time.Now()
 	n, err = t.writerAt.WriteAt(p, off)
+	t.UpdateIOMetrics(startTime)
 	t.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -174,6 +191,9 @@ func (t *transfer) closeIO() error {
 			t.ErrTransfer = err
 		}
 		t.Unlock()
+		if metadater, ok := t.writerAt.(vfs.Metadater); ok {
+			t.BaseTransfer.AddMetadata(metadater.Metadata())
+		}
 	} else if t.readerAt != nil {
 		err = t.readerAt.Close()
 		if metadater, ok := t.readerAt.(vfs.Metadater); ok {
@@ -193,6 +213,13 @@ func (t *transfer) setFinished() error {
 	return nil
 }
 
+func (t *transfer) isClosed() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.isFinished
+}
+
 // used for ssh commands.
 // It reads from src until EOF so it does not treat an EOF from Read as an error to be reported.
 // EOF from Write is reported as error
@@ -206,7 +233,10 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 		return 0, common.ErrQuotaExceeded
 	}
 	isDownload := t.GetType() == common.TransferDownload
-	buf := make([]byte, 32768)
+	bufPtr := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(bufPtr)
+
+	buf := *bufPtr
 	for {
 		t.Connection.UpdateLastActivity()
 		nr, er := src.Read(buf)
@@ -252,3 +282,55 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 	}
 	return written, err
 }
+
+// transferReader adapts a transfer to io.Reader, it reads sequentially
+// starting from offset 0
+type transferReader struct {
+	t      *transfer
+	offset int64
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.t.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// transferWriter adapts a transfer to io.Writer, it writes sequentially
+// starting from offset 0
+type transferWriter struct {
+	t      *transfer
+	offset int64
+	// the last write error, it allows to distinguish write and read errors
+	err error
+}
+
+func (w *transferWriter) Write(p []byte) (int, error) {
+	n, err := w.t.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// copyBuffer copies from src to dst until EOF using a pooled buffer
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	bufPtr := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(bufPtr)
+
+	return io.CopyBuffer(dst, src, *bufPtr)
+}
+
+// copyN copies n bytes from src to dst using a pooled buffer.
+// Like io.CopyN it returns io.EOF if src ends before n bytes are copied
+func copyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := copyBuffer(dst, io.LimitReader(src, n))
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
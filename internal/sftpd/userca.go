@@ -0,0 +1,209 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultUserCertValidity = 60 * time.Minute
+	// issued certificates are valid a bit in the past to tolerate clock skews
+	userCertClockSkew = 5 * time.Minute
+)
+
+var userCA userCertAuthority
+
+// userCertAuthority signs short-lived SSH user certificates
+type userCertAuthority struct {
+	mu       sync.RWMutex
+	signer   ssh.Signer
+	validity time.Duration
+}
+
+func (a *userCertAuthority) set(signer ssh.Signer, validity time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.signer = signer
+	a.validity = validity
+}
+
+func (a *userCertAuthority) isEnabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.signer != nil
+}
+
+func (a *userCertAuthority) isAuthority(key ssh.PublicKey) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.signer != nil && bytes.Equal(a.signer.PublicKey().Marshal(), key.Marshal())
+}
+
+// checkCert checks the certificates signed by our CA. The principals include
+// the group names so the key ID must match the user logging in
+func (a *userCertAuthority) checkCert(username string, cert *ssh.Certificate) error {
+	if !a.isAuthority(cert.SignatureKey) {
+		return nil
+	}
+	if cert.KeyId != username {
+		return fmt.Errorf("ssh: certificate with key ID %q is not valid for user %q", cert.KeyId, username)
+	}
+	return nil
+}
+
+func (a *userCertAuthority) sign(user *dataprovider.User, pubKey ssh.PublicKey) (*ssh.Certificate, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.signer == nil {
+		return nil, util.NewMethodDisabledError("SSH user certificates issuance is not enabled")
+	}
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	principals := []string{user.Username}
+	for _, g := range user.Groups {
+		principals = append(principals, g.Name)
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           user.Username,
+		ValidPrincipals: util.RemoveDuplicates(principals, false),
+		ValidAfter:      uint64(now.Add(-userCertClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(a.validity).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, a.signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (c *Configuration) loadUserCA(configDir string) error {
+	userCA.set(nil, 0)
+	if c.UserCAKey == "" {
+		return nil
+	}
+	if !util.IsFileInputValid(c.UserCAKey) {
+		return fmt.Errorf("invalid user CA key: %q", c.UserCAKey)
+	}
+	keyPath := c.UserCAKey
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		logger.Warn(logSender, "", "error loading user CA key %q: %v", keyPath, err)
+		logger.WarnToConsole("error loading user CA key %q: %v", keyPath, err)
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		logger.Warn(logSender, "", "error parsing user CA key %q: %v", keyPath, err)
+		logger.WarnToConsole("error parsing user CA key %q: %v", keyPath, err)
+		return err
+	}
+	validity := time.Duration(c.UserCertValidity) * time.Minute
+	if validity <= 0 {
+		validity = defaultUserCertValidity
+	}
+	userCA.set(signer, validity)
+	c.parsedUserCAKeys = append(c.parsedUserCAKeys, signer.PublicKey())
+	logger.Info(logSender, "", "user CA key %q loaded, certificates validity: %s, fingerprint: %s", keyPath,
+		validity, ssh.FingerprintSHA256(signer.PublicKey()))
+	return nil
+}
+
+// IsUserCAEnabled returns true if a user certificate authority is configured
+// and so SSH user certificates can be issued
+func IsUserCAEnabled() bool {
+	return userCA.isEnabled()
+}
+
+// IssueUserCertificate signs a short-lived SSH user certificate for the
+// specified public key. The user must be allowed to login using SSH public
+// keys. The principals are the username and the names of the user groups
+func IssueUserCertificate(user *dataprovider.User, publicKey string) (*dataprovider.SSHUserCertificate, error) {
+	if !userCA.isEnabled() {
+		return nil, util.NewMethodDisabledError("SSH user certificates issuance is not enabled")
+	}
+	if util.Contains(user.Filters.DeniedProtocols, common.ProtocolSSH) ||
+		!user.IsLoginMethodAllowed(dataprovider.SSHLoginMethodPublicKey, common.ProtocolSSH) {
+		return nil, util.NewMethodDisabledError(fmt.Sprintf("user %q is not allowed to login using SSH public keys",
+			user.Username))
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, util.NewI18nError(
+			util.NewValidationError(fmt.Sprintf("invalid public key: %v", err)),
+			util.I18nErrorPubKeyInvalid,
+		)
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		return nil, util.NewI18nError(
+			util.NewValidationError("certificates cannot be signed, please provide a public key"),
+			util.I18nErrorPubKeyInvalid,
+		)
+	}
+	if !user.Filters.SSHAlgorithms.IsPublicKeyAllowed(pubKey) {
+		return nil, util.NewValidationError(fmt.Sprintf("public key type %q is not allowed for user %q",
+			pubKey.Type(), user.Username))
+	}
+	cert, err := userCA.sign(user, pubKey)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to sign certificate for user %q: %v", user.Username, err)
+		return nil, err
+	}
+	logger.Info(logSender, "", "issued SSH certificate for user %q, serial: %d, principals: %v, key: %s, valid until: %s",
+		user.Username, cert.Serial, cert.ValidPrincipals, ssh.FingerprintSHA256(pubKey),
+		time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339))
+	return &dataprovider.SSHUserCertificate{
+		Username:    user.Username,
+		KeyID:       cert.KeyId,
+		Serial:      cert.Serial,
+		Principals:  cert.ValidPrincipals,
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+		ValidAfter:  util.GetTimeAsMsSinceEpoch(time.Unix(int64(cert.ValidAfter), 0)),
+		ValidBefore: util.GetTimeAsMsSinceEpoch(time.Unix(int64(cert.ValidBefore), 0)),
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+	}, nil
+}
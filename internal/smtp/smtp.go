@@ -73,6 +73,16 @@ func (c *activeConfig) isEnabled() bool {
 	return c.config != nil && c.config.Host != ""
 }
 
+func (c *activeConfig) setInitialConfig(cfg *Config) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.config == initialConfig {
+		c.config = cfg
+	}
+	initialConfig = cfg
+}
+
 func (c *activeConfig) Set(cfg *dataprovider.SMTPConfigs) {
 	var config *Config
 	if cfg != nil {
@@ -278,6 +288,19 @@ func (c *Config) Initialize(configDir string, isService bool) error {
 	return loadConfigFromProvider()
 }
 
+// Reload applies the SMTP configuration, the email templates are not reloaded.
+// The configuration stored in the data provider, if any, still takes precedence
+func (c *Config) Reload() error {
+	initial := c
+	if c.Host == "" {
+		initial = nil
+	} else if err := c.validate(); err != nil {
+		return err
+	}
+	config.setInitialConfig(initial)
+	return loadConfigFromProvider()
+}
+
 func (c *Config) getMailClientOptions() []mail.Option {
 	options := []mail.Option{mail.WithPort(c.Port), mail.WithoutNoop()}
 
@@ -30,6 +30,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -54,6 +55,9 @@ type Conf struct {
 	// Enable the built-in profiler.
 	// The profiler will be accessible via HTTP/HTTPS using the base URL "/debug/pprof/"
 	EnableProfiler bool `json:"enable_profiler" mapstructure:"enable_profiler"`
+	// Enable the metrics with the username label, for example the transferred bytes for each user.
+	// The number of time series grows with the number of users so they are disabled by default
+	EnableUserMetrics bool `json:"enable_user_metrics" mapstructure:"enable_user_metrics"`
 	// Path to a file used to store usernames and password for basic authentication.
 	// This can be an absolute path or a path relative to the config dir.
 	// We support HTTP basic authentication and the file format must conform to the one generated using the Apache
@@ -105,6 +109,7 @@ func (c Conf) Initialize(configDir string) error {
 	}
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	metric.SetUserMetrics(c.EnableUserMetrics)
 	initializeRouter(c.EnableProfiler)
 	httpServer := &http.Server{
 		Handler:           router,
@@ -28,6 +28,8 @@ const (
 	I18nSharesTitle                    = "title.shares"
 	I18nShareAddTitle                  = "title.add_share"
 	I18nShareUpdateTitle               = "title.update_share"
+	I18nTrashTitle                     = "title.trash"
+	I18nSearchTitle                    = "title.search"
 	I18nProfileTitle                   = "title.profile"
 	I18nUsersTitle                     = "title.users"
 	I18nGroupsTitle                    = "title.groups"
@@ -36,6 +38,7 @@ const (
 	I18n2FATitle                       = "title.two_factor_auth"
 	I18nEditFileTitle                  = "title.edit_file"
 	I18nViewFileTitle                  = "title.view_file"
+	I18nPreviewTitle                   = "title.preview"
 	I18nForgotPwdTitle                 = "title.recovery_password"
 	I18nResetPwdTitle                  = "title.reset_password"
 	I18nSharedFilesTitle               = "title.shared_files"
@@ -51,6 +54,8 @@ const (
 	I18nError500Title                  = "title.error500"
 	I18nErrorPDFTitle                  = "title.errorPDF"
 	I18nErrorEditorTitle               = "title.error_editor"
+	I18nErrorPreviewTitle              = "title.error_preview"
+	I18nErrorWOPITitle                 = "title.error_wopi"
 	I18nAddUserTitle                   = "title.add_user"
 	I18nUpdateUserTitle                = "title.update_user"
 	I18nAddAdminTitle                  = "title.add_admin"
@@ -60,14 +65,19 @@ const (
 	I18nConfigsTitle                   = "title.configs"
 	I18nOAuth2Title                    = "title.oauth2_success"
 	I18nOAuth2ErrorTitle               = "title.oauth2_error"
+	I18nOAuth2ConsentTitle             = "title.oauth2_consent"
 	I18nSessionsTitle                  = "title.connections"
 	I18nRolesTitle                     = "title.roles"
+	I18nTenantsTitle                   = "title.tenants"
+	I18nTenantAddTitle                 = "title.add_tenant"
+	I18nTenantUpdateTitle              = "title.update_tenant"
 	I18nAdminsTitle                    = "title.admins"
 	I18nIPListsTitle                   = "title.ip_lists"
 	I18nAddIPListTitle                 = "title.add_ip_list"
 	I18nUpdateIPListTitle              = "title.update_ip_list"
 	I18nDefenderTitle                  = "title.defender"
 	I18nEventsTitle                    = "title.logs"
+	I18nLiveEventsTitle                = "title.live_events"
 	I18nActionsTitle                   = "title.event_actions"
 	I18nRulesTitle                     = "title.event_rules"
 	I18nAddActionTitle                 = "title.add_action"
@@ -127,6 +137,11 @@ const (
 	I18nErrorNoRootPermission          = "user.no_root_permissions"
 	I18nErrorGenericPermission         = "user.err_permissions_generic"
 	I18nError2FAInvalid                = "user.2fa_invalid"
+	I18nError2FAExemptionInvalid       = "user.2fa_exemption_invalid"
+	I18nErrorPortForwardingInvalid     = "user.port_forwarding_invalid"
+	I18nErrorProtocolBandwidthInvalid  = "user.protocol_bandwidth_invalid"
+	I18nErrorSSHAlgorithmsInvalid      = "user.ssh_algorithms_invalid"
+	I18nErrorFTPPassiveIPInvalid       = "user.ftp_passive_ip_invalid"
 	I18nErrorRecoveryCodesInvalid      = "user.recovery_codes_invalid"
 	I18nErrorFolderNameRequired        = "general.foldername_required"
 	I18nErrorFolderMountPathRequired   = "user.folder_path_required"
@@ -168,10 +183,43 @@ const (
 	I18nErrorShareBrowsePaths          = "share.browsable_multiple_paths"
 	I18nErrorShareBrowseNoDir          = "share.browsable_non_dir"
 	I18nErrorShareInvalidPath          = "share.invalid_path"
+	I18nErrorShareMaxUploaderSize      = "share.max_uploader_size_invalid"
+	I18nErrorShareUploaderRequired     = "share.uploader_required"
+	I18nErrorShareUploaderSize         = "share.uploader_size_exceeded"
+	I18nErrorShareMaxFileDownloads     = "share.max_file_downloads_invalid"
+	I18nErrorShareFileDownloads        = "share.file_downloads_exceeded"
+	I18nErrorTrashDisabled             = "trash.err_disabled"
+	I18nErrorTrashList                 = "trash.err_list"
+	I18nErrorTrashRestore              = "trash.err_restore_generic"
+	I18nErrorTrashDelete               = "trash.err_delete_generic"
+	I18nErrorTrashNotFound             = "trash.err_not_found"
+	I18nErrorTrashRestoreExists        = "trash.err_restore_exists"
+	I18nErrorTrashRestoreVirtualFolder = "trash.err_restore_vfolder"
+	I18nErrorTrashRestoreQuota         = "trash.err_restore_quota"
+	I18nErrorVersioningDisabled        = "versions.err_disabled"
+	I18nErrorVersionsList              = "versions.err_list"
+	I18nErrorVersionRestore            = "versions.err_restore_generic"
+	I18nErrorVersionDelete             = "versions.err_delete_generic"
+	I18nErrorVersionNotFound           = "versions.err_not_found"
+	I18nErrorVersionRestoreNotFile     = "versions.err_restore_not_file"
+	I18nErrorFsArchived                = "archive.err_archived"
+	I18nErrorArchiveRestore            = "archive.err_restore_generic"
+	I18nErrorArchiveRestoreUnsupported = "archive.err_unsupported"
+	I18nErrorArchiveRestoreNotArchived = "archive.err_not_archived"
+	I18nErrorArchiveRestoreNotFile     = "archive.err_not_file"
+	I18nErrorSearchDisabled            = "search.err_disabled"
+	I18nErrorSearchNotReady            = "search.err_not_ready"
+	I18nErrorSearchContentDisabled     = "search.err_content_disabled"
+	I18nErrorSearchInvalidQuery        = "search.err_invalid_query"
+	I18nErrorSearchGeneric             = "search.err_generic"
 	I18nErrorPathInvalid               = "general.path_invalid"
 	I18nErrorQuotaRead                 = "general.err_quota_read"
 	I18nErrorEditDir                   = "general.error_edit_dir"
 	I18nErrorEditSize                  = "general.error_edit_size"
+	I18nErrorPreviewDir                = "general.error_preview_dir"
+	I18nErrorPreviewSize               = "general.error_preview_size"
+	I18nErrorPreviewUnsupported        = "general.error_preview_unsupported"
+	I18nErrorWOPIUnsupported           = "general.error_wopi_unsupported"
 	I18nProfileUpdated                 = "general.profile_updated"
 	I18nShareLoginOK                   = "general.share_ok"
 	I18n2FADisabled                    = "2fa.disabled"
@@ -183,6 +231,12 @@ const (
 	I18nOIDCTokenInvalidRoleAdmin      = "oidc.role_admin_err"
 	I18nOIDCTokenInvalidRoleUser       = "oidc.role_user_err"
 	I18nOIDCErrGetUser                 = "oidc.get_user_err"
+	I18nOIDCProviderNotFound           = "oidc.provider_not_found"
+	I18nSAMLResponseInvalid            = "saml.response_invalid"
+	I18nSAMLInvalidRoleAdmin           = "saml.role_admin_err"
+	I18nSAMLInvalidRoleUser            = "saml.role_user_err"
+	I18nSAMLErrGetUser                 = "saml.get_user_err"
+	I18nSAMLSessionExpired             = "saml.session_expired"
 	I18nErrorInvalidQuotaSize          = "user.invalid_quota_size"
 	I18nErrorTimeOfDayInvalid          = "user.time_of_day_invalid"
 	I18nErrorTimeOfDayConflict         = "user.time_of_day_conflict"
@@ -217,6 +271,13 @@ const (
 	I18nErrorDuplicatedName            = "general.duplicated_name"
 	I18nErrorDuplicatedIPNet           = "ip_list.duplicated"
 	I18nErrorRoleAdminPerms            = "admin.role_permissions"
+	I18nErrorTenantAdminPerms          = "admin.tenant_permissions"
+	I18nErrorTenantMismatch            = "tenant.mismatch"
+	I18nErrorTenantStoragePath         = "tenant.storage_path_outside"
+	I18nErrorTenantEventActions        = "tenant.event_actions"
+	I18nErrorTenantBindingForbidden    = "tenant.binding_forbidden"
+	I18nErrorImpersonationFailed       = "impersonation.err_start"
+	I18nErrorImpersonationNotAllowed   = "impersonation.not_allowed"
 	I18nBackupOK                       = "maintenance.backup_ok"
 	I18nErrorFolderTemplate            = "virtual_folders.template_no_folder"
 	I18nErrorUserTemplate              = "user.template_no_user"
@@ -227,6 +288,7 @@ const (
 	I18nOAuth2ErrTokenExchange         = "oauth2.token_exchange_err"
 	I18nOAuth2ErrNoRefreshToken        = "oauth2.no_refresh_token"
 	I18nOAuth2OK                       = "oauth2.success"
+	I18nErrorOAuth2InvalidRequest      = "oauth2.invalid_request"
 	I18nErrorAdminSelfPerms            = "admin.self_permissions"
 	I18nErrorAdminSelfDisable          = "admin.self_disable"
 	I18nErrorAdminSelfRole             = "admin.self_role"
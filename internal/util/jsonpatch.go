@@ -0,0 +1,92 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPatchOperation defines an RFC 6902 JSON patch operation
+type JSONPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// MarshalJSON implements the json.Marshaler interface, the value
+// is omitted for remove operations
+func (o JSONPatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(map[string]string{"op": o.Op, "path": o.Path})
+	}
+	type op JSONPatchOperation
+	return json.Marshal(op(o))
+}
+
+// GetJSONPatch returns the RFC 6902 JSON patch, serialized as JSON, that
+// transforms the before document in the after one.
+// Objects are compared recursively, arrays that differ are replaced as a whole
+func GetJSONPatch(before, after []byte) ([]byte, error) {
+	var src, dst any
+	if err := json.Unmarshal(before, &src); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &dst); err != nil {
+		return nil, err
+	}
+	ops := getJSONPatchOperations("", src, dst, make([]JSONPatchOperation, 0))
+	return json.Marshal(ops)
+}
+
+func getJSONPatchOperations(path string, src, dst any, ops []JSONPatchOperation) []JSONPatchOperation {
+	srcObj, srcIsObj := src.(map[string]any)
+	dstObj, dstIsObj := dst.(map[string]any)
+	if !srcIsObj || !dstIsObj {
+		if !reflect.DeepEqual(src, dst) {
+			ops = append(ops, JSONPatchOperation{Op: "replace", Path: path, Value: dst})
+		}
+		return ops
+	}
+	keys := make([]string, 0, len(srcObj)+len(dstObj))
+	for k := range srcObj {
+		keys = append(keys, k)
+	}
+	for k := range dstObj {
+		if _, ok := srcObj[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "/" + jsonPointerEscaper.Replace(k)
+		srcVal, inSrc := srcObj[k]
+		dstVal, inDst := dstObj[k]
+		switch {
+		case !inDst:
+			ops = append(ops, JSONPatchOperation{Op: "remove", Path: childPath})
+		case !inSrc:
+			ops = append(ops, JSONPatchOperation{Op: "add", Path: childPath, Value: dstVal})
+		default:
+			ops = getJSONPatchOperations(childPath, srcVal, dstVal, ops)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+var (
+	reusePort       atomic.Bool
+	activationOnce  sync.Once
+	activated       inheritedListeners
+	activeListeners = &listenersRegistry{
+		listeners: make(map[*trackedListener]bool),
+	}
+)
+
+// SetReusePort enables SO_REUSEPORT for the TCP listeners, this way a new
+// process can bind the same addresses and take over while the current one
+// completes the active transfers
+func SetReusePort(val bool) {
+	reusePort.Store(val)
+}
+
+// IsReusePortEnabled returns true if SO_REUSEPORT is enabled for the TCP listeners
+func IsReusePortEnabled() bool {
+	return reusePort.Load()
+}
+
+// inheritedListeners holds the listeners passed using socket activation
+type inheritedListeners struct {
+	sync.Mutex
+	listeners []net.Listener
+	count     int
+}
+
+func (l *inheritedListeners) load() {
+	activationOnce.Do(func() {
+		l.listeners = getActivatedListeners()
+		l.count = len(l.listeners)
+		if l.count > 0 {
+			logger.Info(logSender, "", "socket activation, inherited listeners: %d", l.count)
+		}
+	})
+}
+
+// get returns and removes the inherited listener for the specified address, if any
+func (l *inheritedListeners) get(network, address string) net.Listener {
+	l.load()
+
+	l.Lock()
+	defer l.Unlock()
+
+	for idx, listener := range l.listeners {
+		if isSameListenerAddress(listener.Addr(), network, address) {
+			l.listeners = append(l.listeners[:idx], l.listeners[idx+1:]...)
+			return listener
+		}
+	}
+	return nil
+}
+
+func (l *inheritedListeners) has(network, address string) bool {
+	l.load()
+
+	l.Lock()
+	defer l.Unlock()
+
+	for _, listener := range l.listeners {
+		if isSameListenerAddress(listener.Addr(), network, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSameListenerAddress(addr net.Addr, network, address string) bool {
+	if network == "unix" {
+		return addr.Network() == "unix" && addr.String() == address
+	}
+	inherited, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	requested, err := net.ResolveTCPAddr(network, address)
+	if err != nil || requested.Port != inherited.Port {
+		return false
+	}
+	if requested.IP == nil || requested.IP.IsUnspecified() {
+		return inherited.IP == nil || inherited.IP.IsUnspecified()
+	}
+	return requested.IP.Equal(inherited.IP)
+}
+
+// HasActivatedListeners returns true if the process was started using socket activation
+func HasActivatedListeners() bool {
+	activated.load()
+
+	return activated.count > 0
+}
+
+type listenersRegistry struct {
+	sync.Mutex
+	listeners map[*trackedListener]bool
+}
+
+func (r *listenersRegistry) add(l *trackedListener) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.listeners[l] = true
+}
+
+func (r *listenersRegistry) remove(l *trackedListener) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.listeners, l)
+}
+
+func (r *listenersRegistry) closeAll() int {
+	r.Lock()
+	listeners := make([]*trackedListener, 0, len(r.listeners))
+	for l := range r.listeners {
+		listeners = append(listeners, l)
+	}
+	r.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	return len(listeners)
+}
+
+type trackedListener struct {
+	net.Listener
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (l *trackedListener) Close() error {
+	l.closeOnce.Do(func() {
+		activeListeners.remove(l)
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
+
+// Listen announces on the given network address. The listeners inherited
+// using socket activation are used if they match the requested address,
+// otherwise a new listener is created, with SO_REUSEPORT if enabled
+func Listen(network, address string) (net.Listener, error) {
+	l := activated.get(network, address)
+	if l != nil {
+		logger.Info(logSender, "", "using socket activated listener for address %q", address)
+	} else {
+		lc := net.ListenConfig{}
+		if network != "unix" && reusePort.Load() {
+			lc.Control = reusePortControl
+		}
+		var err error
+		l, err = lc.Listen(context.Background(), network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tl := &trackedListener{
+		Listener: l,
+	}
+	activeListeners.add(tl)
+	return tl, nil
+}
+
+// CloseListeners closes all the listeners created using Listen, the established
+// connections are not affected. It returns the number of closed listeners
+func CloseListeners() int {
+	return activeListeners.closeAll()
+}
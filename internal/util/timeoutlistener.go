@@ -86,7 +86,7 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 }
 
 func newListener(network, addr string, readTimeout, writeTimeout time.Duration) (net.Listener, error) {
-	l, err := net.Listen(network, addr)
+	l, err := Listen(network, addr)
 	if err != nil {
 		return nil, err
 	}
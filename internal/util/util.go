@@ -612,7 +612,9 @@ func HTTPListenAndServe(srv *http.Server, address string, port int, isTLS bool,
 			logger.ErrorToConsole("error creating Unix-domain socket parent dir: %v", err)
 			logger.Error(logSender, "", "error creating Unix-domain socket parent dir: %v", err)
 		}
-		os.Remove(address)
+		if !activated.has("unix", address) {
+			os.Remove(address)
+		}
 		listener, err = newListener("unix", address, srv.ReadTimeout, srv.WriteTimeout)
 		if err == nil {
 			// should a chmod err be fatal?
@@ -17,7 +17,10 @@
 package util
 
 import (
+	"errors"
+	"net"
 	"runtime"
+	"syscall"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 )
@@ -29,3 +32,11 @@ func SetUmask(val string) {
 	}
 	logger.Debug(logSender, "", "umask not supported on OS %q", runtime.GOOS)
 }
+
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on " + runtime.GOOS)
+}
+
+func getActivatedListeners() []net.Listener {
+	return nil
+}
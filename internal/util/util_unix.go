@@ -17,12 +17,21 @@
 package util
 
 import (
+	"net"
+	"os"
 	"strconv"
 	"syscall"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 )
 
+const (
+	// first file descriptor passed using socket activation
+	listenFdsStart = 3
+)
+
 // SetUmask sets the specified umask
 func SetUmask(val string) {
 	if val == "" {
@@ -36,3 +45,47 @@ func SetUmask(val string) {
 	logger.Debug(logSender, "", "set umask to: %d, configured value: %q", umask, val)
 	syscall.Umask(int(umask))
 }
+
+func reusePortControl(_, _ string, c syscall.RawConn) error {
+	var errSetOpt error
+	err := c.Control(func(fd uintptr) {
+		errSetOpt = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return errSetOpt
+}
+
+// getActivatedListeners returns the listeners passed by systemd, or any
+// compatible service manager, using socket activation
+func getActivatedListeners() []net.Listener {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil
+	}
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		// FileListener duplicates the file descriptor
+		f.Close()
+		if err != nil {
+			logger.Warn(logSender, "", "file descriptor %d is not a valid listener: %v", fd, err)
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
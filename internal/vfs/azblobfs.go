@@ -20,9 +20,12 @@ package vfs
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net/http"
@@ -40,6 +43,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/eikenb/pipeat"
@@ -55,6 +59,8 @@ import (
 const (
 	azureDefaultEndpoint = "blob.core.windows.net"
 	azFolderKey          = "hdi_isfolder"
+	// not defined in the SDK error codes
+	azBlobImmutableDueToLegalHold = bloberror.Code("BlobImmutableDueToLegalHold")
 )
 
 var (
@@ -71,6 +77,10 @@ type AzureBlobFs struct {
 	containerClient *container.Client
 	ctxTimeout      time.Duration
 	ctxLongTimeout  time.Duration
+	// identifies the container in the metadata cache
+	cacheBackend string
+	// not nil if the hierarchical namespace is enabled
+	dfsClient *azureDFSClient
 }
 
 func init() {
@@ -101,7 +111,14 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 	fs.setConfigDefaults()
 
 	if fs.config.SASURL.GetPayload() != "" {
-		return fs.initFromSASURL()
+		if _, err := fs.initFromSASURL(); err != nil {
+			return fs, err
+		}
+		if err := fs.initDFSClient(nil); err != nil {
+			return fs, err
+		}
+		fs.setCacheBackend()
+		return fs, nil
 	}
 
 	credential, err := blob.NewSharedKeyCredential(fs.config.AccountName, fs.config.AccountKey.GetPayload())
@@ -120,7 +137,29 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 		return fs, fmt.Errorf("invalid credentials: %v", err)
 	}
 	fs.containerClient = svc
-	return fs, err
+	if err := fs.initDFSClient(credential); err != nil {
+		return fs, err
+	}
+	fs.setCacheBackend()
+	return fs, nil
+}
+
+func (fs *AzureBlobFs) initDFSClient(credential *blob.SharedKeyCredential) error {
+	if !fs.config.HierarchicalNamespace {
+		return nil
+	}
+	client, err := newAzureDFSClient(fs.containerClient.URL(), fs.config.Container, credential)
+	if err != nil {
+		return err
+	}
+	fs.dfsClient = client
+	return nil
+}
+
+func (fs *AzureBlobFs) setCacheBackend() {
+	// the SAS token is not relevant to identify the container
+	containerURL, _, _ := strings.Cut(fs.containerClient.URL(), "?")
+	fs.cacheBackend = getMetadataCacheBackend(azBlobFsName, containerURL)
 }
 
 func (fs *AzureBlobFs) initFromSASURL() (Fs, error) {
@@ -177,7 +216,17 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
 	}
+	if info, ok := metadataCache.get(fs.cacheBackend, name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		metadataCache.add(fs.cacheBackend, name, info)
+	}
+	return info, err
+}
 
+func (fs *AzureBlobFs) getObjectStat(name string) (os.FileInfo, error) {
 	attrs, err := fs.headObject(name)
 	if err == nil {
 		contentType := util.GetStringFromPointer(attrs.ContentType)
@@ -186,7 +235,11 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 		if val := getAzureLastModified(attrs.Metadata); val > 0 {
 			lastModified = util.GetTimeFromMsecSinceEpoch(val)
 		}
-		return NewFileInfo(name, isDir, util.GetIntFromPointer(attrs.ContentLength), lastModified, false), nil
+		info := NewFileInfo(name, isDir, util.GetIntFromPointer(attrs.ContentLength), lastModified, false)
+		if !isDir {
+			info.SetETag(getAzureContentHashETag(attrs.Metadata))
+		}
+		return info, nil
 	}
 	if !fs.IsNotExist(err) {
 		return nil, err
@@ -237,14 +290,25 @@ func (fs *AzureBlobFs) Create(name string, flag, checks int) (File, PipeWriter,
 			return nil, nil, nil, err
 		}
 	}
+	var resume *azureResumeInfo
+	if checks&CheckResume != 0 {
+		var err error
+		resume, err = fs.getResumeInfo(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	metadataCache.invalidate(fs.cacheBackend, name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 
 	var p PipeWriter
-	if checks&CheckResume != 0 {
+	if resume != nil {
+		p = newPipeWriterAtOffset(w, resume.size)
+	} else if checks&CheckResume != 0 {
 		p = newPipeWriterAtOffset(w, 0)
 	} else {
 		p = NewPipeWriter(w)
@@ -267,15 +331,24 @@ func (fs *AzureBlobFs) Create(name string, flag, checks int) (File, PipeWriter,
 	go func() {
 		defer cancelFn()
 
+		var hasher hash.Hash
+		var reader io.Reader = r
+		// resumed uploads reuse the existing blocks so we cannot hash the whole content
+		if flag != -1 && resume == nil {
+			hasher = sha256.New()
+			reader = io.TeeReader(r, hasher)
+		}
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers, metadata)
+		err := fs.handleMultipartUpload(ctx, reader, blockBlob, &headers, metadata, fs.getAccessTier(name), resume, hasher)
+		err = fs.checkImmutableError(name, err)
 		r.CloseWithError(err) //nolint:errcheck
+		metadataCache.invalidate(fs.cacheBackend, name)
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, r.GetReadedBytes(), err)
 		metric.AZTransferCompleted(r.GetReadedBytes(), 0, err)
 	}()
 
-	if checks&CheckResume != 0 {
+	if checks&CheckResume != 0 && resume == nil {
 		readCh := make(chan error, 1)
 
 		go func() {
@@ -332,6 +405,13 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
+	if isDir && fs.dfsClient != nil {
+		err := fs.dfsClient.deleteDirectory(ctx, name)
+		metadataCache.invalidate(fs.cacheBackend, name)
+		metric.AZDeleteObjectCompleted(err)
+		return err
+	}
+
 	blobBlock := fs.containerClient.NewBlockBlobClient(name)
 	var deletSnapshots blob.DeleteSnapshotsOptionType
 	if !isDir {
@@ -348,8 +428,9 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 			})
 		}
 	}
+	metadataCache.invalidate(fs.cacheBackend, name)
 	metric.AZDeleteObjectCompleted(err)
-	return err
+	return fs.checkImmutableError(name, err)
 }
 
 // Mkdir creates a new directory with the specified name and default permissions
@@ -400,6 +481,7 @@ func (fs *AzureBlobFs) Chtimes(name string, _, mtime time.Time, isUploading bool
 	defer cancelFn()
 
 	_, err = fs.containerClient.NewBlockBlobClient(name).SetMetadata(ctx, metadata, &blob.SetMetadataOptions{})
+	metadataCache.invalidate(fs.cacheBackend, name)
 	return err
 }
 
@@ -424,10 +506,11 @@ func (fs *AzureBlobFs) ReadDir(dirname string) (DirLister, error) {
 	})
 
 	return &azureBlobDirLister{
-		paginator: pager,
-		timeout:   fs.ctxTimeout,
-		prefix:    prefix,
-		prefixes:  make(map[string]bool),
+		paginator:    pager,
+		timeout:      fs.ctxTimeout,
+		prefix:       prefix,
+		prefixes:     make(map[string]bool),
+		cacheBackend: fs.cacheBackend,
 	}, nil
 }
 
@@ -438,9 +521,11 @@ func (*AzureBlobFs) IsUploadResumeSupported() bool {
 }
 
 // IsConditionalUploadResumeSupported returns if resuming uploads is supported
-// for the specified size
-func (*AzureBlobFs) IsConditionalUploadResumeSupported(size int64) bool {
-	return size <= resumeMaxSize
+// for the specified size. Files up to the configured resume max size are
+// downloaded and uploaded again, bigger files are resumed reusing their
+// committed blocks, this is checked when the file is opened for writing
+func (*AzureBlobFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return true
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
@@ -470,6 +555,9 @@ func (*AzureBlobFs) IsPermission(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrObjectLocked) {
+		return true
+	}
 	var respErr *azcore.ResponseError
 	if errors.As(err, &respErr) {
 		return respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusUnauthorized
@@ -477,6 +565,38 @@ func (*AzureBlobFs) IsPermission(err error) bool {
 	return false
 }
 
+// checkImmutableError returns an error wrapping ErrObjectLocked if the
+// specified error reports that the blob is protected by an immutability
+// policy or a legal hold
+func (*AzureBlobFs) checkImmutableError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if bloberror.HasCode(err, bloberror.BlobImmutableDueToPolicy, azBlobImmutableDueToLegalHold) {
+		return fmt.Errorf("%w: %q is immutable: %v", ErrObjectLocked, name, err)
+	}
+	return err
+}
+
+// checkImmutability returns an error wrapping ErrObjectLocked if the
+// specified blob is under a legal hold or an unexpired immutability policy
+func (fs *AzureBlobFs) checkImmutability(name string) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if attrs.LegalHold != nil && *attrs.LegalHold {
+		return fmt.Errorf("%w: %q is under legal hold", ErrObjectLocked, name)
+	}
+	if until := util.GetTimeFromPointer(attrs.ImmutabilityPolicyExpiresOn); until.After(time.Now()) {
+		return fmt.Errorf("%w: %q is immutable until %s", ErrObjectLocked, name, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
 // IsNotSupported returns true if the error indicate an unsupported operation
 func (*AzureBlobFs) IsNotSupported(err error) bool {
 	if err == nil {
@@ -669,6 +789,52 @@ func (fs *AzureBlobFs) CopyFile(source, target string, srcSize int64) (int, int6
 	return numFiles, sizeDiff, nil
 }
 
+// RestoreArchived implements the FsArchiveRestorer interface. The blob is
+// rehydrated to the Hot tier, or to the Cool tier if it is the configured
+// access tier. Rehydration can take up to 15 hours using the standard priority
+func (fs *AzureBlobFs) RestoreArchived(name string) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	switch getAzureArchiveStatus(attrs) {
+	case ArchiveStatusNone:
+		return fmt.Errorf("%q is not archived", name)
+	case ArchiveStatusRestoring:
+		fsLog(fs, logger.LevelDebug, "rehydration already in progress for %q, status %q", name,
+			util.GetStringFromPointer(attrs.ArchiveStatus))
+		return nil
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	tier := blob.AccessTierHot
+	if fs.config.AccessTier == string(blob.AccessTierCool) {
+		tier = blob.AccessTierCool
+	}
+	priority := blob.RehydratePriorityStandard
+	if fs.config.RehydratePriority != "" {
+		priority = blob.RehydratePriority(fs.config.RehydratePriority)
+	}
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetTier(ctx, tier, &blob.SetTierOptions{
+		RehydratePriority: &priority,
+	})
+	metadataCache.invalidate(fs.cacheBackend, name)
+	fsLog(fs, logger.LevelInfo, "rehydration to tier %q requested for %q, priority %q, err: %v",
+		tier, name, priority, err)
+	return err
+}
+
+// GetArchiveStatus implements the FsArchiveRestorer interface
+func (fs *AzureBlobFs) GetArchiveStatus(name string) (int, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return ArchiveStatusNone, err
+	}
+	return getAzureArchiveStatus(attrs), nil
+}
+
 func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -688,6 +854,62 @@ func (fs *AzureBlobFs) GetMimeType(name string) (string, error) {
 	return util.GetStringFromPointer(response.ContentType), nil
 }
 
+// GetXattr implements the FsXattrer interface, extended attributes are
+// stored as blob metadata
+func (fs *AzureBlobFs) GetXattr(name, attr string) ([]byte, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrFromMetadata(getAzureMetadata(props.Metadata), attr)
+}
+
+// ListXattr implements the FsXattrer interface
+func (fs *AzureBlobFs) ListXattr(name string) ([]string, error) {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return listXattrFromMetadata(getAzureMetadata(props.Metadata)), nil
+}
+
+// SetXattr implements the FsXattrer interface
+func (fs *AzureBlobFs) SetXattr(name, attr string, value []byte) error {
+	return fs.updateMetadata(name, func(metadata map[string]string) error {
+		setXattrInMetadata(metadata, attr, value)
+		return nil
+	})
+}
+
+// RemoveXattr implements the FsXattrer interface
+func (fs *AzureBlobFs) RemoveXattr(name, attr string) error {
+	return fs.updateMetadata(name, func(metadata map[string]string) error {
+		return removeXattrFromMetadata(metadata, attr)
+	})
+}
+
+func (fs *AzureBlobFs) updateMetadata(name string, update func(map[string]string) error) error {
+	props, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	metadata := getAzureMetadata(props.Metadata)
+	if err := update(metadata); err != nil {
+		return err
+	}
+	blobMetadata := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		blobMetadata[k] = to.Ptr(v)
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.containerClient.NewBlockBlobClient(name).SetMetadata(ctx, blobMetadata, &blob.SetMetadataOptions{})
+	metadataCache.invalidate(fs.cacheBackend, name)
+	return err
+}
+
 // Close closes the fs
 func (*AzureBlobFs) Close() error {
 	return nil
@@ -747,15 +969,17 @@ func (fs *AzureBlobFs) setConfigDefaults() {
 }
 
 func (fs *AzureBlobFs) copyFileInternal(source, target string) error {
+	defer metadataCache.invalidate(fs.cacheBackend, target)
+
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
 
 	srcBlob := fs.containerClient.NewBlockBlobClient(source)
 	dstBlob := fs.containerClient.NewBlockBlobClient(target)
-	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions())
+	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), fs.getCopyOptions(target))
 	if err != nil {
 		metric.AZCopyObjectCompleted(err)
-		return err
+		return fs.checkImmutableError(target, err)
 	}
 	copyStatus := blob.CopyStatusType(util.GetStringFromPointer((*string)(resp.CopyStatus)))
 	nErrors := 0
@@ -782,10 +1006,54 @@ func (fs *AzureBlobFs) copyFileInternal(source, target string) error {
 	}
 
 	metric.AZCopyObjectCompleted(nil)
+	fs.copyAccessControl(ctx, source, target)
 	return nil
 }
 
+// copyAccessControl sets the owner, group and ACL of source on target, the
+// Blob API copy uses the default ACL for new files. Errors are logged and
+// ignored, the copy is already completed
+func (fs *AzureBlobFs) copyAccessControl(ctx context.Context, source, target string) {
+	if fs.dfsClient == nil {
+		return
+	}
+	ac, err := fs.dfsClient.getAccessControl(ctx, source)
+	if err == nil {
+		err = fs.dfsClient.setAccessControl(ctx, target, ac)
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to copy access control from %q to %q: %+v", source, target, err)
+	}
+}
+
+// renameDFS renames files and directories using the DFS endpoint, the rename
+// is atomic and directories are moved with all their contents
+func (fs *AzureBlobFs) renameDFS(source, target string, fi os.FileInfo) (int, int64, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	err := fs.dfsClient.rename(ctx, source, target)
+	metadataCache.invalidateTree(fs.cacheBackend, source)
+	metadataCache.invalidateTree(fs.cacheBackend, target)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.IsDir() {
+		// the caller will compute the moved files and size if required
+		return -1, -1, nil
+	}
+	return 1, fi.Size(), nil
+}
+
 func (fs *AzureBlobFs) renameInternal(source, target string, fi os.FileInfo, recursion int) (int, int64, error) {
+	if !fi.IsDir() {
+		if err := fs.checkImmutability(source); err != nil {
+			return 0, 0, err
+		}
+	}
+	if fs.dfsClient != nil {
+		return fs.renameDFS(source, target, fi)
+	}
 	var numFiles int
 	var filesSize int64
 
@@ -829,6 +1097,14 @@ func (fs *AzureBlobFs) skipNotExistErr(err error) error {
 }
 
 func (fs *AzureBlobFs) mkdirInternal(name string) error {
+	if fs.dfsClient != nil {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		err := fs.dfsClient.createDirectory(ctx, name)
+		metadataCache.invalidate(fs.cacheBackend, name)
+		return err
+	}
 	_, w, _, err := fs.Create(name, -1, 0)
 	if err != nil {
 		return err
@@ -899,6 +1175,11 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 		fsLog(fs, logger.LevelError, "unable to get blob properties, download aborted: %+v", err)
 		return err
 	}
+	if getAzureArchiveStatus(props) != ArchiveStatusNone {
+		fsLog(fs, logger.LevelDebug, "unable to download archived blob, access tier %q, archive status %q",
+			util.GetStringFromPointer(props.AccessTier), util.GetStringFromPointer(props.ArchiveStatus))
+		return ErrObjectArchived
+	}
 	if readMetadata > 0 && pipeReader != nil {
 		pipeReader.setMetadataFromPointerVal(props.Metadata)
 	}
@@ -975,8 +1256,48 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 	return poolError
 }
 
+// azureResumeInfo defines the committed blocks of an existing blob. They are
+// committed again, before the new ones, to resume an upload
+type azureResumeInfo struct {
+	blocks []string
+	size   int64
+	eTag   *azcore.ETag
+}
+
+// getResumeInfo returns the info needed to resume an upload reusing the
+// committed blocks or nil if the blob is small enough to be downloaded and
+// uploaded again
+func (fs *AzureBlobFs) getResumeInfo(name string) (*azureResumeInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.containerClient.NewBlockBlobClient(name).GetBlockList(ctx, blockblob.BlockListTypeCommitted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resume %q, get block list error: %w", name, err)
+	}
+	size := util.GetIntFromPointer(resp.BlobContentLength)
+	if size <= resumeMaxSize {
+		return nil, nil
+	}
+	info := &azureResumeInfo{
+		eTag: resp.ETag,
+	}
+	for _, block := range resp.CommittedBlocks {
+		info.blocks = append(info.blocks, util.GetStringFromPointer(block.Name))
+		info.size += util.GetIntFromPointer(block.Size)
+	}
+	if info.size != size {
+		return nil, fmt.Errorf("unable to resume %q, the committed blocks do not match the blob size: %w",
+			name, ErrVfsUnsupported)
+	}
+	fsLog(fs, logger.LevelDebug, "resuming upload for %q reusing the committed blocks, size: %d, blocks: %d",
+		name, size, len(info.blocks))
+	return info, nil
+}
+
 func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Reader,
 	blockBlob *blockblob.Client, httpHeaders *blob.HTTPHeaders, metadata map[string]*string,
+	accessTier *blob.AccessTier, resume *azureResumeInfo, hasher hash.Hash,
 ) error {
 	partSize := fs.config.UploadPartSize
 	guard := make(chan struct{}, fs.config.UploadConcurrency)
@@ -987,6 +1308,9 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 	pool := newBufferAllocator(int(partSize))
 	finished := false
 	var blocks []string
+	if resume != nil {
+		blocks = append(blocks, resume.blocks...)
+	}
 	var wg sync.WaitGroup
 	var errOnce sync.Once
 	var hasError atomic.Bool
@@ -1064,12 +1388,24 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 		return poolError
 	}
 
+	if hasher != nil {
+		if metadata == nil {
+			metadata = make(map[string]*string)
+		}
+		metadata[contentHashField] = to.Ptr(hex.EncodeToString(hasher.Sum(nil)))
+	}
 	commitOptions := blockblob.CommitBlockListOptions{
 		HTTPHeaders: httpHeaders,
 		Metadata:    metadata,
+		Tier:        accessTier,
 	}
-	if fs.config.AccessTier != "" {
-		commitOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	if resume != nil {
+		// the blob must not be changed while we resume the upload
+		commitOptions.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: resume.eTag,
+			},
+		}
 	}
 
 	_, err := blockBlob.CommitBlockList(ctx, blocks, &commitOptions)
@@ -1098,12 +1434,20 @@ func (*AzureBlobFs) readFill(r io.Reader, buf []byte) (n int, err error) {
 	return n, err
 }
 
-func (fs *AzureBlobFs) getCopyOptions() *blob.StartCopyFromURLOptions {
-	copyOptions := &blob.StartCopyFromURLOptions{}
-	if fs.config.AccessTier != "" {
-		copyOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+func (fs *AzureBlobFs) getCopyOptions(target string) *blob.StartCopyFromURLOptions {
+	return &blob.StartCopyFromURLOptions{
+		Tier: fs.getAccessTier(target),
+	}
+}
+
+// getAccessTier returns the access tier for the specified blob, nil means
+// the account default
+func (fs *AzureBlobFs) getAccessTier(name string) *blob.AccessTier {
+	accessTier := fs.config.getAccessTier(strings.TrimPrefix(name, fs.config.KeyPrefix))
+	if accessTier == "" {
+		return nil
 	}
-	return copyOptions
+	return (*blob.AccessTier)(&accessTier)
 }
 
 func (fs *AzureBlobFs) downloadToWriter(name string, w PipeWriter) (int64, error) {
@@ -1120,6 +1464,16 @@ func (fs *AzureBlobFs) downloadToWriter(name string, w PipeWriter) (int64, error
 	return n, err
 }
 
+func getAzureArchiveStatus(attrs blob.GetPropertiesResponse) int {
+	if strings.HasPrefix(util.GetStringFromPointer(attrs.ArchiveStatus), "rehydrate-pending-") {
+		return ArchiveStatusRestoring
+	}
+	if util.GetStringFromPointer(attrs.AccessTier) == string(blob.AccessTierArchive) {
+		return ArchiveStatusArchived
+	}
+	return ArchiveStatusNone
+}
+
 func checkDirectoryMarkers(contentType string, metadata map[string]*string) bool {
 	if contentType == dirMimeType {
 		return true
@@ -1209,6 +1563,7 @@ type azureBlobDirLister struct {
 	prefix        string
 	prefixes      map[string]bool
 	metricUpdated bool
+	cacheBackend  string
 }
 
 func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
@@ -1255,6 +1610,7 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 		size := int64(0)
 		isDir := false
 		modTime := time.Unix(0, 0)
+		etag := ""
 		if blobItem.Properties != nil {
 			size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
 			modTime = util.GetTimeFromPointer(blobItem.Properties.LastModified)
@@ -1270,8 +1626,14 @@ func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
 			if val := getAzureLastModified(blobItem.Metadata); val > 0 {
 				modTime = util.GetTimeFromMsecSinceEpoch(val)
 			}
+			if !isDir {
+				etag = getAzureContentHashETag(blobItem.Metadata)
+				metadataCache.addListedFile(l.cacheBackend, l.prefix, name, size, modTime, etag)
+			}
 		}
-		l.cache = append(l.cache, NewFileInfo(name, isDir, size, modTime, false))
+		info := NewFileInfo(name, isDir, size, modTime, false)
+		info.SetETag(etag)
+		l.cache = append(l.cache, info)
 	}
 
 	return l.returnFromCache(limit), nil
@@ -0,0 +1,496 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noazblob
+// +build !noazblob
+
+package vfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeAzAccount   = "devstoreaccount1"
+	fakeAzContainer = "container"
+)
+
+type fakeAzBlock struct {
+	id   string
+	data []byte
+}
+
+type fakeAzBlob struct {
+	blocks      []fakeAzBlock
+	contentType string
+	metadata    map[string]string
+	etag        string
+	modTime     time.Time
+}
+
+func (b *fakeAzBlob) getData() []byte {
+	var data []byte
+	for _, block := range b.blocks {
+		data = append(data, block.data...)
+	}
+	return data
+}
+
+// fakeAzBlobServer is an in memory implementation of the Azure Blob REST API
+// subset used by AzureBlobFs, it supports a single container of an emulator
+// account
+type fakeAzBlobServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	blobs map[string]*fakeAzBlob
+	// staged and not yet committed blocks by blob name and block ID
+	uncommitted map[string]map[string][]byte
+	etagIdx     int
+	ops         map[string]int
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeAzBlobServer(t *testing.T) *fakeAzBlobServer {
+	s := &fakeAzBlobServer{
+		blobs:       make(map[string]*fakeAzBlob),
+		uncommitted: make(map[string]map[string][]byte),
+		ops:         make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeAzBlobServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeAzBlobServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeAzBlobServer) getBlobData(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, ok := s.blobs[name]
+	if !ok {
+		return nil, false
+	}
+	return blob.getData(), true
+}
+
+func (s *fakeAzBlobServer) getUncommittedCount(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.uncommitted[name])
+}
+
+// putBlob stores a blob committing the specified blocks
+func (s *fakeAzBlobServer) putBlob(name string, blocks ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := &fakeAzBlob{}
+	for idx, data := range blocks {
+		blob.blocks = append(blob.blocks, fakeAzBlock{
+			id:   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block%d", idx))),
+			data: data,
+		})
+	}
+	s.setBlobLocked(name, blob)
+}
+
+func (s *fakeAzBlobServer) setBlobLocked(name string, blob *fakeAzBlob) {
+	s.etagIdx++
+	blob.etag = fmt.Sprintf(`"0x8D%012d"`, s.etagIdx)
+	blob.modTime = time.Now().UTC().Truncate(time.Second)
+	s.blobs[name] = blob
+}
+
+func (*fakeAzBlobServer) getOperation(r *http.Request) string {
+	comp := r.URL.Query().Get("comp")
+	switch r.Method {
+	case http.MethodHead:
+		return "GetProperties"
+	case http.MethodGet:
+		if comp == "blocklist" {
+			return "GetBlockList"
+		}
+		if comp == "list" {
+			return "ListBlobs"
+		}
+		return "GetBlob"
+	case http.MethodPut:
+		switch comp {
+		case "block":
+			return "StageBlock"
+		case "blocklist":
+			return "CommitBlockList"
+		case "":
+			if r.Header.Get("X-Ms-Copy-Source") != "" {
+				return "CopyBlob"
+			}
+			return "PutBlob"
+		}
+	case http.MethodDelete:
+		return "DeleteBlob"
+	}
+	return "Unknown"
+}
+
+func (s *fakeAzBlobServer) handle(w http.ResponseWriter, r *http.Request) {
+	containerPath := "/" + fakeAzAccount + "/" + fakeAzContainer
+	if r.URL.Path != containerPath && !strings.HasPrefix(r.URL.Path, containerPath+"/") {
+		writeFakeAzError(w, r, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, containerPath), "/")
+	op := s.getOperation(r)
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			writeFakeAzError(w, r, status, "InjectedError")
+			return
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeAzError(w, r, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case "GetProperties", "GetBlob":
+		s.handleGetBlob(w, r, name)
+	case "GetBlockList":
+		s.handleGetBlockList(w, r, name)
+	case "StageBlock":
+		blockID := r.URL.Query().Get("blockid")
+		if blockID == "" {
+			writeFakeAzError(w, r, http.StatusBadRequest, "InvalidQueryParameterValue")
+			return
+		}
+		if _, ok := s.uncommitted[name]; !ok {
+			s.uncommitted[name] = make(map[string][]byte)
+		}
+		s.uncommitted[name][blockID] = body
+		w.WriteHeader(http.StatusCreated)
+	case "CommitBlockList":
+		s.handleCommitBlockList(w, r, name, body)
+	case "DeleteBlob":
+		if _, ok := s.blobs[name]; !ok {
+			writeFakeAzError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeFakeAzError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeAzBlobServer) setBlobHeaders(w http.ResponseWriter, blob *fakeAzBlob) {
+	w.Header().Set("ETag", blob.etag)
+	w.Header().Set("Last-Modified", blob.modTime.Format(http.TimeFormat))
+	w.Header().Set("X-Ms-Blob-Type", "BlockBlob")
+	if blob.contentType != "" {
+		w.Header().Set("Content-Type", blob.contentType)
+	}
+	for k, v := range blob.metadata {
+		w.Header().Set("X-Ms-Meta-"+k, v)
+	}
+}
+
+func (s *fakeAzBlobServer) handleGetBlob(w http.ResponseWriter, r *http.Request, name string) {
+	blob, ok := s.blobs[name]
+	if !ok {
+		writeFakeAzError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	s.setBlobHeaders(w, blob)
+	data := blob.getData()
+	status := http.StatusOK
+	rangeHeader := r.Header.Get("X-Ms-Range")
+	if rangeHeader == "" {
+		rangeHeader = r.Header.Get("Range")
+	}
+	if rangeHeader != "" && r.Method == http.MethodGet {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeAzError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data) //nolint:errcheck
+	}
+}
+
+type fakeAzBlockXML struct {
+	Name string `xml:"Name"`
+	Size int64  `xml:"Size"`
+}
+
+func (s *fakeAzBlobServer) handleGetBlockList(w http.ResponseWriter, r *http.Request, name string) {
+	blob, ok := s.blobs[name]
+	if !ok {
+		writeFakeAzError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	var blocks []fakeAzBlockXML
+	for _, block := range blob.blocks {
+		blocks = append(blocks, fakeAzBlockXML{
+			Name: block.id,
+			Size: int64(len(block.data)),
+		})
+	}
+	s.setBlobHeaders(w, blob)
+	w.Header().Set("X-Ms-Blob-Content-Length", strconv.Itoa(len(blob.getData())))
+	writeFakeAzXML(w, http.StatusOK, struct {
+		XMLName         xml.Name         `xml:"BlockList"`
+		CommittedBlocks []fakeAzBlockXML `xml:"CommittedBlocks>Block"`
+	}{
+		CommittedBlocks: blocks,
+	})
+}
+
+func (s *fakeAzBlobServer) handleCommitBlockList(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	existing := s.blobs[name]
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if existing == nil || existing.etag != ifMatch {
+			writeFakeAzError(w, r, http.StatusPreconditionFailed, "ConditionNotMet")
+			return
+		}
+	}
+	var req struct {
+		Committed   []string `xml:"Committed"`
+		Uncommitted []string `xml:"Uncommitted"`
+		Latest      []string `xml:"Latest"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeFakeAzError(w, r, http.StatusBadRequest, "InvalidXmlDocument")
+		return
+	}
+	committed := make(map[string][]byte)
+	if existing != nil {
+		for _, block := range existing.blocks {
+			committed[block.id] = block.data
+		}
+	}
+	blob := &fakeAzBlob{
+		contentType: r.Header.Get("X-Ms-Blob-Content-Type"),
+		metadata:    make(map[string]string),
+	}
+	// the SDK only uses latest, the uncommitted blocks are searched first
+	for _, id := range append(append(req.Committed, req.Uncommitted...), req.Latest...) {
+		data, ok := s.uncommitted[name][id]
+		if !ok {
+			data, ok = committed[id]
+		}
+		if !ok {
+			writeFakeAzError(w, r, http.StatusBadRequest, "InvalidBlockList")
+			return
+		}
+		blob.blocks = append(blob.blocks, fakeAzBlock{id: id, data: data})
+	}
+	for k, v := range r.Header {
+		if len(v) > 0 && strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+			blob.metadata[strings.ToLower(k[len("x-ms-meta-"):])] = v[0]
+		}
+	}
+	delete(s.uncommitted, name)
+	s.setBlobLocked(name, blob)
+	w.Header().Set("ETag", blob.etag)
+	w.Header().Set("Last-Modified", blob.modTime.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeFakeAzXML(w http.ResponseWriter, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	w.Write(data)               //nolint:errcheck
+}
+
+func writeFakeAzError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("X-Ms-Error-Code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, code)
+}
+
+func newTestAzBlobFs(t *testing.T, server *fakeAzBlobServer) *AzureBlobFs {
+	fs, err := NewAzBlobFs("connID", t.TempDir(), "", AzBlobFsConfig{
+		BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
+			Container:      fakeAzContainer,
+			AccountName:    fakeAzAccount,
+			Endpoint:       server.URL,
+			UseEmulator:    true,
+			UploadPartSize: 1,
+		},
+		AccountKey: kms.NewPlainSecret(base64.StdEncoding.EncodeToString([]byte("account key"))),
+	})
+	require.NoError(t, err)
+	return fs.(*AzureBlobFs)
+}
+
+func resumeTestAzBlobUpload(t *testing.T, fs *AzureBlobFs, name string, offset int64, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, CheckResume)
+	require.NoError(t, err)
+	if offset > resumeMaxSize {
+		// the existing blocks are reused so the data before the resume offset
+		// cannot be overwritten
+		_, err = w.WriteAt(data, offset-1)
+		assert.Error(t, err)
+	}
+	_, err = w.WriteAt(data, offset)
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func TestAzBlobResumeUpload(t *testing.T) {
+	SetResumeMaxSize(1024)
+	t.Cleanup(func() {
+		SetResumeMaxSize(0)
+	})
+	server := newFakeAzBlobServer(t)
+	fs := newTestAzBlobFs(t, server)
+	assert.True(t, fs.IsConditionalUploadResumeSupported(1025))
+	_, _, _, err := fs.Create("missing", 0, CheckResume)
+	assert.Error(t, err)
+	// blobs up to the resume max size are downloaded and uploaded again
+	server.putBlob("small.txt", []byte("small"))
+	err = resumeTestAzBlobUpload(t, fs, "small.txt", 5, []byte(" data"))
+	require.NoError(t, err)
+	data, ok := server.getBlobData("small.txt")
+	require.True(t, ok)
+	assert.Equal(t, []byte("small data"), data)
+	assert.Equal(t, 1, server.getOpCount("GetBlob"))
+
+	block1 := bytes.Repeat([]byte("a"), 1000)
+	block2 := bytes.Repeat([]byte("b"), 500)
+	server.putBlob("file.txt", block1, block2)
+	newData := bytes.Repeat([]byte("c"), 1024*1024+10)
+	err = resumeTestAzBlobUpload(t, fs, "file.txt", 1500, newData)
+	require.NoError(t, err)
+	data, ok = server.getBlobData("file.txt")
+	require.True(t, ok)
+	expected := append(append(append([]byte(nil), block1...), block2...), newData...)
+	assert.Equal(t, expected, data)
+	// the existing blocks are committed again without downloading them
+	assert.Equal(t, 1, server.getOpCount("GetBlob"))
+	assert.Equal(t, 3, server.getOpCount("StageBlock"))
+	server.mu.Lock()
+	blob := server.blobs["file.txt"]
+	require.Len(t, blob.blocks, 4)
+	assert.Equal(t, block1, blob.blocks[0].data)
+	assert.Equal(t, block2, blob.blocks[1].data)
+	assert.Equal(t, "text/plain; charset=utf-8", blob.contentType)
+	server.mu.Unlock()
+	assert.Equal(t, 0, server.getUncommittedCount("file.txt"))
+}
+
+func TestAzBlobResumeUploadErrors(t *testing.T) {
+	SetResumeMaxSize(1024)
+	t.Cleanup(func() {
+		SetResumeMaxSize(0)
+	})
+	server := newFakeAzBlobServer(t)
+	fs := newTestAzBlobFs(t, server)
+	existing := bytes.Repeat([]byte("a"), 2048)
+	server.putBlob("file", existing)
+	// a failed block is not committed and the existing blob is preserved
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "StageBlock" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	err := resumeTestAzBlobUpload(t, fs, "file", 2048, []byte("new data"))
+	assert.ErrorContains(t, err, "multipart upload error")
+	assert.Equal(t, 0, server.getOpCount("CommitBlockList"))
+	data, ok := server.getBlobData("file")
+	require.True(t, ok)
+	assert.Equal(t, existing, data)
+	// the blob changes after the resume offset is returned to the client
+	changed := bytes.Repeat([]byte("b"), 4096)
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "StageBlock" {
+			server.putBlob("file", changed)
+		}
+		return 0
+	})
+	err = resumeTestAzBlobUpload(t, fs, "file", 2048, []byte("new data"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.getOpCount("CommitBlockList"))
+	data, ok = server.getBlobData("file")
+	require.True(t, ok)
+	assert.Equal(t, changed, data)
+	// the client restarts from the new offset
+	server.setOnRequest(nil)
+	err = resumeTestAzBlobUpload(t, fs, "file", 4096, []byte("new data"))
+	require.NoError(t, err)
+	data, ok = server.getBlobData("file")
+	require.True(t, ok)
+	assert.Equal(t, append(append([]byte(nil), changed...), []byte("new data")...), data)
+}
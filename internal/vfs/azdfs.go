@@ -0,0 +1,245 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noazblob
+// +build !noazblob
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	azureDFSAPIVersion = "2023-11-03"
+	// the SAS tokens signed with the account key are valid for this duration
+	// and are renewed some minutes before they expire
+	azureDFSTokenValidity = time.Hour
+	azureDFSTokenRenewal  = 10 * time.Minute
+)
+
+// azureDFSClient uses the Data Lake Storage Gen2 REST API, available for
+// storage accounts with hierarchical namespace enabled. Renaming and deleting
+// directories are atomic operations on the DFS endpoint, while the Blob API
+// requires to copy and delete each blob
+type azureDFSClient struct {
+	fileSystem    string
+	fileSystemURL string
+	// the escaped file system path, it includes the account name for
+	// path-style URLs
+	fileSystemPath string
+	pipeline       runtime.Pipeline
+	// the token from the configured SAS URL, if any
+	sasToken string
+	// used to sign the SAS tokens if no SAS URL is configured
+	credential  *blob.SharedKeyCredential
+	mu          sync.Mutex
+	signedToken string
+	tokenExpiry time.Time
+}
+
+// newAzureDFSClient returns a client for the DFS endpoint matching the
+// specified container URL
+func newAzureDFSClient(containerURL, fileSystem string, credential *blob.SharedKeyCredential) (*azureDFSClient, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container URL: %w", err)
+	}
+	// the emulator does not use per service host names
+	u.Host = strings.Replace(u.Host, ".blob.", ".dfs.", 1)
+	sasToken := u.RawQuery
+	u.RawQuery = ""
+
+	return &azureDFSClient{
+		fileSystem:     fileSystem,
+		fileSystemURL:  strings.TrimSuffix(u.String(), "/"),
+		fileSystemPath: strings.TrimSuffix(u.EscapedPath(), "/"),
+		pipeline: runtime.NewPipeline("sftpgo", version.Get().Version, runtime.PipelineOptions{}, &policy.ClientOptions{
+			Telemetry: policy.TelemetryOptions{
+				ApplicationID: version.GetVersionHash(),
+			},
+		}),
+		sasToken:   sasToken,
+		credential: credential,
+	}, nil
+}
+
+func (c *azureDFSClient) getToken() (string, error) {
+	if c.sasToken != "" || c.credential == nil {
+		return c.sasToken, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.signedToken != "" && time.Until(c.tokenExpiry) > azureDFSTokenRenewal {
+		return c.signedToken, nil
+	}
+	permissions := sas.ContainerPermissions{
+		Read:              true,
+		Add:               true,
+		Create:            true,
+		Write:             true,
+		Delete:            true,
+		List:              true,
+		Move:              true,
+		Execute:           true,
+		ModifyOwnership:   true,
+		ModifyPermissions: true,
+	}
+	expiry := time.Now().Add(azureDFSTokenValidity).UTC()
+	params, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPSandHTTP,
+		StartTime:     time.Now().Add(-5 * time.Minute).UTC(),
+		ExpiryTime:    expiry,
+		Permissions:   permissions.String(),
+		ContainerName: c.fileSystem,
+	}.SignWithSharedKey(c.credential)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign SAS token for the DFS endpoint: %w", err)
+	}
+	c.signedToken = params.Encode()
+	c.tokenExpiry = expiry
+	return c.signedToken, nil
+}
+
+func (*azureDFSClient) escapePath(name string) string {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for idx, part := range parts {
+		parts[idx] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (c *azureDFSClient) do(ctx context.Context, method, name string, query url.Values, headers map[string]string,
+	statusCodes ...int,
+) (*http.Response, error) {
+	token, err := c.getToken()
+	if err != nil {
+		return nil, err
+	}
+	rawQuery := query.Encode()
+	if token != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += token
+	}
+	endpoint := c.fileSystemURL + "/" + c.escapePath(name)
+	if rawQuery != "" {
+		endpoint += "?" + rawQuery
+	}
+	req, err := runtime.NewRequest(ctx, method, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("x-ms-version", azureDFSAPIVersion)
+	for k, v := range headers {
+		req.Raw().Header.Set(k, v)
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !runtime.HasStatusCode(resp, statusCodes...) {
+		return nil, runtime.NewResponseError(resp)
+	}
+	runtime.Drain(resp)
+	return resp, nil
+}
+
+// rename atomically renames a file or a directory. The access control lists
+// are preserved, the target is replaced if it is an existing file
+func (c *azureDFSClient) rename(ctx context.Context, source, target string) error {
+	token, err := c.getToken()
+	if err != nil {
+		return err
+	}
+	renameSource := c.fileSystemPath + "/" + c.escapePath(source)
+	if token != "" {
+		renameSource += "?" + token
+	}
+	_, err = c.do(ctx, http.MethodPut, target, url.Values{"mode": []string{"legacy"}}, map[string]string{
+		"x-ms-rename-source": renameSource,
+	}, http.StatusCreated)
+	return err
+}
+
+// createDirectory creates the specified directory, missing parents are
+// created too
+func (c *azureDFSClient) createDirectory(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodPut, name, url.Values{"resource": []string{"directory"}}, nil, http.StatusCreated)
+	return err
+}
+
+// deleteDirectory removes the specified directory, it must be empty
+func (c *azureDFSClient) deleteDirectory(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodDelete, name, url.Values{"recursive": []string{"false"}}, nil, http.StatusOK)
+	return err
+}
+
+// azureAccessControl defines the POSIX style owner, group and ACL for a path
+type azureAccessControl struct {
+	owner string
+	group string
+	acl   string
+}
+
+func (c *azureDFSClient) getAccessControl(ctx context.Context, name string) (azureAccessControl, error) {
+	var result azureAccessControl
+
+	resp, err := c.do(ctx, http.MethodHead, name, url.Values{
+		"action": []string{"getAccessControl"},
+		"upn":    []string{"false"},
+	}, nil, http.StatusOK)
+	if err != nil {
+		return result, err
+	}
+	result.owner = resp.Header.Get("x-ms-owner")
+	result.group = resp.Header.Get("x-ms-group")
+	result.acl = resp.Header.Get("x-ms-acl")
+	return result, nil
+}
+
+func (c *azureDFSClient) setAccessControl(ctx context.Context, name string, ac azureAccessControl) error {
+	headers := make(map[string]string)
+	if ac.owner != "" {
+		headers["x-ms-owner"] = ac.owner
+	}
+	if ac.group != "" {
+		headers["x-ms-group"] = ac.group
+	}
+	if ac.acl != "" {
+		headers["x-ms-acl"] = ac.acl
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	_, err := c.do(ctx, http.MethodPatch, name, url.Values{"action": []string{"setAccessControl"}}, headers,
+		http.StatusOK)
+	return err
+}
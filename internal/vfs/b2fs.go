@@ -0,0 +1,907 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nob2
+// +build !nob2
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Backblaze/blazer/b2"
+	"github.com/Backblaze/blazer/base"
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	defaultB2PageSize          = 1000
+	defaultB2UploadPartSize    = 16
+	defaultB2UploadConcurrency = 2
+	// B2 does not allow object names ending with "/", directories are
+	// represented using an empty marker object, as the B2 web UI does
+	b2DirMarker = ".bzEmpty"
+	// info key used by B2 to store the SHA1 for large files
+	b2LargeFileSHA1Field = "large_file_sha1"
+)
+
+// b2APIBase is the base URL used to authorize the B2 account, it can be
+// changed in test cases
+var b2APIBase = base.APIBase
+
+// B2Fs is a Fs implementation for Backblaze B2 using the native API.
+type B2Fs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath  string
+	config     *B2FsConfig
+	svc        *b2.Client
+	bucket     *b2.Bucket
+	ctxTimeout time.Duration
+	// identifies the bucket in the metadata cache
+	cacheBackend string
+}
+
+func init() {
+	version.AddFeature("+b2")
+}
+
+// NewB2Fs returns a B2Fs object that allows to interact with Backblaze B2
+func NewB2Fs(connectionID, localTempDir, mountPath string, config B2FsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	var err error
+	fs := &B2Fs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err = fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if err = fs.config.ApplicationKey.TryDecrypt(); err != nil {
+		return fs, err
+	}
+	fs.cacheBackend = getMetadataCacheBackend(b2fsName, fs.config.Bucket)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	fs.svc, err = b2.NewClient(ctx, fs.config.KeyID, fs.config.ApplicationKey.GetPayload(),
+		b2.UserAgent(version.GetServerVersion("/", false)), b2.APIBase(b2APIBase))
+	if err != nil {
+		return fs, fmt.Errorf("unable to authorize B2 account: %w", err)
+	}
+	fs.bucket, err = fs.svc.Bucket(ctx, fs.config.Bucket)
+	return fs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs *B2Fs) Name() string {
+	return fmt.Sprintf("%s bucket %q", b2fsName, fs.config.Bucket)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *B2Fs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *B2Fs) Stat(name string) (os.FileInfo, error) {
+	if name == "" || name == "/" || name == "." {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	if fs.config.KeyPrefix == name+"/" {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	if info, ok := metadataCache.get(fs.cacheBackend, name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		metadataCache.add(fs.cacheBackend, name, info)
+	}
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *B2Fs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *B2Fs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	if readMetadata > 0 {
+		attrs, err := fs.headObject(name)
+		if err != nil {
+			r.Close()
+			w.Close()
+			return nil, nil, nil, err
+		}
+		p.setMetadata(attrs.Info)
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	objectReader := fs.bucket.Object(name).NewRangeReader(ctx, offset, -1)
+	fs.setReaderOptions(objectReader)
+
+	go func() {
+		defer cancelFn()
+		defer objectReader.Close()
+
+		n, err := io.Copy(w, objectReader)
+		if err == nil && offset == 0 {
+			err = verifyB2Download(objectReader)
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *B2Fs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	metadataCache.invalidate(fs.cacheBackend, name)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	objectWriter := fs.newObjectWriter(ctx, name, &b2.Attrs{
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+	})
+
+	go func() {
+		defer cancelFn()
+
+		n, err := io.Copy(objectWriter, r)
+		if err != nil {
+			// the context must be canceled before closing the writer,
+			// otherwise the partial upload will be finalized
+			cancelFn()
+		}
+		closeErr := fs.closeObjectWriter(objectWriter, name, r.GetReadedBytes())
+		if err == nil {
+			err = closeErr
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		metadataCache.invalidate(fs.cacheBackend, name)
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// B2 has no server side copy, the objects are downloaded and uploaded again
+// preserving their metadata, the source is then removed according to the
+// configured delete mode
+func (fs *B2Fs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	_, err := fs.Stat(path.Dir(target))
+	if err != nil {
+		return -1, -1, err
+	}
+	fi, err := fs.getObjectStat(source)
+	if err != nil {
+		return -1, -1, err
+	}
+	return fs.renameInternal(source, target, fi, 0)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *B2Fs) Remove(name string, isDir bool) error {
+	objectName := name
+	if isDir {
+		hasContents, err := fs.hasContents(name)
+		if err != nil {
+			return err
+		}
+		if hasContents {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+		objectName = fs.getDirMarker(name)
+	}
+	err := fs.deleteObject(objectName)
+	metadataCache.invalidate(fs.cacheBackend, name)
+	return err
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *B2Fs) Mkdir(name string) error {
+	_, err := fs.Stat(name)
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	return fs.mkdirInternal(name)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*B2Fs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*B2Fs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*B2Fs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*B2Fs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// B2 objects metadata cannot be updated after the upload
+func (*B2Fs) Chtimes(_ string, _, _ time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	return ErrVfsUnsupported
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*B2Fs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *B2Fs) ReadDir(dirname string) (DirLister, error) {
+	// dirname must be already cleaned
+	prefix := fs.getPrefix(dirname)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	return &b2DirLister{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		iterator:     fs.bucket.List(ctx, b2.ListPrefix(prefix), b2.ListDelimiter("/"), b2.ListPageSize(defaultB2PageSize)),
+		prefix:       prefix,
+		cacheBackend: fs.cacheBackend,
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on B2
+func (*B2Fs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*B2Fs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// B2 uploads are already atomic, we don't need to upload to a temporary
+// file
+func (*B2Fs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*B2Fs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) || b2.IsNotExist(err) {
+		return true
+	}
+	code, _ := base.Code(err)
+	return code == http.StatusNotFound
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*B2Fs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	code, _ := base.Code(err)
+	return code == http.StatusForbidden || code == http.StatusUnauthorized
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*B2Fs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrVfsUnsupported)
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *B2Fs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the bucket,
+// and their size
+func (fs *B2Fs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.KeyPrefix)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *B2Fs) GetDirSize(dirname string) (int, int64, error) {
+	prefix := fs.getPrefix(dirname)
+	numFiles := 0
+	size := int64(0)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	it := fs.bucket.List(ctx, b2.ListPrefix(prefix), b2.ListPageSize(defaultB2PageSize))
+	for it.Next() {
+		obj := it.Object()
+		if path.Base(obj.Name()) == b2DirMarker {
+			continue
+		}
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			return numFiles, size, err
+		}
+		numFiles++
+		size += attrs.Size
+		if numFiles%defaultB2PageSize == 0 {
+			fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+		}
+	}
+	return numFiles, size, it.Err()
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// B2 uploads are already atomic, we never call this method for B2
+func (*B2Fs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *B2Fs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.config.KeyPrefix != "" {
+		if !strings.HasPrefix(rel, "/"+fs.config.KeyPrefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, "/"+fs.config.KeyPrefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *B2Fs) Walk(root string, walkFn filepath.WalkFunc) error {
+	prefix := fs.getPrefix(root)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	it := fs.bucket.List(ctx, b2.ListPrefix(prefix), b2.ListPageSize(defaultB2PageSize))
+	for it.Next() {
+		obj := it.Object()
+		name, isDir := fs.resolve(obj.Name(), prefix)
+		if name == "" {
+			continue
+		}
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			walkFn(root, nil, err) //nolint:errcheck
+			return err
+		}
+		objectName := obj.Name()
+		size := attrs.Size
+		if isDir {
+			objectName = strings.TrimSuffix(objectName, "/"+b2DirMarker)
+			size = 0
+		}
+		err = walkFn(objectName, NewFileInfo(name, isDir, size, getB2ModTime(attrs), false), nil)
+		if err != nil {
+			return err
+		}
+	}
+	err := it.Err()
+	walkFn(root, NewFileInfo(root, true, 0, time.Unix(0, 0), false), err) //nolint:errcheck
+	return err
+}
+
+// Join joins any number of path elements into a single path
+func (*B2Fs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*B2Fs) HasVirtualFolders() bool {
+	return true
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *B2Fs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *B2Fs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	numFiles := 1
+	sizeDiff := srcSize
+	attrs, err := fs.headObject(target)
+	if err == nil {
+		sizeDiff -= attrs.Size
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if err := fs.copyFileInternal(source, target); err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *B2Fs) GetMimeType(name string) (string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return attrs.ContentType, nil
+}
+
+// Close closes the fs
+func (*B2Fs) Close() error {
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (*B2Fs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	return nil, ErrStorageSizeUnavailable
+}
+
+func (*B2Fs) resolve(name, prefix string) (string, bool) {
+	result := strings.TrimPrefix(name, prefix)
+	if path.Base(result) == b2DirMarker {
+		return strings.TrimSuffix(strings.TrimSuffix(result, b2DirMarker), "/"), true
+	}
+	return result, false
+}
+
+// getObjectStat returns the stat result
+func (fs *B2Fs) getObjectStat(name string) (os.FileInfo, error) {
+	attrs, err := fs.headObject(name)
+	if err == nil {
+		info := NewFileInfo(name, false, attrs.Size, getB2ModTime(attrs), false)
+		info.SetETag(getB2ETag(attrs))
+		return info, nil
+	}
+	if !fs.IsNotExist(err) {
+		return nil, err
+	}
+	// now check if this is a prefix (virtual directory), a directory
+	// marker is included in the prefix contents
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	it := fs.bucket.List(ctx, b2.ListPrefix(fs.getPrefix(name)), b2.ListPageSize(1))
+	if it.Next() {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %q", os.ErrNotExist, name)
+}
+
+func (fs *B2Fs) setReaderOptions(r *b2.Reader) {
+	if fs.config.DownloadConcurrency > 0 {
+		r.ConcurrentDownloads = fs.config.DownloadConcurrency
+	}
+	if fs.config.DownloadPartSize > 0 {
+		r.ChunkSize = int(fs.config.DownloadPartSize) * 1024 * 1024
+	}
+}
+
+// newObjectWriter returns a writer for the named object. Files bigger than the
+// upload part size are uploaded using the large file API, each part is sent
+// with its SHA1 checksum and verified by B2. The writer must be closed using
+// closeObjectWriter so unfinished large files are canceled on errors
+func (fs *B2Fs) newObjectWriter(ctx context.Context, name string, attrs *b2.Attrs) *b2.Writer {
+	w := fs.bucket.Object(name).NewWriter(ctx, b2.WithAttrsOption(attrs))
+	w.ConcurrentUploads = defaultB2UploadConcurrency
+	if fs.config.UploadConcurrency > 0 {
+		w.ConcurrentUploads = fs.config.UploadConcurrency
+	}
+	w.ChunkSize = defaultB2UploadPartSize * 1024 * 1024
+	if fs.config.UploadPartSize > 0 {
+		w.ChunkSize = int(fs.config.UploadPartSize) * 1024 * 1024
+	}
+	return w
+}
+
+// closeObjectWriter closes the specified writer, size is the number of bytes
+// sent to the writer. The writer starts a large file when the data reach the
+// upload part size, so if the upload fails the unfinished large file is canceled
+func (fs *B2Fs) closeObjectWriter(w *b2.Writer, name string, size int64) error {
+	err := w.Close()
+	if err != nil && size >= int64(w.ChunkSize) {
+		fs.cancelLargeFiles(name)
+	}
+	return err
+}
+
+// cancelLargeFiles cancels the unfinished large files for the named object.
+// The writer does not expose the ID of the large file it started, and its
+// cancel on error option cannot be used since it does not handle errors
+// happening before starting the large file, so we have to search for it
+func (fs *B2Fs) cancelLargeFiles(name string) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	it := fs.bucket.List(ctx, b2.ListUnfinished(), b2.ListPageSize(defaultB2PageSize))
+	for it.Next() {
+		obj := it.Object()
+		if obj.Name() != name {
+			continue
+		}
+		err := obj.Cancel(ctx)
+		fsLog(fs, logger.LevelDebug, "unfinished large file %q canceled, err: %v", name, err)
+	}
+	if err := it.Err(); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to cancel unfinished large files for %q: %v", name, err)
+	}
+}
+
+// deleteObject hides or deletes the named object based on the configuration.
+// Deleting the latest version of an object reveals the previous one, if any,
+// so all the versions are deleted
+func (fs *B2Fs) deleteObject(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	if fs.config.HideOnDelete {
+		return fs.bucket.Object(name).Hide(ctx)
+	}
+
+	found := false
+	it := fs.bucket.List(ctx, b2.ListPrefix(name), b2.ListHidden())
+	for it.Next() {
+		obj := it.Object()
+		// versions are sorted by name so we can stop at the first different name
+		if obj.Name() != name {
+			break
+		}
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			return err
+		}
+		if attrs.Status != b2.Uploaded && attrs.Status != b2.Hider {
+			continue
+		}
+		if err := obj.Delete(ctx); err != nil {
+			return err
+		}
+		found = true
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %q", os.ErrNotExist, name)
+	}
+	return nil
+}
+
+// copyFileInternal copies source to target streaming the data, B2 has no
+// server side copy in the native API we use. The content type, the info and
+// the modification time are preserved
+func (fs *B2Fs) copyFileInternal(source, target string) error {
+	defer metadataCache.invalidate(fs.cacheBackend, target)
+
+	srcAttrs, err := fs.headObject(source)
+	if err != nil {
+		return err
+	}
+	attrs := &b2.Attrs{
+		ContentType:  srcAttrs.ContentType,
+		LastModified: getB2ModTime(srcAttrs),
+		Info:         make(map[string]string, len(srcAttrs.Info)),
+	}
+	for k, v := range srcAttrs.Info {
+		if k != b2LargeFileSHA1Field {
+			attrs.Info[k] = v
+		}
+	}
+	if sha1 := getB2SHA1(srcAttrs); sha1 != "" {
+		attrs.SHA1 = sha1
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	objectReader := fs.bucket.Object(source).NewReader(ctx)
+	defer objectReader.Close()
+
+	fs.setReaderOptions(objectReader)
+	objectWriter := fs.newObjectWriter(ctx, target, attrs)
+
+	n, err := io.Copy(objectWriter, objectReader)
+	if err == nil {
+		err = verifyB2Download(objectReader)
+	}
+	if err != nil {
+		cancelFn()
+	}
+	closeErr := fs.closeObjectWriter(objectWriter, target, srcAttrs.Size)
+	if err == nil {
+		err = closeErr
+	}
+	fsLog(fs, logger.LevelDebug, "copy completed, source: %q, target: %q, size: %d, err: %v", source, target, n, err)
+	return err
+}
+
+func (fs *B2Fs) renameInternal(source, target string, fi os.FileInfo, recursion int) (int, int64, error) {
+	var numFiles int
+	var filesSize int64
+
+	if fi.IsDir() {
+		if renameMode == 0 {
+			hasContents, err := fs.hasContents(source)
+			if err != nil {
+				return numFiles, filesSize, err
+			}
+			if hasContents {
+				return numFiles, filesSize, fmt.Errorf("%w: cannot rename non empty directory: %q", ErrVfsUnsupported, source)
+			}
+		}
+		if err := fs.mkdirInternal(target); err != nil {
+			return numFiles, filesSize, err
+		}
+		if renameMode == 1 {
+			files, size, err := doRecursiveRename(fs, source, target, fs.renameInternal, recursion)
+			numFiles += files
+			filesSize += size
+			if err != nil {
+				return numFiles, filesSize, err
+			}
+		}
+	} else {
+		if err := fs.copyFileInternal(source, target); err != nil {
+			return numFiles, filesSize, err
+		}
+		numFiles++
+		filesSize += fi.Size()
+	}
+	err := fs.Remove(source, fi.IsDir())
+	if fs.IsNotExist(err) {
+		err = nil
+	}
+	return numFiles, filesSize, err
+}
+
+func (fs *B2Fs) mkdirInternal(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	// closing a writer without data uploads an empty object
+	err := fs.bucket.Object(fs.getDirMarker(name)).NewWriter(ctx).Close()
+	metadataCache.invalidate(fs.cacheBackend, name)
+	return err
+}
+
+func (fs *B2Fs) hasContents(name string) (bool, error) {
+	prefix := fs.getPrefix(name)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	// if we have a directory marker it will be returned so we set the size to 2
+	it := fs.bucket.List(ctx, b2.ListPrefix(prefix), b2.ListPageSize(2))
+	for it.Next() {
+		if it.Object().Name() != prefix+b2DirMarker {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
+
+func (fs *B2Fs) getPrefix(name string) string {
+	prefix := ""
+	if name != "" && name != "." && name != "/" {
+		prefix = strings.TrimPrefix(name, "/")
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	return prefix
+}
+
+func (fs *B2Fs) getDirMarker(name string) string {
+	return fs.getPrefix(name) + b2DirMarker
+}
+
+// headObject returns the attributes for the named object. Getting an object by
+// name requires a download request, listing is cheaper: the object, if it
+// exists, is the first one with its name as prefix
+func (fs *B2Fs) headObject(name string) (*b2.Attrs, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	it := fs.bucket.List(ctx, b2.ListPrefix(name), b2.ListPageSize(1))
+	if it.Next() {
+		obj := it.Object()
+		if obj.Name() == name {
+			return obj.Attrs(ctx)
+		}
+		return nil, fmt.Errorf("%w: %q", os.ErrNotExist, name)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %q", os.ErrNotExist, name)
+}
+
+func getB2ModTime(attrs *b2.Attrs) time.Time {
+	if !attrs.LastModified.IsZero() {
+		return attrs.LastModified
+	}
+	return attrs.UploadTimestamp
+}
+
+// getB2SHA1 returns the SHA1 for the object, if known. It is not available for
+// large files uploaded without knowing the checksum in advance
+func getB2SHA1(attrs *b2.Attrs) string {
+	sha1 := strings.TrimPrefix(attrs.SHA1, "unverified:")
+	if len(sha1) != 40 {
+		return ""
+	}
+	return sha1
+}
+
+func getB2ETag(attrs *b2.Attrs) string {
+	if sha1 := getB2SHA1(attrs); sha1 != "" {
+		return `"` + sha1 + `"`
+	}
+	return ""
+}
+
+// verifyB2Download checks the SHA1 for the downloaded data. The check is
+// possible only if the object was fully read and its SHA1 is known
+func verifyB2Download(r *b2.Reader) error {
+	if err, ok := r.Verify(); ok && err != nil {
+		return fmt.Errorf("integrity check failed: %w", err)
+	}
+	return nil
+}
+
+type b2DirLister struct {
+	baseDirLister
+	ctx           context.Context
+	cancelFn      context.CancelFunc
+	iterator      *b2.ObjectIterator
+	prefix        string
+	noMoreObjects bool
+	cacheBackend  string
+}
+
+func (l *b2DirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if len(l.cache) >= limit {
+		return l.returnFromCache(limit), nil
+	}
+	if l.noMoreObjects {
+		return l.returnFromCache(limit), io.EOF
+	}
+
+	for len(l.cache) < limit {
+		if !l.iterator.Next() {
+			if err := l.iterator.Err(); err != nil {
+				return l.cache, err
+			}
+			l.noMoreObjects = true
+			break
+		}
+		obj := l.iterator.Object()
+		// listed objects already include their attributes
+		attrs, err := obj.Attrs(l.ctx)
+		if err != nil {
+			return l.cache, err
+		}
+		name := strings.TrimPrefix(obj.Name(), l.prefix)
+		if attrs.Status == b2.Folder {
+			name = strings.TrimSuffix(name, "/")
+			if name != "" {
+				l.cache = append(l.cache, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
+			}
+			continue
+		}
+		if name == "" || name == b2DirMarker {
+			continue
+		}
+		modTime := getB2ModTime(attrs)
+		info := NewFileInfo(name, false, attrs.Size, modTime, false)
+		info.SetETag(getB2ETag(attrs))
+		metadataCache.addListedFile(l.cacheBackend, l.prefix, name, attrs.Size, modTime, info.ETag())
+		l.cache = append(l.cache, info)
+	}
+
+	return l.returnFromCache(limit), nil
+}
+
+func (l *b2DirLister) Close() error {
+	l.cancelFn()
+	return l.baseDirLister.Close()
+}
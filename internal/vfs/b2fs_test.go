@@ -0,0 +1,1007 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nob2
+// +build !nob2
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeB2Bucket       = "bucket"
+	fakeB2BucketID     = "bucketID"
+	fakeB2KeyID        = "keyID"
+	fakeB2Key          = "applicationKey"
+	fakeB2AuthToken    = "authToken"
+	fakeB2APIPrefix    = "/b2api/v3/"
+	fakeB2MinPartSize  = 5 * 1024 * 1024
+	fakeB2MaxFileCount = 10000
+)
+
+type fakeB2File struct {
+	id          string
+	name        string
+	data        []byte
+	contentType string
+	sha1        string
+	info        map[string]string
+	action      string
+	timestamp   int64
+}
+
+func (f *fakeB2File) toJSON() map[string]any {
+	return map[string]any{
+		"accountId":       "accountID",
+		"bucketId":        fakeB2BucketID,
+		"fileId":          f.id,
+		"fileName":        f.name,
+		"contentLength":   len(f.data),
+		"contentSha1":     f.sha1,
+		"contentType":     f.contentType,
+		"fileInfo":        f.info,
+		"action":          f.action,
+		"uploadTimestamp": f.timestamp,
+	}
+}
+
+type fakeB2LargeFile struct {
+	name        string
+	contentType string
+	info        map[string]string
+	parts       map[int][]byte
+}
+
+// fakeB2Server is an in memory implementation of the B2 native API subset used
+// by B2Fs, the same server is used for the API, the uploads and the downloads
+type fakeB2Server struct {
+	*httptest.Server
+	mu sync.Mutex
+	// all the versions for each file name, the newest one is the last
+	files      map[string][]*fakeB2File
+	largeFiles map[string]*fakeB2LargeFile
+	fileIdx    int
+	ops        map[string]int
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeB2Server(t *testing.T) *fakeB2Server {
+	s := &fakeB2Server{
+		files:      make(map[string][]*fakeB2File),
+		largeFiles: make(map[string]*fakeB2LargeFile),
+		ops:        make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeB2Server) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeB2Server) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+// getFile returns the latest version of the named file, if it is not hidden
+func (s *fakeB2Server) getFile(name string) (fakeB2File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.getLatestLocked(name)
+	if f == nil {
+		return fakeB2File{}, false
+	}
+	return *f, true
+}
+
+func (s *fakeB2Server) getVersionsCount(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.files[name])
+}
+
+func (s *fakeB2Server) getLargeFilesCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.largeFiles)
+}
+
+func (s *fakeB2Server) putFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addFileLocked(name, data, "application/octet-stream", getFakeB2SHA1(data), nil, "upload")
+}
+
+func (s *fakeB2Server) getLatestLocked(name string) *fakeB2File {
+	versions := s.files[name]
+	if len(versions) == 0 || versions[len(versions)-1].action != "upload" {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func (s *fakeB2Server) addFileLocked(name string, data []byte, contentType, sha string, info map[string]string,
+	action string,
+) *fakeB2File {
+	s.fileIdx++
+	if info == nil {
+		info = make(map[string]string)
+	}
+	f := &fakeB2File{
+		id:          fmt.Sprintf("file%d", s.fileIdx),
+		name:        name,
+		data:        data,
+		contentType: contentType,
+		sha1:        sha,
+		info:        info,
+		action:      action,
+		// the versions are sorted by upload timestamp
+		timestamp: time.Now().UnixMilli() + int64(s.fileIdx),
+	}
+	s.files[name] = append(s.files[name], f)
+	return f
+}
+
+func getFakeB2SHA1(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (*fakeB2Server) getOperation(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, fakeB2APIPrefix):
+		return strings.TrimPrefix(r.URL.Path, fakeB2APIPrefix)
+	case strings.HasPrefix(r.URL.Path, "/upload_part/"):
+		return "b2_upload_part"
+	case r.URL.Path == "/upload":
+		return "b2_upload_file"
+	case strings.HasPrefix(r.URL.Path, "/file/"+fakeB2Bucket+"/"):
+		return "b2_download_file_by_name"
+	}
+	return "unknown"
+}
+
+func (s *fakeB2Server) handle(w http.ResponseWriter, r *http.Request) {
+	op := s.getOperation(r)
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			writeFakeB2Error(w, r, status, "injected_error")
+			return
+		}
+	}
+	if op == "b2_authorize_account" {
+		s.handleAuthorize(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != fakeB2AuthToken {
+		writeFakeB2Error(w, r, http.StatusUnauthorized, "bad_auth_token")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	var req map[string]any
+	if strings.HasPrefix(r.URL.Path, fakeB2APIPrefix) {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeFakeB2Error(w, r, http.StatusBadRequest, "bad_json")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case "b2_list_buckets":
+		var buckets []map[string]any
+		if name, _ := req["bucketName"].(string); name == "" || name == fakeB2Bucket {
+			buckets = append(buckets, map[string]any{
+				"bucketId":   fakeB2BucketID,
+				"bucketName": fakeB2Bucket,
+				"bucketType": "allPrivate",
+			})
+		}
+		writeFakeB2JSON(w, map[string]any{"buckets": buckets})
+	case "b2_get_upload_url":
+		writeFakeB2JSON(w, map[string]any{
+			"bucketId":           fakeB2BucketID,
+			"uploadUrl":          s.URL + "/upload",
+			"authorizationToken": fakeB2AuthToken,
+		})
+	case "b2_upload_file":
+		s.handleUploadFile(w, r, body)
+	case "b2_list_file_names":
+		s.handleListFileNames(w, req)
+	case "b2_list_file_versions":
+		s.handleListFileVersions(w, req)
+	case "b2_get_file_info":
+		s.handleGetFileInfo(w, r, req)
+	case "b2_delete_file_version":
+		s.handleDeleteFileVersion(w, r, req)
+	case "b2_hide_file":
+		s.handleHideFile(w, r, req)
+	case "b2_download_file_by_name":
+		s.handleDownload(w, r)
+	case "b2_list_unfinished_large_files":
+		s.handleListUnfinishedLargeFiles(w, req)
+	case "b2_start_large_file", "b2_get_upload_part_url", "b2_upload_part", "b2_finish_large_file",
+		"b2_cancel_large_file":
+		s.handleLargeFile(w, r, op, req, body)
+	default:
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+	}
+}
+
+func (s *fakeB2Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	credentials := base64.StdEncoding.EncodeToString([]byte(fakeB2KeyID + ":" + fakeB2Key))
+	if r.Header.Get("Authorization") != "Basic "+credentials {
+		writeFakeB2Error(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	writeFakeB2JSON(w, map[string]any{
+		"accountId":          "accountID",
+		"authorizationToken": fakeB2AuthToken,
+		"apiInfo": map[string]any{
+			"storageApi": map[string]any{
+				"apiUrl":                  s.URL,
+				"downloadUrl":             s.URL,
+				"absoluteMinimumPartSize": fakeB2MinPartSize,
+				"recommendedPartSize":     100 * 1024 * 1024,
+				"storageApi":              "storageApi",
+			},
+		},
+	})
+}
+
+// checkFakeB2SHA1 verifies the checksum for the uploaded data as B2 does
+func checkFakeB2SHA1(w http.ResponseWriter, r *http.Request, data []byte) (string, bool) {
+	sha := r.Header.Get("X-Bz-Content-Sha1")
+	if sha != getFakeB2SHA1(data) {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return "", false
+	}
+	return sha, true
+}
+
+func (s *fakeB2Server) handleUploadFile(w http.ResponseWriter, r *http.Request, body []byte) {
+	name, err := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+	if err != nil || name == "" || strings.HasSuffix(name, "/") {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	sha, ok := checkFakeB2SHA1(w, r, body)
+	if !ok {
+		return
+	}
+	info, err := getFakeB2Info(r)
+	if err != nil {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	f := s.addFileLocked(name, body, r.Header.Get("Content-Type"), sha, info, "upload")
+	writeFakeB2JSON(w, f.toJSON())
+}
+
+type fakeB2ListEntry struct {
+	key  string
+	file *fakeB2File
+}
+
+func (s *fakeB2Server) handleListFileNames(w http.ResponseWriter, req map[string]any) {
+	prefix, _ := req["prefix"].(string)
+	delimiter, _ := req["delimiter"].(string)
+	startFileName, _ := req["startFileName"].(string)
+	maxFileCount := getFakeB2MaxFileCount(req)
+
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		if strings.HasPrefix(name, prefix) && s.getLatestLocked(name) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var entries []fakeB2ListEntry
+	for _, name := range names {
+		if delimiter != "" {
+			if idx := strings.Index(name[len(prefix):], delimiter); idx >= 0 {
+				folder := name[:len(prefix)+idx+len(delimiter)]
+				if len(entries) == 0 || entries[len(entries)-1].key != folder {
+					entries = append(entries, fakeB2ListEntry{key: folder})
+				}
+				continue
+			}
+		}
+		entries = append(entries, fakeB2ListEntry{key: name, file: s.getLatestLocked(name)})
+	}
+	files := make([]map[string]any, 0)
+	var nextFileName any
+	for _, entry := range entries {
+		if entry.key < startFileName {
+			continue
+		}
+		if len(files) >= maxFileCount {
+			nextFileName = entry.key
+			break
+		}
+		if entry.file == nil {
+			files = append(files, map[string]any{
+				"fileName": entry.key,
+				"action":   "folder",
+			})
+			continue
+		}
+		files = append(files, entry.file.toJSON())
+	}
+	writeFakeB2JSON(w, map[string]any{
+		"files":        files,
+		"nextFileName": nextFileName,
+	})
+}
+
+func (s *fakeB2Server) handleListFileVersions(w http.ResponseWriter, req map[string]any) {
+	prefix, _ := req["prefix"].(string)
+	startFileName, _ := req["startFileName"].(string)
+	startFileID, _ := req["startFileId"].(string)
+	maxFileCount := getFakeB2MaxFileCount(req)
+
+	var versions []*fakeB2File
+	for name, fileVersions := range s.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		versions = append(versions, fileVersions...)
+	}
+	// sorted by name and by upload timestamp, newest first
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].name != versions[j].name {
+			return versions[i].name < versions[j].name
+		}
+		return versions[i].timestamp > versions[j].timestamp
+	})
+	files := make([]map[string]any, 0)
+	var nextFileName, nextFileID any
+	started := startFileID == ""
+	for _, f := range versions {
+		if !started {
+			started = f.id == startFileID
+		}
+		if !started || f.name < startFileName {
+			continue
+		}
+		if len(files) >= maxFileCount {
+			nextFileName = f.name
+			nextFileID = f.id
+			break
+		}
+		files = append(files, f.toJSON())
+	}
+	writeFakeB2JSON(w, map[string]any{
+		"files":        files,
+		"nextFileName": nextFileName,
+		"nextFileId":   nextFileID,
+	})
+}
+
+func (s *fakeB2Server) handleListUnfinishedLargeFiles(w http.ResponseWriter, req map[string]any) {
+	startFileID, _ := req["startFileId"].(string)
+	maxFileCount := getFakeB2MaxFileCount(req)
+
+	ids := make([]string, 0, len(s.largeFiles))
+	for id := range s.largeFiles {
+		if id >= startFileID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	files := make([]map[string]any, 0)
+	var nextFileID any
+	for _, id := range ids {
+		if len(files) >= maxFileCount {
+			nextFileID = id
+			break
+		}
+		lf := s.largeFiles[id]
+		files = append(files, map[string]any{
+			"fileId":      id,
+			"fileName":    lf.name,
+			"contentType": lf.contentType,
+			"fileInfo":    lf.info,
+			"action":      "start",
+		})
+	}
+	writeFakeB2JSON(w, map[string]any{
+		"files":      files,
+		"nextFileId": nextFileID,
+	})
+}
+
+func (s *fakeB2Server) findVersionLocked(name, id string) (*fakeB2File, int) {
+	for idx, f := range s.files[name] {
+		if f.id == id {
+			return f, idx
+		}
+	}
+	return nil, -1
+}
+
+func (s *fakeB2Server) handleGetFileInfo(w http.ResponseWriter, r *http.Request, req map[string]any) {
+	id, _ := req["fileId"].(string)
+	for _, versions := range s.files {
+		for _, f := range versions {
+			if f.id == id {
+				writeFakeB2JSON(w, f.toJSON())
+				return
+			}
+		}
+	}
+	writeFakeB2Error(w, r, http.StatusNotFound, "not_found")
+}
+
+func (s *fakeB2Server) handleDeleteFileVersion(w http.ResponseWriter, r *http.Request, req map[string]any) {
+	name, _ := req["fileName"].(string)
+	id, _ := req["fileId"].(string)
+	f, idx := s.findVersionLocked(name, id)
+	if f == nil {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "file_not_present")
+		return
+	}
+	s.files[name] = append(s.files[name][:idx], s.files[name][idx+1:]...)
+	if len(s.files[name]) == 0 {
+		delete(s.files, name)
+	}
+	writeFakeB2JSON(w, map[string]any{
+		"fileId":   id,
+		"fileName": name,
+	})
+}
+
+func (s *fakeB2Server) handleHideFile(w http.ResponseWriter, r *http.Request, req map[string]any) {
+	name, _ := req["fileName"].(string)
+	if s.getLatestLocked(name) == nil {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "no_such_file")
+		return
+	}
+	f := s.addFileLocked(name, nil, "", "", nil, "hide")
+	writeFakeB2JSON(w, f.toJSON())
+}
+
+func (s *fakeB2Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	name, err := url.QueryUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/file/"+fakeB2Bucket+"/"))
+	if err != nil {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	f := s.getLatestLocked(name)
+	if f == nil {
+		writeFakeB2Error(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+	data := f.data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeB2Error(w, r, http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("X-Bz-File-Id", f.id)
+	w.Header().Set("X-Bz-File-Name", url.QueryEscape(f.name))
+	w.Header().Set("X-Bz-Content-Sha1", f.sha1)
+	w.Header().Set("X-Bz-Upload-Timestamp", strconv.FormatInt(f.timestamp, 10))
+	for k, v := range f.info {
+		w.Header().Set("X-Bz-Info-"+k, url.QueryEscape(v))
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data) //nolint:errcheck
+	}
+}
+
+func (s *fakeB2Server) handleLargeFile(w http.ResponseWriter, r *http.Request, op string, req map[string]any, body []byte) {
+	id, _ := req["fileId"].(string)
+	if op == "b2_upload_part" {
+		id = strings.TrimPrefix(r.URL.Path, "/upload_part/")
+	}
+	if op == "b2_start_large_file" {
+		name, _ := req["fileName"].(string)
+		contentType, _ := req["contentType"].(string)
+		info := make(map[string]string)
+		if val, ok := req["fileInfo"].(map[string]any); ok {
+			for k, v := range val {
+				info[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		}
+		s.fileIdx++
+		id = fmt.Sprintf("large%d", s.fileIdx)
+		s.largeFiles[id] = &fakeB2LargeFile{
+			name:        name,
+			contentType: contentType,
+			info:        info,
+			parts:       make(map[int][]byte),
+		}
+		writeFakeB2JSON(w, map[string]any{
+			"fileId":   id,
+			"fileName": name,
+			"action":   "start",
+		})
+		return
+	}
+	lf, ok := s.largeFiles[id]
+	if !ok {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	switch op {
+	case "b2_get_upload_part_url":
+		writeFakeB2JSON(w, map[string]any{
+			"fileId":             id,
+			"uploadUrl":          s.URL + "/upload_part/" + id,
+			"authorizationToken": fakeB2AuthToken,
+		})
+	case "b2_upload_part":
+		partNumber, err := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		if err != nil || partNumber < 1 || partNumber > 10000 {
+			writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+			return
+		}
+		sha, ok := checkFakeB2SHA1(w, r, body)
+		if !ok {
+			return
+		}
+		lf.parts[partNumber] = body
+		writeFakeB2JSON(w, map[string]any{
+			"fileId":        id,
+			"partNumber":    partNumber,
+			"contentLength": len(body),
+			"contentSha1":   sha,
+		})
+	case "b2_finish_large_file":
+		s.handleFinishLargeFile(w, r, id, lf, req)
+	case "b2_cancel_large_file":
+		delete(s.largeFiles, id)
+		writeFakeB2JSON(w, map[string]any{
+			"fileId":   id,
+			"fileName": lf.name,
+		})
+	}
+}
+
+func (s *fakeB2Server) handleFinishLargeFile(w http.ResponseWriter, r *http.Request, id string, lf *fakeB2LargeFile,
+	req map[string]any,
+) {
+	hashes, _ := req["partSha1Array"].([]any)
+	if len(hashes) == 0 || len(hashes) != len(lf.parts) {
+		writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+		return
+	}
+	var data []byte
+	for idx, hash := range hashes {
+		part, ok := lf.parts[idx+1]
+		if !ok || hash != getFakeB2SHA1(part) {
+			writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+			return
+		}
+		// all the parts, except the last one, must be at least the minimum part size
+		if idx < len(hashes)-1 && len(part) < fakeB2MinPartSize {
+			writeFakeB2Error(w, r, http.StatusBadRequest, "bad_request")
+			return
+		}
+		data = append(data, part...)
+	}
+	delete(s.largeFiles, id)
+	// the SHA1 is not known for large files
+	f := s.addFileLocked(lf.name, data, lf.contentType, "none", lf.info, "upload")
+	writeFakeB2JSON(w, f.toJSON())
+}
+
+func getFakeB2MaxFileCount(req map[string]any) int {
+	maxFileCount := 100
+	if val, ok := req["maxFileCount"].(float64); ok && val > 0 {
+		maxFileCount = int(val)
+	}
+	if maxFileCount > fakeB2MaxFileCount {
+		maxFileCount = fakeB2MaxFileCount
+	}
+	return maxFileCount
+}
+
+func getFakeB2Info(r *http.Request) (map[string]string, error) {
+	info := make(map[string]string)
+	for k, v := range r.Header {
+		if len(v) == 0 || !strings.HasPrefix(k, "X-Bz-Info-") {
+			continue
+		}
+		val, err := url.QueryUnescape(v[0])
+		if err != nil {
+			return nil, err
+		}
+		info[strings.ToLower(strings.TrimPrefix(k, "X-Bz-Info-"))] = val
+	}
+	return info, nil
+}
+
+func writeFakeB2JSON(w http.ResponseWriter, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data) //nolint:errcheck
+}
+
+func writeFakeB2Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck
+		"status":  status,
+		"code":    code,
+		"message": code,
+	})
+}
+
+func newTestB2Fs(t *testing.T, server *fakeB2Server, config B2FsConfig) *B2Fs {
+	apiBase := b2APIBase
+	b2APIBase = server.URL
+	t.Cleanup(func() {
+		b2APIBase = apiBase
+	})
+
+	config.Bucket = fakeB2Bucket
+	if config.KeyID == "" {
+		config.KeyID = fakeB2KeyID
+	}
+	if config.ApplicationKey == nil {
+		config.ApplicationKey = kms.NewPlainSecret(fakeB2Key)
+	}
+	fs, err := NewB2Fs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	return fs.(*B2Fs)
+}
+
+func uploadTestB2File(t *testing.T, fs *B2Fs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestB2File(t *testing.T, fs *B2Fs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestB2FsBasicOperations(t *testing.T) {
+	server := newFakeB2Server(t)
+	fs := newTestB2Fs(t, server, B2FsConfig{})
+
+	require.NoError(t, uploadTestB2File(t, fs, "dir/file.txt", []byte("content")))
+	f, ok := server.getFile("dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(f.data))
+	assert.Equal(t, "text/plain; charset=utf-8", f.contentType)
+	assert.Equal(t, 1, server.getOpCount("b2_upload_file"))
+	assert.Equal(t, 0, server.getOpCount("b2_start_large_file"))
+	data, err := downloadTestB2File(t, fs, "dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestB2File(t, fs, "dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+
+	info, err := fs.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(7), info.Size())
+	etag := `"` + getFakeB2SHA1([]byte("content")) + `"`
+	assert.Equal(t, etag, GetETag(info))
+	entries := listTestDir(t, fs, "dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, etag, GetETag(entries[0]))
+	}
+	mimeType, err := fs.GetMimeType("dir/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", mimeType)
+
+	require.NoError(t, fs.Mkdir("empty"))
+	_, ok = server.getFile("empty/" + b2DirMarker)
+	assert.True(t, ok)
+	info, err = fs.Stat("empty")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Len(t, listTestDir(t, fs, "empty"), 0)
+	entries = listTestDir(t, fs, "")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.True(t, e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"dir", "empty"}, names)
+	numFiles, size, err := fs.GetDirSize("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	// B2 has no server side copy, the content type is preserved
+	numFiles, size, err = fs.Rename("dir/file.txt", "empty/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	_, ok = server.getFile("dir/file.txt")
+	assert.False(t, ok)
+	f, ok = server.getFile("empty/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "text/plain; charset=utf-8", f.contentType)
+	data, err = downloadTestB2File(t, fs, "empty/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+
+	err = fs.Remove("empty", true)
+	assert.ErrorContains(t, err, "non empty directory")
+	// all the versions are removed
+	require.NoError(t, uploadTestB2File(t, fs, "empty/file.txt", []byte("new content")))
+	assert.Equal(t, 2, server.getVersionsCount("empty/file.txt"))
+	require.NoError(t, fs.Remove("empty/file.txt", false))
+	assert.Equal(t, 0, server.getVersionsCount("empty/file.txt"))
+	require.NoError(t, fs.Remove("empty", true))
+	_, err = fs.Stat("empty")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("empty/file.txt", false)
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestB2FsReadDirPagination(t *testing.T) {
+	server := newFakeB2Server(t)
+	fs := newTestB2Fs(t, server, B2FsConfig{})
+
+	numFiles := defaultB2PageSize + 5
+	expected := make([]string, 0, numFiles+1)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putFile("dir/"+name, []byte(name))
+		expected = append(expected, name)
+	}
+	server.putFile("dir/sub/file", []byte("data"))
+	server.putFile("dir/"+b2DirMarker, nil)
+	expected = append(expected, "sub")
+
+	lister, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	entries, err := lister.Next(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "file0000", entries[0].Name())
+	assert.Equal(t, 1, server.getOpCount("b2_list_file_names"))
+	require.NoError(t, lister.Close())
+
+	entries = listTestDir(t, fs, "dir")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, e.Name() == "sub", e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, expected, names)
+	// the directory marker and the folder are listed too, two pages are required
+	assert.Equal(t, 3, server.getOpCount("b2_list_file_names"))
+	// listed files are added to the metadata cache
+	info, err := fs.Stat("dir/file0001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), info.Size())
+
+	numFiles, size, err := fs.GetDirSize("dir")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultB2PageSize+6, numFiles)
+	assert.Equal(t, int64(8*(defaultB2PageSize+5)+4), size)
+}
+
+func TestB2FsLargeFileUpload(t *testing.T) {
+	server := newFakeB2Server(t)
+	fs := newTestB2Fs(t, server, B2FsConfig{
+		UploadPartSize:    5,
+		UploadConcurrency: 3,
+	})
+
+	data := bytes.Repeat([]byte("0123456789"), 11*1024*1024/10)
+	require.NoError(t, uploadTestB2File(t, fs, "large.bin", data))
+	f, ok := server.getFile("large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, f.data)
+	assert.Equal(t, 0, server.getOpCount("b2_upload_file"))
+	assert.Equal(t, 1, server.getOpCount("b2_start_large_file"))
+	assert.Equal(t, 3, server.getOpCount("b2_upload_part"))
+	assert.Equal(t, 1, server.getOpCount("b2_finish_large_file"))
+	assert.Equal(t, 0, server.getLargeFilesCount())
+
+	downloaded, err := downloadTestB2File(t, fs, "large.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	downloaded, err = downloadTestB2File(t, fs, "large.bin", int64(len(data)-5))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("56789"), downloaded)
+	// the SHA1 is unknown for large files
+	info, err := fs.Stat("large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+	assert.Empty(t, GetETag(info))
+	// a failed part cancels the large file and the existing file is preserved
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "b2_upload_part" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	err = uploadTestB2File(t, fs, "large.bin", bytes.Repeat([]byte("a"), 2*fakeB2MinPartSize))
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.getOpCount("b2_cancel_large_file"))
+	assert.Equal(t, 0, server.getLargeFilesCount())
+	f, ok = server.getFile("large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, f.data)
+	// the same applies to the copies done renaming files
+	_, _, err = fs.Rename("large.bin", "copy.bin")
+	assert.Error(t, err)
+	assert.Equal(t, 2, server.getOpCount("b2_cancel_large_file"))
+	assert.Equal(t, 0, server.getLargeFilesCount())
+	_, ok = server.getFile("copy.bin")
+	assert.False(t, ok)
+	_, ok = server.getFile("large.bin")
+	assert.True(t, ok)
+}
+
+func TestB2FsHideOnDelete(t *testing.T) {
+	server := newFakeB2Server(t)
+	fs := newTestB2Fs(t, server, B2FsConfig{
+		HideOnDelete: true,
+	})
+
+	require.NoError(t, uploadTestB2File(t, fs, "file.txt", []byte("content")))
+	require.NoError(t, fs.Remove("file.txt", false))
+	assert.Equal(t, 1, server.getOpCount("b2_hide_file"))
+	assert.Equal(t, 0, server.getOpCount("b2_delete_file_version"))
+	// the previous version is kept
+	assert.Equal(t, 2, server.getVersionsCount("file.txt"))
+	_, ok := server.getFile("file.txt")
+	assert.False(t, ok)
+	_, err := fs.Stat("file.txt")
+	assert.True(t, fs.IsNotExist(err))
+	assert.Len(t, listTestDir(t, fs, ""), 0)
+	_, err = downloadTestB2File(t, fs, "file.txt", 0)
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestB2FsErrors(t *testing.T) {
+	server := newFakeB2Server(t)
+	apiBase := b2APIBase
+	b2APIBase = server.URL
+	t.Cleanup(func() {
+		b2APIBase = apiBase
+	})
+	_, err := NewB2Fs("connID", t.TempDir(), "", B2FsConfig{
+		Bucket:         fakeB2Bucket,
+		KeyID:          fakeB2KeyID,
+		ApplicationKey: kms.NewPlainSecret("wrong key"),
+	})
+	assert.ErrorContains(t, err, "unable to authorize B2 account")
+	_, err = NewB2Fs("connID", t.TempDir(), "", B2FsConfig{
+		Bucket:         "missing",
+		KeyID:          fakeB2KeyID,
+		ApplicationKey: kms.NewPlainSecret(fakeB2Key),
+	})
+	assert.ErrorContains(t, err, "bucket not found")
+
+	fs := newTestB2Fs(t, server, B2FsConfig{})
+	_, err = fs.Stat("missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("missing", "target")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("target", "missing/target")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("file", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, err = downloadTestB2File(t, fs, "missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+
+	server.putFile("file", []byte("data"))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "b2_list_file_names" || op == "b2_upload_file" || op == "b2_download_file_by_name" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	_, err = fs.Stat("file")
+	assert.True(t, fs.IsPermission(err))
+	lister, err := fs.ReadDir("")
+	require.NoError(t, err)
+	_, err = lister.Next(10)
+	assert.True(t, fs.IsPermission(err))
+	require.NoError(t, lister.Close())
+	_, _, err = fs.Rename("file", "file1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("dir", true)
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestB2File(t, fs, "file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestB2File(t, fs, "file", 0)
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(nil)
+
+	_, ok := server.getFile("file1")
+	assert.False(t, ok)
+	data, err := downloadTestB2File(t, fs, "file", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+}
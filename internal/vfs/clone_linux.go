@@ -0,0 +1,50 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile uses the FICLONE ioctl, supported on btrfs, XFS and other
+// filesystems with reflinks, so src and dst share the same data blocks
+func cloneFile(dst, src *os.File) error {
+	srcConn, err := src.SyscallConn()
+	if err != nil {
+		return err
+	}
+	dstConn, err := dst.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errClone error
+	err = dstConn.Control(func(dstFd uintptr) {
+		errControl := srcConn.Control(func(srcFd uintptr) {
+			errClone = unix.IoctlFileClone(int(dstFd), int(srcFd))
+		})
+		if errControl != nil {
+			errClone = errControl
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return errClone
+}
@@ -0,0 +1,1011 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nodropbox
+// +build !nodropbox
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"golang.org/x/oauth2"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	defaultDropboxUploadPartSize  = 16
+	dropboxListLimit              = 2000
+	dropboxMaxAttempts            = 3
+	dropboxTagFile                = "file"
+	dropboxTagFolder              = "folder"
+	dropboxMaxRetryDelay          = 30 * time.Second
+	dropboxDefaultRetryDelay      = time.Second
+	dropboxStatVFSBlockSize       = 4096
+	dropboxStatVFSMaxFilenameSize = 255
+)
+
+var (
+	// the endpoints can be changed in test cases
+	dropboxAPIEndpoint     = "https://api.dropboxapi.com/2/"
+	dropboxContentEndpoint = "https://content.dropboxapi.com/2/"
+	dropboxOAuth2Endpoint  = oauth2.Endpoint{
+		AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+		TokenURL: "https://api.dropboxapi.com/oauth2/token",
+	}
+	// access tokens are short-lived and shared between the connections
+	// using the same refresh token
+	dropboxTokens sync.Map
+)
+
+// DropboxFs is a Fs implementation for Dropbox.
+type DropboxFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath  string
+	config     *DropboxFsConfig
+	client     *http.Client
+	pathRoot   string
+	ctxTimeout time.Duration
+}
+
+func init() {
+	version.AddFeature("+dropbox")
+}
+
+// NewDropboxFs returns a DropboxFs object that allows to interact with Dropbox
+func NewDropboxFs(connectionID, localTempDir, mountPath string, config DropboxFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &DropboxFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if !fs.config.ClientSecret.IsEmpty() {
+		if err := fs.config.ClientSecret.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if err := fs.config.RefreshToken.TryDecrypt(); err != nil {
+		return fs, err
+	}
+	if fs.config.NamespaceID != "" {
+		pathRoot, err := getDropboxAPIArg(dropboxPathRoot{Tag: "namespace_id", NamespaceID: fs.config.NamespaceID})
+		if err != nil {
+			return fs, err
+		}
+		fs.pathRoot = pathRoot
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	oauthConfig := &oauth2.Config{
+		ClientID:     fs.config.ClientID,
+		ClientSecret: fs.config.ClientSecret.GetPayload(),
+		Endpoint:     dropboxOAuth2Endpoint,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: transport,
+		Timeout:   fs.ctxTimeout,
+	})
+	fs.client = &http.Client{
+		Transport: &oauth2.Transport{
+			Source: &dropboxTokenSource{
+				key: getDropboxTokenKey(fs.config.ClientID, fs.config.RefreshToken.GetPayload()),
+				src: oauthConfig.TokenSource(ctx, &oauth2.Token{RefreshToken: fs.config.RefreshToken.GetPayload()}),
+			},
+			Base: transport,
+		},
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *DropboxFs) Name() string {
+	return fmt.Sprintf("%s app %q root %q", dropboxFsName, fs.config.ClientID, fs.config.RootPath)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *DropboxFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *DropboxFs) Stat(name string) (os.FileInfo, error) {
+	if fs.getAPIPath(name) == "" {
+		// the root folder has no metadata
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var metadata dropboxMetadata
+	if err := fs.rpc(ctx, "files/get_metadata", dropboxPathArg{Path: name}, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata.getFileInfo(), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *DropboxFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *DropboxFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		resp, err := fs.download(ctx, name, offset)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *DropboxFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		n, err := fs.upload(ctx, name, r)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are moved server side including their contents
+func (fs *DropboxFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	err := fs.relocate("files/move_v2", source, target)
+	return -1, -1, err
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *DropboxFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	if isDir {
+		// Dropbox removes directories recursively
+		var result dropboxListFolderResult
+		err := fs.rpc(ctx, "files/list_folder", dropboxListFolderArg{Path: fs.getAPIPath(name), Limit: 1}, &result)
+		if err != nil {
+			return err
+		}
+		if len(result.Entries) > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	return fs.rpc(ctx, "files/delete_v2", dropboxPathArg{Path: name}, nil)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *DropboxFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.rpc(ctx, "files/create_folder_v2", dropboxPathArg{Path: name}, nil)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*DropboxFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*DropboxFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*DropboxFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*DropboxFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time can only be set while uploading a file
+func (*DropboxFs) Chtimes(_ string, _, _ time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	return ErrVfsUnsupported
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*DropboxFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *DropboxFs) ReadDir(dirname string) (DirLister, error) {
+	result, err := fs.listFolder(dirname, false)
+	if err != nil {
+		return nil, err
+	}
+	l := &dropboxDirLister{
+		fs:      fs,
+		cursor:  result.Cursor,
+		hasMore: result.HasMore,
+	}
+	l.addEntries(result.Entries)
+	return l, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on Dropbox
+func (*DropboxFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*DropboxFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Dropbox uploads are already atomic, the file is committed only after
+// the upload completes
+func (*DropboxFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*DropboxFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	var apiErr *dropboxError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusConflict && strings.Contains(apiErr.summary, "not_found")
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*DropboxFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+	var apiErr *dropboxError
+	if errors.As(err, &apiErr) {
+		switch apiErr.statusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return true
+		case http.StatusConflict:
+			return strings.Contains(apiErr.summary, "permission") || strings.Contains(apiErr.summary, "restricted_content")
+		}
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*DropboxFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrVfsUnsupported)
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *DropboxFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *DropboxFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.RootPath)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *DropboxFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+
+	result, err := fs.listFolder(dirname, true)
+	for {
+		if err != nil {
+			return numFiles, size, err
+		}
+		for _, entry := range result.Entries {
+			if entry.Tag == dropboxTagFile {
+				numFiles++
+				size += entry.Size
+			}
+		}
+		if !result.HasMore {
+			return numFiles, size, nil
+		}
+		fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+		result, err = fs.listFolderContinue(result.Cursor)
+	}
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// Dropbox uploads are already atomic, we never call this method
+func (*DropboxFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *DropboxFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.RootPath != "/" {
+		if !strings.HasPrefix(rel, fs.config.RootPath) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.RootPath))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *DropboxFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*DropboxFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*DropboxFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *DropboxFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.RootPath, virtualPath), nil
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *DropboxFs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	numFiles := 1
+	sizeDiff := srcSize
+	info, err := fs.Stat(target)
+	if err == nil {
+		sizeDiff -= info.Size()
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if err := fs.relocate("files/copy_v2", source, target); err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *DropboxFs) GetMimeType(name string) (string, error) {
+	if _, err := fs.Stat(name); err != nil {
+		return "", err
+	}
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// Close closes the fs
+func (fs *DropboxFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *DropboxFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var usage dropboxSpaceUsage
+	if err := fs.rpc(ctx, "users/get_space_usage", nil, &usage); err != nil {
+		return nil, err
+	}
+	if usage.Allocation.Allocated == 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	free := uint64(0)
+	if usage.Allocation.Allocated > usage.Used {
+		free = usage.Allocation.Allocated - usage.Used
+	}
+	return &sftp.StatVFS{
+		Bsize:   dropboxStatVFSBlockSize,
+		Frsize:  dropboxStatVFSBlockSize,
+		Blocks:  usage.Allocation.Allocated / dropboxStatVFSBlockSize,
+		Bfree:   free / dropboxStatVFSBlockSize,
+		Bavail:  free / dropboxStatVFSBlockSize,
+		Namemax: dropboxStatVFSMaxFilenameSize,
+	}, nil
+}
+
+// getAPIPath returns the path to use in API requests, the root folder
+// is identified by an empty string
+func (*DropboxFs) getAPIPath(name string) string {
+	if name == "" || name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+func (fs *DropboxFs) getUploadPartSize() int {
+	if fs.config.UploadPartSize > 0 {
+		return int(fs.config.UploadPartSize) * 1024 * 1024
+	}
+	return defaultDropboxUploadPartSize * 1024 * 1024
+}
+
+// upload reads the data to upload in chunks. Files smaller than a chunk are
+// uploaded using a single request, bigger files are uploaded using an upload
+// session and they are committed after the last chunk
+func (fs *DropboxFs) upload(ctx context.Context, name string, r io.Reader) (int64, error) {
+	commit := dropboxCommitInfo{
+		Path: name,
+		Mode: "overwrite",
+		Mute: true,
+	}
+	buf := make([]byte, fs.getUploadPartSize())
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int64(n), fs.uploadContent(ctx, "files/upload", commit, buf[:n], nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var session dropboxUploadSession
+	if err := fs.uploadContent(ctx, "files/upload_session/start", dropboxUploadSessionStartArg{}, buf[:n], &session); err != nil {
+		return 0, err
+	}
+	offset := int64(n)
+	for {
+		n, err = io.ReadFull(r, buf)
+		cursor := dropboxUploadSessionCursor{
+			SessionID: session.SessionID,
+			Offset:    offset,
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			offset += int64(n)
+			err = fs.uploadContent(ctx, "files/upload_session/finish", dropboxUploadSessionFinishArg{
+				Cursor: cursor,
+				Commit: commit,
+			}, buf[:n], nil)
+			return offset, err
+		}
+		if err != nil {
+			return offset, err
+		}
+		err = fs.uploadContent(ctx, "files/upload_session/append_v2", dropboxUploadSessionAppendArg{
+			Cursor: cursor,
+		}, buf[:n], nil)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+	}
+}
+
+// relocate moves or copies source to target. Dropbox never overwrites existing
+// files, if the target is a file it is removed and the request is sent again
+func (fs *DropboxFs) relocate(route, source, target string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	arg := dropboxRelocationArg{
+		FromPath: source,
+		ToPath:   target,
+	}
+	err := fs.rpc(ctx, route, arg, nil)
+	var apiErr *dropboxError
+	if !errors.As(err, &apiErr) || apiErr.statusCode != http.StatusConflict || !strings.Contains(apiErr.summary, "to/conflict/file") {
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "target %q exists, remove it before retrying %q", target, route)
+	if err := fs.rpc(ctx, "files/delete_v2", dropboxPathArg{Path: target}, nil); err != nil {
+		return err
+	}
+	return fs.rpc(ctx, route, arg, nil)
+}
+
+func (fs *DropboxFs) listFolder(name string, recursive bool) (*dropboxListFolderResult, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result dropboxListFolderResult
+	err := fs.rpc(ctx, "files/list_folder", dropboxListFolderArg{
+		Path:      fs.getAPIPath(name),
+		Recursive: recursive,
+		Limit:     dropboxListLimit,
+	}, &result)
+	return &result, err
+}
+
+func (fs *DropboxFs) listFolderContinue(cursor string) (*dropboxListFolderResult, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result dropboxListFolderResult
+	err := fs.rpc(ctx, "files/list_folder/continue", dropboxListFolderContinueArg{Cursor: cursor}, &result)
+	return &result, err
+}
+
+// rpc sends an RPC request, the arguments and the result, if any, are JSON encoded
+func (fs *DropboxFs) rpc(ctx context.Context, route string, arg, result any) error {
+	var body []byte
+	if arg != nil {
+		var err error
+		body, err = json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := fs.sendRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, dropboxAPIEndpoint+route, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if arg != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize*10)).Decode(result)
+}
+
+// uploadContent sends a content-upload request, the arguments are sent inside
+// an HTTP header
+func (fs *DropboxFs) uploadContent(ctx context.Context, route string, arg any, data []byte, result any) error {
+	apiArg, err := getDropboxAPIArg(arg)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.sendRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, dropboxContentEndpoint+route, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", apiArg)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(result)
+}
+
+func (fs *DropboxFs) download(ctx context.Context, name string, offset int64) (*http.Response, error) {
+	apiArg, err := getDropboxAPIArg(dropboxPathArg{Path: name})
+	if err != nil {
+		return nil, err
+	}
+	return fs.sendRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, dropboxContentEndpoint+"files/download", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", apiArg)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		return req, nil
+	})
+}
+
+// sendRequest sends the request returned by newRequest. Rate limited requests
+// and transient server errors are retried, the request body must be replayable
+func (fs *DropboxFs) sendRequest(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if fs.pathRoot != "" {
+			req.Header.Set("Dropbox-API-Path-Root", fs.pathRoot)
+		}
+		resp, err := fs.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("unable to send Dropbox request %q: %w", req.URL.Path, err)
+		}
+		if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+			return resp, nil
+		}
+		err = getDropboxError(resp)
+		if attempt >= dropboxMaxAttempts || !isDropboxRetryable(resp.StatusCode) {
+			return nil, err
+		}
+		delay := getDropboxRetryDelay(resp)
+		fsLog(fs, logger.LevelDebug, "request %q failed, attempt %d, retry in %s: %v", req.URL.Path, attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *DropboxFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+func isDropboxRetryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+func getDropboxRetryDelay(resp *http.Response) time.Duration {
+	delay := dropboxDefaultRetryDelay
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+	if delay > dropboxMaxRetryDelay {
+		delay = dropboxMaxRetryDelay
+	}
+	return delay
+}
+
+func getDropboxError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPFsResponseSize))
+	apiErr := &dropboxError{
+		statusCode: resp.StatusCode,
+	}
+	var errResponse struct {
+		ErrorSummary string `json:"error_summary"`
+	}
+	if err := json.Unmarshal(body, &errResponse); err == nil && errResponse.ErrorSummary != "" {
+		apiErr.summary = errResponse.ErrorSummary
+	} else {
+		apiErr.summary = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// getDropboxAPIArg returns the JSON encoded arguments to send in an HTTP
+// header. Non ASCII characters must be escaped
+func getDropboxAPIArg(arg any) (string, error) {
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for _, r := range string(data) {
+		switch {
+		case r < 0x7f:
+			sb.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&sb, "\\u%04x\\u%04x", r1, r2)
+		default:
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		}
+	}
+	return sb.String(), nil
+}
+
+func getDropboxTokenKey(clientID, refreshToken string) string {
+	h := sha256.Sum256([]byte(clientID + "\x00" + refreshToken))
+	return hex.EncodeToString(h[:])
+}
+
+// dropboxTokenSource returns the cached access token, if valid, or a new one
+// obtained using the refresh token. Dropbox refresh tokens do not expire and
+// are not rotated
+type dropboxTokenSource struct {
+	key string
+	src oauth2.TokenSource
+}
+
+func (s *dropboxTokenSource) Token() (*oauth2.Token, error) {
+	if val, ok := dropboxTokens.Load(s.key); ok {
+		if token := val.(*oauth2.Token); token.Valid() {
+			return token, nil
+		}
+	}
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Dropbox access token: %w", err)
+	}
+	dropboxTokens.Store(s.key, token)
+	return token, nil
+}
+
+type dropboxError struct {
+	statusCode int
+	summary    string
+}
+
+func (e *dropboxError) Error() string {
+	return fmt.Sprintf("dropbox API error, status code: %d, summary: %q", e.statusCode, e.summary)
+}
+
+type dropboxPathRoot struct {
+	Tag         string `json:".tag"`
+	NamespaceID string `json:"namespace_id"`
+}
+
+type dropboxPathArg struct {
+	Path string `json:"path"`
+}
+
+type dropboxRelocationArg struct {
+	FromPath string `json:"from_path"`
+	ToPath   string `json:"to_path"`
+}
+
+type dropboxListFolderArg struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+type dropboxListFolderContinueArg struct {
+	Cursor string `json:"cursor"`
+}
+
+type dropboxCommitInfo struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Mute bool   `json:"mute"`
+}
+
+type dropboxUploadSessionStartArg struct {
+	Close bool `json:"close"`
+}
+
+type dropboxUploadSession struct {
+	SessionID string `json:"session_id"`
+}
+
+type dropboxUploadSessionCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+type dropboxUploadSessionAppendArg struct {
+	Cursor dropboxUploadSessionCursor `json:"cursor"`
+	Close  bool                       `json:"close"`
+}
+
+type dropboxUploadSessionFinishArg struct {
+	Cursor dropboxUploadSessionCursor `json:"cursor"`
+	Commit dropboxCommitInfo          `json:"commit"`
+}
+
+type dropboxSpaceUsage struct {
+	Used       uint64 `json:"used"`
+	Allocation struct {
+		Allocated uint64 `json:"allocated"`
+	} `json:"allocation"`
+}
+
+type dropboxListFolderResult struct {
+	Entries []dropboxMetadata `json:"entries"`
+	Cursor  string            `json:"cursor"`
+	HasMore bool              `json:"has_more"`
+}
+
+type dropboxMetadata struct {
+	Tag            string    `json:".tag"`
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	ClientModified time.Time `json:"client_modified"`
+	ServerModified time.Time `json:"server_modified"`
+	ContentHash    string    `json:"content_hash"`
+}
+
+func (m *dropboxMetadata) getFileInfo() *FileInfo {
+	if m.Tag == dropboxTagFolder {
+		return NewFileInfo(m.Name, true, 0, time.Unix(0, 0), false)
+	}
+	modTime := m.ClientModified
+	if modTime.IsZero() {
+		modTime = m.ServerModified
+	}
+	info := NewFileInfo(m.Name, false, m.Size, modTime, false)
+	if m.ContentHash != "" {
+		info.SetETag(`"` + m.ContentHash + `"`)
+	}
+	return info
+}
+
+type dropboxDirLister struct {
+	baseDirLister
+	fs      *DropboxFs
+	cursor  string
+	hasMore bool
+}
+
+func (l *dropboxDirLister) addEntries(entries []dropboxMetadata) {
+	for idx := range entries {
+		if entries[idx].Tag == dropboxTagFile || entries[idx].Tag == dropboxTagFolder {
+			l.cache = append(l.cache, entries[idx].getFileInfo())
+		}
+	}
+}
+
+func (l *dropboxDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && l.hasMore {
+		result, err := l.fs.listFolderContinue(l.cursor)
+		if err != nil {
+			return l.cache, err
+		}
+		l.cursor = result.Cursor
+		l.hasMore = result.HasMore
+		l.addEntries(result.Entries)
+	}
+	if len(l.cache) >= limit || l.hasMore {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build nodropbox
+// +build nodropbox
+
+package vfs
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+func init() {
+	version.AddFeature("-dropbox")
+}
+
+// NewDropboxFs returns an error, Dropbox is disabled
+func NewDropboxFs(_, _, _ string, _ DropboxFsConfig) (Fs, error) {
+	return nil, errors.New("Dropbox disabled at build time")
+}
@@ -0,0 +1,828 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nodropbox
+// +build !nodropbox
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeDropboxClientID     = "clientID"
+	fakeDropboxRefreshToken = "refreshToken"
+	fakeDropboxAccessToken  = "accessToken"
+	fakeDropboxAllocated    = 10 * 1024 * 1024
+	// the content hash is computed on blocks of this size
+	fakeDropboxHashBlockSize = 4 * 1024 * 1024
+)
+
+type fakeDropboxEntry struct {
+	isDir   bool
+	data    []byte
+	modTime time.Time
+}
+
+type fakeDropboxCursor struct {
+	entries []map[string]any
+	limit   int
+}
+
+// fakeDropboxServer is an in memory implementation of the Dropbox API subset
+// used by DropboxFs, including the OAuth2 token endpoint. The same server is
+// used for the RPC and the content endpoints
+type fakeDropboxServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// the entries keyed by path, the root folder is implicit
+	entries   map[string]*fakeDropboxEntry
+	sessions  map[string][]byte
+	cursors   map[string]*fakeDropboxCursor
+	idx       int
+	ops       map[string]int
+	namespace string
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeDropboxServer(t *testing.T) *fakeDropboxServer {
+	s := &fakeDropboxServer{
+		entries:  make(map[string]*fakeDropboxEntry),
+		sessions: make(map[string][]byte),
+		cursors:  make(map[string]*fakeDropboxCursor),
+		ops:      make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeDropboxServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeDropboxServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeDropboxServer) getEntry(name string) (fakeDropboxEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return fakeDropboxEntry{}, false
+	}
+	return *entry, true
+}
+
+func (s *fakeDropboxServer) getSessionsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
+
+func (s *fakeDropboxServer) putFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addEntryLocked(name, &fakeDropboxEntry{data: data, modTime: time.Now().UTC().Truncate(time.Second)})
+}
+
+// addEntryLocked adds the specified entry, the parent folders are created as
+// Dropbox does
+func (s *fakeDropboxServer) addEntryLocked(name string, entry *fakeDropboxEntry) {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := s.entries[dir]; !ok {
+			s.entries[dir] = &fakeDropboxEntry{isDir: true}
+		}
+	}
+	s.entries[name] = entry
+}
+
+// getSubtreeLocked returns the specified path and all its descendants
+func (s *fakeDropboxServer) getSubtreeLocked(name string) []string {
+	var result []string
+	for p := range s.entries {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func getFakeDropboxContentHash(data []byte) string {
+	var blockHashes []byte
+	for len(data) > 0 {
+		size := min(len(data), fakeDropboxHashBlockSize)
+		sum := sha256.Sum256(data[:size])
+		blockHashes = append(blockHashes, sum[:]...)
+		data = data[size:]
+	}
+	sum := sha256.Sum256(blockHashes)
+	return hex.EncodeToString(sum[:])
+}
+
+func (*fakeDropboxServer) getMetadata(name string, entry *fakeDropboxEntry) map[string]any {
+	if entry.isDir {
+		return map[string]any{
+			".tag":         dropboxTagFolder,
+			"name":         path.Base(name),
+			"path_display": name,
+			"path_lower":   strings.ToLower(name),
+		}
+	}
+	return map[string]any{
+		".tag":            dropboxTagFile,
+		"name":            path.Base(name),
+		"path_display":    name,
+		"path_lower":      strings.ToLower(name),
+		"size":            len(entry.data),
+		"client_modified": entry.modTime.Format(time.RFC3339),
+		"server_modified": entry.modTime.Format(time.RFC3339),
+		"content_hash":    getFakeDropboxContentHash(entry.data),
+	}
+}
+
+func (s *fakeDropboxServer) handle(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"), "2/")
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			writeFakeDropboxError(w, status, "injected_error/")
+			return
+		}
+	}
+	if op == "oauth2/token" {
+		s.handleToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+fakeDropboxAccessToken {
+		writeFakeDropboxError(w, http.StatusUnauthorized, "invalid_access_token/")
+		return
+	}
+	if r.Header.Get("Dropbox-API-Path-Root") != s.namespace {
+		writeFakeDropboxError(w, http.StatusUnprocessableEntity, "invalid_root/")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeDropboxError(w, http.StatusBadRequest, "bad_request")
+		return
+	}
+	// content endpoints send the arguments in an header
+	argData := body
+	if val := r.Header.Get("Dropbox-API-Arg"); val != "" {
+		argData = []byte(val)
+	}
+	var arg map[string]any
+	if len(argData) > 0 {
+		if err := json.Unmarshal(argData, &arg); err != nil {
+			writeFakeDropboxError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case "files/get_metadata":
+		name, _ := arg["path"].(string)
+		entry, ok := s.entries[name]
+		if !ok {
+			writeFakeDropboxError(w, http.StatusConflict, "path/not_found/")
+			return
+		}
+		writeFakeDropboxJSON(w, s.getMetadata(name, entry))
+	case "files/list_folder":
+		s.handleListFolder(w, arg)
+	case "files/list_folder/continue":
+		s.handleListFolderContinue(w, arg)
+	case "files/create_folder_v2":
+		name, _ := arg["path"].(string)
+		if _, ok := s.entries[name]; ok {
+			writeFakeDropboxError(w, http.StatusConflict, "path/conflict/folder/")
+			return
+		}
+		entry := &fakeDropboxEntry{isDir: true}
+		s.addEntryLocked(name, entry)
+		writeFakeDropboxJSON(w, map[string]any{"metadata": s.getMetadata(name, entry)})
+	case "files/delete_v2":
+		name, _ := arg["path"].(string)
+		entry, ok := s.entries[name]
+		if !ok {
+			writeFakeDropboxError(w, http.StatusConflict, "path_lookup/not_found/")
+			return
+		}
+		for _, p := range s.getSubtreeLocked(name) {
+			delete(s.entries, p)
+		}
+		writeFakeDropboxJSON(w, map[string]any{"metadata": s.getMetadata(name, entry)})
+	case "files/move_v2", "files/copy_v2":
+		s.handleRelocation(w, arg, op == "files/move_v2")
+	case "files/download":
+		s.handleDownload(w, r, arg)
+	case "files/upload", "files/upload_session/start", "files/upload_session/append_v2",
+		"files/upload_session/finish":
+		s.handleUpload(w, op, arg, body)
+	case "users/get_space_usage":
+		used := 0
+		for _, entry := range s.entries {
+			used += len(entry.data)
+		}
+		writeFakeDropboxJSON(w, map[string]any{
+			"used": used,
+			"allocation": map[string]any{
+				".tag":      "individual",
+				"allocated": fakeDropboxAllocated,
+			},
+		})
+	default:
+		writeFakeDropboxError(w, http.StatusBadRequest, "unknown_route")
+	}
+}
+
+func (*fakeDropboxServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+		writeFakeDropboxJSONStatus(w, http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"})
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != fakeDropboxClientID || r.PostForm.Get("refresh_token") != fakeDropboxRefreshToken {
+		writeFakeDropboxJSONStatus(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+		return
+	}
+	writeFakeDropboxJSON(w, map[string]any{
+		"access_token": fakeDropboxAccessToken,
+		"token_type":   "bearer",
+		"expires_in":   14400,
+	})
+}
+
+func (s *fakeDropboxServer) handleListFolder(w http.ResponseWriter, arg map[string]any) {
+	name, _ := arg["path"].(string)
+	recursive, _ := arg["recursive"].(bool)
+	limit := 500
+	if val, ok := arg["limit"].(float64); ok && val > 0 {
+		limit = int(val)
+	}
+	if name != "" {
+		entry, ok := s.entries[name]
+		if !ok {
+			writeFakeDropboxError(w, http.StatusConflict, "path/not_found/")
+			return
+		}
+		if !entry.isDir {
+			writeFakeDropboxError(w, http.StatusConflict, "path/not_folder/")
+			return
+		}
+	}
+	var paths []string
+	for p := range s.entries {
+		if !strings.HasPrefix(p, name+"/") {
+			continue
+		}
+		if recursive || path.Dir(p) == path.Clean("/"+name) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	cursor := &fakeDropboxCursor{
+		limit: limit,
+	}
+	for _, p := range paths {
+		cursor.entries = append(cursor.entries, s.getMetadata(p, s.entries[p]))
+	}
+	s.writeListFolderResult(w, cursor)
+}
+
+func (s *fakeDropboxServer) handleListFolderContinue(w http.ResponseWriter, arg map[string]any) {
+	id, _ := arg["cursor"].(string)
+	cursor, ok := s.cursors[id]
+	if !ok {
+		writeFakeDropboxError(w, http.StatusConflict, "reset/")
+		return
+	}
+	delete(s.cursors, id)
+	s.writeListFolderResult(w, cursor)
+}
+
+func (s *fakeDropboxServer) writeListFolderResult(w http.ResponseWriter, cursor *fakeDropboxCursor) {
+	entries := cursor.entries
+	if len(entries) > cursor.limit {
+		entries = entries[:cursor.limit]
+	}
+	cursor.entries = cursor.entries[len(entries):]
+	s.idx++
+	id := fmt.Sprintf("cursor%d", s.idx)
+	s.cursors[id] = cursor
+	writeFakeDropboxJSON(w, map[string]any{
+		"entries":  append([]map[string]any{}, entries...),
+		"cursor":   id,
+		"has_more": len(cursor.entries) > 0,
+	})
+}
+
+func (s *fakeDropboxServer) handleRelocation(w http.ResponseWriter, arg map[string]any, isMove bool) {
+	fromPath, _ := arg["from_path"].(string)
+	toPath, _ := arg["to_path"].(string)
+	if _, ok := s.entries[fromPath]; !ok {
+		writeFakeDropboxError(w, http.StatusConflict, "from_lookup/not_found/")
+		return
+	}
+	if entry, ok := s.entries[toPath]; ok {
+		if entry.isDir {
+			writeFakeDropboxError(w, http.StatusConflict, "to/conflict/folder/")
+			return
+		}
+		writeFakeDropboxError(w, http.StatusConflict, "to/conflict/file/")
+		return
+	}
+	if toPath == fromPath || strings.HasPrefix(toPath, fromPath+"/") {
+		writeFakeDropboxError(w, http.StatusConflict, "cant_move_folder_into_itself/")
+		return
+	}
+	for _, p := range s.getSubtreeLocked(fromPath) {
+		entry := *s.entries[p]
+		if isMove {
+			delete(s.entries, p)
+		}
+		s.addEntryLocked(toPath+strings.TrimPrefix(p, fromPath), &entry)
+	}
+	writeFakeDropboxJSON(w, map[string]any{"metadata": s.getMetadata(toPath, s.entries[toPath])})
+}
+
+func (s *fakeDropboxServer) handleDownload(w http.ResponseWriter, r *http.Request, arg map[string]any) {
+	name, _ := arg["path"].(string)
+	entry, ok := s.entries[name]
+	if !ok {
+		writeFakeDropboxError(w, http.StatusConflict, "path/not_found/")
+		return
+	}
+	if entry.isDir {
+		writeFakeDropboxError(w, http.StatusConflict, "path/not_file/")
+		return
+	}
+	metadata, err := json.Marshal(s.getMetadata(name, entry))
+	if err != nil {
+		writeFakeDropboxError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	data := entry.data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeDropboxError(w, http.StatusRequestedRangeNotSatisfiable, "invalid_range")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Dropbox-API-Result", string(metadata))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func (s *fakeDropboxServer) commitLocked(w http.ResponseWriter, commit map[string]any, data []byte) {
+	name, _ := commit["path"].(string)
+	if !strings.HasPrefix(name, "/") {
+		writeFakeDropboxError(w, http.StatusBadRequest, "invalid_path")
+		return
+	}
+	if existing, ok := s.entries[name]; ok {
+		if existing.isDir {
+			writeFakeDropboxError(w, http.StatusConflict, "path/conflict/folder/")
+			return
+		}
+		if mode, _ := commit["mode"].(string); mode != "overwrite" {
+			writeFakeDropboxError(w, http.StatusConflict, "path/conflict/file/")
+			return
+		}
+	}
+	entry := &fakeDropboxEntry{
+		data:    data,
+		modTime: time.Now().UTC().Truncate(time.Second),
+	}
+	s.addEntryLocked(name, entry)
+	writeFakeDropboxJSON(w, s.getMetadata(name, entry))
+}
+
+func (s *fakeDropboxServer) handleUpload(w http.ResponseWriter, op string, arg map[string]any, body []byte) {
+	if op == "files/upload" {
+		s.commitLocked(w, arg, body)
+		return
+	}
+	if op == "files/upload_session/start" {
+		s.idx++
+		id := fmt.Sprintf("session%d", s.idx)
+		s.sessions[id] = body
+		writeFakeDropboxJSON(w, map[string]any{"session_id": id})
+		return
+	}
+	cursor, _ := arg["cursor"].(map[string]any)
+	id, _ := cursor["session_id"].(string)
+	offset, _ := cursor["offset"].(float64)
+	data, ok := s.sessions[id]
+	if !ok {
+		writeFakeDropboxError(w, http.StatusConflict, "lookup_failed/not_found/")
+		return
+	}
+	if int(offset) != len(data) {
+		writeFakeDropboxError(w, http.StatusConflict, "lookup_failed/incorrect_offset/")
+		return
+	}
+	data = append(data, body...)
+	if op == "files/upload_session/append_v2" {
+		s.sessions[id] = data
+		writeFakeDropboxJSON(w, nil)
+		return
+	}
+	delete(s.sessions, id)
+	commit, _ := arg["commit"].(map[string]any)
+	s.commitLocked(w, commit, data)
+}
+
+func writeFakeDropboxJSON(w http.ResponseWriter, v any) {
+	writeFakeDropboxJSONStatus(w, http.StatusOK, v)
+}
+
+func writeFakeDropboxJSONStatus(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func writeFakeDropboxError(w http.ResponseWriter, status int, summary string) {
+	writeFakeDropboxJSONStatus(w, status, map[string]any{
+		"error_summary": summary,
+		"error": map[string]any{
+			".tag": strings.Split(summary, "/")[0],
+		},
+	})
+}
+
+func newTestDropboxFs(t *testing.T, server *fakeDropboxServer, config DropboxFsConfig) *DropboxFs {
+	apiEndpoint := dropboxAPIEndpoint
+	contentEndpoint := dropboxContentEndpoint
+	oauth2Endpoint := dropboxOAuth2Endpoint
+	dropboxAPIEndpoint = server.URL + "/2/"
+	dropboxContentEndpoint = server.URL + "/2/"
+	dropboxOAuth2Endpoint = oauth2.Endpoint{
+		AuthURL:  server.URL + "/oauth2/authorize",
+		TokenURL: server.URL + "/oauth2/token",
+	}
+	t.Cleanup(func() {
+		dropboxAPIEndpoint = apiEndpoint
+		dropboxContentEndpoint = contentEndpoint
+		dropboxOAuth2Endpoint = oauth2Endpoint
+	})
+
+	config.ClientID = fakeDropboxClientID
+	if config.RefreshToken == nil {
+		config.RefreshToken = kms.NewPlainSecret(fakeDropboxRefreshToken)
+	}
+	fs, err := NewDropboxFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*DropboxFs)
+}
+
+func uploadTestDropboxFile(t *testing.T, fs *DropboxFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestDropboxFile(t *testing.T, fs *DropboxFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestDropboxFsBasicOperations(t *testing.T) {
+	server := newFakeDropboxServer(t)
+	fs := newTestDropboxFs(t, server, DropboxFsConfig{})
+
+	require.NoError(t, uploadTestDropboxFile(t, fs, "/dir/file.txt", []byte("content")))
+	entry, ok := server.getEntry("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	assert.Equal(t, 1, server.getOpCount("files/upload"))
+	assert.Equal(t, 0, server.getOpCount("files/upload_session/start"))
+	data, err := downloadTestDropboxFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestDropboxFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(7), info.Size())
+	assert.Equal(t, entry.modTime, info.ModTime().UTC())
+	etag := `"` + getFakeDropboxContentHash([]byte("content")) + `"`
+	assert.Equal(t, etag, GetETag(info))
+	entries := listTestDir(t, fs, "/dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, etag, GetETag(entries[0]))
+	}
+
+	require.NoError(t, fs.Mkdir("/empty"))
+	err = fs.Mkdir("/empty")
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+	entries = listTestDir(t, fs, "/")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.True(t, e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"dir", "empty"}, names)
+	// moves are server side
+	numFiles, size, err := fs.Rename("/dir/file.txt", "/empty/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	_, ok = server.getEntry("/dir/file.txt")
+	assert.False(t, ok)
+	data, err = downloadTestDropboxFile(t, fs, "/empty/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	// Dropbox does not overwrite existing files, they are removed
+	server.putFile("/dir/other", []byte("other content"))
+	_, _, err = fs.Rename("/empty/file.txt", "/dir/other")
+	require.NoError(t, err)
+	assert.Equal(t, 3, server.getOpCount("files/move_v2"))
+	assert.Equal(t, 1, server.getOpCount("files/delete_v2"))
+	entry, ok = server.getEntry("/dir/other")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	// directories are moved with their contents
+	_, _, err = fs.Rename("/dir", "/empty/moved")
+	require.NoError(t, err)
+	entry, ok = server.getEntry("/empty/moved/other")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	numFiles, sizeDiff, err := fs.CopyFile("/empty/moved/other", "/copy", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), sizeDiff)
+	numFiles, sizeDiff, err = fs.CopyFile("/empty/moved/other", "/copy", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(0), sizeDiff)
+	numFiles, size, err = fs.GetDirSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, numFiles)
+	assert.Equal(t, int64(14), size)
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64((fakeDropboxAllocated-14)/dropboxStatVFSBlockSize), statVFS.Bfree)
+
+	err = fs.Remove("/empty", true)
+	assert.ErrorContains(t, err, "non empty directory")
+	require.NoError(t, fs.Remove("/empty/moved/other", false))
+	require.NoError(t, fs.Remove("/empty/moved", true))
+	require.NoError(t, fs.Remove("/empty", true))
+	_, err = fs.Stat("/empty")
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestDropboxFsReadDirPagination(t *testing.T) {
+	server := newFakeDropboxServer(t)
+	fs := newTestDropboxFs(t, server, DropboxFsConfig{})
+
+	numFiles := dropboxListLimit + 5
+	expected := make([]string, 0, numFiles+1)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putFile("/dir/"+name, []byte(name))
+		expected = append(expected, name)
+	}
+	server.putFile("/dir/sub/file", []byte("data"))
+	expected = append(expected, "sub")
+
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	entries, err := lister.Next(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "file0000", entries[0].Name())
+	assert.Equal(t, 1, server.getOpCount("files/list_folder"))
+	assert.Equal(t, 0, server.getOpCount("files/list_folder/continue"))
+	require.NoError(t, lister.Close())
+
+	entries = listTestDir(t, fs, "/dir")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, e.Name() == "sub", e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, expected, names)
+	assert.Equal(t, 2, server.getOpCount("files/list_folder"))
+	assert.Equal(t, 1, server.getOpCount("files/list_folder/continue"))
+
+	numFiles, size, err := fs.GetDirSize("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, dropboxListLimit+6, numFiles)
+	assert.Equal(t, int64(8*(dropboxListLimit+5)+4), size)
+	assert.Equal(t, 2, server.getOpCount("files/list_folder/continue"))
+	// walk uses the directory listing
+	var walked []string
+	err = fs.Walk("/dir/sub", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dir/sub", "/dir/sub/file"}, walked)
+}
+
+func TestDropboxFsUploadSession(t *testing.T) {
+	server := newFakeDropboxServer(t)
+	fs := newTestDropboxFs(t, server, DropboxFsConfig{
+		UploadPartSize: 1,
+	})
+
+	data := bytes.Repeat([]byte("0123456789"), 256*1024)
+	require.NoError(t, uploadTestDropboxFile(t, fs, "/large.bin", data))
+	entry, ok := server.getEntry("/large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, entry.data)
+	assert.Equal(t, 0, server.getOpCount("files/upload"))
+	assert.Equal(t, 1, server.getOpCount("files/upload_session/start"))
+	assert.Equal(t, 1, server.getOpCount("files/upload_session/append_v2"))
+	assert.Equal(t, 1, server.getOpCount("files/upload_session/finish"))
+	assert.Equal(t, 0, server.getSessionsCount())
+
+	downloaded, err := downloadTestDropboxFile(t, fs, "/large.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	downloaded, err = downloadTestDropboxFile(t, fs, "/large.bin", int64(len(data)-5))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("56789"), downloaded)
+	info, err := fs.Stat("/large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, `"`+getFakeDropboxContentHash(data)+`"`, GetETag(info))
+	// a failed chunk is not committed and the existing file is preserved
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "files/upload_session/append_v2" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	err = uploadTestDropboxFile(t, fs, "/large.bin", bytes.Repeat([]byte("a"), 3*1024*1024))
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.getOpCount("files/upload_session/finish"))
+	entry, ok = server.getEntry("/large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, entry.data)
+}
+
+func TestDropboxFsErrors(t *testing.T) {
+	server := newFakeDropboxServer(t)
+	fs := newTestDropboxFs(t, server, DropboxFsConfig{
+		RefreshToken: kms.NewPlainSecret("invalid"),
+	})
+	_, err := fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to get Dropbox access token")
+
+	fs = newTestDropboxFs(t, server, DropboxFsConfig{})
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/file", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, err = downloadTestDropboxFile(t, fs, "/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+
+	server.putFile("/file", []byte("data"))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "files/get_metadata" || op == "files/list_folder" || op == "files/upload" ||
+			op == "files/download" || op == "files/move_v2" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.ReadDir("/")
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.Rename("/file", "/file1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("/dir", true)
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestDropboxFile(t, fs, "/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestDropboxFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	// rate limited requests are retried
+	rateLimited := false
+	metadataOps := server.getOpCount("files/get_metadata")
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "files/get_metadata" && !rateLimited {
+			rateLimited = true
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+	info, err := fs.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	assert.Equal(t, metadataOps+2, server.getOpCount("files/get_metadata"))
+	server.setOnRequest(nil)
+	_, ok := server.getEntry("/file1")
+	assert.False(t, ok)
+	// the namespace is sent in all the requests
+	server.mu.Lock()
+	server.namespace = `{".tag":"namespace_id","namespace_id":"123"}`
+	server.mu.Unlock()
+	_, err = fs.Stat("/file")
+	assert.Error(t, err)
+	fs = newTestDropboxFs(t, server, DropboxFsConfig{
+		NamespaceID: "123",
+	})
+	data, err := downloadTestDropboxFile(t, fs, "/file", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+}
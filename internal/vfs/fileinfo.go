@@ -26,6 +26,7 @@ type FileInfo struct {
 	sizeInBytes int64
 	modTime     time.Time
 	mode        os.FileMode
+	etag        string
 }
 
 // NewFileInfo creates file info.
@@ -77,6 +78,17 @@ func (fi *FileInfo) SetMode(mode os.FileMode) {
 	fi.mode = mode
 }
 
+// SetETag sets a strong entity tag derived from the file contents
+func (fi *FileInfo) SetETag(etag string) {
+	fi.etag = etag
+}
+
+// ETag returns the strong entity tag derived from the file contents,
+// if available
+func (fi *FileInfo) ETag() string {
+	return fi.etag
+}
+
 // Sys provides the underlying data source (can return nil)
 func (fi *FileInfo) Sys() any {
 	return nil
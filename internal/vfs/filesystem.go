@@ -22,6 +22,38 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 )
 
+// Filesystem providers not yet defined in the SDK
+const (
+	// B2FilesystemProvider defines the provider for the native Backblaze B2 API
+	B2FilesystemProvider sdk.FilesystemProvider = 7
+	// DropboxFilesystemProvider defines the provider for Dropbox
+	DropboxFilesystemProvider sdk.FilesystemProvider = 8
+	// GDriveFilesystemProvider defines the provider for Google Drive
+	GDriveFilesystemProvider sdk.FilesystemProvider = 9
+	// OneDriveFilesystemProvider defines the provider for OneDrive and
+	// SharePoint document libraries
+	OneDriveFilesystemProvider sdk.FilesystemProvider = 10
+	// WebDAVFilesystemProvider defines the provider for remote WebDAV servers
+	WebDAVFilesystemProvider sdk.FilesystemProvider = 11
+	// SMBFilesystemProvider defines the provider for SMB/CIFS shares
+	SMBFilesystemProvider sdk.FilesystemProvider = 12
+	// HDFSFilesystemProvider defines the provider for HDFS clusters
+	HDFSFilesystemProvider sdk.FilesystemProvider = 13
+	// SwiftFilesystemProvider defines the provider for OpenStack Swift object storage
+	SwiftFilesystemProvider sdk.FilesystemProvider = 14
+)
+
+// IsProviderSupported returns true if the specified provider is supported
+func IsProviderSupported(provider sdk.FilesystemProvider) bool {
+	switch provider {
+	case B2FilesystemProvider, DropboxFilesystemProvider, GDriveFilesystemProvider, OneDriveFilesystemProvider,
+		WebDAVFilesystemProvider, SMBFilesystemProvider, HDFSFilesystemProvider, SwiftFilesystemProvider:
+		return true
+	default:
+		return sdk.IsProviderSupported(provider)
+	}
+}
+
 // Filesystem defines filesystem details
 type Filesystem struct {
 	RedactedSecret string                 `json:"-"`
@@ -33,12 +65,22 @@ type Filesystem struct {
 	CryptConfig    CryptFsConfig          `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig           `json:"sftpconfig,omitempty"`
 	HTTPConfig     HTTPFsConfig           `json:"httpconfig,omitempty"`
+	B2Config       B2FsConfig             `json:"b2config,omitempty"`
+	DropboxConfig  DropboxFsConfig        `json:"dropboxconfig,omitempty"`
+	GDriveConfig   GDriveFsConfig         `json:"gdriveconfig,omitempty"`
+	OneDriveConfig OneDriveFsConfig       `json:"onedriveconfig,omitempty"`
+	WebDAVConfig   WebDAVFsConfig         `json:"webdavconfig,omitempty"`
+	SMBConfig      SMBFsConfig            `json:"smbconfig,omitempty"`
+	HDFSConfig     HDFSFsConfig           `json:"hdfsconfig,omitempty"`
+	SwiftConfig    SwiftFsConfig          `json:"swiftconfig,omitempty"`
 }
 
 // SetEmptySecrets sets the secrets to empty
 func (f *Filesystem) SetEmptySecrets() {
 	f.S3Config.AccessSecret = kms.NewEmptySecret()
+	f.S3Config.SSECustomerKey = kms.NewEmptySecret()
 	f.GCSConfig.Credentials = kms.NewEmptySecret()
+	f.GCSConfig.EncryptionKey = kms.NewEmptySecret()
 	f.AzBlobConfig.AccountKey = kms.NewEmptySecret()
 	f.AzBlobConfig.SASURL = kms.NewEmptySecret()
 	f.CryptConfig.Passphrase = kms.NewEmptySecret()
@@ -47,6 +89,21 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
 	f.HTTPConfig.Password = kms.NewEmptySecret()
 	f.HTTPConfig.APIKey = kms.NewEmptySecret()
+	f.B2Config.ApplicationKey = kms.NewEmptySecret()
+	f.DropboxConfig.ClientSecret = kms.NewEmptySecret()
+	f.DropboxConfig.RefreshToken = kms.NewEmptySecret()
+	f.GDriveConfig.Credentials = kms.NewEmptySecret()
+	f.GDriveConfig.ClientSecret = kms.NewEmptySecret()
+	f.GDriveConfig.RefreshToken = kms.NewEmptySecret()
+	f.OneDriveConfig.ClientSecret = kms.NewEmptySecret()
+	f.OneDriveConfig.RefreshToken = kms.NewEmptySecret()
+	f.WebDAVConfig.Password = kms.NewEmptySecret()
+	f.WebDAVConfig.BearerToken = kms.NewEmptySecret()
+	f.SMBConfig.Password = kms.NewEmptySecret()
+	f.HDFSConfig.KerberosPassword = kms.NewEmptySecret()
+	f.HDFSConfig.KerberosKeytab = kms.NewEmptySecret()
+	f.SwiftConfig.Password = kms.NewEmptySecret()
+	f.SwiftConfig.ApplicationCredentialSecret = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -54,9 +111,15 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.S3Config.AccessSecret == nil {
 		f.S3Config.AccessSecret = kms.NewEmptySecret()
 	}
+	if f.S3Config.SSECustomerKey == nil {
+		f.S3Config.SSECustomerKey = kms.NewEmptySecret()
+	}
 	if f.GCSConfig.Credentials == nil {
 		f.GCSConfig.Credentials = kms.NewEmptySecret()
 	}
+	if f.GCSConfig.EncryptionKey == nil {
+		f.GCSConfig.EncryptionKey = kms.NewEmptySecret()
+	}
 	if f.AzBlobConfig.AccountKey == nil {
 		f.AzBlobConfig.AccountKey = kms.NewEmptySecret()
 	}
@@ -81,6 +144,16 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.HTTPConfig.APIKey == nil {
 		f.HTTPConfig.APIKey = kms.NewEmptySecret()
 	}
+	if f.B2Config.ApplicationKey == nil {
+		f.B2Config.ApplicationKey = kms.NewEmptySecret()
+	}
+	f.DropboxConfig.setEmptyCredentialsIfNil()
+	f.GDriveConfig.setEmptyCredentialsIfNil()
+	f.OneDriveConfig.setEmptyCredentialsIfNil()
+	f.WebDAVConfig.setEmptyCredentialsIfNil()
+	f.SMBConfig.setEmptyCredentialsIfNil()
+	f.HDFSConfig.setEmptyCredentialsIfNil()
+	f.SwiftConfig.setEmptyCredentialsIfNil()
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -90,9 +163,15 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.S3Config.AccessSecret != nil && f.S3Config.AccessSecret.IsEmpty() {
 		f.S3Config.AccessSecret = nil
 	}
+	if f.S3Config.SSECustomerKey != nil && f.S3Config.SSECustomerKey.IsEmpty() {
+		f.S3Config.SSECustomerKey = nil
+	}
 	if f.GCSConfig.Credentials != nil && f.GCSConfig.Credentials.IsEmpty() {
 		f.GCSConfig.Credentials = nil
 	}
+	if f.GCSConfig.EncryptionKey != nil && f.GCSConfig.EncryptionKey.IsEmpty() {
+		f.GCSConfig.EncryptionKey = nil
+	}
 	if f.AzBlobConfig.AccountKey != nil && f.AzBlobConfig.AccountKey.IsEmpty() {
 		f.AzBlobConfig.AccountKey = nil
 	}
@@ -104,6 +183,158 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	}
 	f.SFTPConfig.setNilSecretsIfEmpty()
 	f.HTTPConfig.setNilSecretsIfEmpty()
+	if f.B2Config.ApplicationKey != nil && f.B2Config.ApplicationKey.IsEmpty() {
+		f.B2Config.ApplicationKey = nil
+	}
+	f.DropboxConfig.setNilSecretsIfEmpty()
+	f.GDriveConfig.setNilSecretsIfEmpty()
+	f.OneDriveConfig.setNilSecretsIfEmpty()
+	f.WebDAVConfig.setNilSecretsIfEmpty()
+	f.SMBConfig.setNilSecretsIfEmpty()
+	f.HDFSConfig.setNilSecretsIfEmpty()
+	f.SwiftConfig.setNilSecretsIfEmpty()
+}
+
+// KeepEncryptedSecrets replaces the secrets that are not plain or empty with the
+// values from the current configuration. This way the encrypted or redacted
+// secrets returned to the clients are preserved on update
+func (f *Filesystem) KeepEncryptedSecrets(current *Filesystem) {
+	// we use the new access secret if plain or empty, otherwise the old value
+	switch f.Provider {
+	case sdk.S3FilesystemProvider:
+		if f.S3Config.AccessSecret.IsNotPlainAndNotEmpty() {
+			f.S3Config.AccessSecret = current.S3Config.AccessSecret
+		}
+		if f.S3Config.SSECustomerKey.IsNotPlainAndNotEmpty() {
+			f.S3Config.SSECustomerKey = current.S3Config.SSECustomerKey
+		}
+	case sdk.AzureBlobFilesystemProvider:
+		if f.AzBlobConfig.AccountKey.IsNotPlainAndNotEmpty() {
+			f.AzBlobConfig.AccountKey = current.AzBlobConfig.AccountKey
+		}
+		if f.AzBlobConfig.SASURL.IsNotPlainAndNotEmpty() {
+			f.AzBlobConfig.SASURL = current.AzBlobConfig.SASURL
+		}
+	case sdk.GCSFilesystemProvider:
+		// for GCS credentials will be cleared if we enable automatic credentials
+		// so keep the old credentials here if no new credentials are provided
+		if !f.GCSConfig.Credentials.IsPlain() {
+			f.GCSConfig.Credentials = current.GCSConfig.Credentials
+		}
+		if f.GCSConfig.EncryptionKey.IsNotPlainAndNotEmpty() {
+			f.GCSConfig.EncryptionKey = current.GCSConfig.EncryptionKey
+		}
+	case sdk.CryptedFilesystemProvider:
+		if f.CryptConfig.Passphrase.IsNotPlainAndNotEmpty() {
+			f.CryptConfig.Passphrase = current.CryptConfig.Passphrase
+		}
+	case sdk.SFTPFilesystemProvider:
+		f.keepSFTPFsEncryptedSecrets(current)
+	case sdk.HTTPFilesystemProvider:
+		f.keepHTTPFsEncryptedSecrets(current)
+	case B2FilesystemProvider:
+		if f.B2Config.ApplicationKey.IsNotPlainAndNotEmpty() {
+			f.B2Config.ApplicationKey = current.B2Config.ApplicationKey
+		}
+	case DropboxFilesystemProvider:
+		f.keepDropboxFsEncryptedSecrets(current)
+	case GDriveFilesystemProvider:
+		f.keepGDriveFsEncryptedSecrets(current)
+	case OneDriveFilesystemProvider:
+		f.keepOneDriveFsEncryptedSecrets(current)
+	case WebDAVFilesystemProvider:
+		f.keepWebDAVFsEncryptedSecrets(current)
+	case SMBFilesystemProvider:
+		if f.SMBConfig.Password.IsNotPlainAndNotEmpty() {
+			f.SMBConfig.Password = current.SMBConfig.Password
+		}
+	case HDFSFilesystemProvider:
+		f.keepHDFSFsEncryptedSecrets(current)
+	case SwiftFilesystemProvider:
+		f.keepSwiftFsEncryptedSecrets(current)
+	}
+}
+
+func (f *Filesystem) keepSFTPFsEncryptedSecrets(current *Filesystem) {
+	if f.SFTPConfig.Password.IsNotPlainAndNotEmpty() {
+		f.SFTPConfig.Password = current.SFTPConfig.Password
+	}
+	if f.SFTPConfig.PrivateKey.IsNotPlainAndNotEmpty() {
+		f.SFTPConfig.PrivateKey = current.SFTPConfig.PrivateKey
+	}
+	if f.SFTPConfig.KeyPassphrase.IsNotPlainAndNotEmpty() {
+		f.SFTPConfig.KeyPassphrase = current.SFTPConfig.KeyPassphrase
+	}
+}
+
+func (f *Filesystem) keepHTTPFsEncryptedSecrets(current *Filesystem) {
+	if f.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
+		f.HTTPConfig.Password = current.HTTPConfig.Password
+	}
+	if f.HTTPConfig.APIKey.IsNotPlainAndNotEmpty() {
+		f.HTTPConfig.APIKey = current.HTTPConfig.APIKey
+	}
+}
+
+func (f *Filesystem) keepDropboxFsEncryptedSecrets(current *Filesystem) {
+	if f.DropboxConfig.ClientSecret.IsNotPlainAndNotEmpty() {
+		f.DropboxConfig.ClientSecret = current.DropboxConfig.ClientSecret
+	}
+	if f.DropboxConfig.RefreshToken.IsNotPlainAndNotEmpty() {
+		f.DropboxConfig.RefreshToken = current.DropboxConfig.RefreshToken
+	}
+}
+
+func (f *Filesystem) keepGDriveFsEncryptedSecrets(current *Filesystem) {
+	// as for GCS, keep the old service account credentials if no new
+	// credentials are provided
+	if !f.GDriveConfig.Credentials.IsPlain() {
+		f.GDriveConfig.Credentials = current.GDriveConfig.Credentials
+	}
+	if f.GDriveConfig.ClientSecret.IsNotPlainAndNotEmpty() {
+		f.GDriveConfig.ClientSecret = current.GDriveConfig.ClientSecret
+	}
+	if f.GDriveConfig.RefreshToken.IsNotPlainAndNotEmpty() {
+		f.GDriveConfig.RefreshToken = current.GDriveConfig.RefreshToken
+	}
+}
+
+func (f *Filesystem) keepOneDriveFsEncryptedSecrets(current *Filesystem) {
+	if f.OneDriveConfig.ClientSecret.IsNotPlainAndNotEmpty() {
+		f.OneDriveConfig.ClientSecret = current.OneDriveConfig.ClientSecret
+	}
+	if f.OneDriveConfig.RefreshToken.IsNotPlainAndNotEmpty() {
+		f.OneDriveConfig.RefreshToken = current.OneDriveConfig.RefreshToken
+	}
+}
+
+func (f *Filesystem) keepWebDAVFsEncryptedSecrets(current *Filesystem) {
+	if f.WebDAVConfig.Password.IsNotPlainAndNotEmpty() {
+		f.WebDAVConfig.Password = current.WebDAVConfig.Password
+	}
+	if f.WebDAVConfig.BearerToken.IsNotPlainAndNotEmpty() {
+		f.WebDAVConfig.BearerToken = current.WebDAVConfig.BearerToken
+	}
+}
+
+func (f *Filesystem) keepHDFSFsEncryptedSecrets(current *Filesystem) {
+	if f.HDFSConfig.KerberosPassword.IsNotPlainAndNotEmpty() {
+		f.HDFSConfig.KerberosPassword = current.HDFSConfig.KerberosPassword
+	}
+	// the keytab is uploaded as file, keep the old one if no new keytab
+	// is provided
+	if !f.HDFSConfig.KerberosKeytab.IsPlain() {
+		f.HDFSConfig.KerberosKeytab = current.HDFSConfig.KerberosKeytab
+	}
+}
+
+func (f *Filesystem) keepSwiftFsEncryptedSecrets(current *Filesystem) {
+	if f.SwiftConfig.Password.IsNotPlainAndNotEmpty() {
+		f.SwiftConfig.Password = current.SwiftConfig.Password
+	}
+	if f.SwiftConfig.ApplicationCredentialSecret.IsNotPlainAndNotEmpty() {
+		f.SwiftConfig.ApplicationCredentialSecret = current.SwiftConfig.ApplicationCredentialSecret
+	}
 }
 
 // IsEqual returns true if the fs is equal to other
@@ -124,6 +355,22 @@ func (f *Filesystem) IsEqual(other Filesystem) bool {
 		return f.SFTPConfig.isEqual(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isEqual(other.HTTPConfig)
+	case B2FilesystemProvider:
+		return f.B2Config.isEqual(other.B2Config)
+	case DropboxFilesystemProvider:
+		return f.DropboxConfig.isEqual(other.DropboxConfig)
+	case GDriveFilesystemProvider:
+		return f.GDriveConfig.isEqual(other.GDriveConfig)
+	case OneDriveFilesystemProvider:
+		return f.OneDriveConfig.isEqual(other.OneDriveConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isEqual(other.WebDAVConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isEqual(other.SMBConfig)
+	case HDFSFilesystemProvider:
+		return f.HDFSConfig.isEqual(other.HDFSConfig)
+	case SwiftFilesystemProvider:
+		return f.SwiftConfig.isEqual(other.SwiftConfig)
 	default:
 		return true
 	}
@@ -147,6 +394,22 @@ func (f *Filesystem) IsSameResource(other Filesystem) bool {
 		return f.SFTPConfig.isSameResource(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isSameResource(other.HTTPConfig)
+	case B2FilesystemProvider:
+		return f.B2Config.isSameResource(other.B2Config)
+	case DropboxFilesystemProvider:
+		return f.DropboxConfig.isSameResource(other.DropboxConfig)
+	case GDriveFilesystemProvider:
+		return f.GDriveConfig.isSameResource(other.GDriveConfig)
+	case OneDriveFilesystemProvider:
+		return f.OneDriveConfig.isSameResource(other.OneDriveConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isSameResource(other.WebDAVConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isSameResource(other.SMBConfig)
+	case HDFSFilesystemProvider:
+		return f.HDFSConfig.isSameResource(other.HDFSConfig)
+	case SwiftFilesystemProvider:
+		return f.SwiftConfig.isSameResource(other.SwiftConfig)
 	default:
 		return true
 	}
@@ -176,6 +439,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return nil
 	case sdk.GCSFilesystemProvider:
 		if err := f.GCSConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -187,6 +458,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return nil
 	case sdk.AzureBlobFilesystemProvider:
 		if err := f.AzBlobConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -198,6 +477,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return nil
 	case sdk.CryptedFilesystemProvider:
 		if err := f.CryptConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -209,6 +496,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return validateOSFsConfig(&f.CryptConfig.OSFsConfig)
 	case sdk.SFTPFilesystemProvider:
 		if err := f.SFTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -220,6 +515,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return nil
 	case sdk.HTTPFilesystemProvider:
 		if err := f.HTTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -231,6 +534,166 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case B2FilesystemProvider:
+		if err := f.B2Config.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case DropboxFilesystemProvider:
+		if err := f.DropboxConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case GDriveFilesystemProvider:
+		if err := f.GDriveConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case OneDriveFilesystemProvider:
+		if err := f.OneDriveConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case WebDAVFilesystemProvider:
+		if err := f.WebDAVConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case SMBFilesystemProvider:
+		if err := f.SMBConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case HDFSFilesystemProvider:
+		if err := f.HDFSConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
+		return nil
+	case SwiftFilesystemProvider:
+		if err := f.SwiftConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.OSConfig = sdk.OSFsConfig{}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
 		return nil
 	default:
 		f.Provider = sdk.LocalFilesystemProvider
@@ -240,6 +703,14 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.B2Config = B2FsConfig{}
+		f.DropboxConfig = DropboxFsConfig{}
+		f.GDriveConfig = GDriveFsConfig{}
+		f.OneDriveConfig = OneDriveFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.HDFSConfig = HDFSFsConfig{}
+		f.SwiftConfig = SwiftFsConfig{}
 		return validateOSFsConfig(&f.OSConfig)
 	}
 }
@@ -249,9 +720,15 @@ func (f *Filesystem) HasRedactedSecret() bool {
 	// TODO move vfs specific code into each *FsConfig struct
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
-		return f.S3Config.AccessSecret.IsRedacted()
+		if f.S3Config.AccessSecret.IsRedacted() {
+			return true
+		}
+		return f.S3Config.SSECustomerKey.IsRedacted()
 	case sdk.GCSFilesystemProvider:
-		return f.GCSConfig.Credentials.IsRedacted()
+		if f.GCSConfig.Credentials.IsRedacted() {
+			return true
+		}
+		return f.GCSConfig.EncryptionKey.IsRedacted()
 	case sdk.AzureBlobFilesystemProvider:
 		if f.AzBlobConfig.AccountKey.IsRedacted() {
 			return true
@@ -272,6 +749,37 @@ func (f *Filesystem) HasRedactedSecret() bool {
 			return true
 		}
 		return f.HTTPConfig.APIKey.IsRedacted()
+	case B2FilesystemProvider:
+		return f.B2Config.ApplicationKey.IsRedacted()
+	case DropboxFilesystemProvider:
+		if f.DropboxConfig.ClientSecret.IsRedacted() {
+			return true
+		}
+		return f.DropboxConfig.RefreshToken.IsRedacted()
+	case GDriveFilesystemProvider:
+		return f.GDriveConfig.hasRedactedSecret()
+	case OneDriveFilesystemProvider:
+		if f.OneDriveConfig.ClientSecret.IsRedacted() {
+			return true
+		}
+		return f.OneDriveConfig.RefreshToken.IsRedacted()
+	case WebDAVFilesystemProvider:
+		if f.WebDAVConfig.Password.IsRedacted() {
+			return true
+		}
+		return f.WebDAVConfig.BearerToken.IsRedacted()
+	case SMBFilesystemProvider:
+		return f.SMBConfig.Password.IsRedacted()
+	case HDFSFilesystemProvider:
+		if f.HDFSConfig.KerberosPassword.IsRedacted() {
+			return true
+		}
+		return f.HDFSConfig.KerberosKeytab.IsRedacted()
+	case SwiftFilesystemProvider:
+		if f.SwiftConfig.Password.IsRedacted() {
+			return true
+		}
+		return f.SwiftConfig.ApplicationCredentialSecret.IsRedacted()
 	}
 
 	return false
@@ -292,12 +800,33 @@ func (f *Filesystem) HideConfidentialData() {
 		f.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		f.HTTPConfig.HideConfidentialData()
+	case B2FilesystemProvider:
+		f.B2Config.HideConfidentialData()
+	case DropboxFilesystemProvider:
+		f.DropboxConfig.HideConfidentialData()
+	case GDriveFilesystemProvider:
+		f.GDriveConfig.HideConfidentialData()
+	case OneDriveFilesystemProvider:
+		f.OneDriveConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		f.WebDAVConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		f.SMBConfig.HideConfidentialData()
+	case HDFSFilesystemProvider:
+		f.HDFSConfig.HideConfidentialData()
+	case SwiftFilesystemProvider:
+		f.SwiftConfig.HideConfidentialData()
 	}
 }
 
 // GetACopy returns a filesystem copy
 func (f *Filesystem) GetACopy() Filesystem {
 	f.SetEmptySecretsIfNil()
+	var azAccessTierRules []AzBlobAccessTierRule
+	if len(f.AzBlobConfig.AccessTierRules) > 0 {
+		azAccessTierRules = make([]AzBlobAccessTierRule, len(f.AzBlobConfig.AccessTierRules))
+		copy(azAccessTierRules, f.AzBlobConfig.AccessTierRules)
+	}
 	fs := Filesystem{
 		Provider: f.Provider,
 		OSConfig: sdk.OSFsConfig{
@@ -323,7 +852,13 @@ func (f *Filesystem) GetACopy() Filesystem {
 				ForcePathStyle:      f.S3Config.ForcePathStyle,
 				SkipTLSVerify:       f.S3Config.SkipTLSVerify,
 			},
-			AccessSecret: f.S3Config.AccessSecret.Clone(),
+			AccessSecret:              f.S3Config.AccessSecret.Clone(),
+			SSEKMSKeyID:               f.S3Config.SSEKMSKeyID,
+			SSECustomerKey:            f.S3Config.SSECustomerKey.Clone(),
+			ObjectLockMode:            f.S3Config.ObjectLockMode,
+			ObjectLockRetentionDays:   f.S3Config.ObjectLockRetentionDays,
+			ObjectLockLegalHold:       f.S3Config.ObjectLockLegalHold,
+			AssumeRoleWithWebIdentity: f.S3Config.AssumeRoleWithWebIdentity,
 		},
 		GCSConfig: GCSFsConfig{
 			BaseGCSFsConfig: sdk.BaseGCSFsConfig{
@@ -335,7 +870,9 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartSize:       f.GCSConfig.UploadPartSize,
 				UploadPartMaxTime:    f.GCSConfig.UploadPartMaxTime,
 			},
-			Credentials: f.GCSConfig.Credentials.Clone(),
+			Credentials:   f.GCSConfig.Credentials.Clone(),
+			KMSKeyName:    f.GCSConfig.KMSKeyName,
+			EncryptionKey: f.GCSConfig.EncryptionKey.Clone(),
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -350,8 +887,11 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UseEmulator:         f.AzBlobConfig.UseEmulator,
 				AccessTier:          f.AzBlobConfig.AccessTier,
 			},
-			AccountKey: f.AzBlobConfig.AccountKey.Clone(),
-			SASURL:     f.AzBlobConfig.SASURL.Clone(),
+			AccountKey:            f.AzBlobConfig.AccountKey.Clone(),
+			SASURL:                f.AzBlobConfig.SASURL.Clone(),
+			HierarchicalNamespace: f.AzBlobConfig.HierarchicalNamespace,
+			AccessTierRules:       azAccessTierRules,
+			RehydratePriority:     f.AzBlobConfig.RehydratePriority,
 		},
 		CryptConfig: CryptFsConfig{
 			OSFsConfig: sdk.OSFsConfig{
@@ -383,6 +923,96 @@ func (f *Filesystem) GetACopy() Filesystem {
 			Password: f.HTTPConfig.Password.Clone(),
 			APIKey:   f.HTTPConfig.APIKey.Clone(),
 		},
+		B2Config: B2FsConfig{
+			Bucket:              f.B2Config.Bucket,
+			KeyPrefix:           f.B2Config.KeyPrefix,
+			KeyID:               f.B2Config.KeyID,
+			ApplicationKey:      f.B2Config.ApplicationKey.Clone(),
+			UploadPartSize:      f.B2Config.UploadPartSize,
+			UploadConcurrency:   f.B2Config.UploadConcurrency,
+			DownloadPartSize:    f.B2Config.DownloadPartSize,
+			DownloadConcurrency: f.B2Config.DownloadConcurrency,
+			HideOnDelete:        f.B2Config.HideOnDelete,
+		},
+		DropboxConfig: DropboxFsConfig{
+			RootPath:       f.DropboxConfig.RootPath,
+			ClientID:       f.DropboxConfig.ClientID,
+			ClientSecret:   f.DropboxConfig.ClientSecret.Clone(),
+			RefreshToken:   f.DropboxConfig.RefreshToken.Clone(),
+			NamespaceID:    f.DropboxConfig.NamespaceID,
+			UploadPartSize: f.DropboxConfig.UploadPartSize,
+		},
+		GDriveConfig: GDriveFsConfig{
+			DriveID:        f.GDriveConfig.DriveID,
+			RootFolderID:   f.GDriveConfig.RootFolderID,
+			AuthMethod:     f.GDriveConfig.AuthMethod,
+			Credentials:    f.GDriveConfig.Credentials.Clone(),
+			Subject:        f.GDriveConfig.Subject,
+			ClientID:       f.GDriveConfig.ClientID,
+			ClientSecret:   f.GDriveConfig.ClientSecret.Clone(),
+			RefreshToken:   f.GDriveConfig.RefreshToken.Clone(),
+			ExportFormat:   f.GDriveConfig.ExportFormat,
+			UploadPartSize: f.GDriveConfig.UploadPartSize,
+			UseTrash:       f.GDriveConfig.UseTrash,
+		},
+		OneDriveConfig: OneDriveFsConfig{
+			TenantID:       f.OneDriveConfig.TenantID,
+			ClientID:       f.OneDriveConfig.ClientID,
+			ClientSecret:   f.OneDriveConfig.ClientSecret.Clone(),
+			RefreshToken:   f.OneDriveConfig.RefreshToken.Clone(),
+			DriveID:        f.OneDriveConfig.DriveID,
+			RootPath:       f.OneDriveConfig.RootPath,
+			UploadPartSize: f.OneDriveConfig.UploadPartSize,
+		},
+		WebDAVConfig: WebDAVFsConfig{
+			Endpoint:      f.WebDAVConfig.Endpoint,
+			AuthMethod:    f.WebDAVConfig.AuthMethod,
+			Username:      f.WebDAVConfig.Username,
+			Password:      f.WebDAVConfig.Password.Clone(),
+			BearerToken:   f.WebDAVConfig.BearerToken.Clone(),
+			SkipTLSVerify: f.WebDAVConfig.SkipTLSVerify,
+			BufferUploads: f.WebDAVConfig.BufferUploads,
+		},
+		SMBConfig: SMBFsConfig{
+			Endpoint:       f.SMBConfig.Endpoint,
+			Share:          f.SMBConfig.Share,
+			Username:       f.SMBConfig.Username,
+			Password:       f.SMBConfig.Password.Clone(),
+			Domain:         f.SMBConfig.Domain,
+			Prefix:         f.SMBConfig.Prefix,
+			RequireSigning: f.SMBConfig.RequireSigning,
+		},
+		HDFSConfig: HDFSFsConfig{
+			Endpoint:          f.HDFSConfig.Endpoint,
+			AuthMethod:        f.HDFSConfig.AuthMethod,
+			Username:          f.HDFSConfig.Username,
+			KerberosPrincipal: f.HDFSConfig.KerberosPrincipal,
+			KerberosPassword:  f.HDFSConfig.KerberosPassword.Clone(),
+			KerberosKeytab:    f.HDFSConfig.KerberosKeytab.Clone(),
+			Krb5Conf:          f.HDFSConfig.Krb5Conf,
+			ServicePrincipal:  f.HDFSConfig.ServicePrincipal,
+			DoAs:              f.HDFSConfig.DoAs,
+			Prefix:            f.HDFSConfig.Prefix,
+			SkipTLSVerify:     f.HDFSConfig.SkipTLSVerify,
+		},
+		SwiftConfig: SwiftFsConfig{
+			AuthURL:                     f.SwiftConfig.AuthURL,
+			Username:                    f.SwiftConfig.Username,
+			UserDomain:                  f.SwiftConfig.UserDomain,
+			Password:                    f.SwiftConfig.Password.Clone(),
+			ApplicationCredentialID:     f.SwiftConfig.ApplicationCredentialID,
+			ApplicationCredentialSecret: f.SwiftConfig.ApplicationCredentialSecret.Clone(),
+			ProjectID:                   f.SwiftConfig.ProjectID,
+			ProjectName:                 f.SwiftConfig.ProjectName,
+			ProjectDomain:               f.SwiftConfig.ProjectDomain,
+			Region:                      f.SwiftConfig.Region,
+			EndpointType:                f.SwiftConfig.EndpointType,
+			Container:                   f.SwiftConfig.Container,
+			ContainerPerUser:            f.SwiftConfig.ContainerPerUser,
+			KeyPrefix:                   f.SwiftConfig.KeyPrefix,
+			UploadPartSize:              f.SwiftConfig.UploadPartSize,
+			SkipTLSVerify:               f.SwiftConfig.SkipTLSVerify,
+		},
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
@@ -42,6 +42,8 @@ type BaseVirtualFolder struct {
 	Groups []string `json:"groups,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// Tenant the folder belongs to, empty means no tenant
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -66,6 +68,7 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		Users:           users,
 		Groups:          v.Groups,
 		FsConfig:        v.FsConfig.GetACopy(),
+		Tenant:          v.Tenant,
 	}
 }
 
@@ -89,6 +92,22 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 		v.FsConfig.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		v.FsConfig.HTTPConfig.HideConfidentialData()
+	case B2FilesystemProvider:
+		v.FsConfig.B2Config.HideConfidentialData()
+	case DropboxFilesystemProvider:
+		v.FsConfig.DropboxConfig.HideConfidentialData()
+	case GDriveFilesystemProvider:
+		v.FsConfig.GDriveConfig.HideConfidentialData()
+	case OneDriveFilesystemProvider:
+		v.FsConfig.OneDriveConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		v.FsConfig.WebDAVConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		v.FsConfig.SMBConfig.HideConfidentialData()
+	case HDFSFilesystemProvider:
+		v.FsConfig.HDFSConfig.HideConfidentialData()
+	case SwiftFilesystemProvider:
+		v.FsConfig.SwiftConfig.HideConfidentialData()
 	}
 }
 
@@ -115,6 +134,22 @@ func (v *BaseVirtualFolder) hasPathPlaceholder() bool {
 		return strings.Contains(v.FsConfig.GCSConfig.KeyPrefix, placeholder)
 	case sdk.AzureBlobFilesystemProvider:
 		return strings.Contains(v.FsConfig.AzBlobConfig.KeyPrefix, placeholder)
+	case B2FilesystemProvider:
+		return strings.Contains(v.FsConfig.B2Config.KeyPrefix, placeholder)
+	case DropboxFilesystemProvider:
+		return strings.Contains(v.FsConfig.DropboxConfig.RootPath, placeholder)
+	case GDriveFilesystemProvider:
+		return strings.Contains(v.FsConfig.GDriveConfig.Subject, placeholder)
+	case OneDriveFilesystemProvider:
+		return strings.Contains(v.FsConfig.OneDriveConfig.RootPath, placeholder)
+	case WebDAVFilesystemProvider:
+		return strings.Contains(v.FsConfig.WebDAVConfig.Endpoint, placeholder)
+	case SMBFilesystemProvider:
+		return strings.Contains(v.FsConfig.SMBConfig.Prefix, placeholder)
+	case HDFSFilesystemProvider:
+		return strings.Contains(v.FsConfig.HDFSConfig.Prefix, placeholder)
+	case SwiftFilesystemProvider:
+		return strings.Contains(v.FsConfig.SwiftConfig.KeyPrefix, placeholder)
 	case sdk.SFTPFilesystemProvider:
 		return strings.Contains(v.FsConfig.SFTPConfig.Prefix, placeholder)
 	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
@@ -153,13 +188,31 @@ func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []
 		return NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return NewHTTPFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.HTTPConfig)
+	case B2FilesystemProvider:
+		return NewB2Fs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.B2Config)
+	case DropboxFilesystemProvider:
+		return NewDropboxFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.DropboxConfig)
+	case GDriveFilesystemProvider:
+		return NewGDriveFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.GDriveConfig)
+	case OneDriveFilesystemProvider:
+		return NewOneDriveFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.OneDriveConfig)
+	case WebDAVFilesystemProvider:
+		return NewWebDAVFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.WebDAVConfig)
+	case SMBFilesystemProvider:
+		return NewSMBFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.SMBConfig)
+	case HDFSFilesystemProvider:
+		return NewHDFSFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.HDFSConfig)
+	case SwiftFilesystemProvider:
+		return NewSwiftFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.SwiftConfig.ForOwner(v.Name))
 	default:
 		return NewOsFs(connectionID, v.MappedPath, v.VirtualPath, &v.FsConfig.OSConfig), nil
 	}
 }
 
-// ScanQuota scans the folder and returns the number of files and their size
-func (v *VirtualFolder) ScanQuota() (int, int64, error) {
+// ScanQuota scans the folder and returns the number of files and their size.
+// If incremental is true only the directories changed since the previous scan
+// are scanned again
+func (v *VirtualFolder) ScanQuota(incremental bool) (int, int64, error) {
 	if v.hasPathPlaceholder() {
 		return 0, 0, errors.New("cannot scan quota: this folder has a path placeholder")
 	}
@@ -169,7 +222,9 @@ func (v *VirtualFolder) ScanQuota() (int, int64, error) {
 	}
 	defer fs.Close()
 
-	return fs.ScanRootDirContents()
+	return ScanQuota(fs, FolderQuotaScanKey(v.Name), QuotaScanOptions{
+		Incremental: incremental,
+	})
 }
 
 // IsIncludedInUserQuota returns true if the virtual folder is included in user quota
@@ -19,8 +19,12 @@ package vfs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net/http"
@@ -63,6 +67,10 @@ type GCSFs struct {
 	svc            *storage.Client
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	// identifies the bucket in the metadata cache
+	cacheBackend string
+	// customer supplied encryption key, if any
+	encryptionKey []byte
 }
 
 func init() {
@@ -87,6 +95,7 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 	if err = fs.config.validate(); err != nil {
 		return fs, err
 	}
+	fs.cacheBackend = getMetadataCacheBackend(gcsfsName, fs.config.Bucket)
 	ctx := context.Background()
 	if fs.config.AutomaticCredentials > 0 {
 		fs.svc, err = storage.NewClient(ctx)
@@ -97,7 +106,19 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 		}
 		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
 	}
-	return fs, err
+	if err != nil {
+		return fs, err
+	}
+	if !fs.config.EncryptionKey.IsEmpty() {
+		if err = fs.config.EncryptionKey.TryDecrypt(); err != nil {
+			return fs, err
+		}
+		fs.encryptionKey, err = base64.StdEncoding.DecodeString(fs.config.EncryptionKey.GetPayload())
+		if err != nil {
+			return fs, fmt.Errorf("unable to decode the GCS encryption key: %w", err)
+		}
+	}
+	return fs, nil
 }
 
 // Name returns the name for the Fs implementation
@@ -118,7 +139,14 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
 	}
-	return fs.getObjectStat(name)
+	if info, ok := metadataCache.get(fs.cacheBackend, name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		metadataCache.add(fs.cacheBackend, name, info)
+	}
+	return info, err
 }
 
 // Lstat returns a FileInfo describing the named file
@@ -142,8 +170,7 @@ func (fs *GCSFs) Open(name string, offset int64) (File, PipeReader, func(), erro
 		}
 		p.setMetadata(attrs.Metadata)
 	}
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	obj := fs.getObject(name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	objectReader, err := obj.NewRangeReader(ctx, offset, -1)
 	if err == nil && offset > 0 && objectReader.Attrs.ContentEncoding == "gzip" {
@@ -184,8 +211,9 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func()
 	var attrs *storage.ObjectAttrs
 	var statErr error
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	metadataCache.invalidate(fs.cacheBackend, name)
+
+	obj := fs.getObject(name)
 
 	if flag == -1 {
 		obj = obj.If(storage.Conditions{DoesNotExist: true})
@@ -212,7 +240,7 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func()
 		}
 		p = newPipeWriterAtOffset(w, attrs.Size)
 		partialFileName = fs.getTempObject(name)
-		partialObj := bkt.Object(partialFileName)
+		partialObj := fs.getObject(partialFileName)
 		partialObj = partialObj.If(storage.Conditions{DoesNotExist: true})
 		objectWriter = partialObj.NewWriter(ctx)
 	} else {
@@ -231,17 +259,32 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, PipeWriter, func()
 	go func() {
 		defer cancelFn()
 
-		n, err := io.Copy(objectWriter, r)
+		var hasher hash.Hash
+		var reader io.Reader = r
+		// resumed uploads are composed with the existing object so we cannot
+		// hash the whole content
+		if flag != -1 && partialFileName == "" {
+			hasher = sha256.New()
+			reader = io.TeeReader(r, hasher)
+		}
+		n, err := io.Copy(objectWriter, reader)
 		closeErr := objectWriter.Close()
 		if err == nil {
 			err = closeErr
 		}
 		if err == nil && partialFileName != "" {
-			partialObject := bkt.Object(partialFileName)
+			partialObject := fs.getObject(partialFileName)
 			partialObject = partialObject.If(storage.Conditions{GenerationMatch: objectWriter.Attrs().Generation})
 			err = fs.composeObjects(ctx, obj, partialObject)
 		}
+		if err == nil && hasher != nil {
+			fs.setContentHash(ctx, name, objectWriter.Attrs().Generation, hex.EncodeToString(hasher.Sum(nil)))
+		}
+		if err == nil {
+			fs.setUploadMetadata(p, objectWriter.Attrs())
+		}
 		r.CloseWithError(err) //nolint:errcheck
+		metadataCache.invalidate(fs.cacheBackend, name)
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
 			name, fs.config.ACL, n, err)
@@ -284,7 +327,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 			name += "/"
 		}
 	}
-	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	obj := fs.getObject(name)
 	attrs, statErr := fs.headObject(name)
 	if statErr == nil {
 		obj = obj.If(storage.Conditions{GenerationMatch: attrs.Generation})
@@ -302,8 +345,9 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
-		err = fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")).Delete(ctx)
+		err = fs.getObject(strings.TrimSuffix(name, "/")).Delete(ctx)
 	}
+	metadataCache.invalidate(fs.cacheBackend, name)
 	metric.GCSDeleteObjectCompleted(err)
 	return err
 }
@@ -342,7 +386,7 @@ func (fs *GCSFs) Chtimes(name string, _, mtime time.Time, isUploading bool) erro
 	if isUploading {
 		return nil
 	}
-	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	obj := fs.getObject(name)
 	attrs, err := fs.headObject(name)
 	if err != nil {
 		return err
@@ -362,6 +406,7 @@ func (fs *GCSFs) Chtimes(name string, _, mtime time.Time, isUploading bool) erro
 		Metadata: metadata,
 	}
 	_, err = obj.Update(ctx, objectAttrsToUpdate)
+	metadataCache.invalidate(fs.cacheBackend, name)
 
 	return err
 }
@@ -386,11 +431,12 @@ func (fs *GCSFs) ReadDir(dirname string) (DirLister, error) {
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 
 	return &gcsDirLister{
-		bucket:   bkt,
-		query:    query,
-		timeout:  fs.ctxTimeout,
-		prefix:   prefix,
-		prefixes: make(map[string]bool),
+		bucket:       bkt,
+		query:        query,
+		timeout:      fs.ctxTimeout,
+		prefix:       prefix,
+		prefixes:     make(map[string]bool),
+		cacheBackend: fs.cacheBackend,
 	}, nil
 }
 
@@ -679,7 +725,11 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 			objectModTime = util.GetTimeFromMsecSinceEpoch(val)
 		}
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
-		return NewFileInfo(name, isDir, objSize, objectModTime, false), nil
+		info := NewFileInfo(name, isDir, objSize, objectModTime, false)
+		if !isDir {
+			info.SetETag(getContentHashETag(attrs.Metadata))
+		}
+		return info, nil
 	}
 	if !fs.IsNotExist(err) {
 		return nil, err
@@ -720,6 +770,48 @@ func (fs *GCSFs) setWriterAttrs(objectWriter *storage.Writer, flag int, name str
 	if fs.config.ACL != "" {
 		objectWriter.PredefinedACL = fs.config.ACL
 	}
+	if fs.config.KMSKeyName != "" {
+		objectWriter.ObjectAttrs.KMSKeyName = fs.config.KMSKeyName
+	}
+}
+
+// setUploadMetadata reports the encryption keys used for the uploaded object,
+// they are included in upload events for audit purposes
+func (*GCSFs) setUploadMetadata(p PipeWriter, attrs *storage.ObjectAttrs) {
+	if attrs == nil {
+		return
+	}
+	setter, ok := p.(interface{ setMetadata(map[string]string) })
+	if !ok {
+		return
+	}
+	metadata := make(map[string]string)
+	if attrs.KMSKeyName != "" {
+		metadata["kms_key_name"] = attrs.KMSKeyName
+	}
+	if attrs.CustomerKeySHA256 != "" {
+		metadata["customer_key_sha256"] = attrs.CustomerKeySHA256
+	}
+	if len(metadata) > 0 {
+		setter.setMetadata(metadata)
+	}
+}
+
+// setContentHash stores the content hash, computed at upload time, as object
+// metadata. Errors are logged and ignored, the upload is already completed
+func (fs *GCSFs) setContentHash(ctx context.Context, name string, generation int64, contentHash string) {
+	innerCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj := fs.getObject(name).If(storage.Conditions{GenerationMatch: generation})
+	_, err := obj.Update(innerCtx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{
+			contentHashField: contentHash,
+		},
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to set content hash for %q: %+v", name, err)
+	}
 }
 
 func (fs *GCSFs) composeObjects(ctx context.Context, dst, partialObject *storage.ObjectHandle) error {
@@ -732,6 +824,9 @@ func (fs *GCSFs) composeObjects(ctx context.Context, dst, partialObject *storage
 	if fs.config.ACL != "" {
 		composer.PredefinedACL = fs.config.ACL
 	}
+	if fs.config.KMSKeyName != "" {
+		composer.KMSKeyName = fs.config.KMSKeyName
+	}
 	contentType := mime.TypeByExtension(path.Ext(dst.ObjectName()))
 	if contentType != "" {
 		composer.ContentType = contentType
@@ -750,8 +845,10 @@ func (fs *GCSFs) composeObjects(ctx context.Context, dst, partialObject *storage
 }
 
 func (fs *GCSFs) copyFileInternal(source, target string, conditions *storage.Conditions) error {
-	src := fs.svc.Bucket(fs.config.Bucket).Object(source)
-	dst := fs.svc.Bucket(fs.config.Bucket).Object(target)
+	defer metadataCache.invalidate(fs.cacheBackend, target)
+
+	src := fs.getObject(source)
+	dst := fs.getObject(target)
 	if conditions != nil {
 		dst = dst.If(*conditions)
 	} else {
@@ -776,6 +873,9 @@ func (fs *GCSFs) copyFileInternal(source, target string, conditions *storage.Con
 	if fs.config.ACL != "" {
 		copier.PredefinedACL = fs.config.ACL
 	}
+	if fs.config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = fs.config.KMSKeyName
+	}
 	contentType := mime.TypeByExtension(path.Ext(source))
 	if contentType != "" {
 		copier.ContentType = contentType
@@ -887,13 +987,22 @@ func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
-	bkt := fs.svc.Bucket(fs.config.Bucket)
-	obj := bkt.Object(name)
+	obj := fs.getObject(name)
 	attrs, err := obj.Attrs(ctx)
 	metric.GCSHeadObjectCompleted(err)
 	return attrs, err
 }
 
+// getObject returns an handle for the named object, the customer supplied
+// encryption key, if any, is set on the handle
+func (fs *GCSFs) getObject(name string) *storage.ObjectHandle {
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(name)
+	if len(fs.encryptionKey) > 0 {
+		obj = obj.Key(fs.encryptionKey)
+	}
+	return obj
+}
+
 // GetMimeType returns the content type
 func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	attrs, err := fs.headObject(name)
@@ -903,6 +1012,96 @@ func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	return attrs.ContentType, nil
 }
 
+// GetXattr implements the FsXattrer interface, extended attributes are
+// stored as object metadata
+func (fs *GCSFs) GetXattr(name, attr string) ([]byte, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrFromMetadata(attrs.Metadata, attr)
+}
+
+// ListXattr implements the FsXattrer interface
+func (fs *GCSFs) ListXattr(name string) ([]string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return listXattrFromMetadata(attrs.Metadata), nil
+}
+
+// SetXattr implements the FsXattrer interface
+func (fs *GCSFs) SetXattr(name, attr string, value []byte) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(attrs.Metadata)+1)
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	setXattrInMetadata(metadata, attr, value)
+	defer metadataCache.invalidate(fs.cacheBackend, name)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj := fs.getObject(name)
+	obj = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
+	_, err = obj.Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: metadata,
+	})
+	return err
+}
+
+// RemoveXattr implements the FsXattrer interface
+func (fs *GCSFs) RemoveXattr(name, attr string) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(attrs.Metadata))
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	if err := removeXattrFromMetadata(metadata, attr); err != nil {
+		return err
+	}
+	defer metadataCache.invalidate(fs.cacheBackend, name)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	obj := fs.getObject(name)
+	if len(metadata) == 0 {
+		// an empty map removes all the metadata
+		obj = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
+		_, err = obj.Update(ctx, storage.ObjectAttrsToUpdate{
+			Metadata: map[string]string{},
+		})
+		return err
+	}
+	// the updates merge the metadata keys, so the object is rewritten
+	// with the new metadata to remove a single key
+	copier := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(obj)
+	copier.ContentType = attrs.ContentType
+	copier.ContentEncoding = attrs.ContentEncoding
+	copier.ContentDisposition = attrs.ContentDisposition
+	copier.CacheControl = attrs.CacheControl
+	copier.StorageClass = attrs.StorageClass
+	if fs.config.ACL != "" {
+		copier.PredefinedACL = fs.config.ACL
+	}
+	if fs.config.KMSKeyName != "" {
+		copier.DestinationKMSKeyName = fs.config.KMSKeyName
+	}
+	copier.Metadata = metadata
+	_, err = copier.Run(ctx)
+	metric.GCSCopyObjectCompleted(err)
+	return err
+}
+
 // Close closes the fs
 func (fs *GCSFs) Close() error {
 	return nil
@@ -929,6 +1128,7 @@ type gcsDirLister struct {
 	prefix        string
 	prefixes      map[string]bool
 	metricUpdated bool
+	cacheBackend  string
 }
 
 func (l *gcsDirLister) resolve(name, contentType string) (string, bool) {
@@ -1002,7 +1202,12 @@ func (l *gcsDirLister) Next(limit int) ([]os.FileInfo, error) {
 			if val := getLastModified(attrs.Metadata); val > 0 {
 				modTime = util.GetTimeFromMsecSinceEpoch(val)
 			}
-			l.cache = append(l.cache, NewFileInfo(name, isDir, attrs.Size, modTime, false))
+			info := NewFileInfo(name, isDir, attrs.Size, modTime, false)
+			if !isDir {
+				info.SetETag(getContentHashETag(attrs.Metadata))
+				metadataCache.addListedFile(l.cacheBackend, l.prefix, name, attrs.Size, modTime, info.ETag())
+			}
+			l.cache = append(l.cache, info)
 		}
 	}
 
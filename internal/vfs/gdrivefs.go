@@ -0,0 +1,1114 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nogdrive
+// +build !nogdrive
+
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	defaultGDriveUploadPartSize = 16
+	gdrivePageSize              = 1000
+	gdriveFolderMimeType        = "application/vnd.google-apps.folder"
+	gdriveGoogleAppsPrefix      = "application/vnd.google-apps."
+	gdriveFileFields            = "id,name,mimeType,size,modifiedTime,md5Checksum"
+	gdriveFolderCacheTTL        = 5 * time.Minute
+	gdriveFolderCacheMaxSize    = 10000
+	gdriveStatVFSBlockSize      = 4096
+	gdriveStatVFSMaxNameSize    = 255
+)
+
+var (
+	gdriveFolderIDs = &gdriveFolderCache{
+		items: make(map[string]gdriveFolderCacheEntry),
+	}
+	errGDriveReadOnly = errors.New("documents, spreadsheets, presentations and drawings cannot be overwritten")
+	// gdriveEndpoint overrides the Google Drive API endpoint if not empty,
+	// it can be changed in test cases
+	gdriveEndpoint = ""
+)
+
+// gdriveExportFormat defines the format used to download a Google Workspace document
+type gdriveExportFormat struct {
+	mimeType  string
+	extension string
+}
+
+// gdriveExportFormats maps the supported Google Workspace types to the
+// export formats for each configurable export option. Types not listed
+// here, for example forms and shortcuts, are not visible
+var gdriveExportFormats = map[string][3]gdriveExportFormat{
+	"application/vnd.google-apps.document": {
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+		{"application/vnd.oasis.opendocument.text", ".odt"},
+		{"application/pdf", ".pdf"},
+	},
+	"application/vnd.google-apps.spreadsheet": {
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+		{"application/x-vnd.oasis.opendocument.spreadsheet", ".ods"},
+		{"application/pdf", ".pdf"},
+	},
+	"application/vnd.google-apps.presentation": {
+		{"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+		{"application/vnd.oasis.opendocument.presentation", ".odp"},
+		{"application/pdf", ".pdf"},
+	},
+	"application/vnd.google-apps.drawing": {
+		{"application/pdf", ".pdf"},
+		{"image/svg+xml", ".svg"},
+		{"application/pdf", ".pdf"},
+	},
+}
+
+// GDriveFs is a Fs implementation for Google Drive and shared drives.
+type GDriveFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath  string
+	config     *GDriveFsConfig
+	svc        *drive.Service
+	rootID     string
+	ctxTimeout time.Duration
+	// identifies the drive and the identity in the folder IDs cache
+	cacheBackend string
+}
+
+func init() {
+	version.AddFeature("+gdrive")
+}
+
+// NewGDriveFs returns a GDriveFs object that allows to interact with Google Drive
+func NewGDriveFs(connectionID, localTempDir, mountPath string, config GDriveFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &GDriveFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	tokenSource, identity, err := fs.getTokenSource()
+	if err != nil {
+		return fs, err
+	}
+	opts := []option.ClientOption{
+		option.WithTokenSource(tokenSource),
+		option.WithUserAgent(version.GetServerVersion("/", false)),
+	}
+	if gdriveEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(gdriveEndpoint))
+	}
+	fs.svc, err = drive.NewService(context.Background(), opts...)
+	if err != nil {
+		return fs, fmt.Errorf("unable to create Google Drive client: %w", err)
+	}
+	switch {
+	case fs.config.RootFolderID != "":
+		fs.rootID = fs.config.RootFolderID
+	case fs.config.DriveID != "":
+		// the ID of a shared drive is also the ID of its root folder
+		fs.rootID = fs.config.DriveID
+	default:
+		fs.rootID = "root"
+	}
+	h := sha256.Sum256([]byte(fs.config.DriveID + "\x00" + fs.rootID + "\x00" + identity))
+	fs.cacheBackend = hex.EncodeToString(h[:])
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *GDriveFs) Name() string {
+	if fs.config.DriveID != "" {
+		return fmt.Sprintf("%s shared drive %q root %q", gdriveFsName, fs.config.DriveID, fs.rootID)
+	}
+	return fmt.Sprintf("%s root %q", gdriveFsName, fs.rootID)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *GDriveFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *GDriveFs) Stat(name string) (os.FileInfo, error) {
+	if fs.isRoot(name) {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	f, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.getFileInfo(f), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *GDriveFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *GDriveFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	f, err := fs.resolve(ctx, name)
+	cancelFn()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	export, isGoogleDoc := fs.getExportFormat(f.MimeType)
+	if isGoogleDoc && offset > 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume the download of the exported file %q",
+			ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	ctx, cancelFn = context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		var resp *http.Response
+		var err error
+		if isGoogleDoc {
+			resp, err = fs.svc.Files.Export(f.Id, export.mimeType).Context(ctx).Download()
+		} else {
+			call := fs.svc.Files.Get(f.Id).SupportsAllDrives(true).Context(ctx)
+			if offset > 0 {
+				call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			}
+			resp, err = call.Download()
+		}
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *GDriveFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	parentID, err := fs.resolveFolderID(ctx, path.Dir(name))
+	if err != nil {
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	existing, err := fs.findChild(ctx, parentID, path.Base(name))
+	cancelFn()
+	if err != nil && !fs.IsNotExist(err) {
+		return nil, nil, nil, err
+	}
+	if existing != nil {
+		if existing.MimeType == gdriveFolderMimeType {
+			return nil, nil, nil, fmt.Errorf("cannot overwrite directory %q", name)
+		}
+		if strings.HasPrefix(existing.MimeType, gdriveGoogleAppsPrefix) {
+			return nil, nil, nil, fmt.Errorf("%w: %w", os.ErrPermission, errGDriveReadOnly)
+		}
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn = context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		reader := &gdriveCountingReader{r: r}
+		media := []googleapi.MediaOption{
+			googleapi.ChunkSize(fs.getUploadPartSize()),
+			googleapi.ContentType(fs.getContentType(name)),
+		}
+		var err error
+		if existing != nil {
+			// updating the content preserves the file ID, sharing and revisions
+			_, err = fs.svc.Files.Update(existing.Id, &drive.File{}).Media(reader, media...).
+				SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+		} else {
+			_, err = fs.svc.Files.Create(&drive.File{
+				Name:    path.Base(name),
+				Parents: []string{parentID},
+			}).Media(reader, media...).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %+v", name, reader.n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are moved server side including their contents
+func (fs *GDriveFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	src, srcParentID, err := fs.resolveWithParent(ctx, source)
+	if err != nil {
+		return -1, -1, err
+	}
+	dstParentID, err := fs.removeExistingTarget(ctx, target, src.Id)
+	if err != nil {
+		return -1, -1, err
+	}
+	call := fs.svc.Files.Update(src.Id, &drive.File{
+		Name: fs.getDriveName(src, path.Base(target)),
+	}).SupportsAllDrives(true).Fields("id").Context(ctx)
+	if srcParentID != dstParentID {
+		call = call.AddParents(dstParentID).RemoveParents(srcParentID)
+	}
+	_, err = call.Do()
+	gdriveFolderIDs.invalidate(fs.cacheBackend, source)
+	return -1, -1, err
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *GDriveFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	f, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		// removing a folder on Google Drive removes all its contents
+		result, err := fs.listCall(f.Id, "").PageSize(1).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		if len(result.Files) > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	err = fs.deleteFile(ctx, f.Id)
+	if isDir {
+		gdriveFolderIDs.invalidate(fs.cacheBackend, name)
+	}
+	return err
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *GDriveFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	parentID, err := fs.resolveFolderID(ctx, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	// Google Drive allows multiple files with the same name in a folder
+	_, err = fs.findChild(ctx, parentID, path.Base(name))
+	if err == nil {
+		return fmt.Errorf("%w: %q", os.ErrExist, name)
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	f, err := fs.svc.Files.Create(&drive.File{
+		Name:     path.Base(name),
+		MimeType: gdriveFolderMimeType,
+		Parents:  []string{parentID},
+	}).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	gdriveFolderIDs.add(fs.cacheBackend, name, f.Id)
+	return nil
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*GDriveFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*GDriveFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*GDriveFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*GDriveFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// Only the modification time is stored, for files being uploaded it will
+// be set after the upload completes
+func (fs *GDriveFs) Chtimes(name string, _, mtime time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	f, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, err = fs.svc.Files.Update(f.Id, &drive.File{
+		ModifiedTime: mtime.UTC().Format(time.RFC3339Nano),
+	}).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+	return err
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*GDriveFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *GDriveFs) ReadDir(dirname string) (DirLister, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	folderID, err := fs.resolveFolderID(ctx, dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &gdriveDirLister{
+		fs:       fs,
+		dirname:  path.Clean("/" + dirname),
+		folderID: folderID,
+		hasMore:  true,
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on Google Drive
+func (*GDriveFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*GDriveFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Google Drive uploads are already atomic, the file is created or updated
+// only after the upload completes
+func (*GDriveFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*GDriveFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusNotFound
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*GDriveFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized:
+			return true
+		case http.StatusForbidden:
+			// rate limit errors are also returned using 403
+			for _, item := range apiErr.Errors {
+				if strings.Contains(item.Reason, "RateLimitExceeded") || strings.Contains(item.Reason, "rateLimitExceeded") {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*GDriveFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrVfsUnsupported)
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *GDriveFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *GDriveFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize("/")
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders. Google Workspace documents have no size
+func (fs *GDriveFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	folderID, err := fs.resolveFolderID(ctx, dirname)
+	cancelFn()
+	if err != nil {
+		return numFiles, size, err
+	}
+	folders := []string{folderID}
+	for len(folders) > 0 {
+		folderID, folders = folders[0], folders[1:]
+		pageToken := ""
+		for {
+			ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+			result, err := fs.listCall(folderID, pageToken).Context(ctx).Do()
+			cancelFn()
+			if err != nil {
+				return numFiles, size, err
+			}
+			for _, f := range result.Files {
+				if f.MimeType == gdriveFolderMimeType {
+					folders = append(folders, f.Id)
+					continue
+				}
+				if _, ok := fs.getVisibleName(f); ok {
+					numFiles++
+					size += f.Size
+				}
+			}
+			if result.NextPageToken == "" {
+				break
+			}
+			pageToken = result.NextPageToken
+		}
+		fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+	}
+	return numFiles, size, nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// Google Drive uploads are already atomic, we never call this method
+func (*GDriveFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *GDriveFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *GDriveFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*GDriveFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*GDriveFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *GDriveFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	return path.Clean("/" + virtualPath), nil
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *GDriveFs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	src, err := fs.resolve(ctx, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	numFiles := 1
+	sizeDiff := srcSize
+	if info, err := fs.Stat(target); err == nil {
+		sizeDiff -= info.Size()
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	dstParentID, err := fs.removeExistingTarget(ctx, target, src.Id)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = fs.svc.Files.Copy(src.Id, &drive.File{
+		Name:    fs.getDriveName(src, path.Base(target)),
+		Parents: []string{dstParentID},
+	}).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *GDriveFs) GetMimeType(name string) (string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	f, err := fs.resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if export, ok := fs.getExportFormat(f.MimeType); ok {
+		return export.mimeType, nil
+	}
+	return f.MimeType, nil
+}
+
+// Close closes the fs
+func (*GDriveFs) Close() error {
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *GDriveFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	if fs.config.DriveID != "" {
+		// shared drives use the organization storage
+		return nil, ErrStorageSizeUnavailable
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	about, err := fs.svc.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if about.StorageQuota == nil || about.StorageQuota.Limit <= 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	limit := uint64(about.StorageQuota.Limit)
+	free := uint64(0)
+	if about.StorageQuota.Limit > about.StorageQuota.Usage {
+		free = uint64(about.StorageQuota.Limit - about.StorageQuota.Usage)
+	}
+	return &sftp.StatVFS{
+		Bsize:   gdriveStatVFSBlockSize,
+		Frsize:  gdriveStatVFSBlockSize,
+		Blocks:  limit / gdriveStatVFSBlockSize,
+		Bfree:   free / gdriveStatVFSBlockSize,
+		Bavail:  free / gdriveStatVFSBlockSize,
+		Namemax: gdriveStatVFSMaxNameSize,
+	}, nil
+}
+
+// getTokenSource returns the OAuth2 token source for the configured
+// authentication method and a string that identifies the Drive user
+func (fs *GDriveFs) getTokenSource() (oauth2.TokenSource, string, error) {
+	ctx := context.Background()
+
+	if fs.config.AuthMethod == GDriveAuthOAuth2 {
+		if err := fs.config.ClientSecret.TryDecrypt(); err != nil {
+			return nil, "", err
+		}
+		if err := fs.config.RefreshToken.TryDecrypt(); err != nil {
+			return nil, "", err
+		}
+		oauthConfig := &oauth2.Config{
+			ClientID:     fs.config.ClientID,
+			ClientSecret: fs.config.ClientSecret.GetPayload(),
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{drive.DriveScope},
+		}
+		token := &oauth2.Token{RefreshToken: fs.config.RefreshToken.GetPayload()}
+		return oauthConfig.TokenSource(ctx, token), fs.config.ClientID + "\x00" + token.RefreshToken, nil
+	}
+	if err := fs.config.Credentials.TryDecrypt(); err != nil {
+		return nil, "", err
+	}
+	jwtConfig, err := google.JWTConfigFromJSON([]byte(fs.config.Credentials.GetPayload()), drive.DriveScope)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid Google Drive service account credentials: %w", err)
+	}
+	jwtConfig.Subject = fs.config.Subject
+	return jwtConfig.TokenSource(ctx), jwtConfig.Email + "\x00" + jwtConfig.Subject, nil
+}
+
+func (*GDriveFs) isRoot(name string) bool {
+	return name == "" || name == "/" || name == "."
+}
+
+func (fs *GDriveFs) getUploadPartSize() int {
+	if fs.config.UploadPartSize > 0 {
+		return int(fs.config.UploadPartSize) * 1024 * 1024
+	}
+	return defaultGDriveUploadPartSize * 1024 * 1024
+}
+
+func (*GDriveFs) getContentType(name string) string {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		// avoid content sniffing, it requires buffering the upload
+		contentType = "application/octet-stream"
+	}
+	return contentType
+}
+
+// getExportFormat returns the export format for the specified mime type and
+// true if the mime type is a supported Google Workspace document
+func (fs *GDriveFs) getExportFormat(mimeType string) (gdriveExportFormat, bool) {
+	formats, ok := gdriveExportFormats[mimeType]
+	if !ok {
+		return gdriveExportFormat{}, false
+	}
+	return formats[fs.config.ExportFormat], true
+}
+
+// getVisibleName returns the name to show for the specified file and false
+// if the file must be hidden. Exported documents have the export extension
+// appended to their name
+func (fs *GDriveFs) getVisibleName(f *drive.File) (string, bool) {
+	if f.MimeType == gdriveFolderMimeType {
+		return f.Name, true
+	}
+	if !strings.HasPrefix(f.MimeType, gdriveGoogleAppsPrefix) {
+		return f.Name, true
+	}
+	export, ok := fs.getExportFormat(f.MimeType)
+	if !ok {
+		return "", false
+	}
+	return f.Name + export.extension, true
+}
+
+// getDriveName returns the name to set on Google Drive when a file is renamed
+// or copied to the specified name. For Google Workspace documents the export
+// extension is removed
+func (fs *GDriveFs) getDriveName(f *drive.File, name string) string {
+	if export, ok := fs.getExportFormat(f.MimeType); ok {
+		return strings.TrimSuffix(name, export.extension)
+	}
+	return name
+}
+
+func (fs *GDriveFs) getFileInfo(f *drive.File) *FileInfo {
+	name, _ := fs.getVisibleName(f)
+	modTime := time.Unix(0, 0)
+	if f.ModifiedTime != "" {
+		if t, err := time.Parse(time.RFC3339Nano, f.ModifiedTime); err == nil {
+			modTime = t
+		}
+	}
+	if f.MimeType == gdriveFolderMimeType {
+		return NewFileInfo(name, true, 0, modTime, false)
+	}
+	info := NewFileInfo(name, false, f.Size, modTime, false)
+	if f.Md5Checksum != "" {
+		info.SetETag(`"` + f.Md5Checksum + `"`)
+	}
+	return info
+}
+
+func (fs *GDriveFs) listCall(folderID, pageToken string) *drive.FilesListCall {
+	call := fs.svc.Files.List().
+		Q(fmt.Sprintf("'%s' in parents and trashed = false", escapeGDriveQuery(folderID))).
+		Fields(googleapi.Field("nextPageToken,files(" + gdriveFileFields + ")")).
+		PageSize(gdrivePageSize).
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true)
+	if fs.config.DriveID != "" {
+		call = call.Corpora("drive").DriveId(fs.config.DriveID)
+	}
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call
+}
+
+// findChild returns the file with the specified name inside the given folder.
+// Names with an export extension also match the related Google Workspace
+// documents
+func (fs *GDriveFs) findChild(ctx context.Context, parentID, name string) (*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false",
+		escapeGDriveQuery(parentID), escapeGDriveQuery(name))
+	ext := path.Ext(name)
+	if ext != "" && ext != name {
+		q = fmt.Sprintf("'%s' in parents and (name = '%s' or name = '%s') and trashed = false",
+			escapeGDriveQuery(parentID), escapeGDriveQuery(name), escapeGDriveQuery(strings.TrimSuffix(name, ext)))
+	}
+	call := fs.svc.Files.List().
+		Q(q).
+		Fields(googleapi.Field("files(" + gdriveFileFields + ")")).
+		PageSize(gdrivePageSize).
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true)
+	if fs.config.DriveID != "" {
+		call = call.Corpora("drive").DriveId(fs.config.DriveID)
+	}
+	result, err := call.Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	var found *drive.File
+	for _, f := range result.Files {
+		if visibleName, ok := fs.getVisibleName(f); !ok || visibleName != name {
+			continue
+		}
+		if found != nil {
+			fsLog(fs, logger.LevelWarn, "multiple files named %q found in folder %q, using %q", name, parentID, found.Id)
+			break
+		}
+		found = f
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %q", os.ErrNotExist, name)
+	}
+	return found, nil
+}
+
+// resolveFolderID returns the ID for the folder with the specified path.
+// The path components are resolved starting from the nearest cached ancestor
+func (fs *GDriveFs) resolveFolderID(ctx context.Context, name string) (string, error) {
+	name = path.Clean("/" + name)
+	if fs.isRoot(name) {
+		return fs.rootID, nil
+	}
+	if id, ok := gdriveFolderIDs.get(fs.cacheBackend, name); ok {
+		return id, nil
+	}
+	parentID, err := fs.resolveFolderID(ctx, path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	f, err := fs.findChild(ctx, parentID, path.Base(name))
+	if err != nil {
+		return "", err
+	}
+	if f.MimeType != gdriveFolderMimeType {
+		return "", fmt.Errorf("%q is not a directory: %w", name, os.ErrNotExist)
+	}
+	gdriveFolderIDs.add(fs.cacheBackend, name, f.Id)
+	return f.Id, nil
+}
+
+// resolveWithParent returns the file with the specified path and the ID of
+// its parent folder
+func (fs *GDriveFs) resolveWithParent(ctx context.Context, name string) (*drive.File, string, error) {
+	name = path.Clean("/" + name)
+	if fs.isRoot(name) {
+		return nil, "", fmt.Errorf("%w: the root folder cannot be modified", os.ErrPermission)
+	}
+	parentID, err := fs.resolveFolderID(ctx, path.Dir(name))
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := fs.findChild(ctx, parentID, path.Base(name))
+	if err != nil {
+		if parentID != fs.rootID && fs.IsNotExist(err) {
+			// the cached parent ID could be stale
+			gdriveFolderIDs.invalidate(fs.cacheBackend, path.Dir(name))
+		}
+		return nil, "", err
+	}
+	return f, parentID, nil
+}
+
+func (fs *GDriveFs) resolve(ctx context.Context, name string) (*drive.File, error) {
+	f, _, err := fs.resolveWithParent(ctx, name)
+	return f, err
+}
+
+// removeExistingTarget removes the file with the specified path, if any, and
+// returns the ID of its parent folder. Google Drive never overwrites files
+// while renaming or copying, it creates a new file with the same name instead
+func (fs *GDriveFs) removeExistingTarget(ctx context.Context, target, sourceID string) (string, error) {
+	parentID, err := fs.resolveFolderID(ctx, path.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	existing, err := fs.findChild(ctx, parentID, path.Base(target))
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return parentID, nil
+		}
+		return "", err
+	}
+	if existing.Id == sourceID {
+		return parentID, nil
+	}
+	if existing.MimeType == gdriveFolderMimeType {
+		return "", fmt.Errorf("cannot overwrite directory %q", target)
+	}
+	fsLog(fs, logger.LevelDebug, "target %q exists, remove it", target)
+	return parentID, fs.deleteFile(ctx, existing.Id)
+}
+
+func (fs *GDriveFs) deleteFile(ctx context.Context, id string) error {
+	if fs.config.UseTrash {
+		_, err := fs.svc.Files.Update(id, &drive.File{Trashed: true}).SupportsAllDrives(true).
+			Fields("id").Context(ctx).Do()
+		return err
+	}
+	return fs.svc.Files.Delete(id).SupportsAllDrives(true).Context(ctx).Do()
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *GDriveFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// escapeGDriveQuery escapes a value to use inside a single quoted string in a
+// search query
+func escapeGDriveQuery(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	return strings.ReplaceAll(val, `'`, `\'`)
+}
+
+type gdriveCountingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *gdriveCountingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type gdriveFolderCacheEntry struct {
+	id         string
+	expiration time.Time
+}
+
+// gdriveFolderCache maps folder paths to Google Drive IDs, resolving a path
+// requires a request for each component. The cache is shared by all the
+// connections, entries are invalidated on renames and removals done through
+// SFTPGo or when their TTL expires
+type gdriveFolderCache struct {
+	sync.RWMutex
+	items map[string]gdriveFolderCacheEntry
+}
+
+func (c *gdriveFolderCache) getKey(backend, name string) string {
+	return backend + "\x00" + name
+}
+
+func (c *gdriveFolderCache) get(backend, name string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.items[c.getKey(backend, name)]
+	if !ok || time.Now().After(entry.expiration) {
+		return "", false
+	}
+	return entry.id, true
+}
+
+func (c *gdriveFolderCache) add(backend, name, id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if len(c.items) >= gdriveFolderCacheMaxSize {
+		for k, v := range c.items {
+			if now.After(v.expiration) {
+				delete(c.items, k)
+			}
+		}
+		if len(c.items) >= gdriveFolderCacheMaxSize {
+			c.items = make(map[string]gdriveFolderCacheEntry)
+		}
+	}
+	c.items[c.getKey(backend, name)] = gdriveFolderCacheEntry{
+		id:         id,
+		expiration: now.Add(gdriveFolderCacheTTL),
+	}
+}
+
+// invalidate removes the specified path and all its descendants
+func (c *gdriveFolderCache) invalidate(backend, name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.getKey(backend, name)
+	delete(c.items, key)
+	prefix := key + "/"
+	for k := range c.items {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.items, k)
+		}
+	}
+}
+
+type gdriveDirLister struct {
+	baseDirLister
+	fs        *GDriveFs
+	dirname   string
+	folderID  string
+	pageToken string
+	hasMore   bool
+}
+
+func (l *gdriveDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && l.hasMore {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.fs.ctxTimeout))
+		result, err := l.fs.listCall(l.folderID, l.pageToken).Context(ctx).Do()
+		cancelFn()
+		if err != nil {
+			return l.cache, err
+		}
+		for _, f := range result.Files {
+			if _, ok := l.fs.getVisibleName(f); !ok {
+				continue
+			}
+			if f.MimeType == gdriveFolderMimeType {
+				gdriveFolderIDs.add(l.fs.cacheBackend, path.Join(l.dirname, f.Name), f.Id)
+			}
+			l.cache = append(l.cache, l.fs.getFileInfo(f))
+		}
+		l.pageToken = result.NextPageToken
+		l.hasMore = result.NextPageToken != ""
+	}
+	if len(l.cache) >= limit || l.hasMore {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
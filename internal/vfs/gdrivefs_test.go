@@ -0,0 +1,1089 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nogdrive
+// +build !nogdrive
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeGDriveAccessToken = "accessToken"
+	fakeGDriveRootID      = "root"
+	fakeGDriveQuotaLimit  = 10 * 1024 * 1024
+)
+
+var fakeGDriveQueryValueRegex = regexp.MustCompile(`'((?:\\.|[^'\\])*)'`)
+
+type fakeGDriveFile struct {
+	id       string
+	name     string
+	mimeType string
+	parents  []string
+	data     []byte
+	modTime  time.Time
+	trashed  bool
+}
+
+func (f *fakeGDriveFile) toDriveFile() *drive.File {
+	result := &drive.File{
+		Id:           f.id,
+		Name:         f.name,
+		MimeType:     f.mimeType,
+		Parents:      f.parents,
+		Trashed:      f.trashed,
+		ModifiedTime: f.modTime.Format(time.RFC3339Nano),
+	}
+	if f.mimeType != gdriveFolderMimeType && !strings.HasPrefix(f.mimeType, gdriveGoogleAppsPrefix) {
+		sum := md5.Sum(f.data)
+		result.Size = int64(len(f.data))
+		result.Md5Checksum = hex.EncodeToString(sum[:])
+	}
+	return result
+}
+
+type fakeGDriveUpload struct {
+	// empty for new files
+	fileID      string
+	metadata    drive.File
+	contentType string
+	data        []byte
+}
+
+// fakeGDriveServer is an in memory implementation of the Google Drive API
+// subset used by GDriveFs, including the token endpoint used by service
+// accounts
+type fakeGDriveServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	files       map[string]*fakeGDriveFile
+	uploads     map[string]*fakeGDriveUpload
+	idx         int
+	ops         map[string]int
+	clientEmail string
+	subject     string
+	// if not empty list requests must be restricted to this shared drive
+	driveID string
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeGDriveServer(t *testing.T) *fakeGDriveServer {
+	s := &fakeGDriveServer{
+		files:       make(map[string]*fakeGDriveFile),
+		uploads:     make(map[string]*fakeGDriveUpload),
+		ops:         make(map[string]int),
+		clientEmail: strings.ToLower(t.Name()) + "@sftpgo.iam.gserviceaccount.com",
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeGDriveServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeGDriveServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeGDriveServer) getSubject() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.subject
+}
+
+func (s *fakeGDriveServer) getFile(id string) (fakeGDriveFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return fakeGDriveFile{}, false
+	}
+	return *f, true
+}
+
+func (s *fakeGDriveServer) getFilesCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.files)
+}
+
+// findFile returns the non trashed file with the specified name inside the
+// given folder
+func (s *fakeGDriveServer) findFile(parentID, name string) (fakeGDriveFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.files {
+		if f.name == name && !f.trashed && slices.Contains(f.parents, parentID) {
+			return *f, true
+		}
+	}
+	return fakeGDriveFile{}, false
+}
+
+func (s *fakeGDriveServer) putFile(parentID, name, mimeType string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addFileLocked(parentID, name, mimeType, data).id
+}
+
+func (s *fakeGDriveServer) addFileLocked(parentID, name, mimeType string, data []byte) *fakeGDriveFile {
+	s.idx++
+	f := &fakeGDriveFile{
+		id:       fmt.Sprintf("id%d", s.idx),
+		name:     name,
+		mimeType: mimeType,
+		parents:  []string{parentID},
+		data:     data,
+		modTime:  time.Now().UTC().Truncate(time.Millisecond),
+	}
+	s.files[f.id] = f
+	return f
+}
+
+func (s *fakeGDriveServer) isValidParentLocked(parents []string) bool {
+	if len(parents) != 1 {
+		return false
+	}
+	if parents[0] == fakeGDriveRootID || (s.driveID != "" && parents[0] == s.driveID) {
+		return true
+	}
+	f, ok := s.files[parents[0]]
+	return ok && f.mimeType == gdriveFolderMimeType && !f.trashed
+}
+
+func (s *fakeGDriveServer) deleteLocked(id string) {
+	delete(s.files, id)
+	for _, f := range s.files {
+		if slices.Contains(f.parents, id) {
+			s.deleteLocked(f.id)
+		}
+	}
+}
+
+func (*fakeGDriveServer) getOp(r *http.Request) string {
+	p := r.URL.Path
+	switch {
+	case p == "/token":
+		return "token"
+	case p == "/drive/v3/about":
+		return "about.get"
+	case p == "/drive/v3/files":
+		if r.Method == http.MethodPost {
+			return "files.create"
+		}
+		if strings.Contains(r.URL.Query().Get("q"), " name = ") {
+			return "files.find"
+		}
+		return "files.list"
+	case strings.HasPrefix(p, "/drive/v3/files/"):
+		switch {
+		case strings.HasSuffix(p, "/export"):
+			return "files.export"
+		case strings.HasSuffix(p, "/copy"):
+			return "files.copy"
+		case r.Method == http.MethodPatch:
+			return "files.update"
+		case r.Method == http.MethodDelete:
+			return "files.delete"
+		case r.URL.Query().Get("alt") == "media":
+			return "files.download"
+		}
+		return "files.get"
+	case strings.HasPrefix(p, "/upload/drive/v3/files"):
+		return "upload." + r.URL.Query().Get("uploadType")
+	case strings.HasPrefix(p, "/upload/session/"):
+		return "upload.chunk"
+	}
+	return "unknown"
+}
+
+func (s *fakeGDriveServer) handle(w http.ResponseWriter, r *http.Request) {
+	op := s.getOp(r)
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			writeFakeGDriveError(w, status, "injectedError")
+			return
+		}
+	}
+	if op == "token" {
+		s.handleToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+fakeGDriveAccessToken {
+		writeFakeGDriveError(w, http.StatusUnauthorized, "authError")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeGDriveError(w, http.StatusBadRequest, "badRequest")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if op == "files.list" || op == "files.find" {
+		s.handleList(w, r)
+		return
+	}
+	if op == "about.get" {
+		var usage int64
+		for _, f := range s.files {
+			usage += int64(len(f.data))
+		}
+		writeFakeGDriveJSON(w, &drive.About{
+			StorageQuota: &drive.AboutStorageQuota{
+				Limit: fakeGDriveQuotaLimit,
+				Usage: usage,
+			},
+		})
+		return
+	}
+	if strings.HasPrefix(op, "upload.") {
+		s.handleUpload(w, r, op, body)
+		return
+	}
+	var metadata drive.File
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &metadata); err != nil {
+			writeFakeGDriveError(w, http.StatusBadRequest, "parseError")
+			return
+		}
+	}
+	if op == "files.create" {
+		if !s.isValidParentLocked(metadata.Parents) {
+			writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		writeFakeGDriveJSON(w, s.addFileLocked(metadata.Parents[0], metadata.Name, metadata.MimeType, nil).toDriveFile())
+		return
+	}
+	id := strings.Split(strings.TrimPrefix(r.URL.Path, "/drive/v3/files/"), "/")[0]
+	f, ok := s.files[id]
+	if !ok {
+		writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	switch op {
+	case "files.get":
+		writeFakeGDriveJSON(w, f.toDriveFile())
+	case "files.update":
+		s.handleUpdate(w, r, f, &metadata)
+	case "files.delete":
+		s.deleteLocked(id)
+		w.WriteHeader(http.StatusNoContent)
+	case "files.copy":
+		if !s.isValidParentLocked(metadata.Parents) {
+			writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		writeFakeGDriveJSON(w, s.addFileLocked(metadata.Parents[0], metadata.Name, f.mimeType, f.data).toDriveFile())
+	case "files.download":
+		s.handleDownload(w, r, f)
+	case "files.export":
+		formats, ok := gdriveExportFormats[f.mimeType]
+		exportMimeType := r.URL.Query().Get("mimeType")
+		if !ok || !slices.ContainsFunc(formats[:], func(format gdriveExportFormat) bool {
+			return format.mimeType == exportMimeType
+		}) {
+			writeFakeGDriveError(w, http.StatusBadRequest, "badRequest")
+			return
+		}
+		w.Header().Set("Content-Type", exportMimeType)
+		w.Write(append([]byte(exportMimeType+":"), f.data...)) //nolint:errcheck
+	default:
+		writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+	}
+}
+
+func (s *fakeGDriveServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		writeFakeGDriveJSONStatus(w, http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"})
+		return
+	}
+	// the signature is not verified, we only check the claims
+	var claims struct {
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+	}
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if len(parts) == 3 {
+		if data, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			json.Unmarshal(data, &claims) //nolint:errcheck
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if claims.Iss != s.clientEmail {
+		writeFakeGDriveJSONStatus(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+		return
+	}
+	s.subject = claims.Sub
+	writeFakeGDriveJSON(w, map[string]any{
+		"access_token": fakeGDriveAccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (s *fakeGDriveServer) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if s.driveID != "" && (query.Get("corpora") != "drive" || query.Get("driveId") != s.driveID) {
+		writeFakeGDriveError(w, http.StatusBadRequest, "invalidCorpora")
+		return
+	}
+	var values []string
+	for _, match := range fakeGDriveQueryValueRegex.FindAllStringSubmatch(query.Get("q"), -1) {
+		val := strings.ReplaceAll(match[1], `\'`, `'`)
+		values = append(values, strings.ReplaceAll(val, `\\`, `\`))
+	}
+	if len(values) == 0 || !strings.HasSuffix(query.Get("q"), "trashed = false") {
+		writeFakeGDriveError(w, http.StatusBadRequest, "invalidQuery")
+		return
+	}
+	var files []*fakeGDriveFile
+	for _, f := range s.files {
+		if f.trashed || !slices.Contains(f.parents, values[0]) {
+			continue
+		}
+		if len(values) > 1 && !slices.Contains(values[1:], f.name) {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].name == files[j].name {
+			return files[i].id < files[j].id
+		}
+		return files[i].name < files[j].name
+	})
+	pageSize := 100
+	if val, err := strconv.Atoi(query.Get("pageSize")); err == nil && val > 0 {
+		pageSize = val
+	}
+	offset := 0
+	if token := query.Get("pageToken"); token != "" {
+		val, err := strconv.Atoi(token)
+		if err != nil || val > len(files) {
+			writeFakeGDriveError(w, http.StatusBadRequest, "invalidPageToken")
+			return
+		}
+		offset = val
+	}
+	result := &drive.FileList{
+		Files: []*drive.File{},
+	}
+	for _, f := range files[offset:min(offset+pageSize, len(files))] {
+		result.Files = append(result.Files, f.toDriveFile())
+	}
+	if offset+pageSize < len(files) {
+		result.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+	writeFakeGDriveJSON(w, result)
+}
+
+func (s *fakeGDriveServer) handleUpdate(w http.ResponseWriter, r *http.Request, f *fakeGDriveFile, metadata *drive.File) {
+	query := r.URL.Query()
+	if addParents := query.Get("addParents"); addParents != "" {
+		if !s.isValidParentLocked([]string{addParents}) || !slices.Contains(f.parents, query.Get("removeParents")) {
+			writeFakeGDriveError(w, http.StatusBadRequest, "invalidParent")
+			return
+		}
+		f.parents = []string{addParents}
+	}
+	if metadata.Name != "" {
+		f.name = metadata.Name
+	}
+	if metadata.Trashed {
+		f.trashed = true
+	}
+	if metadata.ModifiedTime != "" {
+		modTime, err := time.Parse(time.RFC3339Nano, metadata.ModifiedTime)
+		if err != nil {
+			writeFakeGDriveError(w, http.StatusBadRequest, "invalidModifiedTime")
+			return
+		}
+		f.modTime = modTime
+	}
+	writeFakeGDriveJSON(w, f.toDriveFile())
+}
+
+func (*fakeGDriveServer) handleDownload(w http.ResponseWriter, r *http.Request, f *fakeGDriveFile) {
+	if f.mimeType == gdriveFolderMimeType || strings.HasPrefix(f.mimeType, gdriveGoogleAppsPrefix) {
+		writeFakeGDriveError(w, http.StatusForbidden, "fileNotDownloadable")
+		return
+	}
+	data := f.data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeGDriveError(w, http.StatusRequestedRangeNotSatisfiable, "requestedRangeNotSatisfiable")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", f.mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func (s *fakeGDriveServer) handleUpload(w http.ResponseWriter, r *http.Request, op string, body []byte) {
+	if op == "upload.chunk" {
+		s.handleUploadChunk(w, r, body)
+		return
+	}
+	upload := &fakeGDriveUpload{}
+	if r.Method == http.MethodPatch {
+		upload.fileID = strings.TrimPrefix(r.URL.Path, "/upload/drive/v3/files/")
+		if _, ok := s.files[upload.fileID]; !ok {
+			writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+			return
+		}
+	}
+	switch op {
+	case "upload.multipart":
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/related" {
+			writeFakeGDriveError(w, http.StatusBadRequest, "badContent")
+			return
+		}
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for i := 0; i < 2; i++ {
+			part, err := reader.NextPart()
+			if err != nil {
+				writeFakeGDriveError(w, http.StatusBadRequest, "badContent")
+				return
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				writeFakeGDriveError(w, http.StatusBadRequest, "badContent")
+				return
+			}
+			if i == 0 {
+				err = json.Unmarshal(data, &upload.metadata)
+				if err != nil {
+					writeFakeGDriveError(w, http.StatusBadRequest, "parseError")
+					return
+				}
+				continue
+			}
+			upload.contentType = part.Header.Get("Content-Type")
+			upload.data = data
+		}
+		s.commitUploadLocked(w, upload)
+	case "upload.resumable":
+		if err := json.Unmarshal(body, &upload.metadata); err != nil {
+			writeFakeGDriveError(w, http.StatusBadRequest, "parseError")
+			return
+		}
+		upload.contentType = r.Header.Get("X-Upload-Content-Type")
+		s.idx++
+		id := fmt.Sprintf("upload%d", s.idx)
+		s.uploads[id] = upload
+		w.Header().Set("Location", s.URL+"/upload/session/"+id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeFakeGDriveError(w, http.StatusBadRequest, "invalidUploadType")
+	}
+}
+
+func (s *fakeGDriveServer) handleUploadChunk(w http.ResponseWriter, r *http.Request, body []byte) {
+	id := strings.TrimPrefix(r.URL.Path, "/upload/session/")
+	upload, ok := s.uploads[id]
+	if !ok {
+		writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	// bytes start-end/total, bytes start-end/* or bytes */total
+	contentRange, ok := strings.CutPrefix(r.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		writeFakeGDriveError(w, http.StatusBadRequest, "badContentRange")
+		return
+	}
+	byteRange, total, _ := strings.Cut(contentRange, "/")
+	if byteRange != "*" {
+		start, _, _ := strings.Cut(byteRange, "-")
+		if start != strconv.Itoa(len(upload.data)) {
+			writeFakeGDriveError(w, http.StatusBadRequest, "badContentRange")
+			return
+		}
+	}
+	upload.data = append(upload.data, body...)
+	if total == "*" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(upload.data)-1))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	delete(s.uploads, id)
+	if total != strconv.Itoa(len(upload.data)) {
+		writeFakeGDriveError(w, http.StatusBadRequest, "badContentRange")
+		return
+	}
+	s.commitUploadLocked(w, upload)
+}
+
+func (s *fakeGDriveServer) commitUploadLocked(w http.ResponseWriter, upload *fakeGDriveUpload) {
+	if upload.fileID != "" {
+		f, ok := s.files[upload.fileID]
+		if !ok {
+			writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		f.data = upload.data
+		f.modTime = time.Now().UTC().Truncate(time.Millisecond)
+		writeFakeGDriveJSON(w, f.toDriveFile())
+		return
+	}
+	if !s.isValidParentLocked(upload.metadata.Parents) {
+		writeFakeGDriveError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	f := s.addFileLocked(upload.metadata.Parents[0], upload.metadata.Name, upload.contentType, upload.data)
+	writeFakeGDriveJSON(w, f.toDriveFile())
+}
+
+func writeFakeGDriveJSON(w http.ResponseWriter, v any) {
+	writeFakeGDriveJSONStatus(w, http.StatusOK, v)
+}
+
+func writeFakeGDriveJSONStatus(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func writeFakeGDriveError(w http.ResponseWriter, status int, reason string) {
+	writeFakeGDriveJSONStatus(w, status, map[string]any{
+		"error": map[string]any{
+			"code":    status,
+			"message": reason,
+			"errors": []map[string]any{
+				{
+					"domain":  "global",
+					"reason":  reason,
+					"message": reason,
+				},
+			},
+		},
+	})
+}
+
+func getTestGDriveCredentials(t *testing.T, server *fakeGDriveServer, clientEmail string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "sftpgo",
+		"private_key_id": "keyID",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		"client_email":   clientEmail,
+		"client_id":      "clientID",
+		"token_uri":      server.URL + "/token",
+	})
+	require.NoError(t, err)
+	return string(credentials)
+}
+
+func newTestGDriveFs(t *testing.T, server *fakeGDriveServer, config GDriveFsConfig) *GDriveFs {
+	endpoint := gdriveEndpoint
+	gdriveEndpoint = server.URL + "/drive/v3/"
+	t.Cleanup(func() {
+		gdriveEndpoint = endpoint
+	})
+
+	if config.Credentials == nil {
+		config.Credentials = kms.NewPlainSecret(getTestGDriveCredentials(t, server, server.clientEmail))
+	}
+	fs, err := NewGDriveFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*GDriveFs)
+}
+
+func uploadTestGDriveFile(t *testing.T, fs *GDriveFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestGDriveFile(t *testing.T, fs *GDriveFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestGDriveFsBasicOperations(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{})
+
+	require.NoError(t, fs.Mkdir("/dir"))
+	err := fs.Mkdir("/dir")
+	assert.ErrorIs(t, err, os.ErrExist)
+	dir, ok := server.findFile(fakeGDriveRootID, "dir")
+	require.True(t, ok)
+	assert.Equal(t, gdriveFolderMimeType, dir.mimeType)
+
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/dir/file.txt", []byte("content")))
+	assert.Equal(t, 1, server.getOpCount("upload.multipart"))
+	assert.Equal(t, 0, server.getOpCount("upload.resumable"))
+	f, ok := server.findFile(dir.id, "file.txt")
+	require.True(t, ok)
+	assert.Equal(t, []byte("content"), f.data)
+	assert.True(t, strings.HasPrefix(f.mimeType, "text/plain"))
+	data, err := downloadTestGDriveFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestGDriveFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+	// overwriting a file preserves its ID
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/dir/file.txt", []byte("new content")))
+	assert.Equal(t, 2, server.getOpCount("upload.multipart"))
+	f, ok = server.getFile(f.id)
+	require.True(t, ok)
+	assert.Equal(t, []byte("new content"), f.data)
+	assert.Equal(t, 2, server.getFilesCount())
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(11), info.Size())
+	sum := md5.Sum([]byte("new content"))
+	assert.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, GetETag(info))
+	mtime := time.Date(2023, 10, 20, 11, 22, 33, 0, time.UTC)
+	require.NoError(t, fs.Chtimes("/dir/file.txt", mtime, mtime, false))
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	entries := listTestDir(t, fs, "/")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "dir", entries[0].Name())
+		assert.True(t, entries[0].IsDir())
+	}
+	entries = listTestDir(t, fs, "/dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, int64(11), entries[0].Size())
+	}
+	// moves are server side
+	numFiles, size, err := fs.Rename("/dir/file.txt", "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	f, ok = server.getFile(f.id)
+	require.True(t, ok)
+	assert.Equal(t, "file.txt", f.name)
+	assert.Equal(t, []string{fakeGDriveRootID}, f.parents)
+	_, err = fs.Stat("/dir/file.txt")
+	assert.True(t, fs.IsNotExist(err))
+	// Google Drive allows duplicate names, existing targets are removed
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/other", []byte("other content")))
+	_, _, err = fs.Rename("/file.txt", "/other")
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.getOpCount("files.delete"))
+	data, err = downloadTestGDriveFile(t, fs, "/other", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new content"), data)
+	// directories are moved with their contents and the cached IDs are invalidated
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/dir/sub.txt", []byte("sub")))
+	_, _, err = fs.Rename("/dir", "/moved")
+	require.NoError(t, err)
+	_, err = fs.Stat("/dir/sub.txt")
+	assert.True(t, fs.IsNotExist(err))
+	data, err = downloadTestGDriveFile(t, fs, "/moved/sub.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("sub"), data)
+
+	numFiles, sizeDiff, err := fs.CopyFile("/other", "/copy", 11)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(11), sizeDiff)
+	numFiles, sizeDiff, err = fs.CopyFile("/other", "/copy", 11)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(0), sizeDiff)
+	assert.Equal(t, 2, server.getOpCount("files.copy"))
+	// the copy preserves the content type detected on upload
+	mimeType, err := fs.GetMimeType("/copy")
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", mimeType)
+	numFiles, size, err = fs.GetDirSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(25), size)
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64((fakeGDriveQuotaLimit-25)/gdriveStatVFSBlockSize), statVFS.Bfree)
+
+	err = fs.Remove("/moved", true)
+	assert.ErrorContains(t, err, "non empty directory")
+	require.NoError(t, fs.Remove("/moved/sub.txt", false))
+	require.NoError(t, fs.Remove("/moved", true))
+	_, err = fs.Stat("/moved")
+	assert.True(t, fs.IsNotExist(err))
+	assert.Equal(t, 2, server.getFilesCount())
+}
+
+func TestGDriveFsReadDirPagination(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{})
+
+	dirID := server.putFile(fakeGDriveRootID, "dir", gdriveFolderMimeType, nil)
+	numFiles := gdrivePageSize + 5
+	expected := make([]string, 0, numFiles+1)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putFile(dirID, name, "application/octet-stream", []byte(name))
+		expected = append(expected, name)
+	}
+	subID := server.putFile(dirID, "sub", gdriveFolderMimeType, nil)
+	server.putFile(subID, "file", "application/octet-stream", []byte("data"))
+	expected = append(expected, "sub")
+	// forms cannot be exported and are not visible
+	server.putFile(dirID, "survey", "application/vnd.google-apps.form", nil)
+
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	entries, err := lister.Next(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "file0000", entries[0].Name())
+	assert.Equal(t, 1, server.getOpCount("files.list"))
+	assert.Equal(t, 1, server.getOpCount("files.find"))
+	require.NoError(t, lister.Close())
+
+	entries = listTestDir(t, fs, "/dir")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, e.Name() == "sub", e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, expected, names)
+	assert.Equal(t, 3, server.getOpCount("files.list"))
+	// the folder ID is cached
+	assert.Equal(t, 1, server.getOpCount("files.find"))
+
+	numFiles, size, err := fs.GetDirSize("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, gdrivePageSize+6, numFiles)
+	assert.Equal(t, int64(8*(gdrivePageSize+5)+4), size)
+	// the subfolder ID was cached while listing its parent
+	var walked []string
+	err = fs.Walk("/dir/sub", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dir/sub", "/dir/sub/file"}, walked)
+	assert.Equal(t, 2, server.getOpCount("files.find"))
+}
+
+func TestGDriveFsResumableUpload(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{
+		UploadPartSize: 1,
+	})
+
+	data := bytes.Repeat([]byte("0123456789"), 256*1024)
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/large.bin", data))
+	assert.Equal(t, 0, server.getOpCount("upload.multipart"))
+	assert.Equal(t, 1, server.getOpCount("upload.resumable"))
+	assert.Equal(t, 3, server.getOpCount("upload.chunk"))
+	f, ok := server.findFile(fakeGDriveRootID, "large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, f.data)
+	assert.Equal(t, "application/octet-stream", f.mimeType)
+
+	downloaded, err := downloadTestGDriveFile(t, fs, "/large.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	downloaded, err = downloadTestGDriveFile(t, fs, "/large.bin", int64(len(data)-5))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("56789"), downloaded)
+	// a failed chunk does not change the existing file
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "upload.chunk" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	err = uploadTestGDriveFile(t, fs, "/large.bin", bytes.Repeat([]byte("a"), 3*1024*1024))
+	assert.Error(t, err)
+	f, ok = server.getFile(f.id)
+	require.True(t, ok)
+	assert.Equal(t, data, f.data)
+	assert.Equal(t, 1, server.getFilesCount())
+}
+
+func TestGDriveFsGoogleDocs(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{})
+
+	docID := server.putFile(fakeGDriveRootID, "report", "application/vnd.google-apps.document", []byte("doc"))
+	server.putFile(fakeGDriveRootID, "survey", "application/vnd.google-apps.form", nil)
+	docxMimeType := gdriveExportFormats["application/vnd.google-apps.document"][GDriveExportOffice].mimeType
+
+	entries := listTestDir(t, fs, "/")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "report.docx", entries[0].Name())
+		assert.Equal(t, int64(0), entries[0].Size())
+	}
+	_, err := fs.Stat("/survey")
+	assert.True(t, fs.IsNotExist(err))
+	info, err := fs.Stat("/report.docx")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	mimeType, err := fs.GetMimeType("/report.docx")
+	require.NoError(t, err)
+	assert.Equal(t, docxMimeType, mimeType)
+	data, err := downloadTestGDriveFile(t, fs, "/report.docx", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(docxMimeType+":doc"), data)
+	assert.Equal(t, 1, server.getOpCount("files.export"))
+	assert.Equal(t, 0, server.getOpCount("files.download"))
+	_, _, _, err = fs.Open("/report.docx", 1)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, _, _, err = fs.Create("/report.docx", 0, 0)
+	assert.True(t, fs.IsPermission(err))
+	assert.ErrorIs(t, err, errGDriveReadOnly)
+	// the export extension is not part of the name on Google Drive
+	_, _, err = fs.Rename("/report.docx", "/renamed.docx")
+	require.NoError(t, err)
+	doc, ok := server.getFile(docID)
+	require.True(t, ok)
+	assert.Equal(t, "renamed", doc.name)
+
+	fs = newTestGDriveFs(t, server, GDriveFsConfig{
+		ExportFormat: GDriveExportPDF,
+	})
+	entries = listTestDir(t, fs, "/")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "renamed.pdf", entries[0].Name())
+	}
+	data, err = downloadTestGDriveFile(t, fs, "/renamed.pdf", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("application/pdf:doc"), data)
+	_, err = fs.Stat("/renamed.docx")
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestGDriveFsSharedDrive(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	server.driveID = "driveID"
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{
+		Subject:  "user@example.com",
+		DriveID:  "driveID",
+		UseTrash: true,
+	})
+	assert.Contains(t, fs.Name(), "shared drive")
+
+	fileID := server.putFile("driveID", "file", "application/octet-stream", []byte("data"))
+	info, err := fs.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	assert.Equal(t, "user@example.com", server.getSubject())
+	require.NoError(t, uploadTestGDriveFile(t, fs, "/file1", []byte("data1")))
+	_, ok := server.findFile("driveID", "file1")
+	assert.True(t, ok)
+	// removed files are moved to the trash
+	require.NoError(t, fs.Remove("/file", false))
+	f, ok := server.getFile(fileID)
+	require.True(t, ok)
+	assert.True(t, f.trashed)
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsNotExist(err))
+	entries := listTestDir(t, fs, "/")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file1", entries[0].Name())
+	}
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.ErrorIs(t, err, ErrStorageSizeUnavailable)
+	assert.Equal(t, 0, server.getOpCount("about.get"))
+}
+
+func TestGDriveFsErrors(t *testing.T) {
+	server := newFakeGDriveServer(t)
+	endpoint := gdriveEndpoint
+	gdriveEndpoint = server.URL + "/drive/v3/"
+	_, err := NewGDriveFs("connID", t.TempDir(), "", GDriveFsConfig{
+		Credentials: kms.NewPlainSecret("invalid"),
+	})
+	assert.ErrorContains(t, err, "invalid Google Drive service account credentials")
+	gdriveEndpoint = endpoint
+
+	fs := newTestGDriveFs(t, server, GDriveFsConfig{
+		Credentials: kms.NewPlainSecret(getTestGDriveCredentials(t, server, "other@sftpgo.iam.gserviceaccount.com")),
+	})
+	_, err = fs.Stat("/file")
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	fs = newTestGDriveFs(t, server, GDriveFsConfig{})
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Chtimes("/missing", time.Now(), time.Now(), false)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Open("/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/file", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, _, err = fs.Rename("/", "/target")
+	assert.True(t, fs.IsPermission(err))
+
+	server.putFile(fakeGDriveRootID, "file", "application/octet-stream", []byte("data"))
+	server.putFile(fakeGDriveRootID, "dir", gdriveFolderMimeType, nil)
+	_, err = fs.ReadDir("/file")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/file", "/dir")
+	assert.ErrorContains(t, err, "cannot overwrite directory")
+	_, _, _, err = fs.Create("/dir", 0, 0)
+	assert.ErrorContains(t, err, "cannot overwrite directory")
+
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		switch op {
+		case "files.find", "files.list", "upload.multipart", "files.download":
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.ReadDir("/dir")
+	assert.True(t, fs.IsPermission(err))
+	// the root folder is listed without resolving it
+	lister, err := fs.ReadDir("/")
+	require.NoError(t, err)
+	_, err = lister.Next(10)
+	assert.True(t, fs.IsPermission(err))
+	require.NoError(t, lister.Close())
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		switch op {
+		case "upload.multipart", "files.download":
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	err = uploadTestGDriveFile(t, fs, "/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestGDriveFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "files.update" {
+			return http.StatusUnauthorized
+		}
+		return 0
+	})
+	_, _, err = fs.Rename("/file", "/file1")
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(nil)
+	_, ok := server.findFile(fakeGDriveRootID, "file1")
+	assert.False(t, ok)
+	// rate limit errors use the 403 status code
+	err = &googleapi.Error{
+		Code: http.StatusForbidden,
+		Errors: []googleapi.ErrorItem{
+			{Reason: "userRateLimitExceeded"},
+		},
+	}
+	assert.False(t, fs.IsPermission(err))
+	assert.False(t, fs.IsPermission(errors.New("generic error")))
+	assert.False(t, fs.IsNotExist(errors.New("generic error")))
+}
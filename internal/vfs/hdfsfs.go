@@ -0,0 +1,1015 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nohdfs
+// +build !nohdfs
+
+package vfs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	hdfsAPIPath                = "/webhdfs/v1"
+	hdfsDefaultKrb5Conf        = "/etc/krb5.conf"
+	hdfsStatVFSBlockSize       = 4096
+	hdfsStatVFSMaxFilenameSize = 255
+)
+
+// HDFSFs is a Fs implementation for Hadoop Distributed File System clusters.
+// The cluster is accessed using the WebHDFS REST API exposed by the namenodes,
+// or by an HttpFS gateway, so no Hadoop client libraries are required
+type HDFSFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath   string
+	config      *HDFSFsConfig
+	endpointURL *url.URL
+	client      *http.Client
+	ctxTimeout  time.Duration
+	mu          sync.Mutex
+	krbClient   *client.Client
+}
+
+func init() {
+	version.AddFeature("+hdfs")
+}
+
+// NewHDFSFs returns an HDFSFs object that allows to interact with an HDFS cluster
+func NewHDFSFs(connectionID, localTempDir, mountPath string, config HDFSFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &HDFSFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if !fs.config.KerberosPassword.IsEmpty() {
+		if err := fs.config.KerberosPassword.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if !fs.config.KerberosKeytab.IsEmpty() {
+		if err := fs.config.KerberosKeytab.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	endpointURL, err := url.Parse(fs.config.Endpoint)
+	if err != nil {
+		return fs, err
+	}
+	fs.endpointURL = endpointURL
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if fs.config.SkipTLSVerify {
+		transport.TLSClientConfig = getInsecureTLSConfig()
+	}
+	fs.client = &http.Client{
+		Transport: transport,
+		// the namenode redirects data operations to a datanode, the
+		// redirects are handled explicitly
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if fs.config.AuthMethod == HDFSAuthKerberos {
+		if _, err := fs.getKerberosClient(); err != nil {
+			fsLog(fs, logger.LevelError, "unable to login to the Kerberos KDC: %v", err)
+			return fs, err
+		}
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *HDFSFs) Name() string {
+	return fmt.Sprintf("%s %q prefix %q", hdfsFsName, fs.config.Endpoint, fs.config.Prefix)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *HDFSFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *HDFSFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result struct {
+		FileStatus hdfsFileStatus `json:"FileStatus"`
+	}
+	if err := fs.call(ctx, http.MethodGet, "GETFILESTATUS", name, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.FileStatus.getFileInfo(path.Base(name)), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *HDFSFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *HDFSFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		params := url.Values{}
+		if offset > 0 {
+			params.Set("offset", strconv.FormatInt(offset, 10))
+		}
+		resp, err := fs.sendData(ctx, http.MethodGet, "OPEN", name, params, nil, http.StatusOK)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *HDFSFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		params := url.Values{}
+		params.Set("overwrite", "true")
+		// the HTTP client closes the request body, the pipe is closed below
+		resp, err := fs.sendData(ctx, http.MethodPut, "CREATE", name, params, io.NopCloser(r),
+			http.StatusCreated, http.StatusOK)
+		if err == nil {
+			err = resp.Body.Close()
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %+v", name, r.GetReadedBytes(), err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are renamed server side including their contents.
+// HDFS moves the source inside the target if it is an existing directory,
+// so the target is checked before renaming
+func (fs *HDFSFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	info, err := fs.Stat(target)
+	if err == nil {
+		if info.IsDir() {
+			return -1, -1, fmt.Errorf("cannot rename %q: target %q is a directory", source, target)
+		}
+		srcInfo, err := fs.Stat(source)
+		if err != nil {
+			return -1, -1, err
+		}
+		if srcInfo.IsDir() {
+			return -1, -1, fmt.Errorf("cannot rename directory %q over the file %q", source, target)
+		}
+		fsLog(fs, logger.LevelDebug, "target %q exists, remove it before renaming", target)
+		if err := fs.Remove(target, false); err != nil {
+			return -1, -1, err
+		}
+	} else if !fs.IsNotExist(err) {
+		return -1, -1, err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	params := url.Values{}
+	params.Set("destination", fs.getHDFSPath(target))
+	if err := fs.callBool(ctx, http.MethodPut, "RENAME", source, params); err != nil {
+		return -1, -1, err
+	}
+	return -1, -1, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *HDFSFs) Remove(name string, _ bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	params := url.Values{}
+	params.Set("recursive", "false")
+	return fs.callBool(ctx, http.MethodDelete, "DELETE", name, params)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions.
+// MKDIRS succeeds if the directory already exists, so we check it before
+func (fs *HDFSFs) Mkdir(name string) error {
+	if _, err := fs.Stat(name); err == nil {
+		return fmt.Errorf("%w: %q", os.ErrExist, name)
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.callBool(ctx, http.MethodPut, "MKDIRS", name, nil)
+}
+
+// Symlink creates source as a symbolic link to target.
+// Symlinks are disabled by default in HDFS
+func (*HDFSFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*HDFSFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+// HDFS owners and groups are names, not numeric ids
+func (*HDFSFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (fs *HDFSFs) Chmod(name string, mode os.FileMode) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	params := url.Values{}
+	params.Set("permission", strconv.FormatUint(uint64(mode.Perm()), 8))
+	return fs.call(ctx, http.MethodPut, "SETPERMISSION", name, params, nil)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *HDFSFs) Chtimes(name string, atime, mtime time.Time, _ bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	params := url.Values{}
+	params.Set("accesstime", strconv.FormatInt(atime.UnixMilli(), 10))
+	params.Set("modificationtime", strconv.FormatInt(mtime.UnixMilli(), 10))
+	return fs.call(ctx, http.MethodPut, "SETTIMES", name, params, nil)
+}
+
+// Truncate changes the size of the named file.
+// Truncating an opened file is handled inside base transfer
+func (fs *HDFSFs) Truncate(name string, size int64) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	params := url.Values{}
+	params.Set("newlength", strconv.FormatInt(size, 10))
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	// false means that the last block recovery is in progress, the file
+	// will be truncated asynchronously
+	return fs.call(ctx, http.MethodPost, "TRUNCATE", name, params, &result)
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+// Large directories are listed in batches
+func (fs *HDFSFs) ReadDir(dirname string) (DirLister, error) {
+	lister := &hdfsDirLister{
+		fs:      fs,
+		dirname: dirname,
+	}
+	if err := lister.fetch(); err != nil {
+		return nil, err
+	}
+	return lister, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on HDFS
+func (*HDFSFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*HDFSFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Files being written are visible in HDFS, atomic uploads are
+// implemented by renaming a temporary file
+func (*HDFSFs) IsAtomicUploadSupported() bool {
+	return true
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*HDFSFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	var apiErr *hdfsError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusNotFound || apiErr.exception == "FileNotFoundException"
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*HDFSFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	var apiErr *hdfsError
+	if errors.As(err, &apiErr) {
+		switch apiErr.exception {
+		case "AccessControlException", "SecurityException", "AuthorizationException":
+			return true
+		}
+		return apiErr.statusCode == http.StatusUnauthorized || apiErr.statusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*HDFSFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrVfsUnsupported) {
+		return true
+	}
+	var apiErr *hdfsError
+	if errors.As(err, &apiErr) {
+		return apiErr.exception == "UnsupportedOperationException" || apiErr.statusCode == http.StatusNotImplemented
+	}
+	return false
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *HDFSFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	// MKDIRS creates the missing parents too
+	if err := fs.callBool(ctx, http.MethodPut, "MKDIRS", fs.config.Prefix, nil); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *HDFSFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders.
+// The content summary is computed by the namenode
+func (fs *HDFSFs) GetDirSize(dirname string) (int, int64, error) {
+	summary, err := fs.getContentSummary(dirname)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(summary.FileCount), summary.Length, nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*HDFSFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *HDFSFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *HDFSFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*HDFSFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*HDFSFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *HDFSFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetMimeType returns the content type
+func (*HDFSFs) GetMimeType(name string) (string, error) {
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// Close closes the fs
+func (fs *HDFSFs) Close() error {
+	fs.client.CloseIdleConnections()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.krbClient != nil {
+		fs.krbClient.Destroy()
+		fs.krbClient = nil
+	}
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path.
+// If a space quota is set on the directory it is used, otherwise the
+// cluster capacity is returned
+func (fs *HDFSFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	summary, err := fs.getContentSummary(dirName)
+	if err != nil {
+		return nil, err
+	}
+	if summary.SpaceQuota > 0 {
+		available := summary.SpaceQuota - summary.SpaceConsumed
+		if available < 0 {
+			available = 0
+		}
+		return getHDFSStatVFS(summary.SpaceQuota, available), nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result struct {
+		FsStatus struct {
+			Capacity  int64 `json:"capacity"`
+			Remaining int64 `json:"remaining"`
+		} `json:"FsStatus"`
+	}
+	// GETSTATUS is available in recent Hadoop versions only
+	if err := fs.call(ctx, http.MethodGet, "GETSTATUS", "/", nil, &result); err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to get the filesystem status: %v", err)
+		return nil, ErrStorageSizeUnavailable
+	}
+	if result.FsStatus.Capacity <= 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	return getHDFSStatVFS(result.FsStatus.Capacity, result.FsStatus.Remaining), nil
+}
+
+func (fs *HDFSFs) getContentSummary(name string) (*hdfsContentSummary, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result struct {
+		ContentSummary hdfsContentSummary `json:"ContentSummary"`
+	}
+	if err := fs.call(ctx, http.MethodGet, "GETCONTENTSUMMARY", name, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.ContentSummary, nil
+}
+
+// getHDFSPath returns the absolute HDFS path for the specified name
+func (*HDFSFs) getHDFSPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// getURL returns the WebHDFS URL for the specified operation and path
+func (fs *HDFSFs) getURL(op, name string, params url.Values) string {
+	u := *fs.endpointURL
+	u.Path = path.Join("/", fs.endpointURL.Path, hdfsAPIPath, fs.getHDFSPath(name))
+	u.RawPath = ""
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set("op", op)
+	if fs.config.AuthMethod == HDFSAuthSimple {
+		q.Set("user.name", fs.config.Username)
+	}
+	if fs.config.DoAs != "" {
+		q.Set("doas", fs.config.DoAs)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (fs *HDFSFs) getKerberosClient() (*client.Client, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.krbClient != nil {
+		return fs.krbClient, nil
+	}
+	var cfg *krb5config.Config
+	var err error
+	if fs.config.Krb5Conf != "" {
+		cfg, err = krb5config.NewFromString(fs.config.Krb5Conf)
+	} else {
+		krb5Conf := os.Getenv("KRB5_CONFIG")
+		if krb5Conf == "" {
+			krb5Conf = hdfsDefaultKrb5Conf
+		}
+		cfg, err = krb5config.Load(krb5Conf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the Kerberos configuration: %w", err)
+	}
+	username, realm, _ := strings.Cut(fs.config.KerberosPrincipal, "@")
+	var cl *client.Client
+	if !fs.config.KerberosKeytab.IsEmpty() {
+		data, err := base64.StdEncoding.DecodeString(fs.config.KerberosKeytab.GetPayload())
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the Kerberos keytab: %w", err)
+		}
+		kt := keytab.New()
+		if err := kt.Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("unable to parse the Kerberos keytab: %w", err)
+		}
+		cl = client.NewWithKeytab(username, realm, kt, cfg)
+	} else {
+		cl = client.NewWithPassword(username, realm, fs.config.KerberosPassword.GetPayload(), cfg)
+	}
+	if err := cl.Login(); err != nil {
+		return nil, err
+	}
+	fs.krbClient = cl
+	return cl, nil
+}
+
+// authorize adds the SPNEGO authorization header, if required.
+// Simple authentication uses the user.name query parameter instead
+func (fs *HDFSFs) authorize(req *http.Request) error {
+	if fs.config.AuthMethod != HDFSAuthKerberos {
+		return nil
+	}
+	cl, err := fs.getKerberosClient()
+	if err != nil {
+		return err
+	}
+	return spnego.SetSPNEGOHeader(cl, req, fs.config.ServicePrincipal)
+}
+
+// do sends the request to the namenode and returns an error if the response
+// status code is not one of the expected ones
+func (fs *HDFSFs) do(req *http.Request, expectedCodes ...int) (*http.Response, error) {
+	if err := fs.authorize(req); err != nil {
+		return nil, fmt.Errorf("unable to authorize WebHDFS request %s %q: %w", req.Method, req.URL.Path, err)
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send WebHDFS request %s %q: %w", req.Method, req.URL.Path, err)
+	}
+	for _, code := range expectedCodes {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	return nil, getHDFSError(req, resp)
+}
+
+// call executes a metadata operation and decodes the JSON response, if any
+func (fs *HDFSFs) call(ctx context.Context, method, op, name string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, fs.getURL(op, name, params), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(result); err != nil {
+		return fmt.Errorf("unable to decode WebHDFS %s response: %w", op, err)
+	}
+	return nil
+}
+
+// callBool executes an operation returning a boolean result, false is
+// returned, for example, if the path does not exist
+func (fs *HDFSFs) callBool(ctx context.Context, method, op, name string, params url.Values) error {
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := fs.call(ctx, method, op, name, params, &result); err != nil {
+		return err
+	}
+	if !result.Boolean {
+		if op == "DELETE" || op == "RENAME" {
+			if _, err := fs.Stat(name); fs.IsNotExist(err) {
+				return fmt.Errorf("%w: %q", os.ErrNotExist, name)
+			}
+		}
+		return fmt.Errorf("WebHDFS %s operation failed for %q", op, name)
+	}
+	return nil
+}
+
+// sendData executes a data operation. The namenode replies with the
+// location of the datanode to use, the data are then sent to, or read from,
+// the returned location
+func (fs *HDFSFs) sendData(ctx context.Context, method, op, name string, params url.Values, body io.Reader,
+	expectedCodes ...int,
+) (*http.Response, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("noredirect", "true")
+	req, err := http.NewRequestWithContext(ctx, method, fs.getURL(op, name, params), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.do(req, http.StatusOK, http.StatusTemporaryRedirect)
+	if err != nil {
+		return nil, err
+	}
+	location, err := getHDFSLocation(resp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the datanode location for WebHDFS %s %q: %w", op, name, err)
+	}
+	dataURL, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datanode location %q: %w", location, err)
+	}
+	req, err = http.NewRequestWithContext(ctx, method, dataURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	// the datanode URL includes a delegation token if Kerberos is enabled,
+	// HttpFS gateways redirect to themselves and require authentication
+	if dataURL.Host == fs.endpointURL.Host {
+		return fs.do(req, expectedCodes...)
+	}
+	resp, err = fs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send WebHDFS request %s %q: %w", req.Method, req.URL.Path, err)
+	}
+	for _, code := range expectedCodes {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	return nil, getHDFSError(req, resp)
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *HDFSFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// getHDFSLocation returns the datanode location from a redirect response
+// or from the JSON body returned if redirects are disabled
+func getHDFSLocation(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTemporaryRedirect {
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return "", errors.New("missing location header")
+		}
+		return location, nil
+	}
+	var result struct {
+		Location string `json:"Location"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Location == "" {
+		return "", errors.New("missing location")
+	}
+	return result.Location, nil
+}
+
+func getHDFSStatVFS(total, available int64) *sftp.StatVFS {
+	return &sftp.StatVFS{
+		Bsize:   hdfsStatVFSBlockSize,
+		Frsize:  hdfsStatVFSBlockSize,
+		Blocks:  uint64(total) / hdfsStatVFSBlockSize,
+		Bfree:   uint64(available) / hdfsStatVFSBlockSize,
+		Bavail:  uint64(available) / hdfsStatVFSBlockSize,
+		Namemax: hdfsStatVFSMaxFilenameSize,
+	}
+}
+
+// getHDFSError returns an error from the RemoteException included in the
+// response body, if any
+func getHDFSError(req *http.Request, resp *http.Response) error {
+	apiErr := &hdfsError{
+		method:     req.Method,
+		path:       req.URL.Path,
+		statusCode: resp.StatusCode,
+	}
+	var result struct {
+		RemoteException struct {
+			Exception string `json:"exception"`
+			Message   string `json:"message"`
+		} `json:"RemoteException"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(&result); err == nil {
+		apiErr.exception = result.RemoteException.Exception
+		apiErr.message = result.RemoteException.Message
+	}
+	return apiErr
+}
+
+type hdfsError struct {
+	method     string
+	path       string
+	statusCode int
+	exception  string
+	message    string
+}
+
+func (e *hdfsError) Error() string {
+	if e.exception != "" {
+		return fmt.Sprintf("WebHDFS %s request for %q failed, status code: %d, %s: %s", e.method, e.path,
+			e.statusCode, e.exception, e.message)
+	}
+	return fmt.Sprintf("WebHDFS %s request for %q failed, status code: %d", e.method, e.path, e.statusCode)
+}
+
+type hdfsFileStatus struct {
+	AccessTime       int64  `json:"accessTime"`
+	BlockSize        int64  `json:"blockSize"`
+	Group            string `json:"group"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"`
+	Owner            string `json:"owner"`
+	PathSuffix       string `json:"pathSuffix"`
+	Permission       string `json:"permission"`
+	Replication      int    `json:"replication"`
+	Type             string `json:"type"`
+}
+
+func (s *hdfsFileStatus) getFileInfo(name string) *FileInfo {
+	if s.PathSuffix != "" {
+		name = s.PathSuffix
+	}
+	isDir := s.Type == "DIRECTORY"
+	size := s.Length
+	if isDir {
+		size = 0
+	}
+	info := NewFileInfo(name, isDir, size, util.GetTimeFromMsecSinceEpoch(s.ModificationTime), false)
+	if perm, err := strconv.ParseUint(s.Permission, 8, 32); err == nil {
+		mode := os.FileMode(perm) & os.ModePerm
+		if isDir {
+			mode |= os.ModeDir
+		}
+		info.SetMode(mode)
+	}
+	return info
+}
+
+type hdfsContentSummary struct {
+	DirectoryCount int64 `json:"directoryCount"`
+	FileCount      int64 `json:"fileCount"`
+	Length         int64 `json:"length"`
+	Quota          int64 `json:"quota"`
+	SpaceConsumed  int64 `json:"spaceConsumed"`
+	SpaceQuota     int64 `json:"spaceQuota"`
+}
+
+// hdfsDirLister uses LISTSTATUS_BATCH to list large directories in
+// batches, if not supported a single LISTSTATUS request is used
+type hdfsDirLister struct {
+	baseDirLister
+	fs         *HDFSFs
+	dirname    string
+	startAfter string
+	noBatch    bool
+	finished   bool
+}
+
+func (l *hdfsDirLister) fetch() error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.fs.ctxTimeout))
+	defer cancelFn()
+
+	if !l.noBatch {
+		params := url.Values{}
+		if l.startAfter != "" {
+			params.Set("startAfter", l.startAfter)
+		}
+		var result struct {
+			DirectoryListing struct {
+				PartialListing struct {
+					FileStatuses struct {
+						FileStatus []hdfsFileStatus `json:"FileStatus"`
+					} `json:"FileStatuses"`
+				} `json:"partialListing"`
+				RemainingEntries int64 `json:"remainingEntries"`
+			} `json:"DirectoryListing"`
+		}
+		err := l.fs.call(ctx, http.MethodGet, "LISTSTATUS_BATCH", l.dirname, params, &result)
+		if err == nil {
+			l.addEntries(result.DirectoryListing.PartialListing.FileStatuses.FileStatus)
+			l.finished = result.DirectoryListing.RemainingEntries == 0
+			return nil
+		}
+		var apiErr *hdfsError
+		if l.startAfter != "" || !errors.As(err, &apiErr) || apiErr.statusCode != http.StatusBadRequest {
+			return err
+		}
+		// unsupported operation, for example on HttpFS
+		l.noBatch = true
+	}
+	var result struct {
+		FileStatuses struct {
+			FileStatus []hdfsFileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	if err := l.fs.call(ctx, http.MethodGet, "LISTSTATUS", l.dirname, nil, &result); err != nil {
+		return err
+	}
+	l.addEntries(result.FileStatuses.FileStatus)
+	l.finished = true
+	return nil
+}
+
+func (l *hdfsDirLister) addEntries(statuses []hdfsFileStatus) {
+	for idx := range statuses {
+		if statuses[idx].PathSuffix == "" {
+			// the listed path is a file
+			continue
+		}
+		l.cache = append(l.cache, statuses[idx].getFileInfo(""))
+		l.startAfter = statuses[idx].PathSuffix
+	}
+}
+
+func (l *hdfsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && !l.finished {
+		if err := l.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	if len(l.cache) >= limit || !l.finished {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
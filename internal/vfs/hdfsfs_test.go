@@ -0,0 +1,1108 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nohdfs
+// +build !nohdfs
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	fakeHDFSUsername    = "hdfs"
+	fakeHDFSReplication = 3
+	// prefix for the operations handled by the datanode
+	fakeHDFSDatanodeOp = "datanode "
+)
+
+// fakeHDFSMethods defines the HTTP method required for each WebHDFS operation
+var fakeHDFSMethods = map[string]string{
+	"GETFILESTATUS":     http.MethodGet,
+	"LISTSTATUS":        http.MethodGet,
+	"LISTSTATUS_BATCH":  http.MethodGet,
+	"GETCONTENTSUMMARY": http.MethodGet,
+	"GETSTATUS":         http.MethodGet,
+	"OPEN":              http.MethodGet,
+	"CREATE":            http.MethodPut,
+	"MKDIRS":            http.MethodPut,
+	"RENAME":            http.MethodPut,
+	"SETPERMISSION":     http.MethodPut,
+	"SETTIMES":          http.MethodPut,
+	"TRUNCATE":          http.MethodPost,
+	"DELETE":            http.MethodDelete,
+}
+
+type fakeHDFSEntry struct {
+	isDir bool
+	data  []byte
+	perm  os.FileMode
+	mtime int64
+	atime int64
+}
+
+// fakeHDFSServer implements the WebHDFS operations used by HDFSFs on top of
+// an in memory tree. Data operations are redirected to a separate datanode
+// server, or handled by the namenode itself as HttpFS gateways do
+type fakeHDFSServer struct {
+	*httptest.Server
+	datanode *httptest.Server
+	mu       sync.Mutex
+	entries  map[string]*fakeHDFSEntry
+	ops      map[string]int
+	// maximum number of entries returned for each LISTSTATUS_BATCH request
+	batchSize int
+	// if set LISTSTATUS_BATCH is not supported, as for HttpFS gateways
+	noBatch bool
+	// if set data operations are redirected to the namenode itself
+	dataOnNamenode bool
+	// if set the noredirect parameter is ignored, as older Hadoop
+	// versions do
+	ignoreNoRedirect bool
+	// the filesystem capacity, GETSTATUS is not supported if zero
+	capacity   int64
+	spaceQuota int64
+	// if set it is called for each authenticated request, a status code
+	// different from zero is returned as an error response without
+	// processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeHDFSServer(t *testing.T) *fakeHDFSServer {
+	s := &fakeHDFSServer{
+		entries: map[string]*fakeHDFSEntry{
+			"/": {
+				isDir: true,
+				perm:  0755,
+				mtime: util.GetTimeAsMsSinceEpoch(time.Now()),
+			},
+		},
+		ops:       make(map[string]int),
+		batchSize: 10,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handleNamenode))
+	t.Cleanup(s.Close)
+	s.datanode = httptest.NewServer(http.HandlerFunc(s.handleDatanode))
+	t.Cleanup(s.datanode.Close)
+	return s
+}
+
+func (s *fakeHDFSServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeHDFSServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeHDFSServer) update(fn func(s *fakeHDFSServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+}
+
+func (s *fakeHDFSServer) getEntry(name string) (fakeHDFSEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return fakeHDFSEntry{}, false
+	}
+	return *entry, true
+}
+
+func (s *fakeHDFSServer) putFile(t *testing.T, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	require.True(t, s.mkdirAllLocked(path.Dir(name)))
+	s.entries[name] = &fakeHDFSEntry{
+		data:  data,
+		perm:  0644,
+		mtime: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+}
+
+// mkdirAllLocked creates the specified directory and any missing parents,
+// false is returned if a path component is a file
+func (s *fakeHDFSServer) mkdirAllLocked(name string) bool {
+	if entry, ok := s.entries[name]; ok {
+		return entry.isDir
+	}
+	if !s.mkdirAllLocked(path.Dir(name)) {
+		return false
+	}
+	s.entries[name] = &fakeHDFSEntry{
+		isDir: true,
+		perm:  0755,
+		mtime: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	return true
+}
+
+func (s *fakeHDFSServer) getChildrenLocked(name string) []string {
+	var children []string
+	for p := range s.entries {
+		if p != "/" && path.Dir(p) == name {
+			children = append(children, path.Base(p))
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+func (s *fakeHDFSServer) getStatusLocked(name, pathSuffix string) hdfsFileStatus {
+	entry := s.entries[name]
+	status := hdfsFileStatus{
+		AccessTime:       entry.atime,
+		BlockSize:        134217728,
+		Group:            "supergroup",
+		Length:           int64(len(entry.data)),
+		ModificationTime: entry.mtime,
+		Owner:            fakeHDFSUsername,
+		PathSuffix:       pathSuffix,
+		Permission:       strconv.FormatUint(uint64(entry.perm), 8),
+		Replication:      fakeHDFSReplication,
+		Type:             "FILE",
+	}
+	if entry.isDir {
+		status.BlockSize = 0
+		status.Replication = 0
+		status.Type = "DIRECTORY"
+	}
+	return status
+}
+
+func (s *fakeHDFSServer) handleNamenode(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	op := query.Get("op")
+	if !s.checkRequest(w, r, op) {
+		return
+	}
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, hdfsAPIPath))
+	switch op {
+	case "OPEN", "CREATE":
+		if query.Get("data") == "true" {
+			s.handleData(w, r, op, name)
+			return
+		}
+		s.mu.Lock()
+		location := s.datanode.URL + r.URL.EscapedPath()
+		if s.dataOnNamenode {
+			location = s.URL + r.URL.EscapedPath()
+			query.Set("data", "true")
+		}
+		ignoreNoRedirect := s.ignoreNoRedirect
+		s.mu.Unlock()
+
+		query.Del("noredirect")
+		location += "?" + query.Encode()
+		if ignoreNoRedirect || r.URL.Query().Get("noredirect") != "true" {
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]string{"Location": location})
+	default:
+		s.handleMetadata(w, r, op, name)
+	}
+}
+
+func (s *fakeHDFSServer) handleDatanode(w http.ResponseWriter, r *http.Request) {
+	op := r.URL.Query().Get("op")
+	if !s.checkRequest(w, r, fakeHDFSDatanodeOp+op) {
+		return
+	}
+	s.handleData(w, r, op, path.Clean("/"+strings.TrimPrefix(r.URL.Path, hdfsAPIPath)))
+}
+
+func (s *fakeHDFSServer) checkRequest(w http.ResponseWriter, r *http.Request, op string) bool {
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if r.URL.Query().Get("user.name") != fakeHDFSUsername {
+		writeFakeHDFSError(w, http.StatusUnauthorized, "SecurityException", "Failed to obtain user group information")
+		return false
+	}
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			exception := ""
+			if status == http.StatusForbidden {
+				exception = "AccessControlException"
+			}
+			writeFakeHDFSError(w, status, exception, "injected error")
+			return false
+		}
+	}
+	method, ok := fakeHDFSMethods[strings.TrimPrefix(op, fakeHDFSDatanodeOp)]
+	if !ok || method != r.Method {
+		writeFakeHDFSError(w, http.StatusBadRequest, "IllegalArgumentException",
+			fmt.Sprintf("Invalid value for webhdfs parameter \"op\": %s %s", r.Method, op))
+		return false
+	}
+	return true
+}
+
+func (s *fakeHDFSServer) handleData(w http.ResponseWriter, r *http.Request, op, name string) {
+	switch op {
+	case "OPEN":
+		s.mu.Lock()
+		entry, ok := s.entries[name]
+		var data []byte
+		if ok && !entry.isDir {
+			data = entry.data
+		}
+		s.mu.Unlock()
+
+		if !ok || entry.isDir {
+			writeFakeHDFSError(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+name)
+			return
+		}
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if offset > int64(len(data)) {
+			writeFakeHDFSError(w, http.StatusForbidden, "IOException", "Offset out of the range")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data[offset:]) //nolint:errcheck
+	case "CREATE":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeHDFSError(w, http.StatusBadRequest, "IOException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if entry, ok := s.entries[name]; ok && (entry.isDir || r.URL.Query().Get("overwrite") != "true") {
+			writeFakeHDFSError(w, http.StatusForbidden, "FileAlreadyExistsException", name+" already exists")
+			return
+		}
+		if !s.mkdirAllLocked(path.Dir(name)) {
+			writeFakeHDFSError(w, http.StatusForbidden, "ParentNotDirectoryException", path.Dir(name)+" is not a directory")
+			return
+		}
+		s.entries[name] = &fakeHDFSEntry{
+			data:  data,
+			perm:  0644,
+			mtime: util.GetTimeAsMsSinceEpoch(time.Now()),
+		}
+		w.Header().Set("Location", "hdfs://localhost:8020"+name)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (s *fakeHDFSServer) handleMetadata(w http.ResponseWriter, r *http.Request, op, name string) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[name]
+	switch op {
+	case "GETSTATUS":
+		if s.capacity == 0 {
+			writeFakeHDFSError(w, http.StatusBadRequest, "IllegalArgumentException",
+				"Invalid value for webhdfs parameter \"op\": GETSTATUS")
+			return
+		}
+		var used int64
+		for _, e := range s.entries {
+			used += int64(len(e.data)) * fakeHDFSReplication
+		}
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]any{
+			"FsStatus": map[string]int64{
+				"capacity":  s.capacity,
+				"used":      used,
+				"remaining": s.capacity - used,
+			},
+		})
+		return
+	case "MKDIRS":
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]bool{"boolean": s.mkdirAllLocked(name)})
+		return
+	case "DELETE":
+		if !exists || name == "/" {
+			writeFakeHDFSResponse(w, http.StatusOK, map[string]bool{"boolean": false})
+			return
+		}
+		if entry.isDir && len(s.getChildrenLocked(name)) > 0 && query.Get("recursive") != "true" {
+			writeFakeHDFSError(w, http.StatusForbidden, "PathIsNotEmptyDirectoryException", name+" is non empty")
+			return
+		}
+		for p := range s.entries {
+			if p == name || strings.HasPrefix(p, name+"/") {
+				delete(s.entries, p)
+			}
+		}
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]bool{"boolean": true})
+		return
+	case "RENAME":
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]bool{"boolean": s.renameLocked(name, query.Get("destination"))})
+		return
+	}
+	if !exists {
+		writeFakeHDFSError(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+name)
+		return
+	}
+	switch op {
+	case "GETFILESTATUS":
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]any{"FileStatus": s.getStatusLocked(name, "")})
+	case "LISTSTATUS", "LISTSTATUS_BATCH":
+		statuses := []hdfsFileStatus{}
+		remaining := 0
+		if entry.isDir {
+			children := s.getChildrenLocked(name)
+			if op == "LISTSTATUS_BATCH" {
+				if s.noBatch {
+					writeFakeHDFSError(w, http.StatusBadRequest, "IllegalArgumentException",
+						"Invalid value for webhdfs parameter \"op\": LISTSTATUS_BATCH")
+					return
+				}
+				startAfter := query.Get("startAfter")
+				idx := sort.SearchStrings(children, startAfter)
+				if startAfter != "" && idx < len(children) && children[idx] == startAfter {
+					idx++
+				}
+				children = children[idx:]
+				if len(children) > s.batchSize {
+					remaining = len(children) - s.batchSize
+					children = children[:s.batchSize]
+				}
+			}
+			for _, child := range children {
+				statuses = append(statuses, s.getStatusLocked(path.Join(name, child), child))
+			}
+		} else {
+			statuses = append(statuses, s.getStatusLocked(name, ""))
+		}
+		fileStatuses := map[string]any{
+			"FileStatuses": map[string]any{"FileStatus": statuses},
+		}
+		if op == "LISTSTATUS" {
+			writeFakeHDFSResponse(w, http.StatusOK, fileStatuses)
+			return
+		}
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]any{
+			"DirectoryListing": map[string]any{
+				"partialListing":   fileStatuses,
+				"remainingEntries": remaining,
+			},
+		})
+	case "GETCONTENTSUMMARY":
+		var summary hdfsContentSummary
+		for p, e := range s.entries {
+			if p != name && !strings.HasPrefix(p, strings.TrimSuffix(name, "/")+"/") {
+				continue
+			}
+			if e.isDir {
+				summary.DirectoryCount++
+			} else {
+				summary.FileCount++
+				summary.Length += int64(len(e.data))
+			}
+		}
+		summary.Quota = -1
+		summary.SpaceConsumed = summary.Length * fakeHDFSReplication
+		summary.SpaceQuota = -1
+		if s.spaceQuota > 0 {
+			summary.SpaceQuota = s.spaceQuota
+		}
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]any{"ContentSummary": summary})
+	case "SETPERMISSION":
+		perm, err := strconv.ParseUint(query.Get("permission"), 8, 32)
+		if err != nil || perm > 01777 {
+			writeFakeHDFSError(w, http.StatusBadRequest, "IllegalArgumentException", "invalid permission")
+			return
+		}
+		entry.perm = os.FileMode(perm)
+		w.WriteHeader(http.StatusOK)
+	case "SETTIMES":
+		if val, err := strconv.ParseInt(query.Get("modificationtime"), 10, 64); err == nil && val >= 0 {
+			entry.mtime = val
+		}
+		if val, err := strconv.ParseInt(query.Get("accesstime"), 10, 64); err == nil && val >= 0 {
+			entry.atime = val
+		}
+		w.WriteHeader(http.StatusOK)
+	case "TRUNCATE":
+		size, err := strconv.ParseInt(query.Get("newlength"), 10, 64)
+		if entry.isDir || err != nil || size < 0 || size > int64(len(entry.data)) {
+			writeFakeHDFSError(w, http.StatusBadRequest, "HadoopIllegalArgumentException", "invalid newlength")
+			return
+		}
+		entry.data = entry.data[:size]
+		entry.mtime = util.GetTimeAsMsSinceEpoch(time.Now())
+		writeFakeHDFSResponse(w, http.StatusOK, map[string]bool{"boolean": true})
+	}
+}
+
+// renameLocked implements the HDFS rename semantics: the source is moved
+// inside the destination if it is an existing directory and false is
+// returned if the rename is not possible
+func (s *fakeHDFSServer) renameLocked(source, target string) bool {
+	srcEntry, ok := s.entries[source]
+	if !ok || source == "/" || target == "" {
+		return false
+	}
+	target = path.Clean(target)
+	if entry, ok := s.entries[target]; ok {
+		if !entry.isDir {
+			return false
+		}
+		target = path.Join(target, path.Base(source))
+		if _, ok := s.entries[target]; ok {
+			return false
+		}
+	}
+	if parent, ok := s.entries[path.Dir(target)]; !ok || !parent.isDir {
+		return false
+	}
+	if srcEntry.isDir && strings.HasPrefix(target, source+"/") {
+		return false
+	}
+	for p, e := range s.entries {
+		if p == source {
+			delete(s.entries, p)
+			s.entries[target] = e
+		} else if rel, ok := strings.CutPrefix(p, source+"/"); ok {
+			delete(s.entries, p)
+			s.entries[path.Join(target, rel)] = e
+		}
+	}
+	return true
+}
+
+func writeFakeHDFSResponse(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result) //nolint:errcheck
+}
+
+func writeFakeHDFSError(w http.ResponseWriter, status int, exception, message string) {
+	if exception == "" {
+		http.Error(w, message, status)
+		return
+	}
+	writeFakeHDFSResponse(w, status, map[string]any{
+		"RemoteException": map[string]string{
+			"exception":     exception,
+			"javaClassName": "org.apache.hadoop." + exception,
+			"message":       message,
+		},
+	})
+}
+
+func newTestHDFSFs(t *testing.T, server *fakeHDFSServer, config HDFSFsConfig) *HDFSFs {
+	config.Endpoint = server.URL
+	if config.Username == "" {
+		config.Username = fakeHDFSUsername
+	}
+	fs, err := NewHDFSFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*HDFSFs)
+}
+
+func uploadTestHDFSFile(t *testing.T, fs *HDFSFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestHDFSFile(t *testing.T, fs *HDFSFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestHDFSFsBasicOperations(t *testing.T) {
+	server := newFakeHDFSServer(t)
+	fs := newTestHDFSFs(t, server, HDFSFsConfig{})
+
+	require.NoError(t, fs.Mkdir("/dir"))
+	assert.ErrorIs(t, fs.Mkdir("/dir"), os.ErrExist)
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/dir/file.txt", []byte("content")))
+	entry, ok := server.getEntry("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	assert.Equal(t, 1, server.getOpCount("CREATE"))
+	assert.Equal(t, 1, server.getOpCount(fakeHDFSDatanodeOp+"CREATE"))
+	// existing files are overwritten
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/dir/file1.txt", []byte("data")))
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/dir/file1.txt", []byte("overwritten")))
+	entry, ok = server.getEntry("/dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "overwritten", string(entry.data))
+	data, err := downloadTestHDFSFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestHDFSFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+	assert.Equal(t, 2, server.getOpCount(fakeHDFSDatanodeOp+"OPEN"))
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, "dir", info.Name())
+	assert.Equal(t, os.ModeDir|0755, info.Mode())
+	info, err = fs.Lstat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "file.txt", info.Name())
+	assert.Equal(t, int64(7), info.Size())
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	entries := listTestDir(t, fs, "/dir")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, int64(7), entries[0].Size())
+		assert.Equal(t, "file1.txt", entries[1].Name())
+		assert.Equal(t, int64(11), entries[1].Size())
+	}
+	mimeType, err := fs.GetMimeType("/dir/file.txt")
+	require.NoError(t, err)
+	assert.Contains(t, mimeType, "text/plain")
+
+	require.NoError(t, fs.Chmod("/dir/file.txt", 0600))
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	require.NoError(t, fs.Chtimes("/dir/file.txt", mtime, mtime, false))
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	entry, ok = server.getEntry("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, util.GetTimeAsMsSinceEpoch(mtime), entry.atime)
+	require.NoError(t, fs.Truncate("/dir/file1.txt", 4))
+	entry, ok = server.getEntry("/dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "over", string(entry.data))
+	assert.Error(t, fs.Truncate("/dir/file1.txt", 10))
+
+	numFiles, size, err := fs.Rename("/dir/file1.txt", "/dir/file2.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	_, ok = server.getEntry("/dir/file1.txt")
+	assert.False(t, ok)
+	_, _, err = fs.Rename("/dir/file2.txt", "/dir/file2.txt")
+	assert.NoError(t, err)
+	// an existing target file is replaced
+	deleteOps := server.getOpCount("DELETE")
+	_, _, err = fs.Rename("/dir/file2.txt", "/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, deleteOps+1, server.getOpCount("DELETE"))
+	entry, ok = server.getEntry("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "over", string(entry.data))
+	_, ok = server.getEntry("/dir/file2.txt")
+	assert.False(t, ok)
+	server.putFile(t, "/dir/file1.txt", []byte("content"))
+	// existing directories are never replaced and the source is not moved
+	// inside them
+	require.NoError(t, fs.Mkdir("/dir1"))
+	_, _, err = fs.Rename("/dir/file1.txt", "/dir1")
+	assert.Error(t, err)
+	_, ok = server.getEntry("/dir1/file1.txt")
+	assert.False(t, ok)
+	_, _, err = fs.Rename("/dir", "/dir1")
+	assert.Error(t, err)
+	_, _, err = fs.Rename("/dir1", "/dir/file1.txt")
+	assert.Error(t, err)
+	_, ok = server.getEntry("/dir/file1.txt")
+	assert.True(t, ok)
+	// directories are moved including their contents
+	_, _, err = fs.Rename("/dir", "/dir2")
+	require.NoError(t, err)
+	assert.Len(t, listTestDir(t, fs, "/dir2"), 2)
+	_, err = fs.Stat("/dir")
+	assert.True(t, fs.IsNotExist(err))
+
+	server.putFile(t, "/dir2/sub/file", []byte("data"))
+	numFiles, size, err = fs.GetDirSize("/dir2")
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(15), size)
+	numFiles, size, err = fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(15), size)
+	var walked []string
+	err = fs.Walk("/", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/dir1", "/dir2", "/dir2/file.txt", "/dir2/file1.txt", "/dir2/sub", "/dir2/sub/file"},
+		walked)
+
+	err = fs.Remove("/dir2/sub", true)
+	assert.ErrorContains(t, err, "PathIsNotEmptyDirectoryException")
+	require.NoError(t, fs.Remove("/dir2/sub/file", false))
+	require.NoError(t, fs.Remove("/dir2/sub", true))
+	_, err = fs.Stat("/dir2/sub")
+	assert.True(t, fs.IsNotExist(err))
+
+	assert.ErrorIs(t, fs.Symlink("/dir2/file.txt", "/link"), ErrVfsUnsupported)
+	_, err = fs.Readlink("/link")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	assert.ErrorIs(t, fs.Chown("/dir2/file.txt", 1000, 1000), ErrVfsUnsupported)
+	_, _, _, err = fs.Create("/dir2/file.txt", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	assert.True(t, fs.IsNotSupported(err))
+	uploadPath := fs.GetAtomicUploadPath("/dir2/file.txt")
+	assert.Equal(t, "/dir2", path.Dir(uploadPath))
+	assert.True(t, strings.HasPrefix(path.Base(uploadPath), ".sftpgo-upload."))
+	assert.True(t, strings.HasSuffix(uploadPath, ".file.txt"))
+
+	// GETSTATUS is not available in older Hadoop versions
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.ErrorIs(t, err, ErrStorageSizeUnavailable)
+	server.update(func(s *fakeHDFSServer) {
+		s.capacity = 4096 * 100
+	})
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), statVFS.Blocks)
+	// 15 bytes replicated 3 times are stored in a partial block
+	assert.Equal(t, uint64(99), statVFS.Bfree)
+	assert.Equal(t, uint64(hdfsStatVFSMaxFilenameSize), statVFS.Namemax)
+	// the space quota has precedence over the cluster capacity
+	server.update(func(s *fakeHDFSServer) {
+		s.spaceQuota = 4096*10 + 45
+	})
+	statVFS, err = fs.GetAvailableDiskSize("/dir2")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), statVFS.Blocks)
+	assert.Equal(t, uint64(10), statVFS.Bfree)
+	server.update(func(s *fakeHDFSServer) {
+		s.spaceQuota = 10
+	})
+	statVFS, err = fs.GetAvailableDiskSize("/dir2")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), statVFS.Bfree)
+}
+
+func TestHDFSFsReadDirPagination(t *testing.T) {
+	server := newFakeHDFSServer(t)
+	fs := newTestHDFSFs(t, server, HDFSFsConfig{})
+
+	var expected []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("file%02d", i)
+		server.putFile(t, path.Join("/dir", name), []byte(name))
+		expected = append(expected, name)
+	}
+	// names are escaped in the requests
+	server.putFile(t, "/dir/sub dir %/file #1?", []byte("data"))
+	expected = append(expected, "sub dir %")
+
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	assert.Equal(t, 1, server.getOpCount("LISTSTATUS_BATCH"))
+	_, err = lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidDirListerLimit)
+	var names []string
+	for _, expectedLen := range []int{10, 10, 6} {
+		entries, err := lister.Next(10)
+		if expectedLen == 6 {
+			assert.ErrorIs(t, err, io.EOF)
+		} else {
+			assert.NoError(t, err)
+		}
+		require.Len(t, entries, expectedLen)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+	assert.Equal(t, 3, server.getOpCount("LISTSTATUS_BATCH"))
+	assert.Equal(t, 0, server.getOpCount("LISTSTATUS"))
+	assert.Equal(t, expected, names)
+	// all the batches are fetched if the limit is greater than the batch size
+	batchOps := server.getOpCount("LISTSTATUS_BATCH")
+	assert.Len(t, listTestDir(t, fs, "/dir"), 26)
+	assert.Equal(t, batchOps+3, server.getOpCount("LISTSTATUS_BATCH"))
+
+	entries := listTestDir(t, fs, "/dir/sub dir %")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file #1?", entries[0].Name())
+		assert.Equal(t, int64(4), entries[0].Size())
+	}
+	data, err := downloadTestHDFSFile(t, fs, "/dir/sub dir %/file #1?", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/dir/sub dir %/file #2?", []byte("data2")))
+	entry, ok := server.getEntry("/dir/sub dir %/file #2?")
+	require.True(t, ok)
+	assert.Equal(t, "data2", string(entry.data))
+	// LISTSTATUS is used if batched listings are not supported
+	server.update(func(s *fakeHDFSServer) {
+		s.noBatch = true
+	})
+	batchOps = server.getOpCount("LISTSTATUS_BATCH")
+	lister, err = fs.ReadDir("/dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	entries, err = lister.Next(10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+	entries, err = lister.Next(100)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Len(t, entries, 16)
+	assert.Equal(t, batchOps+1, server.getOpCount("LISTSTATUS_BATCH"))
+	assert.Equal(t, 1, server.getOpCount("LISTSTATUS"))
+}
+
+func TestHDFSFsDataRedirects(t *testing.T) {
+	server := newFakeHDFSServer(t)
+	fs := newTestHDFSFs(t, server, HDFSFsConfig{})
+
+	data := make([]byte, 1024*1024+1)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/file", data))
+	entry, ok := server.getEntry("/file")
+	require.True(t, ok)
+	assert.Equal(t, data, entry.data)
+	downloaded, err := downloadTestHDFSFile(t, fs, "/file", 1024)
+	require.NoError(t, err)
+	assert.Equal(t, data[1024:], downloaded)
+	assert.Equal(t, 1, server.getOpCount(fakeHDFSDatanodeOp+"CREATE"))
+	assert.Equal(t, 1, server.getOpCount(fakeHDFSDatanodeOp+"OPEN"))
+	// the namenode redirects even if noredirect is set
+	server.update(func(s *fakeHDFSServer) {
+		s.ignoreNoRedirect = true
+	})
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/file1", []byte("redirect")))
+	downloaded, err = downloadTestHDFSFile(t, fs, "/file1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("redirect"), downloaded)
+	assert.Equal(t, 2, server.getOpCount(fakeHDFSDatanodeOp+"CREATE"))
+	assert.Equal(t, 2, server.getOpCount(fakeHDFSDatanodeOp+"OPEN"))
+	// HttpFS gateways handle the data operations themselves
+	server.update(func(s *fakeHDFSServer) {
+		s.dataOnNamenode = true
+	})
+	for _, ignoreNoRedirect := range []bool{true, false} {
+		server.update(func(s *fakeHDFSServer) {
+			s.ignoreNoRedirect = ignoreNoRedirect
+		})
+		createOps := server.getOpCount("CREATE")
+		openOps := server.getOpCount("OPEN")
+		require.NoError(t, uploadTestHDFSFile(t, fs, "/file2", []byte("httpfs")))
+		downloaded, err = downloadTestHDFSFile(t, fs, "/file2", 2)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("tpfs"), downloaded)
+		assert.Equal(t, createOps+2, server.getOpCount("CREATE"))
+		assert.Equal(t, openOps+2, server.getOpCount("OPEN"))
+	}
+	assert.Equal(t, 2, server.getOpCount(fakeHDFSDatanodeOp+"CREATE"))
+	assert.Equal(t, 2, server.getOpCount(fakeHDFSDatanodeOp+"OPEN"))
+	// datanode errors are returned to the readers and writers
+	server.update(func(s *fakeHDFSServer) {
+		s.dataOnNamenode = false
+	})
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if strings.HasPrefix(op, fakeHDFSDatanodeOp) {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	err = uploadTestHDFSFile(t, fs, "/file3", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, ok = server.getEntry("/file3")
+	assert.False(t, ok)
+	_, err = downloadTestHDFSFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(nil)
+	_, err = downloadTestHDFSFile(t, fs, "/file", int64(len(data)+1))
+	assert.Error(t, err)
+	// the datanode is not reachable
+	server.datanode.Close()
+	_, err = downloadTestHDFSFile(t, fs, "/file", 0)
+	assert.ErrorContains(t, err, "unable to send WebHDFS request")
+}
+
+func TestHDFSFsPrefix(t *testing.T) {
+	server := newFakeHDFSServer(t)
+	fs := newTestHDFSFs(t, server, HDFSFsConfig{
+		Prefix: "/users/test/",
+	})
+	assert.Equal(t, "/users/test", fs.config.Prefix)
+	assert.Contains(t, fs.Name(), server.URL)
+
+	assert.True(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+	entry, ok := server.getEntry("/users/test")
+	require.True(t, ok)
+	assert.True(t, entry.isDir)
+	resolved, err := fs.ResolvePath("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/test/dir/file", resolved)
+	resolved, err = fs.ResolvePath("dir/../../file")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/test/file", resolved)
+	assert.Equal(t, "/dir/file", fs.GetRelativePath("/users/test/dir/file"))
+	assert.Equal(t, "/", fs.GetRelativePath("/users/other/file"))
+	assert.Equal(t, "/", fs.GetRelativePath(""))
+
+	require.NoError(t, uploadTestHDFSFile(t, fs, "/users/test/file", []byte("data")))
+	server.putFile(t, "/users/file", []byte("outside"))
+	numFiles, size, err := fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(4), size)
+
+	mountedFs, err := NewHDFSFs("connID", t.TempDir(), "/mount", HDFSFsConfig{
+		Endpoint: server.URL,
+		Username: fakeHDFSUsername,
+		Prefix:   "/users/test",
+	})
+	require.NoError(t, err)
+	defer mountedFs.Close()
+
+	assert.Equal(t, "/mount/file", mountedFs.GetRelativePath("/users/test/file"))
+	resolved, err = mountedFs.ResolvePath("/mount/file")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/test/file", resolved)
+	// the prefix cannot be created inside a file
+	fs = newTestHDFSFs(t, server, HDFSFsConfig{
+		Prefix: "/users/file/test",
+	})
+	assert.False(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+}
+
+func TestHDFSFsErrors(t *testing.T) {
+	server := newFakeHDFSServer(t)
+
+	for _, config := range []HDFSFsConfig{
+		{},
+		{Endpoint: "ftp://localhost"},
+		{Endpoint: server.URL},
+		{Endpoint: server.URL, AuthMethod: HDFSAuthKerberos, KerberosPrincipal: "sftpgo"},
+		{Endpoint: server.URL, AuthMethod: HDFSAuthKerberos, KerberosPrincipal: "sftpgo@EXAMPLE.COM"},
+		{Endpoint: server.URL, AuthMethod: HDFSAuthKerberos + 1},
+	} {
+		_, err := NewHDFSFs("connID", t.TempDir(), "", config)
+		assert.Error(t, err, "config %+v", config)
+	}
+	// the KDC is not reachable
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	kdcAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	_, err = NewHDFSFs("connID", t.TempDir(), "", HDFSFsConfig{
+		Endpoint:          server.URL,
+		AuthMethod:        HDFSAuthKerberos,
+		KerberosPrincipal: "sftpgo@EXAMPLE.COM",
+		KerberosPassword:  kms.NewPlainSecret("password"),
+		Krb5Conf: fmt.Sprintf("[libdefaults]\n default_realm = EXAMPLE.COM\n udp_preference_limit = 1\n"+
+			"[realms]\n EXAMPLE.COM = {\n  kdc = %s\n }\n", kdcAddr),
+	})
+	assert.Error(t, err)
+	_, err = NewHDFSFs("connID", t.TempDir(), "", HDFSFsConfig{
+		Endpoint:          server.URL,
+		AuthMethod:        HDFSAuthKerberos,
+		KerberosPrincipal: "sftpgo@EXAMPLE.COM",
+		KerberosPassword:  kms.NewPlainSecret("password"),
+		Krb5Conf:          "[libdefaults]\n ticket_lifetime = invalid\n",
+	})
+	assert.ErrorContains(t, err, "unable to load the Kerberos configuration")
+
+	fs := newTestHDFSFs(t, server, HDFSFsConfig{
+		Username: "invalid",
+	})
+	_, err = fs.Stat("/")
+	assert.True(t, fs.IsPermission(err))
+	assert.ErrorContains(t, err, "SecurityException")
+
+	fs = newTestHDFSFs(t, server, HDFSFsConfig{})
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Chmod("/missing", 0644)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Chtimes("/missing", time.Now(), time.Now(), false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Truncate("/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.GetDirSize("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.GetAvailableDiskSize("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = downloadTestHDFSFile(t, fs, "/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Walk("/missing", func(_ string, _ os.FileInfo, err error) error {
+		return err
+	})
+	assert.True(t, fs.IsNotExist(err))
+	server.putFile(t, "/file", []byte("data"))
+	// the parent is a file
+	err = uploadTestHDFSFile(t, fs, "/file/file", []byte("data"))
+	assert.ErrorContains(t, err, "ParentNotDirectoryException")
+	assert.False(t, fs.IsNotExist(err))
+	err = fs.Mkdir("/file/dir")
+	assert.ErrorContains(t, err, "MKDIRS operation failed")
+	// the target parent does not exist
+	_, _, err = fs.Rename("/file", "/missing/file")
+	assert.ErrorContains(t, err, "RENAME operation failed")
+	assert.False(t, fs.IsNotExist(err))
+	require.NoError(t, fs.Mkdir("/dir"))
+
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "GETCONTENTSUMMARY" {
+			return http.StatusNotImplemented
+		}
+		return http.StatusForbidden
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+	assert.ErrorContains(t, err, "AccessControlException")
+	_, err = fs.ReadDir("/")
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.Rename("/file", "/file1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("/file", false)
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Mkdir("/dir1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Chmod("/file", 0600)
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestHDFSFile(t, fs, "/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestHDFSFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	var walkErr error
+	err = fs.Walk("/dir", func(_ string, _ os.FileInfo, err error) error {
+		walkErr = err
+		return filepath.SkipDir
+	})
+	assert.Equal(t, filepath.SkipDir, err)
+	assert.True(t, fs.IsPermission(walkErr))
+	_, _, err = fs.GetDirSize("/dir")
+	assert.True(t, fs.IsNotSupported(err))
+	assert.False(t, fs.IsPermission(err))
+	assert.False(t, fs.IsNotExist(err))
+	// an error fetching the next batch is not handled as an unsupported
+	// batched listing
+	for i := 0; i < 15; i++ {
+		server.putFile(t, fmt.Sprintf("/dir/file%02d", i), []byte("data"))
+	}
+	server.setOnRequest(func(op string, r *http.Request) int {
+		if op == "LISTSTATUS_BATCH" && r.URL.Query().Get("startAfter") != "" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	entries, err := lister.Next(10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+	_, err = lister.Next(10)
+	assert.Error(t, err)
+	assert.Equal(t, 0, server.getOpCount("LISTSTATUS"))
+	// invalid responses
+	server.setOnRequest(nil)
+	invalidServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("op") {
+		case "OPEN":
+			writeFakeHDFSResponse(w, http.StatusOK, map[string]string{})
+		case "CREATE":
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		default:
+			w.Write([]byte("not json")) //nolint:errcheck
+		}
+	}))
+	defer invalidServer.Close()
+
+	invalidFs, err := NewHDFSFs("connID", t.TempDir(), "", HDFSFsConfig{
+		Endpoint: invalidServer.URL,
+		Username: fakeHDFSUsername,
+	})
+	require.NoError(t, err)
+	defer invalidFs.Close()
+
+	fs = invalidFs.(*HDFSFs)
+
+	_, err = fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to decode WebHDFS GETFILESTATUS response")
+	_, err = downloadTestHDFSFile(t, fs, "/file", 0)
+	assert.ErrorContains(t, err, "missing location")
+	err = uploadTestHDFSFile(t, fs, "/file", []byte("data"))
+	assert.ErrorContains(t, err, "missing location header")
+	// the server is not reachable
+	fs = newTestHDFSFs(t, server, HDFSFsConfig{})
+	server.Close()
+	_, err = fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to send WebHDFS request")
+	assert.False(t, fs.IsNotExist(err))
+}
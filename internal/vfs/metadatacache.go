@@ -0,0 +1,180 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metadataCache = &statCache{
+		items: make(map[string]statCacheEntry),
+	}
+)
+
+// SetMetadataCache configures the stat cache for cloud storage backends.
+// A zero ttl, in seconds, disables the cache. The cache is shared by all the
+// connections and protocols, so clients that stat files before transferring them
+// don't generate a request to the storage backend for each call
+func SetMetadataCache(ttl, maxEntries int) {
+	metadataCache.configure(time.Duration(ttl)*time.Second, maxEntries)
+}
+
+type statCacheEntry struct {
+	info       os.FileInfo
+	expiration time.Time
+}
+
+// statCache caches the FileInfo for objects stored on cloud backends. Entries
+// are populated from stat calls and directory listings and are invalidated
+// on writes or when their TTL expires
+type statCache struct {
+	sync.RWMutex
+	ttl        time.Duration
+	maxEntries int
+	items      map[string]statCacheEntry
+}
+
+func (c *statCache) configure(ttl time.Duration, maxEntries int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ttl = ttl
+	c.maxEntries = maxEntries
+	c.items = make(map[string]statCacheEntry)
+}
+
+func (c *statCache) isEnabled() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.ttl > 0
+}
+
+func (c *statCache) getKey(backend, name string) string {
+	return backend + "\x00" + strings.TrimSuffix(name, "/")
+}
+
+func (c *statCache) get(backend, name string) (os.FileInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	entry, ok := c.items[c.getKey(backend, name)]
+	if !ok || time.Now().After(entry.expiration) {
+		return nil, false
+	}
+	return entry.info, true
+}
+
+func (c *statCache) add(backend, name string, info os.FileInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	key := c.getKey(backend, name)
+	if _, ok := c.items[key]; !ok && c.maxEntries > 0 && len(c.items) >= c.maxEntries {
+		c.evictLocked()
+	}
+	c.items[key] = statCacheEntry{
+		info:       info,
+		expiration: time.Now().Add(c.ttl),
+	}
+}
+
+// addListedFile caches a file returned by a directory listing, clients
+// often stat the listed files before downloading them
+func (c *statCache) addListedFile(backend, prefix, name string, size int64, modTime time.Time, etag string) {
+	if !c.isEnabled() {
+		return
+	}
+	fullName := prefix + name
+	info := NewFileInfo(fullName, false, size, modTime, false)
+	info.SetETag(etag)
+	c.add(backend, fullName, info)
+}
+
+// evictLocked removes the expired entries, if there are none it removes
+// the entries expiring first until there is room for a new entry
+func (c *statCache) evictLocked() {
+	now := time.Now()
+	for key, entry := range c.items {
+		if now.After(entry.expiration) {
+			delete(c.items, key)
+		}
+	}
+	for len(c.items) >= c.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for key, entry := range c.items {
+			if oldestKey == "" || entry.expiration.Before(oldest) {
+				oldestKey = key
+				oldest = entry.expiration
+			}
+		}
+		delete(c.items, oldestKey)
+	}
+}
+
+// invalidate removes the specified name and its parent directories from
+// the cache, the parents may no longer exist, for example if they are
+// virtual directories and the last object inside them was removed
+func (c *statCache) invalidate(backend, name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	name = strings.TrimSuffix(name, "/")
+	for {
+		delete(c.items, c.getKey(backend, name))
+		parent := path.Dir(name)
+		if parent == name || parent == "." || parent == "/" {
+			return
+		}
+		name = parent
+	}
+}
+
+// invalidateTree removes the specified directory, its contents and its
+// parent directories from the cache
+func (c *statCache) invalidateTree(backend, name string) {
+	c.invalidate(backend, name)
+
+	c.Lock()
+	defer c.Unlock()
+
+	prefix := c.getKey(backend, name) + "/"
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.items, key)
+		}
+	}
+}
+
+// getMetadataCacheBackend returns an identifier for a storage backend, the
+// cached entries are shared between filesystems with the same identifier
+func getMetadataCacheBackend(parts ...string) string {
+	return strings.Join(parts, "|")
+}
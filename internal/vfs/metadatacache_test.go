@@ -0,0 +1,163 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nos3
+// +build !nos3
+
+package vfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enableTestMetadataCache(t *testing.T, ttl, maxEntries int) {
+	SetMetadataCache(ttl, maxEntries)
+	t.Cleanup(func() {
+		SetMetadataCache(0, 0)
+	})
+}
+
+func TestStatCache(t *testing.T) {
+	c := &statCache{}
+	c.configure(0, 0)
+	c.add("b", "file", NewFileInfo("file", false, 1, time.Now(), false))
+	_, ok := c.get("b", "file")
+	assert.False(t, ok, "the cache is disabled")
+
+	c.configure(time.Minute, 3)
+	c.add("b", "a/b/c/file", NewFileInfo("file", false, 1, time.Now(), false))
+	c.add("b", "a/b/c", NewFileInfo("c", true, 0, time.Now(), false))
+	c.add("b", "a/", NewFileInfo("a", true, 0, time.Now(), false))
+	info, ok := c.get("b", "a")
+	require.True(t, ok, "the trailing slash is ignored")
+	assert.True(t, info.IsDir())
+	_, ok = c.get("other", "a")
+	assert.False(t, ok, "the backends are isolated")
+	// invalidating an object removes its parents
+	c.invalidate("b", "a/b/c/file")
+	assert.Len(t, c.items, 0)
+
+	c.add("b", "d", NewFileInfo("d", true, 0, time.Now(), false))
+	c.add("b", "d/f1", NewFileInfo("f1", false, 1, time.Now(), false))
+	c.add("b", "d/sub/f2", NewFileInfo("f2", false, 1, time.Now(), false))
+	c.invalidateTree("b", "d")
+	assert.Len(t, c.items, 0)
+	// the entries expiring first are evicted
+	for _, name := range []string{"f1", "f2", "f3", "f4"} {
+		c.add("b", name, NewFileInfo(name, false, 1, time.Now(), false))
+	}
+	assert.Len(t, c.items, 3)
+	_, ok = c.get("b", "f1")
+	assert.False(t, ok)
+	_, ok = c.get("b", "f4")
+	assert.True(t, ok)
+	// expired entries are not returned and they are evicted first
+	c.items[c.getKey("b", "f3")] = statCacheEntry{
+		info:       NewFileInfo("f3", false, 1, time.Now(), false),
+		expiration: time.Now().Add(-time.Second),
+	}
+	_, ok = c.get("b", "f3")
+	assert.False(t, ok)
+	c.add("b", "f5", NewFileInfo("f5", false, 1, time.Now(), false))
+	_, ok = c.get("b", "f2")
+	assert.True(t, ok)
+	_, ok = c.get("b", "f5")
+	assert.True(t, ok)
+}
+
+func TestS3StatCacheInvalidation(t *testing.T) {
+	enableTestMetadataCache(t, 60, 100)
+	server := newFakeS3Server(t)
+	fs := newTestS3Fs(t, server)
+	// another connection to the same bucket shares the cache
+	otherFs := newTestS3Fs(t, server)
+
+	uploadTestS3File(t, fs, "dir/file.txt", []byte("content"))
+	info, err := fs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), info.Size())
+	heads := server.getOpCount("HeadObject")
+	info, err = otherFs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), info.Size())
+	assert.Equal(t, heads, server.getOpCount("HeadObject"), "stat must be served from the cache")
+	// the objects returned by directory listings are cached
+	server.putObject("dir/listed.txt", []byte("listed"))
+	entries := listTestDir(t, fs, "dir")
+	assert.Len(t, entries, 2)
+	info, err = otherFs.Stat("dir/listed.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size())
+	assert.Equal(t, heads, server.getOpCount("HeadObject"))
+	// changes made outside SFTPGo are not visible until the entry expires
+	server.putObject("dir/listed.txt", []byte("changed outside"))
+	info, err = fs.Stat("dir/listed.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size())
+
+	// write
+	_, err = fs.Stat("dir")
+	require.NoError(t, err)
+	uploadTestS3File(t, otherFs, "dir/file.txt", []byte("new content"))
+	_, ok := metadataCache.get(fs.cacheBackend, "dir")
+	assert.False(t, ok, "the parent directories must be invalidated")
+	info, err = fs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+	assert.Greater(t, server.getOpCount("HeadObject"), heads)
+
+	// rename
+	_, err = fs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	_, _, err = otherFs.Rename("dir/file.txt", "dir/renamed.txt")
+	require.NoError(t, err)
+	_, err = fs.Stat("dir/file.txt")
+	assert.True(t, fs.IsNotExist(err), "got: %v", err)
+	info, err = fs.Stat("dir/renamed.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+
+	// delete
+	require.NoError(t, otherFs.Remove("dir/renamed.txt", false))
+	_, err = fs.Stat("dir/renamed.txt")
+	assert.True(t, fs.IsNotExist(err), "got: %v", err)
+	// copy
+	uploadTestS3File(t, fs, "dir/src.txt", []byte("src"))
+	_, err = fs.Stat("dir/dst.txt")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = otherFs.CopyFile("dir/src.txt", "dir/dst.txt", 3)
+	require.NoError(t, err)
+	info, err = fs.Stat("dir/dst.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), info.Size())
+	// metadata updates
+	_, err = fs.Stat("dir/dst.txt")
+	require.NoError(t, err)
+	require.NoError(t, otherFs.SetXattr("dir/dst.txt", "user.test", []byte("val")))
+	_, ok = metadataCache.get(fs.cacheBackend, "dir/dst.txt")
+	assert.False(t, ok)
+
+	// the cache is disabled
+	SetMetadataCache(0, 0)
+	heads = server.getOpCount("HeadObject")
+	_, err = fs.Stat("dir/dst.txt")
+	require.NoError(t, err)
+	_, err = fs.Stat("dir/dst.txt")
+	require.NoError(t, err)
+	assert.Equal(t, heads+2, server.getOpCount("HeadObject"))
+}
@@ -0,0 +1,1184 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noonedrive
+// +build !noonedrive
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/microsoft"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	oneDriveGraphScope             = "https://graph.microsoft.com/.default"
+	defaultOneDriveUploadPartSize  = 10
+	oneDriveSimpleUploadLimit      = 4 * 1024 * 1024
+	oneDriveUploadFragmentMultiple = 320 * 1024
+	oneDriveListLimit              = 1000
+	oneDriveMaxAttempts            = 3
+	oneDriveMaxRetryDelay          = 30 * time.Second
+	oneDriveDefaultRetryDelay      = time.Second
+	oneDriveCopyTimeout            = 15 * time.Minute
+	oneDriveMaxCopyPollDelay       = 5 * time.Second
+	oneDriveStatVFSBlockSize       = 4096
+	oneDriveStatVFSMaxFilenameSize = 255
+)
+
+var (
+	// the endpoints can be changed in test cases
+	oneDriveGraphEndpoint = "https://graph.microsoft.com/v1.0"
+	oneDriveAuthEndpoint  = microsoft.AzureADEndpoint
+	// access tokens are short-lived and shared between the connections
+	// using the same credentials
+	oneDriveTokens sync.Map
+)
+
+// OneDriveFs is a Fs implementation for OneDrive and SharePoint document
+// libraries based on the Microsoft Graph API.
+type OneDriveFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath string
+	config    *OneDriveFsConfig
+	// client adds the access token to the requests
+	client *http.Client
+	// httpClient is used for pre-authenticated URLs, such as download
+	// URLs, upload sessions and copy monitors
+	httpClient *http.Client
+	driveURL   string
+	ctxTimeout time.Duration
+}
+
+func init() {
+	version.AddFeature("+onedrive")
+}
+
+// NewOneDriveFs returns a OneDriveFs object that allows to interact with
+// OneDrive and SharePoint document libraries
+func NewOneDriveFs(connectionID, localTempDir, mountPath string, config OneDriveFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &OneDriveFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if !fs.config.ClientSecret.IsEmpty() {
+		if err := fs.config.ClientSecret.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if !fs.config.RefreshToken.IsEmpty() {
+		if err := fs.config.RefreshToken.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if fs.config.DriveID != "" {
+		fs.driveURL = oneDriveGraphEndpoint + "/drives/" + url.PathEscape(fs.config.DriveID)
+	} else {
+		fs.driveURL = oneDriveGraphEndpoint + "/me/drive"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: transport,
+		Timeout:   fs.ctxTimeout,
+	})
+	endpoint := oneDriveAuthEndpoint(fs.config.TenantID)
+	var src oauth2.TokenSource
+	var key string
+	if fs.config.RefreshToken.IsEmpty() {
+		ccConfig := &clientcredentials.Config{
+			ClientID:     fs.config.ClientID,
+			ClientSecret: fs.config.ClientSecret.GetPayload(),
+			TokenURL:     endpoint.TokenURL,
+			Scopes:       []string{oneDriveGraphScope},
+		}
+		src = ccConfig.TokenSource(ctx)
+		key = getOneDriveTokenKey(fs.config.TenantID, fs.config.ClientID, fs.config.ClientSecret.GetPayload())
+	} else {
+		oauthConfig := &oauth2.Config{
+			ClientID:     fs.config.ClientID,
+			ClientSecret: fs.config.ClientSecret.GetPayload(),
+			Endpoint:     endpoint,
+			Scopes:       []string{oneDriveGraphScope, "offline_access"},
+		}
+		src = oauthConfig.TokenSource(ctx, &oauth2.Token{RefreshToken: fs.config.RefreshToken.GetPayload()})
+		key = getOneDriveTokenKey(fs.config.TenantID, fs.config.ClientID, fs.config.RefreshToken.GetPayload())
+	}
+	fs.client = &http.Client{
+		Transport: &oauth2.Transport{
+			Source: &oneDriveTokenSource{
+				key: key,
+				src: src,
+			},
+			Base: transport,
+		},
+	}
+	fs.httpClient = &http.Client{
+		Transport: transport,
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *OneDriveFs) Name() string {
+	driveID := fs.config.DriveID
+	if driveID == "" {
+		driveID = "me"
+	}
+	return fmt.Sprintf("%s drive %q root %q", oneDriveFsName, driveID, fs.config.RootPath)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *OneDriveFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *OneDriveFs) Stat(name string) (os.FileInfo, error) {
+	if fs.isRoot(name) {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	item, err := fs.getItem(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return item.getFileInfo(), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *OneDriveFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *OneDriveFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		resp, err := fs.download(ctx, name, offset)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *OneDriveFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		n, err := fs.upload(ctx, name, r)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are moved server side including their contents
+func (fs *OneDriveFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	parent, err := fs.getItem(ctx, path.Dir(target))
+	if err != nil {
+		return -1, -1, err
+	}
+	arg := oneDriveRelocationArg{
+		ParentReference: oneDriveItemReference{ID: parent.ID},
+		Name:            path.Base(target),
+	}
+	err = fs.doJSON(ctx, http.MethodPatch, fs.getItemURL(source), arg, nil)
+	if !fs.isConflict(err) {
+		return -1, -1, err
+	}
+	// Graph never replaces existing items when moving, we only replace files
+	existing, errStat := fs.getItem(ctx, target)
+	if errStat != nil || existing.Folder != nil {
+		return -1, -1, err
+	}
+	fsLog(fs, logger.LevelDebug, "target %q exists, remove it before retrying the rename", target)
+	if err := fs.doJSON(ctx, http.MethodDelete, fs.getItemURL(target), nil, nil); err != nil {
+		return -1, -1, err
+	}
+	return -1, -1, fs.doJSON(ctx, http.MethodPatch, fs.getItemURL(source), arg, nil)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *OneDriveFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	if isDir {
+		// Graph removes folders recursively
+		item, err := fs.getItem(ctx, name)
+		if err != nil {
+			return err
+		}
+		if item.Folder != nil && item.Folder.ChildCount > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	return fs.doJSON(ctx, http.MethodDelete, fs.getItemURL(name), nil, nil)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *OneDriveFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.doJSON(ctx, http.MethodPost, fs.getItemURL(path.Dir(name))+"/children", map[string]any{
+		"name":                              path.Base(name),
+		"folder":                            struct{}{},
+		"@microsoft.graph.conflictBehavior": "fail",
+	}, nil)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*OneDriveFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*OneDriveFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*OneDriveFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*OneDriveFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *OneDriveFs) Chtimes(name string, _, mtime time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	return fs.doJSON(ctx, http.MethodPatch, fs.getItemURL(name), map[string]any{
+		"fileSystemInfo": map[string]string{
+			"lastModifiedDateTime": mtime.UTC().Format(time.RFC3339),
+		},
+	}, nil)
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*OneDriveFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *OneDriveFs) ReadDir(dirname string) (DirLister, error) {
+	result, err := fs.listChildren(fs.getChildrenURL(dirname))
+	if err != nil {
+		return nil, err
+	}
+	l := &oneDriveDirLister{
+		fs:       fs,
+		nextLink: result.NextLink,
+	}
+	l.addItems(result.Value)
+	return l, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on OneDrive
+func (*OneDriveFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*OneDriveFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// OneDrive uploads are already atomic, the file is committed only after
+// the upload completes
+func (*OneDriveFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*OneDriveFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	var apiErr *oneDriveError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*OneDriveFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+	var apiErr *oneDriveError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusUnauthorized || apiErr.statusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*OneDriveFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrVfsUnsupported)
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *OneDriveFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *OneDriveFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.RootPath)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders.
+// The drive root is scanned using a delta query that returns all the items
+// using few requests, delta queries on folders are not supported for
+// SharePoint and OneDrive for Business so folders are listed recursively
+func (fs *OneDriveFs) GetDirSize(dirname string) (int, int64, error) {
+	if fs.isRoot(dirname) {
+		return fs.getDriveSize()
+	}
+	numFiles := 0
+	size := int64(0)
+	dirs := []string{dirname}
+
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		result, err := fs.listChildren(fs.getChildrenURL(dir))
+		for {
+			if err != nil {
+				return numFiles, size, err
+			}
+			for idx := range result.Value {
+				item := &result.Value[idx]
+				if item.Folder != nil {
+					dirs = append(dirs, path.Join(dir, item.Name))
+				} else if item.File != nil {
+					numFiles++
+					size += item.Size
+				}
+			}
+			if result.NextLink == "" {
+				break
+			}
+			result, err = fs.listChildren(result.NextLink)
+		}
+		fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+	}
+	return numFiles, size, nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// OneDrive uploads are already atomic, we never call this method
+func (*OneDriveFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *OneDriveFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.RootPath != "/" {
+		if !strings.HasPrefix(rel, fs.config.RootPath) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.RootPath))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *OneDriveFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*OneDriveFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*OneDriveFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *OneDriveFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.RootPath, virtualPath), nil
+}
+
+// CopyFile implements the FsFileCopier interface.
+// Graph copies items asynchronously, we wait for the copy to complete
+func (fs *OneDriveFs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	numFiles := 1
+	sizeDiff := srcSize
+	info, err := fs.Stat(target)
+	if err == nil {
+		sizeDiff -= info.Size()
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if err := fs.copyItem(source, target); err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *OneDriveFs) GetMimeType(name string) (string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	item, err := fs.getItem(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if item.File != nil && item.File.MimeType != "" {
+		return item.File.MimeType, nil
+	}
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// Close closes the fs
+func (fs *OneDriveFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *OneDriveFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var drive oneDriveDrive
+	if err := fs.doJSON(ctx, http.MethodGet, fs.driveURL+"?$select=quota", nil, &drive); err != nil {
+		return nil, err
+	}
+	if drive.Quota.Total == 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	return &sftp.StatVFS{
+		Bsize:   oneDriveStatVFSBlockSize,
+		Frsize:  oneDriveStatVFSBlockSize,
+		Blocks:  drive.Quota.Total / oneDriveStatVFSBlockSize,
+		Bfree:   drive.Quota.Remaining / oneDriveStatVFSBlockSize,
+		Bavail:  drive.Quota.Remaining / oneDriveStatVFSBlockSize,
+		Namemax: oneDriveStatVFSMaxFilenameSize,
+	}, nil
+}
+
+func (*OneDriveFs) isRoot(name string) bool {
+	return name == "" || name == "/" || name == "."
+}
+
+// getItemURL returns the URL to address the specified item by path
+func (fs *OneDriveFs) getItemURL(name string) string {
+	if fs.isRoot(name) {
+		return fs.driveURL + "/root"
+	}
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for idx := range parts {
+		parts[idx] = url.PathEscape(parts[idx])
+	}
+	return fs.driveURL + "/root:/" + strings.Join(parts, "/") + ":"
+}
+
+func (fs *OneDriveFs) getChildrenURL(name string) string {
+	return fs.getItemURL(name) + "/children?$top=" + strconv.Itoa(oneDriveListLimit)
+}
+
+func (fs *OneDriveFs) getUploadPartSize() int64 {
+	partSize := int64(defaultOneDriveUploadPartSize)
+	if fs.config.UploadPartSize > 0 {
+		partSize = fs.config.UploadPartSize
+	}
+	// upload fragments must be a multiple of 320 KiB
+	partSize *= 1024 * 1024
+	return partSize - partSize%oneDriveUploadFragmentMultiple
+}
+
+func (fs *OneDriveFs) getItem(ctx context.Context, name string) (*oneDriveItem, error) {
+	var item oneDriveItem
+	if err := fs.doJSON(ctx, http.MethodGet, fs.getItemURL(name), nil, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (fs *OneDriveFs) listChildren(pageURL string) (*oneDriveItemsPage, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	var result oneDriveItemsPage
+	err := fs.doJSON(ctx, http.MethodGet, pageURL, nil, &result)
+	return &result, err
+}
+
+// getDriveSize returns the number of files and their size for the whole
+// drive using a delta query
+func (fs *OneDriveFs) getDriveSize() (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+
+	result, err := fs.listChildren(fs.getItemURL("/") + "/delta?$select=id,size,file,folder,deleted")
+	for {
+		if err != nil {
+			return numFiles, size, err
+		}
+		for idx := range result.Value {
+			item := &result.Value[idx]
+			if item.File != nil && item.Deleted == nil {
+				numFiles++
+				size += item.Size
+			}
+		}
+		if result.NextLink == "" {
+			return numFiles, size, nil
+		}
+		fsLog(fs, logger.LevelDebug, "drive scan in progress, files: %d, size: %d", numFiles, size)
+		result, err = fs.listChildren(result.NextLink)
+	}
+}
+
+// upload reads the data to upload in chunks. Small files are uploaded
+// using a single request, bigger files are uploaded using an upload session.
+// Upload sessions require the total size, so if the data does not fit in a
+// single chunk it is stored in a local temporary file before uploading
+func (fs *OneDriveFs) upload(ctx context.Context, name string, r io.Reader) (int64, error) {
+	buf := make([]byte, fs.getUploadPartSize())
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if n <= oneDriveSimpleUploadLimit {
+			return int64(n), fs.uploadSimple(ctx, name, buf[:n])
+		}
+		return int64(n), fs.uploadSession(ctx, name, bytes.NewReader(buf[:n]), int64(n))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(fs.localTempDir, "onedrive_upload_")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(buf[:n]); err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, r)
+	size := int64(n) + written
+	if err != nil {
+		return size, err
+	}
+	return size, fs.uploadSession(ctx, name, f, size)
+}
+
+func (fs *OneDriveFs) uploadSimple(ctx context.Context, name string, data []byte) error {
+	resp, err := fs.sendRequest(ctx, fs.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, fs.getItemURL(name)+"/content", bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadSession uploads the data from src using an upload session.
+// Upload URLs are pre-authenticated, the access token must not be sent
+func (fs *OneDriveFs) uploadSession(ctx context.Context, name string, src io.ReaderAt, size int64) error {
+	var session oneDriveUploadSession
+	err := fs.doJSON(ctx, http.MethodPost, fs.getItemURL(name)+"/createUploadSession", map[string]any{
+		"item": map[string]string{
+			"@microsoft.graph.conflictBehavior": "replace",
+		},
+	}, &session)
+	if err != nil {
+		return err
+	}
+	partSize := fs.getUploadPartSize()
+	for offset := int64(0); offset < size; offset += partSize {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		resp, err := fs.sendRequest(ctx, fs.httpClient, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPut, session.UploadURL, io.NewSectionReader(src, offset, length))
+			if err != nil {
+				return nil, err
+			}
+			req.ContentLength = length
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+			return req, nil
+		})
+		if err != nil {
+			fs.cancelUploadSession(session.UploadURL)
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (fs *OneDriveFs) cancelUploadSession(uploadURL string) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, fs.httpClient, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, uploadURL, nil)
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to cancel upload session: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (fs *OneDriveFs) download(ctx context.Context, name string, offset int64) (*http.Response, error) {
+	item, err := fs.getItem(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if item.DownloadURL == "" {
+		return nil, fmt.Errorf("unable to get the download URL for %q", name)
+	}
+	return fs.sendRequest(ctx, fs.httpClient, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, item.DownloadURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		return req, nil
+	})
+}
+
+// copyItem starts an asynchronous copy and polls the returned monitor URL
+// until the copy completes
+func (fs *OneDriveFs) copyItem(source, target string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(oneDriveCopyTimeout))
+	defer cancelFn()
+
+	parent, err := fs.getItem(ctx, path.Dir(target))
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(oneDriveRelocationArg{
+		ParentReference: oneDriveItemReference{
+			DriveID: parent.ParentReference.DriveID,
+			ID:      parent.ID,
+		},
+		Name: path.Base(target),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := fs.sendRequest(ctx, fs.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, fs.getItemURL(source)+"/copy?@microsoft.graph.conflictBehavior=replace",
+			bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	monitorURL := resp.Header.Get("Location")
+	if monitorURL == "" {
+		return fmt.Errorf("unable to copy %q to %q: no monitor URL returned", source, target)
+	}
+	// the monitor redirects to the copied item once completed, the item URL
+	// requires authentication and we don't need it
+	client := *fs.httpClient
+	client.CheckRedirect = func(_ *http.Request, _ []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	delay := oneDriveDefaultRetryDelay
+	for {
+		status, err := fs.getCopyStatus(ctx, &client, monitorURL)
+		if err != nil {
+			return err
+		}
+		switch status.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return fmt.Errorf("unable to copy %q to %q: %s", source, target, status.Error.Message)
+		}
+		fsLog(fs, logger.LevelDebug, "copy from %q to %q in progress, status %q, completed: %.2f%%",
+			source, target, status.Status, status.PercentageComplete)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < oneDriveMaxCopyPollDelay {
+			delay += time.Second
+		}
+	}
+}
+
+func (fs *OneDriveFs) getCopyStatus(ctx context.Context, client *http.Client, monitorURL string) (*oneDriveCopyStatus, error) {
+	resp, err := fs.sendRequest(ctx, client, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, monitorURL, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return &oneDriveCopyStatus{Status: "completed"}, nil
+	}
+	var status oneDriveCopyStatus
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(&status)
+	return &status, err
+}
+
+func (fs *OneDriveFs) isConflict(err error) bool {
+	var apiErr *oneDriveError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusConflict
+	}
+	return false
+}
+
+// doJSON sends an authenticated request, the arguments and the result, if
+// any, are JSON encoded
+func (fs *OneDriveFs) doJSON(ctx context.Context, method, reqURL string, arg, result any) error {
+	var body []byte
+	if arg != nil {
+		var err error
+		body, err = json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := fs.sendRequest(ctx, fs.client, func() (*http.Request, error) {
+		req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if arg != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize*10)).Decode(result)
+}
+
+// sendRequest sends the request returned by newRequest using the specified
+// client. Throttled requests and transient server errors are retried, the
+// request body must be replayable.
+// Redirect responses are returned to the caller if the client does not
+// follow them
+func (fs *OneDriveFs) sendRequest(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error),
+) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("unable to send OneDrive request %q: %w", req.URL.Path, err)
+		}
+		if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		err = getOneDriveError(resp)
+		if attempt >= oneDriveMaxAttempts || !isOneDriveRetryable(resp.StatusCode) {
+			return nil, err
+		}
+		delay := getOneDriveRetryDelay(resp)
+		fsLog(fs, logger.LevelDebug, "request %q failed, attempt %d, retry in %s: %v", req.URL.Path, attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *OneDriveFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+func isOneDriveRetryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func getOneDriveRetryDelay(resp *http.Response) time.Duration {
+	delay := oneDriveDefaultRetryDelay
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+	if delay > oneDriveMaxRetryDelay {
+		delay = oneDriveMaxRetryDelay
+	}
+	return delay
+}
+
+func getOneDriveError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPFsResponseSize))
+	apiErr := &oneDriveError{
+		statusCode: resp.StatusCode,
+	}
+	var errResponse struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResponse); err == nil && errResponse.Error.Code != "" {
+		apiErr.code = errResponse.Error.Code
+		apiErr.message = errResponse.Error.Message
+	} else {
+		apiErr.message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+func getOneDriveTokenKey(tenantID, clientID, credential string) string {
+	h := sha256.Sum256([]byte(tenantID + "\x00" + clientID + "\x00" + credential))
+	return hex.EncodeToString(h[:])
+}
+
+// oneDriveTokenSource returns the cached access token, if valid, or a new one
+// obtained using the configured credentials
+type oneDriveTokenSource struct {
+	key string
+	src oauth2.TokenSource
+}
+
+func (s *oneDriveTokenSource) Token() (*oauth2.Token, error) {
+	if val, ok := oneDriveTokens.Load(s.key); ok {
+		if token := val.(*oauth2.Token); token.Valid() {
+			return token, nil
+		}
+	}
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get OneDrive access token: %w", err)
+	}
+	oneDriveTokens.Store(s.key, token)
+	return token, nil
+}
+
+type oneDriveError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+func (e *oneDriveError) Error() string {
+	return fmt.Sprintf("OneDrive API error, status code: %d, code: %q, message: %q", e.statusCode, e.code, e.message)
+}
+
+type oneDriveItemReference struct {
+	DriveID string `json:"driveId,omitempty"`
+	ID      string `json:"id,omitempty"`
+}
+
+type oneDriveRelocationArg struct {
+	ParentReference oneDriveItemReference `json:"parentReference"`
+	Name            string                `json:"name"`
+}
+
+type oneDriveUploadSession struct {
+	UploadURL string `json:"uploadUrl"`
+}
+
+type oneDriveCopyStatus struct {
+	Status             string  `json:"status"`
+	PercentageComplete float64 `json:"percentageComplete"`
+	Error              struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type oneDriveDrive struct {
+	Quota struct {
+		Total     uint64 `json:"total"`
+		Used      uint64 `json:"used"`
+		Remaining uint64 `json:"remaining"`
+	} `json:"quota"`
+}
+
+type oneDriveItemsPage struct {
+	Value    []oneDriveItem `json:"value"`
+	NextLink string         `json:"@odata.nextLink"`
+}
+
+type oneDriveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	ETag                 string    `json:"eTag"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	DownloadURL          string    `json:"@microsoft.graph.downloadUrl"`
+	File                 *struct {
+		MimeType string `json:"mimeType"`
+	} `json:"file"`
+	Folder *struct {
+		ChildCount int `json:"childCount"`
+	} `json:"folder"`
+	Deleted        *struct{} `json:"deleted"`
+	FileSystemInfo struct {
+		LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	} `json:"fileSystemInfo"`
+	ParentReference oneDriveItemReference `json:"parentReference"`
+}
+
+func (i *oneDriveItem) getFileInfo() *FileInfo {
+	modTime := i.FileSystemInfo.LastModifiedDateTime
+	if modTime.IsZero() {
+		modTime = i.LastModifiedDateTime
+	}
+	if i.Folder != nil {
+		return NewFileInfo(i.Name, true, 0, modTime, false)
+	}
+	info := NewFileInfo(i.Name, false, i.Size, modTime, false)
+	if i.ETag != "" {
+		info.SetETag(`"` + strings.Trim(i.ETag, `"`) + `"`)
+	}
+	return info
+}
+
+type oneDriveDirLister struct {
+	baseDirLister
+	fs       *OneDriveFs
+	nextLink string
+}
+
+// addItems adds files and folders to the cache, other items such as
+// OneNote packages are skipped
+func (l *oneDriveDirLister) addItems(items []oneDriveItem) {
+	for idx := range items {
+		if items[idx].File != nil || items[idx].Folder != nil {
+			l.cache = append(l.cache, items[idx].getFileInfo())
+		}
+	}
+}
+
+func (l *oneDriveDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && l.nextLink != "" {
+		result, err := l.fs.listChildren(l.nextLink)
+		if err != nil {
+			return l.cache, err
+		}
+		l.nextLink = result.NextLink
+		l.addItems(result.Value)
+	}
+	if len(l.cache) >= limit || l.nextLink != "" {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
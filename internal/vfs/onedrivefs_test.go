@@ -0,0 +1,1036 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noonedrive
+// +build !noonedrive
+
+package vfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeOneDriveRefreshToken = "refreshToken"
+	fakeOneDriveClientSecret = "clientSecret"
+	fakeOneDriveAccessToken  = "accessToken"
+	fakeOneDriveDriveID      = "driveID"
+	fakeOneDriveRootID       = "rootID"
+	fakeOneDriveQuotaTotal   = 10 * 1024 * 1024
+	fakeOneDriveDeltaPage    = 200
+)
+
+type fakeOneDriveItem struct {
+	id      string
+	isDir   bool
+	data    []byte
+	modTime time.Time
+}
+
+type fakeOneDriveUploadSession struct {
+	name string
+	data []byte
+}
+
+// fakeOneDriveServer is an in memory implementation of the Microsoft Graph
+// API subset used by OneDriveFs, including the token endpoint, the
+// pre-authenticated download and upload URLs and the copy monitors
+type fakeOneDriveServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// the items keyed by path, the root folder is implicit
+	items    map[string]*fakeOneDriveItem
+	sessions map[string]*fakeOneDriveUploadSession
+	idx      int
+	ops      map[string]int
+	// if set the copy monitors report a failure
+	copyError string
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeOneDriveServer(t *testing.T) *fakeOneDriveServer {
+	s := &fakeOneDriveServer{
+		items:    make(map[string]*fakeOneDriveItem),
+		sessions: make(map[string]*fakeOneDriveUploadSession),
+		ops:      make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeOneDriveServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeOneDriveServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeOneDriveServer) setCopyError(val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.copyError = val
+}
+
+func (s *fakeOneDriveServer) getItem(name string) (fakeOneDriveItem, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[name]
+	if !ok {
+		return fakeOneDriveItem{}, false
+	}
+	return *item, true
+}
+
+func (s *fakeOneDriveServer) getSessionsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
+
+func (s *fakeOneDriveServer) putFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addItemLocked(name, false, data)
+}
+
+// addItemLocked adds the specified item, the parent folders are created if
+// missing as Graph does for uploads
+func (s *fakeOneDriveServer) addItemLocked(name string, isDir bool, data []byte) *fakeOneDriveItem {
+	if dir := path.Dir(name); dir != "/" {
+		if _, ok := s.items[dir]; !ok {
+			s.addItemLocked(dir, true, nil)
+		}
+	}
+	s.idx++
+	item := &fakeOneDriveItem{
+		id:      fmt.Sprintf("id%d", s.idx),
+		isDir:   isDir,
+		data:    data,
+		modTime: time.Now().UTC().Truncate(time.Second),
+	}
+	s.items[name] = item
+	return item
+}
+
+func (s *fakeOneDriveServer) getPathByIDLocked(id string) (string, bool) {
+	if id == fakeOneDriveRootID {
+		return "/", true
+	}
+	for p, item := range s.items {
+		if item.id == id {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// getSubtreeLocked returns the specified path and all its descendants
+func (s *fakeOneDriveServer) getSubtreeLocked(name string) []string {
+	var result []string
+	for p := range s.items {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (s *fakeOneDriveServer) getChildrenLocked(name string, recursive bool) []string {
+	var result []string
+	for p := range s.items {
+		if p == name || !strings.HasPrefix(p, strings.TrimSuffix(name, "/")+"/") {
+			continue
+		}
+		if recursive || path.Dir(p) == name {
+			result = append(result, p)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s *fakeOneDriveServer) getItemJSONLocked(name string) map[string]any {
+	if name == "/" {
+		return map[string]any{
+			"id":     fakeOneDriveRootID,
+			"name":   "root",
+			"folder": map[string]any{"childCount": len(s.getChildrenLocked("/", false))},
+			"parentReference": map[string]any{
+				"driveId": fakeOneDriveDriveID,
+			},
+		}
+	}
+	item := s.items[name]
+	parentID := fakeOneDriveRootID
+	if parent, ok := s.items[path.Dir(name)]; ok {
+		parentID = parent.id
+	}
+	result := map[string]any{
+		"id":                   item.id,
+		"name":                 path.Base(name),
+		"lastModifiedDateTime": item.modTime.Add(time.Hour).Format(time.RFC3339),
+		"fileSystemInfo": map[string]any{
+			"lastModifiedDateTime": item.modTime.Format(time.RFC3339),
+		},
+		"parentReference": map[string]any{
+			"driveId": fakeOneDriveDriveID,
+			"id":      parentID,
+		},
+	}
+	if item.isDir {
+		result["folder"] = map[string]any{"childCount": len(s.getChildrenLocked(name, false))}
+		return result
+	}
+	result["size"] = len(item.data)
+	result["eTag"] = fmt.Sprintf(`"{%s},%d"`, item.id, len(item.data))
+	result["file"] = map[string]any{"mimeType": "application/octet-stream"}
+	result["@microsoft.graph.downloadUrl"] = s.URL + "/download/" + item.id
+	return result
+}
+
+// parseItemPath returns the item path and the action for the specified
+// request path, for example /v1.0/me/drive/root:/dir/file:/content
+func (*fakeOneDriveServer) parseItemPath(p string) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(p, "/v1.0/me/drive"):
+		p = strings.TrimPrefix(p, "/v1.0/me/drive")
+	case strings.HasPrefix(p, "/v1.0/drives/"+fakeOneDriveDriveID):
+		p = strings.TrimPrefix(p, "/v1.0/drives/"+fakeOneDriveDriveID)
+	default:
+		return "", "", false
+	}
+	if p == "" {
+		return "", "drive", true
+	}
+	if p == "/root" {
+		return "/", "", true
+	}
+	if action, ok := strings.CutPrefix(p, "/root/"); ok {
+		return "/", action, true
+	}
+	itemPath, ok := strings.CutPrefix(p, "/root:")
+	if !ok {
+		return "", "", false
+	}
+	itemPath, action, ok := strings.Cut(itemPath, ":")
+	if !ok {
+		return "", "", false
+	}
+	return path.Clean(itemPath), strings.TrimPrefix(action, "/"), true
+}
+
+func (s *fakeOneDriveServer) getOp(r *http.Request) string {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/token"):
+		return "token"
+	case strings.HasPrefix(r.URL.Path, "/download/"):
+		return "download"
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		if r.Method == http.MethodDelete {
+			return "upload.cancel"
+		}
+		return "upload.fragment"
+	case strings.HasPrefix(r.URL.Path, "/monitor/"):
+		return "monitor"
+	}
+	_, action, ok := s.parseItemPath(r.URL.Path)
+	if !ok {
+		return "unknown"
+	}
+	switch action {
+	case "drive":
+		return "drive.get"
+	case "children":
+		if r.Method == http.MethodPost {
+			return "item.create"
+		}
+		return "item.children"
+	case "":
+		switch r.Method {
+		case http.MethodPatch:
+			return "item.update"
+		case http.MethodDelete:
+			return "item.delete"
+		}
+		return "item.get"
+	}
+	return "item." + action
+}
+
+func (s *fakeOneDriveServer) handle(w http.ResponseWriter, r *http.Request) {
+	op := s.getOp(r)
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			writeFakeOneDriveError(w, status, "injectedError")
+			return
+		}
+	}
+	switch op {
+	case "token":
+		s.handleToken(w, r)
+		return
+	case "download", "upload.fragment", "upload.cancel", "monitor":
+		// pre-authenticated URLs
+		if r.Header.Get("Authorization") != "" {
+			writeFakeOneDriveError(w, http.StatusUnauthorized, "unauthenticated")
+			return
+		}
+	default:
+		if r.Header.Get("Authorization") != "Bearer "+fakeOneDriveAccessToken {
+			writeFakeOneDriveError(w, http.StatusUnauthorized, "InvalidAuthenticationToken")
+			return
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case "download":
+		s.handleDownload(w, r)
+		return
+	case "upload.fragment", "upload.cancel":
+		s.handleUploadFragment(w, r, body)
+		return
+	case "monitor":
+		if s.copyError != "" {
+			writeFakeOneDriveJSON(w, http.StatusAccepted, map[string]any{
+				"status": "failed",
+				"error":  map[string]any{"message": s.copyError},
+			})
+			return
+		}
+		w.Header().Set("Location", s.URL+"/v1.0/drives/"+fakeOneDriveDriveID+"/items/"+
+			strings.TrimPrefix(r.URL.Path, "/monitor/"))
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	case "drive.get":
+		var used uint64
+		for _, item := range s.items {
+			used += uint64(len(item.data))
+		}
+		writeFakeOneDriveJSON(w, http.StatusOK, map[string]any{
+			"quota": map[string]any{
+				"total":     fakeOneDriveQuotaTotal,
+				"used":      used,
+				"remaining": fakeOneDriveQuotaTotal - used,
+			},
+		})
+		return
+	}
+
+	name, _, ok := s.parseItemPath(r.URL.Path)
+	if !ok {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	if op == "item.content" || op == "item.createUploadSession" {
+		s.handleUpload(w, op, name, body)
+		return
+	}
+	if _, ok := s.items[name]; !ok && name != "/" {
+		writeFakeOneDriveError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	switch op {
+	case "item.get":
+		writeFakeOneDriveJSON(w, http.StatusOK, s.getItemJSONLocked(name))
+	case "item.children", "item.delta":
+		s.handleList(w, r, name, op == "item.delta")
+	case "item.create":
+		var arg map[string]any
+		if err := json.Unmarshal(body, &arg); err != nil {
+			writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		childName, _ := arg["name"].(string)
+		childPath := path.Join(name, childName)
+		if _, ok := s.items[childPath]; ok {
+			writeFakeOneDriveError(w, http.StatusConflict, "nameAlreadyExists")
+			return
+		}
+		s.addItemLocked(childPath, true, nil)
+		writeFakeOneDriveJSON(w, http.StatusCreated, s.getItemJSONLocked(childPath))
+	case "item.update":
+		s.handleUpdate(w, name, body)
+	case "item.delete":
+		if name == "/" {
+			writeFakeOneDriveError(w, http.StatusForbidden, "accessDenied")
+			return
+		}
+		for _, p := range s.getSubtreeLocked(name) {
+			delete(s.items, p)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "item.copy":
+		s.handleCopy(w, r, name, body)
+	default:
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+	}
+}
+
+func (*fakeOneDriveServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFakeOneDriveJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+	clientSecret := r.PostForm.Get("client_secret")
+	if _, password, ok := r.BasicAuth(); ok {
+		clientSecret = password
+	}
+	valid := false
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		valid = r.PostForm.Get("refresh_token") == fakeOneDriveRefreshToken
+	case "client_credentials":
+		valid = clientSecret == fakeOneDriveClientSecret && strings.HasPrefix(r.URL.Path, "/tenant/") &&
+			r.PostForm.Get("scope") == oneDriveGraphScope
+	}
+	if !valid {
+		writeFakeOneDriveJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+		return
+	}
+	writeFakeOneDriveJSON(w, http.StatusOK, map[string]any{
+		"access_token": fakeOneDriveAccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (s *fakeOneDriveServer) handleList(w http.ResponseWriter, r *http.Request, name string, isDelta bool) {
+	if name != "/" && !s.items[name].isDir {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	query := r.URL.Query()
+	top := fakeOneDriveDeltaPage
+	if !isDelta {
+		val, err := strconv.Atoi(query.Get("$top"))
+		if err != nil || val <= 0 {
+			writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		top = val
+	}
+	var values []map[string]any
+	if isDelta {
+		if name != "/" {
+			writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		// deleted items are included in delta queries
+		values = append(values, map[string]any{
+			"id":      "deletedID",
+			"size":    1000,
+			"file":    map[string]any{},
+			"deleted": map[string]any{"state": "deleted"},
+		})
+	}
+	for _, p := range s.getChildrenLocked(name, isDelta) {
+		values = append(values, s.getItemJSONLocked(p))
+	}
+	offset := 0
+	if token := query.Get("$skiptoken"); token != "" {
+		val, err := strconv.Atoi(token)
+		if err != nil || val > len(values) {
+			writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		offset = val
+	}
+	result := map[string]any{
+		"value": values[offset:min(offset+top, len(values))],
+	}
+	if offset+top < len(values) {
+		query.Set("$skiptoken", strconv.Itoa(offset+top))
+		result["@odata.nextLink"] = s.URL + r.URL.Path + "?" + query.Encode()
+	}
+	writeFakeOneDriveJSON(w, http.StatusOK, result)
+}
+
+func (s *fakeOneDriveServer) handleUpdate(w http.ResponseWriter, name string, body []byte) {
+	var arg struct {
+		ParentReference *oneDriveItemReference `json:"parentReference"`
+		Name            string                 `json:"name"`
+		FileSystemInfo  *struct {
+			LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+		} `json:"fileSystemInfo"`
+	}
+	if err := json.Unmarshal(body, &arg); err != nil || name == "/" {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	if arg.FileSystemInfo != nil {
+		s.items[name].modTime = arg.FileSystemInfo.LastModifiedDateTime
+	}
+	if arg.ParentReference != nil {
+		parentPath, ok := s.getPathByIDLocked(arg.ParentReference.ID)
+		if !ok {
+			writeFakeOneDriveError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		target := path.Join(parentPath, arg.Name)
+		if _, ok := s.items[target]; ok {
+			writeFakeOneDriveError(w, http.StatusConflict, "nameAlreadyExists")
+			return
+		}
+		if strings.HasPrefix(target, name+"/") {
+			writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		for _, p := range s.getSubtreeLocked(name) {
+			s.items[target+strings.TrimPrefix(p, name)] = s.items[p]
+			delete(s.items, p)
+		}
+		name = target
+	}
+	writeFakeOneDriveJSON(w, http.StatusOK, s.getItemJSONLocked(name))
+}
+
+func (s *fakeOneDriveServer) handleCopy(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	var arg oneDriveRelocationArg
+	if err := json.Unmarshal(body, &arg); err != nil || s.items[name] == nil || s.items[name].isDir {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	if arg.ParentReference.DriveID != fakeOneDriveDriveID {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	parentPath, ok := s.getPathByIDLocked(arg.ParentReference.ID)
+	if !ok {
+		writeFakeOneDriveError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	target := path.Join(parentPath, arg.Name)
+	if existing, ok := s.items[target]; ok {
+		if existing.isDir || r.URL.Query().Get("@microsoft.graph.conflictBehavior") != "replace" {
+			writeFakeOneDriveError(w, http.StatusConflict, "nameAlreadyExists")
+			return
+		}
+	}
+	item := s.addItemLocked(target, false, append([]byte(nil), s.items[name].data...))
+	w.Header().Set("Location", s.URL+"/monitor/"+item.id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *fakeOneDriveServer) handleDownload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/download/")
+	name, ok := s.getPathByIDLocked(id)
+	if !ok || s.items[name] == nil || s.items[name].isDir {
+		writeFakeOneDriveError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	data := s.items[name].data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeOneDriveError(w, http.StatusRequestedRangeNotSatisfiable, "invalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func (s *fakeOneDriveServer) handleUpload(w http.ResponseWriter, op, name string, body []byte) {
+	if existing, ok := s.items[name]; (ok && existing.isDir) || name == "/" {
+		writeFakeOneDriveError(w, http.StatusConflict, "nameAlreadyExists")
+		return
+	}
+	if op == "item.content" {
+		if len(body) > oneDriveSimpleUploadLimit {
+			writeFakeOneDriveError(w, http.StatusRequestEntityTooLarge, "requestTooLarge")
+			return
+		}
+		s.addItemLocked(name, false, body)
+		writeFakeOneDriveJSON(w, http.StatusCreated, s.getItemJSONLocked(name))
+		return
+	}
+	var arg struct {
+		Item map[string]string `json:"item"`
+	}
+	if err := json.Unmarshal(body, &arg); err != nil || arg.Item["@microsoft.graph.conflictBehavior"] != "replace" {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRequest")
+		return
+	}
+	s.idx++
+	id := fmt.Sprintf("session%d", s.idx)
+	s.sessions[id] = &fakeOneDriveUploadSession{name: name}
+	writeFakeOneDriveJSON(w, http.StatusOK, map[string]any{
+		"uploadUrl":          s.URL + "/upload/" + id,
+		"expirationDateTime": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *fakeOneDriveServer) handleUploadFragment(w http.ResponseWriter, r *http.Request, body []byte) {
+	id := strings.TrimPrefix(r.URL.Path, "/upload/")
+	session, ok := s.sessions[id]
+	if !ok {
+		writeFakeOneDriveError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		delete(s.sessions, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var start, end, total int
+	_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total)
+	if err != nil || start != len(session.data) || end-start+1 != len(body) {
+		writeFakeOneDriveError(w, http.StatusRequestedRangeNotSatisfiable, "invalidRange")
+		return
+	}
+	// all the fragments except the last one must be a multiple of 320 KiB
+	if end+1 < total && len(body)%oneDriveUploadFragmentMultiple != 0 {
+		writeFakeOneDriveError(w, http.StatusBadRequest, "invalidRange")
+		return
+	}
+	session.data = append(session.data, body...)
+	if len(session.data) < total {
+		writeFakeOneDriveJSON(w, http.StatusAccepted, map[string]any{
+			"nextExpectedRanges": []string{fmt.Sprintf("%d-", len(session.data))},
+		})
+		return
+	}
+	delete(s.sessions, id)
+	s.addItemLocked(session.name, false, session.data)
+	writeFakeOneDriveJSON(w, http.StatusCreated, s.getItemJSONLocked(session.name))
+}
+
+func writeFakeOneDriveJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+func writeFakeOneDriveError(w http.ResponseWriter, status int, code string) {
+	writeFakeOneDriveJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": code + " error",
+		},
+	})
+}
+
+func newTestOneDriveFs(t *testing.T, server *fakeOneDriveServer, config OneDriveFsConfig) *OneDriveFs {
+	graphEndpoint := oneDriveGraphEndpoint
+	authEndpoint := oneDriveAuthEndpoint
+	oneDriveGraphEndpoint = server.URL + "/v1.0"
+	oneDriveAuthEndpoint = func(tenant string) oauth2.Endpoint {
+		if tenant == "" {
+			tenant = "common"
+		}
+		return oauth2.Endpoint{
+			AuthURL:  server.URL + "/" + tenant + "/authorize",
+			TokenURL: server.URL + "/" + tenant + "/token",
+		}
+	}
+	t.Cleanup(func() {
+		oneDriveGraphEndpoint = graphEndpoint
+		oneDriveAuthEndpoint = authEndpoint
+	})
+
+	// access tokens are cached by client ID
+	config.ClientID = t.Name()
+	if config.RefreshToken == nil && config.TenantID == "" {
+		config.RefreshToken = kms.NewPlainSecret(fakeOneDriveRefreshToken)
+	}
+	fs, err := NewOneDriveFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*OneDriveFs)
+}
+
+func uploadTestOneDriveFile(t *testing.T, fs *OneDriveFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestOneDriveFile(t *testing.T, fs *OneDriveFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestOneDriveFsBasicOperations(t *testing.T) {
+	server := newFakeOneDriveServer(t)
+	fs := newTestOneDriveFs(t, server, OneDriveFsConfig{})
+
+	require.NoError(t, fs.Mkdir("/dir"))
+	err := fs.Mkdir("/dir")
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+	require.NoError(t, uploadTestOneDriveFile(t, fs, "/dir/file.txt", []byte("content")))
+	assert.Equal(t, 1, server.getOpCount("item.content"))
+	assert.Equal(t, 0, server.getOpCount("item.createUploadSession"))
+	item, ok := server.getItem("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, []byte("content"), item.data)
+	data, err := downloadTestOneDriveFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestOneDriveFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+	assert.Equal(t, 2, server.getOpCount("download"))
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(7), info.Size())
+	// the file system modification time has precedence
+	assert.True(t, item.modTime.Equal(info.ModTime()))
+	assert.Equal(t, fmt.Sprintf(`"{%s},7"`, item.id), GetETag(info))
+	mtime := time.Date(2023, 10, 20, 11, 22, 33, 0, time.UTC)
+	require.NoError(t, fs.Chtimes("/dir/file.txt", mtime, mtime, false))
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	mimeType, err := fs.GetMimeType("/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "application/octet-stream", mimeType)
+	entries := listTestDir(t, fs, "/dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, int64(7), entries[0].Size())
+	}
+	// moves are server side
+	numFiles, size, err := fs.Rename("/dir/file.txt", "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	_, ok = server.getItem("/dir/file.txt")
+	assert.False(t, ok)
+	moved, ok := server.getItem("/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, item.id, moved.id)
+	// Graph never replaces existing items while moving
+	server.putFile("/other", []byte("other content"))
+	_, _, err = fs.Rename("/file.txt", "/other")
+	require.NoError(t, err)
+	// Chtimes, the first rename and the two attempts for this one
+	assert.Equal(t, 4, server.getOpCount("item.update"))
+	assert.Equal(t, 1, server.getOpCount("item.delete"))
+	data, err = downloadTestOneDriveFile(t, fs, "/other", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	_, _, err = fs.Rename("/other", "/dir")
+	assert.True(t, fs.isConflict(err))
+	// directories are moved with their contents
+	server.putFile("/dir/sub/file", []byte("sub"))
+	_, _, err = fs.Rename("/dir", "/moved")
+	require.NoError(t, err)
+	data, err = downloadTestOneDriveFile(t, fs, "/moved/sub/file", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("sub"), data)
+	// copies are asynchronous
+	numFiles, sizeDiff, err := fs.CopyFile("/other", "/moved/copy", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), sizeDiff)
+	numFiles, sizeDiff, err = fs.CopyFile("/other", "/moved/copy", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(0), sizeDiff)
+	assert.Equal(t, 2, server.getOpCount("monitor"))
+	server.setCopyError("copy failed")
+	_, _, err = fs.CopyFile("/other", "/copy", 7)
+	assert.ErrorContains(t, err, "copy failed")
+	server.setCopyError("")
+	data, err = downloadTestOneDriveFile(t, fs, "/moved/copy", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(fakeOneDriveQuotaTotal/oneDriveStatVFSBlockSize), statVFS.Blocks)
+
+	err = fs.Remove("/moved", true)
+	assert.ErrorContains(t, err, "non empty directory")
+	require.NoError(t, fs.Remove("/moved/sub/file", false))
+	require.NoError(t, fs.Remove("/moved/sub", true))
+	_, err = fs.Stat("/moved/sub")
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestOneDriveFsReadDirPagination(t *testing.T) {
+	server := newFakeOneDriveServer(t)
+	fs := newTestOneDriveFs(t, server, OneDriveFsConfig{
+		DriveID:  fakeOneDriveDriveID,
+		RootPath: "/base",
+	})
+	assert.Contains(t, fs.Name(), fakeOneDriveDriveID)
+
+	numFiles := oneDriveListLimit + 5
+	expected := make([]string, 0, numFiles+1)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putFile("/base/dir/"+name, []byte(name))
+		expected = append(expected, name)
+	}
+	server.putFile("/base/dir/sub/file", []byte("data"))
+	expected = append(expected, "sub")
+	server.putFile("/outside", []byte("outside"))
+
+	dirName, err := fs.ResolvePath("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, "/base/dir", dirName)
+	assert.Equal(t, "/dir", fs.GetRelativePath(dirName))
+	lister, err := fs.ReadDir(dirName)
+	require.NoError(t, err)
+	entries, err := lister.Next(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "file0000", entries[0].Name())
+	assert.Equal(t, 1, server.getOpCount("item.children"))
+	require.NoError(t, lister.Close())
+
+	entries = listTestDir(t, fs, dirName)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, e.Name() == "sub", e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, expected, names)
+	assert.Equal(t, 3, server.getOpCount("item.children"))
+	// folders are listed recursively if the root path is not the drive root
+	numFiles, size, err := fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, oneDriveListLimit+6, numFiles)
+	assert.Equal(t, int64(8*(oneDriveListLimit+5)+4), size)
+	assert.Equal(t, 0, server.getOpCount("item.delta"))
+	assert.Equal(t, 7, server.getOpCount("item.children"))
+	// the drive root is scanned using a delta query, deleted items are skipped
+	numFiles, size, err = fs.GetDirSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, oneDriveListLimit+7, numFiles)
+	assert.Equal(t, int64(8*(oneDriveListLimit+5)+11), size)
+	assert.Equal(t, 6, server.getOpCount("item.delta"))
+
+	var walked []string
+	err = fs.Walk("/base/dir/sub", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/base/dir/sub", "/base/dir/sub/file"}, walked)
+}
+
+func TestOneDriveFsUploadSession(t *testing.T) {
+	server := newFakeOneDriveServer(t)
+	fs := newTestOneDriveFs(t, server, OneDriveFsConfig{
+		TenantID:       "tenant",
+		ClientSecret:   kms.NewPlainSecret(fakeOneDriveClientSecret),
+		DriveID:        fakeOneDriveDriveID,
+		UploadPartSize: 1,
+	})
+	// fragments are a multiple of 320 KiB
+	assert.Equal(t, int64(3*oneDriveUploadFragmentMultiple), fs.getUploadPartSize())
+
+	data := bytes.Repeat([]byte("0123456789"), 256*1024)
+	require.NoError(t, uploadTestOneDriveFile(t, fs, "/large.bin", data))
+	assert.Equal(t, 0, server.getOpCount("item.content"))
+	assert.Equal(t, 1, server.getOpCount("item.createUploadSession"))
+	assert.Equal(t, 3, server.getOpCount("upload.fragment"))
+	assert.Equal(t, 0, server.getSessionsCount())
+	item, ok := server.getItem("/large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, item.data)
+	downloaded, err := downloadTestOneDriveFile(t, fs, "/large.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	downloaded, err = downloadTestOneDriveFile(t, fs, "/large.bin", int64(len(data)-5))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("56789"), downloaded)
+	// a failed fragment cancels the upload session
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "upload.fragment" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	err = uploadTestOneDriveFile(t, fs, "/large.bin", bytes.Repeat([]byte("a"), 2*1024*1024))
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.getOpCount("upload.cancel"))
+	assert.Equal(t, 0, server.getSessionsCount())
+	item, ok = server.getItem("/large.bin")
+	require.True(t, ok)
+	assert.Equal(t, data, item.data)
+}
+
+func TestOneDriveFsErrors(t *testing.T) {
+	server := newFakeOneDriveServer(t)
+	fs := newTestOneDriveFs(t, server, OneDriveFsConfig{
+		RefreshToken: kms.NewPlainSecret("invalid"),
+	})
+	_, err := fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to get OneDrive access token")
+	fs = newTestOneDriveFs(t, server, OneDriveFsConfig{
+		TenantID:     "tenant",
+		ClientSecret: kms.NewPlainSecret("invalid"),
+		DriveID:      fakeOneDriveDriveID,
+	})
+	_, err = fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to get OneDrive access token")
+
+	fs = newTestOneDriveFs(t, server, OneDriveFsConfig{})
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/file", "/missing/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", true)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = downloadTestOneDriveFile(t, fs, "/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.CopyFile("/missing", "/target", 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/file", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+
+	server.putFile("/file", []byte("data"))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		switch op {
+		case "item.get", "item.children", "item.content", "download":
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.ReadDir("/")
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestOneDriveFile(t, fs, "/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "download" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	_, err = downloadTestOneDriveFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	// throttled requests are retried
+	itemOps := server.getOpCount("item.get")
+	throttled := false
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "item.get" && !throttled {
+			throttled = true
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+	info, err := fs.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	assert.Equal(t, itemOps+2, server.getOpCount("item.get"))
+	// the retry attempts are limited
+	itemOps = server.getOpCount("item.get")
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "item.get" {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+	_, err = fs.Stat("/file")
+	var apiErr *oneDriveError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusInternalServerError, apiErr.statusCode)
+		assert.Equal(t, "injectedError", apiErr.code)
+	}
+	assert.Equal(t, itemOps+oneDriveMaxAttempts, server.getOpCount("item.get"))
+	server.setOnRequest(nil)
+	_, ok := server.getItem("/file1")
+	assert.False(t, ok)
+}
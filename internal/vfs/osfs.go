@@ -577,6 +577,40 @@ func (*OsFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
 	return getStatFS(dirName)
 }
 
+// GetXattr implements the FsXattrer interface
+func (*OsFs) GetXattr(name, attr string) ([]byte, error) {
+	return getXattr(name, attr)
+}
+
+// ListXattr implements the FsXattrer interface
+func (*OsFs) ListXattr(name string) ([]string, error) {
+	return listXattr(name)
+}
+
+// SetXattr implements the FsXattrer interface
+func (*OsFs) SetXattr(name, attr string, value []byte) error {
+	return setXattr(name, attr, value)
+}
+
+// RemoveXattr implements the FsXattrer interface
+func (*OsFs) RemoveXattr(name, attr string) error {
+	return removeXattr(name, attr)
+}
+
+// CloneFile clones the src file into dst, they will share the same data blocks.
+// An error is returned if the underlying filesystem does not support reflinks,
+// the data must be copied in this case
+func CloneFile(dst, src *os.File) (int64, error) {
+	if err := cloneFile(dst, src); err != nil {
+		return 0, err
+	}
+	info, err := dst.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func (fs *OsFs) useWriteBuffering(flag int) bool {
 	if fs.writeBufferSize <= 0 {
 		return false
@@ -0,0 +1,533 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	defaultQuotaScanWorkers = 4
+	// snapshots not used for a scan within this time are removed
+	quotaScanSnapshotMaxAge = 48 * time.Hour
+	// if more changes are recorded a full scan is cheaper than tracking them
+	maxQuotaScanChanges = 10000
+)
+
+var (
+	errQuotaScanDirNotFound = errors.New("quota scan: directory not found")
+	quotaScanWorkers        = defaultQuotaScanWorkers
+	quotaScans              = quotaScanSnapshots{
+		snapshots: make(map[string]*quotaScanSnapshot),
+	}
+)
+
+// SetQuotaScanWorkers sets the number of directories listed in parallel
+// during quota scans
+func SetQuotaScanWorkers(val int) {
+	if val < 1 {
+		val = 1
+	}
+	quotaScanWorkers = val
+}
+
+// UserQuotaScanKey returns the key identifying the incremental quota scan data
+// for the root filesystem of the specified user
+func UserQuotaScanKey(username string) string {
+	return "user:" + username
+}
+
+// FolderQuotaScanKey returns the key identifying the incremental quota scan data
+// for the specified virtual folder
+func FolderQuotaScanKey(name string) string {
+	return "folder:" + name
+}
+
+type dirUsage struct {
+	files int
+	size  int64
+}
+
+// quotaScanSnapshot stores the usage for each directory, not including
+// its sub directories, as found in the last scan and the paths changed
+// since then. The changes map a path relative to the filesystem root to
+// true if the whole tree must be scanned again or false if only its direct
+// contents changed
+type quotaScanSnapshot struct {
+	fsName   string
+	rootPath string
+	dirs     map[string]dirUsage
+	changes  map[string]bool
+	invalid  bool
+	isActive bool
+	lastUsed time.Time
+}
+
+type quotaScanSnapshots struct {
+	sync.Mutex
+	snapshots map[string]*quotaScanSnapshot
+}
+
+// begin returns the directories from the last scan and the changes recorded
+// since then. Nil directories mean a full scan is required. The returned bool
+// is false if the scan results must not be saved
+func (s *quotaScanSnapshots) begin(key, fsName, rootPath string, incremental bool) (map[string]dirUsage, map[string]bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot, ok := s.snapshots[key]
+	if !ok {
+		if !incremental {
+			return nil, nil, false
+		}
+		snapshot = &quotaScanSnapshot{
+			changes: make(map[string]bool),
+			invalid: true,
+		}
+		s.snapshots[key] = snapshot
+	}
+	if snapshot.isActive {
+		// the same filesystem is already being scanned, for example a folder
+		// included in the quota of multiple users
+		return nil, nil, false
+	}
+	snapshot.isActive = true
+	snapshot.lastUsed = time.Now()
+	if snapshot.fsName != fsName || snapshot.rootPath != rootPath {
+		snapshot.fsName = fsName
+		snapshot.rootPath = rootPath
+		snapshot.invalid = true
+	}
+	changes := snapshot.changes
+	snapshot.changes = make(map[string]bool)
+	if snapshot.invalid || !incremental {
+		snapshot.invalid = false
+		return nil, changes, true
+	}
+	dirs := make(map[string]dirUsage, len(snapshot.dirs))
+	for k, v := range snapshot.dirs {
+		dirs[k] = v
+	}
+	return dirs, changes, true
+}
+
+// end saves the scanned directories or restores the changes if the scan failed
+func (s *quotaScanSnapshots) end(key string, dirs map[string]dirUsage, changes map[string]bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot, ok := s.snapshots[key]
+	if !ok {
+		return
+	}
+	snapshot.isActive = false
+	if err != nil {
+		for p, isTree := range changes {
+			snapshot.changes[p] = snapshot.changes[p] || isTree
+		}
+		snapshot.invalid = true
+		return
+	}
+	snapshot.dirs = dirs
+}
+
+func (s *quotaScanSnapshots) addChange(key, relPath string, isTree bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if snapshot, ok := s.snapshots[key]; ok {
+		if snapshot.invalid {
+			return
+		}
+		if len(snapshot.changes) >= maxQuotaScanChanges {
+			snapshot.changes = make(map[string]bool)
+			snapshot.invalid = true
+			return
+		}
+		snapshot.changes[relPath] = snapshot.changes[relPath] || isTree
+	}
+}
+
+func (s *quotaScanSnapshots) invalidate(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if snapshot, ok := s.snapshots[key]; ok {
+		snapshot.invalid = true
+	}
+}
+
+func (s *quotaScanSnapshots) remove(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.snapshots, key)
+}
+
+// cleanup removes the snapshots not used for a scan since the specified time
+func (s *quotaScanSnapshots) cleanup(usedBefore time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for key, snapshot := range s.snapshots {
+		if !snapshot.isActive && snapshot.lastUsed.Before(usedBefore) {
+			delete(s.snapshots, key)
+		}
+	}
+}
+
+func (s *quotaScanSnapshots) hasSnapshots() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.snapshots) > 0
+}
+
+// HasIncrementalQuotaScans returns true if incremental quota scans are in use
+// and so the filesystem changes must be recorded
+func HasIncrementalQuotaScans() bool {
+	return quotaScans.hasSnapshots()
+}
+
+// RecordQuotaScanChange records a change for the specified path, relative to the
+// filesystem root, so the next incremental scan includes it. If isTree is true
+// the path and all its contents are scanned again
+func RecordQuotaScanChange(key, relPath string, isTree bool) {
+	quotaScans.addChange(key, relPath, isTree)
+}
+
+// InvalidateQuotaScan requires a full scan the next time the
+// filesystem identified by the specified key is scanned
+func InvalidateQuotaScan(key string) {
+	quotaScans.invalidate(key)
+}
+
+// RemoveQuotaScan removes the incremental quota scan data for the specified key
+func RemoveQuotaScan(key string) {
+	quotaScans.remove(key)
+}
+
+// CleanupQuotaScans removes the incremental quota scan data not used recently,
+// for example for users deleted from another instance or no longer scanned
+func CleanupQuotaScans() {
+	quotaScans.cleanup(time.Now().Add(-quotaScanSnapshotMaxAge))
+}
+
+type quotaScanDir struct {
+	fsPath  string
+	relPath string
+	// if false only the files directly inside this directory are scanned,
+	// the sub directories are scanned if not already known
+	recursive bool
+}
+
+// quotaScanWalker lists directories in parallel using a fixed number of workers
+type quotaScanWalker struct {
+	fs      Fs
+	known   map[string]dirUsage
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []quotaScanDir
+	active  int
+	result  map[string]dirUsage
+	removed []string
+	err     error
+}
+
+func newQuotaScanWalker(fs Fs, known map[string]dirUsage, dirs []quotaScanDir) *quotaScanWalker {
+	w := &quotaScanWalker{
+		fs:      fs,
+		known:   known,
+		pending: dirs,
+		result:  make(map[string]dirUsage),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// run scans the pending directories and returns the scanned directories
+// and the ones no longer existing
+func (w *quotaScanWalker) run() (map[string]dirUsage, []string, error) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < quotaScanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				dir, ok := w.next()
+				if !ok {
+					return
+				}
+				usage, subDirs, err := w.scanDir(dir)
+				w.done(dir, usage, subDirs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return w.result, w.removed, w.err
+}
+
+func (w *quotaScanWalker) next() (quotaScanDir, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.pending) == 0 && w.active > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if len(w.pending) == 0 || w.err != nil {
+		return quotaScanDir{}, false
+	}
+	dir := w.pending[len(w.pending)-1]
+	w.pending = w.pending[:len(w.pending)-1]
+	w.active++
+	return dir, true
+}
+
+func (w *quotaScanWalker) done(dir quotaScanDir, usage dirUsage, subDirs []quotaScanDir, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.active--
+	if errors.Is(err, errQuotaScanDirNotFound) {
+		w.removed = append(w.removed, dir.relPath)
+	} else if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+	} else {
+		w.result[dir.relPath] = usage
+		w.pending = append(w.pending, subDirs...)
+		if len(w.result)%1000 == 0 {
+			fsLog(w.fs, logger.LevelDebug, "quota scan in progress, scanned dirs: %d, pending: %d",
+				len(w.result), len(w.pending))
+		}
+	}
+	w.cond.Broadcast()
+}
+
+func (w *quotaScanWalker) scanDir(dir quotaScanDir) (dirUsage, []quotaScanDir, error) {
+	var usage dirUsage
+	var subDirs []quotaScanDir
+
+	lister, err := readQuotaScanDir(w.fs, dir.fsPath)
+	if err != nil {
+		if w.fs.IsNotExist(err) && dir.relPath != "/" {
+			// removed after the change was recorded
+			return usage, nil, errQuotaScanDirNotFound
+		}
+		return usage, nil, err
+	}
+	defer lister.Close()
+
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return usage, nil, err
+		}
+		for _, info := range entries {
+			if info.IsDir() {
+				relPath := path.Join(dir.relPath, info.Name())
+				if !dir.recursive {
+					if _, ok := w.known[relPath]; ok {
+						continue
+					}
+				}
+				subDirs = append(subDirs, quotaScanDir{
+					fsPath:    w.fs.Join(dir.fsPath, info.Name()),
+					relPath:   relPath,
+					recursive: true,
+				})
+				continue
+			}
+			if info.Mode().IsRegular() {
+				usage.files++
+				usage.size += info.Size()
+			}
+		}
+		if finished {
+			return usage, subDirs, nil
+		}
+	}
+}
+
+// readQuotaScanDir returns a lister for the specified directory. The quota
+// is based on the stored file sizes, as for GetDirSize, so the sizes of the
+// encrypted files are not converted
+func readQuotaScanDir(fs Fs, dirname string) (DirLister, error) {
+	if cryptFs, ok := fs.(*CryptFs); ok {
+		return cryptFs.OsFs.ReadDir(dirname)
+	}
+	return fs.ReadDir(dirname)
+}
+
+// getQuotaScanDirs returns the directories to scan again for the specified
+// changes and removes the outdated ones from dirs
+func getQuotaScanDirs(fs Fs, rootPath string, dirs map[string]dirUsage, changes map[string]bool) []quotaScanDir {
+	paths := make([]string, 0, len(changes))
+	for p := range changes {
+		paths = append(paths, p)
+	}
+	// parent directories first
+	sort.Strings(paths)
+
+	var result []quotaScanDir
+	var trees []string
+
+	isInTree := func(p string) bool {
+		for _, tree := range trees {
+			if tree == "/" || p == tree || strings.HasPrefix(p, tree+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, p := range paths {
+		if isInTree(p) {
+			continue
+		}
+		isTree := changes[p]
+		fsPath := fs.Join(rootPath, strings.TrimPrefix(p, "/"))
+		if !isTree {
+			result = append(result, quotaScanDir{
+				fsPath:  fsPath,
+				relPath: p,
+			})
+			continue
+		}
+		trees = append(trees, p)
+		for dir := range dirs {
+			if p == "/" || dir == p || strings.HasPrefix(dir, p+"/") {
+				delete(dirs, dir)
+			}
+		}
+		info, err := fs.Stat(fsPath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		result = append(result, quotaScanDir{
+			fsPath:    fsPath,
+			relPath:   p,
+			recursive: true,
+		})
+	}
+	// direct changes inside a scanned tree are already included
+	n := 0
+	for _, dir := range result {
+		if !dir.recursive && isInTree(dir.relPath) {
+			continue
+		}
+		result[n] = dir
+		n++
+	}
+	return result[:n]
+}
+
+// QuotaScanOptions defines the options for a quota scan
+type QuotaScanOptions struct {
+	// If true and a previous scan is available only the directories changed
+	// since then are scanned again
+	Incremental bool
+	// Paths, relative to the filesystem root, to always scan again in
+	// incremental mode, for example directories changed without generating
+	// filesystem events
+	RescanPaths []string
+	// Paths, relative to the filesystem root, excluded from the results
+	ExcludedPaths []string
+}
+
+func (o *QuotaScanOptions) isExcluded(relPath string) bool {
+	for _, p := range o.ExcludedPaths {
+		if relPath == p || strings.HasPrefix(relPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanQuota returns the number of files, and their size, inside the filesystem
+// root. The directories are listed in parallel. In incremental mode, if a
+// previous scan is available, only the directories changed since then, as
+// recorded using RecordQuotaScanChange, are scanned again, otherwise the whole
+// filesystem is scanned and the results are saved for the next incremental scans
+func ScanQuota(fs Fs, key string, opts QuotaScanOptions) (int, int64, error) {
+	rootPath, err := fs.ResolvePath("/")
+	if err != nil {
+		return 0, 0, err
+	}
+	dirs, changes, save := quotaScans.begin(key, fs.Name(), rootPath, opts.Incremental)
+	var scanDirs []quotaScanDir
+	if dirs == nil {
+		dirs = make(map[string]dirUsage)
+		scanDirs = []quotaScanDir{
+			{
+				fsPath:    rootPath,
+				relPath:   "/",
+				recursive: true,
+			},
+		}
+	} else {
+		allChanges := make(map[string]bool, len(changes)+len(opts.RescanPaths))
+		for p, isTree := range changes {
+			allChanges[p] = isTree
+		}
+		for _, p := range opts.RescanPaths {
+			allChanges[p] = true
+		}
+		scanDirs = getQuotaScanDirs(fs, rootPath, dirs, allChanges)
+		fsLog(fs, logger.LevelDebug, "incremental quota scan, changes: %d, directories to scan: %d",
+			len(allChanges), len(scanDirs))
+	}
+	scanned, removed, err := newQuotaScanWalker(fs, dirs, scanDirs).run()
+	if err == nil {
+		for _, p := range removed {
+			for dir := range dirs {
+				if dir == p || strings.HasPrefix(dir, p+"/") {
+					delete(dirs, dir)
+				}
+			}
+		}
+		for p, usage := range scanned {
+			dirs[p] = usage
+		}
+	}
+	if save {
+		quotaScans.end(key, dirs, changes, err)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var numFiles int
+	var size int64
+	for p, usage := range dirs {
+		if opts.isExcluded(p) {
+			continue
+		}
+		numFiles += usage.files
+		size += usage.size
+	}
+	return numFiles, size, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func TestQuotaScanSnapshotsCleanup(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "file"), []byte("content"), os.ModePerm))
+	fs := NewOsFs("", rootDir, "", nil)
+	key := UserQuotaScanKey("quota_scan_cleanup")
+	t.Cleanup(func() {
+		RemoveQuotaScan(key)
+	})
+
+	numFiles, size, err := ScanQuota(fs, key, QuotaScanOptions{Incremental: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	// recently used snapshots are not removed
+	CleanupQuotaScans()
+	quotaScans.Lock()
+	snapshot, ok := quotaScans.snapshots[key]
+	quotaScans.Unlock()
+	require.True(t, ok)
+	assert.False(t, snapshot.invalid)
+	// too many changes require a full scan
+	for i := 0; i <= maxQuotaScanChanges; i++ {
+		RecordQuotaScanChange(key, "/dir"+strconv.Itoa(i), false)
+	}
+	quotaScans.Lock()
+	assert.True(t, snapshot.invalid)
+	assert.Len(t, snapshot.changes, 0)
+	quotaScans.Unlock()
+	RecordQuotaScanChange(key, "/dir", false)
+	quotaScans.Lock()
+	assert.Len(t, snapshot.changes, 0)
+	quotaScans.Unlock()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "file1"), []byte("1"), os.ModePerm))
+	numFiles, size, err = ScanQuota(fs, key, QuotaScanOptions{Incremental: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, numFiles)
+	assert.Equal(t, int64(8), size)
+	// snapshots in use are never removed
+	quotaScans.Lock()
+	snapshot.isActive = true
+	quotaScans.Unlock()
+	quotaScans.cleanup(time.Now().Add(time.Minute))
+	quotaScans.Lock()
+	_, ok = quotaScans.snapshots[key]
+	snapshot.isActive = false
+	quotaScans.Unlock()
+	assert.True(t, ok)
+	quotaScans.cleanup(time.Now().Add(time.Minute))
+	quotaScans.Lock()
+	_, ok = quotaScans.snapshots[key]
+	quotaScans.Unlock()
+	assert.False(t, ok)
+}
+
+func TestQuotaScanCryptFs(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "file"), make([]byte, 100), os.ModePerm))
+	fs, err := NewCryptFs("", rootDir, "", CryptFsConfig{Passphrase: kms.NewPlainSecret("secret")})
+	require.NoError(t, err)
+	key := UserQuotaScanKey("quota_scan_crypt")
+	t.Cleanup(func() {
+		RemoveQuotaScan(key)
+	})
+	// the stored size is used, as for GetDirSize
+	numFiles, size, err := ScanQuota(fs, key, QuotaScanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(100), size)
+	numFiles, size, err = fs.GetDirSize(rootDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(100), size)
+}
@@ -18,8 +18,11 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -45,6 +48,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 
@@ -59,6 +63,16 @@ const (
 	s3DirMimeType         = "application/x-directory"
 	s3TransferBufferSize  = 256 * 1024
 	s3CopyObjectThreshold = 500 * 1024 * 1024
+	// server side copies for big parts and the completion of multipart
+	// copies for big objects can take several minutes
+	s3MultipartCopyTimeout = 10 * time.Minute
+	// parts, except the last one, must be at least 5 MB
+	s3MinPartSize = manager.MinUploadPartSize
+	s3MaxParts    = manager.MaxUploadParts
+	// restored copies of archived objects are available for this number of
+	// days, Intelligent-Tiering objects are moved back to the frequent access
+	// tier and this value is ignored
+	s3RestoreDays = 7
 )
 
 var (
@@ -75,6 +89,11 @@ type S3Fs struct {
 	config     *S3FsConfig
 	svc        *s3.Client
 	ctxTimeout time.Duration
+	// identifies the bucket in the metadata cache
+	cacheBackend string
+	// SSE-C key and its MD5 digest, base64 encoded
+	sseCustomerKey    *string
+	sseCustomerKeyMD5 *string
 }
 
 func init() {
@@ -97,6 +116,8 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 	if err := fs.config.validate(); err != nil {
 		return fs, err
 	}
+	fs.cacheBackend = getMetadataCacheBackend(s3fsName, fs.config.Endpoint, fs.config.Region, fs.config.Bucket,
+		fs.config.AccessKey, fs.config.RoleARN)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -120,13 +141,36 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 				fs.config.SessionToken),
 		)
 	}
+	if !fs.config.SSECustomerKey.IsEmpty() {
+		if err := fs.config.SSECustomerKey.TryDecrypt(); err != nil {
+			return fs, err
+		}
+		key, err := base64.StdEncoding.DecodeString(fs.config.SSECustomerKey.GetPayload())
+		if err != nil {
+			return fs, fmt.Errorf("invalid SSE customer key: %w", err)
+		}
+		keyMD5 := md5.Sum(key)
+		fs.sseCustomerKey = aws.String(fs.config.SSECustomerKey.GetPayload())
+		fs.sseCustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(keyMD5[:]))
+	}
 
 	fs.setConfigDefaults()
 
 	if fs.config.RoleARN != "" {
 		client := sts.NewFromConfig(awsConfig)
-		creds := stscreds.NewAssumeRoleProvider(client, fs.config.RoleARN)
-		awsConfig.Credentials = creds
+		if fs.config.AssumeRoleWithWebIdentity {
+			// fail early, without a token the filesystem is not usable
+			if _, err := webIdentityTokens.get(fs.config.webIdentityUser); err != nil {
+				return fs, err
+			}
+			awsConfig.Credentials = stscreds.NewWebIdentityRoleProvider(client, fs.config.RoleARN,
+				s3WebIdentityTokenRetriever(fs.config.webIdentityUser), func(o *stscreds.WebIdentityRoleOptions) {
+					o.RoleSessionName = getS3WebIdentitySessionName(fs.config.webIdentityUser)
+				})
+		} else {
+			creds := stscreds.NewAssumeRoleProvider(client, fs.config.RoleARN)
+			awsConfig.Credentials = creds
+		}
 	}
 	fs.svc = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
 		o.AppID = version.GetVersionHash()
@@ -150,13 +194,24 @@ func (fs *S3Fs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
-	var result *FileInfo
 	if name == "" || name == "/" || name == "." {
 		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
 	}
 	if fs.config.KeyPrefix == name+"/" {
 		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
 	}
+	if info, ok := metadataCache.get(fs.cacheBackend, name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		metadataCache.add(fs.cacheBackend, name, info)
+	}
+	return info, err
+}
+
+func (fs *S3Fs) getObjectStat(name string) (os.FileInfo, error) {
+	var result *FileInfo
 	obj, err := fs.headObject(name)
 	if err == nil {
 		// Some S3 providers (like SeaweedFS) remove the trailing '/' from object keys.
@@ -166,7 +221,11 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 			_, err = fs.headObject(name + "/")
 			isDir = err == nil
 		}
-		return NewFileInfo(name, isDir, util.GetIntFromPointer(obj.ContentLength), util.GetTimeFromPointer(obj.LastModified), false), nil
+		info := NewFileInfo(name, isDir, util.GetIntFromPointer(obj.ContentLength), util.GetTimeFromPointer(obj.LastModified), false)
+		if !isDir && fs.hasContentETag() {
+			info.SetETag(getS3ETag(obj.ETag))
+		}
+		return info, nil
 	}
 	if !fs.IsNotExist(err) {
 		return result, err
@@ -237,10 +296,14 @@ func (fs *S3Fs) Open(name string, offset int64) (File, PipeReader, func(), error
 		defer cancelFn()
 
 		n, err := downloader.Download(ctx, w, &s3.GetObjectInput{
-			Bucket: aws.String(fs.config.Bucket),
-			Key:    aws.String(name),
-			Range:  streamRange,
+			Bucket:               aws.String(fs.config.Bucket),
+			Key:                  aws.String(name),
+			SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+			SSECustomerKey:       fs.sseCustomerKey,
+			SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+			Range:                streamRange,
 		})
+		err = checkS3ArchivedError(err)
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
 		metric.S3TransferCompleted(n, 1, err)
@@ -256,16 +319,39 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, PipeWriter, func(),
 			return nil, nil, nil, err
 		}
 	}
+	if flag != -1 {
+		if err := fs.checkObjectLock(name); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	var resumeObj *s3.HeadObjectOutput
+	if checks&CheckResume != 0 {
+		obj, err := fs.headObject(name)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to resume %q stat error: %w", name, err)
+		}
+		if size := util.GetIntFromPointer(obj.ContentLength); size > resumeMaxSize {
+			if size < s3MinPartSize {
+				return nil, nil, nil, fmt.Errorf("unable to resume %q, size %d is too small for a multipart upload: %w",
+					name, size, ErrVfsUnsupported)
+			}
+			// the existing data will be copied server side
+			resumeObj = obj
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	var p PipeWriter
-	if checks&CheckResume != 0 {
+	if resumeObj != nil {
+		p = newPipeWriterAtOffset(w, util.GetIntFromPointer(resumeObj.ContentLength))
+	} else if checks&CheckResume != 0 {
 		p = newPipeWriterAtOffset(w, 0)
 	} else {
 		p = NewPipeWriter(w)
 	}
+	metadataCache.invalidate(fs.cacheBackend, name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	uploader := manager.NewUploader(fs.svc, func(u *manager.Uploader) {
 		u.Concurrency = fs.config.UploadConcurrency
@@ -282,27 +368,44 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, PipeWriter, func(),
 		defer cancelFn()
 
 		var contentType string
+		var lockOpts s3ObjectLockOptions
 		if flag == -1 {
 			contentType = s3DirMimeType
 		} else {
 			contentType = mime.TypeByExtension(path.Ext(name))
+			lockOpts = fs.getObjectLockOptions()
+		}
+		var err error
+		if resumeObj != nil {
+			err = fs.resumeMultipartUpload(ctx, name, contentType, resumeObj, r)
+		} else {
+			_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+				Bucket:                    aws.String(fs.config.Bucket),
+				Key:                       aws.String(name),
+				SSECustomerAlgorithm:      fs.getSSECustomerAlgorithm(),
+				SSECustomerKey:            fs.sseCustomerKey,
+				SSECustomerKeyMD5:         fs.sseCustomerKeyMD5,
+				ServerSideEncryption:      fs.getServerSideEncryption(),
+				SSEKMSKeyId:               util.NilIfEmpty(fs.config.SSEKMSKeyID),
+				Body:                      r,
+				ACL:                       types.ObjectCannedACL(fs.config.ACL),
+				StorageClass:              types.StorageClass(fs.config.StorageClass),
+				ContentType:               util.NilIfEmpty(contentType),
+				ObjectLockMode:            lockOpts.mode,
+				ObjectLockRetainUntilDate: lockOpts.retainUntil,
+				ObjectLockLegalHoldStatus: lockOpts.legalHold,
+				ChecksumAlgorithm:         lockOpts.checksum,
+			})
 		}
-		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket:       aws.String(fs.config.Bucket),
-			Key:          aws.String(name),
-			Body:         r,
-			ACL:          types.ObjectCannedACL(fs.config.ACL),
-			StorageClass: types.StorageClass(fs.config.StorageClass),
-			ContentType:  util.NilIfEmpty(contentType),
-		})
 		r.CloseWithError(err) //nolint:errcheck
+		metadataCache.invalidate(fs.cacheBackend, name)
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %d, err: %+v",
 			name, fs.config.ACL, r.GetReadedBytes(), err)
 		metric.S3TransferCompleted(r.GetReadedBytes(), 0, err)
 	}()
 
-	if checks&CheckResume != 0 {
+	if checks&CheckResume != 0 && resumeObj == nil {
 		readCh := make(chan error, 1)
 
 		go func() {
@@ -357,6 +460,10 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		if !strings.HasSuffix(name, "/") {
 			name += "/"
 		}
+	} else {
+		if err := fs.checkObjectLock(name); err != nil {
+			return err
+		}
 	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -365,6 +472,7 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		Bucket: aws.String(fs.config.Bucket),
 		Key:    aws.String(name),
 	})
+	metadataCache.invalidate(fs.cacheBackend, name)
 	metric.S3DeleteObjectCompleted(err)
 	return err
 }
@@ -423,10 +531,12 @@ func (fs *S3Fs) ReadDir(dirname string) (DirLister, error) {
 	})
 
 	return &s3DirLister{
-		paginator: paginator,
-		timeout:   fs.ctxTimeout,
-		prefix:    prefix,
-		prefixes:  make(map[string]bool),
+		paginator:      paginator,
+		timeout:        fs.ctxTimeout,
+		prefix:         prefix,
+		prefixes:       make(map[string]bool),
+		cacheBackend:   fs.cacheBackend,
+		hasContentETag: fs.hasContentETag(),
 	}, nil
 }
 
@@ -437,9 +547,11 @@ func (*S3Fs) IsUploadResumeSupported() bool {
 }
 
 // IsConditionalUploadResumeSupported returns if resuming uploads is supported
-// for the specified size
+// for the specified size. Files up to the configured resume max size are
+// downloaded and uploaded again, bigger files are resumed copying the existing
+// data server side, this requires at least the minimum part size
 func (*S3Fs) IsConditionalUploadResumeSupported(size int64) bool {
-	return size <= resumeMaxSize
+	return size <= resumeMaxSize || size >= s3MinPartSize
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
@@ -471,6 +583,9 @@ func (*S3Fs) IsPermission(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrObjectLocked) {
+		return true
+	}
 
 	var re *awshttp.ResponseError
 	if errors.As(err, &re) {
@@ -636,6 +751,11 @@ func (fs *S3Fs) CopyFile(source, target string, srcSize int64) (int, int64, erro
 	sizeDiff := srcSize
 	attrs, err := fs.headObject(target)
 	if err == nil {
+		if fs.config.isObjectLockEnabled() {
+			if err := fs.getObjectLockError(target, attrs); err != nil {
+				return 0, 0, err
+			}
+		}
 		sizeDiff -= util.GetIntFromPointer(attrs.ContentLength)
 		numFiles = 0
 	} else {
@@ -682,26 +802,50 @@ func (fs *S3Fs) setConfigDefaults() {
 }
 
 func (fs *S3Fs) copyFileInternal(source, target string, fileSize int64) error {
+	defer metadataCache.invalidate(fs.cacheBackend, target)
+
 	contentType := mime.TypeByExtension(path.Ext(source))
 	copySource := pathEscape(fs.Join(fs.config.Bucket, source))
 
 	if fileSize > s3CopyObjectThreshold {
 		fsLog(fs, logger.LevelDebug, "renaming file %q with size %d using multipart copy",
 			source, fileSize)
-		err := fs.doMultipartCopy(copySource, target, contentType, fileSize)
+		// a multipart copy does not preserve the source object attributes,
+		// we need to read them and set them explicitly. The size is also
+		// refreshed, the provided one could be stale
+		srcObj, err := fs.headObject(source)
+		if err != nil {
+			return fmt.Errorf("unable to get the source object for multipart copy: %w", err)
+		}
+		if srcObj.ContentType != nil {
+			contentType = *srcObj.ContentType
+		}
+		err = fs.doMultipartCopy(copySource, target, contentType, srcObj, srcObj.Metadata)
 		metric.S3CopyObjectCompleted(err)
 		return err
 	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
+	lockOpts := fs.getObjectLockOptions()
 	_, err := fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:       aws.String(fs.config.Bucket),
-		CopySource:   aws.String(copySource),
-		Key:          aws.String(target),
-		StorageClass: types.StorageClass(fs.config.StorageClass),
-		ACL:          types.ObjectCannedACL(fs.config.ACL),
-		ContentType:  util.NilIfEmpty(contentType),
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(copySource),
+		Key:                            aws.String(target),
+		SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:                 fs.sseCustomerKey,
+		SSECustomerKeyMD5:              fs.sseCustomerKeyMD5,
+		CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		CopySourceSSECustomerKey:       fs.sseCustomerKey,
+		CopySourceSSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+		ServerSideEncryption:           fs.getServerSideEncryption(),
+		SSEKMSKeyId:                    util.NilIfEmpty(fs.config.SSEKMSKeyID),
+		StorageClass:                   types.StorageClass(fs.config.StorageClass),
+		ACL:                            types.ObjectCannedACL(fs.config.ACL),
+		ContentType:                    util.NilIfEmpty(contentType),
+		ObjectLockMode:                 lockOpts.mode,
+		ObjectLockRetainUntilDate:      lockOpts.retainUntil,
+		ObjectLockLegalHoldStatus:      lockOpts.legalHold,
 	})
 
 	metric.S3CopyObjectCompleted(err)
@@ -734,6 +878,14 @@ func (fs *S3Fs) renameInternal(source, target string, fi os.FileInfo, recursion
 			}
 		}
 	} else {
+		// the source is removed after the copy, if it is locked we fail early
+		// to avoid leaving a copy behind
+		if err := fs.checkObjectLock(source); err != nil {
+			return numFiles, filesSize, err
+		}
+		if err := fs.checkObjectLock(target); err != nil {
+			return numFiles, filesSize, err
+		}
 		if err := fs.copyFileInternal(source, target, fi.Size()); err != nil {
 			return numFiles, filesSize, err
 		}
@@ -791,16 +943,35 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	return false, nil
 }
 
-func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int64) error {
+// doMultipartCopy copies the source object to the target using UploadPartCopy.
+// This is required for objects bigger than 5GB and it is faster for smaller
+// objects too since the parts are copied in parallel. The copy fails if the
+// source object is modified while copying
+func (fs *S3Fs) doMultipartCopy(source, target, contentType string, srcObj *s3.HeadObjectOutput,
+	metadata map[string]string,
+) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
+	lockOpts := fs.getObjectLockOptions()
 	res, err := fs.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-		Bucket:       aws.String(fs.config.Bucket),
-		Key:          aws.String(target),
-		StorageClass: types.StorageClass(fs.config.StorageClass),
-		ACL:          types.ObjectCannedACL(fs.config.ACL),
-		ContentType:  util.NilIfEmpty(contentType),
+		Bucket:                    aws.String(fs.config.Bucket),
+		Key:                       aws.String(target),
+		SSECustomerAlgorithm:      fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:            fs.sseCustomerKey,
+		SSECustomerKeyMD5:         fs.sseCustomerKeyMD5,
+		ServerSideEncryption:      fs.getServerSideEncryption(),
+		SSEKMSKeyId:               util.NilIfEmpty(fs.config.SSEKMSKeyID),
+		StorageClass:              types.StorageClass(fs.config.StorageClass),
+		ACL:                       types.ObjectCannedACL(fs.config.ACL),
+		ContentType:               util.NilIfEmpty(contentType),
+		ContentEncoding:           srcObj.ContentEncoding,
+		ContentDisposition:        srcObj.ContentDisposition,
+		CacheControl:              srcObj.CacheControl,
+		Metadata:                  metadata,
+		ObjectLockMode:            lockOpts.mode,
+		ObjectLockRetainUntilDate: lockOpts.retainUntil,
+		ObjectLockLegalHoldStatus: lockOpts.legalHold,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to create multipart copy request: %w", err)
@@ -809,12 +980,8 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	if uploadID == "" {
 		return errors.New("unable to get multipart copy upload ID")
 	}
-	// We use 32 MB part size and copy 10 parts in parallel.
-	// These values are arbitrary. We don't want to start too many goroutines
-	maxPartSize := int64(32 * 1024 * 1024)
-	if fileSize > int64(100*1024*1024*1024) {
-		maxPartSize = int64(500 * 1024 * 1024)
-	}
+	fileSize := util.GetIntFromPointer(srcObj.ContentLength)
+	maxPartSize := getMultipartCopyPartSize(fileSize)
 	guard := make(chan struct{}, 10)
 	finished := false
 	var completedParts []types.CompletedPart
@@ -851,16 +1018,23 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 				wg.Done()
 			}()
 
-			innerCtx, innerCancelFn := context.WithDeadline(opCtx, time.Now().Add(fs.ctxTimeout))
+			innerCtx, innerCancelFn := context.WithDeadline(opCtx, time.Now().Add(s3MultipartCopyTimeout))
 			defer innerCancelFn()
 
 			partResp, err := fs.svc.UploadPartCopy(innerCtx, &s3.UploadPartCopyInput{
-				Bucket:          aws.String(fs.config.Bucket),
-				CopySource:      aws.String(source),
-				Key:             aws.String(target),
-				PartNumber:      &partNum,
-				UploadId:        aws.String(uploadID),
-				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", partStart, partEnd-1)),
+				Bucket:                         aws.String(fs.config.Bucket),
+				CopySource:                     aws.String(source),
+				CopySourceIfMatch:              srcObj.ETag,
+				Key:                            aws.String(target),
+				SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+				SSECustomerKey:                 fs.sseCustomerKey,
+				SSECustomerKeyMD5:              fs.sseCustomerKeyMD5,
+				CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+				CopySourceSSECustomerKey:       fs.sseCustomerKey,
+				CopySourceSSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+				PartNumber:                     &partNum,
+				UploadId:                       aws.String(uploadID),
+				CopySourceRange:                aws.String(fmt.Sprintf("bytes=%d-%d", partStart, partEnd-1)),
 			})
 			if err != nil {
 				errOnce.Do(func() {
@@ -910,23 +1084,212 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 		return getPartNumber(completedParts[i].PartNumber) < getPartNumber(completedParts[j].PartNumber)
 	})
 
-	completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(s3MultipartCopyTimeout))
 	defer completeCancelFn()
 
 	_, err = fs.svc.CompleteMultipartUpload(completeCtx, &s3.CompleteMultipartUploadInput{
-		Bucket:   aws.String(fs.config.Bucket),
-		Key:      aws.String(target),
-		UploadId: aws.String(uploadID),
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(target),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.sseCustomerKey,
+		SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+		UploadId:             aws.String(uploadID),
 		MultipartUpload: &types.CompletedMultipartUpload{
 			Parts: completedParts,
 		},
 	})
 	if err != nil {
+		abortCtx, abortCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer abortCancelFn()
+
+		_, errAbort := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(fs.config.Bucket),
+			Key:      aws.String(target),
+			UploadId: aws.String(uploadID),
+		})
+		if errAbort != nil {
+			fsLog(fs, logger.LevelError, "unable to abort multipart copy: %+v", errAbort)
+		}
 		return fmt.Errorf("unable to complete multipart upload: %w", err)
 	}
 	return nil
 }
 
+// getMultipartCopyPartSize returns the part size to use to copy an object
+// with the specified size. We use 32 MB parts, 500 MB for objects bigger than
+// 100 GB, and copy 10 parts in parallel. These values are arbitrary, we don't
+// want to start too many goroutines. The part size is increased if needed to
+// respect the maximum number of parts
+func getMultipartCopyPartSize(fileSize int64) int64 {
+	partSize := int64(32 * 1024 * 1024)
+	if fileSize > int64(100*1024*1024*1024) {
+		partSize = int64(500 * 1024 * 1024)
+	}
+	if minPartSize := (fileSize + int64(s3MaxParts) - 1) / int64(s3MaxParts); partSize < minPartSize {
+		partSize = minPartSize
+	}
+	return partSize
+}
+
+// resumeMultipartUpload appends the data read from the reader to the existing
+// object. A new multipart upload is created, the existing data is copied server
+// side as its first parts and the new data is uploaded as the following parts,
+// so an interrupted upload can be resumed without transferring the existing
+// data again
+func (fs *S3Fs) resumeMultipartUpload(ctx context.Context, name, contentType string, obj *s3.HeadObjectOutput,
+	reader io.Reader,
+) error {
+	createCtx, createCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	defer createCancelFn()
+
+	lockOpts := fs.getObjectLockOptions()
+	res, err := fs.svc.CreateMultipartUpload(createCtx, &s3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(fs.config.Bucket),
+		Key:                       aws.String(name),
+		SSECustomerAlgorithm:      fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:            fs.sseCustomerKey,
+		SSECustomerKeyMD5:         fs.sseCustomerKeyMD5,
+		ServerSideEncryption:      fs.getServerSideEncryption(),
+		SSEKMSKeyId:               util.NilIfEmpty(fs.config.SSEKMSKeyID),
+		StorageClass:              types.StorageClass(fs.config.StorageClass),
+		ACL:                       types.ObjectCannedACL(fs.config.ACL),
+		ContentType:               util.NilIfEmpty(contentType),
+		ObjectLockMode:            lockOpts.mode,
+		ObjectLockRetainUntilDate: lockOpts.retainUntil,
+		ObjectLockLegalHoldStatus: lockOpts.legalHold,
+		ChecksumAlgorithm:         lockOpts.checksum,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create multipart upload for resume: %w", err)
+	}
+	uploadID := util.GetStringFromPointer(res.UploadId)
+	if uploadID == "" {
+		return errors.New("unable to get multipart upload ID for resume")
+	}
+	completedParts, err := fs.uploadResumeParts(ctx, name, uploadID, lockOpts.checksum, obj, reader)
+	if err != nil {
+		abortCtx, abortCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer abortCancelFn()
+
+		_, errAbort := fs.svc.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(fs.config.Bucket),
+			Key:      aws.String(name),
+			UploadId: aws.String(uploadID),
+		})
+		if errAbort != nil {
+			fsLog(fs, logger.LevelError, "unable to abort multipart upload for resume: %+v", errAbort)
+		}
+		return err
+	}
+
+	completeCtx, completeCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	defer completeCancelFn()
+
+	_, err = fs.svc.CompleteMultipartUpload(completeCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(name),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.sseCustomerKey,
+		SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+		UploadId:             aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to complete multipart upload for resume: %w", err)
+	}
+	return nil
+}
+
+func (fs *S3Fs) uploadResumeParts(ctx context.Context, name, uploadID string, checksum types.ChecksumAlgorithm,
+	obj *s3.HeadObjectOutput, reader io.Reader,
+) ([]types.CompletedPart, error) {
+	var completedParts []types.CompletedPart
+	var partNumber int32
+
+	partSize := fs.config.UploadPartSize
+	if partSize < s3MinPartSize {
+		partSize = s3MinPartSize
+	}
+	size := util.GetIntFromPointer(obj.ContentLength)
+	source := pathEscape(fs.Join(fs.config.Bucket, name))
+	for offset := int64(0); offset < size; {
+		end := offset + partSize
+		// the remaining data is too small for a part, we add it to the current one
+		if size-end < s3MinPartSize {
+			end = size
+		}
+		partNumber++
+		partCtx, partCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+		partResp, err := fs.svc.UploadPartCopy(partCtx, &s3.UploadPartCopyInput{
+			Bucket:                         aws.String(fs.config.Bucket),
+			CopySource:                     aws.String(source),
+			CopySourceIfMatch:              obj.ETag,
+			Key:                            aws.String(name),
+			SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+			SSECustomerKey:                 fs.sseCustomerKey,
+			SSECustomerKeyMD5:              fs.sseCustomerKeyMD5,
+			CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+			CopySourceSSECustomerKey:       fs.sseCustomerKey,
+			CopySourceSSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+			PartNumber:                     aws.Int32(partNumber),
+			UploadId:                       aws.String(uploadID),
+			CopySourceRange:                aws.String(fmt.Sprintf("bytes=%d-%d", offset, end-1)),
+		})
+		partCancelFn()
+		if err != nil {
+			return nil, fmt.Errorf("unable to copy existing data, part number %d: %w", partNumber, err)
+		}
+		completedParts = append(completedParts, types.CompletedPart{
+			ETag:          partResp.CopyPartResult.ETag,
+			ChecksumCRC32: partResp.CopyPartResult.ChecksumCRC32,
+			PartNumber:    aws.Int32(partNumber),
+		})
+		offset = end
+	}
+	fsLog(fs, logger.LevelDebug, "resuming upload for %q, existing data copied server side, size: %d, parts: %d",
+		name, size, partNumber)
+
+	buf := make([]byte, partSize)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			partNumber++
+			if partNumber > s3MaxParts {
+				return nil, fmt.Errorf("unable to resume upload, exceeded the maximum number of parts: %d", s3MaxParts)
+			}
+			partCtx, partCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+			partResp, errUpload := fs.svc.UploadPart(partCtx, &s3.UploadPartInput{
+				Bucket:               aws.String(fs.config.Bucket),
+				Key:                  aws.String(name),
+				SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+				SSECustomerKey:       fs.sseCustomerKey,
+				SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+				PartNumber:           aws.Int32(partNumber),
+				UploadId:             aws.String(uploadID),
+				Body:                 bytes.NewReader(buf[:n]),
+				ChecksumAlgorithm:    checksum,
+			})
+			partCancelFn()
+			if errUpload != nil {
+				return nil, fmt.Errorf("unable to upload part number %d: %w", partNumber, errUpload)
+			}
+			completedParts = append(completedParts, types.CompletedPart{
+				ETag:          partResp.ETag,
+				ChecksumCRC32: partResp.ChecksumCRC32,
+				PartNumber:    aws.Int32(partNumber),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return completedParts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func (fs *S3Fs) getPrefix(name string) string {
 	prefix := ""
 	if name != "" && name != "." && name != "/" {
@@ -938,18 +1301,148 @@ func (fs *S3Fs) getPrefix(name string) string {
 	return prefix
 }
 
+// RestoreArchived implements the FsArchiveRestorer interface. A temporary
+// copy of objects in the Glacier and Deep Archive storage classes is restored
+// using the standard retrieval tier, this can take up to 12 hours for Glacier
+// and up to 48 hours for Deep Archive
+func (fs *S3Fs) RestoreArchived(name string) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	switch getS3ArchiveStatus(obj) {
+	case ArchiveStatusNone:
+		return fmt.Errorf("%q is not archived", name)
+	case ArchiveStatusRestoring:
+		fsLog(fs, logger.LevelDebug, "restore already in progress for %q", name)
+		return nil
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	restoreRequest := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{
+			Tier: types.TierStandard,
+		},
+	}
+	if obj.ArchiveStatus == "" {
+		restoreRequest.Days = aws.Int32(s3RestoreDays)
+	} else {
+		// Intelligent-Tiering archive access tiers do not allow to specify
+		// the retrieval tier
+		restoreRequest.GlacierJobParameters = nil
+	}
+	_, err = fs.svc.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(fs.config.Bucket),
+		Key:            aws.String(name),
+		RestoreRequest: restoreRequest,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		err = nil
+	}
+	fsLog(fs, logger.LevelInfo, "restore requested for %q, storage class %q, archive status %q, err: %v",
+		name, obj.StorageClass, obj.ArchiveStatus, err)
+	return err
+}
+
+// GetArchiveStatus implements the FsArchiveRestorer interface
+func (fs *S3Fs) GetArchiveStatus(name string) (int, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return ArchiveStatusNone, err
+	}
+	return getS3ArchiveStatus(obj), nil
+}
+
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	obj, err := fs.svc.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(name),
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(name),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.sseCustomerKey,
+		SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
 	})
 	metric.S3HeadObjectCompleted(err)
 	return obj, err
 }
 
+// getSSECustomerAlgorithm returns the algorithm to use for SSE-C, nil if
+// customer provided keys are not configured
+func (fs *S3Fs) getSSECustomerAlgorithm() *string {
+	if fs.sseCustomerKey == nil {
+		return nil
+	}
+	return aws.String("AES256")
+}
+
+// getServerSideEncryption returns the server side encryption to request for
+// new objects. Empty means the bucket default
+// hasContentETag returns true if the entity tags computed by S3 can be used
+// as content validators. Objects encrypted using SSE-KMS or SSE-C have an
+// entity tag that is not the MD5 of their contents
+func (fs *S3Fs) hasContentETag() bool {
+	return fs.config.SSEKMSKeyID == "" && fs.sseCustomerKey == nil
+}
+
+func (fs *S3Fs) getServerSideEncryption() types.ServerSideEncryption {
+	if fs.config.SSEKMSKeyID != "" {
+		return types.ServerSideEncryptionAwsKms
+	}
+	return ""
+}
+
+// checkObjectLock returns an error wrapping ErrObjectLocked if the specified
+// object exists and it is under retention or legal hold
+func (fs *S3Fs) checkObjectLock(name string) error {
+	if !fs.config.isObjectLockEnabled() {
+		return nil
+	}
+	obj, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.getObjectLockError(name, obj)
+}
+
+func (fs *S3Fs) getObjectLockError(name string, obj *s3.HeadObjectOutput) error {
+	if obj.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn {
+		fsLog(fs, logger.LevelWarn, "object %q is under legal hold, it cannot be modified", name)
+		return fmt.Errorf("%w: %q is under legal hold", ErrObjectLocked, name)
+	}
+	if retainUntil := util.GetTimeFromPointer(obj.ObjectLockRetainUntilDate); retainUntil.After(time.Now()) {
+		fsLog(fs, logger.LevelWarn, "object %q is under %s retention until %s, it cannot be modified",
+			name, obj.ObjectLockMode, retainUntil.UTC().Format(time.RFC3339))
+		return fmt.Errorf("%w: %q is under %s retention until %s", ErrObjectLocked, name, obj.ObjectLockMode,
+			retainUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// getObjectLockOptions returns the object lock settings to apply to new objects.
+// Uploads to buckets with object lock enabled require an integrity checksum
+func (fs *S3Fs) getObjectLockOptions() s3ObjectLockOptions {
+	var opts s3ObjectLockOptions
+	if fs.config.ObjectLockMode != "" {
+		opts.mode = types.ObjectLockMode(fs.config.ObjectLockMode)
+		opts.retainUntil = aws.Time(time.Now().AddDate(0, 0, fs.config.ObjectLockRetentionDays).UTC())
+	}
+	if fs.config.ObjectLockLegalHold {
+		opts.legalHold = types.ObjectLockLegalHoldStatusOn
+	}
+	if fs.config.isObjectLockEnabled() {
+		opts.checksum = types.ChecksumAlgorithmCrc32
+	}
+	return opts
+}
+
 // GetMimeType returns the content type
 func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	obj, err := fs.headObject(name)
@@ -959,6 +1452,103 @@ func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	return util.GetStringFromPointer(obj.ContentType), nil
 }
 
+// GetXattr implements the FsXattrer interface, extended attributes are
+// stored as object metadata
+func (fs *S3Fs) GetXattr(name, attr string) ([]byte, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrFromMetadata(obj.Metadata, attr)
+}
+
+// ListXattr implements the FsXattrer interface
+func (fs *S3Fs) ListXattr(name string) ([]string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return listXattrFromMetadata(obj.Metadata), nil
+}
+
+// SetXattr implements the FsXattrer interface
+func (fs *S3Fs) SetXattr(name, attr string, value []byte) error {
+	return fs.updateMetadata(name, func(metadata map[string]string) error {
+		setXattrInMetadata(metadata, attr, value)
+		return nil
+	})
+}
+
+// RemoveXattr implements the FsXattrer interface
+func (fs *S3Fs) RemoveXattr(name, attr string) error {
+	return fs.updateMetadata(name, func(metadata map[string]string) error {
+		return removeXattrFromMetadata(metadata, attr)
+	})
+}
+
+// updateMetadata replaces the metadata for the specified object. S3 objects
+// metadata cannot be modified, so the object is copied over itself
+func (fs *S3Fs) updateMetadata(name string, update func(map[string]string) error) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	if fs.config.isObjectLockEnabled() {
+		if err := fs.getObjectLockError(name, obj); err != nil {
+			return err
+		}
+	}
+	metadata := make(map[string]string, len(obj.Metadata)+1)
+	for k, v := range obj.Metadata {
+		metadata[k] = v
+	}
+	if err := update(metadata); err != nil {
+		return err
+	}
+	defer metadataCache.invalidate(fs.cacheBackend, name)
+
+	if size := util.GetIntFromPointer(obj.ContentLength); size > s3CopyObjectThreshold {
+		fsLog(fs, logger.LevelDebug, "updating metadata for %q with size %d using multipart copy", name, size)
+		err = fs.doMultipartCopy(pathEscape(fs.Join(fs.config.Bucket, name)), name,
+			util.GetStringFromPointer(obj.ContentType), obj, metadata)
+		metric.S3CopyObjectCompleted(err)
+		return err
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	lockOpts := fs.getObjectLockOptions()
+	_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(pathEscape(fs.Join(fs.config.Bucket, name))),
+		Key:                            aws.String(name),
+		SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:                 fs.sseCustomerKey,
+		SSECustomerKeyMD5:              fs.sseCustomerKeyMD5,
+		CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		CopySourceSSECustomerKey:       fs.sseCustomerKey,
+		CopySourceSSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
+		ServerSideEncryption:           fs.getServerSideEncryption(),
+		SSEKMSKeyId:                    util.NilIfEmpty(fs.config.SSEKMSKeyID),
+		StorageClass:                   types.StorageClass(fs.config.StorageClass),
+		ACL:                            types.ObjectCannedACL(fs.config.ACL),
+		ContentType:                    obj.ContentType,
+		ContentEncoding:                obj.ContentEncoding,
+		ContentDisposition:             obj.ContentDisposition,
+		CacheControl:                   obj.CacheControl,
+		Metadata:                       metadata,
+		MetadataDirective:              types.MetadataDirectiveReplace,
+		// the copy is a new version of the object, so the retention must be
+		// applied again
+		ObjectLockMode:            lockOpts.mode,
+		ObjectLockRetainUntilDate: lockOpts.retainUntil,
+		ObjectLockLegalHoldStatus: lockOpts.legalHold,
+	})
+	metric.S3CopyObjectCompleted(err)
+	return err
+}
+
 // Close closes the fs
 func (*S3Fs) Close() error {
 	return nil
@@ -986,8 +1576,11 @@ func (fs *S3Fs) downloadToWriter(name string, w PipeWriter) (int64, error) {
 	})
 
 	n, err := downloader.Download(ctx, w, &s3.GetObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(name),
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(name),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.sseCustomerKey,
+		SSECustomerKeyMD5:    fs.sseCustomerKeyMD5,
 	})
 	fsLog(fs, logger.LevelDebug, "download before resuming upload completed, path %q size: %d, err: %+v",
 		name, n, err)
@@ -995,6 +1588,13 @@ func (fs *S3Fs) downloadToWriter(name string, w PipeWriter) (int64, error) {
 	return n, err
 }
 
+type s3ObjectLockOptions struct {
+	mode        types.ObjectLockMode
+	retainUntil *time.Time
+	legalHold   types.ObjectLockLegalHoldStatus
+	checksum    types.ChecksumAlgorithm
+}
+
 type s3DirLister struct {
 	baseDirLister
 	paginator     *s3.ListObjectsV2Paginator
@@ -1002,6 +1602,9 @@ type s3DirLister struct {
 	prefix        string
 	prefixes      map[string]bool
 	metricUpdated bool
+	cacheBackend  string
+	// true if the object entity tags are content hashes
+	hasContentETag bool
 }
 
 func (l *s3DirLister) resolve(name *string) (string, bool) {
@@ -1061,7 +1664,14 @@ func (l *s3DirLister) Next(limit int) ([]os.FileInfo, error) {
 			l.prefixes[name] = true
 		}
 
-		l.cache = append(l.cache, NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false))
+		info := NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false)
+		if !isDir {
+			if l.hasContentETag {
+				info.SetETag(getS3ETag(fileObject.ETag))
+			}
+			metadataCache.addListedFile(l.cacheBackend, l.prefix, name, objectSize, objectModTime, info.ETag())
+		}
+		l.cache = append(l.cache, info)
 	}
 	return l.returnFromCache(limit), nil
 }
@@ -1096,6 +1706,35 @@ func getAWSHTTPClient(timeout int, idleConnectionTimeout time.Duration, skipTLSV
 	return c
 }
 
+// getS3WebIdentitySessionName returns a valid role session name for the
+// specified user: allowed characters are letters, digits and "+=,.@-_",
+// the maximum length is 64
+func getS3WebIdentitySessionName(username string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			strings.ContainsRune("+=,.@-_", r) {
+			return r
+		}
+		return '_'
+	}, "sftpgo-"+username)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// s3WebIdentityTokenRetriever implements stscreds.IdentityTokenRetriever, it
+// returns the OpenID Connect ID token for the associated user
+type s3WebIdentityTokenRetriever string
+
+func (r s3WebIdentityTokenRetriever) GetIdentityToken() ([]byte, error) {
+	token, err := webIdentityTokens.get(string(r))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(token), nil
+}
+
 // ideally we should simply use url.PathEscape:
 //
 // https://github.com/awsdocs/aws-doc-sdk-examples/blob/master/go/example_code/s3/s3_copy_object.go#L65
@@ -1106,3 +1745,32 @@ func pathEscape(in string) string {
 	u.Path = in
 	return strings.ReplaceAll(u.String(), "+", "%2B")
 }
+
+// getS3ArchiveStatus returns the archive status for the object with the given
+// attributes. The restore header is set while a restore is in progress and
+// after it completes, until the restored copy expires
+func getS3ArchiveStatus(obj *s3.HeadObjectOutput) int {
+	if obj.ArchiveStatus == "" && obj.StorageClass != types.StorageClassGlacier &&
+		obj.StorageClass != types.StorageClassDeepArchive {
+		return ArchiveStatusNone
+	}
+	restore := util.GetStringFromPointer(obj.Restore)
+	switch {
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return ArchiveStatusRestoring
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return ArchiveStatusNone
+	default:
+		return ArchiveStatusArchived
+	}
+}
+
+// checkS3ArchivedError returns ErrObjectArchived if the error reports that the
+// requested object is archived and not restored
+func checkS3ArchivedError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState" {
+		return fmt.Errorf("%w: %v", ErrObjectArchived, err)
+	}
+	return err
+}
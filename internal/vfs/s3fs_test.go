@@ -0,0 +1,744 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nos3
+// +build !nos3
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeS3Bucket  = "bucket"
+	fakeS3TimeFmt = "2006-01-02T15:04:05.000Z"
+	// the maximum size of a part copied using UploadPartCopy
+	fakeS3MaxCopyPartSize = 5 * 1024 * 1024 * 1024
+)
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	metadata    map[string]string
+}
+
+type fakeS3Upload struct {
+	key         string
+	contentType string
+	metadata    map[string]string
+	parts       map[int][]byte
+}
+
+// fakeS3Server is an in memory implementation of the S3 REST API subset used
+// by S3Fs, it only supports path style requests for a single bucket
+type fakeS3Server struct {
+	*httptest.Server
+	mu        sync.Mutex
+	objects   map[string]*fakeS3Object
+	uploads   map[string]*fakeS3Upload
+	uploadIdx int
+	ops       map[string]int
+	// if set it is called for each request, a status code different from
+	// zero is returned as an error response without processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeS3Server(t *testing.T) *fakeS3Server {
+	s := &fakeS3Server{
+		objects: make(map[string]*fakeS3Object),
+		uploads: make(map[string]*fakeS3Upload),
+		ops:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeS3Server) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeS3Server) getObject(key string) (fakeS3Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return fakeS3Object{}, false
+	}
+	return *obj, true
+}
+
+func (s *fakeS3Server) getUploadsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.uploads)
+}
+
+func (s *fakeS3Server) putObject(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = newFakeS3Object(data, "", nil)
+}
+
+func getFakeS3ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func newFakeS3Object(data []byte, contentType string, metadata map[string]string) *fakeS3Object {
+	return &fakeS3Object{
+		data:        data,
+		contentType: contentType,
+		etag:        getFakeS3ETag(data),
+		modTime:     time.Now().UTC().Truncate(time.Second),
+		metadata:    metadata,
+	}
+}
+
+func (s *fakeS3Server) getOperation(r *http.Request, key string) string {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodGet:
+		if key == "" {
+			return "ListObjectsV2"
+		}
+		return "GetObject"
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			if query.Has("uploadId") {
+				return "UploadPartCopy"
+			}
+			return "CopyObject"
+		}
+		if query.Has("uploadId") {
+			return "UploadPart"
+		}
+		return "PutObject"
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return "AbortMultipartUpload"
+		}
+		return "DeleteObject"
+	case http.MethodPost:
+		if query.Has("uploads") {
+			return "CreateMultipartUpload"
+		}
+		if query.Has("uploadId") {
+			return "CompleteMultipartUpload"
+		}
+	}
+	return "Unknown"
+}
+
+func (s *fakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	bucketPath := "/" + fakeS3Bucket
+	if r.URL.Path != bucketPath && !strings.HasPrefix(r.URL.Path, bucketPath+"/") {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPath), "/")
+	op := s.getOperation(r, key)
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			writeFakeS3Error(w, r, status, "InjectedError")
+			return
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case "HeadObject", "GetObject":
+		s.handleGetObject(w, r, key)
+	case "PutObject":
+		s.objects[key] = newFakeS3Object(body, r.Header.Get("Content-Type"), getFakeS3Metadata(r))
+		w.Header().Set("ETag", s.objects[key].etag)
+	case "CopyObject":
+		s.handleCopyObject(w, r, key)
+	case "DeleteObject":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case "ListObjectsV2":
+		s.handleListObjects(w, r)
+	case "CreateMultipartUpload":
+		s.handleCreateMultipartUpload(w, r, key)
+	case "UploadPart", "UploadPartCopy":
+		s.handleUploadPart(w, r, key, body, op == "UploadPartCopy")
+	case "CompleteMultipartUpload":
+		s.handleCompleteMultipartUpload(w, r, key, body)
+	case "AbortMultipartUpload":
+		if _, ok := s.getUpload(w, r, key); ok {
+			delete(s.uploads, r.URL.Query().Get("uploadId"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeFakeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3Server) handleGetObject(w http.ResponseWriter, r *http.Request, key string) {
+	obj, ok := s.objects[key]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	for k, v := range obj.metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	data := obj.data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseTestHTTPRange(rangeHeader, int64(len(data)))
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data) //nolint:errcheck
+	}
+}
+
+// getCopySource returns the source object for copy requests
+func (s *fakeS3Server) getCopySource(r *http.Request) (*fakeS3Object, int, string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return nil, http.StatusBadRequest, "InvalidArgument"
+	}
+	source = strings.TrimPrefix(source, "/")
+	if !strings.HasPrefix(source, fakeS3Bucket+"/") {
+		return nil, http.StatusNotFound, "NoSuchBucket"
+	}
+	obj, ok := s.objects[strings.TrimPrefix(source, fakeS3Bucket+"/")]
+	if !ok {
+		return nil, http.StatusNotFound, "NoSuchKey"
+	}
+	if ifMatch := r.Header.Get("X-Amz-Copy-Source-If-Match"); ifMatch != "" && ifMatch != obj.etag {
+		return nil, http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	return obj, 0, ""
+}
+
+func (s *fakeS3Server) handleCopyObject(w http.ResponseWriter, r *http.Request, key string) {
+	src, status, code := s.getCopySource(r)
+	if src == nil {
+		writeFakeS3Error(w, r, status, code)
+		return
+	}
+	contentType := src.contentType
+	metadata := src.metadata
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		contentType = r.Header.Get("Content-Type")
+		metadata = getFakeS3Metadata(r)
+	}
+	obj := newFakeS3Object(append([]byte(nil), src.data...), contentType, metadata)
+	s.objects[key] = obj
+	writeFakeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string   `xml:"ETag"`
+		LastModified string   `xml:"LastModified"`
+	}{
+		ETag:         obj.etag,
+		LastModified: obj.modTime.Format(fakeS3TimeFmt),
+	})
+}
+
+func (s *fakeS3Server) getUpload(w http.ResponseWriter, r *http.Request, key string) (*fakeS3Upload, bool) {
+	upload, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok || upload.key != key {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return nil, false
+	}
+	return upload, true
+}
+
+func (s *fakeS3Server) handleCreateMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	s.uploadIdx++
+	uploadID := fmt.Sprintf("upload%d", s.uploadIdx)
+	s.uploads[uploadID] = &fakeS3Upload{
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    getFakeS3Metadata(r),
+		parts:       make(map[int][]byte),
+	}
+	writeFakeS3XML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{
+		Bucket:   fakeS3Bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *fakeS3Server) handleUploadPart(w http.ResponseWriter, r *http.Request, key string, body []byte, isCopy bool) {
+	upload, ok := s.getUpload(w, r, key)
+	if !ok {
+		return
+	}
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	if !isCopy {
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", getFakeS3ETag(body))
+		return
+	}
+	src, status, code := s.getCopySource(r)
+	if src == nil {
+		writeFakeS3Error(w, r, status, code)
+		return
+	}
+	data := src.data
+	if val := r.Header.Get("X-Amz-Copy-Source-Range"); val != "" {
+		start, end, ok := parseTestHTTPRange(val, int64(len(data)))
+		if !ok || end-start+1 > fakeS3MaxCopyPartSize {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidRange")
+			return
+		}
+		data = data[start : end+1]
+	}
+	upload.parts[partNumber] = append([]byte(nil), data...)
+	writeFakeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		ETag         string   `xml:"ETag"`
+		LastModified string   `xml:"LastModified"`
+	}{
+		ETag:         getFakeS3ETag(data),
+		LastModified: time.Now().UTC().Format(fakeS3TimeFmt),
+	})
+}
+
+func (s *fakeS3Server) handleCompleteMultipartUpload(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	upload, ok := s.getUpload(w, r, key)
+	if !ok {
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var data []byte
+	for idx, part := range req.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != getFakeS3ETag(partData) {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		if idx > 0 && part.PartNumber <= req.Parts[idx-1].PartNumber {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder")
+			return
+		}
+		// all the parts, except the last one, must be at least 5 MB
+		if idx < len(req.Parts)-1 && int64(len(partData)) < s3MinPartSize {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "EntityTooSmall")
+			return
+		}
+		data = append(data, partData...)
+	}
+	delete(s.uploads, r.URL.Query().Get("uploadId"))
+	obj := newFakeS3Object(data, upload.contentType, upload.metadata)
+	// the entity tag is the MD5 of the parts MD5s followed by the number of parts
+	partsHash := md5.New()
+	for _, part := range req.Parts {
+		partHash := md5.Sum(upload.parts[part.PartNumber])
+		partsHash.Write(partHash[:])
+	}
+	obj.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(partsHash.Sum(nil)), len(req.Parts))
+	s.objects[key] = obj
+	writeFakeS3XML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{
+		Bucket: fakeS3Bucket,
+		Key:    key,
+		ETag:   obj.etag,
+	})
+}
+
+type fakeS3ListContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type fakeS3ListPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *fakeS3Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := 1000
+	if val, err := strconv.Atoi(query.Get("max-keys")); err == nil && val > 0 {
+		maxKeys = val
+	}
+	startAfter := query.Get("continuation-token")
+
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var contents []fakeS3ListContent
+	var prefixes []fakeS3ListPrefix
+	seenPrefixes := make(map[string]bool)
+	var nextToken string
+	count := 0
+	for _, k := range keys {
+		if startAfter != "" && k <= startAfter {
+			continue
+		}
+		if count >= maxKeys {
+			nextToken = contents[len(contents)-1].Key
+			if len(prefixes) > 0 && prefixes[len(prefixes)-1].Prefix > nextToken {
+				nextToken = prefixes[len(prefixes)-1].Prefix
+			}
+			break
+		}
+		if delimiter != "" {
+			if idx := strings.Index(k[len(prefix):], delimiter); idx >= 0 {
+				commonPrefix := k[:len(prefix)+idx+len(delimiter)]
+				if !seenPrefixes[commonPrefix] {
+					seenPrefixes[commonPrefix] = true
+					prefixes = append(prefixes, fakeS3ListPrefix{Prefix: commonPrefix})
+					count++
+				}
+				continue
+			}
+		}
+		obj := s.objects[k]
+		contents = append(contents, fakeS3ListContent{
+			Key:          k,
+			LastModified: obj.modTime.Format(fakeS3TimeFmt),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+		})
+		count++
+	}
+	writeFakeS3XML(w, struct {
+		XMLName               xml.Name            `xml:"ListBucketResult"`
+		Name                  string              `xml:"Name"`
+		Prefix                string              `xml:"Prefix"`
+		KeyCount              int                 `xml:"KeyCount"`
+		MaxKeys               int                 `xml:"MaxKeys"`
+		IsTruncated           bool                `xml:"IsTruncated"`
+		NextContinuationToken string              `xml:"NextContinuationToken,omitempty"`
+		Contents              []fakeS3ListContent `xml:"Contents"`
+		CommonPrefixes        []fakeS3ListPrefix  `xml:"CommonPrefixes"`
+	}{
+		Name:                  fakeS3Bucket,
+		Prefix:                prefix,
+		KeyCount:              count,
+		MaxKeys:               maxKeys,
+		IsTruncated:           nextToken != "",
+		NextContinuationToken: nextToken,
+		Contents:              contents,
+		CommonPrefixes:        prefixes,
+	})
+}
+
+func getFakeS3Metadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range r.Header {
+		if len(v) > 0 && strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			metadata[strings.ToLower(k[len("x-amz-meta-"):])] = v[0]
+		}
+	}
+	return metadata
+}
+
+func writeFakeS3XML(w http.ResponseWriter, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	w.Write(data)               //nolint:errcheck
+}
+
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, code)
+}
+
+func newTestS3Fs(t *testing.T, server *fakeS3Server) *S3Fs {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	fs, err := NewS3Fs("connID", t.TempDir(), "", S3FsConfig{
+		BaseS3FsConfig: sdk.BaseS3FsConfig{
+			Bucket:         fakeS3Bucket,
+			Region:         "us-east-1",
+			Endpoint:       server.URL,
+			AccessKey:      "access_key",
+			ForcePathStyle: true,
+		},
+		AccessSecret: kms.NewPlainSecret("access_secret"),
+	})
+	require.NoError(t, err)
+	return fs.(*S3Fs)
+}
+
+func uploadTestS3File(t *testing.T, fs *S3Fs, name string, data []byte) {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func downloadTestS3File(t *testing.T, fs *S3Fs, name string, offset int64) []byte {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func TestS3FsBasicOperations(t *testing.T) {
+	server := newFakeS3Server(t)
+	fs := newTestS3Fs(t, server)
+
+	uploadTestS3File(t, fs, "dir/file.txt", []byte("content"))
+	obj, ok := server.getObject("dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+	assert.Equal(t, "text/plain; charset=utf-8", obj.contentType)
+	assert.Equal(t, []byte("ntent"), downloadTestS3File(t, fs, "dir/file.txt", 2))
+
+	info, err := fs.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, int64(7), info.Size())
+	assert.Equal(t, getFakeS3ETag([]byte("content")), GetETag(info))
+	entries := listTestDir(t, fs, "dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, getFakeS3ETag([]byte("content")), GetETag(entries[0]))
+	}
+
+	require.NoError(t, fs.Mkdir("empty"))
+	_, ok = server.getObject("empty/")
+	assert.True(t, ok)
+	entries = listTestDir(t, fs, "")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.True(t, e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"dir", "empty"}, names)
+
+	numFiles, size, err := fs.Rename("dir/file.txt", "empty/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	_, ok = server.getObject("dir/file.txt")
+	assert.False(t, ok)
+	assert.Equal(t, []byte("content"), downloadTestS3File(t, fs, "empty/file.txt", 0))
+
+	err = fs.Remove("empty", true)
+	assert.ErrorContains(t, err, "non empty directory")
+	require.NoError(t, fs.Remove("empty/file.txt", false))
+	require.NoError(t, fs.Remove("empty", true))
+	_, err = fs.Stat("empty")
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func resumeTestS3Upload(t *testing.T, fs *S3Fs, name string, offset int64, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, CheckResume)
+	require.NoError(t, err)
+	// the data before the resume offset cannot be overwritten
+	_, err = w.WriteAt(data, offset-1)
+	assert.Error(t, err)
+	_, err = w.WriteAt(data, offset)
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func TestS3ResumeUpload(t *testing.T) {
+	SetResumeMaxSize(1024)
+	t.Cleanup(func() {
+		SetResumeMaxSize(0)
+	})
+	server := newFakeS3Server(t)
+	fs := newTestS3Fs(t, server)
+	assert.True(t, fs.IsConditionalUploadResumeSupported(1024))
+	assert.False(t, fs.IsConditionalUploadResumeSupported(1025))
+	assert.True(t, fs.IsConditionalUploadResumeSupported(s3MinPartSize))
+	// objects smaller than a part cannot be copied server side
+	server.putObject("small", bytes.Repeat([]byte("s"), 2048))
+	_, _, _, err := fs.Create("small", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, _, _, err = fs.Create("missing", 0, CheckResume)
+	assert.Error(t, err)
+
+	existing := bytes.Repeat([]byte("a"), 2*int(s3MinPartSize)+10)
+	server.putObject("file.txt", existing)
+	newData := []byte("resumed data")
+	err = resumeTestS3Upload(t, fs, "file.txt", int64(len(existing)), newData)
+	require.NoError(t, err)
+	obj, ok := server.getObject("file.txt")
+	require.True(t, ok)
+	assert.Equal(t, append(append([]byte(nil), existing...), newData...), obj.data)
+	assert.Equal(t, "text/plain; charset=utf-8", obj.contentType)
+	// the remaining data smaller than a part is added to the last copied part
+	assert.Equal(t, 2, server.getOpCount("UploadPartCopy"))
+	assert.Equal(t, 1, server.getOpCount("UploadPart"))
+	assert.Equal(t, 1, server.getOpCount("CompleteMultipartUpload"))
+	assert.Equal(t, 0, server.getOpCount("GetObject"))
+	assert.Equal(t, 0, server.getOpCount("PutObject"))
+	assert.Equal(t, 0, server.getUploadsCount())
+	// the entity tag of a multipart upload is not a content hash
+	assert.True(t, strings.HasSuffix(obj.etag, `-3"`))
+	info, err := fs.Stat("file.txt")
+	require.NoError(t, err)
+	assert.Empty(t, GetETag(info))
+}
+
+func TestS3ResumeUploadErrors(t *testing.T) {
+	SetResumeMaxSize(1024)
+	t.Cleanup(func() {
+		SetResumeMaxSize(0)
+	})
+	server := newFakeS3Server(t)
+	fs := newTestS3Fs(t, server)
+	existing := bytes.Repeat([]byte("a"), int(s3MinPartSize)+10)
+	server.putObject("file", existing)
+	// a failed part aborts the multipart upload and the existing object is preserved
+	server.onRequest = func(op string, _ *http.Request) int {
+		if op == "UploadPart" {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	err := resumeTestS3Upload(t, fs, "file", int64(len(existing)), []byte("new data"))
+	assert.ErrorContains(t, err, "unable to upload part number 2")
+	assert.Equal(t, 1, server.getOpCount("AbortMultipartUpload"))
+	assert.Equal(t, 0, server.getOpCount("CompleteMultipartUpload"))
+	assert.Equal(t, 0, server.getUploadsCount())
+	obj, ok := server.getObject("file")
+	require.True(t, ok)
+	assert.Equal(t, existing, obj.data)
+	// the object changes after the resume offset is returned to the client
+	changed := bytes.Repeat([]byte("b"), int(s3MinPartSize)+20)
+	server.mu.Lock()
+	server.onRequest = func(op string, _ *http.Request) int {
+		if op == "CreateMultipartUpload" {
+			server.putObject("file", changed)
+		}
+		return 0
+	}
+	server.mu.Unlock()
+	err = resumeTestS3Upload(t, fs, "file", int64(len(existing)), []byte("new data"))
+	assert.ErrorContains(t, err, "unable to copy existing data, part number 1")
+	assert.Equal(t, 2, server.getOpCount("AbortMultipartUpload"))
+	assert.Equal(t, 0, server.getUploadsCount())
+	obj, ok = server.getObject("file")
+	require.True(t, ok)
+	assert.Equal(t, changed, obj.data)
+	// the client restarts from the new offset
+	server.mu.Lock()
+	server.onRequest = nil
+	server.mu.Unlock()
+	err = resumeTestS3Upload(t, fs, "file", int64(len(changed)), []byte("new data"))
+	require.NoError(t, err)
+	obj, ok = server.getObject("file")
+	require.True(t, ok)
+	assert.Equal(t, append(append([]byte(nil), changed...), []byte("new data")...), obj.data)
+	assert.Equal(t, 0, server.getUploadsCount())
+}
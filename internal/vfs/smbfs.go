@@ -0,0 +1,571 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nosmb
+// +build !nosmb
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	smbStatVFSMaxFilenameSize = 255
+)
+
+// SMBFs is a Fs implementation for SMB/CIFS shares.
+// Each instance uses its own SMB session so the share is accessed using
+// the credentials configured for the user
+type SMBFs struct {
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	localTempDir string
+	config       *SMBFsConfig
+	conn         *smbConnection
+}
+
+func init() {
+	version.AddFeature("+smb")
+}
+
+// NewSMBFs returns an SMBFs object that allows to interact with an SMB share
+func NewSMBFs(connectionID, localTempDir, mountPath string, config SMBFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if !config.Password.IsEmpty() {
+		if err := config.Password.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	smbFs := &SMBFs{
+		connectionID: connectionID,
+		mountPath:    getMountPath(mountPath),
+		localTempDir: localTempDir,
+		config:       &config,
+	}
+	smbFs.conn = &smbConnection{
+		config:    smbFs.config,
+		logSender: smbFs.Name(),
+	}
+	if _, err := smbFs.conn.getShare(); err != nil {
+		fsLog(smbFs, logger.LevelError, "error opening connection: %v", err)
+		return nil, err
+	}
+	return smbFs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *SMBFs) Name() string {
+	return fmt.Sprintf(`%s %q@%q share %q`, smbFsName, fs.config.Username, fs.config.Endpoint, fs.config.Share)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *SMBFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *SMBFs) Stat(name string) (os.FileInfo, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Stat(getSMBPath(name))
+	return info, fs.conn.checkError(err)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *SMBFs) Lstat(name string) (os.FileInfo, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Lstat(getSMBPath(name))
+	return info, fs.conn.checkError(err)
+}
+
+// Open opens the named file for reading
+func (fs *SMBFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := share.Open(getSMBPath(name))
+	if err != nil {
+		return nil, nil, nil, fs.conn.checkError(err)
+	}
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+	}
+	return f, nil, nil, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *SMBFs) Create(name string, flag, _ int) (File, PipeWriter, func(), error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var f *smb2.File
+	if flag == 0 {
+		f, err = share.Create(getSMBPath(name))
+	} else {
+		f, err = share.OpenFile(getSMBPath(name), flag, 0666)
+	}
+	if err != nil {
+		return nil, nil, nil, fs.conn.checkError(err)
+	}
+	return f, nil, nil, nil
+}
+
+// Rename renames (moves) source to target.
+// SMB does not replace existing files so an existing target file is
+// removed before retrying
+func (fs *SMBFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return -1, -1, err
+	}
+	err = share.Rename(getSMBPath(source), getSMBPath(target))
+	if !errors.Is(err, os.ErrExist) {
+		return -1, -1, fs.conn.checkError(err)
+	}
+	info, errStat := share.Stat(getSMBPath(target))
+	if errStat != nil || info.IsDir() {
+		return -1, -1, err
+	}
+	fsLog(fs, logger.LevelDebug, "target %q exists, remove it before retrying the rename", target)
+	if err := share.Remove(getSMBPath(target)); err != nil {
+		return -1, -1, fs.conn.checkError(err)
+	}
+	err = share.Rename(getSMBPath(source), getSMBPath(target))
+	return -1, -1, fs.conn.checkError(err)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *SMBFs) Remove(name string, _ bool) error {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.conn.checkError(share.Remove(getSMBPath(name)))
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *SMBFs) Mkdir(name string) error {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.conn.checkError(share.Mkdir(getSMBPath(name), os.ModePerm))
+}
+
+// Symlink creates source as a symbolic link to target.
+// Symlinks are not supported, they could point outside the prefix
+func (*SMBFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*SMBFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*SMBFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+// SMB only has a read-only attribute, permissions are managed using ACLs
+func (*SMBFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *SMBFs) Chtimes(name string, atime, mtime time.Time, _ bool) error {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.conn.checkError(share.Chtimes(getSMBPath(name), atime, mtime))
+}
+
+// Truncate changes the size of the named file.
+func (fs *SMBFs) Truncate(name string, size int64) error {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.conn.checkError(share.Truncate(getSMBPath(name), size))
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *SMBFs) ReadDir(dirname string) (DirLister, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, err
+	}
+	files, err := share.ReadDir(getSMBPath(dirname))
+	if err != nil {
+		return nil, fs.conn.checkError(err)
+	}
+	return &baseDirLister{files}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*SMBFs) IsUploadResumeSupported() bool {
+	return true
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*SMBFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return true
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*SMBFs) IsAtomicUploadSupported() bool {
+	return true
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*SMBFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*SMBFs) IsPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*SMBFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *SMBFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return false
+	}
+	if err := share.MkdirAll(getSMBPath(fs.config.Prefix), os.ModePerm); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *SMBFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*SMBFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *SMBFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *SMBFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*SMBFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*SMBFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *SMBFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *SMBFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+				if numFiles%1000 == 0 {
+					fsLog(fs, logger.LevelDebug, "dirname %q scan in progress, files: %d, size: %d", dirname, numFiles, size)
+				}
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetMimeType returns the content type
+func (fs *SMBFs) GetMimeType(name string) (string, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return "", err
+	}
+	f, err := share.Open(getSMBPath(name))
+	if err != nil {
+		return "", fs.conn.checkError(err)
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *SMBFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Statfs(getSMBPath(dirName))
+	if err != nil {
+		return nil, fs.conn.checkError(err)
+	}
+	// the block size is returned as bytes per sector, the fragment size
+	// as sectors per allocation unit
+	blockSize := info.BlockSize() * info.FragmentSize()
+	return &sftp.StatVFS{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  info.TotalBlockCount(),
+		Bfree:   info.FreeBlockCount(),
+		Bavail:  info.AvailableBlockCount(),
+		Namemax: smbStatVFSMaxFilenameSize,
+	}, nil
+}
+
+// Close closes the SMB session
+func (fs *SMBFs) Close() error {
+	return fs.conn.Close()
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *SMBFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// getSMBPath converts an absolute path to a path relative to the share root
+func getSMBPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+type smbConnection struct {
+	config      *SMBFsConfig
+	logSender   string
+	mu          sync.Mutex
+	conn        net.Conn
+	session     *smb2.Session
+	share       *smb2.Share
+	isConnected bool
+}
+
+// getShare returns the mounted share, a new connection is established
+// if the previous one was closed
+func (c *smbConnection) getShare() (*smb2.Share, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isConnected {
+		return c.share, nil
+	}
+	logger.Debug(c.logSender, "", "try to open a new connection")
+	ctx, cancelFn := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancelFn()
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("smbfs: unable to connect: %w", err)
+	}
+	d := &smb2.Dialer{
+		Negotiator: smb2.Negotiator{
+			RequireMessageSigning: c.config.RequireSigning,
+		},
+		Initiator: &smb2.NTLMInitiator{
+			User:     c.config.Username,
+			Password: c.config.Password.GetPayload(),
+			Domain:   c.config.Domain,
+		},
+	}
+	session, err := d.DialContext(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smbfs: unable to authenticate: %w", err)
+	}
+	host, _, _ := net.SplitHostPort(c.config.Endpoint)
+	share, err := session.Mount(fmt.Sprintf(`\\%s\%s`, host, c.config.Share))
+	if err != nil {
+		session.Logoff() //nolint:errcheck
+		conn.Close()
+		return nil, fmt.Errorf("smbfs: unable to mount share %q: %w", c.config.Share, err)
+	}
+	c.conn = conn
+	c.session = session
+	c.share = share
+	c.isConnected = true
+	return c.share, nil
+}
+
+// checkError marks the connection as closed if the error is a transport
+// error, the next request will establish a new connection
+func (c *smbConnection) checkError(err error) error {
+	var transportErr *smb2.TransportError
+	if errors.As(err, &transportErr) {
+		logger.Warn(c.logSender, "", "connection error, the connection will be reopened: %v", err)
+		c.Close() //nolint:errcheck
+	}
+	return err
+}
+
+func (c *smbConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isConnected {
+		return nil
+	}
+	logger.Debug(c.logSender, "", "closing connection")
+	c.isConnected = false
+	c.share.Umount()   //nolint:errcheck
+	c.session.Logoff() //nolint:errcheck
+	return c.conn.Close()
+}
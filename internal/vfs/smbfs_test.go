@@ -0,0 +1,1262 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nosmb
+// +build !nosmb
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/md4" //nolint:staticcheck
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeSMBShare    = "share"
+	fakeSMBUsername = "user"
+	fakeSMBPassword = "password"
+	// maximum number of entries returned for each QUERY_DIRECTORY request
+	fakeSMBDirBatchSize = 10
+	// maximum read and write size, the client splits bigger requests
+	fakeSMBMaxIOSize = 64 * 1024
+	// number of 100-nanosecond intervals between 1601-01-01 and 1970-01-01
+	fakeSMBFiletimeEpoch = 116444736000000000
+)
+
+const (
+	fakeSMBStatusMoreProcessing = 0xC0000016
+	fakeSMBStatusNoMoreFiles    = 0x80000006
+	fakeSMBStatusInvalidParam   = 0xC000000D
+	fakeSMBStatusEndOfFile      = 0xC0000011
+	fakeSMBStatusAccessDenied   = 0xC0000022
+	fakeSMBStatusNotFound       = 0xC0000034
+	fakeSMBStatusCollision      = 0xC0000035
+	fakeSMBStatusPathNotFound   = 0xC000003A
+	fakeSMBStatusLogonFailure   = 0xC000006D
+	fakeSMBStatusIsADirectory   = 0xC00000BA
+	fakeSMBStatusNotSupported   = 0xC00000BB
+	fakeSMBStatusBadNetworkName = 0xC00000CC
+	fakeSMBStatusDirNotEmpty    = 0xC0000101
+	fakeSMBStatusNotADirectory  = 0xC0000103
+)
+
+var (
+	fakeSMBCommands = map[uint16]string{
+		0:  "negotiate",
+		1:  "session_setup",
+		2:  "logoff",
+		3:  "tree_connect",
+		4:  "tree_disconnect",
+		5:  "create",
+		6:  "close",
+		8:  "read",
+		9:  "write",
+		14: "query_directory",
+		16: "query_info",
+		17: "set_info",
+	}
+	// offset of the file id within the request body for the commands using it
+	fakeSMBFileIDOffsets = map[uint16]int{
+		6:  8,
+		8:  16,
+		9:  16,
+		14: 8,
+		16: 24,
+		17: 16,
+	}
+	fakeSMBNTLMOid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+type fakeSMBEntry struct {
+	isDir    bool
+	readOnly bool
+	data     []byte
+	modTime  time.Time
+	atime    time.Time
+}
+
+type fakeSMBHandle struct {
+	name          string
+	deletePending bool
+	// directory entries not yet returned, populated by the first query
+	pending []string
+	listed  bool
+}
+
+type fakeSMBConn struct {
+	net.Conn
+	sessionID uint64
+	challenge []byte
+}
+
+type fakeNegTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,tag:2"`
+}
+
+// fakeSMBServer is an in memory SMB 2.1 server implementing the subset of the
+// protocol used by SMBFs. Sessions are flagged as guest sessions so the
+// client does not sign the requests, the NTLM credentials are verified anyway
+type fakeSMBServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	// the entries keyed by path, the share root is "/"
+	entries map[string]*fakeSMBEntry
+	handles map[uint64]*fakeSMBHandle
+	conns   map[*fakeSMBConn]bool
+	idx     uint64
+	ops     map[string]int
+	// if set it is called for each request with the command name and the
+	// involved path, if any. A status different from zero is returned as
+	// an error response without processing the request
+	onRequest func(op, name string) uint32
+}
+
+func newFakeSMBServer(t *testing.T) *fakeSMBServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	s := &fakeSMBServer{
+		listener: listener,
+		entries: map[string]*fakeSMBEntry{
+			"/": {isDir: true, modTime: now, atime: now},
+		},
+		handles: make(map[uint64]*fakeSMBHandle),
+		conns:   make(map[*fakeSMBConn]bool),
+		ops:     make(map[string]int),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeSMBServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSMBServer) Close() {
+	s.listener.Close()
+	s.dropConnections()
+	s.wg.Wait()
+}
+
+// dropConnections closes the established connections, the client will get
+// transport errors
+func (s *fakeSMBServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *fakeSMBServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeSMBServer) setOnRequest(fn func(op, name string) uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeSMBServer) getOpenHandles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.handles)
+}
+
+func (s *fakeSMBServer) getEntry(name string) (fakeSMBEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return fakeSMBEntry{}, false
+	}
+	return *entry, true
+}
+
+func (s *fakeSMBServer) setReadOnly(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[name].readOnly = true
+}
+
+// putFile adds a file, the parent directories are created if missing
+func (s *fakeSMBServer) putFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := s.entries[dir]; !ok {
+			s.entries[dir] = &fakeSMBEntry{isDir: true, modTime: now, atime: now}
+		}
+	}
+	s.entries[name] = &fakeSMBEntry{data: data, modTime: now, atime: now}
+}
+
+func (s *fakeSMBServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeSMBConn{Conn: conn}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(c)
+	}
+}
+
+// serveConn handles the requests sent on a connection, each packet is
+// prefixed by its big endian length as for the direct TCP transport
+func (s *fakeSMBServer) serveConn(c *fakeSMBConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		c.Close()
+		s.wg.Done()
+	}()
+
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, pkt); err != nil {
+			return
+		}
+		res := s.handlePacket(c, pkt)
+		if res == nil {
+			return
+		}
+		out := make([]byte, 4+len(res))
+		binary.BigEndian.PutUint32(out, uint32(len(res)))
+		copy(out[4:], res)
+		if _, err := c.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeSMBServer) handlePacket(c *fakeSMBConn, pkt []byte) []byte {
+	if len(pkt) < 64 || !bytes.HasPrefix(pkt, []byte("\xfeSMB")) {
+		return nil
+	}
+	cmd := binary.LittleEndian.Uint16(pkt[12:14])
+	op, ok := fakeSMBCommands[cmd]
+	if !ok {
+		op = fmt.Sprintf("command_%d", cmd)
+	}
+	treeID := binary.LittleEndian.Uint32(pkt[36:40])
+
+	s.mu.Lock()
+	s.ops[op]++
+	onRequest := s.onRequest
+	name := s.getRequestPathLocked(cmd, pkt)
+	s.mu.Unlock()
+
+	if onRequest != nil {
+		if status := onRequest(op, name); status != 0 {
+			return buildFakeSMBResponse(c, pkt, status, nil, treeID)
+		}
+	}
+
+	var status uint32
+	var body []byte
+
+	switch cmd {
+	case 0:
+		status, body = handleFakeSMBNegotiate()
+	case 1:
+		status, body = s.handleSessionSetup(c, pkt)
+	case 2, 4:
+		body = make([]byte, 4)
+		binary.LittleEndian.PutUint16(body, 4)
+	case 3:
+		status, body = handleFakeSMBTreeConnect(pkt)
+		treeID = 1
+	default:
+		s.mu.Lock()
+		status, body = s.handleFileRequestLocked(cmd, pkt)
+		s.mu.Unlock()
+	}
+	return buildFakeSMBResponse(c, pkt, status, body, treeID)
+}
+
+func (s *fakeSMBServer) handleFileRequestLocked(cmd uint16, pkt []byte) (uint32, []byte) {
+	if cmd == 5 {
+		return s.handleCreateLocked(pkt)
+	}
+	id, h := s.getHandleLocked(cmd, pkt)
+	if h == nil {
+		return fakeSMBStatusNotSupported, nil
+	}
+	entry, ok := s.entries[h.name]
+	if !ok && cmd != 6 {
+		return fakeSMBStatusNotFound, nil
+	}
+	req := pkt[64:]
+
+	switch cmd {
+	case 6:
+		delete(s.handles, id)
+		if h.deletePending {
+			delete(s.entries, h.name)
+		}
+		body := make([]byte, 60)
+		binary.LittleEndian.PutUint16(body, 60)
+		return 0, body
+	case 8:
+		length := binary.LittleEndian.Uint32(req[4:8])
+		offset := binary.LittleEndian.Uint64(req[8:16])
+		if offset >= uint64(len(entry.data)) {
+			return fakeSMBStatusEndOfFile, nil
+		}
+		data := entry.data[offset:]
+		if uint64(len(data)) > uint64(length) {
+			data = data[:length]
+		}
+		body := make([]byte, 16+len(data))
+		binary.LittleEndian.PutUint16(body, 17)
+		body[2] = 64 + 16
+		binary.LittleEndian.PutUint32(body[4:8], uint32(len(data)))
+		copy(body[16:], data)
+		return 0, body
+	case 9:
+		data := getFakeSMBBuffer(pkt, uint32(binary.LittleEndian.Uint16(req[2:4])), binary.LittleEndian.Uint32(req[4:8]))
+		offset := int(binary.LittleEndian.Uint64(req[8:16]))
+		if end := offset + len(data); end > len(entry.data) {
+			entry.data = append(entry.data, make([]byte, end-len(entry.data))...)
+		}
+		copy(entry.data[offset:], data)
+		entry.modTime = time.Now().UTC().Truncate(time.Second)
+		body := make([]byte, 17)
+		binary.LittleEndian.PutUint16(body, 17)
+		binary.LittleEndian.PutUint32(body[4:8], uint32(len(data)))
+		return 0, body
+	case 14:
+		return s.handleQueryDirectoryLocked(h, entry)
+	case 16:
+		return handleFakeSMBQueryInfo(req, entry)
+	case 17:
+		return s.handleSetInfoLocked(h, entry, pkt)
+	}
+	return fakeSMBStatusNotSupported, nil
+}
+
+func (s *fakeSMBServer) handleCreateLocked(pkt []byte) (uint32, []byte) {
+	const (
+		dispositionOpen        = 1
+		dispositionCreate      = 2
+		dispositionOverwrite   = 4
+		dispositionOverwriteIf = 5
+		optionDirectory        = 0x1
+		optionNonDirectory     = 0x40
+		// GENERIC_WRITE, FILE_WRITE_DATA, FILE_APPEND_DATA and DELETE
+		modifyAccess = 0x40000000 | 0x2 | 0x4 | 0x10000
+	)
+	req := pkt[64:]
+	name := getFakeSMBCreatePath(pkt)
+	access := binary.LittleEndian.Uint32(req[24:28])
+	disposition := binary.LittleEndian.Uint32(req[36:40])
+	options := binary.LittleEndian.Uint32(req[40:44])
+
+	entry, ok := s.entries[name]
+	if !ok {
+		parent, ok := s.entries[path.Dir(name)]
+		if !ok || !parent.isDir {
+			return fakeSMBStatusPathNotFound, nil
+		}
+		if disposition == dispositionOpen || disposition == dispositionOverwrite {
+			return fakeSMBStatusNotFound, nil
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		entry = &fakeSMBEntry{isDir: options&optionDirectory != 0, modTime: now, atime: now}
+		s.entries[name] = entry
+	} else {
+		if disposition == dispositionCreate {
+			return fakeSMBStatusCollision, nil
+		}
+		if options&optionDirectory != 0 && !entry.isDir {
+			return fakeSMBStatusNotADirectory, nil
+		}
+		if options&optionNonDirectory != 0 && entry.isDir {
+			return fakeSMBStatusIsADirectory, nil
+		}
+		if entry.readOnly && access&modifyAccess != 0 {
+			return fakeSMBStatusAccessDenied, nil
+		}
+		if disposition == dispositionOverwrite || disposition == dispositionOverwriteIf {
+			entry.data = nil
+			entry.modTime = time.Now().UTC().Truncate(time.Second)
+		}
+	}
+	s.idx++
+	s.handles[s.idx] = &fakeSMBHandle{name: name}
+
+	body := make([]byte, 88)
+	binary.LittleEndian.PutUint16(body, 89)
+	putFakeSMBTimes(body[8:40], entry)
+	binary.LittleEndian.PutUint64(body[40:48], uint64(len(entry.data)))
+	binary.LittleEndian.PutUint64(body[48:56], uint64(len(entry.data)))
+	binary.LittleEndian.PutUint32(body[56:60], getFakeSMBAttributes(entry))
+	binary.LittleEndian.PutUint64(body[64:72], s.idx)
+	binary.LittleEndian.PutUint64(body[72:80], s.idx)
+	return 0, body
+}
+
+func (s *fakeSMBServer) handleQueryDirectoryLocked(h *fakeSMBHandle, entry *fakeSMBEntry) (uint32, []byte) {
+	if !entry.isDir {
+		return fakeSMBStatusInvalidParam, nil
+	}
+	if !h.listed {
+		h.listed = true
+		h.pending = []string{".", ".."}
+		h.pending = append(h.pending, s.getChildrenLocked(h.name)...)
+	}
+	if len(h.pending) == 0 {
+		return fakeSMBStatusNoMoreFiles, nil
+	}
+	names := h.pending
+	if len(names) > fakeSMBDirBatchSize {
+		names = names[:fakeSMBDirBatchSize]
+	}
+	h.pending = h.pending[len(names):]
+
+	var buf []byte
+	for idx, name := range names {
+		child := entry
+		if name != "." && name != ".." {
+			child = s.entries[path.Join(h.name, name)]
+		}
+		encodedName := encodeFakeSMBString(name)
+		info := make([]byte, 64+len(encodedName))
+		putFakeSMBTimes(info[8:40], child)
+		binary.LittleEndian.PutUint64(info[40:48], uint64(len(child.data)))
+		binary.LittleEndian.PutUint64(info[48:56], uint64(len(child.data)))
+		binary.LittleEndian.PutUint32(info[56:60], getFakeSMBAttributes(child))
+		binary.LittleEndian.PutUint32(info[60:64], uint32(len(encodedName)))
+		copy(info[64:], encodedName)
+		if idx < len(names)-1 {
+			// entries are 8 bytes aligned
+			info = append(info, make([]byte, (8-len(info)%8)%8)...)
+			binary.LittleEndian.PutUint32(info[:4], uint32(len(info)))
+		}
+		buf = append(buf, info...)
+	}
+	return 0, buildFakeSMBOutputBuffer(buf)
+}
+
+func (s *fakeSMBServer) handleSetInfoLocked(h *fakeSMBHandle, entry *fakeSMBEntry, pkt []byte) (uint32, []byte) {
+	const (
+		classBasic       = 4
+		classRename      = 10
+		classDisposition = 13
+		classEndOfFile   = 20
+	)
+	req := pkt[64:]
+	buf := getFakeSMBBuffer(pkt, uint32(binary.LittleEndian.Uint16(req[8:10])), binary.LittleEndian.Uint32(req[4:8]))
+
+	switch req[3] {
+	case classBasic:
+		if len(buf) < 36 {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		// zero means that the attribute must not be changed
+		if atime := binary.LittleEndian.Uint64(buf[8:16]); atime != 0 {
+			entry.atime = getFakeSMBTime(atime)
+		}
+		if mtime := binary.LittleEndian.Uint64(buf[16:24]); mtime != 0 {
+			entry.modTime = getFakeSMBTime(mtime)
+		}
+		if attrs := binary.LittleEndian.Uint32(buf[32:36]); attrs != 0 {
+			entry.readOnly = attrs&0x1 != 0
+		}
+	case classRename:
+		if len(buf) < 20 || len(buf) < 20+int(binary.LittleEndian.Uint32(buf[16:20])) {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		target := getFakeSMBPath(decodeFakeSMBString(buf[20 : 20+binary.LittleEndian.Uint32(buf[16:20])]))
+		if status := s.renameLocked(h.name, target, buf[0] != 0); status != 0 {
+			return status, nil
+		}
+		h.name = target
+	case classDisposition:
+		if len(buf) < 1 {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		if buf[0] != 0 && entry.isDir && len(s.getChildrenLocked(h.name)) > 0 {
+			return fakeSMBStatusDirNotEmpty, nil
+		}
+		h.deletePending = buf[0] != 0
+	case classEndOfFile:
+		if len(buf) < 8 {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		size := int(binary.LittleEndian.Uint64(buf[:8]))
+		if size > len(entry.data) {
+			entry.data = append(entry.data, make([]byte, size-len(entry.data))...)
+		}
+		entry.data = entry.data[:size]
+	default:
+		return fakeSMBStatusNotSupported, nil
+	}
+	body := make([]byte, 2)
+	binary.LittleEndian.PutUint16(body, 2)
+	return 0, body
+}
+
+func (s *fakeSMBServer) renameLocked(source, target string, replace bool) uint32 {
+	if source == target {
+		return 0
+	}
+	if existing, ok := s.entries[target]; ok {
+		if !replace || existing.isDir {
+			return fakeSMBStatusCollision
+		}
+		delete(s.entries, target)
+	}
+	if parent, ok := s.entries[path.Dir(target)]; !ok || !parent.isDir {
+		return fakeSMBStatusPathNotFound
+	}
+	for name, entry := range s.entries {
+		if name == source || strings.HasPrefix(name, source+"/") {
+			delete(s.entries, name)
+			s.entries[target+strings.TrimPrefix(name, source)] = entry
+		}
+	}
+	return 0
+}
+
+// getChildrenLocked returns the sorted names of the entries inside the
+// specified directory
+func (s *fakeSMBServer) getChildrenLocked(name string) []string {
+	var result []string
+	for key := range s.entries {
+		if key != "/" && path.Dir(key) == name {
+			result = append(result, path.Base(key))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s *fakeSMBServer) getHandleLocked(cmd uint16, pkt []byte) (uint64, *fakeSMBHandle) {
+	offset, ok := fakeSMBFileIDOffsets[cmd]
+	if !ok || len(pkt) < 64+offset+8 {
+		return 0, nil
+	}
+	id := binary.LittleEndian.Uint64(pkt[64+offset:])
+	return id, s.handles[id]
+}
+
+// getRequestPathLocked returns the path involved in the request, if any
+func (s *fakeSMBServer) getRequestPathLocked(cmd uint16, pkt []byte) string {
+	if cmd == 5 {
+		return getFakeSMBCreatePath(pkt)
+	}
+	if _, h := s.getHandleLocked(cmd, pkt); h != nil {
+		return h.name
+	}
+	return ""
+}
+
+// handleSessionSetup implements the NTLM authentication wrapped in SPNEGO
+// tokens. The NTLM messages are located using their signature instead of
+// decoding the SPNEGO envelope
+func (s *fakeSMBServer) handleSessionSetup(c *fakeSMBConn, pkt []byte) (uint32, []byte) {
+	const sessionFlagGuest = 0x1
+
+	req := pkt[64:]
+	if len(req) < 24 {
+		return fakeSMBStatusInvalidParam, nil
+	}
+	token := getFakeSMBBuffer(pkt, uint32(binary.LittleEndian.Uint16(req[12:14])),
+		uint32(binary.LittleEndian.Uint16(req[14:16])))
+	idx := bytes.Index(token, []byte("NTLMSSP\x00"))
+	if idx < 0 || len(token) < idx+16 {
+		return fakeSMBStatusInvalidParam, nil
+	}
+	msg := token[idx:]
+
+	switch binary.LittleEndian.Uint32(msg[8:12]) {
+	case 1:
+		s.mu.Lock()
+		s.idx++
+		c.sessionID = s.idx
+		s.mu.Unlock()
+
+		c.challenge = make([]byte, 8)
+		if _, err := rand.Read(c.challenge); err != nil {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		respToken, err := buildFakeSMBChallenge(msg, c.challenge)
+		if err != nil {
+			return fakeSMBStatusInvalidParam, nil
+		}
+		body := make([]byte, 8+len(respToken))
+		binary.LittleEndian.PutUint16(body, 9)
+		binary.LittleEndian.PutUint16(body[2:4], sessionFlagGuest)
+		binary.LittleEndian.PutUint16(body[4:6], 64+8)
+		binary.LittleEndian.PutUint16(body[6:8], uint16(len(respToken)))
+		copy(body[8:], respToken)
+		return fakeSMBStatusMoreProcessing, body
+	case 3:
+		if c.challenge == nil || !checkFakeSMBAuthenticate(msg, c.challenge) {
+			return fakeSMBStatusLogonFailure, nil
+		}
+		body := make([]byte, 9)
+		binary.LittleEndian.PutUint16(body, 9)
+		binary.LittleEndian.PutUint16(body[2:4], sessionFlagGuest)
+		return 0, body
+	}
+	return fakeSMBStatusInvalidParam, nil
+}
+
+// handleFakeSMBNegotiate selects the SMB 2.1 dialect without large MTU
+// support, so the client sends requests using a single credit
+func handleFakeSMBNegotiate() (uint32, []byte) {
+	body := make([]byte, 65)
+	binary.LittleEndian.PutUint16(body, 65)
+	// signing enabled but not required
+	binary.LittleEndian.PutUint16(body[2:4], 1)
+	binary.LittleEndian.PutUint16(body[4:6], 0x0210)
+	binary.LittleEndian.PutUint32(body[28:32], fakeSMBMaxIOSize)
+	binary.LittleEndian.PutUint32(body[32:36], fakeSMBMaxIOSize)
+	binary.LittleEndian.PutUint32(body[36:40], fakeSMBMaxIOSize)
+	binary.LittleEndian.PutUint64(body[40:48], getFakeSMBFiletime(time.Now()))
+	return 0, body
+}
+
+func handleFakeSMBTreeConnect(pkt []byte) (uint32, []byte) {
+	req := pkt[64:]
+	if len(req) < 8 {
+		return fakeSMBStatusInvalidParam, nil
+	}
+	sharePath := decodeFakeSMBString(getFakeSMBBuffer(pkt, uint32(binary.LittleEndian.Uint16(req[4:6])),
+		uint32(binary.LittleEndian.Uint16(req[6:8]))))
+	if !strings.EqualFold(sharePath[strings.LastIndex(sharePath, `\`)+1:], fakeSMBShare) {
+		return fakeSMBStatusBadNetworkName, nil
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint16(body, 16)
+	// disk share with full access
+	body[2] = 1
+	binary.LittleEndian.PutUint32(body[12:16], 0x001f01ff)
+	return 0, body
+}
+
+func handleFakeSMBQueryInfo(req []byte, entry *fakeSMBEntry) (uint32, []byte) {
+	const (
+		infoTypeFile       = 1
+		infoTypeFilesystem = 2
+		classBasic         = 4
+		classStandard      = 5
+		classFsFullSize    = 7
+	)
+
+	var buf []byte
+	switch {
+	case req[2] == infoTypeFile && req[3] == classBasic:
+		buf = make([]byte, 40)
+		putFakeSMBTimes(buf[:32], entry)
+		binary.LittleEndian.PutUint32(buf[32:36], getFakeSMBAttributes(entry))
+	case req[2] == infoTypeFile && req[3] == classStandard:
+		buf = make([]byte, 24)
+		binary.LittleEndian.PutUint64(buf[:8], uint64(len(entry.data)))
+		binary.LittleEndian.PutUint64(buf[8:16], uint64(len(entry.data)))
+		binary.LittleEndian.PutUint32(buf[16:20], 1)
+		if entry.isDir {
+			buf[21] = 1
+		}
+	case req[2] == infoTypeFilesystem && req[3] == classFsFullSize:
+		buf = make([]byte, 32)
+		binary.LittleEndian.PutUint64(buf[:8], 1000)
+		binary.LittleEndian.PutUint64(buf[8:16], 600)
+		binary.LittleEndian.PutUint64(buf[16:24], 700)
+		binary.LittleEndian.PutUint32(buf[24:28], 8)
+		binary.LittleEndian.PutUint32(buf[28:32], 512)
+	default:
+		return fakeSMBStatusNotSupported, nil
+	}
+	return 0, buildFakeSMBOutputBuffer(buf)
+}
+
+// buildFakeSMBChallenge returns the SPNEGO response including the NTLM
+// CHALLENGE message for the specified NEGOTIATE message
+func buildFakeSMBChallenge(negotiateMsg, challenge []byte) ([]byte, error) {
+	targetName := encodeFakeSMBString("SFTPGO")
+	// the target info contains the MsvAvEOL pair only
+	msg := make([]byte, 56+len(targetName)+4)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:12], 2)
+	binary.LittleEndian.PutUint16(msg[12:14], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:16], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:20], 56)
+	// the negotiate flags are accepted as requested
+	copy(msg[20:24], negotiateMsg[12:16])
+	copy(msg[24:32], challenge)
+	binary.LittleEndian.PutUint16(msg[40:42], 4)
+	binary.LittleEndian.PutUint16(msg[42:44], 4)
+	binary.LittleEndian.PutUint32(msg[44:48], uint32(56+len(targetName)))
+	copy(msg[56:], targetName)
+
+	resp, err := asn1.Marshal(fakeNegTokenResp{
+		NegState:      1,
+		SupportedMech: fakeSMBNTLMOid,
+		ResponseToken: msg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        1,
+		IsCompound: true,
+		Bytes:      resp,
+	})
+}
+
+// checkFakeSMBAuthenticate verifies the NTLMv2 response included in the
+// AUTHENTICATE message
+func checkFakeSMBAuthenticate(msg, challenge []byte) bool {
+	if len(msg) < 64 {
+		return false
+	}
+	getField := func(offset int) []byte {
+		length := uint32(binary.LittleEndian.Uint16(msg[offset : offset+2]))
+		return getFakeSMBBuffer(msg, binary.LittleEndian.Uint32(msg[offset+4:offset+8]), length)
+	}
+	ntResponse := getField(20)
+	domain := getField(28)
+	user := decodeFakeSMBString(getField(36))
+	if len(ntResponse) < 24 || !strings.EqualFold(user, fakeSMBUsername) {
+		return false
+	}
+	h := md4.New()
+	h.Write(encodeFakeSMBString(fakeSMBPassword))
+	mac := hmac.New(md5.New, h.Sum(nil))
+	mac.Write(encodeFakeSMBString(strings.ToUpper(user)))
+	mac.Write(domain)
+	mac = hmac.New(md5.New, mac.Sum(nil))
+	mac.Write(challenge)
+	mac.Write(ntResponse[16:])
+	return hmac.Equal(mac.Sum(nil), ntResponse[:16])
+}
+
+func buildFakeSMBResponse(c *fakeSMBConn, pkt []byte, status uint32, body []byte, treeID uint32) []byte {
+	if body == nil {
+		// error response without error contexts
+		body = make([]byte, 9)
+		binary.LittleEndian.PutUint16(body, 9)
+	}
+	res := make([]byte, 64+len(body))
+	copy(res, "\xfeSMB")
+	binary.LittleEndian.PutUint16(res[4:6], 64)
+	copy(res[6:8], pkt[6:8])
+	binary.LittleEndian.PutUint32(res[8:12], status)
+	copy(res[12:14], pkt[12:14])
+	// grant the requested credits
+	credits := binary.LittleEndian.Uint16(pkt[14:16])
+	if credits == 0 {
+		credits = 1
+	}
+	binary.LittleEndian.PutUint16(res[14:16], credits)
+	// SMB2_FLAGS_SERVER_TO_REDIR
+	binary.LittleEndian.PutUint32(res[16:20], 1)
+	copy(res[24:32], pkt[24:32])
+	binary.LittleEndian.PutUint32(res[36:40], treeID)
+	binary.LittleEndian.PutUint64(res[40:48], c.sessionID)
+	copy(res[64:], body)
+	return res
+}
+
+// buildFakeSMBOutputBuffer returns a QUERY_INFO or QUERY_DIRECTORY response
+// body, they have the same layout
+func buildFakeSMBOutputBuffer(buf []byte) []byte {
+	body := make([]byte, 8+len(buf))
+	binary.LittleEndian.PutUint16(body, 9)
+	binary.LittleEndian.PutUint16(body[2:4], 64+8)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(len(buf)))
+	copy(body[8:], buf)
+	return body
+}
+
+// getFakeSMBBuffer returns the specified buffer, offsets are relative to
+// the start of the message. Nil is returned for invalid offsets
+func getFakeSMBBuffer(msg []byte, offset, length uint32) []byte {
+	if uint64(offset)+uint64(length) > uint64(len(msg)) {
+		return nil
+	}
+	return msg[offset : offset+length]
+}
+
+func getFakeSMBCreatePath(pkt []byte) string {
+	req := pkt[64:]
+	if len(req) < 56 {
+		return ""
+	}
+	return getFakeSMBPath(decodeFakeSMBString(getFakeSMBBuffer(pkt, uint32(binary.LittleEndian.Uint16(req[44:46])),
+		uint32(binary.LittleEndian.Uint16(req[46:48])))))
+}
+
+// getFakeSMBPath converts a share relative SMB path to the key used for the
+// entries
+func getFakeSMBPath(name string) string {
+	return path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))
+}
+
+func getFakeSMBAttributes(entry *fakeSMBEntry) uint32 {
+	// files have the archive attribute as on Windows, this way the
+	// attributes are never zero if the read-only attribute is removed
+	var attrs uint32 = 0x20
+	if entry.isDir {
+		attrs = 0x10
+	}
+	if entry.readOnly {
+		attrs |= 0x1
+	}
+	return attrs
+}
+
+// putFakeSMBTimes writes the creation, last access, last write and change
+// times
+func putFakeSMBTimes(buf []byte, entry *fakeSMBEntry) {
+	binary.LittleEndian.PutUint64(buf[:8], getFakeSMBFiletime(entry.modTime))
+	binary.LittleEndian.PutUint64(buf[8:16], getFakeSMBFiletime(entry.atime))
+	binary.LittleEndian.PutUint64(buf[16:24], getFakeSMBFiletime(entry.modTime))
+	binary.LittleEndian.PutUint64(buf[24:32], getFakeSMBFiletime(entry.modTime))
+}
+
+func getFakeSMBFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + fakeSMBFiletimeEpoch)
+}
+
+func getFakeSMBTime(filetime uint64) time.Time {
+	return time.Unix(0, (int64(filetime)-fakeSMBFiletimeEpoch)*100).UTC()
+}
+
+func encodeFakeSMBString(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	result := make([]byte, 2*len(encoded))
+	for idx, val := range encoded {
+		binary.LittleEndian.PutUint16(result[2*idx:], val)
+	}
+	return result
+}
+
+func decodeFakeSMBString(b []byte) string {
+	encoded := make([]uint16, len(b)/2)
+	for idx := range encoded {
+		encoded[idx] = binary.LittleEndian.Uint16(b[2*idx:])
+	}
+	return string(utf16.Decode(encoded))
+}
+
+func newTestSMBFs(t *testing.T, server *fakeSMBServer, config SMBFsConfig) *SMBFs {
+	config.Endpoint = server.Addr()
+	if config.Share == "" {
+		config.Share = fakeSMBShare
+	}
+	if config.Username == "" {
+		config.Username = fakeSMBUsername
+	}
+	if config.Password == nil {
+		config.Password = kms.NewPlainSecret(fakeSMBPassword)
+	}
+	fs, err := NewSMBFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*SMBFs)
+}
+
+func uploadTestSMBFile(t *testing.T, fs *SMBFs, name string, data []byte) error {
+	f, w, _, err := fs.Create(name, 0, 0)
+	if err != nil {
+		return err
+	}
+	require.Nil(t, w)
+	_, err = f.Write(data)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func downloadTestSMBFile(t *testing.T, fs *SMBFs, name string, offset int64) ([]byte, error) {
+	f, r, _, err := fs.Open(name, offset)
+	if err != nil {
+		return nil, err
+	}
+	require.Nil(t, r)
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+func TestSMBFsBasicOperations(t *testing.T) {
+	server := newFakeSMBServer(t)
+	fs := newTestSMBFs(t, server, SMBFsConfig{})
+
+	require.NoError(t, fs.Mkdir("/dir"))
+	err := fs.Mkdir("/dir")
+	assert.ErrorIs(t, err, os.ErrExist)
+	require.NoError(t, uploadTestSMBFile(t, fs, "/dir/file.txt", []byte("content")))
+	entry, ok := server.getEntry("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	data, err := downloadTestSMBFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestSMBFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Lstat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "file.txt", info.Name())
+	assert.Equal(t, int64(7), info.Size())
+	assert.Equal(t, entry.modTime, info.ModTime().UTC())
+
+	server.putFile("/dir/page.html", []byte("<html><body>test</body></html>"))
+	mimeType, err := fs.GetMimeType("/dir/page.html")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", mimeType)
+	entries := listTestDir(t, fs, "/dir")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.False(t, e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"file.txt", "page.html"}, names)
+	// resumed uploads append to the existing file
+	f, _, _, err := fs.Create("/dir/file.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" appended"))
+	assert.NoError(t, err)
+	require.NoError(t, f.Close())
+	entry, _ = server.getEntry("/dir/file.txt")
+	assert.Equal(t, "content appended", string(entry.data))
+	require.NoError(t, fs.Truncate("/dir/file.txt", 7))
+	entry, _ = server.getEntry("/dir/file.txt")
+	assert.Equal(t, "content", string(entry.data))
+	mtime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, fs.Chtimes("/dir/file.txt", mtime, mtime, false))
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, mtime, info.ModTime().UTC())
+	// reads and writes bigger than the max I/O size are split
+	largeData := bytes.Repeat([]byte("0123456789"), 20*1024)
+	writeOps := server.getOpCount("write")
+	require.NoError(t, uploadTestSMBFile(t, fs, "/large.bin", largeData))
+	assert.Equal(t, writeOps+4, server.getOpCount("write"))
+	data, err = downloadTestSMBFile(t, fs, "/large.bin", int64(len(largeData)-5))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("56789"), data)
+	data, err = downloadTestSMBFile(t, fs, "/large.bin", 0)
+	require.NoError(t, err)
+	assert.Equal(t, largeData, data)
+
+	numFiles, size, err := fs.Rename("/dir/file.txt", "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	_, ok = server.getEntry("/dir/file.txt")
+	assert.False(t, ok)
+	// existing files are not replaced, they are removed before retrying
+	server.putFile("/dir/other", []byte("other content"))
+	setInfoOps := server.getOpCount("set_info")
+	_, _, err = fs.Rename("/file.txt", "/dir/other")
+	require.NoError(t, err)
+	assert.Equal(t, setInfoOps+3, server.getOpCount("set_info"))
+	entry, ok = server.getEntry("/dir/other")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	// directories are moved with their contents
+	_, _, err = fs.Rename("/dir", "/moved")
+	require.NoError(t, err)
+	entry, ok = server.getEntry("/moved/other")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(entry.data))
+	numFiles, size, err = fs.GetDirSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(len(largeData)+37), size)
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4096), statVFS.Bsize)
+	assert.Equal(t, uint64(1000), statVFS.Blocks)
+	assert.Equal(t, uint64(700), statVFS.Bfree)
+	assert.Equal(t, uint64(600), statVFS.Bavail)
+	assert.Equal(t, uint64(smbStatVFSMaxFilenameSize), statVFS.Namemax)
+
+	err = fs.Remove("/moved", true)
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+	// the read-only attribute is removed before retrying
+	server.setReadOnly("/moved/other")
+	info, err = fs.Stat("/moved/other")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), info.Mode())
+	require.NoError(t, fs.Remove("/moved/other", false))
+	require.NoError(t, fs.Remove("/moved/page.html", false))
+	require.NoError(t, fs.Remove("/moved", true))
+	_, err = fs.Stat("/moved")
+	assert.True(t, fs.IsNotExist(err))
+	assert.Equal(t, 0, server.getOpenHandles())
+
+	assert.True(t, fs.IsNotSupported(fs.Symlink("/large.bin", "/link")))
+	_, err = fs.Readlink("/link")
+	assert.True(t, fs.IsNotSupported(err))
+	assert.True(t, fs.IsNotSupported(fs.Chmod("/large.bin", 0600)))
+	assert.True(t, fs.IsNotSupported(fs.Chown("/large.bin", 1000, 1000)))
+}
+
+func TestSMBFsReadDirPagination(t *testing.T) {
+	server := newFakeSMBServer(t)
+	fs := newTestSMBFs(t, server, SMBFsConfig{})
+
+	numFiles := 3*fakeSMBDirBatchSize + 5
+	expected := make([]string, 0, numFiles+1)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putFile("/dir/"+name, []byte(name))
+		expected = append(expected, name)
+	}
+	server.putFile("/dir/sub/file", []byte("data"))
+	expected = append(expected, "sub")
+
+	queryOps := server.getOpCount("query_directory")
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	// the dot entries are included in the first batch and the listing
+	// ends with STATUS_NO_MORE_FILES
+	assert.Equal(t, queryOps+5, server.getOpCount("query_directory"))
+	entries, err := lister.Next(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "file0000", entries[0].Name())
+	require.NoError(t, lister.Close())
+
+	entries = listTestDir(t, fs, "/dir")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, e.Name() == "sub", e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, expected, names)
+
+	numFiles, size, err := fs.GetDirSize("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, 3*fakeSMBDirBatchSize+6, numFiles)
+	assert.Equal(t, int64(8*(3*fakeSMBDirBatchSize+5)+4), size)
+	var walked []string
+	err = fs.Walk("/dir/sub", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dir/sub", "/dir/sub/file"}, walked)
+	assert.Equal(t, 0, server.getOpenHandles())
+}
+
+func TestSMBFsPrefix(t *testing.T) {
+	server := newFakeSMBServer(t)
+	fs := newTestSMBFs(t, server, SMBFsConfig{
+		Prefix: "/base/user",
+	})
+
+	assert.True(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+	entry, ok := server.getEntry("/base/user")
+	require.True(t, ok)
+	assert.True(t, entry.isDir)
+	// the root path already exists
+	assert.True(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+
+	name, err := fs.ResolvePath("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "/base/user/dir/file", name)
+	assert.Equal(t, "/dir/file", fs.GetRelativePath(name))
+	require.NoError(t, fs.Mkdir(path.Dir(name)))
+	require.NoError(t, uploadTestSMBFile(t, fs, name, []byte("data")))
+	_, ok = server.getEntry("/base/user/dir/file")
+	assert.True(t, ok)
+	server.putFile("/base/other", []byte("outside the prefix"))
+	numFiles, size, err := fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(4), size)
+
+	server.putFile("/base/file", []byte("data"))
+	fs = newTestSMBFs(t, server, SMBFsConfig{
+		Prefix: "/base/file",
+	})
+	assert.False(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+}
+
+func TestSMBFsErrors(t *testing.T) {
+	server := newFakeSMBServer(t)
+
+	_, err := NewSMBFs("connID", t.TempDir(), "", SMBFsConfig{
+		Endpoint: server.Addr(),
+		Share:    fakeSMBShare,
+		Username: fakeSMBUsername,
+		Password: kms.NewPlainSecret("wrong password"),
+	})
+	assert.ErrorContains(t, err, "unable to authenticate")
+	_, err = NewSMBFs("connID", t.TempDir(), "", SMBFsConfig{
+		Endpoint: server.Addr(),
+		Share:    "missing",
+		Username: fakeSMBUsername,
+		Password: kms.NewPlainSecret(fakeSMBPassword),
+	})
+	assert.ErrorContains(t, err, "unable to mount share")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	endpoint := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	_, err = NewSMBFs("connID", t.TempDir(), "", SMBFsConfig{
+		Endpoint: endpoint,
+		Share:    fakeSMBShare,
+		Username: fakeSMBUsername,
+		Password: kms.NewPlainSecret(fakeSMBPassword),
+	})
+	assert.ErrorContains(t, err, "unable to connect")
+
+	fs := newTestSMBFs(t, server, SMBFsConfig{})
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Mkdir("/missing/dir")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Truncate("/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Chtimes("/missing", time.Now(), time.Now(), false)
+	assert.True(t, fs.IsNotExist(err))
+	err = uploadTestSMBFile(t, fs, "/missing/file", []byte("data"))
+	assert.True(t, fs.IsNotExist(err))
+	_, err = downloadTestSMBFile(t, fs, "/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.GetMimeType("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.GetAvailableDiskSize("/missing")
+	assert.True(t, fs.IsNotExist(err))
+
+	server.putFile("/dir/file", []byte("data"))
+	_, err = fs.ReadDir("/dir/file")
+	assert.Error(t, err)
+	err = fs.Truncate("/dir", 0)
+	assert.Error(t, err)
+	// directories are not replaced
+	_, _, err = fs.Rename("/dir/file", "/dir")
+	assert.ErrorIs(t, err, os.ErrExist)
+	entry, ok := server.getEntry("/dir/file")
+	require.True(t, ok)
+	assert.Equal(t, "data", string(entry.data))
+
+	server.setOnRequest(func(op, name string) uint32 {
+		if op == "create" && name == "/dir/file" {
+			return fakeSMBStatusAccessDenied
+		}
+		return 0
+	})
+	_, err = fs.Stat("/dir/file")
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestSMBFile(t, fs, "/dir/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestSMBFile(t, fs, "/dir/file", []byte("new data"))
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.Rename("/dir/file", "/file")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("/dir/file", false)
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(func(op, _ string) uint32 {
+		if op == "write" {
+			return fakeSMBStatusAccessDenied
+		}
+		return 0
+	})
+	err = uploadTestSMBFile(t, fs, "/dir/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	server.setOnRequest(nil)
+	entry, ok = server.getEntry("/dir/file")
+	require.True(t, ok)
+	assert.Equal(t, "data", string(entry.data))
+	assert.Equal(t, 0, server.getOpenHandles())
+	// a new connection is established after a transport error
+	negotiateOps := server.getOpCount("negotiate")
+	server.dropConnections()
+	_, err = fs.Stat("/dir/file")
+	assert.Error(t, err)
+	info, err := fs.Stat("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	assert.Equal(t, negotiateOps+1, server.getOpCount("negotiate"))
+}
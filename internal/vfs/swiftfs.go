@@ -0,0 +1,1324 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noswift
+// +build !noswift
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	defaultSwiftPageSize       = 1000
+	defaultSwiftUploadPartSize = 100
+	swiftDirContentType        = "application/directory"
+	swiftSegmentsSuffix        = "_segments"
+	swiftObjectStoreType       = "object-store"
+	swiftStatVFSBlockSize      = 4096
+	swiftStatVFSMaxNameSize    = 1024
+	// the token is renewed if it expires within this interval
+	swiftTokenRenewInterval = 5 * time.Minute
+)
+
+// SwiftFs is a Fs implementation for OpenStack Swift object storage.
+// Keystone v3 is used for authentication, files bigger than the upload
+// part size are uploaded as Dynamic Large Objects
+type SwiftFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath  string
+	config     *SwiftFsConfig
+	client     *http.Client
+	ctxTimeout time.Duration
+	// identifies the container in the metadata cache
+	cacheBackend string
+	mu           sync.Mutex
+	token        string
+	storageURL   string
+	tokenExpires time.Time
+}
+
+func init() {
+	version.AddFeature("+swift")
+}
+
+// NewSwiftFs returns a SwiftFs object that allows to interact with OpenStack Swift
+func NewSwiftFs(connectionID, localTempDir, mountPath string, config SwiftFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &SwiftFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if !fs.config.Password.IsEmpty() {
+		if err := fs.config.Password.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if !fs.config.ApplicationCredentialSecret.IsEmpty() {
+		if err := fs.config.ApplicationCredentialSecret.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if fs.config.SkipTLSVerify {
+		transport.TLSClientConfig = getInsecureTLSConfig()
+	}
+	fs.client = &http.Client{
+		Transport: transport,
+	}
+	fs.cacheBackend = getMetadataCacheBackend(swiftFsName, fs.config.AuthURL, fs.config.Container)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	if _, _, err := fs.getToken(ctx, false); err != nil {
+		fsLog(fs, logger.LevelError, "unable to authenticate to Keystone: %v", err)
+		return fs, err
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *SwiftFs) Name() string {
+	return fmt.Sprintf("%s container %q", swiftFsName, fs.config.Container)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *SwiftFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *SwiftFs) Stat(name string) (os.FileInfo, error) {
+	if name == "" || name == "/" || name == "." {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	if fs.config.KeyPrefix == name+"/" {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	if info, ok := metadataCache.get(fs.cacheBackend, name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		metadataCache.add(fs.cacheBackend, name, info)
+	}
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *SwiftFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *SwiftFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	if readMetadata > 0 {
+		obj, err := fs.headObject(name)
+		if err != nil {
+			r.Close()
+			w.Close()
+			return nil, nil, nil, err
+		}
+		p.setMetadata(obj.metadata)
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		headers := http.Header{}
+		if offset > 0 {
+			headers.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err := fs.do(ctx, http.MethodGet, fs.config.Container, name, nil, headers, nil,
+			http.StatusOK, http.StatusPartialContent)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *SwiftFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	metadataCache.invalidate(fs.cacheBackend, name)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		n, err := fs.uploadObject(ctx, name, r)
+		r.CloseWithError(err) //nolint:errcheck
+		metadataCache.invalidate(fs.cacheBackend, name)
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Objects are copied server side and then deleted, the manifest is copied
+// for large objects so the segments are not copied
+func (fs *SwiftFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	_, err := fs.Stat(path.Dir(target))
+	if err != nil {
+		return -1, -1, err
+	}
+	fi, err := fs.getObjectStat(source)
+	if err != nil {
+		return -1, -1, err
+	}
+	return fs.renameInternal(source, target, fi, 0)
+}
+
+// Remove removes the named file or (empty) directory.
+// The segments are removed too for large objects
+func (fs *SwiftFs) Remove(name string, isDir bool) error {
+	objectName := name
+	if isDir {
+		hasContents, err := fs.hasContents(name)
+		if err != nil {
+			return err
+		}
+		if hasContents {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+		objectName = fs.getDirMarker(name)
+	}
+	err := fs.deleteObject(objectName, !isDir)
+	metadataCache.invalidate(fs.cacheBackend, name)
+	return err
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *SwiftFs) Mkdir(name string) error {
+	_, err := fs.Stat(name)
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	return fs.mkdirInternal(name)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*SwiftFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*SwiftFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*SwiftFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*SwiftFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time for Swift objects is the upload time
+func (*SwiftFs) Chtimes(_ string, _, _ time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	return ErrVfsUnsupported
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*SwiftFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *SwiftFs) ReadDir(dirname string) (DirLister, error) {
+	// dirname must be already cleaned
+	return &swiftDirLister{
+		fs:     fs,
+		prefix: fs.getPrefix(dirname),
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on Swift
+func (*SwiftFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*SwiftFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Swift uploads are already atomic, we don't need to upload to a temporary
+// file
+func (*SwiftFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*SwiftFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	var apiErr *swiftError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*SwiftFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *swiftError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusForbidden || apiErr.statusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*SwiftFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrVfsUnsupported)
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists.
+// If a container per user is configured it is created too
+func (fs *SwiftFs) CheckRootPath(username string, uid int, gid int) bool {
+	if fs.config.ContainerPerUser {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		if err := fs.createContainer(ctx, fs.config.Container); err != nil {
+			fsLog(fs, logger.LevelError, "unable to create container %q: %v", fs.config.Container, err)
+		}
+	}
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the container,
+// and their size
+func (fs *SwiftFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.KeyPrefix)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *SwiftFs) GetDirSize(dirname string) (int, int64, error) {
+	prefix := fs.getPrefix(dirname)
+	numFiles := 0
+	size := int64(0)
+
+	err := fs.listAll(fs.config.Container, prefix, func(entry *swiftListEntry) error {
+		if entry.isDir() {
+			return nil
+		}
+		entrySize, err := fs.getListedSize(entry)
+		if err != nil {
+			return err
+		}
+		numFiles++
+		size += entrySize
+		if numFiles%defaultSwiftPageSize == 0 {
+			fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// Swift uploads are already atomic, we never call this method for Swift
+func (*SwiftFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *SwiftFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.config.KeyPrefix != "" {
+		if !strings.HasPrefix(rel, "/"+fs.config.KeyPrefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, "/"+fs.config.KeyPrefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *SwiftFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	prefix := fs.getPrefix(root)
+
+	err := fs.listAll(fs.config.Container, prefix, func(entry *swiftListEntry) error {
+		name := strings.TrimSuffix(strings.TrimPrefix(entry.Name, prefix), "/")
+		if name == "" {
+			return nil
+		}
+		if entry.isDir() {
+			return walkFn(strings.TrimSuffix(entry.Name, "/"), NewFileInfo(name, true, 0, entry.getModTime(), false), nil)
+		}
+		size, err := fs.getListedSize(entry)
+		if err != nil {
+			walkFn(root, nil, err) //nolint:errcheck
+			return err
+		}
+		return walkFn(entry.Name, NewFileInfo(name, false, size, entry.getModTime(), false), nil)
+	})
+	walkFn(root, NewFileInfo(root, true, 0, time.Unix(0, 0), false), err) //nolint:errcheck
+	return err
+}
+
+// Join joins any number of path elements into a single path
+func (*SwiftFs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*SwiftFs) HasVirtualFolders() bool {
+	return true
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *SwiftFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *SwiftFs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	numFiles := 1
+	sizeDiff := srcSize
+	obj, err := fs.headObject(target)
+	if err == nil {
+		sizeDiff -= obj.size
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if err := fs.copyFileInternal(source, target, false); err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *SwiftFs) GetMimeType(name string) (string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return obj.contentType, nil
+}
+
+// Close closes the fs
+func (fs *SwiftFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path.
+// The size is available only if a quota is set on the container
+func (fs *SwiftFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.do(ctx, http.MethodHead, fs.config.Container, "", nil, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	quota, err := strconv.ParseInt(resp.Header.Get("X-Container-Meta-Quota-Bytes"), 10, 64)
+	if err != nil || quota <= 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	used, _ := strconv.ParseInt(resp.Header.Get("X-Container-Bytes-Used"), 10, 64)
+	available := quota - used
+	if available < 0 {
+		available = 0
+	}
+	return &sftp.StatVFS{
+		Bsize:   swiftStatVFSBlockSize,
+		Frsize:  swiftStatVFSBlockSize,
+		Blocks:  uint64(quota) / swiftStatVFSBlockSize,
+		Bfree:   uint64(available) / swiftStatVFSBlockSize,
+		Bavail:  uint64(available) / swiftStatVFSBlockSize,
+		Namemax: swiftStatVFSMaxNameSize,
+	}, nil
+}
+
+// getObjectStat returns the stat result
+func (fs *SwiftFs) getObjectStat(name string) (os.FileInfo, error) {
+	obj, err := fs.headObject(name)
+	if err == nil {
+		if obj.contentType == swiftDirContentType {
+			return NewFileInfo(name, true, 0, obj.modTime, false), nil
+		}
+		info := NewFileInfo(name, false, obj.size, obj.modTime, false)
+		info.SetETag(obj.etag)
+		return info, nil
+	}
+	if !fs.IsNotExist(err) {
+		return nil, err
+	}
+	// now check if this is a prefix (virtual directory), a directory
+	// marker is included in the prefix contents
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	entries, err := fs.list(ctx, fs.config.Container, fs.getPrefix(name), "", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	return nil, fmt.Errorf("%w: %q", os.ErrNotExist, name)
+}
+
+// uploadObject uploads the data read from r to the named object. If the data
+// exceed the upload part size, the segments are uploaded to the segments
+// container and a Dynamic Large Object manifest is then created.
+// The segments for the replaced object, if any, are removed on success
+func (fs *SwiftFs) uploadObject(ctx context.Context, name string, r *pipeat.PipeReaderAt) (int64, error) {
+	var previousManifest string
+	if obj, err := fs.headObject(name); err == nil {
+		previousManifest = obj.manifest
+	}
+	partSize := fs.getUploadPartSize()
+	headers := http.Header{}
+	headers.Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+
+	// the read blocks until more data than the part size are available or
+	// the upload ends
+	probe := make([]byte, 1)
+	if _, err := r.ReadAt(probe, partSize); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		section := io.NewSectionReader(r, 0, partSize)
+		if err := fs.putObject(ctx, fs.config.Container, name, headers, section); err != nil {
+			return 0, err
+		}
+		n, _ := section.Seek(0, io.SeekCurrent)
+		fs.deleteSegments(previousManifest)
+		return n, nil
+	}
+
+	segmentsContainer := fs.getSegmentsContainer()
+	if err := fs.createContainer(ctx, segmentsContainer); err != nil {
+		return 0, fmt.Errorf("unable to create segments container %q: %w", segmentsContainer, err)
+	}
+	segmentsPrefix := fmt.Sprintf("%s/sftpgo-%d/", name, time.Now().UnixNano())
+	manifest := url.PathEscape(segmentsContainer) + "/" + escapeSwiftObjectName(segmentsPrefix)
+	var size int64
+
+	for idx := 0; ; idx++ {
+		offset := int64(idx) * partSize
+		if idx > 0 {
+			if _, err := r.ReadAt(probe, offset); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				fs.deleteSegments(manifest)
+				return size, err
+			}
+		}
+		section := io.NewSectionReader(r, offset, partSize)
+		segmentName := fmt.Sprintf("%s%08d", segmentsPrefix, idx)
+		if err := fs.putObject(ctx, segmentsContainer, segmentName, nil, section); err != nil {
+			fs.deleteSegments(manifest)
+			return size, err
+		}
+		n, _ := section.Seek(0, io.SeekCurrent)
+		size += n
+		fsLog(fs, logger.LevelDebug, "segment %q uploaded, size: %d", segmentName, n)
+	}
+
+	headers.Set("X-Object-Manifest", manifest)
+	if err := fs.putObject(ctx, fs.config.Container, name, headers, nil); err != nil {
+		fs.deleteSegments(manifest)
+		return size, err
+	}
+	if previousManifest != manifest {
+		fs.deleteSegments(previousManifest)
+	}
+	return size, nil
+}
+
+func (fs *SwiftFs) putObject(ctx context.Context, container, name string, headers http.Header, body io.Reader) error {
+	resp, err := fs.do(ctx, http.MethodPut, container, name, nil, headers, body, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// copyFileInternal copies source to target server side. Renaming a large
+// object copies its manifest only, while copying it creates new segments,
+// so each copy can be removed independently
+func (fs *SwiftFs) copyFileInternal(source, target string, isRename bool) error {
+	defer metadataCache.invalidate(fs.cacheBackend, target)
+
+	srcObj, err := fs.headObject(source)
+	if err != nil {
+		return err
+	}
+	var previousManifest string
+	if obj, err := fs.headObject(target); err == nil {
+		previousManifest = obj.manifest
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	manifest := srcObj.manifest
+	if manifest != "" && !isRename {
+		manifest, err = fs.copySegments(source, target, srcObj.manifest)
+		if err != nil {
+			return err
+		}
+	}
+	headers := http.Header{}
+	if manifest != "" {
+		headers.Set("Content-Type", srcObj.contentType)
+		headers.Set("X-Object-Manifest", manifest)
+	} else {
+		headers.Set("X-Copy-From", "/"+url.PathEscape(fs.config.Container)+"/"+escapeSwiftObjectName(source))
+	}
+	err = fs.putObject(ctx, fs.config.Container, target, headers, nil)
+	if err != nil {
+		if manifest != srcObj.manifest {
+			fs.deleteSegments(manifest)
+		}
+	} else if previousManifest != "" && previousManifest != manifest {
+		fs.deleteSegments(previousManifest)
+	}
+	fsLog(fs, logger.LevelDebug, "copy completed, source: %q, target: %q, size: %d, err: %v",
+		source, target, srcObj.size, err)
+	return err
+}
+
+// copySegments copies the segments referenced by the specified manifest
+// server side and returns the manifest for the copied segments
+func (fs *SwiftFs) copySegments(source, target, manifest string) (string, error) {
+	container, prefix, err := parseSwiftManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+	segmentsContainer := fs.getSegmentsContainer()
+	targetPrefix := fmt.Sprintf("%s/sftpgo-%d/", target, time.Now().UnixNano())
+	targetManifest := url.PathEscape(segmentsContainer) + "/" + escapeSwiftObjectName(targetPrefix)
+
+	err = fs.listAll(container, prefix, func(entry *swiftListEntry) error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		headers := http.Header{}
+		headers.Set("X-Copy-From", "/"+url.PathEscape(container)+"/"+escapeSwiftObjectName(entry.Name))
+		return fs.putObject(ctx, segmentsContainer, targetPrefix+strings.TrimPrefix(entry.Name, prefix), headers, nil)
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to copy segments for %q: %v", source, err)
+		fs.deleteSegments(targetManifest)
+		return "", err
+	}
+	return targetManifest, nil
+}
+
+// deleteObject deletes the named object and, optionally, the segments for
+// large objects
+func (fs *SwiftFs) deleteObject(name string, deleteSegments bool) error {
+	var manifest string
+	if deleteSegments {
+		obj, err := fs.headObject(name)
+		if err != nil {
+			return err
+		}
+		manifest = obj.manifest
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.do(ctx, http.MethodDelete, fs.config.Container, name, nil, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fs.deleteSegments(manifest)
+	return nil
+}
+
+// deleteSegments removes the segments referenced by the specified manifest,
+// errors are logged and ignored
+func (fs *SwiftFs) deleteSegments(manifest string) {
+	if manifest == "" {
+		return
+	}
+	container, prefix, err := parseSwiftManifest(manifest)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to delete segments: %v", err)
+		return
+	}
+	err = fs.listAll(container, prefix, func(entry *swiftListEntry) error {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		resp, err := fs.do(ctx, http.MethodDelete, container, entry.Name, nil, nil, nil, http.StatusNoContent, http.StatusOK)
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to delete segments for manifest %q: %v", manifest, err)
+	}
+}
+
+func (fs *SwiftFs) renameInternal(source, target string, fi os.FileInfo, recursion int) (int, int64, error) {
+	var numFiles int
+	var filesSize int64
+
+	if fi.IsDir() {
+		if renameMode == 0 {
+			hasContents, err := fs.hasContents(source)
+			if err != nil {
+				return numFiles, filesSize, err
+			}
+			if hasContents {
+				return numFiles, filesSize, fmt.Errorf("%w: cannot rename non empty directory: %q", ErrVfsUnsupported, source)
+			}
+		}
+		if err := fs.mkdirInternal(target); err != nil {
+			return numFiles, filesSize, err
+		}
+		if renameMode == 1 {
+			files, size, err := doRecursiveRename(fs, source, target, fs.renameInternal, recursion)
+			numFiles += files
+			filesSize += size
+			if err != nil {
+				return numFiles, filesSize, err
+			}
+		}
+		err := fs.Remove(source, true)
+		if fs.IsNotExist(err) {
+			err = nil
+		}
+		return numFiles, filesSize, err
+	}
+	if err := fs.copyFileInternal(source, target, true); err != nil {
+		return numFiles, filesSize, err
+	}
+	numFiles++
+	filesSize += fi.Size()
+	// the segments, if any, are now referenced by the target manifest
+	err := fs.deleteObject(source, false)
+	metadataCache.invalidate(fs.cacheBackend, source)
+	if fs.IsNotExist(err) {
+		err = nil
+	}
+	return numFiles, filesSize, err
+}
+
+func (fs *SwiftFs) mkdirInternal(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	headers := http.Header{}
+	headers.Set("Content-Type", swiftDirContentType)
+	err := fs.putObject(ctx, fs.config.Container, fs.getDirMarker(name), headers, nil)
+	metadataCache.invalidate(fs.cacheBackend, name)
+	return err
+}
+
+func (fs *SwiftFs) hasContents(name string) (bool, error) {
+	prefix := fs.getPrefix(name)
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	// if we have a directory marker it will be returned so we set the limit to 2
+	entries, err := fs.list(ctx, fs.config.Container, prefix, "", "", 2)
+	if err != nil {
+		return false, err
+	}
+	for idx := range entries {
+		if entries[idx].Name != prefix {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (fs *SwiftFs) createContainer(ctx context.Context, container string) error {
+	resp, err := fs.do(ctx, http.MethodPut, container, "", nil, nil, nil, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (fs *SwiftFs) getPrefix(name string) string {
+	prefix := ""
+	if name != "" && name != "." && name != "/" {
+		prefix = strings.TrimPrefix(name, "/")
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	return prefix
+}
+
+// getDirMarker returns the name of the object used to represent a directory,
+// the same convention used by the Swift CLI and web UI
+func (fs *SwiftFs) getDirMarker(name string) string {
+	return fs.getPrefix(name)
+}
+
+func (fs *SwiftFs) getSegmentsContainer() string {
+	return fs.config.Container + swiftSegmentsSuffix
+}
+
+func (fs *SwiftFs) getUploadPartSize() int64 {
+	if fs.config.UploadPartSize > 0 {
+		return fs.config.UploadPartSize * 1024 * 1024
+	}
+	return defaultSwiftUploadPartSize * 1024 * 1024
+}
+
+// getListedSize returns the size for a listed object. Large object manifests
+// are listed with a zero size so the real size is read from the object
+func (fs *SwiftFs) getListedSize(entry *swiftListEntry) (int64, error) {
+	if entry.Bytes > 0 {
+		return entry.Bytes, nil
+	}
+	obj, err := fs.headObject(entry.Name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return obj.size, nil
+}
+
+func (fs *SwiftFs) headObject(name string) (*swiftObject, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.do(ctx, http.MethodHead, fs.config.Container, name, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	obj := &swiftObject{
+		size:        resp.ContentLength,
+		contentType: resp.Header.Get("Content-Type"),
+		manifest:    resp.Header.Get("X-Object-Manifest"),
+		metadata:    make(map[string]string),
+	}
+	if etag := strings.Trim(resp.Header.Get("Etag"), `"`); etag != "" {
+		obj.etag = `"` + etag + `"`
+	}
+	obj.modTime, err = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		obj.modTime = time.Unix(0, 0)
+	}
+	for k, v := range resp.Header {
+		if key, ok := strings.CutPrefix(k, "X-Object-Meta-"); ok && len(v) > 0 {
+			obj.metadata[strings.ToLower(key)] = v[0]
+		}
+	}
+	return obj, nil
+}
+
+// list returns a page of objects from the specified container
+func (fs *SwiftFs) list(ctx context.Context, container, prefix, delimiter, marker string, limit int,
+) ([]swiftListEntry, error) {
+	params := url.Values{}
+	params.Set("format", "json")
+	params.Set("limit", strconv.Itoa(limit))
+	if prefix != "" {
+		params.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		params.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		params.Set("marker", marker)
+	}
+	resp, err := fs.do(ctx, http.MethodGet, container, "", params, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var entries []swiftListEntry
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize*10)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("unable to decode Swift listing: %w", err)
+	}
+	return entries, nil
+}
+
+// listAll calls fn for each object with the specified prefix
+func (fs *SwiftFs) listAll(container, prefix string, fn func(*swiftListEntry) error) error {
+	marker := ""
+	for {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		entries, err := fs.list(ctx, container, prefix, "", marker, defaultSwiftPageSize)
+		cancelFn()
+		if err != nil {
+			return err
+		}
+		for idx := range entries {
+			if err := fn(&entries[idx]); err != nil {
+				return err
+			}
+			marker = entries[idx].Name
+		}
+		if len(entries) < defaultSwiftPageSize {
+			return nil
+		}
+	}
+}
+
+// getToken returns the cached Keystone token and the object storage URL.
+// A new token is requested if the cached one is expiring or if force is true
+func (fs *SwiftFs) getToken(ctx context.Context, force bool) (string, string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !force && fs.token != "" && time.Until(fs.tokenExpires) > swiftTokenRenewInterval {
+		return fs.token, fs.storageURL, nil
+	}
+	body, err := json.Marshal(fs.getAuthRequest())
+	if err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fs.config.AuthURL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.GetServerVersion("/", false))
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to send Keystone authentication request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", "", getSwiftError(req, resp)
+	}
+	var result struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					RegionID  string `json:"region_id"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(&result); err != nil {
+		return "", "", fmt.Errorf("unable to decode Keystone authentication response: %w", err)
+	}
+	token := resp.Header.Get("X-Subject-Token")
+	if token == "" {
+		return "", "", errors.New("no token returned from Keystone")
+	}
+	storageURL := ""
+	for _, service := range result.Token.Catalog {
+		if service.Type != swiftObjectStoreType {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface != fs.config.EndpointType {
+				continue
+			}
+			if fs.config.Region != "" && endpoint.Region != fs.config.Region && endpoint.RegionID != fs.config.Region {
+				continue
+			}
+			storageURL = strings.TrimRight(endpoint.URL, "/")
+			break
+		}
+	}
+	if storageURL == "" {
+		return "", "", fmt.Errorf("no %s object-store endpoint found in the service catalog, region %q",
+			fs.config.EndpointType, fs.config.Region)
+	}
+	fs.token = token
+	fs.storageURL = storageURL
+	fs.tokenExpires = result.Token.ExpiresAt
+	return token, storageURL, nil
+}
+
+func (fs *SwiftFs) getAuthRequest() map[string]any {
+	if fs.config.ApplicationCredentialID != "" {
+		// application credentials are already scoped to a project
+		return map[string]any{
+			"auth": map[string]any{
+				"identity": map[string]any{
+					"methods": []string{"application_credential"},
+					"application_credential": map[string]any{
+						"id":     fs.config.ApplicationCredentialID,
+						"secret": fs.config.ApplicationCredentialSecret.GetPayload(),
+					},
+				},
+			},
+		}
+	}
+	project := map[string]any{}
+	if fs.config.ProjectID != "" {
+		project["id"] = fs.config.ProjectID
+	} else {
+		project["name"] = fs.config.ProjectName
+		project["domain"] = map[string]any{"name": fs.config.ProjectDomain}
+	}
+	return map[string]any{
+		"auth": map[string]any{
+			"identity": map[string]any{
+				"methods": []string{"password"},
+				"password": map[string]any{
+					"user": map[string]any{
+						"name":     fs.config.Username,
+						"domain":   map[string]any{"name": fs.config.UserDomain},
+						"password": fs.config.Password.GetPayload(),
+					},
+				},
+			},
+			"scope": map[string]any{
+				"project": project,
+			},
+		},
+	}
+}
+
+// do sends a request to the object storage and returns an error if the
+// response status code is not one of the expected ones. Requests without a
+// body are retried once with a new token if the current one is rejected
+func (fs *SwiftFs) do(ctx context.Context, method, container, name string, params url.Values, headers http.Header,
+	body io.Reader, expectedCodes ...int,
+) (*http.Response, error) {
+	force := false
+	for {
+		token, storageURL, err := fs.getToken(ctx, force)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate Swift request: %w", err)
+		}
+		reqURL := storageURL + "/" + url.PathEscape(container)
+		if name != "" {
+			reqURL += "/" + escapeSwiftObjectName(name)
+		}
+		if len(params) > 0 {
+			reqURL += "?" + params.Encode()
+		}
+		req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		req.Header.Set("X-Auth-Token", token)
+		req.Header.Set("User-Agent", version.GetServerVersion("/", false))
+		resp, err := fs.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to send Swift request %s %q: %w", method, req.URL.Path, err)
+		}
+		for _, code := range expectedCodes {
+			if resp.StatusCode == code {
+				return resp, nil
+			}
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !force && body == nil {
+			resp.Body.Close()
+			force = true
+			continue
+		}
+		defer resp.Body.Close()
+
+		return nil, getSwiftError(req, resp)
+	}
+}
+
+// escapeSwiftObjectName escapes each path component of an object name
+func escapeSwiftObjectName(name string) string {
+	parts := strings.Split(name, "/")
+	for idx := range parts {
+		parts[idx] = url.PathEscape(parts[idx])
+	}
+	return strings.Join(parts, "/")
+}
+
+// parseSwiftManifest returns the container and the prefix for the segments
+// of a Dynamic Large Object
+func parseSwiftManifest(manifest string) (string, string, error) {
+	value, err := url.PathUnescape(manifest)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid manifest %q: %w", manifest, err)
+	}
+	container, prefix, ok := strings.Cut(value, "/")
+	if !ok || container == "" || prefix == "" {
+		return "", "", fmt.Errorf("invalid manifest %q", manifest)
+	}
+	return container, prefix, nil
+}
+
+func getSwiftError(req *http.Request, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPFsResponseSize))
+	return &swiftError{
+		method:     req.Method,
+		path:       req.URL.Path,
+		statusCode: resp.StatusCode,
+		message:    strings.TrimSpace(string(body)),
+	}
+}
+
+type swiftError struct {
+	method     string
+	path       string
+	statusCode int
+	message    string
+}
+
+func (e *swiftError) Error() string {
+	if e.message != "" {
+		return fmt.Sprintf("Swift %s request for %q failed, status code: %d, %s", e.method, e.path, e.statusCode,
+			e.message)
+	}
+	return fmt.Sprintf("Swift %s request for %q failed, status code: %d", e.method, e.path, e.statusCode)
+}
+
+type swiftObject struct {
+	size        int64
+	contentType string
+	etag        string
+	modTime     time.Time
+	manifest    string
+	metadata    map[string]string
+}
+
+type swiftListEntry struct {
+	Name         string `json:"name"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash"`
+	ContentType  string `json:"content_type"`
+	LastModified string `json:"last_modified"`
+	Subdir       string `json:"subdir"`
+}
+
+func (e *swiftListEntry) isDir() bool {
+	return strings.HasSuffix(e.Name, "/") || e.ContentType == swiftDirContentType
+}
+
+// getModTime returns the modification time, Swift listings use UTC times
+// without the timezone
+func (e *swiftListEntry) getModTime() time.Time {
+	modTime, err := time.Parse("2006-01-02T15:04:05.999999", e.LastModified)
+	if err != nil {
+		return time.Unix(0, 0)
+	}
+	return modTime
+}
+
+func (e *swiftListEntry) getETag() string {
+	if e.Hash == "" {
+		return ""
+	}
+	return `"` + e.Hash + `"`
+}
+
+type swiftDirLister struct {
+	baseDirLister
+	fs       *SwiftFs
+	prefix   string
+	marker   string
+	finished bool
+}
+
+func (l *swiftDirLister) fetch() error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.fs.ctxTimeout))
+	defer cancelFn()
+
+	entries, err := l.fs.list(ctx, l.fs.config.Container, l.prefix, "/", l.marker, defaultSwiftPageSize)
+	if err != nil {
+		return err
+	}
+	l.finished = len(entries) < defaultSwiftPageSize
+	for idx := range entries {
+		entry := &entries[idx]
+		if entry.Subdir != "" {
+			l.marker = entry.Subdir
+			name := strings.TrimSuffix(strings.TrimPrefix(entry.Subdir, l.prefix), "/")
+			if name != "" {
+				l.cache = append(l.cache, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
+			}
+			continue
+		}
+		l.marker = entry.Name
+		name := strings.TrimPrefix(entry.Name, l.prefix)
+		if name == "" {
+			continue
+		}
+		if entry.isDir() {
+			l.cache = append(l.cache, NewFileInfo(name, true, 0, entry.getModTime(), false))
+			continue
+		}
+		size, err := l.fs.getListedSize(entry)
+		if err != nil {
+			return err
+		}
+		modTime := entry.getModTime()
+		info := NewFileInfo(name, false, size, modTime, false)
+		info.SetETag(entry.getETag())
+		metadataCache.addListedFile(l.fs.cacheBackend, l.prefix, name, size, modTime, info.ETag())
+		l.cache = append(l.cache, info)
+	}
+	return nil
+}
+
+func (l *swiftDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && !l.finished {
+		if err := l.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	if len(l.cache) >= limit || !l.finished {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
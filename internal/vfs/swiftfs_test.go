@@ -0,0 +1,1197 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !noswift
+// +build !noswift
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeSwiftAccount       = "AUTH_test"
+	fakeSwiftContainer     = "test"
+	fakeSwiftUsername      = "user"
+	fakeSwiftPassword      = "password"
+	fakeSwiftProjectName   = "project"
+	fakeSwiftProjectID     = "project-id"
+	fakeSwiftAppCredID     = "app-cred-id"
+	fakeSwiftAppCredSecret = "app-cred-secret"
+	// the maximum number of entries returned for a container listing
+	fakeSwiftListingLimit = 10000
+)
+
+type fakeSwiftObject struct {
+	data        []byte
+	contentType string
+	manifest    string
+	metadata    map[string]string
+	modTime     time.Time
+}
+
+// fakeSwiftServer implements the Keystone v3 token API and the Swift object
+// storage API used by SwiftFs. Containers are stored in memory by account,
+// the account depends on the endpoint selected from the service catalog
+type fakeSwiftServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// objects by "<account>/<container>" and name
+	containers map[string]map[string]*fakeSwiftObject
+	ops        map[string]int
+	tokens     map[string]bool
+	numTokens  int
+	tokenTTL   time.Duration
+	// the quota returned for the containers, if greater than zero
+	quota int64
+	// if set it is called for each authenticated request, a status code
+	// different from zero is returned as an error response without
+	// processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeSwiftServer(t *testing.T) *fakeSwiftServer {
+	s := &fakeSwiftServer{
+		containers: map[string]map[string]*fakeSwiftObject{
+			fakeSwiftAccount + "/" + fakeSwiftContainer: {},
+		},
+		ops:      make(map[string]int),
+		tokens:   make(map[string]bool),
+		tokenTTL: time.Hour,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeSwiftServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeSwiftServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeSwiftServer) update(fn func(s *fakeSwiftServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+}
+
+// revokeTokens invalidates the issued tokens, as if they were expired
+func (s *fakeSwiftServer) revokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]bool)
+}
+
+func (s *fakeSwiftServer) getObject(container, name string) (fakeSwiftObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.containers[fakeSwiftAccount+"/"+container][name]
+	if !ok {
+		return fakeSwiftObject{}, false
+	}
+	return *obj, true
+}
+
+// getObjectNames returns the sorted object names for the specified container
+func (s *fakeSwiftServer) getObjectNames(container string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.containers[fakeSwiftAccount+"/"+container] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *fakeSwiftServer) putObject(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.containers[fakeSwiftAccount+"/"+fakeSwiftContainer][name] = &fakeSwiftObject{
+		data:        data,
+		contentType: "application/octet-stream",
+		modTime:     time.Now(),
+	}
+}
+
+// getDataLocked returns the object data, the segments are concatenated for
+// large objects
+func (s *fakeSwiftServer) getDataLocked(account string, obj *fakeSwiftObject) []byte {
+	if obj.manifest == "" {
+		return obj.data
+	}
+	container, prefix, err := parseSwiftManifest(obj.manifest)
+	if err != nil {
+		return nil
+	}
+	objects := s.containers[account+"/"+container]
+	var names []string
+	for name := range objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var data []byte
+	for _, name := range names {
+		data = append(data, objects[name].data...)
+	}
+	return data
+}
+
+func (s *fakeSwiftServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/tokens" {
+		s.handleAuth(w, r)
+		return
+	}
+	account, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	container, name, _ := strings.Cut(rest, "/")
+	op := r.Method + " container"
+	if name != "" {
+		op = r.Method + " object"
+	}
+
+	s.mu.Lock()
+	s.ops[op]++
+	isValidToken := s.tokens[r.Header.Get("X-Auth-Token")]
+	onRequest := s.onRequest
+	s.mu.Unlock()
+
+	if !isValidToken {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if onRequest != nil {
+		if status := onRequest(op, r); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/") || account == "" || container == "" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		s.handleContainer(w, r, account, container)
+		return
+	}
+	s.handleObject(w, r, account, container, name)
+}
+
+func (s *fakeSwiftServer) handleAuth(w http.ResponseWriter, r *http.Request) {
+	var authReq struct {
+		Auth struct {
+			Identity struct {
+				Methods  []string `json:"methods"`
+				Password struct {
+					User struct {
+						Name   string `json:"name"`
+						Domain struct {
+							Name string `json:"name"`
+						} `json:"domain"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+				ApplicationCredential struct {
+					ID     string `json:"id"`
+					Secret string `json:"secret"`
+				} `json:"application_credential"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					ID     string `json:"id"`
+					Name   string `json:"name"`
+					Domain struct {
+						Name string `json:"name"`
+					} `json:"domain"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops["auth"]++
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&authReq) != nil ||
+		len(authReq.Auth.Identity.Methods) != 1 {
+		http.Error(w, "Invalid authentication request", http.StatusBadRequest)
+		return
+	}
+	identity := authReq.Auth.Identity
+	project := authReq.Auth.Scope.Project
+	isValid := false
+	switch identity.Methods[0] {
+	case "password":
+		isValid = identity.Password.User.Name == fakeSwiftUsername &&
+			identity.Password.User.Domain.Name == "Default" &&
+			identity.Password.User.Password == fakeSwiftPassword &&
+			(project.ID == fakeSwiftProjectID || (project.Name == fakeSwiftProjectName && project.Domain.Name == "Default"))
+	case "application_credential":
+		isValid = identity.ApplicationCredential.ID == fakeSwiftAppCredID &&
+			identity.ApplicationCredential.Secret == fakeSwiftAppCredSecret
+	}
+	if !isValid {
+		http.Error(w, "The request you have made requires authentication.", http.StatusUnauthorized)
+		return
+	}
+	s.numTokens++
+	token := fmt.Sprintf("token%d", s.numTokens)
+	s.tokens[token] = true
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Subject-Token", token)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck
+		"token": map[string]any{
+			"expires_at": time.Now().Add(s.tokenTTL).UTC().Format("2006-01-02T15:04:05.000000Z"),
+			"catalog": []map[string]any{
+				{
+					"type": "identity",
+					"endpoints": []map[string]string{
+						{"interface": "public", "region": "RegionOne", "region_id": "RegionOne", "url": s.URL + "/v3"},
+					},
+				},
+				{
+					"type": swiftObjectStoreType,
+					"endpoints": []map[string]string{
+						{"interface": "internal", "region": "RegionOne", "region_id": "RegionOne",
+							"url": s.URL + "/v1/AUTH_internal/"},
+						{"interface": "public", "region": "RegionOne", "region_id": "RegionOne",
+							"url": s.URL + "/v1/" + fakeSwiftAccount},
+						{"interface": "public", "region": "RegionTwo", "region_id": "region-two",
+							"url": s.URL + "/v1/AUTH_other"},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (s *fakeSwiftServer) handleContainer(w http.ResponseWriter, r *http.Request, account, container string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.containers[account+"/"+container]
+	if r.Method == http.MethodPut {
+		if ok {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		s.containers[account+"/"+container] = make(map[string]*fakeSwiftObject)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		var bytesUsed int64
+		for _, obj := range objects {
+			bytesUsed += int64(len(obj.data))
+		}
+		w.Header().Set("X-Container-Object-Count", strconv.Itoa(len(objects)))
+		w.Header().Set("X-Container-Bytes-Used", strconv.FormatInt(bytesUsed, 10))
+		if s.quota > 0 {
+			w.Header().Set("X-Container-Meta-Quota-Bytes", strconv.FormatInt(s.quota, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		s.handleListingLocked(w, r, objects)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeSwiftServer) handleListingLocked(w http.ResponseWriter, r *http.Request, objects map[string]*fakeSwiftObject) {
+	query := r.URL.Query()
+	if query.Get("format") != "json" {
+		http.Error(w, "Only JSON listings are supported", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	marker := query.Get("marker")
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = fakeSwiftListingLimit
+	}
+	if limit > fakeSwiftListingLimit {
+		http.Error(w, "Maximum limit exceeded", http.StatusPreconditionFailed)
+		return
+	}
+	var names []string
+	for name := range objects {
+		if strings.HasPrefix(name, prefix) && name > marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := []map[string]any{}
+	lastSubdir := ""
+	for _, name := range names {
+		if len(result) >= limit {
+			break
+		}
+		if delimiter != "" {
+			if idx := strings.Index(name[len(prefix):], delimiter); idx >= 0 {
+				subdir := name[:len(prefix)+idx+len(delimiter)]
+				if subdir != lastSubdir && subdir > marker {
+					result = append(result, map[string]any{"subdir": subdir})
+				}
+				lastSubdir = subdir
+				continue
+			}
+		}
+		obj := objects[name]
+		hash := md5.Sum(obj.data)
+		result = append(result, map[string]any{
+			"name":          name,
+			"bytes":         len(obj.data),
+			"hash":          hex.EncodeToString(hash[:]),
+			"content_type":  obj.contentType,
+			"last_modified": obj.modTime.UTC().Format("2006-01-02T15:04:05.000000"),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result) //nolint:errcheck
+}
+
+func (s *fakeSwiftServer) handleObject(w http.ResponseWriter, r *http.Request, account, container, name string) {
+	var body []byte
+	if r.Method == http.MethodPut {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.containers[account+"/"+container]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPut {
+		s.handlePutObjectLocked(w, r, account, objects, name, body)
+		return
+	}
+	obj, ok := objects[name]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		delete(objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodHead, http.MethodGet:
+		data := s.getDataLocked(account, obj)
+		hash := md5.Sum(data)
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Etag", `"`+hex.EncodeToString(hash[:])+`"`)
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		if obj.manifest != "" {
+			w.Header().Set("X-Object-Manifest", obj.manifest)
+		}
+		for k, v := range obj.metadata {
+			w.Header().Set("X-Object-Meta-"+k, v)
+		}
+		status := http.StatusOK
+		if start, end, ok := parseTestHTTPRange(r.Header.Get("Range"), int64(len(data))); ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data) //nolint:errcheck
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeSwiftServer) handlePutObjectLocked(w http.ResponseWriter, r *http.Request, account string,
+	objects map[string]*fakeSwiftObject, name string, body []byte,
+) {
+	obj := &fakeSwiftObject{
+		data:        body,
+		contentType: r.Header.Get("Content-Type"),
+		manifest:    r.Header.Get("X-Object-Manifest"),
+		metadata:    make(map[string]string),
+		modTime:     time.Now(),
+	}
+	if copyFrom := r.Header.Get("X-Copy-From"); copyFrom != "" {
+		source, err := url.PathUnescape(strings.TrimPrefix(copyFrom, "/"))
+		if err != nil || len(body) > 0 {
+			http.Error(w, "Invalid X-Copy-From header", http.StatusBadRequest)
+			return
+		}
+		srcContainer, srcName, _ := strings.Cut(source, "/")
+		srcObj, ok := s.containers[account+"/"+srcContainer][srcName]
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		// large objects are copied as a single object
+		obj.data = s.getDataLocked(account, srcObj)
+		obj.contentType = srcObj.contentType
+		obj.manifest = ""
+		for k, v := range srcObj.metadata {
+			obj.metadata[k] = v
+		}
+	}
+	if obj.contentType == "" {
+		obj.contentType = "application/octet-stream"
+	}
+	for k, v := range r.Header {
+		if key, ok := strings.CutPrefix(k, "X-Object-Meta-"); ok && len(v) > 0 {
+			obj.metadata[key] = v[0]
+		}
+	}
+	objects[name] = obj
+	hash := md5.Sum(obj.data)
+	w.Header().Set("Etag", hex.EncodeToString(hash[:]))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func newTestSwiftFs(t *testing.T, server *fakeSwiftServer, config SwiftFsConfig) *SwiftFs {
+	config.AuthURL = server.URL + "/v3"
+	if config.ApplicationCredentialID == "" && config.Username == "" {
+		config.Username = fakeSwiftUsername
+		config.Password = kms.NewPlainSecret(fakeSwiftPassword)
+		if config.ProjectID == "" {
+			config.ProjectName = fakeSwiftProjectName
+		}
+	}
+	if config.Container == "" {
+		config.Container = fakeSwiftContainer
+	}
+	fs, err := NewSwiftFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*SwiftFs)
+}
+
+func uploadTestSwiftFile(t *testing.T, fs *SwiftFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestSwiftFile(t *testing.T, fs *SwiftFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestSwiftFsBasicOperations(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{})
+	assert.Equal(t, 1, server.getOpCount("auth"))
+
+	require.NoError(t, fs.Mkdir("dir"))
+	obj, ok := server.getObject(fakeSwiftContainer, "dir/")
+	require.True(t, ok)
+	assert.Equal(t, swiftDirContentType, obj.contentType)
+	require.NoError(t, uploadTestSwiftFile(t, fs, "dir/file.txt", []byte("content")))
+	obj, ok = server.getObject(fakeSwiftContainer, "dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+	assert.Contains(t, obj.contentType, "text/plain")
+	data, err := downloadTestSwiftFile(t, fs, "dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestSwiftFile(t, fs, "dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+	// the metadata are read before downloading, if required
+	server.update(func(s *fakeSwiftServer) {
+		s.containers[fakeSwiftAccount+"/"+fakeSwiftContainer]["dir/file.txt"].metadata["Key"] = "value"
+	})
+	SetReadMetadataMode(1)
+	t.Cleanup(func() {
+		SetReadMetadataMode(0)
+	})
+	_, r, _, err := fs.Open("dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": "value"}, r.Metadata())
+	require.NoError(t, r.Close())
+	SetReadMetadataMode(0)
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Lstat("dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "file.txt", info.Name())
+	assert.Equal(t, int64(7), info.Size())
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	hash := md5.Sum([]byte("content"))
+	assert.Equal(t, `"`+hex.EncodeToString(hash[:])+`"`, GetETag(info))
+	// directories without a marker are emulated
+	server.putObject("virtual/sub/file", []byte("data"))
+	info, err = fs.Stat("virtual/sub")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	server.putObject("dir/sub/file", []byte("data"))
+	entries := listTestDir(t, fs, "dir")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.False(t, entries[0].IsDir())
+		assert.Equal(t, int64(7), entries[0].Size())
+		assert.Equal(t, `"`+hex.EncodeToString(hash[:])+`"`, GetETag(entries[0]))
+		assert.Equal(t, "sub", entries[1].Name())
+		assert.True(t, entries[1].IsDir())
+	}
+	mimeType, err := fs.GetMimeType("dir/file.txt")
+	require.NoError(t, err)
+	assert.Contains(t, mimeType, "text/plain")
+
+	numFiles, sizeDiff, err := fs.CopyFile("dir/file.txt", "dir/file1.txt", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), sizeDiff)
+	obj, ok = server.getObject(fakeSwiftContainer, "dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+	assert.Contains(t, obj.contentType, "text/plain")
+	// copying over an existing file replaces it
+	server.putObject("dir/file2.txt", []byte("file2"))
+	numFiles, sizeDiff, err = fs.CopyFile("dir/file.txt", "dir/file2.txt", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(2), sizeDiff)
+	obj, ok = server.getObject(fakeSwiftContainer, "dir/file2.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+
+	numFiles, size, err := fs.Rename("dir/file1.txt", "dir/file3.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), size)
+	_, ok = server.getObject(fakeSwiftContainer, "dir/file1.txt")
+	assert.False(t, ok)
+	obj, ok = server.getObject(fakeSwiftContainer, "dir/file3.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+	_, _, err = fs.Rename("dir/file3.txt", "dir/file3.txt")
+	assert.NoError(t, err)
+	// empty directories are renamed
+	require.NoError(t, fs.Mkdir("dir1"))
+	_, _, err = fs.Rename("dir1", "dir2")
+	require.NoError(t, err)
+	_, ok = server.getObject(fakeSwiftContainer, "dir1/")
+	assert.False(t, ok)
+	obj, ok = server.getObject(fakeSwiftContainer, "dir2/")
+	require.True(t, ok)
+	assert.Equal(t, swiftDirContentType, obj.contentType)
+	// non empty directories are renamed only if enabled
+	_, _, err = fs.Rename("dir", "dir3")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	assert.True(t, fs.IsNotSupported(err))
+	SetRenameMode(1)
+	t.Cleanup(func() {
+		SetRenameMode(0)
+	})
+	numFiles, size, err = fs.Rename("dir", "dir3")
+	require.NoError(t, err)
+	assert.Equal(t, 4, numFiles)
+	assert.Equal(t, int64(25), size)
+	SetRenameMode(0)
+	assert.Equal(t, []string{"dir2/", "dir3/", "dir3/file.txt", "dir3/file2.txt", "dir3/file3.txt",
+		"dir3/sub/", "dir3/sub/file", "virtual/sub/file"}, server.getObjectNames(fakeSwiftContainer))
+
+	numFiles, size, err = fs.GetDirSize("dir3")
+	require.NoError(t, err)
+	assert.Equal(t, 4, numFiles)
+	assert.Equal(t, int64(25), size)
+	numFiles, size, err = fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 5, numFiles)
+	assert.Equal(t, int64(29), size)
+	var walked []string
+	err = fs.Walk("dir3", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir3/file.txt", "dir3/file2.txt", "dir3/file3.txt", "dir3/sub", "dir3/sub/file", "dir3"},
+		walked)
+
+	err = fs.Remove("dir3/sub", true)
+	assert.ErrorContains(t, err, "non empty")
+	require.NoError(t, fs.Remove("dir3/sub/file", false))
+	require.NoError(t, fs.Remove("dir3/sub", true))
+	_, err = fs.Stat("dir3/sub")
+	assert.True(t, fs.IsNotExist(err))
+
+	assert.ErrorIs(t, fs.Symlink("dir3/file.txt", "link"), ErrVfsUnsupported)
+	_, err = fs.Readlink("link")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	assert.ErrorIs(t, fs.Chown("dir3/file.txt", 1000, 1000), ErrVfsUnsupported)
+	assert.ErrorIs(t, fs.Chmod("dir3/file.txt", 0600), ErrVfsUnsupported)
+	assert.ErrorIs(t, fs.Truncate("dir3/file.txt", 0), ErrVfsUnsupported)
+	assert.ErrorIs(t, fs.Chtimes("dir3/file.txt", time.Now(), time.Now(), false), ErrVfsUnsupported)
+	assert.NoError(t, fs.Chtimes("dir3/file.txt", time.Now(), time.Now(), true))
+	_, _, _, err = fs.Create("dir3/file.txt", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	// the container quota is optional
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.ErrorIs(t, err, ErrStorageSizeUnavailable)
+	server.update(func(s *fakeSwiftServer) {
+		s.quota = 4096*10 + 29
+	})
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), statVFS.Blocks)
+	assert.Equal(t, uint64(10), statVFS.Bfree)
+	assert.Equal(t, uint64(swiftStatVFSMaxNameSize), statVFS.Namemax)
+	server.update(func(s *fakeSwiftServer) {
+		s.quota = 10
+	})
+	statVFS, err = fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), statVFS.Bfree)
+	// all the requests used the first token
+	assert.Equal(t, 1, server.getOpCount("auth"))
+}
+
+func TestSwiftFsReadDirPagination(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{})
+
+	numFiles := defaultSwiftPageSize + 5
+	var expected []string
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("file%04d", i)
+		server.putObject(path.Join("dir", name), []byte(name))
+		expected = append(expected, name)
+	}
+	// names are escaped in the requests
+	require.NoError(t, fs.Mkdir("dir"))
+	server.putObject("dir/sub dir %/file #1?", []byte("data"))
+	server.putObject("dir/sub dir %/nested/file", []byte("data"))
+	expected = append(expected, "sub dir %")
+
+	listOps := server.getOpCount("GET container")
+	lister, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	_, err = lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidDirListerLimit)
+	var names []string
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, expected, names)
+	assert.Equal(t, listOps+2, server.getOpCount("GET container"))
+
+	entries := listTestDir(t, fs, "dir/sub dir %")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "file #1?", entries[0].Name())
+		assert.Equal(t, int64(4), entries[0].Size())
+		assert.Equal(t, "nested", entries[1].Name())
+		assert.True(t, entries[1].IsDir())
+	}
+	data, err := downloadTestSwiftFile(t, fs, "dir/sub dir %/file #1?", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	require.NoError(t, uploadTestSwiftFile(t, fs, "dir/sub dir %/file #2?", []byte("data2")))
+	obj, ok := server.getObject(fakeSwiftContainer, "dir/sub dir %/file #2?")
+	require.True(t, ok)
+	assert.Equal(t, "data2", string(obj.data))
+
+	listOps = server.getOpCount("GET container")
+	dirFiles, size, err := fs.GetDirSize("dir")
+	require.NoError(t, err)
+	assert.Equal(t, numFiles+3, dirFiles)
+	assert.Equal(t, int64(8*numFiles+13), size)
+	assert.Equal(t, listOps+2, server.getOpCount("GET container"))
+	var walked int
+	err = fs.Walk("dir", func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			walked++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, numFiles+3, walked)
+}
+
+func TestSwiftFsLargeObjects(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{
+		UploadPartSize: 1,
+	})
+	segmentsContainer := fakeSwiftContainer + swiftSegmentsSuffix
+
+	// objects with the same size of a part are uploaded as a single object
+	data := make([]byte, 1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, uploadTestSwiftFile(t, fs, "small", data))
+	obj, ok := server.getObject(fakeSwiftContainer, "small")
+	require.True(t, ok)
+	assert.Empty(t, obj.manifest)
+	assert.Equal(t, data, obj.data)
+	assert.Equal(t, 0, server.getOpCount("PUT container"))
+
+	data = make([]byte, 2*1024*1024+512)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, uploadTestSwiftFile(t, fs, "dir/large", data))
+	assert.Equal(t, 1, server.getOpCount("PUT container"))
+	obj, ok = server.getObject(fakeSwiftContainer, "dir/large")
+	require.True(t, ok)
+	assert.NotEmpty(t, obj.manifest)
+	assert.Empty(t, obj.data)
+	segments := server.getObjectNames(segmentsContainer)
+	require.Len(t, segments, 3)
+	for _, segment := range segments {
+		assert.True(t, strings.HasPrefix(segment, "dir/large/sftpgo-"), segment)
+	}
+	info, err := fs.Stat("dir/large")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+	// manifests are listed with a zero size
+	entries := listTestDir(t, fs, "dir")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, int64(len(data)), entries[0].Size())
+	}
+	numFiles, size, err := fs.GetDirSize("dir")
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(len(data)), size)
+	downloaded, err := downloadTestSwiftFile(t, fs, "dir/large", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	downloaded, err = downloadTestSwiftFile(t, fs, "dir/large", 1024*1024+1)
+	require.NoError(t, err)
+	assert.Equal(t, data[1024*1024+1:], downloaded)
+	// copies get their own segments
+	_, _, err = fs.CopyFile("dir/large", "dir/copy", int64(len(data)))
+	require.NoError(t, err)
+	copyObj, ok := server.getObject(fakeSwiftContainer, "dir/copy")
+	require.True(t, ok)
+	assert.NotEqual(t, obj.manifest, copyObj.manifest)
+	assert.Len(t, server.getObjectNames(segmentsContainer), 6)
+	downloaded, err = downloadTestSwiftFile(t, fs, "dir/copy", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	// renames copy the manifest only
+	_, _, err = fs.Rename("dir/large", "renamed")
+	require.NoError(t, err)
+	renamedObj, ok := server.getObject(fakeSwiftContainer, "renamed")
+	require.True(t, ok)
+	assert.Equal(t, obj.manifest, renamedObj.manifest)
+	_, ok = server.getObject(fakeSwiftContainer, "dir/large")
+	assert.False(t, ok)
+	assert.Len(t, server.getObjectNames(segmentsContainer), 6)
+	downloaded, err = downloadTestSwiftFile(t, fs, "renamed", 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	// the replaced segments are removed
+	require.NoError(t, uploadTestSwiftFile(t, fs, "dir/copy", []byte("small")))
+	assert.Equal(t, segments, server.getObjectNames(segmentsContainer))
+	_, _, err = fs.CopyFile("small", "renamed", 1024*1024)
+	require.NoError(t, err)
+	assert.Len(t, server.getObjectNames(segmentsContainer), 0)
+	require.NoError(t, uploadTestSwiftFile(t, fs, "large", data))
+	assert.Len(t, server.getObjectNames(segmentsContainer), 3)
+	require.NoError(t, fs.Remove("large", false))
+	assert.Len(t, server.getObjectNames(segmentsContainer), 0)
+	// the uploaded segments are removed if the upload fails
+	server.setOnRequest(func(op string, r *http.Request) int {
+		if op == "PUT object" && strings.HasSuffix(r.URL.Path, "00000002") {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+	err = uploadTestSwiftFile(t, fs, "failed", data)
+	assert.Error(t, err)
+	assert.Len(t, server.getObjectNames(segmentsContainer), 0)
+	server.setOnRequest(func(op string, r *http.Request) int {
+		if op == "PUT object" && r.Header.Get("X-Object-Manifest") != "" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+	err = uploadTestSwiftFile(t, fs, "failed", data)
+	assert.Error(t, err)
+	assert.Len(t, server.getObjectNames(segmentsContainer), 0)
+	_, ok = server.getObject(fakeSwiftContainer, "failed")
+	assert.False(t, ok)
+	server.setOnRequest(func(op string, r *http.Request) int {
+		if op == "PUT container" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	err = uploadTestSwiftFile(t, fs, "failed", data)
+	assert.ErrorContains(t, err, "unable to create segments container")
+	assert.True(t, fs.IsPermission(err))
+}
+
+func TestSwiftFsAuth(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	server.putObject("file", []byte("data"))
+
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{
+		ApplicationCredentialID:     fakeSwiftAppCredID,
+		ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret),
+	})
+	info, err := fs.Stat("file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{
+		ProjectID: fakeSwiftProjectID,
+	})
+	_, err = fs.Stat("file")
+	require.NoError(t, err)
+	// the endpoint is selected based on the region and on the interface
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{
+		Region: "region-two",
+	})
+	_, err = fs.Stat("file")
+	assert.True(t, fs.IsNotExist(err))
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{
+		Region: "RegionOne",
+	})
+	_, err = fs.Stat("file")
+	require.NoError(t, err)
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{
+		EndpointType: "internal",
+	})
+	assert.Equal(t, server.URL+"/v1/AUTH_internal", fs.storageURL)
+	assert.True(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+	_, err = fs.Stat("file")
+	assert.True(t, fs.IsNotExist(err))
+	// a container per user is created
+	config := SwiftFsConfig{
+		Container:        fakeSwiftContainer,
+		ContainerPerUser: true,
+	}
+	fs = newTestSwiftFs(t, server, config.ForOwner("user1"))
+	assert.Equal(t, 0, server.getOpCount("PUT container"))
+	assert.True(t, fs.CheckRootPath("user1", os.Getuid(), os.Getgid()))
+	assert.Equal(t, 1, server.getOpCount("PUT container"))
+	require.NoError(t, uploadTestSwiftFile(t, fs, "file", []byte("user1")))
+	assert.Equal(t, []string{"file"}, server.getObjectNames(fakeSwiftContainer+"-user1"))
+	// the existing container is reused
+	assert.True(t, fs.CheckRootPath("user1", os.Getuid(), os.Getgid()))
+	assert.Equal(t, 2, server.getOpCount("PUT container"))
+	assert.Equal(t, []string{"file"}, server.getObjectNames(fakeSwiftContainer+"-user1"))
+
+	// a new token is requested if the current one is rejected
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{})
+	authOps := server.getOpCount("auth")
+	server.revokeTokens()
+	_, err = fs.Stat("file")
+	require.NoError(t, err)
+	assert.Equal(t, authOps+1, server.getOpCount("auth"))
+	// requests with a body cannot be retried
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "PUT object" {
+			return http.StatusUnauthorized
+		}
+		return 0
+	})
+	err = uploadTestSwiftFile(t, fs, "file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	assert.Equal(t, authOps+1, server.getOpCount("auth"))
+	server.setOnRequest(nil)
+	// expiring tokens are renewed
+	server.update(func(s *fakeSwiftServer) {
+		s.tokenTTL = time.Minute
+	})
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{})
+	authOps = server.getOpCount("auth")
+	_, err = fs.Stat("file")
+	require.NoError(t, err)
+	_, err = fs.Stat("file")
+	require.NoError(t, err)
+	assert.Equal(t, authOps+2, server.getOpCount("auth"))
+
+	for _, config := range []SwiftFsConfig{
+		{
+			Username:    fakeSwiftUsername,
+			Password:    kms.NewPlainSecret("wrong"),
+			ProjectName: fakeSwiftProjectName,
+		},
+		{
+			Username:    fakeSwiftUsername,
+			Password:    kms.NewPlainSecret(fakeSwiftPassword),
+			ProjectName: fakeSwiftProjectName,
+			UserDomain:  "other",
+		},
+		{
+			ApplicationCredentialID:     fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret("wrong"),
+		},
+	} {
+		config.AuthURL = server.URL + "/v3"
+		config.Container = fakeSwiftContainer
+		fs, err := NewSwiftFs("connID", t.TempDir(), "", config)
+		assert.True(t, fs.IsPermission(err), "unexpected error: %v", err)
+	}
+	for _, region := range []string{"RegionThree", "identity"} {
+		_, err = NewSwiftFs("connID", t.TempDir(), "", SwiftFsConfig{
+			AuthURL:     server.URL + "/v3",
+			Username:    fakeSwiftUsername,
+			Password:    kms.NewPlainSecret(fakeSwiftPassword),
+			ProjectName: fakeSwiftProjectName,
+			Container:   fakeSwiftContainer,
+			Region:      region,
+		})
+		assert.ErrorContains(t, err, "no public object-store endpoint found")
+	}
+	for _, config := range []SwiftFsConfig{
+		{},
+		{AuthURL: "ftp://localhost/v3"},
+		{AuthURL: server.URL + "/v3"},
+		{AuthURL: server.URL + "/v3", Username: fakeSwiftUsername},
+		{AuthURL: server.URL + "/v3", Username: fakeSwiftUsername, Password: kms.NewPlainSecret(fakeSwiftPassword)},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret), EndpointType: "private"},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret)},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret), Container: "a/b"},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret), Container: fakeSwiftContainer,
+			KeyPrefix: "/prefix/"},
+		{AuthURL: server.URL + "/v3", ApplicationCredentialID: fakeSwiftAppCredID,
+			ApplicationCredentialSecret: kms.NewPlainSecret(fakeSwiftAppCredSecret), Container: fakeSwiftContainer,
+			UploadPartSize: 5121},
+	} {
+		_, err := NewSwiftFs("connID", t.TempDir(), "", config)
+		assert.Error(t, err, "config %+v", config)
+	}
+}
+
+func TestSwiftFsKeyPrefix(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{
+		KeyPrefix: "users/test",
+	})
+	assert.Equal(t, "users/test/", fs.config.KeyPrefix)
+	assert.Contains(t, fs.Name(), fakeSwiftContainer)
+
+	info, err := fs.Stat("users/test")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	resolved, err := fs.ResolvePath("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "users/test/dir/file", resolved)
+	resolved, err = fs.ResolvePath("dir/../../file")
+	require.NoError(t, err)
+	assert.Equal(t, "users/test/file", resolved)
+	assert.Equal(t, "/dir/file", fs.GetRelativePath("users/test/dir/file"))
+	assert.Equal(t, "/", fs.GetRelativePath("users/other/file"))
+	assert.Equal(t, "/", fs.GetRelativePath(""))
+	assert.Empty(t, fs.GetAtomicUploadPath("users/test/file"))
+
+	require.NoError(t, uploadTestSwiftFile(t, fs, "users/test/file", []byte("data")))
+	server.putObject("users/file", []byte("outside"))
+	numFiles, size, err := fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(4), size)
+	entries := listTestDir(t, fs, "users/test")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file", entries[0].Name())
+	}
+
+	mountedFs, err := NewSwiftFs("connID", t.TempDir(), "/mount", SwiftFsConfig{
+		AuthURL:     server.URL + "/v3",
+		Username:    fakeSwiftUsername,
+		Password:    kms.NewPlainSecret(fakeSwiftPassword),
+		ProjectName: fakeSwiftProjectName,
+		Container:   fakeSwiftContainer,
+		KeyPrefix:   "users/test/",
+	})
+	require.NoError(t, err)
+	defer mountedFs.Close()
+
+	assert.Equal(t, "/mount/file", mountedFs.GetRelativePath("users/test/file"))
+	resolved, err = mountedFs.ResolvePath("/mount/file")
+	require.NoError(t, err)
+	assert.Equal(t, "users/test/file", resolved)
+}
+
+func TestSwiftFsErrors(t *testing.T) {
+	server := newFakeSwiftServer(t)
+	fs := newTestSwiftFs(t, server, SwiftFsConfig{})
+
+	_, err := fs.Stat("missing")
+	assert.True(t, fs.IsNotExist(err))
+	assert.Len(t, listTestDir(t, fs, "missing"), 0)
+	_, _, err = fs.Rename("missing", "target")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("missing", "missing/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("missing", true)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.CopyFile("missing", "target", 10)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.GetMimeType("missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = downloadTestSwiftFile(t, fs, "missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	SetReadMetadataMode(1)
+	_, _, _, err = fs.Open("missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	SetReadMetadataMode(0)
+	server.putObject("file", []byte("data"))
+	// the target parent must exist
+	_, _, err = fs.Rename("file", "missing/file")
+	assert.True(t, fs.IsNotExist(err))
+	_, ok := server.getObject(fakeSwiftContainer, "file")
+	assert.True(t, ok)
+	require.NoError(t, fs.Mkdir("dir"))
+
+	server.setOnRequest(func(_ string, _ *http.Request) int {
+		return http.StatusForbidden
+	})
+	_, err = fs.Stat("file1")
+	assert.True(t, fs.IsPermission(err))
+	lister, err := fs.ReadDir("/")
+	require.NoError(t, err)
+	_, err = lister.Next(10)
+	assert.True(t, fs.IsPermission(err))
+	require.NoError(t, lister.Close())
+	_, _, err = fs.Rename("file", "file1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("file", false)
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("dir", true)
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Mkdir("dir1")
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestSwiftFile(t, fs, "file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestSwiftFile(t, fs, "file", 0)
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.CopyFile("file", "file1", 4)
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.GetDirSize("dir")
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.True(t, fs.IsPermission(err))
+	assert.False(t, fs.IsNotExist(err))
+	assert.False(t, fs.IsNotSupported(err))
+	var walkErr error
+	err = fs.Walk("dir", func(_ string, _ os.FileInfo, err error) error {
+		walkErr = err
+		return nil
+	})
+	assert.True(t, fs.IsPermission(err))
+	assert.True(t, fs.IsPermission(walkErr))
+	// the container per user cannot be created
+	fs = newTestSwiftFs(t, server, SwiftFsConfig{
+		ContainerPerUser: true,
+	})
+	putContainerOps := server.getOpCount("PUT container")
+	assert.True(t, fs.CheckRootPath("user", os.Getuid(), os.Getgid()))
+	assert.Equal(t, putContainerOps+1, server.getOpCount("PUT container"))
+	// the listing fails while checking for a virtual directory
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "GET container" {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+	_, err = fs.Stat("missing")
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+	server.setOnRequest(nil)
+	// the server is not reachable
+	server.Close()
+	_, err = fs.Stat("file1")
+	assert.ErrorContains(t, err, "unable to send Swift request")
+	assert.False(t, fs.IsNotExist(err))
+	fs.mu.Lock()
+	fs.token = ""
+	fs.mu.Unlock()
+	_, err = fs.Stat("file1")
+	assert.ErrorContains(t, err, "unable to send Keystone authentication request")
+}
@@ -16,14 +16,19 @@
 package vfs
 
 import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -43,8 +48,20 @@ const (
 	s3fsName          = "S3Fs"
 	gcsfsName         = "GCSFs"
 	azBlobFsName      = "AzureBlobFs"
+	b2fsName          = "B2Fs"
+	dropboxFsName     = "DropboxFs"
+	gdriveFsName      = "GoogleDriveFs"
+	oneDriveFsName    = "OneDriveFs"
+	webDAVFsName      = "WebDAVFs"
+	smbFsName         = "SMBFs"
+	hdfsFsName        = "HDFSFs"
+	swiftFsName       = "SwiftFs"
 	lastModifiedField = "sftpgo_last_modified"
-	preResumeTimeout  = 90 * time.Second
+	// SHA-256 of the object contents, computed at upload time
+	contentHashField = "sftpgo_sha256"
+	// extended attributes are stored as object metadata using this prefix
+	xattrMetadataPrefix = "sftpgo_xattr_"
+	preResumeTimeout    = 90 * time.Second
 	// ListerBatchSize defines the default limit for DirLister implementations
 	ListerBatchSize = 1000
 )
@@ -56,11 +73,21 @@ const (
 )
 
 var (
-	validAzAccessTier = []string{"", "Archive", "Hot", "Cool"}
+	validAzAccessTier      = []string{"", "Archive", "Hot", "Cool"}
+	validAzRehydratePrio   = []string{"", "Standard", "High"}
+	validS3ObjectLockModes = []string{"", "GOVERNANCE", "COMPLIANCE"}
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
-	ErrVfsUnsupported        = errors.New("not supported")
+	ErrVfsUnsupported = errors.New("not supported")
+	// ErrXattrNotFound is returned if the requested extended attribute does not exist
+	ErrXattrNotFound = errors.New("no such attribute")
+	// ErrObjectLocked is returned if an object cannot be deleted or overwritten
+	// because it is under retention or legal hold
+	ErrObjectLocked = errors.New("object locked")
+	// ErrObjectArchived is returned if an object cannot be read because it is
+	// stored in an archive tier, it must be restored before reading it
+	ErrObjectArchived        = errors.New("object archived, it must be restored before reading it")
 	errInvalidDirListerLimit = errors.New("dir lister: invalid limit, must be > 0")
 	tempPath                 string
 	sftpFingerprints         []string
@@ -162,6 +189,34 @@ type FsFileCopier interface {
 	CopyFile(source, target string, srcSize int64) (int, int64, error)
 }
 
+// Archive status for objects stored on cloud backends
+const (
+	// ArchiveStatusNone means the object is not archived and can be read
+	ArchiveStatusNone = iota
+	// ArchiveStatusArchived means the object is archived and must be restored
+	ArchiveStatusArchived
+	// ArchiveStatusRestoring means the object is archived and a restore is in
+	// progress
+	ArchiveStatusRestoring
+)
+
+// FsArchiveRestorer is a Fs that can restore objects stored in an archive
+// tier. Restoring is asynchronous and may take several hours
+type FsArchiveRestorer interface {
+	Fs
+	RestoreArchived(name string) error
+	GetArchiveStatus(name string) (int, error)
+}
+
+// FsXattrer is a Fs that supports extended attributes.
+type FsXattrer interface {
+	Fs
+	GetXattr(name, attr string) ([]byte, error)
+	ListXattr(name string) ([]string, error)
+	SetXattr(name, attr string, value []byte) error
+	RemoveXattr(name, attr string) error
+}
+
 // File defines an interface representing a SFTPGo file
 type File interface {
 	io.Reader
@@ -266,6 +321,33 @@ func (q *QuotaCheckResult) GetRemainingFiles() int {
 type S3FsConfig struct {
 	sdk.BaseS3FsConfig
 	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
+	// Object lock retention mode to apply to uploaded files: GOVERNANCE or
+	// COMPLIANCE. Empty means no retention. The bucket must have object lock enabled
+	ObjectLockMode string `json:"object_lock_mode,omitempty"`
+	// Retention period, in days, for uploaded files. Required if a retention mode is set
+	ObjectLockRetentionDays int `json:"object_lock_retention_days,omitempty"`
+	// If enabled a legal hold is placed on uploaded files
+	ObjectLockLegalHold bool `json:"object_lock_legal_hold,omitempty"`
+	// If enabled the role is assumed using AssumeRoleWithWebIdentity and the
+	// OpenID Connect ID token obtained when the user logged in, so each user
+	// gets short-lived credentials scoped to its identity
+	AssumeRoleWithWebIdentity bool `json:"assume_role_with_web_identity,omitempty"`
+	// KMS key ID, ARN or alias used to encrypt new objects using SSE-KMS.
+	// Empty means the bucket default encryption
+	SSEKMSKeyID string `json:"sse_kms_key_id,omitempty"`
+	// Customer provided 256-bit key, base64 encoded, used to encrypt objects
+	// using SSE-C. The same key is required to read the objects
+	SSECustomerKey *kms.Secret `json:"sse_customer_key,omitempty"`
+	// the user whose identity token is used, it is not persisted
+	webIdentityUser string
+}
+
+// ForUser returns the configuration to use for the specified user. The user's
+// identity is required to assume the role with web identity
+func (c *S3FsConfig) ForUser(username string) S3FsConfig {
+	config := *c
+	config.webIdentityUser = username
+	return config
 }
 
 // HideConfidentialData hides confidential data
@@ -273,6 +355,9 @@ func (c *S3FsConfig) HideConfidentialData() {
 	if c.AccessSecret != nil {
 		c.AccessSecret.Hide()
 	}
+	if c.SSECustomerKey != nil {
+		c.SSECustomerKey.Hide()
+	}
 }
 
 func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
@@ -291,6 +376,9 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.RoleARN != other.RoleARN {
 		return false
 	}
+	if c.AssumeRoleWithWebIdentity != other.AssumeRoleWithWebIdentity {
+		return false
+	}
 	if c.Endpoint != other.Endpoint {
 		return false
 	}
@@ -309,9 +397,75 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.SkipTLSVerify != other.SkipTLSVerify {
 		return false
 	}
+	if c.SSEKMSKeyID != other.SSEKMSKeyID {
+		return false
+	}
+	if !c.areObjectLockFieldsEqual(other) {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
+func (c *S3FsConfig) areObjectLockFieldsEqual(other S3FsConfig) bool {
+	if c.ObjectLockMode != other.ObjectLockMode {
+		return false
+	}
+	if c.ObjectLockRetentionDays != other.ObjectLockRetentionDays {
+		return false
+	}
+	return c.ObjectLockLegalHold == other.ObjectLockLegalHold
+}
+
+func (c *S3FsConfig) checkEncryption() error {
+	if c.SSECustomerKey == nil {
+		c.SSECustomerKey = kms.NewEmptySecret()
+	}
+	c.SSEKMSKeyID = strings.TrimSpace(c.SSEKMSKeyID)
+	if c.SSECustomerKey.IsEmpty() {
+		return nil
+	}
+	if c.SSEKMSKeyID != "" {
+		return errors.New("sse_kms_key_id and sse_customer_key cannot be used together")
+	}
+	if c.SSECustomerKey.IsEncrypted() && !c.SSECustomerKey.IsValid() {
+		return errors.New("invalid encrypted sse_customer_key")
+	}
+	if !c.SSECustomerKey.IsValidInput() {
+		return errors.New("invalid sse_customer_key")
+	}
+	if c.SSECustomerKey.IsPlain() {
+		key, err := base64.StdEncoding.DecodeString(c.SSECustomerKey.GetPayload())
+		if err != nil || len(key) != 32 {
+			return errors.New("sse_customer_key must be a base64 encoded 256-bit key")
+		}
+	}
+	return nil
+}
+
+// isObjectLockEnabled returns true if uploaded files are protected by a
+// retention period or a legal hold. In this case deleting or overwriting
+// locked objects is denied before sending the request to the bucket
+func (c *S3FsConfig) isObjectLockEnabled() bool {
+	return c.ObjectLockMode != "" || c.ObjectLockLegalHold
+}
+
+func (c *S3FsConfig) checkObjectLock() error {
+	c.ObjectLockMode = strings.ToUpper(strings.TrimSpace(c.ObjectLockMode))
+	if !util.Contains(validS3ObjectLockModes, c.ObjectLockMode) {
+		return fmt.Errorf("invalid object_lock_mode %q", c.ObjectLockMode)
+	}
+	if c.ObjectLockMode == "" {
+		if c.ObjectLockRetentionDays != 0 {
+			return errors.New("object_lock_retention_days requires an object_lock_mode")
+		}
+		return nil
+	}
+	if c.ObjectLockRetentionDays <= 0 || c.ObjectLockRetentionDays > 36500 {
+		return errors.New("object_lock_retention_days must be greater than 0 and lower than or equal to 36500")
+	}
+	return nil
+}
+
 func (c *S3FsConfig) areMultipartFieldsEqual(other S3FsConfig) bool {
 	if c.UploadPartSize != other.UploadPartSize {
 		return false
@@ -341,6 +495,15 @@ func (c *S3FsConfig) isSecretEqual(other S3FsConfig) bool {
 	if other.AccessSecret == nil {
 		other.AccessSecret = kms.NewEmptySecret()
 	}
+	if c.SSECustomerKey == nil {
+		c.SSECustomerKey = kms.NewEmptySecret()
+	}
+	if other.SSECustomerKey == nil {
+		other.SSECustomerKey = kms.NewEmptySecret()
+	}
+	if !c.SSECustomerKey.IsEqual(other.SSECustomerKey) {
+		return false
+	}
 	return c.AccessSecret.IsEqual(other.AccessSecret)
 }
 
@@ -363,6 +526,14 @@ func (c *S3FsConfig) checkCredentials() error {
 	if !c.AccessSecret.IsEmpty() && !c.AccessSecret.IsValidInput() {
 		return errors.New("invalid access_secret")
 	}
+	if c.AssumeRoleWithWebIdentity {
+		if c.RoleARN == "" {
+			return errors.New("role_arn is required to assume a role with web identity")
+		}
+		if c.AccessKey != "" {
+			return errors.New("access_key and access_secret cannot be used to assume a role with web identity")
+		}
+	}
 	return nil
 }
 
@@ -386,6 +557,16 @@ func (c *S3FsConfig) ValidateAndEncryptCredentials(additionalData string) error
 			)
 		}
 	}
+	if c.SSECustomerKey.IsPlain() {
+		c.SSECustomerKey.SetAdditionalData(additionalData)
+		err := c.SSECustomerKey.Encrypt()
+		if err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt s3 SSE customer key: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
 	return nil
 }
 
@@ -440,52 +621,1550 @@ func (c *S3FsConfig) validate() error {
 	if c.Endpoint == "" && c.Region == "" {
 		return util.NewI18nError(errors.New("region cannot be empty"), util.I18nErrorRegionRequired)
 	}
-	if err := c.checkCredentials(); err != nil {
-		return err
+	if err := c.checkCredentials(); err != nil {
+		return err
+	}
+	if c.KeyPrefix != "" {
+		if strings.HasPrefix(c.KeyPrefix, "/") {
+			return util.NewI18nError(errors.New("key_prefix cannot start with /"), util.I18nErrorKeyPrefixInvalid)
+		}
+		c.KeyPrefix = path.Clean(c.KeyPrefix)
+		if !strings.HasSuffix(c.KeyPrefix, "/") {
+			c.KeyPrefix += "/"
+		}
+	}
+	c.StorageClass = strings.TrimSpace(c.StorageClass)
+	c.ACL = strings.TrimSpace(c.ACL)
+	if err := c.checkObjectLock(); err != nil {
+		return err
+	}
+	if err := c.checkEncryption(); err != nil {
+		return err
+	}
+	return c.checkPartSizeAndConcurrency()
+}
+
+// GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
+type GCSFsConfig struct {
+	sdk.BaseGCSFsConfig
+	Credentials *kms.Secret `json:"credentials,omitempty"`
+	// Cloud KMS key used to encrypt new objects, for example
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+	// Empty means the bucket default encryption
+	KMSKeyName string `json:"kms_key_name,omitempty"`
+	// Customer supplied 256-bit key, base64 encoded, used to encrypt objects.
+	// The same key is required to read the objects
+	EncryptionKey *kms.Secret `json:"encryption_key,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *GCSFsConfig) HideConfidentialData() {
+	if c.Credentials != nil {
+		c.Credentials.Hide()
+	}
+	if c.EncryptionKey != nil {
+		c.EncryptionKey.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts credentials if they are in plain text
+func (c *GCSFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate GCS config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.Credentials.IsPlain() {
+		c.Credentials.SetAdditionalData(additionalData)
+		err := c.Credentials.Encrypt()
+		if err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt GCS credentials: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.EncryptionKey.IsPlain() {
+		c.EncryptionKey.SetAdditionalData(additionalData)
+		err := c.EncryptionKey.Encrypt()
+		if err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt GCS encryption key: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
+	if c.Bucket != other.Bucket {
+		return false
+	}
+	if c.KeyPrefix != other.KeyPrefix {
+		return false
+	}
+	if c.AutomaticCredentials != other.AutomaticCredentials {
+		return false
+	}
+	if c.StorageClass != other.StorageClass {
+		return false
+	}
+	if c.ACL != other.ACL {
+		return false
+	}
+	if c.UploadPartSize != other.UploadPartSize {
+		return false
+	}
+	if c.UploadPartMaxTime != other.UploadPartMaxTime {
+		return false
+	}
+	if c.KMSKeyName != other.KMSKeyName {
+		return false
+	}
+	if c.Credentials == nil {
+		c.Credentials = kms.NewEmptySecret()
+	}
+	if other.Credentials == nil {
+		other.Credentials = kms.NewEmptySecret()
+	}
+	if c.EncryptionKey == nil {
+		c.EncryptionKey = kms.NewEmptySecret()
+	}
+	if other.EncryptionKey == nil {
+		other.EncryptionKey = kms.NewEmptySecret()
+	}
+	if !c.EncryptionKey.IsEqual(other.EncryptionKey) {
+		return false
+	}
+	return c.Credentials.IsEqual(other.Credentials)
+}
+
+func (c *GCSFsConfig) checkEncryption() error {
+	if c.EncryptionKey == nil {
+		c.EncryptionKey = kms.NewEmptySecret()
+	}
+	c.KMSKeyName = strings.TrimSpace(c.KMSKeyName)
+	if c.EncryptionKey.IsEmpty() {
+		return nil
+	}
+	if c.KMSKeyName != "" {
+		return errors.New("kms_key_name and encryption_key cannot be used together")
+	}
+	if c.EncryptionKey.IsEncrypted() && !c.EncryptionKey.IsValid() {
+		return errors.New("invalid encrypted encryption_key")
+	}
+	if !c.EncryptionKey.IsValidInput() {
+		return errors.New("invalid encryption_key")
+	}
+	if c.EncryptionKey.IsPlain() {
+		key, err := base64.StdEncoding.DecodeString(c.EncryptionKey.GetPayload())
+		if err != nil || len(key) != 32 {
+			return errors.New("encryption_key must be a base64 encoded 256-bit key")
+		}
+	}
+	return nil
+}
+
+func (c *GCSFsConfig) isSameResource(other GCSFsConfig) bool {
+	return c.Bucket == other.Bucket
+}
+
+// validate returns an error if the configuration is not valid
+func (c *GCSFsConfig) validate() error {
+	if c.Credentials == nil || c.AutomaticCredentials == 1 {
+		c.Credentials = kms.NewEmptySecret()
+	}
+	if c.Bucket == "" {
+		return util.NewI18nError(errors.New("bucket cannot be empty"), util.I18nErrorBucketRequired)
+	}
+	if c.KeyPrefix != "" {
+		if strings.HasPrefix(c.KeyPrefix, "/") {
+			return util.NewI18nError(errors.New("key_prefix cannot start with /"), util.I18nErrorKeyPrefixInvalid)
+		}
+		c.KeyPrefix = path.Clean(c.KeyPrefix)
+		if !strings.HasSuffix(c.KeyPrefix, "/") {
+			c.KeyPrefix += "/"
+		}
+	}
+	if c.Credentials.IsEncrypted() && !c.Credentials.IsValid() {
+		return errors.New("invalid encrypted credentials")
+	}
+	if c.AutomaticCredentials == 0 && !c.Credentials.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid credentials"), util.I18nErrorFsCredentialsRequired)
+	}
+	c.StorageClass = strings.TrimSpace(c.StorageClass)
+	c.ACL = strings.TrimSpace(c.ACL)
+	if c.UploadPartSize < 0 {
+		c.UploadPartSize = 0
+	}
+	if c.UploadPartMaxTime < 0 {
+		c.UploadPartMaxTime = 0
+	}
+	return c.checkEncryption()
+}
+
+// B2FsConfig defines the configuration for Backblaze B2 based filesystem.
+// The native B2 API is used, an application key restricted to the bucket
+// is recommended
+type B2FsConfig struct {
+	Bucket string `json:"bucket,omitempty"`
+	// KeyPrefix is similar to a chroot directory for local filesystem.
+	// If specified then the SFTP user will only see objects that starts
+	// with this prefix and so you can restrict access to a specific
+	// folder. The prefix, if not empty, must not start with "/" and must
+	// end with "/".
+	// If empty the whole bucket contents will be available
+	KeyPrefix string `json:"key_prefix,omitempty"`
+	// Application key ID
+	KeyID string `json:"key_id,omitempty"`
+	// The application key is stored encrypted based on the kms configuration
+	ApplicationKey *kms.Secret `json:"application_key,omitempty"`
+	// The size of a part, in MB, for large file uploads. Files bigger than
+	// this size are uploaded using the large file API. Zero means the default
+	// (16 MB), minimum is 5 MB
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+	// How many parts are uploaded in parallel. Zero means the default (2)
+	UploadConcurrency int `json:"upload_concurrency,omitempty"`
+	// The size of a part, in MB, for downloads. Zero means the default (10 MB)
+	DownloadPartSize int64 `json:"download_part_size,omitempty"`
+	// How many parts are downloaded in parallel. Zero means the default (1)
+	DownloadConcurrency int `json:"download_concurrency,omitempty"`
+	// If enabled, removed files are hidden instead of deleted so the previous
+	// versions are kept until the bucket lifecycle rules expire them.
+	// If disabled, all the versions for the removed files are deleted
+	HideOnDelete bool `json:"hide_on_delete,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *B2FsConfig) HideConfidentialData() {
+	if c.ApplicationKey != nil {
+		c.ApplicationKey.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the application key if it is in plain text
+func (c *B2FsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate B2 config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.ApplicationKey.IsPlain() {
+		c.ApplicationKey.SetAdditionalData(additionalData)
+		err := c.ApplicationKey.Encrypt()
+		if err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt B2 application key: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *B2FsConfig) isEqual(other B2FsConfig) bool {
+	if c.Bucket != other.Bucket {
+		return false
+	}
+	if c.KeyPrefix != other.KeyPrefix {
+		return false
+	}
+	if c.KeyID != other.KeyID {
+		return false
+	}
+	if c.UploadPartSize != other.UploadPartSize {
+		return false
+	}
+	if c.UploadConcurrency != other.UploadConcurrency {
+		return false
+	}
+	if c.DownloadPartSize != other.DownloadPartSize {
+		return false
+	}
+	if c.DownloadConcurrency != other.DownloadConcurrency {
+		return false
+	}
+	if c.HideOnDelete != other.HideOnDelete {
+		return false
+	}
+	if c.ApplicationKey == nil {
+		c.ApplicationKey = kms.NewEmptySecret()
+	}
+	if other.ApplicationKey == nil {
+		other.ApplicationKey = kms.NewEmptySecret()
+	}
+	return c.ApplicationKey.IsEqual(other.ApplicationKey)
+}
+
+func (c *B2FsConfig) isSameResource(other B2FsConfig) bool {
+	return c.Bucket == other.Bucket
+}
+
+// validate returns an error if the configuration is not valid
+func (c *B2FsConfig) validate() error {
+	if c.ApplicationKey == nil {
+		c.ApplicationKey = kms.NewEmptySecret()
+	}
+	if c.Bucket == "" {
+		return util.NewI18nError(errors.New("bucket cannot be empty"), util.I18nErrorBucketRequired)
+	}
+	if c.KeyPrefix != "" {
+		if strings.HasPrefix(c.KeyPrefix, "/") {
+			return util.NewI18nError(errors.New("key_prefix cannot start with /"), util.I18nErrorKeyPrefixInvalid)
+		}
+		c.KeyPrefix = path.Clean(c.KeyPrefix)
+		if !strings.HasSuffix(c.KeyPrefix, "/") {
+			c.KeyPrefix += "/"
+		}
+	}
+	c.KeyID = strings.TrimSpace(c.KeyID)
+	if c.KeyID == "" {
+		return util.NewI18nError(errors.New("key_id cannot be empty"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.ApplicationKey.IsEncrypted() && !c.ApplicationKey.IsValid() {
+		return errors.New("invalid encrypted application_key")
+	}
+	if !c.ApplicationKey.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid application_key"), util.I18nErrorFsCredentialsRequired)
+	}
+	return c.checkPartSizeAndConcurrency()
+}
+
+func (c *B2FsConfig) checkPartSizeAndConcurrency() error {
+	if c.UploadPartSize != 0 && (c.UploadPartSize < 5 || c.UploadPartSize > 5000) {
+		return util.NewI18nError(
+			errors.New("upload_part_size cannot be != 0, lower than 5 (MB) or greater than 5000 (MB)"),
+			util.I18nErrorULPartSizeInvalid,
+		)
+	}
+	if c.UploadConcurrency < 0 || c.UploadConcurrency > 64 {
+		return util.NewI18nError(
+			fmt.Errorf("invalid upload concurrency: %v", c.UploadConcurrency),
+			util.I18nErrorULConcurrencyInvalid,
+		)
+	}
+	if c.DownloadPartSize < 0 || c.DownloadPartSize > 5000 {
+		return util.NewI18nError(
+			errors.New("download_part_size cannot be lower than 0 or greater than 5000 (MB)"),
+			util.I18nErrorDLPartSizeInvalid,
+		)
+	}
+	if c.DownloadConcurrency < 0 || c.DownloadConcurrency > 64 {
+		return util.NewI18nError(
+			fmt.Errorf("invalid download concurrency: %v", c.DownloadConcurrency),
+			util.I18nErrorDLConcurrencyInvalid,
+		)
+	}
+	return nil
+}
+
+// DropboxFsConfig defines the configuration for Dropbox based filesystem.
+// The Dropbox HTTP API is used, access tokens are obtained using the
+// configured OAuth2 refresh token
+type DropboxFsConfig struct {
+	// RootPath is similar to a chroot directory for local filesystem.
+	// If specified the SFTP user will only see the contents of this
+	// Dropbox folder. Empty or "/" means the whole Dropbox space
+	RootPath string `json:"root_path,omitempty"`
+	// ClientID is the app key for the Dropbox app
+	ClientID string `json:"client_id,omitempty"`
+	// ClientSecret is the app secret. It is not required for refresh
+	// tokens obtained using PKCE
+	ClientSecret *kms.Secret `json:"client_secret,omitempty"`
+	// RefreshToken is a long-lived token used to get short-lived access
+	// tokens. It is stored encrypted based on the kms configuration
+	RefreshToken *kms.Secret `json:"refresh_token,omitempty"`
+	// NamespaceID allows to access a Dropbox Business team space. If empty
+	// the user's home namespace is used
+	NamespaceID string `json:"namespace_id,omitempty"`
+	// The size of a chunk, in MB, for upload sessions. Files bigger than
+	// this size are uploaded in chunks using an upload session. Zero means
+	// the default (16 MB), the maximum is 150 MB
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+}
+
+func (c *DropboxFsConfig) setEmptyCredentialsIfNil() {
+	if c.ClientSecret == nil {
+		c.ClientSecret = kms.NewEmptySecret()
+	}
+	if c.RefreshToken == nil {
+		c.RefreshToken = kms.NewEmptySecret()
+	}
+}
+
+func (c *DropboxFsConfig) setNilSecretsIfEmpty() {
+	if c.ClientSecret != nil && c.ClientSecret.IsEmpty() {
+		c.ClientSecret = nil
+	}
+	if c.RefreshToken != nil && c.RefreshToken.IsEmpty() {
+		c.RefreshToken = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *DropboxFsConfig) HideConfidentialData() {
+	if c.ClientSecret != nil {
+		c.ClientSecret.Hide()
+	}
+	if c.RefreshToken != nil {
+		c.RefreshToken.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *DropboxFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate Dropbox config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.ClientSecret.IsPlain() {
+		c.ClientSecret.SetAdditionalData(additionalData)
+		if err := c.ClientSecret.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt Dropbox client secret: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.RefreshToken.IsPlain() {
+		c.RefreshToken.SetAdditionalData(additionalData)
+		if err := c.RefreshToken.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt Dropbox refresh token: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *DropboxFsConfig) isEqual(other DropboxFsConfig) bool {
+	if c.RootPath != other.RootPath {
+		return false
+	}
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if c.NamespaceID != other.NamespaceID {
+		return false
+	}
+	if c.UploadPartSize != other.UploadPartSize {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.ClientSecret.IsEqual(other.ClientSecret) {
+		return false
+	}
+	return c.RefreshToken.IsEqual(other.RefreshToken)
+}
+
+// isSameResource returns true if the configurations refer to the same Dropbox
+// account. The account is not known without an API call, the refresh tokens
+// are compared as stored so only copies of the same configuration match
+func (c *DropboxFsConfig) isSameResource(other DropboxFsConfig) bool {
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if c.NamespaceID != other.NamespaceID {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.RefreshToken.IsEqual(other.RefreshToken)
+}
+
+// validate returns an error if the configuration is not valid
+func (c *DropboxFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.RootPath != "" {
+		c.RootPath = util.CleanPath(c.RootPath)
+	} else {
+		c.RootPath = "/"
+	}
+	c.ClientID = strings.TrimSpace(c.ClientID)
+	if c.ClientID == "" {
+		return util.NewI18nError(errors.New("client_id cannot be empty"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.ClientSecret.IsEncrypted() && !c.ClientSecret.IsValid() {
+		return errors.New("invalid encrypted client_secret")
+	}
+	if !c.ClientSecret.IsEmpty() && !c.ClientSecret.IsValidInput() {
+		return errors.New("invalid client_secret")
+	}
+	if c.RefreshToken.IsEncrypted() && !c.RefreshToken.IsValid() {
+		return errors.New("invalid encrypted refresh_token")
+	}
+	if !c.RefreshToken.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid refresh_token"), util.I18nErrorFsCredentialsRequired)
+	}
+	c.NamespaceID = strings.TrimSpace(c.NamespaceID)
+	if c.UploadPartSize < 0 || c.UploadPartSize > 150 {
+		return util.NewI18nError(
+			errors.New("upload_part_size cannot be lower than 0 or greater than 150 (MB)"),
+			util.I18nErrorULPartSizeInvalid,
+		)
+	}
+	return nil
+}
+
+// Supported authentication methods for Google Drive
+const (
+	// GDriveAuthServiceAccount uses a service account key, optionally with
+	// domain-wide delegation to impersonate a Google Workspace user
+	GDriveAuthServiceAccount = iota
+	// GDriveAuthOAuth2 uses an OAuth2 refresh token obtained by the user
+	GDriveAuthOAuth2
+)
+
+// Supported export formats for Google Docs, Sheets, Slides and Drawings
+const (
+	// GDriveExportOffice exports to Microsoft Office formats
+	GDriveExportOffice = iota
+	// GDriveExportOpenDocument exports to OpenDocument formats
+	GDriveExportOpenDocument
+	// GDriveExportPDF exports to PDF
+	GDriveExportPDF
+)
+
+// GDriveFsConfig defines the configuration for Google Drive based filesystem
+type GDriveFsConfig struct {
+	// DriveID is the ID of a shared drive. If empty the user's My Drive is used
+	DriveID string `json:"drive_id,omitempty"`
+	// RootFolderID is similar to a chroot directory for local filesystem.
+	// If specified the SFTP user will only see the contents of this folder.
+	// If empty the root of the selected drive is used
+	RootFolderID string `json:"root_folder_id,omitempty"`
+	// AuthMethod defines how to authenticate against Google Drive.
+	// 0 service account, 1 OAuth2 refresh token
+	AuthMethod int `json:"auth_method,omitempty"`
+	// Credentials is the service account JSON key
+	Credentials *kms.Secret `json:"credentials,omitempty"`
+	// Subject is the Google Workspace user to impersonate using domain-wide
+	// delegation. If empty the service account acts on its own behalf
+	Subject string `json:"subject,omitempty"`
+	// ClientID is the OAuth2 client ID
+	ClientID string `json:"client_id,omitempty"`
+	// ClientSecret is the OAuth2 client secret
+	ClientSecret *kms.Secret `json:"client_secret,omitempty"`
+	// RefreshToken is the OAuth2 refresh token
+	RefreshToken *kms.Secret `json:"refresh_token,omitempty"`
+	// ExportFormat defines the format used to download Google Docs, Sheets,
+	// Slides and Drawings. 0 Microsoft Office, 1 OpenDocument, 2 PDF
+	ExportFormat int `json:"export_format,omitempty"`
+	// The size of a chunk, in MB, for resumable uploads. Zero means the
+	// default (16 MB)
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+	// UseTrash moves removed and overwritten files to the trash instead of
+	// deleting them permanently
+	UseTrash bool `json:"use_trash,omitempty"`
+}
+
+func (c *GDriveFsConfig) setEmptyCredentialsIfNil() {
+	if c.Credentials == nil {
+		c.Credentials = kms.NewEmptySecret()
+	}
+	if c.ClientSecret == nil {
+		c.ClientSecret = kms.NewEmptySecret()
+	}
+	if c.RefreshToken == nil {
+		c.RefreshToken = kms.NewEmptySecret()
+	}
+}
+
+func (c *GDriveFsConfig) setNilSecretsIfEmpty() {
+	if c.Credentials != nil && c.Credentials.IsEmpty() {
+		c.Credentials = nil
+	}
+	if c.ClientSecret != nil && c.ClientSecret.IsEmpty() {
+		c.ClientSecret = nil
+	}
+	if c.RefreshToken != nil && c.RefreshToken.IsEmpty() {
+		c.RefreshToken = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *GDriveFsConfig) HideConfidentialData() {
+	if c.Credentials != nil {
+		c.Credentials.Hide()
+	}
+	if c.ClientSecret != nil {
+		c.ClientSecret.Hide()
+	}
+	if c.RefreshToken != nil {
+		c.RefreshToken.Hide()
+	}
+}
+
+func (c *GDriveFsConfig) hasRedactedSecret() bool {
+	if c.Credentials.IsRedacted() {
+		return true
+	}
+	if c.ClientSecret.IsRedacted() {
+		return true
+	}
+	return c.RefreshToken.IsRedacted()
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *GDriveFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate Google Drive config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	secrets := []struct {
+		name   string
+		secret *kms.Secret
+	}{
+		{"credentials", c.Credentials},
+		{"client secret", c.ClientSecret},
+		{"refresh token", c.RefreshToken},
+	}
+	for _, s := range secrets {
+		if !s.secret.IsPlain() {
+			continue
+		}
+		s.secret.SetAdditionalData(additionalData)
+		if err := s.secret.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt Google Drive %s: %v", s.name, err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *GDriveFsConfig) isEqual(other GDriveFsConfig) bool {
+	if c.DriveID != other.DriveID {
+		return false
+	}
+	if c.RootFolderID != other.RootFolderID {
+		return false
+	}
+	if c.AuthMethod != other.AuthMethod {
+		return false
+	}
+	if c.Subject != other.Subject {
+		return false
+	}
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if c.ExportFormat != other.ExportFormat {
+		return false
+	}
+	if c.UploadPartSize != other.UploadPartSize {
+		return false
+	}
+	if c.UseTrash != other.UseTrash {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.Credentials.IsEqual(other.Credentials) {
+		return false
+	}
+	if !c.ClientSecret.IsEqual(other.ClientSecret) {
+		return false
+	}
+	return c.RefreshToken.IsEqual(other.RefreshToken)
+}
+
+// isSameResource returns true if the configurations refer to the same drive
+// accessed with the same identity, the secrets are compared as stored
+func (c *GDriveFsConfig) isSameResource(other GDriveFsConfig) bool {
+	if c.DriveID != other.DriveID {
+		return false
+	}
+	if c.AuthMethod != other.AuthMethod {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if c.AuthMethod == GDriveAuthOAuth2 {
+		return c.ClientID == other.ClientID && c.RefreshToken.IsEqual(other.RefreshToken)
+	}
+	return c.Subject == other.Subject && c.Credentials.IsEqual(other.Credentials)
+}
+
+func (c *GDriveFsConfig) validateServiceAccount() error {
+	c.ClientID = ""
+	c.ClientSecret = kms.NewEmptySecret()
+	c.RefreshToken = kms.NewEmptySecret()
+	if c.Credentials.IsEncrypted() && !c.Credentials.IsValid() {
+		return errors.New("invalid encrypted credentials")
+	}
+	if !c.Credentials.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid credentials"), util.I18nErrorFsCredentialsRequired)
+	}
+	c.Subject = strings.TrimSpace(c.Subject)
+	return nil
+}
+
+func (c *GDriveFsConfig) validateOAuth2() error {
+	c.Credentials = kms.NewEmptySecret()
+	c.Subject = ""
+	c.ClientID = strings.TrimSpace(c.ClientID)
+	if c.ClientID == "" {
+		return util.NewI18nError(errors.New("client_id cannot be empty"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.ClientSecret.IsEncrypted() && !c.ClientSecret.IsValid() {
+		return errors.New("invalid encrypted client_secret")
+	}
+	if !c.ClientSecret.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid client_secret"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.RefreshToken.IsEncrypted() && !c.RefreshToken.IsValid() {
+		return errors.New("invalid encrypted refresh_token")
+	}
+	if !c.RefreshToken.IsValidInput() {
+		return util.NewI18nError(errors.New("invalid refresh_token"), util.I18nErrorFsCredentialsRequired)
+	}
+	return nil
+}
+
+// validate returns an error if the configuration is not valid
+func (c *GDriveFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	c.DriveID = strings.TrimSpace(c.DriveID)
+	c.RootFolderID = strings.TrimSpace(c.RootFolderID)
+	switch c.AuthMethod {
+	case GDriveAuthServiceAccount:
+		if err := c.validateServiceAccount(); err != nil {
+			return err
+		}
+	case GDriveAuthOAuth2:
+		if err := c.validateOAuth2(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid auth_method %d", c.AuthMethod)
+	}
+	if c.ExportFormat < GDriveExportOffice || c.ExportFormat > GDriveExportPDF {
+		return fmt.Errorf("invalid export_format %d", c.ExportFormat)
+	}
+	if c.UploadPartSize < 0 || c.UploadPartSize > 1024 {
+		return util.NewI18nError(
+			errors.New("upload_part_size cannot be lower than 0 or greater than 1024 (MB)"),
+			util.I18nErrorULPartSizeInvalid,
+		)
+	}
+	return nil
+}
+
+// OneDriveFsConfig defines the configuration for OneDrive and SharePoint
+// document libraries, accessed using the Microsoft Graph API
+type OneDriveFsConfig struct {
+	// TenantID is the Microsoft Entra ID (Azure AD) tenant. It is required
+	// if no refresh token is set. For refresh tokens empty means "common"
+	TenantID string `json:"tenant_id,omitempty"`
+	// ClientID is the application (client) ID of the app registration
+	ClientID string `json:"client_id,omitempty"`
+	// ClientSecret is the client secret. It is not required for refresh
+	// tokens obtained by public clients
+	ClientSecret *kms.Secret `json:"client_secret,omitempty"`
+	// RefreshToken allows to act on behalf of the user that authorized the
+	// app. If empty the client credentials flow is used and the app must
+	// have application permissions on the configured drive
+	RefreshToken *kms.Secret `json:"refresh_token,omitempty"`
+	// DriveID is the ID of the OneDrive or of the SharePoint document
+	// library. It can be empty only if a refresh token is set, in this
+	// case the OneDrive of the signed-in user is used
+	DriveID string `json:"drive_id,omitempty"`
+	// RootPath is similar to a chroot directory for local filesystem.
+	// If specified the SFTP user will only see the contents of this
+	// folder. Empty or "/" means the whole drive
+	RootPath string `json:"root_path,omitempty"`
+	// The size of a chunk, in MB, for upload sessions. Zero means the
+	// default (10 MB), the maximum is 60 MB
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+}
+
+func (c *OneDriveFsConfig) setEmptyCredentialsIfNil() {
+	if c.ClientSecret == nil {
+		c.ClientSecret = kms.NewEmptySecret()
+	}
+	if c.RefreshToken == nil {
+		c.RefreshToken = kms.NewEmptySecret()
+	}
+}
+
+func (c *OneDriveFsConfig) setNilSecretsIfEmpty() {
+	if c.ClientSecret != nil && c.ClientSecret.IsEmpty() {
+		c.ClientSecret = nil
+	}
+	if c.RefreshToken != nil && c.RefreshToken.IsEmpty() {
+		c.RefreshToken = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *OneDriveFsConfig) HideConfidentialData() {
+	if c.ClientSecret != nil {
+		c.ClientSecret.Hide()
+	}
+	if c.RefreshToken != nil {
+		c.RefreshToken.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *OneDriveFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate OneDrive config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.ClientSecret.IsPlain() {
+		c.ClientSecret.SetAdditionalData(additionalData)
+		if err := c.ClientSecret.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt OneDrive client secret: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.RefreshToken.IsPlain() {
+		c.RefreshToken.SetAdditionalData(additionalData)
+		if err := c.RefreshToken.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt OneDrive refresh token: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *OneDriveFsConfig) isEqual(other OneDriveFsConfig) bool {
+	if c.TenantID != other.TenantID {
+		return false
+	}
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if c.DriveID != other.DriveID {
+		return false
+	}
+	if c.RootPath != other.RootPath {
+		return false
+	}
+	if c.UploadPartSize != other.UploadPartSize {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.ClientSecret.IsEqual(other.ClientSecret) {
+		return false
+	}
+	return c.RefreshToken.IsEqual(other.RefreshToken)
+}
+
+// isSameResource returns true if the configurations refer to the same drive.
+// If the drive ID is not set the drive of the signed-in user is used, so the
+// refresh tokens are compared as stored
+func (c *OneDriveFsConfig) isSameResource(other OneDriveFsConfig) bool {
+	if c.TenantID != other.TenantID {
+		return false
+	}
+	if c.ClientID != other.ClientID {
+		return false
+	}
+	if c.DriveID != other.DriveID {
+		return false
+	}
+	if c.DriveID != "" {
+		return true
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.RefreshToken.IsEqual(other.RefreshToken)
+}
+
+// validate returns an error if the configuration is not valid
+func (c *OneDriveFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.RootPath != "" {
+		c.RootPath = util.CleanPath(c.RootPath)
+	} else {
+		c.RootPath = "/"
+	}
+	c.TenantID = strings.TrimSpace(c.TenantID)
+	c.ClientID = strings.TrimSpace(c.ClientID)
+	c.DriveID = strings.TrimSpace(c.DriveID)
+	if c.ClientID == "" {
+		return util.NewI18nError(errors.New("client_id cannot be empty"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.ClientSecret.IsEncrypted() && !c.ClientSecret.IsValid() {
+		return errors.New("invalid encrypted client_secret")
+	}
+	if !c.ClientSecret.IsEmpty() && !c.ClientSecret.IsValidInput() {
+		return errors.New("invalid client_secret")
+	}
+	if c.RefreshToken.IsEncrypted() && !c.RefreshToken.IsValid() {
+		return errors.New("invalid encrypted refresh_token")
+	}
+	if !c.RefreshToken.IsEmpty() && !c.RefreshToken.IsValidInput() {
+		return errors.New("invalid refresh_token")
+	}
+	if c.RefreshToken.IsEmpty() {
+		// client credentials flow
+		if c.TenantID == "" {
+			return errors.New("tenant_id is required if no refresh token is set")
+		}
+		if c.ClientSecret.IsEmpty() {
+			return util.NewI18nError(
+				errors.New("client_secret is required if no refresh token is set"),
+				util.I18nErrorFsCredentialsRequired,
+			)
+		}
+		if c.DriveID == "" {
+			return errors.New("drive_id is required if no refresh token is set")
+		}
+	}
+	if c.UploadPartSize < 0 || c.UploadPartSize > 60 {
+		return util.NewI18nError(
+			errors.New("upload_part_size cannot be lower than 0 or greater than 60 (MB)"),
+			util.I18nErrorULPartSizeInvalid,
+		)
+	}
+	return nil
+}
+
+// Supported authentication methods for WebDAV servers
+const (
+	WebDAVAuthBasic = iota
+	WebDAVAuthDigest
+	WebDAVAuthBearer
+)
+
+// WebDAVFsConfig defines the configuration for a remote WebDAV server
+type WebDAVFsConfig struct {
+	// Endpoint is the URL of the WebDAV collection to use as root directory,
+	// for example "https://cloud.example.com/remote.php/dav/files/user"
+	Endpoint string `json:"endpoint,omitempty"`
+	// AuthMethod defines how to authenticate against the server
+	AuthMethod int `json:"auth_method,omitempty"`
+	// Username and Password are used for basic and digest authentication
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// BearerToken is used for bearer authentication
+	BearerToken *kms.Secret `json:"bearer_token,omitempty"`
+	// SkipTLSVerify disables the server certificate verification
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+	// Uploads are streamed using chunked transfer encoding. Some servers
+	// or proxies require the content length, if enabled uploads are
+	// buffered in a local temporary file before sending them
+	BufferUploads bool `json:"buffer_uploads,omitempty"`
+}
+
+func (c *WebDAVFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+	if c.BearerToken == nil {
+		c.BearerToken = kms.NewEmptySecret()
+	}
+}
+
+func (c *WebDAVFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+	if c.BearerToken != nil && c.BearerToken.IsEmpty() {
+		c.BearerToken = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *WebDAVFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+	if c.BearerToken != nil {
+		c.BearerToken.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *WebDAVFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate WebDAV config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt WebDAV password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.BearerToken.IsPlain() {
+		c.BearerToken.SetAdditionalData(additionalData)
+		if err := c.BearerToken.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt WebDAV bearer token: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *WebDAVFsConfig) isEqual(other WebDAVFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.AuthMethod != other.AuthMethod {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.SkipTLSVerify != other.SkipTLSVerify {
+		return false
+	}
+	if c.BufferUploads != other.BufferUploads {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.Password.IsEqual(other.Password) {
+		return false
+	}
+	return c.BearerToken.IsEqual(other.BearerToken)
+}
+
+func (c *WebDAVFsConfig) isSameResource(other WebDAVFsConfig) bool {
+	return c.Endpoint == other.Endpoint && c.Username == other.Username
+}
+
+// validate returns an error if the configuration is not valid
+func (c *WebDAVFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	c.Endpoint = strings.TrimRight(strings.TrimSpace(c.Endpoint), "/")
+	if c.Endpoint == "" {
+		return util.NewI18nError(errors.New("endpoint cannot be empty"), util.I18nErrorEndpointRequired)
+	}
+	endpointURL, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return util.NewI18nError(fmt.Errorf("invalid endpoint: %w", err), util.I18nErrorEndpointInvalid)
+	}
+	if !util.IsStringPrefixInSlice(c.Endpoint, supportedEndpointSchema) || endpointURL.Host == "" {
+		return util.NewI18nError(
+			errors.New("invalid endpoint: an http or https URL is required"),
+			util.I18nErrorEndpointInvalid,
+		)
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("invalid password")
+	}
+	if c.BearerToken.IsEncrypted() && !c.BearerToken.IsValid() {
+		return errors.New("invalid encrypted bearer_token")
+	}
+	if !c.BearerToken.IsEmpty() && !c.BearerToken.IsValidInput() {
+		return errors.New("invalid bearer_token")
+	}
+	switch c.AuthMethod {
+	case WebDAVAuthBasic:
+		c.BearerToken = kms.NewEmptySecret()
+	case WebDAVAuthDigest:
+		if c.Username == "" {
+			return util.NewI18nError(
+				errors.New("username is required for digest authentication"),
+				util.I18nErrorFsCredentialsRequired,
+			)
+		}
+		c.BearerToken = kms.NewEmptySecret()
+	case WebDAVAuthBearer:
+		if c.BearerToken.IsEmpty() {
+			return util.NewI18nError(
+				errors.New("bearer_token is required for bearer authentication"),
+				util.I18nErrorFsCredentialsRequired,
+			)
+		}
+		c.Username = ""
+		c.Password = kms.NewEmptySecret()
+	default:
+		return fmt.Errorf("invalid auth_method: %d", c.AuthMethod)
+	}
+	return nil
+}
+
+// SMBFsConfig defines the configuration for SMB/CIFS shares
+type SMBFsConfig struct {
+	// Endpoint is the server address as host:port, the port defaults to 445
+	Endpoint string `json:"endpoint,omitempty"`
+	// Share is the name of the share to mount
+	Share    string      `json:"share,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// Domain is the NTLM domain, it can be empty
+	Domain string `json:"domain,omitempty"`
+	// Prefix is the directory inside the share to use as root directory.
+	// Empty or "/" means the whole share
+	Prefix string `json:"prefix,omitempty"`
+	// RequireSigning enforces message signing
+	RequireSigning bool `json:"require_signing,omitempty"`
+}
+
+func (c *SMBFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *SMBFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *SMBFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the password if it is in plain text
+func (c *SMBFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate SMB config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt SMB password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *SMBFsConfig) isEqual(other SMBFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Share != other.Share {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.Domain != other.Domain {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	if c.RequireSigning != other.RequireSigning {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *SMBFsConfig) isSameResource(other SMBFsConfig) bool {
+	return c.Endpoint == other.Endpoint && strings.EqualFold(c.Share, other.Share)
+}
+
+// validate returns an error if the configuration is not valid
+func (c *SMBFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	c.Endpoint = strings.TrimSpace(c.Endpoint)
+	if c.Endpoint == "" {
+		return util.NewI18nError(errors.New("endpoint cannot be empty"), util.I18nErrorEndpointRequired)
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		c.Endpoint = net.JoinHostPort(strings.Trim(c.Endpoint, "[]"), "445")
+	}
+	host, port, err := net.SplitHostPort(c.Endpoint)
+	if err != nil || host == "" || strings.ContainsAny(host, `/\`) {
+		return util.NewI18nError(fmt.Errorf("invalid endpoint %q", c.Endpoint), util.I18nErrorEndpointInvalid)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return util.NewI18nError(fmt.Errorf("invalid endpoint port %q", port), util.I18nErrorEndpointInvalid)
+	}
+	c.Share = strings.Trim(strings.TrimSpace(c.Share), `/\`)
+	if c.Share == "" {
+		return errors.New("share cannot be empty")
+	}
+	if strings.ContainsAny(c.Share, `/\`) {
+		return fmt.Errorf("invalid share %q", c.Share)
+	}
+	if c.Username == "" {
+		return util.NewI18nError(errors.New("username cannot be empty"), util.I18nErrorFsUsernameRequired)
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("invalid password")
+	}
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(c.Prefix)
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
+// Supported authentication methods for HDFS clusters
+const (
+	HDFSAuthSimple = iota
+	HDFSAuthKerberos
+)
+
+// HDFSFsConfig defines the configuration for HDFS clusters accessed using WebHDFS
+type HDFSFsConfig struct {
+	// Endpoint is the WebHDFS URL of the namenode or of an HttpFS gateway,
+	// for example "https://namenode.example.com:9871"
+	Endpoint string `json:"endpoint,omitempty"`
+	// AuthMethod defines how to authenticate against the cluster
+	AuthMethod int `json:"auth_method,omitempty"`
+	// Username is the user for simple authentication
+	Username string `json:"username,omitempty"`
+	// KerberosPrincipal is the principal, including the realm, used for
+	// Kerberos authentication, for example "sftpgo@EXAMPLE.COM"
+	KerberosPrincipal string `json:"kerberos_principal,omitempty"`
+	// KerberosPassword and KerberosKeytab are the principal credentials,
+	// the keytab is base64 encoded and has precedence over the password
+	KerberosPassword *kms.Secret `json:"kerberos_password,omitempty"`
+	KerberosKeytab   *kms.Secret `json:"kerberos_keytab,omitempty"`
+	// Krb5Conf is the content of the Kerberos configuration file.
+	// If empty the file defined by the KRB5_CONFIG environment variable,
+	// or /etc/krb5.conf, is used
+	Krb5Conf string `json:"krb5_conf,omitempty"`
+	// ServicePrincipal is the HTTP service principal of the namenode.
+	// If empty it is derived from the endpoint host
+	ServicePrincipal string `json:"service_principal,omitempty"`
+	// DoAs is the user to impersonate, the authenticated user must be
+	// allowed to act as a proxy user
+	DoAs string `json:"doas,omitempty"`
+	// Prefix is the HDFS directory to use as root directory.
+	// Empty or "/" means the whole filesystem
+	Prefix string `json:"prefix,omitempty"`
+	// SkipTLSVerify disables the server certificate verification
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+}
+
+func (c *HDFSFsConfig) setEmptyCredentialsIfNil() {
+	if c.KerberosPassword == nil {
+		c.KerberosPassword = kms.NewEmptySecret()
+	}
+	if c.KerberosKeytab == nil {
+		c.KerberosKeytab = kms.NewEmptySecret()
+	}
+}
+
+func (c *HDFSFsConfig) setNilSecretsIfEmpty() {
+	if c.KerberosPassword != nil && c.KerberosPassword.IsEmpty() {
+		c.KerberosPassword = nil
+	}
+	if c.KerberosKeytab != nil && c.KerberosKeytab.IsEmpty() {
+		c.KerberosKeytab = nil
+	}
+}
+
+// HideConfidentialData hides confidential data
+func (c *HDFSFsConfig) HideConfidentialData() {
+	if c.KerberosPassword != nil {
+		c.KerberosPassword.Hide()
+	}
+	if c.KerberosKeytab != nil {
+		c.KerberosKeytab.Hide()
+	}
+}
+
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *HDFSFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		var errI18n *util.I18nError
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate HDFS config: %v", err))
+		if errors.As(err, &errI18n) {
+			return util.NewI18nError(errValidation, errI18n.Message)
+		}
+		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
+	}
+	if c.KerberosPassword.IsPlain() {
+		c.KerberosPassword.SetAdditionalData(additionalData)
+		if err := c.KerberosPassword.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt HDFS Kerberos password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.KerberosKeytab.IsPlain() {
+		c.KerberosKeytab.SetAdditionalData(additionalData)
+		if err := c.KerberosKeytab.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt HDFS Kerberos keytab: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *HDFSFsConfig) isEqual(other HDFSFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.AuthMethod != other.AuthMethod {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.KerberosPrincipal != other.KerberosPrincipal {
+		return false
+	}
+	if c.Krb5Conf != other.Krb5Conf {
+		return false
+	}
+	if c.ServicePrincipal != other.ServicePrincipal {
+		return false
+	}
+	if c.DoAs != other.DoAs {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	if c.SkipTLSVerify != other.SkipTLSVerify {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.KerberosPassword.IsEqual(other.KerberosPassword) {
+		return false
+	}
+	return c.KerberosKeytab.IsEqual(other.KerberosKeytab)
+}
+
+func (c *HDFSFsConfig) isSameResource(other HDFSFsConfig) bool {
+	return c.Endpoint == other.Endpoint
+}
+
+// validate returns an error if the configuration is not valid
+func (c *HDFSFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	c.Endpoint = strings.TrimRight(strings.TrimSpace(c.Endpoint), "/")
+	if c.Endpoint == "" {
+		return util.NewI18nError(errors.New("endpoint cannot be empty"), util.I18nErrorEndpointRequired)
+	}
+	endpointURL, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return util.NewI18nError(fmt.Errorf("invalid endpoint: %w", err), util.I18nErrorEndpointInvalid)
+	}
+	if !util.IsStringPrefixInSlice(c.Endpoint, supportedEndpointSchema) || endpointURL.Host == "" {
+		return util.NewI18nError(
+			errors.New("invalid endpoint: an http or https URL is required"),
+			util.I18nErrorEndpointInvalid,
+		)
+	}
+	if c.KerberosPassword.IsEncrypted() && !c.KerberosPassword.IsValid() {
+		return errors.New("invalid encrypted kerberos_password")
+	}
+	if !c.KerberosPassword.IsEmpty() && !c.KerberosPassword.IsValidInput() {
+		return errors.New("invalid kerberos_password")
 	}
-	if c.KeyPrefix != "" {
-		if strings.HasPrefix(c.KeyPrefix, "/") {
-			return util.NewI18nError(errors.New("key_prefix cannot start with /"), util.I18nErrorKeyPrefixInvalid)
+	if c.KerberosKeytab.IsEncrypted() && !c.KerberosKeytab.IsValid() {
+		return errors.New("invalid encrypted kerberos_keytab")
+	}
+	if !c.KerberosKeytab.IsEmpty() && !c.KerberosKeytab.IsValidInput() {
+		return errors.New("invalid kerberos_keytab")
+	}
+	if c.KerberosKeytab.IsPlain() {
+		if _, err := base64.StdEncoding.DecodeString(c.KerberosKeytab.GetPayload()); err != nil {
+			return errors.New("kerberos_keytab must be base64 encoded")
 		}
-		c.KeyPrefix = path.Clean(c.KeyPrefix)
-		if !strings.HasSuffix(c.KeyPrefix, "/") {
-			c.KeyPrefix += "/"
+	}
+	switch c.AuthMethod {
+	case HDFSAuthSimple:
+		if c.Username == "" {
+			return util.NewI18nError(errors.New("username cannot be empty"), util.I18nErrorFsUsernameRequired)
+		}
+		c.KerberosPrincipal = ""
+		c.KerberosPassword = kms.NewEmptySecret()
+		c.KerberosKeytab = kms.NewEmptySecret()
+		c.Krb5Conf = ""
+		c.ServicePrincipal = ""
+	case HDFSAuthKerberos:
+		if name, realm, ok := strings.Cut(c.KerberosPrincipal, "@"); !ok || name == "" || realm == "" {
+			return util.NewI18nError(
+				fmt.Errorf("invalid kerberos_principal %q, the realm is required", c.KerberosPrincipal),
+				util.I18nErrorFsCredentialsRequired,
+			)
+		}
+		if c.KerberosPassword.IsEmpty() && c.KerberosKeytab.IsEmpty() {
+			return util.NewI18nError(
+				errors.New("a Kerberos password or keytab is required"),
+				util.I18nErrorFsCredentialsRequired,
+			)
 		}
+		c.Username = ""
+	default:
+		return fmt.Errorf("invalid auth_method: %d", c.AuthMethod)
 	}
-	c.StorageClass = strings.TrimSpace(c.StorageClass)
-	c.ACL = strings.TrimSpace(c.ACL)
-	return c.checkPartSizeAndConcurrency()
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(c.Prefix)
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
 }
 
-// GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
-type GCSFsConfig struct {
-	sdk.BaseGCSFsConfig
-	Credentials *kms.Secret `json:"credentials,omitempty"`
+// SwiftFsConfig defines the configuration for OpenStack Swift based filesystem.
+// Keystone v3 is used for authentication, the object storage endpoint is
+// read from the service catalog
+type SwiftFsConfig struct {
+	// AuthURL is the Keystone v3 endpoint, for example
+	// "https://keystone.example.com:5000/v3"
+	AuthURL string `json:"auth_url,omitempty"`
+	// Username, UserDomain and Password are used for password authentication.
+	// UserDomain defaults to "Default"
+	Username   string      `json:"username,omitempty"`
+	UserDomain string      `json:"user_domain,omitempty"`
+	Password   *kms.Secret `json:"password,omitempty"`
+	// ApplicationCredentialID and ApplicationCredentialSecret are used for
+	// application credential authentication. If the ID is set, the password
+	// authentication and the project scope are ignored
+	ApplicationCredentialID     string      `json:"application_credential_id,omitempty"`
+	ApplicationCredentialSecret *kms.Secret `json:"application_credential_secret,omitempty"`
+	// ProjectID or ProjectName and ProjectDomain define the project scope for
+	// password authentication. ProjectDomain defaults to "Default"
+	ProjectID     string `json:"project_id,omitempty"`
+	ProjectName   string `json:"project_name,omitempty"`
+	ProjectDomain string `json:"project_domain,omitempty"`
+	// Region to use. If empty the first object storage endpoint is used
+	Region string `json:"region,omitempty"`
+	// EndpointType is the endpoint interface to use: public, internal or
+	// admin. Empty means public
+	EndpointType string `json:"endpoint_type,omitempty"`
+	Container    string `json:"container,omitempty"`
+	// If enabled, each user gets a dedicated container named
+	// "<container>-<username>", virtual folders use the folder name instead
+	// of the username. The container is automatically created
+	ContainerPerUser bool `json:"container_per_user,omitempty"`
+	// KeyPrefix is similar to a chroot directory for local filesystem.
+	// If specified then the SFTP user will only see objects that starts
+	// with this prefix and so you can restrict access to a specific
+	// folder. The prefix, if not empty, must not start with "/" and must
+	// end with "/".
+	// If empty the whole container contents will be available
+	KeyPrefix string `json:"key_prefix,omitempty"`
+	// The size of a segment, in MB. Files bigger than this size are uploaded
+	// as Dynamic Large Objects, the segments are stored in the
+	// "<container>_segments" container. Zero means the default (100 MB),
+	// maximum is 5120 MB
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+	// SkipTLSVerify disables the server certificate verification
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+}
+
+// ForOwner returns the configuration to use for the specified owner, a user
+// or a virtual folder. The owner container is used if a container per user
+// is configured
+func (c *SwiftFsConfig) ForOwner(owner string) SwiftFsConfig {
+	config := *c
+	if config.ContainerPerUser && owner != "" {
+		config.Container = fmt.Sprintf("%s-%s", config.Container, owner)
+	}
+	return config
+}
+
+func (c *SwiftFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+	if c.ApplicationCredentialSecret == nil {
+		c.ApplicationCredentialSecret = kms.NewEmptySecret()
+	}
+}
+
+func (c *SwiftFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+	if c.ApplicationCredentialSecret != nil && c.ApplicationCredentialSecret.IsEmpty() {
+		c.ApplicationCredentialSecret = nil
+	}
 }
 
 // HideConfidentialData hides confidential data
-func (c *GCSFsConfig) HideConfidentialData() {
-	if c.Credentials != nil {
-		c.Credentials.Hide()
+func (c *SwiftFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+	if c.ApplicationCredentialSecret != nil {
+		c.ApplicationCredentialSecret.Hide()
 	}
 }
 
-// ValidateAndEncryptCredentials validates the configuration and encrypts credentials if they are in plain text
-func (c *GCSFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+// ValidateAndEncryptCredentials validates the configuration and encrypts the secrets if they are in plain text
+func (c *SwiftFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
 	if err := c.validate(); err != nil {
 		var errI18n *util.I18nError
-		errValidation := util.NewValidationError(fmt.Sprintf("could not validate GCS config: %v", err))
+		errValidation := util.NewValidationError(fmt.Sprintf("could not validate Swift config: %v", err))
 		if errors.As(err, &errI18n) {
 			return util.NewI18nError(errValidation, errI18n.Message)
 		}
 		return util.NewI18nError(errValidation, util.I18nErrorFsValidation)
 	}
-	if c.Credentials.IsPlain() {
-		c.Credentials.SetAdditionalData(additionalData)
-		err := c.Credentials.Encrypt()
-		if err != nil {
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
 			return util.NewI18nError(
-				util.NewValidationError(fmt.Sprintf("could not encrypt GCS credentials: %v", err)),
+				util.NewValidationError(fmt.Sprintf("could not encrypt Swift password: %v", err)),
+				util.I18nErrorFsValidation,
+			)
+		}
+	}
+	if c.ApplicationCredentialSecret.IsPlain() {
+		c.ApplicationCredentialSecret.SetAdditionalData(additionalData)
+		if err := c.ApplicationCredentialSecret.Encrypt(); err != nil {
+			return util.NewI18nError(
+				util.NewValidationError(fmt.Sprintf("could not encrypt Swift application credential secret: %v", err)),
 				util.I18nErrorFsValidation,
 			)
 		}
@@ -493,48 +2172,154 @@ func (c *GCSFsConfig) ValidateAndEncryptCredentials(additionalData string) error
 	return nil
 }
 
-func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
-	if c.Bucket != other.Bucket {
+func (c *SwiftFsConfig) isEqual(other SwiftFsConfig) bool {
+	if c.AuthURL != other.AuthURL {
 		return false
 	}
-	if c.KeyPrefix != other.KeyPrefix {
+	if c.Username != other.Username {
 		return false
 	}
-	if c.AutomaticCredentials != other.AutomaticCredentials {
+	if c.UserDomain != other.UserDomain {
 		return false
 	}
-	if c.StorageClass != other.StorageClass {
+	if c.ApplicationCredentialID != other.ApplicationCredentialID {
 		return false
 	}
-	if c.ACL != other.ACL {
+	if c.ProjectID != other.ProjectID {
+		return false
+	}
+	if c.ProjectName != other.ProjectName {
+		return false
+	}
+	if c.ProjectDomain != other.ProjectDomain {
+		return false
+	}
+	if c.Region != other.Region {
+		return false
+	}
+	if c.EndpointType != other.EndpointType {
+		return false
+	}
+	if c.Container != other.Container {
+		return false
+	}
+	if c.ContainerPerUser != other.ContainerPerUser {
+		return false
+	}
+	if c.KeyPrefix != other.KeyPrefix {
 		return false
 	}
 	if c.UploadPartSize != other.UploadPartSize {
 		return false
 	}
-	if c.UploadPartMaxTime != other.UploadPartMaxTime {
+	if c.SkipTLSVerify != other.SkipTLSVerify {
 		return false
 	}
-	if c.Credentials == nil {
-		c.Credentials = kms.NewEmptySecret()
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.Password.IsEqual(other.Password) {
+		return false
 	}
-	if other.Credentials == nil {
-		other.Credentials = kms.NewEmptySecret()
+	return c.ApplicationCredentialSecret.IsEqual(other.ApplicationCredentialSecret)
+}
+
+func (c *SwiftFsConfig) isSameResource(other SwiftFsConfig) bool {
+	if c.AuthURL != other.AuthURL || c.Region != other.Region {
+		return false
 	}
-	return c.Credentials.IsEqual(other.Credentials)
+	return c.Container == other.Container && c.ContainerPerUser == other.ContainerPerUser
 }
 
-func (c *GCSFsConfig) isSameResource(other GCSFsConfig) bool {
-	return c.Bucket == other.Bucket
+func (c *SwiftFsConfig) validateCredentials() error {
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("invalid password")
+	}
+	if c.ApplicationCredentialSecret.IsEncrypted() && !c.ApplicationCredentialSecret.IsValid() {
+		return errors.New("invalid encrypted application_credential_secret")
+	}
+	if !c.ApplicationCredentialSecret.IsEmpty() && !c.ApplicationCredentialSecret.IsValidInput() {
+		return errors.New("invalid application_credential_secret")
+	}
+	c.ApplicationCredentialID = strings.TrimSpace(c.ApplicationCredentialID)
+	if c.ApplicationCredentialID != "" {
+		if c.ApplicationCredentialSecret.IsEmpty() {
+			return util.NewI18nError(
+				errors.New("application_credential_secret cannot be empty"),
+				util.I18nErrorFsCredentialsRequired,
+			)
+		}
+		c.Username = ""
+		c.UserDomain = ""
+		c.Password = kms.NewEmptySecret()
+		c.ProjectID = ""
+		c.ProjectName = ""
+		c.ProjectDomain = ""
+		return nil
+	}
+	c.ApplicationCredentialSecret = kms.NewEmptySecret()
+	if c.Username == "" {
+		return util.NewI18nError(errors.New("username cannot be empty"), util.I18nErrorFsUsernameRequired)
+	}
+	if c.Password.IsEmpty() {
+		return util.NewI18nError(errors.New("password cannot be empty"), util.I18nErrorFsCredentialsRequired)
+	}
+	if c.UserDomain == "" {
+		c.UserDomain = "Default"
+	}
+	c.ProjectID = strings.TrimSpace(c.ProjectID)
+	if c.ProjectID != "" {
+		c.ProjectName = ""
+		c.ProjectDomain = ""
+		return nil
+	}
+	if c.ProjectName == "" {
+		return util.NewI18nError(
+			errors.New("a project name or ID is required"),
+			util.I18nErrorFsCredentialsRequired,
+		)
+	}
+	if c.ProjectDomain == "" {
+		c.ProjectDomain = "Default"
+	}
+	return nil
 }
 
 // validate returns an error if the configuration is not valid
-func (c *GCSFsConfig) validate() error {
-	if c.Credentials == nil || c.AutomaticCredentials == 1 {
-		c.Credentials = kms.NewEmptySecret()
+func (c *SwiftFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	c.AuthURL = strings.TrimRight(strings.TrimSpace(c.AuthURL), "/")
+	if c.AuthURL == "" {
+		return util.NewI18nError(errors.New("auth_url cannot be empty"), util.I18nErrorEndpointRequired)
 	}
-	if c.Bucket == "" {
-		return util.NewI18nError(errors.New("bucket cannot be empty"), util.I18nErrorBucketRequired)
+	authURL, err := url.Parse(c.AuthURL)
+	if err != nil {
+		return util.NewI18nError(fmt.Errorf("invalid auth_url: %w", err), util.I18nErrorEndpointInvalid)
+	}
+	if !util.IsStringPrefixInSlice(c.AuthURL, supportedEndpointSchema) || authURL.Host == "" {
+		return util.NewI18nError(
+			errors.New("invalid auth_url: an http or https URL is required"),
+			util.I18nErrorEndpointInvalid,
+		)
+	}
+	if err := c.validateCredentials(); err != nil {
+		return err
+	}
+	switch c.EndpointType {
+	case "":
+		c.EndpointType = "public"
+	case "public", "internal", "admin":
+	default:
+		return fmt.Errorf("invalid endpoint_type: %q", c.EndpointType)
+	}
+	c.Container = strings.TrimSpace(c.Container)
+	if c.Container == "" {
+		return util.NewI18nError(errors.New("container cannot be empty"), util.I18nErrorContainerRequired)
+	}
+	if strings.Contains(c.Container, "/") {
+		return util.NewI18nError(errors.New("container cannot contain /"), util.I18nErrorContainerRequired)
 	}
 	if c.KeyPrefix != "" {
 		if strings.HasPrefix(c.KeyPrefix, "/") {
@@ -545,19 +2330,11 @@ func (c *GCSFsConfig) validate() error {
 			c.KeyPrefix += "/"
 		}
 	}
-	if c.Credentials.IsEncrypted() && !c.Credentials.IsValid() {
-		return errors.New("invalid encrypted credentials")
-	}
-	if c.AutomaticCredentials == 0 && !c.Credentials.IsValidInput() {
-		return util.NewI18nError(errors.New("invalid credentials"), util.I18nErrorFsCredentialsRequired)
-	}
-	c.StorageClass = strings.TrimSpace(c.StorageClass)
-	c.ACL = strings.TrimSpace(c.ACL)
-	if c.UploadPartSize < 0 {
-		c.UploadPartSize = 0
-	}
-	if c.UploadPartMaxTime < 0 {
-		c.UploadPartMaxTime = 0
+	if c.UploadPartSize < 0 || c.UploadPartSize > 5120 {
+		return util.NewI18nError(
+			errors.New("upload_part_size cannot be lower than 0 or greater than 5120 (MB)"),
+			util.I18nErrorULPartSizeInvalid,
+		)
 	}
 	return nil
 }
@@ -570,6 +2347,87 @@ type AzBlobFsConfig struct {
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
 	// Shared access signature URL, leave blank if using account/key
 	SASURL *kms.Secret `json:"sas_url,omitempty"`
+	// HierarchicalNamespace enables the Data Lake Storage Gen2 endpoint for
+	// storage accounts with hierarchical namespace. Directories are renamed
+	// and removed atomically and the POSIX access control lists are preserved
+	HierarchicalNamespace bool `json:"hierarchical_namespace,omitempty"`
+	// AccessTierRules defines the access tier for files uploaded inside the
+	// matching directories. AccessTier is used if no rule matches
+	AccessTierRules []AzBlobAccessTierRule `json:"access_tier_rules,omitempty"`
+	// Priority for restoring archived blobs: Standard or High.
+	// Empty means Standard
+	RehydratePriority string `json:"rehydrate_priority,omitempty"`
+}
+
+// AzBlobAccessTierRule defines the access tier for the files uploaded inside
+// the directories matching Path. Path is relative to the filesystem root and
+// can be a shell pattern, for example "/archive" or "/*/reports"
+type AzBlobAccessTierRule struct {
+	Path       string `json:"path"`
+	AccessTier string `json:"access_tier"`
+}
+
+// getAccessTier returns the access tier for the specified file, name is
+// relative to the filesystem root. The deepest matching directory wins,
+// rules are evaluated in order for each directory
+func (c *AzBlobFsConfig) getAccessTier(name string) string {
+	if len(c.AccessTierRules) == 0 {
+		return c.AccessTier
+	}
+	dir := path.Dir(path.Clean("/" + name))
+	for {
+		for _, rule := range c.AccessTierRules {
+			if matched, _ := path.Match(rule.Path, dir); matched {
+				return rule.AccessTier
+			}
+		}
+		if dir == "/" {
+			return c.AccessTier
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func (c *AzBlobFsConfig) areAccessTierRulesEqual(other AzBlobFsConfig) bool {
+	if len(c.AccessTierRules) != len(other.AccessTierRules) {
+		return false
+	}
+	for idx, rule := range c.AccessTierRules {
+		if rule != other.AccessTierRules[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *AzBlobFsConfig) checkAccessTiers() error {
+	if !util.Contains(validAzAccessTier, c.AccessTier) {
+		return fmt.Errorf("invalid access tier %q, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
+	}
+	paths := make(map[string]bool)
+	for idx := range c.AccessTierRules {
+		rule := &c.AccessTierRules[idx]
+		rule.Path = strings.TrimSpace(rule.Path)
+		if rule.Path == "" {
+			return errors.New("access tier rules: path cannot be empty")
+		}
+		rule.Path = path.Clean("/" + rule.Path)
+		if _, err := path.Match(rule.Path, "/"); err != nil {
+			return fmt.Errorf("access tier rules: invalid path %q: %w", rule.Path, err)
+		}
+		if paths[rule.Path] {
+			return fmt.Errorf("access tier rules: duplicated path %q", rule.Path)
+		}
+		paths[rule.Path] = true
+		if !util.Contains(validAzAccessTier, rule.AccessTier) {
+			return fmt.Errorf("access tier rules: invalid access tier %q for path %q", rule.AccessTier, rule.Path)
+		}
+	}
+	if !util.Contains(validAzRehydratePrio, c.RehydratePriority) {
+		return fmt.Errorf("invalid rehydrate priority %q, valid values: \"''%v\"", c.RehydratePriority,
+			strings.Join(validAzRehydratePrio, ", "))
+	}
+	return nil
 }
 
 // HideConfidentialData hides confidential data
@@ -622,6 +2480,15 @@ func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
 	if c.AccessTier != other.AccessTier {
 		return false
 	}
+	if c.HierarchicalNamespace != other.HierarchicalNamespace {
+		return false
+	}
+	if c.RehydratePriority != other.RehydratePriority {
+		return false
+	}
+	if !c.areAccessTierRulesEqual(other) {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -764,10 +2631,7 @@ func (c *AzBlobFsConfig) validate() error {
 	if err := c.checkPartSizeAndConcurrency(); err != nil {
 		return err
 	}
-	if !util.Contains(validAzAccessTier, c.AccessTier) {
-		return fmt.Errorf("invalid access tier %q, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
-	}
-	return nil
+	return c.checkAccessTiers()
 }
 
 // CryptFsConfig defines the configuration to store local files as encrypted
@@ -836,8 +2700,10 @@ func (c *CryptFsConfig) validate() error {
 // pipeWriter defines a wrapper for pipeat.PipeWriterAt.
 type pipeWriter struct {
 	*pipeat.PipeWriterAt
-	err  error
-	done chan bool
+	err      error
+	done     chan bool
+	mu       sync.RWMutex
+	metadata map[string]string
 }
 
 // NewPipeWriter initializes a new PipeWriter
@@ -863,6 +2729,30 @@ func (p *pipeWriter) Done(err error) {
 	p.done <- true
 }
 
+// setMetadata sets the metadata to report for the uploaded file,
+// it must be called before Done
+func (p *pipeWriter) setMetadata(value map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metadata = value
+}
+
+// Metadata implements the Metadater interface
+func (p *pipeWriter) Metadata() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.metadata) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	for k, v := range p.metadata {
+		result[k] = v
+	}
+	return result
+}
+
 func newPipeWriterAtOffset(w *pipeat.PipeWriterAt, offset int64) PipeWriter {
 	return &pipeWriterAtOffset{
 		pipeWriter: &pipeWriter{
@@ -981,6 +2871,45 @@ func IsHTTPFs(fs Fs) bool {
 	return strings.HasPrefix(fs.Name(), httpFsName)
 }
 
+// GetBackendName returns a short name for the storage backend of the given filesystem
+func GetBackendName(fs Fs) string {
+	name := fs.Name()
+	switch {
+	case name == osFsName:
+		return "local"
+	case name == cryptFsName:
+		return "crypt"
+	case strings.HasPrefix(name, s3fsName):
+		return "s3"
+	case strings.HasPrefix(name, gcsfsName):
+		return "gcs"
+	case strings.HasPrefix(name, azBlobFsName):
+		return "azblob"
+	case strings.HasPrefix(name, b2fsName):
+		return "b2"
+	case strings.HasPrefix(name, dropboxFsName):
+		return "dropbox"
+	case strings.HasPrefix(name, gdriveFsName):
+		return "gdrive"
+	case strings.HasPrefix(name, oneDriveFsName):
+		return "onedrive"
+	case strings.HasPrefix(name, webDAVFsName):
+		return "webdav"
+	case strings.HasPrefix(name, smbFsName):
+		return "smb"
+	case strings.HasPrefix(name, hdfsFsName):
+		return "hdfs"
+	case strings.HasPrefix(name, swiftFsName):
+		return "swift"
+	case strings.HasPrefix(name, sftpFsName):
+		return "sftp"
+	case strings.HasPrefix(name, httpFsName):
+		return "http"
+	default:
+		return "unknown"
+	}
+}
+
 // IsBufferedLocalOrSFTPFs returns true if this is a buffered SFTP or local filesystem
 func IsBufferedLocalOrSFTPFs(fs Fs) bool {
 	if osFs, ok := fs.(*OsFs); ok {
@@ -1024,6 +2953,9 @@ func IsRenameAtomic(fs Fs) bool {
 	if strings.HasPrefix(fs.Name(), azBlobFsName) {
 		return false
 	}
+	if strings.HasPrefix(fs.Name(), b2fsName) {
+		return false
+	}
 	return true
 }
 
@@ -1038,6 +2970,18 @@ func HasImplicitAtomicUploads(fs Fs) bool {
 	if strings.HasPrefix(fs.Name(), azBlobFsName) {
 		return uploadMode&16 == 0
 	}
+	if strings.HasPrefix(fs.Name(), b2fsName) {
+		return true
+	}
+	if strings.HasPrefix(fs.Name(), dropboxFsName) {
+		return true
+	}
+	if strings.HasPrefix(fs.Name(), gdriveFsName) {
+		return true
+	}
+	if strings.HasPrefix(fs.Name(), oneDriveFsName) {
+		return true
+	}
 	return false
 }
 
@@ -1082,6 +3026,17 @@ func IsUploadResumeSupported(fs Fs, size int64) bool {
 	return fs.IsConditionalUploadResumeSupported(size)
 }
 
+// IsXattrSupported returns true if the specified Fs supports extended attributes
+func IsXattrSupported(fs Fs) bool {
+	if _, ok := fs.(FsXattrer); !ok {
+		return false
+	}
+	if IsLocalOrCryptoFs(fs) {
+		return isOsXattrSupported
+	}
+	return true
+}
+
 func getLastModified(metadata map[string]string) int64 {
 	if val, ok := metadata[lastModifiedField]; ok {
 		lastModified, err := strconv.ParseInt(val, 10, 64)
@@ -1107,6 +3062,126 @@ func getAzureLastModified(metadata map[string]*string) int64 {
 	return 0
 }
 
+// GetETag returns the strong entity tag, derived from the file contents, for
+// the specified file info. An empty string is returned if not available
+func GetETag(info os.FileInfo) string {
+	if fi, ok := info.(interface{ ETag() string }); ok {
+		return fi.ETag()
+	}
+	return ""
+}
+
+func getContentHashETag(metadata map[string]string) string {
+	for k, v := range metadata {
+		if strings.ToLower(k) == contentHashField && v != "" {
+			return `"` + v + `"`
+		}
+	}
+	return ""
+}
+
+func getAzureContentHashETag(metadata map[string]*string) string {
+	for k, v := range metadata {
+		if strings.ToLower(k) == contentHashField {
+			if val := util.GetStringFromPointer(v); val != "" {
+				return `"` + val + `"`
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// getS3ETag returns the entity tag computed by S3 at upload time if it is the
+// MD5 of the object contents. Multipart uploads have an entity tag such as
+// "<hex>-<parts>" that depends on the part size, not only on the contents,
+// so it is not used
+func getS3ETag(etag *string) string {
+	val := strings.Trim(util.GetStringFromPointer(etag), `"`)
+	if len(val) != hex.EncodedLen(md5.Size) {
+		return ""
+	}
+	if _, err := hex.DecodeString(val); err != nil {
+		return ""
+	}
+	return `"` + strings.ToLower(val) + `"`
+}
+
+// getXattrMetadataKey returns the object metadata key for the specified
+// extended attribute. Metadata keys are case insensitive for some backends
+// and must be valid identifiers for Azure, so the name is hex encoded
+func getXattrMetadataKey(attr string) string {
+	return xattrMetadataPrefix + hex.EncodeToString([]byte(attr))
+}
+
+func getXattrFromMetadataKey(key string) (string, bool) {
+	key = strings.ToLower(key)
+	if !strings.HasPrefix(key, xattrMetadataPrefix) {
+		return "", false
+	}
+	attr, err := hex.DecodeString(strings.TrimPrefix(key, xattrMetadataPrefix))
+	if err != nil || len(attr) == 0 {
+		return "", false
+	}
+	return string(attr), true
+}
+
+// findXattrMetadataKey returns the metadata key, as stored, for the specified
+// extended attribute
+func findXattrMetadataKey(metadata map[string]string, attr string) (string, bool) {
+	for k := range metadata {
+		if name, ok := getXattrFromMetadataKey(k); ok && name == attr {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func getXattrFromMetadata(metadata map[string]string, attr string) ([]byte, error) {
+	key, ok := findXattrMetadataKey(metadata, attr)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrXattrNotFound, attr)
+	}
+	return base64.StdEncoding.DecodeString(metadata[key])
+}
+
+func listXattrFromMetadata(metadata map[string]string) []string {
+	var result []string
+	for k := range metadata {
+		if name, ok := getXattrFromMetadataKey(k); ok {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// setXattrInMetadata adds or replaces the specified extended attribute
+func setXattrInMetadata(metadata map[string]string, attr string, value []byte) {
+	if key, ok := findXattrMetadataKey(metadata, attr); ok {
+		delete(metadata, key)
+	}
+	metadata[getXattrMetadataKey(attr)] = base64.StdEncoding.EncodeToString(value)
+}
+
+// removeXattrFromMetadata removes the specified extended attribute
+func removeXattrFromMetadata(metadata map[string]string, attr string) error {
+	key, ok := findXattrMetadataKey(metadata, attr)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrXattrNotFound, attr)
+	}
+	delete(metadata, key)
+	return nil
+}
+
+func getAzureMetadata(metadata map[string]*string) map[string]string {
+	result := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		result[k] = util.GetStringFromPointer(v)
+	}
+	return result
+}
+
 func validateOSFsConfig(config *sdk.OSFsConfig) error {
 	if config.ReadBufferSize < 0 || config.ReadBufferSize > 10 {
 		return fmt.Errorf("invalid read buffer size must be between 0 and 10 MB")
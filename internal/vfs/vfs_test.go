@@ -0,0 +1,73 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// parseTestHTTPRange parses a single range in the "bytes=start-end" form
+func parseTestHTTPRange(val string, size int64) (int64, int64, bool) {
+	val, ok := strings.CutPrefix(val, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	startVal, endVal, ok := strings.Cut(val, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startVal, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endVal != "" {
+		end, err = strconv.ParseInt(endVal, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func listTestDir(t *testing.T, fs Fs, name string) []os.FileInfo {
+	lister, err := fs.ReadDir(name)
+	require.NoError(t, err)
+	defer lister.Close()
+
+	var result []os.FileInfo
+	for {
+		entries, err := lister.Next(100)
+		result = append(result, entries...)
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestIsXattrSupported(t *testing.T) {
+	require.Equal(t, isOsXattrSupported, IsXattrSupported(NewOsFs("", os.TempDir(), "", nil)))
+	require.False(t, IsXattrSupported(&SFTPFs{}))
+}
@@ -0,0 +1,1154 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nowebdavfs
+// +build !nowebdavfs
+
+package vfs
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+const (
+	webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/>` +
+		`<d:getetag/><d:getcontenttype/></d:prop></d:propfind>`
+	webDAVQuotaBody = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop><d:quota-available-bytes/><d:quota-used-bytes/></d:prop></d:propfind>`
+	webDAVStatVFSBlockSize       = 4096
+	webDAVStatVFSMaxFilenameSize = 255
+)
+
+// WebDAVFs is a Fs implementation for remote WebDAV servers.
+type WebDAVFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath   string
+	config      *WebDAVFsConfig
+	endpointURL *url.URL
+	client      *http.Client
+	digest      *webDAVDigestAuth
+	ctxTimeout  time.Duration
+}
+
+func init() {
+	version.AddFeature("+webdavfs")
+}
+
+// NewWebDAVFs returns a WebDAVFs object that allows to interact with a remote WebDAV server
+func NewWebDAVFs(connectionID, localTempDir, mountPath string, config WebDAVFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = getLocalTempDir()
+	}
+
+	fs := &WebDAVFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		ctxTimeout:   30 * time.Second,
+	}
+	if err := fs.config.validate(); err != nil {
+		return fs, err
+	}
+	if !fs.config.Password.IsEmpty() {
+		if err := fs.config.Password.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	if !fs.config.BearerToken.IsEmpty() {
+		if err := fs.config.BearerToken.TryDecrypt(); err != nil {
+			return fs, err
+		}
+	}
+	endpointURL, err := url.Parse(fs.config.Endpoint)
+	if err != nil {
+		return fs, err
+	}
+	fs.endpointURL = endpointURL
+	if fs.config.AuthMethod == WebDAVAuthDigest {
+		fs.digest = &webDAVDigestAuth{
+			username: fs.config.Username,
+			password: fs.config.Password.GetPayload(),
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if fs.config.SkipTLSVerify {
+		transport.TLSClientConfig = getInsecureTLSConfig()
+	}
+	fs.client = &http.Client{
+		Transport: transport,
+		// WebDAV methods cannot be redirected, the HTTP client would
+		// convert them to GET requests, so we check the original method
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if via[0].Method != http.MethodGet || len(via) >= 10 {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *WebDAVFs) Name() string {
+	return fmt.Sprintf("%s %q", webDAVFsName, fs.config.Endpoint)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *WebDAVFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *WebDAVFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	prop, err := fs.getProps(ctx, name, webDAVPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+	return prop.getFileInfo(path.Base(name)), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *WebDAVFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *WebDAVFs) Open(name string, offset int64) (File, PipeReader, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeReader(r)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		resp, err := fs.download(ctx, name, offset)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			return
+		}
+		defer resp.Body.Close()
+
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *WebDAVFs) Create(name string, _, checks int) (File, PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if checks&CheckResume != 0 {
+		return nil, nil, nil, fmt.Errorf("%w: unable to resume %q", ErrVfsUnsupported, name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		n, err := fs.upload(ctx, name, r)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d, err: %+v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are moved server side including their contents
+func (fs *WebDAVFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	err := fs.relocate(ctx, "MOVE", source, target, false)
+	var apiErr *webDAVError
+	if !errors.As(err, &apiErr) || apiErr.statusCode != http.StatusPreconditionFailed {
+		return -1, -1, err
+	}
+	// the target exists, we only replace files
+	info, errStat := fs.Stat(target)
+	if errStat != nil || info.IsDir() {
+		return -1, -1, err
+	}
+	fsLog(fs, logger.LevelDebug, "target %q exists, remove it before retrying the rename", target)
+	if err := fs.Remove(target, false); err != nil {
+		return -1, -1, err
+	}
+	return -1, -1, fs.relocate(ctx, "MOVE", source, target, false)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *WebDAVFs) Remove(name string, isDir bool) error {
+	if isDir {
+		// collections are removed recursively
+		lister, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		files, err := lister.Next(1)
+		lister.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	req, err := fs.newRequest(ctx, http.MethodDelete, name, isDir, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *WebDAVFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	req, err := fs.newRequest(ctx, "MKCOL", name, true, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*WebDAVFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*WebDAVFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*WebDAVFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*WebDAVFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time is a live property and WebDAV servers usually
+// don't allow to change it
+func (*WebDAVFs) Chtimes(_ string, _, _ time.Time, isUploading bool) error {
+	if isUploading {
+		return nil
+	}
+	return ErrVfsUnsupported
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base transfer
+func (*WebDAVFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+// The PROPFIND response is decoded while iterating the entries
+func (fs *WebDAVFs) ReadDir(dirname string) (DirLister, error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	req, err := fs.newPropfindRequest(ctx, dirname, true, "1", webDAVPropfindBody)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	resp, err := fs.do(req, http.StatusMultiStatus)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	return &webDAVDirLister{
+		decoder:  xml.NewDecoder(resp.Body),
+		body:     resp.Body,
+		cancelFn: cancelFn,
+		selfPath: path.Clean(req.URL.Path),
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported on WebDAV
+func (*WebDAVFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsConditionalUploadResumeSupported returns if resuming uploads is supported
+// for the specified size
+func (*WebDAVFs) IsConditionalUploadResumeSupported(_ int64) bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*WebDAVFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*WebDAVFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	var apiErr *webDAVError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*WebDAVFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+	var apiErr *webDAVError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusUnauthorized || apiErr.statusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*WebDAVFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrVfsUnsupported) {
+		return true
+	}
+	var apiErr *webDAVError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode == http.StatusNotImplemented
+	}
+	return false
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *WebDAVFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "", nil)
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *WebDAVFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize("/")
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders.
+// Depth infinity requests are disabled on most servers, so the folders
+// are listed one by one
+func (fs *WebDAVFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	dirs := []string{dirname}
+
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		lister, err := fs.ReadDir(dir)
+		if err != nil {
+			return numFiles, size, err
+		}
+		for {
+			files, err := lister.Next(ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				lister.Close()
+				return numFiles, size, err
+			}
+			for _, fi := range files {
+				if fi.IsDir() {
+					dirs = append(dirs, path.Join(dir, fi.Name()))
+				} else {
+					numFiles++
+					size += fi.Size()
+				}
+			}
+			if finished {
+				break
+			}
+		}
+		lister.Close()
+		fsLog(fs, logger.LevelDebug, "scan in progress for %q, files: %d, size: %d", dirname, numFiles, size)
+	}
+	return numFiles, size, nil
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+func (*WebDAVFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *WebDAVFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *WebDAVFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*WebDAVFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*WebDAVFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *WebDAVFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return virtualPath, nil
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *WebDAVFs) CopyFile(source, target string, srcSize int64) (int, int64, error) {
+	numFiles := 1
+	sizeDiff := srcSize
+	info, err := fs.Stat(target)
+	if err == nil {
+		sizeDiff -= info.Size()
+		numFiles = 0
+	} else if !fs.IsNotExist(err) {
+		return 0, 0, err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	if err := fs.relocate(ctx, "COPY", source, target, true); err != nil {
+		return 0, 0, err
+	}
+	return numFiles, sizeDiff, nil
+}
+
+// GetMimeType returns the content type
+func (fs *WebDAVFs) GetMimeType(name string) (string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	prop, err := fs.getProps(ctx, name, webDAVPropfindBody)
+	if err != nil {
+		return "", err
+	}
+	if prop.ContentType != "" {
+		return prop.ContentType, nil
+	}
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// Close closes the fs
+func (fs *WebDAVFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path.
+// The quota properties defined in RFC 4331 are used
+func (fs *WebDAVFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	prop, err := fs.getProps(ctx, dirName, webDAVQuotaBody)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// the quota properties are not supported
+			return nil, ErrStorageSizeUnavailable
+		}
+		return nil, err
+	}
+	available, err := strconv.ParseInt(strings.TrimSpace(prop.QuotaAvailable), 10, 64)
+	if err != nil || available < 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	used, err := strconv.ParseInt(strings.TrimSpace(prop.QuotaUsed), 10, 64)
+	if err != nil || used < 0 {
+		used = 0
+	}
+	return &sftp.StatVFS{
+		Bsize:   webDAVStatVFSBlockSize,
+		Frsize:  webDAVStatVFSBlockSize,
+		Blocks:  uint64(available+used) / webDAVStatVFSBlockSize,
+		Bfree:   uint64(available) / webDAVStatVFSBlockSize,
+		Bavail:  uint64(available) / webDAVStatVFSBlockSize,
+		Namemax: webDAVStatVFSMaxFilenameSize,
+	}, nil
+}
+
+// getURL returns the URL for the specified path, collections are
+// identified by a trailing slash
+func (fs *WebDAVFs) getURL(name string, isDir bool) string {
+	u := *fs.endpointURL
+	u.Path = path.Join("/", fs.endpointURL.Path, name)
+	if isDir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+func (fs *WebDAVFs) newRequest(ctx context.Context, method, name string, isDir bool, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, fs.getURL(name, isDir), body)
+}
+
+func (fs *WebDAVFs) newPropfindRequest(ctx context.Context, name string, isDir bool, depth, body string,
+) (*http.Request, error) {
+	req, err := fs.newRequest(ctx, "PROPFIND", name, isDir, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	return req, nil
+}
+
+// getProps returns the properties for the specified path. We don't know if
+// the path is a collection, if the server redirects to the collection URL
+// the request is sent again
+func (fs *WebDAVFs) getProps(ctx context.Context, name, body string) (*webDAVProp, error) {
+	req, err := fs.newPropfindRequest(ctx, name, false, "0", body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.do(req, http.StatusMultiStatus)
+	var apiErr *webDAVError
+	if errors.As(err, &apiErr) && apiErr.isRedirect() {
+		req, err = fs.newPropfindRequest(ctx, name, true, "0", body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = fs.do(req, http.StatusMultiStatus)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms webDAVMultistatus
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxHTTPFsResponseSize)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("unable to decode PROPFIND response: %w", err)
+	}
+	for idx := range ms.Responses {
+		if prop := ms.Responses[idx].getProp(); prop != nil {
+			return prop, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no properties returned for %q", os.ErrNotExist, name)
+}
+
+// upload sends the data using a single PUT request. The data is streamed
+// using chunked transfer encoding or buffered in a local temporary file, if
+// configured, so the content length is known
+func (fs *WebDAVFs) upload(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if err := fs.ensureDigestChallenge(ctx); err != nil {
+		return 0, err
+	}
+	if !fs.config.BufferUploads {
+		cr := &webDAVCountingReader{r: r}
+		req, err := fs.newRequest(ctx, http.MethodPut, name, false, cr)
+		if err != nil {
+			return 0, err
+		}
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := fs.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		if err != nil {
+			return cr.n, err
+		}
+		return cr.n, resp.Body.Close()
+	}
+
+	f, err := os.CreateTemp(fs.localTempDir, "webdav_upload_")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return size, err
+	}
+	req, err := fs.newRequest(ctx, http.MethodPut, name, false, io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return size, err
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(f, 0, size)), nil
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := fs.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return size, err
+	}
+	return size, resp.Body.Close()
+}
+
+func (fs *WebDAVFs) download(ctx context.Context, name string, offset int64) (*http.Response, error) {
+	req, err := fs.newRequest(ctx, http.MethodGet, name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := fs.do(req, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode == http.StatusOK {
+		// range requests are not supported, skip the initial data
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			if errors.Is(err, io.EOF) {
+				// the pipe would report a truncated download as a successful one
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// relocate sends a MOVE or COPY request
+func (fs *WebDAVFs) relocate(ctx context.Context, method, source, target string, overwrite bool) error {
+	req, err := fs.newRequest(ctx, method, source, false, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", fs.getURL(target, false))
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+	resp, err := fs.do(req, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ensureDigestChallenge gets a digest challenge, if required, before sending
+// requests with a body that cannot be sent again
+func (fs *WebDAVFs) ensureDigestChallenge(ctx context.Context) error {
+	if fs.digest == nil || fs.digest.hasChallenge() {
+		return nil
+	}
+	_, err := fs.getProps(ctx, "/", webDAVPropfindBody)
+	return err
+}
+
+func (fs *WebDAVFs) authorize(req *http.Request) {
+	switch fs.config.AuthMethod {
+	case WebDAVAuthBasic:
+		if fs.config.Username != "" || fs.config.Password.GetPayload() != "" {
+			req.SetBasicAuth(fs.config.Username, fs.config.Password.GetPayload())
+		}
+	case WebDAVAuthDigest:
+		fs.digest.authorize(req)
+	case WebDAVAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+fs.config.BearerToken.GetPayload())
+	}
+}
+
+// do sends the request and returns an error if the response status code
+// is not one of the expected ones. If a new digest challenge is received
+// the request is sent again, if the body can be replayed
+func (fs *WebDAVFs) do(req *http.Request, expectedCodes ...int) (*http.Response, error) {
+	fs.authorize(req)
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send WebDAV request %s %q: %w", req.Method, req.URL.Path, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && fs.digest != nil && fs.digest.update(resp) &&
+		(req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		resp.Body.Close()
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+		fs.authorize(retry)
+		resp, err = fs.client.Do(retry)
+		if err != nil {
+			return nil, fmt.Errorf("unable to send WebDAV request %s %q: %w", req.Method, req.URL.Path, err)
+		}
+	}
+	for _, code := range expectedCodes {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, &webDAVError{
+		method:     req.Method,
+		path:       req.URL.Path,
+		statusCode: resp.StatusCode,
+	}
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *WebDAVFs) walk(filePath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		if err == nil {
+			lister.Close()
+		}
+		return err1
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, fi := range files {
+			objName := path.Join(filePath, fi.Name())
+			err = fs.walk(objName, fi, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+type webDAVError struct {
+	method     string
+	path       string
+	statusCode int
+}
+
+func (e *webDAVError) Error() string {
+	return fmt.Sprintf("WebDAV %s request for %q failed, status code: %d", e.method, e.path, e.statusCode)
+}
+
+func (e *webDAVError) isRedirect() bool {
+	switch e.statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// webDAVDigestAuth implements the digest access authentication as defined
+// in RFC 7616. The challenge is shared between the requests, the nonce count
+// is incremented for each request
+type webDAVDigestAuth struct {
+	username  string
+	password  string
+	mu        sync.Mutex
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        uint32
+}
+
+func (a *webDAVDigestAuth) hasChallenge() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.nonce != ""
+}
+
+// update parses the digest challenge from the response, it returns false
+// if no valid challenge is found
+func (a *webDAVDigestAuth) update(resp *http.Response) bool {
+	for _, val := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(val), " ")
+		if !strings.EqualFold(scheme, "digest") {
+			continue
+		}
+		challenge := parseWebDAVAuthParams(params)
+		if challenge["nonce"] == "" {
+			continue
+		}
+		algorithm := strings.ToUpper(challenge["algorithm"])
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+		if getWebDAVDigestHash(algorithm) == nil {
+			continue
+		}
+		qop := ""
+		for _, v := range strings.Split(challenge["qop"], ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		a.mu.Lock()
+		a.realm = challenge["realm"]
+		a.nonce = challenge["nonce"]
+		a.opaque = challenge["opaque"]
+		a.algorithm = algorithm
+		a.qop = qop
+		a.nc = 0
+		a.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+func (a *webDAVDigestAuth) authorize(req *http.Request) {
+	a.mu.Lock()
+	if a.nonce == "" {
+		a.mu.Unlock()
+		return
+	}
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+	realm, nonce, opaque, algorithm, qop := a.realm, a.nonce, a.opaque, a.algorithm, a.qop
+	a.mu.Unlock()
+
+	h := getWebDAVDigestHash(algorithm)
+	cnonce := getWebDAVCnonce()
+	uri := req.URL.RequestURI()
+	ha1 := h(a.username + ":" + realm + ":" + a.password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`,
+		a.username, realm, nonce, uri, algorithm, response)
+	if opaque != "" {
+		fmt.Fprintf(&sb, `, opaque=%q`, opaque)
+	}
+	if qop != "" {
+		fmt.Fprintf(&sb, `, qop=%s, nc=%s, cnonce=%q`, qop, nc, cnonce)
+	}
+	req.Header.Set("Authorization", sb.String())
+}
+
+func getWebDAVDigestHash(algorithm string) func(string) string {
+	var newHash func() hash.Hash
+	switch algorithm {
+	case "MD5", "MD5-SESS":
+		newHash = md5.New
+	case "SHA-256", "SHA-256-SESS":
+		newHash = sha256.New
+	default:
+		return nil
+	}
+	return func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+func getWebDAVCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b) //nolint:errcheck
+	return hex.EncodeToString(b)
+}
+
+// parseWebDAVAuthParams parses a comma separated list of auth parameters,
+// values can be quoted strings
+func parseWebDAVAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+		if strings.HasPrefix(rest, `"`) {
+			var sb strings.Builder
+			idx := 1
+			for ; idx < len(rest) && rest[idx] != '"'; idx++ {
+				if rest[idx] == '\\' && idx+1 < len(rest) {
+					idx++
+				}
+				sb.WriteByte(rest[idx])
+			}
+			params[key] = sb.String()
+			if idx < len(rest) {
+				idx++
+			}
+			s = rest[idx:]
+		} else {
+			var value string
+			value, s, _ = strings.Cut(rest, ",")
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+}
+
+// webDAVCountingReader counts the bytes read from the wrapped reader
+type webDAVCountingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *webDAVCountingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type webDAVMultistatus struct {
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+// getProp returns the properties with a success status, if any
+func (r *webDAVResponse) getProp() *webDAVProp {
+	for idx := range r.Propstats {
+		fields := strings.Fields(r.Propstats[idx].Status)
+		if len(fields) >= 2 && fields[1] == "200" {
+			return &r.Propstats[idx].Prop
+		}
+	}
+	return nil
+}
+
+type webDAVPropstat struct {
+	Prop   webDAVProp `xml:"DAV: prop"`
+	Status string     `xml:"DAV: status"`
+}
+
+type webDAVProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength  string `xml:"DAV: getcontentlength"`
+	LastModified   string `xml:"DAV: getlastmodified"`
+	ETag           string `xml:"DAV: getetag"`
+	ContentType    string `xml:"DAV: getcontenttype"`
+	QuotaAvailable string `xml:"DAV: quota-available-bytes"`
+	QuotaUsed      string `xml:"DAV: quota-used-bytes"`
+}
+
+func (p *webDAVProp) getFileInfo(name string) *FileInfo {
+	modTime, err := http.ParseTime(strings.TrimSpace(p.LastModified))
+	if err != nil {
+		modTime = time.Unix(0, 0)
+	}
+	if p.ResourceType.Collection != nil {
+		return NewFileInfo(name, true, 0, modTime, false)
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(p.ContentLength), 10, 64)
+	info := NewFileInfo(name, false, size, modTime, false)
+	if etag := strings.TrimSpace(p.ETag); etag != "" {
+		info.SetETag(etag)
+	}
+	return info
+}
+
+// webDAVDirLister decodes the PROPFIND response entries on demand
+type webDAVDirLister struct {
+	baseDirLister
+	decoder  *xml.Decoder
+	body     io.ReadCloser
+	cancelFn context.CancelFunc
+	selfPath string
+	finished bool
+}
+
+// nextEntry returns the next entry from the multistatus response, the
+// requested collection itself is skipped
+func (l *webDAVDirLister) nextEntry() (os.FileInfo, error) {
+	for {
+		token, err := l.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		se, ok := token.(xml.StartElement)
+		if !ok || se.Name.Space != "DAV:" || se.Name.Local != "response" {
+			continue
+		}
+		var resp webDAVResponse
+		if err := l.decoder.DecodeElement(&resp, &se); err != nil {
+			return nil, err
+		}
+		hrefURL, err := url.Parse(strings.TrimSpace(resp.Href))
+		if err != nil {
+			return nil, fmt.Errorf("invalid href %q: %w", resp.Href, err)
+		}
+		entryPath := path.Clean(hrefURL.Path)
+		if entryPath == l.selfPath {
+			continue
+		}
+		prop := resp.getProp()
+		if prop == nil {
+			continue
+		}
+		return prop.getFileInfo(path.Base(entryPath)), nil
+	}
+}
+
+func (l *webDAVDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for len(l.cache) < limit && !l.finished {
+		info, err := l.nextEntry()
+		if errors.Is(err, io.EOF) {
+			l.finished = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.cache = append(l.cache, info)
+	}
+	if len(l.cache) >= limit || !l.finished {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
+
+func (l *webDAVDirLister) Close() error {
+	l.cancelFn()
+	l.body.Close()
+	return l.baseDirLister.Close()
+}
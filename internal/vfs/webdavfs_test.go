@@ -0,0 +1,671 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nowebdavfs
+// +build !nowebdavfs
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drakkan/webdav"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	fakeWebDAVPrefix   = "/dav"
+	fakeWebDAVUsername = "user"
+	fakeWebDAVPassword = "password"
+	fakeWebDAVToken    = "token"
+	fakeWebDAVRealm    = "sftpgo"
+)
+
+// fakeWebDAVServer wraps an in memory WebDAV handler and adds the
+// authentication, the quota properties and the server behaviors that
+// WebDAVFs must handle
+type fakeWebDAVServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	davFs   webdav.FileSystem
+	handler *webdav.Handler
+	ops     map[string]int
+	// the authentication method required for the requests
+	authMethod int
+	// the digest challenge parameters
+	nonce     string
+	algorithm string
+	// the quota properties are returned if not empty
+	quotaAvailable string
+	quotaUsed      string
+	// if set range requests are ignored and the whole file is returned
+	ignoreRange bool
+	// if set collections requested without the trailing slash are
+	// redirected, as some servers do
+	redirectCollections bool
+	// if set it is called for each authenticated request, a status code
+	// different from zero is returned as an error response without
+	// processing the request
+	onRequest func(op string, r *http.Request) int
+}
+
+func newFakeWebDAVServer(t *testing.T) *fakeWebDAVServer {
+	s := &fakeWebDAVServer{
+		davFs:     webdav.NewMemFS(),
+		ops:       make(map[string]int),
+		nonce:     "nonce1",
+		algorithm: "MD5",
+	}
+	s.handler = &webdav.Handler{
+		Prefix:     fakeWebDAVPrefix,
+		FileSystem: s.davFs,
+		LockSystem: webdav.NewMemLS(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeWebDAVServer) getOpCount(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ops[op]
+}
+
+func (s *fakeWebDAVServer) setOnRequest(fn func(op string, r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onRequest = fn
+}
+
+func (s *fakeWebDAVServer) update(fn func(s *fakeWebDAVServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+}
+
+func (s *fakeWebDAVServer) putFile(t *testing.T, name string, data []byte) {
+	ctx := context.Background()
+	dir := "/"
+	for _, elem := range strings.Split(strings.Trim(path.Dir(name), "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		dir = path.Join(dir, elem)
+		if err := s.davFs.Mkdir(ctx, dir, os.ModePerm); err != nil {
+			require.ErrorIs(t, err, os.ErrExist)
+		}
+	}
+	f, err := s.davFs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func (s *fakeWebDAVServer) getFile(name string) ([]byte, bool) {
+	f, err := s.davFs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	return data, err == nil
+}
+
+func (s *fakeWebDAVServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ops[r.Method]++
+	onRequest := s.onRequest
+	ignoreRange := s.ignoreRange
+	redirectCollections := s.redirectCollections
+	quotaAvailable := s.quotaAvailable
+	quotaUsed := s.quotaUsed
+	s.mu.Unlock()
+
+	if !s.checkAuth(w, r) {
+		return
+	}
+	if onRequest != nil {
+		if status := onRequest(r.Method, r); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	name := strings.TrimPrefix(r.URL.Path, fakeWebDAVPrefix)
+	switch r.Method {
+	case "MOVE", "COPY", http.MethodDelete:
+		// the in memory handler does not report missing sources as not found
+		if _, err := s.davFs.Stat(r.Context(), name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	case "PROPFIND":
+		if redirectCollections && !strings.HasSuffix(name, "/") {
+			info, err := s.davFs.Stat(r.Context(), name)
+			if err == nil && info.IsDir() {
+				http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+				return
+			}
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if quotaAvailable != "" && bytes.Contains(body, []byte("quota-available-bytes")) {
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:"><D:response>`+
+				`<D:href>%s</D:href><D:propstat><D:prop><D:quota-available-bytes>%s</D:quota-available-bytes>`+
+				`<D:quota-used-bytes>%s</D:quota-used-bytes></D:prop><D:status>HTTP/1.1 200 OK</D:status>`+
+				`</D:propstat></D:response></D:multistatus>`, r.URL.Path, quotaAvailable, quotaUsed)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	case http.MethodGet:
+		if ignoreRange {
+			r.Header.Del("Range")
+		}
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *fakeWebDAVServer) checkAuth(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.authMethod {
+	case WebDAVAuthDigest:
+		if s.checkDigestAuthLocked(r) {
+			return true
+		}
+		s.ops["challenge"]++
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm=%q, nonce=%q, opaque="opaque", algorithm=%s, qop="auth,auth-int"`,
+			fakeWebDAVRealm, s.nonce, s.algorithm))
+	case WebDAVAuthBearer:
+		if r.Header.Get("Authorization") == "Bearer "+fakeWebDAVToken {
+			return true
+		}
+	default:
+		username, password, ok := r.BasicAuth()
+		if ok && username == fakeWebDAVUsername && password == fakeWebDAVPassword {
+			return true
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return false
+}
+
+func (s *fakeWebDAVServer) checkDigestAuthLocked(r *http.Request) bool {
+	val, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Digest ")
+	if !ok {
+		return false
+	}
+	params := parseWebDAVAuthParams(val)
+	if params["username"] != fakeWebDAVUsername || params["realm"] != fakeWebDAVRealm || params["nonce"] != s.nonce ||
+		params["uri"] != r.URL.RequestURI() || params["opaque"] != "opaque" || params["qop"] != "auth" ||
+		params["algorithm"] != s.algorithm || params["nc"] == "" || params["cnonce"] == "" {
+		return false
+	}
+	h := func(v string) string {
+		if s.algorithm == "SHA-256" {
+			sum := sha256.Sum256([]byte(v))
+			return hex.EncodeToString(sum[:])
+		}
+		sum := md5.Sum([]byte(v))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := h(fakeWebDAVUsername + ":" + fakeWebDAVRealm + ":" + fakeWebDAVPassword)
+	ha2 := h(r.Method + ":" + params["uri"])
+	expected := h(ha1 + ":" + s.nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	return params["response"] == expected
+}
+
+func newTestWebDAVFs(t *testing.T, server *fakeWebDAVServer, config WebDAVFsConfig) *WebDAVFs {
+	config.Endpoint = server.URL + fakeWebDAVPrefix
+	if config.Username == "" {
+		config.Username = fakeWebDAVUsername
+		config.Password = kms.NewPlainSecret(fakeWebDAVPassword)
+	}
+	if config.AuthMethod == WebDAVAuthBearer && config.BearerToken == nil {
+		config.BearerToken = kms.NewPlainSecret(fakeWebDAVToken)
+	}
+	fs, err := NewWebDAVFs("connID", t.TempDir(), "", config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close()
+	})
+	return fs.(*WebDAVFs)
+}
+
+func uploadTestWebDAVFile(t *testing.T, fs *WebDAVFs, name string, data []byte) error {
+	_, w, _, err := fs.Create(name, 0, 0)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(data))
+	assert.NoError(t, err)
+	return w.Close()
+}
+
+func downloadTestWebDAVFile(t *testing.T, fs *WebDAVFs, name string, offset int64) ([]byte, error) {
+	_, r, _, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestWebDAVFsBasicOperations(t *testing.T) {
+	server := newFakeWebDAVServer(t)
+	fs := newTestWebDAVFs(t, server, WebDAVFsConfig{})
+
+	require.NoError(t, fs.Mkdir("/dir"))
+	require.NoError(t, uploadTestWebDAVFile(t, fs, "/dir/file.txt", []byte("content")))
+	data, ok := server.getFile("/dir/file.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(data))
+	assert.Equal(t, 1, server.getOpCount(http.MethodPut))
+	data, err := downloadTestWebDAVFile(t, fs, "/dir/file.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	data, err = downloadTestWebDAVFile(t, fs, "/dir/file.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ntent"), data)
+	// uploads can be buffered to send the content length
+	bufferedFs := newTestWebDAVFs(t, server, WebDAVFsConfig{
+		BufferUploads: true,
+	})
+	require.NoError(t, uploadTestWebDAVFile(t, bufferedFs, "/dir/file1.txt", []byte("buffered")))
+	data, ok = server.getFile("/dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "buffered", string(data))
+
+	info, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = fs.Stat("/dir/file.txt")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "file.txt", info.Name())
+	assert.Equal(t, int64(7), info.Size())
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	assert.NotEmpty(t, GetETag(info))
+	entries := listTestDir(t, fs, "/dir")
+	if assert.Len(t, entries, 2) {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		assert.Equal(t, "file.txt", entries[0].Name())
+		assert.Equal(t, int64(7), entries[0].Size())
+		assert.Equal(t, "file1.txt", entries[1].Name())
+		assert.Equal(t, int64(8), entries[1].Size())
+	}
+	mimeType, err := fs.GetMimeType("/dir/file.txt")
+	require.NoError(t, err)
+	assert.Contains(t, mimeType, "text/plain")
+
+	numFiles, sizeDiff, err := fs.CopyFile("/dir/file.txt", "/dir/file2.txt", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(7), sizeDiff)
+	// copying over an existing file replaces it
+	numFiles, sizeDiff, err = fs.CopyFile("/dir/file.txt", "/dir/file1.txt", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numFiles)
+	assert.Equal(t, int64(-1), sizeDiff)
+	data, ok = server.getFile("/dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "content", string(data))
+	assert.Equal(t, 2, server.getOpCount("COPY"))
+
+	numFiles, size, err := fs.Rename("/dir/file2.txt", "/dir/file3.txt")
+	require.NoError(t, err)
+	assert.Equal(t, -1, numFiles)
+	assert.Equal(t, int64(-1), size)
+	_, ok = server.getFile("/dir/file2.txt")
+	assert.False(t, ok)
+	server.putFile(t, "/dir/file3.txt", []byte("renamed"))
+	// an existing target file is replaced, the first attempt fails
+	// because overwriting is not allowed
+	moveOps := server.getOpCount("MOVE")
+	deleteOps := server.getOpCount(http.MethodDelete)
+	_, _, err = fs.Rename("/dir/file3.txt", "/dir/file1.txt")
+	require.NoError(t, err)
+	assert.Equal(t, moveOps+2, server.getOpCount("MOVE"))
+	assert.Equal(t, deleteOps+1, server.getOpCount(http.MethodDelete))
+	data, ok = server.getFile("/dir/file1.txt")
+	require.True(t, ok)
+	assert.Equal(t, "renamed", string(data))
+	_, ok = server.getFile("/dir/file3.txt")
+	assert.False(t, ok)
+	// existing directories are never replaced
+	require.NoError(t, fs.Mkdir("/dir1"))
+	_, _, err = fs.Rename("/dir", "/dir1")
+	assert.Error(t, err)
+	_, ok = server.getFile("/dir/file.txt")
+	assert.True(t, ok)
+	// directories are moved including their contents
+	_, _, err = fs.Rename("/dir", "/dir2")
+	require.NoError(t, err)
+	assert.Len(t, listTestDir(t, fs, "/dir2"), 2)
+	_, err = fs.Stat("/dir")
+	assert.True(t, fs.IsNotExist(err))
+
+	server.putFile(t, "/dir2/sub/file", []byte("data"))
+	numFiles, size, err = fs.GetDirSize("/dir2")
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(18), size)
+	numFiles, size, err = fs.ScanRootDirContents()
+	require.NoError(t, err)
+	assert.Equal(t, 3, numFiles)
+	assert.Equal(t, int64(18), size)
+	var walked []string
+	err = fs.Walk("/", func(walkedPath string, _ os.FileInfo, err error) error {
+		walked = append(walked, walkedPath)
+		return err
+	})
+	require.NoError(t, err)
+	sort.Strings(walked)
+	assert.Equal(t, []string{"/", "/dir1", "/dir2", "/dir2/file.txt", "/dir2/file1.txt", "/dir2/sub", "/dir2/sub/file"},
+		walked)
+
+	err = fs.Remove("/dir2/sub", true)
+	assert.ErrorContains(t, err, "non empty")
+	require.NoError(t, fs.Remove("/dir2/sub/file", false))
+	require.NoError(t, fs.Remove("/dir2/sub", true))
+	_, err = fs.Stat("/dir2/sub")
+	assert.True(t, fs.IsNotExist(err))
+
+	assert.ErrorIs(t, fs.Chtimes("/dir2/file.txt", time.Now(), time.Now(), false), ErrVfsUnsupported)
+	assert.NoError(t, fs.Chtimes("/dir2/file.txt", time.Now(), time.Now(), true))
+	// the quota properties are optional
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.ErrorIs(t, err, ErrStorageSizeUnavailable)
+	server.update(func(s *fakeWebDAVServer) {
+		s.quotaAvailable = "8192"
+		s.quotaUsed = "4096"
+	})
+	statVFS, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), statVFS.Bfree)
+	assert.Equal(t, uint64(3), statVFS.Blocks)
+}
+
+func TestWebDAVFsReadDirPagination(t *testing.T) {
+	server := newFakeWebDAVServer(t)
+	fs := newTestWebDAVFs(t, server, WebDAVFsConfig{})
+
+	var expected []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("file%02d", i)
+		server.putFile(t, path.Join("/dir", name), []byte(name))
+		expected = append(expected, name)
+	}
+	// names are escaped in the requests and in the returned hrefs
+	server.putFile(t, "/dir/sub dir %/file #1", []byte("data"))
+	expected = append(expected, "sub dir %")
+	sort.Strings(expected)
+
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	defer lister.Close()
+
+	_, err = lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidDirListerLimit)
+	var names []string
+	for _, expectedLen := range []int{10, 10, 6} {
+		entries, err := lister.Next(10)
+		if expectedLen == 6 {
+			assert.ErrorIs(t, err, io.EOF)
+		} else {
+			assert.NoError(t, err)
+		}
+		require.Len(t, entries, expectedLen)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+	assert.Equal(t, 1, server.getOpCount("PROPFIND"))
+	sort.Strings(names)
+	assert.Equal(t, expected, names)
+
+	entries := listTestDir(t, fs, "/dir/sub dir %")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file #1", entries[0].Name())
+		assert.Equal(t, int64(4), entries[0].Size())
+	}
+	data, err := downloadTestWebDAVFile(t, fs, "/dir/sub dir %/file #1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	// collections are requested again with a trailing slash if redirected
+	server.update(func(s *fakeWebDAVServer) {
+		s.redirectCollections = true
+	})
+	propfindOps := server.getOpCount("PROPFIND")
+	info, err := fs.Stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, "dir", info.Name())
+	assert.Equal(t, propfindOps+2, server.getOpCount("PROPFIND"))
+	info, err = fs.Stat("/dir/file00")
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, propfindOps+3, server.getOpCount("PROPFIND"))
+	assert.Len(t, listTestDir(t, fs, "/dir"), 26)
+}
+
+func TestWebDAVFsAuth(t *testing.T) {
+	server := newFakeWebDAVServer(t)
+	server.putFile(t, "/file", []byte("data"))
+
+	fs := newTestWebDAVFs(t, server, WebDAVFsConfig{
+		Username: fakeWebDAVUsername,
+		Password: kms.NewPlainSecret("wrong"),
+	})
+	_, err := fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+
+	server.update(func(s *fakeWebDAVServer) {
+		s.authMethod = WebDAVAuthBearer
+	})
+	fs = newTestWebDAVFs(t, server, WebDAVFsConfig{
+		AuthMethod: WebDAVAuthBearer,
+	})
+	info, err := fs.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	fs = newTestWebDAVFs(t, server, WebDAVFsConfig{
+		AuthMethod:  WebDAVAuthBearer,
+		BearerToken: kms.NewPlainSecret("invalid"),
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+
+	server.update(func(s *fakeWebDAVServer) {
+		s.authMethod = WebDAVAuthDigest
+		s.algorithm = "SHA-256"
+	})
+	fs = newTestWebDAVFs(t, server, WebDAVFsConfig{
+		AuthMethod: WebDAVAuthDigest,
+	})
+	// the challenge is requested before streaming the upload
+	require.NoError(t, uploadTestWebDAVFile(t, fs, "/file1", []byte("digest")))
+	assert.Equal(t, 1, server.getOpCount("challenge"))
+	data, ok := server.getFile("/file1")
+	require.True(t, ok)
+	assert.Equal(t, "digest", string(data))
+	data, err = downloadTestWebDAVFile(t, fs, "/file1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("digest"), data)
+	assert.Equal(t, 1, server.getOpCount("challenge"))
+	// requests without a body are sent again if the nonce changes
+	server.update(func(s *fakeWebDAVServer) {
+		s.nonce = "nonce2"
+	})
+	info, err = fs.Stat("/file1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size())
+	assert.Equal(t, 2, server.getOpCount("challenge"))
+	// buffered uploads can be sent again too
+	bufferedFs := newTestWebDAVFs(t, server, WebDAVFsConfig{
+		AuthMethod:    WebDAVAuthDigest,
+		BufferUploads: true,
+	})
+	_, err = bufferedFs.Stat("/file1")
+	require.NoError(t, err)
+	server.update(func(s *fakeWebDAVServer) {
+		s.nonce = "nonce3"
+	})
+	require.NoError(t, uploadTestWebDAVFile(t, bufferedFs, "/file2", []byte("buffered")))
+	data, ok = server.getFile("/file2")
+	require.True(t, ok)
+	assert.Equal(t, "buffered", string(data))
+	// a streamed upload cannot be replayed
+	server.update(func(s *fakeWebDAVServer) {
+		s.nonce = "nonce4"
+	})
+	err = uploadTestWebDAVFile(t, fs, "/file3", []byte("streamed"))
+	assert.Error(t, err)
+	_, ok = server.getFile("/file3")
+	assert.False(t, ok)
+
+	fs = newTestWebDAVFs(t, server, WebDAVFsConfig{
+		AuthMethod: WebDAVAuthDigest,
+		Username:   fakeWebDAVUsername,
+		Password:   kms.NewPlainSecret("wrong"),
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+}
+
+func TestWebDAVFsErrors(t *testing.T) {
+	server := newFakeWebDAVServer(t)
+	fs := newTestWebDAVFs(t, server, WebDAVFsConfig{})
+
+	_, err := fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.Rename("/missing", "/target")
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", false)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Remove("/missing", true)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, err = fs.CopyFile("/missing", "/target", 10)
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.GetMimeType("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/missing/file", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err))
+	_, _, _, err = fs.Create("/file", 0, CheckResume)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	_, err = downloadTestWebDAVFile(t, fs, "/missing", 0)
+	assert.True(t, fs.IsNotExist(err))
+	err = fs.Walk("/missing", func(_ string, _ os.FileInfo, err error) error {
+		return err
+	})
+	assert.True(t, fs.IsNotExist(err))
+	// the parent directory must exist
+	err = fs.Mkdir("/missing/dir")
+	assert.Error(t, err)
+	require.NoError(t, fs.Mkdir("/dir"))
+	err = fs.Mkdir("/dir")
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+
+	server.putFile(t, "/file", []byte("data"))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "COPY" {
+			return http.StatusNotImplemented
+		}
+		return http.StatusForbidden
+	})
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.ReadDir("/")
+	assert.True(t, fs.IsPermission(err))
+	_, _, err = fs.Rename("/file", "/file1")
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("/file", false)
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Remove("/dir", true)
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Mkdir("/dir1")
+	assert.True(t, fs.IsPermission(err))
+	err = uploadTestWebDAVFile(t, fs, "/file1", []byte("data"))
+	assert.True(t, fs.IsPermission(err))
+	_, err = downloadTestWebDAVFile(t, fs, "/file", 0)
+	assert.True(t, fs.IsPermission(err))
+	_, err = fs.GetAvailableDiskSize("/")
+	assert.True(t, fs.IsPermission(err))
+	var walkErr error
+	err = fs.Walk("/", func(_ string, _ os.FileInfo, err error) error {
+		walkErr = err
+		return filepath.SkipDir
+	})
+	assert.Equal(t, filepath.SkipDir, err)
+	assert.True(t, fs.IsPermission(walkErr))
+	server.setOnRequest(func(op string, _ *http.Request) int {
+		if op == "COPY" {
+			return http.StatusNotImplemented
+		}
+		return 0
+	})
+	_, _, err = fs.CopyFile("/file", "/file1", 4)
+	assert.True(t, fs.IsNotSupported(err))
+	assert.False(t, fs.IsPermission(err))
+	server.setOnRequest(nil)
+	_, ok := server.getFile("/file1")
+	assert.False(t, ok)
+	// the initial data is skipped if range requests are not supported
+	server.update(func(s *fakeWebDAVServer) {
+		s.ignoreRange = true
+	})
+	data, err := downloadTestWebDAVFile(t, fs, "/file", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ta"), data)
+	_, err = downloadTestWebDAVFile(t, fs, "/file", 10)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	// the server is not reachable
+	server.Close()
+	_, err = fs.Stat("/file")
+	assert.ErrorContains(t, err, "unable to send WebDAV request")
+	assert.False(t, fs.IsNotExist(err))
+}
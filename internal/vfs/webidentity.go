@@ -0,0 +1,95 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrWebIdentityTokenUnavailable is returned if a role must be assumed
+	// using the user's web identity and no valid OpenID Connect ID token is
+	// available for the user
+	ErrWebIdentityTokenUnavailable = errors.New("no valid OpenID Connect identity token available")
+	webIdentityTokens              = &webIdentityTokenStore{
+		tokens: make(map[string]webIdentityToken),
+	}
+)
+
+type webIdentityToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// webIdentityTokenStore stores the OpenID Connect ID tokens obtained when users
+// log in, they are exchanged for short-lived credentials using STS
+type webIdentityTokenStore struct {
+	sync.RWMutex
+	tokens map[string]webIdentityToken
+}
+
+func (s *webIdentityTokenStore) set(username, token string, expiresAt time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.tokens[username] = webIdentityToken{
+		token:     token,
+		expiresAt: expiresAt,
+	}
+}
+
+func (s *webIdentityTokenStore) remove(username, token string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if val, ok := s.tokens[username]; ok && (token == "" || val.token == token) {
+		delete(s.tokens, username)
+	}
+}
+
+func (s *webIdentityTokenStore) get(username string) (string, error) {
+	s.RLock()
+	val, ok := s.tokens[username]
+	s.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w for user %q, an OpenID Connect login is required", ErrWebIdentityTokenUnavailable,
+			username)
+	}
+	if !val.expiresAt.IsZero() && val.expiresAt.Before(time.Now()) {
+		s.remove(username, val.token)
+		return "", fmt.Errorf("%w for user %q, the token is expired", ErrWebIdentityTokenUnavailable, username)
+	}
+	return val.token, nil
+}
+
+// SetWebIdentityToken stores the OpenID Connect ID token for the specified
+// user. The token is used to assume IAM roles with web identity
+func SetWebIdentityToken(username, token string, expiresAt time.Time) {
+	if username == "" || token == "" {
+		return
+	}
+	webIdentityTokens.set(username, token, expiresAt)
+}
+
+// RemoveWebIdentityToken removes the OpenID Connect ID token for the specified
+// user. If token is not empty, it is removed only if it matches the stored one,
+// this way a logout does not affect a newer session for the same user
+func RemoveWebIdentityToken(username, token string) {
+	webIdentityTokens.remove(username, token)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const isOsXattrSupported = true
+
+func getXattr(name, attr string) ([]byte, error) {
+	for {
+		size, err := unix.Getxattr(name, attr, nil)
+		if err != nil {
+			return nil, getXattrError(err, attr)
+		}
+		buf := make([]byte, size)
+		size, err = unix.Getxattr(name, attr, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// the attribute was modified between the two calls
+			continue
+		}
+		if err != nil {
+			return nil, getXattrError(err, attr)
+		}
+		return buf[:size], nil
+	}
+}
+
+func listXattr(name string) ([]string, error) {
+	for {
+		size, err := unix.Listxattr(name, nil)
+		if err != nil {
+			return nil, getXattrError(err, "")
+		}
+		buf := make([]byte, size)
+		size, err = unix.Listxattr(name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, getXattrError(err, "")
+		}
+		// the names are returned as a list of NUL terminated strings
+		var result []string
+		for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+			if attr != "" {
+				result = append(result, attr)
+			}
+		}
+		return result, nil
+	}
+}
+
+func setXattr(name, attr string, value []byte) error {
+	return getXattrError(unix.Setxattr(name, attr, value, 0), attr)
+}
+
+func removeXattr(name, attr string) error {
+	return getXattrError(unix.Removexattr(name, attr), attr)
+}
+
+func getXattrError(err error, attr string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ENOTSUP):
+		return ErrVfsUnsupported
+	case errors.Is(err, xattrNotFoundErrno):
+		return fmt.Errorf("%w: %q", ErrXattrNotFound, attr)
+	default:
+		return err
+	}
+}
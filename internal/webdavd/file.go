@@ -106,6 +106,15 @@ func (fi *webDavFileInfo) ContentType(_ context.Context) (string, error) {
 	return "", webdav.ErrNotImplemented
 }
 
+// ETag implements webdav.ETager interface. The strong, content based, entity
+// tag is returned if available
+func (fi *webDavFileInfo) ETag(_ context.Context) (string, error) {
+	if etag := vfs.GetETag(fi.FileInfo); etag != "" {
+		return etag, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
 // Readdir reads directory entries from the handle
 func (f *webDavFile) Readdir(_ int) ([]os.FileInfo, error) {
 	return nil, webdav.ErrNotImplemented
@@ -202,7 +211,9 @@ func (f *webDavFile) Read(p []byte) (n int, err error) {
 			f.TransferError(common.ErrOpUnsupported)
 			return 0, common.ErrOpUnsupported
 		}
+		startTime := time.Now()
 		file, r, cancelFn, e := f.Fs.Open(f.GetFsPath(), 0)
+		f.Connection.UpdateFsOperationMetrics(f.Fs, common.FsOperationOpen, startTime)
 		f.Lock()
 		if e == nil {
 			if file != nil {
@@ -222,7 +233,9 @@ func (f *webDavFile) Read(p []byte) (n int, err error) {
 		}
 	}
 
+	startTime := time.Now()
 	n, err = f.reader.Read(p)
+	f.UpdateIOMetrics(startTime)
 	f.BytesSent.Add(int64(n))
 	if err == nil {
 		err = f.CheckRead()
@@ -244,7 +257,9 @@ func (f *webDavFile) Write(p []byte) (n int, err error) {
 
 	f.Connection.UpdateLastActivity()
 
+	startTime := time.Now()
 	n, err = f.writer.Write(p)
+	f.UpdateIOMetrics(startTime)
 	f.BytesReceived.Add(int64(n))
 
 	if err == nil {
@@ -355,7 +370,9 @@ func (f *webDavFile) Seek(offset int64, whence int) (int64, error) {
 			startByte = f.info.Size() - offset
 		}
 
+		startTime := time.Now()
 		_, r, cancelFn, err := f.Fs.Open(f.GetFsPath(), startByte)
+		f.Connection.UpdateFsOperationMetrics(f.Fs, common.FsOperationOpen, startTime)
 
 		f.Lock()
 		if err == nil {
@@ -400,6 +417,9 @@ func (f *webDavFile) closeIO() error {
 			f.ErrTransfer = err
 		}
 		f.Unlock()
+		if metadater, ok := f.writer.(vfs.Metadater); ok {
+			f.BaseTransfer.AddMetadata(metadater.Metadata())
+		}
 	} else if f.reader != nil {
 		err = f.reader.Close()
 		if metadater, ok := f.reader.(vfs.Metadater); ok {
@@ -32,6 +32,7 @@ import (
 
 	"github.com/drakkan/webdav"
 	"github.com/eikenb/pipeat"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sftpgo/sdk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1713,3 +1714,82 @@ func TestGetCacheExpirationTime(t *testing.T) {
 	c.ExpirationTime = 1
 	assert.False(t, c.getExpirationTime().IsZero())
 }
+
+func TestReloadBindings(t *testing.T) {
+	registry := runningBindings.Load()
+	bindings := serviceStatus.Bindings
+	defer func() {
+		runningBindings.Store(registry)
+		serviceStatus.Bindings = bindings
+	}()
+
+	runningBindings.Store(nil)
+	c := Configuration{
+		Bindings: []Binding{
+			{
+				Address: "127.0.0.1",
+				Port:    9137,
+			},
+		},
+	}
+	err := c.ReloadBindings()
+	assert.Error(t, err)
+
+	compressor := middleware.NewCompressor(5, "text/*")
+	runningBindings.Store(common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		server := webDavServer{
+			config:  &c,
+			binding: b,
+		}
+		return server.listenAndServe(compressor, l)
+	}))
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	davURL := fmt.Sprintf("http://%s/", c.Bindings[0].GetAddress())
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(davURL)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusUnauthorized
+	}, 2*time.Second, 50*time.Millisecond)
+	// invalid bindings are rejected
+	c.Bindings = append(c.Bindings, Binding{
+		Address:      "127.0.0.1",
+		Port:         9138,
+		ProxyAllowed: []string{"invalid ip"},
+	})
+	err = c.ReloadBindings()
+	assert.Error(t, err)
+	assert.Equal(t, 1, runningBindings.Load().Len())
+	// a new HTTPS binding with a certificate not loaded at startup requires a restart
+	c.Bindings[1] = Binding{
+		Address:            "127.0.0.1",
+		Port:               9138,
+		EnableHTTPS:        true,
+		CertificateFile:    filepath.Join(os.TempDir(), "missing.crt"),
+		CertificateKeyFile: filepath.Join(os.TempDir(), "missing.key"),
+	}
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 1)
+	assert.Equal(t, 1, runningBindings.Load().Len())
+
+	c.Bindings = nil
+	err = c.ReloadBindings()
+	assert.NoError(t, err)
+	assert.Len(t, serviceStatus.Bindings, 0)
+	assert.Equal(t, 0, runningBindings.Load().Len())
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(davURL)
+		if err != nil {
+			return true
+		}
+		resp.Body.Close()
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,291 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// partialUpdateContentType is the content type required for the SabreDAV
+// PATCH extension
+const partialUpdateContentType = "application/x-sabredav-partialupdate"
+
+var (
+	errInvalidUpdateRange = errors.New("invalid update range")
+	lockTokenRegexp       = regexp.MustCompile(`\(\s*(?:Not\s+)?<([^>]+)>`)
+)
+
+// updateRange defines the region of an existing file to overwrite
+type updateRange struct {
+	offset int64
+	// the number of bytes to write, -1 means unknown
+	length int64
+	// the offset is relative to the end of the file
+	fromEnd bool
+}
+
+func (r *updateRange) getOffset(size int64) (int64, error) {
+	offset := r.offset
+	if r.fromEnd {
+		offset = size - r.offset
+	}
+	if offset < 0 || offset > size {
+		return 0, fmt.Errorf("%w: offset %d, file size %d", errInvalidUpdateRange, offset, size)
+	}
+	return offset, nil
+}
+
+func parseRangeBounds(val string) (int64, int64, error) {
+	start, end, ok := strings.Cut(val, "-")
+	if !ok {
+		return 0, 0, errInvalidUpdateRange
+	}
+	startOffset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || startOffset < 0 {
+		return 0, 0, errInvalidUpdateRange
+	}
+	if end == "" {
+		return startOffset, -1, nil
+	}
+	endOffset, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || endOffset < startOffset {
+		return 0, 0, errInvalidUpdateRange
+	}
+	return startOffset, endOffset - startOffset + 1, nil
+}
+
+// parseContentRange parses a Content-Range header sent with a PUT request,
+// for example "bytes 100-199/1000" or "bytes 100-199/*"
+func parseContentRange(val string) (updateRange, error) {
+	val, ok := strings.CutPrefix(strings.TrimSpace(val), "bytes ")
+	if !ok {
+		return updateRange{}, errInvalidUpdateRange
+	}
+	bounds, _, ok := strings.Cut(val, "/")
+	if !ok {
+		return updateRange{}, errInvalidUpdateRange
+	}
+	offset, length, err := parseRangeBounds(strings.TrimSpace(bounds))
+	if err != nil || length < 0 {
+		return updateRange{}, errInvalidUpdateRange
+	}
+	return updateRange{offset: offset, length: length}, nil
+}
+
+// parseUpdateRange parses the X-Update-Range header defined by the SabreDAV
+// PATCH extension: "append", "bytes=<start>-<end>", "bytes=<start>-" or
+// "bytes=-<n>" to overwrite the last n bytes
+func parseUpdateRange(val string) (updateRange, error) {
+	val = strings.TrimSpace(val)
+	if val == "append" {
+		return updateRange{length: -1, fromEnd: true}, nil
+	}
+	val, ok := strings.CutPrefix(val, "bytes=")
+	if !ok {
+		return updateRange{}, errInvalidUpdateRange
+	}
+	if n, ok := strings.CutPrefix(val, "-"); ok {
+		offset, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || offset <= 0 {
+			return updateRange{}, errInvalidUpdateRange
+		}
+		return updateRange{offset: offset, length: -1, fromEnd: true}, nil
+	}
+	offset, length, err := parseRangeBounds(val)
+	if err != nil {
+		return updateRange{}, err
+	}
+	return updateRange{offset: offset, length: length}, nil
+}
+
+// getUpdateRange returns the range for partial updates, if any. The
+// returned bool is false for full uploads
+func getUpdateRange(r *http.Request) (updateRange, bool, error) {
+	switch r.Method {
+	case http.MethodPut:
+		if val := r.Header.Get("Content-Range"); val != "" {
+			rng, err := parseContentRange(val)
+			return rng, true, err
+		}
+	case http.MethodPatch:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != partialUpdateContentType {
+			return updateRange{}, true, fmt.Errorf("%w: content type %q is not supported",
+				errInvalidUpdateRange, r.Header.Get("Content-Type"))
+		}
+		rng, err := parseUpdateRange(r.Header.Get("X-Update-Range"))
+		return rng, true, err
+	}
+	return updateRange{}, false, nil
+}
+
+// confirmLocks checks that the named resource is not locked by another client
+func confirmLocks(r *http.Request, ls webdav.LockSystem, name string) (func(), error) {
+	now := time.Now()
+	header := r.Header.Get("If")
+	if header == "" {
+		token, err := ls.Create(now, webdav.LockDetails{
+			Root:      name,
+			Duration:  -1,
+			ZeroDepth: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			ls.Unlock(now, token) //nolint:errcheck
+		}, nil
+	}
+	var conditions []webdav.Condition
+	for _, match := range lockTokenRegexp.FindAllStringSubmatch(header, -1) {
+		conditions = append(conditions, webdav.Condition{Token: match[1]})
+	}
+	return ls.Confirm(now, name, "", conditions...)
+}
+
+// partialUpdate writes the specified range of an existing file. Only
+// filesystems with random write support are allowed
+func (c *Connection) partialUpdate(name string, rng updateRange, reader io.Reader) error {
+	c.UpdateLastActivity()
+
+	name = util.CleanPath(name)
+	if ok, _ := c.User.IsFileAllowed(name); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", name)
+		return c.GetPermissionDeniedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return c.GetPermissionDeniedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return err
+	}
+	if !vfs.IsLocalOrSFTPFs(fs) {
+		c.Log(logger.LevelDebug, "partial update for %q not supported for fs %q", name, fs.Name())
+		return c.GetOpUnsupportedError()
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return c.GetOpUnsupportedError()
+	}
+	offset, err := rng.getOffset(info.Size())
+	if err != nil {
+		return err
+	}
+	diskQuota, transferQuota := c.HasSpace(false, false, name)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.Log(logger.LevelInfo, "denying partial update due to quota limits")
+		return common.ErrQuotaExceeded
+	}
+	maxWriteSize, err := c.GetMaxWriteSize(diskQuota, true, info.Size(), true)
+	if err != nil {
+		return err
+	}
+	if _, err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, fsPath, name,
+		info.Size(), os.O_WRONLY); err != nil {
+		c.Log(logger.LevelDebug, "partial update for file %q denied by pre action: %v", name, err)
+		return c.GetPermissionDeniedError()
+	}
+	startTime := time.Now()
+	file, w, cancelFn, err := fs.Create(fsPath, os.O_WRONLY, c.GetCreateChecks(name, false, true))
+	c.UpdateFsOperationMetrics(fs, common.FsOperationOpen, startTime)
+	if err != nil {
+		c.Log(logger.LevelError, "error opening file %q for partial update: %+v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, fsPath, name,
+		common.TransferUpload, 0, info.Size(), maxWriteSize, 0, false, fs, transferQuota)
+	f := newWebDavFile(baseTransfer, w, nil)
+	c.Log(logger.LevelDebug, "partial update for file %q, offset: %d, length: %d", name, offset, rng.length)
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	if rng.length >= 0 {
+		reader = io.LimitReader(reader, rng.length)
+	}
+	n, err := io.Copy(f, reader)
+	if err == nil && rng.length >= 0 && n != rng.length {
+		err = fmt.Errorf("%w: expected %d bytes, received %d", errInvalidUpdateRange, rng.length, n)
+		f.TransferError(err)
+	}
+	errClose := f.Close()
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+func (s *webDavServer) handlePartialUpdate(w http.ResponseWriter, r *http.Request, connection *Connection,
+	ls webdav.LockSystem, rng updateRange,
+) {
+	name := r.URL.Path
+	if s.binding.Prefix != "" {
+		name = strings.TrimPrefix(name, s.binding.Prefix)
+	}
+	name = util.CleanPath(name)
+	release, err := confirmLocks(r, ls, name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusLocked), http.StatusLocked)
+		writeLog(r, http.StatusLocked, err)
+		return
+	}
+	defer release()
+
+	status := http.StatusNoContent
+	err = connection.partialUpdate(name, rng, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidUpdateRange):
+			status = http.StatusRequestedRangeNotSatisfiable
+		case connection.IsQuotaExceededError(err):
+			status = http.StatusInsufficientStorage
+		case errors.Is(err, connection.GetOpUnsupportedError()):
+			status = http.StatusNotImplemented
+		case connection.IsNotExistError(err):
+			status = http.StatusNotFound
+		case errors.Is(err, connection.GetPermissionDeniedError()):
+			status = http.StatusForbidden
+		default:
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, http.StatusText(status), status)
+	} else {
+		w.WriteHeader(status)
+	}
+	writeLog(r, status, err)
+}
@@ -0,0 +1,538 @@
+// Copyright (C) 2019 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	methodSearch = "SEARCH"
+	davNamespace = "DAV:"
+	// maxSearchResults is the maximum number of results returned for a SEARCH
+	// request, clients can request less results using the limit element
+	maxSearchResults  = 1000
+	maxSearchBodySize = 64 * 1024
+)
+
+var (
+	errSearchUnsupported = errors.New("unsupported search query")
+	searchProps          = []string{"displayname", "getcontentlength", "getlastmodified", "resourcetype"}
+)
+
+// searchNode is a generic XML element, the basicsearch grammar is recursive
+// so we decode the request as a tree and then we check it
+type searchNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Children []searchNode `xml:",any"`
+	Content  string       `xml:",chardata"`
+}
+
+func (n *searchNode) is(name string) bool {
+	return n.XMLName.Space == davNamespace && n.XMLName.Local == name
+}
+
+func (n *searchNode) child(name string) *searchNode {
+	for idx := range n.Children {
+		if n.Children[idx].is(name) {
+			return &n.Children[idx]
+		}
+	}
+	return nil
+}
+
+func (n *searchNode) getAttr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// getProp returns the name of the single DAV property in a prop element
+func (n *searchNode) getProp() (string, error) {
+	prop := n.child("prop")
+	if prop == nil || len(prop.Children) != 1 {
+		return "", fmt.Errorf("%w: a single property is required", errSearchUnsupported)
+	}
+	if prop.Children[0].XMLName.Space != davNamespace {
+		return "", fmt.Errorf("%w: unsupported property %q", errSearchUnsupported, prop.Children[0].XMLName.Local)
+	}
+	name := prop.Children[0].XMLName.Local
+	if !util.Contains(searchProps, name) || name == "resourcetype" {
+		return "", fmt.Errorf("%w: unsupported property %q", errSearchUnsupported, name)
+	}
+	return name, nil
+}
+
+func (n *searchNode) getLiteral() (string, error) {
+	literal := n.child("literal")
+	if literal == nil {
+		return "", fmt.Errorf("%w: literal is required for %q", errSearchUnsupported, n.XMLName.Local)
+	}
+	return literal.Content, nil
+}
+
+type searchScope struct {
+	virtualPath string
+	recursive   bool
+	// depth 0, only the scope itself is checked
+	onlyScope bool
+}
+
+type searchCondition func(virtualPath string, info os.FileInfo) bool
+
+type searchQuery struct {
+	scopes    []searchScope
+	condition searchCondition
+	props     []string
+	limit     int
+}
+
+func parseSearchRequest(r io.Reader, prefix string) (*searchQuery, error) {
+	var root searchNode
+	if err := xml.NewDecoder(io.LimitReader(r, maxSearchBodySize)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("%w: invalid XML: %v", errSearchUnsupported, err)
+	}
+	if !root.is("searchrequest") {
+		return nil, fmt.Errorf("%w: searchrequest is required", errSearchUnsupported)
+	}
+	basicSearch := root.child("basicsearch")
+	if basicSearch == nil {
+		return nil, fmt.Errorf("%w: only basicsearch is supported", errSearchUnsupported)
+	}
+	query := &searchQuery{
+		props: searchProps,
+		limit: maxSearchResults,
+		condition: func(_ string, _ os.FileInfo) bool {
+			return true
+		},
+	}
+	if sel := basicSearch.child("select"); sel != nil {
+		if prop := sel.child("prop"); prop != nil {
+			query.props = nil
+			for _, p := range prop.Children {
+				if p.XMLName.Space == davNamespace && util.Contains(searchProps, p.XMLName.Local) {
+					query.props = append(query.props, p.XMLName.Local)
+				}
+			}
+		}
+	}
+	scopes, err := parseSearchScopes(basicSearch.child("from"), prefix)
+	if err != nil {
+		return nil, err
+	}
+	query.scopes = scopes
+	if where := basicSearch.child("where"); where != nil {
+		if len(where.Children) != 1 {
+			return nil, fmt.Errorf("%w: a single where condition is required", errSearchUnsupported)
+		}
+		query.condition, err = parseSearchCondition(&where.Children[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit := basicSearch.child("limit"); limit != nil {
+		if nResults := limit.child("nresults"); nResults != nil {
+			val, err := strconv.Atoi(strings.TrimSpace(nResults.Content))
+			if err != nil || val <= 0 {
+				return nil, fmt.Errorf("%w: invalid nresults %q", errSearchUnsupported, nResults.Content)
+			}
+			if val < query.limit {
+				query.limit = val
+			}
+		}
+	}
+	return query, nil
+}
+
+func parseSearchScopes(from *searchNode, prefix string) ([]searchScope, error) {
+	if from == nil {
+		return nil, fmt.Errorf("%w: from is required", errSearchUnsupported)
+	}
+	var scopes []searchScope
+	for idx := range from.Children {
+		node := &from.Children[idx]
+		if !node.is("scope") {
+			continue
+		}
+		href := node.child("href")
+		if href == nil {
+			return nil, fmt.Errorf("%w: scope href is required", errSearchUnsupported)
+		}
+		scopePath := strings.TrimSpace(href.Content)
+		if u, err := url.Parse(scopePath); err == nil {
+			scopePath = u.Path
+		}
+		if prefix != "" {
+			scopePath = strings.TrimPrefix(scopePath, prefix)
+		}
+		scope := searchScope{
+			virtualPath: util.CleanPath(scopePath),
+			recursive:   true,
+		}
+		if depth := node.child("depth"); depth != nil {
+			switch strings.TrimSpace(depth.Content) {
+			case "0":
+				scope.recursive = false
+				scope.onlyScope = true
+			case "1":
+				scope.recursive = false
+			case "infinity":
+			default:
+				return nil, fmt.Errorf("%w: invalid depth %q", errSearchUnsupported, depth.Content)
+			}
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least a scope is required", errSearchUnsupported)
+	}
+	return scopes, nil
+}
+
+func parseSearchCondition(node *searchNode) (searchCondition, error) { //nolint:gocyclo
+	if node.XMLName.Space != davNamespace {
+		return nil, fmt.Errorf("%w: unsupported operator %q", errSearchUnsupported, node.XMLName.Local)
+	}
+	switch node.XMLName.Local {
+	case "and", "or":
+		var conditions []searchCondition
+		for idx := range node.Children {
+			cond, err := parseSearchCondition(&node.Children[idx])
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+		}
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("%w: empty %q condition", errSearchUnsupported, node.XMLName.Local)
+		}
+		isAnd := node.XMLName.Local == "and"
+		return func(virtualPath string, info os.FileInfo) bool {
+			for _, cond := range conditions {
+				if cond(virtualPath, info) != isAnd {
+					return !isAnd
+				}
+			}
+			return isAnd
+		}, nil
+	case "not":
+		if len(node.Children) != 1 {
+			return nil, fmt.Errorf("%w: a single condition is required for not", errSearchUnsupported)
+		}
+		cond, err := parseSearchCondition(&node.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(virtualPath string, info os.FileInfo) bool {
+			return !cond(virtualPath, info)
+		}, nil
+	case "is-collection":
+		return func(_ string, info os.FileInfo) bool {
+			return info.IsDir()
+		}, nil
+	case "like":
+		return parseLikeCondition(node)
+	case "eq", "lt", "gt", "lte", "gte":
+		return parseCompareCondition(node)
+	}
+	return nil, fmt.Errorf("%w: unsupported operator %q", errSearchUnsupported, node.XMLName.Local)
+}
+
+func parseLikeCondition(node *searchNode) (searchCondition, error) {
+	prop, err := node.getProp()
+	if err != nil {
+		return nil, err
+	}
+	if prop != "displayname" {
+		return nil, fmt.Errorf("%w: like is only supported for displayname", errSearchUnsupported)
+	}
+	literal, err := node.getLiteral()
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	if node.getAttr("caseless") != "no" {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+	for _, r := range literal {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid like pattern %q", errSearchUnsupported, literal)
+	}
+	return func(_ string, info os.FileInfo) bool {
+		return re.MatchString(info.Name())
+	}, nil
+}
+
+func parseCompareCondition(node *searchNode) (searchCondition, error) {
+	prop, err := node.getProp()
+	if err != nil {
+		return nil, err
+	}
+	literal, err := node.getLiteral()
+	if err != nil {
+		return nil, err
+	}
+	literal = strings.TrimSpace(literal)
+	op := node.XMLName.Local
+	compare := func(res int) bool {
+		switch op {
+		case "eq":
+			return res == 0
+		case "lt":
+			return res < 0
+		case "gt":
+			return res > 0
+		case "lte":
+			return res <= 0
+		default:
+			return res >= 0
+		}
+	}
+
+	switch prop {
+	case "getcontentlength":
+		size, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid size %q", errSearchUnsupported, literal)
+		}
+		return func(_ string, info os.FileInfo) bool {
+			if info.IsDir() {
+				return false
+			}
+			return compare(compareInt64(info.Size(), size))
+		}, nil
+	case "getlastmodified":
+		modTime, err := parseSearchTime(literal)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ string, info os.FileInfo) bool {
+			return compare(info.ModTime().Truncate(time.Second).Compare(modTime))
+		}, nil
+	default:
+		caseless := node.getAttr("caseless") != "no"
+		return func(_ string, info os.FileInfo) bool {
+			name := info.Name()
+			value := literal
+			if caseless {
+				name = strings.ToLower(name)
+				value = strings.ToLower(value)
+			}
+			return compare(strings.Compare(name, value))
+		}, nil
+	}
+}
+
+func parseSearchTime(val string) (time.Time, error) {
+	for _, layout := range []string{http.TimeFormat, time.RFC3339} {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.Truncate(time.Second), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: invalid date %q", errSearchUnsupported, val)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+type searchResult struct {
+	virtualPath string
+	info        os.FileInfo
+}
+
+func (c *Connection) search(ctx context.Context, query *searchQuery) ([]searchResult, error) {
+	var results []searchResult
+
+	for _, scope := range query.scopes {
+		info, err := c.Stat(ctx, scope.virtualPath)
+		if err != nil {
+			return nil, err
+		}
+		if scope.onlyScope || !info.IsDir() {
+			if query.condition(scope.virtualPath, info) {
+				results = append(results, searchResult{virtualPath: scope.virtualPath, info: info})
+			}
+		} else {
+			results, err = c.searchDir(ctx, scope, query, results)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(results) >= query.limit {
+			return results[:query.limit], nil
+		}
+	}
+	return results, nil
+}
+
+func (c *Connection) searchDir(ctx context.Context, scope searchScope, query *searchQuery,
+	results []searchResult,
+) ([]searchResult, error) {
+	dirs := []string{scope.virtualPath}
+	for len(dirs) > 0 {
+		dirPath := dirs[0]
+		dirs = dirs[1:]
+		c.UpdateLastActivity()
+
+		lister, err := c.ListDir(dirPath)
+		if err != nil {
+			if dirPath == scope.virtualPath {
+				return nil, err
+			}
+			// skip the sub directories that cannot be listed
+			continue
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				lister.Close()
+				return nil, err
+			}
+			files, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				lister.Close()
+				return nil, err
+			}
+			for _, info := range files {
+				virtualPath := path.Join(dirPath, info.Name())
+				if query.condition(virtualPath, info) {
+					results = append(results, searchResult{virtualPath: virtualPath, info: info})
+					if len(results) >= query.limit {
+						lister.Close()
+						return results, nil
+					}
+				}
+				if info.IsDir() && scope.recursive {
+					dirs = append(dirs, virtualPath)
+				}
+			}
+			if finished {
+				break
+			}
+		}
+		lister.Close()
+	}
+	return results, nil
+}
+
+func writeSearchResults(w io.Writer, results []searchResult, props []string, prefix string) error {
+	var b bytes.Buffer
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, res := range results {
+		href := path.Join("/", prefix, res.virtualPath)
+		if res.info.IsDir() && href != "/" {
+			href += "/"
+		}
+		b.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(&b, []byte((&url.URL{Path: href}).EscapedPath())) //nolint:errcheck
+		b.WriteString(`</D:href><D:propstat><D:prop>`)
+		for _, prop := range props {
+			switch prop {
+			case "displayname":
+				b.WriteString(`<D:displayname>`)
+				xml.EscapeText(&b, []byte(res.info.Name())) //nolint:errcheck
+				b.WriteString(`</D:displayname>`)
+			case "getcontentlength":
+				if !res.info.IsDir() {
+					b.WriteString(`<D:getcontentlength>`)
+					b.WriteString(strconv.FormatInt(res.info.Size(), 10))
+					b.WriteString(`</D:getcontentlength>`)
+				}
+			case "getlastmodified":
+				b.WriteString(`<D:getlastmodified>`)
+				b.WriteString(res.info.ModTime().UTC().Format(http.TimeFormat))
+				b.WriteString(`</D:getlastmodified>`)
+			case "resourcetype":
+				if res.info.IsDir() {
+					b.WriteString(`<D:resourcetype><D:collection/></D:resourcetype>`)
+				} else {
+					b.WriteString(`<D:resourcetype/>`)
+				}
+			}
+		}
+		b.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	}
+	b.WriteString(`</D:multistatus>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// handleSearch implements the RFC 5323 basicsearch grammar for the
+// displayname, getcontentlength and getlastmodified properties
+func (s *webDavServer) handleSearch(w http.ResponseWriter, r *http.Request, connection *Connection) {
+	query, err := parseSearchRequest(r.Body, s.binding.Prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeLog(r, http.StatusBadRequest, err)
+		return
+	}
+	results, err := connection.search(r.Context(), query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if connection.IsNotExistError(err) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, connection.GetPermissionDeniedError()) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(status), status)
+		writeLog(r, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	err = writeSearchResults(w, results, query.props, s.binding.Prefix)
+	writeLog(r, http.StatusMultiStatus, err)
+}
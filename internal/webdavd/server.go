@@ -49,8 +49,16 @@ type webDavServer struct {
 	binding Binding
 }
 
-func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
-	handler := compressor.Handler(s)
+func (s *webDavServer) listenAndServe(compressor *middleware.Compressor, l *common.BindingListener) error {
+	compressed := compressor.Handler(s)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// if enabled, downloads are compressed based on the configured HTTP compression settings
+		if r.Method == http.MethodGet && common.Config.HTTPCompression.Enabled {
+			s.ServeHTTP(w, r)
+			return
+		}
+		compressed.ServeHTTP(w, r)
+	})
 	httpServer := &http.Server{
 		ReadHeaderTimeout: 30 * time.Second,
 		ReadTimeout:       60 * time.Second,
@@ -74,8 +82,12 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 		handler = c.Handler(handler)
 	}
 	httpServer.Handler = handler
+	if err := l.SetStopFunc(func() error {
+		return httpServer.Shutdown(context.Background())
+	}); err != nil {
+		return err
+	}
 	if certMgr != nil && s.binding.EnableHTTPS {
-		serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
 		certID := common.DefaultTLSKeyPaidID
 		if getConfigPath(s.binding.CertificateFile, "") != "" && getConfigPath(s.binding.CertificateKeyFile, "") != "" {
 			certID = s.binding.GetAddress()
@@ -100,8 +112,6 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 		}
 		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
-	s.binding.EnableHTTPS = false
-	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
 	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
 }
 
@@ -253,6 +263,32 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rng, ok, err := getUpdateRange(r); ok {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeLog(r, http.StatusBadRequest, err)
+			return
+		}
+		s.handlePartialUpdate(w, r.WithContext(ctx), connection, lockSystem, rng)
+		return
+	}
+
+	switch r.Method {
+	case methodSearch:
+		s.handleSearch(w, r.WithContext(ctx), connection)
+		return
+	case http.MethodOptions:
+		w.Header().Set("DASL", "<DAV:basicsearch>")
+	case http.MethodGet:
+		var closeWriter func() error
+		w, closeWriter = common.WrapCompressedResponse(w, r)
+		defer func() {
+			if err := closeWriter(); err != nil {
+				connection.Log(logger.LevelDebug, "unable to flush compressed response: %v", err)
+			}
+		}()
+	}
+
 	handler := webdav.Handler{
 		Prefix:     s.binding.Prefix,
 		FileSystem: connection,
@@ -350,6 +386,11 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, lo
 		logger.Info(logSender, connectionID, "cannot login user %q, protocol DAV is not allowed", user.Username)
 		return connID, fmt.Errorf("protocol DAV is not allowed for user %q", user.Username)
 	}
+	if s.binding.Tenant != "" && user.Tenant != s.binding.Tenant {
+		logger.Info(logSender, connectionID, "cannot login user %q, tenant %q is not allowed on this binding",
+			user.Username, user.Tenant)
+		return connID, fmt.Errorf("login for user %q is not allowed on this binding", user.Username)
+	}
 	if !user.IsLoginMethodAllowed(loginMethod, common.ProtocolWebDAV) {
 		logger.Info(logSender, connectionID, "cannot login user %q, %v login method is not allowed",
 			user.Username, loginMethod)
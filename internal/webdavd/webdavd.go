@@ -16,11 +16,13 @@
 package webdavd
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -43,9 +45,10 @@ const (
 )
 
 var (
-	certMgr       *common.CertManager
-	serviceStatus ServiceStatus
-	timeFormats   = []string{
+	certMgr         *common.CertManager
+	serviceStatus   ServiceStatus
+	runningBindings atomic.Pointer[common.BindingsRegistry[Binding]]
+	timeFormats     = []string{
 		http.TimeFormat,
 		"Mon, _2 Jan 2006 15:04:05 GMT",
 		time.RFC850,
@@ -150,7 +153,10 @@ type Binding struct {
 	// Do not add the WWW-Authenticate header after an authentication error,
 	// only the 401 status code will be sent
 	DisableWWWAuthHeader bool `json:"disable_www_auth_header" mapstructure:"disable_www_auth_header"`
-	allowHeadersFrom     []func(net.IP) bool
+	// Tenant restricts this binding to the users of the specified tenant.
+	// An empty value means that any user is allowed
+	Tenant           string `json:"tenant" mapstructure:"tenant"`
+	allowHeadersFrom []func(net.IP) bool
 }
 
 func (b *Binding) parseAllowedProxy() error {
@@ -329,6 +335,16 @@ func (c *Configuration) Initialize(configDir string) error {
 	}
 
 	exitChannel := make(chan error, 1)
+	registry := common.NewBindingsRegistry(logSender, func(b Binding) string {
+		return b.GetAddress()
+	}, func(b Binding, l *common.BindingListener) error {
+		server := webDavServer{
+			config:  c,
+			binding: b,
+		}
+		return server.listenAndServe(compressor, l)
+	})
+	runningBindings.Store(registry)
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
@@ -337,13 +353,14 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := binding.parseAllowedProxy(); err != nil {
 			return err
 		}
+		serviceStatus.Bindings = append(serviceStatus.Bindings, getBindingsStatus([]Binding{binding})...)
 
 		go func(binding Binding) {
-			server := webDavServer{
-				config:  c,
-				binding: binding,
+			err := registry.Serve(binding)
+			if errors.Is(err, common.ErrBindingRemoved) {
+				return
 			}
-			exitChannel <- server.listenAndServe(compressor)
+			exitChannel <- err
 		}(binding)
 	}
 
@@ -352,6 +369,58 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
+// ReloadBindings applies the configured bindings to the running WebDAV server.
+// New bindings are started and removed bindings stop accepting new connections,
+// the active connections are not affected. Changing the settings of an existing
+// binding requires a restart
+func (c *Configuration) ReloadBindings() error {
+	registry := runningBindings.Load()
+	if registry == nil {
+		return errors.New("the WebDAV server is not running")
+	}
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.parseAllowedProxy(); err != nil {
+			return err
+		}
+		bindings = append(bindings, binding)
+	}
+	serviceStatus.Bindings = getBindingsStatus(registry.Reload(bindings, checkBindingCertificate))
+	return nil
+}
+
+// checkBindingCertificate returns an error if the binding requires TLS and
+// no certificate was loaded for it
+func checkBindingCertificate(b Binding) error {
+	if !b.EnableHTTPS {
+		return nil
+	}
+	certID := common.DefaultTLSKeyPaidID
+	if getConfigPath(b.CertificateFile, "") != "" && getConfigPath(b.CertificateKeyFile, "") != "" {
+		certID = b.GetAddress()
+	}
+	if certMgr == nil || !certMgr.HasCertificate(certID) {
+		return fmt.Errorf("no TLS certificate loaded for binding %q", b.GetAddress())
+	}
+	return nil
+}
+
+// getBindingsStatus returns the bindings to report in the service status,
+// HTTPS is disabled if no certificate is configured
+func getBindingsStatus(bindings []Binding) []Binding {
+	result := make([]Binding, 0, len(bindings))
+	for _, b := range bindings {
+		if certMgr == nil {
+			b.EnableHTTPS = false
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
 // ReloadCertificateMgr reloads the certificate manager
 func ReloadCertificateMgr() error {
 	if certMgr != nil {
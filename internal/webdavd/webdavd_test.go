@@ -37,6 +37,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/minio/sio"
 	"github.com/rs/zerolog"
 	"github.com/sftpgo/sdk"
@@ -1079,6 +1082,271 @@ func TestPropPatch(t *testing.T) {
 		1*time.Second, 100*time.Millisecond)
 }
 
+func TestSearch(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/denied"] = []string{dataprovider.PermUpload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub", "denied"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "denied"), os.ModePerm)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "file1.txt"), 100)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "sub", "file2.TXT"), 2000)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "sub", "file3.dat"), 10)
+	assert.NoError(t, err)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "denied", "file4.txt"), 10)
+	assert.NoError(t, err)
+
+	search := func(body string) (int, string) {
+		req, err := http.NewRequest("SEARCH", fmt.Sprintf("http://%v/", webDavServerAddr), bytes.NewReader([]byte(body)))
+		assert.NoError(t, err)
+		req.SetBasicAuth(u.Username, u.Password)
+		req.Header.Set("Content-Type", "text/xml")
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+	getSearchBody := func(scope, depth, where, limit string) string {
+		return `<?xml version="1.0" encoding="utf-8" ?><D:searchrequest xmlns:D="DAV:"><D:basicsearch>` +
+			`<D:select><D:prop><D:displayname/><D:getcontentlength/></D:prop></D:select>` +
+			`<D:from><D:scope><D:href>` + scope + `</D:href><D:depth>` + depth + `</D:depth></D:scope></D:from>` +
+			`<D:where>` + where + `</D:where>` + limit + `</D:basicsearch></D:searchrequest>`
+	}
+
+	status, body := search(getSearchBody("/", "infinity",
+		`<D:like><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like>`, ""))
+	assert.Equal(t, http.StatusMultiStatus, status, body)
+	assert.Contains(t, body, "<D:href>/file1.txt</D:href>")
+	assert.Contains(t, body, "<D:href>/sub/file2.TXT</D:href>")
+	assert.Contains(t, body, "<D:getcontentlength>2000</D:getcontentlength>")
+	assert.NotContains(t, body, "file3.dat")
+	assert.NotContains(t, body, "file4.txt")
+	assert.NotContains(t, body, "getlastmodified")
+
+	status, body = search(getSearchBody("/", "1",
+		`<D:like><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like>`, ""))
+	assert.Equal(t, http.StatusMultiStatus, status, body)
+	assert.Contains(t, body, "file1.txt")
+	assert.NotContains(t, body, "file2.TXT")
+
+	status, body = search(getSearchBody("/sub", "infinity",
+		`<D:and><D:not><D:is-collection/></D:not><D:lt><D:prop><D:getcontentlength/></D:prop><D:literal>1000</D:literal></D:lt></D:and>`, ""))
+	assert.Equal(t, http.StatusMultiStatus, status, body)
+	assert.Contains(t, body, "<D:href>/sub/file3.dat</D:href>")
+	assert.NotContains(t, body, "file2.TXT")
+	assert.NotContains(t, body, "file1.txt")
+
+	status, body = search(getSearchBody("/", "infinity", `<D:is-collection/>`,
+		`<D:limit><D:nresults>1</D:nresults></D:limit>`))
+	assert.Equal(t, http.StatusMultiStatus, status, body)
+	assert.Equal(t, 1, strings.Count(body, "<D:response>"))
+
+	status, _ = search(getSearchBody("/", "infinity",
+		`<D:contains>test</D:contains>`, ""))
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = search(getSearchBody("/missing", "infinity", `<D:is-collection/>`, ""))
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = search(getSearchBody("/denied", "infinity", `<D:is-collection/>`, ""))
+	assert.Equal(t, http.StatusForbidden, status)
+
+	req, err := http.NewRequest(http.MethodOptions, fmt.Sprintf("http://%v/", webDavServerAddr), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(u.Username, u.Password)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<DAV:basicsearch>", resp.Header.Get("DASL"))
+		err = resp.Body.Close()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPartialUpdate(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	filePath := filepath.Join(user.GetHomeDir(), testFileName)
+	err = os.WriteFile(filePath, []byte("0123456789"), os.ModePerm)
+	assert.NoError(t, err)
+
+	update := func(method string, headers map[string]string, body string) int {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName),
+			bytes.NewReader([]byte(body)))
+		assert.NoError(t, err)
+		req.SetBasicAuth(u.Username, u.Password)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		err = resp.Body.Close()
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	checkContent := func(expected string) {
+		content, err := os.ReadFile(filePath)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	status := update(http.MethodPut, map[string]string{"Content-Range": "bytes 2-4/10"}, "abc")
+	assert.Equal(t, http.StatusNoContent, status)
+	checkContent("01abc56789")
+	status = update(http.MethodPatch, map[string]string{
+		"Content-Type":   "application/x-sabredav-partialupdate",
+		"X-Update-Range": "append",
+	}, "ABC")
+	assert.Equal(t, http.StatusNoContent, status)
+	checkContent("01abc56789ABC")
+	status = update(http.MethodPatch, map[string]string{
+		"Content-Type":   "application/x-sabredav-partialupdate",
+		"X-Update-Range": "bytes=-2",
+	}, "yz")
+	assert.Equal(t, http.StatusNoContent, status)
+	checkContent("01abc56789Ayz")
+	status = update(http.MethodPatch, map[string]string{
+		"Content-Type":   "application/x-sabredav-partialupdate",
+		"X-Update-Range": "bytes=0-",
+	}, "Z")
+	assert.Equal(t, http.StatusNoContent, status)
+	checkContent("Z1abc56789Ayz")
+	// the body does not match the range
+	status = update(http.MethodPut, map[string]string{"Content-Range": "bytes 0-4/*"}, "ab")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, status)
+	status = update(http.MethodPut, map[string]string{"Content-Range": "bytes 100-101/*"}, "ab")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, status)
+	status = update(http.MethodPut, map[string]string{"Content-Range": "invalid"}, "ab")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status = update(http.MethodPatch, map[string]string{"X-Update-Range": "append"}, "ab")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status = update(http.MethodPatch, map[string]string{
+		"Content-Type":   "application/x-sabredav-partialupdate",
+		"X-Update-Range": "bytes=3-1",
+	}, "ab")
+	assert.Equal(t, http.StatusBadRequest, status)
+	err = os.Remove(filePath)
+	assert.NoError(t, err)
+	status = update(http.MethodPut, map[string]string{"Content-Range": "bytes 0-1/*"}, "ab")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestCompressedDownload(t *testing.T) {
+	compressionConfig := common.Config.HTTPCompression
+	common.Config.HTTPCompression = common.HTTPCompressionConfig{
+		Enabled:   true,
+		MinSize:   100,
+		MimeTypes: []string{"text/*"},
+	}
+	defer func() {
+		common.Config.HTTPCompression = compressionConfig
+	}()
+
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	content := []byte(strings.Repeat("compressible content ", 100))
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), content, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "small.txt"), content[:50], os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.bin"), content, os.ModePerm)
+	assert.NoError(t, err)
+
+	download := func(name string, headers map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%v/%v", webDavServerAddr, name), nil)
+		assert.NoError(t, err)
+		req.SetBasicAuth(u.Username, u.Password)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		if !assert.NoError(t, err) {
+			return nil, nil
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, data
+	}
+
+	resp, data := download("file.txt", map[string]string{"Accept-Encoding": "gzip"})
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Less(t, len(data), len(content))
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			decoded, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, content, decoded)
+		}
+	}
+	resp, data = download("file.txt", map[string]string{"Accept-Encoding": "gzip;q=0.5, zstd"})
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+		decoder, err := zstd.NewReader(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			decoded, err := io.ReadAll(decoder)
+			assert.NoError(t, err)
+			assert.Equal(t, content, decoded)
+			decoder.Close()
+		}
+	}
+	resp, data = download("file.txt", map[string]string{"Accept-Encoding": "gzip, br"})
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+		decoded, err := io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+		assert.NoError(t, err)
+		assert.Equal(t, content, decoded)
+	}
+	// range requests, small files, not allowed MIME types and unsupported
+	// encodings are sent uncompressed
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		size    int
+	}{
+		{"file.txt", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, 10},
+		{"small.txt", map[string]string{"Accept-Encoding": "gzip"}, 50},
+		{"file.bin", map[string]string{"Accept-Encoding": "gzip"}, len(content)},
+		{"file.txt", map[string]string{"Accept-Encoding": "deflate"}, len(content)},
+	} {
+		resp, data = download(tc.name, tc.headers)
+		if assert.NotNil(t, resp) {
+			assert.Empty(t, resp.Header.Get("Content-Encoding"), tc.name)
+			assert.Len(t, data, tc.size, tc.name)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestLoginInvalidPwd(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -1313,7 +1581,7 @@ func TestExternalAuthReturningAnonymousUser(t *testing.T) {
 	assert.Equal(t, []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
 		dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodKeyAndPassword,
 		dataprovider.SSHLoginMethodKeyAndKeyboardInt, dataprovider.LoginMethodTLSCertificate,
-		dataprovider.LoginMethodTLSCertificateAndPwd}, user.Filters.DeniedLoginMethods)
+		dataprovider.LoginMethodTLSCertificateAndPwd, dataprovider.SSHLoginMethodGSSAPI}, user.Filters.DeniedLoginMethods)
 
 	u.Password = emptyPwdPlaceholder
 	client = getWebDavClient(user, false, nil)